@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Command annotations read by CheckAccess
+const (
+	// annotationScope is the scope a command needs
+	annotationScope = "apm.scope"
+
+	// annotationWrite marks a command that changes something: "true", or
+	// the name of the flag that makes it change something. A set --dry-run
+	// flag makes any command a read.
+	annotationWrite = "apm.write"
+)
+
+// needs returns the annotations of a command needing scope. write is
+// "true", a flag name, or empty for commands that only read.
+func needs(scope, write string) map[string]string {
+	annotations := map[string]string{annotationScope: scope}
+	if write != "" {
+		annotations[annotationWrite] = write
+	}
+	return annotations
+}
+
+// CheckAccess refuses a command the access section of apm.yaml does not
+// allow. The annotations of the command, or of its nearest annotated parent,
+// give the scope it needs and whether it changes something. Read-only mode
+// is also turned on by --read-only and APM_READ_ONLY.
+func CheckAccess(cmd *cobra.Command) error {
+	annotated := annotatedCommand(cmd)
+	if annotated == nil {
+		return nil
+	}
+
+	configPath, _ := cmd.Flags().GetString("config")
+	policy, err := loadAccessPolicy(configPath)
+	if err != nil {
+		return err
+	}
+	if readOnly, _ := cmd.Flags().GetBool("read-only"); readOnly || access.ReadOnlyFromEnv() {
+		policy.ReadOnly = true
+	}
+
+	write := false
+	switch flag := annotated.Annotations[annotationWrite]; flag {
+	case "":
+	case "true":
+		write = true
+	default:
+		write, _ = cmd.Flags().GetBool(flag)
+	}
+	if dryRun, err := cmd.Flags().GetBool("dry-run"); err == nil && dryRun {
+		write = false
+	}
+	return policy.Check(cmd.CommandPath(), annotated.Annotations[annotationScope], write)
+}
+
+// Scope returns the scope cmd needs, or "" when neither it nor a parent is
+// annotated
+func Scope(cmd *cobra.Command) string {
+	if annotated := annotatedCommand(cmd); annotated != nil {
+		return annotated.Annotations[annotationScope]
+	}
+	return ""
+}
+
+// annotatedCommand returns cmd or its nearest parent with a scope annotation
+func annotatedCommand(cmd *cobra.Command) *cobra.Command {
+	for cmd != nil && cmd.Annotations[annotationScope] == "" {
+		cmd = cmd.Parent()
+	}
+	return cmd
+}
+
+// loadAccessPolicy reads the access section of apm.yaml; a missing file
+// allows everything
+func loadAccessPolicy(configPath string) (access.CLIPolicy, error) {
+	var config struct {
+		Access access.CLIPolicy `yaml:"access"`
+	}
+	data, err := os.ReadFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return config.Access, nil
+	}
+	if err != nil {
+		return config.Access, fmt.Errorf("error reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config.Access, fmt.Errorf("invalid apm.yaml: %w", err)
+	}
+	return config.Access, config.Access.Validate()
+}
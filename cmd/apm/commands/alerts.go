@@ -0,0 +1,277 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/alertrules"
+	"github.com/spf13/cobra"
+)
+
+var AlertsCmd = &cobra.Command{
+	Use:         "alerts",
+	Annotations: needs(access.ScopeManageAlerts, "true"),
+	Short:       "List, enable, disable, or retune many Prometheus alerting rules at once",
+	Long: `Change every alerting rule matching a label selector in one go.
+
+Rules are selected with --selector, comma separated label=value pairs matched
+against the rule labels and alertname. Values are shell patterns, so
+severity=crit* and alertname=Checkout* work. Without a selector every rule is
+selected.
+
+Prometheus cannot switch a rule off, so disable wraps its expression to never
+fire and enable restores it. Only the changed expressions are rewritten;
+comments and layout are kept. Use --dry-run to preview the changes and
+--reload to have Prometheus pick them up.
+
+Examples:
+  apm alerts list --selector service=checkout
+  apm alerts disable --selector service=checkout,environment=staging --dry-run
+  apm alerts enable --selector severity=warning
+  apm alerts threshold --selector alertname=HighErrorRate --set 0.02
+  apm alerts threshold --selector service=search --scale 1.5 --reload http://localhost:9090`,
+}
+
+var alertsListCmd = &cobra.Command{
+	Use:         "list",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "List the alerting rules matching the selector",
+	RunE:        runAlertsList,
+}
+
+var alertsEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Enable the alerting rules matching the selector",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		enable := true
+		return runAlertsUpdate(cmd, alertrules.Update{Enable: &enable})
+	},
+}
+
+var alertsDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Disable the alerting rules matching the selector",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		disable := false
+		return runAlertsUpdate(cmd, alertrules.Update{Enable: &disable})
+	},
+}
+
+var alertsThresholdCmd = &cobra.Command{
+	Use:   "threshold",
+	Short: "Set or scale the thresholds of the alerting rules matching the selector",
+	Long: `Set or scale the number each selected expression compares with, e.g. the
+0.05 of rate(errors[5m]) > 0.05. Rules without such a comparison are reported
+and left unchanged.`,
+	RunE: runAlertsThreshold,
+}
+
+var (
+	alertsRules    []string
+	alertsSelector string
+	alertsDryRun   bool
+	alertsReload   string
+	alertsSet      string
+	alertsScale    float64
+)
+
+func init() {
+	AlertsCmd.PersistentFlags().StringSliceVar(&alertsRules, "rules", []string{"configs/prometheus/alerts"}, "Rule files or directories")
+	AlertsCmd.PersistentFlags().StringVarP(&alertsSelector, "selector", "l", "", "Rules to change, e.g. service=checkout,severity=critical")
+
+	for _, c := range []*cobra.Command{alertsEnableCmd, alertsDisableCmd, alertsThresholdCmd} {
+		c.Flags().BoolVar(&alertsDryRun, "dry-run", false, "Show the changes without writing them")
+		c.Flags().StringVar(&alertsReload, "reload", "", "Prometheus URL to reload after writing (needs --web.enable-lifecycle)")
+	}
+	alertsThresholdCmd.Flags().StringVar(&alertsSet, "set", "", "New threshold")
+	alertsThresholdCmd.Flags().Float64Var(&alertsScale, "scale", 0, "Factor to multiply the thresholds by")
+
+	AlertsCmd.AddCommand(alertsListCmd)
+	AlertsCmd.AddCommand(alertsEnableCmd)
+	AlertsCmd.AddCommand(alertsDisableCmd)
+	AlertsCmd.AddCommand(alertsThresholdCmd)
+}
+
+func loadAlertRules() (*alertrules.RuleSet, alertrules.Selector, error) {
+	sel, err := alertrules.ParseSelector(alertsSelector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --selector: %w", err)
+	}
+	rs, err := alertrules.Load(alertsRules...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rs, sel, nil
+}
+
+func runAlertsList(cmd *cobra.Command, args []string) error {
+	rs, sel, err := loadAlertRules()
+	if err != nil {
+		return err
+	}
+	rules := rs.Rules(sel)
+
+	if jsonOut, _ := cmd.Flags().GetBool("json"); jsonOut {
+		type ruleJSON struct {
+			File      string            `json:"file"`
+			Group     string            `json:"group"`
+			Alert     string            `json:"alert"`
+			Enabled   bool              `json:"enabled"`
+			Threshold *float64          `json:"threshold"`
+			Labels    map[string]string `json:"labels"`
+		}
+		out := make([]ruleJSON, 0, len(rules))
+		for _, r := range rules {
+			item := ruleJSON{File: r.File, Group: r.Group, Alert: r.Alert, Enabled: !r.Disabled, Labels: r.Labels}
+			if v, err := r.Threshold(); err == nil {
+				item.Threshold = &v
+			}
+			out = append(out, item)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	if len(rules) == 0 {
+		fmt.Println(theme.Dim.Render("No alerting rules match " + describeSelector(sel)))
+		return nil
+	}
+	fmt.Println(theme.Title.Render(fmt.Sprintf("Alerting rules (%d)", len(rules))))
+	for _, r := range rules {
+		state, sev := "enabled", severityOK
+		if r.Disabled {
+			state, sev = "disabled", severityMuted
+		}
+		threshold := "-"
+		if v, err := r.Threshold(); err == nil {
+			threshold = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		fmt.Printf("  %s %-32s %-10s %-10s %s\n", theme.Mark(sev, state, 10), r.Alert, r.Labels["severity"], threshold, theme.Dim.Render(r.File))
+	}
+	return nil
+}
+
+func runAlertsThreshold(cmd *cobra.Command, args []string) error {
+	if (alertsSet == "") == (alertsScale == 0) {
+		return fmt.Errorf("give either --set or --scale")
+	}
+	var u alertrules.Update
+	if alertsSet != "" {
+		v, err := strconv.ParseFloat(alertsSet, 64)
+		if err != nil {
+			return fmt.Errorf("invalid --set: %w", err)
+		}
+		u.Threshold = &v
+	}
+	if alertsScale < 0 {
+		return fmt.Errorf("--scale must be positive")
+	}
+	u.Factor = alertsScale
+	return runAlertsUpdate(cmd, u)
+}
+
+// runAlertsUpdate applies an update to the selected rules, previews it, and
+// unless --dry-run writes the changed files and reloads Prometheus
+func runAlertsUpdate(cmd *cobra.Command, u alertrules.Update) error {
+	rs, sel, err := loadAlertRules()
+	if err != nil {
+		return err
+	}
+	matched := len(rs.Rules(sel))
+	changes := rs.Apply(sel, u)
+
+	failed := 0
+	for _, c := range changes {
+		if c.Error != "" {
+			failed++
+		}
+	}
+	var written []string
+	if !alertsDryRun {
+		if written, err = rs.Save(); err != nil {
+			return err
+		}
+	}
+
+	if jsonOut, _ := cmd.Flags().GetBool("json"); jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{
+			"selector": sel.String(),
+			"dryRun":   alertsDryRun,
+			"matched":  matched,
+			"changes":  changes,
+			"written":  written,
+		}); err != nil {
+			return err
+		}
+	} else {
+		printAlertChanges(sel, matched, changes)
+	}
+
+	if len(written) > 0 && alertsReload != "" {
+		if err := reloadPrometheus(alertsReload); err != nil {
+			return err
+		}
+		if jsonOut, _ := cmd.Flags().GetBool("json"); !jsonOut {
+			fmt.Println(theme.Mark(severityOK, "Prometheus reloaded", 0))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rule change(s) failed", failed, len(changes))
+	}
+	return nil
+}
+
+func printAlertChanges(sel alertrules.Selector, matched int, changes []alertrules.Change) {
+	if len(changes) == 0 {
+		fmt.Printf("%d rule(s) match %s; nothing to change\n", matched, describeSelector(sel))
+		return
+	}
+	if alertsDryRun {
+		fmt.Println(theme.Title.Render(fmt.Sprintf("Would change %d of %d matching rule(s)", len(changes), matched)))
+	} else {
+		fmt.Println(theme.Title.Render(fmt.Sprintf("Changed %d of %d matching rule(s)", len(changes), matched)))
+	}
+	for _, c := range changes {
+		if c.Error != "" {
+			fmt.Printf("  %s %s\n", theme.Mark(severityError, c.Alert, 32), c.Error)
+			continue
+		}
+		fmt.Printf("  %s %s: %s → %s  %s\n", theme.Mark(severityPending, c.Alert, 32), c.Field, c.Old, c.New, theme.Dim.Render(c.File))
+	}
+}
+
+func describeSelector(sel alertrules.Selector) string {
+	if len(sel) == 0 {
+		return "(all rules)"
+	}
+	return sel.String()
+}
+
+// reloadPrometheus asks Prometheus to reread its rule files
+func reloadPrometheus(baseURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/-/reload", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reload Prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to reload Prometheus: %s (is --web.enable-lifecycle set?)", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/kubernetes/autoscale"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var AutoscaleCmd = &cobra.Command{
+	Use:         "autoscale <deployment>",
+	Annotations: needs(access.ScopeDeploy, "apply"),
+	Short:       "Recommend autoscaling settings and generate an HPA or KEDA ScaledObject",
+	Long: `Recommend min/max replicas and scaling targets for a Deployment from its
+observed request rate, replicas, CPU utilization, and latency in Prometheus.
+
+The request rate a replica sustains while meeting the latency SLO sets the
+per-replica target; the traffic trough and peak set the replica range; and
+the CPU used at the target rate sets the CPU utilization target.
+
+The recommendation is rendered as an autoscaling/v2 HorizontalPodAutoscaler
+or a KEDA ScaledObject, printed or written with --output, and applied to the
+cluster with --apply.
+
+Examples:
+  apm autoscale checkout --namespace shop
+  apm autoscale checkout --namespace shop --slo-latency 200ms --format keda -o checkout-scaler.yaml
+  apm autoscale checkout --namespace shop --apply --kubeconfig ~/.kube/config`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAutoscale,
+}
+
+var (
+	autoscalePrometheusURL string
+	autoscaleNamespace     string
+	autoscaleSelector      string
+	autoscaleSLOLatency    time.Duration
+	autoscaleSLOQuantile   float64
+	autoscaleHistory       string
+	autoscaleStep          time.Duration
+	autoscaleTarget        float64
+	autoscaleMinReplicas   int32
+	autoscaleFormat        string
+	autoscalePodsMetric    string
+	autoscaleOutput        string
+	autoscaleApply         bool
+	autoscaleKubeconfig    string
+	autoscaleTenant        string
+	autoscaleJSON          bool
+)
+
+func init() {
+	AutoscaleCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	AutoscaleCmd.Flags().StringVar(&autoscalePrometheusURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	AutoscaleCmd.Flags().StringVar(&autoscaleNamespace, "namespace", "default", "Namespace of the Deployment")
+	AutoscaleCmd.Flags().StringVar(&autoscaleSelector, "selector", "", `Label matchers of the request metrics (default namespace="<ns>", pod=~"<deployment>-.*")`)
+	AutoscaleCmd.Flags().DurationVar(&autoscaleSLOLatency, "slo-latency", 300*time.Millisecond, "Latency objective")
+	AutoscaleCmd.Flags().Float64Var(&autoscaleSLOQuantile, "slo-quantile", 0.99, "Quantile the latency objective applies to")
+	AutoscaleCmd.Flags().StringVar(&autoscaleHistory, "history", "7d", "History to analyze, e.g. 7d or 168h")
+	AutoscaleCmd.Flags().DurationVar(&autoscaleStep, "step", 5*time.Minute, "Sample interval")
+	AutoscaleCmd.Flags().Float64Var(&autoscaleTarget, "target-utilization", autoscale.DefaultTargetUtilization, "Share of the SLO-safe throughput replicas are scaled to")
+	AutoscaleCmd.Flags().Int32Var(&autoscaleMinReplicas, "min-replicas", autoscale.DefaultMinReplicas, "Lowest min replicas recommended")
+	AutoscaleCmd.Flags().StringVar(&autoscaleFormat, "format", "hpa", "Output format: hpa or keda")
+	AutoscaleCmd.Flags().StringVar(&autoscalePodsMetric, "pods-metric", "", "Per-pod request rate metric served by prometheus-adapter, added to the HPA")
+	AutoscaleCmd.Flags().StringVarP(&autoscaleOutput, "output", "o", "", "Write the manifest to a file")
+	AutoscaleCmd.Flags().BoolVar(&autoscaleApply, "apply", false, "Create or update the HPA or ScaledObject in the cluster")
+	AutoscaleCmd.Flags().StringVar(&autoscaleKubeconfig, "kubeconfig", "", "Path to kubeconfig (default $KUBECONFIG, ~/.kube/config, or in-cluster)")
+	AutoscaleCmd.Flags().StringVar(&autoscaleTenant, "tenant", "", "Tenant ID sent to a multi-tenant Prometheus")
+	AutoscaleCmd.Flags().BoolVar(&autoscaleJSON, "json", false, "Output the recommendation and manifest as JSON")
+}
+
+func runAutoscale(cmd *cobra.Command, args []string) error {
+	if autoscaleFormat != "hpa" && autoscaleFormat != "keda" {
+		return fmt.Errorf("invalid --format %q; expected hpa or keda", autoscaleFormat)
+	}
+	if autoscaleSLOQuantile <= 0 || autoscaleSLOQuantile >= 1 {
+		return fmt.Errorf("invalid --slo-quantile %g; expected a value between 0 and 1", autoscaleSLOQuantile)
+	}
+	history, err := retention.ParseDuration(autoscaleHistory)
+	if err != nil {
+		return fmt.Errorf("invalid --history: %w", err)
+	}
+
+	if autoscalePrometheusURL == "" {
+		autoscalePrometheusURL = prometheusURLFromConfig(cmd)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	if autoscaleTenant != "" {
+		client = tenancy.NewClient(client, autoscaleTenant)
+	}
+	collector := &autoscale.Collector{
+		PrometheusURL: autoscalePrometheusURL,
+		Client:        client,
+		History:       history,
+		Step:          autoscaleStep,
+	}
+	workload := autoscale.Workload{Namespace: autoscaleNamespace, Deployment: args[0], Selector: autoscaleSelector}
+	slo := autoscale.SLO{Latency: autoscaleSLOLatency, Quantile: autoscaleSLOQuantile}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	samples, err := collector.Collect(ctx, workload, slo)
+	if err != nil {
+		return err
+	}
+	rec, err := autoscale.Recommend(samples, slo, autoscale.Options{
+		TargetUtilization: autoscaleTarget,
+		MinFloor:          autoscaleMinReplicas,
+	})
+	if err != nil {
+		return err
+	}
+	rec.Workload = workload.Deployment
+	rec.Namespace = workload.Namespace
+	if rec.RequestsPerReplica > 0 {
+		rec.RequestRate = workload.RequestRate()
+	}
+
+	var manifest interface{}
+	if autoscaleFormat == "keda" {
+		manifest = autoscale.KEDA(rec, autoscalePrometheusURL)
+	} else {
+		manifest = autoscale.HPA(rec, autoscalePodsMetric)
+	}
+	out, err := autoscale.YAML(manifest)
+	if err != nil {
+		return err
+	}
+
+	if autoscaleOutput != "" {
+		if err := os.WriteFile(autoscaleOutput, out, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", autoscaleOutput, err)
+		}
+	}
+
+	if autoscaleJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{"recommendation": rec, "manifest": manifest}); err != nil {
+			return err
+		}
+	} else {
+		printAutoscale(rec, len(samples), out)
+	}
+
+	if !autoscaleApply {
+		return nil
+	}
+	config, err := autoscaleRESTConfig()
+	if err != nil {
+		return err
+	}
+	if err := applyAutoscale(ctx, config, rec, manifest); err != nil {
+		return err
+	}
+	if !autoscaleJSON {
+		successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+		fmt.Println(successStyle.Render(fmt.Sprintf("✓ Applied %s for %s/%s", autoscaleFormat, rec.Namespace, rec.Workload)))
+	}
+	return nil
+}
+
+// applyAutoscale creates or updates the manifest in the cluster
+func applyAutoscale(ctx context.Context, config *rest.Config, rec autoscale.Recommendation, manifest interface{}) error {
+	if so, ok := manifest.(*autoscale.ScaledObject); ok {
+		client, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("failed to create kubernetes client: %w", err)
+		}
+		return autoscale.ApplyScaledObject(ctx, client, so)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return autoscale.ApplyHPA(ctx, clientset, autoscale.HPA(rec, autoscalePodsMetric))
+}
+
+// autoscaleRESTConfig loads the kubeconfig from --kubeconfig, $KUBECONFIG,
+// or ~/.kube/config, falling back to the in-cluster config
+func autoscaleRESTConfig() (*rest.Config, error) {
+	path := autoscaleKubeconfig
+	if path == "" {
+		path = os.Getenv("KUBECONFIG")
+	}
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(home, ".kube", "config")); err == nil {
+				path = filepath.Join(home, ".kube", "config")
+			}
+		}
+	}
+
+	var config *rest.Config
+	var err error
+	if path != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", path)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes config: %w", err)
+	}
+	return config, nil
+}
+
+// printAutoscale prints the recommendation, its rationale, and the manifest
+func printAutoscale(rec autoscale.Recommendation, samples int, manifest []byte) {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	fmt.Println(titleStyle.Render(fmt.Sprintf("Autoscaling Recommendation for %s/%s", rec.Namespace, rec.Workload)))
+	fmt.Println(dimStyle.Render(fmt.Sprintf("Based on %d samples", samples)))
+	fmt.Println()
+
+	line := fmt.Sprintf("Replicas %d-%d, CPU target %d%%", rec.MinReplicas, rec.MaxReplicas, rec.CPUTarget)
+	if rec.RequestsPerReplica > 0 {
+		line += fmt.Sprintf(", %.2f req/s per replica", rec.RequestsPerReplica)
+	}
+	fmt.Println(successStyle.Render(line))
+	for _, r := range rec.Rationale {
+		fmt.Println(dimStyle.Render("  • " + r))
+	}
+	fmt.Println()
+
+	if autoscaleOutput != "" {
+		fmt.Println(successStyle.Render("✓ Wrote " + autoscaleOutput))
+		return
+	}
+	fmt.Print(string(manifest))
+}
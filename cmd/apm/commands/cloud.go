@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/cloud/state"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var CloudCmd = &cobra.Command{
+	Use:         "cloud",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Manage cloud monitoring resources created by APM",
+	Long: `Manage the CloudWatch dashboards, alarms, log groups, metric filters,
+SNS topics, and event rules created by APM monitoring setup.
+
+Every resource the setup creates or that is imported is recorded in a state
+manifest per environment, stored locally in .apm/state or in S3.`,
+}
+
+var cloudTeardownCmd = &cobra.Command{
+	Use:         "teardown",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Delete the monitoring resources recorded for an environment",
+	Long: `Delete exactly the resources recorded in an environment's state manifest,
+dependents first. Resources not recorded in the state are never touched. Progress is
+saved after each deletion, so an interrupted teardown can be rerun.
+
+Examples:
+  apm cloud teardown --environment staging --dry-run
+  apm cloud teardown --environment production --state s3://ops-bucket/apm --yes`,
+	RunE: runCloudTeardown,
+}
+
+var cloudImportCmd = &cobra.Command{
+	Use:         "import",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Adopt existing monitoring resources into an environment's state",
+	Long: `Discover existing dashboards, alarms, log groups, and SNS topics whose names
+match the filters and record them in the environment's state manifest. Later
+setups skip adopted resources instead of creating duplicates, and teardown
+deletes them along with the resources APM created.
+
+Examples:
+  apm cloud import -e production --region us-east-1 --prefix APM- --dry-run
+  apm cloud import -e production --region us-east-1 --prefix /aws/apm/ --kind log_group
+  apm cloud import -e staging --region eu-west-1 --match '^(APM|apm)-staging-'`,
+	RunE: runCloudImport,
+}
+
+var (
+	cloudEnvironment string
+	cloudStateURL    string
+	cloudStateRegion string
+	cloudDryRun      bool
+	cloudYes         bool
+
+	cloudImportRegion   string
+	cloudImportPrefixes []string
+	cloudImportMatch    string
+	cloudImportKinds    []string
+)
+
+func init() {
+	CloudCmd.PersistentFlags().StringVarP(&cloudEnvironment, "environment", "e", "", "Environment whose resources to manage")
+	CloudCmd.PersistentFlags().StringVar(&cloudStateURL, "state", state.DefaultDir, "State location: a directory or s3://bucket/prefix")
+	CloudCmd.PersistentFlags().StringVar(&cloudStateRegion, "state-region", "", "Region of the S3 state bucket")
+
+	cloudTeardownCmd.Flags().BoolVar(&cloudDryRun, "dry-run", false, "List the resources that would be deleted")
+	cloudTeardownCmd.Flags().BoolVarP(&cloudYes, "yes", "y", false, "Delete without asking for confirmation")
+
+	cloudImportCmd.Flags().StringVar(&cloudImportRegion, "region", os.Getenv("AWS_REGION"), "Region to discover resources in")
+	cloudImportCmd.Flags().StringSliceVar(&cloudImportPrefixes, "prefix", nil, "Name prefix of resources to adopt (repeatable)")
+	cloudImportCmd.Flags().StringVar(&cloudImportMatch, "match", "", "Regular expression resource names must match")
+	cloudImportCmd.Flags().StringSliceVar(&cloudImportKinds, "kind", nil, "Kinds to discover: dashboard, alarm, log_group, sns_topic (default all)")
+	cloudImportCmd.Flags().BoolVar(&cloudDryRun, "dry-run", false, "List the resources that would be adopted")
+
+	CloudCmd.AddCommand(cloudTeardownCmd)
+	CloudCmd.AddCommand(cloudImportCmd)
+	CloudCmd.AddCommand(cloudReplicationCmd)
+}
+
+// cloudStateStore returns the state store selected by flags
+func cloudStateStore() (state.Store, error) {
+	if cloudEnvironment == "" {
+		return nil, fmt.Errorf("--environment is required")
+	}
+	// After a failover the replica of a replicated state bucket is used
+	replication, err := state.LoadReplication(state.ReplicationPath)
+	if err != nil {
+		return nil, err
+	}
+	if replication != nil {
+		return replication.Resolve(cloudStateURL, cloudStateRegion)
+	}
+	return state.ParseStore(cloudStateURL, cloudStateRegion)
+}
+
+func runCloudTeardown(cmd *cobra.Command, args []string) error {
+	store, err := cloudStateStore()
+	if err != nil {
+		return err
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+	teardown := &state.Teardown{Store: store}
+	resources, err := teardown.Preview(context.Background(), cloudEnvironment)
+	if errors.Is(err, state.ErrNotFound) {
+		fmt.Println(warningStyle.Render(fmt.Sprintf("⚠ No monitoring state recorded for %s", cloudEnvironment)))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(titleStyle.Render(fmt.Sprintf("%d resource(s) recorded for %s:", len(resources), cloudEnvironment)))
+	for _, r := range resources {
+		fmt.Println("  - " + r.String())
+	}
+	if cloudDryRun || len(resources) == 0 {
+		return nil
+	}
+
+	if !cloudYes {
+		fmt.Printf("\nDelete these resources? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Println("Teardown aborted")
+			return nil
+		}
+	}
+
+	ctx, tracker, cancel := startProgress(cmd, "cloud teardown", 30*time.Minute)
+	defer cancel()
+
+	removed, err := teardown.Destroy(ctx, cloudEnvironment)
+	err = tracker.Finish(err)
+	for _, r := range removed {
+		fmt.Println(successStyle.Render("✓ deleted " + r.String()))
+	}
+	return err
+}
+
+func runCloudImport(cmd *cobra.Command, args []string) error {
+	store, err := cloudStateStore()
+	if err != nil {
+		return err
+	}
+	if cloudImportRegion == "" {
+		return fmt.Errorf("--region is required")
+	}
+
+	filter := state.Filter{Prefixes: cloudImportPrefixes}
+	if cloudImportMatch != "" {
+		pattern, err := regexp.Compile(cloudImportMatch)
+		if err != nil {
+			return fmt.Errorf("invalid --match pattern: %w", err)
+		}
+		filter.Pattern = pattern
+	}
+	for _, k := range cloudImportKinds {
+		filter.Kinds = append(filter.Kinds, state.Kind(k))
+	}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	importer := &state.Importer{Store: store}
+	if cloudDryRun {
+		_, adopt, err := importer.Plan(ctx, cloudEnvironment, cloudImportRegion, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Println(titleStyle.Render(fmt.Sprintf("%d resource(s) would be adopted into %s:", len(adopt), cloudEnvironment)))
+		for _, r := range adopt {
+			fmt.Println("  - " + r.String())
+		}
+		return nil
+	}
+
+	adopted, err := importer.Import(ctx, cloudEnvironment, cloudImportRegion, filter)
+	if err != nil {
+		return err
+	}
+	if len(adopted) == 0 {
+		fmt.Println(successStyle.Render("✓ No new matching resources; state is up to date"))
+		return nil
+	}
+	for _, r := range adopted {
+		fmt.Println(successStyle.Render("✓ adopted " + r.String()))
+	}
+	fmt.Printf("\n%d resource(s) adopted into %s\n", len(adopted), cloudEnvironment)
+	return nil
+}
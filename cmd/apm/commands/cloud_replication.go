@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/cloud/state"
+	"github.com/spf13/cobra"
+)
+
+var cloudReplicationCmd = &cobra.Command{
+	Use:         "replication",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Replicate the S3 state bucket to another region and fail over to it",
+	Long: `Replicate the S3 bucket holding APM state and configuration to a bucket in
+another region, check that the replica keeps up, and switch the tooling to it
+during a regional outage.
+
+Replication runs both ways with S3 Replication Time Control, which replicates
+99.99% of objects within 15 minutes: the recovery point objective (RPO) of a
+failover. The replicated pair and the active bucket are recorded in
+.apm/replication.json; every apm cloud command given either bucket as --state
+uses the active one.
+
+Examples:
+  apm cloud replication setup --state s3://apm-state/apm --state-region us-east-1 \
+    --replica apm-state-replica --replica-region us-west-2 \
+    --role arn:aws:iam::123456789012:role/apm-replication
+  apm cloud replication status --json
+  apm cloud replication failover --yes`,
+}
+
+var cloudReplicationSetupCmd = &cobra.Command{
+	Use:         "setup",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Replicate the --state bucket to a replica bucket in another region",
+	RunE:        runCloudReplicationSetup,
+}
+
+var cloudReplicationStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Check that the standby bucket is reachable and within the RPO",
+	Long: `Check that the standby bucket is reachable, versioned, and replicated to, and
+report how far it is behind: the age of the newest heartbeat replicated from
+the active bucket, and the S3 ReplicationLatency and
+OperationsPendingReplication metrics. Each check writes the next heartbeat, so
+run it at least every 15 minutes, e.g. from cron; it exits non-zero when the
+replica is unhealthy.`,
+	RunE: runCloudReplicationStatus,
+}
+
+var cloudReplicationFailoverCmd = &cobra.Command{
+	Use:         "failover",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Switch the tooling to the standby bucket, or back",
+	Long: `Make the standby bucket active. The standby must be reachable and versioned
+unless --force is given; the active bucket may be down. Writes made during the
+failover replicate back once its region recovers, and running failover again
+switches back.`,
+	RunE: runCloudReplicationFailover,
+}
+
+var (
+	cloudReplicaBucket string
+	cloudReplicaRegion string
+	cloudReplicaRole   string
+	cloudFailoverForce bool
+)
+
+func init() {
+	cloudReplicationSetupCmd.Flags().StringVar(&cloudReplicaBucket, "replica", "", "Replica bucket name")
+	cloudReplicationSetupCmd.Flags().StringVar(&cloudReplicaRegion, "replica-region", "", "Region of the replica bucket")
+	cloudReplicationSetupCmd.Flags().StringVar(&cloudReplicaRole, "role", "", "IAM role S3 assumes to replicate")
+	cloudReplicationFailoverCmd.Flags().BoolVar(&cloudFailoverForce, "force", false, "Fail over even if the standby fails its checks")
+	cloudReplicationFailoverCmd.Flags().BoolVarP(&cloudYes, "yes", "y", false, "Fail over without asking for confirmation")
+
+	cloudReplicationCmd.AddCommand(cloudReplicationSetupCmd)
+	cloudReplicationCmd.AddCommand(cloudReplicationStatusCmd)
+	cloudReplicationCmd.AddCommand(cloudReplicationFailoverCmd)
+}
+
+func runCloudReplicationSetup(cmd *cobra.Command, args []string) error {
+	if !strings.HasPrefix(cloudStateURL, "s3://") {
+		return fmt.Errorf("--state must be an s3://bucket/prefix URL")
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(cloudStateURL, "s3://"), "/")
+	replication := &state.Replication{
+		Primary: state.Bucket{Name: bucket, Region: cloudStateRegion},
+		Replica: state.Bucket{Name: cloudReplicaBucket, Region: cloudReplicaRegion},
+		Prefix:  prefix,
+		RoleARN: cloudReplicaRole,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := replication.Setup(ctx); err != nil {
+		return err
+	}
+	replication.ChangedAt = time.Now().UTC()
+	if err := replication.Save(state.ReplicationPath); err != nil {
+		return err
+	}
+	fmt.Println(theme.Mark(severityOK, fmt.Sprintf("Replicating s3://%s (%s) and s3://%s (%s) both ways, RPO %s",
+		bucket, cloudStateRegion, cloudReplicaBucket, cloudReplicaRegion, state.ReplicationRPO), 0))
+	fmt.Println(theme.Dim.Render("Recorded in " + state.ReplicationPath + "; objects written before now are not replicated"))
+	return nil
+}
+
+// loadCloudReplication reads the replication record written by setup
+func loadCloudReplication() (*state.Replication, error) {
+	replication, err := state.LoadReplication(state.ReplicationPath)
+	if err != nil {
+		return nil, err
+	}
+	if replication == nil {
+		return nil, fmt.Errorf("no replication recorded in %s; run apm cloud replication setup first", state.ReplicationPath)
+	}
+	return replication, nil
+}
+
+func runCloudReplicationStatus(cmd *cobra.Command, args []string) error {
+	replication, err := loadCloudReplication()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	health, err := replication.Check(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if jsonOut, _ := cmd.Flags().GetBool("json"); jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(health); err != nil {
+			return err
+		}
+	} else {
+		printReplicaHealth(replication, health)
+	}
+	if !health.Healthy() {
+		return fmt.Errorf("replica unhealthy: %d problem(s)", len(health.Problems))
+	}
+	return nil
+}
+
+func printReplicaHealth(replication *state.Replication, health *state.ReplicaHealth) {
+	fmt.Println(theme.Title.Render("State Bucket Replication"))
+	active := fmt.Sprintf("s3://%s (%s)", health.Active.Name, health.Active.Region)
+	if replication.FailedOver {
+		active += " — failed over " + display.DateTime(replication.ChangedAt)
+	}
+	fmt.Printf("  Active:   %s\n", active)
+	fmt.Printf("  Standby:  s3://%s (%s)\n", health.Standby.Name, health.Standby.Region)
+
+	lag := "no heartbeat yet"
+	if health.Lag > 0 {
+		lag = health.Lag.Round(time.Second).String()
+	}
+	fmt.Printf("  Lag:      %s (RPO %s)\n", lag, state.ReplicationRPO)
+	if health.LatencySeconds != nil {
+		fmt.Printf("  Latency:  %ss\n", display.Float(*health.LatencySeconds, 0))
+	}
+	if health.PendingOperations != nil {
+		fmt.Printf("  Pending:  %s operations\n", display.Float(*health.PendingOperations, 0))
+	}
+
+	if health.Healthy() {
+		fmt.Println(theme.Mark(severityOK, "Standby is ready to take over", 0))
+		return
+	}
+	for _, p := range health.Problems {
+		fmt.Println(theme.Mark(severityError, p, 0))
+	}
+}
+
+func runCloudReplicationFailover(cmd *cobra.Command, args []string) error {
+	replication, err := loadCloudReplication()
+	if err != nil {
+		return err
+	}
+	standby := replication.Standby()
+	if !cloudYes {
+		fmt.Printf("Switch the tooling to s3://%s (%s)? [y/N] ", standby.Name, standby.Region)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Println("Failover aborted")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	health, err := replication.Failover(ctx, time.Now(), cloudFailoverForce)
+	if err != nil {
+		return err
+	}
+	if err := replication.Save(state.ReplicationPath); err != nil {
+		return err
+	}
+	for _, p := range health.Problems {
+		fmt.Println(theme.Mark(severityWarning, p, 0))
+	}
+	fmt.Println(theme.Mark(severityOK, fmt.Sprintf("Tooling now uses s3://%s (%s)", standby.Name, standby.Region), 0))
+	return nil
+}
@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/apmconfig"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var ConfigCmd = &cobra.Command{
+	Use:         "config",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Manage the apm.yaml configuration",
+	Long: `Manage the apm.yaml configuration.
+
+apm.yaml carries a schema version. Files written for an older version keep
+loading, but settings the schema has since moved are ignored until the file
+is migrated with apm config migrate.
+
+Every change apm makes to apm.yaml is recorded in .apm/config-history.jsonl
+next to it: who made it, when, with which command, and the settings it
+changed. apm config history lists the changes, apm config show --at rebuilds
+the settings at a point in time, and apm config undo reverts one change.`,
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:         "migrate",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Upgrade apm.yaml to the current schema version",
+	Long: `Upgrade apm.yaml to the current schema version, applying each schema change
+since the version the file was written for. Comments and key order are kept.
+The original file is saved next to it with a .bak suffix.
+
+Examples:
+  apm config migrate --dry-run
+  apm config migrate
+  apm config migrate -c deploy/apm.yaml --no-backup`,
+	RunE: runConfigMigrate,
+}
+
+var configHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the recorded changes to apm.yaml",
+	Long: `List the recorded changes to apm.yaml, oldest first, with the settings each
+one changed. Hand edits are recorded the next time apm changes the file.
+
+Examples:
+  apm config history
+  apm config history --json`,
+	Args: cobra.NoArgs,
+	RunE: runConfigHistory,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show apm.yaml as it was at a point in time",
+	Long: `Show the settings of apm.yaml as they were at a point in time, rebuilt from
+the change history. Comments are not part of the history.
+
+Examples:
+  apm config show --at 2024-05-01T12:00:00Z
+  apm config show --at 2h`,
+	Args: cobra.NoArgs,
+	RunE: runConfigShow,
+}
+
+var configUndoCmd = &cobra.Command{
+	Use:         "undo <event-id>",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Revert one recorded change to apm.yaml",
+	Long: `Revert the settings changed by one event of apm config history, keeping
+later changes. An unambiguous prefix of the event ID is enough. The undo
+fails, changing nothing, when a setting the event changed has been changed
+again since; undo the later event first. The undo is itself recorded.
+
+Examples:
+  apm config undo 3f2a9c
+  apm config undo 3f2a9c --dry-run`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConfigUndo,
+}
+
+var (
+	configMigrateDryRun   bool
+	configMigrateNoBackup bool
+	configHistoryJSON     bool
+	configShowAt          string
+	configUndoDryRun      bool
+)
+
+func init() {
+	ConfigCmd.PersistentFlags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+
+	configMigrateCmd.Flags().BoolVar(&configMigrateDryRun, "dry-run", false, "Show the changes as a diff without writing them")
+	configMigrateCmd.Flags().BoolVar(&configMigrateNoBackup, "no-backup", false, "Do not keep a copy of the original file")
+
+	configHistoryCmd.Flags().BoolVar(&configHistoryJSON, "json", false, "Print the events as JSON")
+	configShowCmd.Flags().StringVar(&configShowAt, "at", "", "RFC 3339 time, or a duration ago such as 2h")
+	configShowCmd.MarkFlagRequired("at")
+	configUndoCmd.Flags().BoolVar(&configUndoDryRun, "dry-run", false, "Show the changes as a diff without writing them")
+
+	ConfigCmd.AddCommand(configMigrateCmd)
+	ConfigCmd.AddCommand(configHistoryCmd)
+	ConfigCmd.AddCommand(configShowCmd)
+	ConfigCmd.AddCommand(configUndoCmd)
+}
+
+func runConfigMigrate(cmd *cobra.Command, args []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	result, err := apmconfig.Migrate(data)
+	if err != nil {
+		return err
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	if len(result.Steps) == 0 {
+		fmt.Printf("%s is at schema version %d, nothing to migrate.\n", configPath, result.To)
+		return nil
+	}
+	fmt.Println(titleStyle.Render(fmt.Sprintf("Migrating %s from version %d to %d", configPath, result.From, result.To)))
+	for _, step := range result.Steps {
+		fmt.Printf("\n%d -> %d: %s\n", step.From, step.To, step.Description)
+		for _, change := range step.Changes {
+			fmt.Printf("  - %s\n", change)
+		}
+	}
+
+	if configMigrateDryRun {
+		diff, err := result.Diff(configPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n%s", diff)
+		return nil
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+	if !configMigrateNoBackup {
+		if err := os.WriteFile(configPath+".bak", data, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to back up config: %w", err)
+		}
+	}
+	if err := os.WriteFile(configPath, result.After, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	recordConfigChange(configPath, "config migrate", "", data, result.After)
+	fmt.Printf("\n✅ Migrated %s", configPath)
+	if !configMigrateNoBackup {
+		fmt.Printf(" (original saved to %s.bak)", configPath)
+	}
+	fmt.Println()
+	return nil
+}
+
+func runConfigHistory(cmd *cobra.Command, args []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	events, err := apmconfig.OpenHistory(configPath).Events()
+	if err != nil {
+		return err
+	}
+	if configHistoryJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if events == nil {
+			events = []apmconfig.Event{}
+		}
+		return enc.Encode(events)
+	}
+	if len(events) == 0 {
+		fmt.Printf("No changes to %s have been recorded.\n", configPath)
+		return nil
+	}
+
+	idStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	for _, e := range events {
+		action := e.Action
+		if e.Reverts != "" {
+			action += " of " + e.Reverts
+		}
+		fmt.Printf("%s  %s  %s  %s (%s)\n", idStyle.Render(e.ID), display.DateTime(e.Time), e.Actor, action, e.Source)
+		for _, c := range e.Changes {
+			fmt.Printf("    %s\n", describeChange(c))
+		}
+	}
+	return nil
+}
+
+// describeChange renders a change on one line
+func describeChange(c apmconfig.Change) string {
+	value := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil || len(data) > 60 {
+			return "…"
+		}
+		return string(data)
+	}
+	switch c.Op {
+	case apmconfig.OpAdd:
+		return fmt.Sprintf("+ %s = %s", c.Key(), value(c.New))
+	case apmconfig.OpRemove:
+		return fmt.Sprintf("- %s (was %s)", c.Key(), value(c.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Key(), value(c.Old), value(c.New))
+	}
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	at, err := time.Parse(time.RFC3339, configShowAt)
+	if err != nil {
+		ago, derr := time.ParseDuration(configShowAt)
+		if derr != nil || ago < 0 {
+			return fmt.Errorf("invalid --at %q: use an RFC 3339 time or a duration such as 2h", configShowAt)
+		}
+		at = time.Now().Add(-ago)
+	}
+	data, err := apmconfig.OpenHistory(configPath).At(at)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
+
+func runConfigUndo(cmd *cobra.Command, args []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	event, err := apmconfig.OpenHistory(configPath).Find(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	reverted, err := apmconfig.Revert(data, event)
+	if errors.Is(err, apmconfig.ErrConflict) {
+		return fmt.Errorf("%w; undo the later change first", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if configUndoDryRun {
+		diff, err := (&apmconfig.Result{Before: data, After: reverted}).Diff(configPath)
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+		return nil
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(configPath, reverted, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	recordConfigChange(configPath, "config undo", event.ID, data, reverted)
+	fmt.Printf("✅ Reverted %s (%s by %s)\n", event.ID, event.Action, event.Actor)
+	for _, c := range event.Changes {
+		fmt.Printf("  %s\n", describeChange(c))
+	}
+	return nil
+}
+
+// recordConfigChange adds a change apm made to apm.yaml to its history. The
+// change is already written, so a failure is a warning.
+func recordConfigChange(configPath, action, reverts string, before, after []byte) {
+	event := apmconfig.Event{Source: "cli", Action: action, Reverts: reverts}
+	if _, err := apmconfig.OpenHistory(configPath).Record(event, before, after); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record the change in the config history: %v\n", err)
+	}
+}
+
+// WarnConfigVersion prints a warning when the apm.yaml at path was written
+// for an older or newer schema than this build's
+func WarnConfigVersion(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	v, err := apmconfig.Version(data)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", path, err)
+	case v < apmconfig.CurrentVersion:
+		fmt.Fprintf(os.Stderr, "Warning: %s uses schema version %d; run 'apm config migrate' to upgrade it to %d\n", path, v, apmconfig.CurrentVersion)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/chaksack/apm/pkg/access"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
@@ -15,8 +16,9 @@ import (
 )
 
 var DashboardCmd = &cobra.Command{
-	Use:   "dashboard",
-	Short: "Access APM monitoring interfaces",
+	Use:         "dashboard",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Access APM monitoring interfaces",
 	Long: `Display a list of all configured APM tool web interfaces and provide quick access to them.
 Select a tool to automatically open its web interface in your default browser.`,
 	RunE: runDashboard,
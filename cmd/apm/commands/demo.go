@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/demo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+)
+
+var DemoCmd = &cobra.Command{
+	Use:         "demo [scenario]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Generate demo traces, metrics, and logs from fake services",
+	Long: `Run a fleet of fake services that send realistic, correlated traces,
+metrics, and logs to the APM stack, so you can explore dashboards and alerts
+before instrumenting a real application.
+
+Scenarios:
+  checkout   A healthy web shop: browsing, carts, and checkouts (default)
+  failures   The payment provider starts failing, firing HighErrorRate
+  slow-db    Inventory queries slow down, firing HighLatency
+
+Traces are sent over OTLP to Jaeger, metrics are served for Prometheus to
+scrape as the apm-demo job, and logs are pushed to Loki when it is enabled.
+
+Examples:
+  apm demo
+  apm demo failures --duration 20m
+  apm demo slow-db --rate 20 --log-file demo.log
+  apm demo --list`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDemo,
+}
+
+var (
+	demoList         bool
+	demoRate         float64
+	demoDuration     time.Duration
+	demoOTLPEndpoint string
+	demoMetricsAddr  string
+	demoLokiURL      string
+	demoLogFile      string
+	demoSeed         int64
+)
+
+func init() {
+	DemoCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	DemoCmd.Flags().BoolVar(&demoList, "list", false, "List the scenarios and exit")
+	DemoCmd.Flags().Float64Var(&demoRate, "rate", demo.DefaultRate, "User flows started per second")
+	DemoCmd.Flags().DurationVar(&demoDuration, "duration", 0, "Stop after this long (default: until interrupted)")
+	DemoCmd.Flags().StringVar(&demoOTLPEndpoint, "otlp-endpoint", "localhost:4317", "OTLP gRPC endpoint traces are sent to, empty to disable")
+	DemoCmd.Flags().StringVar(&demoMetricsAddr, "metrics-addr", ":9464", "Address metrics are served on for Prometheus, empty to disable")
+	DemoCmd.Flags().StringVar(&demoLokiURL, "loki-url", "", "Loki URL logs are pushed to (default from apm.loki.port when enabled)")
+	DemoCmd.Flags().StringVar(&demoLogFile, "log-file", "", "File the JSON logs are appended to, - for stdout")
+	DemoCmd.Flags().Int64Var(&demoSeed, "seed", 0, "Random seed, for repeatable runs")
+}
+
+func runDemo(cmd *cobra.Command, args []string) error {
+	if demoList {
+		for _, s := range demo.Scenarios() {
+			fmt.Printf("%-10s %s\n", s.Name, s.Description)
+			for _, p := range s.Phases {
+				fmt.Printf("  %-16s %-8s %s\n", p.Name, p.Duration, p.Description)
+			}
+		}
+		return nil
+	}
+
+	name := "checkout"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	scenario, err := demo.Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	if demoLokiURL == "" {
+		demoLokiURL = localStackFromConfig(cmd).Loki
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if demoDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, demoDuration)
+		defer cancel()
+	}
+
+	cfg := demo.Config{
+		Scenario: scenario,
+		Rate:     demoRate,
+		Seed:     demoSeed,
+		OnPhase: func(p demo.Phase) {
+			fmt.Printf("%s  phase %s: %s\n", display.Clock(time.Now()), p.Name, p.Description)
+		},
+	}
+
+	if demoOTLPEndpoint != "" {
+		exporter, err := otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpoint(demoOTLPEndpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		cfg.Exporter = exporter
+	}
+
+	reg := prometheus.NewRegistry()
+	cfg.Registerer = reg
+	if demoMetricsAddr != "" {
+		listener, err := net.Listen("tcp", demoMetricsAddr)
+		if err != nil {
+			return fmt.Errorf("failed to serve metrics on %s: %w", demoMetricsAddr, err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(os.Stderr, "Metrics server failed: %v\n", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	switch demoLogFile {
+	case "":
+	case "-":
+		cfg.Logs = os.Stdout
+	default:
+		file, err := os.OpenFile(demoLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer file.Close()
+		cfg.Logs = file
+	}
+	if demoLokiURL != "" {
+		cfg.Loki = &demo.Loki{URL: demoLokiURL, Client: &http.Client{Timeout: 10 * time.Second}}
+	}
+
+	sim, err := demo.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if cfg.Logs == os.Stdout {
+		out = os.Stderr
+	}
+	printDemoSummary(out, readConfigFlag(cmd), scenario)
+	return sim.Run(ctx)
+}
+
+func printDemoSummary(out io.Writer, config *viper.Viper, scenario *demo.Scenario) {
+	port := func(key string, def int) int {
+		if p := config.GetInt(key); p != 0 {
+			return p
+		}
+		return def
+	}
+
+	fmt.Fprintf(out, "Running the %s demo: %s\n", scenario.Name, scenario.Description)
+	fmt.Fprintf(out, "Services: %s\n", strings.Join(scenario.Services(), ", "))
+	if demoOTLPEndpoint != "" {
+		fmt.Fprintf(out, "  Traces:  %s -> Jaeger UI http://localhost:%d\n", demoOTLPEndpoint, port("apm.jaeger.ui_port", 16686))
+	}
+	if demoMetricsAddr != "" {
+		addr := demoMetricsAddr
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+		fmt.Fprintf(out, "  Metrics: http://%s/metrics -> Prometheus http://localhost:%d\n", addr, port("apm.prometheus.port", 9090))
+	}
+	if demoLokiURL != "" {
+		fmt.Fprintf(out, "  Logs:    %s\n", demoLokiURL)
+	}
+	if demoLogFile != "" && demoLogFile != "-" {
+		fmt.Fprintf(out, "  Logs:    %s\n", demoLogFile)
+	}
+	fmt.Fprintf(out, "Explore the dashboards at http://localhost:%d. Press Ctrl+C to stop.\n", port("apm.grafana.port", 3000))
+}
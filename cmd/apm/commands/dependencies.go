@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/dependency"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var DependenciesCmd = &cobra.Command{
+	Use:         "dependencies [name...]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Check third-party APIs against their vendor SLAs",
+	Long: `Compare the availability and latency of third-party APIs with the SLAs
+declared under "dependencies" in apm.yaml, and report breaches with evidence:
+failures by status code, the worst periods, and the traces of failed and
+slow calls.
+
+The SLIs come from the dependency_requests_total and
+dependency_request_duration_seconds metrics exported by services that record
+client spans with instrumentation.DependencyTracker. Trace evidence needs
+Prometheus exemplar storage (--enable-feature=exemplar-storage).
+
+  dependencies:
+    - name: Stripe
+      host: api.stripe.com
+      availability: 99.99   # percent of calls succeeding
+      latency: 800ms        # at the quantile
+      quantile: 0.99
+      window: 30d
+
+Examples:
+  apm dependencies
+  apm dependencies Stripe --window 7d
+  apm dependencies --rules > configs/prometheus/alerts/dependency-alerts.yml`,
+	RunE: runDependencies,
+}
+
+var (
+	dependenciesPrometheusURL string
+	dependenciesWindow        string
+	dependenciesRules         bool
+	dependenciesTenant        string
+	dependenciesJSON          bool
+)
+
+func init() {
+	DependenciesCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	DependenciesCmd.Flags().StringVar(&dependenciesPrometheusURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	DependenciesCmd.Flags().StringVar(&dependenciesWindow, "window", "", "Period to evaluate, e.g. 7d (default each SLA's window)")
+	DependenciesCmd.Flags().BoolVar(&dependenciesRules, "rules", false, "Print Prometheus alerting rules for the SLAs instead of a report")
+	DependenciesCmd.Flags().StringVar(&dependenciesTenant, "tenant", "", "Tenant ID sent to a multi-tenant Prometheus")
+	DependenciesCmd.Flags().BoolVar(&dependenciesJSON, "json", false, "Output the reports as JSON")
+}
+
+func runDependencies(cmd *cobra.Command, args []string) error {
+	var window time.Duration
+	if dependenciesWindow != "" {
+		var err error
+		if window, err = retention.ParseDuration(dependenciesWindow); err != nil {
+			return fmt.Errorf("invalid --window: %w", err)
+		}
+	}
+
+	configPath, _ := cmd.Flags().GetString("config")
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	if err := config.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	var slas []dependency.SLA
+	if err := config.UnmarshalKey("dependencies", &slas); err != nil {
+		return fmt.Errorf("invalid dependencies in %s: %w", configPath, err)
+	}
+	if len(slas) == 0 {
+		return fmt.Errorf("no dependencies declared in %s", configPath)
+	}
+	if len(args) > 0 {
+		var selected []dependency.SLA
+		for _, name := range args {
+			found := false
+			for _, sla := range slas {
+				if strings.EqualFold(sla.Name, name) || sla.Host == name {
+					selected = append(selected, sla)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("dependency %q is not declared in %s", name, configPath)
+			}
+		}
+		slas = selected
+	}
+
+	if dependenciesRules {
+		rules, err := dependency.AlertRules(slas)
+		if err != nil {
+			return err
+		}
+		fmt.Print(rules)
+		return nil
+	}
+
+	if dependenciesPrometheusURL == "" {
+		dependenciesPrometheusURL = prometheusURLFromConfig(cmd)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	if dependenciesTenant != "" {
+		client = tenancy.NewClient(client, dependenciesTenant)
+	}
+	evaluator := &dependency.Evaluator{PrometheusURL: dependenciesPrometheusURL, Client: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var reports []*dependency.Report
+	breached := 0
+	for _, sla := range slas {
+		report, err := evaluator.Evaluate(ctx, sla, window)
+		if err != nil {
+			return err
+		}
+		if report.Status == dependency.StatusBreached {
+			breached++
+		}
+		reports = append(reports, report)
+	}
+
+	if dependenciesJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{"dependencies": reports}); err != nil {
+			return err
+		}
+	} else {
+		printDependencies(reports)
+	}
+	if breached > 0 {
+		return fmt.Errorf("%d of %d dependencies breached their SLA", breached, len(reports))
+	}
+	return nil
+}
+
+// printDependencies prints one block per dependency with its evidence
+func printDependencies(reports []*dependency.Report) {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	fmt.Println(titleStyle.Render("Dependency SLAs"))
+	fmt.Println()
+
+	for _, r := range reports {
+		fmt.Println(titleStyle.Render(fmt.Sprintf("%s (%s)", r.SLA.Name, r.SLA.Host)))
+		fmt.Println(dimStyle.Render(fmt.Sprintf("  %s to %s, %s calls",
+			display.DateTime(r.Start), display.DateTime(r.End), display.Float(r.Requests, 0))))
+
+		switch r.Status {
+		case dependency.StatusNoData:
+			fmt.Println(warningStyle.Render("  ⚠ No calls recorded"))
+			fmt.Println()
+			continue
+		case dependency.StatusMet:
+			fmt.Println(successStyle.Render("  ✓ Within SLA"))
+		default:
+			for _, b := range r.Breaches {
+				fmt.Println(errorStyle.Render("  ✗ " + b))
+			}
+		}
+
+		if r.Availability != nil {
+			fmt.Printf("  Availability  %.3f%% (SLA %.3f%%, %.0f%% of error budget used)\n",
+				*r.Availability, r.SLA.Availability, *r.ErrorBudgetUsed*100)
+		}
+		if r.Latency != nil {
+			fmt.Printf("  p%-12g %s (SLA %s)\n", r.SLA.Quantile*100,
+				time.Duration(*r.Latency*float64(time.Second)).Round(time.Millisecond), r.SLA.Latency)
+		}
+
+		ev := r.Evidence
+		if len(ev.Codes) > 0 {
+			var codes []string
+			for _, c := range ev.Codes {
+				codes = append(codes, fmt.Sprintf("%s×%.0f", c.Code, c.Count))
+			}
+			fmt.Println(dimStyle.Render("  Codes         " + strings.Join(codes, ", ")))
+		}
+		for _, i := range ev.WorstIntervals {
+			var parts []string
+			if i.Availability != nil {
+				parts = append(parts, display.Float(*i.Availability, 3)+"% available")
+			}
+			if i.Latency != nil {
+				parts = append(parts, fmt.Sprintf("p%g %s", r.SLA.Quantile*100,
+					time.Duration(*i.Latency*float64(time.Second)).Round(time.Millisecond)))
+			}
+			fmt.Println(dimStyle.Render(fmt.Sprintf("  Worst         %s to %s: %s",
+				display.DateTime(i.Start), display.DateTime(i.End), strings.Join(parts, ", "))))
+		}
+		if len(ev.FailedTraces) > 0 {
+			fmt.Println(dimStyle.Render("  Failed traces " + strings.Join(ev.FailedTraces, " ")))
+		}
+		if len(ev.SlowTraces) > 0 {
+			fmt.Println(dimStyle.Render("  Slow traces   " + strings.Join(ev.SlowTraces, " ")))
+		}
+		fmt.Println()
+	}
+}
@@ -10,12 +10,15 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/chaksack/apm/internal/deploy"
+	"github.com/chaksack/apm/pkg/access"
 	"github.com/chaksack/apm/pkg/security"
+	"github.com/chaksack/apm/pkg/webhook"
 )
 
 var DeployCmd = &cobra.Command{
-	Use:   "deploy",
-	Short: "Deploy APM-instrumented application to cloud environments",
+	Use:         "deploy",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Deploy APM-instrumented application to cloud environments",
 	Long: `Deploy your application with integrated APM tools to various cloud environments.
 Supports Docker containers and Kubernetes deployments across AWS, Azure, and Google Cloud.`,
 	RunE: runDeploy,
@@ -111,6 +114,10 @@ type deployWizard struct {
 	deploymentStatus []string
 	deploymentError  error
 	isDeploying      bool
+
+	// Lifecycle webhooks and their delivery failures
+	webhooks    *webhook.Emitter
+	webhookErrs []error
 }
 
 func runDeploy(cmd *cobra.Command, args []string) error {
@@ -136,6 +143,7 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 		availableClusters:   []*Cluster{},
 		availableRegistries: []*Registry{},
 		isDryRun:            dryRun,
+		webhooks:            loadWebhooks(config),
 	}
 
 	// Run the wizard
@@ -144,6 +152,9 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("error running deployment wizard: %w", err)
 	}
+	for _, err := range wizard.webhookErrs {
+		warnWebhook(err)
+	}
 
 	// Check if deployment completed
 	if m, ok := finalModel.(*deployWizard); ok && m.completed {
@@ -950,26 +961,69 @@ type cloudProviderInitializedMsg struct{}
 func startDeployment(m *deployWizard) tea.Cmd {
 	return func() tea.Msg {
 		ctx := context.Background()
+		started := time.Now()
 
-		// Start deployment based on target
-		switch m.target {
-		case targetDocker:
-			return deployDocker(ctx, m)
-		case targetKubernetes:
-			return deployKubernetes(ctx, m)
-		case targetECS:
-			return deployToECS(ctx, m)
-		case targetEKS:
-			return deployToEKS(ctx, m)
-		case targetAKS:
-			return deployToAKS(ctx, m)
-		case targetGKE:
-			return deployToGKE(ctx, m)
-		case targetCloudRun:
-			return deployToCloudRun(ctx, m)
-		default:
-			return deploymentErrorMsg(fmt.Errorf("unsupported deployment target"))
+		m.emitDeployEvent(webhook.EventDeployStarted, "started", nil)
+		msg := runDeployment(ctx, m)
+
+		data := map[string]interface{}{"duration_seconds": time.Since(started).Seconds()}
+		status := "succeeded"
+		if err, ok := msg.(deploymentErrorMsg); ok {
+			status = "failed"
+			data["error"] = err.Error()
 		}
+		m.emitDeployEvent(webhook.EventDeployFinished, status, data)
+		return msg
+	}
+}
+
+// runDeployment deploys to the selected target
+func runDeployment(ctx context.Context, m *deployWizard) tea.Msg {
+	// Start deployment based on target
+	switch m.target {
+	case targetDocker:
+		return deployDocker(ctx, m)
+	case targetKubernetes:
+		return deployKubernetes(ctx, m)
+	case targetECS:
+		return deployToECS(ctx, m)
+	case targetEKS:
+		return deployToEKS(ctx, m)
+	case targetAKS:
+		return deployToAKS(ctx, m)
+	case targetGKE:
+		return deployToGKE(ctx, m)
+	case targetCloudRun:
+		return deployToCloudRun(ctx, m)
+	default:
+		return deploymentErrorMsg(fmt.Errorf("unsupported deployment target"))
+	}
+}
+
+// emitDeployEvent sends a deployment lifecycle webhook. Failures are kept
+// and reported once the wizard exits, so they do not disturb the screen.
+func (m *deployWizard) emitDeployEvent(eventType webhook.EventType, status string, data map[string]interface{}) {
+	if m.webhooks == nil || m.isDryRun {
+		return
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["target"] = getTargetName(m.target)
+	data["environment"] = m.config["environment"]
+	data["image"] = fmt.Sprintf("%s:%s", m.imageName, m.imageTag)
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	err := m.webhooks.Emit(ctx, webhook.Event{
+		Type:    eventType,
+		Source:  "apm deploy",
+		Subject: fmt.Sprint(m.config["service_name"]),
+		Status:  status,
+		Data:    data,
+	})
+	if err != nil {
+		m.webhookErrs = append(m.webhookErrs, err)
 	}
 }
 
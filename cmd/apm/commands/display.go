@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"github.com/chaksack/apm/pkg/locale"
+	"github.com/spf13/cobra"
+)
+
+// display formats the times and numbers shown to people. JSON output is not
+// localized.
+var display = locale.UTC
+
+// SetupDisplay sets the locale and timezone of the output from --locale and
+// --timezone, or from APM_LOCALE, APM_TIMEZONE, and the POSIX locale and TZ
+// variables
+func SetupDisplay(cmd *cobra.Command) error {
+	name, _ := cmd.Flags().GetString("locale")
+	timezone, _ := cmd.Flags().GetString("timezone")
+	if name == "" || timezone == "" {
+		env, err := locale.FromEnv()
+		if err != nil {
+			return err
+		}
+		if name == "" && env.Locale() != "und" {
+			name = env.Locale()
+		}
+		if timezone == "" {
+			timezone = env.Location().String()
+		}
+	}
+	f, err := locale.New(name, timezone)
+	if err != nil {
+		return err
+	}
+	display = f
+	return nil
+}
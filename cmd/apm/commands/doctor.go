@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/tools"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var DoctorCmd = &cobra.Command{
+	Use:         "doctor",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Check the running APM tools against the compatibility matrix",
+	Long: `Check that Prometheus, Grafana, Jaeger, and Loki are reachable, read the
+version each one reports, and look it up in the compatibility matrix.
+
+The matrix lists the tool versions the contract tests in test/contract have
+verified the APM health checkers, clients, and config templates against. A
+version that was not tested is matched to a tested patch release of the same
+minor version when there is one.
+
+Tool URLs default to the ports in apm.yaml on localhost.
+
+Examples:
+  apm doctor
+  apm doctor --grafana-url http://grafana.internal:3000
+  apm doctor --matrix pkg/tools/compatibility.json --json`,
+	RunE: runDoctor,
+}
+
+var (
+	doctorMatrix string
+	doctorURLs   = map[tools.ToolType]*string{}
+	doctorJSON   bool
+)
+
+// doctorTools are the contract-tested tools, with their apm.yaml port key
+// and default port
+var doctorTools = []struct {
+	tool    tools.ToolType
+	portKey string
+	port    int
+}{
+	{tools.ToolTypePrometheus, "apm.prometheus.port", 9090},
+	{tools.ToolTypeGrafana, "apm.grafana.port", 3000},
+	{tools.ToolTypeJaeger, "apm.jaeger.ui_port", 16686},
+	{tools.ToolTypeLoki, "apm.loki.port", 3100},
+}
+
+// doctorResult is one tool's health and compatibility
+type doctorResult struct {
+	URL     string                     `json:"url"`
+	Status  tools.ToolStatus           `json:"status"`
+	Error   string                     `json:"error,omitempty"`
+	Verdict tools.CompatibilityVerdict `json:"compatibility"`
+}
+
+func init() {
+	DoctorCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	DoctorCmd.Flags().StringVar(&doctorMatrix, "matrix", "", "Compatibility matrix file (default the one built in)")
+	for _, t := range doctorTools {
+		url := new(string)
+		doctorURLs[t.tool] = url
+		DoctorCmd.Flags().StringVar(url, string(t.tool)+"-url", "", fmt.Sprintf("%s URL (default from %s)", t.tool, t.portKey))
+	}
+	DoctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "Output the results as JSON")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	matrix, err := tools.DefaultCompatibilityMatrix()
+	if doctorMatrix != "" {
+		matrix, err = tools.LoadCompatibilityMatrix(doctorMatrix)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A missing apm.yaml leaves the default ports
+	configPath, _ := cmd.Flags().GetString("config")
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	_ = config.ReadInConfig()
+
+	factory := tools.NewHealthCheckerFactory()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results := make(map[tools.ToolType]*doctorResult, len(doctorTools))
+	incompatible := 0
+	for _, t := range doctorTools {
+		url := *doctorURLs[t.tool]
+		if url == "" {
+			port := config.GetInt(t.portKey)
+			if port == 0 {
+				port = t.port
+			}
+			url = fmt.Sprintf("http://localhost:%d", port)
+		}
+		result := &doctorResult{URL: url, Status: tools.ToolStatusUnknown}
+		results[t.tool] = result
+
+		checker, err := factory.CreateHealthChecker(&tools.Tool{Type: t.tool, Endpoint: url})
+		if err != nil {
+			return err
+		}
+		health, err := checker.Check(ctx)
+		if err != nil {
+			result.Error = err.Error()
+			result.Verdict = matrix.Check(t.tool, "")
+			continue
+		}
+		result.Status = health.Status
+		result.Error = health.Error
+		result.Verdict = matrix.Check(t.tool, health.Version)
+		if result.Verdict.Status == tools.CompatibilityIncompatible {
+			incompatible++
+		}
+	}
+
+	if doctorJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{"generated_at": matrix.GeneratedAt, "tools": results}); err != nil {
+			return err
+		}
+	} else {
+		printDoctor(results, matrix)
+	}
+	if incompatible > 0 {
+		return fmt.Errorf("%d tool versions are incompatible", incompatible)
+	}
+	return nil
+}
+
+// printDoctor prints one line per tool and its compatibility verdict
+func printDoctor(results map[tools.ToolType]*doctorResult, matrix *tools.CompatibilityMatrix) {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	fmt.Println(titleStyle.Render("APM Doctor"))
+	if matrix.GeneratedAt != nil {
+		fmt.Println(dimStyle.Render("Compatibility matrix from " + display.Date(*matrix.GeneratedAt)))
+	}
+	fmt.Println()
+
+	for _, t := range doctorTools {
+		r := results[t.tool]
+		version := r.Verdict.Version
+		if version == "" {
+			version = "unknown version"
+		}
+		fmt.Printf("%-12s %s\n", t.tool, dimStyle.Render(fmt.Sprintf("%s, %s", r.URL, version)))
+
+		if r.Status != tools.ToolStatusHealthy {
+			msg := string(r.Status)
+			if r.Error != "" {
+				msg += ": " + r.Error
+			}
+			fmt.Println(errorStyle.Render("  ✗ " + msg))
+		}
+
+		switch r.Verdict.Status {
+		case tools.CompatibilityCompatible:
+			fmt.Println(successStyle.Render("  ✓ " + r.Verdict.Message))
+		case tools.CompatibilityIncompatible:
+			fmt.Println(errorStyle.Render("  ✗ " + r.Verdict.Message))
+		default:
+			fmt.Println(warningStyle.Render("  ⚠ " + r.Verdict.Message))
+		}
+		if r.Verdict.Status == tools.CompatibilityPartial || r.Verdict.Status == tools.CompatibilityIncompatible {
+			for _, e := range matrix.Entries {
+				if e.Tool != t.tool || e.Version != r.Verdict.Tested {
+					continue
+				}
+				for _, c := range e.Contracts {
+					if !c.Passed {
+						fmt.Println(dimStyle.Render(fmt.Sprintf("    %s contract: %s", c.Name, c.Error)))
+					}
+				}
+			}
+		}
+	}
+}
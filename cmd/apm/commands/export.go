@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/anonymize"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/spf13/cobra"
+)
+
+var ExportCmd = &cobra.Command{
+	Use:         "export",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Export anonymized traces and logs to share with a vendor",
+	Long: `Export the traces and logs of a time window as OTLP JSON with identifiers
+replaced by consistent hashes and personal data stripped, packaged as a zip
+that can be attached to a support ticket.
+
+Trace, span, and other IDs map to the same hash wherever they appear, so the
+recipient can still follow a request across services and from its spans to
+its logs. Credentials (passwords, tokens, cookies, authorization headers) are
+dropped; attributes naming users, customers, accounts, or sessions are
+hashed; emails, IP addresses, phone, card, and social security numbers are
+replaced in span names and log lines. Use --hash, --keep, and --drop to
+adjust the rules per attribute.
+
+With --key-file the hashing key is kept, so later exports hash the same IDs
+the same way and the sender can map a hash back to the original by hashing
+candidates with the key. Without it every export uses a new random key.
+
+Examples:
+  apm export --since 2h --service checkout
+  apm export --trace-id 4bf92f3577b34da6a3ce929d0e0e4736 -o ticket-4211.zip
+  apm export --since 30m --hash order.id --keep user.tier --key-file ~/.apm/export.key`,
+	RunE: runExport,
+}
+
+var (
+	exportSince     time.Duration
+	exportServices  []string
+	exportTraceIDs  []string
+	exportLimit     int
+	exportLogLimit  int
+	exportJaegerURL string
+	exportLokiURL   string
+	exportSelector  string
+	exportTenant    string
+	exportOutput    string
+	exportKeyFile   string
+	exportHash      []string
+	exportKeep      []string
+	exportDrop      []string
+	exportNoLogs    bool
+)
+
+func init() {
+	ExportCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	ExportCmd.Flags().DurationVar(&exportSince, "since", time.Hour, "How far back to export")
+	ExportCmd.Flags().StringSliceVar(&exportServices, "service", nil, "Services to export traces of (default all)")
+	ExportCmd.Flags().StringSliceVar(&exportTraceIDs, "trace-id", nil, "Export these traces and the logs mentioning them")
+	ExportCmd.Flags().IntVar(&exportLimit, "limit", 20, "Maximum traces per service")
+	ExportCmd.Flags().IntVar(&exportLogLimit, "log-limit", 1000, "Maximum log lines")
+	ExportCmd.Flags().StringVar(&exportJaegerURL, "jaeger-url", "", "Jaeger query URL (default from apm.jaeger.ui_port)")
+	ExportCmd.Flags().StringVar(&exportLokiURL, "loki-url", "", "Loki URL (default from apm.loki.port)")
+	ExportCmd.Flags().StringVar(&exportSelector, "selector", "", `Loki stream selector (default {job=~".+"})`)
+	ExportCmd.Flags().StringVar(&exportTenant, "tenant", "", "Tenant ID sent to multi-tenant backends")
+	ExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Zip file to write (default apm-export-<time>.zip)")
+	ExportCmd.Flags().StringVar(&exportKeyFile, "key-file", "", "File holding the hashing key, created if missing (default a random key)")
+	ExportCmd.Flags().StringSliceVar(&exportHash, "hash", nil, "Attributes to hash")
+	ExportCmd.Flags().StringSliceVar(&exportKeep, "keep", nil, "Attributes to export as they are, with personal data in text stripped")
+	ExportCmd.Flags().StringSliceVar(&exportDrop, "drop", nil, "Attributes to leave out")
+	ExportCmd.Flags().BoolVar(&exportNoLogs, "no-logs", false, "Export traces only")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	stack := localStackFromConfig(cmd)
+	if exportJaegerURL == "" {
+		exportJaegerURL = stack.Jaeger
+	}
+	if exportLokiURL == "" {
+		exportLokiURL = stack.Loki
+	}
+	if exportNoLogs {
+		exportLokiURL = ""
+	}
+	if exportJaegerURL == "" && exportLokiURL == "" {
+		return fmt.Errorf("no backends configured; pass --jaeger-url or --loki-url")
+	}
+
+	var key []byte
+	if exportKeyFile != "" {
+		var err error
+		if key, err = anonymize.LoadKey(exportKeyFile); err != nil {
+			return err
+		}
+	}
+	anonymizer, err := anonymize.New(key)
+	if err != nil {
+		return err
+	}
+	for action, keys := range map[anonymize.Action][]string{
+		anonymize.Hash: exportHash,
+		anonymize.Keep: exportKeep,
+		anonymize.Drop: exportDrop,
+	} {
+		for _, k := range keys {
+			anonymizer.Set(k, action)
+		}
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if exportTenant != "" {
+		client = tenancy.NewClient(client, exportTenant)
+	}
+	exporter := &anonymize.Exporter{
+		JaegerURL:    exportJaegerURL,
+		LokiURL:      exportLokiURL,
+		LokiSelector: exportSelector,
+		Client:       client,
+		Anonymizer:   anonymizer,
+	}
+
+	now := time.Now()
+	query := anonymize.Query{
+		Start:    now.Add(-exportSince),
+		End:      now,
+		Services: exportServices,
+		TraceIDs: exportTraceIDs,
+		Limit:    exportLimit,
+		LogLimit: exportLogLimit,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	bundle, err := exporter.Export(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	output := exportOutput
+	if output == "" {
+		output = "apm-export-" + now.UTC().Format("20060102T150405Z") + ".zip"
+	}
+	if err := writeExport(bundle, output); err != nil {
+		return err
+	}
+
+	m := bundle.Manifest
+	fmt.Println(theme.Mark(severityOK, fmt.Sprintf("Exported %s trace(s), %s span(s), and %s log line(s) to %s",
+		display.Int(int64(m.Traces)), display.Int(int64(m.Spans)), display.Int(int64(m.LogRecords)), output), 0))
+	if len(m.Services) > 0 {
+		fmt.Println(theme.Dim.Render("Services: " + strings.Join(m.Services, ", ")))
+	}
+	for _, e := range m.Errors {
+		fmt.Println(theme.Mark(severityWarning, e, 0))
+	}
+	if exportKeyFile == "" {
+		fmt.Println(theme.Dim.Render("Hashed with a one-off key " + m.KeyFingerprint + "; pass --key-file to hash later exports the same way"))
+	} else {
+		fmt.Println(theme.Dim.Render("Hashed with key " + m.KeyFingerprint + " from " + exportKeyFile))
+	}
+	fmt.Println(theme.Dim.Render("Review the files before sharing: free text is scrubbed by pattern and may still hold personal data"))
+	return nil
+}
+
+// writeExport writes the bundle zip, removing the file if writing fails so
+// no partial export is left to be shared
+func writeExport(bundle *anonymize.Bundle, output string) error {
+	if dir := filepath.Dir(output); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := bundle.WriteZip(f); err != nil {
+		f.Close()
+		os.Remove(output)
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	return f.Close()
+}
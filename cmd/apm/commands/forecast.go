@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/forecast"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var ForecastCmd = &cobra.Command{
+	Use:         "forecast [resource...]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Forecast when CPU, memory, request rate, or storage will saturate",
+	Long: `Project resource usage from Prometheus history and report when it will reach
+capacity, with a confidence interval and a recommended scale action.
+
+Resources are cpu, memory, requests, and storage (default all). CPU and memory
+capacity come from Kubernetes limits and storage capacity from volume stats;
+request rate capacity must be given with --capacity.
+
+Usage is fitted with a linear trend or Holt-Winters (trend plus a daily
+season); --model auto keeps whichever fits the history better.
+
+Examples:
+  apm forecast
+  apm forecast cpu memory --namespace shop --history 28d --horizon 90d
+  apm forecast requests --capacity requests=1200 --json`,
+	RunE: runForecast,
+}
+
+var (
+	forecastPrometheusURL string
+	forecastNamespace     string
+	forecastHistory       string
+	forecastHorizon       string
+	forecastStep          time.Duration
+	forecastModel         string
+	forecastSeason        time.Duration
+	forecastConfidence    float64
+	forecastTarget        float64
+	forecastCapacity      []string
+	forecastTenant        string
+	forecastJSON          bool
+)
+
+func init() {
+	ForecastCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	ForecastCmd.Flags().StringVar(&forecastPrometheusURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	ForecastCmd.Flags().StringVar(&forecastNamespace, "namespace", "", "Kubernetes namespace to forecast (default all)")
+	ForecastCmd.Flags().StringVar(&forecastHistory, "history", "14d", "History to fit, e.g. 14d or 336h")
+	ForecastCmd.Flags().StringVar(&forecastHorizon, "horizon", "30d", "How far ahead to forecast")
+	ForecastCmd.Flags().DurationVar(&forecastStep, "step", time.Hour, "Sample interval")
+	ForecastCmd.Flags().StringVar(&forecastModel, "model", string(forecast.ModelAuto), "Model: auto, linear, or holt-winters")
+	ForecastCmd.Flags().DurationVar(&forecastSeason, "season", forecast.DefaultSeason, "Holt-Winters season length")
+	ForecastCmd.Flags().Float64Var(&forecastConfidence, "confidence", forecast.DefaultConfidence, "Confidence of the forecast interval")
+	ForecastCmd.Flags().Float64Var(&forecastTarget, "target-utilization", forecast.DefaultTargetUtilization, "Utilization recommendations plan for")
+	ForecastCmd.Flags().StringSliceVar(&forecastCapacity, "capacity", nil, "Capacity override as resource=value, e.g. requests=1200")
+	ForecastCmd.Flags().StringVar(&forecastTenant, "tenant", "", "Tenant ID sent to a multi-tenant Prometheus")
+	ForecastCmd.Flags().BoolVar(&forecastJSON, "json", false, "Output the forecasts as JSON")
+}
+
+func runForecast(cmd *cobra.Command, args []string) error {
+	history, err := retention.ParseDuration(forecastHistory)
+	if err != nil {
+		return fmt.Errorf("invalid --history: %w", err)
+	}
+	horizon, err := retention.ParseDuration(forecastHorizon)
+	if err != nil {
+		return fmt.Errorf("invalid --horizon: %w", err)
+	}
+
+	capacities := make(map[string]float64)
+	for _, c := range forecastCapacity {
+		name, value, ok := strings.Cut(c, "=")
+		v, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || v <= 0 {
+			return fmt.Errorf("invalid --capacity %q; expected resource=value", c)
+		}
+		capacities[name] = v
+	}
+
+	var resources []forecast.Resource
+	all := forecast.Resources(forecastNamespace)
+	if len(args) == 0 {
+		resources = all
+	}
+	for _, name := range args {
+		found := false
+		for _, r := range all {
+			if r.Name == name {
+				resources = append(resources, r)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown resource %q; expected cpu, memory, requests, or storage", name)
+		}
+	}
+
+	if forecastPrometheusURL == "" {
+		forecastPrometheusURL = prometheusURLFromConfig(cmd)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	if forecastTenant != "" {
+		client = tenancy.NewClient(client, forecastTenant)
+	}
+	planner := &forecast.Planner{
+		PrometheusURL: forecastPrometheusURL,
+		Client:        client,
+		History:       history,
+		Horizon:       horizon,
+		Step:          forecastStep,
+		Options: forecast.Options{
+			Model:      forecast.Model(forecastModel),
+			Season:     forecastSeason,
+			Confidence: forecastConfidence,
+		},
+		TargetUtilization: forecastTarget,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var plans []*forecast.Plan
+	var errs []string
+	for _, r := range resources {
+		plan, err := planner.Plan(ctx, r, capacities[r.Name])
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		plans = append(plans, plan)
+	}
+
+	if forecastJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{"plans": plans, "errors": errs}); err != nil {
+			return err
+		}
+	} else {
+		printForecasts(plans, errs, horizon)
+	}
+	if len(plans) == 0 {
+		return fmt.Errorf("no resource could be forecast")
+	}
+	return nil
+}
+
+// printForecasts prints one block per resource
+func printForecasts(plans []*forecast.Plan, errs []string, horizon time.Duration) {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	fmt.Println(titleStyle.Render(fmt.Sprintf("Capacity Forecast (next %s)", horizon)))
+	fmt.Println()
+
+	for _, p := range plans {
+		line := fmt.Sprintf("%-9s %s now", p.Resource, forecast.FormatValue(p.Current, p.Unit))
+		if p.Capacity > 0 {
+			line += fmt.Sprintf(" of %s (%.0f%%)", forecast.FormatValue(p.Capacity, p.Unit), p.Utilization*100)
+		}
+		fmt.Println(titleStyle.Render(line))
+
+		peak := p.Forecast.Peak()
+		fmt.Println(dimStyle.Render(fmt.Sprintf("          %s model, peak %s (%s to %s) on %s",
+			p.Forecast.Model, forecast.FormatValue(peak.Value, p.Unit),
+			forecast.FormatValue(peak.Lower, p.Unit), forecast.FormatValue(peak.Upper, p.Unit),
+			display.Date(peak.Time))))
+
+		style := successStyle
+		switch {
+		case p.Saturation.At != nil:
+			style = errorStyle
+		case p.Recommended > 0 || p.Capacity == 0:
+			style = warningStyle
+		}
+		fmt.Println(style.Render("          " + p.Recommendation))
+		fmt.Println()
+	}
+
+	for _, e := range errs {
+		fmt.Println(warningStyle.Render("⚠ " + e))
+	}
+}
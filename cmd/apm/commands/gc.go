@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/apmclient"
+	"github.com/chaksack/apm/pkg/janitor"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var GcCmd = &cobra.Command{
+	Use:         "gc",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Remove telemetry resources left behind by deleted services",
+	Long: `Find and remove resources whose service is gone:
+
+  dashboard     Grafana dashboards tagged apm and service:<name> whose service
+                has not reported to Prometheus within --lookback
+  alarm         CloudWatch alarms on terminated or deleted EC2 instances
+  port          ports allocated by the APM server that nothing listens on
+  ecr-token     expired ECR logins in the Docker config file
+  kube-context  kubeconfig contexts of deleted EKS clusters
+
+The orphans found are listed and removed after confirmation. A kind whose
+backend cannot be reached is reported and skipped.
+
+Examples:
+  apm gc --dry-run
+  apm gc --kind ecr-token --kind kube-context --yes
+  apm gc --kind dashboard --grafana-url http://grafana.internal:3000 --lookback 30d`,
+	RunE: runGc,
+}
+
+var (
+	gcDryRun        bool
+	gcYes           bool
+	gcKinds         []string
+	gcGrafanaURL    string
+	gcPrometheusURL string
+	gcLookback      string
+	gcRegion        string
+	gcAPIURL        string
+	gcDockerConfig  string
+	gcKubeconfig    string
+)
+
+func init() {
+	GcCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	GcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "List the orphans without removing them")
+	GcCmd.Flags().BoolVarP(&gcYes, "yes", "y", false, "Remove without asking for confirmation")
+	GcCmd.Flags().StringSliceVar(&gcKinds, "kind", nil, "Kinds of resources to collect (default all)")
+	GcCmd.Flags().StringVar(&gcGrafanaURL, "grafana-url", "", "Grafana URL (default from apm.grafana.port); the token is read from APM_GRAFANA_API_KEY")
+	GcCmd.Flags().StringVar(&gcPrometheusURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	GcCmd.Flags().StringVar(&gcLookback, "lookback", "7d", "How long a service must have been silent for its dashboards to be collected")
+	GcCmd.Flags().StringVar(&gcRegion, "region", os.Getenv("AWS_REGION"), "AWS region of the alarms and EKS clusters")
+	GcCmd.Flags().StringVar(&gcAPIURL, "api-url", "http://localhost:8080", "URL of the APM server allocating ports")
+	GcCmd.Flags().StringVar(&gcDockerConfig, "docker-config", "", "Docker config file (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	GcCmd.Flags().StringVar(&gcKubeconfig, "kubeconfig", "", "Kubeconfig file (default kubectl's)")
+}
+
+func runGc(cmd *cobra.Command, args []string) error {
+	lookback, err := retention.ParseDuration(gcLookback)
+	if err != nil {
+		return fmt.Errorf("invalid --lookback: %w", err)
+	}
+
+	if gcGrafanaURL == "" {
+		gcGrafanaURL = localURL(readConfigFlag(cmd), "apm.grafana.port", 3000)
+	}
+	if gcPrometheusURL == "" {
+		gcPrometheusURL = prometheusURLFromConfig(cmd)
+	}
+
+	sweepers := map[janitor.Kind]janitor.Sweeper{
+		janitor.KindDashboard: &janitor.GrafanaDashboards{
+			GrafanaURL:    gcGrafanaURL,
+			Token:         os.Getenv("APM_GRAFANA_API_KEY"),
+			PrometheusURL: gcPrometheusURL,
+			Lookback:      lookback,
+		},
+		janitor.KindAlarm:       &janitor.CloudWatchAlarms{Region: gcRegion},
+		janitor.KindPort:        &janitor.PortAllocations{Client: apmclient.NewClient(gcAPIURL)},
+		janitor.KindECRToken:    &janitor.ECRTokens{ConfigPath: gcDockerConfig},
+		janitor.KindKubeContext: &janitor.KubeContexts{Kubeconfig: gcKubeconfig},
+	}
+	kinds := janitor.Kinds
+	if len(gcKinds) > 0 {
+		kinds = nil
+		for _, k := range gcKinds {
+			if _, ok := sweepers[janitor.Kind(k)]; !ok {
+				return fmt.Errorf("unknown kind %q (valid: %s)", k, joinKinds(janitor.Kinds))
+			}
+			kinds = append(kinds, janitor.Kind(k))
+		}
+	}
+	j := &janitor.Janitor{}
+	for _, k := range kinds {
+		j.Sweepers = append(j.Sweepers, sweepers[k])
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	report := j.Find(ctx)
+	cancel()
+
+	for _, k := range kinds {
+		if err := report.Errors[k]; err != nil {
+			fmt.Println(warningStyle.Render(fmt.Sprintf("⚠ Skipped %s: %v", k, err)))
+		}
+	}
+	if len(report.Orphans) == 0 {
+		fmt.Println(successStyle.Render("✓ No orphaned resources found"))
+		return nil
+	}
+
+	fmt.Println(titleStyle.Render(fmt.Sprintf("%d orphaned resource(s):", len(report.Orphans))))
+	for _, o := range report.Orphans {
+		fmt.Printf("  - %s: %s\n", o, o.Reason)
+	}
+	if gcDryRun {
+		return nil
+	}
+
+	if !gcYes {
+		fmt.Printf("\nRemove these resources? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Println("Garbage collection aborted")
+			return nil
+		}
+	}
+
+	ctx, tracker, cancel := startProgress(cmd, "garbage collection", 10*time.Minute)
+	defer cancel()
+
+	removed, err := j.Remove(ctx, report.Orphans)
+	err = tracker.Finish(err)
+	for _, o := range removed {
+		fmt.Println(successStyle.Render("✓ removed " + o.String()))
+	}
+	return err
+}
+
+func joinKinds(kinds []janitor.Kind) string {
+	s := make([]string, len(kinds))
+	for i, k := range kinds {
+		s[i] = string(k)
+	}
+	return strings.Join(s, ", ")
+}
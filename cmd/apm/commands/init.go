@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/apmconfig"
 	"github.com/chaksack/apm/pkg/security"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -14,8 +16,9 @@ import (
 )
 
 var InitCmd = &cobra.Command{
-	Use:   "init",
-	Short: "Initialize APM configuration with interactive setup",
+	Use:         "init",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Initialize APM configuration with interactive setup",
 	Long: `Initialize APM configuration through an interactive wizard that guides you through:
 - Selecting APM tools to integrate (Prometheus, Grafana, Jaeger, Loki, etc.)
 - Configuring essential parameters for each tool
@@ -451,7 +454,7 @@ func saveConfiguration(config map[string]interface{}) error {
 
 	// Create default configuration structure
 	fullConfig := map[string]interface{}{
-		"version": "1.0",
+		"version": apmconfig.CurrentVersion,
 		"project": map[string]interface{}{
 			"name":        config["project_name"],
 			"environment": "development",
@@ -496,9 +499,8 @@ func saveConfiguration(config map[string]interface{}) error {
 				"ui_port":    16686,
 			},
 			"loki": map[string]interface{}{
-				"enabled":   m.selections["loki"],
-				"port":      3100,
-				"retention": "7d",
+				"enabled": m.selections["loki"],
+				"port":    3100,
 			},
 			"alertmanager": map[string]interface{}{
 				"enabled": m.selections["prometheus"] && m.slackEnabled,
@@ -523,6 +525,11 @@ func saveConfiguration(config map[string]interface{}) error {
 				},
 			},
 		},
+		"retention": map[string]interface{}{
+			"metrics": map[string]interface{}{"period": "15d"},
+			"logs":    map[string]interface{}{"period": "7d"},
+			"traces":  map[string]interface{}{"period": "72h", "backend": "badger"},
+		},
 		"notifications": map[string]interface{}{
 			"slack": map[string]interface{}{
 				"enabled":     m.slackEnabled,
@@ -540,6 +547,12 @@ func saveConfiguration(config map[string]interface{}) error {
 				"exclude":    []string{"vendor", "node_modules", ".git"},
 				"extensions": []string{".go", ".mod"},
 			},
+			"supervisor": map[string]interface{}{
+				"restart":         "on-failure",
+				"max_restarts":    10,
+				"initial_backoff": "1s",
+				"max_backoff":     "30s",
+			},
 		},
 	}
 
@@ -552,7 +565,17 @@ func saveConfiguration(config map[string]interface{}) error {
 	}
 
 	configPath := filepath.Join(".", "apm.yaml")
-	return v.WriteConfigAs(configPath)
+	before, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := v.WriteConfigAs(configPath); err != nil {
+		return err
+	}
+	if after, err := os.ReadFile(configPath); err == nil {
+		recordConfigChange(configPath, "init", "", before, after)
+	}
+	return nil
 }
 
 // generateDefaultPassword generates a secure default password
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// prometheusURLFromConfig returns the URL of the Prometheus of the local
+// stack described by the apm.yaml given by --config, on port 9090 unless
+// apm.prometheus.port says otherwise
+func prometheusURLFromConfig(cmd *cobra.Command) string {
+	return localURL(readConfigFlag(cmd), "apm.prometheus.port", 9090)
+}
+
+// localURL returns the localhost URL of a component of the local stack
+func localURL(config *viper.Viper, key string, defaultPort int) string {
+	port := config.GetInt(key)
+	if port == 0 {
+		port = defaultPort
+	}
+	return fmt.Sprintf("http://localhost:%d", port)
+}
+
+// readConfigFlag reads the apm.yaml given by --config; a missing or
+// unreadable file reads as empty
+func readConfigFlag(cmd *cobra.Command) *viper.Viper {
+	configPath, _ := cmd.Flags().GetString("config")
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	_ = config.ReadInConfig()
+	return config
+}
+
+// localStack holds the URLs of the tools of the local stack described by
+// apm.yaml; a tool that is not enabled has no URL
+type localStack struct {
+	Prometheus string
+	Jaeger     string
+	Loki       string
+}
+
+// localStackFromConfig returns the local stack described by the apm.yaml
+// given by --config
+func localStackFromConfig(cmd *cobra.Command) localStack {
+	config := readConfigFlag(cmd)
+	var stack localStack
+	if config.GetBool("apm.prometheus.enabled") {
+		stack.Prometheus = fmt.Sprintf("http://localhost:%d", config.GetInt("apm.prometheus.port"))
+	}
+	if config.GetBool("apm.jaeger.enabled") {
+		stack.Jaeger = fmt.Sprintf("http://localhost:%d", config.GetInt("apm.jaeger.ui_port"))
+	}
+	if config.GetBool("apm.loki.enabled") {
+		stack.Loki = fmt.Sprintf("http://localhost:%d", config.GetInt("apm.loki.port"))
+	}
+	return stack
+}
@@ -11,15 +11,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/chaksack/apm/pkg/access"
 	"github.com/chaksack/apm/pkg/security"
-	"github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var LogsCmd = &cobra.Command{
-	Use:   "logs [component]",
-	Short: "View application and APM component logs",
+	Use:         "logs [component]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "View application and APM component logs",
 	Long: `View logs from your application or APM components (prometheus, grafana, jaeger, loki).
 If no component is specified, application logs are shown.`,
 	Args:      cobra.MaximumNArgs(1),
@@ -335,20 +336,6 @@ func tailFile(path string) (io.ReadCloser, error) {
 func streamLogs(source io.ReadCloser, component string) error {
 	defer source.Close()
 
-	// Style definitions
-	timestampStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
-	componentStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("86")).
-		Bold(true)
-
-	levelStyles := map[string]lipgloss.Style{
-		"debug": lipgloss.NewStyle().Foreground(lipgloss.Color("241")),
-		"info":  lipgloss.NewStyle().Foreground(lipgloss.Color("86")),
-		"warn":  lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
-		"error": lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true),
-		"fatal": lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true).Underline(true),
-	}
-
 	scanner := bufio.NewScanner(source)
 	// Set max buffer size to prevent memory exhaustion (1MB per line)
 	const maxScanTokenSize = 1024 * 1024
@@ -369,7 +356,7 @@ func streamLogs(source io.ReadCloser, component string) error {
 		entry := parseLogLine(line, component)
 
 		// Format output
-		output := formatLogEntry(entry, timestampStyle, componentStyle, levelStyles)
+		output := formatLogEntry(entry)
 		fmt.Println(output)
 
 		lineCount++
@@ -472,21 +459,18 @@ func detectLogLevel(line string) string {
 	}
 }
 
-func formatLogEntry(entry logEntry, timestampStyle, componentStyle lipgloss.Style, levelStyles map[string]lipgloss.Style) string {
+func formatLogEntry(entry logEntry) string {
 	// Format timestamp
-	ts := timestampStyle.Render(entry.Timestamp.Format("15:04:05.000"))
+	ts := theme.Dim.Render(display.ClockMillis(entry.Timestamp))
 
 	// Format component
-	comp := componentStyle.Render(fmt.Sprintf("[%s]", entry.Component))
+	comp := theme.Title.Render(fmt.Sprintf("[%s]", entry.Component))
 
-	// Format level
+	// Format level with a marker, so warnings and errors stand out without
+	// color
 	level := ""
 	if entry.Level != "" {
-		style, ok := levelStyles[entry.Level]
-		if !ok {
-			style = levelStyles["info"]
-		}
-		level = style.Render(fmt.Sprintf("[%s]", strings.ToUpper(entry.Level))) + " "
+		level = theme.Mark(levelSeverity(entry.Level), strings.ToUpper(entry.Level), 7) + " "
 	}
 
 	// Build output
@@ -498,7 +482,7 @@ func formatLogEntry(entry logEntry, timestampStyle, componentStyle lipgloss.Styl
 		for k, v := range entry.Fields {
 			fields = append(fields, fmt.Sprintf("%s=%v", k, v))
 		}
-		output += " " + lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Render(strings.Join(fields, " "))
+		output += " " + theme.Dim.Render(strings.Join(fields, " "))
 	}
 
 	return output
@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/spf13/cobra"
+)
+
+var LookupCmd = &cobra.Command{
+	Use:         "lookup <attribute>=<value>",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Find traces and logs for an order, user, or other business ID",
+	Long: `Search traces and logs for a business identifier recorded as a span
+attribute and print a consolidated timeline.
+
+Traces are searched in Jaeger (or Tempo) by span attribute. Logs are searched
+in Loki for the identifier and for the IDs of the traces found.
+
+Examples:
+  apm lookup order.id=A-1042
+  apm lookup user.id=42 --since 6h --service checkout
+  apm lookup order.id=A-1042 --tempo-url http://localhost:3200 --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLookup,
+}
+
+var (
+	lookupSince     time.Duration
+	lookupServices  []string
+	lookupLimit     int
+	lookupJaegerURL string
+	lookupTempoURL  string
+	lookupLokiURL   string
+	lookupSelector  string
+	lookupTenant    string
+	lookupJSON      bool
+)
+
+func init() {
+	LookupCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	LookupCmd.Flags().DurationVar(&lookupSince, "since", 24*time.Hour, "How far back to search")
+	LookupCmd.Flags().StringSliceVar(&lookupServices, "service", nil, "Services to search in Jaeger (default all)")
+	LookupCmd.Flags().IntVar(&lookupLimit, "limit", 20, "Maximum traces per backend")
+	LookupCmd.Flags().StringVar(&lookupJaegerURL, "jaeger-url", "", "Jaeger query URL (default from apm.jaeger.ui_port)")
+	LookupCmd.Flags().StringVar(&lookupTempoURL, "tempo-url", "", "Tempo URL")
+	LookupCmd.Flags().StringVar(&lookupLokiURL, "loki-url", "", "Loki URL (default from apm.loki.port)")
+	LookupCmd.Flags().StringVar(&lookupSelector, "selector", "", `Loki stream selector (default {job=~".+"})`)
+	LookupCmd.Flags().StringVar(&lookupTenant, "tenant", "", "Tenant ID sent to multi-tenant backends")
+	LookupCmd.Flags().BoolVar(&lookupJSON, "json", false, "Output the timeline as JSON")
+}
+
+func runLookup(cmd *cobra.Command, args []string) error {
+	attribute, value, ok := strings.Cut(args[0], "=")
+	if !ok {
+		return fmt.Errorf("expected <attribute>=<value>, got %q", args[0])
+	}
+
+	stack := localStackFromConfig(cmd)
+	if lookupJaegerURL == "" && lookupTempoURL == "" {
+		lookupJaegerURL = stack.Jaeger
+	}
+	if lookupLokiURL == "" {
+		lookupLokiURL = stack.Loki
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if lookupTenant != "" {
+		client = tenancy.NewClient(client, lookupTenant)
+	}
+
+	service := &lookup.Service{}
+	if lookupJaegerURL != "" {
+		service.Traces = append(service.Traces, &lookup.Jaeger{URL: lookupJaegerURL, Client: client})
+	}
+	if lookupTempoURL != "" {
+		service.Traces = append(service.Traces, &lookup.Tempo{URL: lookupTempoURL, Client: client})
+	}
+	if lookupLokiURL != "" {
+		service.Logs = append(service.Logs, &lookup.Loki{URL: lookupLokiURL, Selector: lookupSelector, Client: client})
+	}
+	if len(service.Traces) == 0 && len(service.Logs) == 0 {
+		return fmt.Errorf("no backends configured; pass --jaeger-url, --tempo-url, or --loki-url")
+	}
+
+	now := time.Now()
+	query := lookup.Query{
+		Attribute: attribute,
+		Value:     value,
+		Start:     now.Add(-lookupSince),
+		End:       now,
+		Services:  lookupServices,
+		Limit:     lookupLimit,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := service.Lookup(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if lookupJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	printLookupTimeline(result)
+	return nil
+}
+
+// printLookupTimeline prints the timeline with one line per event
+func printLookupTimeline(result *lookup.Result) {
+	fmt.Println(theme.Title.Render(fmt.Sprintf("%s=%s: %d trace(s), %d event(s)",
+		result.Query.Attribute, result.Query.Value, len(result.TraceIDs), len(result.Events))))
+	for _, e := range result.Errors {
+		fmt.Println(theme.Mark(severityWarning, e, 0))
+	}
+	fmt.Println()
+
+	for _, e := range result.Events {
+		line := fmt.Sprintf("%s  %-5s  %-20s  %s", display.DateTimeMillis(e.Time), e.Source, e.Service, e.Summary)
+		if e.Duration > 0 {
+			line += fmt.Sprintf(" (%s)", e.Duration)
+		}
+		// Failed spans and error logs carry the error marker, the rest the
+		// uncolored info marker so the column lines up
+		if e.Error {
+			line = theme.Mark(severityError, line, 0)
+		} else {
+			line = theme.Marker(severityInfo) + " " + line
+		}
+		fmt.Println(line)
+		if e.TraceID != "" {
+			fmt.Println(theme.Dim.Render("    trace " + e.TraceID))
+		}
+	}
+}
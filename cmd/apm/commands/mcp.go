@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/chaksack/apm/pkg/mcp"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/spf13/cobra"
+)
+
+var McpCmd = &cobra.Command{
+	Use:         "mcp",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Serve observability queries to AI assistants over MCP",
+	Long: `Run a Model Context Protocol server on stdin/stdout so AI assistants can
+query metrics, traces, logs, and stack status.
+
+Tools are read-only: they only issue queries, time ranges and result sizes
+are capped, and every call is appended to the audit log.
+
+Register the server with an MCP client, for example:
+  {"mcpServers": {"apm": {"command": "apm", "args": ["mcp"]}}}
+
+Examples:
+  apm mcp
+  apm mcp --prometheus-url http://prometheus:9090 --max-range 6h
+  apm mcp --audit-log /var/log/apm/mcp-audit.log --tenant team-a`,
+	RunE: runMcp,
+}
+
+var (
+	mcpPrometheusURL string
+	mcpJaegerURL     string
+	mcpLokiURL       string
+	mcpAuditLog      string
+	mcpMaxRange      time.Duration
+	mcpMaxResults    int
+	mcpTenant        string
+)
+
+func init() {
+	McpCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	McpCmd.Flags().StringVar(&mcpPrometheusURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	McpCmd.Flags().StringVar(&mcpJaegerURL, "jaeger-url", "", "Jaeger query URL (default from apm.jaeger.ui_port)")
+	McpCmd.Flags().StringVar(&mcpLokiURL, "loki-url", "", "Loki URL (default from apm.loki.port)")
+	McpCmd.Flags().StringVar(&mcpAuditLog, "audit-log", "mcp-audit.log", "File the tool calls are audited to")
+	McpCmd.Flags().DurationVar(&mcpMaxRange, "max-range", mcp.DefaultMaxRange, "Longest time range a tool may query")
+	McpCmd.Flags().IntVar(&mcpMaxResults, "max-results", mcp.DefaultMaxResults, "Most series, traces, or log lines a tool may return")
+	McpCmd.Flags().StringVar(&mcpTenant, "tenant", "", "Tenant ID sent to multi-tenant backends")
+}
+
+func runMcp(cmd *cobra.Command, args []string) error {
+	stack := localStackFromConfig(cmd)
+	if mcpPrometheusURL == "" {
+		mcpPrometheusURL = stack.Prometheus
+	}
+	if mcpJaegerURL == "" {
+		mcpJaegerURL = stack.Jaeger
+	}
+	if mcpLokiURL == "" {
+		mcpLokiURL = stack.Loki
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if mcpTenant != "" {
+		client = tenancy.NewClient(client, mcpTenant)
+	}
+
+	health := &chatops.HealthChecker{Components: map[string]string{}, Client: client}
+	if mcpPrometheusURL != "" {
+		health.Components["prometheus"] = mcpPrometheusURL + "/-/healthy"
+	}
+	if mcpJaegerURL != "" {
+		health.Components["jaeger"] = mcpJaegerURL + "/"
+	}
+	if mcpLokiURL != "" {
+		health.Components["loki"] = mcpLokiURL + "/ready"
+	}
+	if len(health.Components) == 0 {
+		return fmt.Errorf("no backends configured; pass --prometheus-url, --jaeger-url, or --loki-url")
+	}
+
+	backends := &mcp.Backends{
+		PrometheusURL: mcpPrometheusURL,
+		JaegerURL:     mcpJaegerURL,
+		LokiURL:       mcpLokiURL,
+		Status:        health.Check,
+		Client:        client,
+		MaxRange:      mcpMaxRange,
+		MaxResults:    mcpMaxResults,
+	}
+	server := &mcp.Server{
+		Name:    "apm",
+		Version: cmd.Root().Version,
+		Tools:   backends.Tools(),
+		Actor:   os.Getenv("USER"),
+		Auditor: mcp.NewFileAuditor(mcpAuditLog),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Stdout carries the protocol, so diagnostics go to stderr
+	fmt.Fprintf(os.Stderr, "apm mcp: serving %d tools on stdio, auditing to %s\n", len(server.Tools), mcpAuditLog)
+	return server.Serve(ctx, os.Stdin, os.Stdout)
+}
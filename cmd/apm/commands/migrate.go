@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/migrate"
+	"github.com/spf13/cobra"
+)
+
+var MigrateCmd = &cobra.Command{
+	Use:         "migrate",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Migrate hand-rolled telemetry wiring to pkg/instrumentation",
+	Long: `Migrate applications that wire otelfiber, Prometheus, and zap by hand to
+pkg/instrumentation.`,
+}
+
+var migrateScanCmd = &cobra.Command{
+	Use:         "scan [path]",
+	Annotations: needs(access.ScopeDeploy, "write"),
+	Short:       "Plan the migration of a source tree and rewrite what can be converted",
+	Long: `Find hand-rolled otelfiber, Prometheus, and zap wiring in the Go packages
+under path (default: the current directory) and print a migration plan.
+
+The codemod sets up instrumentation.New in main with options derived from the
+existing tracer provider, replaces otelfiber and the hand-rolled metrics and
+request logging middleware with inst's, serves the metrics endpoint from inst,
+and removes the HTTP metrics left unused. Steps it cannot take safely are
+listed as manual, and wiring pkg/instrumentation has no equivalent for is
+reported as a gap.
+
+Examples:
+  apm migrate scan
+  apm migrate scan ./sample-app --diff
+  apm migrate scan ./sample-app --write
+  apm migrate scan --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runMigrateScan,
+}
+
+var (
+	migrateJSON  bool
+	migrateDiff  bool
+	migrateWrite bool
+)
+
+func init() {
+	migrateScanCmd.Flags().BoolVar(&migrateJSON, "json", false, "Output the plan as JSON")
+	migrateScanCmd.Flags().BoolVar(&migrateDiff, "diff", false, "Print the codemod's changes as a unified diff")
+	migrateScanCmd.Flags().BoolVar(&migrateWrite, "write", false, "Write the codemod's changes in place")
+
+	MigrateCmd.AddCommand(migrateScanCmd)
+}
+
+func runMigrateScan(cmd *cobra.Command, args []string) error {
+	root := "."
+	if len(args) > 0 {
+		root = args[0]
+	}
+	report, err := migrate.Scan(root)
+	if err != nil {
+		return err
+	}
+
+	if migrateJSON {
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		report.WritePlan(os.Stdout)
+	}
+	if migrateDiff {
+		diff, err := report.Diff()
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+	}
+	if migrateWrite {
+		if err := report.Apply(); err != nil {
+			return err
+		}
+		if !migrateJSON {
+			fmt.Printf("Rewrote %d files. Review the changes, then follow the manual steps above.\n", len(report.Changes))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/chaksack/apm/internal/routes"
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/openapi"
+	"github.com/spf13/cobra"
+)
+
+var OpenAPICmd = &cobra.Command{
+	Use:         "openapi",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Generate the OpenAPI document and Go client of the APM server API",
+	Long: `Generate the OpenAPI 3 document of the APM server's REST API, which the
+server also serves at /openapi.json, and a typed Go client for it.`,
+}
+
+var openapiSpecCmd = &cobra.Command{
+	Use:   "spec",
+	Short: "Write the OpenAPI document",
+	Long: `Write the OpenAPI document of the APM server API as JSON.
+
+Examples:
+  apm openapi spec
+  apm openapi spec --output openapi.json`,
+	RunE: runOpenAPISpec,
+}
+
+var openapiClientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "Generate a typed Go client for the APM server API",
+	Long: `Generate a Go package with a typed client for every operation of the APM
+server API. The client depends only on the standard library.
+
+Examples:
+  apm openapi client --output pkg/apmclient/client.go --package apmclient`,
+	RunE: runOpenAPIClient,
+}
+
+var (
+	openapiOutput  string
+	openapiPackage string
+)
+
+func init() {
+	OpenAPICmd.PersistentFlags().StringVarP(&openapiOutput, "output", "o", "", "Output file (default stdout)")
+	openapiClientCmd.Flags().StringVar(&openapiPackage, "package", "apmclient", "Package name of the generated client")
+
+	OpenAPICmd.AddCommand(openapiSpecCmd)
+	OpenAPICmd.AddCommand(openapiClientCmd)
+}
+
+func runOpenAPISpec(cmd *cobra.Command, args []string) error {
+	data, err := json.MarshalIndent(routes.OpenAPI(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode OpenAPI document: %w", err)
+	}
+	return writeOpenAPIOutput(append(data, '\n'))
+}
+
+func runOpenAPIClient(cmd *cobra.Command, args []string) error {
+	src, err := openapi.GenerateClient(routes.OpenAPI(), openapiPackage)
+	if err != nil {
+		return err
+	}
+	return writeOpenAPIOutput(src)
+}
+
+func writeOpenAPIOutput(data []byte) error {
+	if openapiOutput == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(openapiOutput, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", openapiOutput, err)
+	}
+	fmt.Printf("Wrote %s\n", openapiOutput)
+	return nil
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/chaksack/apm/pkg/progress"
+	"github.com/spf13/cobra"
+)
+
+// startProgress tracks a long-running operation on stderr: JSON progress
+// events with --json, a spinner with step and ETA otherwise. The returned
+// context carries the tracker and is canceled by Ctrl-C or the timeout, after
+// which Finish cleans up partially created resources.
+func startProgress(cmd *cobra.Command, operation string, timeout time.Duration) (context.Context, *progress.Tracker, context.CancelFunc) {
+	var sink progress.Sink = progress.NewTerminalSink(os.Stderr)
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		sink = progress.NewJSONSink(os.Stderr)
+	}
+	tracker := progress.New(operation, 0, sink)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return progress.NewContext(ctx, tracker), tracker, func() {
+		cancel()
+		stop()
+	}
+}
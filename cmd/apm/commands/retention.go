@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/chaksack/apm/pkg/webhook"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var RetentionCmd = &cobra.Command{
+	Use:         "retention",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Manage retention across metrics, logs, traces, and cloud logs",
+	Long: `Manage the unified retention policy declared in the retention section of apm.yaml.
+
+The policy is translated into Prometheus retention flags, Loki compactor settings,
+Jaeger or Tempo retention, and CloudWatch log group retention.`,
+}
+
+var retentionPlanCmd = &cobra.Command{
+	Use:         "plan",
+	Annotations: needs(access.ScopeDeploy, "write"),
+	Short:       "Show the backend settings derived from the retention policy",
+	Long: `Show the backend settings derived from the retention policy.
+
+Examples:
+  apm retention plan
+  apm retention plan --loki-config configs/loki/loki.yaml --write`,
+	RunE: runRetentionPlan,
+}
+
+var retentionCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Detect retention drift in running backends",
+	Long: `Compare live backend retention with the policy and report out-of-band changes.
+
+Examples:
+  apm retention check
+  apm retention check --prometheus-url http://prometheus:9090 --json`,
+	RunE: runRetentionCheck,
+}
+
+var (
+	retentionLokiConfig    string
+	retentionTempoConfig   string
+	retentionWrite         bool
+	retentionPrometheusURL string
+	retentionLokiURL       string
+	retentionTempoURL      string
+	retentionJSON          bool
+)
+
+func init() {
+	RetentionCmd.PersistentFlags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+
+	retentionPlanCmd.Flags().StringVar(&retentionLokiConfig, "loki-config", "", "Loki configuration file to update")
+	retentionPlanCmd.Flags().StringVar(&retentionTempoConfig, "tempo-config", "", "Tempo configuration file to update")
+	retentionPlanCmd.Flags().BoolVar(&retentionWrite, "write", false, "Write updated Loki/Tempo configuration files in place")
+
+	retentionCheckCmd.Flags().StringVar(&retentionPrometheusURL, "prometheus-url", "", "Prometheus base URL (default from apm.prometheus.port)")
+	retentionCheckCmd.Flags().StringVar(&retentionLokiURL, "loki-url", "", "Loki base URL (default from apm.loki.port)")
+	retentionCheckCmd.Flags().StringVar(&retentionTempoURL, "tempo-url", "", "Tempo base URL")
+	retentionCheckCmd.Flags().BoolVar(&retentionJSON, "json", false, "Output drift as JSON")
+
+	RetentionCmd.AddCommand(retentionPlanCmd)
+	RetentionCmd.AddCommand(retentionCheckCmd)
+}
+
+// loadRetentionPolicy reads and validates the retention section of apm.yaml
+func loadRetentionPolicy(cmd *cobra.Command) (retention.Policy, *viper.Viper, error) {
+	var policy retention.Policy
+	configPath, _ := cmd.Flags().GetString("config")
+
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	if err := config.ReadInConfig(); err != nil {
+		return policy, nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if !config.IsSet("retention") {
+		return policy, nil, fmt.Errorf("no retention section in %s", configPath)
+	}
+	if err := config.UnmarshalKey("retention", &policy); err != nil {
+		return policy, nil, fmt.Errorf("invalid retention configuration: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return policy, nil, err
+	}
+	return policy, config, nil
+}
+
+func runRetentionPlan(cmd *cobra.Command, args []string) error {
+	policy, _, err := loadRetentionPolicy(cmd)
+	if err != nil {
+		return err
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	fmt.Println(titleStyle.Render("Retention Plan"))
+
+	flags, err := policy.PrometheusFlags()
+	if err != nil {
+		return err
+	}
+	if len(flags) > 0 {
+		fmt.Println("\nPrometheus flags:")
+		for _, f := range flags {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+
+	if policy.Traces.Period != "" && policy.Traces.Backend != retention.TraceBackendTempo {
+		jaeger, err := policy.JaegerSettings()
+		if err != nil {
+			return err
+		}
+		fmt.Println("\nJaeger storage:")
+		for k, v := range jaeger.Env {
+			fmt.Printf("  %s=%s\n", k, v)
+		}
+		if jaeger.IndexCleanerDays > 0 {
+			fmt.Printf("  jaeger-es-index-cleaner %d <es-url>\n", jaeger.IndexCleanerDays)
+		}
+	}
+
+	commands, err := policy.CloudWatchCommands()
+	if err != nil {
+		return err
+	}
+	if len(commands) > 0 {
+		fmt.Println("\nCloudWatch:")
+		for _, c := range commands {
+			fmt.Printf("  aws %s\n", strings.Join(c, " "))
+		}
+	}
+
+	if retentionLokiConfig != "" && policy.Logs.Period != "" {
+		if err := planConfigFile("Loki", retentionLokiConfig, policy.ApplyLokiConfig); err != nil {
+			return err
+		}
+	}
+	if retentionTempoConfig != "" && policy.Traces.Period != "" {
+		if err := planConfigFile("Tempo", retentionTempoConfig, policy.ApplyTempoConfig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planConfigFile applies the policy to a backend configuration file, printing
+// the result or writing it in place with --write
+func planConfigFile(name, path string, apply func([]byte) ([]byte, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s config: %w", name, err)
+	}
+
+	updated, err := apply(data)
+	if err != nil {
+		return fmt.Errorf("failed to update %s config: %w", name, err)
+	}
+
+	if retentionWrite {
+		if err := os.WriteFile(path, updated, 0644); err != nil {
+			return fmt.Errorf("failed to write %s config: %w", name, err)
+		}
+		fmt.Printf("\n%s configuration updated: %s\n", name, path)
+		return nil
+	}
+
+	fmt.Printf("\n%s configuration (%s):\n%s", name, path, updated)
+	return nil
+}
+
+func runRetentionCheck(cmd *cobra.Command, args []string) error {
+	policy, config, err := loadRetentionPolicy(cmd)
+	if err != nil {
+		return err
+	}
+
+	detector := &retention.Detector{
+		Policy:        policy,
+		PrometheusURL: retentionPrometheusURL,
+		LokiURL:       retentionLokiURL,
+		TempoURL:      retentionTempoURL,
+	}
+	if detector.PrometheusURL == "" && config.GetBool("apm.prometheus.enabled") {
+		detector.PrometheusURL = fmt.Sprintf("http://localhost:%d", config.GetInt("apm.prometheus.port"))
+	}
+	if detector.LokiURL == "" && config.GetBool("apm.loki.enabled") {
+		detector.LokiURL = fmt.Sprintf("http://localhost:%d", config.GetInt("apm.loki.port"))
+	}
+
+	ctx, tracker, cancel := startProgress(cmd, "retention check", 30*time.Second)
+	defer cancel()
+
+	drifts, detectErr := detector.Detect(ctx)
+	if ctx.Err() != nil {
+		return tracker.Finish(ctx.Err())
+	}
+	tracker.Finish(nil)
+
+	if retentionJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(drifts); err != nil {
+			return err
+		}
+	} else {
+		printRetentionDrift(drifts, detectErr)
+	}
+
+	if len(drifts) > 0 {
+		emitWebhook(loadWebhooks(config), webhook.Event{
+			Type:    webhook.EventDriftDetected,
+			Source:  "apm retention check",
+			Subject: "retention",
+			Status:  "drifted",
+			Data:    map[string]interface{}{"drifts": drifts},
+		})
+		return fmt.Errorf("retention drift detected in %d setting(s)", len(drifts))
+	}
+	return detectErr
+}
+
+// printRetentionDrift prints drift results and backends that could not be checked
+func printRetentionDrift(drifts []retention.Drift, detectErr error) {
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+	if detectErr != nil {
+		for _, line := range strings.Split(detectErr.Error(), "\n") {
+			fmt.Println(warningStyle.Render("⚠ " + line))
+		}
+	}
+
+	if len(drifts) == 0 {
+		fmt.Println(successStyle.Render("✓ Backend retention matches the policy"))
+		return
+	}
+	for _, d := range drifts {
+		fmt.Println(errorStyle.Render("✗ " + d.String()))
+	}
+}
@@ -2,25 +2,34 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/runenv"
+	"github.com/chaksack/apm/pkg/supervisor"
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var RunCmd = &cobra.Command{
-	Use:   "run [command]",
-	Short: "Run application with APM instrumentation and hot reload",
+	Use:         "run [command]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Run application with APM instrumentation and hot reload",
 	Long: `Run your application with automatic APM agent injection and hot reload capabilities.
 If no command is specified, it will use the command from apm.yaml configuration.`,
 	Args: cobra.MaximumNArgs(1),
@@ -29,9 +38,8 @@ If no command is specified, it will use the command from apm.yaml configuration.
 
 type runner struct {
 	config      *viper.Viper
-	cmd         *exec.Cmd
+	env         *runenv.Environment
 	watcher     *fsnotify.Watcher
-	mu          sync.Mutex
 	restartChan chan bool
 	ctx         context.Context
 	cancel      context.CancelFunc
@@ -57,6 +65,16 @@ func runApp(cmd *cobra.Command, args []string) error {
 		cancel:      cancel,
 	}
 
+	// Build and validate the application's environment before starting it
+	env, err := r.loadEnvironment(cmd)
+	if err != nil {
+		return err
+	}
+	r.env = env
+	if printEnv, _ := cmd.Flags().GetBool("print-env"); printEnv {
+		return printEnvironment(cmd, env)
+	}
+
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -74,8 +92,30 @@ func runApp(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("🚀 Starting application: %s\n", runCommand)
 
+	sup, err := r.newSupervisor(cmd, runCommand)
+	if err != nil {
+		return err
+	}
+
+	// Serve the supervisor's restart metrics for Prometheus to scrape
+	if addr := r.metricsAddr(cmd); addr != "" {
+		reg := prometheus.NewRegistry()
+		sup.Metrics = supervisor.NewMetrics(reg)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		server := &http.Server{Addr: addr, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Supervisor metrics server error: %v", err)
+			}
+		}()
+		defer server.Close()
+		fmt.Printf("📊 Supervisor metrics at http://%s/metrics\n", addr)
+	}
+
 	// Setup file watcher if hot reload is enabled
-	if config.GetBool("application.hot_reload.enabled") {
+	hotReload := config.GetBool("application.hot_reload.enabled")
+	if hotReload {
 		if err := r.setupWatcher(); err != nil {
 			return fmt.Errorf("error setting up file watcher: %w", err)
 		}
@@ -84,26 +124,40 @@ func runApp(cmd *cobra.Command, args []string) error {
 		fmt.Println("👀 Hot reload enabled. Watching for file changes...")
 	}
 
-	// Start the application
-	if err := r.startApp(runCommand); err != nil {
-		return fmt.Errorf("error starting application: %w", err)
-	}
+	// Start the application under the supervisor
+	supDone := make(chan error, 1)
+	running := true
+	go func() { supDone <- sup.Run(r.ctx) }()
 
 	// Main event loop
 	for {
 		select {
 		case <-sigChan:
 			fmt.Println("\n🛑 Shutting down...")
-			r.stopApp()
+			r.cancel()
+			if running {
+				<-supDone
+			}
 			return nil
 
 		case <-r.restartChan:
 			fmt.Println("\n🔄 Restarting application...")
-			r.stopApp()
-			time.Sleep(100 * time.Millisecond) // Brief pause
-			if err := r.startApp(runCommand); err != nil {
-				log.Printf("Error restarting application: %v", err)
+			if running {
+				sup.Restart()
+			} else {
+				running = true
+				go func() { supDone <- sup.Run(r.ctx) }()
+			}
+
+		case err := <-supDone:
+			running = false
+			if !hotReload {
+				return err
+			}
+			if err != nil {
+				fmt.Printf("❌ Application stopped: %v\n", err)
 			}
+			fmt.Println("👀 Waiting for file changes to start the application again...")
 
 		case <-r.ctx.Done():
 			return nil
@@ -111,6 +165,72 @@ func runApp(cmd *cobra.Command, args []string) error {
 	}
 }
 
+// newSupervisor builds the application's supervisor from
+// application.supervisor in apm.yaml and the command line flags
+func (r *runner) newSupervisor(cmd *cobra.Command, runCommand string) (*supervisor.Supervisor, error) {
+	parts := strings.Fields(runCommand)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	policy := supervisor.DefaultPolicy()
+	if err := r.config.UnmarshalKey("application.supervisor", &policy); err != nil {
+		return nil, fmt.Errorf("invalid application.supervisor config: %w", err)
+	}
+	if cmd.Flags().Changed("restart") {
+		restart, _ := cmd.Flags().GetString("restart")
+		policy.Restart = supervisor.RestartPolicy(restart)
+	}
+	if cmd.Flags().Changed("max-restarts") {
+		policy.MaxRestarts, _ = cmd.Flags().GetInt("max-restarts")
+	}
+
+	sup, err := supervisor.New(r.config.GetString("project.name"), func() *exec.Cmd {
+		return r.newCommand(parts)
+	}, policy)
+	if err != nil {
+		return nil, err
+	}
+	if sup.Name == "" {
+		sup.Name = "app"
+	}
+	sup.Signal = signalProcessGroup
+	sup.OnEvent = logSupervisorEvent
+	return sup, nil
+}
+
+func (r *runner) metricsAddr(cmd *cobra.Command) string {
+	if cmd.Flags().Changed("metrics-addr") {
+		addr, _ := cmd.Flags().GetString("metrics-addr")
+		return addr
+	}
+	return r.config.GetString("application.supervisor.metrics_addr")
+}
+
+// logSupervisorEvent prints the application's lifecycle events
+func logSupervisorEvent(e supervisor.Event) {
+	switch e.Type {
+	case supervisor.EventStarted:
+		if e.Restarts > 0 {
+			fmt.Printf("▶️  Application started (pid %d, restart %d)\n", e.PID, e.Restarts)
+		}
+	case supervisor.EventExited:
+		if e.Err != nil {
+			fmt.Printf("💥 Application exited after %s: %v\n", e.Uptime.Round(time.Millisecond), e.Err)
+		} else {
+			fmt.Printf("⏹️  Application exited after %s\n", e.Uptime.Round(time.Millisecond))
+		}
+	case supervisor.EventRestarting:
+		if e.Reason == "exit" {
+			fmt.Printf("🔁 Restarting in %s (restart %d)\n", e.Backoff, e.Restarts)
+		}
+	case supervisor.EventCrashLoop:
+		fmt.Printf("⚠️  Crash loop detected: %d restarts in a short time, backing off %s between restarts\n", e.Restarts, e.Backoff)
+	case supervisor.EventGaveUp:
+		fmt.Printf("❌ Giving up after %d restarts\n", e.Restarts)
+	}
+}
+
 func (r *runner) setupWatcher() error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -226,115 +346,145 @@ func (r *runner) shouldReload(event fsnotify.Event, extensions []string, exclude
 	return true
 }
 
-func (r *runner) startApp(command string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	// Setup environment variables for APM
-	env := os.Environ()
-	env = r.setupAPMEnvironment(env)
-
-	// Parse command
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return fmt.Errorf("empty command")
-	}
-
-	// Create command
-	cmd := exec.CommandContext(r.ctx, parts[0], parts[1:]...)
-	cmd.Env = env
+func (r *runner) newCommand(parts []string) *exec.Cmd {
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Env = r.env.Environ()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
 	// Set process group ID so we can kill all child processes
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return err
+// signalProcessGroup signals the application and all its child processes,
+// so that e.g. the binary started by `go run` stops too
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	pgid, err := syscall.Getpgid(cmd.Process.Pid)
+	if err != nil {
+		return cmd.Process.Signal(sig)
 	}
-
-	r.cmd = cmd
-
-	// Monitor process
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			if !strings.Contains(err.Error(), "signal: killed") {
-				log.Printf("Application exited with error: %v", err)
-			}
-		}
-	}()
-
-	return nil
+	return syscall.Kill(-pgid, sig.(syscall.Signal))
 }
 
-func (r *runner) stopApp() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// loadEnvironment builds the application's environment from the variables
+// derived from apm.yaml, the env files, and the process environment, and
+// validates it against application.env.schema
+func (r *runner) loadEnvironment(cmd *cobra.Command) (*runenv.Environment, error) {
+	opts := runenv.Options{Derived: r.derivedEnvironment()}
 
-	if r.cmd == nil || r.cmd.Process == nil {
-		return
+	files, _ := cmd.Flags().GetStringSlice("env-file")
+	if len(files) == 0 {
+		files = r.config.GetStringSlice("application.env.files")
 	}
-
-	// Kill the process group
-	pgid, err := syscall.Getpgid(r.cmd.Process.Pid)
-	if err == nil {
-		syscall.Kill(-pgid, syscall.SIGTERM)
+	if len(files) > 0 {
+		opts.Files = files
+	} else {
+		// Without explicit files, a .env next to apm.yaml is picked up if present
+		opts.OptionalFiles = []string{".env"}
 	}
 
-	// Give it time to shutdown gracefully
-	done := make(chan bool)
-	go func() {
-		r.cmd.Wait()
-		done <- true
-	}()
+	if err := r.config.UnmarshalKey("application.env.schema", &opts.Schema); err != nil {
+		return nil, fmt.Errorf("invalid application.env.schema: %w", err)
+	}
+	return runenv.Load(opts)
+}
 
-	select {
-	case <-done:
-		// Process exited gracefully
-	case <-time.After(5 * time.Second):
-		// Force kill if not exited
-		if err == nil {
-			syscall.Kill(-pgid, syscall.SIGKILL)
+// derivedEnvironment returns the OTEL_, APM_, and service variables derived
+// from apm.yaml
+func (r *runner) derivedEnvironment() []runenv.Var {
+	var vars []runenv.Var
+	set := func(name, value string) {
+		if value != "" {
+			vars = append(vars, runenv.Var{Name: name, Value: value})
 		}
 	}
 
-	r.cmd = nil
-}
+	service := r.config.GetString("project.name")
+	environment := r.config.GetString("project.environment")
+
+	// Service configuration read by the instrumentation package
+	set("SERVICE_NAME", service)
+	set("ENVIRONMENT", environment)
+	set("VERSION", r.config.GetString("project.version"))
+	set("LOG_LEVEL", r.config.GetString("application.log_level"))
+	set("APM_SERVICE_NAME", service)
+	set("APM_ENVIRONMENT", environment)
 
-func (r *runner) setupAPMEnvironment(env []string) []string {
 	// Add OpenTelemetry environment variables
 	if r.config.GetBool("apm.opentelemetry.enabled") {
-		env = append(env,
-			fmt.Sprintf("OTEL_SERVICE_NAME=%s", r.config.GetString("project.name")),
-			fmt.Sprintf("OTEL_EXPORTER_OTLP_ENDPOINT=%s", r.config.GetString("apm.opentelemetry.endpoint")),
-			"OTEL_TRACES_EXPORTER=otlp",
-			"OTEL_METRICS_EXPORTER=otlp",
-			"OTEL_LOGS_EXPORTER=otlp",
-		)
+		endpoint := r.config.GetString("apm.opentelemetry.endpoint")
+		set("OTEL_SERVICE_NAME", service)
+		set("OTEL_EXPORTER_OTLP_ENDPOINT", endpoint)
+		set("APM_ENDPOINT", endpoint)
+		set("OTEL_TRACES_EXPORTER", "otlp")
+		set("OTEL_METRICS_EXPORTER", "otlp")
+		set("OTEL_LOGS_EXPORTER", "otlp")
+
+		var attrs []string
+		if service != "" {
+			attrs = append(attrs, "service.name="+service)
+		}
+		if environment != "" {
+			attrs = append(attrs, "deployment.environment="+environment)
+		}
+		set("OTEL_RESOURCE_ATTRIBUTES", strings.Join(attrs, ","))
 	}
 
 	// Add Jaeger environment variables
 	if r.config.GetBool("apm.jaeger.enabled") {
-		env = append(env,
-			fmt.Sprintf("JAEGER_SERVICE_NAME=%s", r.config.GetString("project.name")),
-			fmt.Sprintf("JAEGER_AGENT_HOST=%s", r.config.GetString("apm.jaeger.agent_host")),
-			fmt.Sprintf("JAEGER_AGENT_PORT=%d", r.config.GetInt("apm.jaeger.agent_port")),
-		)
+		set("JAEGER_SERVICE_NAME", service)
+		set("JAEGER_AGENT_HOST", r.config.GetString("apm.jaeger.agent_host"))
+		if port := r.config.GetInt("apm.jaeger.agent_port"); port > 0 {
+			set("JAEGER_AGENT_PORT", strconv.Itoa(port))
+		}
 	}
 
-	// Add service configuration
-	env = append(env,
-		fmt.Sprintf("SERVICE_NAME=%s", r.config.GetString("project.name")),
-		fmt.Sprintf("ENVIRONMENT=%s", r.config.GetString("project.environment")),
-		fmt.Sprintf("LOG_LEVEL=%s", r.config.GetString("application.log_level")),
-	)
+	if r.config.GetBool("apm.prometheus.enabled") {
+		port := r.config.GetInt("apm.prometheus.port")
+		if port == 0 {
+			port = 9090
+		}
+		set("APM_PROMETHEUS_ENDPOINT", fmt.Sprintf("http://localhost:%d", port))
+	}
 
-	return env
+	// Metric relabeling is read by the instrumentation from apm.yaml itself
+	if r.config.IsSet("metrics") {
+		if path, err := filepath.Abs(r.config.ConfigFileUsed()); err == nil {
+			set("METRICS_RELABEL_FILE", path)
+		}
+	}
+
+	return vars
+}
+
+// printEnvironment shows the resolved environment with secrets masked
+func printEnvironment(cmd *cobra.Command, env *runenv.Environment) error {
+	// The process environment is inherited unchanged, so only show what apm
+	// adds or overrides
+	vars := []runenv.Var{}
+	for _, v := range env.Masked() {
+		if v.Source != runenv.SourceProcess {
+			vars = append(vars, v)
+		}
+	}
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(vars)
+	}
+	for _, v := range vars {
+		fmt.Printf("%s=%s  # %s\n", v.Name, v.Value, v.Source)
+	}
+	return nil
 }
 
 func init() {
 	RunCmd.Flags().BoolP("no-reload", "n", false, "Disable hot reload")
 	RunCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	RunCmd.Flags().String("restart", "on-failure", "Restart policy when the application exits (never, always, on-failure)")
+	RunCmd.Flags().Int("max-restarts", 10, "Give up after this many restarts without a stable run (0 for no limit)")
+	RunCmd.Flags().String("metrics-addr", "", "Serve supervisor restart metrics on this address (e.g. :9464)")
+	RunCmd.Flags().StringSlice("env-file", nil, "Load environment from this file; repeat for several, later files take precedence")
+	RunCmd.Flags().Bool("print-env", false, "Print the resolved environment with secrets masked and exit")
 }
@@ -1,12 +1,18 @@
 package commands
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/statusreport"
+	"github.com/chaksack/apm/pkg/tools"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
@@ -14,10 +20,15 @@ import (
 )
 
 var StatusCmd = &cobra.Command{
-	Use:   "status [deployment-id]",
-	Short: "Check deployment status and health",
+	Use:         "status [deployment-id]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Check deployment status and health",
 	Long: `Check the status of APM deployments and monitor their health.
-If no deployment ID is specified, shows the status of the most recent deployment.`,
+If no deployment ID is specified, shows the status of the most recent deployment.
+
+With --all-clusters the APM stack of every cluster registered in apm.yaml, or
+of every kubeconfig context, is checked and shown as one matrix; --cluster
+shows the details of one cluster.`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runStatus,
 }
@@ -26,8 +37,11 @@ var (
 	watchStatus    bool
 	watchInterval  int
 	statusJSON     bool
+	statusSchema   bool
 	statusVerbose  bool
 	allDeployments bool
+	detectTimeout  time.Duration
+	probeTimeout   time.Duration
 )
 
 type statusModel struct {
@@ -39,6 +53,11 @@ type statusModel struct {
 	lastUpdate  time.Time
 	width       int
 	height      int
+
+	// Local tool detection results, streamed in as each probe finishes
+	detections <-chan tools.DetectionResult
+	localTools []tools.DetectionResult
+	detecting  bool
 }
 
 type deploymentStatus struct {
@@ -85,22 +104,31 @@ type deploymentMetrics struct {
 }
 
 func init() {
-	StatusCmd.Flags().BoolVarP(&watchStatus, "watch", "w", false, "Continuously watch deployment status")
+	StatusCmd.Flags().BoolVarP(&watchStatus, "watch", "w", false, "Continuously watch deployment status; with --json, print one NDJSON line per interval")
 	StatusCmd.Flags().IntVar(&watchInterval, "interval", 5, "Watch interval in seconds")
-	StatusCmd.Flags().BoolVar(&statusJSON, "json", false, "Output status in JSON format")
+	StatusCmd.Flags().BoolVar(&statusJSON, "json", false, "Output status as JSON following the schema printed by --schema")
+	StatusCmd.Flags().BoolVar(&statusSchema, "schema", false, "Print the JSON Schema of the --json output and exit")
 	StatusCmd.Flags().BoolVarP(&statusVerbose, "verbose", "v", false, "Show detailed status information")
 	StatusCmd.Flags().BoolVarP(&allDeployments, "all", "a", false, "Show all deployments")
+	StatusCmd.Flags().DurationVar(&detectTimeout, "detect-timeout", tools.DefaultDetectTimeout, "Time allowed for detecting local APM tools")
+	StatusCmd.Flags().DurationVar(&probeTimeout, "probe-timeout", tools.DefaultProbeTimeout, "Time allowed for detecting each local APM tool")
 }
 
-func runStatus(cmd *cobra.Command, args []string) error {
-	// Load configuration
-	config := viper.New()
-	config.SetConfigName("apm")
-	config.SetConfigType("yaml")
-	config.AddConfigPath(".")
+// detectLocalTools starts probing for local APM tools with the configured timeouts
+func detectLocalTools() <-chan tools.DetectionResult {
+	return tools.DetectTools(context.Background(), tools.DetectOptions{
+		Timeout:      detectTimeout,
+		ProbeTimeout: probeTimeout,
+	})
+}
 
-	if err := config.ReadInConfig(); err != nil {
-		return fmt.Errorf("error reading config file: %w", err)
+func runStatus(cmd *cobra.Command, args []string) error {
+	if statusSchema {
+		_, err := os.Stdout.Write(statusreport.Schema())
+		return err
+	}
+	if allClusters || statusCluster != "" {
+		return runClusterStatus()
 	}
 
 	// Get deployment ID
@@ -109,8 +137,21 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		deploymentID = args[0]
 	}
 
+	// JSON output follows the statusreport schema, one line per interval
+	// when watching
+	if statusJSON {
+		if watchStatus {
+			return watchStatusJSON(deploymentID)
+		}
+		report, err := collectStatusReport(deploymentID)
+		if writeErr := report.Write(os.Stdout); writeErr != nil {
+			return writeErr
+		}
+		return err
+	}
+
 	// Get deployment statuses
-	statuses, err := getDeploymentStatuses(deploymentID, config)
+	statuses, err := loadDeploymentStatuses(deploymentID)
 	if err != nil {
 		return err
 	}
@@ -120,16 +161,6 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	// Handle JSON output
-	if statusJSON {
-		encoder := json.NewEncoder(os.Stdout)
-		encoder.SetIndent("", "  ")
-		if allDeployments {
-			return encoder.Encode(statuses)
-		}
-		return encoder.Encode(statuses[0])
-	}
-
 	// Handle watch mode
 	if watchStatus {
 		model := statusModel{
@@ -137,6 +168,8 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			watching:    true,
 			interval:    time.Duration(watchInterval) * time.Second,
 			lastUpdate:  time.Now(),
+			detections:  detectLocalTools(),
+			detecting:   true,
 		}
 
 		p := tea.NewProgram(model)
@@ -146,16 +179,138 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	// Display status once
+	// Display status once, probing for local tools meanwhile
+	detections := detectLocalTools()
 	if allDeployments {
 		displayAllStatuses(statuses)
 	} else {
 		displayDetailedStatus(statuses[0])
 	}
+	displayLocalTools(detections)
+	displayExporters(readinessURL())
 
 	return nil
 }
 
+// loadDeploymentStatuses reads apm.yaml and returns the deployment statuses
+func loadDeploymentStatuses(deploymentID string) ([]deploymentStatus, error) {
+	config := viper.New()
+	config.SetConfigName("apm")
+	config.SetConfigType("yaml")
+	config.AddConfigPath(".")
+
+	if err := config.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	return getDeploymentStatuses(deploymentID, config)
+}
+
+// collectStatusReport collects the deployment statuses, probes the local
+// tools, and reads the application's exporter check. A failure to collect
+// the statuses is also recorded in the report; an application that is not
+// running only leaves the exporters out.
+func collectStatusReport(deploymentID string) (*statusreport.Report, error) {
+	detections := detectLocalTools()
+	report := statusreport.New(time.Now())
+
+	statuses, err := loadDeploymentStatuses(deploymentID)
+	if err != nil {
+		report.Error = &statusreport.Error{Message: err.Error(), Time: report.GeneratedAt}
+	}
+	if !allDeployments && len(statuses) > 1 {
+		statuses = statuses[:1]
+	}
+	for _, status := range statuses {
+		report.Deployments = append(report.Deployments, reportDeployment(status))
+	}
+	report.Exporters, _ = fetchExporters(context.Background(), readinessURL())
+	for result := range detections {
+		report.Tools = append(report.Tools, reportTool(result))
+	}
+	return report, err
+}
+
+// watchStatusJSON writes a status report as one NDJSON line every interval
+// until interrupted. Collection failures are reported in the line rather
+// than ending the stream.
+func watchStatusJSON(deploymentID string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(time.Duration(watchInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		report, _ := collectStatusReport(deploymentID)
+		if err := report.WriteLine(os.Stdout); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func reportDeployment(status deploymentStatus) statusreport.Deployment {
+	d := statusreport.Deployment{
+		ID:              status.ID,
+		Name:            status.Name,
+		Type:            status.Type,
+		Status:          status.Status,
+		Health:          status.Health,
+		Progress:        status.Progress,
+		StartedAt:       status.StartTime,
+		DurationSeconds: statusreport.Seconds(status.Duration),
+		LastCheckedAt:   status.LastChecked,
+		Message:         status.Message,
+		Components:      []statusreport.Component{},
+		Endpoints:       []statusreport.Endpoint{},
+	}
+	if status.Error != "" {
+		d.LastError = &statusreport.Error{Message: status.Error, Time: status.LastChecked}
+	}
+	for _, comp := range status.Components {
+		c := statusreport.Component{
+			Name:    comp.Name,
+			Type:    comp.Type,
+			Status:  comp.Status,
+			Health:  comp.Health,
+			Version: comp.Version,
+			Message: comp.Message,
+		}
+		// A failed component's message is its last error
+		if statusSeverity(comp.Status) == severityError && comp.Message != "" {
+			c.LastError = &statusreport.Error{Message: comp.Message, Time: status.LastChecked}
+		}
+		d.Components = append(d.Components, c)
+	}
+	for name, url := range status.Endpoints {
+		d.Endpoints = append(d.Endpoints, statusreport.Endpoint{Name: name, URL: url})
+	}
+	return d
+}
+
+func reportTool(result tools.DetectionResult) statusreport.Tool {
+	t := statusreport.Tool{
+		Type:           string(result.ToolType),
+		ElapsedSeconds: statusreport.Seconds(result.Elapsed),
+		Health:         statusreport.HealthUnknown,
+	}
+	if result.Tool == nil {
+		t.Reason = "not detected"
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			t.Reason = "timed out"
+		}
+		return t
+	}
+	t.Detected = true
+	t.Endpoint = result.Tool.Endpoint
+	t.Version = result.Tool.Version
+	t.Health = string(result.Tool.Status)
+	return t
+}
+
 func getDeploymentStatuses(deploymentID string, config *viper.Viper) ([]deploymentStatus, error) {
 	// This would integrate with the deploy package to get real status
 	// For now, we'll simulate based on configuration and deployment history
@@ -275,7 +430,7 @@ func getMockStatuses(deploymentID string, config *viper.Viper) ([]deploymentStat
 
 // Tea Model implementation for watch mode
 func (m statusModel) Init() tea.Cmd {
-	return tick()
+	return tea.Batch(tick(), waitForDetection(m.detections))
 }
 
 func (m statusModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -310,6 +465,14 @@ func (m statusModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case statusUpdatedMsg:
 		m.deployments = msg.statuses
 		return m, nil
+
+	case toolDetectedMsg:
+		m.localTools = append(m.localTools, tools.DetectionResult(msg))
+		return m, waitForDetection(m.detections)
+
+	case detectionDoneMsg:
+		m.detecting = false
+		return m, nil
 	}
 
 	return m, nil
@@ -325,10 +488,7 @@ func (m statusModel) View() string {
 	}
 
 	// Title
-	titleStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("86")).
-		MarginBottom(1)
+	titleStyle := theme.Title.MarginBottom(1)
 
 	s := titleStyle.Render("📊 Deployment Status") + "\n\n"
 
@@ -337,20 +497,18 @@ func (m statusModel) View() string {
 		s += renderDeploymentStatus(m.deployments[m.current], true)
 	}
 
+	s += "\n" + renderLocalTools(m.localTools, m.detecting)
+
 	// Show navigation info
 	if len(m.deployments) > 1 {
-		navStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("241")).
-			MarginTop(2)
+		navStyle := theme.Dim.MarginTop(2)
 		s += navStyle.Render(fmt.Sprintf("\nShowing %d of %d deployments. Use ↑/↓ to navigate.", m.current+1, len(m.deployments)))
 	}
 
 	// Show last update time
-	updateStyle := lipgloss.NewStyle().
-		Foreground(lipgloss.Color("241")).
-		MarginTop(1)
+	updateStyle := theme.Dim.MarginTop(1)
 	s += updateStyle.Render(fmt.Sprintf("\nLast updated: %s (refreshing every %s)",
-		m.lastUpdate.Format("15:04:05"), m.interval))
+		display.Clock(m.lastUpdate), m.interval))
 
 	// Instructions
 	s += "\n\n[r] Refresh  [q] Quit"
@@ -364,6 +522,20 @@ type statusUpdatedMsg struct {
 	statuses []deploymentStatus
 }
 
+type toolDetectedMsg tools.DetectionResult
+type detectionDoneMsg struct{}
+
+// waitForDetection delivers the next detection result to the model
+func waitForDetection(detections <-chan tools.DetectionResult) tea.Cmd {
+	return func() tea.Msg {
+		result, ok := <-detections
+		if !ok {
+			return detectionDoneMsg{}
+		}
+		return toolDetectedMsg(result)
+	}
+}
+
 func tick() tea.Cmd {
 	return tea.Tick(5*time.Second, func(t time.Time) tea.Msg {
 		return tickMsg(t)
@@ -391,47 +563,71 @@ func refreshStatus(m *statusModel) tea.Cmd {
 
 // Display functions
 func displayAllStatuses(statuses []deploymentStatus) {
-	titleStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("86")).
-		MarginBottom(1)
+	titleStyle := theme.Title.MarginBottom(1)
 
 	fmt.Println(titleStyle.Render("📊 All Deployments"))
 	fmt.Println()
 
 	// Table header
 	headerStyle := lipgloss.NewStyle().Bold(true)
-	fmt.Printf("%-20s %-15s %-10s %-10s %-20s %-15s\n",
-		headerStyle.Render("ID"),
-		headerStyle.Render("Name"),
-		headerStyle.Render("Type"),
-		headerStyle.Render("Status"),
-		headerStyle.Render("Started"),
-		headerStyle.Render("Duration"),
-	)
+	fmt.Println(headerStyle.Render(fmt.Sprintf("%-20s %-15s %-10s %-12s %-24s %-15s",
+		"ID", "Name", "Type", "Status", "Started", "Duration")))
 	fmt.Println(strings.Repeat("-", 100))
 
 	// Table rows
 	for _, status := range statuses {
-		statusColor := getStatusColor(status.Status)
-		statusStyle := lipgloss.NewStyle().Foreground(statusColor)
-
 		duration := "Running"
 		if status.Duration > 0 {
 			duration = formatDuration(status.Duration)
 		}
 
-		fmt.Printf("%-20s %-15s %-10s %-10s %-20s %-15s\n",
+		fmt.Printf("%-20s %-15s %-10s %s %-24s %-15s\n",
 			status.ID,
 			status.Name,
 			status.Type,
-			statusStyle.Render(status.Status),
-			status.StartTime.Format("2006-01-02 15:04"),
+			theme.Mark(statusSeverity(status.Status), status.Status, 12),
+			display.DateTime(status.StartTime),
 			duration,
 		)
 	}
 }
 
+// displayLocalTools prints each local tool as its probe finishes
+func displayLocalTools(detections <-chan tools.DetectionResult) {
+	fmt.Println()
+	fmt.Println(theme.Title.Render("Local Tools"))
+	for result := range detections {
+		fmt.Println(renderDetection(result))
+	}
+}
+
+// renderLocalTools renders the detection results so far
+func renderLocalTools(results []tools.DetectionResult, detecting bool) string {
+	var b strings.Builder
+	b.WriteString(theme.Title.Render("Local Tools") + "\n")
+	for _, result := range results {
+		b.WriteString(renderDetection(result) + "\n")
+	}
+	if detecting {
+		b.WriteString(theme.Dim.Render("  detecting...") + "\n")
+	}
+	return b.String()
+}
+
+// renderDetection renders one local tool line
+func renderDetection(result tools.DetectionResult) string {
+	elapsed := result.Elapsed.Round(time.Millisecond)
+	if result.Tool == nil {
+		reason := "not detected"
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			reason = "timed out"
+		}
+		return "  " + theme.Mark(severityMuted, fmt.Sprintf("%-13s %s (%s)", result.ToolType, reason, elapsed), 0)
+	}
+	return "  " + theme.Mark(severityOK, fmt.Sprintf("%-13s %s", result.ToolType, result.Tool.Endpoint), 0) +
+		theme.Dim.Render(fmt.Sprintf(" (%s)", elapsed))
+}
+
 func displayDetailedStatus(status deploymentStatus) {
 	fmt.Print(renderDeploymentStatus(status, statusVerbose))
 }
@@ -440,21 +636,16 @@ func renderDeploymentStatus(status deploymentStatus, verbose bool) string {
 	var b strings.Builder
 
 	// Header
-	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	headerStyle := theme.Title
 	b.WriteString(headerStyle.Render(fmt.Sprintf("Deployment: %s", status.Name)) + "\n")
 	b.WriteString(strings.Repeat("─", 50) + "\n\n")
 
 	// Basic info
-	statusColor := getStatusColor(status.Status)
-	statusStyle := lipgloss.NewStyle().Foreground(statusColor).Bold(true)
-	healthColor := getHealthColor(status.Health)
-	healthStyle := lipgloss.NewStyle().Foreground(healthColor).Bold(true)
-
 	b.WriteString(fmt.Sprintf("ID:       %s\n", status.ID))
 	b.WriteString(fmt.Sprintf("Type:     %s\n", status.Type))
-	b.WriteString(fmt.Sprintf("Status:   %s\n", statusStyle.Render(status.Status)))
-	b.WriteString(fmt.Sprintf("Health:   %s\n", healthStyle.Render(status.Health)))
-	b.WriteString(fmt.Sprintf("Started:  %s\n", status.StartTime.Format("2006-01-02 15:04:05")))
+	b.WriteString(fmt.Sprintf("Status:   %s\n", theme.Mark(statusSeverity(status.Status), status.Status, 0)))
+	b.WriteString(fmt.Sprintf("Health:   %s\n", theme.Mark(healthSeverity(status.Health), status.Health, 0)))
+	b.WriteString(fmt.Sprintf("Started:  %s\n", display.DateTime(status.StartTime)))
 
 	if status.Duration > 0 {
 		b.WriteString(fmt.Sprintf("Duration: %s\n", formatDuration(status.Duration)))
@@ -469,20 +660,16 @@ func renderDeploymentStatus(status deploymentStatus, verbose bool) string {
 	}
 
 	if status.Error != "" {
-		errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
-		b.WriteString(fmt.Sprintf("Error:    %s\n", errorStyle.Render(status.Error)))
+		b.WriteString(fmt.Sprintf("Error:    %s\n", theme.Mark(severityError, status.Error, 0)))
 	}
 
 	// Components
 	b.WriteString("\n" + headerStyle.Render("Components:") + "\n")
 	for _, comp := range status.Components {
-		compStatusColor := getStatusColor(comp.Status)
-		compStatusStyle := lipgloss.NewStyle().Foreground(compStatusColor)
-
 		b.WriteString(fmt.Sprintf("  %-20s %-15s %s",
 			comp.Name,
 			comp.Type,
-			compStatusStyle.Render(comp.Status),
+			theme.Mark(statusSeverity(comp.Status), comp.Status, 0),
 		))
 
 		if comp.Version != "" {
@@ -537,34 +724,6 @@ func renderDeploymentStatus(status deploymentStatus, verbose bool) string {
 	return b.String()
 }
 
-func getStatusColor(status string) lipgloss.Color {
-	switch strings.ToLower(status) {
-	case "running", "deployed", "active", "healthy":
-		return lipgloss.Color("42") // Green
-	case "pending", "deploying", "starting", "updating":
-		return lipgloss.Color("214") // Yellow
-	case "failed", "error", "crashed", "unhealthy":
-		return lipgloss.Color("196") // Red
-	case "stopped", "terminated", "completed":
-		return lipgloss.Color("241") // Gray
-	default:
-		return lipgloss.Color("86") // Blue
-	}
-}
-
-func getHealthColor(health string) lipgloss.Color {
-	switch strings.ToLower(health) {
-	case "healthy":
-		return lipgloss.Color("42") // Green
-	case "degraded", "warning":
-		return lipgloss.Color("214") // Yellow
-	case "unhealthy", "critical":
-		return lipgloss.Color("196") // Red
-	default:
-		return lipgloss.Color("241") // Gray
-	}
-}
-
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
 		return fmt.Sprintf("%.0fs", d.Seconds())
@@ -0,0 +1,311 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chaksack/apm/pkg/federation"
+	"github.com/chaksack/apm/pkg/statusreport"
+	"github.com/spf13/viper"
+)
+
+var (
+	allClusters    bool
+	statusCluster  string
+	statusKubeconf string
+	clusterTimeout time.Duration
+)
+
+func init() {
+	StatusCmd.Flags().BoolVar(&allClusters, "all-clusters", false, "Show the APM stack health of every registered cluster, or every kubeconfig context")
+	StatusCmd.Flags().StringVar(&statusCluster, "cluster", "", "Show the details of one cluster of --all-clusters")
+	StatusCmd.Flags().StringVar(&statusKubeconf, "kubeconfig", "", "Path to kubeconfig (default $KUBECONFIG or ~/.kube/config)")
+	StatusCmd.Flags().DurationVar(&clusterTimeout, "cluster-timeout", federation.DefaultTimeout, "Time allowed for checking each cluster")
+}
+
+// loadClusters returns the clusters registered in apm.yaml, or a cluster per
+// kubeconfig context when none is
+func loadClusters() ([]federation.Cluster, error) {
+	config := viper.New()
+	config.SetConfigName("apm")
+	config.SetConfigType("yaml")
+	config.AddConfigPath(".")
+
+	var clusters []federation.Cluster
+	if err := config.ReadInConfig(); err == nil {
+		if err := config.UnmarshalKey("clusters", &clusters); err != nil {
+			return nil, fmt.Errorf("invalid clusters in apm.yaml: %w", err)
+		}
+	}
+	for n := range clusters {
+		if clusters[n].Name == "" {
+			clusters[n].Name = clusters[n].Context
+		}
+		if clusters[n].Name == "" {
+			return nil, fmt.Errorf("cluster %d in apm.yaml has neither a name nor a context", n+1)
+		}
+	}
+	if len(clusters) > 0 {
+		return clusters, nil
+	}
+	clusters, err := federation.ContextClusters(statusKubeconf)
+	if err != nil {
+		return nil, err
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no clusters registered in apm.yaml and no kubeconfig contexts")
+	}
+	return clusters, nil
+}
+
+// runClusterStatus prints the federated status of the clusters, once or
+// every interval with --watch
+func runClusterStatus() error {
+	clusters, err := loadClusters()
+	if err != nil {
+		return err
+	}
+	if statusCluster != "" {
+		var selected []federation.Cluster
+		var names []string
+		for _, c := range clusters {
+			if c.Name == statusCluster {
+				selected = append(selected, c)
+			}
+			names = append(names, c.Name)
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("unknown cluster %q; known clusters: %s", statusCluster, strings.Join(names, ", "))
+		}
+		clusters = selected
+	}
+	opts := federation.Options{Kubeconfig: statusKubeconf, Timeout: clusterTimeout}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(time.Duration(watchInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		report := federation.Collect(ctx, clusters, opts)
+		if err := printClusterReport(report); err != nil {
+			return err
+		}
+		if !watchStatus {
+			if report.Health == statusreport.HealthUnhealthy {
+				return fmt.Errorf("%s unhealthy", pluralClusters(countHealth(report, statusreport.HealthUnhealthy)))
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printClusterReport prints the report as JSON, one line per interval when
+// watching, or as the matrix, or the details with --cluster
+func printClusterReport(report *federation.Report) error {
+	if statusJSON {
+		enc := json.NewEncoder(os.Stdout)
+		if !watchStatus {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(report)
+	}
+	if watchStatus {
+		fmt.Print("\033[H\033[2J")
+	}
+	if statusCluster != "" {
+		fmt.Print(renderClusterDetails(report.Clusters[0]))
+	} else {
+		fmt.Print(renderClusterMatrix(report))
+	}
+	if watchStatus {
+		fmt.Println(theme.Dim.Render(fmt.Sprintf("\nUpdated %s, every %ds. Press Ctrl+C to stop.", display.Clock(report.GeneratedAt), watchInterval)))
+	}
+	return nil
+}
+
+// renderClusterMatrix renders a row per cluster with the health and version
+// of each component, the firing alerts, and the targets up
+func renderClusterMatrix(report *federation.Report) string {
+	var b strings.Builder
+	b.WriteString(theme.Title.Render("🌐 Clusters") + "\n\n")
+
+	header := fmt.Sprintf("%-20s %-12s", "CLUSTER", "HEALTH")
+	for _, component := range federation.Components {
+		header += fmt.Sprintf(" %-14s", strings.ToUpper(component))
+	}
+	header += fmt.Sprintf(" %-18s %s", "ALERTS", "TARGETS")
+	b.WriteString(theme.Title.Render(header) + "\n")
+
+	for _, c := range report.Clusters {
+		b.WriteString(fmt.Sprintf("%-20s ", truncate(c.Name, 20)))
+		b.WriteString(theme.Mark(healthSeverity(c.Health), c.Health, 12))
+		if c.Error != "" {
+			b.WriteString(" " + theme.Mark(severityError, truncate(c.Error, 90), 0) + "\n")
+			continue
+		}
+		for _, component := range federation.Components {
+			b.WriteString(" " + renderComponentCell(c, component))
+		}
+		b.WriteString(" " + renderAlertCounts(c.AlertCounts, 18))
+		if c.TargetsUp+c.TargetsDown > 0 {
+			targets := fmt.Sprintf("%s/%s up", display.Int(int64(c.TargetsUp)), display.Int(int64(c.TargetsUp+c.TargetsDown)))
+			sev := severityOK
+			if c.TargetsDown > 0 {
+				sev = severityWarning
+			}
+			b.WriteString(" " + theme.Mark(sev, targets, 0))
+		} else {
+			b.WriteString(" " + theme.Dim.Render("-"))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n" + theme.Dim.Render(fmt.Sprintf("%s checked. Drill down with: apm status --all-clusters --cluster <name>",
+		pluralClusters(len(report.Clusters)))) + "\n")
+	return b.String()
+}
+
+// renderComponentCell renders the health and version of a component
+func renderComponentCell(c federation.ClusterStatus, component string) string {
+	comp, ok := c.Component(component)
+	if !ok {
+		return theme.Mark(severityMuted, "-", 14)
+	}
+	switch comp.Health {
+	case federation.HealthAbsent:
+		return theme.Mark(severityMuted, "absent", 14)
+	case statusreport.HealthUnhealthy:
+		return theme.Mark(severityError, "down", 14)
+	}
+	text := comp.Version
+	if text == "" {
+		text = comp.Health
+	}
+	return theme.Mark(healthSeverity(comp.Health), truncate(text, 12), 14)
+}
+
+// renderAlertCounts renders the firing alerts per severity, most severe
+// first
+func renderAlertCounts(counts map[string]int, width int) string {
+	if len(counts) == 0 {
+		return theme.Mark(severityOK, "none", width)
+	}
+	severities := make([]string, 0, len(counts))
+	for s := range counts {
+		severities = append(severities, s)
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		ri, rj := alertSeverityRank(severities[i]), alertSeverityRank(severities[j])
+		return ri < rj || ri == rj && severities[i] < severities[j]
+	})
+	parts := make([]string, 0, len(severities))
+	for _, s := range severities {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[s], s))
+	}
+	sev := severityWarning
+	if counts["critical"] > 0 {
+		sev = severityError
+	}
+	return theme.Mark(sev, strings.Join(parts, ", "), width)
+}
+
+func alertSeverityRank(severity string) int {
+	switch severity {
+	case "critical":
+		return 0
+	case "warning":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// renderClusterDetails renders one cluster's components, firing alerts, and
+// down targets
+func renderClusterDetails(c federation.ClusterStatus) string {
+	var b strings.Builder
+	b.WriteString(theme.Title.Render(fmt.Sprintf("Cluster: %s", c.Name)) + "\n")
+	b.WriteString(strings.Repeat("─", 50) + "\n\n")
+	if c.Context != "" {
+		b.WriteString(fmt.Sprintf("Context:  %s\n", c.Context))
+	}
+	b.WriteString(fmt.Sprintf("Health:   %s\n", theme.Mark(healthSeverity(c.Health), c.Health, 0)))
+	b.WriteString(fmt.Sprintf("Checked:  %.1fs\n", c.ElapsedSeconds))
+	if c.Error != "" {
+		b.WriteString(fmt.Sprintf("Error:    %s\n", theme.Mark(severityError, c.Error, 0)))
+		return b.String()
+	}
+
+	b.WriteString("\n" + theme.Title.Render("Components:") + "\n")
+	for _, comp := range c.Components {
+		sev := healthSeverity(comp.Health)
+		if comp.Health == federation.HealthAbsent {
+			sev = severityMuted
+		}
+		b.WriteString(fmt.Sprintf("  %-14s %s %-10s %s\n", comp.Name, theme.Mark(sev, comp.Health, 11), comp.Version, theme.Dim.Render(comp.URL)))
+		if comp.Message != "" && comp.Health != federation.HealthAbsent {
+			b.WriteString(fmt.Sprintf("    %s\n", comp.Message))
+		}
+	}
+
+	b.WriteString("\n" + theme.Title.Render(fmt.Sprintf("Firing alerts (%d):", len(c.Alerts))) + "\n")
+	if len(c.Alerts) == 0 {
+		b.WriteString("  " + theme.Mark(severityOK, "none", 0) + "\n")
+	}
+	for _, a := range c.Alerts {
+		sev := severityWarning
+		if a.Severity == "critical" {
+			sev = severityError
+		}
+		b.WriteString(fmt.Sprintf("  %s %-10s since %s", theme.Mark(sev, a.Name, 30), a.Severity, display.DateTime(a.ActiveAt)))
+		if a.Summary != "" {
+			b.WriteString("  " + theme.Dim.Render(a.Summary))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n" + theme.Title.Render(fmt.Sprintf("Scrape targets: %d up, %d down", c.TargetsUp, c.TargetsDown)) + "\n")
+	for _, t := range c.DownTargets {
+		b.WriteString(fmt.Sprintf("  %s %s\n", theme.Mark(severityError, t.Job+" "+t.Instance, 40), theme.Dim.Render(t.Error)))
+	}
+	return b.String()
+}
+
+func countHealth(report *federation.Report, health string) int {
+	n := 0
+	for _, c := range report.Clusters {
+		if c.Health == health {
+			n++
+		}
+	}
+	return n
+}
+
+func pluralClusters(n int) string {
+	if n == 1 {
+		return "1 cluster"
+	}
+	return fmt.Sprintf("%d clusters", n)
+}
+
+// truncate shortens s to width runes, marking the cut
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/chaksack/apm/pkg/statusreport"
+	"github.com/spf13/viper"
+)
+
+var statusReadyURL string
+
+func init() {
+	StatusCmd.Flags().StringVar(&statusReadyURL, "ready-url", "", "Readiness endpoint of the application (default application.ready_url in apm.yaml, or /health/ready on application.port)")
+}
+
+// readinessURL returns the application's readiness endpoint
+func readinessURL() string {
+	if statusReadyURL != "" {
+		return statusReadyURL
+	}
+	config := viper.New()
+	config.SetConfigName("apm")
+	config.SetConfigType("yaml")
+	config.AddConfigPath(".")
+	config.SetDefault("application.port", 3000)
+	config.ReadInConfig()
+	if url := config.GetString("application.ready_url"); url != "" {
+		return url
+	}
+	return fmt.Sprintf("http://localhost:%d/health/ready", config.GetInt("application.port"))
+}
+
+// fetchExporters reads the exporters check, which instrumentation.New
+// registers, from the application's readiness endpoint. It returns nil
+// without an error when the application has no such check.
+func fetchExporters(ctx context.Context, url string) (*statusreport.Exporters, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// An unhealthy application answers 503 with the same body
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var ready struct {
+		Checks []struct {
+			Name    string          `json:"name"`
+			Status  string          `json:"status"`
+			Message string          `json:"message"`
+			Details json.RawMessage `json:"details"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil {
+		return nil, fmt.Errorf("invalid readiness response from %s: %w", url, err)
+	}
+	for _, check := range ready.Checks {
+		if check.Name != "exporters" {
+			continue
+		}
+		var report instrumentation.ExporterReport
+		if err := json.Unmarshal(check.Details, &report); err != nil {
+			return nil, fmt.Errorf("invalid exporters check from %s: %w", url, err)
+		}
+		exporters := &statusreport.Exporters{
+			Policy:    report.Policy,
+			Health:    check.Status,
+			Message:   check.Message,
+			Exporters: []statusreport.Exporter{},
+		}
+		for _, e := range report.Exporters {
+			exporters.Exporters = append(exporters.Exporters, statusreport.Exporter{
+				Name:      e.Exporter,
+				Endpoint:  e.Endpoint,
+				Reachable: e.Reachable,
+				Enabled:   e.Enabled,
+				Error:     e.Error,
+			})
+		}
+		return exporters, nil
+	}
+	return nil, nil
+}
+
+// displayExporters prints the exporter policy of the application and the
+// state of each exporter
+func displayExporters(url string) {
+	fmt.Println()
+	fmt.Println(theme.Title.Render("Telemetry Exporters"))
+	exporters, err := fetchExporters(context.Background(), url)
+	switch {
+	case err != nil:
+		fmt.Println("  " + theme.Mark(severityMuted, "application not reachable at "+url, 0))
+		return
+	case exporters == nil:
+		fmt.Println("  " + theme.Mark(severityMuted, "no exporter check registered (instrumentation.WithHealthChecker)", 0))
+		return
+	}
+	fmt.Printf("  Policy: %s\n", exporters.Policy)
+	for _, e := range exporters.Exporters {
+		switch {
+		case !e.Enabled:
+			fmt.Println("  " + theme.Mark(severityMuted, fmt.Sprintf("%-13s %s disabled: %s", e.Name, e.Endpoint, e.Error), 0))
+		case !e.Reachable:
+			fmt.Println("  " + theme.Mark(severityWarning, fmt.Sprintf("%-13s %s unreachable: %s", e.Name, e.Endpoint, e.Error), 0))
+		default:
+			fmt.Println("  " + theme.Mark(severityOK, fmt.Sprintf("%-13s %s", e.Name, e.Endpoint), 0))
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/statuspage"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var StatusPageCmd = &cobra.Command{
+	Use:         "statuspage",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Generate a public uptime status page",
+	Long: `Generate a public status page from the components declared under
+"status_page" in apm.yaml: the current status and daily uptime of each
+component from its synthetic check or SLI in Prometheus, the incidents of the
+incident timeline that affected it, and scheduled maintenance.
+
+The page is written as a single HTML file to publish on any static host, or
+served with --serve, where it is regenerated every --refresh and also
+available as JSON at /status.json.
+
+Incidents affect the components whose services match the service, job, or
+app label of their alerts. They are listed by affected components only,
+unless show_incident_titles publishes their summaries' titles.
+
+  status_page:
+    title: Acme Status
+    days: 90
+    components:
+      - name: API
+        group: Core
+        query: probe_success{job="blackbox-http", instance="https://api.acme.com/health"}
+        objective: 99.9
+        services: [api]
+      - name: Checkout
+        group: Core
+        query: slo:availability_1h{service="checkout"}
+        services: [checkout, payments]
+    maintenance:
+      - title: Database upgrade
+        components: [Checkout]
+        start: 2024-06-01T02:00:00Z
+        end: 2024-06-01T03:00:00Z
+
+Examples:
+  apm statuspage -o public/index.html
+  apm statuspage --json
+  apm statuspage --serve :8088 --refresh 1m`,
+	RunE: runStatusPage,
+}
+
+var (
+	statusPageOutput        string
+	statusPageJSON          bool
+	statusPageServe         string
+	statusPageRefresh       time.Duration
+	statusPagePrometheusURL string
+	statusPageTenant        string
+	statusPageTimeline      string
+)
+
+func init() {
+	StatusPageCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	StatusPageCmd.Flags().StringVarP(&statusPageOutput, "output", "o", "status.html", "HTML file to write")
+	StatusPageCmd.Flags().BoolVar(&statusPageJSON, "json", false, "Print the page as JSON instead of writing HTML")
+	StatusPageCmd.Flags().StringVar(&statusPageServe, "serve", "", "Serve the page at this address instead of writing it, e.g. :8088")
+	StatusPageCmd.Flags().DurationVar(&statusPageRefresh, "refresh", time.Minute, "How often the served page is regenerated")
+	StatusPageCmd.Flags().StringVar(&statusPagePrometheusURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	StatusPageCmd.Flags().StringVar(&statusPageTenant, "tenant", "", "Tenant ID sent to a multi-tenant Prometheus")
+	StatusPageCmd.Flags().StringVar(&statusPageTimeline, "timeline", "", "Incident timeline file (default from incidents.timeline)")
+}
+
+func runStatusPage(cmd *cobra.Command, args []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	config.SetDefault("incidents.timeline", "incidents.jsonl")
+	if err := config.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	var pageConfig statuspage.Config
+	if err := config.UnmarshalKey("status_page", &pageConfig, viper.DecodeHook(stringToTime)); err != nil {
+		return fmt.Errorf("invalid status_page in %s: %w", configPath, err)
+	}
+	if err := pageConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid status_page in %s: %w", configPath, err)
+	}
+
+	if statusPagePrometheusURL == "" {
+		statusPagePrometheusURL = prometheusURLFromConfig(cmd)
+	}
+	if statusPageTimeline == "" {
+		statusPageTimeline = config.GetString("incidents.timeline")
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	if statusPageTenant != "" {
+		client = tenancy.NewClient(client, statusPageTenant)
+	}
+	builder := &statuspage.Builder{
+		PrometheusURL: statusPagePrometheusURL,
+		Client:        client,
+		Timeline:      incident.NewFileTimeline(statusPageTimeline),
+	}
+
+	if statusPageServe != "" {
+		return serveStatusPage(builder, pageConfig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	page, err := builder.Build(ctx, pageConfig)
+	if err != nil {
+		return err
+	}
+	if statusPageJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(page)
+	}
+
+	var html bytes.Buffer
+	if err := page.WriteHTML(&html); err != nil {
+		return err
+	}
+	if err := os.WriteFile(statusPageOutput, html.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", statusPageOutput, err)
+	}
+	fmt.Printf("%s %s: %s, %s\n", theme.Marker(statusPageSeverity(page.Status)), statusPageOutput, page.Status,
+		pluralIncidents(len(page.Incidents), pageConfig.Days))
+	return nil
+}
+
+// serveStatusPage serves the page and its JSON, regenerating them every
+// refresh; a failed refresh keeps serving the last page
+func serveStatusPage(builder *statuspage.Builder, cfg statuspage.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		mu         sync.RWMutex
+		html, data []byte
+	)
+	refresh := func() error {
+		buildCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+		page, err := builder.Build(buildCtx, cfg)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := page.WriteHTML(&buf); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(page)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		html, data = buf.Bytes(), encoded
+		mu.Unlock()
+		return nil
+	}
+	if err := refresh(); err != nil {
+		return err
+	}
+
+	serve := func(contentType string, body *[]byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.RLock()
+			defer mu.RUnlock()
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(statusPageRefresh.Seconds())))
+			w.Write(*body)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", serve("text/html; charset=utf-8", &html))
+	mux.HandleFunc("GET /status.json", serve("application/json", &data))
+	server := &http.Server{Addr: statusPageServe, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	fmt.Printf("📄 Status page at http://%s/, refreshed every %s\n", displayAddr(statusPageServe), statusPageRefresh)
+
+	ticker := time.NewTicker(statusPageRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		case <-ticker.C:
+			if err := refresh(); err != nil {
+				log.Printf("Status page refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// stringToTime decodes quoted RFC 3339 times, which YAML leaves as strings
+func stringToTime(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(time.Time{}) {
+		return data, nil
+	}
+	return time.Parse(time.RFC3339, data.(string))
+}
+
+func statusPageSeverity(status string) severity {
+	switch status {
+	case statuspage.StatusOperational:
+		return severityOK
+	case statuspage.StatusMaintenance:
+		return severityInfo
+	case statuspage.StatusDegraded:
+		return severityWarning
+	case statuspage.StatusOutage:
+		return severityError
+	}
+	return severityMuted
+}
+
+func pluralIncidents(n, days int) string {
+	if n == 1 {
+		return fmt.Sprintf("1 incident in %d days", days)
+	}
+	return fmt.Sprintf("%d incidents in %d days", n, days)
+}
+
+// displayAddr turns a listen address like :8088 into one to browse
+func displayAddr(addr string) string {
+	if len(addr) > 0 && addr[0] == ':' {
+		return "localhost" + addr
+	}
+	return addr
+}
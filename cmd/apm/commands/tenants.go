@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var TenantsCmd = &cobra.Command{
+	Use:         "tenants",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Manage tenant isolation for a shared observability stack",
+	Long: `Manage tenants declared in the tenancy section of apm.yaml.
+
+Each tenant's data is isolated in Loki, Mimir, and Tempo by the X-Scope-OrgID
+header, limited by per-tenant runtime overrides, and visible in Grafana only
+through the tenant's own organization.`,
+}
+
+var tenantsRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render per-tenant runtime overrides and Grafana datasources",
+	Long: `Render Loki, Mimir, and Tempo runtime overrides and a Grafana datasource
+provisioning file for every tenant.
+
+Examples:
+  apm tenants render --output-dir deployments/tenancy`,
+	RunE: runTenantsRender,
+}
+
+var tenantsProvisionCmd = &cobra.Command{
+	Use:         "provision-grafana",
+	Annotations: needs(access.ScopeDeploy, "true"),
+	Short:       "Create a Grafana organization and datasources per tenant",
+	Long: `Create a Grafana organization per tenant with Loki, Mimir, and Tempo
+datasources that send the tenant header. Requires Grafana server admin credentials.
+
+Examples:
+  apm tenants provision-grafana --grafana-url http://localhost:3000 --user admin`,
+	RunE: runTenantsProvision,
+}
+
+var (
+	tenantsOutputDir   string
+	tenantsGrafanaURL  string
+	tenantsGrafanaUser string
+	tenantsGrafanaPass string
+)
+
+func init() {
+	TenantsCmd.PersistentFlags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+
+	tenantsRenderCmd.Flags().StringVarP(&tenantsOutputDir, "output-dir", "o", "tenancy", "Directory for rendered files")
+
+	tenantsProvisionCmd.Flags().StringVar(&tenantsGrafanaURL, "grafana-url", "http://localhost:3000", "Grafana URL")
+	tenantsProvisionCmd.Flags().StringVar(&tenantsGrafanaUser, "user", "admin", "Grafana server admin user")
+	tenantsProvisionCmd.Flags().StringVar(&tenantsGrafanaPass, "password", "", "Grafana server admin password (or GRAFANA_ADMIN_PASSWORD)")
+
+	TenantsCmd.AddCommand(tenantsRenderCmd)
+	TenantsCmd.AddCommand(tenantsProvisionCmd)
+}
+
+// loadTenancyConfig reads and validates the tenancy section of apm.yaml
+func loadTenancyConfig(cmd *cobra.Command) (tenancy.Config, tenancy.Endpoints, error) {
+	var cfg tenancy.Config
+	var endpoints tenancy.Endpoints
+	configPath, _ := cmd.Flags().GetString("config")
+
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	if err := config.ReadInConfig(); err != nil {
+		return cfg, endpoints, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := config.UnmarshalKey("tenancy", &cfg); err != nil {
+		return cfg, endpoints, fmt.Errorf("invalid tenancy configuration: %w", err)
+	}
+	if err := config.UnmarshalKey("tenancy.backends", &endpoints); err != nil {
+		return cfg, endpoints, fmt.Errorf("invalid tenancy backends: %w", err)
+	}
+	if len(cfg.Tenants) == 0 {
+		return cfg, endpoints, fmt.Errorf("no tenants configured in %s", configPath)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, endpoints, err
+	}
+	return cfg, endpoints, nil
+}
+
+func runTenantsRender(cmd *cobra.Command, args []string) error {
+	cfg, endpoints, err := loadTenancyConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(tenantsOutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	var datasources []tenancy.Datasource
+	for _, tenant := range cfg.Tenants {
+		// File provisioning cannot create organizations, so tenants need a known org
+		if tenant.GrafanaOrgID == 0 {
+			fmt.Println(warningStyle.Render(fmt.Sprintf("⚠ %s has no grafana_org_id; run provision-grafana instead", tenant.ID)))
+			continue
+		}
+		datasources = append(datasources, cfg.Datasources(tenant, endpoints, tenant.GrafanaOrgID)...)
+	}
+
+	files := []struct {
+		name   string
+		render func() ([]byte, error)
+	}{
+		{"loki-overrides.yaml", cfg.LokiOverrides},
+		{"mimir-overrides.yaml", cfg.MimirOverrides},
+		{"tempo-overrides.yaml", cfg.TempoOverrides},
+		{"grafana-datasources.yaml", func() ([]byte, error) { return tenancy.ProvisioningFile(datasources) }},
+	}
+
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	for _, f := range files {
+		data, err := f.render()
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", f.name, err)
+		}
+		path := filepath.Join(tenantsOutputDir, f.name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Println(successStyle.Render("✓ " + path))
+	}
+
+	fmt.Printf("\nRendered configuration for %d tenant(s). Point Loki and Mimir runtime_config.file\n", len(cfg.Tenants))
+	fmt.Println("and Tempo overrides.per_tenant_override_config at the overrides files.")
+	return nil
+}
+
+func runTenantsProvision(cmd *cobra.Command, args []string) error {
+	cfg, endpoints, err := loadTenancyConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	password := tenantsGrafanaPass
+	if password == "" {
+		password = os.Getenv("GRAFANA_ADMIN_PASSWORD")
+	}
+	if password == "" {
+		return fmt.Errorf("grafana admin password is required (--password or GRAFANA_ADMIN_PASSWORD)")
+	}
+
+	provisioner := &tenancy.GrafanaProvisioner{
+		URL:      tenantsGrafanaURL,
+		Username: tenantsGrafanaUser,
+		Password: password,
+	}
+
+	ctx, tracker, cancel := startProgress(cmd, "grafana provisioning", 2*time.Minute)
+	defer cancel()
+
+	// Canceling removes the organizations and datasources created so far
+	orgs, err := provisioner.Provision(ctx, cfg, endpoints)
+	err = tracker.Finish(err)
+	if ctx.Err() != nil {
+		return err
+	}
+
+	successStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	for _, tenant := range cfg.Tenants {
+		if orgID, ok := orgs[tenant.ID]; ok {
+			fmt.Println(successStyle.Render(fmt.Sprintf("✓ %s → Grafana org %d (grafana_org_id)", tenant.ID, orgID)))
+		}
+	}
+	return err
+}
@@ -7,16 +7,23 @@ import (
 	"strings"
 	"time"
 
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/apmconfig"
+	"github.com/chaksack/apm/pkg/webhook"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var TestCmd = &cobra.Command{
-	Use:   "test",
-	Short: "Validate APM configuration and perform health checks",
+	Use:         "test",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Validate APM configuration and perform health checks",
 	Long: `Validate the APM configuration file and perform connectivity tests for all configured tools.
-This includes checking syntax, required parameters, and testing connections to Prometheus, Grafana, Jaeger, and Loki.`,
+This includes checking syntax, required parameters, and testing connections to Prometheus, Grafana, Jaeger, and Loki.
+
+Use --connectivity to add DNS resolution timing, proxy detection, MTU checks,
+clock offsets, and traceroute-style hop analysis for every configured endpoint.`,
 	RunE: runTest,
 }
 
@@ -68,6 +75,10 @@ func runTest(cmd *cobra.Command, args []string) error {
 	results = append(results, validationTest)
 	renderTestResult(validationTest, passStyle, failStyle)
 
+	schemaTest := testSchemaVersion(config)
+	results = append(results, schemaTest)
+	renderTestResult(schemaTest, passStyle, failStyle)
+
 	// Test 3: Prometheus connectivity
 	if config.GetBool("apm.prometheus.enabled") {
 		promTest := testPrometheus(config)
@@ -108,6 +119,12 @@ func runTest(cmd *cobra.Command, args []string) error {
 	results = append(results, appTest)
 	renderTestResult(appTest, passStyle, failStyle)
 
+	// Test 9: Network path diagnostics
+	if connectivity, _ := cmd.Flags().GetBool("connectivity"); connectivity {
+		maxHops, _ := cmd.Flags().GetInt("max-hops")
+		results = append(results, runConnectivityDiagnostics(config, maxHops, passStyle, failStyle)...)
+	}
+
 	// Summary
 	passed := 0
 	failed := 0
@@ -130,6 +147,20 @@ func runTest(cmd *cobra.Command, args []string) error {
 		fmt.Println(summaryStyle.Foreground(lipgloss.Color("196")).Render(
 			fmt.Sprintf("❌ Some tests failed: %d passed, %d failed", passed, failed)))
 		fmt.Println("\nPlease fix the issues above before running your application.")
+
+		var failures []map[string]interface{}
+		for _, r := range results {
+			if !r.passed {
+				failures = append(failures, map[string]interface{}{"test": r.name, "message": r.message})
+			}
+		}
+		emitWebhook(loadWebhooks(config), webhook.Event{
+			Type:    webhook.EventTestFailed,
+			Source:  "apm test",
+			Subject: config.GetString("project.name"),
+			Status:  "failed",
+			Data:    map[string]interface{}{"passed": passed, "failed": failed, "failures": failures},
+		})
 	}
 
 	return nil
@@ -204,6 +235,24 @@ func testRequiredFields(config *viper.Viper) testResult {
 	}
 }
 
+func testSchemaVersion(config *viper.Viper) testResult {
+	result := testResult{name: "Configuration schema version"}
+	data, err := os.ReadFile(config.ConfigFileUsed())
+	if err == nil {
+		var v int
+		v, err = apmconfig.Version(data)
+		if err == nil && v < apmconfig.CurrentVersion {
+			err = fmt.Errorf("version %d is older than %d; run 'apm config migrate'", v, apmconfig.CurrentVersion)
+		}
+	}
+	if err != nil {
+		result.status, result.message = "FAIL", err.Error()
+		return result
+	}
+	result.status, result.passed = "PASS", true
+	return result
+}
+
 func testPrometheus(config *viper.Viper) testResult {
 	port := config.GetInt("apm.prometheus.port")
 	if port == 0 {
@@ -413,4 +462,6 @@ func testApplicationEntry(config *viper.Viper) testResult {
 
 func init() {
 	TestCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	TestCmd.Flags().Bool("connectivity", false, "Run DNS, proxy, MTU, and network path diagnostics for configured endpoints")
+	TestCmd.Flags().Int("max-hops", 20, "Maximum hops for network path analysis (0 disables hop analysis)")
 }
@@ -0,0 +1,487 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/chaksack/apm/pkg/netaddr"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/viper"
+)
+
+// connectivityTarget is an endpoint taken from apm.yaml that should be reachable
+type connectivityTarget struct {
+	name   string
+	rawURL string
+	host   string
+	port   string
+}
+
+// networkHop is a single hop reported by the system traceroute/tracepath tool
+type networkHop struct {
+	number  int
+	address string
+	rtt     string
+	timeout bool
+}
+
+// connectivityDiagnostics holds the network path analysis for one endpoint
+type connectivityDiagnostics struct {
+	target      connectivityTarget
+	dnsDuration time.Duration
+	addresses   []string
+	dnsErr      error
+	proxy       string
+	tcpDuration time.Duration
+	tcpErr      error
+	iface       string
+	mtu         int
+	pathMTU     int
+	hops        []networkHop
+	hopTool     string
+	clock       *instrumentation.ClockSkew
+	suggestions []string
+}
+
+const (
+	slowDNSThreshold = 500 * time.Millisecond
+	standardMTU      = 1500
+)
+
+// collectConnectivityTargets gathers the endpoints configured in apm.yaml
+func collectConnectivityTargets(config *viper.Viper) []connectivityTarget {
+	defaults := map[string]int{
+		"prometheus": 9090,
+		"grafana":    3000,
+		"jaeger":     16686,
+		"loki":       3100,
+	}
+
+	var targets []connectivityTarget
+	for _, tool := range []string{"prometheus", "grafana", "jaeger", "loki"} {
+		if !config.GetBool("apm." + tool + ".enabled") {
+			continue
+		}
+
+		endpoint := config.GetString("apm." + tool + ".endpoint")
+		if endpoint == "" {
+			port := config.GetInt("apm." + tool + ".port")
+			if tool == "jaeger" && config.GetInt("apm.jaeger.ui_port") != 0 {
+				port = config.GetInt("apm.jaeger.ui_port")
+			}
+			if port == 0 {
+				port = defaults[tool]
+			}
+			endpoint = fmt.Sprintf("http://localhost:%d", port)
+		}
+
+		if t, err := parseConnectivityTarget(tool, endpoint); err != nil {
+			skipConnectivityTarget(tool, err)
+		} else {
+			targets = append(targets, t)
+		}
+	}
+
+	if webhook := config.GetString("notifications.slack.webhook_url"); webhook != "" && config.GetBool("notifications.slack.enabled") {
+		if t, err := parseConnectivityTarget("slack", webhook); err != nil {
+			skipConnectivityTarget("slack", err)
+		} else {
+			targets = append(targets, t)
+		}
+	}
+
+	// Additional endpoints such as OTLP collectors or cloud APIs
+	for name, endpoint := range config.GetStringMapString("connectivity.endpoints") {
+		if t, err := parseConnectivityTarget(name, endpoint); err != nil {
+			skipConnectivityTarget(name, err)
+		} else {
+			targets = append(targets, t)
+		}
+	}
+
+	return targets
+}
+
+// skipConnectivityTarget warns about an endpoint that cannot be diagnosed
+func skipConnectivityTarget(name string, err error) {
+	warnStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	fmt.Println(warnStyle.Render(fmt.Sprintf("  ⚠ Skipping %s: %v", name, err)))
+}
+
+// parseConnectivityTarget turns a URL or host:port into a connectivity target
+func parseConnectivityTarget(name, endpoint string) (connectivityTarget, error) {
+	raw := endpoint
+	if strings.Contains(raw, "://") {
+		if err := netaddr.ValidateURL(raw); err != nil {
+			return connectivityTarget{}, err
+		}
+	} else {
+		if err := netaddr.ValidateAddress(raw); err != nil {
+			return connectivityTarget{}, err
+		}
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return connectivityTarget{}, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return connectivityTarget{
+		name:   name,
+		rawURL: raw,
+		host:   u.Hostname(),
+		port:   port,
+	}, nil
+}
+
+// diagnoseConnectivity runs DNS, proxy, TCP, MTU, and path checks for a target
+func diagnoseConnectivity(ctx context.Context, target connectivityTarget, maxHops int) connectivityDiagnostics {
+	diag := connectivityDiagnostics{target: target}
+
+	// DNS resolution timing
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, target.host)
+	diag.dnsDuration = time.Since(start)
+	diag.addresses = addrs
+	diag.dnsErr = err
+
+	// Proxy detection from HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+	if req, err := http.NewRequest(http.MethodGet, target.rawURL, nil); err == nil {
+		if proxyURL, err := http.ProxyFromEnvironment(req); err == nil && proxyURL != nil {
+			diag.proxy = proxyURL.Redacted()
+		}
+	}
+
+	// TCP reachability
+	dialer := netaddr.Dialer(3 * time.Second)
+	start = time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.host, target.port))
+	diag.tcpDuration = time.Since(start)
+	diag.tcpErr = err
+	if conn != nil {
+		conn.Close()
+	}
+
+	// Clock offset from the HTTP Date header, which exporters and backends
+	// send on every response
+	if diag.tcpErr == nil && strings.HasPrefix(target.rawURL, "http") {
+		source := &instrumentation.HTTPDateSource{URL: target.rawURL, Client: &http.Client{Timeout: 3 * time.Second}}
+		if offset, uncertainty, err := source.Offset(ctx); err == nil {
+			diag.clock = &instrumentation.ClockSkew{Source: source.Name(), Offset: offset, Uncertainty: uncertainty}
+		}
+	}
+
+	// Interface MTU for the route towards the target
+	diag.iface, diag.mtu = routeInterfaceMTU(target.host, target.port)
+
+	// Hop analysis, skipped for loopback targets where it adds nothing
+	if !isLoopbackHost(target.host, addrs) && maxHops > 0 {
+		diag.hops, diag.pathMTU, diag.hopTool = traceRoute(ctx, target.host, maxHops)
+	}
+
+	diag.suggestions = connectivitySuggestions(diag)
+	return diag
+}
+
+// routeInterfaceMTU finds the local interface used to reach the host and its MTU
+func routeInterfaceMTU(host, port string) (string, int) {
+	// A UDP "connection" performs a route lookup without sending packets
+	conn, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return "", 0
+	}
+	defer conn.Close()
+
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", 0
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(localIP) {
+				return iface.Name, iface.MTU
+			}
+		}
+	}
+
+	return "", 0
+}
+
+var (
+	hopLinePattern = regexp.MustCompile(`^\s*(\d+)[?:]?\s+(.*)$`)
+	pmtuPattern    = regexp.MustCompile(`pmtu (\d+)`)
+)
+
+// traceRoute runs tracepath or traceroute, whichever is available, and parses the hops
+func traceRoute(ctx context.Context, host string, maxHops int) ([]networkHop, int, string) {
+	traceCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	var tool string
+	if path, err := exec.LookPath("tracepath"); err == nil {
+		tool = "tracepath"
+		cmd = exec.CommandContext(traceCtx, path, "-n", "-m", strconv.Itoa(maxHops), host)
+	} else if path, err := exec.LookPath("traceroute"); err == nil {
+		tool = "traceroute"
+		cmd = exec.CommandContext(traceCtx, path, "-n", "-q", "1", "-w", "1", "-m", strconv.Itoa(maxHops), host)
+	} else {
+		return nil, 0, ""
+	}
+
+	output, _ := cmd.Output()
+
+	var hops []networkHop
+	pathMTU := 0
+	seen := make(map[int]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := pmtuPattern.FindStringSubmatch(line); m != nil {
+			pathMTU, _ = strconv.Atoi(m[1])
+		}
+
+		m := hopLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[1])
+		if seen[number] {
+			continue
+		}
+
+		fields := strings.Fields(m[2])
+		if len(fields) == 0 {
+			continue
+		}
+
+		hop := networkHop{number: number}
+		if fields[0] == "*" || strings.HasPrefix(fields[0], "no") {
+			hop.timeout = true
+		} else {
+			hop.address = fields[0]
+			for _, f := range fields[1:] {
+				if strings.HasSuffix(f, "ms") {
+					hop.rtt = f
+					break
+				}
+				if _, err := strconv.ParseFloat(f, 64); err == nil {
+					hop.rtt = f + "ms"
+					break
+				}
+			}
+		}
+
+		seen[number] = true
+		hops = append(hops, hop)
+	}
+
+	return hops, pathMTU, tool
+}
+
+// isLoopbackHost reports whether the host resolves only to loopback addresses
+func isLoopbackHost(host string, addrs []string) bool {
+	if host == "localhost" {
+		return true
+	}
+	if len(addrs) == 0 {
+		return false
+	}
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip == nil || !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// connectivitySuggestions turns raw diagnostics into actionable advice
+func connectivitySuggestions(d connectivityDiagnostics) []string {
+	var out []string
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(d.dnsErr, &dnsErr) && dnsErr.IsNotFound:
+		out = append(out, fmt.Sprintf("Host %q does not resolve; check the endpoint spelling or your DNS search domains", d.target.host))
+	case d.dnsErr != nil:
+		out = append(out, "DNS lookup failed; verify /etc/resolv.conf or corporate DNS servers are reachable")
+	case d.dnsDuration > slowDNSThreshold:
+		out = append(out, fmt.Sprintf("DNS resolution took %s; consider a local resolver cache or using IP addresses for collectors", d.dnsDuration.Round(time.Millisecond)))
+	}
+
+	if d.proxy != "" && d.tcpErr != nil {
+		out = append(out, fmt.Sprintf("Requests are routed through proxy %s; direct TCP failed, so exporters must honour the proxy or the host must be added to NO_PROXY", d.proxy))
+	}
+
+	if d.tcpErr != nil && d.dnsErr == nil {
+		switch {
+		case errors.Is(d.tcpErr, syscall.ECONNREFUSED):
+			out = append(out, fmt.Sprintf("Connection refused on port %s; the service is not listening or the port is wrong", d.target.port))
+		case isTimeout(d.tcpErr):
+			out = append(out, fmt.Sprintf("Connection to port %s timed out; a firewall or security group is likely dropping traffic", d.target.port))
+		default:
+			out = append(out, fmt.Sprintf("TCP connection failed: %v", d.tcpErr))
+		}
+	}
+
+	if d.clock != nil && d.clock.Exceeds(instrumentation.DefaultClockSkewThreshold) {
+		out = append(out, fmt.Sprintf("Clock differs from %s by %s; sync both hosts with NTP or spans will be misordered in traces", d.target.host, d.clock.Offset.Round(time.Millisecond)))
+	}
+
+	mtu := d.mtu
+	if d.pathMTU > 0 && (mtu == 0 || d.pathMTU < mtu) {
+		mtu = d.pathMTU
+	}
+	if mtu > 0 && mtu < standardMTU && !isLoopbackHost(d.target.host, d.addresses) {
+		out = append(out, fmt.Sprintf("Path MTU is %d (below %d), typical of VPNs or tunnels; reduce OTLP batch sizes or enable compression to avoid fragmentation", mtu, standardMTU))
+	}
+
+	if len(d.hops) > 0 && d.tcpErr != nil {
+		lastResponding := 0
+		lastAddr := ""
+		for _, h := range d.hops {
+			if !h.timeout {
+				lastResponding = h.number
+				lastAddr = h.address
+			}
+		}
+		if lastResponding > 0 && lastResponding < d.hops[len(d.hops)-1].number {
+			out = append(out, fmt.Sprintf("Traffic stops after hop %d (%s); ask the network team about egress rules beyond that router", lastResponding, lastAddr))
+		}
+	}
+
+	return out
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// runConnectivityDiagnostics performs and renders diagnostics for all configured endpoints
+func runConnectivityDiagnostics(config *viper.Viper, maxHops int, passStyle, failStyle lipgloss.Style) []testResult {
+	sectionStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	warnStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+	fmt.Println()
+	fmt.Println(sectionStyle.Render("🌐 Network Path Diagnostics"))
+
+	targets := collectConnectivityTargets(config)
+	if len(targets) == 0 {
+		fmt.Println(dimStyle.Render("  No endpoints configured"))
+		return nil
+	}
+
+	var results []testResult
+	for _, target := range targets {
+		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+		diag := diagnoseConnectivity(ctx, target, maxHops)
+		cancel()
+
+		result := testResult{
+			name:   fmt.Sprintf("Network path: %s (%s)", target.name, net.JoinHostPort(target.host, target.port)),
+			status: "PASS",
+			passed: diag.dnsErr == nil && diag.tcpErr == nil,
+		}
+		if !result.passed {
+			result.status = "FAIL"
+		}
+		results = append(results, result)
+
+		fmt.Println()
+		renderTestResult(result, passStyle, failStyle)
+
+		if diag.dnsErr != nil {
+			fmt.Printf("  ├─ DNS:   %s\n", failStyle.Render(diag.dnsErr.Error()))
+		} else {
+			fmt.Printf("  ├─ DNS:   %s in %s\n", strings.Join(diag.addresses, ", "), diag.dnsDuration.Round(time.Millisecond))
+		}
+
+		if diag.proxy != "" {
+			fmt.Printf("  ├─ Proxy: %s\n", diag.proxy)
+		} else {
+			fmt.Printf("  ├─ Proxy: %s\n", dimStyle.Render("direct (no proxy configured for this host)"))
+		}
+
+		if diag.tcpErr != nil {
+			fmt.Printf("  ├─ TCP:   %s\n", failStyle.Render(diag.tcpErr.Error()))
+		} else {
+			fmt.Printf("  ├─ TCP:   connected in %s\n", diag.tcpDuration.Round(time.Millisecond))
+		}
+
+		if diag.clock != nil {
+			offset := diag.clock.Offset.Round(time.Millisecond).String()
+			if diag.clock.Offset >= 0 {
+				offset = "+" + offset
+			}
+			fmt.Printf("  ├─ Clock: %s ±%s\n", offset, diag.clock.Uncertainty.Round(time.Millisecond))
+		}
+
+		if diag.mtu > 0 {
+			mtuLine := fmt.Sprintf("%d on %s", diag.mtu, diag.iface)
+			if diag.pathMTU > 0 {
+				mtuLine += fmt.Sprintf(", path MTU %d", diag.pathMTU)
+			}
+			fmt.Printf("  ├─ MTU:   %s\n", mtuLine)
+		}
+
+		if len(diag.hops) > 0 {
+			fmt.Printf("  ├─ Hops (%s):\n", diag.hopTool)
+			for _, hop := range diag.hops {
+				if hop.timeout {
+					fmt.Printf("  │   %2d  %s\n", hop.number, dimStyle.Render("* no reply"))
+				} else {
+					fmt.Printf("  │   %2d  %-40s %s\n", hop.number, hop.address, hop.rtt)
+				}
+			}
+		} else if !isLoopbackHost(target.host, diag.addresses) && maxHops > 0 {
+			fmt.Printf("  ├─ Hops:  %s\n", dimStyle.Render("tracepath/traceroute not available"))
+		}
+
+		if len(diag.suggestions) == 0 {
+			fmt.Printf("  └─ %s\n", passStyle.Render("No issues detected"))
+			continue
+		}
+		for i, s := range diag.suggestions {
+			prefix := "├─"
+			if i == len(diag.suggestions)-1 {
+				prefix = "└─"
+			}
+			fmt.Printf("  %s %s\n", prefix, warnStyle.Render("⚠ "+s))
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,251 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/alertrules"
+	"github.com/chaksack/apm/pkg/ruletest"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var testRulesCmd = &cobra.Command{
+	Use:         "rules",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Unit test alerting rules and check scrape targets",
+	Long: `Check the alerting rule files, run their unit tests, and report the alerts
+no test covers, so rule changes stop shipping untested.
+
+Tests use promtool's format: each test feeds the rules synthetic series and
+lists the alerts expected to fire at each evaluation time. They are run by
+promtool from PATH, or from the Prometheus image when docker is available.
+See configs/prometheus/tests for examples.
+
+With --targets the scrape jobs of the Prometheus config are compared with
+the targets a running Prometheus scrapes, and every target whose last
+scrape failed is reported.
+
+Use --format junit or github in CI: JUnit XML feeds test report views, and
+GitHub annotations mark failures on the pull request. With --output the
+report is written to a file and the summary still printed.
+
+Examples:
+  apm test rules
+  apm test rules --require-coverage --format junit -o rule-tests.xml
+  apm test rules --targets --prometheus-url http://prometheus:9090 --format github`,
+	RunE: runTestRules,
+}
+
+var (
+	testRulesFiles    []string
+	testRulesTests    []string
+	testRulesCoverage bool
+	testRulesTargets  bool
+	testRulesScrape   string
+	testRulesPromURL  string
+	testRulesTenant   string
+	testRulesFormat   string
+	testRulesOutput   string
+	testRulesPromtool string
+	testRulesImage    string
+)
+
+func init() {
+	testRulesCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	testRulesCmd.Flags().StringSliceVar(&testRulesFiles, "rules", []string{"configs/prometheus/alerts"}, "Rule files or directories")
+	testRulesCmd.Flags().StringSliceVar(&testRulesTests, "tests", []string{"configs/prometheus/tests"}, "Test files or directories")
+	testRulesCmd.Flags().BoolVar(&testRulesCoverage, "require-coverage", false, "Fail for alerts without a unit test")
+	testRulesCmd.Flags().BoolVar(&testRulesTargets, "targets", false, "Check the scrape targets against a running Prometheus")
+	testRulesCmd.Flags().StringVar(&testRulesScrape, "scrape-config", "configs/prometheus/prometheus.yml", "Prometheus config whose scrape jobs are checked")
+	testRulesCmd.Flags().StringVar(&testRulesPromURL, "prometheus-url", "", "Prometheus URL (default from apm.prometheus.port)")
+	testRulesCmd.Flags().StringVar(&testRulesTenant, "tenant", "", "Tenant ID sent to multi-tenant backends")
+	testRulesCmd.Flags().StringVar(&testRulesFormat, "format", "text", "Report format: text, junit, or github")
+	testRulesCmd.Flags().StringVarP(&testRulesOutput, "output", "o", "", "Write the report to a file")
+	testRulesCmd.Flags().StringVar(&testRulesPromtool, "promtool", "", "promtool binary (default promtool on PATH)")
+	testRulesCmd.Flags().StringVar(&testRulesImage, "promtool-image", ruletest.DefaultPromtoolImage, "Image running promtool when it is not installed")
+	TestCmd.AddCommand(testRulesCmd)
+}
+
+func runTestRules(cmd *cobra.Command, args []string) error {
+	switch testRulesFormat {
+	case "text", "junit", "github":
+	default:
+		return fmt.Errorf("unknown --format %q; expected text, junit, or github", testRulesFormat)
+	}
+
+	ruleFiles, err := ruletest.FindFiles(testRulesFiles...)
+	if err != nil {
+		return err
+	}
+	testFiles, err := ruletest.FindFiles(testRulesTests...)
+	if err != nil {
+		return err
+	}
+	if len(ruleFiles) == 0 {
+		return fmt.Errorf("no rule files found in %s", strings.Join(testRulesFiles, ", "))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	promtool := &ruletest.Promtool{Path: testRulesPromtool, Image: testRulesImage}
+	report := &ruletest.Report{}
+
+	results, err := promtool.CheckRules(ctx, ruleFiles)
+	if err != nil {
+		return err
+	}
+	report.Add(results...)
+	if results, err = promtool.TestRules(ctx, testFiles); err != nil {
+		return err
+	}
+	report.Add(results...)
+
+	rs, err := alertrules.Load(ruleFiles...)
+	if err != nil {
+		return err
+	}
+	var alerts []ruletest.Alert
+	for _, r := range rs.Rules(nil) {
+		alerts = append(alerts, ruletest.Alert{Name: r.Alert, File: r.File})
+	}
+	tested, err := ruletest.TestedAlerts(testFiles)
+	if err != nil {
+		return err
+	}
+	report.Add(ruletest.Coverage(alerts, tested, testRulesCoverage)...)
+
+	if testRulesTargets {
+		if testRulesPromURL == "" {
+			configPath, _ := cmd.Flags().GetString("config")
+			config := viper.New()
+			config.SetConfigFile(configPath)
+			_ = config.ReadInConfig()
+			port := config.GetInt("apm.prometheus.port")
+			if port == 0 {
+				port = 9090
+			}
+			testRulesPromURL = fmt.Sprintf("http://localhost:%d", port)
+		}
+		result, err := promtool.CheckConfig(ctx, testRulesScrape)
+		if err != nil {
+			return err
+		}
+		report.Add(result)
+
+		client := &http.Client{Timeout: 30 * time.Second}
+		if testRulesTenant != "" {
+			client = tenancy.NewClient(client, testRulesTenant)
+		}
+		results, err := ruletest.CheckTargets(ctx, client, testRulesPromURL, testRulesScrape)
+		if err != nil {
+			return err
+		}
+		report.Add(results...)
+	}
+
+	if err := writeRuleTestReport(cmd, report, testRulesFormat, testRulesOutput); err != nil {
+		return err
+	}
+	if failed := report.Count(ruletest.StatusFailed); failed > 0 {
+		return fmt.Errorf("%d of %d check(s) failed", failed, len(report.Results))
+	}
+	return nil
+}
+
+// writeRuleTestReport writes the report as JSON with --json, or in the
+// chosen format, to --output when set; the text summary is printed unless
+// the report itself went to stdout
+func writeRuleTestReport(cmd *cobra.Command, report *ruletest.Report, format, output string) error {
+	jsonOut, _ := cmd.Flags().GetBool("json")
+	if !jsonOut && format == "text" {
+		if output != "" {
+			return fmt.Errorf("--output needs --format junit or github, or --json")
+		}
+		printRuleTestReport(report)
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	var err error
+	switch {
+	case jsonOut:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	case format == "junit":
+		err = report.WriteJUnit(out)
+	default:
+		err = report.WriteGitHub(out)
+	}
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		printRuleTestReport(report)
+	}
+	return nil
+}
+
+func printRuleTestReport(report *ruletest.Report) {
+	titles := map[string]string{
+		ruletest.SuiteRules:    "Rule files",
+		ruletest.SuiteTests:    "Unit tests",
+		ruletest.SuiteCoverage: "Coverage",
+		ruletest.SuiteConfig:   "Prometheus config",
+		ruletest.SuiteTargets:  "Scrape targets",
+		suiteVerify:            "Deploy verification",
+	}
+	suite := ""
+	for _, r := range report.Results {
+		if r.Suite != suite {
+			suite = r.Suite
+			fmt.Println(theme.Title.Render(titles[suite]))
+		}
+		sev := severityOK
+		switch r.Status {
+		case ruletest.StatusFailed:
+			sev = severityError
+		case ruletest.StatusSkipped:
+			sev = severityMuted
+		}
+		message := r.Message
+		if r.Status == ruletest.StatusPassed && r.Suite == ruletest.SuiteTests {
+			message = ""
+		}
+		first, rest, _ := strings.Cut(message, "\n")
+		fmt.Printf("  %s %s\n", theme.Mark(sev, r.Name, 40), theme.Dim.Render(first))
+		for _, line := range strings.Split(rest, "\n") {
+			if line != "" {
+				fmt.Printf("      %s\n", line)
+			}
+		}
+	}
+
+	passed, failed, skipped := report.Count(ruletest.StatusPassed), report.Count(ruletest.StatusFailed), report.Count(ruletest.StatusSkipped)
+	summary := fmt.Sprintf("%s passed, %s failed, %s skipped",
+		display.Int(int64(passed)), display.Int(int64(failed)), display.Int(int64(skipped)))
+	fmt.Println()
+	if failed > 0 {
+		fmt.Println(theme.Mark(severityError, summary, 0))
+	} else {
+		fmt.Println(theme.Mark(severityOK, summary, 0))
+	}
+}
@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/replay"
+	"github.com/chaksack/apm/pkg/ruletest"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// suiteVerify groups the replayed scenarios in rule test reports
+const suiteVerify = "verify"
+
+var testVerifyCmd = &cobra.Command{
+	Use:         "verify [scenario files or directories]",
+	Annotations: needs(access.ScopeViewMetrics, ""),
+	Short:       "Replay recorded incidents through deploy verification",
+	Long: `Replay recorded incidents through deploy verification offline, so its
+thresholds can be tested against historical incidents before it is trusted
+to roll releases back.
+
+A scenario names a service, its deploys, the time verification ran, and the
+release scorer thresholds under test, with the Prometheus query results and
+failing trace spans recorded around the incident. Replaying it scores the
+release, runs the health check deployment verification runs, summarizes the
+incident from its alerts, and compares the outcome with the scenario's
+expectations: status, score range, whether the release is rolled back,
+regressions reported, and deploys and failing traces the incident summary
+correlates. See configs/replay for an example.
+
+Queries are matched by their PromQL and time, so changing a threshold that
+changes the queries, such as the window, reports the unrecorded queries as
+failures. Record a scenario with --record: it is run against a live
+Prometheus, and Jaeger when the scenario has alerts, and its queries and
+traces are replaced with their answers. Expectations are kept; comments
+are not.
+
+Results use the report formats of apm test rules.
+
+Examples:
+  apm test verify
+  apm test verify configs/replay/checkout-error-spike.yml --format junit -o verify.xml
+  apm test verify incident.yml --record --prometheus-url http://prometheus:9090`,
+	RunE: runTestVerify,
+}
+
+var (
+	testVerifyRecord    bool
+	testVerifyPromURL   string
+	testVerifyJaegerURL string
+	testVerifyTenant    string
+	testVerifyFormat    string
+	testVerifyOutput    string
+)
+
+func init() {
+	testVerifyCmd.Flags().StringP("config", "c", "apm.yaml", "Path to configuration file")
+	testVerifyCmd.Flags().BoolVar(&testVerifyRecord, "record", false, "Record the scenarios' queries and traces from live backends")
+	testVerifyCmd.Flags().StringVar(&testVerifyPromURL, "prometheus-url", "", "Prometheus URL to record from (default from apm.prometheus.port)")
+	testVerifyCmd.Flags().StringVar(&testVerifyJaegerURL, "jaeger-url", "", "Jaeger query URL to record from (default from apm.jaeger.ui_port)")
+	testVerifyCmd.Flags().StringVar(&testVerifyTenant, "tenant", "", "Tenant ID sent to multi-tenant backends")
+	testVerifyCmd.Flags().StringVar(&testVerifyFormat, "format", "text", "Report format: text, junit, or github")
+	testVerifyCmd.Flags().StringVarP(&testVerifyOutput, "output", "o", "", "Write the report to a file")
+	TestCmd.AddCommand(testVerifyCmd)
+}
+
+func runTestVerify(cmd *cobra.Command, args []string) error {
+	switch testVerifyFormat {
+	case "text", "junit", "github":
+	default:
+		return fmt.Errorf("unknown --format %q; expected text, junit, or github", testVerifyFormat)
+	}
+	if len(args) == 0 {
+		args = []string{"configs/replay"}
+	}
+	files, err := ruletest.FindFiles(args...)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no scenarios found in %s", strings.Join(args, ", "))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if testVerifyRecord {
+		return recordScenarios(ctx, cmd, files)
+	}
+
+	report := &ruletest.Report{}
+	for _, file := range files {
+		s, err := replay.Load(file)
+		if err != nil {
+			return err
+		}
+		result, err := replay.Run(ctx, s)
+		if err != nil {
+			report.Add(ruletest.Result{Suite: suiteVerify, Name: s.Name, File: file, Status: ruletest.StatusFailed, Message: err.Error()})
+			continue
+		}
+		report.Add(verifyResult(file, result))
+	}
+
+	if err := writeRuleTestReport(cmd, report, testVerifyFormat, testVerifyOutput); err != nil {
+		return err
+	}
+	if failed := report.Count(ruletest.StatusFailed); failed > 0 {
+		return fmt.Errorf("%d of %d scenario(s) failed", failed, len(report.Results))
+	}
+	return nil
+}
+
+// verifyResult reports a replayed scenario with the verification outcome
+// first, then each unmet expectation and unrecorded query
+func verifyResult(file string, r *replay.Result) ruletest.Result {
+	outcome := fmt.Sprintf("%s, score %d", r.Health.Status, r.Health.Score)
+	if r.Rollback {
+		outcome += ", rolled back"
+	}
+	result := ruletest.Result{Suite: suiteVerify, Name: r.Scenario, File: file, Status: ruletest.StatusPassed, Message: outcome, Duration: r.Duration}
+	if r.Passed() {
+		return result
+	}
+	result.Status = ruletest.StatusFailed
+	lines := []string{outcome}
+	lines = append(lines, r.Failures...)
+	for _, q := range r.Missing {
+		lines = append(lines, "not recorded: "+q)
+	}
+	result.Message = strings.Join(lines, "\n")
+	return result
+}
+
+// recordScenarios replaces the recorded answers of the scenario files with
+// those of the live backends
+func recordScenarios(ctx context.Context, cmd *cobra.Command, files []string) error {
+	configPath, _ := cmd.Flags().GetString("config")
+	config := viper.New()
+	config.SetConfigFile(configPath)
+	_ = config.ReadInConfig()
+	if testVerifyPromURL == "" {
+		port := config.GetInt("apm.prometheus.port")
+		if port == 0 {
+			port = 9090
+		}
+		testVerifyPromURL = fmt.Sprintf("http://localhost:%d", port)
+	}
+	if testVerifyJaegerURL == "" && config.GetBool("apm.jaeger.enabled") {
+		testVerifyJaegerURL = fmt.Sprintf("http://localhost:%d", config.GetInt("apm.jaeger.ui_port"))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if testVerifyTenant != "" {
+		client = tenancy.NewClient(client, testVerifyTenant)
+	}
+	var traces lookup.TraceSearcher
+	if testVerifyJaegerURL != "" {
+		traces = &lookup.Jaeger{URL: testVerifyJaegerURL, Client: client}
+	}
+
+	for _, file := range files {
+		s, err := replay.Load(file)
+		if err != nil {
+			return err
+		}
+		var scenarioTraces lookup.TraceSearcher
+		if len(s.Alerts) > 0 {
+			scenarioTraces = traces
+		}
+		result, err := replay.Record(ctx, s, client, testVerifyPromURL, scenarioTraces)
+		if err != nil {
+			return fmt.Errorf("failed to record %s: %w", file, err)
+		}
+		if err := s.Save(file); err != nil {
+			return err
+		}
+		fmt.Printf("%s %s: %d queries, %d spans (%s, score %d)\n",
+			theme.Marker(severityOK), file, len(s.Queries), len(s.Traces), result.Health.Status, result.Health.Score)
+	}
+	return nil
+}
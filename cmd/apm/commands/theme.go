@@ -0,0 +1,210 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/spf13/cobra"
+)
+
+// themeEnv selects the output theme when --theme is not set
+const themeEnv = "APM_THEME"
+
+// severity is the meaning of a status, log level, or span, shown by a marker
+// and a color so it reads without the color
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityOK
+	severityPending
+	severityWarning
+	severityError
+	severityMuted
+)
+
+// outputTheme holds the styles and markers of terminal output
+type outputTheme struct {
+	Title lipgloss.Style
+	Dim   lipgloss.Style
+
+	styles  map[severity]lipgloss.Style
+	markers map[severity]string
+}
+
+// unicodeMarkers differ in shape, not only in color
+var unicodeMarkers = map[severity]string{
+	severityInfo:    "•",
+	severityOK:      "✓",
+	severityPending: "◔",
+	severityWarning: "▲",
+	severityError:   "✗",
+	severityMuted:   "○",
+}
+
+// asciiMarkers read well in any font and through screen readers
+var asciiMarkers = map[severity]string{
+	severityInfo:    "*",
+	severityOK:      "+",
+	severityPending: "~",
+	severityWarning: "!",
+	severityError:   "x",
+	severityMuted:   "-",
+}
+
+func fg(color string) lipgloss.Style {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(color))
+}
+
+// themes are the selectable output themes
+var themes = map[string]*outputTheme{
+	"default": {
+		// Green, yellow, and red on a dark terminal
+		Title: fg("86").Bold(true),
+		Dim:   fg("241"),
+		styles: map[severity]lipgloss.Style{
+			severityInfo:    fg("86"),
+			severityOK:      fg("42"),
+			severityPending: fg("214"),
+			severityWarning: fg("214"),
+			severityError:   fg("196").Bold(true),
+			severityMuted:   fg("241"),
+		},
+		markers: unicodeMarkers,
+	},
+	"colorblind": {
+		// The Okabe-Ito blue, orange, and vermillion, safe for red-green
+		// color blindness
+		Title: fg("#56B4E9").Bold(true),
+		Dim:   fg("245"),
+		styles: map[severity]lipgloss.Style{
+			severityInfo:    fg("#56B4E9"),
+			severityOK:      fg("#0072B2").Bold(true),
+			severityPending: fg("#F0E442"),
+			severityWarning: fg("#E69F00"),
+			severityError:   fg("#D55E00").Bold(true).Underline(true),
+			severityMuted:   fg("245"),
+		},
+		markers: unicodeMarkers,
+	},
+	"high-contrast": {
+		// Bright colors and bold text for low vision, errors in reverse
+		Title: fg("15").Bold(true).Underline(true),
+		Dim:   fg("250"),
+		styles: map[severity]lipgloss.Style{
+			severityInfo:    fg("14").Bold(true),
+			severityOK:      fg("10").Bold(true),
+			severityPending: fg("11").Bold(true),
+			severityWarning: fg("11").Bold(true),
+			severityError:   lipgloss.NewStyle().Foreground(lipgloss.Color("15")).Background(lipgloss.Color("9")).Bold(true),
+			severityMuted:   fg("250"),
+		},
+		markers: unicodeMarkers,
+	},
+	"plain": {
+		// No color and ASCII markers, for screen readers and logs
+		Title:   lipgloss.NewStyle(),
+		Dim:     lipgloss.NewStyle(),
+		styles:  map[severity]lipgloss.Style{},
+		markers: asciiMarkers,
+	},
+}
+
+// theme styles the terminal output of the commands
+var theme = themes["default"]
+
+// SetupTheme selects the theme from --theme or APM_THEME, and drops colors
+// with --no-color or a set NO_COLOR (https://no-color.org) while keeping the
+// markers
+func SetupTheme(cmd *cobra.Command) error {
+	name, _ := cmd.Flags().GetString("theme")
+	if name == "" {
+		name = os.Getenv(themeEnv)
+	}
+	if name == "" {
+		name = "default"
+	}
+	selected, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, use one of %s", name, strings.Join(themeNames(), ", "))
+	}
+	theme = selected
+
+	if noColor, _ := cmd.Flags().GetBool("no-color"); noColor || os.Getenv("NO_COLOR") != "" {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+	return nil
+}
+
+func themeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Style returns the style of a severity
+func (t *outputTheme) Style(s severity) lipgloss.Style {
+	return t.styles[s]
+}
+
+// Marker returns the marker of a severity
+func (t *outputTheme) Marker(s severity) string {
+	return t.markers[s]
+}
+
+// Mark renders text after the marker of its severity, padded to width
+// before styling so that columns stay aligned
+func (t *outputTheme) Mark(s severity, text string, width int) string {
+	return t.styles[s].Render(fmt.Sprintf("%-*s", width, t.markers[s]+" "+text))
+}
+
+// statusSeverity maps a deployment or component status to a severity
+func statusSeverity(status string) severity {
+	switch strings.ToLower(status) {
+	case "running", "deployed", "active", "healthy":
+		return severityOK
+	case "pending", "deploying", "starting", "updating":
+		return severityPending
+	case "failed", "error", "crashed", "unhealthy":
+		return severityError
+	case "stopped", "terminated", "completed":
+		return severityMuted
+	default:
+		return severityInfo
+	}
+}
+
+// healthSeverity maps a health to a severity
+func healthSeverity(health string) severity {
+	switch strings.ToLower(health) {
+	case "healthy":
+		return severityOK
+	case "degraded", "warning":
+		return severityWarning
+	case "unhealthy", "critical":
+		return severityError
+	default:
+		return severityMuted
+	}
+}
+
+// levelSeverity maps a log level to a severity
+func levelSeverity(level string) severity {
+	switch strings.ToLower(level) {
+	case "debug", "trace":
+		return severityMuted
+	case "warn", "warning":
+		return severityWarning
+	case "error", "fatal", "panic", "critical":
+		return severityError
+	default:
+		return severityInfo
+	}
+}
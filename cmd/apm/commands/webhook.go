@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chaksack/apm/pkg/webhook"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/viper"
+)
+
+// webhookTimeout bounds the delivery of a lifecycle event, including retries
+const webhookTimeout = 30 * time.Second
+
+// loadWebhooks returns an emitter for the webhooks configured in apm.yaml,
+// or nil when none are configured or the configuration is invalid
+func loadWebhooks(config *viper.Viper) *webhook.Emitter {
+	var endpoints []webhook.Endpoint
+	if err := config.UnmarshalKey("webhooks", &endpoints); err != nil || len(endpoints) == 0 {
+		return nil
+	}
+
+	emitter, err := webhook.New(endpoints...)
+	if err != nil {
+		warnWebhook(err)
+		return nil
+	}
+	return emitter
+}
+
+// emitWebhook delivers a lifecycle event. Delivery failures are reported as
+// warnings and never fail the command that emitted the event.
+func emitWebhook(emitter *webhook.Emitter, event webhook.Event) {
+	if emitter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	if err := emitter.Emit(ctx, event); err != nil {
+		warnWebhook(err)
+	}
+}
+
+func warnWebhook(err error) {
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	fmt.Fprintln(os.Stderr, warningStyle.Render(fmt.Sprintf("⚠ webhook: %v", err)))
+}
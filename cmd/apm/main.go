@@ -29,6 +29,20 @@ Examples:
   apm dashboard               # Access monitoring tools
   apm deploy                  # Deploy to cloud with APM`,
 	Version: "1.0.0",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := commands.SetupTheme(cmd); err != nil {
+			return err
+		}
+		// Migrating is how the warning is resolved
+		if cmd.Parent() != commands.ConfigCmd {
+			configPath, _ := cmd.Flags().GetString("config")
+			commands.WarnConfigVersion(configPath)
+		}
+		if err := commands.SetupDisplay(cmd); err != nil {
+			return err
+		}
+		return commands.CheckAccess(cmd)
+	},
 }
 
 func main() {
@@ -47,6 +61,24 @@ func init() {
 	rootCmd.AddCommand(commands.DeployCmd)
 	rootCmd.AddCommand(commands.LogsCmd)
 	rootCmd.AddCommand(commands.StatusCmd)
+	rootCmd.AddCommand(commands.ComplianceCmd)
+	rootCmd.AddCommand(commands.RetentionCmd)
+	rootCmd.AddCommand(commands.TenantsCmd)
+	rootCmd.AddCommand(commands.LookupCmd)
+	rootCmd.AddCommand(commands.CloudCmd)
+	rootCmd.AddCommand(commands.McpCmd)
+	rootCmd.AddCommand(commands.ForecastCmd)
+	rootCmd.AddCommand(commands.AutoscaleCmd)
+	rootCmd.AddCommand(commands.DependenciesCmd)
+	rootCmd.AddCommand(commands.DoctorCmd)
+	rootCmd.AddCommand(commands.OpenAPICmd)
+	rootCmd.AddCommand(commands.DemoCmd)
+	rootCmd.AddCommand(commands.MigrateCmd)
+	rootCmd.AddCommand(commands.ConfigCmd)
+	rootCmd.AddCommand(commands.GcCmd)
+	rootCmd.AddCommand(commands.AlertsCmd)
+	rootCmd.AddCommand(commands.ExportCmd)
+	rootCmd.AddCommand(commands.StatusPageCmd)
 
 	// Configure root command
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
@@ -57,5 +89,9 @@ func init() {
 	rootCmd.PersistentFlags().String("config", "apm.yaml", "Path to configuration file")
 	rootCmd.PersistentFlags().Bool("json", false, "Output in JSON format")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Enable verbose output")
-	rootCmd.PersistentFlags().Bool("no-color", false, "Disable colored output")
+	rootCmd.PersistentFlags().Bool("no-color", false, "Disable colored output (also NO_COLOR)")
+	rootCmd.PersistentFlags().String("theme", "", "Output theme: default, colorblind, high-contrast, or plain (default $APM_THEME or default)")
+	rootCmd.PersistentFlags().String("timezone", "", "Timezone of the times shown, e.g. Europe/Berlin or UTC (default $APM_TIMEZONE, $TZ, or local)")
+	rootCmd.PersistentFlags().String("locale", "", "Locale of the dates and numbers shown, e.g. en-GB or de-DE (default $APM_LOCALE or $LANG)")
+	rootCmd.PersistentFlags().Bool("read-only", false, "Refuse commands that change deployments, configuration, or alerting (also APM_READ_ONLY)")
 }
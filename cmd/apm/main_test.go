@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chaksack/apm/cmd/apm/commands"
+	"github.com/spf13/cobra"
+)
+
+// TestCommandsDeclareScope fails on a command CheckAccess would let through
+// unchecked because neither it nor a parent declares the scope it needs
+func TestCommandsDeclareScope(t *testing.T) {
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			if commands.Scope(sub) == "" {
+				t.Errorf("%s has no scope annotation", sub.CommandPath())
+			}
+			walk(sub)
+		}
+	}
+	walk(rootCmd)
+}
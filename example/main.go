@@ -7,8 +7,6 @@ import (
 	"github.com/chaksack/apm/pkg/instrumentation"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/valyala/fasthttp/fasthttpadaptor"
 	"go.uber.org/zap"
 )
 
@@ -39,10 +37,7 @@ func main() {
 
 	// Prometheus metrics endpoint
 	if cfg.Metrics.Enabled {
-		app.Get(cfg.Metrics.Path, func(c *fiber.Ctx) error {
-			fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())(c.Context())
-			return nil
-		})
+		app.Get(cfg.Metrics.Path, inst.FiberMetricsHandler())
 	}
 
 	// Example endpoints
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"time"
@@ -9,7 +10,12 @@ import (
 	"github.com/chaksack/apm/pkg/cloud"
 )
 
+// planOnly previews the APM monitoring setup instead of creating it
+var planOnly = flag.Bool("plan", false, "Preview the APM monitoring setup without creating resources")
+
 func main() {
+	flag.Parse()
+
 	fmt.Println("CloudWatch Integration Demo")
 	fmt.Println("===========================")
 
@@ -457,9 +463,21 @@ func demonstrateAPMIntegration(ctx context.Context, manager *cloud.CloudWatchMan
 		},
 	}
 
+	apmConfig.Plan = *planOnly
+
 	setup, err := manager.CreateAPMMonitoringSetup(ctx, apmConfig)
 	if err != nil {
 		fmt.Printf("  Error creating APM monitoring setup: %v\n", err)
+		if setup != nil {
+			for _, r := range setup.RolledBack {
+				fmt.Printf("  ↺ Rolled back %s\n", r)
+			}
+		}
+	} else if apmConfig.Plan {
+		fmt.Printf("  Planned APM monitoring setup:\n")
+		for _, change := range setup.Plan {
+			fmt.Printf("    wave %d: %s\n", change.Wave, change)
+		}
 	} else {
 		fmt.Printf("  ✓ Created APM monitoring setup\n")
 		fmt.Printf("  ✓ Dashboards: %d\n", len(setup.Dashboards))
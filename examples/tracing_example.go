@@ -13,12 +13,9 @@ func main() {
 	// Initialize tracer
 	ctx := context.Background()
 	tracerConfig := instrumentation.TracerConfig{
+		Preset:         instrumentation.PresetLocal,
 		ServiceName:    "example-service",
 		ServiceVersion: "1.0.0",
-		Environment:    "development",
-		ExporterType:   "otlp",
-		Endpoint:       "localhost:4317",
-		SampleRate:     1.0,
 	}
 
 	_, cleanup, err := instrumentation.InitTracer(ctx, tracerConfig)
@@ -3,7 +3,17 @@ package config
 import (
 	"fmt"
 	"strings"
-
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/chaksack/apm/pkg/locale"
+	"github.com/chaksack/apm/pkg/residency"
+	"github.com/chaksack/apm/pkg/store"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/chaksack/apm/pkg/webhook"
 	"github.com/spf13/viper"
 )
 
@@ -29,6 +39,45 @@ type Config struct {
 
 	// Service discovery configurations
 	ServiceDiscovery ServiceDiscoveryConfig `mapstructure:"service_discovery"`
+
+	// TLS certificate expiry monitoring
+	CertificateMonitoring CertificateMonitoringConfig `mapstructure:"certificate_monitoring"`
+
+	// Data residency region pinning
+	DataResidency DataResidencyConfig `mapstructure:"data_residency"`
+
+	// Read-only mode and the scopes of API tokens
+	Access access.Policy `mapstructure:"access"`
+
+	// Locale and timezone of times and numbers in chat replies and reports
+	Display DisplayConfig `mapstructure:"display"`
+
+	// Multi-tenant isolation for the shared stack
+	Tenancy TenancyConfig `mapstructure:"tenancy"`
+
+	// Endpoints notified of lifecycle events such as fired alerts
+	Webhooks []webhook.Endpoint `mapstructure:"webhooks"`
+
+	// Chat commands from Slack and Teams
+	ChatOps ChatOpsConfig `mapstructure:"chatops"`
+
+	// Incident summaries of firing alert groups
+	Incidents IncidentsConfig `mapstructure:"incidents"`
+
+	// Health scores of deployed releases
+	Releases ReleasesConfig `mapstructure:"releases"`
+
+	// Reviewed changes to rules, dashboards, and alert routes
+	Changes ChangesConfig `mapstructure:"changes"`
+
+	// Leader election between replicas of the server
+	HA HAConfig `mapstructure:"ha"`
+
+	// Periodic search for orphaned telemetry resources
+	Janitor JanitorConfig `mapstructure:"janitor"`
+
+	// Database holding the server state
+	Storage StorageConfig `mapstructure:"storage"`
 }
 
 // ServerConfig holds GoFiber server configuration
@@ -115,6 +164,157 @@ type ServiceDiscoveryConfig struct {
 	PodSelectors     []string `mapstructure:"pod_selectors"`
 }
 
+// CertificateMonitoringConfig holds TLS certificate expiry monitoring settings
+type CertificateMonitoringConfig struct {
+	Enabled      bool                  `mapstructure:"enabled"`
+	Interval     string                `mapstructure:"interval"`
+	Timeout      string                `mapstructure:"timeout"`
+	WarningDays  int                   `mapstructure:"warning_days"`
+	CriticalDays int                   `mapstructure:"critical_days"`
+	Endpoints    []CertificateEndpoint `mapstructure:"endpoints"`
+}
+
+// CertificateEndpoint describes a TLS endpoint to monitor
+type CertificateEndpoint struct {
+	Name       string `mapstructure:"name"`
+	Address    string `mapstructure:"address"`
+	ServerName string `mapstructure:"server_name"`
+	Kind       string `mapstructure:"kind"`
+}
+
+// Monitor creates the certificate monitor of the configured endpoints and
+// thresholds
+func (c CertificateMonitoringConfig) Monitor() (*instrumentation.CertificateMonitor, error) {
+	interval, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate_monitoring.interval: %w", err)
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate_monitoring.timeout: %w", err)
+	}
+	monitor := instrumentation.CertificateMonitorConfig{
+		WarningThreshold:  time.Duration(c.WarningDays) * 24 * time.Hour,
+		CriticalThreshold: time.Duration(c.CriticalDays) * 24 * time.Hour,
+		Timeout:           timeout,
+		Interval:          interval,
+	}
+	for _, ep := range c.Endpoints {
+		monitor.Endpoints = append(monitor.Endpoints, instrumentation.CertificateEndpoint(ep))
+	}
+	return instrumentation.NewCertificateMonitor(monitor), nil
+}
+
+// DataResidencyConfig holds allowed regions per environment
+type DataResidencyConfig struct {
+	Environment      string `mapstructure:"environment"`
+	AuditLog         string `mapstructure:"audit_log"`
+	residency.Policy `mapstructure:",squash"`
+}
+
+// Guard creates a residency guard for the configured environment, writing
+// violations and overrides to the audit log when one is set
+func (c DataResidencyConfig) Guard() *residency.Guard {
+	var auditor residency.Auditor
+	if c.AuditLog != "" {
+		auditor = residency.NewFileAuditor(c.AuditLog)
+	}
+	return residency.NewGuard(c.Policy, c.Environment, auditor)
+}
+
+// DisplayConfig holds the locale and timezone of times and numbers shown to
+// people; JSON and webhook payloads keep RFC 3339 UTC
+type DisplayConfig struct {
+	Locale   string `mapstructure:"locale"`
+	Timezone string `mapstructure:"timezone"`
+}
+
+// Formatter creates the formatter of the configured locale and timezone
+func (c DisplayConfig) Formatter() (*locale.Formatter, error) {
+	return locale.New(c.Locale, c.Timezone)
+}
+
+// TenancyConfig holds tenant isolation settings and the shared backends
+// behind each tenant's Grafana datasources
+type TenancyConfig struct {
+	tenancy.Config `mapstructure:",squash"`
+	Backends       tenancy.Endpoints `mapstructure:"backends"`
+}
+
+// ChatOpsConfig holds the chat integration secrets, the role policy of chat
+// users, and the command audit log
+type ChatOpsConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	SlackSigningSecret string `mapstructure:"slack_signing_secret"`
+	TeamsSecurityToken string `mapstructure:"teams_security_token"`
+	AuditLog           string `mapstructure:"audit_log"`
+	chatops.Policy     `mapstructure:",squash"`
+}
+
+// IncidentsConfig holds incident summary settings. EventSecret verifies the
+// signed deploy events posted to /api/v1/events by apm deploy webhooks.
+type IncidentsConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Timeline      string   `mapstructure:"timeline"`
+	Lookback      string   `mapstructure:"lookback"`
+	MaxItems      int      `mapstructure:"max_items"`
+	ServiceLabels []string `mapstructure:"service_labels"`
+	EventSecret   string   `mapstructure:"event_secret"`
+}
+
+// ReleasesConfig holds release health scoring settings. Releases are the
+// deploys recorded in the incident timeline, so incidents must be enabled.
+type ReleasesConfig struct {
+	Enabled         bool    `mapstructure:"enabled"`
+	Window          string  `mapstructure:"window"`
+	ServiceLabel    string  `mapstructure:"service_label"`
+	SaturationQuery string  `mapstructure:"saturation_query"`
+	MaxErrorRate    float64 `mapstructure:"max_error_rate"`
+}
+
+// ChangesConfig holds the change request workflow of the rule files,
+// dashboards, and Alertmanager routes, where the requests are kept with the
+// file storage driver, and their audit log
+type ChangesConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	Store          string `mapstructure:"store"`
+	AuditLog       string `mapstructure:"audit_log"`
+	Reload         bool   `mapstructure:"reload"`
+	changes.Config `mapstructure:",squash"`
+}
+
+// HAConfig holds leader election settings for running several replicas of
+// the server behind a load balancer. Every replica serves the API; only the
+// leader runs the singleton jobs. Backend is "kubernetes", for a Lease in the
+// Kubernetes namespace, or "etcd", for a key on Endpoints.
+type HAConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Backend       string   `mapstructure:"backend"`
+	LeaseName     string   `mapstructure:"lease_name"`
+	Identity      string   `mapstructure:"identity"`
+	LeaseDuration string   `mapstructure:"lease_duration"`
+	Endpoints     []string `mapstructure:"endpoints"`
+	Token         string   `mapstructure:"token"`
+}
+
+// JanitorConfig holds the periodic search for the dashboards of services
+// that stopped reporting. Orphans are only counted, never removed.
+type JanitorConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Interval string `mapstructure:"interval"`
+	Lookback string `mapstructure:"lookback"`
+}
+
+// StorageConfig selects where the server keeps its state: the incident
+// timeline with its deploy events, the chat command audit log, and tenant
+// usage. Driver "file" keeps the first two in the files named in their
+// sections and tenant usage in memory, as before the database existed.
+type StorageConfig struct {
+	store.Config   `mapstructure:",squash"`
+	BackupInterval string `mapstructure:"backup_interval"`
+	BackupCommand  string `mapstructure:"backup_command"`
+}
+
 // LoadConfig reads configuration from file and environment variables
 func LoadConfig(configPath string) (*Config, error) {
 	v := viper.New()
@@ -141,6 +341,16 @@ func LoadConfig(configPath string) (*Config, error) {
 	v.BindEnv("prometheus.endpoint", "APM_PROMETHEUS_ENDPOINT")
 	v.BindEnv("grafana.api_key", "APM_GRAFANA_API_KEY")
 	v.BindEnv("kubernetes.namespace", "APM_KUBERNETES_NAMESPACE")
+	v.BindEnv("data_residency.environment", "APM_ENVIRONMENT")
+	v.BindEnv("chatops.slack_signing_secret", "APM_CHATOPS_SLACK_SIGNING_SECRET")
+	v.BindEnv("chatops.teams_security_token", "APM_CHATOPS_TEAMS_SECURITY_TOKEN")
+	v.BindEnv("incidents.event_secret", "APM_INCIDENTS_EVENT_SECRET")
+	v.BindEnv("ha.identity", "APM_HA_IDENTITY", "POD_NAME")
+	v.BindEnv("ha.token", "APM_HA_TOKEN")
+	v.BindEnv("storage.dsn", "APM_STORAGE_DSN")
+	v.BindEnv("access.read_only", access.ReadOnlyEnv)
+	v.BindEnv("display.locale", locale.LocaleEnv)
+	v.BindEnv("display.timezone", locale.TimezoneEnv)
 
 	// Read config file
 	if err := v.ReadInConfig(); err != nil {
@@ -206,4 +416,71 @@ func setDefaults(v *viper.Viper) {
 	// Service discovery defaults
 	v.SetDefault("service_discovery.enabled", true)
 	v.SetDefault("service_discovery.refresh_interval", "30s")
+
+	// Certificate monitoring defaults
+	v.SetDefault("certificate_monitoring.enabled", false)
+	v.SetDefault("certificate_monitoring.interval", "1h")
+	v.SetDefault("certificate_monitoring.timeout", "10s")
+	v.SetDefault("certificate_monitoring.warning_days", 30)
+	v.SetDefault("certificate_monitoring.critical_days", 7)
+
+	// Data residency defaults
+	v.SetDefault("data_residency.environment", "development")
+	v.SetDefault("data_residency.enforce", false)
+
+	// Access defaults
+	v.SetDefault("access.read_only", false)
+
+	// Display defaults
+	v.SetDefault("display.locale", "")
+	v.SetDefault("display.timezone", "UTC")
+
+	// Tenancy defaults
+	v.SetDefault("tenancy.enabled", false)
+	v.SetDefault("tenancy.header", tenancy.DefaultHeader)
+	v.SetDefault("tenancy.required", false)
+	v.SetDefault("tenancy.exempt_paths", []string{"/health", "/metrics"})
+
+	// ChatOps defaults
+	v.SetDefault("chatops.enabled", false)
+	v.SetDefault("chatops.audit_log", "chatops-audit.log")
+
+	// Incident defaults
+	v.SetDefault("incidents.enabled", false)
+	v.SetDefault("incidents.timeline", "incidents.jsonl")
+	v.SetDefault("incidents.lookback", "30m")
+	v.SetDefault("incidents.max_items", 5)
+
+	// Release health defaults
+	v.SetDefault("releases.enabled", false)
+	v.SetDefault("releases.window", "1h")
+	v.SetDefault("releases.service_label", "job")
+	v.SetDefault("releases.max_error_rate", 0.05)
+
+	// Change request defaults
+	v.SetDefault("changes.enabled", false)
+	v.SetDefault("changes.require_approval", true)
+	v.SetDefault("changes.approver_roles", []string{changes.DefaultApproverRole})
+	v.SetDefault("changes.rules_dir", "configs/prometheus/alerts")
+	v.SetDefault("changes.dashboards_dir", "configs/grafana/dashboards")
+	v.SetDefault("changes.alertmanager_config", "configs/alertmanager/alertmanager.yml")
+	v.SetDefault("changes.store", "changes.json")
+	v.SetDefault("changes.audit_log", "changes-audit.log")
+	v.SetDefault("changes.reload", true)
+
+	// High availability defaults
+	v.SetDefault("ha.enabled", false)
+	v.SetDefault("ha.backend", "kubernetes")
+	v.SetDefault("ha.lease_name", "apm-server")
+	v.SetDefault("ha.lease_duration", "15s")
+
+	// Janitor defaults
+	v.SetDefault("janitor.enabled", false)
+	v.SetDefault("janitor.interval", "1h")
+	v.SetDefault("janitor.lookback", "7d")
+
+	// Storage defaults
+	v.SetDefault("storage.driver", store.DriverSQLite)
+	v.SetDefault("storage.dsn", "apm.db")
+	v.SetDefault("storage.backup_dir", "backups")
 }
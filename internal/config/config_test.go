@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -157,3 +158,53 @@ server:
 		t.Errorf("Expected server port ':9090', got '%s'", cfg.Server.Port)
 	}
 }
+
+func TestCertificateMonitoringMonitor(t *testing.T) {
+	cfg := CertificateMonitoringConfig{
+		Interval:     "1h",
+		Timeout:      "10s",
+		WarningDays:  14,
+		CriticalDays: 2,
+		Endpoints:    []CertificateEndpoint{{Name: "api", Address: "api.example.com:443", Kind: "api"}},
+	}
+	monitor, err := cfg.Monitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := monitor.AlertRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"< 1209600", "< 172800"} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("expected alert rules to contain %q", want)
+		}
+	}
+
+	cfg.Interval = "hourly"
+	if _, err := cfg.Monitor(); err == nil {
+		t.Error("expected an invalid interval to be rejected")
+	}
+}
+
+func TestCertificateAlertRulesAreCurrent(t *testing.T) {
+	cfg, err := LoadConfig("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor, err := cfg.CertificateMonitoring.Monitor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := monitor.AlertRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../configs/prometheus/alerts/certificate-alerts.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Error("configs/prometheus/alerts/certificate-alerts.yml does not match the certificate_monitoring thresholds of configs/config.yaml")
+	}
+}
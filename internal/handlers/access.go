@@ -0,0 +1,52 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AccessHandlers serves the access a caller has
+type AccessHandlers struct {
+	policy access.Policy
+}
+
+// NewAccessHandlers creates access handlers
+func NewAccessHandlers(policy access.Policy) *AccessHandlers {
+	return &AccessHandlers{policy: policy}
+}
+
+// AccessInfo is the access of the caller
+type AccessInfo struct {
+	// ReadOnly is true when the server refuses every change
+	ReadOnly bool `json:"read_only"`
+	// Enforced is false until API tokens are configured, when every caller
+	// has every scope
+	Enforced  bool             `json:"enforced"`
+	Principal access.Principal `json:"principal"`
+}
+
+// WhoAmI returns the caller's principal and scopes, and whether the server
+// is read-only
+func (ah *AccessHandlers) WhoAmI(c *fiber.Ctx) error {
+	principal, _ := c.Locals(access.LocalsKey).(access.Principal)
+	return c.JSON(AccessInfo{
+		ReadOnly:  ah.policy.ReadOnly,
+		Enforced:  ah.policy.Enabled(),
+		Principal: principal,
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/webhook"
+	"github.com/gofiber/fiber/v2"
+)
+
+// incidentSummaryTimeout bounds the backend queries of an incident summary
+const incidentSummaryTimeout = 2 * time.Minute
+
+// AlertHandlers relays Alertmanager notifications to the configured webhooks
+// and, when incidents are enabled, summarizes firing alert groups
+type AlertHandlers struct {
+	emitter   *webhook.Emitter
+	incidents *incident.Summarizer
+}
+
+// NewAlertHandlers creates alert handlers that emit through an emitter;
+// incidents may be nil
+func NewAlertHandlers(emitter *webhook.Emitter, incidents *incident.Summarizer) *AlertHandlers {
+	return &AlertHandlers{emitter: emitter, incidents: incidents}
+}
+
+// AlertReceipt reports how many alert events a notification emitted
+type AlertReceipt struct {
+	Emitted int `json:"emitted"`
+}
+
+// Receive accepts an Alertmanager webhook notification and emits an
+// alert.fired or alert.resolved event per alert. Failed deliveries return
+// 502 so that Alertmanager retries the notification.
+func (ah *AlertHandlers) Receive(c *fiber.Ctx) error {
+	var payload webhook.AlertmanagerPayload
+	if err := json.Unmarshal(c.Body(), &payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid Alertmanager payload",
+		})
+	}
+
+	// Summaries query several backends, so they are built after the
+	// notification is acknowledged
+	if ah.incidents != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), incidentSummaryTimeout)
+			defer cancel()
+			if _, err := ah.incidents.Observe(ctx, payload); err != nil {
+				log.Printf("incident summary for %s: %v", payload.GroupKey, err)
+			}
+		}()
+	}
+
+	events := webhook.AlertEvents(payload)
+	var errs []error
+	for _, event := range events {
+		if err := ah.emitter.Emit(c.UserContext(), event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(AlertReceipt{Emitted: len(events)})
+}
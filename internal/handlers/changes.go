@@ -0,0 +1,136 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ChangeHandlers serves the change requests of rules, dashboards, and alert
+// routes
+type ChangeHandlers struct {
+	manager *changes.Manager
+}
+
+// NewChangeHandlers creates change request handlers
+func NewChangeHandlers(manager *changes.Manager) *ChangeHandlers {
+	return &ChangeHandlers{manager: manager}
+}
+
+// ChangeList is a list of change requests, newest first
+type ChangeList struct {
+	Changes []changes.Request `json:"changes"`
+}
+
+// ChangeReview is the comment of an approval or rejection
+type ChangeReview struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// List returns the change requests, filtered by the status query parameter
+func (ch *ChangeHandlers) List(c *fiber.Ctx) error {
+	requests, err := ch.manager.List(c.Query("status"))
+	if err != nil {
+		return changeError(c, err)
+	}
+	return c.JSON(ChangeList{Changes: requests})
+}
+
+// Get returns a change request, or only its diff with format=diff
+func (ch *ChangeHandlers) Get(c *fiber.Ctx) error {
+	req, err := ch.manager.Get(c.Params("id"))
+	if err != nil {
+		return changeError(c, err)
+	}
+	if c.Query("format") == "diff" {
+		c.Set(fiber.HeaderContentType, "text/x-diff; charset=utf-8")
+		return c.SendString(req.Diff)
+	}
+	return c.JSON(req)
+}
+
+// Propose records a change request with its diff
+func (ch *ChangeHandlers) Propose(c *fiber.Ctx) error {
+	var proposal changes.Proposal
+	if err := c.BodyParser(&proposal); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid change request",
+		})
+	}
+	req, err := ch.manager.Propose(c.UserContext(), principalOf(c), proposal)
+	if err != nil {
+		return changeError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(req)
+}
+
+// Approve approves a pending change request
+func (ch *ChangeHandlers) Approve(c *fiber.Ctx) error {
+	var review ChangeReview
+	c.BodyParser(&review)
+	req, err := ch.manager.Approve(c.UserContext(), principalOf(c), c.Params("id"), review.Comment)
+	if err != nil {
+		return changeError(c, err)
+	}
+	return c.JSON(req)
+}
+
+// Reject rejects or withdraws a pending change request
+func (ch *ChangeHandlers) Reject(c *fiber.Ctx) error {
+	var review ChangeReview
+	c.BodyParser(&review)
+	req, err := ch.manager.Reject(c.UserContext(), principalOf(c), c.Params("id"), review.Comment)
+	if err != nil {
+		return changeError(c, err)
+	}
+	return c.JSON(req)
+}
+
+// Apply writes an approved change request
+func (ch *ChangeHandlers) Apply(c *fiber.Ctx) error {
+	req, err := ch.manager.Apply(c.UserContext(), principalOf(c), c.Params("id"))
+	if err != nil {
+		return changeError(c, err)
+	}
+	return c.JSON(req)
+}
+
+func principalOf(c *fiber.Ctx) access.Principal {
+	principal, _ := c.Locals(access.LocalsKey).(access.Principal)
+	return principal
+}
+
+// changeError responds with the status of a workflow error
+func changeError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, changes.ErrInvalid):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, changes.ErrForbidden):
+		status = fiber.StatusForbidden
+	case errors.Is(err, changes.ErrNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, changes.ErrConflict):
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/gofiber/fiber/v2"
+)
+
+// slackAckTimeout is how long a Slack command may run before it is
+// acknowledged and answered later through its response URL; Slack allows 3s
+const slackAckTimeout = 2500 * time.Millisecond
+
+// chatCommandTimeout bounds a command answered through a response URL
+const chatCommandTimeout = time.Minute
+
+// teamsMention matches the bot mention Teams prepends to outgoing webhook text
+var teamsMention = regexp.MustCompile(`(?s)<at>.*?</at>`)
+
+// ChatOpsHandlers receives chat commands from Slack and Teams
+type ChatOpsHandlers struct {
+	bot                *chatops.Bot
+	slackSigningSecret string
+	teamsSecurityToken string
+	client             *http.Client
+}
+
+// NewChatOpsHandlers creates chat handlers. Each platform is only accepted
+// when its signing secret is configured.
+func NewChatOpsHandlers(bot *chatops.Bot, slackSigningSecret, teamsSecurityToken string) *ChatOpsHandlers {
+	return &ChatOpsHandlers{
+		bot:                bot,
+		slackSigningSecret: slackSigningSecret,
+		teamsSecurityToken: teamsSecurityToken,
+		client:             &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Slack handles a Slack slash command such as "/apm status"
+func (ch *ChatOpsHandlers) Slack(c *fiber.Ctx) error {
+	if ch.slackSigningSecret == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Slack commands are not enabled",
+		})
+	}
+	body := c.Body()
+	err := chatops.VerifySlack(ch.slackSigningSecret, c.Get("X-Slack-Request-Timestamp"), c.Get("X-Slack-Signature"), body, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid Slack command payload",
+		})
+	}
+	req := chatops.Request{
+		Platform: chatops.PlatformSlack,
+		UserID:   form.Get("user_id"),
+		UserName: form.Get("user_name"),
+		Channel:  form.Get("channel_name"),
+		Text:     form.Get("text"),
+	}
+
+	// Answer within Slack's deadline when possible, otherwise acknowledge
+	// and post the reply to the response URL when the command finishes
+	done := make(chan chatops.Response, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), chatCommandTimeout)
+		defer cancel()
+		done <- ch.bot.Handle(ctx, req)
+	}()
+
+	select {
+	case resp := <-done:
+		return c.JSON(slackMessage(resp))
+	case <-time.After(slackAckTimeout):
+		responseURL := form.Get("response_url")
+		go func() {
+			resp := <-done
+			if responseURL != "" {
+				ch.postSlack(responseURL, resp)
+			}
+		}()
+		return c.JSON(slackMessage(chatops.Response{Text: "Working on it…"}))
+	}
+}
+
+// Teams handles a Teams outgoing webhook message such as "@APM status"
+func (ch *ChatOpsHandlers) Teams(c *fiber.Ctx) error {
+	if ch.teamsSecurityToken == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Teams commands are not enabled",
+		})
+	}
+	body := c.Body()
+	if err := chatops.VerifyTeams(ch.teamsSecurityToken, c.Get("Authorization"), body); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var activity struct {
+		Text string `json:"text"`
+		From struct {
+			ID          string `json:"id"`
+			Name        string `json:"name"`
+			AADObjectID string `json:"aadObjectId"`
+		} `json:"from"`
+		Conversation struct {
+			Name string `json:"name"`
+		} `json:"conversation"`
+	}
+	if err := json.Unmarshal(body, &activity); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid Teams activity",
+		})
+	}
+
+	userID := activity.From.AADObjectID
+	if userID == "" {
+		userID = activity.From.ID
+	}
+	resp := ch.bot.Handle(c.UserContext(), chatops.Request{
+		Platform: chatops.PlatformTeams,
+		UserID:   userID,
+		UserName: activity.From.Name,
+		Channel:  activity.Conversation.Name,
+		Text:     strings.TrimSpace(teamsMention.ReplaceAllString(activity.Text, "")),
+	})
+	return c.JSON(fiber.Map{
+		"type": "message",
+		"text": resp.Text,
+	})
+}
+
+func slackMessage(resp chatops.Response) fiber.Map {
+	responseType := "ephemeral"
+	if resp.Public {
+		responseType = "in_channel"
+	}
+	return fiber.Map{
+		"response_type": responseType,
+		"text":          resp.Text,
+	}
+}
+
+// postSlack posts a delayed reply to a Slack response URL
+func (ch *ChatOpsHandlers) postSlack(responseURL string, resp chatops.Response) {
+	data, err := json.Marshal(slackMessage(resp))
+	if err != nil {
+		return
+	}
+	res, err := ch.client.Post(responseURL, "application/json", bytes.NewReader(data))
+	if err == nil {
+		res.Body.Close()
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/webhook"
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventTolerance is how old a signed event may be
+const eventTolerance = 5 * time.Minute
+
+// IncidentHandlers records deploy events and serves incident timelines
+type IncidentHandlers struct {
+	summarizer  *incident.Summarizer
+	eventSecret string
+}
+
+// NewIncidentHandlers creates incident handlers. Events must be signed with
+// eventSecret when it is set.
+func NewIncidentHandlers(summarizer *incident.Summarizer, eventSecret string) *IncidentHandlers {
+	return &IncidentHandlers{summarizer: summarizer, eventSecret: eventSecret}
+}
+
+// EventReceipt reports whether an event was recorded; only deploy events
+// are recorded
+type EventReceipt struct {
+	Recorded bool `json:"recorded"`
+}
+
+// IncidentTimeline is the timeline of one incident
+type IncidentTimeline struct {
+	Incident string           `json:"incident"`
+	Entries  []incident.Entry `json:"entries"`
+}
+
+// ReceiveEvent records a deploy.started or deploy.finished webhook event,
+// such as those sent by apm deploy, for correlation with later incidents.
+// Other event types are accepted and ignored.
+func (ih *IncidentHandlers) ReceiveEvent(c *fiber.Ctx) error {
+	body := c.Body()
+	if ih.eventSecret != "" {
+		err := webhook.Verify(ih.eventSecret, c.Get(webhook.HeaderSignature), c.Get(webhook.HeaderTimestamp), body, eventTolerance)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	var event webhook.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid event payload",
+		})
+	}
+	if event.Type != webhook.EventDeployStarted && event.Type != webhook.EventDeployFinished {
+		return c.JSON(EventReceipt{Recorded: false})
+	}
+	if err := ih.summarizer.RecordDeploy(event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(EventReceipt{Recorded: true})
+}
+
+// Timeline returns the timeline of an incident, oldest entry first
+func (ih *IncidentHandlers) Timeline(c *fiber.Ctx) error {
+	id := c.Params("id")
+	entries, err := ih.summarizer.Timeline.Entries(incident.Filter{Incident: id})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(entries) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "incident not found",
+		})
+	}
+
+	return c.JSON(IncidentTimeline{
+		Incident: id,
+		Entries:  entries,
+	})
+}
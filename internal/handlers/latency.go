@@ -0,0 +1,99 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/chaksack/apm/pkg/latency"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/gofiber/fiber/v2"
+)
+
+// LatencyHandlers serves latency distributions from Prometheus histograms
+type LatencyHandlers struct {
+	client *latency.Client
+}
+
+// NewLatencyHandlers creates latency handlers backed by a Prometheus client
+func NewLatencyHandlers(client *latency.Client) *LatencyHandlers {
+	return &LatencyHandlers{client: client}
+}
+
+// Heatmap returns latency bucket counts over time per route or service, e.g.
+// GET /api/v1/latency/heatmap?service=checkout&by=route&since=6h&step=5m.
+// format=csv downloads the counts as CSV.
+func (lh *LatencyHandlers) Heatmap(c *fiber.Ctx) error {
+	query := latency.Query{
+		Metric:  c.Query("metric"),
+		Service: c.Query("service"),
+		Route:   c.Query("route"),
+		GroupBy: c.Query("by"),
+	}
+
+	if step := c.Query("step"); step != "" {
+		d, err := time.ParseDuration(step)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid step duration",
+			})
+		}
+		query.Step = d
+	}
+	if since := c.Query("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid since duration",
+			})
+		}
+		query.End = time.Now()
+		query.Start = query.End.Add(-d)
+	}
+
+	if err := query.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Tenant-scoped callers only see their own tenant's metrics
+	ctx := c.UserContext()
+	if caller, ok := c.Locals(tenancy.LocalsKey).(string); ok {
+		ctx = tenancy.WithTenant(ctx, caller)
+	}
+
+	result, err := lh.client.Heatmaps(ctx, query)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := result.WriteCSV(&buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="latency-heatmap.csv"`)
+		return c.Send(buf.Bytes())
+	}
+	return c.JSON(result)
+}
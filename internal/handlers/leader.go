@@ -0,0 +1,36 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"github.com/chaksack/apm/pkg/leader"
+	"github.com/gofiber/fiber/v2"
+)
+
+// LeaderHandlers serves the leader election state of this replica
+type LeaderHandlers struct {
+	elector *leader.Elector
+}
+
+// NewLeaderHandlers creates leader handlers
+func NewLeaderHandlers(elector *leader.Elector) *LeaderHandlers {
+	return &LeaderHandlers{elector: elector}
+}
+
+// Status returns which replica leads and the singleton jobs it runs
+func (lh *LeaderHandlers) Status(c *fiber.Ctx) error {
+	return c.JSON(lh.elector.Status())
+}
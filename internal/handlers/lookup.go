@@ -0,0 +1,79 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"time"
+
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/gofiber/fiber/v2"
+)
+
+// LookupHandlers provides HTTP handlers for support lookups
+type LookupHandlers struct {
+	service *lookup.Service
+}
+
+// NewLookupHandlers creates lookup handlers backed by a lookup service
+func NewLookupHandlers(service *lookup.Service) *LookupHandlers {
+	return &LookupHandlers{service: service}
+}
+
+// Lookup returns the consolidated trace and log timeline of a business
+// identifier, e.g. GET /api/v1/lookup?attribute=order.id&value=1234&since=6h
+func (lh *LookupHandlers) Lookup(c *fiber.Ctx) error {
+	query := lookup.Query{
+		Attribute: c.Query("attribute"),
+		Value:     c.Query("value"),
+		Limit:     c.QueryInt("limit"),
+	}
+	if service := c.Query("service"); service != "" {
+		query.Services = []string{service}
+	}
+
+	if since := c.Query("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid since duration",
+			})
+		}
+		query.End = time.Now()
+		query.Start = query.End.Add(-d)
+	}
+
+	if err := query.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Tenant-scoped callers only search their own tenant's data
+	ctx := c.UserContext()
+	if caller, ok := c.Locals(tenancy.LocalsKey).(string); ok {
+		ctx = tenancy.WithTenant(ctx, caller)
+	}
+
+	result, err := lh.service.Lookup(ctx, query)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error":  "no backend could be searched",
+			"errors": result.Errors,
+		})
+	}
+	return c.JSON(result)
+}
@@ -16,14 +16,15 @@
 package handlers
 
 import (
-	"github.com/gofiber/adaptor/v2"
+	"github.com/chaksack/apm/pkg/instrumentation"
 	"github.com/gofiber/fiber/v2"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Metrics returns Prometheus metrics
+var metricsHandler = instrumentation.NewFiberMetricsHandler(prometheus.DefaultGatherer, nil)
+
+// Metrics returns Prometheus metrics, negotiating OpenMetrics, protobuf, and
+// gzip with the scraper
 func Metrics(c *fiber.Ctx) error {
-	// Use the Prometheus HTTP handler with Fiber adaptor
-	handler := adaptor.HTTPHandler(promhttp.Handler())
-	return handler(c)
+	return metricsHandler(c)
 }
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"errors"
+
+	"github.com/chaksack/apm/pkg/release"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReleaseHandlers serves the health scores of deployed releases
+type ReleaseHandlers struct {
+	scorer *release.Scorer
+}
+
+// NewReleaseHandlers creates release handlers
+func NewReleaseHandlers(scorer *release.Scorer) *ReleaseHandlers {
+	return &ReleaseHandlers{scorer: scorer}
+}
+
+// ReleaseHistory is the scores of a service's latest releases
+type ReleaseHistory struct {
+	Service  string            `json:"service"`
+	Releases []*release.Health `json:"releases"`
+}
+
+// History returns the scores of the latest releases of a service, newest
+// first; limit defaults to 10
+func (rh *ReleaseHandlers) History(c *fiber.Ctx) error {
+	service := c.Params("service")
+	limit := c.QueryInt("limit", 10)
+
+	releases, err := rh.scorer.History(c.Context(), service, limit)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(releases) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "no releases recorded for " + service,
+		})
+	}
+
+	return c.JSON(ReleaseHistory{
+		Service:  service,
+		Releases: releases,
+	})
+}
+
+// Version returns the score of one release of a service
+func (rh *ReleaseHandlers) Version(c *fiber.Ctx) error {
+	health, err := rh.scorer.Version(c.Context(), c.Params("service"), c.Params("version"))
+	if errors.Is(err, release.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(health)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TenantHandlers provides HTTP handlers for tenant usage
+type TenantHandlers struct {
+	config tenancy.Config
+	meter  *tenancy.Meter
+}
+
+// NewTenantHandlers creates tenant handlers backed by a meter
+func NewTenantHandlers(config tenancy.Config, meter *tenancy.Meter) *TenantHandlers {
+	return &TenantHandlers{config: config, meter: meter}
+}
+
+// TenantUsageList is the metered usage of the visible tenants
+type TenantUsageList struct {
+	Tenants []tenancy.Usage `json:"tenants"`
+}
+
+// TenantUsage is the metered usage of a tenant and, for configured tenants,
+// its effective limits
+type TenantUsage struct {
+	Usage  tenancy.Usage   `json:"usage"`
+	Limits *tenancy.Limits `json:"limits,omitempty"`
+}
+
+// ListUsage returns metered usage for every tenant, or only the caller's
+// tenant for tenant-scoped requests
+func (th *TenantHandlers) ListUsage(c *fiber.Ctx) error {
+	usage := th.meter.Snapshot()
+	if caller, ok := c.Locals(tenancy.LocalsKey).(string); ok {
+		usage = usage[:0]
+		if u, found := th.meter.Usage(caller); found {
+			usage = append(usage, u)
+		}
+	}
+
+	return c.JSON(TenantUsageList{
+		Tenants: usage,
+	})
+}
+
+// GetUsage returns metered usage and effective limits for one tenant
+func (th *TenantHandlers) GetUsage(c *fiber.Ctx) error {
+	id := c.Params("tenant")
+
+	// Tenant-scoped callers may only read their own usage
+	if caller, ok := c.Locals(tenancy.LocalsKey).(string); ok && caller != id {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "usage of other tenants is not visible",
+		})
+	}
+
+	usage, recorded := th.meter.Usage(id)
+	tenant, known := th.config.Lookup(id)
+	if !recorded && !known {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "no usage recorded for tenant",
+		})
+	}
+	usage.Tenant = id
+
+	response := TenantUsage{Usage: usage}
+	if known {
+		limits := th.config.EffectiveLimits(tenant)
+		response.Limits = &limits
+	}
+	return c.JSON(response)
+}
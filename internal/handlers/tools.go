@@ -18,6 +18,7 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/chaksack/apm/pkg/tools"
@@ -47,6 +48,75 @@ func NewToolHandlers() (*ToolHandlers, error) {
 	}, nil
 }
 
+// DetectedTools lists the installed tools with their health
+type DetectedTools struct {
+	Tools []*tools.Tool `json:"tools"`
+	Count int           `json:"count"`
+}
+
+// ToolHealth is the health and metrics of one tool
+type ToolHealth struct {
+	Tool    *tools.Tool          `json:"tool"`
+	Health  *tools.HealthStatus  `json:"health"`
+	Metrics *tools.HealthMetrics `json:"metrics"`
+}
+
+// AllocatedPorts lists the allocated ports and the tools holding them
+type AllocatedPorts struct {
+	AllocatedPorts map[int]string `json:"allocated_ports"`
+	Count          int            `json:"count"`
+}
+
+// PortRequest asks for a tool's main port, or a named additional port
+type PortRequest struct {
+	ToolType string `json:"tool_type"`
+	PortName string `json:"port_name,omitempty"`
+}
+
+// PortAllocation is an allocated port
+type PortAllocation struct {
+	ToolType string `json:"tool_type"`
+	PortName string `json:"port_name"`
+	Port     int    `json:"port"`
+}
+
+// PortRegistry holds the known ports by tool
+type PortRegistry struct {
+	PortRegistry map[string]PortRegistryEntry `json:"port_registry"`
+}
+
+// PortRegistryEntry holds the ports of one tool
+type PortRegistryEntry struct {
+	Default         int                       `json:"default"`
+	Alternatives    []int                     `json:"alternatives"`
+	Description     string                    `json:"description"`
+	AdditionalPorts map[string]AdditionalPort `json:"additional_ports,omitempty"`
+}
+
+// AdditionalPort is a secondary port of a tool
+type AdditionalPort struct {
+	Default     int    `json:"default"`
+	Protocol    string `json:"protocol"`
+	Description string `json:"description"`
+}
+
+// ToolList lists the supported tools
+type ToolList struct {
+	Tools []ToolSummary `json:"tools"`
+	Count int           `json:"count"`
+}
+
+// ToolSummary is the installation and health of one supported tool
+type ToolSummary struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Endpoint    string `json:"endpoint,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Health      string `json:"health,omitempty"`
+	Version     string `json:"version,omitempty"`
+	DefaultPort int    `json:"default_port,omitempty"`
+}
+
 // DetectTools detects all installed APM tools
 func (th *ToolHandlers) DetectTools(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -77,9 +147,9 @@ func (th *ToolHandlers) DetectTools(c *fiber.Ctx) error {
 		tool.LastHealthCheck = time.Now()
 	}
 
-	return c.JSON(fiber.Map{
-		"tools": detectedTools,
-		"count": len(detectedTools),
+	return c.JSON(DetectedTools{
+		Tools: detectedTools,
+		Count: len(detectedTools),
 	})
 }
 
@@ -128,10 +198,10 @@ func (th *ToolHandlers) GetToolHealth(c *fiber.Ctx) error {
 	// Get metrics
 	metrics, _ := checker.GetMetrics()
 
-	return c.JSON(fiber.Map{
-		"tool":    tool,
-		"health":  health,
-		"metrics": metrics,
+	return c.JSON(ToolHealth{
+		Tool:    tool,
+		Health:  health,
+		Metrics: metrics,
 	})
 }
 
@@ -164,19 +234,15 @@ func (th *ToolHandlers) GetToolConfig(c *fiber.Ctx) error {
 func (th *ToolHandlers) GetAllocatedPorts(c *fiber.Ctx) error {
 	ports := th.portManager.GetAllocatedPorts()
 
-	return c.JSON(fiber.Map{
-		"allocated_ports": ports,
-		"count":           len(ports),
+	return c.JSON(AllocatedPorts{
+		AllocatedPorts: ports,
+		Count:          len(ports),
 	})
 }
 
 // AllocatePort allocates a port for a tool
 func (th *ToolHandlers) AllocatePort(c *fiber.Ctx) error {
-	var request struct {
-		ToolType string `json:"tool_type"`
-		PortName string `json:"port_name,omitempty"`
-	}
-
+	var request PortRequest
 	if err := c.BodyParser(&request); err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Invalid request body",
@@ -202,46 +268,63 @@ func (th *ToolHandlers) AllocatePort(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"tool_type": request.ToolType,
-		"port_name": request.PortName,
-		"port":      port,
+	return c.JSON(PortAllocation{
+		ToolType: request.ToolType,
+		PortName: request.PortName,
+		Port:     port,
 	})
 }
 
+// ReleasePort releases an allocated port
+func (th *ToolHandlers) ReleasePort(c *fiber.Ctx) error {
+	port, err := strconv.Atoi(c.Params("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": fmt.Sprintf("Invalid port: %s", c.Params("port")),
+		})
+	}
+	if _, ok := th.portManager.GetAllocatedPorts()[port]; !ok {
+		return c.Status(404).JSON(fiber.Map{
+			"error": fmt.Sprintf("Port %d is not allocated", port),
+		})
+	}
+
+	th.portManager.ReleasePort(port)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
 // GetPortRegistry returns the port registry information
 func (th *ToolHandlers) GetPortRegistry(c *fiber.Ctx) error {
-	registry := make(map[string]interface{})
+	registry := make(map[string]PortRegistryEntry)
 
 	// Main ports
 	for toolType, config := range tools.PortRegistry {
-		registry[string(toolType)] = map[string]interface{}{
-			"default":      config.Default,
-			"alternatives": config.Alternatives,
-			"description":  config.Description,
+		registry[string(toolType)] = PortRegistryEntry{
+			Default:      config.Default,
+			Alternatives: config.Alternatives,
+			Description:  config.Description,
 		}
 	}
 
 	// Additional ports
 	for toolType, additionalPorts := range tools.AdditionalPorts {
-		if _, exists := registry[string(toolType)]; exists {
-			toolRegistry := registry[string(toolType)].(map[string]interface{})
-			additionalMap := make(map[string]interface{})
+		if toolRegistry, exists := registry[string(toolType)]; exists {
+			toolRegistry.AdditionalPorts = make(map[string]AdditionalPort)
 
 			for name, config := range additionalPorts {
-				additionalMap[name] = map[string]interface{}{
-					"default":     config.Default,
-					"protocol":    config.Protocol,
-					"description": config.Description,
+				toolRegistry.AdditionalPorts[name] = AdditionalPort{
+					Default:     config.Default,
+					Protocol:    config.Protocol,
+					Description: config.Description,
 				}
 			}
 
-			toolRegistry["additional_ports"] = additionalMap
+			registry[string(toolType)] = toolRegistry
 		}
 	}
 
-	return c.JSON(fiber.Map{
-		"port_registry": registry,
+	return c.JSON(PortRegistry{
+		PortRegistry: registry,
 	})
 }
 
@@ -279,7 +362,7 @@ func (th *ToolHandlers) ListTools(c *fiber.Ctx) error {
 		tools.ToolTypeAlertManager,
 	}
 
-	toolList := make([]map[string]interface{}, 0, len(supportedTools))
+	toolList := make([]ToolSummary, 0, len(supportedTools))
 
 	for _, toolType := range supportedTools {
 		detector, err := th.detector.CreateDetector(toolType)
@@ -287,16 +370,16 @@ func (th *ToolHandlers) ListTools(c *fiber.Ctx) error {
 			continue
 		}
 
-		toolInfo := map[string]interface{}{
-			"name":   string(toolType),
-			"status": "not_installed",
+		toolInfo := ToolSummary{
+			Name:   string(toolType),
+			Status: "not_installed",
 		}
 
 		// Try to detect the tool
 		if tool, err := detector.Detect(); err == nil {
-			toolInfo["status"] = "installed"
-			toolInfo["endpoint"] = tool.Endpoint
-			toolInfo["port"] = tool.Port
+			toolInfo.Status = "installed"
+			toolInfo.Endpoint = tool.Endpoint
+			toolInfo.Port = tool.Port
 
 			// Check health
 			if checker, err := th.healthChecker.CreateHealthChecker(tool); err == nil {
@@ -304,22 +387,22 @@ func (th *ToolHandlers) ListTools(c *fiber.Ctx) error {
 				defer cancel()
 
 				if health, err := checker.Check(ctx); err == nil {
-					toolInfo["health"] = string(health.Status)
-					toolInfo["version"] = health.Version
+					toolInfo.Health = string(health.Status)
+					toolInfo.Version = health.Version
 				}
 			}
 		}
 
 		// Add port information
 		if portConfig, exists := tools.PortRegistry[toolType]; exists {
-			toolInfo["default_port"] = portConfig.Default
+			toolInfo.DefaultPort = portConfig.Default
 		}
 
 		toolList = append(toolList, toolInfo)
 	}
 
-	return c.JSON(fiber.Map{
-		"tools": toolList,
-		"count": len(toolList),
+	return c.JSON(ToolList{
+		Tools: toolList,
+		Count: len(toolList),
 	})
 }
@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"testing"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAccessRules(t *testing.T) {
+	tests := []struct {
+		method, path string
+		public       bool
+		scope        string
+		write        bool
+	}{
+		{fiber.MethodGet, "/health", true, "", false},
+		{fiber.MethodGet, OpenAPIPath, true, "", false},
+		{fiber.MethodPost, "/api/v1/chatops/slack", true, "", false},
+		{fiber.MethodGet, "/api/v1/status", false, access.ScopeViewMetrics, false},
+		{fiber.MethodPost, "/api/v1/alerts/webhook", false, access.ScopeManageAlerts, false},
+		{fiber.MethodPost, "/api/v1/events", false, access.ScopeDeploy, false},
+		{fiber.MethodGet, "/api/v1/changes", false, access.ScopeViewMetrics, false},
+		{fiber.MethodPost, "/api/v1/changes", false, access.ScopeManageAlerts, true},
+		{fiber.MethodPost, "/api/v1/changes/cr-1/approve", false, access.ScopeManageAlerts, true},
+		{fiber.MethodPost, "/tools/allocate-port", false, access.ScopeDeploy, true},
+		{fiber.MethodPost, "/tools/allocate-port/", false, access.ScopeDeploy, true},
+		{fiber.MethodPost, "/tools/Allocate-Port", false, access.ScopeDeploy, true},
+		{fiber.MethodDelete, "/Tools/Ports/9090/", false, access.ScopeDeploy, true},
+		{fiber.MethodDelete, "/tools/ports/9090", false, access.ScopeDeploy, true},
+		{fiber.MethodPost, "/tools/grafana/config", false, access.ScopeViewMetrics, false},
+		{fiber.MethodPut, "/api/v1/unknown", false, access.ScopeDeploy, true},
+	}
+	for _, tt := range tests {
+		rule := access.Match(AccessRules, tt.method, tt.path)
+		if rule.Public != tt.public || (!tt.public && (rule.Scope != tt.scope || rule.Write != tt.write)) {
+			t.Errorf("%s %s = %+v", tt.method, tt.path, rule)
+		}
+	}
+}
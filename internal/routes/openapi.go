@@ -0,0 +1,258 @@
+// Copyright (c) 2024 APM Solution Contributors
+// Authors: Andrew Chakdahah (chakdahah@gmail.com) and Yaw Boateng Kessie (ybkess@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routes
+
+import (
+	"github.com/chaksack/apm/internal/handlers"
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/chaksack/apm/pkg/latency"
+	"github.com/chaksack/apm/pkg/leader"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/openapi"
+	"github.com/chaksack/apm/pkg/release"
+	"github.com/chaksack/apm/pkg/webhook"
+	"github.com/gofiber/fiber/v2"
+)
+
+// OpenAPIPath is where the server serves its OpenAPI document
+const OpenAPIPath = "/openapi.json"
+
+// OpenAPI describes the REST API of the server. Routes set up by the
+// optional Setup functions are included whether or not they are enabled.
+func OpenAPI() *openapi.Document {
+	b := openapi.NewBuilder(openapi.Info{
+		Title:       "APM Service",
+		Description: "Status, tools, queries, and deploy events of the APM stack",
+		Version:     "1.0.0",
+	})
+	b.Tag("status", "Service health and status").
+		Tag("tools", "Detected monitoring tools and their ports").
+		Tag("tenants", "Tenant usage, with tenancy enabled").
+		Tag("queries", "Trace, log, and latency queries").
+		Tag("alerts", "Alertmanager notifications").
+		Tag("deploys", "Deploy events, incidents, and release health").
+		Tag("changes", "Reviewed changes to rules, dashboards, and alert routes").
+		SecurityScheme("bearerAuth", &openapi.SecurityScheme{Type: "http", Scheme: "bearer"})
+
+	// Status
+	b.Add(fiber.MethodGet, "/health", openapi.Route{
+		ID: "getHealth", Summary: "Check the health of the service", Tags: []string{"status"},
+		Response: handlers.HealthCheck{},
+	})
+	b.Add(fiber.MethodGet, "/metrics", openapi.Route{
+		ID: "getMetrics", Summary: "Scrape the Prometheus metrics of the service", Tags: []string{"status"},
+		Response: "",
+	})
+	b.Add(fiber.MethodGet, "/api/v1/status", openapi.Route{
+		ID: "getStatus", Summary: "Get the status of the APM stack", Tags: []string{"status"},
+		Response: handlers.SystemStatus{},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/access", openapi.Route{
+		ID: "getAccess", Summary: "Get the scopes of the caller and whether the server is read-only", Tags: []string{"status"},
+		Description: "Scopes are enforced once API tokens are configured; until then every caller has every scope.",
+		Response:    handlers.AccessInfo{},
+		Errors:      []int{fiber.StatusUnauthorized},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/leader", openapi.Route{
+		ID: "getLeader", Summary: "Get the leader election state of this replica", Tags: []string{"status"},
+		Description: "Every replica serves the API; only the leader runs the singleton jobs.",
+		Response:    leader.Status{},
+	})
+
+	// Tools
+	b.Add(fiber.MethodGet, "/tools/", openapi.Route{
+		ID: "listTools", Summary: "List the supported tools with their installation and health", Tags: []string{"tools"},
+		Response: handlers.ToolList{},
+	})
+	b.Add(fiber.MethodGet, "/tools/detect", openapi.Route{
+		ID: "detectTools", Summary: "Detect the installed tools and check their health", Tags: []string{"tools"},
+		Response: handlers.DetectedTools{},
+		Errors:   []int{fiber.StatusInternalServerError},
+	})
+	b.Add(fiber.MethodGet, "/tools/ports", openapi.Route{
+		ID: "getAllocatedPorts", Summary: "List the allocated ports", Tags: []string{"tools"},
+		Response: handlers.AllocatedPorts{},
+	})
+	b.Add(fiber.MethodGet, "/tools/port-registry", openapi.Route{
+		ID: "getPortRegistry", Summary: "Get the default and alternative ports of each tool", Tags: []string{"tools"},
+		Response: handlers.PortRegistry{},
+	})
+	b.Add(fiber.MethodPost, "/tools/allocate-port", openapi.Route{
+		ID: "allocatePort", Summary: "Allocate a port for a tool", Tags: []string{"tools"},
+		Request:  handlers.PortRequest{},
+		Response: handlers.PortAllocation{},
+		Errors:   []int{fiber.StatusBadRequest, fiber.StatusInternalServerError},
+	})
+	b.Add(fiber.MethodDelete, "/tools/ports/:port", openapi.Route{
+		ID: "releasePort", Summary: "Release an allocated port", Tags: []string{"tools"},
+		Status: fiber.StatusNoContent,
+		Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+	})
+	b.Add(fiber.MethodGet, "/tools/:tool", openapi.Route{
+		ID: "openTool", Summary: "Redirect to the UI of a tool", Tags: []string{"tools"},
+		Status: fiber.StatusTemporaryRedirect,
+		Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+	})
+	b.Add(fiber.MethodGet, "/tools/:tool/health", openapi.Route{
+		ID: "getToolHealth", Summary: "Check the health of a tool", Tags: []string{"tools"},
+		Response: handlers.ToolHealth{},
+		Errors:   []int{fiber.StatusBadRequest, fiber.StatusNotFound, fiber.StatusInternalServerError},
+	})
+	b.Add(fiber.MethodGet, "/tools/:tool/config", openapi.Route{
+		ID: "getToolConfig", Summary: "Render the default configuration of a tool", Tags: []string{"tools"},
+		Response: "",
+		Errors:   []int{fiber.StatusBadRequest},
+	})
+	b.Add(fiber.MethodPost, "/tools/:tool/config", openapi.Route{
+		ID: "renderToolConfig", Summary: "Render the configuration of a tool from template data", Tags: []string{"tools"},
+		Request:  map[string]any{},
+		Response: "",
+		Errors:   []int{fiber.StatusBadRequest},
+	})
+
+	// Tenants
+	b.Add(fiber.MethodGet, "/api/v1/tenants/usage", openapi.Route{
+		ID: "listTenantUsage", Summary: "List the usage of every tenant, or only the caller's", Tags: []string{"tenants"},
+		Response: handlers.TenantUsageList{},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/tenants/:tenant/usage", openapi.Route{
+		ID: "getTenantUsage", Summary: "Get the usage and effective limits of a tenant", Tags: []string{"tenants"},
+		Response: handlers.TenantUsage{},
+		Errors:   []int{fiber.StatusForbidden, fiber.StatusNotFound},
+	})
+
+	// Queries
+	b.Add(fiber.MethodGet, "/api/v1/lookup", openapi.Route{
+		ID: "lookup", Summary: "Find the traces and logs of a business identifier", Tags: []string{"queries"},
+		Query: []openapi.Param{
+			{Name: "attribute", Description: "Span attribute or log field, such as order.id", Required: true},
+			{Name: "value", Description: "Value to look up", Required: true},
+			{Name: "service", Description: "Only search this service"},
+			{Name: "since", Description: "How far back to search, such as 6h"},
+			{Name: "limit", Description: "Maximum traces and log lines per backend", Type: 0},
+		},
+		Response: lookup.Result{},
+		Errors:   []int{fiber.StatusBadRequest, fiber.StatusBadGateway},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/latency/heatmap", openapi.Route{
+		ID: "getLatencyHeatmap", Summary: "Get latency heatmaps from Prometheus histograms", Tags: []string{"queries"},
+		Description: "format=csv downloads the counts as CSV instead.",
+		Query: []openapi.Param{
+			{Name: "metric", Description: "Histogram metric, the HTTP request duration by default"},
+			{Name: "service", Description: "Only this service"},
+			{Name: "route", Description: "Only this route"},
+			{Name: "by", Description: "route or service to draw one heatmap per value of"},
+			{Name: "step", Description: "Resolution, such as 5m"},
+			{Name: "since", Description: "How far back to query, such as 6h"},
+		},
+		Response: latency.Result{},
+		Errors:   []int{fiber.StatusBadRequest, fiber.StatusBadGateway, fiber.StatusInternalServerError},
+	})
+
+	// Alerts
+	b.Add(fiber.MethodPost, "/api/v1/alerts/webhook", openapi.Route{
+		ID: "receiveAlerts", Summary: "Relay an Alertmanager notification to the configured webhooks", Tags: []string{"alerts"},
+		Request:  webhook.AlertmanagerPayload{},
+		Response: handlers.AlertReceipt{},
+		Errors:   []int{fiber.StatusBadRequest, fiber.StatusBadGateway},
+	})
+
+	// Deploys
+	b.Add(fiber.MethodPost, "/api/v1/events", openapi.Route{
+		ID: "recordEvent", Summary: "Record a deploy event in the incident timeline", Tags: []string{"deploys"},
+		Description: "With an event secret configured, requests are signed like outgoing webhooks.",
+		Request:     webhook.Event{},
+		Response:    handlers.EventReceipt{},
+		Errors:      []int{fiber.StatusBadRequest, fiber.StatusUnauthorized},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/incidents/:id", openapi.Route{
+		ID: "getIncidentTimeline", Summary: "Get the timeline of an incident", Tags: []string{"deploys"},
+		Response: handlers.IncidentTimeline{},
+		Errors:   []int{fiber.StatusNotFound, fiber.StatusInternalServerError},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/releases/:service", openapi.Route{
+		ID: "listReleases", Summary: "Get the health scores of the latest releases of a service", Tags: []string{"deploys"},
+		Query: []openapi.Param{
+			{Name: "limit", Description: "Releases to score, 10 by default", Type: 0},
+		},
+		Response: handlers.ReleaseHistory{},
+		Errors:   []int{fiber.StatusNotFound, fiber.StatusBadGateway},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/releases/:service/:version", openapi.Route{
+		ID: "getRelease", Summary: "Get the health score of one release", Tags: []string{"deploys"},
+		Response: release.Health{},
+		Errors:   []int{fiber.StatusNotFound, fiber.StatusBadGateway},
+	})
+
+	// Changes
+	b.Add(fiber.MethodGet, "/api/v1/changes", openapi.Route{
+		ID: "listChanges", Summary: "List change requests, newest first", Tags: []string{"changes"},
+		Query: []openapi.Param{
+			{Name: "status", Description: "Only pending, approved, rejected, or applied requests"},
+		},
+		Response: handlers.ChangeList{},
+	})
+	b.Add(fiber.MethodPost, "/api/v1/changes", openapi.Route{
+		ID: "proposeChange", Summary: "Propose new content for a rule file, dashboard, or the alert routes", Tags: []string{"changes"},
+		Description: "The content is validated and the request records its diff against the current file.",
+		Request:     changes.Proposal{},
+		Response:    changes.Request{},
+		Status:      fiber.StatusCreated,
+		Errors:      []int{fiber.StatusBadRequest},
+	})
+	b.Add(fiber.MethodGet, "/api/v1/changes/:id", openapi.Route{
+		ID: "getChange", Summary: "Get a change request", Tags: []string{"changes"},
+		Description: "format=diff returns only the unified diff.",
+		Response:    changes.Request{},
+		Errors:      []int{fiber.StatusNotFound},
+	})
+	b.Add(fiber.MethodPost, "/api/v1/changes/:id/approve", openapi.Route{
+		ID: "approveChange", Summary: "Approve a pending change request", Tags: []string{"changes"},
+		Description: "Needs an approver role; authors cannot approve their own changes.",
+		Request:     handlers.ChangeReview{},
+		Response:    changes.Request{},
+		Errors:      []int{fiber.StatusForbidden, fiber.StatusNotFound, fiber.StatusConflict},
+	})
+	b.Add(fiber.MethodPost, "/api/v1/changes/:id/reject", openapi.Route{
+		ID: "rejectChange", Summary: "Reject a pending change request, or withdraw one's own", Tags: []string{"changes"},
+		Request:  handlers.ChangeReview{},
+		Response: changes.Request{},
+		Errors:   []int{fiber.StatusForbidden, fiber.StatusNotFound, fiber.StatusConflict},
+	})
+	b.Add(fiber.MethodPost, "/api/v1/changes/:id/apply", openapi.Route{
+		ID: "applyChange", Summary: "Write an approved change request and reload its backend", Tags: []string{"changes"},
+		Description: "Refused when the file changed since the diff was rendered.",
+		Response:    changes.Request{},
+		Errors:      []int{fiber.StatusForbidden, fiber.StatusNotFound, fiber.StatusConflict},
+	})
+
+	b.Add(fiber.MethodGet, OpenAPIPath, openapi.Route{
+		ID: "getOpenAPI", Summary: "Get this OpenAPI document", Tags: []string{"status"},
+		Response: map[string]any{},
+	})
+
+	// Every route but the public ones accepts an API token
+	doc := b.Document()
+	for path, item := range doc.Paths {
+		for _, op := range item.Operations() {
+			if !access.Match(AccessRules, op.Method, path).Public {
+				op.Operation.Security = []map[string][]string{{"bearerAuth": {}}}
+			}
+		}
+	}
+	return doc
+}
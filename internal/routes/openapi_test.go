@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/openapi"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	app := fiber.New()
+	if err := SetupAccess(app, access.Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetupRoutes(app); err != nil {
+		t.Fatal(err)
+	}
+	SetupLookup(app, nil)
+	SetupLatency(app, nil)
+	SetupWebhooks(app, nil, nil)
+	SetupIncidents(app, nil, "")
+	SetupReleases(app, nil)
+	SetupLeader(app, nil)
+	SetupChanges(app, nil)
+	tenants := app.Group("/api/v1/tenants")
+	tenants.Get("/usage", func(c *fiber.Ctx) error { return nil })
+	tenants.Get("/:tenant/usage", func(c *fiber.Ctx) error { return nil })
+
+	doc := OpenAPI()
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for _, op := range item.Operations() {
+			documented[op.Method+" "+path] = true
+		}
+	}
+
+	served := make(map[string]bool)
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		path := route.Path
+		for _, segment := range strings.Split(path, "/") {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				path = strings.Replace(path, segment, "{"+name+"}", 1)
+			}
+		}
+		key := route.Method + " " + path
+		served[key] = true
+		if !documented[key] {
+			t.Errorf("%s is served but not documented", key)
+		}
+	}
+	for key := range documented {
+		if !served[key] {
+			t.Errorf("%s is documented but not served", key)
+		}
+	}
+}
+
+func TestGeneratedClientIsCurrent(t *testing.T) {
+	want, err := openapi.GenerateClient(OpenAPI(), "apmclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../pkg/apmclient/client.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("pkg/apmclient is out of date; run go generate ./pkg/apmclient")
+	}
+}
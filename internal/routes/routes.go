@@ -17,9 +17,21 @@ package routes
 
 import (
 	"github.com/chaksack/apm/internal/handlers"
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/latency"
+	"github.com/chaksack/apm/pkg/leader"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/openapi"
+	"github.com/chaksack/apm/pkg/release"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/chaksack/apm/pkg/webhook"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // SetupRoutes configures all application routes
@@ -34,6 +46,9 @@ func SetupRoutes(app *fiber.App) error {
 	// Prometheus metrics endpoint
 	app.Get("/metrics", handlers.Metrics)
 
+	// OpenAPI document of this API
+	app.Get(OpenAPIPath, openapi.Handler(OpenAPI()))
+
 	// API v1 routes
 	api := app.Group("/api/v1")
 	api.Get("/status", handlers.Status)
@@ -51,6 +66,7 @@ func SetupRoutes(app *fiber.App) error {
 	tools.Get("/ports", toolHandlers.GetAllocatedPorts)
 	tools.Get("/port-registry", toolHandlers.GetPortRegistry)
 	tools.Post("/allocate-port", toolHandlers.AllocatePort)
+	tools.Delete("/ports/:port", toolHandlers.ReleasePort)
 	tools.Get("/:tool", toolHandlers.RedirectToTool)
 	tools.Get("/:tool/health", toolHandlers.GetToolHealth)
 	tools.Get("/:tool/config", toolHandlers.GetToolConfig)
@@ -58,3 +74,122 @@ func SetupRoutes(app *fiber.App) error {
 
 	return nil
 }
+
+// AccessRules are the scopes the routes need. Routes not listed need
+// view-metrics to read and deploy to change anything.
+var AccessRules = []access.Rule{
+	{Path: "/health", Public: true},
+	{Path: "/metrics", Public: true},
+	{Path: OpenAPIPath, Public: true},
+	// Chat requests are signed by the platform and authorized per chat user
+	{Path: "/api/v1/chatops/", Public: true},
+	// Notifications and deploy events are recorded, not acted on, so they
+	// are accepted in read-only mode
+	{Method: fiber.MethodPost, Path: "/api/v1/alerts/webhook", Scope: access.ScopeManageAlerts},
+	{Method: fiber.MethodPost, Path: "/api/v1/events", Scope: access.ScopeDeploy},
+	// Change requests check the approver role of each step themselves
+	{Method: fiber.MethodPost, Path: "/api/v1/changes", Scope: access.ScopeManageAlerts, Write: true},
+	{Method: fiber.MethodPost, Path: "/api/v1/changes/", Scope: access.ScopeManageAlerts, Write: true},
+	{Method: fiber.MethodPost, Path: "/tools/allocate-port", Scope: access.ScopeDeploy, Write: true},
+	{Method: fiber.MethodDelete, Path: "/tools/ports/", Scope: access.ScopeDeploy, Write: true},
+	// The other posts generate a tool's configuration, changing nothing
+	{Method: fiber.MethodPost, Path: "/tools/", Scope: access.ScopeViewMetrics},
+}
+
+// SetupAccess enforces the access policy and serves the caller's access at
+// /api/v1/access. It must be called before the other Setup functions so the
+// policy covers every route.
+func SetupAccess(app *fiber.App, policy access.Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	app.Use(access.Middleware(policy, AccessRules))
+
+	accessHandlers := handlers.NewAccessHandlers(policy)
+	app.Get("/api/v1/access", accessHandlers.WhoAmI)
+	return nil
+}
+
+// SetupTenancy resolves and meters the tenant of every request and exposes
+// tenant usage. It must be called before SetupRoutes so the tenant middleware
+// runs ahead of the other routes. The meter is returned so its usage can be
+// persisted.
+func SetupTenancy(app *fiber.App, config tenancy.Config) (*tenancy.Meter, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	meter := tenancy.NewMeter(prometheus.DefaultRegisterer)
+	app.Use(tenancy.Middleware(config, meter))
+
+	tenantHandlers := handlers.NewTenantHandlers(config, meter)
+	tenants := app.Group("/api/v1/tenants")
+	tenants.Get("/usage", tenantHandlers.ListUsage)
+	tenants.Get("/:tenant/usage", tenantHandlers.GetUsage)
+
+	return meter, nil
+}
+
+// SetupLookup exposes the support lookup API over the configured backends
+func SetupLookup(app *fiber.App, service *lookup.Service) {
+	lookupHandlers := handlers.NewLookupHandlers(service)
+	app.Get("/api/v1/lookup", lookupHandlers.Lookup)
+}
+
+// SetupLatency exposes latency heatmaps from Prometheus histograms
+func SetupLatency(app *fiber.App, client *latency.Client) {
+	latencyHandlers := handlers.NewLatencyHandlers(client)
+	app.Get("/api/v1/latency/heatmap", latencyHandlers.Heatmap)
+}
+
+// SetupWebhooks relays Alertmanager notifications posted to
+// /api/v1/alerts/webhook to the configured webhook endpoints and, when
+// incidents is not nil, summarizes firing alert groups
+func SetupWebhooks(app *fiber.App, emitter *webhook.Emitter, incidents *incident.Summarizer) {
+	alertHandlers := handlers.NewAlertHandlers(emitter, incidents)
+	app.Post("/api/v1/alerts/webhook", alertHandlers.Receive)
+}
+
+// SetupIncidents records deploy events posted to /api/v1/events and serves
+// incident timelines at /api/v1/incidents/:id
+func SetupIncidents(app *fiber.App, summarizer *incident.Summarizer, eventSecret string) {
+	incidentHandlers := handlers.NewIncidentHandlers(summarizer, eventSecret)
+	app.Post("/api/v1/events", incidentHandlers.ReceiveEvent)
+	app.Get("/api/v1/incidents/:id", incidentHandlers.Timeline)
+}
+
+// SetupChanges serves the change requests of rules, dashboards, and alert
+// routes at /api/v1/changes
+func SetupChanges(app *fiber.App, manager *changes.Manager) {
+	changeHandlers := handlers.NewChangeHandlers(manager)
+	app.Get("/api/v1/changes", changeHandlers.List)
+	app.Post("/api/v1/changes", changeHandlers.Propose)
+	app.Get("/api/v1/changes/:id", changeHandlers.Get)
+	app.Post("/api/v1/changes/:id/approve", changeHandlers.Approve)
+	app.Post("/api/v1/changes/:id/reject", changeHandlers.Reject)
+	app.Post("/api/v1/changes/:id/apply", changeHandlers.Apply)
+}
+
+// SetupReleases serves release health scores at /api/v1/releases/:service
+// and /api/v1/releases/:service/:version
+func SetupReleases(app *fiber.App, scorer *release.Scorer) {
+	releaseHandlers := handlers.NewReleaseHandlers(scorer)
+	app.Get("/api/v1/releases/:service", releaseHandlers.History)
+	app.Get("/api/v1/releases/:service/:version", releaseHandlers.Version)
+}
+
+// SetupLeader serves the leader election state of this replica at
+// /api/v1/leader
+func SetupLeader(app *fiber.App, elector *leader.Elector) {
+	leaderHandlers := handlers.NewLeaderHandlers(elector)
+	app.Get("/api/v1/leader", leaderHandlers.Status)
+}
+
+// SetupChatOps receives chat commands from Slack slash commands at
+// /api/v1/chatops/slack and Teams outgoing webhooks at /api/v1/chatops/teams
+func SetupChatOps(app *fiber.App, bot *chatops.Bot, slackSigningSecret, teamsSecurityToken string) {
+	chatHandlers := handlers.NewChatOpsHandlers(bot, slackSigningSecret, teamsSecurityToken)
+	chat := app.Group("/api/v1/chatops")
+	chat.Post("/slack", chatHandlers.Slack)
+	chat.Post("/teams", chatHandlers.Teams)
+}
@@ -16,10 +16,31 @@
 package main
 
 import (
+	"context"
 	"github.com/gofiber/fiber/v2"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/chaksack/apm/internal/config"
 	"github.com/chaksack/apm/internal/routes"
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/janitor"
+	"github.com/chaksack/apm/pkg/latency"
+	"github.com/chaksack/apm/pkg/leader"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/release"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/chaksack/apm/pkg/store"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/chaksack/apm/pkg/webhook"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
@@ -28,9 +49,312 @@ func main() {
 		AppName: "APM Service",
 	})
 
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// On SIGTERM the server stops accepting requests, background work stops,
+	// and the leader releases its lease
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+	identity := cfg.HA.Identity
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+
+	// Server state lives in SQLite or Postgres, or in files with the file
+	// driver
+	var db *store.Store
+	if cfg.Storage.Driver != "file" {
+		db, err = store.Open(ctx, cfg.Storage.Config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+	}
+
+	// Read-only mode and API token scopes cover every route, so they are
+	// set up first
+	if err := routes.SetupAccess(app, cfg.Access); err != nil {
+		log.Fatal(err)
+	}
+
+	// Tenant isolation must be set up before the other routes
+	if cfg.Tenancy.Enabled {
+		meter, err := routes.SetupTenancy(app, cfg.Tenancy.Config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Usage survives restarts: it is restored at startup and saved
+		// every minute and at shutdown
+		if db != nil {
+			usage, err := db.LoadUsage(ctx, identity)
+			if err != nil {
+				log.Fatalf("failed to load tenant usage: %v", err)
+			}
+			meter.Restore(usage)
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				ticker := time.NewTicker(time.Minute)
+				defer ticker.Stop()
+				for done := false; !done; {
+					select {
+					case <-ctx.Done():
+						done = true
+					case <-ticker.C:
+					}
+					saveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					if err := db.SaveUsage(saveCtx, identity, meter.Snapshot()); err != nil {
+						log.Printf("failed to save tenant usage: %v", err)
+					}
+					cancel()
+				}
+			}()
+		}
+	}
+
 	// Setup routes
 	routes.SetupRoutes(app)
 
+	// Support lookup across the tracing and logging backends; with tenancy
+	// enabled the backends are queried as the calling tenant
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Tenancy.Enabled {
+		client.Transport = &tenancy.Transport{Header: cfg.Tenancy.HeaderName()}
+	}
+	lookupService := &lookup.Service{
+		Traces: []lookup.TraceSearcher{&lookup.Jaeger{URL: cfg.Jaeger.Endpoint, Client: client}},
+		Logs:   []lookup.LogSearcher{&lookup.Loki{URL: cfg.Loki.Endpoint, Client: client}},
+	}
+	routes.SetupLookup(app, lookupService)
+	routes.SetupLatency(app, &latency.Client{URL: cfg.Prometheus.Endpoint, Client: client})
+
+	// Times and numbers in chat replies and incident summaries
+	display, err := cfg.Display.Formatter()
+	if err != nil {
+		log.Fatalf("invalid display settings: %v", err)
+	}
+
+	// Chat commands from Slack and Teams, authorized per chat user and audited
+	if cfg.ChatOps.Enabled {
+		if err := cfg.ChatOps.Policy.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		var auditor chatops.Auditor = chatops.NewFileAuditor(cfg.ChatOps.AuditLog)
+		if db != nil {
+			auditor = db.Auditor()
+		}
+		routes.SetupChatOps(app, &chatops.Bot{
+			Policy:   cfg.ChatOps.Policy,
+			ReadOnly: cfg.Access.ReadOnly,
+			Display:  display,
+			Auditor:  auditor,
+			Status: &chatops.HealthChecker{Components: map[string]string{
+				"prometheus":   cfg.Prometheus.Endpoint + "/-/healthy",
+				"grafana":      cfg.Grafana.Endpoint + "/api/health",
+				"loki":         cfg.Loki.Endpoint + "/ready",
+				"jaeger":       cfg.Jaeger.Endpoint + "/",
+				"alertmanager": cfg.AlertManager.Endpoint + "/-/healthy",
+			}},
+			Silencer: &chatops.Alertmanager{URL: cfg.AlertManager.Endpoint},
+			Lookup:   lookupService,
+		}, cfg.ChatOps.SlackSigningSecret, cfg.ChatOps.TeamsSecurityToken)
+	}
+
+	// Relay Alertmanager notifications to the configured webhooks
+	var emitter *webhook.Emitter
+	if len(cfg.Webhooks) > 0 {
+		emitter, err = webhook.New(cfg.Webhooks...)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Summarize firing alert groups with correlated deploys, errors, traces,
+	// and logs, and post the summaries to the webhooks
+	var summarizer *incident.Summarizer
+	if cfg.Incidents.Enabled {
+		lookback, err := time.ParseDuration(cfg.Incidents.Lookback)
+		if err != nil {
+			log.Fatalf("invalid incidents.lookback: %v", err)
+		}
+		var timeline incident.Timeline = incident.NewFileTimeline(cfg.Incidents.Timeline)
+		if db != nil {
+			timeline = db.Timeline()
+		}
+		summarizer = &incident.Summarizer{
+			Traces:        lookupService.Traces[0],
+			Logs:          lookupService.Logs[0],
+			Timeline:      timeline,
+			Emitter:       emitter,
+			ServiceLabels: cfg.Incidents.ServiceLabels,
+			Lookback:      lookback,
+			MaxItems:      cfg.Incidents.MaxItems,
+			Display:       display,
+		}
+		routes.SetupIncidents(app, summarizer, cfg.Incidents.EventSecret)
+	}
+	// Score each deployed release from the deploys in the incident timeline
+	if cfg.Releases.Enabled {
+		if summarizer == nil {
+			log.Fatal("releases requires incidents.enabled")
+		}
+		window, err := time.ParseDuration(cfg.Releases.Window)
+		if err != nil {
+			log.Fatalf("invalid releases.window: %v", err)
+		}
+		scorer := &release.Scorer{
+			PrometheusURL:   cfg.Prometheus.Endpoint,
+			Client:          client,
+			Timeline:        summarizer.Timeline,
+			Window:          window,
+			ServiceLabel:    cfg.Releases.ServiceLabel,
+			SaturationQuery: cfg.Releases.SaturationQuery,
+			MaxErrorRate:    cfg.Releases.MaxErrorRate,
+		}
+		prometheus.MustRegister(scorer.Collector())
+		routes.SetupReleases(app, scorer)
+	}
+	if emitter != nil || summarizer != nil {
+		routes.SetupWebhooks(app, emitter, summarizer)
+	}
+
+	// Rules, dashboards, and alert routes change through reviewed change
+	// requests, recorded in the audit log
+	if cfg.Changes.Enabled {
+		if err := cfg.Changes.Config.Validate(cfg.Access); err != nil {
+			log.Fatal(err)
+		}
+		manager := &changes.Manager{
+			Config:  cfg.Changes.Config,
+			Store:   changes.NewFileStore(cfg.Changes.Store),
+			Auditor: changes.NewFileAuditor(cfg.Changes.AuditLog),
+		}
+		if db != nil {
+			manager.Store, manager.Auditor = db.Changes(), db.ChangeAuditor()
+		}
+		if cfg.Changes.Reload {
+			manager.Reloaders = map[string]changes.Reloader{
+				changes.KindRules:       changes.ReloadURL(client, cfg.Prometheus.Endpoint+"/-/reload"),
+				changes.KindAlertRoutes: changes.ReloadURL(client, cfg.AlertManager.Endpoint+"/-/reload"),
+			}
+		}
+		routes.SetupChanges(app, manager)
+	}
+
+	// Every replica checks the TLS certificates of the configured endpoints
+	// and exports their expiry
+	if cfg.CertificateMonitoring.Enabled {
+		certificates, err := cfg.CertificateMonitoring.Monitor()
+		if err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(certificates.Collectors()...)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			certificates.Start(ctx)
+		}()
+	}
+
+	// Singleton jobs run on one replica only: with HA enabled, the one
+	// holding the lease; every replica keeps serving the API
+	var lock leader.Lock = leader.Standalone{}
+	leaseDuration := leader.DefaultLeaseDuration
+	if cfg.HA.Enabled {
+		leaseDuration, err = time.ParseDuration(cfg.HA.LeaseDuration)
+		if err != nil {
+			log.Fatalf("invalid ha.lease_duration: %v", err)
+		}
+		switch cfg.HA.Backend {
+		case leader.BackendKubernetes:
+			kubeconfig := cfg.Kubernetes.ConfigPath
+			if cfg.Kubernetes.InCluster {
+				kubeconfig = ""
+			}
+			lock, err = leader.NewKubernetesLease(kubeconfig, cfg.Kubernetes.Namespace, cfg.HA.LeaseName)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case leader.BackendEtcd:
+			lock = &leader.EtcdLease{Endpoints: cfg.HA.Endpoints, Key: "apm/leader/" + cfg.HA.LeaseName, Token: cfg.HA.Token}
+		default:
+			log.Fatalf("invalid ha.backend %q: use kubernetes or etcd", cfg.HA.Backend)
+		}
+	}
+	elector := leader.New(lock, identity, leaseDuration)
+	prometheus.MustRegister(elector.Collectors()...)
+
+	// Back up the database from one replica
+	if db != nil && cfg.Storage.BackupInterval != "" {
+		interval, err := time.ParseDuration(cfg.Storage.BackupInterval)
+		if err != nil {
+			log.Fatalf("invalid storage.backup_interval: %v", err)
+		}
+		if cfg.Storage.BackupCommand != "" {
+			db.OnBackup(store.CommandHook(cfg.Storage.BackupCommand))
+		}
+		elector.Add("backup", func(ctx context.Context) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if path, err := db.Backup(ctx); err != nil {
+					log.Printf("database backup failed: %v", err)
+				} else {
+					log.Printf("database backed up to %s", path)
+				}
+			}
+		})
+	}
+
+	// Count the dashboards of services that stopped reporting
+	if cfg.Janitor.Enabled {
+		interval, err := time.ParseDuration(cfg.Janitor.Interval)
+		if err != nil {
+			log.Fatalf("invalid janitor.interval: %v", err)
+		}
+		lookback, err := retention.ParseDuration(cfg.Janitor.Lookback)
+		if err != nil {
+			log.Fatalf("invalid janitor.lookback: %v", err)
+		}
+		monitor := &janitor.Monitor{
+			Janitor: &janitor.Janitor{Sweepers: []janitor.Sweeper{&janitor.GrafanaDashboards{
+				GrafanaURL:    cfg.Grafana.Endpoint,
+				Token:         cfg.Grafana.APIKey,
+				PrometheusURL: cfg.Prometheus.Endpoint,
+				Lookback:      lookback,
+			}}},
+			Interval: interval,
+		}
+		prometheus.MustRegister(monitor.Collector())
+		elector.Add("janitor", monitor.Run)
+	}
+	routes.SetupLeader(app, elector)
+
+	// The leader releases the lease at shutdown, so another replica takes
+	// over without waiting for it to expire
+	elected := make(chan struct{})
+	go func() {
+		elector.Run(ctx)
+		close(elected)
+	}()
+	go func() {
+		<-ctx.Done()
+		app.Shutdown()
+	}()
+
 	// Start server
-	log.Fatal(app.Listen(":3000"))
+	if err := app.Listen(":3000"); err != nil {
+		log.Fatal(err)
+	}
+	<-elected
+	background.Wait()
 }
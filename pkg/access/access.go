@@ -0,0 +1,199 @@
+// Package access scopes what callers of the APM server and users of the CLI
+// may do. Roles grant scopes (view-metrics, manage-alerts, deploy), API tokens
+// are assigned roles, and read-only mode refuses every change regardless of
+// scope, so a platform team can hand the tool to developers without risking
+// production.
+package access
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scopes
+const (
+	ScopeViewMetrics  = "view-metrics"
+	ScopeManageAlerts = "manage-alerts"
+	ScopeDeploy       = "deploy"
+)
+
+// Scopes lists every scope
+var Scopes = []string{ScopeViewMetrics, ScopeManageAlerts, ScopeDeploy}
+
+// ReadOnlyEnv turns read-only mode on for the server and the CLI when set
+// to a true value
+const ReadOnlyEnv = "APM_READ_ONLY"
+
+// ErrReadOnly is returned for changes refused in read-only mode
+var ErrReadOnly = errors.New("apm is in read-only mode")
+
+// Built-in roles used when the policy defines none
+var defaultRoles = map[string][]string{
+	"viewer":   {ScopeViewMetrics},
+	"operator": {ScopeViewMetrics, ScopeManageAlerts},
+	"admin":    {ScopeViewMetrics, ScopeManageAlerts, ScopeDeploy},
+	// approver reviews change requests, see pkg/changes
+	"approver": {ScopeViewMetrics, ScopeManageAlerts},
+}
+
+// Token is an API token and the roles it is assigned. Only the SHA-256 of
+// the token is configured, e.g. printf %s "$TOKEN" | sha256sum.
+type Token struct {
+	Name   string   `mapstructure:"name" yaml:"name" json:"name"`
+	SHA256 string   `mapstructure:"sha256" yaml:"sha256" json:"-"`
+	Roles  []string `mapstructure:"roles" yaml:"roles" json:"roles"`
+}
+
+// Policy is the access policy. Scopes are enforced once a token is
+// configured; until then every caller has every scope, as before access
+// control existed. Read-only mode applies either way.
+type Policy struct {
+	// ReadOnly refuses every change
+	ReadOnly bool `mapstructure:"read_only" yaml:"read_only" json:"read_only"`
+
+	// Tokens are the API tokens of the server
+	Tokens []Token `mapstructure:"tokens" yaml:"tokens,omitempty" json:"tokens,omitempty"`
+
+	// Anonymous are the roles of requests without a token
+	Anonymous []string `mapstructure:"anonymous" yaml:"anonymous,omitempty" json:"anonymous,omitempty"`
+
+	// Roles maps a role to its scopes; empty uses the viewer, operator,
+	// admin, and approver roles
+	Roles map[string][]string `mapstructure:"roles" yaml:"roles,omitempty" json:"roles,omitempty"`
+}
+
+// Enabled reports whether scopes are enforced
+func (p Policy) Enabled() bool {
+	return len(p.Tokens) > 0
+}
+
+// Validate checks that every assigned role and granted scope exists and
+// that tokens are well-formed hashes
+func (p Policy) Validate() error {
+	roles := p.roles()
+	check := func(owner string, assigned []string) error {
+		for _, role := range assigned {
+			if _, ok := roles[role]; !ok {
+				return fmt.Errorf("access %s has unknown role %q", owner, role)
+			}
+		}
+		return nil
+	}
+	names := map[string]bool{}
+	for i, t := range p.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("access token %d has no name", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("access token %s is defined twice", t.Name)
+		}
+		names[t.Name] = true
+		if b, err := hex.DecodeString(t.SHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("access token %s: sha256 must be 64 hex characters", t.Name)
+		}
+		if err := check("token "+t.Name, t.Roles); err != nil {
+			return err
+		}
+	}
+	if err := check("anonymous", p.Anonymous); err != nil {
+		return err
+	}
+	for role, scopes := range roles {
+		for _, s := range scopes {
+			if !KnownScope(s) {
+				return fmt.Errorf("access role %s grants unknown scope %q", role, s)
+			}
+		}
+	}
+	return nil
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes"`
+}
+
+// Has reports whether the principal holds a scope
+func (p Principal) Has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns the principal of a token, or the anonymous principal
+// for an empty one. ok is false for an unknown token.
+func (p Policy) Authenticate(token string) (principal Principal, ok bool) {
+	if !p.Enabled() {
+		return Principal{Name: "anonymous", Scopes: append([]string(nil), Scopes...)}, true
+	}
+	if token == "" {
+		return p.principal("anonymous", p.Anonymous), true
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, t := range p.Tokens {
+		want, _ := hex.DecodeString(t.SHA256)
+		if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+			return p.principal(t.Name, t.Roles), true
+		}
+	}
+	return Principal{}, false
+}
+
+func (p Policy) principal(name string, assigned []string) Principal {
+	roles := p.roles()
+	granted := map[string]bool{}
+	for _, role := range assigned {
+		for _, s := range roles[role] {
+			granted[s] = true
+		}
+	}
+	principal := Principal{Name: name, Roles: append([]string(nil), assigned...)}
+	for _, s := range Scopes {
+		if granted[s] {
+			principal.Scopes = append(principal.Scopes, s)
+		}
+	}
+	sort.Strings(principal.Roles)
+	return principal
+}
+
+func (p Policy) roles() map[string][]string {
+	if len(p.Roles) == 0 {
+		return defaultRoles
+	}
+	return p.Roles
+}
+
+// HasRole reports whether role is defined
+func (p Policy) HasRole(role string) bool {
+	_, ok := p.roles()[role]
+	return ok
+}
+
+// KnownScope reports whether scope exists
+func KnownScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnlyFromEnv reports whether APM_READ_ONLY turns read-only mode on
+func ReadOnlyFromEnv() bool {
+	on, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(ReadOnlyEnv)))
+	return on
+}
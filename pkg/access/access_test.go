@@ -0,0 +1,133 @@
+package access
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func testPolicy() Policy {
+	return Policy{
+		Tokens: []Token{
+			{Name: "grafana", SHA256: hash("view-token"), Roles: []string{"viewer"}},
+			{Name: "ci", SHA256: hash("deploy-token"), Roles: []string{"admin"}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testPolicy().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []Policy{
+		{Tokens: []Token{{Name: "x", SHA256: "abc", Roles: []string{"viewer"}}}},
+		{Tokens: []Token{{Name: "x", SHA256: hash("t"), Roles: []string{"root"}}}},
+		{Tokens: []Token{{SHA256: hash("t")}}},
+		{Anonymous: []string{"nobody"}},
+		{Roles: map[string][]string{"viewer": {"delete-everything"}}},
+	}
+	for i, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("policy %d: expected an error", i)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	p := testPolicy()
+	principal, ok := p.Authenticate("view-token")
+	if !ok || principal.Name != "grafana" || !principal.Has(ScopeViewMetrics) || principal.Has(ScopeDeploy) {
+		t.Errorf("viewer = %+v, %v", principal, ok)
+	}
+	if _, ok := p.Authenticate("guess"); ok {
+		t.Error("expected an unknown token to be rejected")
+	}
+	if anonymous, _ := p.Authenticate(""); len(anonymous.Scopes) != 0 {
+		t.Errorf("anonymous scopes = %v", anonymous.Scopes)
+	}
+	// Without tokens every caller has every scope
+	if open, _ := (Policy{}).Authenticate(""); len(open.Scopes) != len(Scopes) {
+		t.Errorf("open scopes = %v", open.Scopes)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rules := []Rule{
+		{Path: "/health", Public: true},
+		{Method: fiber.MethodPost, Path: "/alerts/", Scope: ScopeManageAlerts},
+		{Method: fiber.MethodPost, Path: "/tools/allocate-port", Scope: ScopeDeploy, Write: true},
+		{Method: fiber.MethodPost, Path: "/tools/", Scope: ScopeViewMetrics},
+	}
+	newApp := func(p Policy) *fiber.App {
+		app := fiber.New()
+		app.Use(Middleware(p, rules))
+		app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+		return app
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"public", testPolicy(), "GET", "/health", "", 204},
+		{"no token", testPolicy(), "GET", "/api/v1/status", "", 401},
+		{"bad token", testPolicy(), "GET", "/api/v1/status", "guess", 401},
+		{"viewer reads", testPolicy(), "GET", "/api/v1/status", "view-token", 204},
+		{"viewer changes", testPolicy(), "DELETE", "/tools/ports/80", "view-token", 403},
+		{"viewer posts alerts", testPolicy(), "POST", "/alerts/webhook", "view-token", 403},
+		{"admin changes", testPolicy(), "DELETE", "/tools/ports/80", "deploy-token", 204},
+		{"open", Policy{}, "DELETE", "/tools/ports/80", "", 204},
+		{"read-only", Policy{ReadOnly: true}, "DELETE", "/tools/ports/80", "", 403},
+		{"read-only admin", Policy{ReadOnly: true, Tokens: testPolicy().Tokens}, "DELETE", "/tools/ports/80", "deploy-token", 403},
+		{"read-only reads", Policy{ReadOnly: true}, "POST", "/alerts/webhook", "", 204},
+		{"read-only trailing slash", Policy{ReadOnly: true}, "POST", "/tools/allocate-port/", "", 403},
+		{"read-only mixed case", Policy{ReadOnly: true}, "POST", "/tools/Allocate-Port", "", 403},
+		{"viewer mixed case alerts", testPolicy(), "POST", "/Alerts/webhook", "view-token", 403},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := newApp(tt.policy).Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestCLIPolicy(t *testing.T) {
+	viewer := CLIPolicy{Scopes: []string{ScopeViewMetrics}}
+	if err := viewer.Check("apm status", ScopeViewMetrics, false); err != nil {
+		t.Error(err)
+	}
+	if err := viewer.Check("apm deploy", ScopeDeploy, true); err == nil {
+		t.Error("expected deploy to need the deploy scope")
+	}
+	readOnly := CLIPolicy{ReadOnly: true}
+	if err := readOnly.Check("apm deploy", ScopeDeploy, true); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("err = %v, want ErrReadOnly", err)
+	}
+	if err := readOnly.Check("apm deploy --dry-run", ScopeDeploy, false); err != nil {
+		t.Error(err)
+	}
+	if err := (CLIPolicy{Scopes: []string{"root"}}).Validate(); err == nil {
+		t.Error("expected an unknown scope to be rejected")
+	}
+}
@@ -0,0 +1,43 @@
+package access
+
+import "fmt"
+
+// CLIPolicy is the access section of apm.yaml. It limits what the CLI may do
+// from a checkout a platform team hands out; it is a guard rail, not a
+// security boundary, since the file can be edited.
+type CLIPolicy struct {
+	ReadOnly bool     `mapstructure:"read_only" yaml:"read_only" json:"read_only"`
+	Scopes   []string `mapstructure:"scopes" yaml:"scopes,omitempty" json:"scopes,omitempty"`
+}
+
+// Validate checks that every scope exists
+func (p CLIPolicy) Validate() error {
+	for _, s := range p.Scopes {
+		if !KnownScope(s) {
+			return fmt.Errorf("access.scopes has unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+// Check refuses an action needing scope, or changing something when write
+// is true in read-only mode. Empty Scopes grants every scope.
+func (p CLIPolicy) Check(action, scope string, write bool) error {
+	if p.ReadOnly && write {
+		return fmt.Errorf("%w: %s changes %s", ErrReadOnly, action, describe(scope))
+	}
+	if len(p.Scopes) > 0 && !(Principal{Scopes: p.Scopes}).Has(scope) {
+		return fmt.Errorf("%s needs the %s scope, which access.scopes in apm.yaml does not grant", action, scope)
+	}
+	return nil
+}
+
+func describe(scope string) string {
+	switch scope {
+	case ScopeDeploy:
+		return "deployments or configuration"
+	case ScopeManageAlerts:
+		return "alerting"
+	}
+	return "monitoring data"
+}
@@ -0,0 +1,115 @@
+package access
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LocalsKey is the fiber.Ctx locals key holding the Principal of a request
+const LocalsKey = "access.principal"
+
+// Rule is the scope a route needs. Path matches exactly or, ending in /, as
+// a prefix; an empty Method matches every method. Like fiber's default
+// router, matching ignores case and a trailing slash.
+type Rule struct {
+	Method string
+	Path   string
+
+	// Public routes need no scope, e.g. health checks and routes that
+	// verify their own signatures
+	Public bool
+
+	// Scope is the scope the route needs
+	Scope string
+
+	// Write marks routes that change something, refused in read-only mode
+	Write bool
+}
+
+func (r Rule) matches(method, path string) bool {
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	rulePath := strings.ToLower(r.Path)
+	if strings.HasSuffix(rulePath, "/") {
+		return strings.HasPrefix(path, rulePath)
+	}
+	return path == rulePath
+}
+
+// normalizePath lowercases a request path and trims its trailing slash, so
+// /tools/Allocate-Port/ matches the same rule as the route fiber serves it
+// from
+func normalizePath(path string) string {
+	path = strings.ToLower(path)
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// Match returns the first rule matching a request. Requests no rule matches
+// need view-metrics when they only read, and deploy, as a change, otherwise.
+func Match(rules []Rule, method, path string) Rule {
+	normalized := normalizePath(path)
+	for _, r := range rules {
+		if r.matches(method, normalized) {
+			return r
+		}
+	}
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return Rule{Method: method, Path: path, Scope: ScopeViewMetrics}
+	}
+	return Rule{Method: method, Path: path, Scope: ScopeDeploy, Write: true}
+}
+
+// Middleware authenticates the bearer token of each request and refuses
+// requests lacking the scope their route needs, and changes in read-only
+// mode
+func Middleware(policy Policy, rules []Rule) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rule := Match(rules, c.Method(), c.Path())
+		if rule.Public {
+			return c.Next()
+		}
+		if policy.ReadOnly && rule.Write {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": ErrReadOnly.Error(),
+			})
+		}
+
+		token := ""
+		if auth := c.Get(fiber.HeaderAuthorization); auth != "" {
+			scheme, value, _ := strings.Cut(auth, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "unsupported authorization scheme, use Bearer",
+				})
+			}
+			token = strings.TrimSpace(value)
+		}
+		principal, ok := policy.Authenticate(token)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "invalid token",
+			})
+		}
+		if !principal.Has(rule.Scope) {
+			status := fiber.StatusForbidden
+			if token == "" {
+				status = fiber.StatusUnauthorized
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"error": "missing scope " + rule.Scope,
+			})
+		}
+
+		c.Locals(LocalsKey, principal)
+		return c.Next()
+	}
+}
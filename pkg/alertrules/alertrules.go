@@ -0,0 +1,440 @@
+// Package alertrules changes many Prometheus alerting rules at once: rules
+// matching a label selector are disabled, enabled, or get their thresholds
+// set or scaled, across every rule file, with a preview of each change before
+// anything is written.
+//
+// Prometheus cannot switch a rule off, so a disabled rule keeps its place in
+// the file with its expression wrapped to never return a series. Enabling it
+// unwraps the original expression.
+package alertrules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// disabledSuffix wraps the expression of a disabled rule; unless on() drops
+// every series the expression returns
+const disabledSuffix = ") unless on() vector(1)"
+
+// ErrNoThreshold is returned for rules whose expression does not end in a
+// comparison with a number, such as up == 0 or rate(x[5m]) > 0.05
+var ErrNoThreshold = errors.New("expression does not end in a comparison with a number")
+
+// threshold matches the trailing comparison of an expression
+var threshold = regexp.MustCompile(`(>=|<=|==|!=|>|<)(\s*(?:bool\s+)?)(-?(?:\d+\.?\d*|\.\d+)(?:[eE][+-]?\d+)?)\s*$`)
+
+// Selector selects rules by label, e.g. service=checkout severity=critical.
+// The alert name matches as alertname. Values are shell patterns, so
+// severity=crit* and alertname=High* work.
+type Selector map[string]string
+
+// ParseSelector parses comma separated label=value pairs
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid selector %q, want label=value", pair)
+		}
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in selector %q: %w", pair, err)
+		}
+		sel[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return sel, nil
+}
+
+// Matches reports whether every label of the selector matches
+func (s Selector) Matches(labels map[string]string) bool {
+	for name, pattern := range s {
+		if ok, _ := path.Match(pattern, labels[name]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the selector as ParseSelector reads it
+func (s Selector) String() string {
+	pairs := make([]string, 0, len(s))
+	for name, value := range s {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Update is the change made to each selected rule. Threshold sets the
+// threshold and Factor, when not zero, multiplies it.
+type Update struct {
+	Enable    *bool
+	Threshold *float64
+	Factor    float64
+}
+
+// Change is one change to a rule
+type Change struct {
+	File  string `json:"file"`
+	Group string `json:"group"`
+	Alert string `json:"alert"`
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	Error string `json:"error,omitempty"`
+}
+
+// Rule is an alerting rule in a rule file
+type Rule struct {
+	File     string
+	Group    string
+	Alert    string
+	Labels   map[string]string
+	Disabled bool
+
+	key  *yaml.Node
+	expr *yaml.Node
+}
+
+// Expr returns the expression of the rule, unwrapped when disabled
+func (r *Rule) Expr() string {
+	expr, _ := unwrap(r.expr.Value)
+	return expr
+}
+
+// Threshold returns the number the expression compares with
+func (r *Rule) Threshold() (float64, error) {
+	m := threshold.FindStringSubmatch(r.Expr())
+	if m == nil {
+		return 0, ErrNoThreshold
+	}
+	return strconv.ParseFloat(m[3], 64)
+}
+
+// file is a parsed rule file
+type file struct {
+	path    string
+	data    []byte
+	changed []*Rule
+}
+
+// RuleSet is the alerting rules of a set of rule files
+type RuleSet struct {
+	files []*file
+	rules []*Rule
+	owner map[*Rule]*file
+}
+
+// Load reads rule files, and the .yml and .yaml files of directories
+func Load(paths ...string) (*RuleSet, error) {
+	rs := &RuleSet{owner: make(map[*Rule]*file)}
+	for _, p := range paths {
+		names, err := ruleFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if err := rs.load(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rs, nil
+}
+
+func ruleFiles(p string) ([]string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+	var names []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, _ := filepath.Glob(filepath.Join(p, pattern))
+		names = append(names, matches...)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (rs *RuleSet) load(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("failed to read rules: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid rule file %s: %w", name, err)
+	}
+	f := &file{path: name, data: data}
+	rs.files = append(rs.files, f)
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	for _, group := range items(lookup(doc.Content[0], "groups")) {
+		groupName := scalar(lookup(group, "name"))
+		for _, node := range items(lookup(group, "rules")) {
+			alert := scalar(lookup(node, "alert"))
+			key, expr := lookupPair(node, "expr")
+			// Recording rules have no threshold to change
+			if alert == "" || expr == nil || expr.Kind != yaml.ScalarNode {
+				continue
+			}
+			labels := map[string]string{}
+			if l := lookup(node, "labels"); l != nil && l.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(l.Content); i += 2 {
+					labels[l.Content[i].Value] = l.Content[i+1].Value
+				}
+			}
+			labels["alertname"] = alert
+			_, disabled := unwrap(expr.Value)
+			r := &Rule{File: name, Group: groupName, Alert: alert, Labels: labels, Disabled: disabled, key: key, expr: expr}
+			rs.rules = append(rs.rules, r)
+			rs.owner[r] = f
+		}
+	}
+	return nil
+}
+
+// Rules returns the alerting rules the selector matches
+func (rs *RuleSet) Rules(sel Selector) []*Rule {
+	var rules []*Rule
+	for _, r := range rs.rules {
+		if sel.Matches(r.Labels) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Apply changes every rule the selector matches in memory and returns the
+// changes; Save writes them. Rules whose threshold cannot be changed are
+// reported with an error and left alone.
+func (rs *RuleSet) Apply(sel Selector, u Update) []Change {
+	var changes []Change
+	for _, r := range rs.Rules(sel) {
+		change := func(field, old, new string) Change {
+			return Change{File: r.File, Group: r.Group, Alert: r.Alert, Field: field, Old: old, New: new}
+		}
+		expr, disabled := unwrap(r.expr.Value)
+		dirty := false
+
+		if u.Threshold != nil || u.Factor != 0 {
+			updated, old, value, err := setThreshold(expr, u)
+			if err != nil {
+				c := change("threshold", "", "")
+				c.Error = err.Error()
+				changes = append(changes, c)
+			} else if updated != expr {
+				changes = append(changes, change("threshold", old, value))
+				expr, dirty = updated, true
+			}
+		}
+		if u.Enable != nil && *u.Enable == disabled {
+			changes = append(changes, change("enabled", strconv.FormatBool(!disabled), strconv.FormatBool(disabled)))
+			disabled, dirty = !disabled, true
+		}
+		if !dirty {
+			continue
+		}
+
+		r.expr.Value = expr
+		if disabled {
+			r.expr.Value = "(" + strings.TrimSpace(expr) + disabledSuffix
+		}
+		r.Disabled = disabled
+		if f := rs.owner[r]; !containsRule(f.changed, r) {
+			f.changed = append(f.changed, r)
+		}
+	}
+	return changes
+}
+
+// Save writes the changed rule files and returns their paths. Only the
+// changed expressions are rewritten; comments, blank lines, and the rest of
+// each file are kept as they are.
+func (rs *RuleSet) Save() ([]string, error) {
+	var written []string
+	for _, f := range rs.files {
+		if len(f.changed) == 0 {
+			continue
+		}
+		data, err := patch(f.data, f.changed)
+		if err != nil {
+			return written, fmt.Errorf("failed to update %s: %w", f.path, err)
+		}
+
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return written, err
+		}
+		tmp := f.path + ".tmp"
+		if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+		if err := os.Rename(tmp, f.path); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+		f.data, f.changed = data, nil
+		written = append(written, f.path)
+	}
+	return written, nil
+}
+
+// patch replaces the expression values of rules in data. A value runs from
+// its start to the last line indented deeper than its key, which covers
+// plain, quoted, and block scalars.
+func patch(data []byte, rules []*Rule) ([]byte, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	sorted := append([]*Rule(nil), rules...)
+	// From the bottom up, so earlier line numbers stay valid
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].expr.Line > sorted[j].expr.Line })
+
+	for _, r := range sorted {
+		start, col := r.expr.Line-1, r.expr.Column-1
+		indent := r.key.Column - 1
+		if start >= len(lines) || col > len(lines[start]) {
+			return nil, fmt.Errorf("expression of %s not found", r.Alert)
+		}
+		end := start
+		for i := start + 1; i < len(lines); i++ {
+			line := strings.TrimRight(lines[i], "\r\n")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if len(line)-len(strings.TrimLeft(line, " ")) <= indent {
+				break
+			}
+			end = i
+		}
+		newline := ""
+		if strings.HasSuffix(lines[end], "\n") {
+			newline = "\n"
+		}
+		value, err := encodeScalar(r.expr.Value, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		replaced := lines[start][:col] + value + newline
+		lines = append(lines[:start], append([]string{replaced}, lines[end+1:]...)...)
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// encodeScalar encodes a value for the position after "expr: ", as a literal
+// block indented by indent spaces when it spans lines
+func encodeScalar(value string, indent int) (string, error) {
+	if strings.Contains(strings.TrimRight(value, "\n"), "\n") {
+		header := "|-"
+		if strings.HasSuffix(value, "\n") {
+			header = "|"
+		}
+		var b strings.Builder
+		b.WriteString(header)
+		for _, line := range strings.Split(strings.TrimRight(value, "\n"), "\n") {
+			b.WriteString("\n")
+			if line != "" {
+				b.WriteString(strings.Repeat(" ", indent) + line)
+			}
+		}
+		return b.String(), nil
+	}
+	out, err := yaml.Marshal(strings.TrimRight(value, "\n"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// setThreshold returns expr with its threshold set or scaled, and the old and
+// new threshold
+func setThreshold(expr string, u Update) (string, string, string, error) {
+	loc := threshold.FindStringSubmatchIndex(expr)
+	if loc == nil {
+		return expr, "", "", ErrNoThreshold
+	}
+	old := expr[loc[6]:loc[7]]
+	current, err := strconv.ParseFloat(old, 64)
+	if err != nil {
+		return expr, "", "", err
+	}
+	v := current
+	if u.Threshold != nil {
+		v = *u.Threshold
+	}
+	if u.Factor != 0 {
+		v *= u.Factor
+	}
+	// 5 and 5.0 are the same threshold
+	if v == current {
+		return expr, old, old, nil
+	}
+	value := strconv.FormatFloat(v, 'g', -1, 64)
+	return expr[:loc[6]] + value + expr[loc[7]:], old, value, nil
+}
+
+// unwrap returns the expression of a disabled rule and whether it was
+func unwrap(expr string) (string, bool) {
+	trimmed := strings.TrimSpace(expr)
+	if strings.HasPrefix(trimmed, "(") && strings.HasSuffix(trimmed, disabledSuffix) {
+		return trimmed[1 : len(trimmed)-len(disabledSuffix)], true
+	}
+	return expr, false
+}
+
+func lookup(node *yaml.Node, key string) *yaml.Node {
+	_, value := lookupPair(node, key)
+	return value
+}
+
+// lookupPair returns the key and value nodes of a mapping entry
+func lookupPair(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func containsRule(rules []*Rule, r *Rule) bool {
+	for _, c := range rules {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+func items(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+func scalar(node *yaml.Node) string {
+	if node == nil {
+		return ""
+	}
+	return node.Value
+}
@@ -0,0 +1,144 @@
+package alertrules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const rulesYAML = `groups:
+  - name: checkout
+    rules:
+      # Error rate of the checkout service
+      - alert: CheckoutErrors
+        expr: rate(http_errors_total{service="checkout"}[5m]) > 0.05
+        labels:
+          service: checkout
+          environment: production
+          severity: critical
+      - alert: CheckoutLatency
+        expr: histogram_quantile(0.99, rate(http_duration_seconds_bucket[5m])) >= 1.5
+        labels:
+          service: checkout
+          environment: staging
+          severity: warning
+      - alert: CheckoutDown
+        expr: absent(up{service="checkout"})
+        labels:
+          service: checkout
+          severity: critical
+      - record: checkout:errors:rate5m
+        expr: rate(http_errors_total[5m])
+`
+
+func writeRules(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "checkout.yml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestParseSelector(t *testing.T) {
+	sel, err := ParseSelector("service=checkout, severity=crit*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sel.Matches(map[string]string{"service": "checkout", "severity": "critical"}) {
+		t.Error("expected a match")
+	}
+	if sel.Matches(map[string]string{"service": "checkout", "severity": "warning"}) {
+		t.Error("expected no match")
+	}
+	if sel.String() != "service=checkout,severity=crit*" {
+		t.Errorf("String() = %s", sel)
+	}
+	if _, err := ParseSelector("service"); err == nil {
+		t.Error("expected a selector without a value to be rejected")
+	}
+}
+
+func TestDisableAndEnable(t *testing.T) {
+	dir := writeRules(t)
+	rs, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(rs.Rules(Selector{})); got != 3 {
+		t.Fatalf("expected the three alerting rules, got %d", got)
+	}
+
+	disable := false
+	changes := rs.Apply(Selector{"severity": "critical"}, Update{Enable: &disable})
+	if len(changes) != 2 || changes[0].Field != "enabled" || changes[0].New != "false" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if written, err := rs.Save(); err != nil || len(written) != 1 {
+		t.Fatalf("written %v, %v", written, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "checkout.yml"))
+	if !strings.Contains(string(data), `(rate(http_errors_total{service="checkout"}[5m]) > 0.05) unless on() vector(1)`) {
+		t.Errorf("expected a wrapped expression\n%s", data)
+	}
+	if !strings.Contains(string(data), "# Error rate of the checkout service") {
+		t.Errorf("expected comments to be kept\n%s", data)
+	}
+
+	// Disabling again changes nothing; enabling restores the expressions
+	rs, _ = Load(dir)
+	if changes := rs.Apply(Selector{"severity": "critical"}, Update{Enable: &disable}); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+	enable := true
+	rs.Apply(Selector{}, Update{Enable: &enable})
+	rs.Save()
+	rs, _ = Load(dir)
+	for _, r := range rs.Rules(Selector{}) {
+		if r.Disabled || strings.Contains(r.expr.Value, "unless") {
+			t.Errorf("%s still disabled: %s", r.Alert, r.expr.Value)
+		}
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	dir := writeRules(t)
+	rs, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := rs.Apply(Selector{"service": "checkout"}, Update{Factor: 2})
+	want := map[string]string{"CheckoutErrors": "0.1", "CheckoutLatency": "3"}
+	for _, c := range changes {
+		if c.Alert == "CheckoutDown" {
+			if c.Error == "" {
+				t.Error("expected an error for an expression without a threshold")
+			}
+			continue
+		}
+		if c.New != want[c.Alert] {
+			t.Errorf("%s: threshold %s -> %s, want %s", c.Alert, c.Old, c.New, want[c.Alert])
+		}
+	}
+
+	// A dry run is Apply without Save: the file is unchanged
+	data, _ := os.ReadFile(filepath.Join(dir, "checkout.yml"))
+	if string(data) != rulesYAML {
+		t.Error("expected the file to be unchanged before Save")
+	}
+
+	set := 0.02
+	rs, _ = Load(dir)
+	disable := false
+	rs.Apply(Selector{"alertname": "CheckoutErrors"}, Update{Enable: &disable})
+	changes = rs.Apply(Selector{"alertname": "CheckoutErrors"}, Update{Threshold: &set})
+	if len(changes) != 1 || changes[0].Old != "0.05" || changes[0].New != "0.02" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	r := rs.Rules(Selector{"alertname": "CheckoutErrors"})[0]
+	if v, _ := r.Threshold(); v != 0.02 || !r.Disabled {
+		t.Errorf("threshold %v, disabled %v", v, r.Disabled)
+	}
+}
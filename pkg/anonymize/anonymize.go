@@ -0,0 +1,329 @@
+// Package anonymize exports traces and logs for a time window as OTLP JSON
+// with identifiers replaced by consistent keyed hashes and personal data
+// stripped, so they can be attached to vendor support tickets. The same
+// identifier always maps to the same hash within an export, and across
+// exports made with the same key, so traces still join with their logs.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Action is what happens to an attribute
+type Action string
+
+const (
+	// Keep exports the value with personal data in its text stripped
+	Keep Action = "keep"
+	// Hash replaces the value with a consistent hash
+	Hash Action = "hash"
+	// Drop leaves the attribute out
+	Drop Action = "drop"
+)
+
+// dropFragments mark attributes holding credentials, which are never
+// exported, even hashed
+var dropFragments = []string{
+	"password", "passwd", "secret", "token", "authorization", "cookie",
+	"api_key", "apikey", "api-key", "credential", "private_key", "signature",
+}
+
+// hashFragments mark attributes identifying people or their business
+// entities. Names are matched as names of people only: a bare "name" also
+// names hosts, routes, and spans.
+var hashFragments = []string{
+	"email", "phone", "user", "customer", "account", "tenant", "session",
+	"address", "client_ip", "client.ip", "peer.ip", "enduser", "card",
+	"iban", "ssn", "first_name", "last_name", "full_name", "given_name",
+	"family_name", "middle_name",
+}
+
+// keepPrefixes are the namespaces of attributes describing the system
+// rather than its users, kept although their names contain a hash fragment,
+// e.g. k8s.pod.name
+var keepPrefixes = []string{
+	"service.", "host.", "k8s.", "container.", "cloud.", "process.", "os.",
+	"telemetry.", "otel.", "db.", "rpc.", "messaging.", "server.", "net.host.",
+	"user_agent.",
+}
+
+// idKeys hold trace and span IDs, mapped like the IDs of the exported spans
+// so logs still point at their traces
+var idKeys = map[string]bool{
+	"trace_id": true, "traceid": true, "trace.id": true, "dd.trace_id": true,
+	"span_id": true, "spanid": true, "span.id": true, "parent_id": true,
+}
+
+// Anonymizer replaces identifiers with consistent hashes and strips personal
+// data. Rules set by Set take precedence over the built-in ones.
+type Anonymizer struct {
+	key   []byte
+	rules map[string]Action
+}
+
+// New creates an anonymizer hashing with key; a nil key generates a random
+// one, so hashes are consistent only within the export
+func New(key []byte) (*Anonymizer, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate a key: %w", err)
+		}
+	}
+	return &Anonymizer{key: key, rules: make(map[string]Action)}, nil
+}
+
+// LoadKey reads the hex key in file, or creates the file with a new random
+// key when it does not exist
+func LoadKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < 16 {
+			return nil, fmt.Errorf("invalid key in %s: want at least 32 hex digits", file)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate a key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Set sets the action for an attribute key
+func (a *Anonymizer) Set(key string, action Action) {
+	a.rules[strings.ToLower(key)] = action
+}
+
+// Rules returns the actions set with Set
+func (a *Anonymizer) Rules() map[string]Action {
+	rules := make(map[string]Action, len(a.rules))
+	for k, v := range a.rules {
+		rules[k] = v
+	}
+	return rules
+}
+
+// KeyFingerprint identifies the key without revealing it, so exports that
+// share a key can be recognized
+func (a *Anonymizer) KeyFingerprint() string {
+	sum := sha256.Sum256(a.key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Action returns what happens to the attribute key
+func (a *Anonymizer) Action(key string) Action {
+	lower := strings.ToLower(key)
+	if action, ok := a.rules[lower]; ok {
+		return action
+	}
+	for _, f := range dropFragments {
+		if strings.Contains(lower, f) {
+			return Drop
+		}
+	}
+	for _, prefix := range keepPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return Keep
+		}
+	}
+	if lower == "id" || strings.HasSuffix(lower, ".id") || strings.HasSuffix(lower, "_id") {
+		return Hash
+	}
+	for _, f := range hashFragments {
+		if strings.Contains(lower, f) {
+			return Hash
+		}
+	}
+	return Keep
+}
+
+// sum returns the keyed hash of a value in hex
+func (a *Anonymizer) sum(kind, value string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ID maps a hex trace or span ID to another of the same length
+func (a *Anonymizer) ID(id string) string {
+	if id == "" {
+		return ""
+	}
+	id = strings.ToLower(id)
+	// Jaeger shortens 128-bit IDs with leading zeros; map the full ID so
+	// logs written with it match
+	if len(id) > 16 && len(id) < 32 {
+		id = strings.Repeat("0", 32-len(id)) + id
+	}
+	sum := a.sum("id", id)
+	for len(sum) < len(id) {
+		sum += a.sum("id", sum)
+	}
+	return sum[:len(id)]
+}
+
+// HashValue replaces an identifier with a consistent token
+func (a *Anonymizer) HashValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "anon-" + a.sum("value", value)[:12]
+}
+
+// Attribute anonymizes an attribute value by its key; ok is false when the
+// attribute is dropped
+func (a *Anonymizer) Attribute(key string, value interface{}) (interface{}, bool) {
+	if id, ok := value.(string); ok && idKeys[strings.ToLower(key)] && hexIDPattern.MatchString(id) {
+		return a.ID(id), true
+	}
+	switch a.Action(key) {
+	case Drop:
+		return nil, false
+	case Hash:
+		if value == nil {
+			return nil, true
+		}
+		return a.HashValue(fmt.Sprint(value)), true
+	}
+	switch v := value.(type) {
+	case string:
+		return a.Text(v), true
+	case map[string]interface{}:
+		return a.Object(v), true
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if anon, ok := a.Attribute(key, item); ok {
+				out = append(out, anon)
+			}
+		}
+		return out, true
+	}
+	return value, true
+}
+
+// Object anonymizes the fields of a JSON object by their keys
+func (a *Anonymizer) Object(fields map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if anon, ok := a.Attribute(k, v); ok {
+			out[k] = anon
+		}
+	}
+	return out
+}
+
+// Personal data and identifiers found in free text. Secrets are removed; the
+// rest are hashed so repeated values stay recognizable.
+var (
+	jwtPattern      = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+	authPattern     = regexp.MustCompile(`(?i)\b(bearer|basic|token)\s+[A-Za-z0-9._~+/=-]{8,}`)
+	userinfoPattern = regexp.MustCompile(`([a-z][a-z0-9+.-]*://)[^/\s:@]+:[^/\s@]+@`)
+	queryPattern    = regexp.MustCompile(`([?&][A-Za-z0-9_.\[\]-]+)=([^&#\s"']+)`)
+	emailPattern    = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	uuidPattern     = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	hexIDPattern    = regexp.MustCompile(`(?i)\b([0-9a-f]{32}|[0-9a-f]{16})\b`)
+	ipv4Pattern     = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)
+	ipv6Pattern     = regexp.MustCompile(`(?i)\b(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b`)
+	cardPattern     = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	ssnPattern      = regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)
+	phonePattern    = regexp.MustCompile(`\+\d{1,3}[ -]?\(?\d{1,4}\)?(?:[ -]?\d{2,4}){2,4}\b`)
+)
+
+// Text strips personal data from free text such as log lines, span names,
+// and URLs. Trace and span IDs and UUIDs are mapped like ID, so they still
+// match the anonymized spans; JSON objects are anonymized field by field.
+func (a *Anonymizer) Text(s string) string {
+	if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "{") {
+		var fields map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		dec.UseNumber()
+		if dec.Decode(&fields) == nil && !dec.More() {
+			if out, err := json.Marshal(a.Object(fields)); err == nil {
+				return string(out)
+			}
+		}
+	}
+
+	s = jwtPattern.ReplaceAllString(s, "<jwt>")
+	s = authPattern.ReplaceAllString(s, "$1 <redacted>")
+	s = userinfoPattern.ReplaceAllString(s, "$1<redacted>@")
+	s = queryPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name, value, _ := strings.Cut(m, "=")
+		switch a.Action(name[1:]) {
+		case Drop:
+			return name + "=<redacted>"
+		case Hash:
+			return name + "=" + a.HashValue(value)
+		}
+		return name + "=" + a.textValue(value)
+	})
+	return a.textValue(s)
+}
+
+// textValue replaces the identifiers and personal data in text
+func (a *Anonymizer) textValue(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return a.sum("email", strings.ToLower(m))[:12] + "@anon.invalid"
+	})
+	s = uuidPattern.ReplaceAllStringFunc(s, func(m string) string {
+		h := a.ID(strings.ReplaceAll(m, "-", ""))
+		return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	})
+	s = hexIDPattern.ReplaceAllStringFunc(s, a.ID)
+	s = ipv6Pattern.ReplaceAllStringFunc(s, func(m string) string { return "ip-" + a.sum("ip", strings.ToLower(m))[:8] })
+	s = ipv4Pattern.ReplaceAllStringFunc(s, func(m string) string { return "ip-" + a.sum("ip", m)[:8] })
+	s = ssnPattern.ReplaceAllString(s, "<ssn>")
+	s = cardPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if luhn(m) {
+			return "<card>"
+		}
+		return m
+	})
+	s = phonePattern.ReplaceAllStringFunc(s, func(m string) string { return "phone-" + a.sum("phone", m)[:8] })
+	return s
+}
+
+// luhn reports whether the digits of s pass the card number checksum
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
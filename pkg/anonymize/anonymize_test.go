@@ -0,0 +1,134 @@
+package anonymize
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestAnonymizer(t *testing.T) *Anonymizer {
+	t.Helper()
+	a, err := New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAction(t *testing.T) {
+	a := newTestAnonymizer(t)
+	a.Set("order.total", Hash)
+	a.Set("user.tier", Keep)
+
+	for key, want := range map[string]Action{
+		"http.request.header.authorization": Drop,
+		"db.password":                       Drop,
+		"user.email":                        Hash,
+		"enduser.id":                        Hash,
+		"order.id":                          Hash,
+		"customer_name":                     Hash,
+		"user.name":                         Hash,
+		"first_name":                        Hash,
+		"last_name":                         Hash,
+		"full_name":                         Hash,
+		"hostname":                          Keep,
+		"http.route_name":                   Keep,
+		"span.name":                         Keep,
+		"k8s.pod.name":                      Keep,
+		"service.name":                      Keep,
+		"http.route":                        Keep,
+		"http.status_code":                  Keep,
+		"order.total":                       Hash,
+		"user.tier":                         Keep,
+	} {
+		if got := a.Action(key); got != want {
+			t.Errorf("Action(%q) = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	a := newTestAnonymizer(t)
+	id := "4bf92f3577b34da6a3ce929d0e0e4736"
+	got := a.ID(id)
+	if len(got) != 32 || got == id {
+		t.Errorf("ID(%q) = %q", id, got)
+	}
+	if a.ID(strings.ToUpper(id)) != got {
+		t.Error("ID is not case insensitive")
+	}
+	// Jaeger drops leading zeros of 128-bit IDs
+	if a.ID("00"+id[2:]) != a.ID(strings.TrimLeft("00"+id[2:], "0")) {
+		t.Error("shortened ID maps differently")
+	}
+	if got := a.ID("00f067aa0ba902b7"); len(got) != 16 {
+		t.Errorf("span ID mapped to %q", got)
+	}
+
+	other, _ := New([]byte("another key, another mapping...."))
+	if other.ID(id) == got {
+		t.Error("different keys map IDs the same way")
+	}
+}
+
+func TestText(t *testing.T) {
+	a := newTestAnonymizer(t)
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	line := "payment for jane.doe@example.com from 203.0.113.7 failed: card 4111 1111 1111 1111, ssn 123-45-6789, " +
+		"call +1 415-555-0100 trace_id=" + traceID + " GET /orders?user=42&page=2 Authorization: Bearer abcdef123456789"
+	got := a.Text(line)
+	for _, leaked := range []string{"jane.doe", "203.0.113.7", "4111", "123-45-6789", "555-0100", traceID, "user=42", "abcdef123456789"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("Text leaked %q: %s", leaked, got)
+		}
+	}
+	for _, kept := range []string{"payment for", "<card>", "<ssn>", "@anon.invalid", "trace_id=" + a.ID(traceID), "page=2", "Bearer <redacted>"} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("Text lost %q: %s", kept, got)
+		}
+	}
+	if a.Text(line) != got {
+		t.Error("Text is not consistent")
+	}
+
+	// JSON lines are anonymized by field, keeping numbers as written
+	got = a.Text(`{"level":"info","user_id":"u-17","password":"hunter2","trace_id":"` + traceID + `","amount":12.50,"count":3}`)
+	for _, want := range []string{`"level":"info"`, `"user_id":"anon-`, `"trace_id":"` + a.ID(traceID) + `"`, `"amount":12.50`, `"count":3`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("JSON line %s lacks %s", got, want)
+		}
+	}
+	if strings.Contains(got, "hunter2") || strings.Contains(got, "password") {
+		t.Errorf("JSON line kept the password: %s", got)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys", "export.key")
+	key, err := LoadKey(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	again, err := LoadKey(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(key) {
+		t.Error("LoadKey did not read back the generated key")
+	}
+
+	os.WriteFile(file, []byte("not hex\n"), 0o600)
+	if _, err := LoadKey(file); err == nil {
+		t.Error("invalid key loaded without error")
+	}
+}
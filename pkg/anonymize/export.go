@@ -0,0 +1,548 @@
+package anonymize
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxServices bounds the services exported when Jaeger lists them
+const maxServices = 50
+
+// Query selects the telemetry to export
+type Query struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Services restricts the traces exported; empty exports every service
+	Services []string `json:"services,omitempty"`
+	// TraceIDs exports these traces, and only the logs mentioning them,
+	// instead of searching the window
+	TraceIDs []string `json:"trace_ids,omitempty"`
+
+	// Limit bounds traces per service, LogLimit log lines
+	Limit    int `json:"limit"`
+	LogLimit int `json:"log_limit"`
+}
+
+// Validate checks the query and fills defaults
+func (q *Query) Validate() error {
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+	if q.Start.IsZero() {
+		q.Start = q.End.Add(-time.Hour)
+	}
+	if !q.Start.Before(q.End) {
+		return fmt.Errorf("start must be before end")
+	}
+	for _, id := range q.TraceIDs {
+		if !traceIDFormat.MatchString(id) {
+			return fmt.Errorf("invalid trace ID %q: want 16 or 32 hex digits", id)
+		}
+	}
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	if q.LogLimit <= 0 {
+		q.LogLimit = 1000
+	}
+	return nil
+}
+
+var traceIDFormat = regexp.MustCompile(`^(?i)[0-9a-f]{16}([0-9a-f]{16})?$`)
+
+// Manifest describes an export, so the recipient knows what it holds and
+// the sender can match it to the key it was made with
+type Manifest struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Services    []string  `json:"services"`
+	Traces      int       `json:"traces"`
+	Spans       int       `json:"spans"`
+	LogRecords  int       `json:"log_records"`
+	// KeyFingerprint identifies the hashing key; exports with the same
+	// fingerprint hash identifiers the same way
+	KeyFingerprint string            `json:"key_fingerprint"`
+	Rules          map[string]Action `json:"rules,omitempty"`
+	// Errors lists backends that could not be exported
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Bundle is an anonymized export
+type Bundle struct {
+	Traces   TracesData
+	Logs     LogsData
+	Manifest Manifest
+}
+
+// WriteZip writes the bundle as a zip of traces.json and logs.json, in OTLP
+// JSON, and manifest.json
+func (b *Bundle) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"traces.json", b.Traces},
+		{"logs.json", b.Logs},
+		{"manifest.json", b.Manifest},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: b.Manifest.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// Exporter reads traces from Jaeger and logs from Loki and anonymizes them
+type Exporter struct {
+	// JaegerURL is the Jaeger query URL; empty skips traces
+	JaegerURL string
+	// LokiURL is the Loki URL; empty skips logs
+	LokiURL string
+	// LokiSelector is the stream selector exported, e.g. {namespace="shop"}.
+	// Defaults to every stream with a job label.
+	LokiSelector string
+	Client       *http.Client
+	Anonymizer   *Anonymizer
+}
+
+// Export reads and anonymizes the telemetry selected by q. Backends that fail
+// are reported in the manifest; an error is returned only when all fail.
+func (e *Exporter) Export(ctx context.Context, q Query) (*Bundle, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	if e.JaegerURL == "" && e.LokiURL == "" {
+		return nil, fmt.Errorf("no backends configured")
+	}
+
+	bundle := &Bundle{
+		Traces: TracesData{ResourceSpans: []ResourceSpans{}},
+		Logs:   LogsData{ResourceLogs: []ResourceLogs{}},
+		Manifest: Manifest{
+			GeneratedAt:    time.Now().UTC(),
+			Start:          q.Start.UTC(),
+			End:            q.End.UTC(),
+			Services:       []string{},
+			KeyFingerprint: e.Anonymizer.KeyFingerprint(),
+			Rules:          e.Anonymizer.Rules(),
+		},
+	}
+	var failures []error
+	exported := 0
+
+	if e.JaegerURL != "" {
+		traces, err := e.jaegerTraces(ctx, q)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("jaeger: %w", err))
+		} else {
+			exported++
+			services := make(map[string]bool)
+			for _, t := range traces {
+				bundle.Traces.ResourceSpans = append(bundle.Traces.ResourceSpans, t.resourceSpans(e.Anonymizer)...)
+				for _, p := range t.Processes {
+					services[p.ServiceName] = true
+				}
+				bundle.Manifest.Spans += len(t.Spans)
+			}
+			bundle.Manifest.Traces = len(traces)
+			for s := range services {
+				bundle.Manifest.Services = append(bundle.Manifest.Services, s)
+			}
+			sort.Strings(bundle.Manifest.Services)
+		}
+	}
+
+	if e.LokiURL != "" {
+		logs, err := e.lokiLogs(ctx, q)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("loki: %w", err))
+		} else {
+			exported++
+			bundle.Logs.ResourceLogs = logs
+			for _, rl := range logs {
+				for _, sl := range rl.ScopeLogs {
+					bundle.Manifest.LogRecords += len(sl.LogRecords)
+				}
+			}
+		}
+	}
+
+	for _, err := range failures {
+		bundle.Manifest.Errors = append(bundle.Manifest.Errors, err.Error())
+	}
+	if exported == 0 {
+		return bundle, errors.Join(failures...)
+	}
+	return bundle, nil
+}
+
+type jaegerTracesResponse struct {
+	Data []jaegerTrace `json:"data"`
+}
+
+type jaegerTrace struct {
+	TraceID   string                   `json:"traceID"`
+	Spans     []jaegerSpan             `json:"spans"`
+	Processes map[string]jaegerProcess `json:"processes"`
+}
+
+type jaegerSpan struct {
+	TraceID       string `json:"traceID"`
+	SpanID        string `json:"spanID"`
+	OperationName string `json:"operationName"`
+	References    []struct {
+		RefType string `json:"refType"`
+		TraceID string `json:"traceID"`
+		SpanID  string `json:"spanID"`
+	} `json:"references"`
+	StartTime int64       `json:"startTime"` // microseconds
+	Duration  int64       `json:"duration"`  // microseconds
+	Tags      []jaegerTag `json:"tags"`
+	Logs      []struct {
+		Timestamp int64       `json:"timestamp"` // microseconds
+		Fields    []jaegerTag `json:"fields"`
+	} `json:"logs"`
+	ProcessID string `json:"processID"`
+}
+
+type jaegerProcess struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []jaegerTag `json:"tags"`
+}
+
+type jaegerTag struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// jaegerTraces fetches the traces of the query from the Jaeger query API
+func (e *Exporter) jaegerTraces(ctx context.Context, q Query) ([]jaegerTrace, error) {
+	base := strings.TrimRight(e.JaegerURL, "/")
+	var traces []jaegerTrace
+	seen := make(map[string]bool)
+	add := func(resp jaegerTracesResponse) {
+		for _, t := range resp.Data {
+			// A trace spanning several services is returned for each of them
+			if seen[t.TraceID] {
+				continue
+			}
+			seen[t.TraceID] = true
+			traces = append(traces, t)
+		}
+	}
+
+	if len(q.TraceIDs) > 0 {
+		for _, id := range q.TraceIDs {
+			var resp jaegerTracesResponse
+			if err := getJSON(ctx, e.Client, base+"/api/traces/"+strings.ToLower(id), &resp); err != nil {
+				return nil, err
+			}
+			add(resp)
+		}
+		return traces, nil
+	}
+
+	services := q.Services
+	if len(services) == 0 {
+		var resp struct {
+			Data []string `json:"data"`
+		}
+		if err := getJSON(ctx, e.Client, base+"/api/services", &resp); err != nil {
+			return nil, err
+		}
+		services = resp.Data
+		sort.Strings(services)
+		if len(services) > maxServices {
+			services = services[:maxServices]
+		}
+	}
+	for _, service := range services {
+		params := url.Values{}
+		params.Set("service", service)
+		params.Set("start", strconv.FormatInt(q.Start.UnixMicro(), 10))
+		params.Set("end", strconv.FormatInt(q.End.UnixMicro(), 10))
+		params.Set("limit", strconv.Itoa(q.Limit))
+
+		var resp jaegerTracesResponse
+		if err := getJSON(ctx, e.Client, base+"/api/traces?"+params.Encode(), &resp); err != nil {
+			return nil, err
+		}
+		add(resp)
+	}
+	return traces, nil
+}
+
+// spanKinds maps the span.kind tag to OTLP span kinds
+var spanKinds = map[string]int{
+	"internal": SpanKindInternal,
+	"server":   SpanKindServer,
+	"client":   SpanKindClient,
+	"producer": SpanKindProducer,
+	"consumer": SpanKindConsumer,
+}
+
+// resourceSpans converts a trace to anonymized OTLP spans, one resource per
+// Jaeger process and one scope per instrumentation library
+func (t jaegerTrace) resourceSpans(a *Anonymizer) []ResourceSpans {
+	// Spans by process, then by scope
+	spans := make(map[string]map[string][]Span)
+	for _, js := range t.Spans {
+		scope := ""
+		span := Span{
+			TraceID:           traceID(a, js.TraceID),
+			SpanID:            a.ID(js.SpanID),
+			Name:              a.Text(js.OperationName),
+			StartTimeUnixNano: nanos(js.StartTime * 1000),
+			EndTimeUnixNano:   nanos((js.StartTime + js.Duration) * 1000),
+		}
+		for _, ref := range js.References {
+			switch {
+			case ref.RefType == "CHILD_OF" && span.ParentSpanID == "" && ref.TraceID == js.TraceID:
+				span.ParentSpanID = a.ID(ref.SpanID)
+			default:
+				span.Links = append(span.Links, SpanLink{TraceID: traceID(a, ref.TraceID), SpanID: a.ID(ref.SpanID)})
+			}
+		}
+
+		fields := make(map[string]interface{}, len(js.Tags))
+		for _, tag := range js.Tags {
+			value := fmt.Sprint(tag.Value)
+			switch tag.Key {
+			case "span.kind":
+				span.Kind = spanKinds[value]
+			case "otel.scope.name", "otel.library.name":
+				scope = value
+			case "otel.status_code":
+				if value == "ERROR" {
+					span.Status.Code = StatusError
+				} else if value == "OK" && span.Status.Code != StatusError {
+					span.Status.Code = StatusOK
+				}
+			case "otel.status_description":
+				span.Status.Message = a.Text(value)
+			case "error":
+				if value == "true" {
+					span.Status.Code = StatusError
+				}
+			case "internal.span.format", "otel.scope.version", "otel.library.version":
+			default:
+				fields[tag.Key] = tag.Value
+			}
+		}
+		span.Attributes = attributes(a.Object(fields))
+
+		for _, log := range js.Logs {
+			event := SpanEvent{TimeUnixNano: nanos(log.Timestamp * 1000), Name: "log"}
+			fields := make(map[string]interface{}, len(log.Fields))
+			for _, f := range log.Fields {
+				if f.Key == "event" {
+					event.Name = a.Text(fmt.Sprint(f.Value))
+					continue
+				}
+				fields[f.Key] = f.Value
+			}
+			event.Attributes = attributes(a.Object(fields))
+			span.Events = append(span.Events, event)
+		}
+
+		if spans[js.ProcessID] == nil {
+			spans[js.ProcessID] = make(map[string][]Span)
+		}
+		spans[js.ProcessID][scope] = append(spans[js.ProcessID][scope], span)
+	}
+
+	out := make([]ResourceSpans, 0, len(spans))
+	for _, id := range sortedKeys(spans) {
+		process := t.Processes[id]
+		fields := make(map[string]interface{}, len(process.Tags))
+		for _, tag := range process.Tags {
+			fields[tag.Key] = tag.Value
+		}
+		fields = a.Object(fields)
+		fields["service.name"] = process.ServiceName
+
+		rs := ResourceSpans{Resource: Resource{Attributes: attributes(fields)}}
+		for _, scope := range sortedKeys(spans[id]) {
+			rs.ScopeSpans = append(rs.ScopeSpans, ScopeSpans{Scope: Scope{Name: scope}, Spans: spans[id][scope]})
+		}
+		out = append(out, rs)
+	}
+	return out
+}
+
+// traceID maps a Jaeger trace ID to an anonymized 128-bit OTLP trace ID
+func traceID(a *Anonymizer, id string) string {
+	if len(id) < 32 {
+		id = strings.Repeat("0", 32-len(id)) + id
+	}
+	return a.ID(id)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// systemLabels are Loki stream labels describing where logs come from,
+// exported as they are
+var systemLabels = map[string]bool{
+	"job": true, "namespace": true, "pod": true, "container": true, "app": true,
+	"instance": true, "cluster": true, "node": true, "node_name": true,
+	"service_name": true, "component": true, "env": true, "environment": true,
+	"stream": true, "level": true, "detected_level": true, "filename": true,
+}
+
+// severities maps log levels to OTLP severity numbers
+var severities = map[string]int{
+	"trace": 1, "debug": 5, "info": 9, "notice": 10, "warn": 13, "warning": 13,
+	"error": 17, "err": 17, "critical": 21, "fatal": 21, "panic": 21,
+}
+
+var (
+	levelPattern   = regexp.MustCompile(`(?i)"?(?:level|severity|lvl)"?\s*[:=]\s*"?([a-z]+)`)
+	traceIDPattern = regexp.MustCompile(`(?i)trace_?id"?\s*[:=]\s*"?([0-9a-f]{32})`)
+	spanIDPattern  = regexp.MustCompile(`(?i)span_?id"?\s*[:=]\s*"?([0-9a-f]{16})`)
+)
+
+// lokiLogs fetches the log lines of the query from Loki and converts them
+// to anonymized OTLP log records, one resource per stream
+func (e *Exporter) lokiLogs(ctx context.Context, q Query) ([]ResourceLogs, error) {
+	query := e.LokiSelector
+	if query == "" {
+		query = `{job=~".+"}`
+	}
+	if len(q.TraceIDs) > 0 {
+		ids := make([]string, 0, len(q.TraceIDs))
+		for _, id := range q.TraceIDs {
+			ids = append(ids, "(?i)"+id)
+		}
+		query += " |~ " + strconv.Quote(strings.Join(ids, "|"))
+	}
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(q.Start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(q.End.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(q.LogLimit))
+	params.Set("direction", "forward")
+
+	var resp struct {
+		Data struct {
+			Result []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, e.Client, strings.TrimRight(e.LokiURL, "/")+"/loki/api/v1/query_range?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	// fmt prints maps with sorted keys, ordering streams by their labels
+	streams := resp.Data.Result
+	sort.SliceStable(streams, func(i, j int) bool {
+		return fmt.Sprint(streams[i].Stream) < fmt.Sprint(streams[j].Stream)
+	})
+
+	a := e.Anonymizer
+	out := make([]ResourceLogs, 0, len(resp.Data.Result))
+	for _, stream := range streams {
+		fields := make(map[string]interface{}, len(stream.Stream))
+		for k, v := range stream.Stream {
+			if systemLabels[k] {
+				fields[k] = v
+			} else if anon, ok := a.Attribute(k, v); ok {
+				fields[k] = anon
+			}
+		}
+		if service := stream.Stream["service_name"]; service != "" {
+			fields["service.name"] = service
+		} else if job := stream.Stream["job"]; job != "" {
+			fields["service.name"] = job
+		}
+
+		records := make([]LogRecord, 0, len(stream.Values))
+		for _, v := range stream.Values {
+			line := v[1]
+			level := strings.ToLower(stream.Stream["level"])
+			if m := levelPattern.FindStringSubmatch(line); level == "" && m != nil {
+				level = strings.ToLower(m[1])
+			}
+			record := LogRecord{
+				TimeUnixNano:   v[0],
+				SeverityNumber: severities[level],
+				Body:           Value(a.Text(line)),
+			}
+			if record.SeverityNumber > 0 {
+				record.SeverityText = strings.ToUpper(level)
+			}
+			if m := traceIDPattern.FindStringSubmatch(line); m != nil {
+				record.TraceID = a.ID(m[1])
+			}
+			if m := spanIDPattern.FindStringSubmatch(line); m != nil {
+				record.SpanID = a.ID(m[1])
+			}
+			records = append(records, record)
+		}
+		out = append(out, ResourceLogs{
+			Resource:  Resource{Attributes: attributes(fields)},
+			ScopeLogs: []ScopeLogs{{Scope: Scope{Name: "loki"}, LogRecords: records}},
+		})
+	}
+	return out, nil
+}
+
+// getJSON fetches a URL and decodes a successful JSON response, keeping
+// numbers as json.Number so integers survive the round trip
+func getJSON(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
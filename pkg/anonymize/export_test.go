@@ -0,0 +1,146 @@
+package anonymize
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestExport(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/services":
+			w.Write([]byte(`{"data":["checkout","payments"]}`))
+		case "/api/traces":
+			// Both services return the same trace
+			w.Write([]byte(`{"data":[{"traceID":"` + testTraceID + `","spans":[
+				{"traceID":"` + testTraceID + `","spanID":"00f067aa0ba902b7","operationName":"POST /orders","startTime":1704110400000000,"duration":120000,"processID":"p1",
+				 "tags":[{"key":"span.kind","value":"server"},{"key":"enduser.id","value":"jane"},{"key":"http.status_code","value":201},{"key":"http.request.header.cookie","value":"sid=1"}],
+				 "logs":[{"timestamp":1704110400010000,"fields":[{"key":"event","value":"cache miss"},{"key":"customer.email","value":"jane@example.com"}]}]},
+				{"traceID":"` + testTraceID + `","spanID":"53995c3f42cd8ad8","operationName":"charge","startTime":1704110400050000,"duration":60000,"processID":"p2",
+				 "references":[{"refType":"CHILD_OF","traceID":"` + testTraceID + `","spanID":"00f067aa0ba902b7"}],
+				 "tags":[{"key":"error","value":true},{"key":"otel.status_description","value":"declined for jane@example.com"}]}
+			],"processes":{"p1":{"serviceName":"checkout","tags":[{"key":"hostname","value":"ip-10-0-0-12"}]},"p2":{"serviceName":"payments"}}}]}`))
+		case "/loki/api/v1/query_range":
+			w.Write([]byte(`{"data":{"result":[{"stream":{"job":"payments","user":"jane"},"values":[
+				["1704110400080000000","{\"level\":\"error\",\"msg\":\"card declined\",\"email\":\"jane@example.com\",\"trace_id\":\"` + testTraceID + `\"}"]
+			]}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	a := newTestAnonymizer(t)
+	exporter := &Exporter{JaegerURL: server.URL, LokiURL: server.URL, Anonymizer: a}
+	bundle, err := exporter.Export(context.Background(), Query{Start: start.Add(-time.Hour), End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := bundle.Manifest
+	if m.Traces != 1 || m.Spans != 2 || m.LogRecords != 1 || strings.Join(m.Services, ",") != "checkout,payments" {
+		t.Errorf("manifest = %+v", m)
+	}
+
+	if len(bundle.Traces.ResourceSpans) != 2 {
+		t.Fatalf("got %d resources, want one per process", len(bundle.Traces.ResourceSpans))
+	}
+	server1 := bundle.Traces.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	charge := bundle.Traces.ResourceSpans[1].ScopeSpans[0].Spans[0]
+	anonTraceID := a.ID(testTraceID)
+	if server1.TraceID != anonTraceID || charge.ParentSpanID != server1.SpanID || server1.SpanID == "00f067aa0ba902b7" {
+		t.Errorf("IDs not mapped consistently: %+v, %+v", server1, charge)
+	}
+	if server1.Kind != SpanKindServer || charge.Status.Code != StatusError {
+		t.Errorf("kind %d, status %d", server1.Kind, charge.Status.Code)
+	}
+	if len(server1.Events) != 1 || server1.Events[0].Name != "cache miss" {
+		t.Errorf("events = %+v", server1.Events)
+	}
+
+	var logRecord LogRecord
+	for _, rl := range bundle.Logs.ResourceLogs {
+		logRecord = rl.ScopeLogs[0].LogRecords[0]
+	}
+	if logRecord.TraceID != anonTraceID || logRecord.SeverityNumber != 17 {
+		t.Errorf("log record = %+v", logRecord)
+	}
+
+	var buf bytes.Buffer
+	if err := bundle.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var all strings.Builder
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		all.Write(data)
+		if !json.Valid(data) {
+			t.Errorf("%s is not valid JSON", f.Name)
+		}
+	}
+	if strings.Join(names, ",") != "traces.json,logs.json,manifest.json" {
+		t.Errorf("zip holds %v", names)
+	}
+	for _, leaked := range []string{"jane", "example.com", "sid=1", testTraceID, "00f067aa0ba902b7"} {
+		if strings.Contains(all.String(), leaked) {
+			t.Errorf("export leaked %q", leaked)
+		}
+	}
+	for _, kept := range []string{`"intValue": "201"`, "POST /orders", "card declined", "ip-10-0-0-12"} {
+		if !strings.Contains(all.String(), kept) {
+			t.Errorf("export lost %s", kept)
+		}
+	}
+}
+
+func TestExportBackendFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loki/api/v1/query_range" {
+			if !strings.Contains(r.URL.Query().Get("query"), testTraceID) {
+				t.Errorf("log query %q does not filter by trace", r.URL.Query().Get("query"))
+			}
+			w.Write([]byte(`{"data":{"result":[]}}`))
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := &Exporter{JaegerURL: server.URL, LokiURL: server.URL, Anonymizer: newTestAnonymizer(t)}
+	bundle, err := exporter.Export(context.Background(), Query{TraceIDs: []string{testTraceID}})
+	if err != nil {
+		t.Fatalf("one failed backend failed the export: %v", err)
+	}
+	if len(bundle.Manifest.Errors) != 1 || !strings.HasPrefix(bundle.Manifest.Errors[0], "jaeger:") {
+		t.Errorf("errors = %v", bundle.Manifest.Errors)
+	}
+
+	exporter.LokiURL = ""
+	if _, err := exporter.Export(context.Background(), Query{}); err == nil {
+		t.Error("export without a working backend succeeded")
+	}
+
+	if _, err := exporter.Export(context.Background(), Query{TraceIDs: []string{"not-an-id"}}); err == nil {
+		t.Error("invalid trace ID accepted")
+	}
+}
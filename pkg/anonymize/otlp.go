@@ -0,0 +1,204 @@
+package anonymize
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// The OTLP JSON encoding of traces and logs, as accepted by the /v1/traces
+// and /v1/logs endpoints of an OpenTelemetry Collector. Only the fields the
+// export fills are declared.
+
+// TracesData is an ExportTraceServiceRequest
+type TracesData struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+// ResourceSpans groups the spans of one process
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// Resource describes the process that emitted telemetry
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// Scope is the instrumentation scope
+type Scope struct {
+	Name string `json:"name"`
+}
+
+// ScopeSpans groups spans by instrumentation scope
+type ScopeSpans struct {
+	Scope Scope  `json:"scope"`
+	Spans []Span `json:"spans"`
+}
+
+// Span kinds
+const (
+	SpanKindUnspecified = 0
+	SpanKindInternal    = 1
+	SpanKindServer      = 2
+	SpanKindClient      = 3
+	SpanKindProducer    = 4
+	SpanKindConsumer    = 5
+)
+
+// Status codes
+const (
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+// Span is one span; IDs are hex and times nanoseconds since the epoch
+type Span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []KeyValue  `json:"attributes,omitempty"`
+	Events            []SpanEvent `json:"events,omitempty"`
+	Links             []SpanLink  `json:"links,omitempty"`
+	Status            Status      `json:"status"`
+}
+
+// SpanEvent is a timestamped event of a span
+type SpanEvent struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes,omitempty"`
+}
+
+// SpanLink points to a span of another trace
+type SpanLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+// Status is the outcome of a span
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// LogsData is an ExportLogsServiceRequest
+type LogsData struct {
+	ResourceLogs []ResourceLogs `json:"resourceLogs"`
+}
+
+// ResourceLogs groups the log records of one stream
+type ResourceLogs struct {
+	Resource  Resource    `json:"resource"`
+	ScopeLogs []ScopeLogs `json:"scopeLogs"`
+}
+
+// ScopeLogs groups log records by instrumentation scope
+type ScopeLogs struct {
+	Scope      Scope       `json:"scope"`
+	LogRecords []LogRecord `json:"logRecords"`
+}
+
+// LogRecord is one log line
+type LogRecord struct {
+	TimeUnixNano         string     `json:"timeUnixNano"`
+	ObservedTimeUnixNano string     `json:"observedTimeUnixNano,omitempty"`
+	SeverityNumber       int        `json:"severityNumber,omitempty"`
+	SeverityText         string     `json:"severityText,omitempty"`
+	Body                 AnyValue   `json:"body"`
+	Attributes           []KeyValue `json:"attributes,omitempty"`
+	TraceID              string     `json:"traceId,omitempty"`
+	SpanID               string     `json:"spanId,omitempty"`
+}
+
+// KeyValue is an attribute
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds one of the OTLP value types. 64-bit integers are encoded as
+// strings, as the OTLP JSON mapping requires.
+type AnyValue struct {
+	StringValue *string      `json:"stringValue,omitempty"`
+	BoolValue   *bool        `json:"boolValue,omitempty"`
+	IntValue    *string      `json:"intValue,omitempty"`
+	DoubleValue *float64     `json:"doubleValue,omitempty"`
+	ArrayValue  *ArrayValue  `json:"arrayValue,omitempty"`
+	KvlistValue *KvlistValue `json:"kvlistValue,omitempty"`
+}
+
+// ArrayValue is a list of values
+type ArrayValue struct {
+	Values []AnyValue `json:"values"`
+}
+
+// KvlistValue is a nested map of values
+type KvlistValue struct {
+	Values []KeyValue `json:"values"`
+}
+
+// Value converts a decoded JSON value to an AnyValue
+func Value(v interface{}) AnyValue {
+	switch v := v.(type) {
+	case nil:
+		return AnyValue{}
+	case string:
+		return AnyValue{StringValue: &v}
+	case bool:
+		return AnyValue{BoolValue: &v}
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			s := string(v)
+			return AnyValue{IntValue: &s}
+		}
+		f, _ := v.Float64()
+		return AnyValue{DoubleValue: &f}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return AnyValue{IntValue: &s}
+	case int:
+		s := strconv.Itoa(v)
+		return AnyValue{IntValue: &s}
+	case float64:
+		return AnyValue{DoubleValue: &v}
+	case []interface{}:
+		values := make([]AnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, Value(item))
+		}
+		return AnyValue{ArrayValue: &ArrayValue{Values: values}}
+	case map[string]interface{}:
+		return AnyValue{KvlistValue: &KvlistValue{Values: attributes(v)}}
+	}
+	s := ""
+	if b, err := json.Marshal(v); err == nil {
+		s = string(b)
+	}
+	return AnyValue{StringValue: &s}
+}
+
+// attributes converts a map to attributes sorted by key
+func attributes(fields map[string]interface{}) []KeyValue {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]KeyValue, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, KeyValue{Key: k, Value: Value(fields[k])})
+	}
+	return out
+}
+
+// nanos encodes a time in nanoseconds as OTLP JSON does
+func nanos(ns int64) string {
+	return strconv.FormatInt(ns, 10)
+}
@@ -0,0 +1,56 @@
+package apmclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/releases/{service}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"service":  r.PathValue("service"),
+			"releases": []map[string]any{{"version": "v2", "score": 93}},
+			"limit":    r.URL.Query().Get("limit"),
+		})
+	})
+	mux.HandleFunc("GET /api/v1/incidents/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"incident not found"}`))
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("up 1\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL + "/")
+	client.Header.Set("Authorization", "Bearer token")
+	ctx := context.Background()
+
+	history, err := client.ListReleases(ctx, "check out", ListReleasesParams{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Service != "check out" || len(history.Releases) != 1 || history.Releases[0].Score != 93 {
+		t.Errorf("history = %+v", history)
+	}
+
+	_, err = client.GetIncidentTimeline(ctx, "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "incident not found" {
+		t.Errorf("err = %v, want a 404 API error", err)
+	}
+
+	if metrics, err := client.GetMetrics(ctx); err != nil || metrics != "up 1\n" {
+		t.Errorf("metrics = %q, %v", metrics, err)
+	}
+}
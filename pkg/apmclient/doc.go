@@ -0,0 +1,8 @@
+// Package apmclient is a typed Go client for the REST API of the APM server,
+// generated from the server's OpenAPI document.
+//
+//	client := apmclient.NewClient("http://localhost:8080")
+//	status, err := client.GetStatus(ctx)
+package apmclient
+
+//go:generate go run ../../cmd/apm openapi client --output client.go --package apmclient
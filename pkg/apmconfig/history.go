@@ -0,0 +1,427 @@
+package apmconfig
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HistoryFile is where the change history of an apm.yaml is kept, relative
+// to the directory of the file
+const HistoryFile = ".apm/config-history.jsonl"
+
+// Change operations
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// ErrConflict is returned when undoing an event whose settings were changed
+// again since
+var ErrConflict = errors.New("setting changed since the event")
+
+// Change is one setting added, removed or replaced. Lists are compared and
+// replaced as a whole.
+type Change struct {
+	Op   string      `json:"op"`
+	Path []string    `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Key returns the dotted path of the setting
+func (c Change) Key() string {
+	return strings.Join(c.Path, ".")
+}
+
+// Event is one recorded change of the configuration. Events are appended to
+// the history and never rewritten; each carries the hash of the one before,
+// so an edited or deleted entry breaks the chain.
+type Event struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Source  string    `json:"source"`
+	Action  string    `json:"action"`
+	Reverts string    `json:"reverts,omitempty"`
+	Changes []Change  `json:"changes"`
+	Prev    string    `json:"prev,omitempty"`
+	Hash    string    `json:"hash"`
+}
+
+// History is the append-only change log of one apm.yaml
+type History struct {
+	path string
+	now  func() time.Time
+}
+
+// OpenHistory returns the history of the apm.yaml at configPath
+func OpenHistory(configPath string) *History {
+	return &History{path: filepath.Join(filepath.Dir(configPath), HistoryFile), now: time.Now}
+}
+
+// Path returns the file holding the history
+func (h *History) Path() string {
+	return h.path
+}
+
+// Events returns the recorded events, oldest first, after checking the
+// hash chain
+func (h *History) Events() ([]Event, error) {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config history: %w", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	prev := ""
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("config history line %d: %w", line, err)
+		}
+		if e.Prev != prev || e.Hash != e.hash() {
+			return nil, fmt.Errorf("config history line %d: event %s was modified or an earlier event was removed", line, e.ID)
+		}
+		prev = e.Hash
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config history: %w", err)
+	}
+	return events, nil
+}
+
+// Find returns the event whose ID starts with id
+func (h *History) Find(id string) (Event, error) {
+	events, err := h.Events()
+	if err != nil {
+		return Event{}, err
+	}
+	var found []Event
+	for _, e := range events {
+		if id != "" && strings.HasPrefix(e.ID, id) {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Event{}, fmt.Errorf("no config event %q", id)
+	case 1:
+		return found[0], nil
+	default:
+		return Event{}, fmt.Errorf("config event %q is ambiguous: %d events match", id, len(found))
+	}
+}
+
+// Record appends an event for a change of the configuration from before to
+// after. Actor, Source, Action and Reverts are taken from event; the actor
+// defaults to the current user. Nothing is recorded when the settings did
+// not change. When the file was edited by hand since the last event, the
+// edit is recorded first as an event of its own, so the history can still
+// rebuild every version.
+func (h *History) Record(event Event, before, after []byte) (*Event, error) {
+	events, err := h.Events()
+	if err != nil {
+		return nil, err
+	}
+	known, err := replay(events, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	prior, err := decode(before)
+	if err != nil {
+		return nil, err
+	}
+	next, err := decode(after)
+	if err != nil {
+		return nil, err
+	}
+
+	if drift := diff(nil, known, prior); len(drift) > 0 {
+		action := "external edit"
+		if len(events) == 0 {
+			action = "baseline"
+		}
+		e, err := h.append(events, Event{Actor: "unknown", Source: "file", Action: action, Changes: drift})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+
+	changes := diff(nil, prior, next)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if event.Actor == "" {
+		event.Actor = CurrentActor()
+	}
+	event.Changes = changes
+	return h.append(events, event)
+}
+
+func (h *History) append(events []Event, event Event) (*Event, error) {
+	event.Time = h.now().UTC()
+	event.Prev = ""
+	if len(events) > 0 {
+		event.Prev = events[len(events)-1].Hash
+	}
+	event.Hash = event.hash()
+	event.ID = event.Hash[:12]
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create config history directory: %w", err)
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write config history: %w", err)
+	}
+	return &event, nil
+}
+
+// hash covers everything but the ID and the hash itself
+func (e Event) hash() string {
+	e.ID, e.Hash = "", ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// At rebuilds the configuration as it was at t from the history. Comments
+// and key order are not recorded, so the result is the settings alone.
+func (h *History) At(t time.Time) ([]byte, error) {
+	events, err := h.Events()
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 || events[0].Time.After(t) {
+		return nil, fmt.Errorf("config history starts after %s", t.Format(time.RFC3339))
+	}
+	settings, err := replay(events, t)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(settings)
+}
+
+// replay applies the events up to t, or all of them when t is zero
+func replay(events []Event, t time.Time) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	for _, e := range events {
+		if !t.IsZero() && e.Time.After(t) {
+			break
+		}
+		for _, c := range e.Changes {
+			if len(c.Path) == 0 {
+				return nil, fmt.Errorf("config event %s has a change without a path", e.ID)
+			}
+			if c.Op == OpRemove {
+				removeSetting(settings, c.Path)
+			} else {
+				setSetting(settings, c.Path, c.New)
+			}
+		}
+	}
+	return settings, nil
+}
+
+// Revert undoes the changes of an event in the configuration data, keeping
+// its comments and key order. It fails with ErrConflict when a setting the
+// event changed has been changed again since.
+func Revert(data []byte, event Event) ([]byte, error) {
+	doc, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+
+	var conflicts []string
+	for _, c := range event.Changes {
+		current, present, err := valueAt(root, c.Path)
+		if err != nil {
+			return nil, err
+		}
+		want, err := normalize(c.New)
+		if err != nil {
+			return nil, err
+		}
+		if present != (c.Op != OpRemove) || (present && !reflect.DeepEqual(current, want)) {
+			conflicts = append(conflicts, c.Key())
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("cannot undo %s: %w: %s", event.ID, ErrConflict, strings.Join(conflicts, ", "))
+	}
+
+	for i := len(event.Changes) - 1; i >= 0; i-- {
+		c := event.Changes[i]
+		if c.Op == OpAdd {
+			remove(lookup(root, c.Path[:len(c.Path)-1]...), c.Path[len(c.Path)-1])
+			continue
+		}
+		var value yaml.Node
+		if err := value.Encode(c.Old); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", c.Key(), err)
+		}
+		set(root, &value, c.Path...)
+	}
+	return encode(doc)
+}
+
+// CurrentActor names the user making a change: APM_ACTOR when set, the
+// login name otherwise
+func CurrentActor() string {
+	if actor := os.Getenv("APM_ACTOR"); actor != "" {
+		return actor
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// decode reads the settings of a document in the form they are recorded in
+func decode(data []byte) (map[string]interface{}, error) {
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("invalid apm.yaml: %w", err)
+	}
+	v, err := normalize(settings)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]interface{})
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	return m, nil
+}
+
+// normalize converts a value to its JSON form, the form events are read
+// back in, so recorded and current values compare equal
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value in apm.yaml: %w", err)
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// diff lists the changes from a to b, descending into mappings present in
+// both and in key order
+func diff(path []string, a, b map[string]interface{}) []Change {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, k := range keys {
+		p := append(append([]string(nil), path...), k)
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inB:
+			changes = append(changes, Change{Op: OpRemove, Path: p, Old: va})
+		case !inA:
+			changes = append(changes, Change{Op: OpAdd, Path: p, New: vb})
+		default:
+			ma, ok1 := va.(map[string]interface{})
+			mb, ok2 := vb.(map[string]interface{})
+			if ok1 && ok2 {
+				changes = append(changes, diff(p, ma, mb)...)
+			} else if !reflect.DeepEqual(va, vb) {
+				changes = append(changes, Change{Op: OpReplace, Path: p, Old: va, New: vb})
+			}
+		}
+	}
+	return changes
+}
+
+func setSetting(settings map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := settings[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			settings[key] = next
+		}
+		settings = next
+	}
+	settings[path[len(path)-1]] = value
+}
+
+func removeSetting(settings map[string]interface{}, path []string) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := settings[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		settings = next
+	}
+	delete(settings, path[len(path)-1])
+}
+
+// valueAt returns the normalized value at a path of the document
+func valueAt(root *yaml.Node, path []string) (interface{}, bool, error) {
+	parent := lookup(root, path[:len(path)-1]...)
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return nil, false, nil
+	}
+	var node *yaml.Node
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == path[len(path)-1] {
+			node = parent.Content[i+1]
+		}
+	}
+	if node == nil {
+		return nil, false, nil
+	}
+	var v interface{}
+	if err := node.Decode(&v); err != nil {
+		return nil, false, fmt.Errorf("invalid apm.yaml: %w", err)
+	}
+	v, err := normalize(v)
+	return v, true, err
+}
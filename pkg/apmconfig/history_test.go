@@ -0,0 +1,161 @@
+package apmconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testHistory returns a history whose clock advances a minute per event
+func testHistory(t *testing.T) (*History, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "apm.yaml")
+	h := OpenHistory(path)
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	return h, path
+}
+
+func settingsOf(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestHistoryRecord(t *testing.T) {
+	h, _ := testHistory(t)
+	v1 := []byte("project:\n  name: shop\napm:\n  grafana:\n    port: 3000\n")
+	v2 := []byte("project:\n  name: shop\napm:\n  grafana:\n    port: 3001\n  loki:\n    enabled: true\n")
+	v3 := []byte("project:\n  name: shop\napm:\n  grafana:\n    port: 3001\n")
+
+	if _, err := h.Record(Event{Actor: "alice", Source: "cli", Action: "init"}, nil, v1); err != nil {
+		t.Fatal(err)
+	}
+	e, err := h.Record(Event{Actor: "bob", Source: "cli", Action: "edit"}, v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Changes) != 2 || e.Changes[0].Key() != "apm.grafana.port" || e.Changes[0].Op != OpReplace || e.Changes[1].Key() != "apm.loki" {
+		t.Errorf("changes = %+v", e.Changes)
+	}
+	// An unchanged file records nothing
+	if e, err := h.Record(Event{Action: "noop"}, v2, v2); err != nil || e != nil {
+		t.Errorf("noop = %v, %v", e, err)
+	}
+	// The file was edited by hand before this change
+	if _, err := h.Record(Event{Actor: "carol", Action: "edit"}, v3, v1); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := h.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Actor+":"+e.Action)
+	}
+	if got := strings.Join(actions, ","); got != "alice:init,bob:edit,unknown:external edit,carol:edit" {
+		t.Errorf("events = %s", got)
+	}
+
+	if found, err := h.Find(events[1].ID[:6]); err != nil || found.ID != events[1].ID {
+		t.Errorf("Find = %v, %v", found.ID, err)
+	}
+	if _, err := h.Find("zzz"); err == nil {
+		t.Error("expected an unknown event to be rejected")
+	}
+}
+
+func TestHistoryAt(t *testing.T) {
+	h, _ := testHistory(t)
+	v1 := []byte("apm:\n  grafana:\n    port: 3000\n")
+	v2 := []byte("apm:\n  grafana:\n    port: 3001\n")
+	h.Record(Event{Action: "init"}, nil, v1)
+	h.Record(Event{Action: "edit"}, v1, v2)
+	events, _ := h.Events()
+
+	got, err := h.At(events[0].Time.Add(30 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := settingsOf(t, got)["apm"].(map[string]interface{})["grafana"].(map[string]interface{})["port"]; port != 3000 {
+		t.Errorf("port at first event = %v", port)
+	}
+	got, _ = h.At(events[1].Time)
+	if port := settingsOf(t, got)["apm"].(map[string]interface{})["grafana"].(map[string]interface{})["port"]; port != 3001 {
+		t.Errorf("port at second event = %v", port)
+	}
+	if _, err := h.At(events[0].Time.Add(-time.Hour)); err == nil {
+		t.Error("expected a time before the history to be rejected")
+	}
+}
+
+func TestHistoryTampering(t *testing.T) {
+	h, _ := testHistory(t)
+	v1 := []byte("retention:\n  logs:\n    period: 7d\n")
+	v2 := []byte("retention:\n  logs:\n    period: 30d\n")
+	h.Record(Event{Action: "init"}, nil, v1)
+	h.Record(Event{Action: "edit"}, v1, v2)
+
+	data, err := os.ReadFile(h.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(h.Path(), []byte(strings.Replace(string(data), `"30d"`, `"90d"`, 1)), 0o644)
+	if _, err := h.Events(); err == nil {
+		t.Error("expected an edited event to be detected")
+	}
+
+	lines := strings.SplitN(string(data), "\n", 2)
+	os.WriteFile(h.Path(), []byte(lines[1]), 0o644)
+	if _, err := h.Events(); err == nil {
+		t.Error("expected a removed event to be detected")
+	}
+}
+
+func TestRevert(t *testing.T) {
+	h, _ := testHistory(t)
+	v1 := []byte("# APM\nproject:\n  name: shop # the app\napm:\n  grafana:\n    port: 3000\n")
+	v2 := []byte("# APM\nproject:\n  name: shop # the app\napm:\n  grafana:\n    port: 3001\n  loki:\n    enabled: true\n")
+	h.Record(Event{Action: "init"}, nil, v1)
+	e, err := h.Record(Event{Action: "edit"}, v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := Revert(v2, *e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(reverted), "# the app") {
+		t.Errorf("comments were lost:\n%s", reverted)
+	}
+	got := settingsOf(t, reverted)
+	if want := settingsOf(t, v1); !equalSettings(got, want) {
+		t.Errorf("reverted = %v, want %v", got, want)
+	}
+
+	// A later change to the same setting blocks the undo
+	v3 := []byte(strings.Replace(string(v2), "3001", "3002", 1))
+	if _, err := Revert(v3, *e); !errors.Is(err, ErrConflict) {
+		t.Errorf("err = %v, want ErrConflict", err)
+	}
+}
+
+func equalSettings(a, b map[string]interface{}) bool {
+	x, _ := yaml.Marshal(a)
+	y, _ := yaml.Marshal(b)
+	return string(x) == string(y)
+}
@@ -0,0 +1,279 @@
+// Package apmconfig versions the apm.yaml schema, upgrades configuration
+// files written for older versions to the current one, and keeps the history
+// of changes made to a file so they can be inspected and undone.
+package apmconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the apm.yaml schema version this build reads and writes
+const CurrentVersion = 2
+
+// ErrNewerVersion is returned for files written for a newer schema
+var ErrNewerVersion = errors.New("apm.yaml was written by a newer version of apm")
+
+// migration upgrades a document from one schema version to the next
+type migration struct {
+	from        int
+	description string
+	apply       func(doc *yaml.Node) []string
+}
+
+// migrations are the schema changes in order; migrations[i] upgrades
+// version i to i+1
+var migrations = []migration{
+	{
+		from:        0,
+		description: "Add the version field and rename apm.jaeger.port to apm.jaeger.ui_port",
+		apply:       renameJaegerPort,
+	},
+	{
+		from:        1,
+		description: "Move per-component retention into the retention section",
+		apply:       moveRetention,
+	},
+}
+
+// Step is one migration applied to a file
+type Step struct {
+	From        int      `json:"from"`
+	To          int      `json:"to"`
+	Description string   `json:"description"`
+	Changes     []string `json:"changes"`
+}
+
+// Result is the outcome of migrating a file
+type Result struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Steps  []Step `json:"steps"`
+	Before []byte `json:"-"`
+	After  []byte `json:"-"`
+}
+
+// Changed reports whether migrating changed the file
+func (r *Result) Changed() bool {
+	return !bytes.Equal(r.Before, r.After)
+}
+
+// Diff returns the changes to the file named name as a unified diff
+func (r *Result) Diff(name string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(r.Before)),
+		B:        difflib.SplitLines(string(r.After)),
+		FromFile: name,
+		ToFile:   name + " (migrated)",
+		Context:  3,
+	})
+}
+
+// Version returns the schema version of an apm.yaml document. Files without
+// a version field predate versioning and are version 0; "1.0" written by
+// earlier releases of apm init is version 1.
+func Version(data []byte) (int, error) {
+	doc, err := parse(data)
+	if err != nil {
+		return 0, err
+	}
+	return version(doc)
+}
+
+// Migrate upgrades an apm.yaml document to CurrentVersion. Comments and key
+// order are kept; a document already at CurrentVersion is returned as is.
+func Migrate(data []byte) (*Result, error) {
+	doc, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	from, err := version(doc)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{From: from, To: CurrentVersion, Before: data, After: data}
+	if from == CurrentVersion {
+		return result, nil
+	}
+
+	root := doc.Content[0]
+	for _, m := range migrations[from:] {
+		changes := m.apply(root)
+		setVersion(root, m.from+1)
+		changes = append(changes, fmt.Sprintf("set version to %d", m.from+1))
+		result.Steps = append(result.Steps, Step{From: m.from, To: m.from + 1, Description: m.description, Changes: changes})
+	}
+
+	after, err := encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	result.After = after
+	return result, nil
+}
+
+// encode writes a document back with the indentation apm init uses
+func encode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parse(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid apm.yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		// An empty file is an unversioned, empty configuration
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("invalid apm.yaml: top level is not a mapping")
+	}
+	return &doc, nil
+}
+
+func version(doc *yaml.Node) (int, error) {
+	node := lookup(doc.Content[0], "version")
+	if node == nil {
+		return 0, nil
+	}
+	if node.Kind != yaml.ScalarNode {
+		return 0, fmt.Errorf("invalid apm.yaml version: not a number")
+	}
+	// "1.0" and "1" are the same version
+	major, _, _ := strings.Cut(node.Value, ".")
+	v, err := strconv.Atoi(major)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid apm.yaml version %q", node.Value)
+	}
+	if v > CurrentVersion {
+		return v, fmt.Errorf("%w: version %d, this build supports up to %d", ErrNewerVersion, v, CurrentVersion)
+	}
+	return v, nil
+}
+
+func setVersion(root *yaml.Node, v int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(v)}
+	if node := lookup(root, "version"); node != nil {
+		*node = *value
+		return
+	}
+	// The version goes first, where it is easy to spot, below the comment
+	// heading the file
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+func renameJaegerPort(root *yaml.Node) []string {
+	jaeger := lookup(root, "apm", "jaeger")
+	if jaeger == nil || lookup(jaeger, "port") == nil {
+		return nil
+	}
+	if lookup(jaeger, "ui_port") != nil {
+		remove(jaeger, "port")
+		return []string{"removed apm.jaeger.port, which apm.jaeger.ui_port overrides"}
+	}
+	for i := 0; i < len(jaeger.Content); i += 2 {
+		if jaeger.Content[i].Value == "port" {
+			jaeger.Content[i].Value = "ui_port"
+		}
+	}
+	return []string{"renamed apm.jaeger.port to apm.jaeger.ui_port"}
+}
+
+func moveRetention(root *yaml.Node) []string {
+	var changes []string
+	for _, c := range []struct{ component, signal string }{
+		{"prometheus", "metrics"},
+		{"loki", "logs"},
+		{"jaeger", "traces"},
+	} {
+		component := lookup(root, "apm", c.component)
+		old := lookup(component, "retention")
+		if old == nil {
+			continue
+		}
+		remove(component, "retention")
+		if len(component.Content) == 0 {
+			remove(lookup(root, "apm"), c.component)
+		}
+		if old.Kind != yaml.ScalarNode || lookup(root, "retention", c.signal, "period") != nil {
+			changes = append(changes, fmt.Sprintf("removed apm.%s.retention, which retention.%s.period overrides", c.component, c.signal))
+			continue
+		}
+		set(root, old, "retention", c.signal, "period")
+		changes = append(changes, fmt.Sprintf("moved apm.%s.retention to retention.%s.period", c.component, c.signal))
+	}
+	return changes
+}
+
+// lookup returns the value at a path of mapping keys, or nil
+func lookup(node *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// set stores value at a path of mapping keys, creating the mappings on the
+// way
+func set(node *yaml.Node, value *yaml.Node, path ...string) {
+	for i, key := range path {
+		next := lookup(node, key)
+		if i == len(path)-1 {
+			if next != nil {
+				*next = *value
+				return
+			}
+			next = value
+		} else if next == nil || next.Kind != yaml.MappingNode {
+			remove(node, key)
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		} else {
+			node = next
+			continue
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+		node = next
+	}
+}
+
+// remove deletes a key from a mapping
+func remove(node *yaml.Node, key string) {
+	if node == nil {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package apmconfig
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const legacyConfig = `# APM Configuration File
+project:
+  name: my-gofiber-app
+apm:
+  prometheus:
+    enabled: true
+    port: 9090
+    retention: 30d
+  jaeger:
+    enabled: true
+    port: 16686 # Jaeger UI
+  loki:
+    enabled: false
+    retention: 7d
+`
+
+func TestVersion(t *testing.T) {
+	tests := []struct {
+		doc     string
+		want    int
+		wantErr bool
+	}{
+		{"project:\n  name: x\n", 0, false},
+		{"", 0, false},
+		{"version: \"1.0\"\n", 1, false},
+		{"version: 2\n", 2, false},
+		{"version: banana\n", 0, true},
+		{"version: 99\n", 99, true},
+	}
+	for _, tt := range tests {
+		got, err := Version([]byte(tt.doc))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Version(%q) = %d, %v; want %d, error %v", tt.doc, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := Version([]byte("version: 99\n")); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("newer version error = %v, want ErrNewerVersion", err)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	result, err := Migrate([]byte(legacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.From != 0 || result.To != CurrentVersion || len(result.Steps) != 2 {
+		t.Fatalf("migrated %d to %d in %d steps", result.From, result.To, len(result.Steps))
+	}
+
+	var got struct {
+		Version int `yaml:"version"`
+		APM     struct {
+			Prometheus map[string]any `yaml:"prometheus"`
+			Jaeger     map[string]any `yaml:"jaeger"`
+		} `yaml:"apm"`
+		Retention map[string]map[string]string `yaml:"retention"`
+	}
+	if err := yaml.Unmarshal(result.After, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != CurrentVersion {
+		t.Errorf("version = %d", got.Version)
+	}
+	if got.APM.Jaeger["ui_port"] != 16686 || got.APM.Jaeger["port"] != nil {
+		t.Errorf("jaeger = %v", got.APM.Jaeger)
+	}
+	if _, ok := got.APM.Prometheus["retention"]; ok {
+		t.Error("apm.prometheus.retention was kept")
+	}
+	if got.Retention["metrics"]["period"] != "30d" || got.Retention["logs"]["period"] != "7d" {
+		t.Errorf("retention = %v", got.Retention)
+	}
+
+	out := string(result.After)
+	if !strings.HasPrefix(out, "# APM Configuration File\nversion: 2\n") {
+		t.Errorf("version is not first:\n%s", out)
+	}
+	if !strings.Contains(out, "ui_port: 16686 # Jaeger UI") {
+		t.Errorf("comment lost:\n%s", out)
+	}
+
+	diff, err := result.Diff("apm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-    port: 16686 # Jaeger UI") || !strings.Contains(diff, "+    ui_port: 16686 # Jaeger UI") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+}
+
+func TestMigrateKeepsExistingRetention(t *testing.T) {
+	doc := "version: \"1.0\"\napm:\n  loki:\n    retention: 7d\nretention:\n  logs:\n    period: 14d\n"
+	result, err := Migrate([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Steps) != 1 || !strings.Contains(result.Steps[0].Changes[0], "overrides") {
+		t.Fatalf("steps = %+v", result.Steps)
+	}
+	if !strings.Contains(string(result.After), "period: 14d") || strings.Contains(string(result.After), "7d") {
+		t.Errorf("unexpected result:\n%s", result.After)
+	}
+}
+
+func TestMigrateCurrent(t *testing.T) {
+	doc := "version: 2\n\nproject:\n  name: x\n"
+	result, err := Migrate([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed() || len(result.Steps) != 0 {
+		t.Errorf("current config changed:\n%s", result.After)
+	}
+	if _, err := Migrate([]byte("version: 3\n")); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("err = %v, want ErrNewerVersion", err)
+	}
+}
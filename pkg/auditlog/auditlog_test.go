@@ -0,0 +1,63 @@
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type event struct {
+	EventType string `json:"event_type"`
+	Actor     string `json:"username"`
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := NewFile[event]("test", path)
+	for _, actor := range []string{"ana", "bo"} {
+		if err := a.Record(event{EventType: "test_event", Actor: actor}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var actors []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		actors = append(actors, e.Actor)
+	}
+	if strings.Join(actors, ",") != "ana,bo" {
+		t.Errorf("recorded %v, want ana and bo in order", actors)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("audit log mode %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestFileOpenError(t *testing.T) {
+	a := NewFile[event]("test", filepath.Join(t.TempDir(), "missing", "audit.log"))
+	if err := a.Record(event{}); err == nil || !strings.Contains(err.Error(), "test audit log") {
+		t.Errorf("err = %v, want it to name the audit log", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	a := &Memory[event]{}
+	a.Record(event{Actor: "ana"})
+	events := a.Events()
+	events[0].Actor = "changed"
+	if a.Events()[0].Actor != "ana" {
+		t.Error("Events does not return a copy")
+	}
+}
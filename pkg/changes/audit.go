@@ -0,0 +1,44 @@
+package changes
+
+import (
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+)
+
+// Audit outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeDenied    = "denied"
+	OutcomeFailed    = "failed"
+	OutcomeInvalid   = "invalid"
+)
+
+// AuditEvent records one step of a change request
+type AuditEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	EventType string    `json:"event_type"`
+	Action    string    `json:"action"`
+	RequestID string    `json:"request_id,omitempty"`
+	Kind      string    `json:"kind,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Actor     string    `json:"username"`
+	Roles     []string  `json:"roles,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Auditor records change request steps
+type Auditor = auditlog.Auditor[AuditEvent]
+
+// FileAuditor appends change request steps as JSON lines to a file
+type FileAuditor = auditlog.File[AuditEvent]
+
+// NewFileAuditor creates an auditor writing to path
+func NewFileAuditor(path string) *FileAuditor {
+	return auditlog.NewFile[AuditEvent]("changes", path)
+}
+
+// MemoryAuditor keeps change request steps in memory, for tests
+type MemoryAuditor = auditlog.Memory[AuditEvent]
@@ -0,0 +1,569 @@
+// Package changes puts the configuration the server manages, Prometheus rule
+// files, Grafana dashboards, and Alertmanager routes, behind change requests.
+// A change is proposed with its new content and rendered as a diff against
+// the file, approved by someone holding an approver role other than its
+// author, and then applied. Applying refuses a file changed since the diff was
+// rendered, so what is applied is what was approved. Every step, allowed or
+// refused, is recorded in the audit log.
+package changes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/access"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of configuration
+const (
+	KindRules       = "rules"
+	KindDashboard   = "dashboard"
+	KindAlertRoutes = "alert_routes"
+)
+
+// Kinds lists every kind
+var Kinds = []string{KindRules, KindDashboard, KindAlertRoutes}
+
+// Status of a change request
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusApplied  = "applied"
+)
+
+// EventChange is the audit event type of a change request step
+const EventChange = "config_change"
+
+// Steps of a change request, recorded as the audit event action
+const (
+	ActionPropose = "propose"
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+	ActionApply   = "apply"
+)
+
+// DefaultApproverRole is the role allowed to approve changes by default
+const DefaultApproverRole = "approver"
+
+// Errors of the workflow, wrapped with the details
+var (
+	ErrNotFound  = errors.New("change request not found")
+	ErrInvalid   = errors.New("invalid change")
+	ErrForbidden = errors.New("not allowed")
+	ErrConflict  = errors.New("conflict")
+)
+
+// Config holds the workflow settings and the files changes are applied to
+type Config struct {
+	// RequireApproval makes changes wait for an approver; without it their
+	// author may apply them right away, still recorded in the audit log
+	RequireApproval bool `mapstructure:"require_approval" yaml:"require_approval" json:"require_approval"`
+
+	// ApproverRoles are the access roles allowed to approve, default
+	// DefaultApproverRole
+	ApproverRoles []string `mapstructure:"approver_roles" yaml:"approver_roles" json:"approver_roles"`
+
+	// RulesDir holds the Prometheus rule files, one per name
+	RulesDir string `mapstructure:"rules_dir" yaml:"rules_dir" json:"rules_dir"`
+
+	// DashboardsDir holds the Grafana dashboards provisioned from files
+	DashboardsDir string `mapstructure:"dashboards_dir" yaml:"dashboards_dir" json:"dashboards_dir"`
+
+	// AlertmanagerConfig is the Alertmanager configuration file holding the
+	// alert routes
+	AlertmanagerConfig string `mapstructure:"alertmanager_config" yaml:"alertmanager_config" json:"alertmanager_config"`
+}
+
+// Validate checks that the approver roles exist in the access policy and,
+// when approval is required, that API tokens identify who approves
+func (c Config) Validate(policy access.Policy) error {
+	for _, role := range c.approverRoles() {
+		if !policy.HasRole(role) {
+			return fmt.Errorf("changes approver role %q is not an access role", role)
+		}
+	}
+	if c.RequireApproval && !policy.Enabled() {
+		return fmt.Errorf("changes.require_approval needs access tokens to tell authors and approvers apart")
+	}
+	return nil
+}
+
+func (c Config) approverRoles() []string {
+	if len(c.ApproverRoles) == 0 {
+		return []string{DefaultApproverRole}
+	}
+	return c.ApproverRoles
+}
+
+// Proposal is a new content for a file
+type Proposal struct {
+	Kind string `json:"kind"`
+	// Name is the rule file or dashboard, without extension; alert routes
+	// have a single file and need none
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Content     string `json:"content"`
+}
+
+// Request is a change request
+type Request struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`
+	Name        string `json:"name,omitempty"`
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+	Status      string `json:"status"`
+	Content     string `json:"content"`
+	// Diff is the unified diff of the content against the file when proposed
+	Diff string `json:"diff"`
+	// BaseSHA256 is the hash of the file when proposed, empty for a new file
+	BaseSHA256 string `json:"base_sha256,omitempty"`
+
+	Author     string     `json:"author"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Reviewer   string     `json:"reviewer,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	AppliedBy  string     `json:"applied_by,omitempty"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	// ReloadError is why the backend did not pick up the applied file
+	ReloadError string `json:"reload_error,omitempty"`
+}
+
+// Reloader makes a backend pick up a changed file
+type Reloader func(ctx context.Context) error
+
+// ReloadURL returns a reloader posting to a lifecycle endpoint, such as
+// Prometheus's /-/reload, which needs --web.enable-lifecycle
+func ReloadURL(client *http.Client, url string) Reloader {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+		}
+		return nil
+	}
+}
+
+// Manager runs the change request workflow
+type Manager struct {
+	Config  Config
+	Store   Store
+	Auditor Auditor
+
+	// Reloaders are run after a file of their kind is applied, e.g. to
+	// reload Prometheus; Grafana picks up dashboard files by itself
+	Reloaders map[string]Reloader
+
+	// mu serializes the steps so two approvals or applies cannot race
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// Propose validates new content for a file and records a pending change
+// request with its diff
+func (m *Manager) Propose(ctx context.Context, principal access.Principal, p Proposal) (*Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req := &Request{Kind: p.Kind, Name: p.Name, Description: p.Description, Content: p.Content}
+	path, err := m.path(p.Kind, p.Name)
+	if err == nil {
+		err = validateContent(p.Kind, p.Content)
+	}
+	if err != nil {
+		m.audit(principal, ActionPropose, req, err)
+		return nil, err
+	}
+	current, err := readFile(path)
+	if err != nil {
+		m.audit(principal, ActionPropose, req, err)
+		return nil, err
+	}
+	if current != nil && string(current) == p.Content {
+		err = fmt.Errorf("%w: %s already has this content", ErrInvalid, path)
+		m.audit(principal, ActionPropose, req, err)
+		return nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	req.ID = id
+	req.Path = path
+	req.Status = StatusPending
+	req.Author = principal.Name
+	req.CreatedAt = m.clock()
+	if current != nil {
+		req.BaseSHA256 = hash(current)
+	}
+	diff := difflib.UnifiedDiff{
+		B:        difflib.SplitLines(p.Content),
+		FromFile: "/dev/null",
+		ToFile:   "b/" + filepath.ToSlash(path),
+		Context:  3,
+	}
+	if current != nil {
+		diff.A, diff.FromFile = difflib.SplitLines(string(current)), "a/"+filepath.ToSlash(path)
+	}
+	req.Diff, err = difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Store.Save(*req); err != nil {
+		return nil, fmt.Errorf("failed to save change request: %w", err)
+	}
+	m.audit(principal, ActionPropose, req, nil)
+	return req, nil
+}
+
+// Approve approves a pending change request. The approver needs an approver
+// role and must not be its author.
+func (m *Manager) Approve(ctx context.Context, principal access.Principal, id, comment string) (*Request, error) {
+	return m.review(principal, ActionApprove, id, comment)
+}
+
+// Reject rejects a pending change request, which can no longer be applied.
+// Its author may withdraw it this way too.
+func (m *Manager) Reject(ctx context.Context, principal access.Principal, id, comment string) (*Request, error) {
+	return m.review(principal, ActionReject, id, comment)
+}
+
+func (m *Manager) review(principal access.Principal, action, id, comment string) (*Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req, err := m.get(id)
+	if err != nil {
+		m.audit(principal, action, &Request{ID: id}, err)
+		return nil, err
+	}
+	withdraw := action == ActionReject && principal.Name == req.Author
+	switch {
+	case req.Status != StatusPending:
+		err = fmt.Errorf("%w: change request %s is %s", ErrConflict, id, req.Status)
+	case withdraw:
+	case !m.isApprover(principal):
+		err = fmt.Errorf("%w: %s needs one of the roles %v to %s changes", ErrForbidden, principal.Name, m.Config.approverRoles(), action)
+	case principal.Name == req.Author:
+		err = fmt.Errorf("%w: %s cannot approve their own change", ErrForbidden, principal.Name)
+	}
+	if err != nil {
+		m.audit(principal, action, req, err)
+		return nil, err
+	}
+
+	now := m.clock()
+	req.Status = StatusApproved
+	if action == ActionReject {
+		req.Status = StatusRejected
+	}
+	req.Reviewer = principal.Name
+	req.ReviewedAt = &now
+	req.Comment = comment
+	if err := m.Store.Save(*req); err != nil {
+		return nil, fmt.Errorf("failed to save change request: %w", err)
+	}
+	m.audit(principal, action, req, nil)
+	return req, nil
+}
+
+// Apply writes an approved change request, or a pending one of its author
+// when approval is not required, and reloads the backend. The file must be
+// as it was when the diff was rendered.
+func (m *Manager) Apply(ctx context.Context, principal access.Principal, id string) (*Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req, err := m.get(id)
+	if err != nil {
+		m.audit(principal, ActionApply, &Request{ID: id}, err)
+		return nil, err
+	}
+	switch {
+	case req.Status == StatusApproved:
+	case req.Status == StatusPending && !m.Config.RequireApproval:
+		if principal.Name != req.Author && !m.isApprover(principal) {
+			err = fmt.Errorf("%w: only the author or an approver may apply an unreviewed change", ErrForbidden)
+		}
+	case req.Status == StatusPending:
+		err = fmt.Errorf("%w: change request %s is waiting for approval", ErrConflict, id)
+	default:
+		err = fmt.Errorf("%w: change request %s is %s", ErrConflict, id, req.Status)
+	}
+	if err == nil {
+		err = m.write(req)
+	}
+	if err != nil {
+		m.audit(principal, ActionApply, req, err)
+		return nil, err
+	}
+
+	now := m.clock()
+	req.Status = StatusApplied
+	req.AppliedBy = principal.Name
+	req.AppliedAt = &now
+	if reload := m.Reloaders[req.Kind]; reload != nil {
+		if err := reload(ctx); err != nil {
+			req.ReloadError = err.Error()
+		}
+	}
+	if err := m.Store.Save(*req); err != nil {
+		return nil, fmt.Errorf("failed to save change request: %w", err)
+	}
+	m.audit(principal, ActionApply, req, nil)
+	return req, nil
+}
+
+// Get returns a change request
+func (m *Manager) Get(id string) (*Request, error) {
+	return m.get(id)
+}
+
+// List returns the change requests with a status, or all of them for an
+// empty status, newest first
+func (m *Manager) List(status string) ([]Request, error) {
+	requests, err := m.Store.List(status)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].CreatedAt.After(requests[j].CreatedAt) })
+	return requests, nil
+}
+
+func (m *Manager) get(id string) (*Request, error) {
+	req, ok, err := m.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return &req, nil
+}
+
+// write replaces the file of a change request, refusing one changed since
+// the request was proposed
+func (m *Manager) write(req *Request) error {
+	current, err := readFile(req.Path)
+	if err != nil {
+		return err
+	}
+	base := ""
+	if current != nil {
+		base = hash(current)
+	}
+	if base != req.BaseSHA256 {
+		return fmt.Errorf("%w: %s changed since the change request was proposed; propose it again", ErrConflict, req.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(req.Path), 0o755); err != nil {
+		return err
+	}
+	// Write a sibling file and rename it so readers never see half a file
+	tmp := req.Path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(req.Content), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", req.Path, err)
+	}
+	if err := os.Rename(tmp, req.Path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", req.Path, err)
+	}
+	return nil
+}
+
+// validName keeps rule file and dashboard names inside their directory
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// path returns the file a change of a kind and name applies to
+func (m *Manager) path(kind, name string) (string, error) {
+	var dir, ext string
+	switch kind {
+	case KindRules:
+		dir, ext = m.Config.RulesDir, ".yml"
+	case KindDashboard:
+		dir, ext = m.Config.DashboardsDir, ".json"
+	case KindAlertRoutes:
+		if m.Config.AlertmanagerConfig == "" {
+			return "", fmt.Errorf("%w: no Alertmanager configuration file is configured", ErrInvalid)
+		}
+		return m.Config.AlertmanagerConfig, nil
+	default:
+		return "", fmt.Errorf("%w: unknown kind %q; use one of %v", ErrInvalid, kind, Kinds)
+	}
+	if dir == "" {
+		return "", fmt.Errorf("%w: no directory is configured for %s", ErrInvalid, kind)
+	}
+	if !validName.MatchString(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("%w: invalid %s name %q", ErrInvalid, kind, name)
+	}
+	return filepath.Join(dir, name+ext), nil
+}
+
+// validateContent checks that content parses as its kind
+func validateContent(kind, content string) error {
+	switch kind {
+	case KindRules:
+		var rules struct {
+			Groups []struct {
+				Name  string `yaml:"name"`
+				Rules []struct {
+					Alert  string `yaml:"alert"`
+					Record string `yaml:"record"`
+					Expr   string `yaml:"expr"`
+				} `yaml:"rules"`
+			} `yaml:"groups"`
+		}
+		if err := yaml.Unmarshal([]byte(content), &rules); err != nil {
+			return fmt.Errorf("%w: rules are not valid YAML: %v", ErrInvalid, err)
+		}
+		if len(rules.Groups) == 0 {
+			return fmt.Errorf("%w: rules have no groups", ErrInvalid)
+		}
+		for _, g := range rules.Groups {
+			if g.Name == "" {
+				return fmt.Errorf("%w: rule group without a name", ErrInvalid)
+			}
+			for n, r := range g.Rules {
+				if (r.Alert == "") == (r.Record == "") || r.Expr == "" {
+					return fmt.Errorf("%w: rule %d of group %s needs an expr and either alert or record", ErrInvalid, n+1, g.Name)
+				}
+			}
+		}
+	case KindDashboard:
+		var dashboard struct {
+			Title  string            `json:"title"`
+			Panels []json.RawMessage `json:"panels"`
+		}
+		if err := json.Unmarshal([]byte(content), &dashboard); err != nil {
+			return fmt.Errorf("%w: dashboard is not a JSON object: %v", ErrInvalid, err)
+		}
+		if dashboard.Title == "" {
+			return fmt.Errorf("%w: dashboard has no title", ErrInvalid)
+		}
+	case KindAlertRoutes:
+		var config struct {
+			Route *struct {
+				Receiver string `yaml:"receiver"`
+			} `yaml:"route"`
+			Receivers []struct {
+				Name string `yaml:"name"`
+			} `yaml:"receivers"`
+		}
+		if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+			return fmt.Errorf("%w: Alertmanager configuration is not valid YAML: %v", ErrInvalid, err)
+		}
+		if config.Route == nil || config.Route.Receiver == "" {
+			return fmt.Errorf("%w: Alertmanager configuration needs a route with a receiver", ErrInvalid)
+		}
+		found := false
+		for _, r := range config.Receivers {
+			found = found || r.Name == config.Route.Receiver
+		}
+		if !found {
+			return fmt.Errorf("%w: the route receiver %s is not among the receivers", ErrInvalid, config.Route.Receiver)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) isApprover(principal access.Principal) bool {
+	for _, role := range principal.Roles {
+		for _, approver := range m.Config.approverRoles() {
+			if role == approver {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// audit records a step of a change request, refused when err is set
+func (m *Manager) audit(principal access.Principal, action string, req *Request, err error) {
+	if m.Auditor == nil {
+		return
+	}
+	event := AuditEvent{
+		Timestamp: m.clock(),
+		EventType: EventChange,
+		Action:    action,
+		RequestID: req.ID,
+		Kind:      req.Kind,
+		Name:      req.Name,
+		Path:      req.Path,
+		Actor:     principal.Name,
+		Roles:     principal.Roles,
+		Outcome:   OutcomeSucceeded,
+	}
+	switch {
+	case err == nil:
+		if req.ReloadError != "" {
+			event.Error = "reload failed: " + req.ReloadError
+		}
+	case errors.Is(err, ErrForbidden):
+		event.Outcome, event.Error = OutcomeDenied, err.Error()
+	case errors.Is(err, ErrInvalid), errors.Is(err, ErrNotFound), errors.Is(err, ErrConflict):
+		event.Outcome, event.Error = OutcomeInvalid, err.Error()
+	default:
+		event.Outcome, event.Error = OutcomeFailed, err.Error()
+	}
+	m.Auditor.Record(event)
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now().UTC()
+}
+
+// readFile returns the content of a file, nil when it does not exist
+func readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "cr-" + hex.EncodeToString(b), nil
+}
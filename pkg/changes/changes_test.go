@@ -0,0 +1,186 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chaksack/apm/pkg/access"
+)
+
+const rules = `groups:
+  - name: api
+    rules:
+      - alert: HighErrorRate
+        expr: rate(http_requests_total{status=~"5.."}[5m]) > 0.05
+`
+
+var (
+	alice = access.Principal{Name: "alice", Roles: []string{"operator"}}
+	bob   = access.Principal{Name: "bob", Roles: []string{"approver"}}
+	carol = access.Principal{Name: "carol", Roles: []string{"approver"}}
+)
+
+func newTestManager(t *testing.T, requireApproval bool) (*Manager, *MemoryAuditor, string) {
+	t.Helper()
+	dir := t.TempDir()
+	auditor := &MemoryAuditor{}
+	m := &Manager{
+		Config: Config{
+			RequireApproval:    requireApproval,
+			RulesDir:           filepath.Join(dir, "alerts"),
+			DashboardsDir:      filepath.Join(dir, "dashboards"),
+			AlertmanagerConfig: filepath.Join(dir, "alertmanager.yml"),
+		},
+		Store:   &MemoryStore{},
+		Auditor: auditor,
+	}
+	return m, auditor, dir
+}
+
+func TestApprovalWorkflow(t *testing.T) {
+	m, auditor, dir := newTestManager(t, true)
+	ctx := context.Background()
+	path := filepath.Join(dir, "alerts", "api.yml")
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	os.WriteFile(path, []byte("groups:\n  - name: api\n    rules: []\n"), 0o644)
+
+	reloaded := 0
+	m.Reloaders = map[string]Reloader{KindRules: func(context.Context) error { reloaded++; return nil }}
+
+	req, err := m.Propose(ctx, alice, Proposal{Kind: KindRules, Name: "api", Content: rules, Description: "Page on 5xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Status != StatusPending || req.Path != path || req.BaseSHA256 == "" {
+		t.Errorf("request = %+v", req)
+	}
+	if !strings.Contains(req.Diff, "-    rules: []") || !strings.Contains(req.Diff, "+      - alert: HighErrorRate") {
+		t.Errorf("diff = %s", req.Diff)
+	}
+
+	if _, err := m.Apply(ctx, alice, req.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("apply before approval = %v", err)
+	}
+	if _, err := m.Approve(ctx, alice, req.ID, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("approval without the approver role = %v", err)
+	}
+	self, _ := m.Propose(ctx, bob, Proposal{Kind: KindDashboard, Name: "api", Content: `{"title":"API"}`})
+	if _, err := m.Approve(ctx, bob, self.ID, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("self-approval = %v", err)
+	}
+
+	if req, err = m.Approve(ctx, bob, req.ID, "LGTM"); err != nil || req.Status != StatusApproved || req.Reviewer != "bob" {
+		t.Fatalf("approve = %+v, %v", req, err)
+	}
+	if _, err := m.Approve(ctx, carol, req.ID, ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("second approval = %v", err)
+	}
+	if req, err = m.Apply(ctx, alice, req.ID); err != nil || req.Status != StatusApplied || req.AppliedBy != "alice" {
+		t.Fatalf("apply = %+v, %v", req, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != rules || reloaded != 1 {
+		t.Errorf("applied file = %q, reloaded %d times", data, reloaded)
+	}
+
+	var steps []string
+	for _, e := range auditor.Events() {
+		if e.EventType != EventChange {
+			t.Errorf("event type = %s", e.EventType)
+		}
+		steps = append(steps, e.Actor+" "+e.Action+" "+e.Outcome)
+	}
+	want := "alice propose succeeded, alice apply invalid, alice approve denied, bob propose succeeded, bob approve denied, " +
+		"bob approve succeeded, carol approve invalid, alice apply succeeded"
+	if got := strings.Join(steps, ", "); got != want {
+		t.Errorf("audit = %s", got)
+	}
+
+	pending, _ := m.List(StatusPending)
+	if len(pending) != 1 || pending[0].ID != self.ID {
+		t.Errorf("pending = %+v", pending)
+	}
+}
+
+func TestApplyRefusesChangedFile(t *testing.T) {
+	m, _, dir := newTestManager(t, true)
+	ctx := context.Background()
+	req, err := m.Propose(ctx, alice, Proposal{Kind: KindRules, Name: "new", Content: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.BaseSHA256 != "" || !strings.HasPrefix(req.Diff, "--- /dev/null") || !strings.Contains(req.Diff, "+groups:") {
+		t.Errorf("new file request = %+v", req)
+	}
+	if _, err := m.Approve(ctx, bob, req.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "alerts", "new.yml")
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	os.WriteFile(path, []byte("groups: []\n"), 0o644)
+	if _, err := m.Apply(ctx, alice, req.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("apply over a changed file = %v", err)
+	}
+
+	// The author withdraws a request by rejecting it
+	req, _ = m.Propose(ctx, alice, Proposal{Kind: KindRules, Name: "new", Content: rules})
+	if req, err = m.Reject(ctx, alice, req.ID, "superseded"); err != nil || req.Status != StatusRejected {
+		t.Errorf("withdraw = %+v, %v", req, err)
+	}
+	if _, err := m.Apply(ctx, alice, req.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("apply of a rejected request = %v", err)
+	}
+}
+
+func TestWithoutApproval(t *testing.T) {
+	m, _, dir := newTestManager(t, false)
+	ctx := context.Background()
+	routes := "route:\n  receiver: team\nreceivers:\n  - name: team\n"
+	req, err := m.Propose(ctx, alice, Proposal{Kind: KindAlertRoutes, Content: routes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Apply(ctx, access.Principal{Name: "dave", Roles: []string{"operator"}}, req.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("apply by someone else = %v", err)
+	}
+	if _, err := m.Apply(ctx, alice, req.ID); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "alertmanager.yml")); string(data) != routes {
+		t.Errorf("alertmanager.yml = %q", data)
+	}
+}
+
+func TestProposeValidates(t *testing.T) {
+	m, _, _ := newTestManager(t, true)
+	for name, p := range map[string]Proposal{
+		"unknown kind":      {Kind: "silences", Content: "x"},
+		"path traversal":    {Kind: KindRules, Name: "../prometheus", Content: rules},
+		"no name":           {Kind: KindDashboard, Content: `{"title":"API"}`},
+		"invalid yaml":      {Kind: KindRules, Name: "api", Content: "groups: ["},
+		"rule without expr": {Kind: KindRules, Name: "api", Content: "groups:\n  - name: api\n    rules:\n      - alert: X\n"},
+		"dashboard json":    {Kind: KindDashboard, Name: "api", Content: "{"},
+		"dashboard title":   {Kind: KindDashboard, Name: "api", Content: `{"panels":[]}`},
+		"unknown receiver":  {Kind: KindAlertRoutes, Content: "route:\n  receiver: team\nreceivers: []\n"},
+	} {
+		if _, err := m.Propose(context.Background(), alice, p); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tokens := []access.Token{{Name: "ci", SHA256: strings.Repeat("a", 64), Roles: []string{"admin"}}}
+	if err := (Config{RequireApproval: true}).Validate(access.Policy{Tokens: tokens}); err != nil {
+		t.Error(err)
+	}
+	if err := (Config{RequireApproval: true}).Validate(access.Policy{}); err == nil {
+		t.Error("approval without access tokens accepted")
+	}
+	if err := (Config{ApproverRoles: []string{"release-manager"}}).Validate(access.Policy{}); err == nil {
+		t.Error("unknown approver role accepted")
+	}
+}
@@ -0,0 +1,150 @@
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Store keeps change requests
+type Store interface {
+	// Save inserts or replaces a change request
+	Save(req Request) error
+	// Get returns a change request; ok is false when there is none
+	Get(id string) (req Request, ok bool, err error)
+	// List returns the change requests with a status, or all of them for
+	// an empty status
+	List(status string) ([]Request, error)
+}
+
+// FileStore keeps change requests in a JSON file
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore creates a store writing to path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Save implements Store, rewriting the file
+func (s *FileStore) Save(req Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests, err := s.load()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for n := range requests {
+		if requests[n].ID == req.ID {
+			requests[n], replaced = req, true
+		}
+	}
+	if !replaced {
+		requests = append(requests, req)
+	}
+	data, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write change requests: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Get implements Store
+func (s *FileStore) Get(id string) (Request, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests, err := s.load()
+	if err != nil {
+		return Request{}, false, err
+	}
+	for _, req := range requests {
+		if req.ID == id {
+			return req, true, nil
+		}
+	}
+	return Request{}, false, nil
+}
+
+// List implements Store
+func (s *FileStore) List(status string) ([]Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return filterStatus(requests, status), nil
+}
+
+func (s *FileStore) load() ([]Request, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change requests: %w", err)
+	}
+	var requests []Request
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("invalid change requests file %s: %w", s.path, err)
+	}
+	return requests, nil
+}
+
+// MemoryStore keeps change requests in memory, for tests
+type MemoryStore struct {
+	requests []Request
+	mu       sync.RWMutex
+}
+
+// Save implements Store
+func (s *MemoryStore) Save(req Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n := range s.requests {
+		if s.requests[n].ID == req.ID {
+			s.requests[n] = req
+			return nil
+		}
+	}
+	s.requests = append(s.requests, req)
+	return nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(id string) (Request, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, req := range s.requests {
+		if req.ID == id {
+			return req, true, nil
+		}
+	}
+	return Request{}, false, nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(status string) ([]Request, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return filterStatus(s.requests, status), nil
+}
+
+func filterStatus(requests []Request, status string) []Request {
+	out := []Request{}
+	for _, req := range requests {
+		if status == "" || req.Status == status {
+			out = append(out, req)
+		}
+	}
+	return out
+}
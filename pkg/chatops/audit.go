@@ -0,0 +1,47 @@
+package chatops
+
+import (
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+)
+
+// EventCommand is the audit event type of a chat command
+const EventCommand = "chatops_command"
+
+// Audit outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeDenied    = "denied"
+	OutcomeFailed    = "failed"
+	OutcomeInvalid   = "invalid"
+)
+
+// AuditEvent records one chat command
+type AuditEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	EventType string    `json:"event_type"`
+	Platform  string    `json:"platform"`
+	UserID    string    `json:"user_id"`
+	Actor     string    `json:"username,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	Command   string    `json:"command"`
+	Text      string    `json:"text"`
+	Roles     []string  `json:"roles,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Auditor records chat commands
+type Auditor = auditlog.Auditor[AuditEvent]
+
+// FileAuditor appends chat commands as JSON lines to a file
+type FileAuditor = auditlog.File[AuditEvent]
+
+// NewFileAuditor creates an auditor writing to path
+func NewFileAuditor(path string) *FileAuditor {
+	return auditlog.NewFile[AuditEvent]("chatops", path)
+}
+
+// MemoryAuditor keeps chat commands in memory, for tests
+type MemoryAuditor = auditlog.Memory[AuditEvent]
@@ -0,0 +1,120 @@
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alertmanager creates silences through the Alertmanager v2 API
+type Alertmanager struct {
+	URL    string
+	Client *http.Client
+}
+
+// Silence creates a silence and returns its ID
+func (am *Alertmanager) Silence(ctx context.Context, silence Silence) (string, error) {
+	type matcher struct {
+		Name    string `json:"name"`
+		Value   string `json:"value"`
+		IsRegex bool   `json:"isRegex"`
+		IsEqual bool   `json:"isEqual"`
+	}
+	body := struct {
+		Matchers  []matcher `json:"matchers"`
+		StartsAt  time.Time `json:"startsAt"`
+		EndsAt    time.Time `json:"endsAt"`
+		CreatedBy string    `json:"createdBy"`
+		Comment   string    `json:"comment"`
+	}{StartsAt: silence.StartsAt, EndsAt: silence.EndsAt, CreatedBy: silence.CreatedBy, Comment: silence.Comment}
+	for name, value := range silence.Matchers {
+		body.Matchers = append(body.Matchers, matcher{Name: name, Value: value, IsEqual: true})
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(am.URL, "/")+"/api/v2/silences", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client(am.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach Alertmanager: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Alertmanager returned %s", resp.Status)
+	}
+
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("failed to parse Alertmanager response: %w", err)
+	}
+	return created.SilenceID, nil
+}
+
+// HealthChecker checks components by requesting their health URLs
+type HealthChecker struct {
+	// Components maps a component name to its health URL, e.g.
+	// "prometheus": "http://prometheus:9090/-/healthy"
+	Components map[string]string
+	Client     *http.Client
+}
+
+// Check requests every health URL in parallel; a component is healthy when
+// its URL returns a 2xx status
+func (hc *HealthChecker) Check(ctx context.Context) (map[string]error, error) {
+	if len(hc.Components) == 0 {
+		return nil, fmt.Errorf("no components to check")
+	}
+
+	results := make(map[string]error, len(hc.Components))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, url := range hc.Components {
+		wg.Add(1)
+		go func(name, url string) {
+			defer wg.Done()
+			err := hc.check(ctx, url)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, url)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (hc *HealthChecker) check(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client(hc.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("unreachable")
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("returned %s", resp.Status)
+	}
+	return nil
+}
+
+func client(c *http.Client) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: 5 * time.Second}
+	}
+	return c
+}
@@ -0,0 +1,323 @@
+// Package chatops lets authorized chat users run a safe subset of APM
+// commands from Slack or Microsoft Teams: stack status, alert silences, and
+// trace searches. Every command is checked against a role policy keyed by
+// chat identity and written to an audit log, whether it ran or was denied.
+package chatops
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+	"github.com/chaksack/apm/pkg/locale"
+	"github.com/chaksack/apm/pkg/lookup"
+)
+
+// Commands available from chat
+const (
+	CommandStatus  = "status"
+	CommandSilence = "silence"
+	CommandTraces  = "traces"
+	CommandHelp    = "help"
+)
+
+// Commands lists every chat command
+var Commands = []string{CommandStatus, CommandSilence, CommandTraces, CommandHelp}
+
+// MaxSilence caps the duration of silences created from chat
+const MaxSilence = 24 * time.Hour
+
+// Platforms
+const (
+	PlatformSlack = "slack"
+	PlatformTeams = "teams"
+)
+
+// Request is a command sent from chat
+type Request struct {
+	Platform string
+	UserID   string
+	UserName string
+	Channel  string
+
+	// Text is the command without the bot mention or slash command,
+	// e.g. "silence alertname=HighErrorRate 2h deploying fix"
+	Text string
+}
+
+// Response is the reply posted back to chat
+type Response struct {
+	Text string `json:"text"`
+
+	// Public replies are visible to the channel; others only to the caller
+	Public bool `json:"public"`
+}
+
+// StatusChecker reports the health of each stack component
+type StatusChecker interface {
+	Check(ctx context.Context) (map[string]error, error)
+}
+
+// Silencer creates alert silences
+type Silencer interface {
+	Silence(ctx context.Context, silence Silence) (string, error)
+}
+
+// Silence is an alert silence requested from chat
+type Silence struct {
+	Matchers  map[string]string
+	StartsAt  time.Time
+	EndsAt    time.Time
+	CreatedBy string
+	Comment   string
+}
+
+// Bot runs chat commands
+type Bot struct {
+	Policy  Policy
+	Auditor Auditor
+
+	// ReadOnly refuses commands that change anything, such as silences
+	ReadOnly bool
+
+	// Display formats times in replies, UTC when nil
+	Display *locale.Formatter
+
+	Status   StatusChecker
+	Silencer Silencer
+	Lookup   *lookup.Service
+
+	now func() time.Time
+}
+
+// Handle authorizes, runs, and audits a chat command
+func (b *Bot) Handle(ctx context.Context, req Request) Response {
+	fields := strings.Fields(req.Text)
+	command := CommandHelp
+	if len(fields) > 0 {
+		command = strings.ToLower(fields[0])
+		fields = fields[1:]
+	}
+
+	event := AuditEvent{
+		Timestamp: b.clock(),
+		EventType: EventCommand,
+		Platform:  req.Platform,
+		UserID:    req.UserID,
+		Actor:     req.UserName,
+		Channel:   req.Channel,
+		Command:   command,
+		Text:      req.Text,
+	}
+	defer func() { b.auditor().Record(event) }()
+
+	if !knownCommand(command) {
+		event.Outcome = OutcomeInvalid
+		return Response{Text: fmt.Sprintf("Unknown command %q.\n%s", command, usage)}
+	}
+
+	// Anyone may ask for help
+	allowed, roles := command == CommandHelp, []string(nil)
+	if !allowed {
+		allowed, roles = b.Policy.Allowed(req.Platform, req.UserID, command)
+	}
+	event.Roles = roles
+	if !allowed {
+		event.Outcome = OutcomeDenied
+		return Response{Text: fmt.Sprintf("You are not allowed to run `%s`. Ask an APM admin to grant you a role that permits it.", command)}
+	}
+
+	if b.ReadOnly && command == CommandSilence {
+		event.Outcome = OutcomeDenied
+		return Response{Text: fmt.Sprintf("APM is in read-only mode, so `%s` is disabled.", command)}
+	}
+
+	var resp Response
+	var err error
+	switch command {
+	case CommandStatus:
+		resp, err = b.status(ctx)
+	case CommandSilence:
+		resp, err = b.silence(ctx, req, fields)
+	case CommandTraces:
+		resp, err = b.traces(ctx, fields)
+	default:
+		resp = Response{Text: usage}
+	}
+
+	if err != nil {
+		event.Outcome = OutcomeFailed
+		event.Error = err.Error()
+		return Response{Text: fmt.Sprintf("`%s` failed: %v", command, err)}
+	}
+	event.Outcome = OutcomeSucceeded
+	return resp
+}
+
+const usage = "Usage:\n" +
+	"  status - health of the monitoring stack\n" +
+	"  silence <label>=<value>... <duration> [comment] - silence matching alerts, at most 24h\n" +
+	"  traces search <attribute>=<value> [since] - find traces and logs for a business identifier"
+
+func (b *Bot) status(ctx context.Context) (Response, error) {
+	if b.Status == nil {
+		return Response{}, fmt.Errorf("status checks are not configured")
+	}
+	results, err := b.Status.Check(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	healthy := 0
+	for _, name := range names {
+		if results[name] == nil {
+			healthy++
+			fmt.Fprintf(&sb, "✓ %s\n", name)
+		} else {
+			fmt.Fprintf(&sb, "✗ %s: %v\n", name, results[name])
+		}
+	}
+	return Response{Text: fmt.Sprintf("APM stack: %d/%d components healthy\n%s", healthy, len(names), sb.String()), Public: true}, nil
+}
+
+func (b *Bot) silence(ctx context.Context, req Request, args []string) (Response, error) {
+	if b.Silencer == nil {
+		return Response{}, fmt.Errorf("silences are not configured")
+	}
+
+	silence := Silence{Matchers: map[string]string{}, CreatedBy: fmt.Sprintf("%s:%s", req.Platform, req.UserID)}
+	if req.UserName != "" {
+		silence.CreatedBy = fmt.Sprintf("%s (%s)", req.UserName, silence.CreatedBy)
+	}
+
+	var duration time.Duration
+	for i, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			silence.Matchers[name] = value
+			continue
+		}
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return Response{}, fmt.Errorf("expected <label>=<value>... <duration> [comment], got %q", arg)
+		}
+		duration = d
+		silence.Comment = strings.Join(args[i+1:], " ")
+		break
+	}
+	if len(silence.Matchers) == 0 || duration <= 0 {
+		return Response{}, fmt.Errorf("a matcher and a duration are required, e.g. silence alertname=HighErrorRate 2h")
+	}
+	if duration > MaxSilence {
+		return Response{}, fmt.Errorf("silences from chat are limited to %s", MaxSilence)
+	}
+	if silence.Comment == "" {
+		silence.Comment = "Silenced from chat"
+	}
+
+	silence.StartsAt = b.clock()
+	silence.EndsAt = silence.StartsAt.Add(duration)
+	id, err := b.Silencer.Silence(ctx, silence)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var matchers []string
+	for name, value := range silence.Matchers {
+		matchers = append(matchers, name+"="+value)
+	}
+	sort.Strings(matchers)
+	return Response{
+		Text: fmt.Sprintf("🔕 %s silenced %s for %s (silence %s): %s",
+			silence.CreatedBy, strings.Join(matchers, ", "), duration, id, silence.Comment),
+		Public: true,
+	}, nil
+}
+
+func (b *Bot) traces(ctx context.Context, args []string) (Response, error) {
+	if b.Lookup == nil {
+		return Response{}, fmt.Errorf("trace search is not configured")
+	}
+	if len(args) > 0 && args[0] == "search" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return Response{}, fmt.Errorf("expected traces search <attribute>=<value> [since]")
+	}
+
+	attribute, value, ok := strings.Cut(args[0], "=")
+	if !ok {
+		return Response{}, fmt.Errorf("expected <attribute>=<value>, got %q", args[0])
+	}
+	query := lookup.Query{Attribute: attribute, Value: value, Limit: 10}
+	if len(args) > 1 {
+		since, err := time.ParseDuration(args[1])
+		if err != nil {
+			return Response{}, fmt.Errorf("invalid since duration %q", args[1])
+		}
+		query.End = b.clock()
+		query.Start = query.End.Add(-since)
+	}
+	if err := query.Validate(); err != nil {
+		return Response{}, err
+	}
+
+	result, err := b.Lookup.Lookup(ctx, query)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d trace(s) and %d event(s) for %s=%s\n", len(result.TraceIDs), len(result.Events), attribute, value)
+	for i, e := range result.Events {
+		if i == 10 {
+			fmt.Fprintf(&sb, "… %d more\n", len(result.Events)-i)
+			break
+		}
+		line := fmt.Sprintf("%s [%s] %s", b.display().DateTime(e.Time), e.Source, e.Summary)
+		if e.TraceID != "" {
+			line += " trace=" + e.TraceID
+		}
+		sb.WriteString(line + "\n")
+	}
+	return Response{Text: sb.String()}, nil
+}
+
+func (b *Bot) auditor() Auditor {
+	if b.Auditor == nil {
+		return auditlog.Discard[AuditEvent]{}
+	}
+	return b.Auditor
+}
+
+func (b *Bot) display() *locale.Formatter {
+	if b.Display == nil {
+		return locale.UTC
+	}
+	return b.Display
+}
+
+func (b *Bot) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now().UTC()
+}
+
+func knownCommand(command string) bool {
+	for _, c := range Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
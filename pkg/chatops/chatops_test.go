@@ -0,0 +1,218 @@
+package chatops
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSilencer struct {
+	silences []Silence
+}
+
+func (f *fakeSilencer) Silence(ctx context.Context, s Silence) (string, error) {
+	f.silences = append(f.silences, s)
+	return "abc123", nil
+}
+
+type fakeStatus map[string]error
+
+func (f fakeStatus) Check(ctx context.Context) (map[string]error, error) {
+	return f, nil
+}
+
+func testBot() (*Bot, *fakeSilencer, *MemoryAuditor) {
+	silencer := &fakeSilencer{}
+	auditor := &MemoryAuditor{}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bot := &Bot{
+		Policy: Policy{Users: map[string][]string{
+			"slack:UOPS":  {"operator"},
+			"teams:*":     {"viewer"},
+			"slack:UVIEW": {"viewer"},
+		}},
+		Auditor:  auditor,
+		Status:   fakeStatus{"prometheus": nil, "loki": errors.New("unreachable")},
+		Silencer: silencer,
+		now:      func() time.Time { return now },
+	}
+	return bot, silencer, auditor
+}
+
+func TestHandleAuthorizesAndAudits(t *testing.T) {
+	bot, silencer, auditor := testBot()
+	ctx := context.Background()
+
+	resp := bot.Handle(ctx, Request{Platform: PlatformSlack, UserID: "UOPS", UserName: "ana", Text: "silence alertname=HighErrorRate service=checkout 2h deploying fix"})
+	if !resp.Public || !strings.Contains(resp.Text, "alertname=HighErrorRate, service=checkout for 2h0m0s") {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(silencer.silences) != 1 {
+		t.Fatalf("expected a silence, got %d", len(silencer.silences))
+	}
+	s := silencer.silences[0]
+	if s.EndsAt.Sub(s.StartsAt) != 2*time.Hour || s.Comment != "deploying fix" || s.CreatedBy != "ana (slack:UOPS)" {
+		t.Errorf("unexpected silence %+v", s)
+	}
+
+	// Viewers may check status but not silence alerts
+	resp = bot.Handle(ctx, Request{Platform: PlatformTeams, UserID: "29:anyone", Text: "status"})
+	if !strings.Contains(resp.Text, "1/2 components healthy") || !strings.Contains(resp.Text, "✗ loki: unreachable") {
+		t.Errorf("unexpected status %q", resp.Text)
+	}
+	resp = bot.Handle(ctx, Request{Platform: PlatformSlack, UserID: "UVIEW", Text: "silence alertname=X 1h"})
+	if !strings.Contains(resp.Text, "not allowed") || len(silencer.silences) != 1 {
+		t.Errorf("expected the silence to be denied, got %q", resp.Text)
+	}
+
+	// Unknown users are denied everything but help
+	if resp := bot.Handle(ctx, Request{Platform: PlatformSlack, UserID: "USTRANGER", Text: "status"}); !strings.Contains(resp.Text, "not allowed") {
+		t.Errorf("expected status to be denied, got %q", resp.Text)
+	}
+	if resp := bot.Handle(ctx, Request{Platform: PlatformSlack, UserID: "USTRANGER", Text: ""}); !strings.Contains(resp.Text, "Usage") {
+		t.Errorf("expected usage, got %q", resp.Text)
+	}
+
+	var outcomes []string
+	for _, e := range auditor.Events() {
+		outcomes = append(outcomes, e.Command+":"+e.Outcome)
+	}
+	want := "silence:succeeded,status:succeeded,silence:denied,status:denied,help:succeeded"
+	if got := strings.Join(outcomes, ","); got != want {
+		t.Errorf("unexpected audit trail\n got: %s\nwant: %s", got, want)
+	}
+	if e := auditor.Events()[2]; e.UserID != "UVIEW" || len(e.Roles) != 1 || e.Roles[0] != "viewer" {
+		t.Errorf("expected the denied caller and roles to be audited, got %+v", e)
+	}
+}
+
+func TestSilenceValidation(t *testing.T) {
+	bot, silencer, auditor := testBot()
+	for _, text := range []string{
+		"silence 2h",
+		"silence alertname=X",
+		"silence alertname=X 48h",
+		"silence alertname=X soon",
+	} {
+		resp := bot.Handle(context.Background(), Request{Platform: PlatformSlack, UserID: "UOPS", Text: text})
+		if !strings.Contains(resp.Text, "failed") {
+			t.Errorf("%q: expected a failure, got %q", text, resp.Text)
+		}
+	}
+	if len(silencer.silences) != 0 {
+		t.Errorf("expected no silences, got %d", len(silencer.silences))
+	}
+	if e := auditor.Events()[0]; e.Outcome != OutcomeFailed || e.Error == "" {
+		t.Errorf("expected failures to be audited, got %+v", e)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	bot, silencer, auditor := testBot()
+	bot.ReadOnly = true
+	resp := bot.Handle(context.Background(), Request{Platform: PlatformSlack, UserID: "UOPS", Text: "silence alertname=X 1h"})
+	if !strings.Contains(resp.Text, "read-only") || len(silencer.silences) != 0 {
+		t.Errorf("expected the silence to be refused, got %q", resp.Text)
+	}
+	if resp := bot.Handle(context.Background(), Request{Platform: PlatformSlack, UserID: "UOPS", Text: "status"}); strings.Contains(resp.Text, "read-only") {
+		t.Errorf("expected status to run, got %q", resp.Text)
+	}
+	if e := auditor.Events()[0]; e.Outcome != OutcomeDenied {
+		t.Errorf("expected the refusal to be audited, got %+v", e)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	if err := (Policy{Users: map[string][]string{"slack:U1": {"admin"}}}).Validate(); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+	custom := Policy{
+		Users: map[string][]string{"slack:U1": {"oncall"}},
+		Roles: map[string][]string{"oncall": {"silence"}},
+	}
+	if err := custom.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ok, _ := custom.Allowed(PlatformSlack, "U1", CommandStatus); ok {
+		t.Error("expected custom roles to replace the defaults")
+	}
+	if err := (Policy{Roles: map[string][]string{"oncall": {"deploy"}}}).Validate(); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+}
+
+func TestAlertmanagerSilence(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/silences" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			Matchers []struct {
+				Name    string `json:"name"`
+				IsEqual bool   `json:"isEqual"`
+			} `json:"matchers"`
+			CreatedBy string `json:"createdBy"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Matchers) != 1 || body.Matchers[0].Name != "alertname" || !body.Matchers[0].IsEqual || body.CreatedBy != "ana" {
+			t.Errorf("unexpected silence %+v", body)
+		}
+		w.Write([]byte(`{"silenceID":"s-1"}`))
+	}))
+	defer server.Close()
+
+	am := &Alertmanager{URL: server.URL + "/"}
+	id, err := am.Silence(context.Background(), Silence{Matchers: map[string]string{"alertname": "X"}, CreatedBy: "ana"})
+	if err != nil || id != "s-1" {
+		t.Errorf("unexpected result %q %v", id, err)
+	}
+}
+
+func TestVerifySlack(t *testing.T) {
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte("command=%2Fapm&text=status&user_id=U1")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	sig := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if err := VerifySlack("secret", ts, sig, body, now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := VerifySlack("other", ts, sig, body, now); err == nil {
+		t.Error("expected a signature mismatch")
+	}
+	if err := VerifySlack("secret", ts, sig, body, now.Add(10*time.Minute)); err == nil {
+		t.Error("expected a stale request to be rejected")
+	}
+}
+
+func TestVerifyTeams(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	token := base64.StdEncoding.EncodeToString(key)
+	body := []byte(`{"text":"<at>APM</at> status"}`)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	auth := "HMAC " + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if err := VerifyTeams(token, auth, body); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := VerifyTeams(token, auth, []byte(`{}`)); err == nil {
+		t.Error("expected a signature mismatch")
+	}
+	if err := VerifyTeams(token, "Bearer x", body); err == nil {
+		t.Error("expected a missing HMAC header to be rejected")
+	}
+}
@@ -0,0 +1,69 @@
+package chatops
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Built-in roles used when the policy defines none
+var defaultRoles = map[string][]string{
+	"viewer":   {CommandStatus, CommandTraces},
+	"operator": {CommandStatus, CommandTraces, CommandSilence},
+}
+
+// Policy maps chat identities to roles and roles to the commands they may
+// run. Identities are "<platform>:<user id>", e.g. "slack:U024BE7LH" or
+// "teams:29:1a2b3c"; "<platform>:*" matches every user of a platform.
+type Policy struct {
+	// Users maps an identity to its roles
+	Users map[string][]string `mapstructure:"users" yaml:"users" json:"users"`
+
+	// Roles maps a role to its commands; empty uses the viewer and
+	// operator roles
+	Roles map[string][]string `mapstructure:"roles" yaml:"roles,omitempty" json:"roles,omitempty"`
+}
+
+// Validate checks that every assigned role and permitted command exists
+func (p Policy) Validate() error {
+	roles := p.roles()
+	for identity, assigned := range p.Users {
+		for _, role := range assigned {
+			if _, ok := roles[role]; !ok {
+				return fmt.Errorf("chatops user %s has unknown role %q", identity, role)
+			}
+		}
+	}
+	for role, commands := range roles {
+		for _, c := range commands {
+			if !knownCommand(c) {
+				return fmt.Errorf("chatops role %s allows unknown command %q", role, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether a user may run a command and returns the roles
+// that were considered
+func (p Policy) Allowed(platform, userID, command string) (bool, []string) {
+	assigned := append([]string(nil), p.Users[platform+":"+userID]...)
+	assigned = append(assigned, p.Users[platform+":*"]...)
+	sort.Strings(assigned)
+
+	roles := p.roles()
+	for _, role := range assigned {
+		for _, c := range roles[role] {
+			if c == command {
+				return true, assigned
+			}
+		}
+	}
+	return false, assigned
+}
+
+func (p Policy) roles() map[string][]string {
+	if len(p.Roles) == 0 {
+		return defaultRoles
+	}
+	return p.Roles
+}
@@ -0,0 +1,59 @@
+package chatops
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackTolerance rejects Slack requests older than this, as Slack recommends
+const slackTolerance = 5 * time.Minute
+
+// VerifySlack checks a Slack request signature: the X-Slack-Signature header
+// is "v0=" followed by the hex HMAC-SHA256 of "v0:<timestamp>:<body>" keyed
+// with the app's signing secret
+func VerifySlack(signingSecret, timestamp, signature string, body []byte, now time.Time) error {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Slack request timestamp")
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > slackTolerance || age < -slackTolerance {
+		return fmt.Errorf("stale Slack request")
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("invalid Slack request signature")
+	}
+	return nil
+}
+
+// VerifyTeams checks a Teams outgoing webhook signature: the Authorization
+// header is "HMAC " followed by the base64 HMAC-SHA256 of the body keyed with
+// the base64-decoded security token
+func VerifyTeams(securityToken, authorization string, body []byte) error {
+	key, err := base64.StdEncoding.DecodeString(securityToken)
+	if err != nil {
+		return fmt.Errorf("invalid Teams security token: %w", err)
+	}
+	provided := strings.TrimPrefix(authorization, "HMAC ")
+	if provided == authorization {
+		return fmt.Errorf("missing Teams HMAC authorization")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(provided), []byte(expected)) {
+		return fmt.Errorf("invalid Teams request signature")
+	}
+	return nil
+}
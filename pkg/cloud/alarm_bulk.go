@@ -0,0 +1,327 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// maxAlarmNamesPerCall is the most alarm names EnableAlarmActions and
+// DisableAlarmActions accept at once
+const maxAlarmNamesPerCall = 100
+
+// AlarmSelector selects alarms for a bulk update. Service, Environment,
+// Severity, and Tags match alarm tags, dimensions of the same name (case
+// insensitive), and the APM alarm configuration. Values are shell patterns.
+type AlarmSelector struct {
+	NamePrefix  string            `json:"namePrefix,omitempty"`
+	Service     string            `json:"service,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// BulkAlarmUpdate is the change made to every selected alarm. Threshold sets
+// the threshold and ThresholdFactor, when not zero, multiplies it.
+type BulkAlarmUpdate struct {
+	Selector        AlarmSelector `json:"selector"`
+	Enable          *bool         `json:"enable,omitempty"`
+	Threshold       *float64      `json:"threshold,omitempty"`
+	ThresholdFactor float64       `json:"thresholdFactor,omitempty"`
+
+	// DryRun previews the changes without making them
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// AlarmChange is the planned or made change to one alarm
+type AlarmChange struct {
+	AlarmName         string  `json:"alarmName"`
+	ActionsEnabled    bool    `json:"actionsEnabled"`
+	NewActionsEnabled bool    `json:"newActionsEnabled"`
+	Threshold         float64 `json:"threshold"`
+	NewThreshold      float64 `json:"newThreshold"`
+	Error             string  `json:"error,omitempty"`
+}
+
+// BulkAlarmResult lists the alarms a bulk update matched and changed
+type BulkAlarmResult struct {
+	DryRun  bool          `json:"dryRun"`
+	Matched int           `json:"matched"`
+	Changes []AlarmChange `json:"changes"`
+	Failed  int           `json:"failed"`
+}
+
+// alarmActionSetter switches the actions of many alarms in one call
+type alarmActionSetter interface {
+	SetAlarmActions(ctx context.Context, names []string, enabled bool) error
+}
+
+// alarmTagger returns the tags of an alarm, which listing alarms omits
+type alarmTagger interface {
+	AlarmTags(ctx context.Context, arn string) (map[string]string, error)
+}
+
+// BulkUpdateAlarms enables, disables, or changes the thresholds of every
+// alarm the selector matches. Actions are switched for up to 100 alarms per
+// call when api supports it; thresholds need a PutMetricAlarm per alarm.
+// Failures are reported per alarm and do not stop the others.
+func BulkUpdateAlarms(ctx context.Context, api CloudWatchAPI, update BulkAlarmUpdate) (*BulkAlarmResult, error) {
+	if update.Enable == nil && update.Threshold == nil && update.ThresholdFactor == 0 {
+		return nil, fmt.Errorf("nothing to update: set enable, threshold, or thresholdFactor")
+	}
+	alarms, err := api.ListAlarms(ctx, update.Selector.NamePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkAlarmResult{DryRun: update.DryRun, Changes: []AlarmChange{}}
+	var selected []*CloudWatchAlarm
+	for _, alarm := range alarms {
+		if alarm.Tags == nil && update.Selector.needsTags() {
+			if tagger, ok := api.(alarmTagger); ok {
+				if alarm.Tags, err = tagger.AlarmTags(ctx, alarm.AlarmArn); err != nil {
+					return nil, fmt.Errorf("failed to read tags of alarm %s: %w", alarm.AlarmName, err)
+				}
+			}
+		}
+		if update.Selector.Matches(alarm) {
+			selected = append(selected, alarm)
+		}
+	}
+	result.Matched = len(selected)
+
+	changes := make(map[string]*AlarmChange)
+	var toEnable, toDisable []string
+	for _, alarm := range selected {
+		change := AlarmChange{
+			AlarmName:         alarm.AlarmName,
+			ActionsEnabled:    alarm.ActionsEnabled,
+			NewActionsEnabled: alarm.ActionsEnabled,
+			Threshold:         alarm.Threshold,
+			NewThreshold:      alarm.Threshold,
+		}
+		if update.Enable != nil {
+			change.NewActionsEnabled = *update.Enable
+		}
+		if update.Threshold != nil {
+			change.NewThreshold = *update.Threshold
+		}
+		if update.ThresholdFactor != 0 {
+			change.NewThreshold *= update.ThresholdFactor
+		}
+		if change.NewActionsEnabled == change.ActionsEnabled && change.NewThreshold == change.Threshold {
+			continue
+		}
+		result.Changes = append(result.Changes, change)
+		changes[alarm.AlarmName] = &result.Changes[len(result.Changes)-1]
+
+		if change.NewThreshold != change.Threshold && !update.DryRun {
+			config := alarm.config()
+			config.Threshold = change.NewThreshold
+			// PutMetricAlarm only keeps actions it is given, and enables them
+			config.ActionsEnabled = true
+			if _, err := api.CreateAlarm(ctx, config); err != nil {
+				changes[alarm.AlarmName].Error = err.Error()
+				continue
+			}
+			if !change.NewActionsEnabled {
+				toDisable = append(toDisable, alarm.AlarmName)
+			}
+			continue
+		}
+		if change.NewActionsEnabled != change.ActionsEnabled {
+			if change.NewActionsEnabled {
+				toEnable = append(toEnable, alarm.AlarmName)
+			} else {
+				toDisable = append(toDisable, alarm.AlarmName)
+			}
+		}
+	}
+
+	if !update.DryRun {
+		setAlarmActions(ctx, api, toEnable, true, changes)
+		setAlarmActions(ctx, api, toDisable, false, changes)
+	}
+	for _, c := range result.Changes {
+		if c.Error != "" {
+			result.Failed++
+		}
+	}
+	return result, nil
+}
+
+// setAlarmActions switches the actions of alarms, in batches when api
+// supports it, recording failures in changes
+func setAlarmActions(ctx context.Context, api CloudWatchAPI, names []string, enabled bool, changes map[string]*AlarmChange) {
+	if setter, ok := api.(alarmActionSetter); ok {
+		for start := 0; start < len(names); start += maxAlarmNamesPerCall {
+			batch := names[start:min(start+maxAlarmNamesPerCall, len(names))]
+			if err := setter.SetAlarmActions(ctx, batch, enabled); err != nil {
+				for _, name := range batch {
+					changes[name].Error = err.Error()
+				}
+			}
+		}
+		return
+	}
+	for _, name := range names {
+		var err error
+		if enabled {
+			err = api.EnableAlarm(ctx, name)
+		} else {
+			err = api.DisableAlarm(ctx, name)
+		}
+		if err != nil {
+			changes[name].Error = err.Error()
+		}
+	}
+}
+
+// Matches reports whether an alarm matches every field of the selector
+func (s AlarmSelector) Matches(alarm *CloudWatchAlarm) bool {
+	if !strings.HasPrefix(alarm.AlarmName, s.NamePrefix) {
+		return false
+	}
+	wanted := map[string]string{"service": s.Service, "environment": s.Environment, "severity": s.Severity}
+	for key, value := range s.Tags {
+		wanted[key] = value
+	}
+	for key, pattern := range wanted {
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, alarmLabel(alarm, key)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s AlarmSelector) needsTags() bool {
+	return s.Service != "" || s.Environment != "" || s.Severity != "" || len(s.Tags) > 0
+}
+
+// alarmLabel looks a key up in the tags, dimensions, and APM configuration of
+// an alarm
+func alarmLabel(alarm *CloudWatchAlarm, key string) string {
+	for _, tags := range []map[string]string{alarm.Tags, alarm.APMAlarmConfig.Tags} {
+		for k, v := range tags {
+			if strings.EqualFold(k, key) {
+				return v
+			}
+		}
+	}
+	for _, d := range alarm.Dimensions {
+		if d != nil && strings.EqualFold(d.Name, key) {
+			return d.Value
+		}
+	}
+	switch strings.ToLower(key) {
+	case "service":
+		return alarm.APMAlarmConfig.APMService
+	case "severity":
+		return alarm.APMAlarmConfig.Severity
+	}
+	return ""
+}
+
+// config returns the configuration that recreates an alarm
+func (a *CloudWatchAlarm) config() *AlarmConfig {
+	return &AlarmConfig{
+		AlarmName:                        a.AlarmName,
+		AlarmDescription:                 a.AlarmDescription,
+		MetricName:                       a.MetricName,
+		Namespace:                        a.Namespace,
+		Statistic:                        a.Statistic,
+		Dimensions:                       a.Dimensions,
+		Period:                           a.Period,
+		EvaluationPeriods:                a.EvaluationPeriods,
+		Threshold:                        a.Threshold,
+		ComparisonOperator:               a.ComparisonOperator,
+		TreatMissingData:                 a.TreatMissingData,
+		EvaluateLowSampleCountPercentile: a.EvaluateLowSampleCountPercentile,
+		DatapointsToAlarm:                a.DatapointsToAlarm,
+		ActionsEnabled:                   a.ActionsEnabled,
+		OKActions:                        a.OKActions,
+		AlarmActions:                     a.AlarmActions,
+		InsufficientDataActions:          a.InsufficientDataActions,
+		Tags:                             a.Tags,
+		APMAlarmConfig:                   a.APMAlarmConfig,
+	}
+}
+
+// BulkUpdateAlarms enables, disables, or changes the thresholds of the
+// alarms the selector matches
+func (cw *CloudWatchManager) BulkUpdateAlarms(ctx context.Context, update BulkAlarmUpdate) (*BulkAlarmResult, error) {
+	return BulkUpdateAlarms(ctx, cw, update)
+}
+
+// SetAlarmActions enables or disables the actions of up to 100 alarms
+func (cw *CloudWatchManager) SetAlarmActions(ctx context.Context, names []string, enabled bool) error {
+	return cw.alarmMgr.SetAlarmActions(ctx, names, enabled)
+}
+
+// AlarmTags returns the tags of an alarm
+func (cw *CloudWatchManager) AlarmTags(ctx context.Context, arn string) (map[string]string, error) {
+	return cw.alarmMgr.AlarmTags(ctx, arn)
+}
+
+// SetAlarmActions enables or disables the actions of up to 100 alarms in one
+// call
+func (am *AlarmManager) SetAlarmActions(ctx context.Context, names []string, enabled bool) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if len(names) > maxAlarmNamesPerCall {
+		return fmt.Errorf("at most %d alarms can be switched at once, got %d", maxAlarmNamesPerCall, len(names))
+	}
+	operation, verb := "disable-alarm-actions", "disable"
+	if enabled {
+		operation, verb = "enable-alarm-actions", "enable"
+	}
+	am.cloudWatch.logger.LogInfo(ctx, "Switching CloudWatch alarm actions", map[string]interface{}{
+		"alarms":  len(names),
+		"enabled": enabled,
+		"region":  am.cloudWatch.provider.config.DefaultRegion,
+	})
+
+	args := append([]string{"cloudwatch", operation, "--region", am.cloudWatch.provider.config.DefaultRegion, "--alarm-names"}, names...)
+	if err := exec.CommandContext(ctx, "aws", args...).Run(); err != nil {
+		return fmt.Errorf("failed to %s %d alarms: %w", verb, len(names), err)
+	}
+
+	for _, name := range names {
+		if alarm := am.cloudWatch.cache.GetAlarm(name); alarm != nil {
+			alarm.ActionsEnabled = enabled
+			am.cloudWatch.cache.SetAlarm(name, alarm)
+		}
+	}
+	return nil
+}
+
+// AlarmTags returns the tags of an alarm, which DescribeAlarms omits
+func (am *AlarmManager) AlarmTags(ctx context.Context, arn string) (map[string]string, error) {
+	output, err := exec.CommandContext(ctx, "aws", "cloudwatch", "list-tags-for-resource",
+		"--resource-arn", arn,
+		"--region", am.cloudWatch.provider.config.DefaultRegion).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alarm tags: %w", err)
+	}
+	var response struct {
+		Tags []struct {
+			Key   string `json:"Key"`
+			Value string `json:"Value"`
+		} `json:"Tags"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse alarm tags: %w", err)
+	}
+	tags := make(map[string]string, len(response.Tags))
+	for _, tag := range response.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
@@ -0,0 +1,348 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// Alert transport types. Alarm actions can only notify SNS, so the other
+// transports are EventBridge rules on the alarm state change events of the
+// account's default bus.
+const (
+	AlertTransportSNS         = "sns"
+	AlertTransportSQS         = "sqs"
+	AlertTransportEventBridge = "eventbridge"
+	AlertTransportWebhook     = "webhook"
+)
+
+// WebhookTokenHeader carries the shared secret of a webhook transport
+const WebhookTokenHeader = "X-APM-Token"
+
+// alarmStates are the states a transport can filter on
+var alarmStates = []string{"OK", "ALARM", "INSUFFICIENT_DATA"}
+
+// AlertTransport routes alarm state changes to consumers that cannot
+// subscribe to SNS
+type AlertTransport struct {
+	// Name names the EventBridge rule, and for webhooks the API destination
+	// and its connection (<name>-connection)
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// ARN is the SQS queue, EventBridge bus, or SNS topic to deliver to
+	ARN string `json:"arn,omitempty"`
+
+	// URL is the HTTPS endpoint of a webhook. Secret is sent in the
+	// X-APM-Token header of every delivery; EventBridge keeps it in Secrets
+	// Manager.
+	URL    string `json:"url,omitempty"`
+	Secret string `json:"secret,omitempty"`
+
+	// RateLimitPerSecond bounds webhook deliveries; zero uses 10
+	RateLimitPerSecond int `json:"rateLimitPerSecond,omitempty"`
+
+	// RoleARN lets EventBridge put events on a bus or invoke a webhook
+	RoleARN string `json:"roleArn,omitempty"`
+
+	// Region is where the rule is created; empty uses the provider's
+	// default region
+	Region string `json:"region,omitempty"`
+
+	// DeadLetterQueueARN is an SQS queue receiving the events that could not
+	// be delivered once the retries are used up
+	DeadLetterQueueARN string `json:"deadLetterQueueArn,omitempty"`
+
+	// MaxRetries and MaxEventAgeSeconds bound the retries; zero keeps the
+	// EventBridge defaults of 185 retries over 24 hours
+	MaxRetries         int `json:"maxRetries,omitempty"`
+	MaxEventAgeSeconds int `json:"maxEventAgeSeconds,omitempty"`
+
+	// AlarmPrefixes and States select the alarms and states routed; empty
+	// routes every alarm and state
+	AlarmPrefixes []string `json:"alarmPrefixes,omitempty"`
+	States        []string `json:"states,omitempty"`
+}
+
+// Validate checks that the transport can be created
+func (t *AlertTransport) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("alert transport name is required")
+	}
+	switch t.Type {
+	case AlertTransportSNS:
+		if !isARN(t.ARN, "sns") {
+			return fmt.Errorf("alert transport %s: arn must be an SNS topic ARN", t.Name)
+		}
+	case AlertTransportSQS:
+		if !isARN(t.ARN, "sqs") {
+			return fmt.Errorf("alert transport %s: arn must be an SQS queue ARN", t.Name)
+		}
+	case AlertTransportEventBridge:
+		if !isARN(t.ARN, "events") || !strings.Contains(t.ARN, ":event-bus/") {
+			return fmt.Errorf("alert transport %s: arn must be an EventBridge bus ARN", t.Name)
+		}
+		if t.RoleARN == "" {
+			return fmt.Errorf("alert transport %s: roleArn is required to put events on a bus", t.Name)
+		}
+	case AlertTransportWebhook:
+		u, err := url.Parse(t.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("alert transport %s: url must be an https URL", t.Name)
+		}
+		if t.Secret == "" {
+			return fmt.Errorf("alert transport %s: secret is required for webhooks", t.Name)
+		}
+		if t.RoleARN == "" {
+			return fmt.Errorf("alert transport %s: roleArn is required to invoke webhooks", t.Name)
+		}
+	default:
+		return fmt.Errorf("alert transport %s: unknown type %q (valid: sns, sqs, eventbridge, webhook)", t.Name, t.Type)
+	}
+
+	if t.DeadLetterQueueARN != "" && !isARN(t.DeadLetterQueueARN, "sqs") {
+		return fmt.Errorf("alert transport %s: deadLetterQueueArn must be an SQS queue ARN", t.Name)
+	}
+	if t.MaxRetries < 0 || t.MaxRetries > 185 {
+		return fmt.Errorf("alert transport %s: maxRetries must be between 0 and 185", t.Name)
+	}
+	if t.MaxEventAgeSeconds != 0 && (t.MaxEventAgeSeconds < 60 || t.MaxEventAgeSeconds > 86400) {
+		return fmt.Errorf("alert transport %s: maxEventAgeSeconds must be between 60 and 86400", t.Name)
+	}
+	for _, s := range t.States {
+		if !containsString(alarmStates, s) {
+			return fmt.Errorf("alert transport %s: unknown state %q (valid: %s)", t.Name, s, strings.Join(alarmStates, ", "))
+		}
+	}
+	return nil
+}
+
+// ConnectionName is the name of a webhook's EventBridge connection
+func (t *AlertTransport) ConnectionName() string {
+	return t.Name + "-connection"
+}
+
+// EventPattern matches the alarm state changes the transport routes
+func (t *AlertTransport) EventPattern() map[string]interface{} {
+	detail := map[string]interface{}{}
+	if len(t.AlarmPrefixes) > 0 {
+		prefixes := make([]map[string]string, 0, len(t.AlarmPrefixes))
+		for _, p := range t.AlarmPrefixes {
+			prefixes = append(prefixes, map[string]string{"prefix": p})
+		}
+		detail["alarmName"] = prefixes
+	}
+	if len(t.States) > 0 {
+		detail["state"] = map[string]interface{}{"value": t.States}
+	}
+	pattern := map[string]interface{}{
+		"source":      []string{"aws.cloudwatch"},
+		"detail-type": []string{"CloudWatch Alarm State Change"},
+	}
+	if len(detail) > 0 {
+		pattern["detail"] = detail
+	}
+	return pattern
+}
+
+// Target returns the put-targets entry delivering to arn: the transport's
+// ARN, or the API destination of a webhook
+func (t *AlertTransport) Target(arn string) map[string]interface{} {
+	target := map[string]interface{}{"Id": "apm-" + t.Type, "Arn": arn}
+	if t.RoleARN != "" && t.Type != AlertTransportSQS && t.Type != AlertTransportSNS {
+		target["RoleArn"] = t.RoleARN
+	}
+	if t.DeadLetterQueueARN != "" {
+		target["DeadLetterConfig"] = map[string]string{"Arn": t.DeadLetterQueueARN}
+	}
+	if t.MaxRetries > 0 || t.MaxEventAgeSeconds > 0 {
+		retry := map[string]int{}
+		if t.MaxRetries > 0 {
+			retry["MaximumRetryAttempts"] = t.MaxRetries
+		}
+		if t.MaxEventAgeSeconds > 0 {
+			retry["MaximumEventAgeInSeconds"] = t.MaxEventAgeSeconds
+		}
+		target["RetryPolicy"] = retry
+	}
+
+	switch t.Type {
+	case AlertTransportSQS:
+		// FIFO queues need a message group; one group keeps alerts in order
+		if strings.HasSuffix(t.ARN, ".fifo") {
+			target["SqsParameters"] = map[string]string{"MessageGroupId": "apm-alerts"}
+		}
+	case AlertTransportWebhook:
+		// The payload has the shape of the APM lifecycle webhooks
+		target["InputTransformer"] = map[string]interface{}{
+			"InputPathsMap": map[string]string{
+				"id":       "$.id",
+				"time":     "$.time",
+				"alarm":    "$.detail.alarmName",
+				"state":    "$.detail.state.value",
+				"previous": "$.detail.previousState.value",
+				"reason":   "$.detail.state.reason",
+				"region":   "$.region",
+				"account":  "$.account",
+			},
+			"InputTemplate": `{"id": "<id>", "type": "cloudwatch.alarm", "time": "<time>", "source": "aws.cloudwatch", "subject": "<alarm>", "status": "<state>", ` +
+				`"data": {"previousState": "<previous>", "reason": "<reason>", "region": "<region>", "account": "<account>"}}`,
+		}
+		target["HttpParameters"] = map[string]interface{}{
+			"HeaderParameters": map[string]string{"X-APM-Event": "cloudwatch.alarm"},
+		}
+	}
+	return target
+}
+
+// CreateAlertTransport routes alarm state changes to a transport
+func (cw *CloudWatchManager) CreateAlertTransport(ctx context.Context, transport *AlertTransport) (*EventRule, error) {
+	return cw.eventsMgr.CreateAlertTransport(ctx, transport)
+}
+
+// CreateAlertTransport routes alarm state changes to a transport, creating
+// the connection and API destination of a webhook first, and returns the
+// rule. SQS queues must allow events.amazonaws.com to send messages.
+func (em *EventsManager) CreateAlertTransport(ctx context.Context, transport *AlertTransport) (*EventRule, error) {
+	if err := transport.Validate(); err != nil {
+		return nil, err
+	}
+	arn := transport.ARN
+	if transport.Type == AlertTransportWebhook {
+		connectionARN, err := em.CreateWebhookConnection(ctx, transport)
+		if err != nil {
+			return nil, err
+		}
+		if arn, err = em.CreateAPIDestination(ctx, transport, connectionARN); err != nil {
+			return nil, err
+		}
+	}
+	return em.PutAlertTransportRule(ctx, transport, arn)
+}
+
+// CreateWebhookConnection creates the connection that authenticates the
+// deliveries of a webhook transport with its secret and returns its ARN
+func (em *EventsManager) CreateWebhookConnection(ctx context.Context, transport *AlertTransport) (string, error) {
+	auth, _ := json.Marshal(map[string]interface{}{
+		"ApiKeyAuthParameters": map[string]string{"ApiKeyName": WebhookTokenHeader, "ApiKeyValue": transport.Secret},
+	})
+	output, err := em.aws(ctx, transport.Region, "events", "create-connection",
+		"--name", transport.ConnectionName(),
+		"--description", "APM alert webhook "+transport.Name,
+		"--authorization-type", "API_KEY",
+		"--auth-parameters", string(auth))
+	if err != nil {
+		return "", fmt.Errorf("failed to create connection for %s: %w", transport.Name, err)
+	}
+	var response struct {
+		ConnectionArn string `json:"ConnectionArn"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return "", fmt.Errorf("failed to parse connection response: %w", err)
+	}
+	return response.ConnectionArn, nil
+}
+
+// CreateAPIDestination creates the API destination of a webhook transport
+// and returns its ARN
+func (em *EventsManager) CreateAPIDestination(ctx context.Context, transport *AlertTransport, connectionARN string) (string, error) {
+	rate := transport.RateLimitPerSecond
+	if rate == 0 {
+		rate = 10
+	}
+	output, err := em.aws(ctx, transport.Region, "events", "create-api-destination",
+		"--name", transport.Name,
+		"--description", "APM alert webhook "+transport.Name,
+		"--connection-arn", connectionARN,
+		"--invocation-endpoint", transport.URL,
+		"--http-method", "POST",
+		"--invocation-rate-limit-per-second", fmt.Sprint(rate))
+	if err != nil {
+		return "", fmt.Errorf("failed to create API destination for %s: %w", transport.Name, err)
+	}
+	var response struct {
+		ApiDestinationArn string `json:"ApiDestinationArn"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return "", fmt.Errorf("failed to parse API destination response: %w", err)
+	}
+	return response.ApiDestinationArn, nil
+}
+
+// PutAlertTransportRule creates or updates the rule routing alarm state
+// changes to arn. Unlike CreateEventRule, a target that cannot be added
+// fails the call: a rule without its target would drop alerts silently.
+func (em *EventsManager) PutAlertTransportRule(ctx context.Context, transport *AlertTransport, arn string) (*EventRule, error) {
+	em.cloudWatch.logger.LogInfo(ctx, "Routing CloudWatch alarms", map[string]interface{}{
+		"transport": transport.Name,
+		"type":      transport.Type,
+		"region":    em.cloudWatch.provider.config.region(transport.Region),
+	})
+
+	pattern := transport.EventPattern()
+	patternJSON, _ := json.Marshal(pattern)
+	output, err := em.aws(ctx, transport.Region, "events", "put-rule",
+		"--name", transport.Name,
+		"--description", fmt.Sprintf("APM alarm state changes to %s %s", transport.Type, transport.Name),
+		"--state", "ENABLED",
+		"--event-pattern", string(patternJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rule %s: %w", transport.Name, err)
+	}
+	var rule struct {
+		RuleArn string `json:"RuleArn"`
+	}
+	if err := json.Unmarshal(output, &rule); err != nil {
+		return nil, fmt.Errorf("failed to parse rule response: %w", err)
+	}
+
+	targets, _ := json.Marshal([]map[string]interface{}{transport.Target(arn)})
+	output, err = em.aws(ctx, transport.Region, "events", "put-targets", "--rule", transport.Name, "--targets", string(targets))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add target to rule %s: %w", transport.Name, err)
+	}
+	var result struct {
+		FailedEntryCount int `json:"FailedEntryCount"`
+		FailedEntries    []struct {
+			ErrorCode    string `json:"ErrorCode"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"FailedEntries"`
+	}
+	if err := json.Unmarshal(output, &result); err == nil && result.FailedEntryCount > 0 {
+		return nil, fmt.Errorf("failed to add target to rule %s: %s: %s", transport.Name, result.FailedEntries[0].ErrorCode, result.FailedEntries[0].ErrorMessage)
+	}
+
+	created := &EventRule{
+		Name:         transport.Name,
+		Arn:          rule.RuleArn,
+		Description:  fmt.Sprintf("APM alarm state changes to %s %s", transport.Type, transport.Name),
+		EventPattern: pattern,
+		State:        "ENABLED",
+		Targets:      []EventTarget{{Id: "apm-" + transport.Type, Arn: arn, RoleArn: transport.RoleARN}},
+	}
+	em.cloudWatch.cache.SetEventRule(transport.Name, created)
+	return created, nil
+}
+
+func (em *EventsManager) aws(ctx context.Context, region string, args ...string) ([]byte, error) {
+	args = append(args, "--region", em.cloudWatch.provider.config.region(region))
+	return exec.CommandContext(ctx, "aws", args...).Output()
+}
+
+// isARN reports whether arn is an ARN of an AWS service
+func isARN(arn, service string) bool {
+	parts := strings.SplitN(arn, ":", 6)
+	return len(parts) == 6 && parts[0] == "arn" && parts[2] == service
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
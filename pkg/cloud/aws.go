@@ -20,6 +20,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/chaksack/apm/pkg/progress"
 )
 
 // AWSProvider implements CloudProvider for AWS
@@ -855,15 +857,29 @@ type CloudWatchDashboard struct {
 // DashboardConfig represents configuration for creating/updating dashboards
 type DashboardConfig struct {
 	Name           string                  `json:"name"`
+	Region         string                  `json:"region,omitempty"` // Overrides the provider's default region
 	Body           string                  `json:"body,omitempty"`
 	Widgets        []*DashboardWidget      `json:"widgets,omitempty"`
-	Variables      map[string]string       `json:"variables,omitempty"`
 	Tags           map[string]string       `json:"tags,omitempty"`
 	APMIntegration APMDashboardIntegration `json:"apmIntegration"`
 	Template       string                  `json:"template,omitempty"` // APM template type
 	AutoRefresh    int                     `json:"autoRefresh,omitempty"`
 	TimeRange      DashboardTimeRange      `json:"timeRange,omitempty"`
 	Description    string                  `json:"description,omitempty"`
+
+	// Variables sets the default values of the template variables
+	// (environment, service, namespace, region)
+	Variables map[string]string `json:"variables,omitempty"`
+	// Choices lists the values offered by a template variable; without them
+	// environment, service, and namespace offer the values found in the
+	// metrics
+	Choices map[string][]string `json:"choices,omitempty"`
+
+	// Thresholds are drawn on the template widgets showing their metric,
+	// keyed by metric name, e.g. ErrorRate or CPUUtilization
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+	// EnvironmentThresholds overrides Thresholds per environment
+	EnvironmentThresholds map[string]map[string]float64 `json:"environmentThresholds,omitempty"`
 }
 
 // DashboardWidget represents a widget in a CloudWatch dashboard
@@ -994,6 +1010,7 @@ type CloudWatchAlarm struct {
 // AlarmConfig represents configuration for creating/updating alarms
 type AlarmConfig struct {
 	AlarmName                        string            `json:"alarmName"`
+	Region                           string            `json:"region,omitempty"` // Overrides the provider's default region
 	AlarmDescription                 string            `json:"alarmDescription"`
 	MetricName                       string            `json:"metricName"`
 	Namespace                        string            `json:"namespace"`
@@ -1088,6 +1105,7 @@ type CloudWatchLogGroup struct {
 // LogGroupConfig represents configuration for creating/updating log groups
 type LogGroupConfig struct {
 	LogGroupName    string            `json:"logGroupName"`
+	Region          string            `json:"region,omitempty"` // Overrides the provider's default region
 	RetentionInDays int               `json:"retentionInDays"`
 	KmsKeyId        string            `json:"kmsKeyId"`
 	Tags            map[string]string `json:"tags"`
@@ -1130,6 +1148,7 @@ type APMLogConfig struct {
 // MetricFilter represents a CloudWatch metric filter
 type MetricFilter struct {
 	FilterName            string                 `json:"filterName"`
+	Region                string                 `json:"region,omitempty"` // Overrides the provider's default region
 	FilterPattern         string                 `json:"filterPattern"`
 	MetricTransformations []MetricTransformation `json:"metricTransformations"`
 	CreationTime          time.Time              `json:"creationTime"`
@@ -1269,6 +1288,7 @@ type EventRule struct {
 // EventRuleConfig represents configuration for creating/updating event rules
 type EventRuleConfig struct {
 	Name               string                 `json:"name"`
+	Region             string                 `json:"region,omitempty"` // Overrides the provider's default region
 	Description        string                 `json:"description"`
 	EventPattern       map[string]interface{} `json:"eventPattern"`
 	ScheduleExpression string                 `json:"scheduleExpression"`
@@ -1462,6 +1482,7 @@ type SNSTopic struct {
 // SNSTopicConfig represents configuration for creating/updating SNS topics
 type SNSTopicConfig struct {
 	TopicName             string                `json:"topicName"`
+	Region                string                `json:"region,omitempty"` // Overrides the provider's default region
 	DisplayName           string                `json:"displayName"`
 	Attributes            map[string]string     `json:"attributes"`
 	Tags                  map[string]string     `json:"tags"`
@@ -1552,6 +1573,10 @@ func NewAWSProvider(config *ProviderConfig) (*AWSProvider, error) {
 		}
 	}
 
+	if err := config.checkRegion("NewProvider", config.DefaultRegion); err != nil {
+		return nil, err
+	}
+
 	p := &AWSProvider{
 		config:    config,
 		cache:     NewCredentialCache(config.CacheDuration),
@@ -1976,6 +2001,9 @@ func (p *AWSProvider) GetCurrentRegion() string {
 
 // SetRegion sets the AWS region
 func (p *AWSProvider) SetRegion(region string) error {
+	if err := p.config.checkRegion("SetRegion", region); err != nil {
+		return err
+	}
 	p.config.DefaultRegion = region
 	return nil
 }
@@ -2343,22 +2371,32 @@ func (p *AWSProvider) ListEKSClustersAllRegions(ctx context.Context) ([]*Cluster
 		return nil, fmt.Errorf("failed to list regions: %w", err)
 	}
 
+	tracker := progress.FromContext(ctx)
+	tracker.AddTotal(len(regions))
+
 	var allClusters []*Cluster
 	for _, region := range regions {
+		if err := ctx.Err(); err != nil {
+			return allClusters, err
+		}
+		tracker.Step(region)
+
 		// Temporarily set region
 		originalRegion := p.config.DefaultRegion
 		p.config.DefaultRegion = region
 
 		clusters, err := p.ListClusters(ctx)
+
+		// Restore original region
+		p.config.DefaultRegion = originalRegion
+		tracker.Advance(1)
+
 		if err != nil {
 			// Skip regions with errors (e.g., access denied)
 			continue
 		}
 
 		allClusters = append(allClusters, clusters...)
-
-		// Restore original region
-		p.config.DefaultRegion = originalRegion
 	}
 
 	return allClusters, nil
@@ -2790,6 +2828,7 @@ func (p *AWSProvider) ValidateSTSToken(ctx context.Context, credentials *Credent
 
 	cmd := exec.Command("aws", "sts", "get-caller-identity")
 	cmd.Env = env
+	applyProxyEnv(cmd, p.config)
 	output, err := cmd.Output()
 	if err != nil {
 		return &STSTokenValidation{
@@ -4301,8 +4340,15 @@ func (m *CloudFormationManager) DetectDrift(ctx context.Context, stackName, regi
 	maxRetries := 30
 	retryInterval := 5 * time.Second
 
+	tracker := progress.FromContext(ctx)
+	tracker.Step("drift detection " + stackName)
+
 	for i := 0; i < maxRetries; i++ {
-		time.Sleep(retryInterval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
 
 		statusCmd := exec.Command("aws", "cloudformation", "describe-stack-drift-detection-status",
 			"--stack-drift-detection-id", driftResult.StackDriftDetectionId, "--region", region)
@@ -4321,6 +4367,8 @@ func (m *CloudFormationManager) DetectDrift(ctx context.Context, stackName, regi
 			continue
 		}
 
+		tracker.Message("%s (poll %d/%d)", statusResult.DetectionStatus, i+1, maxRetries)
+
 		if statusResult.DetectionStatus == "DETECTION_COMPLETE" {
 			// Get detailed drift results
 			return m.getDriftDetails(ctx, stackName, region, statusResult.StackDriftStatus, statusResult.Timestamp)
@@ -5657,6 +5705,10 @@ func (s *S3Manager) getDefaultBucketOptions(region string) *BucketOptions {
 
 // createBucketWithAWS creates the bucket using AWS CLI
 func (s *S3Manager) createBucketWithAWS(ctx context.Context, name, region string) error {
+	if err := s.provider.config.checkRegion("CreateBucket", region); err != nil {
+		return err
+	}
+
 	args := []string{"s3api", "create-bucket", "--bucket", name}
 
 	if region != "us-east-1" {
@@ -7606,12 +7658,12 @@ func (m *S3Metrics) RecordOperation(ctx *S3OperationContext) {
 }
 
 // GetMetrics returns current metrics
-func (m *S3Metrics) GetMetrics() S3Metrics {
+func (m *S3Metrics) GetMetrics() *S3Metrics {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
 	// Create a copy to avoid race conditions
-	metrics := S3Metrics{
+	metrics := &S3Metrics{
 		TotalOperations:      m.TotalOperations,
 		SuccessfulOps:        m.SuccessfulOps,
 		FailedOps:            m.FailedOps,
@@ -8579,10 +8631,11 @@ func NewDashboardManager(cw *CloudWatchManager) *DashboardManager {
 
 // CreateDashboard creates a CloudWatch dashboard with APM-specific templates
 func (dm *DashboardManager) CreateDashboard(ctx context.Context, config *DashboardConfig) (*CloudWatchDashboard, error) {
+	region := dm.cloudWatch.provider.config.region(config.Region)
 	dm.cloudWatch.logger.LogInfo(ctx, "Creating CloudWatch dashboard", map[string]interface{}{
 		"dashboardName": config.Name,
 		"template":      config.Template,
-		"region":        dm.cloudWatch.provider.config.DefaultRegion,
+		"region":        region,
 	})
 
 	startTime := time.Now()
@@ -8601,14 +8654,12 @@ func (dm *DashboardManager) CreateDashboard(ctx context.Context, config *Dashboa
 	}
 
 	// Build AWS CLI command for dashboard creation
-	region := dm.cloudWatch.provider.config.DefaultRegion
 	cmd := exec.Command("aws", "cloudwatch", "put-dashboard",
 		"--dashboard-name", config.Name,
 		"--dashboard-body", dashboardBody,
 		"--region", region)
 
-	output, err := cmd.Output()
-	if err != nil {
+	if _, err := cmd.Output(); err != nil {
 		return nil, fmt.Errorf("failed to create dashboard: %w", err)
 	}
 
@@ -8819,23 +8870,31 @@ func (dm *DashboardManager) DeleteDashboard(ctx context.Context, name string) er
 // generateDashboardFromTemplate generates dashboard JSON from APM templates
 func (dm *DashboardManager) generateDashboardFromTemplate(template string, config *DashboardConfig) (string, error) {
 	var dashboardTemplate map[string]interface{}
+	t := dm.newDashboardTemplate(config)
 
 	switch template {
 	case "infrastructure":
-		dashboardTemplate = dm.getInfrastructureDashboardTemplate(config)
+		dashboardTemplate = dm.getInfrastructureDashboardTemplate(t)
 	case "application":
-		dashboardTemplate = dm.getApplicationDashboardTemplate(config)
+		dashboardTemplate = dm.getApplicationDashboardTemplate(t)
 	case "service-mesh":
-		dashboardTemplate = dm.getServiceMeshDashboardTemplate(config)
+		dashboardTemplate = dm.getServiceMeshDashboardTemplate(t)
 	case "logs":
-		dashboardTemplate = dm.getLogsDashboardTemplate(config)
+		dashboardTemplate = dm.getLogsDashboardTemplate(t)
 	case "tracing":
-		dashboardTemplate = dm.getTracingDashboardTemplate(config)
+		dashboardTemplate = dm.getTracingDashboardTemplate(t)
 	case "cost":
+		// Billing metrics only exist in us-east-1, so the variables do not apply
 		dashboardTemplate = dm.getCostDashboardTemplate(config)
 	default:
 		return "", fmt.Errorf("unknown dashboard template: %s", template)
 	}
+	if widgets, ok := dashboardTemplate["widgets"].([]map[string]interface{}); ok {
+		t.annotate(widgets)
+	}
+	if template != "cost" {
+		dashboardTemplate["variables"] = t.variables()
+	}
 
 	// Serialize template to JSON
 	templateJSON, err := json.MarshalIndent(dashboardTemplate, "", "  ")
@@ -8913,7 +8972,7 @@ func getBool(m map[string]interface{}, key string) bool {
 // APM Dashboard Templates
 
 // getInfrastructureDashboardTemplate returns infrastructure monitoring template
-func (dm *DashboardManager) getInfrastructureDashboardTemplate(config *DashboardConfig) map[string]interface{} {
+func (dm *DashboardManager) getInfrastructureDashboardTemplate(t *dashboardTemplate) map[string]interface{} {
 	return map[string]interface{}{
 		"widgets": []map[string]interface{}{
 			{
@@ -8927,7 +8986,7 @@ func (dm *DashboardManager) getInfrastructureDashboardTemplate(config *Dashboard
 					},
 					"period": 300,
 					"stat":   "Average",
-					"region": dm.cloudWatch.provider.config.DefaultRegion,
+					"region": t.region(),
 					"title":  "Infrastructure Metrics",
 				},
 			},
@@ -8941,7 +9000,7 @@ func (dm *DashboardManager) getInfrastructureDashboardTemplate(config *Dashboard
 					},
 					"period": 300,
 					"stat":   "Sum",
-					"region": dm.cloudWatch.provider.config.DefaultRegion,
+					"region": t.region(),
 					"title":  "Kubernetes Cluster Metrics",
 				},
 			},
@@ -8950,7 +9009,7 @@ func (dm *DashboardManager) getInfrastructureDashboardTemplate(config *Dashboard
 }
 
 // getApplicationDashboardTemplate returns application performance template
-func (dm *DashboardManager) getApplicationDashboardTemplate(config *DashboardConfig) map[string]interface{} {
+func (dm *DashboardManager) getApplicationDashboardTemplate(t *dashboardTemplate) map[string]interface{} {
 	return map[string]interface{}{
 		"widgets": []map[string]interface{}{
 			{
@@ -8958,13 +9017,13 @@ func (dm *DashboardManager) getApplicationDashboardTemplate(config *DashboardCon
 				"position": map[string]int{"x": 0, "y": 0, "width": 8, "height": 6},
 				"properties": map[string]interface{}{
 					"metrics": [][]interface{}{
-						{"Custom/APM", "RequestRate"},
-						{"Custom/APM", "ErrorRate"},
-						{"Custom/APM", "ResponseTime"},
+						t.metric("Custom/APM", "RequestRate"),
+						t.metric("Custom/APM", "ErrorRate"),
+						t.metric("Custom/APM", "ResponseTime"),
 					},
 					"period": 300,
 					"stat":   "Average",
-					"region": dm.cloudWatch.provider.config.DefaultRegion,
+					"region": t.region(),
 					"title":  "Application Performance",
 				},
 			},
@@ -8973,9 +9032,9 @@ func (dm *DashboardManager) getApplicationDashboardTemplate(config *DashboardCon
 				"position": map[string]int{"x": 8, "y": 0, "width": 16, "height": 6},
 				"properties": map[string]interface{}{
 					"query":     "fields @timestamp, @message | filter @type = \"ERROR\" | sort @timestamp desc | limit 100",
-					"region":    dm.cloudWatch.provider.config.DefaultRegion,
+					"region":    t.region(),
 					"title":     "Recent Errors",
-					"logGroups": config.APMIntegration.Namespaces,
+					"logGroups": t.config.APMIntegration.Namespaces,
 				},
 			},
 		},
@@ -8983,7 +9042,7 @@ func (dm *DashboardManager) getApplicationDashboardTemplate(config *DashboardCon
 }
 
 // getServiceMeshDashboardTemplate returns service mesh template
-func (dm *DashboardManager) getServiceMeshDashboardTemplate(config *DashboardConfig) map[string]interface{} {
+func (dm *DashboardManager) getServiceMeshDashboardTemplate(t *dashboardTemplate) map[string]interface{} {
 	return map[string]interface{}{
 		"widgets": []map[string]interface{}{
 			{
@@ -8991,13 +9050,13 @@ func (dm *DashboardManager) getServiceMeshDashboardTemplate(config *DashboardCon
 				"position": map[string]int{"x": 0, "y": 0, "width": 12, "height": 6},
 				"properties": map[string]interface{}{
 					"metrics": [][]interface{}{
-						{"Custom/Istio", "RequestTotal"},
-						{"Custom/Istio", "RequestDuration"},
-						{"Custom/Istio", "RequestBytes"},
+						t.metric("Custom/Istio", "RequestTotal"),
+						t.metric("Custom/Istio", "RequestDuration"),
+						t.metric("Custom/Istio", "RequestBytes"),
 					},
 					"period": 300,
 					"stat":   "Average",
-					"region": dm.cloudWatch.provider.config.DefaultRegion,
+					"region": t.region(),
 					"title":  "Service Mesh Metrics",
 				},
 			},
@@ -9006,7 +9065,7 @@ func (dm *DashboardManager) getServiceMeshDashboardTemplate(config *DashboardCon
 }
 
 // getLogsDashboardTemplate returns logs analysis template
-func (dm *DashboardManager) getLogsDashboardTemplate(config *DashboardConfig) map[string]interface{} {
+func (dm *DashboardManager) getLogsDashboardTemplate(t *dashboardTemplate) map[string]interface{} {
 	return map[string]interface{}{
 		"widgets": []map[string]interface{}{
 			{
@@ -9014,9 +9073,9 @@ func (dm *DashboardManager) getLogsDashboardTemplate(config *DashboardConfig) ma
 				"position": map[string]int{"x": 0, "y": 0, "width": 24, "height": 6},
 				"properties": map[string]interface{}{
 					"query":     "fields @timestamp, @message | sort @timestamp desc | limit 100",
-					"region":    dm.cloudWatch.provider.config.DefaultRegion,
+					"region":    t.region(),
 					"title":     "Application Logs",
-					"logGroups": config.APMIntegration.Namespaces,
+					"logGroups": t.config.APMIntegration.Namespaces,
 				},
 			},
 		},
@@ -9024,7 +9083,7 @@ func (dm *DashboardManager) getLogsDashboardTemplate(config *DashboardConfig) ma
 }
 
 // getTracingDashboardTemplate returns distributed tracing template
-func (dm *DashboardManager) getTracingDashboardTemplate(config *DashboardConfig) map[string]interface{} {
+func (dm *DashboardManager) getTracingDashboardTemplate(t *dashboardTemplate) map[string]interface{} {
 	return map[string]interface{}{
 		"widgets": []map[string]interface{}{
 			{
@@ -9032,13 +9091,13 @@ func (dm *DashboardManager) getTracingDashboardTemplate(config *DashboardConfig)
 				"position": map[string]int{"x": 0, "y": 0, "width": 12, "height": 6},
 				"properties": map[string]interface{}{
 					"metrics": [][]interface{}{
-						{"Custom/Tracing", "TraceCount"},
-						{"Custom/Tracing", "SpanCount"},
-						{"Custom/Tracing", "TraceDuration"},
+						t.metric("Custom/Tracing", "TraceCount"),
+						t.metric("Custom/Tracing", "SpanCount"),
+						t.metric("Custom/Tracing", "TraceDuration"),
 					},
 					"period": 300,
 					"stat":   "Average",
-					"region": dm.cloudWatch.provider.config.DefaultRegion,
+					"region": t.region(),
 					"title":  "Distributed Tracing",
 				},
 			},
@@ -9083,11 +9142,12 @@ func NewAlarmManager(cw *CloudWatchManager) *AlarmManager {
 
 // CreateAlarm creates a CloudWatch alarm for APM infrastructure
 func (am *AlarmManager) CreateAlarm(ctx context.Context, config *AlarmConfig) (*CloudWatchAlarm, error) {
+	region := am.cloudWatch.provider.config.region(config.Region)
 	am.cloudWatch.logger.LogInfo(ctx, "Creating CloudWatch alarm", map[string]interface{}{
 		"alarmName":  config.AlarmName,
 		"metricName": config.MetricName,
 		"namespace":  config.Namespace,
-		"region":     am.cloudWatch.provider.config.DefaultRegion,
+		"region":     region,
 	})
 
 	startTime := time.Now()
@@ -9096,7 +9156,6 @@ func (am *AlarmManager) CreateAlarm(ctx context.Context, config *AlarmConfig) (*
 	}()
 
 	// Build AWS CLI command for alarm creation
-	region := am.cloudWatch.provider.config.DefaultRegion
 	args := []string{
 		"cloudwatch", "put-metric-alarm",
 		"--alarm-name", config.AlarmName,
@@ -9221,23 +9280,30 @@ func (am *AlarmManager) ListAlarms(ctx context.Context, prefix string) ([]*Cloud
 	// Parse output
 	var response struct {
 		MetricAlarms []struct {
-			AlarmName               string    `json:"AlarmName"`
-			AlarmArn                string    `json:"AlarmArn"`
-			AlarmDescription        string    `json:"AlarmDescription"`
-			MetricName              string    `json:"MetricName"`
-			Namespace               string    `json:"Namespace"`
-			Statistic               string    `json:"Statistic"`
-			Period                  int       `json:"Period"`
-			EvaluationPeriods       int       `json:"EvaluationPeriods"`
-			Threshold               float64   `json:"Threshold"`
-			ComparisonOperator      string    `json:"ComparisonOperator"`
-			StateValue              string    `json:"StateValue"`
-			StateReason             string    `json:"StateReason"`
-			StateUpdatedTimestamp   time.Time `json:"StateUpdatedTimestamp"`
-			ActionsEnabled          bool      `json:"ActionsEnabled"`
-			AlarmActions            []string  `json:"AlarmActions"`
-			OKActions               []string  `json:"OKActions"`
-			InsufficientDataActions []string  `json:"InsufficientDataActions"`
+			AlarmName          string  `json:"AlarmName"`
+			AlarmArn           string  `json:"AlarmArn"`
+			AlarmDescription   string  `json:"AlarmDescription"`
+			MetricName         string  `json:"MetricName"`
+			Namespace          string  `json:"Namespace"`
+			Statistic          string  `json:"Statistic"`
+			Period             int     `json:"Period"`
+			EvaluationPeriods  int     `json:"EvaluationPeriods"`
+			Threshold          float64 `json:"Threshold"`
+			ComparisonOperator string  `json:"ComparisonOperator"`
+			Dimensions         []struct {
+				Name  string `json:"Name"`
+				Value string `json:"Value"`
+			} `json:"Dimensions"`
+			TreatMissingData                 string    `json:"TreatMissingData"`
+			EvaluateLowSampleCountPercentile string    `json:"EvaluateLowSampleCountPercentile"`
+			DatapointsToAlarm                int       `json:"DatapointsToAlarm"`
+			StateValue                       string    `json:"StateValue"`
+			StateReason                      string    `json:"StateReason"`
+			StateUpdatedTimestamp            time.Time `json:"StateUpdatedTimestamp"`
+			ActionsEnabled                   bool      `json:"ActionsEnabled"`
+			AlarmActions                     []string  `json:"AlarmActions"`
+			OKActions                        []string  `json:"OKActions"`
+			InsufficientDataActions          []string  `json:"InsufficientDataActions"`
 		} `json:"MetricAlarms"`
 	}
 
@@ -9249,29 +9315,35 @@ func (am *AlarmManager) ListAlarms(ctx context.Context, prefix string) ([]*Cloud
 	alarms := make([]*CloudWatchAlarm, 0, len(response.MetricAlarms))
 	for _, entry := range response.MetricAlarms {
 		alarm := &CloudWatchAlarm{
-			AlarmName:               entry.AlarmName,
-			AlarmArn:                entry.AlarmArn,
-			AlarmDescription:        entry.AlarmDescription,
-			MetricName:              entry.MetricName,
-			Namespace:               entry.Namespace,
-			Statistic:               entry.Statistic,
-			Period:                  entry.Period,
-			EvaluationPeriods:       entry.EvaluationPeriods,
-			Threshold:               entry.Threshold,
-			ComparisonOperator:      entry.ComparisonOperator,
-			StateReason:             entry.StateReason,
-			StateUpdatedTimestamp:   entry.StateUpdatedTimestamp,
-			ActionsEnabled:          entry.ActionsEnabled,
-			AlarmActions:            entry.AlarmActions,
-			OKActions:               entry.OKActions,
-			InsufficientDataActions: entry.InsufficientDataActions,
-			Region:                  region,
+			AlarmName:                        entry.AlarmName,
+			AlarmArn:                         entry.AlarmArn,
+			AlarmDescription:                 entry.AlarmDescription,
+			MetricName:                       entry.MetricName,
+			Namespace:                        entry.Namespace,
+			Statistic:                        entry.Statistic,
+			Period:                           entry.Period,
+			EvaluationPeriods:                entry.EvaluationPeriods,
+			Threshold:                        entry.Threshold,
+			ComparisonOperator:               entry.ComparisonOperator,
+			TreatMissingData:                 entry.TreatMissingData,
+			EvaluateLowSampleCountPercentile: entry.EvaluateLowSampleCountPercentile,
+			DatapointsToAlarm:                entry.DatapointsToAlarm,
+			StateReason:                      entry.StateReason,
+			StateUpdatedTimestamp:            entry.StateUpdatedTimestamp,
+			ActionsEnabled:                   entry.ActionsEnabled,
+			AlarmActions:                     entry.AlarmActions,
+			OKActions:                        entry.OKActions,
+			InsufficientDataActions:          entry.InsufficientDataActions,
+			Region:                           region,
 			State: AlarmState{
 				Value:     entry.StateValue,
 				Reason:    entry.StateReason,
 				Timestamp: entry.StateUpdatedTimestamp,
 			},
 		}
+		for _, d := range entry.Dimensions {
+			alarm.Dimensions = append(alarm.Dimensions, &AlarmDimension{Name: d.Name, Value: d.Value})
+		}
 		alarms = append(alarms, alarm)
 
 		// Cache individual alarm
@@ -9424,10 +9496,11 @@ func NewLogsManager(cw *CloudWatchManager) *LogsManager {
 
 // CreateLogGroup creates a CloudWatch log group
 func (lm *LogsManager) CreateLogGroup(ctx context.Context, config *LogGroupConfig) (*CloudWatchLogGroup, error) {
+	region := lm.cloudWatch.provider.config.region(config.Region)
 	lm.cloudWatch.logger.LogInfo(ctx, "Creating CloudWatch log group", map[string]interface{}{
 		"logGroupName":  config.LogGroupName,
 		"retentionDays": config.RetentionInDays,
-		"region":        lm.cloudWatch.provider.config.DefaultRegion,
+		"region":        region,
 	})
 
 	startTime := time.Now()
@@ -9436,7 +9509,9 @@ func (lm *LogsManager) CreateLogGroup(ctx context.Context, config *LogGroupConfi
 	}()
 
 	// Build AWS CLI command for log group creation
-	region := lm.cloudWatch.provider.config.DefaultRegion
+	if err := lm.cloudWatch.provider.config.checkRegion("CreateLogGroup", region); err != nil {
+		return nil, err
+	}
 	cmd := exec.Command("aws", "logs", "create-log-group",
 		"--log-group-name", config.LogGroupName,
 		"--region", region)
@@ -9747,10 +9822,11 @@ func NewEventsManager(cw *CloudWatchManager) *EventsManager {
 
 // CreateEventRule creates a CloudWatch event rule
 func (em *EventsManager) CreateEventRule(ctx context.Context, config *EventRuleConfig) (*EventRule, error) {
+	region := em.cloudWatch.provider.config.region(config.Region)
 	em.cloudWatch.logger.LogInfo(ctx, "Creating CloudWatch event rule", map[string]interface{}{
 		"ruleName":     config.Name,
 		"eventPattern": config.EventPattern,
-		"region":       em.cloudWatch.provider.config.DefaultRegion,
+		"region":       region,
 	})
 
 	startTime := time.Now()
@@ -9759,7 +9835,6 @@ func (em *EventsManager) CreateEventRule(ctx context.Context, config *EventRuleC
 	}()
 
 	// Build AWS CLI command
-	region := em.cloudWatch.provider.config.DefaultRegion
 	args := []string{
 		"events", "put-rule",
 		"--name", config.Name,
@@ -9865,9 +9940,10 @@ func NewSNSManager(cw *CloudWatchManager) *SNSManager {
 
 // CreateSNSTopic creates an SNS topic for notifications
 func (sm *SNSManager) CreateSNSTopic(ctx context.Context, config *SNSTopicConfig) (*SNSTopic, error) {
+	region := sm.cloudWatch.provider.config.region(config.Region)
 	sm.cloudWatch.logger.LogInfo(ctx, "Creating SNS topic", map[string]interface{}{
 		"topicName": config.TopicName,
-		"region":    sm.cloudWatch.provider.config.DefaultRegion,
+		"region":    region,
 	})
 
 	startTime := time.Now()
@@ -9876,7 +9952,6 @@ func (sm *SNSManager) CreateSNSTopic(ctx context.Context, config *SNSTopicConfig
 	}()
 
 	// Build AWS CLI command
-	region := sm.cloudWatch.provider.config.DefaultRegion
 	cmd := exec.Command("aws", "sns", "create-topic",
 		"--name", config.TopicName,
 		"--region", region)
@@ -10970,42 +11045,6 @@ type SessionMetrics struct {
 	LastRefreshTime time.Time `json:"lastRefreshTime"`
 }
 
-// CredentialCache provides secure credential storage with encryption
-type CredentialCache struct {
-	// Store is the underlying storage backend
-	Store map[string]*CachedCredential `json:"-"`
-
-	// EncryptionKey is used to encrypt sensitive credential data
-	EncryptionKey []byte `json:"-"`
-
-	// TTL is the default time-to-live for cached credentials
-	TTL time.Duration `json:"ttl"`
-
-	// MaxEntries is the maximum number of credentials to cache
-	MaxEntries int `json:"maxEntries"`
-
-	// EvictionPolicy determines how credentials are evicted (LRU, FIFO, TTL)
-	EvictionPolicy string `json:"evictionPolicy"`
-
-	// PersistToDisk enables persistent storage of encrypted credentials
-	PersistToDisk bool `json:"persistToDisk"`
-
-	// StoragePath is where to persist credentials on disk
-	StoragePath string `json:"storagePath"`
-
-	// AutoSave enables automatic saving on changes
-	AutoSave bool `json:"autoSave"`
-
-	// LastCleanup tracks when the cache was last cleaned
-	LastCleanup time.Time `json:"lastCleanup"`
-
-	// CleanupInterval is how often to clean expired entries
-	CleanupInterval time.Duration `json:"cleanupInterval"`
-
-	// mu protects concurrent access
-	mu sync.RWMutex `json:"-"`
-}
-
 // CachedCredential represents a cached credential entry
 type CachedCredential struct {
 	// Credential is the encrypted credential data
@@ -11514,14 +11553,15 @@ func (m *AccountManager) loadFromS3(ctx context.Context, s3Path string) error {
 
 	bucket, key := parts[0], parts[1]
 
-	downloadOptions := &DownloadOptions{
-		Bucket: bucket,
-		Key:    key,
+	body, err := s3Manager.DownloadFile(ctx, bucket, key, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download config from S3: %w", err)
 	}
+	defer body.Close()
 
-	data, err := s3Manager.DownloadFileToMemory(ctx, downloadOptions)
+	data, err := io.ReadAll(body)
 	if err != nil {
-		return fmt.Errorf("failed to download config from S3: %w", err)
+		return fmt.Errorf("failed to read config from S3: %w", err)
 	}
 
 	if err := json.Unmarshal(data, &m.config); err != nil {
@@ -11551,9 +11591,6 @@ func (m *AccountManager) saveToS3(ctx context.Context, s3Path string) error {
 	bucket, key := parts[0], parts[1]
 
 	uploadOptions := &UploadOptions{
-		Bucket:      bucket,
-		Key:         key,
-		Body:        data,
 		ContentType: "application/json",
 		Metadata: map[string]string{
 			"organization": m.config.Organization,
@@ -11561,7 +11598,7 @@ func (m *AccountManager) saveToS3(ctx context.Context, s3Path string) error {
 		},
 	}
 
-	_, err = s3Manager.UploadFile(ctx, uploadOptions)
+	_, err = s3Manager.UploadFile(ctx, bucket, key, bytes.NewReader(data), uploadOptions)
 	if err != nil {
 		return fmt.Errorf("failed to upload config to S3: %w", err)
 	}
@@ -73,8 +73,8 @@ func (p *AWSProvider) ValidateMFADevice(ctx context.Context, mfaDeviceArn string
 
 	// List MFA devices for the user
 	cmd := exec.CommandContext(ctx, "aws", "iam", "list-mfa-devices", "--user-name", userName, "--output", "json")
-	if p.config != nil && p.config.Region != "" {
-		cmd.Env = append(cmd.Environ(), fmt.Sprintf("AWS_DEFAULT_REGION=%s", p.config.Region))
+	if p.config != nil && p.config.DefaultRegion != "" {
+		cmd.Env = append(cmd.Environ(), fmt.Sprintf("AWS_DEFAULT_REGION=%s", p.config.DefaultRegion))
 	}
 
 	output, err := cmd.Output()
@@ -123,8 +123,8 @@ func (p *AWSProvider) ValidateMFADevice(ctx context.Context, mfaDeviceArn string
 // ListMFADevices lists all MFA devices for a user
 func (p *AWSProvider) ListMFADevices(ctx context.Context, userName string) ([]*MFADevice, error) {
 	cmd := exec.CommandContext(ctx, "aws", "iam", "list-mfa-devices", "--user-name", userName, "--output", "json")
-	if p.config != nil && p.config.Region != "" {
-		cmd.Env = append(cmd.Environ(), fmt.Sprintf("AWS_DEFAULT_REGION=%s", p.config.Region))
+	if p.config != nil && p.config.DefaultRegion != "" {
+		cmd.Env = append(cmd.Environ(), fmt.Sprintf("AWS_DEFAULT_REGION=%s", p.config.DefaultRegion))
 	}
 
 	output, err := cmd.Output()
@@ -163,8 +163,8 @@ func (p *AWSProvider) ListMFADevices(ctx context.Context, userName string) ([]*M
 func (p *AWSProvider) GetCurrentUserMFADevices(ctx context.Context) ([]*MFADevice, error) {
 	// Get current user name
 	cmd := exec.CommandContext(ctx, "aws", "sts", "get-caller-identity", "--output", "json")
-	if p.config != nil && p.config.Region != "" {
-		cmd.Env = append(cmd.Environ(), fmt.Sprintf("AWS_DEFAULT_REGION=%s", p.config.Region))
+	if p.config != nil && p.config.DefaultRegion != "" {
+		cmd.Env = append(cmd.Environ(), fmt.Sprintf("AWS_DEFAULT_REGION=%s", p.config.DefaultRegion))
 	}
 
 	output, err := cmd.Output()
@@ -357,11 +357,15 @@ func (m *MFASessionManager) GetOrCreateSession(ctx context.Context, roleArn, mfa
 		return nil, err
 	}
 
-	// Cache the session
+	// Cache the session; credentials without an expiry are not reused
+	var expiresAt time.Time
+	if creds.Expiry != nil {
+		expiresAt = *creds.Expiry
+	}
 	m.cache[cacheKey] = &MFASessionCache{
 		RoleArn:     roleArn,
 		Credentials: creds,
-		ExpiresAt:   creds.Expiration,
+		ExpiresAt:   expiresAt,
 		MFADevice:   mfaDeviceArn,
 		SessionName: options.SessionName,
 	}
@@ -310,8 +310,8 @@ func (m *RoleChainManager) validateChain(chain []*RoleChainStep) error {
 // rollbackChain performs cleanup for failed chain assumptions
 func (m *RoleChainManager) rollbackChain(session *ChainedSession, failedStep int) {
 	// Log the rollback
-	if m.provider.config != nil && m.provider.config.DebugMode {
-		fmt.Printf("Rolling back chain %s due to failure at step %d\n", session.ChainID, failedStep+1)
+	if m.provider.config != nil && m.provider.config.Logger != nil {
+		m.provider.config.Logger(fmt.Sprintf("Rolling back chain %s due to failure at step %d", session.ChainID, failedStep+1))
 	}
 
 	// Clear any stored credentials for this chain
@@ -388,8 +388,8 @@ func (m *RoleChainManager) refreshExpiringSessions() {
 				// Refresh the chain
 				if _, err := m.RefreshChain(ctx, session.ChainID); err != nil {
 					// Log error but continue with other sessions
-					if m.provider.config != nil && m.provider.config.DebugMode {
-						fmt.Printf("Failed to refresh chain %s: %v\n", session.ChainID, err)
+					if m.provider.config != nil && m.provider.config.Logger != nil {
+						m.provider.config.Logger(fmt.Sprintf("Failed to refresh chain %s: %v", session.ChainID, err))
 					}
 				}
 			}
@@ -441,15 +441,6 @@ func generateChainID() string {
 	return fmt.Sprintf("chain-%d-%d", time.Now().Unix(), time.Now().Nanosecond())
 }
 
-// extractAccountFromArn extracts the account ID from an ARN
-func extractAccountFromArn(arn string) string {
-	parts := strings.Split(arn, ":")
-	if len(parts) >= 5 {
-		return parts[4]
-	}
-	return ""
-}
-
 // Enhanced AssumeRoleChain method for AWSProvider that uses the RoleChainManager
 func (p *AWSProvider) AssumeRoleChainEnhanced(ctx context.Context, roleChain []*RoleChainStep, config *RoleChainConfig) (*Credentials, error) {
 	if p.crossAccountManager == nil {
@@ -104,8 +104,8 @@ func TestRoleChainManager_AssumeRoleChain(t *testing.T) {
 			// Mock provider for testing
 			provider := &AWSProvider{
 				config: &ProviderConfig{
-					Region:    "us-east-1",
-					DebugMode: true,
+					DefaultRegion: "us-east-1",
+					Logger:        func(msg string) { t.Log(msg) },
 				},
 			}
 
@@ -225,7 +225,7 @@ func TestRoleChainConfig_Defaults(t *testing.T) {
 func TestRoleChainManager_SessionManagement(t *testing.T) {
 	provider := &AWSProvider{
 		config: &ProviderConfig{
-			Region: "us-east-1",
+			DefaultRegion: "us-east-1",
 		},
 	}
 	manager := NewRoleChainManager(provider)
@@ -37,11 +37,15 @@ func NewAzureProvider(config *ProviderConfig) (*AzureProviderImpl, error) {
 		}
 	}
 
+	if err := config.checkRegion("NewProvider", config.DefaultRegion); err != nil {
+		return nil, err
+	}
+
 	return &AzureProviderImpl{
 		config:     config,
 		cache:      NewCredentialCache(config.CacheDuration),
 		logger:     log.New(os.Stdout, "[Azure] ", log.LstdFlags),
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		httpClient: newProviderHTTPClient(config, 30*time.Second),
 	}, nil
 }
 
@@ -458,6 +462,9 @@ func (p *AzureProviderImpl) GetCurrentRegion() string {
 
 // SetRegion sets the Azure region
 func (p *AzureProviderImpl) SetRegion(region string) error {
+	if err := p.config.checkRegion("SetRegion", region); err != nil {
+		return err
+	}
 	p.config.DefaultRegion = region
 	return nil
 }
@@ -678,6 +685,10 @@ func (p *AzureProviderImpl) ListResourceGroups(ctx context.Context) ([]*AzureRes
 
 // CreateResourceGroup creates a new resource group
 func (p *AzureProviderImpl) CreateResourceGroup(ctx context.Context, name, location string, tags map[string]string) (*AzureResourceGroup, error) {
+	if err := p.config.checkRegion("CreateResourceGroup", location); err != nil {
+		return nil, err
+	}
+
 	p.logger.Printf("Creating resource group: %s in %s", name, location)
 
 	args := []string{"group", "create", "--name", name, "--location", location, "-o", "json"}
@@ -1013,6 +1024,10 @@ func (p *AzureProviderImpl) ListActionGroups(ctx context.Context, resourceGroup
 
 // CreateApplicationInsights creates a new Application Insights resource
 func (p *AzureProviderImpl) CreateApplicationInsights(ctx context.Context, name, resourceGroup, location string) (*AzureApplicationInsights, error) {
+	if err := p.config.checkRegion("CreateApplicationInsights", location); err != nil {
+		return nil, err
+	}
+
 	p.logger.Printf("Creating Application Insights: %s in %s", name, resourceGroup)
 
 	cmd := exec.CommandContext(ctx, "az", "extension", "add", "--name", "application-insights")
@@ -1103,6 +1118,10 @@ func (p *AzureProviderImpl) ListStorageAccounts(ctx context.Context) ([]*AzureSt
 
 // CreateStorageAccount creates a new storage account
 func (p *AzureProviderImpl) CreateStorageAccount(ctx context.Context, name, resourceGroup, location string) (*AzureStorageAccount, error) {
+	if err := p.config.checkRegion("CreateStorageAccount", location); err != nil {
+		return nil, err
+	}
+
 	p.logger.Printf("Creating storage account: %s in %s", name, resourceGroup)
 
 	cmd := exec.CommandContext(ctx, "az", "storage", "account", "create",
@@ -0,0 +1,109 @@
+package cloud
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default CLI detection timeouts. `gcloud --version` alone can take a few
+// seconds on a cold start.
+const (
+	DefaultCLIDetectTimeout = 15 * time.Second
+	DefaultCLIProbeTimeout  = 10 * time.Second
+)
+
+// createCLIDetector is replaced in tests
+var createCLIDetector = NewDetectorFactory().CreateDetector
+
+// CLIDetectOptions configures DetectCLIs
+type CLIDetectOptions struct {
+	// Providers to probe; empty probes AWS, Azure, and GCP
+	Providers []Provider
+
+	// Timeout bounds the whole detection and ProbeTimeout each CLI's probe;
+	// zero uses DefaultCLIDetectTimeout and DefaultCLIProbeTimeout
+	Timeout      time.Duration
+	ProbeTimeout time.Duration
+}
+
+// CLIDetectionResult is the outcome of probing one provider's CLI
+type CLIDetectionResult struct {
+	Provider Provider
+	// Status is nil when detection failed or timed out
+	Status  *CLIStatus
+	Err     error
+	Elapsed time.Duration
+}
+
+// DetectCLIs probes the providers' CLIs concurrently and sends each result
+// as soon as its probe finishes. Every provider gets exactly one result. The
+// channel is closed after the last one, at the latest when the global
+// timeout or ctx ends the remaining probes.
+func DetectCLIs(ctx context.Context, opts CLIDetectOptions) <-chan CLIDetectionResult {
+	providers := opts.Providers
+	if len(providers) == 0 {
+		providers = []Provider{ProviderAWS, ProviderAzure, ProviderGCP}
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCLIDetectTimeout
+	}
+	probeTimeout := opts.ProbeTimeout
+	if probeTimeout <= 0 {
+		probeTimeout = DefaultCLIProbeTimeout
+	}
+
+	// Buffered so probes never block on a reader that stopped early
+	results := make(chan CLIDetectionResult, len(providers))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	var wg sync.WaitGroup
+	for _, provider := range providers {
+		wg.Add(1)
+		go func(provider Provider) {
+			defer wg.Done()
+			probeCtx, probeCancel := context.WithTimeout(ctx, probeTimeout)
+			defer probeCancel()
+
+			start := time.Now()
+			status, err := probeCLI(probeCtx, provider)
+			results <- CLIDetectionResult{Provider: provider, Status: status, Err: err, Elapsed: time.Since(start)}
+		}(provider)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
+	return results
+}
+
+// probeCLI runs one detector. Detectors without DetectContext run in their
+// own goroutine and are abandoned when ctx is done.
+func probeCLI(ctx context.Context, provider Provider) (*CLIStatus, error) {
+	detector, err := createCLIDetector(provider)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := detector.(CLIContextDetector); ok {
+		return cd.DetectContext(ctx)
+	}
+
+	type outcome struct {
+		status *CLIStatus
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		status, err := detector.Detect()
+		done <- outcome{status, err}
+	}()
+	select {
+	case o := <-done:
+		return o.status, o.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,65 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubCLIDetector reports its status after a delay, or ctx's error if that
+// comes first
+type stubCLIDetector struct {
+	*BaseCLIDetector
+	status *CLIStatus
+	delay  time.Duration
+}
+
+func (d *stubCLIDetector) Detect() (*CLIStatus, error) {
+	return d.DetectContext(context.Background())
+}
+
+func (d *stubCLIDetector) DetectContext(ctx context.Context) (*CLIStatus, error) {
+	select {
+	case <-time.After(d.delay):
+		return d.status, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *stubCLIDetector) GetInstallInstructions() string { return "" }
+
+func TestDetectCLIsConcurrently(t *testing.T) {
+	detectors := map[Provider]CLIDetector{
+		ProviderAWS:   &stubCLIDetector{status: &CLIStatus{Installed: true, Version: "2.15.0"}, delay: 100 * time.Millisecond},
+		ProviderAzure: &stubCLIDetector{status: &CLIStatus{Installed: true, Version: "2.55.0"}, delay: 100 * time.Millisecond},
+		ProviderGCP:   &stubCLIDetector{delay: time.Hour},
+	}
+	orig := createCLIDetector
+	createCLIDetector = func(p Provider) (CLIDetector, error) { return detectors[p], nil }
+	t.Cleanup(func() { createCLIDetector = orig })
+
+	start := time.Now()
+	results := map[Provider]CLIDetectionResult{}
+	for r := range DetectCLIs(context.Background(), CLIDetectOptions{ProbeTimeout: 300 * time.Millisecond}) {
+		results[r.Provider] = r
+	}
+	// Sequential detection would take at least 500ms
+	if elapsed := time.Since(start); elapsed > 450*time.Millisecond {
+		t.Errorf("detection took %s, want about the probe timeout", elapsed)
+	}
+	if r := results[ProviderAWS]; r.Status == nil || r.Status.Version != "2.15.0" {
+		t.Errorf("aws = %+v", r)
+	}
+	if r := results[ProviderGCP]; r.Status != nil || !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Errorf("gcp = %+v, want deadline exceeded", r)
+	}
+
+	// A detector that finds nothing is reported as not installed
+	detectors[ProviderGCP] = &stubCLIDetector{}
+	all := DetectAllCLIs(context.Background())
+	if len(all) != 3 || all[ProviderGCP] == nil || all[ProviderGCP].Installed || !all[ProviderAzure].Installed {
+		t.Errorf("DetectAllCLIs = %+v", all)
+	}
+}
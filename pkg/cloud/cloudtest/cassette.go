@@ -0,0 +1,366 @@
+package cloudtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordEnv set to 1 makes cassettes run the real CLIs and record their
+// output instead of replaying it
+const RecordEnv = "APM_CLOUDTEST_RECORD"
+
+// Environment of the stand-in programs
+const (
+	shimAddrEnv     = "APM_CLOUDTEST_SHIM_ADDR"
+	shimProgramsEnv = "APM_CLOUDTEST_SHIM_PROGRAMS"
+	shimStdinEnv    = "APM_CLOUDTEST_SHIM_STDIN"
+)
+
+// Interaction is one recorded CLI invocation
+type Interaction struct {
+	// Command is the program name followed by its arguments
+	Command  []string `json:"command"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exit_code"`
+}
+
+// Cassette replays, or records, the output of CLI programs for one test.
+// It works by copying the test binary into a temporary directory under each
+// program's name and putting that directory first on PATH; the copies hand
+// their arguments to the cassette and print what it answers. Code that runs
+// a CLI by absolute path bypasses the cassette.
+type Cassette struct {
+	t         testing.TB
+	path      string
+	recording bool
+	shimDir   string
+	real      map[string]string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	commands     [][]string
+	redactions   []string
+}
+
+// UseCassette replays path for the named programs until the test ends, or
+// records it when APM_CLOUDTEST_RECORD=1. Replaying fails the test on a
+// command the cassette has no recording of. Identical commands are answered
+// in recorded order, the last answer repeating once they are used up.
+//
+// UseCassette changes PATH for the test, so the test must not be parallel.
+func UseCassette(t testing.TB, path string, programs ...string) *Cassette {
+	t.Helper()
+	c := &Cassette{
+		t:         t,
+		path:      path,
+		recording: os.Getenv(RecordEnv) == "1",
+		real:      make(map[string]string),
+	}
+
+	if c.recording {
+		for _, program := range programs {
+			real, err := exec.LookPath(program)
+			if err != nil {
+				t.Fatalf("cloudtest: recording %s: %v", program, err)
+			}
+			c.real[program] = real
+		}
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("cloudtest: reading cassette: %v (record it with %s=1)", err, RecordEnv)
+		}
+		if err := json.Unmarshal(data, &c.interactions); err != nil {
+			t.Fatalf("cloudtest: parsing cassette %s: %v", path, err)
+		}
+		c.used = make([]bool, len(c.interactions))
+	}
+
+	c.shimDir = t.TempDir()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatalf("cloudtest: locating test binary: %v", err)
+	}
+	for _, program := range programs {
+		if err := linkOrCopy(self, filepath.Join(c.shimDir, program+exeSuffix())); err != nil {
+			t.Fatalf("cloudtest: installing %s stand-in: %v", program, err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cloudtest: listening: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(c.serve)}
+	go server.Serve(listener)
+
+	t.Setenv(shimAddrEnv, listener.Addr().String())
+	t.Setenv(shimProgramsEnv, strings.Join(programs, ","))
+	if c.recording {
+		t.Setenv(shimStdinEnv, "1")
+	}
+	t.Setenv("PATH", c.shimDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	t.Cleanup(func() {
+		server.Close()
+		if c.recording && !t.Failed() {
+			if err := c.save(); err != nil {
+				t.Errorf("cloudtest: saving cassette: %v", err)
+			}
+		}
+	})
+	return c
+}
+
+// Recording reports whether the cassette runs the real CLIs
+func (c *Cassette) Recording() bool {
+	return c.recording
+}
+
+// Redact replaces secret with placeholder in recorded arguments and output.
+// Commands are matched after redaction, so a test that redacts a value from
+// the environment replays whatever that value is.
+func (c *Cassette) Redact(secret, placeholder string) {
+	if secret == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redactions = append(c.redactions, secret, placeholder)
+}
+
+// Commands returns the commands run so far, redacted, in order
+func (c *Cassette) Commands() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]string(nil), c.commands...)
+}
+
+// shimRequest is what a stand-in sends for one invocation
+type shimRequest struct {
+	Command []string `json:"command"`
+	Stdin   []byte   `json:"stdin,omitempty"`
+	Env     []string `json:"env,omitempty"`
+}
+
+func (c *Cassette) serve(w http.ResponseWriter, r *http.Request) {
+	var req shimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Command) == 0 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var answer Interaction
+	if c.recording {
+		answer = c.record(req)
+	} else {
+		answer = c.replay(req.Command)
+	}
+	_ = json.NewEncoder(w).Encode(answer)
+}
+
+func (c *Cassette) replay(command []string) Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	command = c.redactAll(command)
+	c.commands = append(c.commands, command)
+
+	last := -1
+	for i, interaction := range c.interactions {
+		if !equalCommand(interaction.Command, command) {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return interaction
+		}
+		last = i
+	}
+	if last >= 0 {
+		return c.interactions[last]
+	}
+	c.t.Errorf("cloudtest: no recording of %q in %s", strings.Join(command, " "), c.path)
+	return Interaction{
+		Command:  command,
+		Stderr:   fmt.Sprintf("cloudtest: no recording of %q\n", strings.Join(command, " ")),
+		ExitCode: 127,
+	}
+}
+
+func (c *Cassette) record(req shimRequest) Interaction {
+	cmd := exec.Command(c.real[req.Command[0]], req.Command[1:]...)
+	cmd.Env = withoutDir(req.Env, c.shimDir)
+	cmd.Stdin = bytes.NewReader(req.Stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	answer := Interaction{Command: req.Command}
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			c.t.Errorf("cloudtest: running %s: %v", req.Command[0], err)
+			answer.ExitCode = 127
+		} else {
+			answer.ExitCode = exitErr.ExitCode()
+		}
+	}
+	answer.Stdout, answer.Stderr = stdout.String(), stderr.String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, c.redactAll(req.Command))
+	c.interactions = append(c.interactions, answer)
+	return answer
+}
+
+func (c *Cassette) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Interaction, len(c.interactions))
+	for i, interaction := range c.interactions {
+		out[i] = Interaction{
+			Command:  c.redactAll(interaction.Command),
+			Stdout:   c.redactString(interaction.Stdout),
+			Stderr:   c.redactString(interaction.Stderr),
+			ExitCode: interaction.ExitCode,
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
+
+func (c *Cassette) redactString(s string) string {
+	for i := 0; i < len(c.redactions); i += 2 {
+		s = strings.ReplaceAll(s, c.redactions[i], c.redactions[i+1])
+	}
+	return s
+}
+
+func (c *Cassette) redactAll(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = c.redactString(arg)
+	}
+	return out
+}
+
+func equalCommand(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutDir removes dir from the PATH of env, so recorded CLIs that run
+// each other reach the real programs
+func withoutDir(env []string, dir string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.EqualFold(key, "PATH") {
+			var kept []string
+			for _, entry := range filepath.SplitList(value) {
+				if entry != dir {
+					kept = append(kept, entry)
+				}
+			}
+			kv = key + "=" + strings.Join(kept, string(os.PathListSeparator))
+		}
+		out = append(out, kv)
+	}
+	return out
+}
+
+func exeSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// init turns the test binary into a stand-in when a cassette runs it under
+// one of its program names
+func init() {
+	addr := os.Getenv(shimAddrEnv)
+	if addr == "" {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), exeSuffix())
+	for _, program := range strings.Split(os.Getenv(shimProgramsEnv), ",") {
+		if program == name {
+			os.Exit(runShim(addr, append([]string{name}, os.Args[1:]...)))
+		}
+	}
+}
+
+// runShim asks the cassette at addr to answer command and prints the answer
+func runShim(addr string, command []string) int {
+	req := shimRequest{Command: command}
+	if os.Getenv(shimStdinEnv) == "1" {
+		req.Stdin, _ = io.ReadAll(os.Stdin)
+		req.Env = os.Environ()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cloudtest: %v\n", err)
+		return 127
+	}
+	resp, err := http.Post("http://"+addr, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cloudtest: reaching cassette: %v\n", err)
+		return 127
+	}
+	defer resp.Body.Close()
+	var answer Interaction
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		fmt.Fprintf(os.Stderr, "cloudtest: reading answer: %v\n", err)
+		return 127
+	}
+	os.Stdout.WriteString(answer.Stdout)
+	os.Stderr.WriteString(answer.Stderr)
+	return answer.ExitCode
+}
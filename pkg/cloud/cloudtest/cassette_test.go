@@ -0,0 +1,113 @@
+package cloudtest
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCassetteReplay(t *testing.T) {
+	t.Setenv(RecordEnv, "")
+	c := UseCassette(t, "testdata/aws.json", "aws")
+
+	out, err := exec.Command("aws", "sts", "get-caller-identity", "--output", "json").Output()
+	if err != nil {
+		t.Fatalf("aws sts: %v", err)
+	}
+	var identity struct{ Account string }
+	if err := json.Unmarshal(out, &identity); err != nil || identity.Account != "123456789012" {
+		t.Errorf("identity = %q, %v", out, err)
+	}
+
+	cmd := exec.Command("aws", "eks", "describe-cluster", "--name", "missing")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 254 {
+		t.Errorf("describe-cluster err = %v, want exit status 254", err)
+	}
+	if !strings.Contains(stderr.String(), "ResourceNotFoundException") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	// A used-up recording repeats
+	for i := 0; i < 2; i++ {
+		out, err := exec.Command("aws", "--version").Output()
+		if err != nil || !strings.HasPrefix(string(out), "aws-cli/2.15.0") {
+			t.Errorf("aws --version = %q, %v", out, err)
+		}
+	}
+
+	if got := len(c.Commands()); got != 4 {
+		t.Errorf("Commands() has %d entries, want 4", got)
+	}
+}
+
+func TestCassetteLookPath(t *testing.T) {
+	t.Setenv(RecordEnv, "")
+	UseCassette(t, "testdata/aws.json", "aws")
+
+	path, err := exec.LookPath("aws")
+	if err != nil {
+		t.Fatalf("LookPath: %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(path), "aws") {
+		t.Errorf("LookPath = %s", path)
+	}
+}
+
+func TestCassetteRecord(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("records a shell script")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"listed $2 with token-123\"\necho warning >&2\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(bin, "fakecli"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv(RecordEnv, "1")
+
+	path := filepath.Join(t.TempDir(), "recorded", "fakecli.json")
+	t.Run("record", func(t *testing.T) {
+		c := UseCassette(t, path, "fakecli")
+		c.Redact("token-123", "REDACTED")
+		if !c.Recording() {
+			t.Fatal("not recording")
+		}
+
+		out, err := exec.Command("fakecli", "list", "clusters").Output()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Errorf("err = %v, want exit status 3", err)
+		}
+		if string(out) != "listed clusters with token-123\n" {
+			t.Errorf("stdout = %q", out)
+		}
+	})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []Interaction
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	want := Interaction{
+		Command:  []string{"fakecli", "list", "clusters"},
+		Stdout:   "listed clusters with REDACTED\n",
+		Stderr:   "warning\n",
+		ExitCode: 3,
+	}
+	if len(recorded) != 1 || !equalCommand(recorded[0].Command, want.Command) ||
+		recorded[0].Stdout != want.Stdout || recorded[0].Stderr != want.Stderr || recorded[0].ExitCode != want.ExitCode {
+		t.Errorf("recorded %+v, want [%+v]", recorded, want)
+	}
+}
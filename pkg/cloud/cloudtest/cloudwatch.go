@@ -0,0 +1,334 @@
+package cloudtest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/cloud"
+)
+
+// FakeCloudWatch is an in-memory cloud.CloudWatchAPI. New alarms start in
+// INSUFFICIENT_DATA; SetAlarmState moves them as CloudWatch would.
+type FakeCloudWatch struct {
+	recorder
+
+	// Region and Account are used in ARNs
+	Region  string
+	Account string
+
+	// Now timestamps resources and state changes; it defaults to time.Now
+	Now func() time.Time
+
+	mu         sync.Mutex
+	dashboards map[string]*cloud.CloudWatchDashboard
+	alarms     map[string]*cloud.CloudWatchAlarm
+	logGroups  map[string]*cloud.CloudWatchLogGroup
+	logEvents  map[string]map[string][]*cloud.LogEvent
+}
+
+var _ cloud.CloudWatchAPI = (*FakeCloudWatch)(nil)
+
+// NewFakeCloudWatch creates an empty fake in us-east-1
+func NewFakeCloudWatch() *FakeCloudWatch {
+	return &FakeCloudWatch{
+		Region:     "us-east-1",
+		Account:    "123456789012",
+		dashboards: make(map[string]*cloud.CloudWatchDashboard),
+		alarms:     make(map[string]*cloud.CloudWatchAlarm),
+		logGroups:  make(map[string]*cloud.CloudWatchLogGroup),
+		logEvents:  make(map[string]map[string][]*cloud.LogEvent),
+	}
+}
+
+func (cw *FakeCloudWatch) now() time.Time {
+	if cw.Now != nil {
+		return cw.Now()
+	}
+	return time.Now()
+}
+
+func (cw *FakeCloudWatch) arn(service, resource string) string {
+	return "arn:aws:" + service + ":" + cw.Region + ":" + cw.Account + ":" + resource
+}
+
+func invalidInput(operation, message string) error {
+	return cloud.NewErrorBuilder(cloud.ProviderAWS, operation).Build(cloud.ErrCodeInvalidInput, message)
+}
+
+// CreateDashboard implements cloud.CloudWatchAPI; like PutDashboard it
+// replaces an existing dashboard of the same name
+func (cw *FakeCloudWatch) CreateDashboard(ctx context.Context, config *cloud.DashboardConfig) (*cloud.CloudWatchDashboard, error) {
+	if err := cw.record("CreateDashboard", config); err != nil {
+		return nil, err
+	}
+	if config == nil || config.Name == "" {
+		return nil, invalidInput("create_dashboard", "dashboard name is required")
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	dashboard := &cloud.CloudWatchDashboard{
+		DashboardName:  config.Name,
+		DashboardBody:  config.Body,
+		DashboardArn:   "arn:aws:cloudwatch::" + cw.Account + ":dashboard/" + config.Name,
+		LastModified:   cw.now(),
+		Size:           int64(len(config.Body)),
+		Region:         cw.Region,
+		Tags:           copyMap(config.Tags),
+		Widgets:        config.Widgets,
+		Variables:      copyMap(config.Variables),
+		APMIntegration: config.APMIntegration,
+		Description:    config.Description,
+	}
+	cw.dashboards[config.Name] = dashboard
+	out := *dashboard
+	return &out, nil
+}
+
+// GetDashboard implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) GetDashboard(ctx context.Context, name string) (*cloud.CloudWatchDashboard, error) {
+	if err := cw.record("GetDashboard", name); err != nil {
+		return nil, err
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	dashboard, ok := cw.dashboards[name]
+	if !ok {
+		return nil, notFound(cloud.ProviderAWS, "get_dashboard", "dashboard %s not found", name)
+	}
+	out := *dashboard
+	return &out, nil
+}
+
+// ListDashboards implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) ListDashboards(ctx context.Context, prefix string) ([]*cloud.CloudWatchDashboard, error) {
+	if err := cw.record("ListDashboards", prefix); err != nil {
+		return nil, err
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var out []*cloud.CloudWatchDashboard
+	for name, dashboard := range cw.dashboards {
+		if strings.HasPrefix(name, prefix) {
+			d := *dashboard
+			out = append(out, &d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DashboardName < out[j].DashboardName })
+	return out, nil
+}
+
+// DeleteDashboard implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) DeleteDashboard(ctx context.Context, name string) error {
+	if err := cw.record("DeleteDashboard", name); err != nil {
+		return err
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if _, ok := cw.dashboards[name]; !ok {
+		return notFound(cloud.ProviderAWS, "delete_dashboard", "dashboard %s not found", name)
+	}
+	delete(cw.dashboards, name)
+	return nil
+}
+
+// CreateAlarm implements cloud.CloudWatchAPI; like PutMetricAlarm it
+// replaces the configuration of an existing alarm but keeps its state
+func (cw *FakeCloudWatch) CreateAlarm(ctx context.Context, config *cloud.AlarmConfig) (*cloud.CloudWatchAlarm, error) {
+	if err := cw.record("CreateAlarm", config); err != nil {
+		return nil, err
+	}
+	if config == nil || config.AlarmName == "" {
+		return nil, invalidInput("create_alarm", "alarm name is required")
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	now := cw.now()
+	alarm := &cloud.CloudWatchAlarm{
+		AlarmName:                          config.AlarmName,
+		AlarmDescription:                   config.AlarmDescription,
+		AlarmArn:                           cw.arn("cloudwatch", "alarm:"+config.AlarmName),
+		MetricName:                         config.MetricName,
+		Namespace:                          config.Namespace,
+		Statistic:                          config.Statistic,
+		Dimensions:                         config.Dimensions,
+		Period:                             config.Period,
+		EvaluationPeriods:                  config.EvaluationPeriods,
+		Threshold:                          config.Threshold,
+		ComparisonOperator:                 config.ComparisonOperator,
+		TreatMissingData:                   config.TreatMissingData,
+		DatapointsToAlarm:                  config.DatapointsToAlarm,
+		ActionsEnabled:                     config.ActionsEnabled,
+		OKActions:                          config.OKActions,
+		AlarmActions:                       config.AlarmActions,
+		InsufficientDataActions:            config.InsufficientDataActions,
+		Tags:                               copyMap(config.Tags),
+		APMAlarmConfig:                     config.APMAlarmConfig,
+		Region:                             cw.Region,
+		AlarmConfigurationUpdatedTimestamp: now,
+		State:                              cloud.AlarmState{Value: "INSUFFICIENT_DATA", Reason: "Unchecked: Initial alarm creation", Timestamp: now},
+		StateUpdatedTimestamp:              now,
+	}
+	if existing, ok := cw.alarms[config.AlarmName]; ok {
+		alarm.State = existing.State
+		alarm.StateReason = existing.StateReason
+		alarm.StateUpdatedTimestamp = existing.StateUpdatedTimestamp
+	}
+	alarm.StateReason = alarm.State.Reason
+	cw.alarms[config.AlarmName] = alarm
+	out := *alarm
+	return &out, nil
+}
+
+// SetAlarmState moves an alarm to OK, ALARM, or INSUFFICIENT_DATA and records
+// the transition in its evaluation history
+func (cw *FakeCloudWatch) SetAlarmState(name, value, reason string) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	alarm, ok := cw.alarms[name]
+	if !ok {
+		return notFound(cloud.ProviderAWS, "set_alarm_state", "alarm %s not found", name)
+	}
+	switch value {
+	case "OK", "ALARM", "INSUFFICIENT_DATA":
+	default:
+		return invalidInput("set_alarm_state", "invalid alarm state "+value)
+	}
+	now := cw.now()
+	history := append(alarm.State.EvaluationHistory, cloud.AlarmEvaluation{
+		Timestamp: now,
+		State:     value,
+		Reason:    reason,
+		Threshold: alarm.Threshold,
+	})
+	alarm.State = cloud.AlarmState{Value: value, Reason: reason, Timestamp: now, EvaluationHistory: history}
+	alarm.StateReason = reason
+	alarm.StateUpdatedTimestamp = now
+	return nil
+}
+
+// ListAlarms implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) ListAlarms(ctx context.Context, prefix string) ([]*cloud.CloudWatchAlarm, error) {
+	if err := cw.record("ListAlarms", prefix); err != nil {
+		return nil, err
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var out []*cloud.CloudWatchAlarm
+	for name, alarm := range cw.alarms {
+		if strings.HasPrefix(name, prefix) {
+			a := *alarm
+			out = append(out, &a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AlarmName < out[j].AlarmName })
+	return out, nil
+}
+
+// GetAlarmState implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) GetAlarmState(ctx context.Context, name string) (*cloud.AlarmState, error) {
+	if err := cw.record("GetAlarmState", name); err != nil {
+		return nil, err
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	alarm, ok := cw.alarms[name]
+	if !ok {
+		return nil, notFound(cloud.ProviderAWS, "get_alarm_state", "alarm %s not found", name)
+	}
+	state := alarm.State
+	state.EvaluationHistory = append([]cloud.AlarmEvaluation(nil), state.EvaluationHistory...)
+	return &state, nil
+}
+
+// EnableAlarm implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) EnableAlarm(ctx context.Context, name string) error {
+	if err := cw.record("EnableAlarm", name); err != nil {
+		return err
+	}
+	return cw.setActionsEnabled("enable_alarm", name, true)
+}
+
+// DisableAlarm implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) DisableAlarm(ctx context.Context, name string) error {
+	if err := cw.record("DisableAlarm", name); err != nil {
+		return err
+	}
+	return cw.setActionsEnabled("disable_alarm", name, false)
+}
+
+func (cw *FakeCloudWatch) setActionsEnabled(operation, name string, enabled bool) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	alarm, ok := cw.alarms[name]
+	if !ok {
+		return notFound(cloud.ProviderAWS, operation, "alarm %s not found", name)
+	}
+	alarm.ActionsEnabled = enabled
+	return nil
+}
+
+// CreateLogGroup implements cloud.CloudWatchAPI
+func (cw *FakeCloudWatch) CreateLogGroup(ctx context.Context, config *cloud.LogGroupConfig) (*cloud.CloudWatchLogGroup, error) {
+	if err := cw.record("CreateLogGroup", config); err != nil {
+		return nil, err
+	}
+	if config == nil || config.LogGroupName == "" {
+		return nil, invalidInput("create_log_group", "log group name is required")
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if _, ok := cw.logGroups[config.LogGroupName]; ok {
+		return nil, exists(cloud.ProviderAWS, "create_log_group", "log group %s already exists", config.LogGroupName)
+	}
+	group := &cloud.CloudWatchLogGroup{
+		LogGroupName:    config.LogGroupName,
+		LogGroupArn:     cw.arn("logs", "log-group:"+config.LogGroupName),
+		CreationTime:    cw.now(),
+		RetentionInDays: config.RetentionInDays,
+		Tags:            copyMap(config.Tags),
+		KmsKeyId:        config.KmsKeyId,
+		APMLogConfig:    config.APMLogConfig,
+		Region:          cw.Region,
+	}
+	cw.logGroups[config.LogGroupName] = group
+	cw.logEvents[config.LogGroupName] = make(map[string][]*cloud.LogEvent)
+	out := *group
+	return &out, nil
+}
+
+// PutLogEvents implements cloud.CloudWatchAPI. The log group must exist;
+// streams are created on first use.
+func (cw *FakeCloudWatch) PutLogEvents(ctx context.Context, logGroupName, logStreamName string, events []*cloud.LogEvent) error {
+	if err := cw.record("PutLogEvents", logGroupName, logStreamName, events); err != nil {
+		return err
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	group, ok := cw.logGroups[logGroupName]
+	if !ok {
+		return notFound(cloud.ProviderAWS, "put_log_events", "log group %s not found", logGroupName)
+	}
+	ingested := cw.now().UnixMilli()
+	for _, event := range events {
+		e := *event
+		e.IngestionTime = ingested
+		cw.logEvents[logGroupName][logStreamName] = append(cw.logEvents[logGroupName][logStreamName], &e)
+		group.StoredBytes += int64(len(e.Message))
+	}
+	return nil
+}
+
+// LogEvents returns the events written to a log stream, for assertions
+func (cw *FakeCloudWatch) LogEvents(logGroupName, logStreamName string) []*cloud.LogEvent {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var out []*cloud.LogEvent
+	for _, event := range cw.logEvents[logGroupName][logStreamName] {
+		e := *event
+		out = append(out, &e)
+	}
+	return out
+}
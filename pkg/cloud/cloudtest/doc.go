@@ -0,0 +1,23 @@
+// Package cloudtest provides test doubles for pkg/cloud, so code built on it
+// can be tested without AWS, Azure, or GCP access.
+//
+// There are two kinds of double. FakeProvider, FakeS3, and FakeCloudWatch
+// implement cloud.CloudProvider, cloud.S3API, and cloud.CloudWatchAPI in
+// memory, record every call, and fail on demand:
+//
+//	s3 := cloudtest.NewFakeS3()
+//	s3.FailOn("UploadFile", errors.New("throttled"))
+//	err := backup(ctx, s3) // code under test takes a cloud.S3API
+//
+// A Cassette replays recorded CLI output instead, for code that runs aws,
+// az, gcloud, or kubectl itself. It puts stand-ins for the named programs
+// first on PATH that answer from a JSON fixture:
+//
+//	cloudtest.UseCassette(t, "testdata/eks.json", "aws")
+//	provider, _ := cloud.NewAWSProvider(nil)
+//	clusters, err := provider.ListClusters(ctx)
+//
+// Run the test once with APM_CLOUDTEST_RECORD=1 and real credentials to
+// record the fixture. Review it before committing: outputs are stored as
+// returned, apart from the values passed to Cassette.Redact.
+package cloudtest
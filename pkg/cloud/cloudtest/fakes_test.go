@@ -0,0 +1,271 @@
+package cloudtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/cloud"
+)
+
+func isCode(err error, code string) bool {
+	var cloudErr *cloud.CloudError
+	return errors.As(err, &cloudErr) && cloudErr.Code == code
+}
+
+func TestFakeProvider(t *testing.T) {
+	ctx := context.Background()
+	p := NewFakeProvider(cloud.ProviderGCP).
+		AddCluster(&cloud.Cluster{Name: "prod", Type: "GKE"}, []byte("apiVersion: v1")).
+		AddRegistry(&cloud.Registry{Name: "gcr", URL: "gcr.io/project"})
+
+	if p.Name() != cloud.ProviderGCP || p.GetCurrentRegion() != "us-central1" {
+		t.Errorf("defaults = %s %s", p.Name(), p.GetCurrentRegion())
+	}
+	if err := p.ValidateAuth(ctx); err != nil {
+		t.Errorf("ValidateAuth: %v", err)
+	}
+
+	cluster, err := p.GetCluster(ctx, "prod")
+	if err != nil || cluster.Provider != cloud.ProviderGCP || cluster.Region != "us-central1" {
+		t.Fatalf("GetCluster = %+v, %v", cluster, err)
+	}
+	cluster.Name = "mutated"
+	if clusters, _ := p.ListClusters(ctx); clusters[0].Name != "prod" {
+		t.Error("returned cluster aliases the fake's state")
+	}
+	if kubeconfig, err := p.GetKubeconfig(ctx, &cloud.Cluster{Name: "prod"}); err != nil || string(kubeconfig) != "apiVersion: v1" {
+		t.Errorf("GetKubeconfig = %q, %v", kubeconfig, err)
+	}
+	if _, err := p.GetCluster(ctx, "dev"); !isCode(err, cloud.ErrCodeResourceNotFound) {
+		t.Errorf("GetCluster(dev) err = %v, want not found", err)
+	}
+	if err := p.AuthenticateRegistry(ctx, &cloud.Registry{Name: "gcr"}); err != nil {
+		t.Errorf("AuthenticateRegistry: %v", err)
+	}
+
+	if err := p.SetRegion("europe-west1"); err != nil || p.GetCurrentRegion() != "europe-west1" {
+		t.Errorf("SetRegion: %v", err)
+	}
+	if err := p.SetRegion("mars-1"); !isCode(err, cloud.ErrCodeInvalidInput) {
+		t.Errorf("SetRegion(mars-1) err = %v", err)
+	}
+
+	p.SetCredentials(nil).SetCLI(nil)
+	if err := p.ValidateAuth(ctx); !isCode(err, cloud.ErrCodeNotAuthenticated) {
+		t.Errorf("ValidateAuth without credentials err = %v", err)
+	}
+	if err := p.ValidateCLI(); !isCode(err, cloud.ErrCodeCLINotInstalled) {
+		t.Errorf("ValidateCLI without CLI err = %v", err)
+	}
+
+	throttled := errors.New("throttled")
+	p.FailOn("ListClusters", throttled)
+	if _, err := p.ListClusters(ctx); err != throttled {
+		t.Errorf("ListClusters err = %v, want injected error", err)
+	}
+	p.FailOn("ListClusters", nil)
+	if _, err := p.ListClusters(ctx); err != nil {
+		t.Errorf("ListClusters after clearing: %v", err)
+	}
+
+	if n := p.CallCount("ListClusters"); n != 3 {
+		t.Errorf("CallCount(ListClusters) = %d, want 3", n)
+	}
+	if calls := p.Calls(); calls[1].Method != "GetCluster" || calls[1].Args[0] != "prod" {
+		t.Errorf("calls[1] = %+v", calls[1])
+	}
+}
+
+func TestFakeS3(t *testing.T) {
+	ctx := context.Background()
+	s3 := NewFakeS3()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s3.Now = func() time.Time { return now }
+
+	if _, err := s3.CreateBucket(ctx, "configs", "", &cloud.BucketOptions{Tags: map[string]string{"team": "apm"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s3.CreateBucket(ctx, "configs", "", nil); !isCode(err, cloud.ErrCodeResourceExists) {
+		t.Errorf("duplicate CreateBucket err = %v", err)
+	}
+	for _, key := range []string{"prod/app.yaml", "prod/alerts/cpu.yaml", "prod/alerts/mem.yaml", "staging/app.yaml"} {
+		if _, err := s3.UploadFile(ctx, "configs", key, strings.NewReader("k: "+key), &cloud.UploadOptions{ContentType: "text/yaml"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := s3.DownloadFile(ctx, "configs", "prod/app.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != "k: prod/app.yaml" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	list, err := s3.ListFiles(ctx, "configs", "prod/", &cloud.ListOptions{Delimiter: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Objects) != 1 || list.Objects[0].Key != "prod/app.yaml" || len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0] != "prod/alerts/" {
+		t.Errorf("delimited list = %+v", list)
+	}
+
+	var keys []string
+	token := ""
+	for pages := 0; ; pages++ {
+		page, err := s3.ListFiles(ctx, "configs", "", &cloud.ListOptions{MaxKeys: 3, ContinuationToken: token})
+		if err != nil || pages > 3 {
+			t.Fatalf("paging: %v", err)
+		}
+		for _, obj := range page.Objects {
+			keys = append(keys, obj.Key)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	if strings.Join(keys, ",") != "prod/alerts/cpu.yaml,prod/alerts/mem.yaml,prod/app.yaml,staging/app.yaml" {
+		t.Errorf("paged keys = %v", keys)
+	}
+
+	if _, err := s3.CopyFile(ctx, "configs", "prod/app.yaml", "configs", "backup/app.yaml", nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s3.Object("configs", "backup/app.yaml"); !ok || string(data) != "k: prod/app.yaml" {
+		t.Errorf("copied object = %q, %v", data, ok)
+	}
+
+	details, err := s3.GetBucket(ctx, "configs", "")
+	if err != nil || details.ObjectCount != 5 || details.Bucket.Tags["team"] != "apm" || details.Bucket.Region != "us-east-1" {
+		t.Errorf("GetBucket = %+v, %v", details, err)
+	}
+
+	if err := s3.DeleteFile(ctx, "configs", "no/such/key", nil); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if err := s3.DeleteBucket(ctx, "configs", "", false); !isCode(err, cloud.ErrCodeResourceInUse) {
+		t.Errorf("deleting a non-empty bucket err = %v", err)
+	}
+	if err := s3.DeleteBucket(ctx, "configs", "", true); err != nil {
+		t.Errorf("forced delete: %v", err)
+	}
+	if _, err := s3.DownloadFile(ctx, "configs", "prod/app.yaml", nil); !isCode(err, cloud.ErrCodeResourceNotFound) {
+		t.Errorf("download from deleted bucket err = %v", err)
+	}
+}
+
+func TestFakeCloudWatch(t *testing.T) {
+	ctx := context.Background()
+	cw := NewFakeCloudWatch()
+
+	if _, err := cw.CreateDashboard(ctx, &cloud.DashboardConfig{Name: "apm-prod", Body: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cw.CreateDashboard(ctx, &cloud.DashboardConfig{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if dashboards, _ := cw.ListDashboards(ctx, "apm-"); len(dashboards) != 1 || dashboards[0].DashboardName != "apm-prod" {
+		t.Errorf("ListDashboards = %+v", dashboards)
+	}
+	if err := cw.DeleteDashboard(ctx, "apm-prod"); err != nil {
+		t.Error(err)
+	}
+	if _, err := cw.GetDashboard(ctx, "apm-prod"); !isCode(err, cloud.ErrCodeResourceNotFound) {
+		t.Errorf("GetDashboard after delete err = %v", err)
+	}
+
+	alarm, err := cw.CreateAlarm(ctx, &cloud.AlarmConfig{AlarmName: "high-latency", Threshold: 0.5, ActionsEnabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alarm.State.Value != "INSUFFICIENT_DATA" || !strings.HasSuffix(alarm.AlarmArn, ":alarm:high-latency") {
+		t.Errorf("new alarm = %+v", alarm)
+	}
+	if err := cw.SetAlarmState("high-latency", "ALARM", "p99 above 500ms"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cw.CreateAlarm(ctx, &cloud.AlarmConfig{AlarmName: "high-latency", Threshold: 0.8}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := cw.GetAlarmState(ctx, "high-latency")
+	if err != nil || state.Value != "ALARM" || len(state.EvaluationHistory) != 1 {
+		t.Errorf("state after update = %+v, %v", state, err)
+	}
+	if err := cw.DisableAlarm(ctx, "high-latency"); err != nil {
+		t.Fatal(err)
+	}
+	if alarms, _ := cw.ListAlarms(ctx, ""); len(alarms) != 1 || alarms[0].ActionsEnabled || alarms[0].Threshold != 0.8 {
+		t.Errorf("ListAlarms = %+v", alarms)
+	}
+
+	if err := cw.PutLogEvents(ctx, "/apm/app", "pod-1", []*cloud.LogEvent{{Message: "x"}}); !isCode(err, cloud.ErrCodeResourceNotFound) {
+		t.Errorf("PutLogEvents to a missing group err = %v", err)
+	}
+	if _, err := cw.CreateLogGroup(ctx, &cloud.LogGroupConfig{LogGroupName: "/apm/app", RetentionInDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cw.CreateLogGroup(ctx, &cloud.LogGroupConfig{LogGroupName: "/apm/app"}); !isCode(err, cloud.ErrCodeResourceExists) {
+		t.Errorf("duplicate CreateLogGroup err = %v", err)
+	}
+	events := []*cloud.LogEvent{{Timestamp: 1, Message: "started"}, {Timestamp: 2, Message: "ready"}}
+	if err := cw.PutLogEvents(ctx, "/apm/app", "pod-1", events); err != nil {
+		t.Fatal(err)
+	}
+	if got := cw.LogEvents("/apm/app", "pod-1"); len(got) != 2 || got[1].Message != "ready" || got[1].IngestionTime == 0 {
+		t.Errorf("LogEvents = %+v", got)
+	}
+}
+
+func TestBulkUpdateAlarms(t *testing.T) {
+	ctx := context.Background()
+	cw := NewFakeCloudWatch()
+	for _, config := range []*cloud.AlarmConfig{
+		{AlarmName: "checkout-errors", Threshold: 5, ActionsEnabled: true, Tags: map[string]string{"Service": "checkout", "Environment": "prod"}},
+		{AlarmName: "checkout-latency", Threshold: 0.5, ActionsEnabled: true, Dimensions: []*cloud.AlarmDimension{{Name: "service", Value: "checkout"}}},
+		{AlarmName: "search-errors", Threshold: 5, ActionsEnabled: true, APMAlarmConfig: cloud.APMAlarmConfig{APMService: "search"}},
+	} {
+		if _, err := cw.CreateAlarm(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	disable := false
+	result, err := cloud.BulkUpdateAlarms(ctx, cw, cloud.BulkAlarmUpdate{
+		Selector: cloud.AlarmSelector{Service: "checkout"},
+		Enable:   &disable,
+		DryRun:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Matched != 2 || len(result.Changes) != 2 || result.Changes[0].NewActionsEnabled {
+		t.Errorf("dry run = %+v", result)
+	}
+	if alarms, _ := cw.ListAlarms(ctx, "checkout-"); !alarms[0].ActionsEnabled || !alarms[1].ActionsEnabled {
+		t.Error("expected a dry run to change nothing")
+	}
+
+	// Scaling the threshold of a disabled alarm keeps its actions disabled
+	result, err = cloud.BulkUpdateAlarms(ctx, cw, cloud.BulkAlarmUpdate{
+		Selector:        cloud.AlarmSelector{Service: "checkout", Environment: "prod"},
+		Enable:          &disable,
+		ThresholdFactor: 2,
+	})
+	if err != nil || result.Matched != 1 || result.Failed != 0 {
+		t.Fatalf("update = %+v, %v", result, err)
+	}
+	alarms, _ := cw.ListAlarms(ctx, "checkout-errors")
+	if alarms[0].Threshold != 10 || alarms[0].ActionsEnabled || alarms[0].Tags["Service"] != "checkout" {
+		t.Errorf("alarm after update = %+v", alarms[0])
+	}
+
+	if _, err := cloud.BulkUpdateAlarms(ctx, cw, cloud.BulkAlarmUpdate{}); err == nil {
+		t.Error("expected an update without changes to be rejected")
+	}
+}
@@ -0,0 +1,317 @@
+package cloudtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/chaksack/apm/pkg/cloud"
+)
+
+// FakeProvider is an in-memory cloud.CloudProvider. It starts authenticated,
+// with the provider's CLI installed and a few regions, and no registries or
+// clusters.
+type FakeProvider struct {
+	recorder
+
+	mu          sync.Mutex
+	name        cloud.Provider
+	region      string
+	regions     []string
+	credentials *cloud.Credentials
+	cli         *cloud.CLIStatus
+	registries  []*cloud.Registry
+	clusters    []*cloud.Cluster
+	kubeconfigs map[string][]byte
+}
+
+var _ cloud.CloudProvider = (*FakeProvider)(nil)
+
+// fakeDefaults holds the region, regions, CLI, and CLI version of each provider
+var fakeDefaults = map[cloud.Provider]struct {
+	region, cli, version string
+	regions              []string
+}{
+	cloud.ProviderAWS:   {"us-east-1", "aws", "2.15.0", []string{"us-east-1", "us-west-2", "eu-west-1"}},
+	cloud.ProviderAzure: {"eastus", "az", "2.55.0", []string{"eastus", "westus2", "westeurope"}},
+	cloud.ProviderGCP:   {"us-central1", "gcloud", "460.0.0", []string{"us-central1", "us-east1", "europe-west1"}},
+}
+
+// NewFakeProvider creates a fake of the given provider
+func NewFakeProvider(name cloud.Provider) *FakeProvider {
+	defaults, ok := fakeDefaults[name]
+	if !ok {
+		defaults = fakeDefaults[cloud.ProviderAWS]
+	}
+	return &FakeProvider{
+		name:    name,
+		region:  defaults.region,
+		regions: append([]string(nil), defaults.regions...),
+		credentials: &cloud.Credentials{
+			Provider:   name,
+			AuthMethod: cloud.AuthMethodCLI,
+			Profile:    "default",
+			Region:     defaults.region,
+			Account:    "123456789012",
+		},
+		cli: &cloud.CLIStatus{
+			Installed:   true,
+			Version:     defaults.version,
+			Path:        "/usr/local/bin/" + defaults.cli,
+			IsSupported: true,
+		},
+		kubeconfigs: make(map[string][]byte),
+	}
+}
+
+// AddRegistry adds a registry; its provider defaults to the fake's
+func (f *FakeProvider) AddRegistry(registry *cloud.Registry) *FakeProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := *registry
+	if r.Provider == "" {
+		r.Provider = f.name
+	}
+	f.registries = append(f.registries, &r)
+	return f
+}
+
+// AddCluster adds a cluster and the kubeconfig GetKubeconfig returns for it
+func (f *FakeProvider) AddCluster(cluster *cloud.Cluster, kubeconfig []byte) *FakeProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := *cluster
+	if c.Provider == "" {
+		c.Provider = f.name
+	}
+	if c.Region == "" {
+		c.Region = f.region
+	}
+	f.clusters = append(f.clusters, &c)
+	f.kubeconfigs[c.Name] = append([]byte(nil), kubeconfig...)
+	return f
+}
+
+// SetCredentials replaces the credentials; nil makes the fake unauthenticated
+func (f *FakeProvider) SetCredentials(credentials *cloud.Credentials) *FakeProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.credentials = credentials
+	return f
+}
+
+// SetCLI replaces the CLI status; nil makes the CLI missing
+func (f *FakeProvider) SetCLI(status *cloud.CLIStatus) *FakeProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cli = status
+	return f
+}
+
+// SetRegions replaces the regions ListRegions returns and SetRegion accepts
+func (f *FakeProvider) SetRegions(regions ...string) *FakeProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.regions = append([]string(nil), regions...)
+	return f
+}
+
+// Name implements cloud.CloudProvider
+func (f *FakeProvider) Name() cloud.Provider {
+	return f.name
+}
+
+// ValidateAuth implements cloud.CloudProvider
+func (f *FakeProvider) ValidateAuth(ctx context.Context) error {
+	if err := f.record("ValidateAuth"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.credentials == nil {
+		return cloud.NewErrorBuilder(f.name, "validate_auth").Build(cloud.ErrCodeNotAuthenticated, "not authenticated")
+	}
+	return nil
+}
+
+// GetCredentials implements cloud.CloudProvider
+func (f *FakeProvider) GetCredentials() (*cloud.Credentials, error) {
+	if err := f.record("GetCredentials"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.credentials == nil {
+		return nil, cloud.NewErrorBuilder(f.name, "get_credentials").Build(cloud.ErrCodeNotAuthenticated, "no credentials")
+	}
+	creds := *f.credentials
+	return &creds, nil
+}
+
+// DetectCLI implements cloud.CloudProvider
+func (f *FakeProvider) DetectCLI() (*cloud.CLIStatus, error) {
+	if err := f.record("DetectCLI"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cli == nil {
+		return &cloud.CLIStatus{}, nil
+	}
+	status := *f.cli
+	return &status, nil
+}
+
+// ValidateCLI implements cloud.CloudProvider
+func (f *FakeProvider) ValidateCLI() error {
+	if err := f.record("ValidateCLI"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	errs := cloud.NewErrorBuilder(f.name, "validate_cli")
+	switch {
+	case f.cli == nil || !f.cli.Installed:
+		return errs.Build(cloud.ErrCodeCLINotInstalled, "CLI not installed")
+	case !f.cli.IsSupported:
+		return errs.Build(cloud.ErrCodeCLIVersionMismatch, fmt.Sprintf("CLI version %s is not supported", f.cli.Version))
+	}
+	return nil
+}
+
+// GetCLIVersion implements cloud.CloudProvider
+func (f *FakeProvider) GetCLIVersion() (string, error) {
+	if err := f.record("GetCLIVersion"); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cli == nil || !f.cli.Installed {
+		return "", cloud.NewErrorBuilder(f.name, "get_cli_version").Build(cloud.ErrCodeCLINotInstalled, "CLI not installed")
+	}
+	return f.cli.Version, nil
+}
+
+// ListRegistries implements cloud.CloudProvider
+func (f *FakeProvider) ListRegistries(ctx context.Context) ([]*cloud.Registry, error) {
+	if err := f.record("ListRegistries"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]*cloud.Registry, len(f.registries))
+	for i, r := range f.registries {
+		registry := *r
+		out[i] = &registry
+	}
+	return out, nil
+}
+
+// GetRegistry implements cloud.CloudProvider
+func (f *FakeProvider) GetRegistry(ctx context.Context, name string) (*cloud.Registry, error) {
+	if err := f.record("GetRegistry", name); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.registries {
+		if r.Name == name {
+			registry := *r
+			return &registry, nil
+		}
+	}
+	return nil, notFound(f.name, "get_registry", "registry %s not found", name)
+}
+
+// AuthenticateRegistry implements cloud.CloudProvider
+func (f *FakeProvider) AuthenticateRegistry(ctx context.Context, registry *cloud.Registry) error {
+	if err := f.record("AuthenticateRegistry", registry); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.registries {
+		if r.Name == registry.Name {
+			return nil
+		}
+	}
+	return notFound(f.name, "authenticate_registry", "registry %s not found", registry.Name)
+}
+
+// ListClusters implements cloud.CloudProvider
+func (f *FakeProvider) ListClusters(ctx context.Context) ([]*cloud.Cluster, error) {
+	if err := f.record("ListClusters"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]*cloud.Cluster, len(f.clusters))
+	for i, c := range f.clusters {
+		cluster := *c
+		out[i] = &cluster
+	}
+	return out, nil
+}
+
+// GetCluster implements cloud.CloudProvider
+func (f *FakeProvider) GetCluster(ctx context.Context, name string) (*cloud.Cluster, error) {
+	if err := f.record("GetCluster", name); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.clusters {
+		if c.Name == name {
+			cluster := *c
+			return &cluster, nil
+		}
+	}
+	return nil, notFound(f.name, "get_cluster", "cluster %s not found", name)
+}
+
+// GetKubeconfig implements cloud.CloudProvider
+func (f *FakeProvider) GetKubeconfig(ctx context.Context, cluster *cloud.Cluster) ([]byte, error) {
+	if err := f.record("GetKubeconfig", cluster); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kubeconfig, ok := f.kubeconfigs[cluster.Name]
+	if !ok {
+		return nil, notFound(f.name, "get_kubeconfig", "cluster %s not found", cluster.Name)
+	}
+	return append([]byte(nil), kubeconfig...), nil
+}
+
+// ListRegions implements cloud.CloudProvider
+func (f *FakeProvider) ListRegions(ctx context.Context) ([]string, error) {
+	if err := f.record("ListRegions"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.regions...), nil
+}
+
+// GetCurrentRegion implements cloud.CloudProvider
+func (f *FakeProvider) GetCurrentRegion() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.region
+}
+
+// SetRegion implements cloud.CloudProvider; it accepts only the fake's regions
+func (f *FakeProvider) SetRegion(region string) error {
+	if err := f.record("SetRegion", region); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.regions {
+		if r == region {
+			f.region = region
+			return nil
+		}
+	}
+	return cloud.NewErrorBuilder(f.name, "set_region").Build(cloud.ErrCodeInvalidInput, fmt.Sprintf("unknown region %s", region))
+}
@@ -0,0 +1,74 @@
+package cloudtest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/chaksack/apm/pkg/cloud"
+)
+
+// Call is one method call on a fake
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// recorder records calls and holds the errors injected per method. The fakes
+// embed it, so FailOn, Calls, and CallCount are part of their API.
+type recorder struct {
+	mu     sync.Mutex
+	calls  []Call
+	errors map[string]error
+}
+
+// FailOn makes every later call of method return err; a nil err clears it
+func (r *recorder) FailOn(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errors == nil {
+		r.errors = make(map[string]error)
+	}
+	if err == nil {
+		delete(r.errors, method)
+		return
+	}
+	r.errors[method] = err
+}
+
+// Calls returns the calls made so far, in order
+func (r *recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallCount returns how often method was called
+func (r *recorder) CallCount(method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, call := range r.calls {
+		if call.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// record adds a call and returns the error injected for its method
+func (r *recorder) record(method string, args ...interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+	return r.errors[method]
+}
+
+// notFound and exists build the errors the real providers classify resources
+// by, so callers checking the code behave the same against a fake
+func notFound(provider cloud.Provider, operation, format string, args ...interface{}) error {
+	return cloud.NewErrorBuilder(provider, operation).Build(cloud.ErrCodeResourceNotFound, fmt.Sprintf(format, args...))
+}
+
+func exists(provider cloud.Provider, operation, format string, args ...interface{}) error {
+	return cloud.NewErrorBuilder(provider, operation).Build(cloud.ErrCodeResourceExists, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,371 @@
+package cloudtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/cloud"
+)
+
+// FakeS3 is an in-memory cloud.S3API with S3's listing, delete, and
+// bucket-emptiness semantics
+type FakeS3 struct {
+	recorder
+
+	// Region is used for buckets created without one
+	Region string
+
+	// Now timestamps buckets and objects; it defaults to time.Now
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+}
+
+type fakeBucket struct {
+	bucket  cloud.Bucket
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	info cloud.FileInfo
+	data []byte
+}
+
+var _ cloud.S3API = (*FakeS3)(nil)
+
+// NewFakeS3 creates an empty fake in us-east-1
+func NewFakeS3() *FakeS3 {
+	return &FakeS3{
+		Region:  "us-east-1",
+		buckets: make(map[string]*fakeBucket),
+	}
+}
+
+func (s *FakeS3) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Object returns the content of an object, for assertions
+func (s *FakeS3) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, false
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// CreateBucket implements cloud.S3API
+func (s *FakeS3) CreateBucket(ctx context.Context, name, region string, options *cloud.BucketOptions) (*cloud.Bucket, error) {
+	if err := s.record("CreateBucket", name, region, options); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; ok {
+		return nil, exists(cloud.ProviderAWS, "create_bucket", "bucket %s already exists", name)
+	}
+	if region == "" && options != nil {
+		region = options.Region
+	}
+	if region == "" {
+		region = s.Region
+	}
+	b := &fakeBucket{
+		bucket: cloud.Bucket{
+			Name:         name,
+			Region:       region,
+			Location:     region,
+			CreationDate: s.now(),
+			StorageClass: "STANDARD",
+			Tags:         map[string]string{},
+		},
+		objects: make(map[string]*fakeObject),
+	}
+	if options != nil {
+		for k, v := range options.Tags {
+			b.bucket.Tags[k] = v
+		}
+		if options.Versioning != nil {
+			b.bucket.Versioning = *options.Versioning
+		}
+		if options.Encryption != nil {
+			b.bucket.Encryption = *options.Encryption
+		}
+	}
+	s.buckets[name] = b
+	bucket := b.bucket
+	return &bucket, nil
+}
+
+// ListBuckets implements cloud.S3API; an empty region lists every bucket
+func (s *FakeS3) ListBuckets(ctx context.Context, region string) ([]*cloud.Bucket, error) {
+	if err := s.record("ListBuckets", region); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*cloud.Bucket
+	for _, b := range s.buckets {
+		if region == "" || b.bucket.Region == region {
+			bucket := b.bucket
+			out = append(out, &bucket)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// GetBucket implements cloud.S3API
+func (s *FakeS3) GetBucket(ctx context.Context, name, region string) (*cloud.BucketDetails, error) {
+	if err := s.record("GetBucket", name, region); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, notFound(cloud.ProviderAWS, "get_bucket", "bucket %s not found", name)
+	}
+	bucket := b.bucket
+	details := &cloud.BucketDetails{Bucket: &bucket, LastModified: bucket.CreationDate}
+	for _, obj := range b.objects {
+		details.Size += obj.info.Size
+		details.ObjectCount++
+		if obj.info.LastModified.After(details.LastModified) {
+			details.LastModified = obj.info.LastModified
+		}
+	}
+	return details, nil
+}
+
+// DeleteBucket implements cloud.S3API; a bucket with objects is only
+// deleted with force
+func (s *FakeS3) DeleteBucket(ctx context.Context, name, region string, force bool) error {
+	if err := s.record("DeleteBucket", name, region, force); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return notFound(cloud.ProviderAWS, "delete_bucket", "bucket %s not found", name)
+	}
+	if len(b.objects) > 0 && !force {
+		return cloud.NewErrorBuilder(cloud.ProviderAWS, "delete_bucket").Build(cloud.ErrCodeResourceInUse, "bucket "+name+" is not empty")
+	}
+	delete(s.buckets, name)
+	return nil
+}
+
+// UploadFile implements cloud.S3API
+func (s *FakeS3) UploadFile(ctx context.Context, bucket, key string, content io.Reader, options *cloud.UploadOptions) (*cloud.FileInfo, error) {
+	if err := s.record("UploadFile", bucket, key, options); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, notFound(cloud.ProviderAWS, "upload_file", "bucket %s not found", bucket)
+	}
+	sum := md5.Sum(data)
+	info := cloud.FileInfo{
+		Key:          key,
+		LastModified: s.now(),
+		ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		Size:         int64(len(data)),
+		StorageClass: "STANDARD",
+		IsLatest:     true,
+	}
+	if options != nil {
+		info.ContentType = options.ContentType
+		info.ContentEncoding = options.ContentEncoding
+		info.CacheControl = options.CacheControl
+		info.Metadata = copyMap(options.Metadata)
+		info.Tags = copyMap(options.Tags)
+		info.ServerSideEncryption = options.ServerSideEncryption
+		if options.StorageClass != "" {
+			info.StorageClass = options.StorageClass
+		}
+	}
+	b.objects[key] = &fakeObject{info: info, data: data}
+	return &info, nil
+}
+
+// DownloadFile implements cloud.S3API
+func (s *FakeS3) DownloadFile(ctx context.Context, bucket, key string, options *cloud.DownloadOptions) (io.ReadCloser, error) {
+	if err := s.record("DownloadFile", bucket, key, options); err != nil {
+		return nil, err
+	}
+	obj, err := s.object("download_file", bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// ListFiles implements cloud.S3API. Prefix falls back to options.Prefix;
+// Delimiter, StartAfter, MaxKeys, and ContinuationToken work as in S3.
+func (s *FakeS3) ListFiles(ctx context.Context, bucket, prefix string, options *cloud.ListOptions) (*cloud.ListResult, error) {
+	if err := s.record("ListFiles", bucket, prefix, options); err != nil {
+		return nil, err
+	}
+	var opts cloud.ListOptions
+	if options != nil {
+		opts = *options
+	}
+	if prefix == "" {
+		prefix = opts.Prefix
+	}
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = 1000
+	}
+	after := opts.StartAfter
+	if opts.ContinuationToken != "" {
+		after = opts.ContinuationToken
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, notFound(cloud.ProviderAWS, "list_files", "bucket %s not found", bucket)
+	}
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := &cloud.ListResult{
+		Name:      bucket,
+		Prefix:    prefix,
+		Delimiter: opts.Delimiter,
+		MaxKeys:   opts.MaxKeys,
+	}
+	seenPrefixes := map[string]bool{}
+	for _, key := range keys {
+		if opts.Delimiter != "" {
+			if i := strings.Index(key[len(prefix):], opts.Delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(opts.Delimiter)]
+				if seenPrefixes[common] {
+					continue
+				}
+				if result.KeyCount == opts.MaxKeys {
+					result.IsTruncated = true
+					break
+				}
+				seenPrefixes[common] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, common)
+				result.KeyCount++
+				// The token sorts after every key under the prefix, so the
+				// next page does not repeat it
+				result.NextContinuationToken = common + "\U0010FFFF"
+				continue
+			}
+		}
+		if result.KeyCount == opts.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		info := b.objects[key].info
+		result.Objects = append(result.Objects, &info)
+		result.KeyCount++
+		result.NextContinuationToken = key
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	return result, nil
+}
+
+// DeleteFile implements cloud.S3API; as in S3, deleting a missing key
+// succeeds
+func (s *FakeS3) DeleteFile(ctx context.Context, bucket, key string, options *cloud.DeleteOptions) error {
+	if err := s.record("DeleteFile", bucket, key, options); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return notFound(cloud.ProviderAWS, "delete_file", "bucket %s not found", bucket)
+	}
+	delete(b.objects, key)
+	return nil
+}
+
+// CopyFile implements cloud.S3API
+func (s *FakeS3) CopyFile(ctx context.Context, sourceBucket, sourceKey, destBucket, destKey string, options *cloud.CopyOptions) (*cloud.FileInfo, error) {
+	if err := s.record("CopyFile", sourceBucket, sourceKey, destBucket, destKey, options); err != nil {
+		return nil, err
+	}
+	src, err := s.object("copy_file", sourceBucket, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[destBucket]
+	if !ok {
+		return nil, notFound(cloud.ProviderAWS, "copy_file", "bucket %s not found", destBucket)
+	}
+	info := src.info
+	info.Key = destKey
+	info.LastModified = s.now()
+	if options != nil && options.MetadataDirective == "REPLACE" {
+		info.Metadata = copyMap(options.Metadata)
+		info.ContentType = options.ContentType
+	}
+	b.objects[destKey] = &fakeObject{info: info, data: src.data}
+	return &info, nil
+}
+
+// object returns a copy of an object
+func (s *FakeS3) object(operation, bucket, key string) (fakeObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return fakeObject{}, notFound(cloud.ProviderAWS, operation, "bucket %s not found", bucket)
+	}
+	obj, ok := b.objects[key]
+	if !ok {
+		return fakeObject{}, notFound(cloud.ProviderAWS, operation, "object %s/%s not found", bucket, key)
+	}
+	return fakeObject{info: obj.info, data: append([]byte(nil), obj.data...)}, nil
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package cloud
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Template variables of the built-in dashboards. Picking a value in the
+// dashboard switches every widget, so one dashboard serves every cluster.
+const (
+	DashboardVariableEnvironment = "environment"
+	DashboardVariableService     = "service"
+	DashboardVariableNamespace   = "namespace"
+	DashboardVariableRegion      = "region"
+)
+
+// dashboardDimensions are the metric dimensions the environment, service,
+// and namespace variables switch
+var dashboardDimensions = []struct {
+	variable  string
+	dimension string
+	label     string
+}{
+	{DashboardVariableEnvironment, "Environment", "Environment"},
+	{DashboardVariableService, "ServiceName", "Service"},
+	{DashboardVariableNamespace, "Namespace", "Namespace"},
+}
+
+// ThresholdsFor returns the thresholds of an environment: Thresholds with
+// the overrides of the environment applied
+func (c *DashboardConfig) ThresholdsFor(environment string) map[string]float64 {
+	thresholds := make(map[string]float64, len(c.Thresholds))
+	for metric, v := range c.Thresholds {
+		thresholds[metric] = v
+	}
+	for metric, v := range c.EnvironmentThresholds[environment] {
+		thresholds[metric] = v
+	}
+	return thresholds
+}
+
+// dashboardTemplate resolves the variables of a built-in template to their
+// default values
+type dashboardTemplate struct {
+	config *DashboardConfig
+	values map[string]string
+}
+
+func (dm *DashboardManager) newDashboardTemplate(config *DashboardConfig) *dashboardTemplate {
+	t := &dashboardTemplate{config: config, values: map[string]string{
+		DashboardVariableEnvironment: "production",
+		DashboardVariableService:     "default",
+		DashboardVariableNamespace:   "default",
+		DashboardVariableRegion:      dm.cloudWatch.provider.config.region(config.Region),
+	}}
+	if len(config.APMIntegration.APMServices) > 0 {
+		t.values[DashboardVariableService] = config.APMIntegration.APMServices[0]
+	}
+	if len(config.APMIntegration.Namespaces) > 0 {
+		t.values[DashboardVariableNamespace] = config.APMIntegration.Namespaces[0]
+	}
+	for name, value := range config.Variables {
+		if value != "" {
+			t.values[name] = value
+		}
+	}
+	return t
+}
+
+// region returns the default region
+func (t *dashboardTemplate) region() string {
+	return t.values[DashboardVariableRegion]
+}
+
+// metric returns a metric of an APM namespace with the dimensions the
+// environment, service, and namespace variables switch
+func (t *dashboardTemplate) metric(namespace, name string) []interface{} {
+	metric := []interface{}{namespace, name}
+	for _, d := range dashboardDimensions {
+		metric = append(metric, d.dimension, t.values[d.variable])
+	}
+	return metric
+}
+
+// variables returns the dashboard variables. Environment, service, and
+// namespace are dimension (property) variables whose choices come from the
+// metrics, or from Choices when given; region replaces the default region
+// everywhere in the dashboard.
+func (t *dashboardTemplate) variables() []map[string]interface{} {
+	variables := make([]map[string]interface{}, 0, len(dashboardDimensions)+1)
+	for _, d := range dashboardDimensions {
+		v := map[string]interface{}{
+			"type":         "property",
+			"property":     d.dimension,
+			"inputType":    "select",
+			"id":           d.variable,
+			"label":        d.label,
+			"defaultValue": t.values[d.variable],
+			"visible":      true,
+		}
+		if choices := t.config.Choices[d.variable]; len(choices) > 0 {
+			v["values"] = variableValues(choices)
+		} else {
+			v["search"] = fmt.Sprintf("{Custom/APM,%s,%s,%s} MetricName=\"RequestRate\"",
+				dashboardDimensions[0].dimension, dashboardDimensions[1].dimension, dashboardDimensions[2].dimension)
+			v["populateFrom"] = d.dimension
+		}
+		variables = append(variables, v)
+	}
+
+	region := map[string]interface{}{
+		"type":         "pattern",
+		"pattern":      t.region(),
+		"inputType":    "input",
+		"id":           DashboardVariableRegion,
+		"label":        "Region",
+		"defaultValue": t.region(),
+		"visible":      true,
+	}
+	if choices := t.config.Choices[DashboardVariableRegion]; len(choices) > 0 {
+		region["inputType"] = "select"
+		region["values"] = variableValues(choices)
+	}
+	return append(variables, region)
+}
+
+func variableValues(choices []string) []map[string]string {
+	values := make([]map[string]string, 0, len(choices))
+	for _, c := range choices {
+		values = append(values, map[string]string{"value": c, "label": c})
+	}
+	return values
+}
+
+// annotate draws the thresholds of the metrics each metric widget shows: the
+// threshold of the default environment and, labelled with their environment,
+// the overrides of the others
+func (t *dashboardTemplate) annotate(widgets []map[string]interface{}) {
+	environment := t.values[DashboardVariableEnvironment]
+	thresholds := t.config.ThresholdsFor(environment)
+	environments := make([]string, 0, len(t.config.EnvironmentThresholds))
+	for env := range t.config.EnvironmentThresholds {
+		if env != environment {
+			environments = append(environments, env)
+		}
+	}
+	sort.Strings(environments)
+
+	for _, widget := range widgets {
+		properties, ok := widget["properties"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		metrics, _ := properties["metrics"].([][]interface{})
+		var lines []map[string]interface{}
+		for _, m := range metrics {
+			if len(m) < 2 {
+				continue
+			}
+			name, _ := m[1].(string)
+			if v, ok := thresholds[name]; ok {
+				lines = append(lines, map[string]interface{}{"label": name + " threshold", "value": v})
+			}
+			for _, env := range environments {
+				if v, ok := t.config.EnvironmentThresholds[env][name]; ok {
+					lines = append(lines, map[string]interface{}{"label": fmt.Sprintf("%s threshold (%s)", name, env), "value": v})
+				}
+			}
+		}
+		if len(lines) > 0 {
+			properties["annotations"] = map[string]interface{}{"horizontal": lines}
+		}
+	}
+}
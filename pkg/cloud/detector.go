@@ -35,6 +35,11 @@ func NewBaseCLIDetector(provider Provider, commands []string, minVersion, versio
 
 // Detect attempts to detect the CLI installation
 func (d *BaseCLIDetector) Detect() (*CLIStatus, error) {
+	return d.DetectContext(context.Background())
+}
+
+// DetectContext is Detect, killing the version command when ctx is done
+func (d *BaseCLIDetector) DetectContext(ctx context.Context) (*CLIStatus, error) {
 	for _, cmd := range d.commands {
 		path, err := exec.LookPath(cmd)
 		if err != nil {
@@ -42,8 +47,11 @@ func (d *BaseCLIDetector) Detect() (*CLIStatus, error) {
 		}
 
 		// Get version
-		version, err := d.getVersion(path)
+		version, err := d.getVersion(ctx, path)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 		}
 
@@ -68,8 +76,8 @@ func (d *BaseCLIDetector) Detect() (*CLIStatus, error) {
 }
 
 // getVersion extracts version from CLI output
-func (d *BaseCLIDetector) getVersion(cliPath string) (string, error) {
-	cmd := exec.Command(cliPath, d.versionFlag)
+func (d *BaseCLIDetector) getVersion(ctx context.Context, cliPath string) (string, error) {
+	cmd := exec.CommandContext(ctx, cliPath, d.versionFlag)
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get version: %w", err)
@@ -162,6 +170,11 @@ func NewAWSCLIDetectorWithLogger(logger CLILogger) *AWSCLIDetector {
 
 // Detect performs enhanced AWS CLI detection with comprehensive logging and error handling
 func (d *AWSCLIDetector) Detect() (*CLIStatus, error) {
+	return d.DetectContext(context.Background())
+}
+
+// DetectContext is Detect, killing the version commands when ctx is done
+func (d *AWSCLIDetector) DetectContext(ctx context.Context) (*CLIStatus, error) {
 	d.logger.Info("Starting AWS CLI detection")
 
 	status := &CLIStatus{
@@ -171,7 +184,10 @@ func (d *AWSCLIDetector) Detect() (*CLIStatus, error) {
 	}
 
 	// Try multiple detection strategies
-	detectionResults := d.detectMultiplePaths()
+	detectionResults := d.detectMultiplePaths(ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(detectionResults) == 0 {
 		d.logger.Warn("No AWS CLI installations found")
@@ -212,12 +228,12 @@ type AWSCLIInstallation struct {
 }
 
 // detectMultiplePaths attempts to detect AWS CLI installations in various locations
-func (d *AWSCLIDetector) detectMultiplePaths() []AWSCLIInstallation {
+func (d *AWSCLIDetector) detectMultiplePaths(ctx context.Context) []AWSCLIInstallation {
 	var results []AWSCLIInstallation
 
 	// Standard PATH detection
 	if path, err := exec.LookPath("aws"); err == nil {
-		if installation, err := d.analyzeInstallation(path); err == nil {
+		if installation, err := d.analyzeInstallation(ctx, path); err == nil {
 			results = append(results, installation)
 		}
 	}
@@ -225,7 +241,7 @@ func (d *AWSCLIDetector) detectMultiplePaths() []AWSCLIInstallation {
 	// Platform-specific additional paths
 	additionalPaths := d.getPlatformSpecificPaths()
 	for _, path := range additionalPaths {
-		if installation, err := d.analyzeInstallation(path); err == nil {
+		if installation, err := d.analyzeInstallation(ctx, path); err == nil {
 			// Avoid duplicates
 			isDuplicate := false
 			for _, existing := range results {
@@ -272,11 +288,11 @@ func (d *AWSCLIDetector) getPlatformSpecificPaths() []string {
 }
 
 // analyzeInstallation analyzes a specific AWS CLI installation
-func (d *AWSCLIDetector) analyzeInstallation(path string) (AWSCLIInstallation, error) {
+func (d *AWSCLIDetector) analyzeInstallation(ctx context.Context, path string) (AWSCLIInstallation, error) {
 	startTime := time.Now()
 
 	// Get version information
-	cmd := exec.Command(path, "--version")
+	cmd := exec.CommandContext(ctx, path, "--version")
 	output, err := cmd.Output()
 	if err != nil {
 		return AWSCLIInstallation{}, fmt.Errorf("failed to get version from %s: %w", path, err)
@@ -511,7 +527,7 @@ func (d *AWSCLIDetector) GetDetailedValidationResult() (*AWSCLIValidationResult,
 	}
 
 	// Detect all installations
-	installations := d.detectMultiplePaths()
+	installations := d.detectMultiplePaths(context.Background())
 	result.TotalInstallations = len(installations)
 
 	if len(installations) == 0 {
@@ -739,28 +755,20 @@ func (f *DetectorFactory) CreateDetector(provider Provider) (CLIDetector, error)
 	}
 }
 
-// DetectAllCLIs detects all cloud provider CLIs
+// DetectAllCLIs detects all cloud provider CLIs concurrently with the
+// default timeouts. A CLI that is missing, fails, or times out is reported
+// as not installed.
 func DetectAllCLIs(ctx context.Context) map[Provider]*CLIStatus {
-	factory := NewDetectorFactory()
-	providers := []Provider{ProviderAWS, ProviderAzure, ProviderGCP}
-
 	results := make(map[Provider]*CLIStatus)
-
-	for _, provider := range providers {
-		detector, err := factory.CreateDetector(provider)
-		if err != nil {
-			continue
-		}
-
-		status, err := detector.Detect()
-		if err != nil {
+	for result := range DetectCLIs(ctx, CLIDetectOptions{}) {
+		status := result.Status
+		if status == nil {
 			status = &CLIStatus{
 				Installed:   false,
 				IsSupported: false,
 			}
 		}
-
-		results[provider] = status
+		results[result.Provider] = status
 	}
 
 	return results
@@ -33,6 +33,10 @@ func NewGCPProvider(config *ProviderConfig) (*GCPProvider, error) {
 		}
 	}
 
+	if err := config.checkRegion("NewProvider", config.DefaultRegion); err != nil {
+		return nil, err
+	}
+
 	return &GCPProvider{
 		config: config,
 		cache:  NewCredentialCache(config.CacheDuration),
@@ -426,6 +430,9 @@ func (p *GCPProvider) GetCurrentRegion() string {
 
 // SetRegion sets the GCP region
 func (p *GCPProvider) SetRegion(region string) error {
+	if err := p.config.checkRegion("SetRegion", region); err != nil {
+		return err
+	}
 	p.config.DefaultRegion = region
 	p.region = region
 
@@ -926,6 +933,10 @@ func (sm *GCPStorageManager) ListStorageBuckets(ctx context.Context) ([]*Storage
 
 // CreateStorageBucket creates a new Cloud Storage bucket
 func (sm *GCPStorageManager) CreateStorageBucket(ctx context.Context, bucketName, location, storageClass string) (*StorageBucket, error) {
+	if err := sm.provider.config.checkRegion("CreateStorageBucket", location); err != nil {
+		return nil, err
+	}
+
 	cmd := exec.Command("gcloud", "storage", "buckets", "create",
 		fmt.Sprintf("gs://%s", bucketName),
 		"--location", location,
@@ -0,0 +1,444 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/chaksack/apm/pkg/cloud/state"
+)
+
+// APMMonitoringConfig describes the CloudWatch resources of an environment's
+// APM monitoring setup
+type APMMonitoringConfig struct {
+	Environment string   `json:"environment"`
+	Region      string   `json:"region"`
+	Tools       []string `json:"tools"`
+
+	// Dashboards maps a dashboard template (infrastructure, application,
+	// service-mesh, logs, tracing, cost) to the dashboard name
+	Dashboards map[string]string `json:"dashboards"`
+
+	// DashboardThresholds are drawn on the dashboards, keyed by metric; the
+	// overrides of Environment apply
+	DashboardThresholds   map[string]float64            `json:"dashboardThresholds,omitempty"`
+	EnvironmentThresholds map[string]map[string]float64 `json:"environmentThresholds,omitempty"`
+
+	// Alarms maps an alarm template (see apmAlarmTemplates) to the alarm name
+	Alarms map[string]string `json:"alarms"`
+
+	LogGroups        []string `json:"logGroups"`
+	LogRetentionDays int      `json:"logRetentionDays,omitempty"`
+
+	// MetricFilters maps a filter template (error-filter, performance-filter,
+	// security-filter) to the filter name; filters apply to the first log group
+	MetricFilters map[string]string `json:"metricFilters"`
+
+	// SNSTopics receive alarm and event notifications; alarms and event rules
+	// notify the first topic
+	SNSTopics  []string `json:"snsTopics"`
+	EventRules []string `json:"eventRules"`
+
+	// AlertTransports route alarm state changes to SQS queues, EventBridge
+	// buses, and webhooks, for consumers that cannot subscribe to SNS
+	AlertTransports []AlertTransport `json:"alertTransports,omitempty"`
+
+	Tags map[string]string `json:"tags"`
+
+	// StateStore records created resources; nil uses a local store
+	StateStore state.Store `json:"-"`
+
+	// Parallelism bounds concurrent creations; zero uses state.DefaultParallelism
+	Parallelism int `json:"parallelism,omitempty"`
+
+	// KeepOnFailure leaves the resources created before a failure in place
+	// instead of rolling them back, so a rerun resumes where setup stopped
+	KeepOnFailure bool `json:"keepOnFailure,omitempty"`
+
+	// Plan previews the setup without creating anything
+	Plan bool `json:"-"`
+}
+
+// APMMonitoringSetup is the result of CreateAPMMonitoringSetup
+type APMMonitoringSetup struct {
+	Environment   string   `json:"environment"`
+	Region        string   `json:"region"`
+	Dashboards    []string `json:"dashboards"`
+	Alarms        []string `json:"alarms"`
+	LogGroups     []string `json:"logGroups"`
+	MetricFilters []string `json:"metricFilters"`
+	SNSTopics     []string `json:"snsTopics"`
+	EventRules    []string `json:"eventRules"`
+
+	// Created lists resources created by this run; the others were already
+	// recorded in the state
+	Created []state.Resource `json:"created"`
+	// RolledBack lists resources deleted again after a failed setup
+	RolledBack []state.Resource `json:"rolledBack,omitempty"`
+	State      *state.Manifest  `json:"state"`
+
+	// Plan lists the planned changes when APMMonitoringConfig.Plan is set
+	Plan []state.Change `json:"plan,omitempty"`
+}
+
+// apmAlarmTemplates are the alarms available to APMMonitoringConfig.Alarms
+var apmAlarmTemplates = map[string]AlarmConfig{
+	"high-cpu":           {MetricName: "CPUUtilization", Namespace: "AWS/EC2", Statistic: "Average", Threshold: 80, ComparisonOperator: "GreaterThanThreshold"},
+	"high-memory":        {MetricName: "mem_used_percent", Namespace: "CWAgent", Statistic: "Average", Threshold: 85, ComparisonOperator: "GreaterThanThreshold"},
+	"disk-space":         {MetricName: "disk_used_percent", Namespace: "CWAgent", Statistic: "Average", Threshold: 90, ComparisonOperator: "GreaterThanThreshold"},
+	"service-down":       {MetricName: "StatusCheckFailed", Namespace: "AWS/EC2", Statistic: "Maximum", Threshold: 1, ComparisonOperator: "GreaterThanOrEqualToThreshold"},
+	"high-error-rate":    {MetricName: "ErrorCount", Statistic: "Sum", Threshold: 10, ComparisonOperator: "GreaterThanThreshold"},
+	"slow-response-time": {MetricName: "ResponseTime", Statistic: "Average", Threshold: 1000, ComparisonOperator: "GreaterThanThreshold"},
+}
+
+// apmMetricFilterTemplates are the filters available to APMMonitoringConfig.MetricFilters
+var apmMetricFilterTemplates = map[string]MetricFilter{
+	"error-filter": {
+		FilterPattern:         "?ERROR ?Error ?\"level\\\":\\\"error\"",
+		MetricTransformations: []MetricTransformation{{MetricName: "ErrorCount", MetricValue: "1", Unit: "Count"}},
+	},
+	"performance-filter": {
+		FilterPattern:         "{ $.duration_ms = * }",
+		MetricTransformations: []MetricTransformation{{MetricName: "ResponseTime", MetricValue: "$.duration_ms", Unit: "Milliseconds"}},
+	},
+	"security-filter": {
+		FilterPattern:         "?Unauthorized ?Forbidden ?\"authentication failed\"",
+		MetricTransformations: []MetricTransformation{{MetricName: "SecurityEvents", MetricValue: "1", Unit: "Count"}},
+	},
+}
+
+// apmEventPattern returns the event pattern for a rule from its name
+func apmEventPattern(name string) map[string]interface{} {
+	switch {
+	case strings.Contains(name, "instance-state"):
+		return map[string]interface{}{"source": []string{"aws.ec2"}, "detail-type": []string{"EC2 Instance State-change Notification"}}
+	case strings.Contains(name, "autoscaling"):
+		return map[string]interface{}{"source": []string{"aws.autoscaling"}}
+	case strings.Contains(name, "deployment"):
+		return map[string]interface{}{"source": []string{"aws.codedeploy", "aws.ecs"}}
+	default:
+		return map[string]interface{}{"source": []string{"aws.cloudwatch"}, "detail-type": []string{"CloudWatch Alarm State Change"}}
+	}
+}
+
+// apmMetricNamespace is the namespace of metrics derived from an environment's logs
+func apmMetricNamespace(environment string) string {
+	return "APM/" + environment
+}
+
+// CreateAPMMonitoringSetup creates the dashboards, alarms, log groups,
+// metric filters, SNS topics, and event rules of an environment. Resources
+// are created in dependency order with bounded parallelism and recorded in
+// the state store as soon as they exist; resources already recorded are not
+// created again. If a creation fails, the resources created by this run are
+// rolled back unless KeepOnFailure is set. With Plan set, nothing is created
+// and the result lists the planned changes.
+func (cw *CloudWatchManager) CreateAPMMonitoringSetup(ctx context.Context, config *APMMonitoringConfig) (*APMMonitoringSetup, error) {
+	if config.Environment == "" {
+		return nil, fmt.Errorf("environment is required")
+	}
+	if err := validateAPMMonitoringConfig(config); err != nil {
+		return nil, err
+	}
+
+	region := config.Region
+	if region == "" {
+		region = cw.provider.config.DefaultRegion
+	}
+	if err := cw.provider.config.checkRegion("CreateAPMMonitoringSetup", region); err != nil {
+		return nil, err
+	}
+
+	store := config.StateStore
+	if store == nil {
+		store = &state.LocalStore{}
+	}
+	plan := cw.planAPMMonitoringSetup(config, region)
+	setup := &APMMonitoringSetup{Environment: config.Environment, Region: region}
+
+	if config.Plan {
+		manifest, err := store.Load(ctx, config.Environment)
+		if errors.Is(err, state.ErrNotFound) {
+			manifest = nil
+		} else if err != nil {
+			return nil, err
+		}
+		if manifest != nil && manifest.Region != region {
+			return nil, fmt.Errorf("environment %s is recorded in region %s; tear it down before moving it to %s", config.Environment, manifest.Region, region)
+		}
+		setup.Plan, err = plan.Preview(manifest)
+		return setup, err
+	}
+
+	applier := &state.Applier{Store: store, Parallelism: config.Parallelism, KeepOnFailure: config.KeepOnFailure}
+	result, err := applier.Apply(ctx, plan)
+	if result == nil {
+		return nil, err
+	}
+	setup.State = result.Manifest
+	setup.Created = result.Created
+	setup.RolledBack = result.RolledBack
+
+	for _, r := range result.Manifest.Resources {
+		switch r.Kind {
+		case state.KindSNSTopic:
+			setup.SNSTopics = append(setup.SNSTopics, r.Name)
+		case state.KindLogGroup:
+			setup.LogGroups = append(setup.LogGroups, r.Name)
+		case state.KindMetricFilter:
+			setup.MetricFilters = append(setup.MetricFilters, r.Name)
+		case state.KindDashboard:
+			setup.Dashboards = append(setup.Dashboards, r.Name)
+		case state.KindAlarm:
+			setup.Alarms = append(setup.Alarms, r.Name)
+		case state.KindEventRule:
+			setup.EventRules = append(setup.EventRules, r.Name)
+		}
+	}
+	return setup, err
+}
+
+// planAPMMonitoringSetup builds the plan of an environment's resources.
+// Metric filters depend on the first log group, and alarms and event rules
+// on the first SNS topic they notify.
+func (cw *CloudWatchManager) planAPMMonitoringSetup(config *APMMonitoringConfig, region string) *state.Plan {
+	plan := state.NewPlan(config.Environment, region)
+
+	for _, name := range config.SNSTopics {
+		plan.Add(state.Resource{Kind: state.KindSNSTopic, Name: name}, func(ctx context.Context, _ []state.Resource) (string, error) {
+			topic, err := cw.snsMgr.CreateSNSTopic(ctx, &SNSTopicConfig{TopicName: name, Region: region, Tags: config.Tags})
+			if err != nil {
+				return "", err
+			}
+			return topic.TopicArn, nil
+		})
+	}
+
+	var notify []state.Ref
+	if len(config.SNSTopics) > 0 {
+		notify = []state.Ref{{Kind: state.KindSNSTopic, Name: config.SNSTopics[0]}}
+	}
+	notifyARNs := func(deps []state.Resource) []string {
+		if len(deps) == 0 || deps[0].ARN == "" {
+			return nil
+		}
+		return []string{deps[0].ARN}
+	}
+
+	for _, name := range config.LogGroups {
+		plan.Add(state.Resource{Kind: state.KindLogGroup, Name: name}, func(ctx context.Context, _ []state.Resource) (string, error) {
+			group, err := cw.logsMgr.CreateLogGroup(ctx, &LogGroupConfig{
+				LogGroupName:    name,
+				Region:          region,
+				RetentionInDays: config.LogRetentionDays,
+				Tags:            config.Tags,
+			})
+			if err != nil {
+				return "", err
+			}
+			return group.LogGroupArn, nil
+		})
+	}
+
+	for _, template := range sortedKeys(config.MetricFilters) {
+		filter := apmMetricFilterTemplates[template]
+		filter.FilterName = config.MetricFilters[template]
+		filter.Region = region
+		filter.LogGroupName = config.LogGroups[0]
+		filter.MetricTransformations = append([]MetricTransformation(nil), filter.MetricTransformations...)
+		for i := range filter.MetricTransformations {
+			filter.MetricTransformations[i].MetricNamespace = apmMetricNamespace(config.Environment)
+		}
+
+		plan.Add(state.Resource{Kind: state.KindMetricFilter, Name: filter.FilterName, Parent: filter.LogGroupName},
+			func(ctx context.Context, _ []state.Resource) (string, error) {
+				return "", cw.logsMgr.PutMetricFilter(ctx, &filter)
+			},
+			state.Ref{Kind: state.KindLogGroup, Name: filter.LogGroupName})
+	}
+
+	for _, template := range sortedKeys(config.Dashboards) {
+		name := config.Dashboards[template]
+		plan.Add(state.Resource{Kind: state.KindDashboard, Name: name}, func(ctx context.Context, _ []state.Resource) (string, error) {
+			dashboard, err := cw.dashboardMgr.CreateDashboard(ctx, &DashboardConfig{
+				Name:     name,
+				Region:   region,
+				Template: template,
+				Tags:     config.Tags,
+				Variables: map[string]string{
+					DashboardVariableEnvironment: config.Environment,
+					DashboardVariableRegion:      region,
+				},
+				Thresholds:            config.DashboardThresholds,
+				EnvironmentThresholds: config.EnvironmentThresholds,
+			})
+			if err != nil {
+				return "", err
+			}
+			return dashboard.DashboardArn, nil
+		})
+	}
+
+	for _, template := range sortedKeys(config.Alarms) {
+		alarm := apmAlarmTemplates[template]
+		alarm.AlarmName = config.Alarms[template]
+		alarm.Region = region
+		alarm.AlarmDescription = fmt.Sprintf("APM %s alarm for %s", template, config.Environment)
+		if alarm.Namespace == "" {
+			alarm.Namespace = apmMetricNamespace(config.Environment)
+		}
+		alarm.Period = 300
+		alarm.EvaluationPeriods = 2
+		alarm.TreatMissingData = "notBreaching"
+		alarm.Tags = config.Tags
+
+		plan.Add(state.Resource{Kind: state.KindAlarm, Name: alarm.AlarmName}, func(ctx context.Context, deps []state.Resource) (string, error) {
+			alarm.AlarmActions = notifyARNs(deps)
+			alarm.ActionsEnabled = len(alarm.AlarmActions) > 0
+			created, err := cw.alarmMgr.CreateAlarm(ctx, &alarm)
+			if err != nil {
+				return "", err
+			}
+			return created.AlarmArn, nil
+		}, notify...)
+	}
+
+	for _, name := range config.EventRules {
+		rule := &EventRuleConfig{
+			Name:         name,
+			Region:       region,
+			Description:  fmt.Sprintf("APM events for %s", config.Environment),
+			EventPattern: apmEventPattern(name),
+			State:        "ENABLED",
+			Tags:         config.Tags,
+		}
+
+		plan.Add(state.Resource{Kind: state.KindEventRule, Name: name}, func(ctx context.Context, deps []state.Resource) (string, error) {
+			if arns := notifyARNs(deps); len(arns) > 0 {
+				rule.Targets = []EventTarget{{Id: "apm-notify", Arn: arns[0]}}
+			}
+			created, err := cw.eventsMgr.CreateEventRule(ctx, rule)
+			if err != nil {
+				return "", err
+			}
+			return created.Arn, nil
+		}, notify...)
+	}
+
+	for i := range config.AlertTransports {
+		transport := config.AlertTransports[i]
+		transport.Region = region
+		rule := state.Resource{Kind: state.KindEventRule, Name: transport.Name}
+		if transport.Type != AlertTransportWebhook {
+			plan.Add(rule, func(ctx context.Context, _ []state.Resource) (string, error) {
+				created, err := cw.eventsMgr.PutAlertTransportRule(ctx, &transport, transport.ARN)
+				if err != nil {
+					return "", err
+				}
+				return created.Arn, nil
+			})
+			continue
+		}
+
+		// A webhook is an API destination authenticated by a connection
+		connection := state.Resource{Kind: state.KindConnection, Name: transport.ConnectionName()}
+		plan.Add(connection, func(ctx context.Context, _ []state.Resource) (string, error) {
+			return cw.eventsMgr.CreateWebhookConnection(ctx, &transport)
+		})
+		destination := state.Resource{Kind: state.KindAPIDestination, Name: transport.Name}
+		plan.Add(destination, func(ctx context.Context, deps []state.Resource) (string, error) {
+			return cw.eventsMgr.CreateAPIDestination(ctx, &transport, deps[0].ARN)
+		}, state.Ref{Kind: state.KindConnection, Name: connection.Name})
+		plan.Add(rule, func(ctx context.Context, deps []state.Resource) (string, error) {
+			created, err := cw.eventsMgr.PutAlertTransportRule(ctx, &transport, deps[0].ARN)
+			if err != nil {
+				return "", err
+			}
+			return created.Arn, nil
+		}, state.Ref{Kind: state.KindAPIDestination, Name: destination.Name})
+	}
+
+	return plan
+}
+
+// DeleteAPMMonitoringSetup removes exactly the resources recorded for an
+// environment and returns them; store nil uses a local store
+func (cw *CloudWatchManager) DeleteAPMMonitoringSetup(ctx context.Context, environment string, store state.Store) ([]state.Resource, error) {
+	if store == nil {
+		store = &state.LocalStore{}
+	}
+	teardown := &state.Teardown{Store: store}
+	return teardown.Destroy(ctx, environment)
+}
+
+// validateAPMMonitoringConfig checks that every template is known
+func validateAPMMonitoringConfig(config *APMMonitoringConfig) error {
+	for template := range config.Alarms {
+		if _, ok := apmAlarmTemplates[template]; !ok {
+			return fmt.Errorf("unknown alarm template %q", template)
+		}
+	}
+	for template := range config.MetricFilters {
+		if _, ok := apmMetricFilterTemplates[template]; !ok {
+			return fmt.Errorf("unknown metric filter template %q", template)
+		}
+	}
+	if len(config.MetricFilters) > 0 && len(config.LogGroups) == 0 {
+		return fmt.Errorf("metric filters require a log group")
+	}
+	rules := make(map[string]bool, len(config.EventRules))
+	for _, name := range config.EventRules {
+		rules[name] = true
+	}
+	for i := range config.AlertTransports {
+		transport := &config.AlertTransports[i]
+		if err := transport.Validate(); err != nil {
+			return err
+		}
+		if rules[transport.Name] {
+			return fmt.Errorf("alert transport %s: an event rule has the same name", transport.Name)
+		}
+		rules[transport.Name] = true
+	}
+	return nil
+}
+
+// sortedKeys returns map keys in a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PutMetricFilter creates or updates a metric filter on a log group
+func (lm *LogsManager) PutMetricFilter(ctx context.Context, filter *MetricFilter) error {
+	region := lm.cloudWatch.provider.config.region(filter.Region)
+	if err := lm.cloudWatch.provider.config.checkRegion("PutMetricFilter", region); err != nil {
+		return err
+	}
+
+	var transformations []string
+	for _, t := range filter.MetricTransformations {
+		transformations = append(transformations, fmt.Sprintf("metricName=%s,metricNamespace=%s,metricValue=%s,defaultValue=%g,unit=%s",
+			t.MetricName, t.MetricNamespace, t.MetricValue, t.DefaultValue, t.Unit))
+	}
+
+	args := []string{"logs", "put-metric-filter",
+		"--log-group-name", filter.LogGroupName,
+		"--filter-name", filter.FilterName,
+		"--filter-pattern", filter.FilterPattern,
+		"--metric-transformations"}
+	args = append(args, transformations...)
+	args = append(args, "--region", region)
+
+	if err := exec.CommandContext(ctx, "aws", args...).Run(); err != nil {
+		return fmt.Errorf("failed to put metric filter: %w", err)
+	}
+	return nil
+}
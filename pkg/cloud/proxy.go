@@ -0,0 +1,44 @@
+package cloud
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/chaksack/apm/pkg/proxy"
+)
+
+// proxyConfig returns the provider proxy settings, falling back to the environment
+func (c *ProviderConfig) proxyConfig() *proxy.Config {
+	if c == nil || c.Proxy == nil {
+		return proxy.FromEnvironment()
+	}
+	return c.Proxy
+}
+
+// newProviderHTTPClient creates an HTTP client honouring the provider proxy settings.
+// Invalid proxy settings fall back to a client that only uses the environment.
+func newProviderHTTPClient(config *ProviderConfig, timeout time.Duration) *http.Client {
+	client, err := config.proxyConfig().HTTPClient(timeout)
+	if err != nil {
+		if config != nil && config.Logger != nil {
+			config.Logger("invalid proxy configuration, using environment: " + err.Error())
+		}
+		return &http.Client{Timeout: timeout}
+	}
+	return client
+}
+
+// applyProxyEnv adds the provider proxy settings to a CLI command environment.
+// Commands with an empty environment inherit the current process environment first.
+func applyProxyEnv(cmd *exec.Cmd, config *ProviderConfig) {
+	env := config.proxyConfig().CommandEnv()
+	if len(env) == 0 {
+		return
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
+}
@@ -0,0 +1,27 @@
+package cloud
+
+import (
+	"time"
+)
+
+// checkRegion validates a region against the provider's data residency guard.
+// Violations are returned as CloudError with code DATA_RESIDENCY_VIOLATION.
+func (c *ProviderConfig) checkRegion(operation, region string) error {
+	if c == nil || c.Residency == nil {
+		return nil
+	}
+
+	if err := c.Residency.Check("cloud."+string(c.Provider)+"."+operation, string(c.Provider), region); err != nil {
+		return &CloudError{
+			Provider:  c.Provider,
+			Operation: operation,
+			Code:      "DATA_RESIDENCY_VIOLATION",
+			Message:   "region not allowed by data residency policy",
+			Cause:     err,
+			Timestamp: time.Now(),
+			UserMessage: err.Error() +
+				"\nChoose an allowed region or grant an audited override.",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cloud
+
+import (
+	"context"
+	"io"
+)
+
+// S3API is the object storage surface of S3Manager. Code that depends on it
+// instead of *S3Manager can be tested with cloudtest.FakeS3.
+type S3API interface {
+	CreateBucket(ctx context.Context, name, region string, options *BucketOptions) (*Bucket, error)
+	ListBuckets(ctx context.Context, region string) ([]*Bucket, error)
+	GetBucket(ctx context.Context, name, region string) (*BucketDetails, error)
+	DeleteBucket(ctx context.Context, name, region string, force bool) error
+	UploadFile(ctx context.Context, bucket, key string, content io.Reader, options *UploadOptions) (*FileInfo, error)
+	DownloadFile(ctx context.Context, bucket, key string, options *DownloadOptions) (io.ReadCloser, error)
+	ListFiles(ctx context.Context, bucket, prefix string, options *ListOptions) (*ListResult, error)
+	DeleteFile(ctx context.Context, bucket, key string, options *DeleteOptions) error
+	CopyFile(ctx context.Context, sourceBucket, sourceKey, destBucket, destKey string, options *CopyOptions) (*FileInfo, error)
+}
+
+// CloudWatchAPI is the dashboard, alarm, and log surface of CloudWatchManager.
+// Code that depends on it instead of *CloudWatchManager can be tested with
+// cloudtest.FakeCloudWatch.
+type CloudWatchAPI interface {
+	CreateDashboard(ctx context.Context, config *DashboardConfig) (*CloudWatchDashboard, error)
+	GetDashboard(ctx context.Context, name string) (*CloudWatchDashboard, error)
+	ListDashboards(ctx context.Context, prefix string) ([]*CloudWatchDashboard, error)
+	DeleteDashboard(ctx context.Context, name string) error
+
+	CreateAlarm(ctx context.Context, config *AlarmConfig) (*CloudWatchAlarm, error)
+	ListAlarms(ctx context.Context, prefix string) ([]*CloudWatchAlarm, error)
+	GetAlarmState(ctx context.Context, name string) (*AlarmState, error)
+	EnableAlarm(ctx context.Context, name string) error
+	DisableAlarm(ctx context.Context, name string) error
+
+	CreateLogGroup(ctx context.Context, config *LogGroupConfig) (*CloudWatchLogGroup, error)
+	PutLogEvents(ctx context.Context, logGroupName, logStreamName string, events []*LogEvent) error
+}
+
+var (
+	_ CloudProvider = (*AWSProvider)(nil)
+	_ S3API         = (*S3Manager)(nil)
+	_ CloudWatchAPI = (*CloudWatchManager)(nil)
+)
+
+// CreateDashboard creates a dashboard through the dashboard manager
+func (cw *CloudWatchManager) CreateDashboard(ctx context.Context, config *DashboardConfig) (*CloudWatchDashboard, error) {
+	return cw.dashboardMgr.CreateDashboard(ctx, config)
+}
+
+// GetDashboard returns a dashboard by name
+func (cw *CloudWatchManager) GetDashboard(ctx context.Context, name string) (*CloudWatchDashboard, error) {
+	return cw.dashboardMgr.GetDashboard(ctx, name)
+}
+
+// ListDashboards lists the dashboards whose names start with prefix
+func (cw *CloudWatchManager) ListDashboards(ctx context.Context, prefix string) ([]*CloudWatchDashboard, error) {
+	return cw.dashboardMgr.ListDashboards(ctx, prefix)
+}
+
+// DeleteDashboard deletes a dashboard by name
+func (cw *CloudWatchManager) DeleteDashboard(ctx context.Context, name string) error {
+	return cw.dashboardMgr.DeleteDashboard(ctx, name)
+}
+
+// CreateAlarm creates an alarm through the alarm manager
+func (cw *CloudWatchManager) CreateAlarm(ctx context.Context, config *AlarmConfig) (*CloudWatchAlarm, error) {
+	return cw.alarmMgr.CreateAlarm(ctx, config)
+}
+
+// ListAlarms lists the alarms whose names start with prefix
+func (cw *CloudWatchManager) ListAlarms(ctx context.Context, prefix string) ([]*CloudWatchAlarm, error) {
+	return cw.alarmMgr.ListAlarms(ctx, prefix)
+}
+
+// GetAlarmState returns the current state of an alarm
+func (cw *CloudWatchManager) GetAlarmState(ctx context.Context, name string) (*AlarmState, error) {
+	return cw.alarmMgr.GetAlarmState(ctx, name)
+}
+
+// EnableAlarm enables the actions of an alarm
+func (cw *CloudWatchManager) EnableAlarm(ctx context.Context, name string) error {
+	return cw.alarmMgr.EnableAlarm(ctx, name)
+}
+
+// DisableAlarm disables the actions of an alarm
+func (cw *CloudWatchManager) DisableAlarm(ctx context.Context, name string) error {
+	return cw.alarmMgr.DisableAlarm(ctx, name)
+}
+
+// CreateLogGroup creates a log group through the logs manager
+func (cw *CloudWatchManager) CreateLogGroup(ctx context.Context, config *LogGroupConfig) (*CloudWatchLogGroup, error) {
+	return cw.logsMgr.CreateLogGroup(ctx, config)
+}
+
+// PutLogEvents writes events to a log stream
+func (cw *CloudWatchManager) PutLogEvents(ctx context.Context, logGroupName, logStreamName string, events []*LogEvent) error {
+	return cw.logsMgr.PutLogEvents(ctx, logGroupName, logStreamName, events)
+}
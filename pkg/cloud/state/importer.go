@@ -0,0 +1,266 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+)
+
+// ImportableKinds are the kinds Importer can discover
+var ImportableKinds = []Kind{KindDashboard, KindAlarm, KindLogGroup, KindSNSTopic}
+
+// Filter selects the existing resources to adopt. At least one prefix or a
+// pattern is required so an import never adopts a whole account.
+type Filter struct {
+	// Prefixes match the start of resource names
+	Prefixes []string
+	// Pattern, when set, must also match the resource name
+	Pattern *regexp.Regexp
+	// Kinds limits discovery; empty means ImportableKinds
+	Kinds []Kind
+}
+
+// Validate checks that the filter narrows the import
+func (f Filter) Validate() error {
+	if len(f.Prefixes) == 0 && f.Pattern == nil {
+		return fmt.Errorf("a name prefix or pattern is required")
+	}
+	for _, k := range f.Kinds {
+		if !importable(k) {
+			return fmt.Errorf("resources of kind %s cannot be imported", k)
+		}
+	}
+	return nil
+}
+
+func (f Filter) kinds() []Kind {
+	if len(f.Kinds) == 0 {
+		return ImportableKinds
+	}
+	return f.Kinds
+}
+
+// matches reports whether a name passes the filter
+func (f Filter) matches(name string) bool {
+	if len(f.Prefixes) > 0 {
+		found := false
+		for _, p := range f.Prefixes {
+			if strings.HasPrefix(name, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.Pattern == nil || f.Pattern.MatchString(name)
+}
+
+func importable(k Kind) bool {
+	for _, ik := range ImportableKinds {
+		if k == ik {
+			return true
+		}
+	}
+	return false
+}
+
+// Importer discovers existing monitoring resources and adopts them into an
+// environment's manifest, so later setups skip them and teardown removes them
+type Importer struct {
+	Store Store
+
+	// Run executes the aws CLI; nil uses os/exec
+	Run CommandRunner
+}
+
+// Discover lists the existing resources in a region that match the filter
+func (im *Importer) Discover(ctx context.Context, region string, filter Filter) ([]Resource, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	var resources []Resource
+	var errs []error
+	for _, kind := range filter.kinds() {
+		found, err := im.discover(ctx, kind, region, filter)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list %s resources: %w", kind, err))
+			continue
+		}
+		for _, r := range found {
+			if filter.matches(r.Name) {
+				r.Kind, r.Region, r.Imported = kind, region, true
+				resources = append(resources, r)
+			}
+		}
+	}
+	return resources, errors.Join(errs...)
+}
+
+// Plan returns the discovered resources not yet recorded for the environment
+func (im *Importer) Plan(ctx context.Context, environment, region string, filter Filter) (*Manifest, []Resource, error) {
+	m, err := im.Store.Load(ctx, environment)
+	if errors.Is(err, ErrNotFound) {
+		m = NewManifest(environment, region)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if m.Region != region {
+		return nil, nil, fmt.Errorf("environment %s is recorded in region %s, not %s", environment, m.Region, region)
+	}
+
+	discovered, err := im.Discover(ctx, region, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var adopt []Resource
+	for _, r := range discovered {
+		if !m.Has(r.Kind, r.Name) {
+			adopt = append(adopt, r)
+		}
+	}
+	return m, adopt, nil
+}
+
+// Import adopts the matching resources into the environment's manifest and
+// returns the newly adopted ones
+func (im *Importer) Import(ctx context.Context, environment, region string, filter Filter) ([]Resource, error) {
+	m, adopt, err := im.Plan(ctx, environment, region, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(adopt) == 0 {
+		return nil, nil
+	}
+
+	for _, r := range adopt {
+		m.Record(r)
+	}
+	if err := im.Store.Save(ctx, m); err != nil {
+		return nil, err
+	}
+	return adopt, nil
+}
+
+// discover lists resources of one kind, narrowed server-side by prefix where
+// the API supports it
+func (im *Importer) discover(ctx context.Context, kind Kind, region string, filter Filter) ([]Resource, error) {
+	// Without a prefix, list everything once and filter locally
+	prefixes := filter.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	var resources []Resource
+	switch kind {
+	case KindDashboard:
+		for _, prefix := range prefixes {
+			args := []string{"cloudwatch", "list-dashboards"}
+			if prefix != "" {
+				args = append(args, "--dashboard-name-prefix", prefix)
+			}
+			var out struct {
+				DashboardEntries []struct {
+					DashboardName string `json:"DashboardName"`
+					DashboardArn  string `json:"DashboardArn"`
+				} `json:"DashboardEntries"`
+			}
+			if err := im.aws(ctx, region, &out, args...); err != nil {
+				return nil, err
+			}
+			for _, d := range out.DashboardEntries {
+				resources = append(resources, Resource{Name: d.DashboardName, ARN: d.DashboardArn})
+			}
+		}
+	case KindAlarm:
+		for _, prefix := range prefixes {
+			args := []string{"cloudwatch", "describe-alarms"}
+			if prefix != "" {
+				args = append(args, "--alarm-name-prefix", prefix)
+			}
+			var out struct {
+				MetricAlarms []struct {
+					AlarmName string `json:"AlarmName"`
+					AlarmArn  string `json:"AlarmArn"`
+				} `json:"MetricAlarms"`
+			}
+			if err := im.aws(ctx, region, &out, args...); err != nil {
+				return nil, err
+			}
+			for _, a := range out.MetricAlarms {
+				resources = append(resources, Resource{Name: a.AlarmName, ARN: a.AlarmArn})
+			}
+		}
+	case KindLogGroup:
+		for _, prefix := range prefixes {
+			args := []string{"logs", "describe-log-groups"}
+			if prefix != "" {
+				args = append(args, "--log-group-name-prefix", prefix)
+			}
+			var out struct {
+				LogGroups []struct {
+					LogGroupName string `json:"logGroupName"`
+					Arn          string `json:"arn"`
+				} `json:"logGroups"`
+			}
+			if err := im.aws(ctx, region, &out, args...); err != nil {
+				return nil, err
+			}
+			for _, g := range out.LogGroups {
+				resources = append(resources, Resource{Name: g.LogGroupName, ARN: strings.TrimSuffix(g.Arn, ":*")})
+			}
+		}
+	case KindSNSTopic:
+		// SNS cannot filter by name, so list once
+		var out struct {
+			Topics []struct {
+				TopicArn string `json:"TopicArn"`
+			} `json:"Topics"`
+		}
+		if err := im.aws(ctx, region, &out, "sns", "list-topics"); err != nil {
+			return nil, err
+		}
+		for _, t := range out.Topics {
+			name := t.TopicArn[strings.LastIndex(t.TopicArn, ":")+1:]
+			resources = append(resources, Resource{Name: name, ARN: t.TopicArn})
+		}
+	}
+	return dedupe(resources), nil
+}
+
+// dedupe drops resources listed by more than one overlapping prefix
+func dedupe(resources []Resource) []Resource {
+	seen := make(map[string]bool, len(resources))
+	out := resources[:0]
+	for _, r := range resources {
+		if !seen[r.Name] {
+			seen[r.Name] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// aws runs an aws CLI command in a region and decodes its JSON output
+func (im *Importer) aws(ctx context.Context, region string, out interface{}, args ...string) error {
+	run := im.Run
+	if run == nil {
+		run = cmdrun.Exec
+	}
+	output, err := run(ctx, "aws", append(args, "--region", region, "--output", "json")...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(output, out); err != nil {
+		return fmt.Errorf("failed to parse aws output: %w", err)
+	}
+	return nil
+}
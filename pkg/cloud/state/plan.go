@@ -0,0 +1,347 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/progress"
+)
+
+// DefaultParallelism bounds concurrent creations when Applier.Parallelism is unset
+const DefaultParallelism = 4
+
+// rollbackTimeout bounds a rollback, which runs even if the apply was canceled
+const rollbackTimeout = 5 * time.Minute
+
+// Ref identifies a resource by kind and name
+type Ref struct {
+	Kind Kind
+	Name string
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("%s %s", r.Kind, r.Name)
+}
+
+// Ref returns the resource's identity
+func (r Resource) Ref() Ref {
+	return Ref{Kind: r.Kind, Name: r.Name}
+}
+
+// CreateFunc creates a resource and returns its ARN. deps holds the recorded
+// resources of the step's dependencies, in the order they were declared.
+type CreateFunc func(ctx context.Context, deps []Resource) (string, error)
+
+// Step is one resource of a plan
+type Step struct {
+	Resource  Resource
+	DependsOn []Ref
+	Create    CreateFunc
+}
+
+// Plan is the set of resources of an environment's monitoring setup and the
+// dependencies between them
+type Plan struct {
+	Environment string
+	Region      string
+	Steps       []*Step
+}
+
+// NewPlan creates an empty plan
+func NewPlan(environment, region string) *Plan {
+	return &Plan{Environment: environment, Region: region}
+}
+
+// Add adds a resource created by create once its dependencies exist
+func (p *Plan) Add(r Resource, create CreateFunc, dependsOn ...Ref) {
+	r.Region = p.Region
+	p.Steps = append(p.Steps, &Step{Resource: r, DependsOn: dependsOn, Create: create})
+}
+
+// Waves orders the steps into waves; every step's dependencies are in earlier
+// waves, so the steps of a wave can be created in parallel
+func (p *Plan) Waves() ([][]*Step, error) {
+	steps := make(map[Ref]*Step, len(p.Steps))
+	for _, s := range p.Steps {
+		ref := s.Resource.Ref()
+		if _, ok := steps[ref]; ok {
+			return nil, fmt.Errorf("%s is planned twice", ref)
+		}
+		steps[ref] = s
+	}
+	for _, s := range p.Steps {
+		for _, dep := range s.DependsOn {
+			if _, ok := steps[dep]; !ok {
+				return nil, fmt.Errorf("%s depends on %s, which is not planned", s.Resource.Ref(), dep)
+			}
+		}
+	}
+
+	placed := make(map[Ref]bool, len(p.Steps))
+	var waves [][]*Step
+	for len(placed) < len(p.Steps) {
+		var wave []*Step
+		for _, s := range p.Steps {
+			if placed[s.Resource.Ref()] {
+				continue
+			}
+			ready := true
+			for _, dep := range s.DependsOn {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, s)
+			}
+		}
+		if len(wave) == 0 {
+			return nil, fmt.Errorf("plan has a dependency cycle")
+		}
+		for _, s := range wave {
+			placed[s.Resource.Ref()] = true
+		}
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+// Action is what applying a plan does to a resource
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	// ActionKeep leaves a resource that is already recorded in the state
+	ActionKeep Action = "keep"
+)
+
+// Change is a planned action on one resource
+type Change struct {
+	Action    Action   `json:"action"`
+	Resource  Resource `json:"resource"`
+	Wave      int      `json:"wave"`
+	DependsOn []Ref    `json:"depends_on,omitempty"`
+}
+
+// String formats the change for CLI output
+func (c Change) String() string {
+	sign := "+"
+	if c.Action == ActionKeep {
+		sign = "="
+	}
+	return fmt.Sprintf("%s %s", sign, c.Resource)
+}
+
+// Preview returns the changes applying the plan would make to a manifest, in
+// wave order; m nil means nothing is recorded yet
+func (p *Plan) Preview(m *Manifest) ([]Change, error) {
+	waves, err := p.Waves()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for i, wave := range waves {
+		for _, s := range wave {
+			change := Change{Action: ActionCreate, Resource: s.Resource, Wave: i + 1, DependsOn: s.DependsOn}
+			if m != nil {
+				if recorded, ok := m.Get(s.Resource.Kind, s.Resource.Name); ok {
+					change.Action, change.Resource = ActionKeep, recorded
+				}
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// Result is the outcome of applying a plan
+type Result struct {
+	Manifest *Manifest
+	// Created lists the resources created by this apply that still exist
+	Created []Resource
+	// RolledBack lists the resources deleted again after a failure
+	RolledBack []Resource
+}
+
+// Applier creates the resources of a plan that are not recorded yet, wave by
+// wave with bounded parallelism, recording each in the store as it is created.
+// If any creation fails or the apply is canceled, the resources created by
+// this apply are deleted again, so a failed setup leaves the environment as it
+// was; resources recorded by earlier runs or imported are never rolled back.
+type Applier struct {
+	Store Store
+
+	// Run executes the aws CLI for rollback; nil uses os/exec
+	Run CommandRunner
+
+	// Parallelism bounds concurrent creations; zero uses DefaultParallelism
+	Parallelism int
+
+	// KeepOnFailure disables rollback, leaving created resources recorded
+	// so a rerun resumes where the apply stopped
+	KeepOnFailure bool
+}
+
+// Apply applies a plan
+func (a *Applier) Apply(ctx context.Context, p *Plan) (*Result, error) {
+	waves, err := p.Waves()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := a.Store.Load(ctx, p.Environment)
+	if errors.Is(err, ErrNotFound) {
+		m = NewManifest(p.Environment, p.Region)
+	} else if err != nil {
+		return nil, err
+	}
+	if m.Region != p.Region {
+		return nil, fmt.Errorf("environment %s is recorded in region %s; tear it down before moving it to %s", p.Environment, m.Region, p.Region)
+	}
+
+	tracker := progress.FromContext(ctx)
+	tracker.AddTotal(len(p.Steps))
+
+	result := &Result{Manifest: m}
+	var mu sync.Mutex
+	var errs []error
+
+	for _, wave := range waves {
+		if err := a.applyWave(ctx, wave, m, result, &mu, &errs); err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return result, nil
+	}
+
+	applyErr := errors.Join(errs...)
+	if a.KeepOnFailure || len(result.Created) == 0 {
+		return result, applyErr
+	}
+	if err := a.rollback(ctx, result); err != nil {
+		return result, errors.Join(applyErr, err)
+	}
+	return result, applyErr
+}
+
+// applyWave creates the unrecorded resources of one wave in parallel. The
+// first failure cancels the creations still running.
+func (a *Applier) applyWave(ctx context.Context, wave []*Step, m *Manifest, result *Result, mu *sync.Mutex, errs *[]error) error {
+	parallelism := a.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	waveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tracker := progress.FromContext(ctx)
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for _, s := range wave {
+		mu.Lock()
+		recorded := m.Has(s.Resource.Kind, s.Resource.Name)
+		deps := make([]Resource, 0, len(s.DependsOn))
+		for _, dep := range s.DependsOn {
+			r, _ := m.Get(dep.Kind, dep.Name)
+			deps = append(deps, r)
+		}
+		mu.Unlock()
+
+		if recorded {
+			tracker.Advance(1)
+			continue
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-waveCtx.Done():
+		}
+		if waveCtx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(s *Step, deps []Resource) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			tracker.Step(s.Resource.Ref().String())
+			arn, err := s.Create(waveCtx, deps)
+			tracker.Advance(1)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				// Creations interrupted by another failure or cancellation
+				// are not failures of their own
+				if waveCtx.Err() == nil {
+					*errs = append(*errs, fmt.Errorf("failed to create %s: %w", s.Resource.Ref(), err))
+					cancel()
+				}
+				return
+			}
+
+			r := s.Resource
+			r.ARN = arn
+			r.CreatedAt = time.Now().UTC()
+			m.Record(r)
+			result.Created = append(result.Created, r)
+			if err := a.Store.Save(ctx, m); err != nil {
+				*errs = append(*errs, err)
+				cancel()
+			}
+		}(s, deps)
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// rollback deletes the resources created by the apply, dependents first.
+// Resources that cannot be deleted stay recorded for a later teardown.
+func (a *Applier) rollback(ctx context.Context, result *Result) error {
+	// Roll back even if the apply was canceled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	tracker := progress.FromContext(ctx)
+	tracker.Message("rolling back %d created resource(s)", len(result.Created))
+
+	created := &Manifest{Resources: result.Created}
+	teardown := &Teardown{Store: a.Store, Run: a.Run}
+	m := result.Manifest
+
+	var errs []error
+	var kept []Resource
+	for _, r := range created.TeardownOrder() {
+		if err := teardown.delete(ctx, r); err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to roll back %s: %w", r, err))
+			kept = append(kept, r)
+			continue
+		}
+		m.Remove(r.Kind, r.Name)
+		result.RolledBack = append(result.RolledBack, r)
+		if err := a.Store.Save(ctx, m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	result.Created = kept
+
+	if len(m.Resources) == 0 {
+		if err := a.Store.Delete(ctx, m.Environment); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
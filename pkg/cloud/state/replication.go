@@ -0,0 +1,387 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+)
+
+// ReplicationPath records the replicated state buckets and which one is
+// active
+const ReplicationPath = ".apm/replication.json"
+
+// ReplicationRPO is the replication time S3 Replication Time Control
+// commits to for 99.99% of objects, and so the recovery point objective of
+// a failover
+const ReplicationRPO = 15 * time.Minute
+
+// heartbeatKey is written to the active bucket by every health check; its
+// age in the standby bucket is how far replication is behind
+const heartbeatKey = ".apm-replication-heartbeat"
+
+// Replication rule IDs, one per direction
+const (
+	ruleToReplica = "apm-to-replica"
+	ruleToPrimary = "apm-to-primary"
+)
+
+// Bucket is an S3 bucket in a region
+type Bucket struct {
+	Name   string `json:"name"`
+	Region string `json:"region"`
+}
+
+// Replication replicates a state or configuration bucket to a bucket in
+// another region, both ways, so the tooling keeps working from the replica
+// during a regional outage and its writes flow back once the primary region
+// recovers.
+type Replication struct {
+	Primary Bucket `json:"primary"`
+	Replica Bucket `json:"replica"`
+
+	// Prefix limits replication to the keys under it; empty replicates the
+	// whole bucket
+	Prefix string `json:"prefix,omitempty"`
+
+	// RoleARN is the IAM role S3 assumes to replicate objects
+	RoleARN string `json:"roleArn"`
+
+	// FailedOver is set while the replica is active
+	FailedOver bool      `json:"failedOver"`
+	ChangedAt  time.Time `json:"changedAt,omitempty"`
+
+	// Run executes the aws CLI; nil uses os/exec
+	Run CommandRunner `json:"-"`
+}
+
+// Active returns the bucket the tooling uses
+func (r *Replication) Active() Bucket {
+	if r.FailedOver {
+		return r.Replica
+	}
+	return r.Primary
+}
+
+// Standby returns the bucket replicated to
+func (r *Replication) Standby() Bucket {
+	if r.FailedOver {
+		return r.Primary
+	}
+	return r.Replica
+}
+
+// Validate checks the buckets and role
+func (r *Replication) Validate() error {
+	if r.Primary.Name == "" || r.Replica.Name == "" {
+		return fmt.Errorf("primary and replica buckets are required")
+	}
+	if r.Primary.Region == "" || r.Replica.Region == "" {
+		return fmt.Errorf("primary and replica regions are required")
+	}
+	if r.Primary.Region == r.Replica.Region {
+		return fmt.Errorf("the replica must be in another region than %s", r.Primary.Region)
+	}
+	if r.RoleARN == "" {
+		return fmt.Errorf("a replication role is required")
+	}
+	return nil
+}
+
+func (r *Replication) aws(ctx context.Context, region string, args ...string) ([]byte, error) {
+	run := r.Run
+	if run == nil {
+		run = cmdrun.Exec
+	}
+	return run(ctx, "aws", append(args, "--region", region)...)
+}
+
+// Setup enables versioning on both buckets, which replication requires, and
+// replicates each to the other with Replication Time Control and its
+// metrics. Objects replicated into a bucket are not replicated back.
+func (r *Replication) Setup(ctx context.Context) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	for _, b := range []Bucket{r.Primary, r.Replica} {
+		if _, err := r.aws(ctx, b.Region, "s3api", "put-bucket-versioning", "--bucket", b.Name,
+			"--versioning-configuration", "Status=Enabled"); err != nil {
+			return fmt.Errorf("failed to enable versioning on %s: %w", b.Name, err)
+		}
+	}
+	for _, d := range []struct {
+		from, to Bucket
+		rule     string
+	}{{r.Primary, r.Replica, ruleToReplica}, {r.Replica, r.Primary, ruleToPrimary}} {
+		config, err := json.Marshal(r.replicationConfiguration(d.to, d.rule))
+		if err != nil {
+			return err
+		}
+		if _, err := r.aws(ctx, d.from.Region, "s3api", "put-bucket-replication", "--bucket", d.from.Name,
+			"--replication-configuration", string(config)); err != nil {
+			return fmt.Errorf("failed to replicate %s to %s: %w", d.from.Name, d.to.Name, err)
+		}
+	}
+	return nil
+}
+
+// replicationConfiguration is the put-bucket-replication document
+// replicating to a bucket
+func (r *Replication) replicationConfiguration(to Bucket, rule string) map[string]interface{} {
+	minutes := int(ReplicationRPO / time.Minute)
+	return map[string]interface{}{
+		"Role": r.RoleARN,
+		"Rules": []map[string]interface{}{{
+			"ID":                      rule,
+			"Status":                  "Enabled",
+			"Priority":                1,
+			"Filter":                  map[string]string{"Prefix": r.Prefix},
+			"DeleteMarkerReplication": map[string]string{"Status": "Enabled"},
+			"Destination": map[string]interface{}{
+				"Bucket":          "arn:aws:s3:::" + to.Name,
+				"ReplicationTime": map[string]interface{}{"Status": "Enabled", "Time": map[string]int{"Minutes": minutes}},
+				"Metrics":         map[string]interface{}{"Status": "Enabled", "EventThreshold": map[string]int{"Minutes": minutes}},
+			},
+		}},
+	}
+}
+
+// ReplicaHealth is the outcome of a replication health check
+type ReplicaHealth struct {
+	Active  Bucket `json:"active"`
+	Standby Bucket `json:"standby"`
+
+	Reachable   bool `json:"reachable"`
+	Versioned   bool `json:"versioned"`
+	Replicating bool `json:"replicating"`
+
+	// Lag is the age of the newest heartbeat found in the standby bucket,
+	// an upper bound of how far it is behind; zero when none has arrived
+	Lag time.Duration `json:"lagSeconds"`
+
+	// LatencySeconds and PendingOperations are the S3 replication metrics
+	// of the last 15 minutes; nil when S3 has not published them
+	LatencySeconds    *float64 `json:"latencySeconds"`
+	PendingOperations *float64 `json:"pendingOperations"`
+
+	Problems []string `json:"problems"`
+}
+
+// Healthy reports whether the standby can take over within the RPO
+func (h *ReplicaHealth) Healthy() bool {
+	return len(h.Problems) == 0
+}
+
+// MarshalJSON reports the lag in seconds
+func (h ReplicaHealth) MarshalJSON() ([]byte, error) {
+	type plain ReplicaHealth
+	return json.Marshal(struct {
+		plain
+		Lag     float64 `json:"lagSeconds"`
+		Healthy bool    `json:"healthy"`
+	}{plain(h), h.Lag.Seconds(), h.Healthy()})
+}
+
+// Check checks that the standby bucket is reachable, versioned, and
+// receiving the active bucket's writes within the RPO. It reads the
+// heartbeat replicated from the previous check and writes the next one, so
+// run it regularly, e.g. from cron.
+func (r *Replication) Check(ctx context.Context, now time.Time) (*ReplicaHealth, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	active, standby := r.Active(), r.Standby()
+	health := &ReplicaHealth{Active: active, Standby: standby, Problems: []string{}}
+	problem := func(format string, args ...interface{}) {
+		health.Problems = append(health.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, err := r.aws(ctx, standby.Region, "s3api", "head-bucket", "--bucket", standby.Name); err != nil {
+		problem("standby bucket %s is unreachable: %v", standby.Name, err)
+		return health, nil
+	}
+	health.Reachable = true
+
+	var versioning struct {
+		Status string `json:"Status"`
+	}
+	if output, err := r.aws(ctx, standby.Region, "s3api", "get-bucket-versioning", "--bucket", standby.Name); err != nil {
+		problem("cannot read versioning of %s: %v", standby.Name, err)
+	} else if json.Unmarshal(output, &versioning) == nil && versioning.Status == "Enabled" {
+		health.Versioned = true
+	} else {
+		problem("versioning is not enabled on %s", standby.Name)
+	}
+
+	rule := ruleToReplica
+	if r.FailedOver {
+		rule = ruleToPrimary
+	}
+	if output, err := r.aws(ctx, active.Region, "s3api", "get-bucket-replication", "--bucket", active.Name); err != nil {
+		problem("%s is not replicated: %v", active.Name, err)
+	} else {
+		var replication struct {
+			ReplicationConfiguration struct {
+				Rules []struct {
+					ID     string `json:"ID"`
+					Status string `json:"Status"`
+				} `json:"Rules"`
+			} `json:"ReplicationConfiguration"`
+		}
+		_ = json.Unmarshal(output, &replication)
+		for _, rl := range replication.ReplicationConfiguration.Rules {
+			if rl.ID == rule && rl.Status == "Enabled" {
+				health.Replicating = true
+			}
+		}
+		if !health.Replicating {
+			problem("replication rule %s of %s is missing or disabled", rule, active.Name)
+		}
+	}
+
+	key := path.Join(strings.Trim(r.Prefix, "/"), heartbeatKey)
+	if output, err := r.aws(ctx, standby.Region, "s3", "cp", "s3://"+standby.Name+"/"+key, "-"); err == nil {
+		if sent, err := time.Parse(time.RFC3339, strings.TrimSpace(string(output))); err == nil {
+			health.Lag = now.Sub(sent)
+			if health.Lag > 2*ReplicationRPO {
+				// Checks run at least every RPO, so an older heartbeat is stuck
+				problem("newest heartbeat in %s is %s old, beyond the %s RPO", standby.Name, health.Lag.Round(time.Second), ReplicationRPO)
+			}
+		}
+	} else if !isNotFound(err) {
+		problem("cannot read the heartbeat in %s: %v", standby.Name, err)
+	}
+
+	health.LatencySeconds = r.metric(ctx, active, standby, rule, "ReplicationLatency", now)
+	health.PendingOperations = r.metric(ctx, active, standby, rule, "OperationsPendingReplication", now)
+	if health.LatencySeconds != nil && *health.LatencySeconds > ReplicationRPO.Seconds() {
+		problem("replication latency %.0fs exceeds the %s RPO", *health.LatencySeconds, ReplicationRPO)
+	}
+
+	if err := r.writeHeartbeat(ctx, active, key, now); err != nil {
+		problem("cannot write the heartbeat to %s: %v", active.Name, err)
+	}
+	return health, nil
+}
+
+// metric returns the maximum of an S3 replication metric over the last 15
+// minutes, or nil without datapoints
+func (r *Replication) metric(ctx context.Context, from, to Bucket, rule, name string, now time.Time) *float64 {
+	output, err := r.aws(ctx, from.Region, "cloudwatch", "get-metric-statistics",
+		"--namespace", "AWS/S3",
+		"--metric-name", name,
+		"--dimensions", "Name=SourceBucket,Value="+from.Name, "Name=DestinationBucket,Value="+to.Name, "Name=RuleId,Value="+rule,
+		"--start-time", now.Add(-ReplicationRPO).UTC().Format(time.RFC3339),
+		"--end-time", now.UTC().Format(time.RFC3339),
+		"--period", "60",
+		"--statistics", "Maximum")
+	if err != nil {
+		return nil
+	}
+	var stats struct {
+		Datapoints []struct {
+			Maximum float64 `json:"Maximum"`
+		} `json:"Datapoints"`
+	}
+	if json.Unmarshal(output, &stats) != nil || len(stats.Datapoints) == 0 {
+		return nil
+	}
+	max := stats.Datapoints[0].Maximum
+	for _, d := range stats.Datapoints[1:] {
+		if d.Maximum > max {
+			max = d.Maximum
+		}
+	}
+	return &max
+}
+
+func (r *Replication) writeHeartbeat(ctx context.Context, bucket Bucket, key string, now time.Time) error {
+	tmp, err := os.CreateTemp("", "apm-heartbeat-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(now.UTC().Format(time.RFC3339)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	_, err = r.aws(ctx, bucket.Region, "s3", "cp", tmp.Name(), "s3://"+bucket.Name+"/"+key)
+	return err
+}
+
+// Failover makes the standby bucket active. Unless force is set, the
+// standby must pass a health check first; during a regional outage the
+// active bucket is unreachable, so only the standby's own checks apply.
+func (r *Replication) Failover(ctx context.Context, now time.Time, force bool) (*ReplicaHealth, error) {
+	health, err := r.Check(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	if !force && !health.Reachable {
+		return health, fmt.Errorf("standby bucket %s is unreachable; use force to fail over anyway", health.Standby.Name)
+	}
+	if !force && !health.Versioned {
+		return health, fmt.Errorf("standby bucket %s is not versioned; use force to fail over anyway", health.Standby.Name)
+	}
+	r.FailedOver = !r.FailedOver
+	r.ChangedAt = now.UTC()
+	return health, nil
+}
+
+// Resolve returns the store for a location, using the active bucket when
+// the location names either bucket of the replication
+func (r *Replication) Resolve(location, region string) (Store, error) {
+	store, err := ParseStore(location, region)
+	if err != nil {
+		return nil, err
+	}
+	s3, ok := store.(*S3Store)
+	if !ok || (s3.Bucket != r.Primary.Name && s3.Bucket != r.Replica.Name) {
+		return store, nil
+	}
+	active := r.Active()
+	s3.Bucket, s3.Region = active.Name, active.Region
+	return s3, nil
+}
+
+// LoadReplication reads a replication record; nil without one
+func LoadReplication(file string) (*Replication, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	var r Replication
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid replication record %s: %w", file, err)
+	}
+	return &r, nil
+}
+
+// Save writes the replication record atomically
+func (r *Replication) Save(file string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return os.Rename(tmp, file)
+}
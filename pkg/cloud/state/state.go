@@ -0,0 +1,167 @@
+// Package state records the cloud monitoring resources APM creates or adopts, so
+// that setup can be rerun without duplicating resources and teardown removes
+// exactly what is managed. The manifest is stored locally or in S3 and
+// teardown uses the aws CLI, so the package has no dependency on pkg/cloud.
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+)
+
+// ManifestVersion is the current manifest format version
+const ManifestVersion = 1
+
+// Kind is the type of a recorded resource
+type Kind string
+
+const (
+	KindSNSTopic     Kind = "sns_topic"
+	KindLogGroup     Kind = "log_group"
+	KindMetricFilter Kind = "metric_filter"
+	KindDashboard    Kind = "dashboard"
+	KindAlarm        Kind = "alarm"
+	KindEventRule    Kind = "event_rule"
+
+	// EventBridge connections and API destinations deliver alerts to webhooks
+	KindConnection     Kind = "event_connection"
+	KindAPIDestination Kind = "api_destination"
+)
+
+// CreationOrder lists kinds so that every resource is created after the
+// resources it references; teardown runs in the reverse order
+var CreationOrder = []Kind{KindSNSTopic, KindLogGroup, KindMetricFilter, KindDashboard, KindAlarm, KindConnection, KindAPIDestination, KindEventRule}
+
+// Resource is a resource created by a monitoring setup or imported into it
+type Resource struct {
+	Kind   Kind   `json:"kind"`
+	Name   string `json:"name"`
+	ARN    string `json:"arn,omitempty"`
+	Region string `json:"region"`
+	// Parent is the log group of a metric filter
+	Parent string `json:"parent,omitempty"`
+	// Imported marks pre-existing resources adopted into the state
+	Imported  bool      `json:"imported,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// String formats the resource for CLI output
+func (r Resource) String() string {
+	s := fmt.Sprintf("%s %s in %s", r.Kind, r.Name, r.Region)
+	if r.Parent != "" {
+		s = fmt.Sprintf("%s %s (%s) in %s", r.Kind, r.Name, r.Parent, r.Region)
+	}
+	if r.Imported {
+		s += " (imported)"
+	}
+	return s
+}
+
+// Manifest is the recorded state of one environment's monitoring setup
+type Manifest struct {
+	Version     int        `json:"version"`
+	Environment string     `json:"environment"`
+	Region      string     `json:"region"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Resources   []Resource `json:"resources"`
+}
+
+// NewManifest creates an empty manifest
+func NewManifest(environment, region string) *Manifest {
+	now := time.Now().UTC()
+	return &Manifest{
+		Version:     ManifestVersion,
+		Environment: environment,
+		Region:      region,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Resources:   []Resource{},
+	}
+}
+
+// Get returns the recorded resource of a kind and name
+func (m *Manifest) Get(kind Kind, name string) (Resource, bool) {
+	for _, r := range m.Resources {
+		if r.Kind == kind && r.Name == name {
+			return r, true
+		}
+	}
+	return Resource{}, false
+}
+
+// Has reports whether a resource of a kind and name is recorded
+func (m *Manifest) Has(kind Kind, name string) bool {
+	_, ok := m.Get(kind, name)
+	return ok
+}
+
+// Record adds a resource, replacing an existing record of the same kind and name
+func (m *Manifest) Record(r Resource) {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	if r.Region == "" {
+		r.Region = m.Region
+	}
+	m.UpdatedAt = time.Now().UTC()
+
+	for i := range m.Resources {
+		if m.Resources[i].Kind == r.Kind && m.Resources[i].Name == r.Name {
+			m.Resources[i] = r
+			return
+		}
+	}
+	m.Resources = append(m.Resources, r)
+}
+
+// Remove deletes the record of a resource
+func (m *Manifest) Remove(kind Kind, name string) {
+	for i, r := range m.Resources {
+		if r.Kind == kind && r.Name == name {
+			m.Resources = append(m.Resources[:i], m.Resources[i+1:]...)
+			m.UpdatedAt = time.Now().UTC()
+			return
+		}
+	}
+}
+
+// TeardownOrder returns the resources in the order they can be deleted:
+// dependents before the resources they reference, newest first within a kind
+func (m *Manifest) TeardownOrder() []Resource {
+	rank := make(map[Kind]int, len(CreationOrder))
+	for i, k := range CreationOrder {
+		rank[k] = i
+	}
+
+	resources := append([]Resource(nil), m.Resources...)
+	sort.SliceStable(resources, func(i, j int) bool {
+		ri, rj := rank[resources[i].Kind], rank[resources[j].Kind]
+		if ri != rj {
+			return ri > rj
+		}
+		return resources[i].CreatedAt.After(resources[j].CreatedAt)
+	})
+	return resources
+}
+
+// CommandRunner runs a CLI command and returns its standard output
+type CommandRunner = cmdrun.Runner
+
+// isNotFound reports whether an aws CLI error means the resource is gone
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, marker := range []string{"ResourceNotFound", "NotFound", "NoSuchKey", "does not exist", "(404)"} {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,544 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testManifest() *Manifest {
+	m := NewManifest("staging", "us-east-1")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.Record(Resource{Kind: KindSNSTopic, Name: "apm-alerts", ARN: "arn:aws:sns:us-east-1:123456789012:apm-alerts", CreatedAt: base})
+	m.Record(Resource{Kind: KindLogGroup, Name: "/aws/apm/app", CreatedAt: base.Add(time.Second)})
+	m.Record(Resource{Kind: KindMetricFilter, Name: "APM-Error-Count", Parent: "/aws/apm/app", CreatedAt: base.Add(2 * time.Second)})
+	m.Record(Resource{Kind: KindAlarm, Name: "APM-High-CPU", CreatedAt: base.Add(3 * time.Second)})
+	m.Record(Resource{Kind: KindAlarm, Name: "APM-Service-Down", CreatedAt: base.Add(4 * time.Second)})
+	m.Record(Resource{Kind: KindEventRule, Name: "apm-deployment-events", CreatedAt: base.Add(5 * time.Second)})
+	return m
+}
+
+func TestManifest(t *testing.T) {
+	m := testManifest()
+
+	m.Record(Resource{Kind: KindAlarm, Name: "APM-High-CPU", ARN: "updated"})
+	if len(m.Resources) != 6 {
+		t.Fatalf("expected recording an existing resource to replace it, got %d resources", len(m.Resources))
+	}
+	if r, _ := m.Get(KindAlarm, "APM-High-CPU"); r.ARN != "updated" || r.Region != "us-east-1" {
+		t.Errorf("unexpected resource %+v", r)
+	}
+
+	// A dashboard and an alarm may share a name
+	if m.Has(KindDashboard, "APM-High-CPU") {
+		t.Error("expected lookups to match the kind")
+	}
+
+	m.Remove(KindAlarm, "APM-High-CPU")
+	if m.Has(KindAlarm, "APM-High-CPU") || len(m.Resources) != 5 {
+		t.Error("expected the alarm to be removed")
+	}
+}
+
+func TestTeardownOrder(t *testing.T) {
+	var names []string
+	for _, r := range testManifest().TeardownOrder() {
+		names = append(names, r.Name)
+	}
+
+	want := "apm-deployment-events,APM-Service-Down,APM-High-CPU,APM-Error-Count,/aws/apm/app,apm-alerts"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("unexpected teardown order\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+
+	if _, err := store.Load(ctx, "staging"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.Save(ctx, testManifest()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := store.Load(ctx, "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Resources) != 6 || m.Region != "us-east-1" {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+
+	if err := store.Delete(ctx, "staging"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Load(ctx, "staging"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the manifest to be deleted, got %v", err)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+	if err := store.Save(ctx, testManifest()); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	failAlarm := true
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		call := strings.Join(args, " ")
+		calls = append(calls, call)
+		switch {
+		case strings.HasPrefix(call, "events list-targets-by-rule"):
+			return []byte(`{"Targets":[{"Id":"apm-notify"}]}`), nil
+		case strings.Contains(call, "APM-Service-Down") && failAlarm:
+			return nil, errors.New("AccessDenied")
+		case strings.Contains(call, "APM-High-CPU"):
+			// Deleted out of band
+			return nil, errors.New("ResourceNotFound: alarm does not exist")
+		}
+		return nil, nil
+	}
+
+	teardown := &Teardown{Store: store, Run: run}
+	removed, err := teardown.Destroy(ctx, "staging")
+	if err == nil || !strings.Contains(err.Error(), "APM-Service-Down") {
+		t.Fatalf("expected the alarm failure to be reported, got %v", err)
+	}
+	if len(removed) != 5 {
+		t.Errorf("expected the other 5 resources to be removed, got %d", len(removed))
+	}
+	if calls[1] != "events remove-targets --rule apm-deployment-events --ids apm-notify --region us-east-1" {
+		t.Errorf("expected rule targets to be removed first, got %q", calls[1])
+	}
+
+	// Only the failed resource remains recorded, so a rerun resumes there
+	m, err := store.Load(ctx, "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Resources) != 1 || m.Resources[0].Name != "APM-Service-Down" {
+		t.Errorf("unexpected remaining resources %+v", m.Resources)
+	}
+
+	failAlarm = false
+	if _, err := teardown.Destroy(ctx, "staging"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Load(ctx, "staging"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the manifest to be deleted after a complete teardown, got %v", err)
+	}
+}
+
+func TestTeardownAlertWebhook(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+	m := NewManifest("staging", "us-east-1")
+	m.Record(Resource{Kind: KindConnection, Name: "pager-connection"})
+	m.Record(Resource{Kind: KindAPIDestination, Name: "pager"})
+	m.Record(Resource{Kind: KindEventRule, Name: "pager"})
+	if err := store.Save(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args[:2], " "))
+		if args[1] == "list-targets-by-rule" {
+			return []byte(`{"Targets":[]}`), nil
+		}
+		return nil, nil
+	}
+	if _, err := (&Teardown{Store: store, Run: run}).Destroy(ctx, "staging"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rule goes before the destination it targets, and the destination
+	// before its connection
+	want := "events list-targets-by-rule,events delete-rule,events delete-api-destination,events delete-connection"
+	if got := strings.Join(calls, ","); got != want {
+		t.Errorf("calls\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestParseStore(t *testing.T) {
+	store, err := ParseStore("s3://ops-bucket/apm/state", "eu-west-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s3, ok := store.(*S3Store)
+	if !ok || s3.url("prod") != "s3://ops-bucket/apm/state/prod-monitoring.json" {
+		t.Errorf("unexpected store %+v", store)
+	}
+
+	if _, err := ParseStore("s3://", ""); err == nil {
+		t.Error("expected an error without a bucket")
+	}
+	if _, ok := mustStore(t, "").(*LocalStore); !ok {
+		t.Error("expected a local store by default")
+	}
+}
+
+func mustStore(t *testing.T, location string) Store {
+	t.Helper()
+	store, err := ParseStore(location, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestImporter(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+
+	m := NewManifest("prod", "us-east-1")
+	m.Record(Resource{Kind: KindAlarm, Name: "APM-High-CPU"})
+	if err := store.Save(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		switch args[1] {
+		case "list-dashboards":
+			return []byte(`{"DashboardEntries":[{"DashboardName":"APM-Overview","DashboardArn":"arn:aws:cloudwatch::123456789012:dashboard/APM-Overview"}]}`), nil
+		case "describe-alarms":
+			if args[3] != "APM-" {
+				t.Errorf("expected the prefix to be sent to the API, got %v", args)
+			}
+			return []byte(`{"MetricAlarms":[{"AlarmName":"APM-High-CPU"},{"AlarmName":"APM-Disk-Full"},{"AlarmName":"APM-test-scratch"}]}`), nil
+		case "describe-log-groups":
+			return []byte(`{"logGroups":[]}`), nil
+		case "list-topics":
+			return []byte(`{"Topics":[{"TopicArn":"arn:aws:sns:us-east-1:123456789012:APM-Alerts"},{"TopicArn":"arn:aws:sns:us-east-1:123456789012:billing"}]}`), nil
+		}
+		t.Fatalf("unexpected command %v", args)
+		return nil, nil
+	}
+
+	importer := &Importer{Store: store, Run: run}
+	filter := Filter{Prefixes: []string{"APM-"}, Pattern: regexp.MustCompile(`^APM-[A-Z]`)}
+
+	adopted, err := importer.Import(ctx, "prod", "us-east-1", filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, r := range adopted {
+		if !r.Imported || r.Region != "us-east-1" {
+			t.Errorf("unexpected adopted resource %+v", r)
+		}
+		names = append(names, r.Name)
+	}
+	// Already recorded, filtered by pattern, and unmatched topics are skipped
+	if got := strings.Join(names, ","); got != "APM-Overview,APM-Disk-Full,APM-Alerts" {
+		t.Errorf("unexpected adopted resources %s", got)
+	}
+
+	m, _ = store.Load(ctx, "prod")
+	if len(m.Resources) != 4 {
+		t.Errorf("expected 4 recorded resources, got %d", len(m.Resources))
+	}
+	if r, _ := m.Get(KindSNSTopic, "APM-Alerts"); r.ARN != "arn:aws:sns:us-east-1:123456789012:APM-Alerts" {
+		t.Errorf("expected the topic ARN to be recorded for teardown, got %+v", r)
+	}
+
+	// Importing again adopts nothing new
+	if adopted, err := importer.Import(ctx, "prod", "us-east-1", filter); err != nil || len(adopted) != 0 {
+		t.Errorf("expected a repeated import to be a no-op, got %v %v", adopted, err)
+	}
+
+	if _, err := importer.Import(ctx, "prod", "eu-west-1", filter); err == nil {
+		t.Error("expected an error importing into a different region")
+	}
+	if err := (Filter{}).Validate(); err == nil {
+		t.Error("expected an error for an empty filter")
+	}
+}
+
+func testPlan(create func(name string) CreateFunc) *Plan {
+	topic := Ref{Kind: KindSNSTopic, Name: "apm-alerts"}
+	group := Ref{Kind: KindLogGroup, Name: "/aws/apm/app"}
+
+	p := NewPlan("staging", "us-east-1")
+	p.Add(Resource{Kind: KindAlarm, Name: "APM-High-CPU"}, create("APM-High-CPU"), topic)
+	p.Add(Resource{Kind: KindSNSTopic, Name: "apm-alerts"}, create("apm-alerts"))
+	p.Add(Resource{Kind: KindLogGroup, Name: "/aws/apm/app"}, create("/aws/apm/app"))
+	p.Add(Resource{Kind: KindMetricFilter, Name: "APM-Error-Count", Parent: "/aws/apm/app"}, create("APM-Error-Count"), group)
+	p.Add(Resource{Kind: KindDashboard, Name: "APM-Overview"}, create("APM-Overview"))
+	p.Add(Resource{Kind: KindAlarm, Name: "APM-Service-Down"}, create("APM-Service-Down"), topic)
+	return p
+}
+
+func TestPlanPreview(t *testing.T) {
+	p := testPlan(func(string) CreateFunc { return nil })
+
+	m := NewManifest("staging", "us-east-1")
+	m.Record(Resource{Kind: KindSNSTopic, Name: "apm-alerts", ARN: "arn:topic", Imported: true})
+
+	changes, err := p.Preview(m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, fmt.Sprintf("%d%s", c.Wave, c))
+	}
+	want := "1= sns_topic apm-alerts in us-east-1 (imported)," +
+		"1+ log_group /aws/apm/app in us-east-1," +
+		"1+ dashboard APM-Overview in us-east-1," +
+		"2+ alarm APM-High-CPU in us-east-1," +
+		"2+ metric_filter APM-Error-Count (/aws/apm/app) in us-east-1," +
+		"2+ alarm APM-Service-Down in us-east-1"
+	if strings.Join(got, ",") != want {
+		t.Errorf("unexpected plan\n got: %s\nwant: %s", strings.Join(got, ","), want)
+	}
+
+	p.Add(Resource{Kind: KindEventRule, Name: "apm-events"}, nil, Ref{Kind: KindSNSTopic, Name: "missing"})
+	if _, err := p.Preview(nil); err == nil {
+		t.Error("expected an error for a dependency that is not planned")
+	}
+}
+
+func TestApplierRollback(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+
+	// An imported dashboard is kept through the rollback
+	m := NewManifest("staging", "us-east-1")
+	m.Record(Resource{Kind: KindDashboard, Name: "APM-Overview", Imported: true})
+	if err := store.Save(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	var deps []Resource
+	create := func(name string) CreateFunc {
+		return func(ctx context.Context, d []Resource) (string, error) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			if name == "APM-High-CPU" {
+				deps = d
+			}
+			mu.Unlock()
+			defer func() { mu.Lock(); running--; mu.Unlock() }()
+
+			time.Sleep(10 * time.Millisecond)
+			if name == "APM-Service-Down" {
+				return "", errors.New("LimitExceeded: alarm quota exceeded")
+			}
+			return "arn:" + name, nil
+		}
+	}
+
+	var deleted []string
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		deleted = append(deleted, args[1])
+		return nil, nil
+	}
+
+	applier := &Applier{Store: store, Run: run, Parallelism: 1}
+	result, err := applier.Apply(ctx, testPlan(create))
+	if err == nil || !strings.Contains(err.Error(), "APM-Service-Down") {
+		t.Fatalf("expected the alarm failure to be reported, got %v", err)
+	}
+	if maxRunning != 1 {
+		t.Errorf("expected creations to respect the parallelism, got %d concurrent", maxRunning)
+	}
+	if len(deps) != 1 || deps[0].ARN != "arn:apm-alerts" {
+		t.Errorf("expected the alarm to receive the created topic, got %+v", deps)
+	}
+
+	// Dependents are deleted before the resources they reference
+	if got := strings.Join(deleted, ","); got != "delete-alarms,delete-metric-filter,delete-log-group,delete-topic" {
+		t.Errorf("unexpected rollback %s", got)
+	}
+	if len(result.RolledBack) != 4 || len(result.Created) != 0 {
+		t.Errorf("expected 4 resources rolled back, got %+v", result)
+	}
+
+	m, err = store.Load(ctx, "staging")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Resources) != 1 || !m.Has(KindDashboard, "APM-Overview") {
+		t.Errorf("expected only the imported dashboard to remain, got %+v", m.Resources)
+	}
+}
+
+func TestApplierKeepOnFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+
+	fail := true
+	create := func(name string) CreateFunc {
+		return func(ctx context.Context, _ []Resource) (string, error) {
+			if name == "APM-Service-Down" && fail {
+				return "", errors.New("LimitExceeded")
+			}
+			return "arn:" + name, nil
+		}
+	}
+
+	applier := &Applier{Store: store, KeepOnFailure: true}
+	if _, err := applier.Apply(ctx, testPlan(create)); err == nil {
+		t.Fatal("expected an error")
+	}
+	m, err := store.Load(ctx, "staging")
+	if err != nil || m.Has(KindAlarm, "APM-Service-Down") || !m.Has(KindSNSTopic, "apm-alerts") {
+		t.Fatalf("expected the created resources to stay recorded, got %+v %v", m, err)
+	}
+
+	// A rerun creates only what is missing
+	fail = false
+	result, err := applier.Apply(ctx, testPlan(create))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Created) != 1 || result.Created[0].Name != "APM-Service-Down" {
+		t.Errorf("expected only the failed alarm to be created, got %+v", result.Created)
+	}
+}
+
+func testReplication(run CommandRunner) *Replication {
+	return &Replication{
+		Primary: Bucket{Name: "apm-state", Region: "us-east-1"},
+		Replica: Bucket{Name: "apm-state-replica", Region: "us-west-2"},
+		Prefix:  "apm",
+		RoleARN: "arn:aws:iam::123456789012:role/apm-replication",
+		Run:     run,
+	}
+}
+
+func TestReplicationSetup(t *testing.T) {
+	var calls []string
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return nil, nil
+	}
+	if err := testReplication(run).Setup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 4 || !strings.HasPrefix(calls[1], "s3api put-bucket-versioning --bucket apm-state-replica") {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+	for i, want := range []string{`"Bucket":"arn:aws:s3:::apm-state-replica"`, `"Bucket":"arn:aws:s3:::apm-state"`} {
+		call := calls[2+i]
+		if !strings.Contains(call, want) || !strings.Contains(call, `"ReplicationTime":{"Status":"Enabled","Time":{"Minutes":15}}`) {
+			t.Errorf("unexpected replication %s", call)
+		}
+	}
+
+	invalid := testReplication(run)
+	invalid.Replica.Region = "us-east-1"
+	if err := invalid.Setup(context.Background()); err == nil {
+		t.Error("expected a replica in the same region to be rejected")
+	}
+}
+
+func TestReplicationCheck(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	heartbeat := now.Add(-5 * time.Minute)
+	var wrote []string
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		call := strings.Join(args, " ")
+		switch {
+		case strings.HasPrefix(call, "s3api get-bucket-versioning"):
+			return []byte(`{"Status":"Enabled"}`), nil
+		case strings.HasPrefix(call, "s3api get-bucket-replication --bucket apm-state "):
+			return []byte(`{"ReplicationConfiguration":{"Rules":[{"ID":"apm-to-replica","Status":"Enabled"}]}}`), nil
+		case strings.HasPrefix(call, "s3 cp s3://apm-state-replica/apm/.apm-replication-heartbeat -"):
+			return []byte(heartbeat.Format(time.RFC3339)), nil
+		case strings.Contains(call, "--metric-name ReplicationLatency"):
+			return []byte(`{"Datapoints":[{"Maximum":40},{"Maximum":95}]}`), nil
+		case strings.Contains(call, "get-metric-statistics"):
+			return []byte(`{"Datapoints":[]}`), nil
+		case strings.HasPrefix(call, "s3 cp "):
+			wrote = append(wrote, args[3])
+		}
+		return nil, nil
+	}
+
+	r := testReplication(run)
+	health, err := r.Check(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Healthy() || health.Lag != 5*time.Minute || *health.LatencySeconds != 95 || health.PendingOperations != nil {
+		t.Errorf("unexpected health %+v", health)
+	}
+	if len(wrote) != 1 || wrote[0] != "s3://apm-state/apm/.apm-replication-heartbeat" {
+		t.Errorf("expected the next heartbeat in the active bucket, got %q", wrote)
+	}
+
+	heartbeat = now.Add(-time.Hour)
+	if health, _ := r.Check(context.Background(), now); health.Healthy() {
+		t.Error("expected a stale heartbeat to be reported")
+	}
+}
+
+func TestReplicationFailover(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	primaryDown := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		call := strings.Join(args, " ")
+		switch {
+		case strings.Contains(call, "us-east-1"):
+			return nil, errors.New("Could not connect to the endpoint URL")
+		case strings.HasPrefix(call, "s3api get-bucket-versioning"):
+			return []byte(`{"Status":"Enabled"}`), nil
+		}
+		return nil, nil
+	}
+
+	r := testReplication(primaryDown)
+	health, err := r.Failover(ctx, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Healthy() || !r.FailedOver || r.Active().Region != "us-west-2" {
+		t.Errorf("expected to fail over despite the unreachable primary, got %+v", health)
+	}
+
+	file := t.TempDir() + "/replication.json"
+	if err := r.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReplication(file)
+	if err != nil || !loaded.FailedOver {
+		t.Fatalf("loaded %+v, %v", loaded, err)
+	}
+	store, _ := loaded.Resolve("s3://apm-state/apm", "us-east-1")
+	if s3 := store.(*S3Store); s3.Bucket != "apm-state-replica" || s3.Region != "us-west-2" || s3.Prefix != "apm" {
+		t.Errorf("expected the replica to be used, got %+v", s3)
+	}
+	if store, _ := loaded.Resolve("s3://other/apm", "us-east-1"); store.(*S3Store).Bucket != "other" {
+		t.Error("expected other buckets to be left alone")
+	}
+
+	replicaDown := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return nil, errors.New("Could not connect to the endpoint URL")
+	}
+	r = testReplication(replicaDown)
+	if _, err := r.Failover(ctx, now, false); err == nil || r.FailedOver {
+		t.Error("expected failing over to an unreachable replica to be refused")
+	}
+	if missing, err := LoadReplication(t.TempDir() + "/none.json"); missing != nil || err != nil {
+		t.Errorf("expected no record, got %+v, %v", missing, err)
+	}
+}
@@ -0,0 +1,181 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+)
+
+// ErrNotFound is returned when no manifest is stored for an environment
+var ErrNotFound = errors.New("no monitoring state found")
+
+// DefaultDir is the default directory of LocalStore
+const DefaultDir = ".apm/state"
+
+// Store persists manifests per environment
+type Store interface {
+	Load(ctx context.Context, environment string) (*Manifest, error)
+	Save(ctx context.Context, m *Manifest) error
+	Delete(ctx context.Context, environment string) error
+}
+
+// fileName is the manifest file name of an environment
+func fileName(environment string) string {
+	return environment + "-monitoring.json"
+}
+
+// decode parses and checks a stored manifest
+func decode(data []byte, environment string) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid monitoring state for %s: %w", environment, err)
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("monitoring state for %s has version %d; upgrade apm to read it", environment, m.Version)
+	}
+	return &m, nil
+}
+
+// LocalStore keeps manifests as JSON files in a directory
+type LocalStore struct {
+	// Dir defaults to DefaultDir
+	Dir string
+}
+
+func (s *LocalStore) path(environment string) string {
+	dir := s.Dir
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return filepath.Join(dir, fileName(environment))
+}
+
+// Load implements Store
+func (s *LocalStore) Load(ctx context.Context, environment string) (*Manifest, error) {
+	data, err := os.ReadFile(s.path(environment))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read monitoring state: %w", err)
+	}
+	return decode(data, environment)
+}
+
+// Save implements Store, replacing the file atomically
+func (s *LocalStore) Save(ctx context.Context, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := s.path(m.Environment)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write monitoring state: %w", err)
+	}
+	return os.Rename(tmp, file)
+}
+
+// Delete implements Store
+func (s *LocalStore) Delete(ctx context.Context, environment string) error {
+	err := os.Remove(s.path(environment))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// S3Store keeps manifests in an S3 bucket so a team shares one record
+type S3Store struct {
+	Bucket string
+	Prefix string
+	Region string
+
+	// Run executes the aws CLI; nil uses os/exec
+	Run CommandRunner
+}
+
+func (s *S3Store) url(environment string) string {
+	return "s3://" + s.Bucket + "/" + path.Join(strings.Trim(s.Prefix, "/"), fileName(environment))
+}
+
+func (s *S3Store) run(ctx context.Context, args ...string) ([]byte, error) {
+	if s.Region != "" {
+		args = append(args, "--region", s.Region)
+	}
+	run := s.Run
+	if run == nil {
+		run = cmdrun.Exec
+	}
+	return run(ctx, "aws", args...)
+}
+
+// Load implements Store
+func (s *S3Store) Load(ctx context.Context, environment string) (*Manifest, error) {
+	data, err := s.run(ctx, "s3", "cp", s.url(environment), "-")
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read monitoring state from %s: %w", s.url(environment), err)
+	}
+	return decode(data, environment)
+}
+
+// Save implements Store
+func (s *S3Store) Save(ctx context.Context, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "apm-state-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if _, err := s.run(ctx, "s3", "cp", tmp.Name(), s.url(m.Environment), "--content-type", "application/json"); err != nil {
+		return fmt.Errorf("failed to write monitoring state to %s: %w", s.url(m.Environment), err)
+	}
+	return nil
+}
+
+// Delete implements Store
+func (s *S3Store) Delete(ctx context.Context, environment string) error {
+	if _, err := s.run(ctx, "s3", "rm", s.url(environment)); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete monitoring state: %w", err)
+	}
+	return nil
+}
+
+// ParseStore returns the store for a location: an s3://bucket/prefix URL
+// or a local directory; empty uses DefaultDir
+func ParseStore(location, region string) (Store, error) {
+	if strings.HasPrefix(location, "s3://") {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid state location %q: missing bucket", location)
+		}
+		return &S3Store{Bucket: bucket, Prefix: prefix, Region: region}, nil
+	}
+	return &LocalStore{Dir: location}, nil
+}
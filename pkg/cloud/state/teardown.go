@@ -0,0 +1,143 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+	"github.com/chaksack/apm/pkg/progress"
+)
+
+// Teardown deletes the resources recorded for an environment, and nothing
+// else. Each deletion is saved to the store as it happens, so an interrupted
+// teardown resumes where it stopped; resources already deleted out of band
+// count as removed. The manifest is deleted once it is empty.
+type Teardown struct {
+	Store Store
+
+	// Run executes the aws CLI; nil uses os/exec
+	Run CommandRunner
+}
+
+// Destroy tears down an environment, returning the resources removed
+func (t *Teardown) Destroy(ctx context.Context, environment string) ([]Resource, error) {
+	m, err := t.Store.Load(ctx, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := progress.FromContext(ctx)
+	resources := m.TeardownOrder()
+	tracker.AddTotal(len(resources))
+
+	var removed []Resource
+	var errs []error
+	for _, r := range resources {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		tracker.Step(string(r.Kind) + " " + r.Name)
+
+		err := t.delete(ctx, r)
+		tracker.Advance(1)
+		if err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("%s: %w", r, err))
+			continue
+		}
+
+		m.Remove(r.Kind, r.Name)
+		removed = append(removed, r)
+		if err := t.Store.Save(ctx, m); err != nil {
+			return removed, err
+		}
+	}
+
+	if len(errs) > 0 {
+		return removed, errors.Join(errs...)
+	}
+	return removed, t.Store.Delete(ctx, environment)
+}
+
+// Preview returns the resources Destroy would delete, in order
+func (t *Teardown) Preview(ctx context.Context, environment string) ([]Resource, error) {
+	m, err := t.Store.Load(ctx, environment)
+	if err != nil {
+		return nil, err
+	}
+	return m.TeardownOrder(), nil
+}
+
+func (t *Teardown) aws(ctx context.Context, region string, args ...string) ([]byte, error) {
+	run := t.Run
+	if run == nil {
+		run = cmdrun.Exec
+	}
+	return run(ctx, "aws", append(args, "--region", region)...)
+}
+
+// delete removes one resource with the aws CLI
+func (t *Teardown) delete(ctx context.Context, r Resource) error {
+	switch r.Kind {
+	case KindDashboard:
+		_, err := t.aws(ctx, r.Region, "cloudwatch", "delete-dashboards", "--dashboard-names", r.Name)
+		return err
+	case KindAlarm:
+		_, err := t.aws(ctx, r.Region, "cloudwatch", "delete-alarms", "--alarm-names", r.Name)
+		return err
+	case KindLogGroup:
+		_, err := t.aws(ctx, r.Region, "logs", "delete-log-group", "--log-group-name", r.Name)
+		return err
+	case KindMetricFilter:
+		_, err := t.aws(ctx, r.Region, "logs", "delete-metric-filter", "--log-group-name", r.Parent, "--filter-name", r.Name)
+		return err
+	case KindSNSTopic:
+		if r.ARN == "" {
+			return fmt.Errorf("topic ARN not recorded")
+		}
+		_, err := t.aws(ctx, r.Region, "sns", "delete-topic", "--topic-arn", r.ARN)
+		return err
+	case KindEventRule:
+		return t.deleteEventRule(ctx, r)
+	case KindAPIDestination:
+		_, err := t.aws(ctx, r.Region, "events", "delete-api-destination", "--name", r.Name)
+		return err
+	case KindConnection:
+		_, err := t.aws(ctx, r.Region, "events", "delete-connection", "--name", r.Name)
+		return err
+	default:
+		return fmt.Errorf("unknown resource kind %q", r.Kind)
+	}
+}
+
+// deleteEventRule removes the rule's targets, which EventBridge requires
+// before the rule itself can be deleted
+func (t *Teardown) deleteEventRule(ctx context.Context, r Resource) error {
+	output, err := t.aws(ctx, r.Region, "events", "list-targets-by-rule", "--rule", r.Name)
+	if err != nil {
+		return err
+	}
+
+	var targets struct {
+		Targets []struct {
+			ID string `json:"Id"`
+		} `json:"Targets"`
+	}
+	if err := json.Unmarshal(output, &targets); err != nil {
+		return fmt.Errorf("failed to parse rule targets: %w", err)
+	}
+
+	if len(targets.Targets) > 0 {
+		args := []string{"events", "remove-targets", "--rule", r.Name, "--ids"}
+		for _, target := range targets.Targets {
+			args = append(args, target.ID)
+		}
+		if _, err := t.aws(ctx, r.Region, args...); err != nil {
+			return err
+		}
+	}
+
+	_, err = t.aws(ctx, r.Region, "events", "delete-rule", "--name", r.Name)
+	return err
+}
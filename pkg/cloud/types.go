@@ -3,6 +3,9 @@ package cloud
 import (
 	"context"
 	"time"
+
+	"github.com/chaksack/apm/pkg/proxy"
+	"github.com/chaksack/apm/pkg/residency"
 )
 
 // Provider represents a cloud provider type
@@ -102,7 +105,18 @@ type ProviderConfig struct {
 	EnableCache     bool              `json:"enable_cache"`
 	CacheDuration   time.Duration     `json:"cache_duration"`
 	CustomEndpoints map[string]string `json:"custom_endpoints,omitempty"`
-	Logger          Logger            `json:"-"` // Logger function for debugging
+	Proxy           *proxy.Config     `json:"proxy,omitempty"` // Proxy and CA settings for API calls and CLIs
+	Residency       *residency.Guard  `json:"-"`               // Restricts regions for data residency
+	Logger          Logger            `json:"-"`               // Logger function for debugging
+}
+
+// region returns the region an operation acts in: its own when set, the
+// default region otherwise
+func (c *ProviderConfig) region(region string) string {
+	if region != "" {
+		return region
+	}
+	return c.DefaultRegion
 }
 
 // CloudProvider interface for all cloud providers
@@ -141,6 +155,12 @@ type CLIDetector interface {
 	GetInstallInstructions() string
 }
 
+// CLIContextDetector is a CLIDetector whose detection stops when ctx is done
+type CLIContextDetector interface {
+	CLIDetector
+	DetectContext(ctx context.Context) (*CLIStatus, error)
+}
+
 // CredentialManager interface for managing credentials
 type CredentialManager interface {
 	Store(credentials *Credentials) error
@@ -19,6 +19,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/chaksack/apm/pkg/proxy"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -506,7 +507,10 @@ func (cfm *ConfigFileManager) getConfigFilename(provider Provider, environment s
 }
 
 // CrossPlatformUtils provides cross-platform compatibility utilities
-type CrossPlatformUtils struct{}
+type CrossPlatformUtils struct {
+	// Proxy settings applied to every command; nil inherits the process environment
+	Proxy *proxy.Config
+}
 
 // NewCrossPlatformUtils creates a new cross-platform utils instance
 func NewCrossPlatformUtils() *CrossPlatformUtils {
@@ -644,6 +648,7 @@ func (cpu *CrossPlatformUtils) StreamCommand(ctx context.Context, name string, a
 func (cpu *CrossPlatformUtils) configureCommand(cmd *exec.Cmd) {
 	// Platform-specific configurations can be added here
 	// For now, we'll keep it simple and avoid Windows-specific syscall attributes
+	cmd.Env = append(cmd.Env, cpu.Proxy.CommandEnv()...)
 }
 
 // commandReader wraps command output with proper cleanup
@@ -0,0 +1,26 @@
+// Package cmdrun runs external CLI commands such as aws and kubectl. The
+// packages driving them take a Runner, so tests can replace the CLI with a
+// fake.
+package cmdrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs a CLI command and returns its standard output
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Exec runs commands with os/exec, including stderr in errors so that
+// failures such as missing resources can be recognized
+func Exec(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return output, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return output, err
+}
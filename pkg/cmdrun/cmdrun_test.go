@@ -0,0 +1,23 @@
+package cmdrun
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	output, err := Exec(context.Background(), "sh", "-c", "echo out")
+	if err != nil || strings.TrimSpace(string(output)) != "out" {
+		t.Errorf("output %q, err %v", output, err)
+	}
+
+	_, err = Exec(context.Background(), "sh", "-c", "echo 'NoSuchKey: gone' >&2; exit 1")
+	if err == nil || !strings.Contains(err.Error(), "NoSuchKey: gone") {
+		t.Errorf("err = %v, want stderr in it", err)
+	}
+}
@@ -0,0 +1,213 @@
+package demo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func newTestSimulator(t *testing.T, scenario string, cfg Config) (*Simulator, *tracetest.InMemoryExporter, *prometheus.Registry) {
+	t.Helper()
+	s, err := Lookup(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	reg := prometheus.NewRegistry()
+	cfg.Scenario, cfg.Exporter, cfg.Registerer, cfg.Seed = s, exporter, reg, 1
+	sim, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim, exporter, reg
+}
+
+// simulate runs n flows in the given phase of the scenario and flushes
+// the spans and logs. Shutting down would reset the in-memory exporter.
+func simulate(t *testing.T, sim *Simulator, phase string, n int) {
+	t.Helper()
+	var p Phase
+	for _, candidate := range sim.cfg.Scenario.Phases {
+		if candidate.Name == phase {
+			p = candidate
+		}
+	}
+	if p.Name == "" {
+		t.Fatalf("no phase %q", phase)
+	}
+	at := time.Now()
+	for i := 0; i < n; i++ {
+		sim.Simulate(p, at)
+		at = at.Add(200 * time.Millisecond)
+	}
+	if err := sim.processor.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.flushLogs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulateCorrelatesServices(t *testing.T) {
+	var logs bytes.Buffer
+	sim, exporter, reg := newTestSimulator(t, "checkout", Config{Logs: &logs})
+	simulate(t, sim, "steady", 200)
+
+	spans := exporter.GetSpans()
+	services := make(map[string]map[string]bool)
+	for _, span := range spans {
+		trace := span.SpanContext.TraceID().String()
+		if services[trace] == nil {
+			services[trace] = make(map[string]bool)
+		}
+		for _, kv := range span.Resource.Attributes() {
+			if kv.Key == semconv.ServiceNameKey {
+				services[trace][kv.Value.AsString()] = true
+			}
+		}
+	}
+	if len(services) != 200 {
+		t.Errorf("%d traces, want 200", len(services))
+	}
+	deepest := 0
+	for _, names := range services {
+		deepest = max(deepest, len(names))
+	}
+	if deepest < 5 {
+		t.Errorf("deepest trace spans %d services, want checkouts to span at least 5", deepest)
+	}
+
+	if n := testutil.CollectAndCount(reg, "http_requests_total"); n == 0 {
+		t.Error("no request metrics")
+	}
+	if n := testutil.CollectAndCount(reg, "db_query_duration_seconds"); n == 0 {
+		t.Error("no query metrics")
+	}
+
+	// Every log line carries the trace it belongs to
+	scanner := bufio.NewScanner(&logs)
+	lines := 0
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q: %v", scanner.Text(), err)
+		}
+		trace, _ := line["trace_id"].(string)
+		if services[trace] == nil {
+			t.Errorf("log line %s belongs to no trace", scanner.Text())
+		}
+		lines++
+	}
+	if lines == 0 {
+		t.Error("no log lines")
+	}
+}
+
+func TestFailuresScenario(t *testing.T) {
+	sim, exporter, reg := newTestSimulator(t, "failures", Config{})
+	simulate(t, sim, "payment-outage", 300)
+
+	failed := 0
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "POST /checkout" && span.Status.Code == codes.Error {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Error("no checkouts failed during the payment outage")
+	}
+
+	if ratio := requests(reg, "5xx") / requests(reg, ""); ratio <= 0.05 {
+		t.Errorf("error ratio = %.3f, want above the HighErrorRate threshold of 5%%", ratio)
+	}
+}
+
+func TestSlowDBScenario(t *testing.T) {
+	var logs bytes.Buffer
+	sim, exporter, _ := newTestSimulator(t, "slow-db", Config{Logs: &logs})
+	simulate(t, sim, "slow-queries", 100)
+
+	slow := 0
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "SELECT inventory" && span.EndTime.Sub(span.StartTime) > time.Second {
+			slow++
+		}
+	}
+	if slow == 0 {
+		t.Error("inventory queries did not slow down")
+	}
+	if !bytes.Contains(logs.Bytes(), []byte(`"msg":"slow query"`)) {
+		t.Error("slow queries not logged")
+	}
+}
+
+func TestPhaseAt(t *testing.T) {
+	s, _ := Lookup("failures")
+	for elapsed, want := range map[time.Duration]string{
+		0:                "steady",
+		6 * time.Minute:  "payment-outage",
+		16 * time.Minute: "steady",
+	} {
+		if got := s.PhaseAt(elapsed).Name; got != want {
+			t.Errorf("PhaseAt(%v) = %s, want %s", elapsed, got, want)
+		}
+	}
+}
+
+func TestLokiPush(t *testing.T) {
+	var pushed struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&pushed)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sim, _, _ := newTestSimulator(t, "checkout", Config{Loki: &Loki{URL: server.URL}})
+	simulate(t, sim, "steady", 20)
+
+	if len(pushed.Streams) == 0 {
+		t.Fatal("nothing pushed")
+	}
+	for _, stream := range pushed.Streams {
+		if stream.Stream["job"] != "apm-demo" || stream.Stream["service"] == "" || len(stream.Values) == 0 {
+			t.Errorf("stream = %+v", stream)
+		}
+	}
+}
+
+// requests sums http_requests_total over the series with the
+// given status class, or all series when status is empty
+func requests(reg *prometheus.Registry, status string) float64 {
+	families, _ := reg.Gather()
+	sum := 0.0
+	for _, family := range families {
+		if family.GetName() != "http_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "status" && (status == "" || label.GetValue() == status) {
+					sum += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return sum
+}
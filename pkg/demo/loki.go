@@ -0,0 +1,73 @@
+package demo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Loki pushes log lines to Loki's push API
+type Loki struct {
+	URL    string
+	Client *http.Client
+	// Job is the job label of the streams, apm-demo by default
+	Job string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// push sends entries in one request, one stream per service and level
+func (l *Loki) push(ctx context.Context, entries []logEntry) error {
+	job := l.Job
+	if job == "" {
+		job = "apm-demo"
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	streams := make(map[[2]string]*lokiStream)
+	var order []*lokiStream
+	for _, e := range entries {
+		key := [2]string{e.Service, e.Level}
+		stream := streams[key]
+		if stream == nil {
+			stream = &lokiStream{Stream: map[string]string{"job": job, "service": e.Service, "level": e.Level}}
+			streams[key] = stream
+			order = append(order, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(e.line())})
+	}
+
+	body, err := json.Marshal(map[string]any{"streams": order})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(l.URL, "/")+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push logs to Loki: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("loki answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
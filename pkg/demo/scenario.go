@@ -0,0 +1,273 @@
+// Package demo simulates a small fleet of services that send correlated
+// traces, metrics, and logs to the APM stack, so dashboards and alerts can
+// be explored before any real application is instrumented.
+package demo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Kind is what a call does
+type Kind string
+
+const (
+	// KindServer is an HTTP request served by a service
+	KindServer Kind = "server"
+	// KindClient is an HTTP request to a third party outside the fleet
+	KindClient Kind = "client"
+	// KindDB is a database query
+	KindDB Kind = "db"
+	// KindCache is a cache lookup
+	KindCache Kind = "cache"
+)
+
+// Call is one operation of a fake service and the calls it makes
+// downstream, in order
+type Call struct {
+	Service string
+	// Name names the span
+	Name string
+	Kind Kind
+	// Method and Route describe server and client calls
+	Method string
+	Route  string
+	// System is the database, cache, or third party called, such as
+	// postgresql or redis
+	System string
+	// Statement is the query of database and cache calls
+	Statement string
+	// Latency is the median time the call takes, not counting its children
+	Latency time.Duration
+	// ErrorRate is the fraction of calls that fail on their own
+	ErrorRate float64
+	// Optional calls do not fail their caller, such as cache misses
+	Optional bool
+	Calls    []Call
+}
+
+// Flow is a user journey through the fleet, started at its root call
+type Flow struct {
+	Name string
+	// Weight is how often the flow is started relative to the others
+	Weight int
+	// Attribute names a business identifier, such as order.id, given a new
+	// value for every run of the flow and attached to all its spans and logs
+	Attribute string
+	Root      Call
+}
+
+// Fault makes the matching calls fail or slow down
+type Fault struct {
+	Service string
+	// Operation is the span name to match, every call of the service when
+	// empty
+	Operation string
+	ErrorRate float64
+	Latency   time.Duration
+	// Message is the error calls fail with
+	Message string
+}
+
+// Phase is a stretch of a scenario with a set of faults
+type Phase struct {
+	Name        string
+	Description string
+	Duration    time.Duration
+	Faults      []Fault
+}
+
+// Scenario is a fleet of services, the flows through it, and phases that
+// inject faults. Phases repeat for as long as the demo runs.
+type Scenario struct {
+	Name        string
+	Description string
+	Flows       []Flow
+	Phases      []Phase
+}
+
+// Services returns the names of the services of the scenario
+func (s *Scenario) Services() []string {
+	seen := make(map[string]bool)
+	var walk func(Call)
+	walk = func(c Call) {
+		seen[c.Service] = true
+		for _, child := range c.Calls {
+			walk(child)
+		}
+	}
+	for _, flow := range s.Flows {
+		walk(flow.Root)
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PhaseAt returns the phase the scenario is in after running for elapsed
+func (s *Scenario) PhaseAt(elapsed time.Duration) Phase {
+	var cycle time.Duration
+	for _, p := range s.Phases {
+		cycle += p.Duration
+	}
+	if cycle <= 0 {
+		return Phase{Name: "steady"}
+	}
+	elapsed %= cycle
+	for _, p := range s.Phases {
+		if elapsed < p.Duration {
+			return p
+		}
+		elapsed -= p.Duration
+	}
+	return s.Phases[len(s.Phases)-1]
+}
+
+// Scenarios returns the built-in scenarios
+func Scenarios() []*Scenario {
+	return []*Scenario{
+		{
+			Name:        "checkout",
+			Description: "A healthy web shop: browsing, carts, and checkouts across six services",
+			Flows:       shopFlows(),
+			Phases:      []Phase{{Name: "steady", Description: "Normal traffic", Duration: time.Hour}},
+		},
+		{
+			Name:        "failures",
+			Description: "The payment provider starts failing checkouts, firing the HighErrorRate alert",
+			Flows:       shopFlows(),
+			Phases: []Phase{
+				{Name: "steady", Description: "Normal traffic", Duration: 5 * time.Minute},
+				{
+					Name:        "payment-outage",
+					Description: "Stripe answers 503 to most charges",
+					Duration:    10 * time.Minute,
+					Faults: []Fault{{
+						Service: "payment", Operation: "POST api.stripe.com",
+						ErrorRate: 0.6, Latency: 800 * time.Millisecond,
+						Message: "stripe: 503 Service Unavailable",
+					}},
+				},
+			},
+		},
+		{
+			Name:        "slow-db",
+			Description: "Inventory queries slow down after a bad index change, firing the HighLatency alert",
+			Flows:       shopFlows(),
+			Phases: []Phase{
+				{Name: "steady", Description: "Normal traffic", Duration: 5 * time.Minute},
+				{
+					Name:        "slow-queries",
+					Description: "Stock queries scan the whole inventory table",
+					Duration:    10 * time.Minute,
+					Faults: []Fault{{
+						Service: "inventory", Operation: "SELECT inventory",
+						Latency: 1200 * time.Millisecond, ErrorRate: 0.02,
+						Message: "pq: canceling statement due to statement timeout",
+					}},
+				},
+			},
+		},
+	}
+}
+
+// Lookup returns the built-in scenario with the given name
+func Lookup(name string) (*Scenario, error) {
+	for _, s := range Scenarios() {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown scenario %q", name)
+}
+
+// shopFlows describes a web shop: a frontend in front of catalog and
+// checkout services, with checkout reserving stock and charging payments
+func shopFlows() []Flow {
+	cacheGet := func(service, key string) Call {
+		return Call{
+			Service: service, Name: "GET " + key, Kind: KindCache, System: "redis",
+			Statement: "GET " + key + ":*", Latency: time.Millisecond, ErrorRate: 0.002, Optional: true,
+		}
+	}
+	query := func(service, name, statement string, latency time.Duration) Call {
+		return Call{
+			Service: service, Name: name, Kind: KindDB, System: "postgresql",
+			Statement: statement, Latency: latency, ErrorRate: 0.001,
+		}
+	}
+
+	browse := Call{
+		Service: "frontend", Name: "GET /products", Kind: KindServer, Method: "GET", Route: "/products",
+		Latency: 8 * time.Millisecond,
+		Calls: []Call{{
+			Service: "catalog", Name: "GET /api/products", Kind: KindServer, Method: "GET", Route: "/api/products",
+			Latency: 5 * time.Millisecond, ErrorRate: 0.002,
+			Calls: []Call{
+				cacheGet("catalog", "products"),
+				query("catalog", "SELECT products", "SELECT id, name, price FROM products WHERE category = $1 LIMIT 50", 12*time.Millisecond),
+			},
+		}},
+	}
+
+	cart := Call{
+		Service: "frontend", Name: "POST /cart", Kind: KindServer, Method: "POST", Route: "/cart",
+		Latency: 6 * time.Millisecond,
+		Calls: []Call{
+			{
+				Service: "catalog", Name: "GET /api/products/:id", Kind: KindServer, Method: "GET", Route: "/api/products/:id",
+				Latency: 3 * time.Millisecond, ErrorRate: 0.002,
+				Calls: []Call{cacheGet("catalog", "product")},
+			},
+			{
+				Service: "inventory", Name: "GET /api/stock/:sku", Kind: KindServer, Method: "GET", Route: "/api/stock/:sku",
+				Latency: 3 * time.Millisecond,
+				Calls: []Call{
+					query("inventory", "SELECT inventory", "SELECT quantity FROM inventory WHERE sku = $1", 6*time.Millisecond),
+				},
+			},
+		},
+	}
+
+	checkout := Call{
+		Service: "frontend", Name: "POST /checkout", Kind: KindServer, Method: "POST", Route: "/checkout",
+		Latency: 10 * time.Millisecond,
+		Calls: []Call{{
+			Service: "checkout", Name: "POST /api/orders", Kind: KindServer, Method: "POST", Route: "/api/orders",
+			Latency: 15 * time.Millisecond, ErrorRate: 0.003,
+			Calls: []Call{
+				{
+					Service: "inventory", Name: "POST /api/reservations", Kind: KindServer, Method: "POST", Route: "/api/reservations",
+					Latency: 5 * time.Millisecond, ErrorRate: 0.002,
+					Calls: []Call{
+						query("inventory", "SELECT inventory", "SELECT quantity FROM inventory WHERE sku = ANY($1) FOR UPDATE", 8*time.Millisecond),
+						query("inventory", "UPDATE inventory", "UPDATE inventory SET quantity = quantity - $2 WHERE sku = $1", 5*time.Millisecond),
+					},
+				},
+				{
+					Service: "payment", Name: "POST /api/charges", Kind: KindServer, Method: "POST", Route: "/api/charges",
+					Latency: 6 * time.Millisecond,
+					Calls: []Call{{
+						Service: "payment", Name: "POST api.stripe.com", Kind: KindClient, Method: "POST", Route: "/v1/charges",
+						System: "api.stripe.com", Latency: 180 * time.Millisecond, ErrorRate: 0.005,
+					}},
+				},
+				query("checkout", "INSERT orders", "INSERT INTO orders (id, customer_id, total) VALUES ($1, $2, $3)", 4*time.Millisecond),
+				{
+					Service: "notification", Name: "POST /api/emails", Kind: KindServer, Method: "POST", Route: "/api/emails",
+					Latency: 25 * time.Millisecond, ErrorRate: 0.01, Optional: true,
+				},
+			},
+		}},
+	}
+
+	return []Flow{
+		{Name: "browse", Weight: 6, Root: browse},
+		{Name: "add-to-cart", Weight: 3, Root: cart},
+		{Name: "checkout", Weight: 1, Attribute: "order.id", Root: checkout},
+	}
+}
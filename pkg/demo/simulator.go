@@ -0,0 +1,456 @@
+package demo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultRate is how many flows are started per second by default
+	DefaultRate = 5.0
+	// DefaultEnvironment is the deployment environment of the fake services
+	DefaultEnvironment = "demo"
+
+	// networkLatency is the time a request spends on the wire each way
+	networkLatency = 500 * time.Microsecond
+	// slowQuery is how long a query takes before it is logged as slow
+	slowQuery = 500 * time.Millisecond
+)
+
+// Config configures a simulation
+type Config struct {
+	Scenario *Scenario
+	// Rate is how many flows are started per second
+	Rate float64
+	// Exporter receives the spans; without one, spans only correlate logs
+	Exporter sdktrace.SpanExporter
+	// Registerer registers the metrics of the services, nil means
+	// prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
+	// Logs receives the log lines of the services as JSON, one per line
+	Logs io.Writer
+	// Loki receives the log lines too, when set
+	Loki *Loki
+	// Environment is the deployment environment of the services
+	Environment string
+	// Seed makes runs repeatable; zero seeds from the clock
+	Seed int64
+	// OnPhase is called when the scenario enters a phase
+	OnPhase func(Phase)
+	Logger  *zap.Logger
+}
+
+// Simulator runs the services of a scenario
+type Simulator struct {
+	cfg       Config
+	rand      *rand.Rand
+	flows     []Flow
+	weight    int
+	processor sdktrace.SpanProcessor
+	tracers   map[string]trace.Tracer
+	metrics   map[string]*instrumentation.MetricsCollector
+	queries   map[string]*prometheus.HistogramVec
+	logger    *zap.Logger
+
+	mu      sync.Mutex
+	pending []logEntry
+}
+
+// New creates a simulator for the scenario of cfg
+func New(cfg Config) (*Simulator, error) {
+	if cfg.Scenario == nil || len(cfg.Scenario.Flows) == 0 {
+		return nil, errors.New("demo: scenario has no flows")
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = DefaultRate
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+	if cfg.Environment == "" {
+		cfg.Environment = DefaultEnvironment
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zap.L()
+	}
+
+	s := &Simulator{
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+		flows:   cfg.Scenario.Flows,
+		tracers: make(map[string]trace.Tracer),
+		metrics: make(map[string]*instrumentation.MetricsCollector),
+		queries: make(map[string]*prometheus.HistogramVec),
+		logger:  cfg.Logger,
+	}
+	for _, flow := range s.flows {
+		s.weight += max(flow.Weight, 1)
+	}
+	if cfg.Exporter != nil {
+		s.processor = sdktrace.NewBatchSpanProcessor(cfg.Exporter)
+	}
+
+	// Every service gets its own resource and its own copy of the metrics,
+	// told apart by a service label
+	for _, name := range cfg.Scenario.Services() {
+		res := resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(name),
+			semconv.ServiceVersion("1.0.0"),
+			semconv.DeploymentEnvironment(cfg.Environment),
+		)
+		opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res), sdktrace.WithSampler(sdktrace.AlwaysSample())}
+		if s.processor != nil {
+			opts = append(opts, sdktrace.WithSpanProcessor(s.processor))
+		}
+		s.tracers[name] = sdktrace.NewTracerProvider(opts...).Tracer("github.com/chaksack/apm/pkg/demo")
+
+		reg := prometheus.WrapRegistererWith(prometheus.Labels{"service": name}, cfg.Registerer)
+		s.metrics[name] = instrumentation.NewMetricsCollectorFor(reg, "", "")
+		s.queries[name] = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Database and cache query duration in seconds",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"system", "operation"})
+	}
+	return s, nil
+}
+
+// Run starts flows at the configured rate until ctx is done, then flushes
+// the spans and logs
+func (s *Simulator) Run(ctx context.Context) error {
+	start := time.Now()
+	phase := ""
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	next := time.NewTimer(0)
+	defer next.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return s.Shutdown(shutdownCtx)
+		case <-flush.C:
+			if err := s.flushLogs(ctx); err != nil {
+				s.logger.Warn("Failed to push demo logs to Loki", zap.Error(err))
+			}
+		case now := <-next.C:
+			p := s.cfg.Scenario.PhaseAt(now.Sub(start))
+			if p.Name != phase {
+				phase = p.Name
+				if s.cfg.OnPhase != nil {
+					s.cfg.OnPhase(p)
+				}
+			}
+			s.Simulate(p, now)
+			// Arrivals are a Poisson process
+			next.Reset(time.Duration(s.rand.ExpFloat64() / s.cfg.Rate * float64(time.Second)))
+		}
+	}
+}
+
+// Shutdown flushes the spans and logs
+func (s *Simulator) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s.processor != nil {
+		errs = append(errs, s.processor.Shutdown(ctx))
+	}
+	errs = append(errs, s.flushLogs(ctx))
+	return errors.Join(errs...)
+}
+
+// Simulate runs one flow, picked by weight, starting at the given time
+// with the faults of phase. It returns the trace ID of the flow.
+func (s *Simulator) Simulate(phase Phase, at time.Time) trace.TraceID {
+	pick := s.rand.Intn(s.weight)
+	flow := &s.flows[len(s.flows)-1]
+	for i := range s.flows {
+		if pick -= max(s.flows[i].Weight, 1); pick < 0 {
+			flow = &s.flows[i]
+			break
+		}
+	}
+
+	run := &flowRun{phase: phase}
+	if flow.Attribute != "" {
+		run.attribute = attribute.String(flow.Attribute, fmt.Sprintf("%s-%08x", strings.SplitN(flow.Attribute, ".", 2)[0], s.rand.Uint32()))
+	}
+	return s.call(context.Background(), run, &flow.Root, at).span.TraceID()
+}
+
+// flowRun is one run of a flow
+type flowRun struct {
+	phase     Phase
+	attribute attribute.KeyValue
+}
+
+// outcome is how a call ended
+type outcome struct {
+	span   trace.SpanContext
+	end    time.Time
+	status int
+	err    error
+}
+
+// call simulates c starting at start, with its children, and records its
+// span, metrics, and logs
+func (s *Simulator) call(ctx context.Context, run *flowRun, c *Call, start time.Time) outcome {
+	errorRate, extra, message := c.ErrorRate, time.Duration(0), ""
+	for _, f := range run.phase.Faults {
+		if f.Service == c.Service && (f.Operation == "" || f.Operation == c.Name) {
+			errorRate += f.ErrorRate
+			extra += f.Latency
+			if f.Message != "" {
+				message = f.Message
+			}
+		}
+	}
+	self := s.latency(c.Latency) + extra
+
+	ctx, span := s.tracers[c.Service].Start(ctx, c.Name,
+		trace.WithTimestamp(start),
+		trace.WithSpanKind(spanKind(c.Kind)),
+		trace.WithAttributes(s.attributes(run, c)...),
+	)
+
+	// Half the call's own time is spent before its children and half after
+	t := start.Add(self / 2)
+	status := 200
+	var err error
+	for i := range c.Calls {
+		child := &c.Calls[i]
+		var out outcome
+		if child.Kind == KindServer && child.Service != c.Service {
+			out = s.remote(ctx, run, c.Service, child, t)
+		} else {
+			out = s.call(ctx, run, child, t)
+		}
+		t = out.end
+		if out.err != nil && !child.Optional {
+			// The caller gives up on the rest of its work
+			err = fmt.Errorf("%s: %w", child.Name, out.err)
+			status = 500
+			if child.Kind == KindServer || child.Kind == KindClient {
+				status = 502
+			}
+			break
+		}
+	}
+	if err == nil && s.rand.Float64() < errorRate {
+		if message == "" {
+			message = defaultErrors[c.Kind]
+		}
+		err = errors.New(message)
+		status = 500
+		if c.Kind == KindClient {
+			status = 503
+		}
+	}
+	end := t.Add(self - self/2)
+
+	if c.Kind == KindServer || c.Kind == KindClient {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	}
+	if err != nil {
+		span.RecordError(err, trace.WithTimestamp(end))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+
+	s.record(run, c, span.SpanContext(), start, end, status, err)
+	return outcome{span: span.SpanContext(), end: end, status: status, err: err}
+}
+
+// remote simulates an HTTP request from the caller service to another
+// service of the fleet, with a client span on the caller's side
+func (s *Simulator) remote(ctx context.Context, run *flowRun, caller string, c *Call, start time.Time) outcome {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(c.Method),
+		semconv.PeerService(c.Service),
+		semconv.ServerAddress(c.Service),
+	}
+	if run.attribute.Valid() {
+		attrs = append(attrs, run.attribute)
+	}
+	ctx, span := s.tracers[caller].Start(ctx, c.Method+" "+c.Service,
+		trace.WithTimestamp(start),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	out := s.call(ctx, run, c, start.Add(networkLatency))
+	out.end = out.end.Add(networkLatency)
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(out.status))
+	if out.err != nil {
+		span.SetStatus(codes.Error, fmt.Sprintf("%s answered %d", c.Service, out.status))
+	}
+	span.End(trace.WithTimestamp(out.end))
+	return out
+}
+
+// defaultErrors are the errors calls fail with outside of faults
+var defaultErrors = map[Kind]string{
+	KindServer: "internal error",
+	KindClient: "upstream returned 503 Service Unavailable",
+	KindDB:     "pq: deadlock detected",
+	KindCache:  "redis: connection pool timeout",
+}
+
+func spanKind(kind Kind) trace.SpanKind {
+	switch kind {
+	case KindServer:
+		return trace.SpanKindServer
+	default:
+		return trace.SpanKindClient
+	}
+}
+
+func (s *Simulator) attributes(run *flowRun, c *Call) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	switch c.Kind {
+	case KindServer:
+		attrs = append(attrs, semconv.HTTPRequestMethodKey.String(c.Method), semconv.HTTPRoute(c.Route))
+	case KindClient:
+		attrs = append(attrs, semconv.HTTPRequestMethodKey.String(c.Method), semconv.PeerService(c.System), semconv.ServerAddress(c.System))
+	case KindDB, KindCache:
+		attrs = append(attrs, semconv.DBSystemKey.String(c.System), semconv.DBStatement(c.Statement))
+	}
+	if run.attribute.Valid() {
+		attrs = append(attrs, run.attribute)
+	}
+	return attrs
+}
+
+// latency returns a duration around median, log-normally distributed with
+// an occasional slow outlier
+func (s *Simulator) latency(median time.Duration) time.Duration {
+	d := float64(median) * math.Exp(0.35*s.rand.NormFloat64())
+	if s.rand.Float64() < 0.01 {
+		d *= 4
+	}
+	return time.Duration(d)
+}
+
+// record records the metrics and logs of a finished call
+func (s *Simulator) record(run *flowRun, c *Call, sc trace.SpanContext, start, end time.Time, status int, err error) {
+	took := end.Sub(start)
+	entry := logEntry{
+		Time:    end,
+		Service: c.Service,
+		Level:   "info",
+		Fields: map[string]any{
+			"trace_id":   sc.TraceID().String(),
+			"span_id":    sc.SpanID().String(),
+			"latency_ms": float64(took.Microseconds()) / 1000,
+		},
+	}
+	if run.attribute.Valid() {
+		entry.Fields[strings.ReplaceAll(string(run.attribute.Key), ".", "_")] = run.attribute.Value.AsString()
+	}
+	if err != nil {
+		entry.Level = "error"
+		entry.Fields["error"] = err.Error()
+	}
+
+	switch c.Kind {
+	case KindServer:
+		s.metrics[c.Service].RecordHTTPRequest(c.Method, c.Route, status, took)
+		entry.Message = "request completed"
+		if err != nil {
+			entry.Message = "request failed"
+		}
+		entry.Fields["method"] = c.Method
+		entry.Fields["route"] = c.Route
+		entry.Fields["status"] = status
+	case KindClient:
+		if err == nil {
+			return
+		}
+		entry.Message = "upstream request failed"
+		entry.Fields["peer"] = c.System
+		entry.Fields["status"] = status
+	case KindDB, KindCache:
+		s.queries[c.Service].WithLabelValues(c.System, c.Name).Observe(took.Seconds())
+		switch {
+		case err != nil:
+			entry.Message = "query failed"
+		case took >= slowQuery:
+			entry.Level = "warn"
+			entry.Message = "slow query"
+		default:
+			return
+		}
+		entry.Fields["db_system"] = c.System
+		entry.Fields["statement"] = c.Statement
+	}
+	s.log(entry)
+}
+
+// logEntry is a log line of a service
+type logEntry struct {
+	Time    time.Time
+	Service string
+	Level   string
+	Message string
+	Fields  map[string]any
+}
+
+// line encodes the entry as a JSON log line
+func (e logEntry) line() []byte {
+	fields := make(map[string]any, len(e.Fields)+4)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	fields["ts"] = e.Time.UTC().Format(time.RFC3339Nano)
+	fields["level"] = e.Level
+	fields["service"] = e.Service
+	fields["msg"] = e.Message
+	line, _ := json.Marshal(fields)
+	return line
+}
+
+func (s *Simulator) log(entry logEntry) {
+	if s.cfg.Logs != nil {
+		_, _ = s.cfg.Logs.Write(append(entry.line(), '\n'))
+	}
+	if s.cfg.Loki != nil {
+		s.mu.Lock()
+		s.pending = append(s.pending, entry)
+		s.mu.Unlock()
+	}
+}
+
+// flushLogs pushes the pending log lines to Loki
+func (s *Simulator) flushLogs(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if s.cfg.Loki == nil || len(pending) == 0 {
+		return nil
+	}
+	return s.cfg.Loki.push(ctx, pending)
+}
@@ -0,0 +1,132 @@
+package dependency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+
+func prometheus(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if !strings.Contains(query, `dependency="api.stripe.com"`) {
+			t.Errorf("unexpected query %s", query)
+		}
+		switch r.URL.Path {
+		case "/api/v1/query_exemplars":
+			fmt.Fprint(w, `{"status":"success","data":[{"exemplars":[
+				{"labels":{"trace_id":"aaa","outcome":"error"},"value":"0.2","timestamp":100},
+				{"labels":{"trace_id":"bbb","outcome":"success"},"value":"2.5","timestamp":200},
+				{"labels":{"trace_id":"ccc","outcome":"success"},"value":"0.1","timestamp":300}]}]}`)
+		case "/api/v1/query_range":
+			// One interval breaches both SLIs
+			value := "99.99"
+			if strings.HasPrefix(query, "histogram_quantile") {
+				value = "0.3"
+			}
+			fmt.Fprintf(w, `{"status":"success","data":{"result":[{"values":[[%d,"%s"],[%d,"%s"]]}]}}`,
+				now.Add(-time.Hour).Unix(), value, now.Unix(), map[string]string{"99.99": "97.5", "0.3": "1.2"}[value])
+		default:
+			if strings.HasPrefix(query, "histogram_quantile") {
+				fmt.Fprint(w, `{"status":"success","data":{"result":[{"metric":{},"value":[0,"0.65"]}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"success","data":{"result":[
+				{"metric":{"code":"200","outcome":"success"},"value":[0,"9980"]},
+				{"metric":{"code":"503","outcome":"error"},"value":[0,"15"]},
+				{"metric":{"code":"error","outcome":"error"},"value":[0,"5"]}]}}`)
+		}
+	}))
+}
+
+func TestEvaluate(t *testing.T) {
+	server := prometheus(t)
+	defer server.Close()
+
+	e := &Evaluator{PrometheusURL: server.URL, now: func() time.Time { return now }}
+	sla := SLA{Name: "Stripe", Host: "api.stripe.com", Availability: 99.9, Latency: "500ms"}
+	report, err := e.Evaluate(context.Background(), sla, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusBreached || report.Requests != 10000 || report.Errors != 20 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if *report.Availability != 99.8 || *report.ErrorBudgetUsed < 1.99 || *report.ErrorBudgetUsed > 2.01 {
+		t.Errorf("expected 99.8%% availability using twice the budget, got %v and %v", *report.Availability, *report.ErrorBudgetUsed)
+	}
+	if len(report.Breaches) != 2 || !strings.Contains(report.Breaches[1], "p99 latency 650ms is above the 500ms SLA") {
+		t.Errorf("unexpected breaches %v", report.Breaches)
+	}
+	if !report.Start.Equal(now.Add(-30 * 24 * time.Hour)) {
+		t.Errorf("expected the default 30d window, got %s", report.Start)
+	}
+
+	ev := report.Evidence
+	if len(ev.Codes) != 3 || ev.Codes[0].Code != "200" || ev.Codes[1].Code != "503" {
+		t.Errorf("unexpected codes %+v", ev.Codes)
+	}
+	if len(ev.WorstIntervals) != 1 || !ev.WorstIntervals[0].End.Equal(now) || *ev.WorstIntervals[0].Availability != 97.5 || *ev.WorstIntervals[0].Latency != 1.2 {
+		t.Errorf("unexpected intervals %+v", ev.WorstIntervals)
+	}
+	if strings.Join(ev.FailedTraces, ",") != "aaa" || strings.Join(ev.SlowTraces, ",") != "bbb" {
+		t.Errorf("unexpected traces %v %v", ev.FailedTraces, ev.SlowTraces)
+	}
+}
+
+func TestSLAValidate(t *testing.T) {
+	for _, sla := range []SLA{
+		{Name: "no host", Availability: 99.9},
+		{Host: "a.example.com"},
+		{Host: "a.example.com", Availability: 100},
+		{Host: "a.example.com", Latency: "fast"},
+		{Host: "a.example.com", Latency: "1s", Window: "month"},
+	} {
+		if err := sla.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", sla)
+		}
+	}
+
+	sla := SLA{Host: "*.twilio.com", Latency: "1s"}
+	if err := sla.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if sla.Name != "*.twilio.com" || sla.Quantile != DefaultQuantile || sla.Window != DefaultWindow {
+		t.Errorf("expected defaults, got %+v", sla)
+	}
+	if got := sla.Matcher(); got != `dependency=~".+\\.twilio\\.com"` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	rules, err := AlertRules([]SLA{
+		{Name: "Stripe", Host: "api.stripe.com", Availability: 99.95, Latency: "800ms", Quantile: 0.95},
+		{Name: "Maps", Host: "maps.example.com", Latency: "2s"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`dependency_requests_total{dependency="api.stripe.com", outcome="error"}[1h]`,
+		`< 99.95`,
+		`histogram_quantile(0.95, sum by (le) (increase(dependency_request_duration_seconds_bucket{dependency="api.stripe.com"}[1h]))) > 0.8`,
+		`Stripe p95 latency is above its 800ms SLA`,
+		`{{ $value | humanizeDuration }}`,
+		`dependency_request_duration_seconds_bucket{dependency="maps.example.com"}[1h]))) > 2`,
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("expected %q in\n%s", want, rules)
+		}
+	}
+	if strings.Count(rules, "DependencyAvailabilityBelowSLA") != 1 {
+		t.Errorf("expected an availability rule only for Stripe:\n%s", rules)
+	}
+}
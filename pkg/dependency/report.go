@@ -0,0 +1,393 @@
+package dependency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status of a dependency against its SLA
+const (
+	StatusMet      = "met"
+	StatusBreached = "breached"
+	StatusNoData   = "no_data"
+)
+
+// maxEvidence bounds each list of evidence in a report
+const maxEvidence = 5
+
+// Report is a dependency's SLIs over the SLA window compared with its SLA
+type Report struct {
+	SLA   SLA       `json:"sla"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	Requests float64 `json:"requests"`
+	Errors   float64 `json:"errors"`
+
+	// Availability is the share of successful calls in percent
+	Availability *float64 `json:"availability,omitempty"`
+	// Latency is the SLA quantile latency in seconds
+	Latency *float64 `json:"latency_seconds,omitempty"`
+	// ErrorBudgetUsed is the share of the allowed failures used, e.g. 1.4 is
+	// 140% of the budget
+	ErrorBudgetUsed *float64 `json:"error_budget_used,omitempty"`
+
+	Status   string   `json:"status"`
+	Breaches []string `json:"breaches,omitempty"`
+
+	Evidence Evidence `json:"evidence"`
+}
+
+// Evidence supports a report with the failures and slow calls behind it
+type Evidence struct {
+	// Codes counts calls by status code, most frequent first
+	Codes []CodeCount `json:"codes,omitempty"`
+	// WorstIntervals are the sub-periods furthest from the SLA
+	WorstIntervals []Interval `json:"worst_intervals,omitempty"`
+	// FailedTraces and SlowTraces are trace IDs from exemplars, newest first
+	FailedTraces []string `json:"failed_traces,omitempty"`
+	SlowTraces   []string `json:"slow_traces,omitempty"`
+}
+
+// CodeCount is the number of calls that returned a status code
+type CodeCount struct {
+	Code  string  `json:"code"`
+	Count float64 `json:"count"`
+}
+
+// Interval is a sub-period of the window with its SLIs
+type Interval struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Availability *float64  `json:"availability,omitempty"`
+	Latency      *float64  `json:"latency_seconds,omitempty"`
+}
+
+// Evaluator computes dependency reports from the metrics exported by
+// instrumentation.DependencyTracker
+type Evaluator struct {
+	PrometheusURL string
+	Client        *http.Client
+
+	now func() time.Time
+}
+
+// Evaluate reports on one dependency over its SLA window, or over window
+// when it is non-zero
+func (e *Evaluator) Evaluate(ctx context.Context, sla SLA, window time.Duration) (*Report, error) {
+	if err := sla.Validate(); err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		window = sla.WindowDuration()
+	}
+	end := e.clock()
+	report := &Report{SLA: sla, Start: end.Add(-window), End: end, Status: StatusNoData}
+
+	m := sla.Matcher()
+	w := promRange(window)
+	codes, err := e.vector(ctx, fmt.Sprintf(`sum by (code, outcome) (increase(dependency_requests_total{%s}[%s]))`, m, w), end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", sla.Name, err)
+	}
+	counts := make(map[string]float64)
+	for _, c := range codes {
+		report.Requests += c.value
+		if c.labels["outcome"] == "error" {
+			report.Errors += c.value
+		}
+		counts[c.labels["code"]] += c.value
+	}
+	for code, n := range counts {
+		if n >= 0.5 {
+			report.Evidence.Codes = append(report.Evidence.Codes, CodeCount{Code: code, Count: math.Round(n)})
+		}
+	}
+	sort.Slice(report.Evidence.Codes, func(i, j int) bool {
+		return report.Evidence.Codes[i].Count > report.Evidence.Codes[j].Count
+	})
+	if report.Requests < 0.5 {
+		return report, nil
+	}
+
+	report.Status = StatusMet
+	if sla.Availability > 0 {
+		a := 100 * (1 - report.Errors/report.Requests)
+		report.Availability = &a
+		used := (100 - a) / (100 - sla.Availability)
+		report.ErrorBudgetUsed = &used
+		if a < sla.Availability {
+			report.Status = StatusBreached
+			report.Breaches = append(report.Breaches, fmt.Sprintf(
+				"Availability %.3f%% is below the %.3f%% SLA (%.0f of %.0f calls failed, %.0f%% of the error budget)",
+				a, sla.Availability, report.Errors, report.Requests, used*100))
+		}
+	}
+
+	objective := sla.LatencyObjective()
+	if objective > 0 {
+		values, err := e.vector(ctx, latencyQuery(sla, w), end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s latency: %w", sla.Name, err)
+		}
+		if len(values) > 0 && valid(values[0].value) {
+			l := values[0].value
+			report.Latency = &l
+			if l > objective.Seconds() {
+				report.Status = StatusBreached
+				report.Breaches = append(report.Breaches, fmt.Sprintf(
+					"p%g latency %s is above the %s SLA", sla.Quantile*100, formatSeconds(l), objective))
+			}
+		}
+	}
+
+	// Evidence is best effort: a report stands without it
+	report.Evidence.WorstIntervals = e.worstIntervals(ctx, sla, report.Start, end)
+	report.Evidence.FailedTraces, report.Evidence.SlowTraces = e.exemplars(ctx, sla, report.Start, end)
+	return report, nil
+}
+
+// intervals is the number of sub-periods the window is split into
+const intervals = 24
+
+// worstIntervals returns up to maxEvidence sub-periods that missed the SLA,
+// worst first
+func (e *Evaluator) worstIntervals(ctx context.Context, sla SLA, start, end time.Time) []Interval {
+	step := end.Sub(start) / intervals
+	if step < 5*time.Minute {
+		step = 5 * time.Minute
+	}
+	m, w := sla.Matcher(), promRange(step)
+	byTime := make(map[int64]*Interval)
+	get := func(ts int64) *Interval {
+		if byTime[ts] == nil {
+			t := time.Unix(ts, 0).UTC()
+			byTime[ts] = &Interval{Start: t.Add(-step), End: t}
+		}
+		return byTime[ts]
+	}
+
+	var score = make(map[int64]float64)
+	if sla.Availability > 0 {
+		query := fmt.Sprintf(`100 * (1 - (sum(increase(dependency_requests_total{%s, outcome="error"}[%s])) or vector(0)) / sum(increase(dependency_requests_total{%s}[%s])))`, m, w, m, w)
+		values, _ := e.matrix(ctx, query, start.Add(step), end, step)
+		for ts, v := range values {
+			if v < sla.Availability {
+				a := v
+				get(ts).Availability = &a
+				score[ts] += (sla.Availability - v) / (100 - sla.Availability)
+			}
+		}
+	}
+	if objective := sla.LatencyObjective(); objective > 0 {
+		values, _ := e.matrix(ctx, latencyQuery(sla, w), start.Add(step), end, step)
+		for ts, v := range values {
+			if v > objective.Seconds() {
+				l := v
+				get(ts).Latency = &l
+				score[ts] += v/objective.Seconds() - 1
+			}
+		}
+	}
+
+	var out []Interval
+	for ts := range byTime {
+		out = append(out, *byTime[ts])
+	}
+	sort.Slice(out, func(i, j int) bool {
+		si, sj := score[out[i].End.Unix()], score[out[j].End.Unix()]
+		if si != sj {
+			return si > sj
+		}
+		return out[i].End.After(out[j].End)
+	})
+	if len(out) > maxEvidence {
+		out = out[:maxEvidence]
+	}
+	return out
+}
+
+// exemplars returns trace IDs of failed and slow calls, newest first
+func (e *Evaluator) exemplars(ctx context.Context, sla SLA, start, end time.Time) (failed, slow []string) {
+	params := url.Values{}
+	params.Set("query", fmt.Sprintf(`dependency_request_duration_seconds_bucket{%s}`, sla.Matcher()))
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+
+	var data []struct {
+		Exemplars []struct {
+			Labels    map[string]string `json:"labels"`
+			Value     string            `json:"value"`
+			Timestamp float64           `json:"timestamp"`
+		} `json:"exemplars"`
+	}
+	if err := e.get(ctx, "/api/v1/query_exemplars", params, &data); err != nil {
+		return nil, nil
+	}
+
+	type exemplar struct {
+		traceID string
+		seconds float64
+		failed  bool
+		at      float64
+	}
+	var all []exemplar
+	seen := make(map[string]bool)
+	for _, series := range data {
+		for _, ex := range series.Exemplars {
+			id := ex.Labels["trace_id"]
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			v, _ := strconv.ParseFloat(ex.Value, 64)
+			all = append(all, exemplar{traceID: id, seconds: v, failed: ex.Labels["outcome"] == "error", at: ex.Timestamp})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].at > all[j].at })
+
+	objective := sla.LatencyObjective().Seconds()
+	for _, ex := range all {
+		if ex.failed && len(failed) < maxEvidence {
+			failed = append(failed, ex.traceID)
+		}
+		if objective > 0 && ex.seconds > objective && len(slow) < maxEvidence {
+			slow = append(slow, ex.traceID)
+		}
+	}
+	return failed, slow
+}
+
+// latencyQuery is the PromQL of the SLA quantile latency over a range
+func latencyQuery(sla SLA, w string) string {
+	return fmt.Sprintf(`histogram_quantile(%g, sum by (le) (increase(dependency_request_duration_seconds_bucket{%s}[%s])))`,
+		sla.Quantile, sla.Matcher(), w)
+}
+
+// promRange formats a duration as a PromQL range
+func promRange(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d.Seconds()))
+}
+
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// vector runs an instant query
+func (e *Evaluator) vector(ctx context.Context, query string, at time.Time) ([]sample, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(at.Unix(), 10))
+
+	var data struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]interface{}    `json:"value"`
+		} `json:"result"`
+	}
+	if err := e.get(ctx, "/api/v1/query", params, &data); err != nil {
+		return nil, err
+	}
+	var out []sample
+	for _, r := range data.Result {
+		s, _ := r.Value[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, sample{labels: r.Metric, value: v})
+	}
+	return out, nil
+}
+
+// matrix runs a range query and returns the first series by timestamp
+func (e *Evaluator) matrix(ctx context.Context, query string, start, end time.Time, step time.Duration) (map[int64]float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatInt(int64(step.Seconds()), 10))
+
+	var data struct {
+		Result []struct {
+			Values [][2]interface{} `json:"values"`
+		} `json:"result"`
+	}
+	if err := e.get(ctx, "/api/v1/query_range", params, &data); err != nil {
+		return nil, err
+	}
+	values := make(map[int64]float64)
+	if len(data.Result) == 0 {
+		return values, nil
+	}
+	for _, v := range data.Result[0].Values {
+		ts, _ := v[0].(float64)
+		s, _ := v[1].(string)
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || !valid(f) {
+			continue
+		}
+		values[int64(ts)] = f
+	}
+	return values, nil
+}
+
+// get calls a Prometheus API endpoint and decodes its data
+func (e *Evaluator) get(ctx context.Context, path string, params url.Values, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(e.PrometheusURL, "/")+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32*1024*1024))
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Status string          `json:"status"`
+		Error  string          `json:"error"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	if out.Status != "success" {
+		return fmt.Errorf("prometheus: %s", out.Error)
+	}
+	return json.Unmarshal(out.Data, data)
+}
+
+func (e *Evaluator) clock() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+func valid(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// formatSeconds formats a latency in seconds as a duration
+func formatSeconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
@@ -0,0 +1,77 @@
+package dependency
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// AlertWindow is the period alerting rules evaluate SLIs over. It is far
+// shorter than SLA windows so breaches are caught while they happen.
+const AlertWindow = "1h"
+
+// alertRulesTemplate renders Prometheus alerting rules for declared SLAs
+var alertRulesTemplate = template.Must(template.New("dependency-alerts").Parse(`groups:
+  - name: dependency-slas
+    interval: 1m
+    rules:
+{{- range .Rules }}
+{{- if .Availability }}
+      - alert: DependencyAvailabilityBelowSLA
+        expr: |
+          100 * (1 - (sum(increase(dependency_requests_total{ {{- .Matcher }}, outcome="error"}[{{ $.Window }}])) or vector(0))
+            / sum(increase(dependency_requests_total{ {{- .Matcher }}}[{{ $.Window }}]))) < {{ .Availability }}
+        for: 10m
+        labels:
+          severity: warning
+          dependency: "{{ .Name }}"
+        annotations:
+          summary: "{{ .Name }} availability is below its {{ .Availability }}% SLA"
+          description: "Calls to {{ .Host }} succeeded {{ "{{ $value | humanize }}" }}% of the time over the last {{ $.Window }}. Run 'apm dependencies {{ .Name }}' for evidence."
+{{- end }}
+{{- if .Latency }}
+      - alert: DependencyLatencyAboveSLA
+        expr: |
+          histogram_quantile({{ .Quantile }}, sum by (le) (increase(dependency_request_duration_seconds_bucket{ {{- .Matcher }}}[{{ $.Window }}]))) > {{ .Seconds }}
+        for: 10m
+        labels:
+          severity: warning
+          dependency: "{{ .Name }}"
+        annotations:
+          summary: "{{ .Name }} p{{ .Percentile }} latency is above its {{ .Latency }} SLA"
+          description: "Calls to {{ .Host }} took {{ "{{ $value | humanizeDuration }}" }} at p{{ .Percentile }} over the last {{ $.Window }}. Run 'apm dependencies {{ .Name }}' for evidence."
+{{- end }}
+{{- end }}
+`))
+
+// ruleData is an SLA as rendered in alerting rules
+type ruleData struct {
+	SLA
+	Seconds    float64
+	Percentile float64
+}
+
+// AlertRules renders Prometheus alerting rules that fire when a dependency
+// falls short of its SLA over AlertWindow
+func AlertRules(slas []SLA) (string, error) {
+	var data []ruleData
+	for _, sla := range slas {
+		if err := sla.Validate(); err != nil {
+			return "", err
+		}
+		data = append(data, ruleData{
+			SLA:        sla,
+			Seconds:    sla.LatencyObjective().Seconds(),
+			Percentile: sla.Quantile * 100,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := alertRulesTemplate.Execute(&buf, struct {
+		Window string
+		Rules  []ruleData
+	}{AlertWindow, data}); err != nil {
+		return "", fmt.Errorf("failed to render dependency alert rules: %w", err)
+	}
+	return buf.String(), nil
+}
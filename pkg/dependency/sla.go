@@ -0,0 +1,97 @@
+// Package dependency tracks third-party APIs against their vendor SLAs. Client
+// spans are grouped by external host by instrumentation.DependencyTracker; this
+// package evaluates the resulting availability and latency SLIs in Prometheus
+// against the SLAs declared in apm.yaml, reports breaches with evidence, and
+// renders alerting rules for them.
+package dependency
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/retention"
+)
+
+// Defaults
+const (
+	DefaultWindow   = "30d"
+	DefaultQuantile = 0.99
+)
+
+// SLA is a vendor's declared service level for a dependency
+type SLA struct {
+	// Name is the vendor or API name, e.g. Stripe
+	Name string `mapstructure:"name" yaml:"name" json:"name"`
+
+	// Host is the dependency host as tracked, e.g. api.stripe.com; a leading
+	// "*." matches any subdomain
+	Host string `mapstructure:"host" yaml:"host" json:"host"`
+
+	// Availability is the committed share of successful calls in percent,
+	// e.g. 99.95; zero means no availability commitment
+	Availability float64 `mapstructure:"availability" yaml:"availability" json:"availability,omitempty"`
+
+	// Latency is the committed latency at Quantile, e.g. 500ms; empty means
+	// no latency commitment
+	Latency  string  `mapstructure:"latency" yaml:"latency" json:"latency,omitempty"`
+	Quantile float64 `mapstructure:"quantile" yaml:"quantile" json:"quantile,omitempty"`
+
+	// Window is the SLA period, e.g. 30d
+	Window string `mapstructure:"window" yaml:"window" json:"window,omitempty"`
+}
+
+// Validate checks the SLA and fills in defaults
+func (s *SLA) Validate() error {
+	if s.Host == "" {
+		return fmt.Errorf("dependency SLA %q has no host", s.Name)
+	}
+	if s.Name == "" {
+		s.Name = s.Host
+	}
+	if s.Availability < 0 || s.Availability >= 100 {
+		return fmt.Errorf("dependency SLA %s: availability must be a percentage below 100", s.Name)
+	}
+	if s.Latency != "" {
+		if _, err := time.ParseDuration(s.Latency); err != nil {
+			return fmt.Errorf("dependency SLA %s: invalid latency: %w", s.Name, err)
+		}
+	}
+	if s.Availability == 0 && s.Latency == "" {
+		return fmt.Errorf("dependency SLA %s declares neither availability nor latency", s.Name)
+	}
+	if s.Quantile == 0 {
+		s.Quantile = DefaultQuantile
+	}
+	if s.Quantile <= 0 || s.Quantile >= 1 {
+		return fmt.Errorf("dependency SLA %s: quantile must be between 0 and 1", s.Name)
+	}
+	if s.Window == "" {
+		s.Window = DefaultWindow
+	}
+	if _, err := retention.ParseDuration(s.Window); err != nil {
+		return fmt.Errorf("dependency SLA %s: invalid window: %w", s.Name, err)
+	}
+	return nil
+}
+
+// LatencyObjective returns the committed latency, or zero
+func (s SLA) LatencyObjective() time.Duration {
+	d, _ := time.ParseDuration(s.Latency)
+	return d
+}
+
+// WindowDuration returns the SLA period
+func (s SLA) WindowDuration() time.Duration {
+	d, _ := retention.ParseDuration(s.Window)
+	return d
+}
+
+// Matcher is the PromQL label matcher selecting the dependency's series
+func (s SLA) Matcher() string {
+	if rest, ok := strings.CutPrefix(s.Host, "*."); ok {
+		return fmt.Sprintf(`dependency=~%q`, `.+\.`+regexp.QuoteMeta(rest))
+	}
+	return fmt.Sprintf(`dependency=%q`, s.Host)
+}
@@ -18,8 +18,14 @@ type Service struct {
 	streamer    *StatusStreamer
 	cache       *redis.Pool
 	mu          sync.RWMutex
+
+	releaseHealth ReleaseHealthFunc
 }
 
+// ReleaseHealthFunc scores the health of a deployed version during
+// verification, e.g. with release.Scorer
+type ReleaseHealthFunc func(deployment *Deployment) (HealthCheck, error)
+
 // ServiceConfig contains configuration for the deployment service
 type ServiceConfig struct {
 	KubeConfig       string
@@ -184,6 +190,14 @@ func (s *Service) CheckDeploymentHealth(deploymentID string) ([]HealthCheck, err
 		return nil, err
 	}
 
+	// The release must also score healthy on errors, latency, saturation,
+	// and alerts; scoring failures do not fail verification
+	if fn := s.getReleaseHealth(); fn != nil {
+		if check, err := fn(deployment); err == nil {
+			healthChecks = append(healthChecks, check)
+		}
+	}
+
 	// Update deployment with health checks
 	deployment.HealthChecks = healthChecks
 	s.history.RecordDeployment(deployment)
@@ -259,6 +273,19 @@ func (s *Service) GetDeployments(filters DeploymentFilters) ([]Deployment, error
 	return s.history.GetDeployments(filters)
 }
 
+// SetReleaseHealth adds a release health check to deployment verification
+func (s *Service) SetReleaseHealth(fn ReleaseHealthFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseHealth = fn
+}
+
+func (s *Service) getReleaseHealth() ReleaseHealthFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.releaseHealth
+}
+
 // GetWebSocketHub returns the WebSocket hub for handling connections
 func (s *Service) GetWebSocketHub() *WebSocketHub {
 	return s.hub
@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InstrumentedService is an application service of the local stack declared
+// in the compose.services section of apm.yaml. Services in languages with an
+// OpenTelemetry agent are traced without code changes; Go services are
+// expected to use pkg/instrumentation and only get the OTEL_* variables.
+//
+//	compose:
+//	  services:
+//	    billing:
+//	      language: java
+//	      build: ./billing
+//	      ports: ["8081:8080"]
+//	    storefront:
+//	      language: nodejs
+//	      image: storefront:dev
+type InstrumentedService struct {
+	Name     string   `yaml:"-"`
+	Language Language `yaml:"language"`
+	Image    string   `yaml:"image"`
+	// Build is the build context directory, used when Image is empty
+	Build       string            `yaml:"build"`
+	Ports       []string          `yaml:"ports"`
+	Environment map[string]string `yaml:"environment"`
+	// AgentVersion is the tag of the agent image, default latest
+	AgentVersion string `yaml:"agent_version"`
+	// Disabled leaves the service uninstrumented
+	Disabled bool `yaml:"disabled"`
+}
+
+// LoadInstrumentedServices reads the compose.services section of an
+// apm.yaml, sorted by name
+func LoadInstrumentedServices(path string) ([]InstrumentedService, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Compose struct {
+			Services map[string]InstrumentedService `yaml:"services"`
+		} `yaml:"compose"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	services := make([]InstrumentedService, 0, len(config.Compose.Services))
+	for name, svc := range config.Compose.Services {
+		svc.Name = name
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// agentMountPath is where the agent is mounted in the application container
+const agentMountPath = "/otel-auto-instrumentation"
+
+// autoInstrumentation describes how the OpenTelemetry agent of a language is
+// added to a container: an init service copies the agent out of the
+// OpenTelemetry Operator's image into a shared volume, and the environment
+// makes the runtime load it
+type autoInstrumentation struct {
+	image string
+	copy  string // Shell command copying the agent to agentMountPath
+	// protocol is the OTLP protocol the agent exports with
+	protocol string
+	env      map[string]string
+}
+
+var autoInstrumentations = map[Language]autoInstrumentation{
+	LanguageJava: {
+		image:    "ghcr.io/open-telemetry/opentelemetry-operator/autoinstrumentation-java",
+		copy:     "cp /javaagent.jar " + agentMountPath + "/javaagent.jar",
+		protocol: "grpc",
+		env: map[string]string{
+			"JAVA_TOOL_OPTIONS": "-javaagent:" + agentMountPath + "/javaagent.jar",
+		},
+	},
+	LanguageNodeJS: {
+		image:    "ghcr.io/open-telemetry/opentelemetry-operator/autoinstrumentation-nodejs",
+		copy:     "cp -r /autoinstrumentation/. " + agentMountPath,
+		protocol: "grpc",
+		env: map[string]string{
+			"NODE_OPTIONS": "--require " + agentMountPath + "/autoinstrumentation.js",
+		},
+	},
+	LanguagePython: {
+		image:    "ghcr.io/open-telemetry/opentelemetry-operator/autoinstrumentation-python",
+		copy:     "cp -r /autoinstrumentation/. " + agentMountPath,
+		protocol: "http/protobuf",
+		env: map[string]string{
+			"PYTHONPATH": agentMountPath + "/opentelemetry/instrumentation/auto_instrumentation:" + agentMountPath,
+		},
+	},
+	LanguageDotNet: {
+		image:    "ghcr.io/open-telemetry/opentelemetry-operator/autoinstrumentation-dotnet",
+		copy:     "cp -r /autoinstrumentation/. " + agentMountPath,
+		protocol: "grpc",
+		env: map[string]string{
+			"CORECLR_ENABLE_PROFILING": "1",
+			"CORECLR_PROFILER":         "{918728DD-259F-4A6A-AC2B-B85E1B658318}",
+			"CORECLR_PROFILER_PATH":    agentMountPath + "/linux-x64/OpenTelemetry.AutoInstrumentation.Native.so",
+			"DOTNET_ADDITIONAL_DEPS":   agentMountPath + "/AdditionalDeps",
+			"DOTNET_SHARED_STORE":      agentMountPath + "/store",
+			"DOTNET_STARTUP_HOOKS":     agentMountPath + "/net/OpenTelemetry.AutoInstrumentation.StartupHook.dll",
+			"OTEL_DOTNET_AUTO_HOME":    agentMountPath,
+		},
+	},
+}
+
+// collectorEndpoints are the OTLP endpoints of the Jaeger service added by
+// AddAPMService, by protocol
+var collectorEndpoints = map[string]string{
+	"grpc":          "http://jaeger:4317",
+	"http/protobuf": "http://jaeger:4318",
+}
+
+// AddInstrumentedService adds an application service traced by the
+// OpenTelemetry agent of its language. For agent languages an init service
+// named <name>-otel-agent copies the agent into the <name>-otel-agent volume
+// before the application starts. Environment set in apm.yaml overrides the
+// generated variables.
+func (b *DockerComposeBuilder) AddInstrumentedService(svc InstrumentedService) error {
+	if svc.Name == "" {
+		return fmt.Errorf("service has no name")
+	}
+	if svc.Image == "" && svc.Build == "" {
+		return fmt.Errorf("service %s needs an image or a build context", svc.Name)
+	}
+
+	service := ServiceConfig{
+		Image:       svc.Image,
+		Ports:       svc.Ports,
+		Environment: make(map[string]string),
+		Labels:      map[string]string{},
+	}
+	if svc.Image == "" {
+		service.Build = BuildContext{Context: svc.Build}
+	}
+
+	if !svc.Disabled {
+		protocol := "grpc"
+		if svc.Language != LanguageGo {
+			agent, ok := autoInstrumentations[svc.Language]
+			if !ok {
+				return fmt.Errorf("service %s: no OpenTelemetry agent for language %q: use java, nodejs, python, dotnet, or go", svc.Name, svc.Language)
+			}
+			version := svc.AgentVersion
+			if version == "" {
+				version = "latest"
+			}
+			agentService := svc.Name + "-otel-agent"
+			volume := agentService + ":" + agentMountPath
+			b.config.Services[agentService] = ServiceConfig{
+				Image:   agent.image + ":" + version,
+				Command: []string{"sh", "-c", agent.copy},
+				Volumes: []string{volume},
+				Labels:  map[string]string{"apm.agent-for": svc.Name},
+			}
+			b.config.Volumes[agentService] = VolumeConfig{}
+
+			service.Volumes = append(service.Volumes, volume+":ro")
+			service.WaitFor = append(service.WaitFor, agentService)
+			for k, v := range agent.env {
+				service.Environment[k] = v
+			}
+			protocol = agent.protocol
+			// Jaeger only receives traces
+			service.Environment["OTEL_METRICS_EXPORTER"] = "none"
+			service.Environment["OTEL_LOGS_EXPORTER"] = "none"
+		}
+
+		service.Environment["OTEL_SERVICE_NAME"] = svc.Name
+		service.Environment["OTEL_EXPORTER_OTLP_ENDPOINT"] = collectorEndpoints[protocol]
+		service.Environment["OTEL_EXPORTER_OTLP_PROTOCOL"] = protocol
+		service.Environment["OTEL_TRACES_EXPORTER"] = "otlp"
+		service.Labels["apm.enabled"] = "true"
+		service.Labels["apm.language"] = string(svc.Language)
+		if _, ok := b.config.Services["jaeger"]; ok {
+			service.DependsOn = append(service.DependsOn, "jaeger")
+		}
+	}
+
+	for k, v := range svc.Environment {
+		service.Environment[k] = v
+	}
+	b.config.Services[svc.Name] = service
+	return nil
+}
+
+// AddInstrumentedServices adds every service with AddInstrumentedService,
+// reporting all the services that could not be added
+func (b *DockerComposeBuilder) AddInstrumentedServices(services []InstrumentedService) error {
+	var problems []string
+	for _, svc := range services {
+		if err := b.AddInstrumentedService(svc); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid compose services: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
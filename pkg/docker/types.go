@@ -208,11 +208,15 @@ type DockerComposeConfig struct {
 type ServiceConfig struct {
 	Image       string
 	Build       BuildContext
+	Command     []string
 	Environment map[string]string
 	Ports       []string
 	Volumes     []string
 	Labels      map[string]string
 	DependsOn   []string
+	// WaitFor are services that must exit successfully before this one
+	// starts (condition: service_completed_successfully)
+	WaitFor     []string
 	HealthCheck HealthCheckConfig
 }
 
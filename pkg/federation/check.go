@@ -0,0 +1,343 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/statusreport"
+)
+
+// errAbsent is returned when the service proxy has no such service
+var errAbsent = errors.New("not deployed")
+
+// probes check a component and return its version, empty when the
+// component does not report one
+var probes = map[string]func(ctx context.Context, ep Endpoint) (string, error){
+	Prometheus:   probePrometheus,
+	Alertmanager: probeAlertmanager,
+	Grafana:      probeGrafana,
+	Loki:         probeLoki,
+	Jaeger:       probeJaeger,
+}
+
+// Check checks the components of a cluster in parallel, and with Prometheus
+// reachable collects its firing alerts and scrape targets
+func Check(ctx context.Context, name string, endpoints map[string]Endpoint) ClusterStatus {
+	status := ClusterStatus{
+		Name:        name,
+		Components:  []ComponentStatus{},
+		AlertCounts: map[string]int{},
+		Alerts:      []Alert{},
+		DownTargets: []Target{},
+	}
+
+	var checked []string
+	for _, component := range Components {
+		if _, ok := endpoints[component]; ok {
+			checked = append(checked, component)
+		}
+	}
+	components := make([]ComponentStatus, len(checked))
+	var wg sync.WaitGroup
+	for n, component := range checked {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			components[n] = checkComponent(ctx, component, endpoints[component])
+		}()
+	}
+
+	var alerts []Alert
+	var targets []activeTarget
+	var alertsErr, targetsErr error
+	if ep, ok := endpoints[Prometheus]; ok {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			alerts, alertsErr = firingAlerts(ctx, ep)
+		}()
+		go func() {
+			defer wg.Done()
+			targets, targetsErr = activeTargets(ctx, ep)
+		}()
+	}
+	wg.Wait()
+	status.Components = components
+
+	// Alerts and targets only mean something when Prometheus answered
+	if prom, ok := status.Component(Prometheus); ok && prom.Health == statusreport.HealthHealthy {
+		var problems []string
+		if alertsErr != nil {
+			problems = append(problems, alertsErr.Error())
+		}
+		if targetsErr != nil {
+			problems = append(problems, targetsErr.Error())
+		}
+		for n := range status.Components {
+			if status.Components[n].Name == Prometheus && len(problems) > 0 {
+				status.Components[n].Health = statusreport.HealthDegraded
+				status.Components[n].Message = strings.Join(problems, "; ")
+			}
+		}
+		for _, a := range alerts {
+			status.AlertCounts[a.Severity]++
+		}
+		status.Alerts = append(status.Alerts, alerts...)
+		for _, t := range targets {
+			switch t.Health {
+			case "up":
+				status.TargetsUp++
+			case "down":
+				status.TargetsDown++
+				status.DownTargets = append(status.DownTargets, t.target())
+			}
+		}
+		sort.Slice(status.DownTargets, func(i, j int) bool {
+			a, b := status.DownTargets[i], status.DownTargets[j]
+			return a.Job < b.Job || a.Job == b.Job && a.URL < b.URL
+		})
+	}
+
+	status.Health = clusterHealth(status)
+	return status
+}
+
+// clusterHealth is unhealthy when no deployed component answers, degraded
+// when one fails, a critical alert fires, or a target is down
+func clusterHealth(status ClusterStatus) string {
+	deployed, failed := 0, 0
+	degraded := false
+	for _, c := range status.Components {
+		switch c.Health {
+		case HealthAbsent:
+			continue
+		case statusreport.HealthUnhealthy:
+			failed++
+		case statusreport.HealthDegraded:
+			degraded = true
+		}
+		deployed++
+	}
+	switch {
+	case deployed == 0 || failed == deployed:
+		return statusreport.HealthUnhealthy
+	case failed > 0, degraded, status.AlertCounts["critical"] > 0, status.TargetsDown > 0:
+		return statusreport.HealthDegraded
+	default:
+		return statusreport.HealthHealthy
+	}
+}
+
+// checkComponent runs the probe of a component
+func checkComponent(ctx context.Context, component string, ep Endpoint) ComponentStatus {
+	status := ComponentStatus{Name: component, URL: ep.URL, Health: statusreport.HealthHealthy}
+	probe, ok := probes[component]
+	if !ok {
+		status.Health = statusreport.HealthUnknown
+		return status
+	}
+	version, err := probe(ctx, ep)
+	switch {
+	case errors.Is(err, errAbsent):
+		status.Health = HealthAbsent
+		status.Message = err.Error()
+	case err != nil:
+		status.Health = statusreport.HealthUnhealthy
+		status.Message = err.Error()
+	}
+	status.Version = version
+	return status
+}
+
+// get requests path from the endpoint and decodes a JSON answer into out,
+// unless out is nil
+func get(ctx context.Context, ep Endpoint, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	client := ep.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && ep.Proxied {
+		return errAbsent
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("GET %s: %s: %s", path, resp.Status, msg)
+		}
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	return nil
+}
+
+// buildInfo is the answer of Prometheus' and Loki's buildinfo endpoints
+type buildInfo struct {
+	Version string `json:"version"`
+	Data    struct {
+		Version string `json:"version"`
+	} `json:"data"`
+}
+
+func (b buildInfo) version() string {
+	if b.Data.Version != "" {
+		return b.Data.Version
+	}
+	return b.Version
+}
+
+func probePrometheus(ctx context.Context, ep Endpoint) (string, error) {
+	if err := get(ctx, ep, "/-/ready", nil); err != nil {
+		return "", err
+	}
+	var info buildInfo
+	_ = get(ctx, ep, "/api/v1/status/buildinfo", &info)
+	return info.version(), nil
+}
+
+func probeAlertmanager(ctx context.Context, ep Endpoint) (string, error) {
+	var body struct {
+		Cluster struct {
+			Status string `json:"status"`
+		} `json:"cluster"`
+		VersionInfo struct {
+			Version string `json:"version"`
+		} `json:"versionInfo"`
+	}
+	if err := get(ctx, ep, "/api/v2/status", &body); err != nil {
+		return "", err
+	}
+	if body.Cluster.Status == "settling" {
+		return body.VersionInfo.Version, errors.New("cluster is settling")
+	}
+	return body.VersionInfo.Version, nil
+}
+
+func probeGrafana(ctx context.Context, ep Endpoint) (string, error) {
+	var body struct {
+		Database string `json:"database"`
+		Version  string `json:"version"`
+	}
+	if err := get(ctx, ep, "/api/health", &body); err != nil {
+		return "", err
+	}
+	if body.Database != "ok" {
+		return body.Version, fmt.Errorf("database %s", body.Database)
+	}
+	return body.Version, nil
+}
+
+func probeLoki(ctx context.Context, ep Endpoint) (string, error) {
+	if err := get(ctx, ep, "/ready", nil); err != nil {
+		return "", err
+	}
+	var info buildInfo
+	_ = get(ctx, ep, "/loki/api/v1/status/buildinfo", &info)
+	return info.version(), nil
+}
+
+func probeJaeger(ctx context.Context, ep Endpoint) (string, error) {
+	return "", get(ctx, ep, "/api/services", nil)
+}
+
+// firingAlerts returns the firing alerts of Prometheus, most severe first
+func firingAlerts(ctx context.Context, ep Endpoint) ([]Alert, error) {
+	var body struct {
+		Data struct {
+			Alerts []struct {
+				Labels      map[string]string `json:"labels"`
+				Annotations map[string]string `json:"annotations"`
+				State       string            `json:"state"`
+				ActiveAt    time.Time         `json:"activeAt"`
+			} `json:"alerts"`
+		} `json:"data"`
+	}
+	if err := get(ctx, ep, "/api/v1/alerts", &body); err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	var alerts []Alert
+	for _, a := range body.Data.Alerts {
+		if a.State != "firing" {
+			continue
+		}
+		severity := a.Labels["severity"]
+		if severity == "" {
+			severity = "none"
+		}
+		alerts = append(alerts, Alert{
+			Name:     a.Labels["alertname"],
+			Severity: severity,
+			Summary:  a.Annotations["summary"],
+			ActiveAt: a.ActiveAt.UTC(),
+			Labels:   a.Labels,
+		})
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if ri, rj := severityRank(alerts[i].Severity), severityRank(alerts[j].Severity); ri != rj {
+			return ri < rj
+		}
+		return alerts[i].Name < alerts[j].Name
+	})
+	return alerts, nil
+}
+
+// severityRank orders the usual severity labels, others last
+func severityRank(severity string) int {
+	switch severity {
+	case "critical":
+		return 0
+	case "warning":
+		return 1
+	case "info":
+		return 2
+	default:
+		return 3
+	}
+}
+
+// activeTarget is a target of Prometheus' /api/v1/targets
+type activeTarget struct {
+	ScrapePool string            `json:"scrapePool"`
+	ScrapeURL  string            `json:"scrapeUrl"`
+	Labels     map[string]string `json:"labels"`
+	Health     string            `json:"health"`
+	LastError  string            `json:"lastError"`
+}
+
+func (t activeTarget) target() Target {
+	return Target{Job: t.ScrapePool, Instance: t.Labels["instance"], URL: t.ScrapeURL, Error: t.LastError}
+}
+
+// activeTargets returns the active scrape targets of Prometheus
+func activeTargets(ctx context.Context, ep Endpoint) ([]activeTarget, error) {
+	var body struct {
+		Data struct {
+			ActiveTargets []activeTarget `json:"activeTargets"`
+		} `json:"data"`
+	}
+	if err := get(ctx, ep, "/api/v1/targets?state=active", &body); err != nil {
+		return nil, fmt.Errorf("failed to query targets: %w", err)
+	}
+	return body.Data.ActiveTargets, nil
+}
@@ -0,0 +1,321 @@
+// Package federation collects the health of the APM stacks of many clusters
+// into one view: for each cluster the version and health of Prometheus,
+// Alertmanager, Grafana, Loki, and Jaeger, the alerts firing, and the scrape
+// targets that are down.
+//
+// A cluster is reached through a kubeconfig context, whose API server proxies
+// the requests to the stack's services, or through registered endpoint URLs
+// for stacks exposed outside the cluster.
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/statusreport"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Components of the stack, in display order
+const (
+	Prometheus   = "prometheus"
+	Alertmanager = "alertmanager"
+	Grafana      = "grafana"
+	Loki         = "loki"
+	Jaeger       = "jaeger"
+)
+
+// Components lists the components in display order
+var Components = []string{Prometheus, Alertmanager, Grafana, Loki, Jaeger}
+
+// DefaultNamespace is where the stack is deployed unless a cluster says
+// otherwise
+const DefaultNamespace = "monitoring"
+
+// DefaultServices are the services of the deployed stack, as name:port
+var DefaultServices = map[string]string{
+	Prometheus:   "prometheus:9090",
+	Alertmanager: "alertmanager:9093",
+	Grafana:      "grafana:3000",
+	Loki:         "loki:3100",
+	Jaeger:       "jaeger-query:16686",
+}
+
+// DefaultTimeout bounds the checks of one cluster
+const DefaultTimeout = 10 * time.Second
+
+// HealthAbsent is the health of a component the cluster does not run
+const HealthAbsent = "absent"
+
+// Cluster is a registered cluster, read from the clusters list of apm.yaml
+type Cluster struct {
+	// Name is shown in the matrix, default the context
+	Name string `mapstructure:"name" json:"name"`
+	// Context is the kubeconfig context whose API server proxies to the
+	// stack's services
+	Context string `mapstructure:"context" json:"context,omitempty"`
+	// Namespace of the stack, default DefaultNamespace
+	Namespace string `mapstructure:"namespace" json:"namespace,omitempty"`
+	// Services override DefaultServices per component
+	Services map[string]string `mapstructure:"services" json:"services,omitempty"`
+	// Endpoints are base URLs per component; a cluster without a context
+	// only checks the components listed here
+	Endpoints map[string]string `mapstructure:"endpoints" json:"endpoints,omitempty"`
+	// Tenant is sent to multi-tenant backends
+	Tenant string `mapstructure:"tenant" json:"tenant,omitempty"`
+}
+
+// Endpoint is where a component of a cluster is reached
+type Endpoint struct {
+	URL    string
+	Client *http.Client
+	// Proxied is set when the URL goes through the API server's service
+	// proxy, which answers 404 for a service that does not exist
+	Proxied bool
+}
+
+// Options configure Collect
+type Options struct {
+	// Kubeconfig is the kubeconfig file, default $KUBECONFIG or
+	// ~/.kube/config
+	Kubeconfig string
+	// Timeout bounds the checks of each cluster, default DefaultTimeout
+	Timeout time.Duration
+	// Client reaches registered endpoints, default http.DefaultClient
+	Client *http.Client
+}
+
+// Contexts returns the contexts of the kubeconfig, sorted
+func Contexts(kubeconfig string) ([]string, error) {
+	config, err := loadingRules(kubeconfig).Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the kubeconfig: %w", err)
+	}
+	contexts := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// ContextClusters returns a cluster per kubeconfig context, used when no
+// cluster is registered
+func ContextClusters(kubeconfig string) ([]Cluster, error) {
+	contexts, err := Contexts(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clusters := make([]Cluster, 0, len(contexts))
+	for _, name := range contexts {
+		clusters = append(clusters, Cluster{Name: name, Context: name})
+	}
+	return clusters, nil
+}
+
+func loadingRules(kubeconfig string) *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	return rules
+}
+
+// Connect returns the endpoints of the cluster's components: its registered
+// endpoints, and with a context every other component through the API
+// server's service proxy
+func (c Cluster) Connect(opts Options) (map[string]Endpoint, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Tenant != "" {
+		client = tenancy.NewClient(client, c.Tenant)
+	}
+	endpoints := make(map[string]Endpoint)
+	for component, url := range c.Endpoints {
+		endpoints[component] = Endpoint{URL: strings.TrimSuffix(url, "/"), Client: client}
+	}
+	if c.Context == "" {
+		if len(endpoints) == 0 {
+			return nil, fmt.Errorf("cluster %s has neither a context nor endpoints", c.Name)
+		}
+		return endpoints, nil
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules(opts.Kubeconfig),
+		&clientcmd.ConfigOverrides{CurrentContext: c.Context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load context %s: %w", c.Context, err)
+	}
+	apiClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client of context %s: %w", c.Context, err)
+	}
+	if c.Tenant != "" {
+		apiClient = tenancy.NewClient(apiClient, c.Tenant)
+	}
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	for _, component := range Components {
+		if _, ok := endpoints[component]; ok {
+			continue
+		}
+		service := c.Services[component]
+		if service == "" {
+			service = DefaultServices[component]
+		}
+		endpoints[component] = Endpoint{
+			URL:     fmt.Sprintf("%s/api/v1/namespaces/%s/services/%s/proxy", strings.TrimSuffix(config.Host, "/"), namespace, service),
+			Client:  apiClient,
+			Proxied: true,
+		}
+	}
+	return endpoints, nil
+}
+
+// Report is the federated status of the clusters
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// Health is the worst health of the clusters
+	Health   string          `json:"health"`
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+// Cluster returns the status of the named cluster
+func (r *Report) Cluster(name string) (ClusterStatus, bool) {
+	for _, c := range r.Clusters {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ClusterStatus{}, false
+}
+
+// ClusterStatus is the status of one cluster's stack
+type ClusterStatus struct {
+	Name       string            `json:"name"`
+	Context    string            `json:"context,omitempty"`
+	Health     string            `json:"health"`
+	Components []ComponentStatus `json:"components"`
+	// AlertCounts counts the firing alerts per severity label
+	AlertCounts map[string]int `json:"alert_counts"`
+	Alerts      []Alert        `json:"alerts"`
+	TargetsUp   int            `json:"targets_up"`
+	TargetsDown int            `json:"targets_down"`
+	DownTargets []Target       `json:"down_targets"`
+	// Error is why the cluster could not be checked at all
+	Error          string  `json:"error,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// Component returns the status of a component
+func (c ClusterStatus) Component(name string) (ComponentStatus, bool) {
+	for _, comp := range c.Components {
+		if comp.Name == name {
+			return comp, true
+		}
+	}
+	return ComponentStatus{}, false
+}
+
+// ComponentStatus is the health and version of a component
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Health  string `json:"health"`
+	Version string `json:"version,omitempty"`
+	URL     string `json:"url"`
+	Message string `json:"message,omitempty"`
+}
+
+// Alert is a firing alert
+type Alert struct {
+	Name     string            `json:"name"`
+	Severity string            `json:"severity"`
+	Summary  string            `json:"summary,omitempty"`
+	ActiveAt time.Time         `json:"active_at"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// Target is a scrape target that is down
+type Target struct {
+	Job      string `json:"job"`
+	Instance string `json:"instance"`
+	URL      string `json:"url"`
+	Error    string `json:"error"`
+}
+
+// Collect checks the clusters in parallel, each within the timeout; a
+// cluster that cannot be reached is reported unhealthy with its error
+func Collect(ctx context.Context, clusters []Cluster, opts Options) *Report {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	report := &Report{GeneratedAt: time.Now().UTC(), Clusters: make([]ClusterStatus, len(clusters))}
+	var wg sync.WaitGroup
+	for n, cluster := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			endpoints, err := cluster.Connect(opts)
+			var status ClusterStatus
+			if err != nil {
+				status = ClusterStatus{
+					Name:        cluster.Name,
+					Health:      statusreport.HealthUnhealthy,
+					Components:  []ComponentStatus{},
+					AlertCounts: map[string]int{},
+					Alerts:      []Alert{},
+					DownTargets: []Target{},
+					Error:       err.Error(),
+				}
+			} else {
+				status = Check(ctx, cluster.Name, endpoints)
+			}
+			status.Context = cluster.Context
+			status.ElapsedSeconds = statusreport.Seconds(time.Since(start))
+			report.Clusters[n] = status
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(report.Clusters, func(i, j int) bool { return report.Clusters[i].Name < report.Clusters[j].Name })
+	report.Health = worstHealth(report.Clusters)
+	return report
+}
+
+// healthRank orders healths from best to worst
+var healthRank = map[string]int{
+	statusreport.HealthHealthy:   0,
+	statusreport.HealthUnknown:   1,
+	statusreport.HealthDegraded:  2,
+	statusreport.HealthUnhealthy: 3,
+}
+
+func worstHealth(clusters []ClusterStatus) string {
+	if len(clusters) == 0 {
+		return statusreport.HealthUnknown
+	}
+	worst := statusreport.HealthHealthy
+	for _, c := range clusters {
+		if healthRank[c.Health] > healthRank[worst] {
+			worst = c.Health
+		}
+	}
+	return worst
+}
@@ -0,0 +1,182 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chaksack/apm/pkg/statusreport"
+)
+
+// stackHandler fakes the APIs of a stack whose Grafana database is down
+func stackHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/v1/status/buildinfo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"version":"2.48.0"}}`))
+	})
+	mux.HandleFunc("/api/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"alerts":[
+			{"labels":{"alertname":"HighMemoryUsage","severity":"warning"},"annotations":{"summary":"High memory"},"state":"firing","activeAt":"2024-05-01T11:00:00Z"},
+			{"labels":{"alertname":"InstanceDown","severity":"critical"},"annotations":{},"state":"firing","activeAt":"2024-05-01T11:30:00Z"},
+			{"labels":{"alertname":"HighCPUUsage","severity":"warning"},"annotations":{},"state":"pending","activeAt":"2024-05-01T11:59:00Z"}
+		]}}`))
+	})
+	mux.HandleFunc("/api/v1/targets", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"activeTargets":[
+			{"scrapePool":"node-exporter","scrapeUrl":"http://node-exporter:9100/metrics","labels":{"instance":"node-1"},"health":"up"},
+			{"scrapePool":"api","scrapeUrl":"http://api:8080/metrics","labels":{"instance":"api:8080"},"health":"down","lastError":"connection refused"}
+		]}}`))
+	})
+	mux.HandleFunc("/api/v2/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"cluster":{"status":"ready"},"versionInfo":{"version":"0.26.0"}}`))
+	})
+	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"database":"failing","version":"10.2.0"}`))
+	})
+	return mux
+}
+
+func TestCollect(t *testing.T) {
+	stack := httptest.NewServer(stackHandler())
+	defer stack.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no endpoints available", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	clusters := []Cluster{
+		{Name: "prod-eu", Endpoints: map[string]string{Prometheus: stack.URL, Alertmanager: stack.URL, Grafana: stack.URL + "/"}},
+		{Name: "edge", Endpoints: map[string]string{Prometheus: down.URL}},
+		{Name: "broken"},
+	}
+	report := Collect(context.Background(), clusters, Options{Client: stack.Client()})
+
+	if report.Health != statusreport.HealthUnhealthy {
+		t.Errorf("report health = %s", report.Health)
+	}
+	if names := []string{report.Clusters[0].Name, report.Clusters[1].Name, report.Clusters[2].Name}; strings.Join(names, ",") != "broken,edge,prod-eu" {
+		t.Errorf("clusters = %v", names)
+	}
+
+	prod, _ := report.Cluster("prod-eu")
+	if prod.Health != statusreport.HealthDegraded {
+		t.Errorf("prod-eu health = %s", prod.Health)
+	}
+	want := map[string]struct{ health, version string }{
+		Prometheus:   {statusreport.HealthHealthy, "2.48.0"},
+		Alertmanager: {statusreport.HealthHealthy, "0.26.0"},
+		Grafana:      {statusreport.HealthUnhealthy, "10.2.0"},
+	}
+	if len(prod.Components) != len(want) {
+		t.Fatalf("components = %+v", prod.Components)
+	}
+	for _, c := range prod.Components {
+		if w := want[c.Name]; c.Health != w.health || c.Version != w.version {
+			t.Errorf("%s = %s %s, want %s %s", c.Name, c.Health, c.Version, w.health, w.version)
+		}
+	}
+	if grafana, _ := prod.Component(Grafana); grafana.Message != "database failing" || grafana.URL != stack.URL {
+		t.Errorf("grafana = %+v", grafana)
+	}
+	if prod.AlertCounts["critical"] != 1 || prod.AlertCounts["warning"] != 1 || len(prod.Alerts) != 2 {
+		t.Errorf("alerts = %v %+v", prod.AlertCounts, prod.Alerts)
+	}
+	if prod.Alerts[0].Name != "InstanceDown" || prod.Alerts[1].Summary != "High memory" {
+		t.Errorf("alerts not most severe first: %+v", prod.Alerts)
+	}
+	if prod.TargetsUp != 1 || prod.TargetsDown != 1 || prod.DownTargets[0].Error != "connection refused" {
+		t.Errorf("targets = %d up, %d down, %+v", prod.TargetsUp, prod.TargetsDown, prod.DownTargets)
+	}
+
+	edge, _ := report.Cluster("edge")
+	if edge.Health != statusreport.HealthUnhealthy || !strings.Contains(edge.Components[0].Message, "no endpoints available") {
+		t.Errorf("edge = %+v", edge)
+	}
+	if edge.TargetsDown != 0 || len(edge.Alerts) != 0 {
+		t.Errorf("alerts and targets of an unreachable Prometheus reported: %+v", edge)
+	}
+
+	broken, _ := report.Cluster("broken")
+	if broken.Health != statusreport.HealthUnhealthy || broken.Error == "" {
+		t.Errorf("broken = %+v", broken)
+	}
+}
+
+func TestConnectThroughContext(t *testing.T) {
+	stack := stackHandler()
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		prefix := "/api/v1/namespaces/observability/services/prometheus:9090/proxy"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			http.Error(w, `{"kind":"Status","reason":"NotFound"}`, http.StatusNotFound)
+			return
+		}
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		stack.ServeHTTP(w, r)
+	}))
+	defer apiServer.Close()
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+  - name: prod
+    cluster: {server: %s, insecure-skip-tls-verify: true}
+  - name: staging
+    cluster: {server: https://staging.example.com}
+users:
+  - name: admin
+    user: {token: secret}
+contexts:
+  - name: prod
+    context: {cluster: prod, user: admin}
+  - name: staging
+    context: {cluster: staging, user: admin}
+current-context: staging
+`, apiServer.URL)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	clusters, err := ContextClusters(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 2 || clusters[0].Name != "prod" || clusters[1].Context != "staging" {
+		t.Errorf("context clusters = %+v", clusters)
+	}
+
+	cluster := Cluster{Name: "prod", Context: "prod", Namespace: "observability"}
+	endpoints, err := cluster.Connect(Options{Kubeconfig: kubeconfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := Check(context.Background(), cluster.Name, endpoints)
+	if status.Health != statusreport.HealthDegraded {
+		t.Errorf("health = %s", status.Health)
+	}
+	for _, c := range status.Components {
+		wantHealth := HealthAbsent
+		if c.Name == Prometheus {
+			wantHealth = statusreport.HealthHealthy
+		}
+		if c.Health != wantHealth {
+			t.Errorf("%s = %s, want %s", c.Name, c.Health, wantHealth)
+		}
+	}
+	if status.AlertCounts["critical"] != 1 {
+		t.Errorf("alerts through the proxy = %v", status.AlertCounts)
+	}
+
+	if _, err := (Cluster{Name: "gone", Context: "gone"}).Connect(Options{Kubeconfig: kubeconfig}); err == nil {
+		t.Error("missing context not reported")
+	}
+}
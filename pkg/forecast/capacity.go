@@ -0,0 +1,312 @@
+package forecast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTargetUtilization is the share of capacity recommendations aim for
+const DefaultTargetUtilization = 0.8
+
+// Resource is a forecastable resource. Usage and Capacity are PromQL
+// expressions returning a single series; Capacity may be empty when the
+// capacity is supplied by the caller.
+type Resource struct {
+	Name     string `json:"name"`
+	Unit     string `json:"unit"`
+	Usage    string `json:"usage"`
+	Capacity string `json:"capacity,omitempty"`
+
+	// Action is the scale action recommended when the resource saturates
+	Action string `json:"-"`
+}
+
+// Resource names
+const (
+	ResourceCPU      = "cpu"
+	ResourceMemory   = "memory"
+	ResourceRequests = "requests"
+	ResourceStorage  = "storage"
+)
+
+// Resources returns the built-in resources, scoped to a Kubernetes namespace
+// when one is given
+func Resources(namespace string) []Resource {
+	ns := ""
+	if namespace != "" {
+		ns = ", namespace=" + strconv.Quote(namespace)
+	}
+	return []Resource{
+		{
+			Name:     ResourceCPU,
+			Unit:     "cores",
+			Usage:    fmt.Sprintf(`sum(rate(container_cpu_usage_seconds_total{container!=""%s}[5m]))`, ns),
+			Capacity: fmt.Sprintf(`sum(kube_pod_container_resource_limits{resource="cpu"%s})`, ns),
+			Action:   "raise CPU limits or add replicas",
+		},
+		{
+			Name:     ResourceMemory,
+			Unit:     "bytes",
+			Usage:    fmt.Sprintf(`sum(container_memory_working_set_bytes{container!=""%s})`, ns),
+			Capacity: fmt.Sprintf(`sum(kube_pod_container_resource_limits{resource="memory"%s})`, ns),
+			Action:   "raise memory limits or add replicas",
+		},
+		{
+			Name:   ResourceRequests,
+			Unit:   "req/s",
+			Usage:  fmt.Sprintf(`sum(rate(http_requests_total{%s}[5m]))`, strings.TrimPrefix(ns, ", ")),
+			Action: "add replicas",
+		},
+		{
+			Name:     ResourceStorage,
+			Unit:     "bytes",
+			Usage:    fmt.Sprintf(`sum(kubelet_volume_stats_used_bytes{%s})`, strings.TrimPrefix(ns, ", ")),
+			Capacity: fmt.Sprintf(`sum(kubelet_volume_stats_capacity_bytes{%s})`, strings.TrimPrefix(ns, ", ")),
+			Action:   "expand volumes or shorten retention",
+		},
+	}
+}
+
+// Plan is the capacity forecast of one resource
+type Plan struct {
+	Resource    string     `json:"resource"`
+	Unit        string     `json:"unit"`
+	Current     float64    `json:"current"`
+	Capacity    float64    `json:"capacity,omitempty"`
+	Utilization float64    `json:"utilization,omitempty"`
+	Forecast    *Forecast  `json:"forecast"`
+	Saturation  Saturation `json:"saturation"`
+
+	// Recommended is the capacity that keeps the forecast's upper bound at
+	// the target utilization
+	Recommended    float64 `json:"recommended,omitempty"`
+	Recommendation string  `json:"recommendation"`
+}
+
+// Planner forecasts resources from Prometheus history
+type Planner struct {
+	PrometheusURL string
+	Client        *http.Client
+
+	History time.Duration
+	Horizon time.Duration
+	Step    time.Duration
+	Options Options
+
+	// TargetUtilization is the share of capacity to plan for; zero means
+	// DefaultTargetUtilization
+	TargetUtilization float64
+
+	now func() time.Time
+}
+
+// Plan forecasts a resource. A positive capacity overrides the resource's
+// capacity query.
+func (p *Planner) Plan(ctx context.Context, r Resource, capacity float64) (*Plan, error) {
+	end := p.clock()
+	series, err := p.queryRange(ctx, r.Usage, end.Add(-p.History), end)
+	if err != nil {
+		return nil, fmt.Errorf("%s usage: %w", r.Name, err)
+	}
+	if len(series) == 0 {
+		return nil, fmt.Errorf("%s usage: no data", r.Name)
+	}
+
+	if capacity <= 0 && r.Capacity != "" {
+		if capacity, err = p.queryInstant(ctx, r.Capacity, end); err != nil {
+			return nil, fmt.Errorf("%s capacity: %w", r.Name, err)
+		}
+	}
+
+	f, err := Fit(series, p.Step, p.Horizon, p.Options)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.Name, err)
+	}
+
+	plan := &Plan{
+		Resource: r.Name,
+		Unit:     r.Unit,
+		Current:  series[len(series)-1].Value,
+		Capacity: capacity,
+		Forecast: f,
+	}
+	if capacity > 0 {
+		plan.Utilization = plan.Current / capacity
+		plan.Saturation = f.Saturation(capacity)
+	}
+	plan.recommend(r, p.target(), end)
+	return plan, nil
+}
+
+// recommend fills the recommended capacity and action
+func (plan *Plan) recommend(r Resource, target float64, now time.Time) {
+	peak := plan.Forecast.Peak()
+	if peak.Upper > 0 {
+		plan.Recommended = peak.Upper / target
+	}
+	peakText := FormatValue(peak.Upper, plan.Unit)
+
+	if plan.Capacity <= 0 {
+		plan.Recommendation = fmt.Sprintf("Capacity unknown; usage may peak at %s by %s. Provide the capacity to get a saturation date.",
+			peakText, peak.Time.Format("2006-01-02"))
+		return
+	}
+
+	grow := fmt.Sprintf("%s from %s to %s (+%.0f%%)", r.Action,
+		FormatValue(plan.Capacity, plan.Unit), FormatValue(plan.Recommended, plan.Unit),
+		(plan.Recommended/plan.Capacity-1)*100)
+	s := plan.Saturation
+	switch {
+	case s.At != nil:
+		window := fmt.Sprintf("between %s and %s", s.Earliest.Format("2006-01-02"), s.At.Format("2006-01-02"))
+		if s.Latest != nil {
+			window = fmt.Sprintf("between %s and %s", s.Earliest.Format("2006-01-02"), s.Latest.Format("2006-01-02"))
+		}
+		plan.Recommendation = fmt.Sprintf("Saturates around %s (in %s, %s): %s before %s.",
+			s.At.Format("2006-01-02"), days(s.At.Sub(now)), window, grow, s.Earliest.Format("2006-01-02"))
+	case s.Earliest != nil:
+		plan.Recommendation = fmt.Sprintf("May saturate from %s if growth is at the high end: %s to keep %.0f%% headroom.",
+			s.Earliest.Format("2006-01-02"), grow, (1-target)*100)
+	case peak.Upper > plan.Capacity*target:
+		plan.Recommendation = fmt.Sprintf("Headroom drops below %.0f%% (peak %s): %s.", (1-target)*100, peakText, grow)
+	default:
+		plan.Recommendation = fmt.Sprintf("No action needed: usage peaks at %.0f%% of capacity over the horizon.", peak.Upper/plan.Capacity*100)
+		plan.Recommended = 0
+	}
+}
+
+func (p *Planner) target() float64 {
+	if p.TargetUtilization <= 0 || p.TargetUtilization > 1 {
+		return DefaultTargetUtilization
+	}
+	return p.TargetUtilization
+}
+
+func (p *Planner) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+type promResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Result []struct {
+			Value  [2]interface{}   `json:"value"`
+			Values [][2]interface{} `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func (p *Planner) queryRange(ctx context.Context, query string, start, end time.Time) ([]Point, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatInt(int64(p.Step/time.Second), 10))
+
+	var resp promResponse
+	if err := p.get(ctx, "/api/v1/query_range?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data.Result) == 0 {
+		return nil, nil
+	}
+	var points []Point
+	for _, v := range resp.Data.Result[0].Values {
+		if point, ok := parseSample(v); ok {
+			points = append(points, point)
+		}
+	}
+	return points, nil
+}
+
+func (p *Planner) queryInstant(ctx context.Context, query string, at time.Time) (float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(at.Unix(), 10))
+
+	var resp promResponse
+	if err := p.get(ctx, "/api/v1/query?"+params.Encode(), &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.Data.Result) == 0 {
+		return 0, nil
+	}
+	point, _ := parseSample(resp.Data.Result[0].Value)
+	return point.Value, nil
+}
+
+func (p *Planner) get(ctx context.Context, path string, out *promResponse) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.PrometheusURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32*1024*1024))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	if out.Status != "success" {
+		return fmt.Errorf("prometheus: %s", out.Error)
+	}
+	return nil
+}
+
+func parseSample(v [2]interface{}) (Point, bool) {
+	ts, ok := v[0].(float64)
+	if !ok {
+		return Point{}, false
+	}
+	s, _ := v[1].(string)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Point{}, false
+	}
+	sec, frac := math.Modf(ts)
+	return Point{Time: time.Unix(int64(sec), int64(frac*1e9)).UTC(), Value: f}, true
+}
+
+// FormatValue formats a value in a resource's unit, e.g. 3.2 GiB
+func FormatValue(v float64, unit string) string {
+	if unit != "bytes" {
+		return fmt.Sprintf("%.2f %s", v, unit)
+	}
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for math.Abs(v) >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func days(d time.Duration) string {
+	n := int(math.Round(d.Hours() / 24))
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
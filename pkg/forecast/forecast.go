@@ -0,0 +1,297 @@
+// Package forecast projects resource metrics forward for capacity planning.
+// Series are fitted with a linear trend or additive Holt-Winters (trend plus
+// a daily or weekly season), and each projection carries a confidence
+// interval so that saturation can be reported as a date range.
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Model is a forecasting model
+type Model string
+
+const (
+	ModelAuto        Model = "auto"
+	ModelLinear      Model = "linear"
+	ModelHoltWinters Model = "holt-winters"
+)
+
+// Defaults
+const (
+	DefaultSeason     = 24 * time.Hour
+	DefaultConfidence = 0.95
+)
+
+// Point is one sample of a series
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Prediction is a projected value with its confidence interval
+type Prediction struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Lower float64   `json:"lower"`
+	Upper float64   `json:"upper"`
+}
+
+// Options configures a fit
+type Options struct {
+	// Model to fit; ModelAuto fits both and keeps the better one
+	Model Model
+
+	// Season is the Holt-Winters period; zero means DefaultSeason
+	Season time.Duration
+
+	// Confidence of the prediction intervals; zero means DefaultConfidence
+	Confidence float64
+}
+
+// Forecast is a fitted model's projection
+type Forecast struct {
+	Model       Model        `json:"model"`
+	Predictions []Prediction `json:"predictions"`
+
+	// RMSE is the in-sample error of the fit
+	RMSE float64 `json:"rmse"`
+}
+
+// Fit projects a series horizon past its last sample. The series is resampled
+// at step; gaps are filled with the previous value.
+func Fit(series []Point, step, horizon time.Duration, opts Options) (*Forecast, error) {
+	if step <= 0 || horizon < step {
+		return nil, fmt.Errorf("step must be positive and horizon at least one step")
+	}
+	if opts.Model == "" {
+		opts.Model = ModelAuto
+	}
+	if opts.Season <= 0 {
+		opts.Season = DefaultSeason
+	}
+	if opts.Confidence <= 0 || opts.Confidence >= 1 {
+		opts.Confidence = DefaultConfidence
+	}
+
+	y, last := resample(series, step)
+	if len(y) < 3 {
+		return nil, fmt.Errorf("at least 3 samples are needed, got %d", len(y))
+	}
+	z := math.Sqrt2 * math.Erfinv(opts.Confidence)
+	h := int(horizon / step)
+	m := int(opts.Season / step)
+	canSeason := m >= 2 && len(y) >= 2*m
+
+	var f *Forecast
+	switch opts.Model {
+	case ModelLinear:
+		f = linear(y, h, z)
+	case ModelHoltWinters:
+		if !canSeason {
+			return nil, fmt.Errorf("holt-winters needs two seasons (%s) of history", 2*opts.Season)
+		}
+		f = holtWinters(y, m, h, z)
+	case ModelAuto:
+		f = linear(y, h, z)
+		if canSeason {
+			if hw := holtWinters(y, m, h, z); hw.RMSE < f.RMSE {
+				f = hw
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown model %q", opts.Model)
+	}
+
+	for i := range f.Predictions {
+		f.Predictions[i].Time = last.Add(time.Duration(i+1) * step)
+	}
+	return f, nil
+}
+
+// linear fits a least-squares trend; intervals are the regression
+// prediction intervals
+func linear(y []float64, h int, z float64) *Forecast {
+	n := float64(len(y))
+	var sx, sy float64
+	for i, v := range y {
+		sx += float64(i)
+		sy += v
+	}
+	mx, my := sx/n, sy/n
+	var sxx, sxy float64
+	for i, v := range y {
+		dx := float64(i) - mx
+		sxx += dx * dx
+		sxy += dx * (v - my)
+	}
+	slope := sxy / sxx
+	intercept := my - slope*mx
+
+	var sse float64
+	for i, v := range y {
+		r := v - (intercept + slope*float64(i))
+		sse += r * r
+	}
+	sigma := math.Sqrt(sse / (n - 2))
+
+	f := &Forecast{Model: ModelLinear, RMSE: math.Sqrt(sse / n)}
+	for k := 1; k <= h; k++ {
+		x := float64(len(y) - 1 + k)
+		value := intercept + slope*x
+		margin := z * sigma * math.Sqrt(1+1/n+(x-mx)*(x-mx)/sxx)
+		f.Predictions = append(f.Predictions, Prediction{Value: value, Lower: value - margin, Upper: value + margin})
+	}
+	return f
+}
+
+// Smoothing parameters searched when fitting Holt-Winters
+var (
+	alphas = []float64{0.05, 0.1, 0.2, 0.4, 0.6, 0.8}
+	betas  = []float64{0.01, 0.05, 0.1, 0.2}
+	gammas = []float64{0.05, 0.1, 0.3, 0.5}
+)
+
+// holtWinters fits additive Holt-Winters with season length m, choosing the
+// smoothing parameters with the smallest one-step-ahead error
+func holtWinters(y []float64, m, h int, z float64) *Forecast {
+	best := math.Inf(1)
+	var bestParams [3]float64
+	for _, a := range alphas {
+		for _, b := range betas {
+			for _, g := range gammas {
+				if sse, _, _, _ := hwRun(y, m, a, b, g); sse < best {
+					best, bestParams = sse, [3]float64{a, b, g}
+				}
+			}
+		}
+	}
+
+	a, b, g := bestParams[0], bestParams[1], bestParams[2]
+	sse, level, trend, season := hwRun(y, m, a, b, g)
+	fitted := float64(len(y) - m)
+	sigma := math.Sqrt(sse / fitted)
+
+	f := &Forecast{Model: ModelHoltWinters, RMSE: sigma}
+	var variance float64
+	for k := 1; k <= h; k++ {
+		value := level + float64(k)*trend + season[(len(y)+k-1)%m]
+		// Interval of the additive model, ignoring the seasonal term's
+		// contribution: sigma^2 * (1 + sum_{j<k} (alpha*(1+j*beta))^2)
+		if k > 1 {
+			c := a * (1 + float64(k-1)*b)
+			variance += c * c
+		}
+		margin := z * sigma * math.Sqrt(1+variance)
+		f.Predictions = append(f.Predictions, Prediction{Value: value, Lower: value - margin, Upper: value + margin})
+	}
+	return f
+}
+
+// hwRun runs Holt-Winters over y and returns the one-step-ahead SSE after
+// the first season and the final level, trend, and seasonal indices
+func hwRun(y []float64, m int, alpha, beta, gamma float64) (float64, float64, float64, []float64) {
+	var first, second float64
+	for i := 0; i < m; i++ {
+		first += y[i]
+		second += y[m+i]
+	}
+	first /= float64(m)
+	second /= float64(m)
+
+	level := first
+	trend := (second - first) / float64(m)
+	season := make([]float64, m)
+	for i := 0; i < m; i++ {
+		season[i] = y[i] - first
+	}
+
+	var sse float64
+	for t := m; t < len(y); t++ {
+		s := season[t%m]
+		predicted := level + trend + s
+		e := y[t] - predicted
+		sse += e * e
+
+		prevLevel := level
+		level = alpha*(y[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-prevLevel) + (1-beta)*trend
+		season[t%m] = gamma*(y[t]-level) + (1-gamma)*s
+	}
+	return sse, level, trend, season
+}
+
+// resample places a series on a regular grid of step, filling gaps with the
+// previous value, and returns the values and the time of the last one
+func resample(series []Point, step time.Duration) ([]float64, time.Time) {
+	if len(series) == 0 {
+		return nil, time.Time{}
+	}
+	points := append([]Point(nil), series...)
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	start := points[0].Time
+	end := points[len(points)-1].Time
+	values := make([]float64, 0, int(end.Sub(start)/step)+1)
+	j := 0
+	current := points[0].Value
+	var last time.Time
+	for t := start; !t.After(end); t = t.Add(step) {
+		for j < len(points) && !points[j].Time.After(t) {
+			if !math.IsNaN(points[j].Value) {
+				current = points[j].Value
+			}
+			j++
+		}
+		values = append(values, current)
+		last = t
+	}
+	return values, last
+}
+
+// Saturation is when a forecast reaches a capacity. Times are nil when the
+// capacity is not reached within the forecast.
+type Saturation struct {
+	Capacity float64 `json:"capacity"`
+
+	// At is when the projected value reaches capacity; Earliest and
+	// Latest are when the upper and lower bounds of the interval do
+	At       *time.Time `json:"at,omitempty"`
+	Earliest *time.Time `json:"earliest,omitempty"`
+	Latest   *time.Time `json:"latest,omitempty"`
+}
+
+// Saturation finds when the forecast reaches capacity
+func (f *Forecast) Saturation(capacity float64) Saturation {
+	s := Saturation{Capacity: capacity}
+	if capacity <= 0 {
+		return s
+	}
+	for i := range f.Predictions {
+		p := &f.Predictions[i]
+		if s.Earliest == nil && p.Upper >= capacity {
+			s.Earliest = &p.Time
+		}
+		if s.At == nil && p.Value >= capacity {
+			s.At = &p.Time
+		}
+		if s.Latest == nil && p.Lower >= capacity {
+			s.Latest = &p.Time
+		}
+	}
+	return s
+}
+
+// Peak returns the highest upper bound of the forecast
+func (f *Forecast) Peak() Prediction {
+	var peak Prediction
+	for i, p := range f.Predictions {
+		if i == 0 || p.Upper > peak.Upper {
+			peak = p
+		}
+	}
+	return peak
+}
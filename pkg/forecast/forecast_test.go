@@ -0,0 +1,121 @@
+package forecast
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func series(n int, f func(i int) float64) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = Point{Time: start.Add(time.Duration(i) * time.Hour), Value: f(i)}
+	}
+	return points
+}
+
+func TestLinearSaturation(t *testing.T) {
+	// 1 unit per hour with a little noise, from 10: reaches 100 at hour 90
+	s := series(48, func(i int) float64 { return 10 + float64(i) + 0.2*math.Sin(float64(i)) })
+	f, err := Fit(s, time.Hour, 72*time.Hour, Options{Model: ModelLinear})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sat := f.Saturation(100)
+	if sat.At == nil || sat.Earliest == nil || sat.Latest == nil {
+		t.Fatalf("expected saturation within the horizon, got %+v", sat)
+	}
+	if got := sat.At.Sub(start); got < 89*time.Hour || got > 91*time.Hour {
+		t.Errorf("expected saturation around hour 90, got %s", got)
+	}
+	if sat.Earliest.After(*sat.At) || sat.Latest.Before(*sat.At) {
+		t.Errorf("expected the interval to bracket saturation, got %+v", sat)
+	}
+	last := f.Predictions[len(f.Predictions)-1]
+	first := f.Predictions[0]
+	if last.Upper-last.Lower <= first.Upper-first.Lower {
+		t.Error("expected the interval to widen with the horizon")
+	}
+}
+
+func TestAutoPicksHoltWintersForSeasonalSeries(t *testing.T) {
+	// A daily cycle on a slow upward trend
+	s := series(24*7, func(i int) float64 { return 50 + 0.1*float64(i) + 20*math.Sin(2*math.Pi*float64(i)/24) })
+	f, err := Fit(s, time.Hour, 24*time.Hour, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Model != ModelHoltWinters {
+		t.Fatalf("expected holt-winters, got %s", f.Model)
+	}
+	// The projection keeps the daily shape: six hours in is a peak
+	want := 50 + 0.1*float64(24*7+5) + 20*math.Sin(2*math.Pi*float64(24*7+5)/24)
+	if got := f.Predictions[5].Value; math.Abs(got-want) > 3 {
+		t.Errorf("expected about %.1f, got %.1f", want, got)
+	}
+
+	if _, err := Fit(s[:30], time.Hour, time.Hour, Options{Model: ModelHoltWinters}); err == nil {
+		t.Error("expected holt-winters to need two seasons")
+	}
+}
+
+func TestPlannerPlan(t *testing.T) {
+	now := start.Add(47 * time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/query" {
+			fmt.Fprintf(w, `{"status":"success","data":{"result":[{"value":[%d,"100"]}]}}`, now.Unix())
+			return
+		}
+		var values []string
+		for i := 0; i < 48; i++ {
+			values = append(values, fmt.Sprintf(`[%d,"%d"]`, start.Add(time.Duration(i)*time.Hour).Unix(), 10+i))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"result":[{"values":[%s]}]}}`, strings.Join(values, ","))
+	}))
+	defer server.Close()
+
+	p := &Planner{
+		PrometheusURL: server.URL,
+		History:       48 * time.Hour,
+		Horizon:       72 * time.Hour,
+		Step:          time.Hour,
+		Options:       Options{Model: ModelLinear},
+		now:           func() time.Time { return now },
+	}
+	plan, err := p.Plan(context.Background(), Resources("shop")[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Capacity != 100 || plan.Current != 57 || plan.Saturation.At == nil {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if !strings.HasPrefix(plan.Recommendation, "Saturates around 2024-05-04") || !strings.Contains(plan.Recommendation, "raise CPU limits or add replicas from 100.00 cores") {
+		t.Errorf("unexpected recommendation %q", plan.Recommendation)
+	}
+	// The recommended capacity keeps the upper bound at 80%
+	if want := plan.Forecast.Peak().Upper / 0.8; math.Abs(plan.Recommended-want) > 1e-9 {
+		t.Errorf("expected %.2f recommended, got %.2f", want, plan.Recommended)
+	}
+
+	// Plenty of capacity needs no action
+	plan, _ = p.Plan(context.Background(), Resources("")[2], 1000)
+	if !strings.HasPrefix(plan.Recommendation, "No action needed") || plan.Recommended != 0 {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	if got := FormatValue(3.5*1024*1024*1024, "bytes"); got != "3.5 GiB" {
+		t.Errorf("got %q", got)
+	}
+	if got := FormatValue(1.5, "cores"); got != "1.50 cores" {
+		t.Errorf("got %q", got)
+	}
+}
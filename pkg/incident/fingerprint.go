@@ -0,0 +1,103 @@
+package incident
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Variable parts of log messages, replaced so that occurrences of the same
+// error share a fingerprint. Order matters: longer tokens go first.
+var normalizers = []struct {
+	re          *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<ts>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b(0x)?[0-9a-f]{8,}\b`), "<hex>"},
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`\d+(\.\d+)?`), "<n>"},
+}
+
+// Message fields checked, in order, when a log line is JSON
+var messageFields = []string{"error", "err", "exception", "msg", "message"}
+
+// Pattern reduces a log line to its error message with variable parts
+// replaced, e.g. `timeout after 30s calling "inventory"` becomes
+// `timeout after <n>s calling <str>`
+func Pattern(line string) string {
+	message := strings.TrimSpace(line)
+	if strings.HasPrefix(message, "{") {
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(message), &fields) == nil {
+			for _, name := range messageFields {
+				if s, ok := fields[name].(string); ok && s != "" {
+					message = s
+					break
+				}
+			}
+		}
+	}
+	for _, n := range normalizers {
+		message = n.re.ReplaceAllString(message, n.placeholder)
+	}
+	if len(message) > 200 {
+		message = message[:200]
+	}
+	return message
+}
+
+// Fingerprint identifies an error pattern of a service
+func Fingerprint(service, pattern string) string {
+	sum := sha256.Sum256([]byte(service + "\x00" + pattern))
+	return hex.EncodeToString(sum[:6])
+}
+
+// ErrorGroup is a set of log lines sharing a fingerprint
+type ErrorGroup struct {
+	Fingerprint string    `json:"fingerprint"`
+	Service     string    `json:"service"`
+	Pattern     string    `json:"pattern"`
+	Count       int       `json:"count"`
+	Example     string    `json:"example"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// groupErrors groups log lines by fingerprint, most frequent first
+func groupErrors(logs []LogExcerpt) []ErrorGroup {
+	groups := make(map[string]*ErrorGroup)
+	for _, l := range logs {
+		pattern := Pattern(l.Line)
+		fp := Fingerprint(l.Service, pattern)
+		g, ok := groups[fp]
+		if !ok {
+			g = &ErrorGroup{Fingerprint: fp, Service: l.Service, Pattern: pattern, Example: l.Line, FirstSeen: l.Time, LastSeen: l.Time}
+			groups[fp] = g
+		}
+		g.Count++
+		if l.Time.Before(g.FirstSeen) {
+			g.FirstSeen = l.Time
+		}
+		if l.Time.After(g.LastSeen) {
+			g.LastSeen = l.Time
+		}
+	}
+
+	result := make([]ErrorGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Fingerprint < result[j].Fingerprint
+	})
+	return result
+}
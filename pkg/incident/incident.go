@@ -0,0 +1,559 @@
+// Package incident assembles a summary when an alert group fires: the
+// affected services, deploys shortly before the alerts, the most frequent
+// error fingerprints, failing traces, and log excerpts. Summaries are posted
+// to the webhook endpoints and kept, with the alerts and deploys, in an
+// incident timeline.
+package incident
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/locale"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/webhook"
+)
+
+// Defaults
+const (
+	DefaultLookback = 30 * time.Minute
+	DefaultMaxItems = 5
+)
+
+// DefaultServiceLabels are the alert labels checked, in order, for the
+// affected service
+var DefaultServiceLabels = []string{"service", "service_name", "job", "app"}
+
+// Log terms searched for error lines
+var errorTerms = []string{"error", "ERROR", "Error", "exception", "Exception", "panic", "fatal", "FATAL"}
+
+// Alert is a firing alert of the incident
+type Alert struct {
+	Name        string    `json:"name"`
+	Severity    string    `json:"severity,omitempty"`
+	Service     string    `json:"service,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	StartsAt    time.Time `json:"starts_at"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+}
+
+// Deploy is a deploy of an affected service shortly before the incident
+type Deploy struct {
+	Time        time.Time `json:"time"`
+	Service     string    `json:"service"`
+	Event       string    `json:"event"`
+	Status      string    `json:"status,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+}
+
+// Trace is a failing trace of an affected service
+type Trace struct {
+	TraceID   string        `json:"trace_id"`
+	Service   string        `json:"service"`
+	Operation string        `json:"operation"`
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration"`
+	Errors    int           `json:"errors"`
+}
+
+// LogExcerpt is an error log line of an affected service
+type LogExcerpt struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Line    string    `json:"line"`
+	TraceID string    `json:"trace_id,omitempty"`
+}
+
+// Summary describes an incident
+type Summary struct {
+	ID          string    `json:"id"`
+	GroupKey    string    `json:"group_key"`
+	Title       string    `json:"title"`
+	StartedAt   time.Time `json:"started_at"`
+	GeneratedAt time.Time `json:"generated_at"`
+
+	Services []string     `json:"services"`
+	Alerts   []Alert      `json:"alerts"`
+	Deploys  []Deploy     `json:"deploys"`
+	Errors   []ErrorGroup `json:"errors"`
+	Traces   []Trace      `json:"traces"`
+	Logs     []LogExcerpt `json:"logs"`
+
+	// Warnings lists sources that could not be queried
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Text renders the summary for chat and ticket notifications, with times
+// in UTC
+func (s *Summary) Text() string {
+	return s.TextIn(locale.UTC)
+}
+
+// TextIn renders the summary with the times and numbers of a locale and
+// timezone
+func (s *Summary) TextIn(f *locale.Formatter) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Incident %s: %s\n", s.ID, s.Title)
+	fmt.Fprintf(&sb, "Started %s, affecting %s\n", f.DateTime(s.StartedAt), strings.Join(s.Services, ", "))
+
+	sb.WriteString("\nAlerts:\n")
+	for _, a := range s.Alerts {
+		fmt.Fprintf(&sb, "  • %s", a.Name)
+		if a.Severity != "" {
+			fmt.Fprintf(&sb, " [%s]", a.Severity)
+		}
+		if a.Summary != "" {
+			fmt.Fprintf(&sb, ": %s", a.Summary)
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(s.Deploys) > 0 {
+		sb.WriteString("\nRecent deploys:\n")
+		for _, d := range s.Deploys {
+			fmt.Fprintf(&sb, "  • %s %s %s %s (%s before the first alert)\n",
+				f.Clock(d.Time), d.Service, d.Image, d.Status, s.StartedAt.Sub(d.Time).Round(time.Second))
+		}
+	}
+	if len(s.Errors) > 0 {
+		sb.WriteString("\nTop errors:\n")
+		for _, e := range s.Errors {
+			fmt.Fprintf(&sb, "  • %s× %s: %s [%s]\n", f.Int(int64(e.Count)), e.Service, e.Pattern, e.Fingerprint)
+		}
+	}
+	if len(s.Traces) > 0 {
+		sb.WriteString("\nFailing traces:\n")
+		for _, t := range s.Traces {
+			fmt.Fprintf(&sb, "  • %s %s %s (%s, %d error spans)\n", t.TraceID, t.Service, t.Operation, t.Duration, t.Errors)
+		}
+	}
+	if len(s.Logs) > 0 {
+		sb.WriteString("\nLog excerpts:\n")
+		for _, l := range s.Logs {
+			fmt.Fprintf(&sb, "  %s %s %s\n", f.ClockMillis(l.Time), l.Service, l.Line)
+		}
+	}
+	for _, w := range s.Warnings {
+		fmt.Fprintf(&sb, "\n⚠ %s", w)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// Summarizer builds and publishes incident summaries
+type Summarizer struct {
+	Traces   lookup.TraceSearcher
+	Logs     lookup.LogSearcher
+	Timeline Timeline
+	Emitter  *webhook.Emitter
+
+	// ServiceLabels are the alert labels naming the affected service;
+	// empty uses DefaultServiceLabels
+	ServiceLabels []string
+
+	// Lookback is how long before the first alert deploys, errors, and
+	// traces are collected from; zero means DefaultLookback
+	Lookback time.Duration
+
+	// MaxItems caps each section of the summary; zero means DefaultMaxItems
+	MaxItems int
+
+	// Display formats the times and numbers of summary texts; nil means UTC
+	Display *locale.Formatter
+
+	// Now returns the current time; nil means time.Now
+	Now func() time.Time
+
+	mu sync.Mutex
+}
+
+// ID derives an incident ID from an alert group. Alertmanager resends the
+// group's alerts on every notification, so the ID stays stable while the
+// group's first alert is firing or recently resolved.
+func ID(payload webhook.AlertmanagerPayload) string {
+	var first time.Time
+	for _, a := range payload.Alerts {
+		if first.IsZero() || a.StartsAt.Before(first) {
+			first = a.StartsAt
+		}
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", payload.GroupKey, first.Unix())))
+	return "inc-" + hex.EncodeToString(sum[:6])
+}
+
+// Observe records an Alertmanager notification in the timeline. When the
+// group fires alerts that no summary covers yet, it builds a summary, stores
+// it, and posts it as an incident.summary webhook; otherwise it returns nil.
+func (s *Summarizer) Observe(ctx context.Context, payload webhook.AlertmanagerPayload) (*Summary, error) {
+	// Serialized so concurrent notifications of a group summarize it once
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := ID(payload)
+	recorded, err := s.Timeline.Entries(Filter{Incident: id})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	summarized := 0
+	for _, e := range recorded {
+		seen[e.Type+"/"+e.Subject] = true
+		if e.Type == EntrySummary {
+			if n, ok := e.Data["alerts"].(float64); ok {
+				summarized = int(n)
+			} else if n, ok := e.Data["alerts"].(int); ok {
+				summarized = n
+			}
+		}
+	}
+
+	firing := 0
+	for _, a := range payload.Alerts {
+		entry := Entry{Incident: id, Subject: a.Fingerprint, Data: map[string]interface{}{"labels": a.Labels}}
+		if a.Status == "resolved" {
+			entry.Type, entry.Time = EntryResolved, a.EndsAt
+			entry.Text = fmt.Sprintf("%s resolved", a.Labels["alertname"])
+		} else {
+			firing++
+			entry.Type, entry.Time = EntryAlert, a.StartsAt
+			entry.Text = fmt.Sprintf("%s fired", a.Labels["alertname"])
+		}
+		if entry.Subject == "" || seen[entry.Type+"/"+entry.Subject] {
+			continue
+		}
+		if err := s.Timeline.Append(entry); err != nil {
+			return nil, err
+		}
+	}
+	if firing == 0 || firing <= summarized {
+		return nil, nil
+	}
+
+	summary, err := s.Summarize(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Timeline.Append(Entry{
+		Time:     summary.GeneratedAt,
+		Incident: summary.ID,
+		Type:     EntrySummary,
+		Subject:  summary.Title,
+		Text:     summary.TextIn(s.display()),
+		Data:     map[string]interface{}{"alerts": len(summary.Alerts), "summary": summary},
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	err = s.Emitter.Emit(ctx, webhook.Event{
+		Type:    webhook.EventIncidentSummary,
+		Time:    summary.GeneratedAt,
+		Source:  "apm incidents",
+		Subject: summary.Title,
+		Status:  "firing",
+		Data:    map[string]interface{}{"incident": summary.ID, "text": summary.TextIn(s.display()), "summary": summary},
+	})
+	return summary, err
+}
+
+func (s *Summarizer) display() *locale.Formatter {
+	if s.Display == nil {
+		return locale.UTC
+	}
+	return s.Display
+}
+
+// RecordDeploy records a deploy.started or deploy.finished webhook event so
+// that later incidents can correlate it
+func (s *Summarizer) RecordDeploy(event webhook.Event) error {
+	if event.Type != webhook.EventDeployStarted && event.Type != webhook.EventDeployFinished {
+		return fmt.Errorf("event type %q is not a deploy", event.Type)
+	}
+	if event.Subject == "" {
+		return fmt.Errorf("deploy event has no service")
+	}
+	if event.Time.IsZero() {
+		event.Time = s.clock()
+	}
+	data := map[string]interface{}{"event": string(event.Type), "status": event.Status, "source": event.Source}
+	for _, k := range []string{"image", "version", "environment", "target"} {
+		if v, ok := event.Data[k]; ok {
+			data[k] = fmt.Sprint(v)
+		}
+	}
+	return s.Timeline.Append(Entry{
+		Time:    event.Time,
+		Type:    EntryDeploy,
+		Subject: event.Subject,
+		Text:    fmt.Sprintf("%s %s %s", event.Subject, event.Type, event.Status),
+		Data:    data,
+	})
+}
+
+// Summarize builds the summary of an alert group's firing alerts. Sources
+// that fail are listed as warnings rather than failing the summary.
+func (s *Summarizer) Summarize(ctx context.Context, payload webhook.AlertmanagerPayload) (*Summary, error) {
+	summary := &Summary{
+		ID:          ID(payload),
+		GroupKey:    payload.GroupKey,
+		GeneratedAt: s.clock(),
+	}
+
+	services := make(map[string]bool)
+	for _, a := range payload.Alerts {
+		if a.Status == "resolved" {
+			continue
+		}
+		alert := Alert{
+			Name:        a.Labels["alertname"],
+			Severity:    a.Labels["severity"],
+			Service:     s.service(a.Labels),
+			Summary:     a.Annotations["summary"],
+			StartsAt:    a.StartsAt,
+			Fingerprint: a.Fingerprint,
+		}
+		if alert.Summary == "" {
+			alert.Summary = a.Annotations["description"]
+		}
+		summary.Alerts = append(summary.Alerts, alert)
+		if alert.Service != "" {
+			services[alert.Service] = true
+		}
+		if summary.StartedAt.IsZero() || alert.StartsAt.Before(summary.StartedAt) {
+			summary.StartedAt = alert.StartsAt
+		}
+	}
+	if len(summary.Alerts) == 0 {
+		return nil, errors.New("alert group has no firing alerts")
+	}
+	sort.Slice(summary.Alerts, func(i, j int) bool { return summary.Alerts[i].StartsAt.Before(summary.Alerts[j].StartsAt) })
+
+	for name := range services {
+		summary.Services = append(summary.Services, name)
+	}
+	sort.Strings(summary.Services)
+	summary.Title = title(payload, summary)
+
+	start := summary.StartedAt.Add(-s.lookback())
+	end := summary.GeneratedAt
+	if !start.Before(end) {
+		end = summary.StartedAt.Add(time.Minute)
+	}
+
+	// The sources are independent, so they are queried in parallel
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	warn := func(source string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%s: %v", source, err))
+	}
+	if s.Timeline != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deploys, err := s.deploys(services, start, end)
+			if err != nil {
+				warn("timeline", err)
+			}
+			mu.Lock()
+			summary.Deploys = deploys
+			mu.Unlock()
+		}()
+	}
+	if s.Logs != nil && len(services) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logs, err := s.errorLogs(ctx, services, start, end)
+			if err != nil {
+				warn(s.Logs.Name(), err)
+			}
+			groups := groupErrors(logs)
+			mu.Lock()
+			summary.Errors = limit(groups, s.maxItems())
+			summary.Logs = limit(logs, s.maxItems())
+			mu.Unlock()
+		}()
+	}
+	if s.Traces != nil && len(services) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			traces, err := s.errorTraces(ctx, summary.Services, start, end)
+			if err != nil {
+				warn(s.Traces.Name(), err)
+			}
+			mu.Lock()
+			summary.Traces = limit(traces, s.maxItems())
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	sort.Strings(summary.Warnings)
+	return summary, nil
+}
+
+// deploys returns the recorded deploys of the services between start and
+// end, newest first
+func (s *Summarizer) deploys(services map[string]bool, start, end time.Time) ([]Deploy, error) {
+	entries, err := s.Timeline.Entries(Filter{Type: EntryDeploy, Since: start, Until: end})
+	if err != nil {
+		return nil, err
+	}
+	var deploys []Deploy
+	for _, e := range entries {
+		if len(services) > 0 && !services[e.Subject] {
+			continue
+		}
+		deploys = append(deploys, Deploy{
+			Time:        e.Time,
+			Service:     e.Subject,
+			Event:       str(e.Data["event"]),
+			Status:      str(e.Data["status"]),
+			Image:       str(e.Data["image"]),
+			Environment: str(e.Data["environment"]),
+		})
+	}
+	sort.SliceStable(deploys, func(i, j int) bool { return deploys[i].Time.After(deploys[j].Time) })
+	return limit(deploys, s.maxItems()), nil
+}
+
+// errorLogs returns the error lines of the services, newest first
+func (s *Summarizer) errorLogs(ctx context.Context, services map[string]bool, start, end time.Time) ([]LogExcerpt, error) {
+	events, err := s.Logs.SearchLogs(ctx, lookup.Query{Start: start, End: end, Limit: 50}, errorTerms)
+	if err != nil {
+		return nil, err
+	}
+	var logs []LogExcerpt
+	for _, e := range events {
+		if !services[e.Service] {
+			continue
+		}
+		logs = append(logs, LogExcerpt{Time: e.Time, Service: e.Service, Line: e.Summary, TraceID: e.TraceID})
+	}
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Time.After(logs[j].Time) })
+	return logs, nil
+}
+
+// errorTraces returns the traces of the services with error spans, the
+// most errors first
+func (s *Summarizer) errorTraces(ctx context.Context, services []string, start, end time.Time) ([]Trace, error) {
+	events, err := s.Traces.SearchTraces(ctx, lookup.Query{
+		Attribute: "error",
+		Value:     "true",
+		Services:  services,
+		Start:     start,
+		End:       end,
+		Limit:     s.maxItems() * 2,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make(map[string]*Trace)
+	ends := make(map[string]time.Time)
+	var order []string
+	for _, e := range events {
+		t, ok := traces[e.TraceID]
+		if !ok {
+			t = &Trace{TraceID: e.TraceID, Service: e.Service, Operation: e.Summary, Time: e.Time}
+			traces[e.TraceID] = t
+			order = append(order, e.TraceID)
+		}
+		// The earliest span is taken as the root
+		if e.Time.Before(t.Time) {
+			t.Service, t.Operation, t.Time = e.Service, e.Summary, e.Time
+		}
+		if spanEnd := e.Time.Add(e.Duration); spanEnd.After(ends[e.TraceID]) {
+			ends[e.TraceID] = spanEnd
+		}
+		if e.Error {
+			t.Errors++
+		}
+	}
+
+	result := make([]Trace, 0, len(order))
+	for _, id := range order {
+		t := traces[id]
+		t.Duration = ends[id].Sub(t.Time)
+		result = append(result, *t)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Errors != result[j].Errors {
+			return result[i].Errors > result[j].Errors
+		}
+		return result[i].Duration > result[j].Duration
+	})
+	return result, nil
+}
+
+func (s *Summarizer) service(labels map[string]string) string {
+	names := s.ServiceLabels
+	if len(names) == 0 {
+		names = DefaultServiceLabels
+	}
+	for _, name := range names {
+		if v := labels[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *Summarizer) lookback() time.Duration {
+	if s.Lookback <= 0 {
+		return DefaultLookback
+	}
+	return s.Lookback
+}
+
+func (s *Summarizer) maxItems() int {
+	if s.MaxItems <= 0 {
+		return DefaultMaxItems
+	}
+	return s.MaxItems
+}
+
+func (s *Summarizer) clock() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now().UTC()
+}
+
+// title names the incident after its alerts and services
+func title(payload webhook.AlertmanagerPayload, summary *Summary) string {
+	name := payload.CommonLabels["alertname"]
+	if name == "" {
+		name = summary.Alerts[0].Name
+		if len(summary.Alerts) > 1 {
+			name = fmt.Sprintf("%s and %d more", name, len(summary.Alerts)-1)
+		}
+	}
+	if len(summary.Services) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s on %s", name, strings.Join(summary.Services, ", "))
+}
+
+func limit[T any](items []T, n int) []T {
+	if len(items) > n {
+		return items[:n]
+	}
+	return items
+}
+
+func str(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
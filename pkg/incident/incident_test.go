@@ -0,0 +1,160 @@
+package incident
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/locale"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/webhook"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeLogs []lookup.Event
+
+func (f fakeLogs) Name() string { return "loki" }
+
+func (f fakeLogs) SearchLogs(ctx context.Context, q lookup.Query, terms []string) ([]lookup.Event, error) {
+	return f, nil
+}
+
+type failingTraces struct{}
+
+func (failingTraces) Name() string { return "jaeger" }
+
+func (failingTraces) SearchTraces(ctx context.Context, q lookup.Query) ([]lookup.Event, error) {
+	return nil, errors.New("unreachable")
+}
+
+func payload(status string) webhook.AlertmanagerPayload {
+	return webhook.AlertmanagerPayload{
+		GroupKey:     `{}:{alertname="HighErrorRate"}`,
+		Status:       status,
+		CommonLabels: map[string]string{"alertname": "HighErrorRate"},
+		Alerts: []webhook.AlertmanagerAlert{{
+			Status:      status,
+			Labels:      map[string]string{"alertname": "HighErrorRate", "service": "checkout", "severity": "critical"},
+			Annotations: map[string]string{"summary": "5% of checkout requests fail"},
+			StartsAt:    t0,
+			EndsAt:      t0.Add(time.Hour),
+			Fingerprint: "fp1",
+		}},
+	}
+}
+
+func TestPattern(t *testing.T) {
+	a := Pattern(`{"level":"error","msg":"timeout after 30s calling \"inventory\" for order 7f3a9c2e11"}`)
+	b := Pattern(`{"level":"error","msg":"timeout after 12s calling \"pricing\" for order 0a1b2c3d4e"}`)
+	if a != b || a != "timeout after <n>s calling <str> for order <hex>" {
+		t.Errorf("expected matching patterns, got %q and %q", a, b)
+	}
+	if Fingerprint("checkout", a) == Fingerprint("cart", a) {
+		t.Error("expected fingerprints to differ by service")
+	}
+}
+
+func TestObserveSummarizesOnce(t *testing.T) {
+	timeline := &MemoryTimeline{}
+	var mu sync.Mutex
+	var posted []webhook.Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		mu.Lock()
+		posted = append(posted, e)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+	emitter, _ := webhook.New(webhook.Endpoint{URL: receiver.URL, Events: []webhook.EventType{webhook.EventIncidentSummary}})
+
+	s := &Summarizer{
+		Logs: fakeLogs{
+			{Time: t0.Add(-2 * time.Minute), Service: "checkout", Summary: `payment failed: status 502`},
+			{Time: t0.Add(-time.Minute), Service: "checkout", Summary: `payment failed: status 503`, TraceID: "abc"},
+			{Time: t0, Service: "checkout", Summary: `cart empty`},
+			{Time: t0, Service: "search", Summary: `index missing`},
+		},
+		Traces:   failingTraces{},
+		Timeline: timeline,
+		Emitter:  emitter,
+		Now:      func() time.Time { return t0.Add(5 * time.Minute) },
+	}
+
+	// A deploy of the affected service before the alert is correlated; a
+	// deploy of another service is not
+	s.RecordDeploy(webhook.Event{Type: webhook.EventDeployFinished, Time: t0.Add(-10 * time.Minute), Subject: "checkout", Status: "succeeded", Data: map[string]interface{}{"image": "checkout:1.4.0"}})
+	s.RecordDeploy(webhook.Event{Type: webhook.EventDeployFinished, Time: t0.Add(-5 * time.Minute), Subject: "search", Status: "succeeded"})
+
+	summary, err := s.Observe(context.Background(), payload("firing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Title != "HighErrorRate on checkout" || len(summary.Services) != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if len(summary.Deploys) != 1 || summary.Deploys[0].Image != "checkout:1.4.0" {
+		t.Errorf("unexpected deploys %+v", summary.Deploys)
+	}
+	if len(summary.Errors) != 2 || summary.Errors[0].Count != 2 || summary.Errors[0].Pattern != "payment failed: status <n>" {
+		t.Errorf("unexpected error groups %+v", summary.Errors)
+	}
+	if len(summary.Logs) != 3 || summary.Logs[0].Line != "cart empty" {
+		t.Errorf("expected the newest checkout lines first, got %+v", summary.Logs)
+	}
+	if len(summary.Warnings) != 1 || !strings.Contains(summary.Warnings[0], "jaeger") {
+		t.Errorf("expected a jaeger warning, got %v", summary.Warnings)
+	}
+	if text := summary.Text(); !strings.Contains(text, "checkout:1.4.0 succeeded (10m0s before the first alert)") {
+		t.Errorf("unexpected text\n%s", text)
+	}
+	berlin, _ := locale.New("de-DE", "Europe/Berlin")
+	if text := summary.TextIn(berlin); !strings.Contains(text, "Started 01.05.2024 14:00:00 CEST") {
+		t.Errorf("expected Berlin time in the text\n%s", text)
+	}
+
+	// A repeated notification of the same alerts is not summarized again
+	again, err := s.Observe(context.Background(), payload("firing"))
+	if err != nil || again != nil {
+		t.Errorf("expected no second summary, got %v %v", again, err)
+	}
+	s.Observe(context.Background(), payload("resolved"))
+
+	if len(posted) != 1 || posted[0].Type != webhook.EventIncidentSummary || posted[0].Data["incident"] != summary.ID {
+		t.Errorf("expected one posted summary, got %+v", posted)
+	}
+	entries, _ := timeline.Entries(Filter{Incident: summary.ID})
+	var types []string
+	for _, e := range entries {
+		types = append(types, e.Type)
+	}
+	if got := strings.Join(types, ","); got != "alert,summary,resolved" {
+		t.Errorf("unexpected timeline %s", got)
+	}
+}
+
+func TestFileTimeline(t *testing.T) {
+	timeline := NewFileTimeline(filepath.Join(t.TempDir(), "incidents.jsonl"))
+	if entries, err := timeline.Entries(Filter{}); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty timeline, got %v %v", entries, err)
+	}
+	timeline.Append(Entry{Time: t0, Incident: "inc-1", Type: EntrySummary, Data: map[string]interface{}{"alerts": 2}})
+	timeline.Append(Entry{Time: t0.Add(time.Hour), Type: EntryDeploy, Subject: "checkout"})
+
+	entries, err := timeline.Entries(Filter{Type: EntryDeploy, Since: t0.Add(time.Minute)})
+	if err != nil || len(entries) != 1 || entries[0].Subject != "checkout" {
+		t.Errorf("unexpected entries %+v %v", entries, err)
+	}
+	entries, _ = timeline.Entries(Filter{Incident: "inc-1"})
+	if len(entries) != 1 || entries[0].Data["alerts"] != float64(2) {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
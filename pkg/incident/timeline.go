@@ -0,0 +1,139 @@
+package incident
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Timeline entry types
+const (
+	EntryAlert    = "alert"
+	EntryDeploy   = "deploy"
+	EntrySummary  = "summary"
+	EntryResolved = "resolved"
+)
+
+// Entry is one event in an incident timeline. Deploys are recorded without
+// an incident so that any later incident can correlate them.
+type Entry struct {
+	Time     time.Time              `json:"timestamp"`
+	Incident string                 `json:"incident,omitempty"`
+	Type     string                 `json:"type"`
+	Subject  string                 `json:"subject"`
+	Text     string                 `json:"text"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// Filter selects timeline entries; zero fields match everything
+type Filter struct {
+	Incident string
+	Type     string
+	Since    time.Time
+	Until    time.Time
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.Incident == "" || e.Incident == f.Incident) &&
+		(f.Type == "" || e.Type == f.Type) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !e.Time.After(f.Until))
+}
+
+// Timeline stores incident timeline entries
+type Timeline interface {
+	Append(entry Entry) error
+	Entries(filter Filter) ([]Entry, error)
+}
+
+// FileTimeline appends entries as JSON lines to a file
+type FileTimeline struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileTimeline creates a timeline stored at path
+func NewFileTimeline(path string) *FileTimeline {
+	return &FileTimeline{path: path}
+}
+
+// Append adds an entry to the timeline file
+func (t *FileTimeline) Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open incident timeline: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Entries returns the matching entries in the order they were appended
+func (t *FileTimeline) Entries(filter Filter) ([]Entry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, err := os.Open(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open incident timeline: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Skip a line torn by a crash rather than losing the timeline
+			continue
+		}
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// MemoryTimeline keeps entries in memory, for tests
+type MemoryTimeline struct {
+	entries []Entry
+	mu      sync.RWMutex
+}
+
+// Append stores an entry
+func (t *MemoryTimeline) Append(entry Entry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, entry)
+	return nil
+}
+
+// Entries returns the matching entries
+func (t *MemoryTimeline) Entries(filter Filter) ([]Entry, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var entries []Entry
+	for _, e := range t.entries {
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,463 @@
+package instrumentation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Access log formats; any other format is an Apache LogFormat template
+const (
+	// AccessLogZap logs each request as a structured entry of the logger,
+	// the default
+	AccessLogZap = "zap"
+	// AccessLogCommon is Apache's Common Log Format
+	AccessLogCommon = "common"
+	// AccessLogCombined is Apache's combined format, CLF with the referer
+	// and user agent
+	AccessLogCombined = "combined"
+	// AccessLogECS writes JSON lines following the Elastic Common Schema
+	AccessLogECS = "ecs"
+)
+
+// ecsVersion is the version of the Elastic Common Schema the entries follow
+const ecsVersion = "8.11.0"
+
+// accessLogTemplates are the LogFormat templates of the named formats
+var accessLogTemplates = map[string]string{
+	AccessLogCommon:   `%h %l %u %t "%r" %>s %b`,
+	AccessLogCombined: `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`,
+}
+
+// AccessLogConfig configures an AccessLogger
+type AccessLogConfig struct {
+	// Format is common, combined, ecs, or an Apache LogFormat template such
+	// as `%h %t "%r" %>s %b %D %{trace_id}x`. Templates support %a %h %l %u
+	// %t %r %m %U %q %H %s %>s %b %B %D %T %v %%, %{Header}i and %{Header}o,
+	// and %{trace_id}x, %{span_id}x, and %{request_id}x.
+	Format string
+	// Output receives one line per request, default os.Stdout
+	Output io.Writer
+	// ServiceName is the service.name of ECS entries
+	ServiceName string
+}
+
+// AccessLogger writes one line per request in a format existing log
+// pipelines parse, such as Apache's combined format or ECS JSON
+type AccessLogger struct {
+	format  string
+	fields  []accessField
+	service string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// accessRecord is what is known about a finished request
+type accessRecord struct {
+	c        *fiber.Ctx
+	start    time.Time
+	duration time.Duration
+	status   int
+	bytes    int
+	err      error
+	span     trace.SpanContext
+}
+
+// accessField appends one part of a template line
+type accessField func(b []byte, r *accessRecord) []byte
+
+// NewAccessLogger parses the format of an access log
+func NewAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	out := config.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	a := &AccessLogger{format: config.Format, service: config.ServiceName, out: out}
+	switch config.Format {
+	case AccessLogECS:
+		return a, nil
+	case "", AccessLogZap:
+		return nil, fmt.Errorf("access log format %q logs through the logger, not an access log", config.Format)
+	}
+	template, ok := accessLogTemplates[config.Format]
+	if !ok {
+		if !strings.Contains(config.Format, "%") {
+			return nil, fmt.Errorf("unknown access log format %q: use common, combined, ecs, or a LogFormat template", config.Format)
+		}
+		template = config.Format
+	}
+	fields, err := parseLogFormat(template)
+	if err != nil {
+		return nil, err
+	}
+	a.fields = fields
+	return a, nil
+}
+
+// ValidateAccessLogFormat checks an access log format; the zap format and
+// the empty one are valid
+func ValidateAccessLogFormat(format string) error {
+	if format == "" || format == AccessLogZap {
+		return nil
+	}
+	_, err := NewAccessLogger(AccessLogConfig{Format: format, Output: io.Discard})
+	return err
+}
+
+// parseLogFormat compiles an Apache LogFormat template
+func parseLogFormat(template string) ([]accessField, error) {
+	var fields []accessField
+	literal := func(s string) accessField {
+		return func(b []byte, _ *accessRecord) []byte { return append(b, s...) }
+	}
+	rest := template
+	for rest != "" {
+		n := strings.IndexByte(rest, '%')
+		if n < 0 {
+			fields = append(fields, literal(rest))
+			break
+		}
+		if n > 0 {
+			fields = append(fields, literal(rest[:n]))
+		}
+		rest = rest[n+1:]
+
+		arg := ""
+		if strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("access log format %q: unclosed %%{", template)
+			}
+			arg, rest = rest[1:end], rest[end+1:]
+		}
+		rest = strings.TrimLeft(rest, "<>")
+		if rest == "" {
+			return nil, fmt.Errorf("access log format %q ends with %%", template)
+		}
+		directive := rest[0]
+		rest = rest[1:]
+		field, err := logFormatDirective(directive, arg)
+		if err != nil {
+			return nil, fmt.Errorf("access log format %q: %w", template, err)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// logFormatDirective returns the field of a LogFormat directive
+func logFormatDirective(directive byte, arg string) (accessField, error) {
+	text := func(value func(r *accessRecord) string) accessField {
+		return func(b []byte, r *accessRecord) []byte { return appendLogItem(b, value(r)) }
+	}
+	if arg != "" {
+		switch directive {
+		case 'i':
+			return text(func(r *accessRecord) string { return r.c.Get(arg) }), nil
+		case 'o':
+			return text(func(r *accessRecord) string { return string(r.c.Response().Header.Peek(arg)) }), nil
+		case 'x':
+			switch arg {
+			case "trace_id":
+				return text(func(r *accessRecord) string { return traceIDOf(r.span) }), nil
+			case "span_id":
+				return text(func(r *accessRecord) string { return spanIDOf(r.span) }), nil
+			case "request_id":
+				return text(func(r *accessRecord) string { return requestIDOf(r.c) }), nil
+			}
+			return nil, fmt.Errorf("unknown %%{%s}x: use trace_id, span_id, or request_id", arg)
+		}
+		return nil, fmt.Errorf("%%{%s}%c is not supported", arg, directive)
+	}
+
+	switch directive {
+	case '%':
+		return func(b []byte, _ *accessRecord) []byte { return append(b, '%') }, nil
+	case 'a', 'h':
+		return text(func(r *accessRecord) string { return r.c.IP() }), nil
+	case 'l':
+		return func(b []byte, _ *accessRecord) []byte { return append(b, '-') }, nil
+	case 'u':
+		return text(func(r *accessRecord) string { return remoteUser(r.c) }), nil
+	case 't':
+		return func(b []byte, r *accessRecord) []byte {
+			return r.start.AppendFormat(b, "[02/Jan/2006:15:04:05 -0700]")
+		}, nil
+	case 'r':
+		return text(func(r *accessRecord) string {
+			return r.c.Method() + " " + r.c.OriginalURL() + " " + string(r.c.Request().Header.Protocol())
+		}), nil
+	case 'm':
+		return text(func(r *accessRecord) string { return r.c.Method() }), nil
+	case 'U':
+		return text(func(r *accessRecord) string { return r.c.Path() }), nil
+	case 'q':
+		return func(b []byte, r *accessRecord) []byte {
+			if query := r.c.Context().QueryArgs().QueryString(); len(query) > 0 {
+				return appendLogItem(append(b, '?'), string(query))
+			}
+			return b
+		}, nil
+	case 'H':
+		return text(func(r *accessRecord) string { return string(r.c.Request().Header.Protocol()) }), nil
+	case 's':
+		return func(b []byte, r *accessRecord) []byte { return strconv.AppendInt(b, int64(r.status), 10) }, nil
+	case 'b':
+		return func(b []byte, r *accessRecord) []byte {
+			if r.bytes == 0 {
+				return append(b, '-')
+			}
+			return strconv.AppendInt(b, int64(r.bytes), 10)
+		}, nil
+	case 'B':
+		return func(b []byte, r *accessRecord) []byte { return strconv.AppendInt(b, int64(r.bytes), 10) }, nil
+	case 'D':
+		return func(b []byte, r *accessRecord) []byte { return strconv.AppendInt(b, r.duration.Microseconds(), 10) }, nil
+	case 'T':
+		return func(b []byte, r *accessRecord) []byte { return strconv.AppendInt(b, int64(r.duration/time.Second), 10) }, nil
+	case 'v', 'V':
+		return text(func(r *accessRecord) string { return r.c.Hostname() }), nil
+	}
+	return nil, fmt.Errorf("%%%c is not supported", directive)
+}
+
+// appendLogItem appends a value as Apache escapes it, with "-" for empty
+// values, so a line always splits into the same fields
+func appendLogItem(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c == '\r':
+			b = append(b, '\\', 'r')
+		case c == '\t':
+			b = append(b, '\\', 't')
+		case c < 0x20 || c >= 0x7f:
+			b = append(b, fmt.Sprintf("\\x%02x", c)...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// remoteUser returns the user of Basic authentication
+func remoteUser(c *fiber.Ctx) string {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) < 6 || !strings.EqualFold(auth[:6], "basic ") {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[6:])
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user
+}
+
+// requestIDOf returns the request ID header, or the ID of Fiber's requestid
+// middleware
+func requestIDOf(c *fiber.Ctx) string {
+	if id := c.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
+
+func traceIDOf(sc trace.SpanContext) string {
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+func spanIDOf(sc trace.SpanContext) string {
+	if !sc.HasSpanID() {
+		return ""
+	}
+	return sc.SpanID().String()
+}
+
+// accessStatus is the status the client receives: a handler's error is
+// turned into a response by the error handler after the middleware returns
+func accessStatus(c *fiber.Ctx, err error) int {
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &fiberErr):
+		return fiberErr.Code
+	case err != nil:
+		return fiber.StatusInternalServerError
+	}
+	return c.Response().StatusCode()
+}
+
+// Log writes the line of a finished request; it is called by the logging
+// middlewares after the handler returned err
+func (a *AccessLogger) Log(c *fiber.Ctx, start time.Time, err error) {
+	r := &accessRecord{
+		c:        c,
+		start:    start,
+		duration: time.Since(start),
+		status:   accessStatus(c, err),
+		bytes:    len(c.Response().Body()),
+		err:      err,
+		span:     trace.SpanContextFromContext(c.UserContext()),
+	}
+
+	var line []byte
+	if a.format == AccessLogECS {
+		line = a.ecsLine(r)
+	} else {
+		for _, field := range a.fields {
+			line = field(line, r)
+		}
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = a.out.Write(line)
+}
+
+// ecsEntry is an access log entry following the Elastic Common Schema
+type ecsEntry struct {
+	Timestamp string `json:"@timestamp"`
+	Log       struct {
+		Level  string `json:"level"`
+		Logger string `json:"logger"`
+	} `json:"log"`
+	Message string `json:"message"`
+	ECS     struct {
+		Version string `json:"version"`
+	} `json:"ecs"`
+	Event struct {
+		Kind     string   `json:"kind"`
+		Category []string `json:"category"`
+		Type     []string `json:"type"`
+		Outcome  string   `json:"outcome"`
+		Duration int64    `json:"duration"`
+	} `json:"event"`
+	HTTP struct {
+		Version string `json:"version,omitempty"`
+		Request struct {
+			ID       string `json:"id,omitempty"`
+			Method   string `json:"method"`
+			Referrer string `json:"referrer,omitempty"`
+		} `json:"request"`
+		Response struct {
+			StatusCode int `json:"status_code"`
+			Body       struct {
+				Bytes int `json:"bytes"`
+			} `json:"body"`
+		} `json:"response"`
+	} `json:"http"`
+	URL struct {
+		Original string `json:"original"`
+		Path     string `json:"path"`
+		Query    string `json:"query,omitempty"`
+		Domain   string `json:"domain,omitempty"`
+	} `json:"url"`
+	Client struct {
+		IP string `json:"ip,omitempty"`
+	} `json:"client"`
+	UserAgent *ecsOriginal `json:"user_agent,omitempty"`
+	User      *ecsName     `json:"user,omitempty"`
+	Service   *ecsName     `json:"service,omitempty"`
+	Trace     *ecsID       `json:"trace,omitempty"`
+	Span      *ecsID       `json:"span,omitempty"`
+	Error     *ecsError    `json:"error,omitempty"`
+}
+
+type ecsOriginal struct {
+	Original string `json:"original"`
+}
+
+type ecsName struct {
+	Name string `json:"name"`
+}
+
+type ecsID struct {
+	ID string `json:"id"`
+}
+
+type ecsError struct {
+	Message string `json:"message"`
+}
+
+// ecsLine renders the ECS entry of a request
+func (a *AccessLogger) ecsLine(r *accessRecord) []byte {
+	c := r.c
+	var e ecsEntry
+	e.Timestamp = r.start.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	e.Log.Logger = "access"
+	e.ECS.Version = ecsVersion
+	e.Event.Kind = "event"
+	e.Event.Category = []string{"web"}
+	e.Event.Type = []string{"access"}
+	e.Event.Duration = r.duration.Nanoseconds()
+	e.Event.Outcome = "success"
+	switch {
+	case r.status >= 500:
+		e.Log.Level = "error"
+		e.Event.Outcome = "failure"
+	case r.status >= 400:
+		e.Log.Level = "warn"
+	default:
+		e.Log.Level = "info"
+	}
+	e.Message = fmt.Sprintf("%s %s %d", c.Method(), c.OriginalURL(), r.status)
+
+	e.HTTP.Version = strings.TrimPrefix(string(c.Request().Header.Protocol()), "HTTP/")
+	e.HTTP.Request.ID = requestIDOf(c)
+	e.HTTP.Request.Method = c.Method()
+	e.HTTP.Request.Referrer = c.Get(fiber.HeaderReferer)
+	e.HTTP.Response.StatusCode = r.status
+	e.HTTP.Response.Body.Bytes = r.bytes
+	e.URL.Original = c.OriginalURL()
+	e.URL.Path = c.Path()
+	e.URL.Query = string(c.Context().QueryArgs().QueryString())
+	e.URL.Domain = c.Hostname()
+	e.Client.IP = c.IP()
+
+	if ua := c.Get(fiber.HeaderUserAgent); ua != "" {
+		e.UserAgent = &ecsOriginal{Original: ua}
+	}
+	if user := remoteUser(c); user != "" {
+		e.User = &ecsName{Name: user}
+	}
+	if a.service != "" {
+		e.Service = &ecsName{Name: a.service}
+	}
+	if id := traceIDOf(r.span); id != "" {
+		e.Trace = &ecsID{ID: id}
+	}
+	if id := spanIDOf(r.span); id != "" {
+		e.Span = &ecsID{ID: id}
+	}
+	if r.err != nil {
+		e.Error = &ecsError{Message: r.err.Error()}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return []byte(fmt.Sprintf(`{"message":%q}`, err.Error()))
+	}
+	return line
+}
@@ -0,0 +1,158 @@
+package instrumentation
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+)
+
+// accessLogApp serves /orders and /fail behind the logging middleware with
+// an access log in format
+func accessLogApp(t *testing.T, format string, out *bytes.Buffer) *fiber.App {
+	t.Helper()
+	accessLog, err := NewAccessLogger(AccessLogConfig{Format: format, Output: out, ServiceName: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Use(FiberOtelMiddleware("test"))
+	app.Use(LoggerMiddleware(zap.NewNop(), WithAccessLog(accessLog)))
+	app.Get("/orders", func(c *fiber.Ctx) error { return c.SendString("[1,2,3]") })
+	app.Get("/fail", func(c *fiber.Ctx) error { return fiber.NewError(fiber.StatusBadGateway, "upstream down") })
+	return app
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var out bytes.Buffer
+	app := accessLogApp(t, AccessLogCombined, &out)
+
+	req := httptest.NewRequest("GET", "/orders?page=2", nil)
+	req.Header.Set(fiber.HeaderXForwardedFor, "10.1.2.3")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set(fiber.HeaderReferer, "https://shop.example.com/")
+	req.Header.Set(fiber.HeaderUserAgent, `curl/8.0 "quoted"`)
+	req.SetBasicAuth("alice", "secret")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Test(httptest.NewRequest("GET", "/fail", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q", lines)
+	}
+	want := regexp.MustCompile(`^10\.1\.2\.3 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /orders\?page=2 HTTP/1\.1" 200 7 "https://shop\.example\.com/" "curl/8\.0 \\"quoted\\""$`)
+	if !want.MatchString(lines[0]) {
+		t.Errorf("combined line = %s", lines[0])
+	}
+	// The error handler turns the error into the response after the
+	// middleware, so the status comes from the error
+	if !strings.Contains(lines[1], `"GET /fail HTTP/1.1" 502 - "-" "-"`) {
+		t.Errorf("error line = %s", lines[1])
+	}
+}
+
+func TestAccessLogTemplate(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(previous)
+
+	var out bytes.Buffer
+	app := accessLogApp(t, `%m %U%q %>s %B %{X-Cache}o %{trace_id}x %{request_id}x 100%%`, &out)
+	req := httptest.NewRequest("GET", "/orders?page=2", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^GET /orders\?page=2 200 7 - [0-9a-f]{32} req-1 100%\n$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("template line = %q", out.String())
+	}
+}
+
+func TestAccessLogECS(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(previous)
+
+	var out bytes.Buffer
+	app := accessLogApp(t, AccessLogECS, &out)
+	req := httptest.NewRequest("GET", "/fail?retry=1", nil)
+	req.Header.Set(fiber.HeaderUserAgent, "k6/0.47")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		Timestamp string `json:"@timestamp"`
+		Log       struct{ Level string }
+		ECS       struct{ Version string }
+		Event     struct {
+			Category []string
+			Outcome  string
+			Duration int64
+		}
+		HTTP struct {
+			Request  struct{ Method string }
+			Response struct {
+				StatusCode int `json:"status_code"`
+			}
+		}
+		URL struct {
+			Original string
+			Path     string
+			Query    string
+		}
+		UserAgent struct{ Original string } `json:"user_agent"`
+		Service   struct{ Name string }
+		Trace     struct{ ID string }
+		Error     struct{ Message string }
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid ECS line %q: %v", out.String(), err)
+	}
+	if entry.ECS.Version != ecsVersion || entry.Event.Category[0] != "web" || entry.Event.Outcome != "failure" || entry.Log.Level != "error" {
+		t.Errorf("event = %+v, log = %+v", entry.Event, entry.Log)
+	}
+	if entry.HTTP.Request.Method != "GET" || entry.HTTP.Response.StatusCode != 502 {
+		t.Errorf("http = %+v", entry.HTTP)
+	}
+	if entry.URL.Original != "/fail?retry=1" || entry.URL.Path != "/fail" || entry.URL.Query != "retry=1" {
+		t.Errorf("url = %+v", entry.URL)
+	}
+	if entry.UserAgent.Original != "k6/0.47" || entry.Service.Name != "shop" || len(entry.Trace.ID) != 32 || entry.Error.Message != "upstream down" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if !strings.HasSuffix(entry.Timestamp, "Z") || entry.Event.Duration <= 0 {
+		t.Errorf("timestamp %s, duration %d", entry.Timestamp, entry.Event.Duration)
+	}
+}
+
+func TestValidateAccessLogFormat(t *testing.T) {
+	for _, format := range []string{"", AccessLogZap, AccessLogCommon, AccessLogCombined, AccessLogECS, `%h "%r" %>s %D`} {
+		if err := ValidateAccessLogFormat(format); err != nil {
+			t.Errorf("%q: %v", format, err)
+		}
+	}
+	for _, format := range []string{"apache", "%h %", "%{Referer", "%Z", "%{foo}x", "%{foo}s"} {
+		if err := ValidateAccessLogFormat(format); err == nil {
+			t.Errorf("%q accepted", format)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Logging.AccessFormat = "apache"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "LOG_ACCESS_FORMAT") {
+		t.Errorf("Validate = %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package instrumentation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/openapi"
+	"github.com/chaksack/apm/pkg/security/auth"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// AdminConfig configures the admin API
+type AdminConfig struct {
+	// Prefix is where the API is mounted, /admin by default
+	Prefix string
+	// RBAC authorizes requests against the runtime resource: reading needs
+	// the read action, changing a control the update action
+	RBAC *auth.RBACManager
+	// JWT and APIKeys authenticate requests that arrive without an auth
+	// context set by earlier middleware; nil disables the scheme
+	JWT     *auth.JWTManager
+	APIKeys *auth.APIKeyManager
+	// APIKeyHeader carries API keys, X-API-Key by default
+	APIKeyHeader string
+	// Usage, when set, serves per-client usage at /admin/usage/clients
+	Usage *ClientUsage
+}
+
+// RegisterAdminAPI mounts the runtime controls on router as one
+// authenticated API group:
+//
+//	GET    /admin/controls          all controls
+//	PUT    /admin/sampling          {"rate": 0.1}, or {"rate": null} to reset
+//	PUT    /admin/log-level         {"level": "debug"}
+//	PUT    /admin/flags/:name       {"enabled": true}
+//	DELETE /admin/flags/:name
+//	PUT    /admin/faults            {"rules": [...], "ttl": "10m"}
+//	DELETE /admin/faults
+//	PUT    /admin/cardinality       {"max_series": 5000}
+//	GET    /admin/quota             usage against the quota
+//	GET    /admin/buckets           histogram bucket fit and suggestions
+//	GET    /admin/exporters         startup check of the telemetry backends
+//	GET    /admin/usage/clients     per-client API usage, with AdminConfig.Usage
+//
+// Every change is logged with the user who made it. DescribeAdminAPI
+// documents these routes.
+func (i *Instrumentation) RegisterAdminAPI(router fiber.Router, cfg AdminConfig) error {
+	if cfg.RBAC == nil {
+		return errors.New("admin API requires an RBAC manager")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "/admin"
+	}
+	if cfg.APIKeyHeader == "" {
+		cfg.APIKeyHeader = "X-API-Key"
+	}
+
+	ctl := i.Controls
+	ctl.mu.Lock()
+	ctl.faultExempt = cfg.Prefix
+	ctl.mu.Unlock()
+
+	a := &adminAPI{inst: i, config: cfg}
+	group := router.Group(cfg.Prefix, a.authenticate)
+	read := a.authorize(auth.ActionRead)
+	update := a.authorize(auth.ActionUpdate)
+
+	group.Get("/controls", read, func(c *fiber.Ctx) error {
+		return c.JSON(ctl.Snapshot())
+	})
+
+	group.Put("/sampling", update, func(c *fiber.Ctx) error {
+		var req samplingRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if err := ctl.SetSampleRate(req.Rate); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		value := "configured sampler"
+		if req.Rate != nil {
+			value = fmt.Sprint(*req.Rate)
+		}
+		return a.changed(c, "sampling", value)
+	})
+
+	group.Put("/log-level", update, func(c *fiber.Ctx) error {
+		var req logLevelRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if err := ctl.SetLogLevel(req.Level); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		return a.changed(c, "log_level", req.Level)
+	})
+
+	group.Put("/flags/:name", update, func(c *fiber.Ctx) error {
+		var req flagRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		ctl.SetFlag(c.Params("name"), req.Enabled)
+		return a.changed(c, "flag "+c.Params("name"), fmt.Sprint(req.Enabled))
+	})
+
+	group.Delete("/flags/:name", update, func(c *fiber.Ctx) error {
+		ctl.DeleteFlag(c.Params("name"))
+		return a.changed(c, "flag "+c.Params("name"), "deleted")
+	})
+
+	group.Put("/faults", update, func(c *fiber.Ctx) error {
+		var req faultsRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "ttl must be a duration such as 10m")
+			}
+		}
+		if err := ctl.SetFaults(req.Rules, ttl); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		return a.changed(c, "faults", fmt.Sprintf("%d rules", len(req.Rules)))
+	})
+
+	group.Delete("/faults", update, func(c *fiber.Ctx) error {
+		_ = ctl.SetFaults(nil, 0)
+		return a.changed(c, "faults", "cleared")
+	})
+
+	group.Put("/cardinality", update, func(c *fiber.Ctx) error {
+		var req cardinalityRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		if err := ctl.SetMaxSeries(req.MaxSeries); err != nil {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		return a.changed(c, "max_series", fmt.Sprint(req.MaxSeries))
+	})
+
+	group.Get("/quota", read, func(c *fiber.Ctx) error {
+		if i.Quota == nil {
+			return fiber.NewError(fiber.StatusNotFound, "quotas are disabled")
+		}
+		return c.JSON(i.Quota.Usage())
+	})
+
+	group.Get("/buckets", read, func(c *fiber.Ctx) error {
+		reports, err := i.AnalyzeBuckets()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(reports)
+	})
+
+	group.Get("/exporters", read, func(c *fiber.Ctx) error {
+		if i.Exporters == nil {
+			return fiber.NewError(fiber.StatusNotFound, "exporters were not checked")
+		}
+		return c.JSON(i.Exporters.Report())
+	})
+
+	if cfg.Usage != nil {
+		group.Get("/usage/clients", read, cfg.Usage.Handler())
+	}
+
+	return nil
+}
+
+// Admin API request bodies
+type (
+	samplingRequest struct {
+		Rate *float64 `json:"rate"`
+	}
+	logLevelRequest struct {
+		Level string `json:"level"`
+	}
+	flagRequest struct {
+		Enabled bool `json:"enabled"`
+	}
+	faultsRequest struct {
+		Rules []FaultRule `json:"rules"`
+		TTL   string      `json:"ttl"`
+	}
+	cardinalityRequest struct {
+		MaxSeries int `json:"max_series"`
+	}
+)
+
+// DescribeAdminAPI adds the admin API mounted with cfg to an OpenAPI
+// document, so services can publish it with their own routes
+func DescribeAdminAPI(b *openapi.Builder, cfg AdminConfig) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "/admin"
+	}
+	if cfg.APIKeyHeader == "" {
+		cfg.APIKeyHeader = "X-API-Key"
+	}
+
+	var security []string
+	if cfg.JWT != nil {
+		b.SecurityScheme("adminBearer", &openapi.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"})
+		security = append(security, "adminBearer")
+	}
+	if cfg.APIKeys != nil {
+		b.SecurityScheme("adminAPIKey", &openapi.SecurityScheme{Type: "apiKey", In: "header", Name: cfg.APIKeyHeader})
+		security = append(security, "adminAPIKey")
+	}
+	b.Tag("admin", "Runtime controls")
+
+	// Changes answer with the new state of the controls
+	route := func(id, summary string, request any, errors ...int) openapi.Route {
+		return openapi.Route{
+			ID: id, Summary: summary, Tags: []string{"admin"},
+			Request:    request,
+			Response:   ControlsSnapshot{},
+			Errors:     append([]int{fiber.StatusUnauthorized, fiber.StatusForbidden}, errors...),
+			TextErrors: true,
+			Security:   security,
+		}
+	}
+	b.Add(fiber.MethodGet, cfg.Prefix+"/controls", route("getControls", "Get the runtime controls", nil))
+	b.Add(fiber.MethodPut, cfg.Prefix+"/sampling", route("setSampling", "Override the sample rate, or reset it with a null rate", samplingRequest{}, fiber.StatusBadRequest, fiber.StatusBadGateway))
+	b.Add(fiber.MethodPut, cfg.Prefix+"/log-level", route("setLogLevel", "Change the log level", logLevelRequest{}, fiber.StatusBadRequest, fiber.StatusBadGateway))
+	b.Add(fiber.MethodPut, cfg.Prefix+"/flags/:name", route("setFlag", "Turn a feature flag on or off", flagRequest{}, fiber.StatusBadRequest, fiber.StatusBadGateway))
+	b.Add(fiber.MethodDelete, cfg.Prefix+"/flags/:name", route("deleteFlag", "Delete a feature flag", nil, fiber.StatusBadGateway))
+	b.Add(fiber.MethodPut, cfg.Prefix+"/faults", route("setFaults", "Inject faults for a while", faultsRequest{}, fiber.StatusBadRequest, fiber.StatusBadGateway))
+	b.Add(fiber.MethodDelete, cfg.Prefix+"/faults", route("clearFaults", "Stop injecting faults", nil, fiber.StatusBadGateway))
+	b.Add(fiber.MethodPut, cfg.Prefix+"/cardinality", route("setMaxSeries", "Change the series quota", cardinalityRequest{}, fiber.StatusConflict, fiber.StatusBadGateway))
+
+	quota := route("getQuota", "Get usage against the quota", nil, fiber.StatusNotFound)
+	quota.Response = QuotaUsage{}
+	b.Add(fiber.MethodGet, cfg.Prefix+"/quota", quota)
+	buckets := route("getBucketReports", "Report how well histogram buckets fit, with suggestions", nil, fiber.StatusInternalServerError)
+	buckets.Response = []BucketReport{}
+	b.Add(fiber.MethodGet, cfg.Prefix+"/buckets", buckets)
+	exporters := route("getExporters", "Get the startup check of the telemetry backends", nil, fiber.StatusNotFound)
+	exporters.Response = ExporterReport{}
+	b.Add(fiber.MethodGet, cfg.Prefix+"/exporters", exporters)
+	if cfg.Usage != nil {
+		usage := route("getClientUsage", "Get per-client API usage", nil, fiber.StatusBadRequest)
+		usage.Response = ClientUsageReport{}
+		usage.Query = []openapi.Param{
+			{Name: "client", Description: "Only this client"},
+			{Name: "from", Description: "Start, RFC 3339 or Unix seconds; an hour ago by default"},
+			{Name: "to", Description: "End, RFC 3339 or Unix seconds; now by default"},
+			{Name: "step", Description: "Resolution, such as 5m; 1m by default"},
+		}
+		b.Add(fiber.MethodGet, cfg.Prefix+"/usage/clients", usage)
+	}
+}
+
+type adminAPI struct {
+	inst   *Instrumentation
+	config AdminConfig
+}
+
+// authenticate resolves the caller from an auth context set by earlier
+// middleware, a bearer JWT, or an API key
+func (a *adminAPI) authenticate(c *fiber.Ctx) error {
+	if authCtx := auth.GetAuthContext(c); authCtx != nil && authCtx.User != nil {
+		return c.Next()
+	}
+
+	if token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok && a.config.JWT != nil {
+		claims, err := a.config.JWT.ValidateToken(token)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid token")
+		}
+		user := claims.User
+		if len(user.Roles) == 0 {
+			user.Roles = claims.Roles
+		}
+		auth.SetAuthContext(c, &auth.AuthContext{User: &user, AuthType: auth.AuthTypeJWT, Claims: claims})
+		return c.Next()
+	}
+
+	if key := c.Get(a.config.APIKeyHeader); key != "" && a.config.APIKeys != nil {
+		apiKey, err := a.config.APIKeys.ValidateAPIKey(key)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid API key")
+		}
+		user := &auth.User{ID: apiKey.UserID, Username: apiKey.Name, Roles: apiKey.Roles}
+		auth.SetAuthContext(c, &auth.AuthContext{User: user, AuthType: auth.AuthTypeAPIKey, ClientID: apiKey.ID})
+		return c.Next()
+	}
+
+	return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+}
+
+// authorize requires the caller's roles to allow action on the runtime
+// resource
+func (a *adminAPI) authorize(action auth.Action) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := auth.GetAuthContext(c).User
+		if !a.config.RBAC.CheckPermission(user.Roles, string(auth.ResourceRuntime), string(action)) {
+			a.inst.Logger.Warn("admin API access denied",
+				zap.String("user", user.ID),
+				zap.Strings("roles", user.Roles),
+				zap.String("method", c.Method()),
+				zap.String("path", c.Path()))
+			return fiber.NewError(fiber.StatusForbidden, "insufficient permissions")
+		}
+		return c.Next()
+	}
+}
+
+// changed logs a control change, shares it with the other replicas, and
+// responds with the new state
+func (a *adminAPI) changed(c *fiber.Ctx, control, value string) error {
+	user := auth.GetAuthContext(c).User
+	a.inst.Logger.Info("runtime control changed",
+		zap.String("control", control),
+		zap.String("value", value),
+		zap.String("user", user.ID),
+		zap.String("username", user.Username))
+
+	if err := a.inst.Controls.Publish(c.UserContext()); err != nil {
+		a.inst.Logger.Error("failed to share runtime control change", zap.String("control", control), zap.Error(err))
+		return fiber.NewError(fiber.StatusBadGateway, "changed on this replica only: "+err.Error())
+	}
+	return c.JSON(a.inst.Controls.Snapshot())
+}
@@ -0,0 +1,204 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/openapi"
+	"github.com/chaksack/apm/pkg/security/auth"
+	"github.com/gofiber/fiber/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newAdminApp(t *testing.T) (*fiber.App, *Instrumentation, map[string]string) {
+	t.Helper()
+	inst := &Instrumentation{Logger: zap.NewNop(), Controls: NewControls(zap.NewAtomicLevelAt(zapcore.InfoLevel), nil)}
+	keys := auth.NewAPIKeyManager(auth.APIKeyConfig{}, zap.NewNop())
+
+	raw := map[string]string{}
+	for _, role := range []string{"operator", "viewer"} {
+		_, key, err := keys.GenerateAPIKey(role, role+"-user", []string{role}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[role] = key
+	}
+
+	app := fiber.New()
+	app.Use(inst.Controls.FaultMiddleware())
+	err := inst.RegisterAdminAPI(app, AdminConfig{
+		RBAC:    auth.NewRBACManager(auth.RBACConfig{}, zap.NewNop()),
+		APIKeys: keys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Get("/api/orders", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	return app, inst, raw
+}
+
+func adminRequest(t *testing.T, app *fiber.App, method, path, key, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestAdminAPIAuthorization(t *testing.T) {
+	app, inst, keys := newAdminApp(t)
+
+	if code := adminRequest(t, app, "GET", "/admin/controls", "", ""); code != fiber.StatusUnauthorized {
+		t.Errorf("anonymous read = %d, want 401", code)
+	}
+	if code := adminRequest(t, app, "GET", "/admin/controls", "bogus", ""); code != fiber.StatusUnauthorized {
+		t.Errorf("unknown key = %d, want 401", code)
+	}
+	if code := adminRequest(t, app, "GET", "/admin/controls", keys["viewer"], ""); code != fiber.StatusOK {
+		t.Errorf("viewer read = %d, want 200", code)
+	}
+	if code := adminRequest(t, app, "PUT", "/admin/log-level", keys["viewer"], `{"level":"debug"}`); code != fiber.StatusForbidden {
+		t.Errorf("viewer update = %d, want 403", code)
+	}
+	if inst.Controls.LogLevel() != zapcore.InfoLevel {
+		t.Fatal("viewer changed the log level")
+	}
+
+	if code := adminRequest(t, app, "PUT", "/admin/log-level", keys["operator"], `{"level":"debug"}`); code != fiber.StatusOK {
+		t.Errorf("operator update = %d, want 200", code)
+	}
+	if inst.Controls.LogLevel() != zapcore.DebugLevel {
+		t.Errorf("log level = %s, want debug", inst.Controls.LogLevel())
+	}
+	if code := adminRequest(t, app, "PUT", "/admin/log-level", keys["operator"], `{"level":"loud"}`); code != fiber.StatusBadRequest {
+		t.Errorf("invalid level = %d, want 400", code)
+	}
+	if code := adminRequest(t, app, "PUT", "/admin/cardinality", keys["operator"], `{"max_series":10}`); code != fiber.StatusConflict {
+		t.Errorf("cardinality without quotas = %d, want 409", code)
+	}
+}
+
+func TestAdminAPIFlagsAndFaults(t *testing.T) {
+	app, inst, keys := newAdminApp(t)
+	operator := keys["operator"]
+
+	if code := adminRequest(t, app, "PUT", "/admin/flags/new-checkout", operator, `{"enabled":true}`); code != fiber.StatusOK {
+		t.Fatalf("set flag = %d", code)
+	}
+	if !inst.Controls.Flag("new-checkout") {
+		t.Error("flag not enabled")
+	}
+	adminRequest(t, app, "DELETE", "/admin/flags/new-checkout", operator, "")
+	if inst.Controls.Flag("new-checkout") {
+		t.Error("deleted flag still enabled")
+	}
+
+	// A catch-all fault fails every request except the admin API's own
+	rules := `{"rules":[{"error_rate":1,"status":500}],"ttl":"1m"}`
+	if code := adminRequest(t, app, "PUT", "/admin/faults", operator, rules); code != fiber.StatusOK {
+		t.Fatalf("set faults = %d", code)
+	}
+	if code := adminRequest(t, app, "GET", "/api/orders", "", ""); code != fiber.StatusInternalServerError {
+		t.Errorf("faulted request = %d, want 500", code)
+	}
+	if code := adminRequest(t, app, "DELETE", "/admin/faults", operator, ""); code != fiber.StatusOK {
+		t.Errorf("clear faults = %d, want the admin API exempt from faults", code)
+	}
+	if code := adminRequest(t, app, "GET", "/api/orders", "", ""); code != fiber.StatusOK {
+		t.Errorf("request after clearing faults = %d", code)
+	}
+}
+
+func TestDescribeAdminAPI(t *testing.T) {
+	app, _, _ := newAdminApp(t)
+	b := openapi.NewBuilder(openapi.Info{Title: "orders", Version: "1"})
+	DescribeAdminAPI(b, AdminConfig{APIKeys: auth.NewAPIKeyManager(auth.APIKeyConfig{}, zap.NewNop())})
+	doc := b.Document()
+
+	described := 0
+	for path, item := range doc.Paths {
+		described += len(item.Operations())
+		for _, op := range item.Operations() {
+			if len(op.Operation.Security) != 1 {
+				t.Errorf("%s %s security = %v, want the API key", op.Method, path, op.Operation.Security)
+			}
+		}
+	}
+	mounted := 0
+	for _, route := range app.GetRoutes(true) {
+		if strings.HasPrefix(route.Path, "/admin/") && route.Method != fiber.MethodHead {
+			mounted++
+			path := strings.Replace(route.Path, ":name", "{name}", 1)
+			if doc.Paths[path] == nil {
+				t.Errorf("%s %s is not described", route.Method, route.Path)
+			}
+		}
+	}
+	if described != mounted {
+		t.Errorf("%d operations described, %d mounted", described, mounted)
+	}
+	if _, err := openapi.GenerateClient(doc, "adminclient"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestControlsFaultExpiry(t *testing.T) {
+	ctl := NewControls(zap.NewAtomicLevel(), nil)
+	if err := ctl.SetFaults([]FaultRule{{Path: "/api", ErrorRate: 2}}, 0); err == nil {
+		t.Error("error rate above 1 accepted")
+	}
+	if err := ctl.SetFaults([]FaultRule{{Path: "/api", ErrorRate: 1, ExpiresAt: time.Now().Add(-time.Second)}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ctl.matchFault("GET", "/api/orders"); ok {
+		t.Error("expired fault matched")
+	}
+	if err := ctl.SetFaults([]FaultRule{{Path: "/api", Method: "post"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if rule, ok := ctl.matchFault("POST", "/api/orders"); !ok || rule.Status != fiber.StatusServiceUnavailable {
+		t.Errorf("rule = %+v, %v, want a 503 fault for POST", rule, ok)
+	}
+	if _, ok := ctl.matchFault("GET", "/api/orders"); ok {
+		t.Error("fault matched another method")
+	}
+}
+
+func TestControlsSampler(t *testing.T) {
+	ctl := NewControls(zap.NewAtomicLevel(), nil)
+	sampler := ctl.Sampler(sdktrace.AlwaysSample())
+	params := sdktrace.SamplingParameters{ParentContext: context.Background(), TraceID: trace.TraceID{1}, Name: "op"}
+
+	if got := sampler.ShouldSample(params).Decision; got != sdktrace.RecordAndSample {
+		t.Fatalf("decision = %v, want the configured sampler", got)
+	}
+	zero := 0.0
+	if err := ctl.SetSampleRate(&zero); err != nil {
+		t.Fatal(err)
+	}
+	if got := sampler.ShouldSample(params).Decision; got != sdktrace.Drop {
+		t.Errorf("decision = %v, want the override to drop", got)
+	}
+	invalid := 1.5
+	if err := ctl.SetSampleRate(&invalid); err == nil {
+		t.Error("rate above 1 accepted")
+	}
+	if err := ctl.SetSampleRate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := sampler.ShouldSample(params).Decision; got != sdktrace.RecordAndSample {
+		t.Errorf("decision = %v after reset, want the configured sampler", got)
+	}
+}
@@ -0,0 +1,332 @@
+// Package apmtest records the telemetry of instrumented code in memory, so
+// application tests can check their spans, metrics, and logs without a
+// collector.
+//
+//	func TestCreateOrder(t *testing.T) {
+//		h := apmtest.New(t)
+//		app := h.App()
+//		app.Post("/orders", createOrder)
+//
+//		resp := h.Do(httptest.NewRequest("POST", "/orders", body))
+//
+//		h.AssertSpan(t, "POST /orders", attribute.Int("http.status_code", 201))
+//		h.AssertMetric(t, "http_requests_total", map[string]string{"status": "2xx"}, 1)
+//		h.AssertLog(t, "order created", map[string]interface{}{"order_id": "o-1"})
+//	}
+//
+// A harness installs its tracer provider and propagator globally, as the
+// instrumentation middleware uses the global ones, and restores the previous
+// ones when the test ends. Tests using a harness must not run in parallel.
+package apmtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// ServiceName is the service name the harness instruments apps as
+const ServiceName = "apmtest"
+
+// Harness records spans, metrics, and logs in memory
+type Harness struct {
+	t testing.TB
+
+	// Spans records every span ended through TracerProvider
+	Spans          *tracetest.SpanRecorder
+	TracerProvider *sdktrace.TracerProvider
+
+	// Registry holds the HTTP metrics of App and any collectors the test
+	// registers
+	Registry *prometheus.Registry
+
+	// Logs records every entry written to Logger, at all levels
+	Logs   *observer.ObservedLogs
+	Logger *zap.Logger
+
+	// Instrumentation is wired to the in-memory recorders
+	Instrumentation *instrumentation.Instrumentation
+
+	mu  sync.Mutex
+	app *fiber.App
+}
+
+// New creates a harness and installs its tracer provider and a W3C trace
+// context propagator until the test ends
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(spans),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+	registry := prometheus.NewRegistry()
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+		_ = tp.Shutdown(context.Background())
+	})
+
+	return &Harness{
+		t:              t,
+		Spans:          spans,
+		TracerProvider: tp,
+		Registry:       registry,
+		Logs:           logs,
+		Logger:         logger,
+		Instrumentation: &instrumentation.Instrumentation{
+			Logger:   logger,
+			Metrics:  instrumentation.NewMetricsCollectorFor(registry, "", ""),
+			Gatherer: registry,
+		},
+	}
+}
+
+// Tracer returns a tracer of the harness's provider
+func (h *Harness) Tracer(name string) trace.Tracer {
+	return h.TracerProvider.Tracer(name)
+}
+
+// App returns a Fiber app with the request ID, tracing, logging, and metrics
+// middleware installed, in the order a service registers them. Do sends requests to the
+// most recently created app.
+func (h *Harness) App(config ...fiber.Config) *fiber.App {
+	app := fiber.New(config...)
+	app.Use(requestid.New())
+	app.Use(instrumentation.FiberOtelMiddleware(ServiceName))
+	app.Use(instrumentation.LoggerMiddleware(h.Logger))
+	app.Use(h.Instrumentation.FiberMiddleware())
+
+	h.mu.Lock()
+	h.app = app
+	h.mu.Unlock()
+	return app
+}
+
+// Do sends a request to the app and fails the test if it cannot be served.
+// Spans of the request have ended when it returns.
+func (h *Harness) Do(req *http.Request) *http.Response {
+	h.t.Helper()
+	h.mu.Lock()
+	app := h.app
+	h.mu.Unlock()
+	if app == nil {
+		h.t.Fatal("apmtest: Do called before App")
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		h.t.Fatalf("apmtest: request %s %s failed: %v", req.Method, req.URL, err)
+	}
+	return resp
+}
+
+// Reset discards the recorded spans and logs
+func (h *Harness) Reset() {
+	h.Spans.Reset()
+	h.Logs.TakeAll()
+}
+
+// EndedSpans returns the spans ended so far, in the order they ended
+func (h *Harness) EndedSpans() []sdktrace.ReadOnlySpan {
+	return h.Spans.Ended()
+}
+
+// FindSpan returns the first ended span with the name and attributes
+func (h *Harness) FindSpan(name string, attrs ...attribute.KeyValue) (sdktrace.ReadOnlySpan, bool) {
+	for _, span := range h.Spans.Ended() {
+		if span.Name() == name && hasAttributes(span.Attributes(), attrs) {
+			return span, true
+		}
+	}
+	return nil, false
+}
+
+// AssertSpan fails the test unless a span with the name and at least the
+// given attributes has ended, and returns it
+func (h *Harness) AssertSpan(t testing.TB, name string, attrs ...attribute.KeyValue) sdktrace.ReadOnlySpan {
+	t.Helper()
+	span, ok := h.FindSpan(name, attrs...)
+	if !ok {
+		t.Fatalf("apmtest: no span %q with %s; recorded:\n%s", name, formatAttributes(attrs), h.describeSpans())
+	}
+	return span
+}
+
+// AssertNoSpan fails the test if a span with the name has ended
+func (h *Harness) AssertNoSpan(t testing.TB, name string) {
+	t.Helper()
+	if _, ok := h.FindSpan(name); ok {
+		t.Errorf("apmtest: unexpected span %q", name)
+	}
+}
+
+// AssertChildOf fails the test unless child's parent is parent
+func (h *Harness) AssertChildOf(t testing.TB, child, parent sdktrace.ReadOnlySpan) {
+	t.Helper()
+	if child.Parent().SpanID() != parent.SpanContext().SpanID() || child.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("apmtest: span %q is not a child of %q", child.Name(), parent.Name())
+	}
+}
+
+// MetricValue sums the series of a metric whose labels include the given
+// ones. Counters and gauges contribute their value, histograms and summaries
+// their observation count. The second result is false when no series match.
+func (h *Harness) MetricValue(name string, labels map[string]string) (float64, bool) {
+	h.t.Helper()
+	families, err := h.Registry.Gather()
+	if err != nil {
+		h.t.Fatalf("apmtest: gathering metrics: %v", err)
+	}
+
+	var sum float64
+	found := false
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+			found = true
+			switch {
+			case m.Counter != nil:
+				sum += m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				sum += m.GetGauge().GetValue()
+			case m.Untyped != nil:
+				sum += m.GetUntyped().GetValue()
+			case m.Histogram != nil:
+				sum += float64(m.GetHistogram().GetSampleCount())
+			case m.Summary != nil:
+				sum += float64(m.GetSummary().GetSampleCount())
+			}
+		}
+	}
+	return sum, found
+}
+
+// AssertMetric fails the test unless MetricValue returns want
+func (h *Harness) AssertMetric(t testing.TB, name string, labels map[string]string, want float64) {
+	t.Helper()
+	got, ok := h.MetricValue(name, labels)
+	if !ok {
+		t.Errorf("apmtest: no series of %s with %v", name, labels)
+		return
+	}
+	if got != want {
+		t.Errorf("apmtest: %s%v = %g, want %g", name, labels, got, want)
+	}
+}
+
+// AssertLog fails the test unless an entry with the message and at least
+// the given fields was logged, and returns it. Field values are compared in
+// their fmt %v form.
+func (h *Harness) AssertLog(t testing.TB, message string, fields map[string]interface{}) observer.LoggedEntry {
+	t.Helper()
+	for _, entry := range h.Logs.FilterMessage(message).All() {
+		if hasFields(entry.ContextMap(), fields) {
+			return entry
+		}
+	}
+	var logged []string
+	for _, entry := range h.Logs.All() {
+		logged = append(logged, fmt.Sprintf("  %s %q %v", entry.Level, entry.Message, entry.ContextMap()))
+	}
+	t.Fatalf("apmtest: no log %q with %v; logged:\n%s", message, fields, strings.Join(logged, "\n"))
+	return observer.LoggedEntry{}
+}
+
+func hasAttributes(have, want []attribute.KeyValue) bool {
+	for _, w := range want {
+		found := false
+		for _, kv := range have {
+			if kv.Key == w.Key && kv.Value == w.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLabels(m *dto.Metric, want map[string]string) bool {
+	for name, value := range want {
+		found := false
+		for _, lp := range m.GetLabel() {
+			if lp.GetName() == name && lp.GetValue() == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func hasFields(have, want map[string]interface{}) bool {
+	for key, value := range want {
+		got, ok := have[key]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatAttributes(attrs []attribute.KeyValue) string {
+	if len(attrs) == 0 {
+		return "any attributes"
+	}
+	parts := make([]string, len(attrs))
+	for i, kv := range attrs {
+		parts[i] = string(kv.Key) + "=" + kv.Value.Emit()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *Harness) describeSpans() string {
+	spans := h.Spans.Ended()
+	if len(spans) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(spans))
+	for i, span := range spans {
+		attrs := append([]attribute.KeyValue(nil), span.Attributes()...)
+		sort.Slice(attrs, func(a, b int) bool { return attrs[a].Key < attrs[b].Key })
+		lines[i] = fmt.Sprintf("  %q %s", span.Name(), formatAttributes(attrs))
+	}
+	return strings.Join(lines, "\n")
+}
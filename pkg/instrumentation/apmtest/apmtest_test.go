@@ -0,0 +1,77 @@
+package apmtest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+func TestHarness(t *testing.T) {
+	h := New(t)
+	app := h.App()
+	app.Post("/orders/:id", func(c *fiber.Ctx) error {
+		_, span := h.Tracer("orders").Start(c.UserContext(), "save-order")
+		span.SetAttributes(attribute.String("order.id", c.Params("id")))
+		span.End()
+		instrumentation.GetLogger(c).Info("order created", zap.String("order_id", c.Params("id")))
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	resp := h.Do(httptest.NewRequest("POST", "/orders/o-1", nil))
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	request := h.AssertSpan(t, "POST /orders/o-1", attribute.Int("http.status_code", 201))
+	save := h.AssertSpan(t, "save-order", attribute.String("order.id", "o-1"))
+	h.AssertChildOf(t, save, request)
+	h.AssertNoSpan(t, "GET /orders")
+
+	h.AssertMetric(t, "http_requests_total", map[string]string{"method": "POST", "status": "2xx"}, 1)
+	h.AssertMetric(t, "http_request_duration_seconds", nil, 1)
+	if _, ok := h.MetricValue("http_requests_total", map[string]string{"status": "5xx"}); ok {
+		t.Error("expected no 5xx series")
+	}
+
+	entry := h.AssertLog(t, "order created", map[string]interface{}{"order_id": "o-1"})
+	if entry.ContextMap()["request_id"] == nil {
+		t.Errorf("expected the request logger's fields, got %v", entry.ContextMap())
+	}
+
+	h.Reset()
+	if len(h.EndedSpans()) != 0 || h.Logs.Len() != 0 {
+		t.Error("expected Reset to discard spans and logs")
+	}
+}
+
+func TestHarnessReportsMissingSpan(t *testing.T) {
+	h := New(t)
+	_, span := h.Tracer("test").Start(context.Background(), "present")
+	span.End()
+
+	probe := &fakeTB{TB: t}
+	func() {
+		defer func() { recover() }()
+		h.AssertSpan(probe, "absent")
+	}()
+	if !probe.failed {
+		t.Error("expected AssertSpan to fail for a missing span")
+	}
+}
+
+// fakeTB records failures instead of failing the test
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeTB) Helper() {}
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+	panic("fatal")
+}
@@ -0,0 +1,65 @@
+// Package auto instruments a service with a single import and no
+// configuration. Importing it runs instrumentation.AutoInit during program
+// initialization:
+//
+//	import _ "github.com/chaksack/apm/pkg/instrumentation/auto"
+//
+// Go cannot patch fiber.New from an import, so Fiber apps are created with
+// auto.New instead, which adds the middleware, the metrics endpoint, and a
+// shutdown hook:
+//
+//	app := auto.New(fiber.Config{AppName: "checkout"})
+//
+// Building with the apm_noauto tag turns the package into a no-op, so the
+// same code ships without instrumentation. A failed initialization is
+// logged and leaves the service running uninstrumented.
+package auto
+
+import (
+	"log"
+
+	"github.com/chaksack/apm/pkg/instrumentation"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	inst    *instrumentation.Instrumentation
+	initErr error
+)
+
+func init() {
+	if !enabled {
+		return
+	}
+	inst, initErr = instrumentation.AutoInit()
+	if initErr != nil {
+		log.Printf("apm: automatic instrumentation disabled: %v", initErr)
+	}
+}
+
+// Instrumentation returns the instrumentation set up at startup, or nil when
+// it is disabled or failed
+func Instrumentation() *instrumentation.Instrumentation {
+	return inst
+}
+
+// Err returns the error of the automatic initialization, if any
+func Err() error {
+	return initErr
+}
+
+// New creates a Fiber app like fiber.New, instrumented when the automatic
+// initialization succeeded
+func New(config ...fiber.Config) *fiber.App {
+	app := fiber.New(config...)
+	Instrument(app)
+	return app
+}
+
+// Instrument adds the instrumentation to an existing Fiber app; it does
+// nothing when the instrumentation is disabled
+func Instrument(app *fiber.App) {
+	if inst != nil {
+		inst.InstrumentFiber(app)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build apm_noauto
+
+package auto
+
+// Built with the apm_noauto tag: importing the package does nothing
+const enabled = false
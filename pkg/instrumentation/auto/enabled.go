@@ -0,0 +1,5 @@
+//go:build !apm_noauto
+
+package auto
+
+const enabled = true
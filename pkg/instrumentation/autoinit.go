@@ -0,0 +1,186 @@
+package instrumentation
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Zero-config detection inputs, replaced in tests
+var (
+	// podInfoDir is where a Kubernetes downward API volume exposes the pod's
+	// labels
+	podInfoDir = "/etc/podinfo"
+	// agentPort and agentDialTimeout are the port of a local OpenTelemetry
+	// agent and the bound of the probe for it
+	agentPort        = "4317"
+	agentDialTimeout = 250 * time.Millisecond
+	readBuildInfo    = debug.ReadBuildInfo
+)
+
+// podHashSuffix matches the ReplicaSet and pod suffixes Kubernetes appends
+// to a Deployment's pod names, e.g. "-7d9f8b6c5d-x2x4z"
+var podHashSuffix = regexp.MustCompile(`-[a-z0-9]{5,10}-[a-z0-9]{5}$`)
+
+// AutoInit sets up instrumentation without any configuration. It starts from
+// LoadFromEnv and fills in what the environment leaves open:
+//
+//   - the service name from the Kubernetes app labels or pod name, the Go
+//     module, or the binary name
+//   - the version from the module version or VCS revision in the build info
+//   - tracing over OTLP to a local agent on localhost:4317 or the node's
+//     HOST_IP, when one is listening and no exporter is configured
+//
+// Options are applied last and override everything detected.
+func AutoInit(opts ...Option) (*Instrumentation, error) {
+	return New(append([]Option{AutoConfig()}, opts...)...)
+}
+
+// AutoConfig returns the configuration AutoInit uses
+func AutoConfig() *Config {
+	cfg := LoadFromEnv()
+
+	if os.Getenv("SERVICE_NAME") == "" && cfg.ServiceName == "app" {
+		if name := detectServiceName(); name != "" {
+			cfg.ServiceName = name
+			cfg.setInitialField("service", name)
+		}
+	}
+	if os.Getenv("VERSION") == "" && cfg.Version == "unknown" {
+		if version := detectVersion(); version != "" {
+			cfg.Version = version
+			cfg.setInitialField("version", version)
+		}
+	}
+
+	if cfg.Tracing == nil && !tracingDisabledByEnv() {
+		if endpoint := detectLocalAgent(); endpoint != "" {
+			cfg.Tracing = &TracerConfig{
+				ExporterType: "otlp",
+				Endpoint:     endpoint,
+				Sampler:      SamplerParentBasedAlwaysOn,
+			}
+		}
+	}
+	return cfg
+}
+
+// InstrumentFiber adds the metrics, logging, tracing, and fault injection
+// middleware to app, serves the metrics on the configured path, and shuts the
+// instrumentation down with the app
+func (i *Instrumentation) InstrumentFiber(app *fiber.App) {
+	if i.TracerProvider != nil {
+		app.Use(FiberOtelMiddleware(i.config.ServiceName))
+	}
+	app.Use(i.FiberMiddleware())
+	app.Use(i.Controls.FaultMiddleware())
+	if i.config.Metrics.Enabled {
+		app.Get(i.config.Metrics.Path, i.FiberMetricsHandler())
+	}
+	app.Hooks().OnShutdown(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return i.Shutdown(ctx)
+	})
+}
+
+// tracingDisabledByEnv reports whether the environment turned tracing off
+// rather than leaving it unconfigured
+func tracingDisabledByEnv() bool {
+	return parseBool(getEnv("OTEL_SDK_DISABLED", "false")) ||
+		strings.EqualFold(os.Getenv("OTEL_TRACES_EXPORTER"), "none")
+}
+
+// detectServiceName returns the first name found in the Kubernetes pod
+// metadata, the main module path, or the binary name
+func detectServiceName() string {
+	if name := podLabelName(); name != "" {
+		return name
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		pod := getEnv("POD_NAME", os.Getenv("HOSTNAME"))
+		if name := podHashSuffix.ReplaceAllString(pod, ""); name != pod {
+			return name
+		}
+	}
+
+	binary := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	// `go run` and `go test` binaries have generic names
+	if binary == "main" || binary == "" || strings.HasSuffix(binary, ".test") || strings.HasPrefix(os.Args[0], os.TempDir()) {
+		if info, ok := readBuildInfo(); ok && info.Main.Path != "" {
+			return filepath.Base(info.Main.Path)
+		}
+	}
+	return binary
+}
+
+// podLabelName reads app.kubernetes.io/name or app from the downward API
+// labels file
+func podLabelName() string {
+	f, err := os.Open(filepath.Join(podInfoDir, "labels"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	labels := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok {
+			labels[key] = strings.Trim(value, `"`)
+		}
+	}
+	if name := labels["app.kubernetes.io/name"]; name != "" {
+		return name
+	}
+	return labels["app"]
+}
+
+// detectVersion returns the main module version, or the short VCS revision
+// of a development build
+func detectVersion() string {
+	info, ok := readBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			if len(setting.Value) > 12 {
+				return setting.Value[:12]
+			}
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// detectLocalAgent returns the address of an OTLP gRPC agent listening on
+// the node (HOST_IP, as exposed by the downward API) or on localhost
+func detectLocalAgent() string {
+	var candidates []string
+	if hostIP := os.Getenv("HOST_IP"); hostIP != "" {
+		candidates = append(candidates, net.JoinHostPort(hostIP, agentPort))
+	}
+	candidates = append(candidates, net.JoinHostPort("localhost", agentPort))
+
+	for _, addr := range candidates {
+		conn, err := net.DialTimeout("tcp", addr, agentDialTimeout)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package instrumentation
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"testing"
+)
+
+// stubDetection points the detection at a temporary pod info directory, a
+// closed agent port, and the given build info
+func stubDetection(t *testing.T, info *debug.BuildInfo) string {
+	t.Helper()
+	dir := t.TempDir()
+	origDir, origPort, origInfo := podInfoDir, agentPort, readBuildInfo
+	podInfoDir = dir
+	agentPort = closedPort(t)
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return info, info != nil }
+	t.Cleanup(func() { podInfoDir, agentPort, readBuildInfo = origDir, origPort, origInfo })
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("HOST_IP", "")
+	return dir
+}
+
+func closedPort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	return port
+}
+
+func TestAutoConfigServiceName(t *testing.T) {
+	info := &debug.BuildInfo{Main: debug.Module{Path: "github.com/acme/checkout", Version: "v1.4.0"}}
+	dir := stubDetection(t, info)
+
+	// A test binary has a generic name, so the module path is used
+	cfg := AutoConfig()
+	if cfg.ServiceName != "checkout" || cfg.Version != "v1.4.0" {
+		t.Errorf("service, version = %q, %q, want them from the build info", cfg.ServiceName, cfg.Version)
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("HOSTNAME", "payments-api-7d9f8b6c5d-x2x4z")
+	if cfg := AutoConfig(); cfg.ServiceName != "payments-api" {
+		t.Errorf("service = %q, want the Deployment name from the pod name", cfg.ServiceName)
+	}
+
+	labels := "app=\"legacy\"\napp.kubernetes.io/name=\"orders\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "labels"), []byte(labels), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := AutoConfig(); cfg.ServiceName != "orders" || cfg.Logging.InitialFields["service"] != "orders" {
+		t.Errorf("service = %q, want the app.kubernetes.io/name label", cfg.ServiceName)
+	}
+
+	t.Setenv("OTEL_SERVICE_NAME", "explicit")
+	if cfg := AutoConfig(); cfg.ServiceName != "explicit" {
+		t.Errorf("service = %q, want the environment to win over detection", cfg.ServiceName)
+	}
+}
+
+func TestAutoConfigVersionFromVCS(t *testing.T) {
+	stubDetection(t, &debug.BuildInfo{
+		Main:     debug.Module{Path: "example.com/svc", Version: "(devel)"},
+		Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "0123456789abcdef0123"}},
+	})
+	if cfg := AutoConfig(); cfg.Version != "0123456789ab" {
+		t.Errorf("version = %q, want the short revision", cfg.Version)
+	}
+}
+
+func TestAutoConfigLocalAgent(t *testing.T) {
+	stubDetection(t, nil)
+	if cfg := AutoConfig(); cfg.Tracing != nil {
+		t.Fatalf("tracing = %+v without an agent", cfg.Tracing)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	agentPort = strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	t.Setenv("HOST_IP", "127.0.0.1")
+
+	cfg := AutoConfig()
+	if cfg.Tracing == nil || cfg.Tracing.ExporterType != "otlp" || cfg.Tracing.Endpoint != "127.0.0.1:"+agentPort {
+		t.Fatalf("tracing = %+v, want OTLP to the node agent", cfg.Tracing)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if cfg := AutoConfig(); cfg.Tracing != nil {
+		t.Errorf("tracing = %+v, want it to stay disabled", cfg.Tracing)
+	}
+}
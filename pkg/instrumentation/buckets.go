@@ -0,0 +1,391 @@
+package instrumentation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// Native histogram defaults
+const (
+	DefaultNativeBucketFactor = 1.1
+	DefaultNativeMaxBuckets   = 160
+)
+
+// Bucket analysis defaults
+const (
+	// DefaultBucketSamples is how many observations the analyzer keeps per
+	// metric
+	DefaultBucketSamples = 2048
+	// MinBucketObservations is the fewest observations a histogram needs
+	// before its buckets are judged
+	MinBucketObservations = 100
+	// suggestedBuckets is the number of buckets suggested
+	suggestedBuckets = 12
+)
+
+// Bucket fits
+const (
+	BucketFitGood             = "good"
+	BucketFitPoor             = "poor"
+	BucketFitInsufficientData = "insufficient_data"
+)
+
+// HistogramConfig sets the buckets of the histograms whose name matches
+// Metric, declared under "metrics" in apm.yaml:
+//
+//	metrics:
+//	  histograms:
+//	    - metric: http_request_duration_seconds
+//	      buckets: [0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05]
+//	    - metric: db_.*_seconds
+//	      native: true
+//
+// Metric is an anchored regular expression on the full metric name,
+// namespace and subsystem included. The first matching entry applies.
+type HistogramConfig struct {
+	Metric  string    `yaml:"metric" mapstructure:"metric" json:"metric"`
+	Buckets []float64 `yaml:"buckets,omitempty" mapstructure:"buckets" json:"buckets,omitempty"`
+
+	// Native also exposes a native histogram, whose exponential buckets fit
+	// any range. Classic buckets are still exposed for scrapers that do not
+	// read native histograms.
+	Native bool `yaml:"native,omitempty" mapstructure:"native" json:"native,omitempty"`
+	// NativeBucketFactor is the growth factor between native buckets,
+	// DefaultNativeBucketFactor by default
+	NativeBucketFactor float64 `yaml:"native_bucket_factor,omitempty" mapstructure:"native_bucket_factor" json:"native_bucket_factor,omitempty"`
+	// NativeMaxBuckets caps the native buckets, DefaultNativeMaxBuckets by
+	// default
+	NativeMaxBuckets uint32 `yaml:"native_max_buckets,omitempty" mapstructure:"native_max_buckets" json:"native_max_buckets,omitempty"`
+}
+
+// LoadHistogramConfig reads the histograms of the "metrics" section of an
+// apm.yaml file
+func LoadHistogramConfig(path string) ([]HistogramConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Metrics struct {
+			Histograms []HistogramConfig `yaml:"histograms"`
+		} `yaml:"metrics"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file.Metrics.Histograms, nil
+}
+
+type histogramRule struct {
+	HistogramConfig
+	metric *regexp.Regexp
+}
+
+type histogramRules []histogramRule
+
+// compileHistograms validates histogram configs
+func compileHistograms(configs []HistogramConfig) (histogramRules, error) {
+	rules := make(histogramRules, 0, len(configs))
+	for _, config := range configs {
+		if config.Metric == "" {
+			return nil, fmt.Errorf("histogram config has no metric")
+		}
+		metric, err := anchored(config.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("histogram %q: %w", config.Metric, err)
+		}
+		for i, bound := range config.Buckets {
+			if math.IsNaN(bound) || math.IsInf(bound, 0) {
+				return nil, fmt.Errorf("histogram %q: bucket %g is not finite", config.Metric, bound)
+			}
+			if i > 0 && bound <= config.Buckets[i-1] {
+				return nil, fmt.Errorf("histogram %q: buckets must increase", config.Metric)
+			}
+		}
+		if config.NativeBucketFactor != 0 && config.NativeBucketFactor <= 1 {
+			return nil, fmt.Errorf("histogram %q: native bucket factor %g must be above 1", config.Metric, config.NativeBucketFactor)
+		}
+		rules = append(rules, histogramRule{HistogramConfig: config, metric: metric})
+	}
+	return rules, nil
+}
+
+// apply sets the buckets configured for the histogram of opts
+func (r histogramRules) apply(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	for _, rule := range r {
+		if !rule.metric.MatchString(name) {
+			continue
+		}
+		if len(rule.Buckets) > 0 {
+			opts.Buckets = append([]float64(nil), rule.Buckets...)
+		}
+		if rule.Native {
+			opts.NativeHistogramBucketFactor = rule.NativeBucketFactor
+			if opts.NativeHistogramBucketFactor == 0 {
+				opts.NativeHistogramBucketFactor = DefaultNativeBucketFactor
+			}
+			opts.NativeHistogramMaxBucketNumber = rule.NativeMaxBuckets
+			if opts.NativeHistogramMaxBucketNumber == 0 {
+				opts.NativeHistogramMaxBucketNumber = DefaultNativeMaxBuckets
+			}
+			opts.NativeHistogramMinResetDuration = time.Hour
+		}
+		break
+	}
+	return opts
+}
+
+// BucketAnalyzer keeps a uniform sample of the observations of each
+// histogram it is given, so bucket suggestions can see the distribution
+// inside a bucket, such as sub-millisecond latencies all counted in the
+// first default bucket
+type BucketAnalyzer struct {
+	size int
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	samples map[string]*reservoir
+}
+
+type reservoir struct {
+	seen   uint64
+	values []float64
+}
+
+// NewBucketAnalyzer returns an analyzer that keeps size observations per
+// metric, DefaultBucketSamples if size is not positive
+func NewBucketAnalyzer(size int) *BucketAnalyzer {
+	if size <= 0 {
+		size = DefaultBucketSamples
+	}
+	return &BucketAnalyzer{
+		size:    size,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		samples: make(map[string]*reservoir),
+	}
+}
+
+// Observe records an observation of the histogram metric
+func (a *BucketAnalyzer) Observe(metric string, value float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := a.samples[metric]
+	if r == nil {
+		r = &reservoir{values: make([]float64, 0, a.size)}
+		a.samples[metric] = r
+	}
+	r.seen++
+	if len(r.values) < a.size {
+		r.values = append(r.values, value)
+	} else if i := a.rand.Int63n(int64(r.seen)); i < int64(a.size) {
+		r.values[i] = value
+	}
+}
+
+// sorted returns the sample of metric in increasing order
+func (a *BucketAnalyzer) sorted(metric string) []float64 {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	r := a.samples[metric]
+	var values []float64
+	if r != nil {
+		values = append(values, r.values...)
+	}
+	a.mu.Unlock()
+	sort.Float64s(values)
+	return values
+}
+
+// BucketReport describes how well a histogram's buckets fit the values it
+// observes
+type BucketReport struct {
+	Metric       string    `json:"metric"`
+	Observations uint64    `json:"observations"`
+	Buckets      []float64 `json:"buckets"`
+
+	// Source is "samples" when the quantiles come from observations kept by
+	// the analyzer, "buckets" when they are estimated from the buckets
+	Source    string             `json:"source"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+
+	// FirstBucketShare and OverflowShare are the fractions of observations
+	// in the first bucket and above the largest one
+	FirstBucketShare float64 `json:"first_bucket_share"`
+	OverflowShare    float64 `json:"overflow_share"`
+	// ResolvingBuckets counts the bucket bounds between p50 and p99
+	ResolvingBuckets int `json:"resolving_buckets"`
+
+	Fit     string   `json:"fit"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Suggested is an apm.yaml histogram entry for a poor fit
+	Suggested *HistogramConfig `json:"suggested,omitempty"`
+}
+
+// AnalyzeBuckets judges the buckets of every classic histogram of g against
+// the values it observed, taking quantiles from the samples of analyzer
+// where it has them, and suggests buckets for those that fit poorly.
+// analyzer may be nil.
+func AnalyzeBuckets(g prometheus.Gatherer, analyzer *BucketAnalyzer) ([]BucketReport, error) {
+	mfs, err := g.Gather()
+	if err != nil && len(mfs) == 0 {
+		return nil, err
+	}
+
+	var reports []BucketReport
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_HISTOGRAM || len(mf.Metric) == 0 {
+			continue
+		}
+		var merged *dto.Histogram
+		for _, m := range mf.Metric {
+			if h := m.GetHistogram(); h != nil && len(h.Bucket) > 0 {
+				if merged == nil {
+					merged = h
+				} else {
+					merged = mergeHistograms(merged, h)
+				}
+			}
+		}
+		if merged == nil {
+			continue
+		}
+		reports = append(reports, analyzeHistogram(mf.GetName(), merged, analyzer.sorted(mf.GetName())))
+	}
+	return reports, nil
+}
+
+func analyzeHistogram(name string, h *dto.Histogram, samples []float64) BucketReport {
+	report := BucketReport{Metric: name, Observations: h.GetSampleCount(), Source: "buckets"}
+	for _, bucket := range h.Bucket {
+		report.Buckets = append(report.Buckets, bucket.GetUpperBound())
+	}
+	if report.Observations < MinBucketObservations {
+		report.Fit = BucketFitInsufficientData
+		return report
+	}
+
+	quantile := func(q float64) float64 { return bucketQuantile(q, h) }
+	if len(samples) >= MinBucketObservations {
+		report.Source = "samples"
+		quantile = func(q float64) float64 { return sampleQuantile(q, samples) }
+	}
+	p1, p50, p99, p999 := quantile(0.01), quantile(0.5), quantile(0.99), quantile(0.999)
+	report.Quantiles = map[string]float64{"p1": p1, "p50": p50, "p99": p99, "p99.9": p999}
+
+	total := float64(report.Observations)
+	first, last := report.Buckets[0], report.Buckets[len(report.Buckets)-1]
+	report.FirstBucketShare = float64(h.Bucket[0].GetCumulativeCount()) / total
+	report.OverflowShare = float64(report.Observations-h.Bucket[len(h.Bucket)-1].GetCumulativeCount()) / total
+	for _, bound := range report.Buckets {
+		if bound >= p50 && bound <= p99 {
+			report.ResolvingBuckets++
+		}
+	}
+
+	if report.FirstBucketShare > 0.5 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("%.0f%% of observations fall in the first bucket (<= %g)", report.FirstBucketShare*100, first))
+	}
+	if report.OverflowShare > 0.01 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("%.1f%% of observations exceed the largest bucket (%g)", report.OverflowShare*100, last))
+	}
+	if report.ResolvingBuckets == 0 && p99 > p50 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("p50 (%g) and p99 (%g) fall in the same bucket", p50, p99))
+	}
+	if len(report.Reasons) == 0 {
+		report.Fit = BucketFitGood
+		return report
+	}
+	report.Fit = BucketFitPoor
+	report.Suggested = suggestBuckets(name, p1, p999)
+	return report
+}
+
+// suggestBuckets spreads buckets evenly on a log scale from lo to hi, and
+// turns on the native histogram when that range is too wide for them
+func suggestBuckets(name string, lo, hi float64) *HistogramConfig {
+	if !(hi > 0) {
+		return nil
+	}
+	if !(lo > 0) || lo > hi {
+		lo = hi / 1000
+	}
+	config := &HistogramConfig{Metric: name, Native: hi/lo > 1e4}
+	if hi/lo < 10 {
+		// Too narrow to spread a dozen buckets sensibly
+		lo, hi = lo/2, hi*2
+	}
+
+	step := math.Log(hi/lo) / float64(suggestedBuckets-1)
+	for i := 0; i < suggestedBuckets; i++ {
+		bound := lo * math.Exp(step*float64(i))
+		switch i {
+		case 0:
+			bound = niceBound(bound, math.Floor)
+		case suggestedBuckets - 1:
+			bound = niceBound(bound, math.Ceil)
+		default:
+			bound = niceBound(bound, math.Round)
+		}
+		if n := len(config.Buckets); n == 0 || bound > config.Buckets[n-1] {
+			config.Buckets = append(config.Buckets, bound)
+		}
+	}
+	return config
+}
+
+// niceSteps are the mantissas bucket bounds are rounded to
+var niceSteps = []float64{1, 1.5, 2, 2.5, 3, 4, 5, 6, 8, 10}
+
+// niceBound rounds v to a nice mantissa in log space, in the direction of
+// round
+func niceBound(v float64, round func(float64) float64) float64 {
+	exp := math.Floor(math.Log10(v))
+	scale := math.Pow(10, exp)
+	mantissa := v / scale
+
+	// Position of the mantissa between the nice steps, in log space
+	pos := 0.0
+	for i := 1; i < len(niceSteps); i++ {
+		if mantissa <= niceSteps[i] {
+			lo, hi := math.Log(niceSteps[i-1]), math.Log(niceSteps[i])
+			pos = float64(i-1) + (math.Log(mantissa)-lo)/(hi-lo)
+			break
+		}
+	}
+	idx := int(round(pos))
+	if idx >= len(niceSteps) {
+		idx = len(niceSteps) - 1
+	}
+	// Drop the float noise of scaling, so 3*0.0001 is 0.0003
+	bound, _ := strconv.ParseFloat(strconv.FormatFloat(niceSteps[idx]*scale, 'g', 6, 64), 64)
+	return bound
+}
+
+// sampleQuantile is the nearest-rank quantile of sorted values
+func sampleQuantile(q float64, sorted []float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,164 @@
+package instrumentation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func histogramBounds(t *testing.T, reg *prometheus.Registry, name string) []float64 {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			var bounds []float64
+			for _, bucket := range mf.Metric[0].GetHistogram().Bucket {
+				bounds = append(bounds, bucket.GetUpperBound())
+			}
+			return bounds
+		}
+	}
+	t.Fatalf("%s not gathered", name)
+	return nil
+}
+
+func TestHistogramConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apm.yaml")
+	os.WriteFile(path, []byte(`metrics:
+  histograms:
+    - metric: shop_http_request_duration_seconds
+      buckets: [0.0001, 0.0005, 0.001]
+    - metric: shop_db_.*
+      native: true
+`), 0o644)
+	histograms, err := LoadHistogramConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	mc, err := NewMetricsCollectorWith(reg, MetricsConfig{Namespace: "shop", Histograms: histograms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc.RecordHTTPRequest("GET", "/cart", 200, 300*time.Microsecond)
+	if got := histogramBounds(t, reg, "shop_http_request_duration_seconds"); len(got) != 3 || got[0] != 0.0001 {
+		t.Errorf("buckets = %v, want the configured ones", got)
+	}
+
+	queries := mc.NewHistogram("db_query_seconds", "Queries", nil, nil)
+	reg.MustRegister(queries)
+	queries.WithLabelValues().Observe(0.002)
+	mfs, _ := reg.Gather()
+	for _, mf := range mfs {
+		if mf.GetName() == "shop_db_query_seconds" && mf.Metric[0].GetHistogram().Schema == nil {
+			t.Error("native histogram not enabled")
+		}
+	}
+
+	for _, bad := range [][]HistogramConfig{
+		{{Metric: "x", Buckets: []float64{1, 0.5}}},
+		{{Metric: "x", Native: true, NativeBucketFactor: 0.9}},
+		{{Buckets: []float64{1}}},
+	} {
+		if _, err := NewMetricsCollectorWith(prometheus.NewRegistry(), MetricsConfig{Histograms: bad}); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestAnalyzeBucketsSuggestsSubMillisecondBuckets(t *testing.T) {
+	record := func(mc *MetricsCollector) {
+		for i := 0; i < 1000; i++ {
+			mc.RecordHTTPRequest("GET", "/healthz", 200, time.Duration(50+i%900)*time.Microsecond)
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	mc, err := NewMetricsCollectorWith(reg, MetricsConfig{AnalyzeBuckets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(mc)
+	reports, err := AnalyzeBuckets(reg, mc.BucketAnalyzer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report BucketReport
+	for _, r := range reports {
+		if r.Metric == "http_request_duration_seconds" {
+			report = r
+		}
+	}
+	if report.Fit != BucketFitPoor || report.Source != "samples" || report.FirstBucketShare != 1 {
+		t.Fatalf("report = %+v, want a poor fit from samples", report)
+	}
+	if len(report.Reasons) == 0 || !strings.Contains(report.Reasons[0], "first bucket") {
+		t.Errorf("reasons = %v", report.Reasons)
+	}
+	suggested := report.Suggested
+	if suggested == nil || suggested.Native {
+		t.Fatalf("suggested = %+v, want classic buckets", suggested)
+	}
+	if first, last := suggested.Buckets[0], suggested.Buckets[len(suggested.Buckets)-1]; first > 0.00006 || last < 0.00094 || last > 0.002 {
+		t.Errorf("suggested buckets = %v, want them to span 60µs to 940µs", suggested.Buckets)
+	}
+
+	// The suggestion fits
+	reg = prometheus.NewRegistry()
+	mc, err = NewMetricsCollectorWith(reg, MetricsConfig{Histograms: []HistogramConfig{*suggested}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(mc)
+	reports, _ = AnalyzeBuckets(reg, nil)
+	for _, r := range reports {
+		if r.Metric == "http_request_duration_seconds" && r.Fit != BucketFitGood {
+			t.Errorf("suggested buckets %v fit poorly: %v", suggested.Buckets, r.Reasons)
+		}
+	}
+}
+
+func TestAnalyzeBucketsFromBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "job_duration_seconds", Help: "Jobs", Buckets: prometheus.DefBuckets})
+	few := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "rare_seconds", Help: "Rare"})
+	reg.MustRegister(latency, few)
+	for i := 0; i < 500; i++ {
+		latency.Observe(0.01 * float64(1+i%200))
+	}
+	few.Observe(1)
+
+	reports, err := AnalyzeBuckets(reg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("%d reports, want 2", len(reports))
+	}
+	if r := reports[0]; r.Metric != "job_duration_seconds" || r.Fit != BucketFitGood || r.Source != "buckets" {
+		t.Errorf("report = %+v, want a good fit from buckets", r)
+	}
+	if r := reports[1]; r.Fit != BucketFitInsufficientData {
+		t.Errorf("report = %+v, want insufficient data", r)
+	}
+}
+
+func TestSuggestBucketsWideRangeIsNative(t *testing.T) {
+	suggested := suggestBuckets("x", 0.00001, 30)
+	if !suggested.Native {
+		t.Errorf("suggested = %+v, want a native histogram for six decades", suggested)
+	}
+	for i := 1; i < len(suggested.Buckets); i++ {
+		if suggested.Buckets[i] <= suggested.Buckets[i-1] {
+			t.Fatalf("buckets %v do not increase", suggested.Buckets)
+		}
+	}
+}
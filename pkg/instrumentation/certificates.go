@@ -0,0 +1,420 @@
+package instrumentation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CertificateEndpoint describes a TLS endpoint whose certificate should be monitored
+type CertificateEndpoint struct {
+	Name       string // Logical name used as the "endpoint" label
+	Address    string // host:port to dial
+	ServerName string // SNI server name, defaults to the host part of Address
+	Kind       string // api, collector, ingress, ...
+}
+
+// CertificateMonitorConfig holds configuration for certificate expiry monitoring
+type CertificateMonitorConfig struct {
+	Endpoints         []CertificateEndpoint
+	WarningThreshold  time.Duration // Remaining validity that raises a warning
+	CriticalThreshold time.Duration // Remaining validity that raises a critical alert
+	Timeout           time.Duration // Dial and handshake timeout per endpoint
+	Interval          time.Duration // Interval between checks when running in the background
+	RootCAs           *x509.CertPool
+}
+
+// DefaultCertificateMonitorConfig returns a default certificate monitor configuration
+func DefaultCertificateMonitorConfig() CertificateMonitorConfig {
+	return CertificateMonitorConfig{
+		WarningThreshold:  30 * 24 * time.Hour,
+		CriticalThreshold: 7 * 24 * time.Hour,
+		Timeout:           10 * time.Second,
+		Interval:          time.Hour,
+	}
+}
+
+// CertificateStatus is the result of checking a single endpoint
+type CertificateStatus struct {
+	Endpoint      string       `json:"endpoint"`
+	Address       string       `json:"address"`
+	Kind          string       `json:"kind,omitempty"`
+	Subject       string       `json:"subject,omitempty"`
+	Issuer        string       `json:"issuer,omitempty"`
+	DNSNames      []string     `json:"dns_names,omitempty"`
+	NotBefore     time.Time    `json:"not_before,omitempty"`
+	NotAfter      time.Time    `json:"not_after,omitempty"`
+	DaysRemaining float64      `json:"days_remaining"`
+	Verified      bool         `json:"verified"`
+	Status        HealthStatus `json:"status"`
+	Error         string       `json:"error,omitempty"`
+	CheckedAt     time.Time    `json:"checked_at"`
+}
+
+// CertificateMonitor periodically checks TLS certificates and exports expiry gauges
+type CertificateMonitor struct {
+	config CertificateMonitorConfig
+
+	notAfter     *prometheus.GaugeVec
+	checkSuccess *prometheus.GaugeVec
+	verified     *prometheus.GaugeVec
+
+	mu      sync.RWMutex
+	results map[string]CertificateStatus
+}
+
+// NewCertificateMonitor creates a new certificate monitor
+func NewCertificateMonitor(cfg CertificateMonitorConfig) *CertificateMonitor {
+	defaults := DefaultCertificateMonitorConfig()
+	if cfg.WarningThreshold <= 0 {
+		cfg.WarningThreshold = defaults.WarningThreshold
+	}
+	if cfg.CriticalThreshold <= 0 {
+		cfg.CriticalThreshold = defaults.CriticalThreshold
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+
+	return &CertificateMonitor{
+		config: cfg,
+		notAfter: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tls_certificate_not_after_timestamp_seconds",
+				Help: "Expiry time of the leaf TLS certificate as a Unix timestamp",
+			},
+			[]string{"endpoint", "kind", "subject", "issuer"},
+		),
+		checkSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tls_certificate_check_success",
+				Help: "Whether the last TLS handshake with the endpoint succeeded (1) or failed (0)",
+			},
+			[]string{"endpoint", "kind"},
+		),
+		verified: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tls_certificate_verified",
+				Help: "Whether the certificate chain verified against the configured roots (1) or not (0)",
+			},
+			[]string{"endpoint", "kind"},
+		),
+		results: make(map[string]CertificateStatus),
+	}
+}
+
+// Collectors returns the Prometheus collectors exported by the monitor
+func (m *CertificateMonitor) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.notAfter, m.checkSuccess, m.verified}
+}
+
+// Register registers the monitor's collectors with the given registerer
+func (m *CertificateMonitor) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range m.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register certificate collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Check checks all configured endpoints once and updates the exported gauges
+func (m *CertificateMonitor) Check(ctx context.Context) []CertificateStatus {
+	statuses := make([]CertificateStatus, len(m.config.Endpoints))
+
+	var wg sync.WaitGroup
+	for i, ep := range m.config.Endpoints {
+		wg.Add(1)
+		go func(i int, ep CertificateEndpoint) {
+			defer wg.Done()
+			statuses[i] = m.checkEndpoint(ctx, ep)
+		}(i, ep)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, status := range statuses {
+		m.results[status.Endpoint] = status
+		m.record(status)
+	}
+
+	return statuses
+}
+
+// Start runs checks on the configured interval until the context is cancelled
+func (m *CertificateMonitor) Start(ctx context.Context) {
+	m.Check(ctx)
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Results returns the latest result for every endpoint, sorted by endpoint name
+func (m *CertificateMonitor) Results() []CertificateStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]CertificateStatus, 0, len(m.results))
+	for _, r := range m.results {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Endpoint < results[j].Endpoint })
+	return results
+}
+
+// HealthCheck returns a HealthCheckFunc that reports the worst certificate
+// status of the last scheduled check; probes do not dial the endpoints
+func (m *CertificateMonitor) HealthCheck() HealthCheckFunc {
+	return func(ctx context.Context) HealthCheck {
+		statuses := m.Results()
+
+		check := HealthCheck{
+			Name:    "tls_certificates",
+			Status:  HealthStatusHealthy,
+			Message: "All certificates are valid",
+			Details: make(map[string]interface{}),
+		}
+		if len(statuses) == 0 && len(m.config.Endpoints) > 0 {
+			check.Status = HealthStatusDegraded
+			check.Message = "certificates have not been checked yet"
+			check.LastChecked = time.Now().UTC()
+			return check
+		}
+
+		for _, s := range statuses {
+			if s.CheckedAt.After(check.LastChecked) {
+				check.LastChecked = s.CheckedAt
+			}
+			check.Details[s.Endpoint] = map[string]interface{}{
+				"days_remaining": s.DaysRemaining,
+				"not_after":      s.NotAfter,
+				"status":         s.Status,
+				"error":          s.Error,
+			}
+
+			switch s.Status {
+			case HealthStatusUnhealthy:
+				check.Status = HealthStatusUnhealthy
+				check.Message = fmt.Sprintf("certificate for %s requires attention", s.Endpoint)
+			case HealthStatusDegraded:
+				if check.Status == HealthStatusHealthy {
+					check.Status = HealthStatusDegraded
+					check.Message = fmt.Sprintf("certificate for %s expires soon", s.Endpoint)
+				}
+			}
+		}
+
+		return check
+	}
+}
+
+// checkEndpoint performs a TLS handshake with the endpoint and inspects the leaf certificate
+func (m *CertificateMonitor) checkEndpoint(ctx context.Context, ep CertificateEndpoint) CertificateStatus {
+	name := ep.Name
+	if name == "" {
+		name = ep.Address
+	}
+
+	status := CertificateStatus{
+		Endpoint:  name,
+		Address:   ep.Address,
+		Kind:      ep.Kind,
+		CheckedAt: time.Now().UTC(),
+	}
+
+	serverName := ep.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(ep.Address)
+		if err != nil {
+			status.Status = HealthStatusUnhealthy
+			status.Error = fmt.Sprintf("invalid address: %v", err)
+			return status
+		}
+		serverName = host
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	// Verification is performed separately so that expired or untrusted
+	// certificates can still be inspected and reported.
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: m.config.Timeout},
+		Config: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true, // #nosec G402 -- chain is verified manually below
+		},
+	}
+
+	conn, err := dialer.DialContext(dialCtx, "tcp", ep.Address)
+	if err != nil {
+		status.Status = HealthStatusUnhealthy
+		status.Error = fmt.Sprintf("tls handshake failed: %v", err)
+		return status
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		status.Status = HealthStatusUnhealthy
+		status.Error = "no peer certificates presented"
+		return status
+	}
+
+	leaf := state.PeerCertificates[0]
+	status.Subject = leaf.Subject.CommonName
+	status.Issuer = leaf.Issuer.CommonName
+	status.DNSNames = leaf.DNSNames
+	status.NotBefore = leaf.NotBefore
+	status.NotAfter = leaf.NotAfter
+
+	remaining := time.Until(leaf.NotAfter)
+	status.DaysRemaining = remaining.Hours() / 24
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         m.config.RootCAs,
+		Intermediates: intermediates,
+	})
+	status.Verified = verifyErr == nil
+
+	switch {
+	case remaining <= 0:
+		status.Status = HealthStatusUnhealthy
+		status.Error = "certificate has expired"
+	case remaining <= m.config.CriticalThreshold:
+		status.Status = HealthStatusUnhealthy
+	case remaining <= m.config.WarningThreshold:
+		status.Status = HealthStatusDegraded
+	default:
+		status.Status = HealthStatusHealthy
+	}
+
+	if verifyErr != nil && status.Error == "" {
+		status.Error = fmt.Sprintf("certificate verification failed: %v", verifyErr)
+		if status.Status == HealthStatusHealthy {
+			status.Status = HealthStatusDegraded
+		}
+	}
+
+	return status
+}
+
+// record updates the gauges for a single endpoint result. The series of the
+// previous result are deleted first, so a renewed certificate or a failed
+// check leaves no stale expiry behind.
+func (m *CertificateMonitor) record(status CertificateStatus) {
+	previous := prometheus.Labels{"endpoint": status.Endpoint}
+	m.notAfter.DeletePartialMatch(previous)
+	m.checkSuccess.DeletePartialMatch(previous)
+	m.verified.DeletePartialMatch(previous)
+
+	if status.NotAfter.IsZero() {
+		m.checkSuccess.WithLabelValues(status.Endpoint, status.Kind).Set(0)
+		m.verified.WithLabelValues(status.Endpoint, status.Kind).Set(0)
+		return
+	}
+
+	m.checkSuccess.WithLabelValues(status.Endpoint, status.Kind).Set(1)
+	m.notAfter.WithLabelValues(status.Endpoint, status.Kind, status.Subject, status.Issuer).
+		Set(float64(status.NotAfter.Unix()))
+
+	verified := 0.0
+	if status.Verified {
+		verified = 1
+	}
+	m.verified.WithLabelValues(status.Endpoint, status.Kind).Set(verified)
+}
+
+// certificateAlertRulesTemplate renders Prometheus alerting rules for the exported gauges
+var certificateAlertRulesTemplate = template.Must(template.New("certificate-alerts").Parse(`# Rendered by CertificateMonitor.AlertRules for warning_days {{ .WarningDays }} and
+# critical_days {{ .CriticalDays }}; render the rules again when the certificate_monitoring
+# thresholds change.
+groups:
+  - name: tls-certificates
+    interval: 5m
+    rules:
+      # Certificate expires within {{ .WarningDays }} days
+      - alert: TLSCertificateExpiringSoon
+        expr: (tls_certificate_not_after_timestamp_seconds - time()) < {{ .WarningSeconds }}
+        for: 15m
+        labels:
+          severity: warning
+        annotations:
+          summary: "TLS certificate for {{ "{{ $labels.endpoint }}" }} expires soon"
+          description: "Certificate {{ "{{ $labels.subject }}" }} expires in {{ "{{ $value | humanizeDuration }}" }}."
+
+      # Certificate expires within {{ .CriticalDays }} days
+      - alert: TLSCertificateExpiryCritical
+        expr: (tls_certificate_not_after_timestamp_seconds - time()) < {{ .CriticalSeconds }}
+        for: 5m
+        labels:
+          severity: critical
+        annotations:
+          summary: "TLS certificate for {{ "{{ $labels.endpoint }}" }} is about to expire"
+          description: "Certificate {{ "{{ $labels.subject }}" }} expires in {{ "{{ $value | humanizeDuration }}" }}."
+
+      # Handshake with a monitored endpoint failing
+      - alert: TLSCertificateCheckFailed
+        expr: tls_certificate_check_success == 0
+        for: 15m
+        labels:
+          severity: warning
+        annotations:
+          summary: "TLS handshake with {{ "{{ $labels.endpoint }}" }} failing"
+          description: "The certificate monitor could not complete a TLS handshake with {{ "{{ $labels.endpoint }}" }}."
+
+      # Chain does not verify against trusted roots
+      - alert: TLSCertificateUntrusted
+        expr: tls_certificate_verified == 0 and tls_certificate_check_success == 1
+        for: 15m
+        labels:
+          severity: warning
+        annotations:
+          summary: "TLS certificate for {{ "{{ $labels.endpoint }}" }} failed verification"
+          description: "The certificate chain presented by {{ "{{ $labels.endpoint }}" }} does not verify against the trusted roots."
+`))
+
+// AlertRules generates Prometheus alerting rules matching the monitor's thresholds
+func (m *CertificateMonitor) AlertRules() (string, error) {
+	var buf bytes.Buffer
+	err := certificateAlertRulesTemplate.Execute(&buf, map[string]int64{
+		"WarningSeconds":  int64(m.config.WarningThreshold.Seconds()),
+		"CriticalSeconds": int64(m.config.CriticalThreshold.Seconds()),
+		"WarningDays":     int64(m.config.WarningThreshold / (24 * time.Hour)),
+		"CriticalDays":    int64(m.config.CriticalThreshold / (24 * time.Hour)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render certificate alert rules: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,118 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCertificateMonitorCheck(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	// httptest certificates are valid until 2084, so a threshold beyond that
+	// forces the warning path without needing a short-lived certificate.
+	monitor := NewCertificateMonitor(CertificateMonitorConfig{
+		Endpoints: []CertificateEndpoint{
+			{Name: "test", Address: address, ServerName: "example.com", Kind: "api"},
+			{Name: "closed", Address: "127.0.0.1:1", Kind: "collector"},
+		},
+		WarningThreshold:  100 * 365 * 24 * time.Hour,
+		CriticalThreshold: time.Hour,
+		Timeout:           2 * time.Second,
+	})
+
+	statuses := monitor.Check(context.Background())
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+
+	ok := statuses[0]
+	if ok.NotAfter.IsZero() {
+		t.Fatalf("expected certificate details for %s, got error %q", ok.Endpoint, ok.Error)
+	}
+	if ok.Status != HealthStatusDegraded {
+		t.Errorf("expected degraded status within warning threshold, got %s", ok.Status)
+	}
+	if ok.Verified {
+		t.Error("expected self-signed test certificate to fail verification")
+	}
+
+	failed := statuses[1]
+	if failed.Status != HealthStatusUnhealthy || failed.Error == "" {
+		t.Errorf("expected unhealthy status with error for closed port, got %s (%q)", failed.Status, failed.Error)
+	}
+
+	if v := testutil.ToFloat64(monitor.checkSuccess.WithLabelValues("test", "api")); v != 1 {
+		t.Errorf("expected check success gauge 1, got %v", v)
+	}
+	if v := testutil.ToFloat64(monitor.checkSuccess.WithLabelValues("closed", "collector")); v != 0 {
+		t.Errorf("expected check success gauge 0, got %v", v)
+	}
+
+	check := monitor.HealthCheck()(context.Background())
+	if check.Status != HealthStatusUnhealthy {
+		t.Errorf("expected aggregated health to be unhealthy, got %s", check.Status)
+	}
+	if !check.LastChecked.Equal(statuses[0].CheckedAt) && !check.LastChecked.Equal(statuses[1].CheckedAt) {
+		t.Errorf("expected health to report the last check, got %s", check.LastChecked)
+	}
+}
+
+func TestCertificateMonitorHealthCheckBeforeFirstCheck(t *testing.T) {
+	// The endpoint is never dialed: probes only report scheduled results
+	monitor := NewCertificateMonitor(CertificateMonitorConfig{
+		Endpoints: []CertificateEndpoint{{Name: "closed", Address: "127.0.0.1:1"}},
+	})
+	check := monitor.HealthCheck()(context.Background())
+	if check.Status != HealthStatusDegraded {
+		t.Errorf("expected degraded health before the first check, got %s", check.Status)
+	}
+	if len(monitor.Results()) != 0 {
+		t.Error("expected the health check not to run a check")
+	}
+}
+
+func TestCertificateMonitorAlertRules(t *testing.T) {
+	monitor := NewCertificateMonitor(CertificateMonitorConfig{
+		WarningThreshold:  14 * 24 * time.Hour,
+		CriticalThreshold: 2 * 24 * time.Hour,
+	})
+
+	rules, err := monitor.AlertRules()
+	if err != nil {
+		t.Fatalf("failed to render alert rules: %v", err)
+	}
+
+	for _, want := range []string{"< 1209600", "< 172800", "{{ $labels.endpoint }}", "TLSCertificateCheckFailed"} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("expected alert rules to contain %q", want)
+		}
+	}
+}
+
+func TestCertificateMonitorRecordReplacesSeries(t *testing.T) {
+	monitor := NewCertificateMonitor(CertificateMonitorConfig{})
+	notAfter := time.Now().Add(30 * 24 * time.Hour)
+
+	monitor.record(CertificateStatus{Endpoint: "api", Kind: "api", Subject: "old", Issuer: "ca", NotAfter: notAfter})
+	monitor.record(CertificateStatus{Endpoint: "api", Kind: "api", Subject: "new", Issuer: "ca", NotAfter: notAfter.Add(time.Hour)})
+	if n := testutil.CollectAndCount(monitor.notAfter); n != 1 {
+		t.Errorf("expected 1 expiry series after renewal, got %d", n)
+	}
+
+	monitor.record(CertificateStatus{Endpoint: "api", Kind: "api", Status: HealthStatusUnhealthy, Error: "tls handshake failed"})
+	if n := testutil.CollectAndCount(monitor.notAfter); n != 0 {
+		t.Errorf("expected no expiry series after a failed check, got %d", n)
+	}
+	if v := testutil.ToFloat64(monitor.checkSuccess.WithLabelValues("api", "api")); v != 0 {
+		t.Errorf("expected check success gauge 0, got %v", v)
+	}
+}
@@ -0,0 +1,281 @@
+package instrumentation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/security/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client labels that are not client IDs
+const (
+	// ClientAnonymous is requests without an API client
+	ClientAnonymous = "anonymous"
+	// ClientOther is clients past the MaxClients bound
+	ClientOther = "other"
+)
+
+// clientUsageResolution is the granularity of the usage history
+const clientUsageResolution = time.Minute
+
+// ClientUsageConfig configures per-client usage tracking
+type ClientUsageConfig struct {
+	// MaxClients bounds the clients tracked individually (default 100);
+	// later clients are counted as ClientOther so metric cardinality stays
+	// bounded
+	MaxClients int
+	// Retention is how far back Usage answers (default 24h); Prometheus
+	// holds the metrics for longer reports
+	Retention time.Duration
+}
+
+// ClientUsage attributes requests to the API client they authenticated as,
+// in metrics, spans, and a per-minute usage history for partner reporting
+type ClientUsage struct {
+	config ClientUsageConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientHistory
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// clientHistory is a ring of per-minute usage, indexed by minute modulo its
+// length
+type clientHistory struct {
+	name    string
+	buckets []usageBucket
+}
+
+type usageBucket struct {
+	minute   int64
+	requests uint64
+	errors   uint64
+	seconds  float64
+}
+
+// NewClientUsage creates a client usage tracker
+func NewClientUsage(config ClientUsageConfig) *ClientUsage {
+	if config.MaxClients <= 0 {
+		config.MaxClients = 100
+	}
+	if config.Retention <= 0 {
+		config.Retention = 24 * time.Hour
+	}
+	return &ClientUsage{
+		config:  config,
+		now:     time.Now,
+		clients: make(map[string]*clientHistory),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apm_client_requests_total",
+			Help: "Requests by API client and status class",
+		}, []string{"client", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "apm_client_request_duration_seconds",
+			Help:    "Request latency by API client",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"client"}),
+	}
+}
+
+// Collectors returns the Prometheus collectors exported by the tracker
+func (u *ClientUsage) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{u.requests, u.duration}
+}
+
+// Register registers the tracker's collectors with the given registerer
+func (u *ClientUsage) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range u.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register client usage collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Middleware returns a Fiber middleware that attributes each request to the
+// client in its auth context. Add it after the authentication middleware.
+func (u *ClientUsage) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := u.now()
+		err := c.Next()
+
+		client := ClientAnonymous
+		if authCtx := auth.GetAuthContext(c); authCtx != nil && authCtx.ClientID != "" {
+			client = authCtx.ClientID
+			trace.SpanFromContext(c.UserContext()).SetAttributes(attribute.String("apm.client.id", authCtx.ClientID))
+		}
+		status := c.Response().StatusCode()
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		}
+		u.Record(client, status, u.now().Sub(start))
+		return err
+	}
+}
+
+// Record counts a request by client
+func (u *ClientUsage) Record(client string, status int, duration time.Duration) {
+	u.mu.Lock()
+	history := u.clients[client]
+	if history == nil {
+		if len(u.clients) >= u.config.MaxClients && client != ClientAnonymous {
+			client = ClientOther
+			history = u.clients[client]
+		}
+		if history == nil {
+			// Client IDs may point into the request, which fasthttp reuses
+			name := strings.Clone(client)
+			history = &clientHistory{name: name, buckets: make([]usageBucket, int(u.config.Retention/clientUsageResolution)+1)}
+			u.clients[name] = history
+		}
+	}
+	client = history.name
+	minute := u.now().Unix() / 60
+	b := &history.buckets[minute%int64(len(history.buckets))]
+	if b.minute != minute {
+		*b = usageBucket{minute: minute}
+	}
+	b.requests++
+	if status >= 500 {
+		b.errors++
+	}
+	b.seconds += duration.Seconds()
+	u.mu.Unlock()
+
+	u.requests.WithLabelValues(client, statusCodeClass(status)).Inc()
+	u.duration.WithLabelValues(client).Observe(duration.Seconds())
+}
+
+// ClientUsageSeries is one client's usage over time
+type ClientUsageSeries struct {
+	Client   string       `json:"client"`
+	Requests uint64       `json:"requests"`
+	Errors   uint64       `json:"errors"`
+	Points   []UsagePoint `json:"points"`
+}
+
+// UsagePoint is a client's usage in the step starting at Time
+type UsagePoint struct {
+	Time         time.Time `json:"time"`
+	Requests     uint64    `json:"requests"`
+	Errors       uint64    `json:"errors"`
+	AvgLatencyMS float64   `json:"avg_latency_ms"`
+}
+
+// Usage returns the usage of each client, or of client when it is not
+// empty, from from to to in steps of step, rounded to whole minutes
+func (u *ClientUsage) Usage(client string, from, to time.Time, step time.Duration) []ClientUsageSeries {
+	if step < clientUsageResolution {
+		step = clientUsageResolution
+	}
+	perStep := int64(step / clientUsageResolution)
+	now := u.now().Unix() / 60
+	first, last := from.Unix()/60, to.Unix()/60
+	if oldest := now - int64(u.config.Retention/clientUsageResolution); first < oldest {
+		first = oldest
+	}
+	if last > now {
+		last = now
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var result []ClientUsageSeries
+	for name, history := range u.clients {
+		if client != "" && name != client {
+			continue
+		}
+		series := ClientUsageSeries{Client: name, Points: []UsagePoint{}}
+		for start := first; start <= last; start += perStep {
+			point := UsagePoint{Time: time.Unix(start*60, 0).UTC()}
+			var seconds float64
+			for minute := start; minute < start+perStep && minute <= last; minute++ {
+				b := history.buckets[minute%int64(len(history.buckets))]
+				if b.minute != minute {
+					continue
+				}
+				point.Requests += b.requests
+				point.Errors += b.errors
+				seconds += b.seconds
+			}
+			if point.Requests > 0 {
+				point.AvgLatencyMS = seconds * 1000 / float64(point.Requests)
+			}
+			series.Requests += point.Requests
+			series.Errors += point.Errors
+			series.Points = append(series.Points, point)
+		}
+		result = append(result, series)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Client < result[j].Client })
+	return result
+}
+
+// ClientUsageReport is the usage served by Handler
+type ClientUsageReport struct {
+	From    time.Time           `json:"from"`
+	To      time.Time           `json:"to"`
+	Step    string              `json:"step"`
+	Clients []ClientUsageSeries `json:"clients"`
+}
+
+// Handler returns a Fiber handler that serves Usage as JSON. The query
+// parameters are client, from and to (RFC 3339 or Unix seconds, default the
+// last hour), and step (a duration, default 1m).
+func (u *ClientUsage) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		to := u.now()
+		from := to.Add(-time.Hour)
+		step := clientUsageResolution
+		var err error
+		if v := c.Query("from"); v != "" {
+			if from, err = parseUsageTime(v); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "from: "+err.Error())
+			}
+		}
+		if v := c.Query("to"); v != "" {
+			if to, err = parseUsageTime(v); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "to: "+err.Error())
+			}
+		}
+		if v := c.Query("step"); v != "" {
+			if step, err = time.ParseDuration(v); err != nil || step <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "step must be a duration such as 5m")
+			}
+		}
+		if !from.Before(to) {
+			return fiber.NewError(fiber.StatusBadRequest, "from must be before to")
+		}
+		return c.JSON(ClientUsageReport{
+			From:    from.UTC(),
+			To:      to.UTC(),
+			Step:    step.String(),
+			Clients: u.Usage(c.Query("client"), from, to, step),
+		})
+	}
+}
+
+func parseUsageTime(v string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor Unix seconds", v)
+	}
+	return t, nil
+}
@@ -0,0 +1,86 @@
+package instrumentation
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/security/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClientUsage(t *testing.T) {
+	u := NewClientUsage(ClientUsageConfig{MaxClients: 2})
+	now := time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC)
+	u.now = func() time.Time { return now }
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if key := c.Get("X-API-Key"); key != "" {
+			auth.SetAuthContext(c, &auth.AuthContext{User: &auth.User{ID: "u"}, AuthType: auth.AuthTypeAPIKey, ClientID: key})
+		}
+		return c.Next()
+	})
+	app.Use(u.Middleware())
+	app.Get("/orders", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/fail", func(c *fiber.Ctx) error { return fiber.ErrBadGateway })
+	app.Get("/usage", u.Handler())
+
+	request := func(path, key string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request("/orders", "partner-a")
+	request("/fail", "partner-a")
+	now = now.Add(2 * time.Minute)
+	request("/orders", "partner-a")
+	request("/orders", "partner-b")
+	request("/orders", "partner-c") // past MaxClients
+	request("/orders", "")
+
+	if got := testutil.ToFloat64(u.requests.WithLabelValues("partner-a", "5xx")); got != 1 {
+		t.Errorf("partner-a 5xx = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(u.requests.WithLabelValues(ClientOther, "2xx")); got != 1 {
+		t.Errorf("other = %g, want partner-c counted as other", got)
+	}
+	if got := testutil.ToFloat64(u.requests.WithLabelValues(ClientAnonymous, "2xx")); got != 1 {
+		t.Errorf("anonymous = %g, want 1", got)
+	}
+
+	series := u.Usage("partner-a", now.Add(-5*time.Minute), now, 2*time.Minute)
+	if len(series) != 1 || series[0].Requests != 3 || series[0].Errors != 1 {
+		t.Fatalf("usage = %+v, want 3 requests and 1 error for partner-a", series)
+	}
+	var perPoint []uint64
+	for _, p := range series[0].Points {
+		perPoint = append(perPoint, p.Requests)
+	}
+	if len(perPoint) != 3 || perPoint[1] != 2 || perPoint[2] != 1 {
+		t.Errorf("requests per step = %v, want [0 2 1]", perPoint)
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/usage?step=10m", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Clients []ClientUsageSeries `json:"clients"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if len(body.Clients) != 4 {
+		t.Errorf("clients = %+v, want partner-a, partner-b, other, and anonymous", body.Clients)
+	}
+
+	resp, _ = app.Test(httptest.NewRequest("GET", "/usage?from=yesterday", nil))
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("status = %d for a bad from, want 400", resp.StatusCode)
+	}
+}
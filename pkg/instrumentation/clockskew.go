@@ -0,0 +1,414 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+)
+
+// Clock skew defaults
+const (
+	DefaultClockSkewInterval  = 5 * time.Minute
+	DefaultClockSkewThreshold = 500 * time.Millisecond
+)
+
+// Attributes set on spans whose timestamps were corrected
+const (
+	ClockOffsetAttribute    = attribute.Key("apm.clock.offset_ms")
+	ClockReferenceAttribute = attribute.Key("apm.clock.reference")
+)
+
+// ClockSkewConfig configures clock skew detection between the application
+// host and the collector and backends. Spans from hosts whose clocks
+// disagree can start before their parent or end before they start in trace
+// waterfalls.
+type ClockSkewConfig struct {
+	// NTPServer is queried over SNTP, e.g. pool.ntp.org; the port defaults
+	// to 123
+	NTPServer string
+	// Endpoints are HTTP(S) URLs of the collector and backends, compared
+	// through the Date header of their responses. The header has a
+	// resolution of one second, which is counted in the uncertainty.
+	Endpoints []string
+	// Interval between measurements, default DefaultClockSkewInterval
+	Interval time.Duration
+	// Threshold is the skew, beyond the measurement uncertainty, logged
+	// as a warning; default DefaultClockSkewThreshold
+	Threshold time.Duration
+	// Correct shifts the timestamps of exported spans by the offset
+	// measured against NTPServer and records it in ClockOffsetAttribute
+	Correct bool
+}
+
+// Enabled reports whether any clock is compared against
+func (c ClockSkewConfig) Enabled() bool {
+	return c.NTPServer != "" || len(c.Endpoints) > 0
+}
+
+// ClockSource measures a reference clock against the local clock
+type ClockSource interface {
+	// Name identifies the source in metrics and logs
+	Name() string
+	// Offset returns how far the reference clock is ahead of the local
+	// clock, and the uncertainty of the measurement
+	Offset(ctx context.Context) (offset, uncertainty time.Duration, err error)
+}
+
+// ClockSkew is one measurement of a clock source
+type ClockSkew struct {
+	Source      string
+	Offset      time.Duration
+	Uncertainty time.Duration
+	Time        time.Time
+	Err         error
+}
+
+// Exceeds reports whether the skew is beyond threshold even at the edge of
+// the measurement uncertainty
+func (s ClockSkew) Exceeds(threshold time.Duration) bool {
+	if s.Err != nil {
+		return false
+	}
+	offset := s.Offset
+	if offset < 0 {
+		offset = -offset
+	}
+	return offset-s.Uncertainty > threshold
+}
+
+// ClockSkewMonitor measures the clock sources periodically, exports the
+// offsets as the clock_skew_seconds gauge, and warns when one exceeds the
+// threshold. With Correct set, its Exporter shifts span timestamps onto the
+// NTP clock.
+type ClockSkewMonitor struct {
+	config  ClockSkewConfig
+	sources []ClockSource
+	logger  *zap.Logger
+	skew    *prometheus.GaugeVec
+
+	mu         sync.RWMutex
+	last       []ClockSkew
+	correction time.Duration
+	reference  string
+}
+
+// NewClockSkewMonitor creates a monitor of the configured NTP server and
+// endpoints; a nil logger discards the warnings
+func NewClockSkewMonitor(config ClockSkewConfig, logger *zap.Logger) *ClockSkewMonitor {
+	if config.Interval <= 0 {
+		config.Interval = DefaultClockSkewInterval
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultClockSkewThreshold
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	var sources []ClockSource
+	if config.NTPServer != "" {
+		sources = append(sources, &NTPSource{Server: config.NTPServer})
+	}
+	for _, endpoint := range config.Endpoints {
+		sources = append(sources, &HTTPDateSource{URL: endpoint})
+	}
+
+	return &ClockSkewMonitor{
+		config:  config,
+		sources: sources,
+		logger:  logger,
+		skew: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "clock_skew_seconds",
+				Help: "Offset of a reference clock from the local clock; positive when the local clock is behind",
+			},
+			[]string{"source"},
+		),
+	}
+}
+
+// Collectors returns the Prometheus collectors exported by the monitor
+func (m *ClockSkewMonitor) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.skew}
+}
+
+// Register registers the monitor's collectors with the given registerer
+func (m *ClockSkewMonitor) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range m.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register clock skew collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Measure measures every source once, updating the gauge and the
+// correction, and logs the sources whose skew exceeds the threshold
+func (m *ClockSkewMonitor) Measure(ctx context.Context) []ClockSkew {
+	results := make([]ClockSkew, len(m.sources))
+	var wg sync.WaitGroup
+	for i, source := range m.sources {
+		wg.Add(1)
+		go func(i int, source ClockSource) {
+			defer wg.Done()
+			offset, uncertainty, err := source.Offset(ctx)
+			results[i] = ClockSkew{Source: source.Name(), Offset: offset, Uncertainty: uncertainty, Time: time.Now(), Err: err}
+		}(i, source)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last = results
+	for i, r := range results {
+		if r.Err != nil {
+			m.logger.Warn("clock skew measurement failed", zap.String("source", r.Source), zap.Error(r.Err))
+			continue
+		}
+		m.skew.WithLabelValues(r.Source).Set(r.Offset.Seconds())
+		if _, ok := m.sources[i].(*NTPSource); ok {
+			m.correction, m.reference = r.Offset, r.Source
+		}
+		if r.Exceeds(m.config.Threshold) {
+			m.logger.Warn("clock skew exceeds threshold",
+				zap.String("source", r.Source),
+				zap.Duration("offset", r.Offset),
+				zap.Duration("uncertainty", r.Uncertainty),
+				zap.Duration("threshold", m.config.Threshold))
+		}
+	}
+	return results
+}
+
+// Run measures every Interval until ctx is done
+func (m *ClockSkewMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			measureCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			m.Measure(measureCtx)
+			cancel()
+		}
+	}
+}
+
+// Last returns the latest measurements
+func (m *ClockSkewMonitor) Last() []ClockSkew {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]ClockSkew(nil), m.last...)
+}
+
+// Correction returns the offset added to span timestamps and the source it
+// was measured against; the source is empty until the NTP server answers
+// or when Correct is not set
+func (m *ClockSkewMonitor) Correction() (time.Duration, string) {
+	if !m.config.Correct {
+		return 0, ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.correction, m.reference
+}
+
+// Exporter wraps a span exporter so the exported timestamps are corrected;
+// without Correct it returns next unchanged
+func (m *ClockSkewMonitor) Exporter(next sdktrace.SpanExporter) sdktrace.SpanExporter {
+	if !m.config.Correct {
+		return next
+	}
+	return &skewExporter{next: next, monitor: m}
+}
+
+type skewExporter struct {
+	next    sdktrace.SpanExporter
+	monitor *ClockSkewMonitor
+}
+
+// ExportSpans implements sdktrace.SpanExporter
+func (e *skewExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	offset, reference := e.monitor.Correction()
+	if reference == "" {
+		return e.next.ExportSpans(ctx, spans)
+	}
+	extra := []attribute.KeyValue{
+		ClockOffsetAttribute.Float64(float64(offset) / float64(time.Millisecond)),
+		ClockReferenceAttribute.String(reference),
+	}
+	out := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		out[i] = skewSpan{ReadOnlySpan: s, offset: offset, extra: extra}
+	}
+	return e.next.ExportSpans(ctx, out)
+}
+
+// Shutdown implements sdktrace.SpanExporter
+func (e *skewExporter) Shutdown(ctx context.Context) error {
+	return e.next.Shutdown(ctx)
+}
+
+// skewSpan shifts the timestamps of a span by the clock offset
+type skewSpan struct {
+	sdktrace.ReadOnlySpan
+	offset time.Duration
+	extra  []attribute.KeyValue
+}
+
+func (s skewSpan) StartTime() time.Time { return s.ReadOnlySpan.StartTime().Add(s.offset) }
+func (s skewSpan) EndTime() time.Time   { return s.ReadOnlySpan.EndTime().Add(s.offset) }
+
+func (s skewSpan) Attributes() []attribute.KeyValue {
+	attrs := s.ReadOnlySpan.Attributes()
+	return append(attrs[:len(attrs):len(attrs)], s.extra...)
+}
+
+func (s skewSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]sdktrace.Event, len(events))
+	for i, e := range events {
+		e.Time = e.Time.Add(s.offset)
+		out[i] = e
+	}
+	return out
+}
+
+// ntpEpochOffset is the number of seconds between 1900 and 1970
+const ntpEpochOffset = 2208988800
+
+// NTPSource measures the clock of an NTP server with a single SNTP query
+// (RFC 4330)
+type NTPSource struct {
+	Server  string        // host or host:port
+	Timeout time.Duration // default 5s
+}
+
+// Name implements ClockSource
+func (n *NTPSource) Name() string { return "ntp:" + n.Server }
+
+// Offset implements ClockSource. The uncertainty is half the round trip.
+func (n *NTPSource) Offset(ctx context.Context) (time.Duration, time.Duration, error) {
+	address := n.Server
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), "123")
+	}
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reach NTP server %s: %w", n.Server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Version 4, client mode; the transmit timestamp comes back as the
+	// originate timestamp and ties the reply to the request
+	request := make([]byte, 48)
+	request[0] = 4<<3 | 3
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, 0, fmt.Errorf("failed to query NTP server %s: %w", n.Server, err)
+	}
+	reply := make([]byte, 48)
+	if read, err := conn.Read(reply); err != nil || read < len(reply) {
+		if err == nil {
+			err = fmt.Errorf("short reply of %d bytes", read)
+		}
+		return 0, 0, fmt.Errorf("no reply from NTP server %s: %w", n.Server, err)
+	}
+	received := sent.Add(time.Since(sent))
+
+	switch {
+	case reply[0]&7 != 4:
+		return 0, 0, fmt.Errorf("NTP server %s sent an unexpected mode %d", n.Server, reply[0]&7)
+	case reply[1] == 0:
+		return 0, 0, fmt.Errorf("NTP server %s refused the query (%s)", n.Server, strings.TrimRight(string(reply[12:16]), "\x00"))
+	case binary.BigEndian.Uint64(reply[24:]) != binary.BigEndian.Uint64(request[40:]):
+		return 0, 0, errors.New("NTP reply does not match the request")
+	}
+
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(reply[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(reply[40:]))
+	offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	delay := received.Sub(sent) - serverSent.Sub(serverReceived)
+	return offset, delay / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(v uint64) time.Time {
+	seconds := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// HTTPDateSource measures the clock of an HTTP server, such as a collector
+// or backend, from the Date header of its response to a HEAD request
+type HTTPDateSource struct {
+	URL    string
+	Client *http.Client // default has a 5s timeout
+}
+
+// Name implements ClockSource
+func (h *HTTPDateSource) Name() string { return h.URL }
+
+// Offset implements ClockSource. The Date header is truncated to the
+// second, so the uncertainty is half a second plus half the round trip.
+func (h *HTTPDateSource) Offset(ctx context.Context) (time.Duration, time.Duration, error) {
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.URL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp.Body.Close()
+	rtt := time.Since(sent)
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s sent no valid Date header", h.URL)
+	}
+	// The server's clock read somewhere within the second of the header,
+	// while the request was in flight
+	serverTime := date.Add(time.Second / 2)
+	localTime := sent.Add(rtt / 2)
+	return serverTime.Sub(localTime), time.Second/2 + rtt/2, nil
+}
@@ -0,0 +1,143 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeNTPServer answers SNTP queries with a clock ahead by skew
+func fakeNTPServer(t *testing.T, skew time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n != 48 {
+				continue
+			}
+			reply := make([]byte, 48)
+			reply[0] = 4<<3 | 4
+			reply[1] = 2
+			copy(reply[24:32], buf[40:48])
+			now := toNTPTime(time.Now().Add(skew))
+			binary.BigEndian.PutUint64(reply[32:], now)
+			binary.BigEndian.PutUint64(reply[40:], now)
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNTPSource(t *testing.T) {
+	source := &NTPSource{Server: fakeNTPServer(t, 3*time.Second)}
+	offset, uncertainty, err := source.Offset(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := offset - 3*time.Second; d < -50*time.Millisecond || d > 50*time.Millisecond {
+		t.Errorf("offset = %s, want about 3s", offset)
+	}
+	if uncertainty < 0 || uncertainty > 50*time.Millisecond {
+		t.Errorf("uncertainty = %s", uncertainty)
+	}
+}
+
+func TestHTTPDateSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-10*time.Second).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	source := &HTTPDateSource{URL: server.URL}
+	offset, uncertainty, err := source.Offset(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset > -9*time.Second || offset < -11*time.Second {
+		t.Errorf("offset = %s, want about -10s", offset)
+	}
+	if uncertainty < 500*time.Millisecond {
+		t.Errorf("uncertainty = %s, want at least the Date resolution", uncertainty)
+	}
+	skew := ClockSkew{Offset: offset, Uncertainty: uncertainty}
+	if !skew.Exceeds(DefaultClockSkewThreshold) || skew.Exceeds(20*time.Second) {
+		t.Errorf("Exceeds gave the wrong answer for %s ±%s", offset, uncertainty)
+	}
+}
+
+func TestClockSkewMonitor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	m := NewClockSkewMonitor(ClockSkewConfig{
+		NTPServer: fakeNTPServer(t, 2*time.Second),
+		Endpoints: []string{server.URL, "http://127.0.0.1:0"},
+		Correct:   true,
+	}, nil)
+	reg := prometheus.NewRegistry()
+	if err := m.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	results := m.Measure(context.Background())
+	if len(results) != 3 || results[0].Err != nil || results[1].Err != nil || results[2].Err == nil {
+		t.Fatalf("results = %+v", results)
+	}
+	if n := testutil.CollectAndCount(m.skew); n != 2 {
+		t.Errorf("expected gauges for the 2 reachable sources, got %d", n)
+	}
+	offset, reference := m.Correction()
+	if reference != "ntp:"+m.config.NTPServer || offset < time.Second {
+		t.Fatalf("correction = %s from %q", offset, reference)
+	}
+
+	recorder := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(m.Exporter(recorder)))
+	start := time.Now()
+	_, span := tp.Tracer("test").Start(context.Background(), "GET /", trace.WithTimestamp(start),
+		trace.WithAttributes(attribute.String("http.method", "GET")))
+	span.AddEvent("retry", trace.WithTimestamp(start.Add(time.Millisecond)))
+	span.End(trace.WithTimestamp(start.Add(time.Second)))
+
+	spans := recorder.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if !s.StartTime.Equal(start.Add(offset)) || s.EndTime.Sub(s.StartTime) != time.Second {
+		t.Errorf("start %s, end %s, want shifted by %s", s.StartTime, s.EndTime, offset)
+	}
+	if !s.Events[0].Time.Equal(start.Add(time.Millisecond + offset)) {
+		t.Errorf("event time %s was not shifted", s.Events[0].Time)
+	}
+	if got := attrMap(s.Attributes); got["http.method"] != "GET" || got[string(ClockReferenceAttribute)] != reference || got[string(ClockOffsetAttribute)] == "" {
+		t.Errorf("attributes = %v", got)
+	}
+}
+
+func TestClockSkewExporterWithoutCorrect(t *testing.T) {
+	recorder := tracetest.NewInMemoryExporter()
+	m := NewClockSkewMonitor(ClockSkewConfig{NTPServer: "127.0.0.1:0"}, nil)
+	if m.Exporter(recorder) != sdktrace.SpanExporter(recorder) {
+		t.Error("expected the exporter to be left alone")
+	}
+}
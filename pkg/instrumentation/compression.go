@@ -0,0 +1,271 @@
+package instrumentation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/stats"
+)
+
+// OTLP compression algorithms
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+func init() {
+	// gRPC ships gzip; zstd is registered under the name the collector
+	// accepts in grpc-encoding
+	encoding.RegisterCompressor(zstdCompressor{})
+}
+
+// validateCompression checks an OTLP compression name
+func validateCompression(name string) error {
+	switch name {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("compression %q is not supported: use gzip, zstd, or none", name)
+}
+
+// CompressionStats counts the bytes of OTLP exports before and after
+// compression, so the egress saved by an algorithm can be compared
+type CompressionStats struct {
+	uncompressed *prometheus.CounterVec
+	compressed   *prometheus.CounterVec
+}
+
+// NewCompressionStats creates the export byte counters
+func NewCompressionStats() *CompressionStats {
+	labels := []string{"protocol", "compression"}
+	return &CompressionStats{
+		uncompressed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "otlp_export_uncompressed_bytes_total",
+				Help: "Bytes of OTLP trace export payloads before compression",
+			},
+			labels,
+		),
+		compressed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "otlp_export_compressed_bytes_total",
+				Help: "Bytes of OTLP trace export payloads as sent, after compression",
+			},
+			labels,
+		),
+	}
+}
+
+// Collectors returns the Prometheus collectors of the stats
+func (s *CompressionStats) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{s.uncompressed, s.compressed}
+}
+
+// Register registers the stats' collectors with the given registerer
+func (s *CompressionStats) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range s.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register compression collector: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *CompressionStats) observe(protocol, compression string, uncompressed, compressed int) {
+	if compression == "" {
+		compression = CompressionNone
+	}
+	s.uncompressed.WithLabelValues(protocol, compression).Add(float64(uncompressed))
+	s.compressed.WithLabelValues(protocol, compression).Add(float64(compressed))
+}
+
+// grpcHandler returns a gRPC stats handler counting the outgoing messages
+func (s *CompressionStats) grpcHandler(compression string) stats.Handler {
+	return &compressionStatsHandler{stats: s, compression: compression}
+}
+
+type compressionStatsHandler struct {
+	stats       *CompressionStats
+	compression string
+}
+
+func (h *compressionStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *compressionStatsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if p, ok := s.(*stats.OutPayload); ok {
+		h.stats.observe("grpc", h.compression, p.Length, p.CompressedLength)
+	}
+}
+
+func (h *compressionStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *compressionStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// compressingTransport compresses OTLP/HTTP request bodies and counts their
+// size. The SDK only offers gzip, so both algorithms are applied here.
+type compressingTransport struct {
+	next        http.RoundTripper
+	compression string
+	stats       *CompressionStats
+}
+
+func (t *compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return t.next.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := body
+	switch t.compression {
+	case CompressionGzip, CompressionZstd:
+		if payload, err = compress(t.compression, body); err != nil {
+			return nil, err
+		}
+	}
+	if t.stats != nil {
+		t.stats.observe("http", t.compression, len(body), len(payload))
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(payload))
+	out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(payload)), nil }
+	out.ContentLength = int64(len(payload))
+	if t.compression == CompressionGzip || t.compression == CompressionZstd {
+		out.Header.Set("Content-Encoding", t.compression)
+	}
+	return t.next.RoundTrip(out)
+}
+
+var zstdEncoders = sync.Pool{New: func() interface{} {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	return enc
+}}
+
+func compress(algorithm string, data []byte) ([]byte, error) {
+	if algorithm == CompressionZstd {
+		enc := zstdEncoders.Get().(*zstd.Encoder)
+		defer zstdEncoders.Put(enc)
+		return enc.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zstdCompressor implements the gRPC zstd encoding
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string { return CompressionZstd }
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+// limitPayload splits export batches so the estimated size of each stays
+// under maxBytes; zero leaves batches whole
+func limitPayload(next sdktrace.SpanExporter, maxBytes int) sdktrace.SpanExporter {
+	if maxBytes <= 0 {
+		return next
+	}
+	return &payloadLimitExporter{next: next, maxBytes: maxBytes}
+}
+
+type payloadLimitExporter struct {
+	next     sdktrace.SpanExporter
+	maxBytes int
+}
+
+// ExportSpans implements sdktrace.SpanExporter. A single span larger than
+// the limit is still exported, on its own.
+func (e *payloadLimitExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	var errs []error
+	start, size := 0, 0
+	for i, s := range spans {
+		n := estimateSpanSize(s)
+		if i > start && size+n > e.maxBytes {
+			if err := e.next.ExportSpans(ctx, spans[start:i]); err != nil {
+				errs = append(errs, err)
+			}
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(spans) {
+		if err := e.next.ExportSpans(ctx, spans[start:]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown implements sdktrace.SpanExporter
+func (e *payloadLimitExporter) Shutdown(ctx context.Context) error {
+	return e.next.Shutdown(ctx)
+}
+
+// estimateSpanSize approximates the encoded OTLP size of a span: IDs,
+// timestamps, and framing, plus its strings and attributes
+func estimateSpanSize(s sdktrace.ReadOnlySpan) int {
+	size := 64 + len(s.Name()) + len(s.Status().Description) + attributesSize(s.Attributes())
+	for _, e := range s.Events() {
+		size += 16 + len(e.Name) + attributesSize(e.Attributes)
+	}
+	for _, l := range s.Links() {
+		size += 40 + len(l.SpanContext.TraceState().String()) + attributesSize(l.Attributes)
+	}
+	return size
+}
+
+func attributesSize(attrs []attribute.KeyValue) int {
+	size := 0
+	for _, kv := range attrs {
+		size += 8 + len(kv.Key)
+		switch kv.Value.Type() {
+		case attribute.STRING:
+			size += len(kv.Value.AsString())
+		case attribute.STRINGSLICE:
+			size += len(strings.Join(kv.Value.AsStringSlice(), "")) + 2*len(kv.Value.AsStringSlice())
+		default:
+			size += len(kv.Value.Emit())
+		}
+	}
+	return size
+}
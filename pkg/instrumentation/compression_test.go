@@ -0,0 +1,208 @@
+package instrumentation
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/protobuf/proto"
+)
+
+// exportTestSpan exports one span with compressible attributes
+func exportTestSpan(t *testing.T, exporter sdktrace.SpanExporter) {
+	t.Helper()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := tp.Tracer("test").Start(context.Background(), "GET /orders")
+	span.SetAttributes(attribute.String("http.user_agent", strings.Repeat("Mozilla/5.0 ", 50)))
+	span.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOTLPHTTPCompression(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			var encoding, name string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding = r.Header.Get("Content-Encoding")
+				var body io.Reader = r.Body
+				switch encoding {
+				case CompressionGzip:
+					body, _ = gzip.NewReader(r.Body)
+				case CompressionZstd:
+					dec, _ := zstd.NewReader(r.Body)
+					defer dec.Close()
+					body = dec
+				}
+				data, _ := io.ReadAll(body)
+				var req coltracepb.ExportTraceServiceRequest
+				if err := proto.Unmarshal(data, &req); err == nil && len(req.ResourceSpans) > 0 {
+					name = req.ResourceSpans[0].ScopeSpans[0].Spans[0].Name
+				}
+				w.Header().Set("Content-Type", "application/x-protobuf")
+			}))
+			defer server.Close()
+
+			stats := NewCompressionStats()
+			exporter, err := CreateExporter(context.Background(), ExporterConfig{
+				Type:             "otlp-http",
+				Endpoint:         strings.TrimPrefix(server.URL, "http://"),
+				Insecure:         true,
+				Compression:      compression,
+				CompressionStats: stats,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			exportTestSpan(t, exporter)
+
+			if encoding != compression || name != "GET /orders" {
+				t.Errorf("received encoding %q, span %q", encoding, name)
+			}
+			raw := testutil.ToFloat64(stats.uncompressed.WithLabelValues("http", compression))
+			sent := testutil.ToFloat64(stats.compressed.WithLabelValues("http", compression))
+			if raw == 0 || sent >= raw {
+				t.Errorf("uncompressed %v bytes, compressed %v", raw, sent)
+			}
+		})
+	}
+}
+
+// fakeTraceService is an OTLP collector recording the compression of the
+// requests it receives
+type fakeTraceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	mu        sync.Mutex
+	encodings []string
+	spans     int
+}
+
+func (f *fakeTraceService) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (f *fakeTraceService) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		f.mu.Lock()
+		f.encodings = append(f.encodings, h.Compression)
+		f.mu.Unlock()
+	}
+}
+
+func (f *fakeTraceService) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (f *fakeTraceService) HandleConn(context.Context, stats.ConnStats) {}
+
+func (f *fakeTraceService) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			f.spans += len(ss.Spans)
+		}
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func TestOTLPGRPCZstdCompression(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &fakeTraceService{}
+	server := grpc.NewServer(grpc.StatsHandler(service))
+	coltracepb.RegisterTraceServiceServer(server, service)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	stats := NewCompressionStats()
+	exporter, err := CreateExporter(context.Background(), ExporterConfig{
+		Type:             "otlp-grpc",
+		Endpoint:         lis.Addr().String(),
+		Insecure:         true,
+		Compression:      CompressionZstd,
+		CompressionStats: stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportTestSpan(t, exporter)
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if service.spans != 1 || len(service.encodings) == 0 || service.encodings[0] != CompressionZstd {
+		t.Errorf("received %d spans with encodings %v", service.spans, service.encodings)
+	}
+	raw := testutil.ToFloat64(stats.uncompressed.WithLabelValues("grpc", CompressionZstd))
+	sent := testutil.ToFloat64(stats.compressed.WithLabelValues("grpc", CompressionZstd))
+	if raw == 0 || sent >= raw {
+		t.Errorf("uncompressed %v bytes, compressed %v", raw, sent)
+	}
+}
+
+type batchRecorder struct {
+	batches []int
+}
+
+func (b *batchRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	b.batches = append(b.batches, len(spans))
+	return nil
+}
+
+func (b *batchRecorder) Shutdown(context.Context) error { return nil }
+
+func TestPayloadLimitSplitsBatches(t *testing.T) {
+	recorder := &batchRecorder{}
+	exporter := limitPayload(recorder, 1000)
+
+	tp := sdktrace.NewTracerProvider()
+	var spans []sdktrace.ReadOnlySpan
+	for i := 0; i < 10; i++ {
+		_, span := tp.Tracer("test").Start(context.Background(), strings.Repeat("x", 200))
+		span.End()
+		spans = append(spans, span.(sdktrace.ReadOnlySpan))
+	}
+	_, huge := tp.Tracer("test").Start(context.Background(), strings.Repeat("y", 5000))
+	huge.End()
+	spans = append(spans, huge.(sdktrace.ReadOnlySpan))
+
+	if err := exporter.ExportSpans(context.Background(), spans); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, n := range recorder.batches {
+		total += n
+	}
+	if total != 11 || len(recorder.batches) < 4 || recorder.batches[len(recorder.batches)-1] != 1 {
+		t.Errorf("batches = %v, want the spans split with the oversized one alone", recorder.batches)
+	}
+
+	if limitPayload(recorder, 0) != sdktrace.SpanExporter(recorder) {
+		t.Error("a zero limit should leave the exporter alone")
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	if err := buildConfig(WithOTLP("collector:4317"), WithTracing(TracerConfig{ExporterType: "otlp", Endpoint: "collector:4317", Compression: "brotli"})).Validate(); err == nil || !strings.Contains(err.Error(), "OTEL_EXPORTER_OTLP_COMPRESSION") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := CreateExporter(context.Background(), ExporterConfig{Type: "otlp-grpc", Endpoint: "collector:4317", Compression: "lz4"}); err == nil {
+		t.Error("expected an unknown compression to be rejected")
+	}
+}
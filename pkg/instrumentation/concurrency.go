@@ -0,0 +1,502 @@
+package instrumentation
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Concurrency limit algorithms
+const (
+	// LimitVegas grows the limit while latency stays near the lowest seen
+	// and shrinks it as requests start to queue, like TCP Vegas
+	LimitVegas = "vegas"
+	// LimitGradient scales the limit by the ratio of long-term to recent
+	// latency
+	LimitGradient = "gradient"
+)
+
+// Reasons a request is shed
+const (
+	ShedLimit        = "limit"         // the limit was reached and the queue was full
+	ShedQueueTimeout = "queue_timeout" // the request waited in the queue too long
+)
+
+// ConcurrencyConfig configures the adaptive concurrency limiter
+type ConcurrencyConfig struct {
+	// Algorithm is LimitVegas (default) or LimitGradient
+	Algorithm string
+
+	// InitialLimit, MinLimit, and MaxLimit bound the concurrent requests
+	// (default 20, 1, and 1000)
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// QueueSize requests may wait up to QueueTimeout for a slot before they
+	// are shed; zero sheds as soon as the limit is reached
+	QueueSize    int
+	QueueTimeout time.Duration
+
+	// ShedWindow is the period the shed ratio is measured over (default
+	// 10s). The health check reports degraded while requests are being shed
+	// and unhealthy when more than ShedUnhealthyRatio of them were (default
+	// 0.5), so readiness takes the instance out of rotation until it
+	// recovers.
+	ShedWindow         time.Duration
+	ShedUnhealthyRatio float64
+
+	// Health, when set, gets the limiter's check registered as "concurrency"
+	Health *HealthChecker
+
+	// Skip exempts requests from the limit, such as health probes
+	Skip func(*fiber.Ctx) bool
+
+	Logger *zap.Logger
+}
+
+func (c *ConcurrencyConfig) withDefaults() error {
+	if c.Algorithm == "" {
+		c.Algorithm = LimitVegas
+	}
+	if c.Algorithm != LimitVegas && c.Algorithm != LimitGradient {
+		return fmt.Errorf("concurrency limit algorithm %q is not supported: use vegas or gradient", c.Algorithm)
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = 1
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 1000
+	}
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = 20
+	}
+	if c.MinLimit > c.MaxLimit || c.InitialLimit < c.MinLimit || c.InitialLimit > c.MaxLimit {
+		return errors.New("concurrency limits must satisfy min <= initial <= max")
+	}
+	if c.QueueSize < 0 {
+		return errors.New("concurrency queue size must not be negative")
+	}
+	if c.QueueSize > 0 && c.QueueTimeout <= 0 {
+		c.QueueTimeout = 100 * time.Millisecond
+	}
+	if c.ShedWindow <= 0 {
+		c.ShedWindow = 10 * time.Second
+	}
+	if c.ShedUnhealthyRatio <= 0 {
+		c.ShedUnhealthyRatio = 0.5
+	}
+	if c.Logger == nil {
+		c.Logger = zap.L()
+	}
+	return nil
+}
+
+// ConcurrencyLimiter bounds the requests a service handles at once. The
+// limit adapts to the latency of completed requests: it grows while
+// latency holds and falls as soon as requests start queueing inside the
+// service, so overload turns into fast 429s instead of slow timeouts for
+// everyone.
+//
+//	limiter, err := instrumentation.NewConcurrencyLimiter(instrumentation.ConcurrencyConfig{
+//		Health: health,
+//		Skip:   func(c *fiber.Ctx) bool { return c.Path() == "/health/ready" },
+//	})
+//	limiter.Register(nil)
+//	app.Use(limiter.Middleware())
+type ConcurrencyLimiter struct {
+	config    ConcurrencyConfig
+	algorithm limitAlgorithm
+	now       func() time.Time
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	queue    *list.List // of *waiter
+
+	windowStart    time.Time
+	windowRequests int
+	windowShed     int
+	lastShedRatio  float64
+	shedding       bool
+
+	limitGauge    prometheus.Gauge
+	inflightGauge prometheus.Gauge
+	queuedGauge   prometheus.Gauge
+	shed          *prometheus.CounterVec
+	queueWait     prometheus.Histogram
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewConcurrencyLimiter creates a limiter
+func NewConcurrencyLimiter(config ConcurrencyConfig) (*ConcurrencyLimiter, error) {
+	if err := config.withDefaults(); err != nil {
+		return nil, err
+	}
+
+	l := &ConcurrencyLimiter{
+		config: config,
+		now:    time.Now,
+		limit:  float64(config.InitialLimit),
+		queue:  list.New(),
+		limitGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apm_concurrency_limit",
+			Help: "Current adaptive concurrency limit",
+		}),
+		inflightGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apm_concurrency_inflight",
+			Help: "Requests being handled under the concurrency limit",
+		}),
+		queuedGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apm_concurrency_queued",
+			Help: "Requests waiting for a concurrency slot",
+		}),
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apm_concurrency_shed_total",
+			Help: "Requests rejected by the concurrency limiter by reason",
+		}, []string{"reason"}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "apm_concurrency_queue_wait_seconds",
+			Help:    "Time requests waited for a concurrency slot",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+	}
+	switch config.Algorithm {
+	case LimitGradient:
+		l.algorithm = newGradientLimit()
+	default:
+		l.algorithm = newVegasLimit()
+	}
+	l.windowStart = l.now()
+	l.limitGauge.Set(l.limit)
+
+	if config.Health != nil {
+		config.Health.RegisterCheck("concurrency", l.HealthCheck())
+	}
+	return l, nil
+}
+
+// Collectors returns the Prometheus collectors exported by the limiter
+func (l *ConcurrencyLimiter) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{l.limitGauge, l.inflightGauge, l.queuedGauge, l.shed, l.queueWait}
+}
+
+// Register registers the limiter's collectors with the given registerer
+func (l *ConcurrencyLimiter) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range l.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register concurrency collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Limit returns the current concurrency limit
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Middleware returns a Fiber middleware that holds each request to the
+// limit. Shed requests get a 429 with Retry-After, and the request span
+// records why.
+func (l *ConcurrencyLimiter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if l.config.Skip != nil && l.config.Skip(c) {
+			return c.Next()
+		}
+
+		ctx := c.UserContext()
+		span := trace.SpanFromContext(ctx)
+		inflight, reason := l.acquire(ctx)
+		if reason != "" {
+			span.AddEvent("concurrency.shed", trace.WithAttributes(
+				attribute.String("concurrency.shed_reason", reason),
+				attribute.Int("concurrency.limit", l.Limit()),
+			))
+			span.SetAttributes(attribute.Bool("concurrency.shed", true))
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "server overloaded, retry later",
+			})
+		}
+
+		// The slot is released even when the handler panics, which counts
+		// as a drop
+		start := l.now()
+		dropped := true
+		defer func() { l.release(l.now().Sub(start), inflight, dropped) }()
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		}
+		// Upstream timeouts and unavailability are the overload signals a
+		// latency sample alone would miss
+		dropped = status == fiber.StatusServiceUnavailable || status == fiber.StatusGatewayTimeout || errors.Is(err, context.DeadlineExceeded)
+		return err
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room, and returns
+// the requests in flight once it holds one, or why it was shed
+func (l *ConcurrencyLimiter) acquire(ctx context.Context) (int, string) {
+	l.mu.Lock()
+	l.countRequest()
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		inflight := l.inflight
+		l.updateGauges()
+		l.mu.Unlock()
+		return inflight, ""
+	}
+	if l.queue.Len() >= l.config.QueueSize {
+		l.shedRequest(ShedLimit)
+		l.mu.Unlock()
+		return 0, ShedLimit
+	}
+	w := &waiter{ready: make(chan struct{})}
+	elem := l.queue.PushBack(w)
+	l.updateGauges()
+	l.mu.Unlock()
+
+	start := l.now()
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.queueWait.Observe(l.now().Sub(start).Seconds())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		return l.inflight, ""
+	}
+	l.queue.Remove(elem)
+	l.shedRequest(ShedQueueTimeout)
+	l.updateGauges()
+	return 0, ShedQueueTimeout
+}
+
+// release frees a slot, updates the limit from the request's latency, and
+// hands freed slots to queued requests
+func (l *ConcurrencyLimiter) release(rtt time.Duration, inflight int, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.limit = l.algorithm.update(l.limit, rtt, inflight, dropped)
+	l.limit = math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), l.limit))
+
+	for l.queue.Len() > 0 && l.inflight < int(l.limit) {
+		w := l.queue.Remove(l.queue.Front()).(*waiter)
+		w.granted = true
+		l.inflight++
+		close(w.ready)
+	}
+	l.updateGauges()
+}
+
+func (l *ConcurrencyLimiter) updateGauges() {
+	l.limitGauge.Set(math.Floor(l.limit))
+	l.inflightGauge.Set(float64(l.inflight))
+	l.queuedGauge.Set(float64(l.queue.Len()))
+}
+
+// countRequest counts a request in the shed window, starting a new window
+// when the current one is over
+func (l *ConcurrencyLimiter) countRequest() {
+	l.rollWindow()
+	l.windowRequests++
+}
+
+func (l *ConcurrencyLimiter) shedRequest(reason string) {
+	l.windowShed++
+	l.shed.WithLabelValues(reason).Inc()
+	if !l.shedding {
+		l.shedding = true
+		l.config.Logger.Warn("shedding load",
+			zap.String("reason", reason),
+			zap.Int("limit", int(l.limit)),
+			zap.Int("inflight", l.inflight))
+	}
+}
+
+func (l *ConcurrencyLimiter) rollWindow() {
+	now := l.now()
+	if now.Sub(l.windowStart) < l.config.ShedWindow {
+		return
+	}
+	l.lastShedRatio = 0
+	if l.windowRequests > 0 && now.Sub(l.windowStart) < 2*l.config.ShedWindow {
+		l.lastShedRatio = float64(l.windowShed) / float64(l.windowRequests)
+	}
+	if l.shedding && l.windowShed == 0 {
+		l.shedding = false
+		l.config.Logger.Info("stopped shedding load", zap.Int("limit", int(l.limit)))
+	}
+	l.windowStart, l.windowRequests, l.windowShed = now, 0, 0
+}
+
+// HealthCheck reports degraded while requests are being shed and unhealthy
+// while most of them are
+func (l *ConcurrencyLimiter) HealthCheck() HealthCheckFunc {
+	return func(context.Context) HealthCheck {
+		l.mu.Lock()
+		l.rollWindow()
+		ratio := l.lastShedRatio
+		if l.windowRequests > 0 {
+			ratio = math.Max(ratio, float64(l.windowShed)/float64(l.windowRequests))
+		}
+		check := HealthCheck{
+			Status:      HealthStatusHealthy,
+			Message:     "within concurrency limit",
+			LastChecked: l.now().UTC(),
+			Details: map[string]interface{}{
+				"limit":      int(l.limit),
+				"inflight":   l.inflight,
+				"queued":     l.queue.Len(),
+				"shed_ratio": ratio,
+			},
+		}
+		l.mu.Unlock()
+
+		switch {
+		case ratio > l.config.ShedUnhealthyRatio:
+			check.Status = HealthStatusUnhealthy
+			check.Message = "shedding " + strconv.FormatFloat(ratio*100, 'f', 0, 64) + "% of requests"
+		case ratio > 0:
+			check.Status = HealthStatusDegraded
+			check.Message = "shedding " + strconv.FormatFloat(ratio*100, 'f', 1, 64) + "% of requests"
+		}
+		return check
+	}
+}
+
+// limitAlgorithm computes a new limit from a completed request
+type limitAlgorithm interface {
+	update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// vegasLimit estimates the requests queued inside the service from how far
+// latency is above the lowest seen, and keeps that queue small
+type vegasLimit struct {
+	rttNoLoad time.Duration
+	// probeIn counts samples down to the next reset of rttNoLoad, so a
+	// lowest latency from a quieter time does not pin the limit down
+	probeIn int
+}
+
+func newVegasLimit() *vegasLimit {
+	return &vegasLimit{}
+}
+
+func (v *vegasLimit) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if rtt <= 0 {
+		return limit
+	}
+	v.probeIn--
+	if v.probeIn <= 0 {
+		v.rttNoLoad = 0
+		v.probeIn = int(30 * limit)
+	}
+	if v.rttNoLoad == 0 || rtt < v.rttNoLoad {
+		v.rttNoLoad = rtt
+		return limit
+	}
+
+	step := math.Max(1, math.Log10(limit))
+	if dropped {
+		return limit - step
+	}
+	// Too few requests to tell whether the limit is too high
+	if float64(inflight)*2 < limit {
+		return limit
+	}
+
+	queued := math.Ceil(limit * (1 - float64(v.rttNoLoad)/float64(rtt)))
+	alpha, beta := 3*step, 6*step
+	switch {
+	case queued <= step:
+		return limit + beta
+	case queued < alpha:
+		return limit + step
+	case queued > beta:
+		return limit - step
+	default:
+		return limit
+	}
+}
+
+// gradientLimit compares a fast and a slow moving average of latency: when
+// recent latency rises above the long-term average, the limit shrinks in
+// proportion
+type gradientLimit struct {
+	shortRTT float64
+	longRTT  float64
+}
+
+const (
+	gradientShortWindow = 10
+	gradientLongWindow  = 600
+	gradientTolerance   = 1.5
+	gradientSmoothing   = 0.2
+)
+
+func newGradientLimit() *gradientLimit {
+	return &gradientLimit{}
+}
+
+func (g *gradientLimit) update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	sample := float64(rtt)
+	if sample <= 0 {
+		return limit
+	}
+	if g.longRTT == 0 {
+		g.shortRTT, g.longRTT = sample, sample
+		return limit
+	}
+	g.shortRTT += (sample - g.shortRTT) / gradientShortWindow
+	g.longRTT += (sample - g.longRTT) / gradientLongWindow
+
+	// After a sustained rise the long-term average catches up quickly, so
+	// the limit can grow again once latency settles
+	if g.longRTT/g.shortRTT > 2 {
+		g.longRTT *= 0.95
+	}
+
+	if dropped {
+		return limit * 0.9
+	}
+	if float64(inflight)*2 < limit {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, gradientTolerance*g.longRTT/g.shortRTT))
+	target := limit*gradient + math.Sqrt(limit)
+	return limit*(1-gradientSmoothing) + target*gradientSmoothing
+}
@@ -0,0 +1,187 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+// blockingApp serves /work until release is closed, signalling each request
+// that reaches the handler on started
+func blockingApp(l *ConcurrencyLimiter) (*fiber.App, chan struct{}, chan struct{}) {
+	started, release := make(chan struct{}, 10), make(chan struct{})
+	app := fiber.New()
+	app.Use(l.Middleware())
+	app.Get("/work", func(c *fiber.Ctx) error {
+		started <- struct{}{}
+		<-release
+		return c.SendString("done")
+	})
+	return app, started, release
+}
+
+func TestConcurrencyLimiterSheds(t *testing.T) {
+	health := NewHealthChecker()
+	l, err := NewConcurrencyLimiter(ConcurrencyConfig{InitialLimit: 2, MinLimit: 2, MaxLimit: 2, Health: health, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, started, release := blockingApp(l)
+
+	statuses := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := app.Test(httptest.NewRequest("GET", "/work", nil), -1)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			statuses <- resp.StatusCode
+		}()
+		<-started
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/work", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("status = %d, want 429 with Retry-After", resp.StatusCode)
+	}
+	if got := testutil.ToFloat64(l.shed.WithLabelValues(ShedLimit)); got != 1 {
+		t.Errorf("shed = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(l.inflightGauge); got != 2 {
+		t.Errorf("inflight = %g, want 2", got)
+	}
+	if status, _ := health.CheckHealth(context.Background()); status != HealthStatusDegraded {
+		t.Errorf("health = %s, want degraded while shedding", status)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if status := <-statuses; status != fiber.StatusOK {
+			t.Errorf("admitted request status = %d", status)
+		}
+	}
+}
+
+func TestConcurrencyLimiterReleasesOnPanic(t *testing.T) {
+	l, err := NewConcurrencyLimiter(ConcurrencyConfig{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Use(recover.New())
+	app.Use(l.Middleware())
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("handler failed")
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest("GET", "/panic", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusInternalServerError {
+			t.Errorf("request %d: status = %d, want 500 from the recovered panic", i, resp.StatusCode)
+		}
+	}
+	if got := testutil.ToFloat64(l.inflightGauge); got != 0 {
+		t.Errorf("inflight = %g, want 0 after panics", got)
+	}
+}
+
+func TestConcurrencyLimiterQueues(t *testing.T) {
+	l, err := NewConcurrencyLimiter(ConcurrencyConfig{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, QueueSize: 1, QueueTimeout: 5 * time.Second, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, started, release := blockingApp(l)
+
+	first := make(chan int, 1)
+	go func() {
+		resp, _ := app.Test(httptest.NewRequest("GET", "/work", nil), -1)
+		first <- resp.StatusCode
+	}()
+	<-started
+
+	queued := make(chan int, 1)
+	go func() {
+		resp, _ := app.Test(httptest.NewRequest("GET", "/work", nil), -1)
+		queued <- resp.StatusCode
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(l.queuedGauge) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so a third request is shed
+	resp, _ := app.Test(httptest.NewRequest("GET", "/work", nil), -1)
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", resp.StatusCode)
+	}
+
+	close(release)
+	if <-first != fiber.StatusOK || <-queued != fiber.StatusOK {
+		t.Error("queued request was not served once a slot freed")
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	l, err := NewConcurrencyLimiter(ConcurrencyConfig{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, QueueSize: 1, QueueTimeout: 10 * time.Millisecond, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, reason := l.acquire(context.Background()); reason != "" {
+		t.Fatalf("first request shed: %s", reason)
+	}
+	if _, reason := l.acquire(context.Background()); reason != ShedQueueTimeout {
+		t.Errorf("reason = %q, want %q", reason, ShedQueueTimeout)
+	}
+	if got := testutil.ToFloat64(l.queuedGauge); got != 0 {
+		t.Errorf("queued = %g after timeout", got)
+	}
+}
+
+func TestConcurrencyLimitAdapts(t *testing.T) {
+	for _, algorithm := range []string{LimitVegas, LimitGradient} {
+		t.Run(algorithm, func(t *testing.T) {
+			l, err := NewConcurrencyLimiter(ConcurrencyConfig{Algorithm: algorithm, Logger: zap.NewNop()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sample := func(rtt time.Duration, n int) {
+				for i := 0; i < n; i++ {
+					inflight, _ := l.acquire(context.Background())
+					// Busy enough that the limit is what holds requests back
+					l.release(rtt, max(inflight, l.Limit()), false)
+				}
+			}
+
+			sample(10*time.Millisecond, 200)
+			grown := l.Limit()
+			if grown <= 20 {
+				t.Fatalf("limit = %d under steady latency, want it above the initial 20", grown)
+			}
+
+			sample(100*time.Millisecond, 200)
+			if got := l.Limit(); got >= grown {
+				t.Errorf("limit = %d after latency rose tenfold, want below %d", got, grown)
+			}
+		})
+	}
+
+	if _, err := NewConcurrencyLimiter(ConcurrencyConfig{Algorithm: "aimd"}); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+	if _, err := NewConcurrencyLimiter(ConcurrencyConfig{MinLimit: 50, MaxLimit: 10}); err == nil {
+		t.Error("min above max accepted")
+	}
+}
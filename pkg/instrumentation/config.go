@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds the configuration for instrumentation
@@ -14,6 +15,40 @@ type Config struct {
 
 	Metrics MetricsConfig
 	Logging LoggingConfig
+	Quota   QuotaConfig
+	Push    PushConfig
+
+	// ControlSync shares the runtime controls between replicas
+	ControlSync ControlSyncConfig
+
+	// ClockSkew compares the local clock with NTP and the collector
+	ClockSkew ClockSkewConfig
+
+	// Crash writes a report of recent logs and spans when the process dies
+	Crash CrashConfig
+
+	// Hardware exports GPU and host temperature metrics
+	Hardware HardwareConfig
+
+	// Kubernetes adds the pod's Kubernetes metadata to spans and logs
+	Kubernetes KubernetesConfig
+
+	// ExporterPolicy decides what happens when a telemetry backend is
+	// unreachable at startup
+	ExporterPolicy ExporterPolicyConfig
+
+	// Health, when set, gets the instrumentation's own readiness checks
+	// registered, such as "exporters" for the telemetry backends
+	Health *HealthChecker
+
+	// Tracing initializes the tracer with the instrumentation; nil leaves
+	// tracing to InitTracer
+	Tracing *TracerConfig
+
+	// optionErrs are errors of options applied by New, reported by Validate
+	optionErrs []error
+	// presetErr is the error of an unknown APM_PRESET; WithPreset clears it
+	presetErr error
 }
 
 // MetricsConfig holds metrics-specific configuration
@@ -22,6 +57,17 @@ type MetricsConfig struct {
 	Namespace string
 	Subsystem string
 	Path      string // Prometheus metrics endpoint path
+
+	// Relabel rewrites and aggregates metrics before exposure; RelabelFile
+	// is an apm.yaml whose metrics section is added to it and to Histograms
+	Relabel     RelabelConfig
+	RelabelFile string
+
+	// Histograms sets the buckets of histograms by metric name
+	Histograms []HistogramConfig
+	// AnalyzeBuckets samples the HTTP histograms so AnalyzeBuckets can
+	// suggest buckets from the observed distribution
+	AnalyzeBuckets bool
 }
 
 // LoggingConfig holds logging-specific configuration
@@ -34,13 +80,38 @@ type LoggingConfig struct {
 	EnableCaller     bool                   // Enable caller information
 	EnableStacktrace bool                   // Enable stack trace for errors
 	InitialFields    map[string]interface{} // Initial fields to add to all logs
+	AccessFormat     string                 // zap, common, combined, ecs, or a LogFormat template
+	AccessOutput     string                 // Access log output (stdout, stderr, or a file path)
 }
 
-// DefaultConfig returns a default configuration
+// DefaultConfig returns a default configuration, based on the APM_PRESET
+// preset when it is set. An unknown APM_PRESET is reported by Validate.
 func DefaultConfig() *Config {
+	preset, err := presetFromEnv()
+	cfg := defaultConfig(preset)
+	cfg.presetErr = err
+	return cfg
+}
+
+// PresetConfig returns the configuration of a built-in preset; environment
+// variables still override it field by field
+func PresetConfig(name string) (*Config, error) {
+	preset, err := LookupPreset(name)
+	if err != nil {
+		return nil, err
+	}
+	return defaultConfig(preset), nil
+}
+
+func defaultConfig(preset Preset) *Config {
+	environment, logLevel, logEncoding := "development", "info", "json"
+	if preset.Name != "" {
+		environment, logLevel, logEncoding = preset.Name, preset.LogLevel, preset.LogEncoding
+	}
+
 	return &Config{
 		ServiceName: getEnv("SERVICE_NAME", "app"),
-		Environment: getEnv("ENVIRONMENT", "development"),
+		Environment: getEnv("ENVIRONMENT", environment),
 		Version:     getEnv("VERSION", "unknown"),
 
 		Metrics: MetricsConfig{
@@ -48,26 +119,107 @@ func DefaultConfig() *Config {
 			Namespace: getEnv("METRICS_NAMESPACE", ""),
 			Subsystem: getEnv("METRICS_SUBSYSTEM", ""),
 			Path:      getEnv("METRICS_PATH", "/metrics"),
+
+			RelabelFile: getEnv("METRICS_RELABEL_FILE", ""),
+
+			AnalyzeBuckets: getEnvBool("METRICS_ANALYZE_BUCKETS", false),
 		},
 
 		Logging: LoggingConfig{
-			Level:            getEnv("LOG_LEVEL", "info"),
-			Encoding:         getEnv("LOG_ENCODING", "json"),
-			Development:      getEnvBool("LOG_DEVELOPMENT", false),
+			Level:            getEnv("LOG_LEVEL", logLevel),
+			Encoding:         getEnv("LOG_ENCODING", logEncoding),
+			Development:      getEnvBool("LOG_DEVELOPMENT", preset.LogDevelopment),
 			OutputPaths:      getEnvSlice("LOG_OUTPUT_PATHS", []string{"stdout"}),
 			ErrorOutputPaths: getEnvSlice("LOG_ERROR_OUTPUT_PATHS", []string{"stderr"}),
 			EnableCaller:     getEnvBool("LOG_ENABLE_CALLER", false),
 			EnableStacktrace: getEnvBool("LOG_ENABLE_STACKTRACE", false),
+			AccessFormat:     getEnv("LOG_ACCESS_FORMAT", AccessLogZap),
+			AccessOutput:     getEnv("LOG_ACCESS_OUTPUT", "stdout"),
 			InitialFields: map[string]interface{}{
 				"service": getEnv("SERVICE_NAME", "app"),
-				"env":     getEnv("ENVIRONMENT", "development"),
+				"env":     getEnv("ENVIRONMENT", environment),
 				"version": getEnv("VERSION", "unknown"),
 			},
 		},
+
+		Quota: QuotaConfig{
+			Enabled:        getEnvBool("QUOTA_ENABLED", false),
+			SpansPerMinute: getEnvInt("QUOTA_SPANS_PER_MINUTE", 0),
+			LogMBPerMinute: getEnvFloat("QUOTA_LOG_MB_PER_MINUTE", 0),
+			MaxSeries:      getEnvInt("QUOTA_MAX_SERIES", 0),
+			MinSampleRate:  getEnvFloat("QUOTA_MIN_SAMPLE_RATE", 0.01),
+			MaxLogLevel:    getEnv("QUOTA_MAX_LOG_LEVEL", "error"),
+		},
+
+		Push: PushConfig{
+			Gateway:      getEnv("PUSH_GATEWAY_URL", ""),
+			OTLPEndpoint: getEnv("PUSH_OTLP_ENDPOINT", ""),
+			Job:          getEnv("PUSH_JOB", ""),
+			Instance:     getEnv("PUSH_INSTANCE", ""),
+			Interval:     getEnvDuration("PUSH_INTERVAL", 0),
+			StaleAfter:   getEnvDuration("PUSH_STALE_AFTER", 0),
+			Temporality: strings.ToLower(getEnv("PUSH_OTLP_TEMPORALITY",
+				getEnv("OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE", ""))),
+			HistogramAggregation: strings.ToLower(getEnv("PUSH_OTLP_HISTOGRAM_AGGREGATION",
+				getEnv("OTEL_EXPORTER_OTLP_METRICS_DEFAULT_HISTOGRAM_AGGREGATION", ""))),
+		},
+
+		ControlSync: ControlSyncConfig{
+			Backend:   getEnv("CONTROLS_BACKEND", ""),
+			Endpoints: getEnvSlice("CONTROLS_ENDPOINTS", nil),
+			Key:       getEnv("CONTROLS_KEY", ""),
+			Token:     getEnv("CONTROLS_TOKEN", ""),
+			CacheFile: getEnv("CONTROLS_CACHE_FILE", ""),
+		},
+
+		ClockSkew: ClockSkewConfig{
+			NTPServer: getEnv("CLOCK_SKEW_NTP_SERVER", ""),
+			Endpoints: getEnvSlice("CLOCK_SKEW_ENDPOINTS", nil),
+			Interval:  getEnvDuration("CLOCK_SKEW_INTERVAL", 0),
+			Threshold: getEnvDuration("CLOCK_SKEW_THRESHOLD", 0),
+			Correct:   getEnvBool("CLOCK_SKEW_CORRECT", false),
+		},
+
+		Crash: CrashConfig{
+			Dir:       getEnv("CRASH_DIR", ""),
+			UploadURL: getEnv("CRASH_UPLOAD_URL", ""),
+			LogLines:  getEnvInt("CRASH_LOG_LINES", 0),
+			Spans:     getEnvInt("CRASH_SPANS", 0),
+		},
+
+		Hardware: HardwareConfig{
+			GPU:           strings.ToLower(getEnv("GPU_METRICS_SOURCE", "")),
+			NvidiaSMIPath: getEnv("GPU_NVIDIA_SMI_PATH", ""),
+			DCGMEndpoint:  getEnv("GPU_DCGM_ENDPOINT", ""),
+			Thermal:       getEnvBool("HARDWARE_THERMAL_METRICS", false),
+			Interval:      getEnvDuration("HARDWARE_METRICS_INTERVAL", 0),
+		},
+
+		Kubernetes: KubernetesConfig{
+			Enabled:         getEnvBool("K8S_METADATA_ENABLED", false),
+			PodName:         getEnv("POD_NAME", ""),
+			Namespace:       getEnv("POD_NAMESPACE", ""),
+			NodeName:        getEnv("NODE_NAME", ""),
+			PodUID:          getEnv("POD_UID", ""),
+			PodInfoDir:      getEnv("K8S_PODINFO_DIR", ""),
+			Watch:           getEnvBool("K8S_METADATA_WATCH", false),
+			Labels:          getEnvSlice("K8S_METADATA_LABELS", nil),
+			Annotations:     getEnvSlice("K8S_METADATA_ANNOTATIONS", nil),
+			RefreshInterval: getEnvDuration("K8S_REFRESH_INTERVAL", 0),
+		},
+
+		ExporterPolicy: ExporterPolicyConfig{
+			Policy:  strings.ToLower(getEnv("APM_EXPORTER_POLICY", preset.ExporterPolicy)),
+			Timeout: getEnvDuration("APM_EXPORTER_CHECK_TIMEOUT", 0),
+		},
 	}
 }
 
-// LoadFromEnv loads configuration from environment variables
+// LoadFromEnv loads configuration from environment variables. The standard
+// OpenTelemetry variables (OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES,
+// OTEL_TRACES_EXPORTER, OTEL_EXPORTER_OTLP_*, OTEL_TRACES_SAMPLER, ...)
+// override APM's own, which override the APM_PRESET defaults. Tracing is
+// configured only when the OTEL_* variables ask for an exporter.
 func LoadFromEnv() *Config {
 	cfg := DefaultConfig()
 
@@ -104,6 +256,14 @@ func LoadFromEnv() *Config {
 		cfg.Metrics.Path = path
 	}
 
+	if file := os.Getenv("METRICS_RELABEL_FILE"); file != "" {
+		cfg.Metrics.RelabelFile = file
+	}
+
+	if analyze := os.Getenv("METRICS_ANALYZE_BUCKETS"); analyze != "" {
+		cfg.Metrics.AnalyzeBuckets = parseBool(analyze)
+	}
+
 	// Load logging config
 	if level := os.Getenv("LOG_LEVEL"); level != "" {
 		cfg.Logging.Level = level
@@ -133,6 +293,9 @@ func LoadFromEnv() *Config {
 		cfg.Logging.EnableStacktrace = parseBool(stacktrace)
 	}
 
+	// Standard OTEL_* variables take precedence over the ones above
+	applyOTelEnv(cfg)
+
 	return cfg
 }
 
@@ -152,6 +315,36 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getEnvInt returns the integer value of an environment variable or a default value
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// getEnvFloat returns the float value of an environment variable or a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration returns the duration value of an environment variable or a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
 // getEnvSlice returns a slice from a comma-separated environment variable
 func getEnvSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
@@ -91,6 +91,7 @@ func StartSpanWithCorrelation(ctx context.Context, tracer trace.Tracer, spanName
 
 // FiberContextWithCorrelation creates a context with correlation ID for Fiber
 func FiberContextWithCorrelation(c *fiber.Ctx) context.Context {
+	checkContext(c, "FiberContextWithCorrelation")
 	ctx := c.UserContext()
 	if ctx == nil {
 		ctx = c.Context()
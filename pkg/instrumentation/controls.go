@@ -0,0 +1,352 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultFaultTTL bounds how long injected faults stay active when no TTL is
+// given, so a forgotten experiment cannot degrade a service indefinitely
+const DefaultFaultTTL = 15 * time.Minute
+
+// Controls holds the settings that may change while the service runs: the
+// log level, a trace sampling override, feature flags, fault injection, and
+// the series quota. The admin API changes them; the service reads flags with
+// Flag.
+type Controls struct {
+	logLevel zap.AtomicLevel
+	quota    *QuotaManager
+
+	mu          sync.RWMutex
+	sampleRate  *float64
+	sampler     sdktrace.Sampler
+	flags       map[string]bool
+	faults      []FaultRule
+	faultExempt string
+	rand        func() float64
+	// publish shares changes with other replicas; nil keeps them local
+	publish func(context.Context, ControlsSnapshot) error
+}
+
+// FaultRule injects latency or errors into matching requests
+type FaultRule struct {
+	// Path is a request path prefix; empty matches every path
+	Path string `json:"path,omitempty"`
+	// Method restricts the rule to one HTTP method; empty matches any
+	Method string `json:"method,omitempty"`
+	// LatencyMS delays matching requests before they are handled
+	LatencyMS int `json:"latency_ms,omitempty"`
+	// ErrorRate is the fraction of matching requests failed with Status
+	ErrorRate float64 `json:"error_rate,omitempty"`
+	// Status is the error status, 503 by default
+	Status    int       `json:"status,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ControlsSnapshot is the current state of the runtime controls
+type ControlsSnapshot struct {
+	// SampleRate is the sampling override; nil when the configured sampler
+	// applies
+	SampleRate *float64        `json:"sample_rate"`
+	LogLevel   string          `json:"log_level"`
+	Flags      map[string]bool `json:"flags"`
+	Faults     []FaultRule     `json:"faults"`
+	// MaxSeries is the series quota; nil when quotas are disabled
+	MaxSeries *int `json:"max_series,omitempty"`
+}
+
+// NewControls creates controls over the given log level and, when non-nil,
+// the series quota
+func NewControls(level zap.AtomicLevel, quota *QuotaManager) *Controls {
+	return &Controls{
+		logLevel: level,
+		quota:    quota,
+		flags:    make(map[string]bool),
+		rand:     rand.Float64,
+	}
+}
+
+// Snapshot returns the current state of all controls
+func (ctl *Controls) Snapshot() ControlsSnapshot {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+
+	snap := ControlsSnapshot{
+		LogLevel: ctl.logLevel.Level().String(),
+		Flags:    make(map[string]bool, len(ctl.flags)),
+		Faults:   ctl.activeFaults(),
+	}
+	if ctl.sampleRate != nil {
+		rate := *ctl.sampleRate
+		snap.SampleRate = &rate
+	}
+	for name, enabled := range ctl.flags {
+		snap.Flags[name] = enabled
+	}
+	if ctl.quota != nil {
+		maxSeries := ctl.quota.MaxSeries()
+		snap.MaxSeries = &maxSeries
+	}
+	return snap
+}
+
+// Apply replaces all controls with a snapshot, such as one shared by another
+// replica. The series quota is left alone when quotas are disabled.
+func (ctl *Controls) Apply(snap ControlsSnapshot) error {
+	if snap.LogLevel != "" {
+		if err := ctl.SetLogLevel(snap.LogLevel); err != nil {
+			return err
+		}
+	}
+	if err := ctl.SetSampleRate(snap.SampleRate); err != nil {
+		return err
+	}
+	if err := ctl.SetFaults(snap.Faults, 0); err != nil {
+		return err
+	}
+	if snap.MaxSeries != nil && ctl.quota != nil {
+		if err := ctl.SetMaxSeries(*snap.MaxSeries); err != nil {
+			return err
+		}
+	}
+
+	flags := make(map[string]bool, len(snap.Flags))
+	for name, enabled := range snap.Flags {
+		flags[name] = enabled
+	}
+	ctl.mu.Lock()
+	ctl.flags = flags
+	ctl.mu.Unlock()
+	return nil
+}
+
+// Publish shares the current controls with the other replicas when a
+// ControlSync is attached
+func (ctl *Controls) Publish(ctx context.Context) error {
+	ctl.mu.RLock()
+	publish := ctl.publish
+	ctl.mu.RUnlock()
+	if publish == nil {
+		return nil
+	}
+	return publish(ctx, ctl.Snapshot())
+}
+
+// LogLevel returns the current minimum log level
+func (ctl *Controls) LogLevel() zapcore.Level {
+	return ctl.logLevel.Level()
+}
+
+// SetLogLevel changes the minimum log level. Quota degradation starts from
+// the new level.
+func (ctl *Controls) SetLogLevel(level string) error {
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	ctl.logLevel.SetLevel(parsed)
+	if ctl.quota != nil {
+		ctl.quota.setBaseLogLevel(parsed)
+	}
+	return nil
+}
+
+// SampleRate returns the sampling override, if one is set
+func (ctl *Controls) SampleRate() (float64, bool) {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	if ctl.sampleRate == nil {
+		return 0, false
+	}
+	return *ctl.sampleRate, true
+}
+
+// SetSampleRate overrides the configured sampler: new traces are sampled at
+// rate and spans follow their parent's decision. Nil restores the configured
+// sampler.
+func (ctl *Controls) SetSampleRate(rate *float64) error {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	if rate == nil {
+		ctl.sampleRate, ctl.sampler = nil, nil
+		return nil
+	}
+	if *rate < 0 || *rate > 1 {
+		return fmt.Errorf("sample rate %g must be between 0 and 1", *rate)
+	}
+	r := *rate
+	ctl.sampleRate = &r
+	ctl.sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(r))
+	return nil
+}
+
+// Sampler wraps the configured sampler so SetSampleRate can override it
+func (ctl *Controls) Sampler(base sdktrace.Sampler) sdktrace.Sampler {
+	return &controlledSampler{controls: ctl, base: base}
+}
+
+type controlledSampler struct {
+	controls *Controls
+	base     sdktrace.Sampler
+}
+
+// ShouldSample implements sdktrace.Sampler
+func (s *controlledSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	s.controls.mu.RLock()
+	override := s.controls.sampler
+	s.controls.mu.RUnlock()
+	if override != nil {
+		return override.ShouldSample(p)
+	}
+	return s.base.ShouldSample(p)
+}
+
+// Description implements sdktrace.Sampler
+func (s *controlledSampler) Description() string {
+	return "ControlledSampler{" + s.base.Description() + "}"
+}
+
+// Flag reports whether a feature flag is enabled; unknown flags are off
+func (ctl *Controls) Flag(name string) bool {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	return ctl.flags[name]
+}
+
+// SetFlag enables or disables a feature flag
+func (ctl *Controls) SetFlag(name string, enabled bool) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	ctl.flags[name] = enabled
+}
+
+// DeleteFlag removes a feature flag, which then reads as disabled
+func (ctl *Controls) DeleteFlag(name string) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	delete(ctl.flags, name)
+}
+
+// Faults returns the fault rules that have not expired
+func (ctl *Controls) Faults() []FaultRule {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+	return ctl.activeFaults()
+}
+
+// SetFaults replaces the fault rules. Rules without an expiry expire after
+// ttl, or DefaultFaultTTL when ttl is zero.
+func (ctl *Controls) SetFaults(rules []FaultRule, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultFaultTTL
+	}
+	expires := time.Now().Add(ttl)
+
+	out := make([]FaultRule, len(rules))
+	for i, rule := range rules {
+		if rule.ErrorRate < 0 || rule.ErrorRate > 1 {
+			return fmt.Errorf("fault %d: error rate %g must be between 0 and 1", i+1, rule.ErrorRate)
+		}
+		if rule.LatencyMS < 0 {
+			return fmt.Errorf("fault %d: latency must not be negative", i+1)
+		}
+		if rule.Status == 0 {
+			rule.Status = fiber.StatusServiceUnavailable
+		}
+		if rule.Status < 400 || rule.Status > 599 {
+			return fmt.Errorf("fault %d: status %d is not an error status", i+1, rule.Status)
+		}
+		if rule.ExpiresAt.IsZero() {
+			rule.ExpiresAt = expires
+		}
+		rule.Method = strings.ToUpper(rule.Method)
+		out[i] = rule
+	}
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	ctl.faults = out
+	return nil
+}
+
+// activeFaults returns the unexpired fault rules; callers hold mu
+func (ctl *Controls) activeFaults() []FaultRule {
+	now := time.Now()
+	active := make([]FaultRule, 0, len(ctl.faults))
+	for _, rule := range ctl.faults {
+		if now.Before(rule.ExpiresAt) {
+			active = append(active, rule)
+		}
+	}
+	return active
+}
+
+// matchFault returns the first active fault rule matching a request
+func (ctl *Controls) matchFault(method, path string) (FaultRule, bool) {
+	ctl.mu.RLock()
+	defer ctl.mu.RUnlock()
+
+	if ctl.faultExempt != "" && strings.HasPrefix(path, ctl.faultExempt) {
+		return FaultRule{}, false
+	}
+	now := time.Now()
+	for _, rule := range ctl.faults {
+		if now.Before(rule.ExpiresAt) &&
+			(rule.Method == "" || rule.Method == method) &&
+			strings.HasPrefix(path, rule.Path) {
+			return rule, true
+		}
+	}
+	return FaultRule{}, false
+}
+
+// FaultMiddleware applies the fault rules. Requests to the admin API are
+// never faulted, so injected errors cannot lock operators out.
+func (ctl *Controls) FaultMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rule, ok := ctl.matchFault(c.Method(), c.Path())
+		if !ok {
+			return c.Next()
+		}
+
+		span := trace.SpanFromContext(c.UserContext())
+		if rule.LatencyMS > 0 {
+			time.Sleep(time.Duration(rule.LatencyMS) * time.Millisecond)
+		}
+		fail := rule.ErrorRate > 0 && ctl.rand() < rule.ErrorRate
+
+		span.AddEvent("fault.injected", trace.WithAttributes(
+			attribute.String("fault.path", rule.Path),
+			attribute.Int("fault.latency_ms", rule.LatencyMS),
+			attribute.Bool("fault.error", fail),
+		))
+		if fail {
+			return fiber.NewError(rule.Status, "injected fault")
+		}
+		return c.Next()
+	}
+}
+
+// SetMaxSeries changes the series quota; it fails when quotas are disabled
+func (ctl *Controls) SetMaxSeries(maxSeries int) error {
+	if ctl.quota == nil {
+		return fmt.Errorf("quotas are disabled")
+	}
+	if maxSeries < 0 {
+		return fmt.Errorf("max series must not be negative")
+	}
+	ctl.quota.SetMaxSeries(maxSeries)
+	return nil
+}
@@ -0,0 +1,299 @@
+package instrumentation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errNoEndpoints is returned by a store without endpoints
+var errNoEndpoints = errors.New("no control store endpoints")
+
+// storeClient sends requests to the first reachable of several endpoints
+type storeClient struct {
+	endpoints []string
+	client    *http.Client
+	header    func(*http.Request)
+}
+
+// do sends a request built for each endpoint in turn until one answers.
+// Failures to connect move on to the next endpoint; any response is returned.
+func (c *storeClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	if len(c.endpoints) == 0 {
+		return nil, errNoEndpoints
+	}
+	var errs []error
+	for _, endpoint := range c.endpoints {
+		req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		c.header(req)
+		resp, err := c.client.Do(req)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ConsulControlStore stores the controls in the Consul KV store and watches
+// them with blocking queries
+type ConsulControlStore struct {
+	key    string
+	client storeClient
+}
+
+// NewConsulControlStore creates a store for key on the Consul agents at
+// endpoints, http://localhost:8500 by default
+func NewConsulControlStore(endpoints []string, key, token string) *ConsulControlStore {
+	if len(endpoints) == 0 {
+		endpoints = []string{"http://localhost:8500"}
+	}
+	return &ConsulControlStore{
+		key: strings.TrimPrefix(key, "/"),
+		client: storeClient{
+			endpoints: endpoints,
+			client:    &http.Client{},
+			header: func(req *http.Request) {
+				if token != "" {
+					req.Header.Set("X-Consul-Token", token)
+				}
+			},
+		},
+	}
+}
+
+// Get implements ControlStore
+func (s *ConsulControlStore) Get(ctx context.Context) (*ControlsSnapshot, uint64, error) {
+	return s.get(ctx, "/v1/kv/"+s.key)
+}
+
+// Watch implements ControlStore with a blocking query on the key's index
+func (s *ConsulControlStore) Watch(ctx context.Context, version uint64) (*ControlsSnapshot, uint64, error) {
+	wait := int(controlWatchWait.Seconds())
+	return s.get(ctx, fmt.Sprintf("/v1/kv/%s?index=%d&wait=%ds", s.key, version, wait))
+}
+
+func (s *ConsulControlStore) get(ctx context.Context, path string) (*ControlsSnapshot, uint64, error) {
+	resp, err := s.client.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	// Consul requires an index of at least 1 for a blocking query to block
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if index == 0 {
+		index = 1
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, index, nil
+	default:
+		return nil, 0, storeError("consul", resp)
+	}
+
+	var entries []struct {
+		Value []byte // base64 in the response
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("consul: %w", err)
+	}
+	if len(entries) == 0 || len(entries[0].Value) == 0 {
+		return nil, index, nil
+	}
+	snap, err := decodeControls(entries[0].Value)
+	return snap, index, err
+}
+
+// Put implements ControlStore
+func (s *ConsulControlStore) Put(ctx context.Context, snap ControlsSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.do(ctx, http.MethodPut, "/v1/kv/"+s.key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return storeError("consul", resp)
+	}
+	return nil
+}
+
+// EtcdControlStore stores the controls in etcd through its v3 JSON gateway
+// and watches them with a watch stream
+type EtcdControlStore struct {
+	key    string
+	client storeClient
+}
+
+// NewEtcdControlStore creates a store for key on the etcd members at
+// endpoints, http://localhost:2379 by default
+func NewEtcdControlStore(endpoints []string, key, token string) *EtcdControlStore {
+	if len(endpoints) == 0 {
+		endpoints = []string{"http://localhost:2379"}
+	}
+	return &EtcdControlStore{
+		key: key,
+		client: storeClient{
+			endpoints: endpoints,
+			client:    &http.Client{},
+			header: func(req *http.Request) {
+				if token != "" {
+					req.Header.Set("Authorization", token)
+				}
+			},
+		},
+	}
+}
+
+// etcdKV is a key-value pair in etcd gateway responses; int64 fields are
+// encoded as strings
+type etcdKV struct {
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+// Get implements ControlStore
+func (s *EtcdControlStore) Get(ctx context.Context) (*ControlsSnapshot, uint64, error) {
+	var out struct {
+		Header etcdHeader `json:"header"`
+		KVs    []etcdKV   `json:"kvs"`
+	}
+	if err := s.call(ctx, "/v3/kv/range", map[string][]byte{"key": []byte(s.key)}, &out); err != nil {
+		return nil, 0, err
+	}
+	if len(out.KVs) == 0 {
+		return nil, uint64(out.Header.Revision), nil
+	}
+	snap, err := decodeControls(out.KVs[0].Value)
+	return snap, uint64(out.KVs[0].ModRevision), err
+}
+
+// Watch implements ControlStore; it reads the watch stream until the key
+// changes after version
+func (s *EtcdControlStore) Watch(ctx context.Context, version uint64) (*ControlsSnapshot, uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, controlWatchWait)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(s.key),
+			"start_revision": strconv.FormatUint(version+1, 10),
+		},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.client.do(ctx, http.MethodPost, "/v3/watch", body)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, version, nil
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, storeError("etcd", resp)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []struct {
+					Type string `json:"type"`
+					KV   etcdKV `json:"kv"`
+				} `json:"events"`
+				Canceled     bool   `json:"canceled"`
+				CancelReason string `json:"cancel_reason"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			// An expired wait closes the stream without a change
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, version, nil
+			}
+			return nil, 0, fmt.Errorf("etcd watch: %w", err)
+		}
+		if msg.Result.Canceled {
+			return nil, 0, fmt.Errorf("etcd watch canceled: %s", msg.Result.CancelReason)
+		}
+		if n := len(msg.Result.Events); n > 0 {
+			last := msg.Result.Events[n-1]
+			if last.Type == "DELETE" {
+				return nil, uint64(last.KV.ModRevision), nil
+			}
+			snap, err := decodeControls(last.KV.Value)
+			return snap, uint64(last.KV.ModRevision), err
+		}
+	}
+}
+
+// Put implements ControlStore
+func (s *EtcdControlStore) Put(ctx context.Context, snap ControlsSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "/v3/kv/put", map[string][]byte{"key": []byte(s.key), "value": data}, nil)
+}
+
+// call posts a JSON gateway request; byte slices are sent base64-encoded as
+// the gateway expects
+func (s *EtcdControlStore) call(ctx context.Context, path string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.do(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return storeError("etcd", resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("etcd: %w", err)
+	}
+	return nil
+}
+
+func decodeControls(data []byte) (*ControlsSnapshot, error) {
+	var snap ControlsSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("stored controls are invalid: %w", err)
+	}
+	return &snap, nil
+}
+
+func storeError(backend string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s returned %s: %s", backend, resp.Status, strings.TrimSpace(string(msg)))
+}
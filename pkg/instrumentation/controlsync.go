@@ -0,0 +1,197 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Control sync backends
+const (
+	ControlBackendEtcd   = "etcd"
+	ControlBackendConsul = "consul"
+)
+
+// controlWatchWait bounds a single watch request so dead connections are
+// noticed; an expired wait is not an error
+const controlWatchWait = 5 * time.Minute
+
+// ControlSyncConfig shares the runtime controls between the replicas of a
+// service through etcd or Consul, so a change made through the admin API of
+// one replica reaches all of them
+type ControlSyncConfig struct {
+	Backend   string   // "etcd" or "consul"; empty keeps the controls local
+	Endpoints []string // Store URLs, tried in order; default to the local agent
+	Key       string   // Key holding the controls, default apm/controls/<service>
+	Token     string   // Consul ACL token or etcd auth token
+	// CacheFile keeps the last known controls, which are applied when the
+	// store is unreachable at startup
+	CacheFile string
+}
+
+// Enabled reports whether a backend is configured
+func (c ControlSyncConfig) Enabled() bool {
+	return c.Backend != ""
+}
+
+// ControlStore stores the shared controls as a versioned document
+type ControlStore interface {
+	// Get returns the stored controls and their version; the snapshot is
+	// nil when none are stored
+	Get(ctx context.Context) (*ControlsSnapshot, uint64, error)
+	// Watch blocks until the version moves past version, or a store-defined
+	// wait expires, and then returns as Get
+	Watch(ctx context.Context, version uint64) (*ControlsSnapshot, uint64, error)
+	// Put stores the controls
+	Put(ctx context.Context, snap ControlsSnapshot) error
+}
+
+// NewControlStore creates the store of a sync configuration
+func NewControlStore(config ControlSyncConfig) (ControlStore, error) {
+	switch config.Backend {
+	case ControlBackendConsul:
+		return NewConsulControlStore(config.Endpoints, config.Key, config.Token), nil
+	case ControlBackendEtcd:
+		return NewEtcdControlStore(config.Endpoints, config.Key, config.Token), nil
+	default:
+		return nil, fmt.Errorf("unsupported control backend %q: use etcd or consul", config.Backend)
+	}
+}
+
+// ControlSync keeps Controls in step with a ControlStore: it loads the stored
+// controls at startup, applies every change it watches, and publishes local
+// changes. The last applied controls are cached on disk as a fallback.
+type ControlSync struct {
+	controls  *Controls
+	store     ControlStore
+	cacheFile string
+	logger    *zap.Logger
+	version   uint64
+}
+
+// NewControlSync attaches a store to controls, so Controls.Publish writes to
+// it. An empty cacheFile disables the local fallback.
+func NewControlSync(controls *Controls, store ControlStore, cacheFile string, logger *zap.Logger) *ControlSync {
+	s := &ControlSync{controls: controls, store: store, cacheFile: cacheFile, logger: logger}
+	controls.mu.Lock()
+	controls.publish = store.Put
+	controls.mu.Unlock()
+	return s
+}
+
+// Load applies the stored controls, or the cached ones when the store is
+// unreachable. It returns the store error even when the cache was applied.
+func (s *ControlSync) Load(ctx context.Context) error {
+	snap, version, err := s.store.Get(ctx)
+	if err != nil {
+		if cached, cacheErr := s.readCache(); cacheErr == nil {
+			s.logger.Warn("control store unreachable, using cached controls",
+				zap.String("cache", s.cacheFile), zap.Error(err))
+			if applyErr := s.controls.Apply(*cached); applyErr != nil {
+				s.logger.Error("failed to apply cached controls", zap.Error(applyErr))
+			}
+		}
+		return fmt.Errorf("failed to load controls: %w", err)
+	}
+	s.version = version
+	s.apply(snap)
+	return nil
+}
+
+// Run watches the store until ctx is done. When a watch fails it retries
+// with backoff, resynchronizing first in case the watch fell behind.
+func (s *ControlSync) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		snap, version, err := s.store.Watch(ctx, s.version)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("control store watch failed", zap.Error(err), zap.Duration("retry_in", backoff))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+			if snap, version, err := s.store.Get(ctx); err == nil {
+				s.update(snap, version)
+			}
+			continue
+		}
+		backoff = time.Second
+		s.update(snap, version)
+	}
+}
+
+// update applies stored controls of a new version
+func (s *ControlSync) update(snap *ControlsSnapshot, version uint64) {
+	if version == s.version {
+		return
+	}
+	s.version = version
+	s.apply(snap)
+}
+
+// apply applies stored controls and caches them; nil leaves the local
+// controls as they are
+func (s *ControlSync) apply(snap *ControlsSnapshot) {
+	if snap == nil {
+		return
+	}
+	if err := s.controls.Apply(*snap); err != nil {
+		s.logger.Error("failed to apply shared controls", zap.Error(err))
+		return
+	}
+	s.logger.Info("applied shared controls", zap.Uint64("version", s.version))
+	if err := s.writeCache(*snap); err != nil {
+		s.logger.Warn("failed to cache controls", zap.String("cache", s.cacheFile), zap.Error(err))
+	}
+}
+
+func (s *ControlSync) readCache() (*ControlsSnapshot, error) {
+	if s.cacheFile == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(s.cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var snap ControlsSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// writeCache replaces the cache file atomically
+func (s *ControlSync) writeCache(snap ControlsSnapshot) error {
+	if s.cacheFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.cacheFile), ".controls-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.cacheFile)
+}
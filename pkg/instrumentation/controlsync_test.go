@@ -0,0 +1,195 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fakeKV is a single versioned value behind the Consul KV and etcd gateway
+// APIs, enough for the control stores
+type fakeKV struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	value   []byte
+	version uint64
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{changed: make(chan struct{}), version: 1}
+}
+
+func (kv *fakeKV) put(value []byte) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.value = value
+	kv.version++
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+// wait blocks until the version passes after, or the request ends
+func (kv *fakeKV) wait(r *http.Request, after uint64) ([]byte, uint64) {
+	for {
+		kv.mu.Lock()
+		value, version, changed := kv.value, kv.version, kv.changed
+		kv.mu.Unlock()
+		if version > after {
+			return value, version
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return value, version
+		}
+	}
+}
+
+func (kv *fakeKV) consul() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			kv.put(body)
+			w.Write([]byte("true"))
+			return
+		}
+		after, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		value, version := kv.wait(r, after)
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(version, 10))
+		if value == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"Key": r.URL.Path, "Value": value}})
+	})
+}
+
+func (kv *fakeKV) etcd() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.URL.Path {
+		case "/v3/kv/put":
+			var value []byte
+			json.Unmarshal(req["value"], &value)
+			kv.put(value)
+			w.Write([]byte(`{}`))
+		case "/v3/kv/range":
+			kv.mu.Lock()
+			value, version := kv.value, kv.version
+			kv.mu.Unlock()
+			resp := map[string]interface{}{"header": map[string]string{"revision": strconv.FormatUint(version, 10)}}
+			if value != nil {
+				resp["kvs"] = []map[string]string{{"value": base64.StdEncoding.EncodeToString(value), "mod_revision": strconv.FormatUint(version, 10)}}
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/v3/watch":
+			var create struct {
+				StartRevision string `json:"start_revision"`
+			}
+			json.Unmarshal(req["create_request"], &create)
+			start, _ := strconv.ParseUint(create.StartRevision, 10, 64)
+			w.Write([]byte(`{"result":{"created":true}}` + "\n"))
+			w.(http.Flusher).Flush()
+			value, version := kv.wait(r, start-1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{
+				"events": []map[string]interface{}{{"kv": map[string]string{
+					"value":        base64.StdEncoding.EncodeToString(value),
+					"mod_revision": strconv.FormatUint(version, 10),
+				}}},
+			}})
+		}
+	})
+}
+
+func TestControlSyncPropagates(t *testing.T) {
+	for _, backend := range []string{ControlBackendConsul, ControlBackendEtcd} {
+		t.Run(backend, func(t *testing.T) {
+			kv := newFakeKV()
+			handler := kv.consul()
+			if backend == ControlBackendEtcd {
+				handler = kv.etcd()
+			}
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			replicas := make([]*Controls, 2)
+			for i := range replicas {
+				replicas[i] = NewControls(zap.NewAtomicLevelAt(zapcore.InfoLevel), nil)
+				store, err := NewControlStore(ControlSyncConfig{Backend: backend, Endpoints: []string{"http://127.0.0.1:1", srv.URL}, Key: "apm/controls/test"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				s := NewControlSync(replicas[i], store, "", zap.NewNop())
+				if err := s.Load(ctx); err != nil {
+					t.Fatal(err)
+				}
+				go s.Run(ctx)
+			}
+
+			rate := 0.5
+			replicas[0].SetFlag("new-checkout", true)
+			replicas[0].SetSampleRate(&rate)
+			replicas[0].SetLogLevel("debug")
+			if err := replicas[0].Publish(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !replicas[1].Flag("new-checkout") && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !replicas[1].Flag("new-checkout") {
+				t.Fatal("flag did not reach the other replica")
+			}
+			if got, ok := replicas[1].SampleRate(); !ok || got != 0.5 {
+				t.Errorf("sample rate = %g, %v", got, ok)
+			}
+			if replicas[1].LogLevel() != zapcore.DebugLevel {
+				t.Errorf("log level = %s", replicas[1].LogLevel())
+			}
+		})
+	}
+}
+
+func TestControlSyncCacheFallback(t *testing.T) {
+	kv := newFakeKV()
+	srv := httptest.NewServer(kv.consul())
+	cache := filepath.Join(t.TempDir(), "controls.json")
+
+	ctl := NewControls(zap.NewAtomicLevel(), nil)
+	ctl.SetFlag("dark-mode", true)
+	snap := ctl.Snapshot()
+	data, _ := json.Marshal(snap)
+	kv.put(data)
+
+	s := NewControlSync(NewControls(zap.NewAtomicLevel(), nil), NewConsulControlStore([]string{srv.URL}, "k", ""), cache, zap.NewNop())
+	if err := s.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	// The store is gone; a restarted replica starts from the cache
+	restarted := NewControls(zap.NewAtomicLevel(), nil)
+	s = NewControlSync(restarted, NewConsulControlStore([]string{srv.URL}, "k", ""), cache, zap.NewNop())
+	if err := s.Load(context.Background()); err == nil {
+		t.Error("expected the store error to be reported")
+	}
+	if !restarted.Flag("dark-mode") {
+		t.Error("cached controls were not applied")
+	}
+}
@@ -0,0 +1,530 @@
+package instrumentation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Crash report defaults
+const (
+	DefaultCrashLogLines = 200
+	DefaultCrashSpans    = 100
+)
+
+// CrashConfig configures crash reports, written when the process panics,
+// logs at fatal level, or exits through CrashReporter.Exit
+type CrashConfig struct {
+	// Dir receives the reports; empty disables crash reporting
+	Dir string
+	// UploadURL also receives each report as an HTTP PUT, e.g. a bucket URL
+	// accepting writes. {name} is replaced by the report's file name, which
+	// is otherwise appended as a path segment.
+	UploadURL string
+	// LogLines is the number of recent log entries kept, default
+	// DefaultCrashLogLines
+	LogLines int
+	// Spans is the number of recently ended spans kept, default
+	// DefaultCrashSpans
+	Spans int
+}
+
+// Enabled reports whether crash reports are written
+func (c CrashConfig) Enabled() bool {
+	return c.Dir != ""
+}
+
+// CrashReport is the content of a crash report file
+type CrashReport struct {
+	Service string    `json:"service"`
+	Reason  string    `json:"reason"` // panic, fatal, exit, or previous-run
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	PID     int       `json:"pid"`
+	// Stack holds the stacks of all goroutines, or for previous-run the
+	// runtime's crash output
+	Stack       string            `json:"stack"`
+	Logs        []json.RawMessage `json:"logs"`
+	ActiveSpans []CrashSpan       `json:"active_spans"`
+	RecentSpans []CrashSpan       `json:"recent_spans"`
+	Runtime     CrashRuntime      `json:"runtime"`
+}
+
+// CrashSpan is a span in a crash report; End is zero for active spans
+type CrashSpan struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// CrashRuntime holds the runtime statistics at the time of the crash
+type CrashRuntime struct {
+	GoVersion  string        `json:"go_version"`
+	Goroutines int           `json:"goroutines"`
+	GOMAXPROCS int           `json:"gomaxprocs"`
+	HeapAlloc  uint64        `json:"heap_alloc_bytes"`
+	HeapSys    uint64        `json:"heap_sys_bytes"`
+	NumGC      uint32        `json:"num_gc"`
+	Uptime     time.Duration `json:"uptime_ns"`
+}
+
+// CrashReporter keeps the recent logs and spans of the process and writes
+// them to a crash report when the process dies, before exporters had a
+// chance to flush. It is a span processor, and a zap core through LogCore.
+//
+// Panics are caught by deferring Recover in main and in goroutines started
+// with Go; the runtime's own output for other fatal errors is saved by
+// Install and reported at the next start.
+type CrashReporter struct {
+	config  CrashConfig
+	service string
+	started time.Time
+	logger  *zap.Logger
+	crashes *prometheus.CounterVec
+
+	mu       sync.Mutex
+	logs     *ring[[]byte]
+	active   map[trace.SpanID]sdktrace.ReadWriteSpan
+	recent   *ring[sdktrace.ReadOnlySpan]
+	flushers []func(context.Context) error
+	crashOut *os.File
+	reported bool
+}
+
+// NewCrashReporter creates a crash reporter for a service
+func NewCrashReporter(config CrashConfig, service string) *CrashReporter {
+	if config.LogLines <= 0 {
+		config.LogLines = DefaultCrashLogLines
+	}
+	if config.Spans <= 0 {
+		config.Spans = DefaultCrashSpans
+	}
+	return &CrashReporter{
+		config:  config,
+		service: service,
+		started: time.Now(),
+		logger:  zap.NewNop(),
+		crashes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "crash_reports_total",
+				Help: "Crash reports written, by reason",
+			},
+			[]string{"reason"},
+		),
+		logs:   newRing[[]byte](config.LogLines),
+		active: make(map[trace.SpanID]sdktrace.ReadWriteSpan),
+		recent: newRing[sdktrace.ReadOnlySpan](config.Spans),
+	}
+}
+
+// Collectors returns the Prometheus collectors exported by the reporter
+func (r *CrashReporter) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.crashes}
+}
+
+// Register registers the reporter's collectors with the given registerer
+func (r *CrashReporter) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range r.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register crash collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// OnCrash registers a function run after a report is written, such as a
+// final metrics push or span flush. Each run is bounded by a few seconds.
+func (r *CrashReporter) OnCrash(fn func(context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushers = append(r.flushers, fn)
+}
+
+// Install sends the runtime's crash output, such as an unrecovered panic in
+// a goroutine or a fatal error, to a file in Dir, and reports the output
+// left by previous processes
+func (r *CrashReporter) Install() error {
+	if err := os.MkdirAll(r.config.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create crash directory: %w", err)
+	}
+
+	previous, _ := filepath.Glob(filepath.Join(r.config.Dir, r.service+"-*.crash"))
+	for _, path := range previous {
+		output, err := os.ReadFile(path)
+		// The output of live processes sharing Dir stays empty
+		if err != nil || len(bytes.TrimSpace(output)) == 0 {
+			continue
+		}
+		report := CrashReport{
+			Service: r.service,
+			Reason:  "previous-run",
+			Message: firstLine(string(output)),
+			Time:    modTime(path),
+			PID:     crashOutputPID(path),
+			Stack:   string(output),
+		}
+		if _, err := r.write(report); err != nil {
+			r.logger.Error("failed to report previous crash", zap.String("output", path), zap.Error(err))
+			continue
+		}
+		os.Remove(path)
+	}
+
+	path := filepath.Join(r.config.Dir, fmt.Sprintf("%s-%d.crash", r.service, os.Getpid()))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create crash output: %w", err)
+	}
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set crash output: %w", err)
+	}
+	r.crashOut = f
+	return nil
+}
+
+// Close stops sending the runtime's crash output to Dir, for a clean
+// shutdown
+func (r *CrashReporter) Close() error {
+	r.mu.Lock()
+	f := r.crashOut
+	r.crashOut = nil
+	r.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	debug.SetCrashOutput(nil, debug.CrashOptions{})
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Recover reports a panic and panics again, so the process still dies. It
+// must be deferred directly:
+//
+//	defer inst.Crash.Recover()
+//
+// Recover, Go, and Exit do nothing more than usual on a nil reporter.
+func (r *CrashReporter) Recover() {
+	if r == nil {
+		return
+	}
+	if v := recover(); v != nil {
+		r.Report("panic", fmt.Sprint(v))
+		panic(v)
+	}
+}
+
+// Go runs fn in a goroutine whose panics are reported
+func (r *CrashReporter) Go(fn func()) {
+	if r == nil {
+		go fn()
+		return
+	}
+	go func() {
+		defer r.Recover()
+		fn()
+	}()
+}
+
+// Exit reports a fatal condition and exits with code
+func (r *CrashReporter) Exit(code int, message string) {
+	if r != nil {
+		r.Report("exit", message)
+	}
+	os.Exit(code)
+}
+
+// OnWrite implements zapcore.CheckWriteHook, so logging at fatal level
+// writes a report before exiting
+func (r *CrashReporter) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	r.Report("fatal", ce.Message)
+	os.Exit(1)
+}
+
+// Report writes a crash report, uploads it when UploadURL is set, and runs
+// the OnCrash functions. Only the first report of a process is written.
+func (r *CrashReporter) Report(reason, message string) (string, error) {
+	r.mu.Lock()
+	if r.reported {
+		r.mu.Unlock()
+		return "", nil
+	}
+	r.reported = true
+	report := r.snapshot(reason, message)
+	flushers := r.flushers
+	r.mu.Unlock()
+
+	path, err := r.write(report)
+	if err != nil {
+		r.logger.Error("failed to write crash report", zap.Error(err))
+	} else {
+		r.logger.Error("crash report written", zap.String("reason", reason), zap.String("path", path))
+	}
+	r.logger.Sync()
+
+	for _, flush := range flushers {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		flush(ctx)
+		cancel()
+	}
+	return path, err
+}
+
+// snapshot collects the report content; r.mu is held
+func (r *CrashReporter) snapshot(reason, message string) CrashReport {
+	report := CrashReport{
+		Service: r.service,
+		Reason:  reason,
+		Message: message,
+		Time:    time.Now().UTC(),
+		Stack:   allStacks(),
+		Runtime: r.runtimeStats(),
+	}
+	for _, line := range r.logs.items() {
+		report.Logs = append(report.Logs, json.RawMessage(line))
+	}
+	for _, s := range r.active {
+		report.ActiveSpans = append(report.ActiveSpans, crashSpan(s))
+	}
+	for _, s := range r.recent.items() {
+		report.RecentSpans = append(report.RecentSpans, crashSpan(s))
+	}
+	return report
+}
+
+func (r *CrashReporter) runtimeStats() CrashRuntime {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return CrashRuntime{
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		HeapAlloc:  mem.HeapAlloc,
+		HeapSys:    mem.HeapSys,
+		NumGC:      mem.NumGC,
+		Uptime:     time.Since(r.started),
+	}
+}
+
+// write saves a report to Dir and uploads it, returning the file path
+func (r *CrashReporter) write(report CrashReport) (string, error) {
+	if report.PID == 0 {
+		report.PID = os.Getpid()
+	}
+	r.crashes.WithLabelValues(report.Reason).Inc()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode crash report: %w", err)
+	}
+	name := fmt.Sprintf("crash-%s-%s-%d.json", r.service, report.Time.UTC().Format("20060102T150405Z"), report.PID)
+	if err := os.MkdirAll(r.config.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create crash directory: %w", err)
+	}
+	path := filepath.Join(r.config.Dir, name)
+	// Logs and span attributes may hold sensitive values
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write crash report: %w", err)
+	}
+
+	if r.config.UploadURL != "" {
+		if err := uploadCrashReport(r.config.UploadURL, name, data); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+func uploadCrashReport(uploadURL, name string, data []byte) error {
+	target := strings.ReplaceAll(uploadURL, "{name}", name)
+	if target == uploadURL {
+		target = strings.TrimSuffix(uploadURL, "/") + "/" + name
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid crash upload URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload crash report: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to upload crash report: %s", resp.Status)
+	}
+	return nil
+}
+
+// OnStart implements sdktrace.SpanProcessor
+func (r *CrashReporter) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	r.mu.Lock()
+	r.active[s.SpanContext().SpanID()] = s
+	r.mu.Unlock()
+}
+
+// OnEnd implements sdktrace.SpanProcessor
+func (r *CrashReporter) OnEnd(s sdktrace.ReadOnlySpan) {
+	r.mu.Lock()
+	delete(r.active, s.SpanContext().SpanID())
+	r.recent.add(s)
+	r.mu.Unlock()
+}
+
+// Shutdown implements sdktrace.SpanProcessor
+func (r *CrashReporter) Shutdown(context.Context) error { return nil }
+
+// ForceFlush implements sdktrace.SpanProcessor
+func (r *CrashReporter) ForceFlush(context.Context) error { return nil }
+
+func crashSpan(s sdktrace.ReadOnlySpan) CrashSpan {
+	span := CrashSpan{
+		TraceID: s.SpanContext().TraceID().String(),
+		SpanID:  s.SpanContext().SpanID().String(),
+		Name:    s.Name(),
+		Start:   s.StartTime(),
+		End:     s.EndTime(),
+	}
+	if s.Parent().IsValid() {
+		span.ParentID = s.Parent().SpanID().String()
+	}
+	if status := s.Status(); status.Code != 0 {
+		span.Status = strings.TrimSpace(status.Code.String() + " " + status.Description)
+	}
+	if attrs := s.Attributes(); len(attrs) > 0 {
+		span.Attributes = make(map[string]string, len(attrs))
+		for _, kv := range attrs {
+			span.Attributes[string(kv.Key)] = kv.Value.Emit()
+		}
+	}
+	return span
+}
+
+// LogCore returns a core keeping the last LogLines entries logged at the
+// levels enab enables, to be teed with the logger's own core
+func (r *CrashReporter) LogCore(enab zapcore.LevelEnabler) zapcore.Core {
+	return &crashLogCore{
+		LevelEnabler: enab,
+		enc:          zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		reporter:     r,
+	}
+}
+
+type crashLogCore struct {
+	zapcore.LevelEnabler
+	enc      zapcore.Encoder
+	reporter *CrashReporter
+}
+
+func (c *crashLogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &crashLogCore{LevelEnabler: c.LevelEnabler, enc: enc, reporter: c.reporter}
+}
+
+func (c *crashLogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *crashLogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := bytes.TrimSpace(append([]byte(nil), buf.Bytes()...))
+	buf.Free()
+
+	c.reporter.mu.Lock()
+	c.reporter.logs.add(line)
+	c.reporter.mu.Unlock()
+	return nil
+}
+
+func (c *crashLogCore) Sync() error { return nil }
+
+// ring keeps the last n items added
+type ring[T any] struct {
+	buf  []T
+	next int
+	full bool
+}
+
+func newRing[T any](n int) *ring[T] {
+	return &ring[T]{buf: make([]T, n)}
+}
+
+func (r *ring[T]) add(v T) {
+	r.buf[r.next] = v
+	r.next = (r.next + 1) % len(r.buf)
+	r.full = r.full || r.next == 0
+}
+
+// items returns the items, oldest first
+func (r *ring[T]) items() []T {
+	if !r.full {
+		return append([]T(nil), r.buf[:r.next]...)
+	}
+	return append(append([]T(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// allStacks returns the stacks of all goroutines, up to 4 MiB
+func allStacks() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 4<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// crashOutputPID returns the process ID in the name of a crash output file
+func crashOutputPID(path string) int {
+	name := strings.TrimSuffix(filepath.Base(path), ".crash")
+	pid, _ := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	return pid
+}
+
+func modTime(path string) time.Time {
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime().UTC()
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,160 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func readCrashReport(t *testing.T, path string) CrashReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestCrashReporterReport(t *testing.T) {
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPut && json.Valid(body) {
+			uploaded = r.URL.Path
+		}
+	}))
+	defer server.Close()
+
+	r := NewCrashReporter(CrashConfig{Dir: t.TempDir(), UploadURL: server.URL + "/crashes/{name}", LogLines: 2}, "checkout")
+	flushed := false
+	r.OnCrash(func(context.Context) error {
+		flushed = true
+		return nil
+	})
+
+	logger := zap.New(r.LogCore(zapcore.InfoLevel)).With(zap.String("request_id", "r-1"))
+	logger.Info("first")
+	logger.Debug("not kept")
+	logger.Info("second")
+	logger.Warn("third")
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(r))
+	ctx, active := tp.Tracer("test").Start(context.Background(), "POST /orders")
+	_, child := tp.Tracer("test").Start(ctx, "charge card")
+	child.End()
+
+	path, err := r.Report("panic", "boom")
+	active.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := readCrashReport(t, path)
+
+	if report.Service != "checkout" || report.Reason != "panic" || report.Message != "boom" || report.PID != os.Getpid() {
+		t.Errorf("report = %+v", report)
+	}
+	if !strings.Contains(report.Stack, "goroutine") || report.Runtime.Goroutines == 0 || report.Runtime.GoVersion == "" {
+		t.Errorf("missing stack or runtime stats: %+v", report.Runtime)
+	}
+	if len(report.Logs) != 2 || !strings.Contains(string(report.Logs[0]), `"second"`) || !strings.Contains(string(report.Logs[1]), `"r-1"`) {
+		t.Errorf("logs = %s", report.Logs)
+	}
+	if len(report.ActiveSpans) != 1 || report.ActiveSpans[0].Name != "POST /orders" || !report.ActiveSpans[0].End.IsZero() {
+		t.Errorf("active spans = %+v", report.ActiveSpans)
+	}
+	if len(report.RecentSpans) != 1 || report.RecentSpans[0].ParentID != report.ActiveSpans[0].SpanID {
+		t.Errorf("recent spans = %+v", report.RecentSpans)
+	}
+	if uploaded != "/crashes/"+filepath.Base(path) {
+		t.Errorf("uploaded to %q", uploaded)
+	}
+	if !flushed {
+		t.Error("OnCrash functions were not run")
+	}
+
+	if again, _ := r.Report("panic", "again"); again != "" {
+		t.Errorf("a second report was written to %s", again)
+	}
+}
+
+func TestCrashReporterRecover(t *testing.T) {
+	dir := t.TempDir()
+	r := NewCrashReporter(CrashConfig{Dir: dir}, "worker")
+
+	func() {
+		defer func() {
+			if v := recover(); v != "out of range" {
+				t.Errorf("recovered %v, want the panic to continue", v)
+			}
+		}()
+		defer r.Recover()
+		panic("out of range")
+	}()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "crash-worker-*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("reports = %v", paths)
+	}
+	if report := readCrashReport(t, paths[0]); report.Reason != "panic" || report.Message != "out of range" || !strings.Contains(report.Stack, "TestCrashReporterRecover") {
+		t.Errorf("report = %+v", report)
+	}
+
+	var nilReporter *CrashReporter
+	func() {
+		defer func() { recover() }()
+		defer nilReporter.Recover()
+		panic("ignored")
+	}()
+}
+
+func TestCrashReporterPreviousRun(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "api-4242.crash"), []byte("fatal error: concurrent map writes\n\ngoroutine 7 [running]:\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	live := filepath.Join(dir, "api-4343.crash")
+	if err := os.WriteFile(live, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewCrashReporter(CrashConfig{Dir: dir}, "api")
+	if err := r.Install(); err != nil {
+		t.Fatal(err)
+	}
+	own := r.crashOut.Name()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "crash-api-*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("reports = %v", paths)
+	}
+	report := readCrashReport(t, paths[0])
+	if report.Reason != "previous-run" || report.PID != 4242 || report.Message != "fatal error: concurrent map writes" {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "api-4242.crash")); !os.IsNotExist(err) {
+		t.Error("reported crash output was not removed")
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Error("the empty output of a live process was removed")
+	}
+	if _, err := os.Stat(own); !os.IsNotExist(err) {
+		t.Error("Close left the crash output behind")
+	}
+}
@@ -0,0 +1,192 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultInternalHostSuffixes are host suffixes never tracked as third parties
+var DefaultInternalHostSuffixes = []string{"localhost", ".local", ".internal", ".svc", ".svc.cluster.local", ".cluster.local"}
+
+// DependencyTrackerConfig configures outbound dependency tracking
+type DependencyTrackerConfig struct {
+	// InternalHostSuffixes are skipped in addition to private and loopback
+	// addresses; nil uses DefaultInternalHostSuffixes
+	InternalHostSuffixes []string
+
+	// Buckets are the latency histogram buckets in seconds; nil uses
+	// prometheus.DefBuckets
+	Buckets []float64
+}
+
+// DependencyTracker is a span processor that groups client spans by the
+// external host they call and exports request and latency metrics per host.
+// Failed and slow calls carry their trace ID as an exemplar, so SLA reports
+// can link the traces behind a breach.
+type DependencyTracker struct {
+	internal []string
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewDependencyTracker creates a dependency tracker
+func NewDependencyTracker(cfg DependencyTrackerConfig) *DependencyTracker {
+	if cfg.InternalHostSuffixes == nil {
+		cfg.InternalHostSuffixes = DefaultInternalHostSuffixes
+	}
+	if cfg.Buckets == nil {
+		cfg.Buckets = prometheus.DefBuckets
+	}
+
+	return &DependencyTracker{
+		internal: cfg.InternalHostSuffixes,
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dependency_requests_total",
+				Help: "Outbound calls to third-party dependencies by host, outcome, and status code",
+			},
+			[]string{"dependency", "outcome", "code"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "dependency_request_duration_seconds",
+				Help:    "Latency of outbound calls to third-party dependencies",
+				Buckets: cfg.Buckets,
+			},
+			[]string{"dependency"},
+		),
+	}
+}
+
+// Collectors returns the Prometheus collectors exported by the tracker
+func (d *DependencyTracker) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{d.requests, d.duration}
+}
+
+// Register registers the tracker's collectors with the given registerer
+func (d *DependencyTracker) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range d.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register dependency collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// OnStart implements sdktrace.SpanProcessor
+func (d *DependencyTracker) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+// OnEnd records a finished client span against its external host
+func (d *DependencyTracker) OnEnd(s sdktrace.ReadOnlySpan) {
+	if s.SpanKind() != trace.SpanKindClient {
+		return
+	}
+	host := DependencyHost(s.Attributes())
+	if host == "" || (!hasAttribute(s.Attributes(), "peer.service") && d.isInternal(host)) {
+		return
+	}
+
+	outcome, code := "success", "ok"
+	status := 0
+	for _, kv := range s.Attributes() {
+		if kv.Key == "http.response.status_code" || kv.Key == "http.status_code" {
+			status = int(kv.Value.AsInt64())
+		}
+	}
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	if status >= 500 || s.Status().Code == codes.Error {
+		outcome = "error"
+		if status == 0 {
+			code = "error"
+		}
+	}
+	d.requests.WithLabelValues(host, outcome, code).Inc()
+
+	seconds := s.EndTime().Sub(s.StartTime()).Seconds()
+	observer := d.duration.WithLabelValues(host)
+	if sc := s.SpanContext(); sc.IsSampled() {
+		if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(seconds, prometheus.Labels{"trace_id": sc.TraceID().String(), "outcome": outcome})
+			return
+		}
+	}
+	observer.Observe(seconds)
+}
+
+// Shutdown implements sdktrace.SpanProcessor
+func (d *DependencyTracker) Shutdown(context.Context) error { return nil }
+
+// ForceFlush implements sdktrace.SpanProcessor
+func (d *DependencyTracker) ForceFlush(context.Context) error { return nil }
+
+// isInternal reports whether a host belongs to the cluster or private
+// network; single-label names are taken to be cluster services
+func (d *DependencyTracker) isInternal(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+	}
+	for _, suffix := range d.internal {
+		if host == strings.TrimPrefix(suffix, ".") || strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return !strings.Contains(host, ".")
+}
+
+// DependencyHost returns the remote host of a client span from its
+// attributes, preferring an explicit peer.service name, then the current and
+// legacy semantic convention keys
+func DependencyHost(attrs []attribute.KeyValue) string {
+	values := make(map[attribute.Key]string, len(attrs))
+	for _, kv := range attrs {
+		values[kv.Key] = kv.Value.Emit()
+	}
+	if v := values["peer.service"]; v != "" {
+		return strings.ToLower(v)
+	}
+	for _, key := range []attribute.Key{"server.address", "net.peer.name", "http.host"} {
+		if v := values[key]; v != "" {
+			return normalizeHost(v)
+		}
+	}
+	for _, key := range []attribute.Key{"url.full", "http.url"} {
+		if u, err := url.Parse(values[key]); err == nil && u.Host != "" {
+			return normalizeHost(u.Host)
+		}
+	}
+	return ""
+}
+
+// hasAttribute reports whether a non-empty attribute is set
+func hasAttribute(attrs []attribute.KeyValue, key attribute.Key) bool {
+	for _, kv := range attrs {
+		if kv.Key == key && kv.Value.Emit() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost lowercases a host and strips any port
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
@@ -0,0 +1,60 @@
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestDependencyTracker(t *testing.T) {
+	tracker := NewDependencyTracker(DependencyTrackerConfig{})
+	reg := prometheus.NewRegistry()
+	if err := tracker.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(tracker))
+	tracer := tp.Tracer("test")
+
+	span := func(kind trace.SpanKind, attrs ...attribute.KeyValue) trace.Span {
+		_, s := tracer.Start(context.Background(), "call", trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+		return s
+	}
+
+	span(trace.SpanKindClient, attribute.String("server.address", "API.Stripe.com"), attribute.Int("http.response.status_code", 200)).End()
+	span(trace.SpanKindClient, attribute.String("http.url", "https://api.stripe.com:443/v1/charges"), attribute.Int("http.status_code", 503)).End()
+	s := span(trace.SpanKindClient, attribute.String("net.peer.name", "api.stripe.com"))
+	s.SetStatus(codes.Error, "connection reset")
+	s.End()
+	span(trace.SpanKindClient, attribute.String("peer.service", "geocoder")).End()
+
+	// Internal and non-client spans are not dependencies
+	span(trace.SpanKindClient, attribute.String("server.address", "payments.shop.svc.cluster.local")).End()
+	span(trace.SpanKindClient, attribute.String("server.address", "10.0.3.7")).End()
+	span(trace.SpanKindClient, attribute.String("server.address", "inventory")).End()
+	span(trace.SpanKindServer, attribute.String("server.address", "api.stripe.com")).End()
+
+	if got := testutil.ToFloat64(tracker.requests.WithLabelValues("api.stripe.com", "success", "200")); got != 1 {
+		t.Errorf("expected 1 success, got %v", got)
+	}
+	if got := testutil.ToFloat64(tracker.requests.WithLabelValues("api.stripe.com", "error", "503")); got != 1 {
+		t.Errorf("expected 1 503, got %v", got)
+	}
+	if got := testutil.ToFloat64(tracker.requests.WithLabelValues("api.stripe.com", "error", "error")); got != 1 {
+		t.Errorf("expected 1 transport error, got %v", got)
+	}
+	if got := testutil.ToFloat64(tracker.requests.WithLabelValues("geocoder", "success", "ok")); got != 1 {
+		t.Errorf("expected peer.service to name the dependency, got %v", got)
+	}
+	if got := testutil.CollectAndCount(tracker.requests); got != 4 {
+		t.Errorf("expected 4 series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(tracker.duration); got != 2 {
+		t.Errorf("expected latency for 2 dependencies, got %d", got)
+	}
+}
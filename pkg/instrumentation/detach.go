@@ -0,0 +1,160 @@
+package instrumentation
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// contextOwnerLocal holds the goroutine serving a request in debug mode
+const contextOwnerLocal = "apm.context.owner"
+
+var (
+	contextDebug  atomic.Bool
+	misuseHandler atomic.Value // func(op string, stack []byte)
+)
+
+func init() {
+	contextDebug.Store(getEnvBool("APM_CONTEXT_DEBUG", false))
+}
+
+// Detached is an immutable snapshot of a request's logger and trace context.
+// Fiber reuses its contexts once a handler returns, so goroutines started by
+// a handler must not touch the *fiber.Ctx; they use a Detached instead.
+//
+//	d := instrumentation.Detach(c)
+//	go func() {
+//		ctx, span := d.Start(tracer, "send-receipt")
+//		defer span.End()
+//		d.Logger().Info("receipt sent")
+//	}()
+type Detached struct {
+	ctx    context.Context
+	logger *zap.Logger
+	path   string
+}
+
+// Detach snapshots the request's logger and span context. It must be called
+// on the handler's goroutine, before any goroutine is started.
+func Detach(c *fiber.Ctx) Detached {
+	checkContext(c, "Detach")
+
+	ctx := c.UserContext()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// The work outlives the request, so it must not be cancelled with it
+	ctx = context.WithoutCancel(ctx)
+
+	logger := GetLogger(c)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.With(
+			zap.String("trace_id", sc.TraceID().String()),
+			zap.String("span_id", sc.SpanID().String()),
+		)
+	}
+
+	return Detached{
+		ctx:    ctx,
+		logger: logger,
+		path:   utils.CopyString(c.Path()),
+	}
+}
+
+// Context returns the request context without its cancellation; it carries
+// the request span, baggage, and correlation ID
+func (d Detached) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// Logger returns the request logger with the trace and span IDs
+func (d Detached) Logger() *zap.Logger {
+	if d.logger == nil {
+		return zap.L()
+	}
+	return d.logger
+}
+
+// SpanContext returns the span context of the request
+func (d Detached) SpanContext() trace.SpanContext {
+	return trace.SpanContextFromContext(d.Context())
+}
+
+// Path returns a copy of the request path
+func (d Detached) Path() string {
+	return d.path
+}
+
+// Start starts a child span of the request span
+func (d Detached) Start(tracer trace.Tracer, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer.Start(d.Context(), name, opts...)
+}
+
+// SetContextDebug enables detection of request contexts used from goroutines
+// other than the one serving the request, such as a *fiber.Ctx captured by a
+// goroutine instead of a Detached. It is off by default as it inspects the
+// goroutine on every access; APM_CONTEXT_DEBUG=true enables it at startup.
+func SetContextDebug(enabled bool) {
+	contextDebug.Store(enabled)
+}
+
+// OnContextMisuse replaces the function called when debug mode detects a
+// request context used from another goroutine. The default logs a warning
+// with the stack of the offending call.
+func OnContextMisuse(fn func(op string, stack []byte)) {
+	misuseHandler.Store(fn)
+}
+
+// guardContext records the goroutine serving the request in debug mode
+func guardContext(c *fiber.Ctx) {
+	if !contextDebug.Load() {
+		return
+	}
+	if _, ok := c.Locals(contextOwnerLocal).(uint64); !ok {
+		c.Locals(contextOwnerLocal, goroutineID())
+	}
+}
+
+// checkContext reports op as misuse in debug mode when it is called from a
+// goroutine other than the one serving the request
+func checkContext(c *fiber.Ctx, op string) {
+	if !contextDebug.Load() {
+		return
+	}
+	owner, ok := c.Locals(contextOwnerLocal).(uint64)
+	if !ok || owner == goroutineID() {
+		return
+	}
+	stack := debug.Stack()
+	if fn, ok := misuseHandler.Load().(func(string, []byte)); ok && fn != nil {
+		fn(op, stack)
+		return
+	}
+	zap.L().Warn("fiber context used outside its handler goroutine; use instrumentation.Detach",
+		zap.String("op", op),
+		zap.ByteString("stack", stack),
+	)
+}
+
+// goroutineID parses the current goroutine's ID from its stack header
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
@@ -0,0 +1,118 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDetach(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	core, logs := observer.New(zap.InfoLevel)
+
+	var wg sync.WaitGroup
+	released := make(chan struct{})
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctx, span := tracer.Start(ctx, "GET /orders")
+		defer span.End()
+		c.SetUserContext(ctx)
+		c.Locals("logger", zap.New(core).With(zap.String("request_id", "r-1")))
+		return c.Next()
+	})
+	app.Get("/orders", func(c *fiber.Ctx) error {
+		d := Detach(c)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-released // after the request and its context are done
+			_, span := d.Start(tracer, "send-receipt")
+			span.End()
+			if err := d.Context().Err(); err != nil {
+				t.Errorf("expected the detached context to outlive the request, got %v", err)
+			}
+			d.Logger().Info("receipt sent", zap.String("path", d.Path()))
+		}()
+		return c.SendStatus(fiber.StatusAccepted)
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/orders", nil)); err != nil {
+		t.Fatal(err)
+	}
+	close(released)
+	wg.Wait()
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["request_id"] != "r-1" || fields["trace_id"] == "" || fields["path"] != "/orders" {
+		t.Errorf("unexpected log fields %v", fields)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	var request, receipt sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if s.Name() == "send-receipt" {
+			receipt = s
+		} else {
+			request = s
+		}
+	}
+	if receipt == nil || receipt.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("expected the receipt span to be a child of the request span")
+	}
+}
+
+func TestContextDebugDetectsMisuse(t *testing.T) {
+	SetContextDebug(true)
+	defer SetContextDebug(false)
+
+	var mu sync.Mutex
+	var ops []string
+	OnContextMisuse(func(op string, stack []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		ops = append(ops, op)
+	})
+	defer OnContextMisuse(nil)
+
+	app := fiber.New()
+	app.Use(LoggerMiddleware(zap.NewNop()))
+	app.Get("/", func(c *fiber.Ctx) error {
+		GetLogger(c) // on the handler goroutine
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			GetLogger(c)
+		}()
+		<-done
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "r-2")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ops) != 1 || ops[0] != "GetLogger" {
+		t.Errorf("expected one misuse from the goroutine, got %v", ops)
+	}
+}
@@ -21,12 +21,9 @@
 //	// Initialize tracer
 //	ctx := context.Background()
 //	config := instrumentation.TracerConfig{
+//	    Preset:         "production", // 10% sampling, OTLP to otel-collector:4317
 //	    ServiceName:    "apm",
 //	    ServiceVersion: "1.0.0",
-//	    Environment:    "production",
-//	    ExporterType:   "otlp",
-//	    Endpoint:       "localhost:4317",
-//	    SampleRate:     0.1, // Sample 10% of traces
 //	}
 //
 //	tracerProvider, cleanup, err := instrumentation.InitTracer(ctx, config)
@@ -0,0 +1,418 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// DrainConfig configures a DrainTracker
+type DrainConfig struct {
+	// ReportInterval is how often the remaining requests are logged while
+	// draining (default 1s)
+	ReportInterval time.Duration
+
+	// Skip exempts requests from tracking, such as health probes
+	Skip func(*fiber.Ctx) bool
+
+	Logger *zap.Logger
+}
+
+// DrainSummary describes how a shutdown drained the in-flight requests
+type DrainSummary struct {
+	// Duration is the time from the start of the drain until the last
+	// request completed or the deadline passed
+	Duration time.Duration `json:"duration"`
+	// InFlight is the number of requests in flight when the drain started
+	InFlight int `json:"in_flight"`
+	// Completed is the number of requests that completed during the drain
+	Completed int `json:"completed"`
+	// Terminated counts the requests still in flight at the deadline by
+	// route, which the process exit cuts off
+	Terminated map[string]int `json:"terminated,omitempty"`
+	// Longest is the age of the oldest request that completed or was
+	// terminated during the drain
+	Longest time.Duration `json:"longest"`
+	// Headroom is the time left before the deadline when the drain
+	// finished; zero when requests were terminated or there was no deadline
+	Headroom time.Duration `json:"headroom"`
+}
+
+// TerminatedCount returns the number of requests terminated
+func (s DrainSummary) TerminatedCount() int {
+	n := 0
+	for _, count := range s.Terminated {
+		n += count
+	}
+	return n
+}
+
+// DrainTracker tracks the in-flight requests of a Fiber app by route and
+// reports how they drain during a graceful shutdown: the remaining requests
+// while it drains, how long it took, and which requests were cut off at the
+// deadline. Use it to size the Kubernetes terminationGracePeriodSeconds and
+// the shutdown timeout from data.
+//
+//	drain := instrumentation.NewDrainTracker(instrumentation.DrainConfig{})
+//	drain.Register(nil)
+//	app.Use(drain.Middleware())
+//	...
+//	<-quit
+//	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
+//	defer cancel()
+//	summary, err := drain.Shutdown(ctx, app)
+type DrainTracker struct {
+	config DrainConfig
+	now    func() time.Time
+
+	routesOnce sync.Once
+	routes     []routePattern
+
+	mu         sync.Mutex
+	nextID     uint64
+	requests   map[uint64]drainRequest
+	draining   bool
+	drainStart time.Time
+	atStart    int
+	completed  int
+	longest    time.Duration
+	drained    chan struct{} // closed once draining with no requests left
+	done       chan struct{} // closed when Finish returns
+	summary    *DrainSummary
+
+	inflight      *prometheus.GaugeVec
+	drainingGauge prometheus.Gauge
+	drainDuration prometheus.Gauge
+	drainedTotal  *prometheus.CounterVec
+	terminated    *prometheus.CounterVec
+}
+
+type drainRequest struct {
+	method string
+	route  string
+	start  time.Time
+}
+
+// NewDrainTracker creates a drain tracker
+func NewDrainTracker(config DrainConfig) *DrainTracker {
+	if config.ReportInterval <= 0 {
+		config.ReportInterval = time.Second
+	}
+	if config.Logger == nil {
+		config.Logger = zap.L()
+	}
+
+	return &DrainTracker{
+		config:   config,
+		now:      time.Now,
+		requests: make(map[uint64]drainRequest),
+		inflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "apm_inflight_requests",
+			Help: "Requests being handled by route",
+		}, []string{"method", "route"}),
+		drainingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apm_shutdown_draining",
+			Help: "Whether the service is draining requests for shutdown (1) or not (0)",
+		}),
+		drainDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apm_shutdown_drain_duration_seconds",
+			Help: "Time spent draining in-flight requests, updated while the drain runs",
+		}),
+		drainedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apm_shutdown_drained_requests_total",
+			Help: "Requests that completed while draining for shutdown by route",
+		}, []string{"method", "route"}),
+		terminated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apm_shutdown_terminated_requests_total",
+			Help: "Requests still in flight when the shutdown deadline passed by route",
+		}, []string{"method", "route"}),
+	}
+}
+
+// Collectors returns the Prometheus collectors exported by the tracker
+func (d *DrainTracker) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{d.inflight, d.drainingGauge, d.drainDuration, d.drainedTotal, d.terminated}
+}
+
+// Register registers the tracker's collectors with the given registerer
+func (d *DrainTracker) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range d.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register drain collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Middleware returns a Fiber middleware that tracks each request until it
+// completes
+func (d *DrainTracker) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if d.config.Skip != nil && d.config.Skip(c) {
+			return c.Next()
+		}
+		id := d.begin(c.Method(), d.route(c))
+		defer d.end(id)
+		return c.Next()
+	}
+}
+
+func (d *DrainTracker) begin(method, route string) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	d.requests[d.nextID] = drainRequest{method: method, route: route, start: d.now()}
+	d.inflight.WithLabelValues(method, route).Inc()
+	return d.nextID
+}
+
+func (d *DrainTracker) end(id uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	req, ok := d.requests[id]
+	if !ok {
+		// Counted as terminated at the deadline
+		return
+	}
+	delete(d.requests, id)
+	d.inflight.WithLabelValues(req.method, req.route).Dec()
+
+	if d.draining && d.summary == nil {
+		d.completed++
+		d.drainedTotal.WithLabelValues(req.method, req.route).Inc()
+		if age := d.now().Sub(req.start); age > d.longest {
+			d.longest = age
+		}
+		if len(d.requests) == 0 {
+			d.closeDrained()
+		}
+	}
+}
+
+// closeDrained signals that no requests are left; requests accepted while
+// the listener closes may empty the map more than once
+func (d *DrainTracker) closeDrained() {
+	select {
+	case <-d.drained:
+	default:
+		close(d.drained)
+	}
+}
+
+// Start marks the beginning of the drain, logging the requests in flight
+// and then the remaining ones every ReportInterval until Finish. Call it
+// when the shutdown signal arrives, before shutting the server down.
+func (d *DrainTracker) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return
+	}
+	d.draining = true
+	d.drainStart = d.now()
+	d.atStart = len(d.requests)
+	d.drained = make(chan struct{})
+	d.done = make(chan struct{})
+	if len(d.requests) == 0 {
+		d.closeDrained()
+	}
+	d.drainingGauge.Set(1)
+
+	d.config.Logger.Info("draining in-flight requests",
+		zap.Int("in_flight", d.atStart),
+		zap.Any("routes", d.countByRoute()))
+	go d.report(d.done)
+}
+
+// report logs the remaining requests until the drain finishes
+func (d *DrainTracker) report(done chan struct{}) {
+	ticker := time.NewTicker(d.config.ReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		if d.summary != nil {
+			d.mu.Unlock()
+			return
+		}
+		elapsed := d.now().Sub(d.drainStart)
+		d.drainDuration.Set(elapsed.Seconds())
+		if len(d.requests) > 0 {
+			d.config.Logger.Info("waiting for in-flight requests",
+				zap.Int("remaining", len(d.requests)),
+				zap.Duration("elapsed", elapsed),
+				zap.Duration("oldest", d.oldest()),
+				zap.Any("routes", d.countByRoute()))
+		}
+		d.mu.Unlock()
+	}
+}
+
+// Finish waits until the in-flight requests complete or ctx is done, counts
+// the requests left as terminated, and logs and returns the summary. It
+// starts the drain if Start was not called.
+func (d *DrainTracker) Finish(ctx context.Context) DrainSummary {
+	d.Start()
+	d.mu.Lock()
+	drained := d.drained
+	d.mu.Unlock()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.summary != nil {
+		return *d.summary
+	}
+
+	now := d.now()
+	summary := DrainSummary{
+		Duration:  now.Sub(d.drainStart),
+		InFlight:  d.atStart,
+		Completed: d.completed,
+		Longest:   d.longest,
+	}
+	if len(d.requests) > 0 {
+		summary.Terminated = d.countByRoute()
+		if oldest := d.oldest(); oldest > summary.Longest {
+			summary.Longest = oldest
+		}
+		for id, req := range d.requests {
+			d.terminated.WithLabelValues(req.method, req.route).Inc()
+			d.inflight.WithLabelValues(req.method, req.route).Dec()
+			delete(d.requests, id)
+		}
+	} else if deadline, ok := ctx.Deadline(); ok && deadline.After(now) {
+		summary.Headroom = deadline.Sub(now)
+	}
+	d.summary = &summary
+	d.drainDuration.Set(summary.Duration.Seconds())
+	d.drainingGauge.Set(0)
+	close(d.done)
+
+	fields := []zap.Field{
+		zap.Duration("drain_duration", summary.Duration),
+		zap.Int("in_flight", summary.InFlight),
+		zap.Int("completed", summary.Completed),
+		zap.Duration("longest", summary.Longest),
+	}
+	if n := summary.TerminatedCount(); n > 0 {
+		fields = append(fields, zap.Int("terminated", n), zap.Any("terminated_routes", summary.Terminated))
+		d.config.Logger.Warn("shutdown deadline passed with requests in flight", fields...)
+	} else {
+		fields = append(fields, zap.Duration("headroom", summary.Headroom))
+		d.config.Logger.Info("drained in-flight requests", fields...)
+	}
+	return summary
+}
+
+// Shutdown drains app: it starts the drain, shuts the app down within ctx,
+// and returns the summary along with the error of the shutdown
+func (d *DrainTracker) Shutdown(ctx context.Context, app *fiber.App) (DrainSummary, error) {
+	d.Start()
+	err := app.ShutdownWithContext(ctx)
+	return d.Finish(ctx), err
+}
+
+// countByRoute returns the requests in flight by "METHOD route"; the caller
+// holds the lock
+func (d *DrainTracker) countByRoute() map[string]int {
+	counts := make(map[string]int)
+	for _, req := range d.requests {
+		counts[req.method+" "+req.route]++
+	}
+	return counts
+}
+
+// oldest returns the age of the oldest request in flight; the caller holds
+// the lock
+func (d *DrainTracker) oldest() time.Duration {
+	var oldest time.Duration
+	now := d.now()
+	for _, req := range d.requests {
+		if age := now.Sub(req.start); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+// routePattern matches request paths to a registered route
+type routePattern struct {
+	method  string
+	path    string
+	pattern *regexp.Regexp
+}
+
+// route returns the registered route a request will be handled by. A
+// middleware only sees the matched route after the handler ran, so the
+// routes of the app are matched up front; paths that match none are
+// "unmatched", keeping the label bounded.
+func (d *DrainTracker) route(c *fiber.Ctx) string {
+	d.routesOnce.Do(func() { d.routes = compileRoutes(c.App().GetRoutes(true)) })
+	method, path := c.Method(), c.Path()
+	for _, r := range d.routes {
+		if r.method == method && r.pattern.MatchString(path) {
+			return r.path
+		}
+	}
+	return "unmatched"
+}
+
+// compileRoutes turns Fiber route paths into patterns, in the order Fiber
+// matches them
+func compileRoutes(routes []fiber.Route) []routePattern {
+	patterns := make([]routePattern, 0, len(routes))
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		if seen[r.Method+" "+r.Path] {
+			continue
+		}
+		seen[r.Method+" "+r.Path] = true
+
+		var expr strings.Builder
+		expr.WriteString("^")
+		for i, segment := range strings.Split(strings.TrimPrefix(r.Path, "/"), "/") {
+			optional := strings.HasPrefix(segment, ":") && strings.HasSuffix(segment, "?")
+			switch {
+			case optional && i == 0:
+				expr.WriteString("(?:/[^/]*)?")
+			case optional:
+				expr.WriteString("(?:/[^/]+)?")
+			case strings.HasPrefix(segment, ":"):
+				expr.WriteString("/[^/]+")
+			case segment == "*":
+				expr.WriteString("(?:/.*)?")
+			case segment == "+":
+				expr.WriteString("/.+")
+			default:
+				expr.WriteString("/" + regexp.QuoteMeta(segment))
+			}
+		}
+		expr.WriteString("/?$")
+		pattern, err := regexp.Compile("(?i)" + expr.String())
+		if err != nil {
+			continue
+		}
+		patterns = append(patterns, routePattern{method: r.Method, path: r.Path, pattern: pattern})
+	}
+	return patterns
+}
@@ -0,0 +1,135 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDrainTracker(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	d := NewDrainTracker(DrainConfig{ReportInterval: 10 * time.Millisecond, Logger: zap.New(core)})
+
+	started := make(chan struct{}, 2)
+	releaseFast, releaseSlow := make(chan struct{}), make(chan struct{})
+	defer close(releaseSlow)
+
+	app := fiber.New()
+	app.Use(d.Middleware())
+	app.Get("/orders/:id", func(c *fiber.Ctx) error {
+		started <- struct{}{}
+		<-releaseFast
+		return c.SendString("order")
+	})
+	app.Post("/reports/*", func(c *fiber.Ctx) error {
+		started <- struct{}{}
+		<-releaseSlow
+		return c.SendString("report")
+	})
+
+	go app.Test(httptest.NewRequest("GET", "/orders/42", nil), -1)
+	go app.Test(httptest.NewRequest("POST", "/reports/daily/2024", nil), -1)
+	<-started
+	<-started
+
+	if got := testutil.ToFloat64(d.inflight.WithLabelValues("GET", "/orders/:id")); got != 1 {
+		t.Errorf("in-flight GET /orders/:id = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(d.inflight.WithLabelValues("POST", "/reports/*")); got != 1 {
+		t.Errorf("in-flight POST /reports/* = %g, want 1", got)
+	}
+
+	d.Start()
+	if got := testutil.ToFloat64(d.drainingGauge); got != 1 {
+		t.Errorf("draining = %g during the drain", got)
+	}
+	close(releaseFast)
+
+	// The report request outlives the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	summary := d.Finish(ctx)
+
+	if summary.InFlight != 2 || summary.Completed != 1 {
+		t.Errorf("in flight %d, completed %d; want 2 and 1", summary.InFlight, summary.Completed)
+	}
+	if summary.TerminatedCount() != 1 || summary.Terminated["POST /reports/*"] != 1 {
+		t.Errorf("terminated = %v", summary.Terminated)
+	}
+	if summary.Duration < 100*time.Millisecond || summary.Longest < summary.Duration || summary.Headroom != 0 {
+		t.Errorf("duration %s, longest %s, headroom %s", summary.Duration, summary.Longest, summary.Headroom)
+	}
+	if got := testutil.ToFloat64(d.terminated.WithLabelValues("POST", "/reports/*")); got != 1 {
+		t.Errorf("terminated POST /reports/* = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(d.drainedTotal.WithLabelValues("GET", "/orders/:id")); got != 1 {
+		t.Errorf("drained GET /orders/:id = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(d.inflight.WithLabelValues("POST", "/reports/*")); got != 0 {
+		t.Errorf("terminated request still counted in flight: %g", got)
+	}
+	if got := testutil.ToFloat64(d.drainingGauge); got != 0 {
+		t.Errorf("draining = %g after the drain", got)
+	}
+
+	if logs.FilterMessage("waiting for in-flight requests").Len() == 0 {
+		t.Error("no progress logged while draining")
+	}
+	warnings := logs.FilterMessage("shutdown deadline passed with requests in flight").All()
+	if len(warnings) != 1 || warnings[0].ContextMap()["terminated"] != int64(1) {
+		t.Errorf("summary log = %+v", warnings)
+	}
+}
+
+func TestDrainTrackerDrains(t *testing.T) {
+	d := NewDrainTracker(DrainConfig{Logger: zap.NewNop()})
+	app := fiber.New()
+	app.Use(d.Middleware())
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	if _, err := app.Test(httptest.NewRequest("GET", "/nowhere", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(d.inflight); n != 1 {
+		t.Errorf("%d in-flight series, want the unmatched one", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	summary := d.Finish(ctx)
+	if summary.TerminatedCount() != 0 || summary.Headroom <= 0 {
+		t.Errorf("idle drain summary = %+v", summary)
+	}
+}
+
+func TestCompileRoutes(t *testing.T) {
+	routes := compileRoutes([]fiber.Route{
+		{Method: "GET", Path: "/"},
+		{Method: "GET", Path: "/users/:id/orders/:order?"},
+		{Method: "GET", Path: "/static/*"},
+	})
+	for path, want := range map[string]string{
+		"/":                    "/",
+		"/users/7/orders":      "/users/:id/orders/:order?",
+		"/users/7/orders/9":    "/users/:id/orders/:order?",
+		"/static/css/site.css": "/static/*",
+		"/users":               "",
+	} {
+		got := ""
+		for _, r := range routes {
+			if r.pattern.MatchString(path) {
+				got = r.path
+				break
+			}
+		}
+		if got != want {
+			t.Errorf("%s matched %q, want %q", path, got, want)
+		}
+	}
+}
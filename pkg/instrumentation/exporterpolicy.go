@@ -0,0 +1,282 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Exporter policies: what New does when a telemetry backend is unreachable
+// at startup
+const (
+	// ExporterPolicyFailClosed fails New, so a canary with broken telemetry
+	// never takes traffic
+	ExporterPolicyFailClosed = "fail-closed"
+	// ExporterPolicyFailOpen starts with the exporters enabled, warns, and
+	// reports degraded readiness until the backend is reachable (default)
+	ExporterPolicyFailOpen = "fail-open"
+	// ExporterPolicyDisable turns the exporters of unreachable backends off
+	// without a warning, for local runs without a collector
+	ExporterPolicyDisable = "disable"
+)
+
+// ErrExporterUnreachable is returned by New under the fail-closed policy
+var ErrExporterUnreachable = errors.New("telemetry backend unreachable")
+
+// DefaultExporterCheckTimeout bounds the startup check of each backend
+const DefaultExporterCheckTimeout = 2 * time.Second
+
+// exporterRecheckInterval bounds how often the health check probes the
+// backends that were unreachable
+const exporterRecheckInterval = 30 * time.Second
+
+// ExporterPolicyConfig configures the startup check of telemetry backends
+type ExporterPolicyConfig struct {
+	// Policy is ExporterPolicyFailClosed, ExporterPolicyFailOpen (default),
+	// or ExporterPolicyDisable
+	Policy string
+	// Timeout bounds the check of each backend (default 2s)
+	Timeout time.Duration
+}
+
+// ExporterCheck is the startup check of one telemetry backend
+type ExporterCheck struct {
+	// Exporter is "tracing", "push_gateway", or "push_otlp"
+	Exporter  string    `json:"exporter"`
+	Endpoint  string    `json:"endpoint"`
+	Reachable bool      `json:"reachable"`
+	Enabled   bool      `json:"enabled"`
+	Error     string    `json:"error,omitempty"`
+	Checked   time.Time `json:"checked"`
+
+	address string
+}
+
+// ExporterReport lists the checks of the telemetry backends under the
+// policy applied
+type ExporterReport struct {
+	Policy    string          `json:"policy"`
+	Exporters []ExporterCheck `json:"exporters"`
+}
+
+// ExporterStatus holds the outcome of the startup check, served by the
+// admin API at /admin/exporters
+type ExporterStatus struct {
+	mu          sync.Mutex
+	policy      string
+	exporters   []ExporterCheck
+	lastRecheck time.Time
+	timeout     time.Duration
+	dial        func(ctx context.Context, address string) error
+}
+
+// exporterTarget is a backend an exporter sends to
+type exporterTarget struct {
+	exporter string
+	endpoint string
+	// address is the host:port dialed
+	address string
+	// disable turns the exporter off in the config
+	disable func(*Config)
+}
+
+// exporterTargets returns the backends of the configured exporters
+func exporterTargets(cfg *Config) []exporterTarget {
+	var targets []exporterTarget
+	if cfg.Tracing != nil {
+		tracing := *cfg.Tracing
+		// The preset may supply the endpoint; an unknown preset fails later
+		_ = tracing.applyPreset()
+		if tracing.Shards == nil && tracing.Endpoint != "" && tracing.ExporterType != "" {
+			defaultPort := "4317"
+			if tracing.Protocol == ProtocolHTTPProtobuf {
+				defaultPort = "4318"
+			}
+			targets = append(targets, exporterTarget{
+				exporter: "tracing",
+				endpoint: tracing.Endpoint,
+				address:  dialAddress(tracing.Endpoint, defaultPort),
+				disable:  func(c *Config) { c.Tracing = nil },
+			})
+		}
+	}
+	if cfg.Push.Gateway != "" {
+		targets = append(targets, exporterTarget{
+			exporter: "push_gateway",
+			endpoint: cfg.Push.Gateway,
+			address:  dialAddress(cfg.Push.Gateway, "9091"),
+			disable:  func(c *Config) { c.Push.Gateway = "" },
+		})
+	}
+	if cfg.Push.OTLPEndpoint != "" {
+		targets = append(targets, exporterTarget{
+			exporter: "push_otlp",
+			endpoint: cfg.Push.OTLPEndpoint,
+			address:  dialAddress(cfg.Push.OTLPEndpoint, "4318"),
+			disable:  func(c *Config) { c.Push.OTLPEndpoint = "" },
+		})
+	}
+	return targets
+}
+
+// dialAddress returns the host:port of an endpoint given as a URL or as
+// host[:port]
+func dialAddress(endpoint, defaultPort string) string {
+	host := endpoint
+	if strings.Contains(endpoint, "://") {
+		if u, err := url.Parse(endpoint); err == nil {
+			host = u.Host
+			// A URL without a port uses its scheme's
+			switch u.Scheme {
+			case "https":
+				defaultPort = "443"
+			case "http":
+				defaultPort = "80"
+			}
+		}
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+}
+
+// dialTCP checks that a TCP connection to address can be opened
+func dialTCP(ctx context.Context, address string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkExporters checks that the backend of each configured exporter is
+// reachable and applies the policy: it returns ErrExporterUnreachable under
+// fail-closed, warns under fail-open, and removes the exporter from cfg
+// under disable
+func checkExporters(cfg *Config, logger *zap.Logger, dial func(context.Context, string) error) (*ExporterStatus, error) {
+	policy := cfg.ExporterPolicy.Policy
+	if policy == "" {
+		policy = ExporterPolicyFailOpen
+	}
+	timeout := cfg.ExporterPolicy.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExporterCheckTimeout
+	}
+	status := &ExporterStatus{policy: policy, exporters: []ExporterCheck{}, timeout: timeout, dial: dial}
+
+	targets := exporterTargets(cfg)
+	checks := make([]ExporterCheck, len(targets))
+	var wg sync.WaitGroup
+	for n, target := range targets {
+		wg.Add(1)
+		go func(n int, target exporterTarget) {
+			defer wg.Done()
+			checks[n] = status.check(target.exporter, target.endpoint, target.address)
+		}(n, target)
+	}
+	wg.Wait()
+
+	var unreachable []error
+	for n, check := range checks {
+		target := targets[n]
+		if check.Reachable {
+			status.exporters = append(status.exporters, check)
+			continue
+		}
+		fields := []zap.Field{
+			zap.String("exporter", check.Exporter),
+			zap.String("endpoint", check.Endpoint),
+			zap.String("error", check.Error),
+			zap.String("policy", policy),
+		}
+		switch policy {
+		case ExporterPolicyFailClosed:
+			unreachable = append(unreachable, fmt.Errorf("%s at %s: %s", check.Exporter, check.Endpoint, check.Error))
+		case ExporterPolicyDisable:
+			check.Enabled = false
+			target.disable(cfg)
+			logger.Debug("telemetry backend unreachable, exporter disabled", fields...)
+		default:
+			logger.Warn("telemetry backend unreachable, starting degraded", fields...)
+		}
+		status.exporters = append(status.exporters, check)
+	}
+	if len(unreachable) > 0 {
+		return status, fmt.Errorf("%w under the fail-closed policy (APM_EXPORTER_POLICY): %w", ErrExporterUnreachable, errors.Join(unreachable...))
+	}
+	return status, nil
+}
+
+// check dials the backend of one exporter
+func (s *ExporterStatus) check(exporter, endpoint, address string) ExporterCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	check := ExporterCheck{Exporter: exporter, Endpoint: endpoint, Reachable: true, Enabled: true, Checked: time.Now().UTC(), address: address}
+	if err := s.dial(ctx, address); err != nil {
+		check.Reachable = false
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// Report returns the policy and the latest checks
+func (s *ExporterStatus) Report() ExporterReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ExporterReport{Policy: s.policy, Exporters: append([]ExporterCheck(nil), s.exporters...)}
+}
+
+// HealthCheck reports degraded while an enabled exporter's backend is
+// unreachable, probing those backends again at most every 30 seconds.
+// Exporters disabled at startup stay off and are listed in the details.
+func (s *ExporterStatus) HealthCheck() HealthCheckFunc {
+	return func(context.Context) HealthCheck {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if time.Since(s.lastRecheck) >= exporterRecheckInterval {
+			s.lastRecheck = time.Now()
+			for n, check := range s.exporters {
+				if check.Enabled && !check.Reachable {
+					s.exporters[n] = s.check(check.Exporter, check.Endpoint, check.address)
+				}
+			}
+		}
+
+		var unreachable, disabled []string
+		for _, check := range s.exporters {
+			switch {
+			case !check.Enabled:
+				disabled = append(disabled, check.Exporter)
+			case !check.Reachable:
+				unreachable = append(unreachable, check.Exporter+" ("+check.Endpoint+")")
+			}
+		}
+		health := HealthCheck{
+			Status:      HealthStatusHealthy,
+			Message:     "telemetry backends reachable",
+			LastChecked: time.Now().UTC(),
+			Details: map[string]interface{}{
+				"policy":    s.policy,
+				"exporters": append([]ExporterCheck(nil), s.exporters...),
+			},
+		}
+		if len(disabled) > 0 {
+			health.Message = "exporters disabled at startup: " + strings.Join(disabled, ", ")
+		}
+		if len(unreachable) > 0 {
+			health.Status = HealthStatusDegraded
+			health.Message = "telemetry backends unreachable: " + strings.Join(unreachable, ", ")
+		}
+		return health
+	}
+}
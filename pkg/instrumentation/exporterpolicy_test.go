@@ -0,0 +1,144 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeDialer reaches the addresses in up
+type fakeDialer struct {
+	up map[string]bool
+}
+
+func (d *fakeDialer) dial(_ context.Context, address string) error {
+	if d.up[address] {
+		return nil
+	}
+	return errors.New("connection refused")
+}
+
+func exporterPolicyConfig(policy string) *Config {
+	cfg := DefaultConfig()
+	cfg.ExporterPolicy.Policy = policy
+	cfg.Tracing = &TracerConfig{ExporterType: "otlp", Endpoint: "collector:4317"}
+	cfg.Push = PushConfig{Gateway: "http://pushgateway:9091", OTLPEndpoint: "https://otlp.example.com/v1/metrics"}
+	return cfg
+}
+
+func TestCheckExporters(t *testing.T) {
+	dialer := &fakeDialer{up: map[string]bool{"pushgateway:9091": true}}
+
+	t.Run("fail-closed", func(t *testing.T) {
+		cfg := exporterPolicyConfig(ExporterPolicyFailClosed)
+		_, err := checkExporters(cfg, zap.NewNop(), dialer.dial)
+		if !errors.Is(err, ErrExporterUnreachable) {
+			t.Fatalf("err = %v, want ErrExporterUnreachable", err)
+		}
+		for _, want := range []string{"tracing at collector:4317", "push_otlp", "APM_EXPORTER_POLICY"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not mention %s", err, want)
+			}
+		}
+	})
+
+	t.Run("fail-open", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		cfg := exporterPolicyConfig(ExporterPolicyFailOpen)
+		status, err := checkExporters(cfg, zap.New(core), dialer.dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Tracing == nil || cfg.Push.OTLPEndpoint == "" {
+			t.Error("fail-open removed an exporter")
+		}
+		if n := logs.FilterMessage("telemetry backend unreachable, starting degraded").Len(); n != 2 {
+			t.Errorf("%d warnings, want one per unreachable backend", n)
+		}
+
+		health := status.HealthCheck()(context.Background())
+		if health.Status != HealthStatusDegraded || !strings.Contains(health.Message, "tracing (collector:4317)") {
+			t.Errorf("health = %s: %s", health.Status, health.Message)
+		}
+
+		// The backends come up; the next probe clears the degradation
+		dialer.up["collector:4317"], dialer.up["otlp.example.com:443"] = true, true
+		defer func() { delete(dialer.up, "collector:4317"); delete(dialer.up, "otlp.example.com:443") }()
+		status.lastRecheck = status.lastRecheck.Add(-exporterRecheckInterval)
+		if health := status.HealthCheck()(context.Background()); health.Status != HealthStatusHealthy {
+			t.Errorf("health = %s after recovery: %s", health.Status, health.Message)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		cfg := exporterPolicyConfig(ExporterPolicyDisable)
+		status, err := checkExporters(cfg, zap.New(core), dialer.dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Tracing != nil || cfg.Push.OTLPEndpoint != "" || cfg.Push.Gateway == "" {
+			t.Errorf("tracing %v, push %+v: want only the unreachable exporters removed", cfg.Tracing, cfg.Push)
+		}
+		if logs.Len() != 0 {
+			t.Errorf("disable logged %d entries at info or above", logs.Len())
+		}
+
+		report := status.Report()
+		if report.Policy != ExporterPolicyDisable || len(report.Exporters) != 3 {
+			t.Fatalf("report = %+v", report)
+		}
+		health := status.HealthCheck()(context.Background())
+		if health.Status != HealthStatusHealthy || !strings.Contains(health.Message, "disabled at startup: tracing, push_otlp") {
+			t.Errorf("health = %s: %s", health.Status, health.Message)
+		}
+	})
+}
+
+func TestDialAddress(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"collector:4317":                 "collector:4317",
+		"collector":                      "collector:4317",
+		"http://jaeger:14268/api/traces": "jaeger:14268",
+		"https://otlp.example.com":       "otlp.example.com:443",
+		"http://pushgateway":             "pushgateway:80",
+		"[::1]:4317":                     "[::1]:4317",
+	} {
+		if got := dialAddress(endpoint, "4317"); got != want {
+			t.Errorf("dialAddress(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
+
+func TestExporterPolicyPresets(t *testing.T) {
+	t.Setenv("APM_EXPORTER_POLICY", "")
+	for preset, want := range map[string]string{
+		PresetLocal:      ExporterPolicyDisable,
+		PresetStaging:    ExporterPolicyFailOpen,
+		PresetProduction: ExporterPolicyFailOpen,
+	} {
+		cfg, err := PresetConfig(preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ExporterPolicy.Policy != want {
+			t.Errorf("%s preset policy = %q, want %q", preset, cfg.ExporterPolicy.Policy, want)
+		}
+	}
+
+	t.Setenv("APM_EXPORTER_POLICY", "Fail-Closed")
+	if cfg, _ := PresetConfig(PresetProduction); cfg.ExporterPolicy.Policy != ExporterPolicyFailClosed {
+		t.Errorf("APM_EXPORTER_POLICY did not override the preset: %q", cfg.ExporterPolicy.Policy)
+	}
+
+	cfg := DefaultConfig()
+	cfg.ExporterPolicy.Policy = "ignore"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "APM_EXPORTER_POLICY") {
+		t.Errorf("invalid policy: err = %v", err)
+	}
+}
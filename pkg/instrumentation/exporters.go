@@ -2,17 +2,26 @@ package instrumentation
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"time"
 
+	"github.com/chaksack/apm/pkg/netaddr"
+	"github.com/chaksack/apm/pkg/proxy"
+	"github.com/chaksack/apm/pkg/residency"
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
 	"go.opentelemetry.io/otel/exporters/jaeger"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // ExporterConfig holds configuration for exporters
@@ -21,10 +30,37 @@ type ExporterConfig struct {
 	Endpoint string            // Endpoint for the exporter
 	Headers  map[string]string // Headers for OTLP exporters
 	Insecure bool              // Use insecure connection
+	URLPath  string            // OTLP HTTP path; empty uses /v1/traces
+	Timeout  time.Duration     // OTLP export timeout; zero uses the SDK default
+	// Compression is "gzip", "zstd", or "none" (the default) for OTLP
+	Compression string
+	// MaxPayloadBytes splits OTLP export batches whose estimated size
+	// exceeds it; zero leaves batches whole
+	MaxPayloadBytes int
+	// CompressionStats counts OTLP export bytes before and after
+	// compression. Nil disables it.
+	CompressionStats *CompressionStats
+	// Proxy overrides HTTP_PROXY/NO_PROXY, enables SOCKS5 or a custom dialer,
+	// and adds CA certificates for TLS intercepting proxies. Nil uses the environment.
+	Proxy *proxy.Config
+	// TLSPolicy restricts TLS versions and ciphers. Nil uses tlspolicy.DefaultPolicy,
+	// which enables FIPS mode from the build tag or APM_FIPS_MODE.
+	TLSPolicy *tlspolicy.Policy
+	// Region is the region the backend stores data in. When empty it is
+	// inferred from the endpoint host name for residency checks.
+	Region string
+	// Residency rejects backends outside the allowed regions. Nil disables the check.
+	Residency *residency.Guard
+	// Semconv translates exported attributes to a semantic convention
+	// version; each exporter of a multi-exporter may follow its own
+	Semconv SemconvConfig
 	// For stdout exporter
 	Writer io.Writer
 	// For multi-exporter
 	Exporters []ExporterConfig
+	// Shards spreads spans over several agents by trace ID; each agent gets
+	// an exporter of this configuration with its endpoint. Nil disables it.
+	Shards *ShardConfig
 	// Batch processor configuration
 	BatchTimeout   int // Milliseconds
 	MaxExportBatch int
@@ -42,42 +78,227 @@ func DefaultBatchProcessorOptions() []trace.BatchSpanProcessorOption {
 
 // CreateExporter creates a span exporter based on the configuration
 func CreateExporter(ctx context.Context, config ExporterConfig) (trace.SpanExporter, error) {
+	if config.Endpoint != "" {
+		if err := validateExporterEndpoint(config.Type, config.Endpoint); err != nil {
+			return nil, fmt.Errorf("exporter %s: %w", config.Type, err)
+		}
+	}
+	if err := validateCompression(config.Compression); err != nil {
+		return nil, fmt.Errorf("exporter %s: %w", config.Type, err)
+	}
+	if err := config.checkResidency(); err != nil {
+		return nil, err
+	}
+
+	if config.Shards != nil {
+		shards := *config.Shards
+		config.Shards = nil
+		return NewShardedExporter(ctx, shards, func(ctx context.Context, endpoint string) (trace.SpanExporter, error) {
+			shard := config
+			shard.Endpoint = endpoint
+			return CreateExporter(ctx, shard)
+		})
+	}
+
+	var exporter trace.SpanExporter
+	var err error
 	switch config.Type {
 	case "otlp-grpc":
-		return createOTLPGRPCExporter(ctx, config)
+		exporter, err = createOTLPGRPCExporter(ctx, config)
 	case "otlp-http":
-		return createOTLPHTTPExporter(ctx, config)
+		exporter, err = createOTLPHTTPExporter(ctx, config)
 	case "jaeger":
-		return createJaegerExporterFromConfig(config)
+		exporter, err = createJaegerExporterFromConfig(config)
 	case "stdout":
-		return createStdoutExporter(config)
+		exporter, err = createStdoutExporter(config)
 	case "multi":
-		return createMultiExporter(ctx, config)
+		exporter, err = createMultiExporter(ctx, config)
 	default:
 		return nil, fmt.Errorf("unknown exporter type: %s", config.Type)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSemconvExporter(exporter, config.Semconv)
+}
+
+// validateExporterEndpoint checks the endpoint of an exporter type: OTLP
+// exporters take host[:port], with IPv6 addresses in brackets, and Jaeger a
+// collector URL
+func validateExporterEndpoint(exporterType, endpoint string) error {
+	switch exporterType {
+	case "otlp", "otlp-grpc", "otlp-http":
+		return netaddr.ValidateAddress(endpoint)
+	case "jaeger":
+		return netaddr.ValidateURL(endpoint)
+	}
+	return nil
+}
+
+// tlsPolicy returns the effective TLS policy for the exporter
+func (c ExporterConfig) tlsPolicy() tlspolicy.Policy {
+	if c.TLSPolicy != nil {
+		return c.TLSPolicy.Effective()
+	}
+	if c.Proxy != nil && c.Proxy.TLS != nil {
+		return c.Proxy.TLS.Effective()
+	}
+	return tlspolicy.DefaultPolicy()
+}
+
+// proxyConfig returns the proxy settings carrying the exporter TLS policy
+func (c ExporterConfig) proxyConfig() *proxy.Config {
+	if c.Proxy == nil && c.TLSPolicy == nil {
+		return nil
+	}
+
+	cfg := &proxy.Config{}
+	if c.Proxy != nil {
+		copied := *c.Proxy
+		cfg = &copied
+	}
+	if c.TLSPolicy != nil {
+		cfg.TLS = c.TLSPolicy
+	}
+	return cfg
+}
+
+// tlsConfig builds the client TLS configuration for the exporter
+func (c ExporterConfig) tlsConfig() (*tls.Config, error) {
+	return c.proxyConfig().TLSConfig(nil)
+}
+
+// checkResidency rejects exporters whose backend region is not allowed
+func (c ExporterConfig) checkResidency() error {
+	if c.Residency == nil || c.Type == "stdout" || c.Type == "multi" {
+		return nil
+	}
+	component := "exporter." + c.Type
+	if c.Region != "" {
+		return c.Residency.Check(component, "", c.Region)
+	}
+	return c.Residency.CheckEndpoint(component, "", c.Endpoint)
+}
+
+// checkTransportSecurity rejects plaintext exporters when FIPS mode is active
+func (c ExporterConfig) checkTransportSecurity() error {
+	if c.Insecure && c.tlsPolicy().FIPSMode {
+		return fmt.Errorf("exporter %s: insecure connection to %s not allowed in FIPS mode", c.Type, c.Endpoint)
+	}
+	return nil
+}
+
+// CheckCompliance records exporter settings that violate the TLS policy
+func (c ExporterConfig) CheckCompliance(report *tlspolicy.Report) {
+	component := "exporter." + c.Type
+	policy := c.tlsPolicy()
+
+	switch c.Type {
+	case "multi":
+		for _, exp := range c.Exporters {
+			exp.CheckCompliance(report)
+		}
+	case "otlp-grpc", "otlp-http", "jaeger":
+		if c.Insecure {
+			policy.CheckPlaintext(report, component, c.Endpoint)
+			return
+		}
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			report.Add(tlspolicy.Finding{
+				Component:   component,
+				Setting:     "tls",
+				Value:       err.Error(),
+				Requirement: "valid TLS policy",
+				Severity:    tlspolicy.SeverityViolation,
+			})
+			return
+		}
+		policy.CheckTLSConfig(report, component, tlsConfig)
+	}
 }
 
 // createOTLPGRPCExporter creates an OTLP gRPC exporter
 func createOTLPGRPCExporter(ctx context.Context, config ExporterConfig) (trace.SpanExporter, error) {
+	if err := config.checkTransportSecurity(); err != nil {
+		return nil, err
+	}
+
 	opts := []otlptracegrpc.Option{
 		otlptracegrpc.WithEndpoint(config.Endpoint),
 	}
 
 	if config.Insecure {
 		opts = append(opts, otlptracegrpc.WithInsecure())
+	} else {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
 	}
 
 	if len(config.Headers) > 0 {
 		opts = append(opts, otlptracegrpc.WithHeaders(config.Headers))
 	}
+	if config.Timeout > 0 {
+		opts = append(opts, otlptracegrpc.WithTimeout(config.Timeout))
+	}
+
+	// WithDialOption replaces earlier dial options, so they are collected
+	// and passed once
+	var dialOpts []grpc.DialOption
+	switch config.Compression {
+	case CompressionGzip:
+		opts = append(opts, otlptracegrpc.WithCompressor(CompressionGzip))
+	case CompressionZstd:
+		// The SDK only knows gzip; zstd is set for every call instead
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(CompressionZstd)))
+	}
+	if config.CompressionStats != nil {
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(config.CompressionStats.grpcHandler(config.Compression)))
+	}
 
+	if config.Proxy != nil {
+		if err := config.Proxy.Validate(); err != nil {
+			return nil, err
+		}
+
+		// A custom dialer disables gRPC's own proxy handling, so the dialer
+		// resolves the environment proxies itself.
+		dial := config.Proxy.DialContext()
+		dialOpts = append(dialOpts, grpc.WithContextDialer(
+			func(ctx context.Context, addr string) (net.Conn, error) {
+				return dial(ctx, "tcp", addr)
+			},
+		))
+
+		if !config.Insecure && config.Proxy.CAFile != "" {
+			tlsConfig, err := config.Proxy.TLSConfig(nil)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+		}
+	}
+
+	if len(dialOpts) > 0 {
+		opts = append(opts, otlptracegrpc.WithDialOption(dialOpts...))
+	}
 	client := otlptracegrpc.NewClient(opts...)
-	return otlptrace.New(ctx, client)
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return limitPayload(exporter, config.MaxPayloadBytes), nil
 }
 
 // createOTLPHTTPExporter creates an OTLP HTTP exporter
 func createOTLPHTTPExporter(ctx context.Context, config ExporterConfig) (trace.SpanExporter, error) {
+	if err := config.checkTransportSecurity(); err != nil {
+		return nil, err
+	}
+
 	opts := []otlptracehttp.Option{
 		otlptracehttp.WithEndpoint(config.Endpoint),
 	}
@@ -89,16 +310,132 @@ func createOTLPHTTPExporter(ctx context.Context, config ExporterConfig) (trace.S
 	if len(config.Headers) > 0 {
 		opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
 	}
+	if config.URLPath != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(config.URLPath))
+	}
+	if config.Timeout > 0 {
+		opts = append(opts, otlptracehttp.WithTimeout(config.Timeout))
+	}
+
+	switch {
+	case config.compressesHTTP():
+		// The compressing transport replaces the SDK's client, so it
+		// carries the proxy, TLS, and timeout settings itself
+		httpClient, err := config.otlpHTTPClient()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlptracehttp.WithHTTPClient(httpClient))
+	case config.Proxy != nil:
+		proxyOpts, err := otlpHTTPProxyOptions(config.proxyConfig())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, proxyOpts...)
+	case !config.Insecure:
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsConfig))
+	}
 
 	client := otlptracehttp.NewClient(opts...)
-	return otlptrace.New(ctx, client)
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return limitPayload(exporter, config.MaxPayloadBytes), nil
+}
+
+// compressesHTTP reports whether OTLP/HTTP requests go through the
+// compressing transport
+func (c ExporterConfig) compressesHTTP() bool {
+	return c.Compression == CompressionGzip || c.Compression == CompressionZstd || c.CompressionStats != nil
+}
+
+// otlpHTTPClient builds the HTTP client of the compressing transport
+func (c ExporterConfig) otlpHTTPClient() (*http.Client, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	var base http.RoundTripper
+	if pc := c.proxyConfig(); pc != nil {
+		if err := pc.Validate(); err != nil {
+			return nil, err
+		}
+		client, err := pc.HTTPClient(timeout)
+		if err != nil {
+			return nil, err
+		}
+		base = client.Transport
+	} else {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if !c.Insecure {
+			tlsConfig, err := c.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsConfig
+		}
+		base = transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &compressingTransport{next: base, compression: c.Compression, stats: c.CompressionStats},
+	}, nil
+}
+
+// otlpHTTPProxyOptions converts proxy settings into OTLP HTTP client options
+func otlpHTTPProxyOptions(cfg *proxy.Config) ([]otlptracehttp.Option, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// SOCKS5 and custom dialers need a full HTTP client
+	if cfg.IsSOCKS() || cfg.Dialer != nil {
+		client, err := cfg.HTTPClient(0)
+		if err != nil {
+			return nil, err
+		}
+		return []otlptracehttp.Option{otlptracehttp.WithHTTPClient(client)}, nil
+	}
+
+	tlsConfig, err := cfg.TLSConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+	return []otlptracehttp.Option{
+		otlptracehttp.WithProxy(cfg.ProxyFunc()),
+		otlptracehttp.WithTLSClientConfig(tlsConfig),
+	}, nil
 }
 
 // createJaegerExporterFromConfig creates a Jaeger exporter from config
 func createJaegerExporterFromConfig(config ExporterConfig) (trace.SpanExporter, error) {
-	return jaeger.New(jaeger.WithCollectorEndpoint(
+	endpointOpts := []jaeger.CollectorEndpointOption{
 		jaeger.WithEndpoint(config.Endpoint),
-	))
+	}
+
+	if err := config.checkTransportSecurity(); err != nil {
+		return nil, err
+	}
+
+	if pc := config.proxyConfig(); pc != nil {
+		client, err := pc.HTTPClient(10 * time.Second)
+		if err != nil {
+			return nil, err
+		}
+		endpointOpts = append(endpointOpts, jaeger.WithHTTPClient(client))
+	}
+
+	return jaeger.New(jaeger.WithCollectorEndpoint(endpointOpts...))
 }
 
 // createStdoutExporter creates a stdout exporter
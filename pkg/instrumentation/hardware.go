@@ -0,0 +1,499 @@
+package instrumentation
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+// GPU metric sources
+const (
+	GPUSourceNvidiaSMI = "nvidia-smi"
+	GPUSourceDCGM      = "dcgm"
+)
+
+// Hardware metric defaults
+const (
+	DefaultHardwareInterval = 15 * time.Second
+	DefaultDCGMEndpoint     = "http://localhost:9400/metrics"
+)
+
+// HardwareConfig configures the collection of GPU and other hardware
+// metrics, for services such as model inference whose performance depends
+// on the accelerators they run on
+type HardwareConfig struct {
+	// GPU is the source of GPU metrics: GPUSourceNvidiaSMI queries the
+	// nvidia-smi tool, GPUSourceDCGM scrapes an NVIDIA DCGM exporter. Empty
+	// disables GPU metrics.
+	GPU string
+	// NvidiaSMIPath is the nvidia-smi binary, default nvidia-smi on PATH
+	NvidiaSMIPath string
+	// DCGMEndpoint is the metrics URL of the DCGM exporter, default
+	// DefaultDCGMEndpoint
+	DCGMEndpoint string
+	// Thermal exports the temperatures of the host's thermal zones (Linux)
+	Thermal bool
+	// Interval between collections, default DefaultHardwareInterval
+	Interval time.Duration
+}
+
+// Enabled reports whether any hardware metrics are collected
+func (c HardwareConfig) Enabled() bool {
+	return c.GPU != "" || c.Thermal
+}
+
+// GPUStats is one reading of a GPU. Values the GPU or source does not
+// report are NaN and left out of the exported metrics.
+type GPUStats struct {
+	Index string
+	UUID  string
+	Name  string
+
+	Utilization        float64 // Fraction of time a kernel was running, 0 to 1
+	MemoryUtilization  float64 // Fraction of time memory was read or written, 0 to 1
+	MemoryUsedBytes    float64
+	MemoryTotalBytes   float64
+	TemperatureCelsius float64
+	PowerWatts         float64
+	SMClockHertz       float64
+}
+
+func newGPUStats() GPUStats {
+	nan := math.NaN()
+	return GPUStats{
+		Utilization:        nan,
+		MemoryUtilization:  nan,
+		MemoryUsedBytes:    nan,
+		MemoryTotalBytes:   nan,
+		TemperatureCelsius: nan,
+		PowerWatts:         nan,
+		SMClockHertz:       nan,
+	}
+}
+
+// GPUSource reads the current stats of every GPU
+type GPUSource interface {
+	// Name identifies the source in logs
+	Name() string
+	GPUs(ctx context.Context) ([]GPUStats, error)
+}
+
+const mebibyte = 1 << 20
+
+// nvidiaSMIFields are queried from nvidia-smi, in this order
+var nvidiaSMIFields = []string{
+	"index", "uuid", "name",
+	"utilization.gpu", "utilization.memory",
+	"memory.used", "memory.total",
+	"temperature.gpu", "power.draw", "clocks.sm",
+}
+
+// NvidiaSMISource reads GPU stats with nvidia-smi
+type NvidiaSMISource struct {
+	Path string // Default nvidia-smi on PATH
+}
+
+// Name implements GPUSource
+func (s *NvidiaSMISource) Name() string { return GPUSourceNvidiaSMI }
+
+// GPUs implements GPUSource
+func (s *NvidiaSMISource) GPUs(ctx context.Context) ([]GPUStats, error) {
+	path := s.Path
+	if path == "" {
+		path = "nvidia-smi"
+	}
+	cmd := exec.CommandContext(ctx, path,
+		"--query-gpu="+strings.Join(nvidiaSMIFields, ","), "--format=csv,noheader,nounits")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("nvidia-smi failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("nvidia-smi failed: %w", err)
+	}
+	return parseNvidiaSMI(output)
+}
+
+// parseNvidiaSMI parses the CSV nvidia-smi prints for nvidiaSMIFields.
+// Fields a GPU does not support read [N/A] or [Not Supported].
+func parseNvidiaSMI(output []byte) ([]GPUStats, error) {
+	r := csv.NewReader(bytes.NewReader(output))
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid nvidia-smi output: %w", err)
+	}
+
+	gpus := make([]GPUStats, 0, len(records))
+	for _, record := range records {
+		if len(record) != len(nvidiaSMIFields) {
+			return nil, fmt.Errorf("invalid nvidia-smi output: %d fields, want %d", len(record), len(nvidiaSMIFields))
+		}
+		value := func(i int, scale float64) float64 {
+			v, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+			if err != nil {
+				return math.NaN()
+			}
+			return v * scale
+		}
+		gpu := GPUStats{
+			Index:              strings.TrimSpace(record[0]),
+			UUID:               strings.TrimSpace(record[1]),
+			Name:               strings.TrimSpace(record[2]),
+			Utilization:        value(3, 1) / 100,
+			MemoryUtilization:  value(4, 1) / 100,
+			MemoryUsedBytes:    value(5, mebibyte),
+			MemoryTotalBytes:   value(6, mebibyte),
+			TemperatureCelsius: value(7, 1),
+			PowerWatts:         value(8, 1),
+			SMClockHertz:       value(9, 1e6),
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+// dcgmFields maps the DCGM exporter fields to the stats they set, in the
+// units the exporter reports them
+var dcgmFields = map[string]func(*GPUStats, float64){
+	"DCGM_FI_DEV_GPU_UTIL":      func(g *GPUStats, v float64) { g.Utilization = v / 100 },
+	"DCGM_FI_DEV_MEM_COPY_UTIL": func(g *GPUStats, v float64) { g.MemoryUtilization = v / 100 },
+	"DCGM_FI_DEV_FB_USED":       func(g *GPUStats, v float64) { g.MemoryUsedBytes = v * mebibyte },
+	"DCGM_FI_DEV_GPU_TEMP":      func(g *GPUStats, v float64) { g.TemperatureCelsius = v },
+	"DCGM_FI_DEV_POWER_USAGE":   func(g *GPUStats, v float64) { g.PowerWatts = v },
+	"DCGM_FI_DEV_SM_CLOCK":      func(g *GPUStats, v float64) { g.SMClockHertz = v * 1e6 },
+}
+
+// dcgmMemoryFields add up to the total memory of a GPU
+var dcgmMemoryFields = []string{"DCGM_FI_DEV_FB_USED", "DCGM_FI_DEV_FB_FREE", "DCGM_FI_DEV_FB_RESERVED"}
+
+// DCGMSource reads GPU stats from an NVIDIA DCGM exporter, which also
+// works where the service's container has no access to the GPUs
+type DCGMSource struct {
+	Endpoint string       // Default DefaultDCGMEndpoint
+	Client   *http.Client // Default http.DefaultClient
+}
+
+// Name implements GPUSource
+func (s *DCGMSource) Name() string { return GPUSourceDCGM }
+
+// GPUs implements GPUSource
+func (s *DCGMSource) GPUs(ctx context.Context) ([]GPUStats, error) {
+	endpoint, client := s.Endpoint, s.Client
+	if endpoint == "" {
+		endpoint = DefaultDCGMEndpoint
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape DCGM exporter: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to scrape DCGM exporter: %s", resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DCGM exporter metrics: %w", err)
+	}
+
+	byIndex := make(map[string]*GPUStats)
+	gpu := func(labels map[string]string) *GPUStats {
+		g, ok := byIndex[labels["gpu"]]
+		if !ok {
+			stats := newGPUStats()
+			stats.Index, stats.UUID, stats.Name = labels["gpu"], labels["UUID"], labels["modelName"]
+			g = &stats
+			byIndex[labels["gpu"]] = g
+		}
+		return g
+	}
+	each := func(field string, fn func(*GPUStats, float64)) {
+		family, ok := families[field]
+		if !ok {
+			return
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if _, ok := labels["gpu"]; !ok {
+				continue
+			}
+			v := m.GetGauge().GetValue()
+			if m.Gauge == nil {
+				v = m.GetCounter().GetValue()
+			}
+			fn(gpu(labels), v)
+		}
+	}
+
+	for field, set := range dcgmFields {
+		each(field, set)
+	}
+	totals := make(map[*GPUStats]bool)
+	for _, field := range dcgmMemoryFields {
+		each(field, func(g *GPUStats, v float64) {
+			if !totals[g] {
+				totals[g] = true
+				g.MemoryTotalBytes = 0
+			}
+			g.MemoryTotalBytes += v * mebibyte
+		})
+	}
+
+	gpus := make([]GPUStats, 0, len(byIndex))
+	for _, g := range byIndex {
+		gpus = append(gpus, *g)
+	}
+	sort.Slice(gpus, func(i, j int) bool { return gpuLess(gpus[i].Index, gpus[j].Index) })
+	return gpus, nil
+}
+
+// gpuLess orders GPU indexes numerically
+func gpuLess(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return x < y
+}
+
+// ThermalZone is one temperature reading of a host thermal zone
+type ThermalZone struct {
+	Zone               string // e.g. thermal_zone0
+	Type               string // e.g. x86_pkg_temp or acpitz
+	TemperatureCelsius float64
+}
+
+// readThermalZones reads the thermal zones under root, normally
+// /sys/class/thermal; zones without a readable temperature are skipped
+func readThermalZones(root string) ([]ThermalZone, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]ThermalZone, 0, len(dirs))
+	for _, dir := range dirs {
+		temp, err := os.ReadFile(filepath.Join(dir, "temp"))
+		if err != nil {
+			continue
+		}
+		millidegrees, err := strconv.ParseFloat(strings.TrimSpace(string(temp)), 64)
+		if err != nil {
+			continue
+		}
+		kind, _ := os.ReadFile(filepath.Join(dir, "type"))
+		zones = append(zones, ThermalZone{
+			Zone:               filepath.Base(dir),
+			Type:               strings.TrimSpace(string(kind)),
+			TemperatureCelsius: millidegrees / 1000,
+		})
+	}
+	return zones, nil
+}
+
+// HardwareMonitor collects GPU stats and host temperatures periodically and
+// exports them as gauges labelled with the GPU index, UUID, and model
+type HardwareMonitor struct {
+	config      HardwareConfig
+	gpu         GPUSource
+	thermalRoot string
+	logger      *zap.Logger
+
+	gpuUtilization       *prometheus.GaugeVec
+	gpuMemoryUtilization *prometheus.GaugeVec
+	gpuMemoryUsed        *prometheus.GaugeVec
+	gpuMemoryTotal       *prometheus.GaugeVec
+	gpuTemperature       *prometheus.GaugeVec
+	gpuPower             *prometheus.GaugeVec
+	gpuSMClock           *prometheus.GaugeVec
+	gpuSuccess           prometheus.Gauge
+	thermal              *prometheus.GaugeVec
+
+	mu   sync.RWMutex
+	gpus []GPUStats
+}
+
+// NewHardwareMonitor creates a monitor of the configured GPU source and
+// thermal zones; a nil logger discards collection errors
+func NewHardwareMonitor(config HardwareConfig, logger *zap.Logger) *HardwareMonitor {
+	if config.Interval <= 0 {
+		config.Interval = DefaultHardwareInterval
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	m := &HardwareMonitor{
+		config:      config,
+		thermalRoot: "/sys/class/thermal",
+		logger:      logger,
+	}
+	switch config.GPU {
+	case GPUSourceNvidiaSMI:
+		m.gpu = &NvidiaSMISource{Path: config.NvidiaSMIPath}
+	case GPUSourceDCGM:
+		m.gpu = &DCGMSource{Endpoint: config.DCGMEndpoint, Client: &http.Client{Timeout: 10 * time.Second}}
+	}
+
+	gpuLabels := []string{"gpu", "uuid", "model"}
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, gpuLabels)
+	}
+	m.gpuUtilization = gauge("gpu_utilization_ratio", "Fraction of time one or more kernels ran on the GPU")
+	m.gpuMemoryUtilization = gauge("gpu_memory_utilization_ratio", "Fraction of time GPU memory was read or written")
+	m.gpuMemoryUsed = gauge("gpu_memory_used_bytes", "GPU memory in use")
+	m.gpuMemoryTotal = gauge("gpu_memory_total_bytes", "Total GPU memory")
+	m.gpuTemperature = gauge("gpu_temperature_celsius", "GPU temperature")
+	m.gpuPower = gauge("gpu_power_watts", "GPU power draw")
+	m.gpuSMClock = gauge("gpu_sm_clock_hertz", "Clock frequency of the GPU streaming multiprocessors")
+	m.gpuSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gpu_collection_success",
+		Help: "Whether the last collection of GPU metrics succeeded (1) or failed (0)",
+	})
+	m.thermal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hardware_temperature_celsius",
+			Help: "Temperature of a host thermal zone",
+		},
+		[]string{"zone", "type"},
+	)
+	return m
+}
+
+// Collectors returns the Prometheus collectors exported by the monitor
+func (m *HardwareMonitor) Collectors() []prometheus.Collector {
+	var collectors []prometheus.Collector
+	if m.gpu != nil {
+		collectors = append(collectors,
+			m.gpuUtilization, m.gpuMemoryUtilization, m.gpuMemoryUsed, m.gpuMemoryTotal,
+			m.gpuTemperature, m.gpuPower, m.gpuSMClock, m.gpuSuccess)
+	}
+	if m.config.Thermal {
+		collectors = append(collectors, m.thermal)
+	}
+	return collectors
+}
+
+// Register registers the monitor's collectors with the given registerer
+func (m *HardwareMonitor) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	for _, c := range m.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register hardware collector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Collect reads the GPUs and thermal zones once and updates the gauges.
+// GPUs and zones that disappear are removed from the metrics.
+func (m *HardwareMonitor) Collect(ctx context.Context) {
+	if m.gpu != nil {
+		gpus, err := m.gpu.GPUs(ctx)
+		if err != nil {
+			m.logger.Warn("GPU metrics collection failed", zap.String("source", m.gpu.Name()), zap.Error(err))
+			m.gpuSuccess.Set(0)
+		} else {
+			m.recordGPUs(gpus)
+			m.gpuSuccess.Set(1)
+		}
+	}
+
+	if m.config.Thermal {
+		zones, err := readThermalZones(m.thermalRoot)
+		if err != nil {
+			m.logger.Warn("thermal zone collection failed", zap.Error(err))
+			return
+		}
+		m.thermal.Reset()
+		for _, z := range zones {
+			m.thermal.WithLabelValues(z.Zone, z.Type).Set(z.TemperatureCelsius)
+		}
+	}
+}
+
+func (m *HardwareMonitor) recordGPUs(gpus []GPUStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gpus = gpus
+
+	gauges := []struct {
+		vec   *prometheus.GaugeVec
+		value func(GPUStats) float64
+	}{
+		{m.gpuUtilization, func(g GPUStats) float64 { return g.Utilization }},
+		{m.gpuMemoryUtilization, func(g GPUStats) float64 { return g.MemoryUtilization }},
+		{m.gpuMemoryUsed, func(g GPUStats) float64 { return g.MemoryUsedBytes }},
+		{m.gpuMemoryTotal, func(g GPUStats) float64 { return g.MemoryTotalBytes }},
+		{m.gpuTemperature, func(g GPUStats) float64 { return g.TemperatureCelsius }},
+		{m.gpuPower, func(g GPUStats) float64 { return g.PowerWatts }},
+		{m.gpuSMClock, func(g GPUStats) float64 { return g.SMClockHertz }},
+	}
+	for _, gauge := range gauges {
+		gauge.vec.Reset()
+		for _, g := range gpus {
+			if v := gauge.value(g); !math.IsNaN(v) {
+				gauge.vec.WithLabelValues(g.Index, g.UUID, g.Name).Set(v)
+			}
+		}
+	}
+}
+
+// Run collects immediately and then every Interval until ctx is done
+func (m *HardwareMonitor) Run(ctx context.Context) {
+	collect := func() {
+		collectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		m.Collect(collectCtx)
+		cancel()
+	}
+	collect()
+
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			collect()
+		}
+	}
+}
+
+// GPUs returns the latest stats of every GPU
+func (m *HardwareMonitor) GPUs() []GPUStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]GPUStats(nil), m.gpus...)
+}
@@ -0,0 +1,176 @@
+package instrumentation
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseNvidiaSMI(t *testing.T) {
+	output := `0, GPU-5f1b, NVIDIA A100-SXM4-40GB, 87, 41, 30210, 40960, 64, 312.45, 1410
+1, GPU-9c2e, NVIDIA A100-SXM4-40GB, 0, 0, 3, 40960, 31, [N/A], 210
+`
+	gpus, err := parseNvidiaSMI([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gpus) != 2 {
+		t.Fatalf("got %d GPUs, want 2", len(gpus))
+	}
+	g := gpus[0]
+	if g.Index != "0" || g.UUID != "GPU-5f1b" || g.Name != "NVIDIA A100-SXM4-40GB" {
+		t.Errorf("identity = %q %q %q", g.Index, g.UUID, g.Name)
+	}
+	if g.Utilization != 0.87 || g.MemoryUtilization != 0.41 {
+		t.Errorf("utilization = %g, %g", g.Utilization, g.MemoryUtilization)
+	}
+	if g.MemoryUsedBytes != 30210*mebibyte || g.MemoryTotalBytes != 40960*mebibyte {
+		t.Errorf("memory = %g / %g", g.MemoryUsedBytes, g.MemoryTotalBytes)
+	}
+	if g.TemperatureCelsius != 64 || g.PowerWatts != 312.45 || g.SMClockHertz != 1410e6 {
+		t.Errorf("temperature, power, clock = %g, %g, %g", g.TemperatureCelsius, g.PowerWatts, g.SMClockHertz)
+	}
+	if !math.IsNaN(gpus[1].PowerWatts) {
+		t.Errorf("unsupported power = %g, want NaN", gpus[1].PowerWatts)
+	}
+
+	if _, err := parseNvidiaSMI([]byte("0, GPU-5f1b\n")); err == nil {
+		t.Error("short record parsed without error")
+	}
+}
+
+const dcgmExposition = `# HELP DCGM_FI_DEV_GPU_UTIL GPU utilization (in %).
+# TYPE DCGM_FI_DEV_GPU_UTIL gauge
+DCGM_FI_DEV_GPU_UTIL{gpu="1",UUID="GPU-9c2e",device="nvidia1",modelName="NVIDIA L4"} 12
+DCGM_FI_DEV_GPU_UTIL{gpu="0",UUID="GPU-5f1b",device="nvidia0",modelName="NVIDIA L4"} 95
+# HELP DCGM_FI_DEV_FB_USED Framebuffer memory used (in MiB).
+# TYPE DCGM_FI_DEV_FB_USED gauge
+DCGM_FI_DEV_FB_USED{gpu="0",UUID="GPU-5f1b",device="nvidia0",modelName="NVIDIA L4"} 20000
+# HELP DCGM_FI_DEV_FB_FREE Framebuffer memory free (in MiB).
+# TYPE DCGM_FI_DEV_FB_FREE gauge
+DCGM_FI_DEV_FB_FREE{gpu="0",UUID="GPU-5f1b",device="nvidia0",modelName="NVIDIA L4"} 2000
+# HELP DCGM_FI_DEV_FB_RESERVED Framebuffer memory reserved (in MiB).
+# TYPE DCGM_FI_DEV_FB_RESERVED gauge
+DCGM_FI_DEV_FB_RESERVED{gpu="0",UUID="GPU-5f1b",device="nvidia0",modelName="NVIDIA L4"} 528
+# HELP DCGM_FI_DEV_POWER_USAGE Power draw (in W).
+# TYPE DCGM_FI_DEV_POWER_USAGE gauge
+DCGM_FI_DEV_POWER_USAGE{gpu="0",UUID="GPU-5f1b",device="nvidia0",modelName="NVIDIA L4"} 71.5
+`
+
+func TestDCGMSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(dcgmExposition))
+	}))
+	defer srv.Close()
+
+	gpus, err := (&DCGMSource{Endpoint: srv.URL}).GPUs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gpus) != 2 || gpus[0].Index != "0" || gpus[1].Index != "1" {
+		t.Fatalf("gpus = %+v", gpus)
+	}
+	g := gpus[0]
+	if g.UUID != "GPU-5f1b" || g.Name != "NVIDIA L4" {
+		t.Errorf("identity = %q %q", g.UUID, g.Name)
+	}
+	if g.Utilization != 0.95 || g.PowerWatts != 71.5 {
+		t.Errorf("utilization, power = %g, %g", g.Utilization, g.PowerWatts)
+	}
+	if g.MemoryUsedBytes != 20000*mebibyte || g.MemoryTotalBytes != 22528*mebibyte {
+		t.Errorf("memory = %g / %g", g.MemoryUsedBytes, g.MemoryTotalBytes)
+	}
+	if !math.IsNaN(g.TemperatureCelsius) || !math.IsNaN(gpus[1].MemoryTotalBytes) {
+		t.Error("fields the exporter does not report should be NaN")
+	}
+}
+
+type fakeGPUSource struct {
+	gpus []GPUStats
+	err  error
+}
+
+func (s *fakeGPUSource) Name() string { return "fake" }
+
+func (s *fakeGPUSource) GPUs(context.Context) ([]GPUStats, error) { return s.gpus, s.err }
+
+func TestHardwareMonitor(t *testing.T) {
+	root := t.TempDir()
+	for zone, files := range map[string][2]string{
+		"thermal_zone0": {"x86_pkg_temp\n", "54000\n"},
+		"thermal_zone1": {"acpitz\n", "not a number\n"},
+	} {
+		dir := filepath.Join(root, zone)
+		os.Mkdir(dir, 0o755)
+		os.WriteFile(filepath.Join(dir, "type"), []byte(files[0]), 0o644)
+		os.WriteFile(filepath.Join(dir, "temp"), []byte(files[1]), 0o644)
+	}
+
+	m := NewHardwareMonitor(HardwareConfig{GPU: GPUSourceDCGM, Thermal: true}, nil)
+	m.thermalRoot = root
+	gpu := newGPUStats()
+	gpu.Index, gpu.UUID, gpu.Name, gpu.Utilization = "0", "GPU-5f1b", "NVIDIA L4", 0.5
+	source := &fakeGPUSource{gpus: []GPUStats{gpu}}
+	m.gpu = source
+
+	reg := prometheus.NewRegistry()
+	if err := m.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	m.Collect(context.Background())
+
+	expected := `
+# HELP gpu_collection_success Whether the last collection of GPU metrics succeeded (1) or failed (0)
+# TYPE gpu_collection_success gauge
+gpu_collection_success 1
+# HELP gpu_utilization_ratio Fraction of time one or more kernels ran on the GPU
+# TYPE gpu_utilization_ratio gauge
+gpu_utilization_ratio{gpu="0",model="NVIDIA L4",uuid="GPU-5f1b"} 0.5
+# HELP hardware_temperature_celsius Temperature of a host thermal zone
+# TYPE hardware_temperature_celsius gauge
+hardware_temperature_celsius{type="x86_pkg_temp",zone="thermal_zone0"} 54
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"gpu_collection_success", "gpu_utilization_ratio", "gpu_power_watts", "hardware_temperature_celsius"); err != nil {
+		t.Error(err)
+	}
+
+	// A failed collection keeps the last readings and reports the failure
+	source.err = context.DeadlineExceeded
+	m.Collect(context.Background())
+	if v := testutil.ToFloat64(m.gpuSuccess); v != 0 {
+		t.Errorf("gpu_collection_success = %g after a failure", v)
+	}
+	if n := testutil.CollectAndCount(m.gpuUtilization); n != 1 {
+		t.Errorf("%d utilization series after a failure, want 1", n)
+	}
+
+	// GPUs that disappear are removed
+	source.gpus, source.err = nil, nil
+	m.Collect(context.Background())
+	if n := testutil.CollectAndCount(m.gpuUtilization); n != 0 {
+		t.Errorf("%d utilization series without GPUs, want 0", n)
+	}
+}
+
+func TestHardwareConfigValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Hardware = HardwareConfig{GPU: "rocm", DCGMEndpoint: "localhost:9400"}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid hardware config validated")
+	}
+	for _, want := range []string{"GPU_METRICS_SOURCE", "GPU_DCGM_ENDPOINT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
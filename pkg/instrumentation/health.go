@@ -2,6 +2,7 @@ package instrumentation
 
 import (
 	"context"
+	"net"
 	"sync"
 	"time"
 
@@ -276,3 +277,32 @@ func (d *DependencyAggregator) CheckAllDependencies(ctx context.Context) map[str
 	results["overall"] = overallStatus
 	return results
 }
+
+// TCPHealthCheck creates a health check that dials a TCP address, for
+// dependencies such as caches and brokers without an HTTP endpoint
+func TCPHealthCheck(name, address string, timeout time.Duration) HealthCheckFunc {
+	return func(ctx context.Context) HealthCheck {
+		start := time.Now()
+		check := HealthCheck{
+			Name:        name,
+			LastChecked: time.Now().UTC(),
+			Details: map[string]interface{}{
+				"address": address,
+			},
+		}
+
+		dialer := net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		check.Details["response_time_ms"] = time.Since(start).Milliseconds()
+		if err != nil {
+			check.Status = HealthStatusUnhealthy
+			check.Message = err.Error()
+			return check
+		}
+		conn.Close()
+
+		check.Status = HealthStatusHealthy
+		check.Message = "Address is reachable"
+		return check
+	}
+}
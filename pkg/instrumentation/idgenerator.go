@@ -0,0 +1,91 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ID generator names accepted by NewIDGenerator and TRACE_ID_GENERATOR
+const (
+	IDGeneratorRandom = "random"
+	IDGeneratorXRay   = "xray"
+	IDGenerator64Bit  = "64bit"
+)
+
+// NewIDGenerator returns the trace and span ID generator with the given name.
+// "random" (or empty) returns nil, which keeps the SDK's generator.
+func NewIDGenerator(name string) (sdktrace.IDGenerator, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", IDGeneratorRandom:
+		return nil, nil
+	case IDGeneratorXRay:
+		return XRayIDGenerator{}, nil
+	case IDGenerator64Bit:
+		return Legacy64IDGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown ID generator %q", name)
+	}
+}
+
+// IDGeneratorFromEnv returns the generator named by TRACE_ID_GENERATOR
+func IDGeneratorFromEnv() (sdktrace.IDGenerator, error) {
+	return NewIDGenerator(os.Getenv("TRACE_ID_GENERATOR"))
+}
+
+// XRayIDGenerator creates trace IDs AWS X-Ray accepts: the first 4 bytes are
+// the Unix time in seconds, the remaining 12 are random
+type XRayIDGenerator struct{}
+
+// NewIDs implements sdktrace.IDGenerator
+func (XRayIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var tid trace.TraceID
+	binary.BigEndian.PutUint32(tid[:4], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(tid[4:8], rand.Uint32())
+	binary.BigEndian.PutUint64(tid[8:], nonZeroUint64())
+	return tid, newSpanID()
+}
+
+// NewSpanID implements sdktrace.IDGenerator
+func (XRayIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+// Legacy64IDGenerator creates 64-bit trace IDs, left-padded with zeros to
+// the W3C length, for systems such as older Zipkin and Jaeger deployments
+// that store only the low 8 bytes
+type Legacy64IDGenerator struct{}
+
+// NewIDs implements sdktrace.IDGenerator
+func (Legacy64IDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var tid trace.TraceID
+	binary.BigEndian.PutUint64(tid[8:], nonZeroUint64())
+	return tid, newSpanID()
+}
+
+// NewSpanID implements sdktrace.IDGenerator
+func (Legacy64IDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var sid trace.SpanID
+	binary.BigEndian.PutUint64(sid[:], nonZeroUint64())
+	return sid
+}
+
+// nonZeroUint64 returns a random value; all-zero IDs are invalid
+func nonZeroUint64() uint64 {
+	for {
+		if v := rand.Uint64(); v != 0 {
+			return v
+		}
+	}
+}
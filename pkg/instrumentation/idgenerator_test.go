@@ -0,0 +1,34 @@
+package instrumentation
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestIDGenerators(t *testing.T) {
+	before := time.Now().Unix()
+	tid, sid := XRayIDGenerator{}.NewIDs(context.Background())
+	if ts := int64(binary.BigEndian.Uint32(tid[:4])); ts < before || ts > time.Now().Unix() {
+		t.Errorf("expected the X-Ray trace ID to start with the time, got %s", tid)
+	}
+	if !tid.IsValid() || !sid.IsValid() {
+		t.Errorf("expected valid IDs, got %s %s", tid, sid)
+	}
+
+	tid, sid = Legacy64IDGenerator{}.NewIDs(context.Background())
+	if binary.BigEndian.Uint64(tid[:8]) != 0 || !tid.IsValid() || !sid.IsValid() {
+		t.Errorf("expected a 64-bit trace ID, got %s", tid)
+	}
+
+	for name, want := range map[string]bool{"": false, "random": false, "XRay": true, "64bit": true} {
+		gen, err := NewIDGenerator(name)
+		if err != nil || (gen != nil) != want {
+			t.Errorf("NewIDGenerator(%q) = %v, %v", name, gen, err)
+		}
+	}
+	if _, err := NewIDGenerator("snowflake"); err == nil {
+		t.Error("expected an unknown generator to be rejected")
+	}
+}
@@ -17,7 +17,9 @@ package instrumentation
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -26,30 +28,116 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Instrumentation provides a unified interface for metrics, logging, and tracing
 type Instrumentation struct {
 	Logger  *zap.Logger
 	Metrics *MetricsCollector
-	config  *Config
+	Quota   *QuotaManager  // nil unless quotas are enabled
+	Pusher  *MetricsPusher // nil unless pushing is configured
+	// ClockSkew is nil unless clock skew detection is configured
+	ClockSkew *ClockSkewMonitor
+	// Crash is nil unless crash reports are configured
+	Crash *CrashReporter
+	// Hardware is nil unless GPU or thermal metrics are configured
+	Hardware *HardwareMonitor
+	// Kubernetes is nil unless Kubernetes metadata is enabled
+	Kubernetes *KubernetesEnricher
+	// AccessLog is nil unless an access log format is configured, in which
+	// case FiberMiddleware writes requests to it instead of the logger
+	AccessLog *AccessLogger
+	// Exporters is the startup check of the telemetry backends; its
+	// HealthCheck, registered as "exporters" with Config.Health, reports
+	// degraded while one is unreachable
+	Exporters *ExporterStatus
+	// Controls are the settings the admin API changes at runtime
+	Controls *Controls
+
+	// Gatherer collects the metrics as exposed, after relabeling
+	Gatherer prometheus.Gatherer
+	// TracerProvider is set when the config enables tracing
+	TracerProvider trace.TracerProvider
+	config         *Config
 
 	shutdownFuncs []func() error
 	mu            sync.Mutex
 }
 
-// New creates a new instrumentation instance
-func New(cfg *Config) (*Instrumentation, error) {
-	if cfg == nil {
-		cfg = DefaultConfig()
+// New creates a new instrumentation instance from the environment defaults
+// and the given options, which may include a *Config. The configuration is
+// validated before anything is registered.
+func New(opts ...Option) (*Instrumentation, error) {
+	cfg := buildConfig(opts...)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Relabeling applies to the exposed metrics, so quotas count the series
+	// left after it
+	gatherer, err := initRelabeler(cfg.Metrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize relabeling: %w", err)
+	}
+
+	var quota *QuotaManager
+	if cfg.Quota.Enabled {
+		quota = NewQuotaManager(cfg.ServiceName, cfg.Quota, gatherer)
 	}
 
 	// Initialize logger
-	logger, err := initLogger(cfg.Logging)
+	level := zap.NewAtomicLevel()
+	logger, err := initLogger(cfg.Logging, quota, level)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize logger: %w", err)
 	}
+	// The crash reporter keeps the recent log entries and reports fatal logs
+	var crash *CrashReporter
+	if cfg.Crash.Enabled() {
+		crash = NewCrashReporter(cfg.Crash, cfg.ServiceName)
+		logger = logger.WithOptions(
+			zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+				return zapcore.NewTee(core, crash.LogCore(level))
+			}),
+			zap.WithFatalHook(crash),
+		)
+		crash.logger = logger
+	}
+	// Kubernetes metadata is added to every log entry, and to spans below
+	var k8s *KubernetesEnricher
+	if cfg.Kubernetes.Enabled {
+		k8s = NewKubernetesEnricher(cfg.Kubernetes, logger)
+		loadCtx, cancelLoad := context.WithTimeout(context.Background(), 5*time.Second)
+		err := k8s.Load(loadCtx)
+		cancelLoad()
+		if errors.Is(err, ErrNotInPod) {
+			logger.Info("not running in a kubernetes pod, kubernetes metadata disabled")
+			k8s = nil
+		} else {
+			if err != nil {
+				logger.Warn("kubernetes metadata is incomplete", zap.Error(err))
+			}
+			logger = logger.WithOptions(zap.WrapCore(k8s.WrapCore))
+			if crash != nil {
+				crash.logger = logger
+			}
+		}
+	}
+	controls := NewControls(level, quota)
+
+	// Unreachable backends fail startup, degrade it, or have their
+	// exporters removed from cfg, as the policy says
+	exporters, err := checkExporters(cfg, logger, dialTCP)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Health != nil {
+		cfg.Health.RegisterCheck("exporters", exporters.HealthCheck())
+	}
 
 	// Initialize metrics
 	metrics, err := initMetrics(cfg.Metrics)
@@ -60,6 +148,12 @@ func New(cfg *Config) (*Instrumentation, error) {
 	inst := &Instrumentation{
 		Logger:        logger,
 		Metrics:       metrics,
+		Quota:         quota,
+		Crash:         crash,
+		Kubernetes:    k8s,
+		Controls:      controls,
+		Exporters:     exporters,
+		Gatherer:      gatherer,
 		config:        cfg,
 		shutdownFuncs: make([]func() error, 0),
 	}
@@ -69,6 +163,189 @@ func New(cfg *Config) (*Instrumentation, error) {
 		return nil, fmt.Errorf("failed to register metrics: %w", err)
 	}
 
+	if quota != nil {
+		prometheus.MustRegister(quota)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go quota.Start(ctx)
+		inst.RegisterShutdownFunc(func() error {
+			cancel()
+			return nil
+		})
+	}
+
+	if accessLog := cfg.Logging.AccessFormat; accessLog != "" && accessLog != AccessLogZap {
+		output := cfg.Logging.AccessOutput
+		if output == "" {
+			output = "stdout"
+		}
+		out, closeOut, err := zap.Open(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log %s: %w", output, err)
+		}
+		inst.AccessLog, err = NewAccessLogger(AccessLogConfig{Format: accessLog, Output: out, ServiceName: cfg.ServiceName})
+		if err != nil {
+			closeOut()
+			return nil, err
+		}
+		inst.RegisterShutdownFunc(func() error {
+			err := out.Sync()
+			closeOut()
+			return err
+		})
+	}
+
+	if crash != nil {
+		if err := crash.Register(nil); err != nil {
+			return nil, err
+		}
+		if err := crash.Install(); err != nil {
+			logger.Warn("runtime crash output is not saved", zap.Error(err))
+		}
+		inst.RegisterShutdownFunc(crash.Close)
+	}
+
+	// Batch jobs push their metrics, and push once more at shutdown so
+	// nothing recorded before exit is lost
+	if cfg.Push.Enabled() {
+		pushConfig := cfg.Push
+		if pushConfig.Job == "" {
+			pushConfig.Job = cfg.ServiceName
+		}
+		pusher, err := NewMetricsPusher(pushConfig, gatherer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize metrics push: %w", err)
+		}
+		inst.Pusher = pusher
+		if crash != nil {
+			crash.OnCrash(pusher.Push)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go pusher.Start(ctx)
+		inst.RegisterShutdownFunc(func() error {
+			cancel()
+			pushCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+			defer done()
+			return pusher.Push(pushCtx)
+		})
+	}
+
+	// Replicas share the controls: load them before serving, then follow
+	// changes
+	if cfg.ControlSync.Enabled() {
+		syncConfig := cfg.ControlSync
+		if syncConfig.Key == "" {
+			syncConfig.Key = "apm/controls/" + cfg.ServiceName
+		}
+		store, err := NewControlStore(syncConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize control sync: %w", err)
+		}
+		controlSync := NewControlSync(controls, store, syncConfig.CacheFile, logger)
+
+		loadCtx, cancelLoad := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := controlSync.Load(loadCtx); err != nil {
+			logger.Warn("starting with local controls", zap.Error(err))
+		}
+		cancelLoad()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go controlSync.Run(ctx)
+		inst.RegisterShutdownFunc(func() error {
+			cancel()
+			return nil
+		})
+	}
+
+	// The first measurement is taken before tracing starts, so corrected
+	// timestamps apply from the first span
+	if cfg.ClockSkew.Enabled() {
+		monitor := NewClockSkewMonitor(cfg.ClockSkew, logger)
+		if err := monitor.Register(nil); err != nil {
+			return nil, err
+		}
+		inst.ClockSkew = monitor
+
+		measureCtx, cancelMeasure := context.WithTimeout(context.Background(), 5*time.Second)
+		monitor.Measure(measureCtx)
+		cancelMeasure()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go monitor.Run(ctx)
+		inst.RegisterShutdownFunc(func() error {
+			cancel()
+			return nil
+		})
+	}
+
+	if cfg.Hardware.Enabled() {
+		monitor := NewHardwareMonitor(cfg.Hardware, logger)
+		if err := monitor.Register(nil); err != nil {
+			return nil, err
+		}
+		inst.Hardware = monitor
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go monitor.Run(ctx)
+		inst.RegisterShutdownFunc(func() error {
+			cancel()
+			return nil
+		})
+	}
+
+	if k8s != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		go k8s.Run(ctx)
+		inst.RegisterShutdownFunc(func() error {
+			cancel()
+			return nil
+		})
+	}
+
+	if cfg.Tracing != nil {
+		tracing := *cfg.Tracing
+		if tracing.ServiceName == "" {
+			tracing.ServiceName = cfg.ServiceName
+		}
+		if tracing.ServiceVersion == "" {
+			tracing.ServiceVersion = cfg.Version
+		}
+		if tracing.Environment == "" {
+			tracing.Environment = cfg.Environment
+		}
+		if tracing.Quota == nil {
+			tracing.Quota = quota
+		}
+		if tracing.Controls == nil {
+			tracing.Controls = controls
+		}
+		if tracing.ClockSkew == nil {
+			tracing.ClockSkew = inst.ClockSkew
+		}
+		if tracing.Crash == nil {
+			tracing.Crash = crash
+		}
+		if tracing.Kubernetes == nil {
+			tracing.Kubernetes = k8s
+		}
+		if tracing.CompressionStats == nil {
+			tracing.CompressionStats = NewCompressionStats()
+			if err := tracing.CompressionStats.Register(nil); err != nil {
+				return nil, err
+			}
+		}
+		tp, cleanup, err := InitTracer(context.Background(), tracing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize tracing: %w", err)
+		}
+		inst.TracerProvider = tp
+		inst.RegisterShutdownFunc(func() error {
+			cleanup()
+			return nil
+		})
+	}
+
 	return inst, nil
 }
 
@@ -122,10 +399,24 @@ func (i *Instrumentation) WaitForShutdown() {
 	}
 }
 
+// MetricsHandler serves the metrics as exposed, after relabeling
+func (i *Instrumentation) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(i.Gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// AnalyzeBuckets reports how well the buckets of the exposed histograms fit
+// the values they observe, and suggests apm.yaml buckets for poor fits. The
+// HTTP histograms are judged from sampled observations when
+// METRICS_ANALYZE_BUCKETS is on, the others from their buckets.
+func (i *Instrumentation) AnalyzeBuckets() ([]BucketReport, error) {
+	return AnalyzeBuckets(i.Gatherer, i.Metrics.BucketAnalyzer())
+}
+
 // FiberMiddleware returns a Fiber middleware that instruments HTTP requests
 func (i *Instrumentation) FiberMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
+		guardContext(c)
 
 		// Get request details
 		method := c.Method()
@@ -133,6 +424,9 @@ func (i *Instrumentation) FiberMiddleware() fiber.Handler {
 		if path == "" {
 			path = c.Path()
 		}
+		if i.Quota != nil {
+			path = i.Quota.LimitLabel(path)
+		}
 
 		// Process request
 		err := c.Next()
@@ -145,6 +439,14 @@ func (i *Instrumentation) FiberMiddleware() fiber.Handler {
 		i.Metrics.RecordHTTPRequestSize(method, path, float64(len(c.Body())))
 		i.Metrics.RecordHTTPResponseSize(method, path, float64(len(c.Response().Body())))
 
+		if i.AccessLog != nil {
+			i.AccessLog.Log(c, start, err)
+			if err != nil {
+				i.Logger.Error("request failed", zap.String("method", method), zap.String("path", path), zap.Error(err))
+			}
+			return err
+		}
+
 		// Log request
 		fields := []zap.Field{
 			zap.String("method", method),
@@ -168,17 +470,9 @@ func (i *Instrumentation) FiberMiddleware() fiber.Handler {
 	}
 }
 
-// registerMetrics registers all Prometheus metrics
+// registerMetrics registers the custom Prometheus metrics; the HTTP metrics
+// are registered when the collector is created
 func (i *Instrumentation) registerMetrics() error {
-	// Register HTTP metrics
-	prometheus.MustRegister(
-		i.Metrics.httpRequestsTotal,
-		i.Metrics.httpRequestDuration,
-		i.Metrics.httpRequestSize,
-		i.Metrics.httpResponseSize,
-	)
-
-	// Register custom metrics if any
 	for _, collector := range i.Metrics.customCollectors {
 		prometheus.MustRegister(collector)
 	}
@@ -186,8 +480,8 @@ func (i *Instrumentation) registerMetrics() error {
 	return nil
 }
 
-// initLogger initializes the zap logger
-func initLogger(cfg LoggingConfig) (*zap.Logger, error) {
+// initLogger initializes the zap logger, applying the log quota when set
+func initLogger(cfg LoggingConfig, quota *QuotaManager, level zap.AtomicLevel) (*zap.Logger, error) {
 	var zapCfg zap.Config
 
 	if cfg.Development {
@@ -196,7 +490,8 @@ func initLogger(cfg LoggingConfig) (*zap.Logger, error) {
 		zapCfg = zap.NewProductionConfig()
 	}
 
-	// Set log level
+	// Set log level; the controls may change it later
+	zapCfg.Level = level
 	if err := zapCfg.Level.UnmarshalText([]byte(cfg.Level)); err != nil {
 		return nil, fmt.Errorf("invalid log level %s: %w", cfg.Level, err)
 	}
@@ -208,10 +503,43 @@ func initLogger(cfg LoggingConfig) (*zap.Logger, error) {
 	// Set encoding
 	zapCfg.Encoding = cfg.Encoding
 
+	if quota != nil {
+		quota.setBaseLogLevel(zapCfg.Level.Level())
+		sampling := zapCfg.Sampling
+		zapCfg.Sampling = nil
+		return zapCfg.Build(quota.wrapCore(sampling))
+	}
+
 	return zapCfg.Build()
 }
 
-// initMetrics initializes the metrics collector
+// initMetrics initializes the metrics collector, with the histograms of the
+// relabel file after the configured ones
 func initMetrics(cfg MetricsConfig) (*MetricsCollector, error) {
-	return NewMetricsCollector(cfg.Namespace, cfg.Subsystem), nil
+	if cfg.RelabelFile != "" {
+		file, err := LoadHistogramConfig(cfg.RelabelFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Histograms = append(append([]HistogramConfig(nil), cfg.Histograms...), file...)
+	}
+	return NewMetricsCollectorWith(prometheus.DefaultRegisterer, cfg)
+}
+
+// initRelabeler wraps the default gatherer with the configured relabeling,
+// if any
+func initRelabeler(cfg MetricsConfig) (prometheus.Gatherer, error) {
+	relabel := cfg.Relabel
+	if cfg.RelabelFile != "" {
+		file, err := LoadRelabelConfig(cfg.RelabelFile)
+		if err != nil {
+			return nil, err
+		}
+		relabel.Relabel = append(relabel.Relabel, file.Relabel...)
+		relabel.Aggregate = append(relabel.Aggregate, file.Aggregate...)
+	}
+	if relabel.Empty() {
+		return prometheus.DefaultGatherer, nil
+	}
+	return NewRelabeler(prometheus.DefaultGatherer, relabel)
 }
@@ -0,0 +1,471 @@
+package instrumentation
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Kubernetes metadata defaults
+const (
+	DefaultPodInfoDir           = "/etc/podinfo"
+	DefaultKubernetesRefresh    = 30 * time.Second
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// Prefixes of the attributes holding pod labels and annotations
+const (
+	podLabelPrefix      = "k8s.pod.label."
+	podAnnotationPrefix = "k8s.pod.annotation."
+)
+
+// ErrNotInPod is returned by KubernetesEnricher.Load when the pod's
+// namespace cannot be found
+var ErrNotInPod = errors.New("not running in a kubernetes pod")
+
+// podTemplateLabels vary per rollout and are left out unless listed
+var podTemplateLabels = map[string]bool{
+	"pod-template-hash":        true,
+	"controller-revision-hash": true,
+	"pod-template-generation":  true,
+}
+
+// KubernetesConfig configures the Kubernetes attributes added to spans and
+// logs, so telemetry can be sliced by workload without collector-side
+// processors. The pod identity comes from the downward API; with Watch the
+// pod is also read from the API server and followed.
+type KubernetesConfig struct {
+	Enabled bool
+	// Pod identity, usually set through downward API environment
+	// variables. PodName defaults to the hostname and Namespace to the
+	// service account's.
+	PodName   string
+	Namespace string
+	NodeName  string
+	PodUID    string
+	// PodInfoDir is a downward API volume holding "labels" and
+	// "annotations" files, default DefaultPodInfoDir. Missing files are
+	// skipped.
+	PodInfoDir string
+	// Watch reads the pod from the API server and follows its changes;
+	// the service account needs get and watch on pods in its namespace
+	Watch bool
+	// Client is the API client used by Watch, default in-cluster
+	Client kubernetes.Interface
+	// Labels are the pod labels attached; nil attaches all but the pod
+	// template hashes. Annotations are the annotations attached; nil
+	// attaches none. "*" matches every key.
+	Labels      []string
+	Annotations []string
+	// RefreshInterval between reads of PodInfoDir, default
+	// DefaultKubernetesRefresh
+	RefreshInterval time.Duration
+}
+
+// podMetadata is what is known about the local pod
+type podMetadata struct {
+	name, namespace, uid, node string
+	labels, annotations        map[string]string
+	owners                     []metav1.OwnerReference
+}
+
+// kubernetesAttributes is one version of the attributes
+type kubernetesAttributes struct {
+	version uint64
+	attrs   []attribute.KeyValue
+	fields  []zap.Field
+}
+
+// KubernetesEnricher adds the local pod's Kubernetes metadata to spans, as a
+// span processor, and to logs, through WrapCore. Changed labels and
+// annotations apply to spans and log entries from then on.
+type KubernetesEnricher struct {
+	config KubernetesConfig
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	meta    podMetadata
+	current atomic.Pointer[kubernetesAttributes]
+}
+
+var _ sdktrace.SpanProcessor = (*KubernetesEnricher)(nil)
+
+// NewKubernetesEnricher creates an enricher; Load reads the metadata
+func NewKubernetesEnricher(config KubernetesConfig, logger *zap.Logger) *KubernetesEnricher {
+	if config.PodInfoDir == "" {
+		config.PodInfoDir = DefaultPodInfoDir
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultKubernetesRefresh
+	}
+	if logger == nil {
+		logger = zap.L()
+	}
+	e := &KubernetesEnricher{config: config, logger: logger}
+	e.current.Store(&kubernetesAttributes{})
+	return e
+}
+
+// Load reads the pod identity from the config and the downward API volume
+// and, with Watch, the pod from the API server. It returns ErrNotInPod
+// outside a pod.
+func (e *KubernetesEnricher) Load(ctx context.Context) error {
+	meta := podMetadata{
+		name:      e.config.PodName,
+		namespace: e.config.Namespace,
+		uid:       e.config.PodUID,
+		node:      e.config.NodeName,
+	}
+	if meta.name == "" {
+		meta.name, _ = os.Hostname()
+	}
+	if meta.namespace == "" {
+		if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			meta.namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if meta.namespace == "" {
+		return ErrNotInPod
+	}
+	meta.labels, meta.annotations = e.readPodInfo()
+	e.set(meta)
+
+	if !e.config.Watch {
+		return nil
+	}
+	if e.config.Client == nil {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load the in-cluster kubernetes config: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("failed to create the kubernetes client: %w", err)
+		}
+		e.config.Client = client
+	}
+	pod, err := e.config.Client.CoreV1().Pods(meta.namespace).Get(ctx, meta.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s/%s: %w", meta.namespace, meta.name, err)
+	}
+	e.setPod(pod)
+	return nil
+}
+
+// Run follows changes to the pod until ctx is done: through the API server
+// with Watch, otherwise by reading the downward API volume again, which the
+// kubelet updates when labels or annotations change
+func (e *KubernetesEnricher) Run(ctx context.Context) {
+	if e.config.Watch && e.config.Client != nil {
+		e.watch(ctx)
+		return
+	}
+	ticker := time.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			labels, annotations := e.readPodInfo()
+			e.mu.Lock()
+			meta := e.meta
+			e.mu.Unlock()
+			meta.labels, meta.annotations = labels, annotations
+			e.set(meta)
+		}
+	}
+}
+
+// watch follows the pod through the API server, reconnecting with backoff
+// when the watch ends
+func (e *KubernetesEnricher) watch(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := e.watchPod(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// The API server ended the watch; reconnect soon
+			backoff = time.Second
+		} else {
+			e.logger.Debug("kubernetes pod watch failed", zap.Error(err), zap.Duration("retry_in", backoff))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			backoff = min(backoff*2, e.config.RefreshInterval)
+		}
+	}
+}
+
+// watchPod reads the pod and applies its changes until the watch ends
+func (e *KubernetesEnricher) watchPod(ctx context.Context) error {
+	e.mu.Lock()
+	namespace, name := e.meta.namespace, e.meta.name
+	e.mu.Unlock()
+
+	pods := e.config.Client.CoreV1().Pods(namespace)
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s/%s: %w", namespace, name, err)
+	}
+	e.setPod(pod)
+
+	w, err := pods.Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+		ResourceVersion: pod.ResourceVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch pod %s/%s: %w", namespace, name, err)
+	}
+	defer w.Stop()
+	for event := range w.ResultChan() {
+		if pod, ok := event.Object.(*corev1.Pod); ok && pod.Name == name && event.Type == watch.Modified {
+			e.setPod(pod)
+		}
+	}
+	return nil
+}
+
+// setPod applies the metadata of the pod read from the API server
+func (e *KubernetesEnricher) setPod(pod *corev1.Pod) {
+	e.mu.Lock()
+	meta := e.meta
+	e.mu.Unlock()
+	meta.uid = string(pod.UID)
+	if pod.Spec.NodeName != "" {
+		meta.node = pod.Spec.NodeName
+	}
+	meta.labels, meta.annotations = pod.Labels, pod.Annotations
+	meta.owners = pod.OwnerReferences
+	e.set(meta)
+}
+
+// readPodInfo reads the labels and annotations files of the downward API
+// volume
+func (e *KubernetesEnricher) readPodInfo() (labels, annotations map[string]string) {
+	labels, err := readDownwardAPIFile(filepath.Join(e.config.PodInfoDir, "labels"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		e.logger.Debug("failed to read pod labels", zap.Error(err))
+	}
+	annotations, err = readDownwardAPIFile(filepath.Join(e.config.PodInfoDir, "annotations"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		e.logger.Debug("failed to read pod annotations", zap.Error(err))
+	}
+	return labels, annotations
+}
+
+// readDownwardAPIFile parses the key="value" lines of a downward API file
+func readDownwardAPIFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		key, quoted, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			value = quoted
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// set stores the metadata and rebuilds the attributes when they changed
+func (e *KubernetesEnricher) set(meta podMetadata) {
+	attrs := e.attributes(meta)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.meta = meta
+	previous := e.current.Load()
+	before, after := attribute.NewSet(previous.attrs...), attribute.NewSet(attrs...)
+	if before.Equals(&after) {
+		return
+	}
+	logFields := make([]zap.Field, len(attrs))
+	for n, attr := range attrs {
+		logFields[n] = zap.String(string(attr.Key), attr.Value.AsString())
+	}
+	e.current.Store(&kubernetesAttributes{version: previous.version + 1, attrs: attrs, fields: logFields})
+}
+
+// attributes converts the metadata to resource semantic convention
+// attributes, with the workload taken from the owner references or, from
+// the downward API alone, from the labels the controllers set
+func (e *KubernetesEnricher) attributes(meta podMetadata) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	add := func(key attribute.Key, value string) {
+		if value != "" {
+			attrs = append(attrs, key.String(value))
+		}
+	}
+	add(semconv.K8SNamespaceNameKey, meta.namespace)
+	add(semconv.K8SPodNameKey, meta.name)
+	add(semconv.K8SPodUIDKey, meta.uid)
+	add(semconv.K8SNodeNameKey, meta.node)
+
+	hash := meta.labels["pod-template-hash"]
+	replicaSet := ""
+	for _, owner := range meta.owners {
+		switch owner.Kind {
+		case "ReplicaSet":
+			replicaSet = owner.Name
+		case "StatefulSet":
+			add(semconv.K8SStatefulSetNameKey, owner.Name)
+		case "DaemonSet":
+			add(semconv.K8SDaemonSetNameKey, owner.Name)
+		case "Job":
+			add(semconv.K8SJobNameKey, owner.Name)
+		}
+	}
+	if meta.owners == nil {
+		// ReplicaSet pods are named <deployment>-<hash>-<suffix>
+		if i := strings.LastIndex(meta.name, "-"); hash != "" && i > 0 && strings.HasSuffix(meta.name[:i], "-"+hash) {
+			replicaSet = meta.name[:i]
+		}
+		if name := meta.labels["statefulset.kubernetes.io/pod-name"]; name != "" {
+			if i := strings.LastIndex(name, "-"); i > 0 {
+				add(semconv.K8SStatefulSetNameKey, name[:i])
+			}
+		}
+		if job := meta.labels["batch.kubernetes.io/job-name"]; job != "" {
+			add(semconv.K8SJobNameKey, job)
+		} else {
+			add(semconv.K8SJobNameKey, meta.labels["job-name"])
+		}
+	}
+	if replicaSet != "" {
+		add(semconv.K8SReplicaSetNameKey, replicaSet)
+		if hash != "" && strings.HasSuffix(replicaSet, "-"+hash) {
+			add(semconv.K8SDeploymentNameKey, strings.TrimSuffix(replicaSet, "-"+hash))
+		}
+	}
+
+	for _, key := range selectKeys(meta.labels, e.config.Labels, true) {
+		attrs = append(attrs, attribute.String(podLabelPrefix+key, meta.labels[key]))
+	}
+	for _, key := range selectKeys(meta.annotations, e.config.Annotations, false) {
+		attrs = append(attrs, attribute.String(podAnnotationPrefix+key, meta.annotations[key]))
+	}
+	return attrs
+}
+
+// selectKeys returns the sorted keys of values in the allow list; a nil
+// list selects every key but the pod template hashes when defaultAll is set
+func selectKeys(values map[string]string, allow []string, defaultAll bool) []string {
+	all := slices.Contains(allow, "*")
+	var keys []string
+	for key := range values {
+		if all || slices.Contains(allow, key) || allow == nil && defaultAll && !podTemplateLabels[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Attributes returns the current Kubernetes attributes
+func (e *KubernetesEnricher) Attributes() []attribute.KeyValue {
+	return e.current.Load().attrs
+}
+
+// OnStart implements sdktrace.SpanProcessor by adding the attributes
+func (e *KubernetesEnricher) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(e.current.Load().attrs...)
+}
+
+// OnEnd implements sdktrace.SpanProcessor
+func (e *KubernetesEnricher) OnEnd(sdktrace.ReadOnlySpan) {}
+
+// Shutdown implements sdktrace.SpanProcessor
+func (e *KubernetesEnricher) Shutdown(context.Context) error { return nil }
+
+// ForceFlush implements sdktrace.SpanProcessor
+func (e *KubernetesEnricher) ForceFlush(context.Context) error { return nil }
+
+// WrapCore adds the attributes as fields to every log entry; use it with
+// zap.WrapCore
+func (e *KubernetesEnricher) WrapCore(core zapcore.Core) zapcore.Core {
+	return &kubernetesCore{Core: core, enricher: e}
+}
+
+// kubernetesCore passes log entries to the wrapped core with the current
+// attributes added, so its own checks such as sampling still apply
+type kubernetesCore struct {
+	zapcore.Core
+	enricher *KubernetesEnricher
+	// cached is the wrapped core with the fields of one attribute version
+	cached atomic.Pointer[versionedCore]
+}
+
+type versionedCore struct {
+	version uint64
+	core    zapcore.Core
+}
+
+// withFields returns the wrapped core with the current fields, rebuilt
+// only when the attributes change
+func (c *kubernetesCore) withFields() zapcore.Core {
+	current := c.enricher.current.Load()
+	if cached := c.cached.Load(); cached != nil && cached.version == current.version {
+		return cached.core
+	}
+	core := c.Core
+	if len(current.fields) > 0 {
+		core = core.With(current.fields)
+	}
+	c.cached.Store(&versionedCore{version: current.version, core: core})
+	return core
+}
+
+// With implements zapcore.Core
+func (c *kubernetesCore) With(fields []zapcore.Field) zapcore.Core {
+	return &kubernetesCore{Core: c.Core.With(fields), enricher: c.enricher}
+}
+
+// Check implements zapcore.Core
+func (c *kubernetesCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.withFields().Check(ent, ce)
+}
+
+// Write implements zapcore.Core
+func (c *kubernetesCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.withFields().Write(ent, fields)
+}
@@ -0,0 +1,188 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func kubernetesAttributeMap(attrs []attribute.KeyValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		m[string(attr.Key)] = attr.Value.AsString()
+	}
+	return m
+}
+
+func writePodInfo(t *testing.T, dir, labels, annotations string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "labels"), []byte(labels), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "annotations"), []byte(annotations), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubernetesEnricherDownwardAPI(t *testing.T) {
+	dir := t.TempDir()
+	writePodInfo(t, dir,
+		"app=\"checkout\"\npod-template-hash=\"7d9f8b6c5d\"\nteam=\"payments\"\n",
+		"owner=\"team-payments@example.com\"\nkubectl.kubernetes.io/restartedAt=\"2024-05-01T10:00:00Z\"\nnote=\"say \\\"hi\\\"\"\n")
+
+	e := NewKubernetesEnricher(KubernetesConfig{
+		PodName:     "checkout-7d9f8b6c5d-x2x9k",
+		Namespace:   "shop",
+		NodeName:    "node-1",
+		PodInfoDir:  dir,
+		Annotations: []string{"owner", "note"},
+	}, zap.NewNop())
+	if err := e.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := kubernetesAttributeMap(e.Attributes())
+	want := map[string]string{
+		"k8s.namespace.name":       "shop",
+		"k8s.pod.name":             "checkout-7d9f8b6c5d-x2x9k",
+		"k8s.node.name":            "node-1",
+		"k8s.replicaset.name":      "checkout-7d9f8b6c5d",
+		"k8s.deployment.name":      "checkout",
+		"k8s.pod.label.app":        "checkout",
+		"k8s.pod.label.team":       "payments",
+		"k8s.pod.annotation.owner": "team-payments@example.com",
+		"k8s.pod.annotation.note":  `say "hi"`,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("attributes = %v, want only %v", got, want)
+	}
+
+	// The kubelet rewrites the files when labels change
+	writePodInfo(t, dir, "app=\"checkout\"\nteam=\"platform\"\n", "")
+	labels, annotations := e.readPodInfo()
+	e.mu.Lock()
+	meta := e.meta
+	e.mu.Unlock()
+	meta.labels, meta.annotations = labels, annotations
+	e.set(meta)
+	if got := kubernetesAttributeMap(e.Attributes()); got["k8s.pod.label.team"] != "platform" || got["k8s.deployment.name"] != "" {
+		t.Errorf("attributes after relabel = %v", got)
+	}
+}
+
+func TestKubernetesEnricherNotInPod(t *testing.T) {
+	if _, err := os.Stat(serviceAccountNamespaceFile); err == nil {
+		t.Skip("running in a pod")
+	}
+	e := NewKubernetesEnricher(KubernetesConfig{PodName: "app"}, zap.NewNop())
+	if err := e.Load(context.Background()); !errors.Is(err, ErrNotInPod) {
+		t.Errorf("err = %v, want ErrNotInPod", err)
+	}
+}
+
+func TestKubernetesEnricherWatch(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ledger-2",
+			Namespace: "bank",
+			UID:       "0b3c8f6e-1d1a-4b5e-9a53-52f4a8e1c9d1",
+			Labels:    map[string]string{"app": "ledger", "controller-revision-hash": "ledger-6c9f"},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "StatefulSet", Name: "ledger"},
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-7"},
+	}
+	client := fake.NewSimpleClientset(pod)
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(watcher, nil))
+
+	e := NewKubernetesEnricher(KubernetesConfig{
+		PodName:    "ledger-2",
+		Namespace:  "bank",
+		PodInfoDir: t.TempDir(),
+		Watch:      true,
+		Client:     client,
+		Labels:     []string{"app"},
+	}, zap.NewNop())
+	if err := e.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := kubernetesAttributeMap(e.Attributes())
+	if got["k8s.statefulset.name"] != "ledger" || got["k8s.node.name"] != "node-7" || got["k8s.pod.uid"] != string(pod.UID) {
+		t.Errorf("attributes = %v", got)
+	}
+	if _, ok := got["k8s.pod.label.controller-revision-hash"]; ok {
+		t.Error("label outside the allow list attached")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	relabeled := pod.DeepCopy()
+	relabeled.Labels["app"] = "ledger-v2"
+	watcher.Modify(relabeled)
+	deadline := time.Now().Add(5 * time.Second)
+	for kubernetesAttributeMap(e.Attributes())["k8s.pod.label.app"] != "ledger-v2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("watched label change not applied: %v", kubernetesAttributeMap(e.Attributes()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKubernetesEnricherSpansAndLogs(t *testing.T) {
+	e := NewKubernetesEnricher(KubernetesConfig{PodName: "api-0", Namespace: "web"}, zap.NewNop())
+	if err := e.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(e), sdktrace.WithSpanProcessor(recorder))
+	_, span := tp.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	if got := kubernetesAttributeMap(recorder.Ended()[0].Attributes()); got["k8s.pod.name"] != "api-0" || got["k8s.namespace.name"] != "web" {
+		t.Errorf("span attributes = %v", got)
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core, zap.WrapCore(e.WrapCore)).With(zap.String("request_id", "r1"))
+	logger.Debug("dropped")
+	logger.Info("served")
+
+	e.set(podMetadata{name: "api-0", namespace: "web", node: "node-3"})
+	logger.Info("served again")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("%d entries logged, want 2", len(entries))
+	}
+	first, second := entries[0].ContextMap(), entries[1].ContextMap()
+	if first["k8s.pod.name"] != "api-0" || first["request_id"] != "r1" || first["k8s.node.name"] != nil {
+		t.Errorf("first entry fields = %v", first)
+	}
+	if second["k8s.node.name"] != "node-3" {
+		t.Errorf("fields after the change = %v", second)
+	}
+}
@@ -8,16 +8,34 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// LoggerOption configures LoggerMiddleware
+type LoggerOption func(*loggerOptions)
+
+type loggerOptions struct {
+	accessLog *AccessLogger
+}
+
+// WithAccessLog writes each finished request to the access log instead of
+// logging it as a structured entry; errors are still logged
+func WithAccessLog(accessLog *AccessLogger) LoggerOption {
+	return func(o *loggerOptions) {
+		o.accessLog = accessLog
+	}
+}
+
 // LoggerMiddleware returns a Fiber middleware for structured request logging
-func LoggerMiddleware(logger *zap.Logger) fiber.Handler {
+func LoggerMiddleware(logger *zap.Logger, opts ...LoggerOption) fiber.Handler {
+	var options loggerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
+		guardContext(c)
 
 		// Get request ID if available
-		requestID := c.Get("X-Request-ID")
-		if requestID == "" {
-			requestID = c.Locals("requestid").(string)
-		}
+		requestID := requestIDOf(c)
 
 		// Create request-scoped logger
 		reqLogger := logger.With(
@@ -40,6 +58,14 @@ func LoggerMiddleware(logger *zap.Logger) fiber.Handler {
 		// Process request
 		err := c.Next()
 
+		if options.accessLog != nil {
+			options.accessLog.Log(c, start, err)
+			if err != nil {
+				reqLogger.Error("request failed", zap.Error(err))
+			}
+			return err
+		}
+
 		// Calculate request duration
 		duration := time.Since(start)
 		status := c.Response().StatusCode()
@@ -72,8 +98,10 @@ func LoggerMiddleware(logger *zap.Logger) fiber.Handler {
 	}
 }
 
-// GetLogger retrieves the request-scoped logger from Fiber context
+// GetLogger retrieves the request-scoped logger from Fiber context. Use
+// Detach for a logger that goroutines started by the handler may keep.
 func GetLogger(c *fiber.Ctx) *zap.Logger {
+	checkContext(c, "GetLogger")
 	if logger, ok := c.Locals("logger").(*zap.Logger); ok {
 		return logger
 	}
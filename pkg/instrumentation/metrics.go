@@ -20,17 +20,51 @@ type MetricsCollector struct {
 
 	// Custom collectors
 	customCollectors []prometheus.Collector
+
+	// histograms overrides the buckets of histograms, and analyzer samples
+	// the HTTP histograms when bucket analysis is on
+	histograms histogramRules
+	analyzer   *BucketAnalyzer
 }
 
-// NewMetricsCollector creates a new metrics collector
+// NewMetricsCollector creates a new metrics collector registered with the
+// default Prometheus registerer
 func NewMetricsCollector(namespace, subsystem string) *MetricsCollector {
+	return NewMetricsCollectorFor(prometheus.DefaultRegisterer, namespace, subsystem)
+}
+
+// NewMetricsCollectorFor creates a new metrics collector registered with reg,
+// such as a test's own registry
+func NewMetricsCollectorFor(reg prometheus.Registerer, namespace, subsystem string) *MetricsCollector {
+	return newMetricsCollector(reg, namespace, subsystem, nil, nil)
+}
+
+// NewMetricsCollectorWith creates a metrics collector registered with reg,
+// with the histogram buckets of cfg and a bucket analyzer when cfg asks for
+// one
+func NewMetricsCollectorWith(reg prometheus.Registerer, cfg MetricsConfig) (*MetricsCollector, error) {
+	histograms, err := compileHistograms(cfg.Histograms)
+	if err != nil {
+		return nil, err
+	}
+	var analyzer *BucketAnalyzer
+	if cfg.AnalyzeBuckets {
+		analyzer = NewBucketAnalyzer(0)
+	}
+	return newMetricsCollector(reg, cfg.Namespace, cfg.Subsystem, histograms, analyzer), nil
+}
+
+func newMetricsCollector(reg prometheus.Registerer, namespace, subsystem string, histograms histogramRules, analyzer *BucketAnalyzer) *MetricsCollector {
 	mc := &MetricsCollector{
-		namespace: namespace,
-		subsystem: subsystem,
+		namespace:  namespace,
+		subsystem:  subsystem,
+		histograms: histograms,
+		analyzer:   analyzer,
 	}
+	factory := promauto.With(reg)
 
 	// Initialize HTTP metrics
-	mc.httpRequestsTotal = promauto.NewCounterVec(
+	mc.httpRequestsTotal = factory.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
@@ -40,36 +74,36 @@ func NewMetricsCollector(namespace, subsystem string) *MetricsCollector {
 		[]string{"method", "path", "status"},
 	)
 
-	mc.httpRequestDuration = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
+	mc.httpRequestDuration = factory.NewHistogramVec(
+		mc.histograms.apply(prometheus.HistogramOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "http_request_duration_seconds",
 			Help:      "HTTP request duration in seconds",
 			Buckets:   prometheus.DefBuckets,
-		},
+		}),
 		[]string{"method", "path", "status"},
 	)
 
-	mc.httpRequestSize = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
+	mc.httpRequestSize = factory.NewHistogramVec(
+		mc.histograms.apply(prometheus.HistogramOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "http_request_size_bytes",
 			Help:      "HTTP request size in bytes",
 			Buckets:   prometheus.ExponentialBuckets(100, 10, 7), // 100B to 100MB
-		},
+		}),
 		[]string{"method", "path"},
 	)
 
-	mc.httpResponseSize = promauto.NewHistogramVec(
-		prometheus.HistogramOpts{
+	mc.httpResponseSize = factory.NewHistogramVec(
+		mc.histograms.apply(prometheus.HistogramOpts{
 			Namespace: namespace,
 			Subsystem: subsystem,
 			Name:      "http_response_size_bytes",
 			Help:      "HTTP response size in bytes",
 			Buckets:   prometheus.ExponentialBuckets(100, 10, 7), // 100B to 100MB
-		},
+		}),
 		[]string{"method", "path"},
 	)
 
@@ -82,16 +116,32 @@ func (mc *MetricsCollector) RecordHTTPRequest(method, path string, status int, d
 
 	mc.httpRequestsTotal.WithLabelValues(method, path, statusStr).Inc()
 	mc.httpRequestDuration.WithLabelValues(method, path, statusStr).Observe(duration.Seconds())
+	mc.sample("http_request_duration_seconds", duration.Seconds())
 }
 
 // RecordHTTPRequestSize records the size of an HTTP request
 func (mc *MetricsCollector) RecordHTTPRequestSize(method, path string, size float64) {
 	mc.httpRequestSize.WithLabelValues(method, path).Observe(size)
+	mc.sample("http_request_size_bytes", size)
 }
 
 // RecordHTTPResponseSize records the size of an HTTP response
 func (mc *MetricsCollector) RecordHTTPResponseSize(method, path string, size float64) {
 	mc.httpResponseSize.WithLabelValues(method, path).Observe(size)
+	mc.sample("http_response_size_bytes", size)
+}
+
+// sample gives an observation of the named histogram to the bucket analyzer
+func (mc *MetricsCollector) sample(name string, value float64) {
+	if mc.analyzer != nil {
+		mc.analyzer.Observe(prometheus.BuildFQName(mc.namespace, mc.subsystem, name), value)
+	}
+}
+
+// BucketAnalyzer returns the analyzer sampling the HTTP histograms, nil
+// unless bucket analysis is on
+func (mc *MetricsCollector) BucketAnalyzer() *BucketAnalyzer {
+	return mc.analyzer
 }
 
 // NewCounter creates a new counter metric
@@ -126,20 +176,21 @@ func (mc *MetricsCollector) NewGauge(name, help string, labels []string) *promet
 	return gauge
 }
 
-// NewHistogram creates a new histogram metric
+// NewHistogram creates a new histogram metric. Buckets configured for it
+// in apm.yaml take precedence over buckets.
 func (mc *MetricsCollector) NewHistogram(name, help string, labels []string, buckets []float64) *prometheus.HistogramVec {
 	if buckets == nil {
 		buckets = prometheus.DefBuckets
 	}
 
 	histogram := prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
+		mc.histograms.apply(prometheus.HistogramOpts{
 			Namespace: mc.namespace,
 			Subsystem: mc.subsystem,
 			Name:      name,
 			Help:      help,
 			Buckets:   buckets,
-		},
+		}),
 		labels,
 	)
 
@@ -0,0 +1,94 @@
+package instrumentation
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// FiberMetricsHandler serves the metrics as exposed, after relabeling,
+// directly on Fiber
+func (i *Instrumentation) FiberMetricsHandler() fiber.Handler {
+	return NewFiberMetricsHandler(i.Gatherer, i.Logger)
+}
+
+// NewFiberMetricsHandler returns a Fiber handler that serves the metrics of
+// g without going through net/http. The format follows the scrape's Accept
+// header: OpenMetrics, which carries exemplars, the protobuf format, or the
+// text format by default. The response is gzipped when the scraper accepts
+// it. If gathering partly fails, the metrics that were gathered are served
+// and the error is logged.
+func NewFiberMetricsHandler(g prometheus.Gatherer, logger *zap.Logger) fiber.Handler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return func(c *fiber.Ctx) error {
+		mfs, err := g.Gather()
+		if err != nil {
+			logger.Warn("error gathering metrics", zap.Error(err))
+			if len(mfs) == 0 {
+				return fiber.NewError(fiber.StatusInternalServerError, "error gathering metrics: "+err.Error())
+			}
+		}
+
+		format := negotiateFormat(c.Get(fiber.HeaderAccept))
+		c.Set(fiber.HeaderContentType, string(format))
+		c.Vary(fiber.HeaderAccept, fiber.HeaderAcceptEncoding)
+
+		var w io.Writer = c.Response().BodyWriter()
+		var gz *gzip.Writer
+		if acceptsGzip(c) {
+			gz = gzipWriters.Get().(*gzip.Writer)
+			defer gzipWriters.Put(gz)
+			gz.Reset(w)
+			w = gz
+			c.Set(fiber.HeaderContentEncoding, "gzip")
+		}
+
+		enc := expfmt.NewEncoder(w, format)
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				return encodeFailed(c, logger, err)
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return encodeFailed(c, logger, err)
+			}
+		}
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				return encodeFailed(c, logger, err)
+			}
+		}
+		return nil
+	}
+}
+
+// negotiateFormat picks the exposition format for an Accept header
+func negotiateFormat(accept string) expfmt.Format {
+	header := make(http.Header, 1)
+	header.Set(fiber.HeaderAccept, accept)
+	return expfmt.NegotiateIncludingOpenMetrics(header)
+}
+
+// acceptsGzip reports whether the request allows a gzipped response
+func acceptsGzip(c *fiber.Ctx) bool {
+	return c.Get(fiber.HeaderAcceptEncoding) != "" && c.AcceptsEncodings("gzip") == "gzip"
+}
+
+// encodeFailed discards a partly written response
+func encodeFailed(c *fiber.Ctx, logger *zap.Logger, err error) error {
+	logger.Error("error encoding metrics", zap.Error(err))
+	c.Response().ResetBody()
+	c.Response().Header.Del(fiber.HeaderContentEncoding)
+	return fiber.NewError(fiber.StatusInternalServerError, "error encoding metrics")
+}
@@ -0,0 +1,180 @@
+package instrumentation
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/adaptor/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/valyala/fasthttp"
+)
+
+func exemplarRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "http_requests_total", Help: "Requests"})
+	reg.MustRegister(requests)
+	requests.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"})
+	return reg
+}
+
+func scrape(t *testing.T, handler fiber.Handler, accept, encoding string) ([]byte, string, string) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/metrics", handler)
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if encoding != "" {
+		req.Header.Set("Accept-Encoding", encoding)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, data)
+	}
+	return data, resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding")
+}
+
+func TestFiberMetricsHandlerNegotiation(t *testing.T) {
+	handler := NewFiberMetricsHandler(exemplarRegistry(), nil)
+
+	// Plain scrapes get the text format, which has no exemplars
+	body, contentType, encoding := scrape(t, handler, "", "")
+	if !strings.HasPrefix(contentType, "text/plain") || encoding != "" {
+		t.Errorf("content type = %q, encoding = %q", contentType, encoding)
+	}
+	if !strings.Contains(string(body), "http_requests_total 1") || strings.Contains(string(body), "trace_id") {
+		t.Errorf("text body = %s", body)
+	}
+
+	// OpenMetrics carries the exemplar and ends with EOF
+	body, contentType, _ = scrape(t, handler, "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5", "")
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("content type = %q", contentType)
+	}
+	if !strings.Contains(string(body), `http_requests_total 1.0 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 1.0`) {
+		t.Errorf("OpenMetrics body has no exemplar: %s", body)
+	}
+	if !strings.HasSuffix(string(body), "# EOF\n") {
+		t.Errorf("OpenMetrics body not terminated: %s", body)
+	}
+
+	// The protobuf format, gzipped
+	body, contentType, encoding = scrape(t, handler, "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited", "gzip, deflate")
+	if encoding != "gzip" {
+		t.Errorf("encoding = %q, want gzip", encoding)
+	}
+	var mf dto.MetricFamily
+	if err := expfmt.NewDecoder(strings.NewReader(string(body)), expfmt.Format(contentType)).Decode(&mf); err != nil {
+		t.Fatalf("decoding %q: %v", contentType, err)
+	}
+	if ex := mf.GetMetric()[0].GetCounter().GetExemplar(); ex == nil || ex.GetLabel()[0].GetValue() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("protobuf exemplar = %v", ex)
+	}
+
+	// gzip refused with q=0 is not used
+	if _, _, encoding = scrape(t, handler, "", "gzip;q=0, identity"); encoding != "" {
+		t.Errorf("encoding = %q, want none", encoding)
+	}
+}
+
+type failingGatherer struct {
+	mfs []*dto.MetricFamily
+}
+
+func (g failingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return g.mfs, errors.New("collector failed")
+}
+
+func TestFiberMetricsHandlerGatherErrors(t *testing.T) {
+	mfs, _ := exemplarRegistry().Gather()
+	body, _, _ := scrape(t, NewFiberMetricsHandler(failingGatherer{mfs: mfs}, nil), "", "")
+	if !strings.Contains(string(body), "http_requests_total") {
+		t.Errorf("partial gather not served: %s", body)
+	}
+
+	app := fiber.New()
+	app.Get("/metrics", NewFiberMetricsHandler(failingGatherer{}, nil))
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+}
+
+// BenchmarkFiberMetricsHandler scrapes 100k series in each format
+func BenchmarkFiberMetricsHandler(b *testing.B) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "http_requests_total", Help: "Requests"},
+		[]string{"method", "path", "status"})
+	reg.MustRegister(requests)
+	const series = 100000
+	for i := 0; i < series; i++ {
+		counter := requests.WithLabelValues("GET", fmt.Sprintf("/items/%d", i/5), fmt.Sprint(200+i%5))
+		counter.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"trace_id": fmt.Sprintf("%032x", i)})
+	}
+
+	app := fiber.New()
+	app.Get("/metrics", NewFiberMetricsHandler(reg, nil))
+	// The promhttp handler through the net/http adaptor, for comparison
+	app.Get("/adaptor", adaptor.HTTPHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+	serve := app.Handler()
+
+	for _, tc := range []struct {
+		name, path, accept, encoding string
+	}{
+		{"text", "/metrics", "", ""},
+		{"openmetrics", "/metrics", "application/openmetrics-text;version=1.0.0", ""},
+		{"protobuf", "/metrics", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited", ""},
+		{"openmetrics-gzip", "/metrics", "application/openmetrics-text;version=1.0.0", "gzip"},
+		{"adaptor-text", "/adaptor", "", ""},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			var size int
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				var ctx fasthttp.RequestCtx
+				ctx.Request.SetRequestURI(tc.path)
+				if tc.accept != "" {
+					ctx.Request.Header.Set("Accept", tc.accept)
+				}
+				if tc.encoding != "" {
+					ctx.Request.Header.Set("Accept-Encoding", tc.encoding)
+				}
+				serve(&ctx)
+				if ctx.Response.StatusCode() != fiber.StatusOK {
+					b.Fatalf("status = %d", ctx.Response.StatusCode())
+				}
+				size = len(ctx.Response.Body())
+			}
+			b.ReportMetric(float64(size), "bytes/scrape")
+		})
+	}
+}
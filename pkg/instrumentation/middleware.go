@@ -13,13 +13,20 @@ import (
 )
 
 // FiberOtelMiddleware creates a Fiber middleware for OpenTelemetry tracing
-func FiberOtelMiddleware(serviceName string) fiber.Handler {
+func FiberOtelMiddleware(serviceName string, opts ...MiddlewareOption) fiber.Handler {
 	tracer := otel.Tracer(serviceName)
 	propagator := otel.GetTextMapPropagator()
 
+	var options middlewareOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return func(c *fiber.Ctx) error {
+		guardContext(c)
+
 		// Extract trace context from incoming request
-		ctx := propagator.Extract(c.Context(), propagation.HeaderCarrier(c.GetReqHeaders()))
+		ctx := propagator.Extract(c.Context(), propagation.HeaderCarrier(requestHeaders(c)))
 
 		// Start span
 		spanName := fmt.Sprintf("%s %s", c.Method(), c.Path())
@@ -32,6 +39,10 @@ func FiberOtelMiddleware(serviceName string) fiber.Handler {
 		// Store context in Fiber locals
 		c.SetUserContext(ctx)
 
+		if options.traceEcho != nil {
+			options.traceEcho.apply(c, span.SpanContext())
+		}
+
 		// Process request
 		err := c.Next()
 
@@ -90,6 +101,7 @@ func PropagateContext(c *fiber.Ctx, headers map[string]string) {
 
 // GetSpanFromContext retrieves the current span from Fiber context
 func GetSpanFromContext(c *fiber.Ctx) trace.Span {
+	checkContext(c, "GetSpanFromContext")
 	ctx := c.UserContext()
 	if ctx == nil {
 		return trace.SpanFromContext(c.Context())
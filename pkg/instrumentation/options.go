@@ -0,0 +1,374 @@
+package instrumentation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chaksack/apm/pkg/netaddr"
+	"go.uber.org/zap/zapcore"
+)
+
+// Option configures New. A *Config is itself an Option that replaces the
+// configuration built so far, so New(cfg) keeps working and options can be
+// layered on top of a loaded config:
+//
+//	inst, err := instrumentation.New(
+//		instrumentation.LoadFromEnv(),
+//		instrumentation.WithService("checkout"),
+//		instrumentation.WithOTLP("otel-collector:4317"),
+//	)
+type Option interface {
+	apply(*Config)
+}
+
+type optionFunc func(*Config)
+
+func (f optionFunc) apply(c *Config) { f(c) }
+
+// apply replaces the configuration with a copy of c; a nil *Config keeps it
+func (c *Config) apply(target *Config) {
+	if c != nil {
+		*target = *c
+	}
+}
+
+// WithService sets the service name reported by logs, metrics, and traces
+func WithService(name string) Option {
+	return optionFunc(func(c *Config) {
+		c.ServiceName = name
+		c.setInitialField("service", name)
+	})
+}
+
+// WithVersion sets the service version
+func WithVersion(version string) Option {
+	return optionFunc(func(c *Config) {
+		c.Version = version
+		c.setInitialField("version", version)
+	})
+}
+
+// WithEnvironment sets the deployment environment
+func WithEnvironment(env string) Option {
+	return optionFunc(func(c *Config) {
+		c.Environment = env
+		c.setInitialField("env", env)
+	})
+}
+
+func (c *Config) setInitialField(key string, value interface{}) {
+	if c.Logging.InitialFields == nil {
+		c.Logging.InitialFields = make(map[string]interface{})
+	}
+	c.Logging.InitialFields[key] = value
+}
+
+// WithPreset resets the configuration to a built-in preset, including its
+// tracing defaults; options after it override the preset's values
+func WithPreset(name string) Option {
+	return optionFunc(func(c *Config) {
+		preset, err := PresetConfig(name)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, err)
+			return
+		}
+		preset.optionErrs = c.optionErrs
+		preset.Tracing = &TracerConfig{Preset: name}
+		*c = *preset
+	})
+}
+
+// WithOTLP enables tracing exported over OTLP/gRPC to endpoint
+func WithOTLP(endpoint string) Option {
+	return withTracing(func(t *TracerConfig) {
+		t.ExporterType = "otlp"
+		t.Endpoint = endpoint
+	})
+}
+
+// WithJaeger enables tracing exported to a Jaeger collector endpoint
+func WithJaeger(endpoint string) Option {
+	return withTracing(func(t *TracerConfig) {
+		t.ExporterType = "jaeger"
+		t.Endpoint = endpoint
+	})
+}
+
+// WithSampleRate sets the fraction of new traces sampled
+func WithSampleRate(rate float64) Option {
+	return withTracing(func(t *TracerConfig) { t.SampleRate = rate })
+}
+
+// WithTracing enables tracing with the given tracer configuration; service
+// name, version, and environment default to the instrumentation's
+func WithTracing(config TracerConfig) Option {
+	return optionFunc(func(c *Config) { c.Tracing = &config })
+}
+
+func withTracing(fn func(*TracerConfig)) Option {
+	return optionFunc(func(c *Config) {
+		if c.Tracing == nil {
+			c.Tracing = &TracerConfig{}
+		}
+		fn(c.Tracing)
+	})
+}
+
+// WithMetrics enables metrics with the given configuration
+func WithMetrics(config MetricsConfig) Option {
+	return optionFunc(func(c *Config) {
+		config.Enabled = true
+		if config.Path == "" {
+			config.Path = c.Metrics.Path
+		}
+		c.Metrics = config
+	})
+}
+
+// WithoutMetrics disables the HTTP metrics
+func WithoutMetrics() Option {
+	return optionFunc(func(c *Config) { c.Metrics.Enabled = false })
+}
+
+// WithLogLevel sets the minimum log level
+func WithLogLevel(level string) Option {
+	return optionFunc(func(c *Config) { c.Logging.Level = level })
+}
+
+// WithLogging replaces the logging configuration
+func WithLogging(config LoggingConfig) Option {
+	return optionFunc(func(c *Config) {
+		if config.InitialFields == nil {
+			config.InitialFields = c.Logging.InitialFields
+		}
+		c.Logging = config
+	})
+}
+
+// WithQuota enables telemetry quotas
+func WithQuota(config QuotaConfig) Option {
+	return optionFunc(func(c *Config) {
+		config.Enabled = true
+		c.Quota = config
+	})
+}
+
+// WithPush pushes metrics to a Pushgateway or OTLP endpoint
+func WithPush(config PushConfig) Option {
+	return optionFunc(func(c *Config) { c.Push = config })
+}
+
+// WithControlSync shares the runtime controls with the other replicas
+// through etcd or Consul
+func WithControlSync(config ControlSyncConfig) Option {
+	return optionFunc(func(c *Config) { c.ControlSync = config })
+}
+
+// WithHardwareMetrics collects GPU and host temperature metrics
+func WithHardwareMetrics(config HardwareConfig) Option {
+	return optionFunc(func(c *Config) { c.Hardware = config })
+}
+
+// WithKubernetesMetadata adds the pod's Kubernetes metadata to spans and
+// logs
+func WithKubernetesMetadata(config KubernetesConfig) Option {
+	return optionFunc(func(c *Config) {
+		config.Enabled = true
+		c.Kubernetes = config
+	})
+}
+
+// WithExporterPolicy sets what New does when a telemetry backend is
+// unreachable: ExporterPolicyFailClosed, ExporterPolicyFailOpen, or
+// ExporterPolicyDisable
+func WithExporterPolicy(policy string) Option {
+	return optionFunc(func(c *Config) { c.ExporterPolicy.Policy = policy })
+}
+
+// WithHealthChecker registers the instrumentation's own readiness checks
+// with health
+func WithHealthChecker(health *HealthChecker) Option {
+	return optionFunc(func(c *Config) { c.Health = health })
+}
+
+// buildConfig applies the options to the default configuration
+func buildConfig(opts ...Option) *Config {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(cfg)
+		}
+	}
+	return cfg
+}
+
+// Validate checks the configuration for invalid values and combinations
+// and returns every problem found, naming the environment variable that sets
+// each field
+func (c *Config) Validate() error {
+	errs := append([]error(nil), c.optionErrs...)
+	if c.presetErr != nil {
+		errs = append(errs, c.presetErr)
+	}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if strings.TrimSpace(c.ServiceName) == "" {
+		fail("service name is empty: set SERVICE_NAME or use WithService")
+	}
+
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		fail("metrics path %q must start with \"/\" (METRICS_PATH)", c.Metrics.Path)
+	}
+	if _, err := NewRelabeler(nil, c.Metrics.Relabel); err != nil {
+		fail("metrics relabeling: %v", err)
+	}
+	if _, err := compileHistograms(c.Metrics.Histograms); err != nil {
+		fail("metrics histograms: %v", err)
+	}
+
+	if _, err := zapcore.ParseLevel(c.Logging.Level); err != nil {
+		fail("log level %q is invalid (LOG_LEVEL): use debug, info, warn, or error", c.Logging.Level)
+	}
+	if c.Logging.Encoding != "json" && c.Logging.Encoding != "console" {
+		fail("log encoding %q is invalid (LOG_ENCODING): use json or console", c.Logging.Encoding)
+	}
+	if len(c.Logging.OutputPaths) == 0 {
+		fail("no log output paths (LOG_OUTPUT_PATHS): use stdout, stderr, or a file path")
+	}
+	if err := ValidateAccessLogFormat(c.Logging.AccessFormat); err != nil {
+		fail("%v (LOG_ACCESS_FORMAT)", err)
+	}
+
+	if c.Quota.Enabled {
+		if c.Quota.SpansPerMinute < 0 || c.Quota.LogMBPerMinute < 0 || c.Quota.MaxSeries < 0 {
+			fail("quota limits must not be negative (QUOTA_SPANS_PER_MINUTE, QUOTA_LOG_MB_PER_MINUTE, QUOTA_MAX_SERIES)")
+		}
+		if c.Quota.MinSampleRate < 0 || c.Quota.MinSampleRate > 1 {
+			fail("minimum sample rate %g must be between 0 and 1 (QUOTA_MIN_SAMPLE_RATE)", c.Quota.MinSampleRate)
+		}
+		if c.Quota.MaxLogLevel != "" {
+			if _, err := zapcore.ParseLevel(c.Quota.MaxLogLevel); err != nil {
+				fail("quota log level %q is invalid (QUOTA_MAX_LOG_LEVEL)", c.Quota.MaxLogLevel)
+			}
+		}
+	}
+
+	for _, target := range []struct{ env, url string }{
+		{"PUSH_GATEWAY_URL", c.Push.Gateway},
+		{"PUSH_OTLP_ENDPOINT", c.Push.OTLPEndpoint},
+	} {
+		if target.url == "" {
+			continue
+		}
+		if err := netaddr.ValidateURL(target.url); err != nil {
+			fail("push target: %v (%s)", err, target.env)
+		}
+	}
+	if c.Push.Interval < 0 || c.Push.StaleAfter < 0 {
+		fail("push interval and stale age must not be negative (PUSH_INTERVAL, PUSH_STALE_AFTER)")
+	}
+	if err := validateTemporality(c.Push.Temporality); err != nil {
+		fail("%v (PUSH_OTLP_TEMPORALITY)", err)
+	}
+	if err := validateHistogramAggregation(c.Push.HistogramAggregation); err != nil {
+		fail("%v (PUSH_OTLP_HISTOGRAM_AGGREGATION)", err)
+	}
+
+	if c.ControlSync.Enabled() {
+		switch c.ControlSync.Backend {
+		case ControlBackendEtcd, ControlBackendConsul:
+		default:
+			fail("control backend %q is not supported: use etcd or consul (CONTROLS_BACKEND)", c.ControlSync.Backend)
+		}
+		for _, endpoint := range c.ControlSync.Endpoints {
+			if err := netaddr.ValidateURL(endpoint); err != nil {
+				fail("control store: %v (CONTROLS_ENDPOINTS)", err)
+			}
+		}
+	}
+
+	switch c.Hardware.GPU {
+	case "", GPUSourceNvidiaSMI, GPUSourceDCGM:
+	default:
+		fail("GPU metrics source %q is not supported: use nvidia-smi or dcgm (GPU_METRICS_SOURCE)", c.Hardware.GPU)
+	}
+	if c.Hardware.DCGMEndpoint != "" {
+		if err := netaddr.ValidateURL(c.Hardware.DCGMEndpoint); err != nil {
+			fail("DCGM exporter: %v (GPU_DCGM_ENDPOINT)", err)
+		}
+	}
+	if c.Hardware.Interval < 0 {
+		fail("hardware metrics interval must not be negative (HARDWARE_METRICS_INTERVAL)")
+	}
+
+	if c.Kubernetes.RefreshInterval < 0 {
+		fail("kubernetes metadata refresh interval must not be negative (K8S_REFRESH_INTERVAL)")
+	}
+
+	switch c.ExporterPolicy.Policy {
+	case "", ExporterPolicyFailClosed, ExporterPolicyFailOpen, ExporterPolicyDisable:
+	default:
+		fail("exporter policy %q is not supported: use fail-closed, fail-open, or disable (APM_EXPORTER_POLICY)", c.ExporterPolicy.Policy)
+	}
+	if c.ExporterPolicy.Timeout < 0 {
+		fail("exporter check timeout must not be negative (APM_EXPORTER_CHECK_TIMEOUT)")
+	}
+
+	if c.Tracing != nil {
+		tracing := *c.Tracing
+		if err := tracing.applyPreset(); err != nil {
+			fail("tracing: %v (APM_PRESET)", err)
+		}
+		switch tracing.ExporterType {
+		case "otlp", "jaeger":
+			if tracing.Endpoint == "" {
+				fail("tracing exporter %s has no endpoint: use WithOTLP or WithJaeger", tracing.ExporterType)
+			} else if err := validateExporterEndpoint(tracing.ExporterType, tracing.Endpoint); err != nil {
+				env := "OTEL_EXPORTER_OTLP_ENDPOINT"
+				if tracing.ExporterType == "jaeger" {
+					env = "OTEL_EXPORTER_JAEGER_ENDPOINT"
+				}
+				fail("tracing: %v (%s)", err, env)
+			}
+		case "":
+			fail("tracing has no exporter: use WithOTLP, WithJaeger, or a preset")
+		default:
+			fail("tracing exporter %q is not supported: use otlp or jaeger", tracing.ExporterType)
+		}
+		if tracing.SampleRate < 0 || tracing.SampleRate > 1 {
+			fail("sample rate %g must be between 0 and 1 (OTEL_TRACES_SAMPLER_ARG)", tracing.SampleRate)
+		}
+		if _, err := newSampler(tracing.Sampler, tracing.SampleRate); err != nil {
+			fail("tracing: %v (OTEL_TRACES_SAMPLER)", err)
+		}
+		switch tracing.Protocol {
+		case "", ProtocolGRPC, ProtocolHTTPProtobuf:
+		default:
+			fail("OTLP protocol %q is not supported: use grpc or http/protobuf (OTEL_EXPORTER_OTLP_PROTOCOL)", tracing.Protocol)
+		}
+		if err := validateCompression(tracing.Compression); err != nil {
+			fail("tracing: %v (OTEL_EXPORTER_OTLP_COMPRESSION)", err)
+		}
+		if tracing.MaxPayloadBytes < 0 {
+			fail("maximum payload size must not be negative (APM_OTLP_MAX_PAYLOAD_BYTES)")
+		}
+		if tracing.Shards != nil {
+			if err := tracing.Shards.validate(); err != nil {
+				fail("tracing: %v (APM_AGENT_SHARDS)", err)
+			}
+		}
+		if tracing.Semconv.Version != "" {
+			if _, err := tracing.Semconv.stable(); err != nil {
+				fail("tracing: %v (SEMCONV_VERSION)", err)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid instrumentation config: %w", errors.Join(errs...))
+}
@@ -0,0 +1,93 @@
+package instrumentation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	base := DefaultConfig()
+	base.Metrics.Namespace = "shop"
+
+	cfg := buildConfig(
+		base,
+		WithService("checkout"),
+		WithVersion("1.4.2"),
+		WithOTLP("otel-collector:4317"),
+		WithSampleRate(0.2),
+		WithLogLevel("debug"),
+	)
+	if cfg.ServiceName != "checkout" || cfg.Logging.InitialFields["service"] != "checkout" || cfg.Version != "1.4.2" {
+		t.Errorf("unexpected service fields %+v", cfg)
+	}
+	if cfg.Metrics.Namespace != "shop" {
+		t.Error("expected the *Config option to be the base of later options")
+	}
+	if cfg.Tracing == nil || cfg.Tracing.ExporterType != "otlp" || cfg.Tracing.Endpoint != "otel-collector:4317" || cfg.Tracing.SampleRate != 0.2 {
+		t.Errorf("unexpected tracing config %+v", cfg.Tracing)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	cfg = buildConfig(WithPreset("staging"), WithService("worker"))
+	if cfg.Logging.Level != "info" || cfg.Tracing == nil || cfg.Tracing.Preset != "staging" {
+		t.Errorf("expected staging defaults, got %+v", cfg.Logging)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected the preset to be valid, got %v", err)
+	}
+
+	if cfg := buildConfig((*Config)(nil)); cfg.ServiceName == "" {
+		t.Error("expected a nil *Config to keep the defaults")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := buildConfig(
+		WithService(" "),
+		WithPreset("qa"),
+		WithMetrics(MetricsConfig{Path: "metrics"}),
+		WithLogLevel("verbose"),
+		WithJaeger(""),
+		WithSampleRate(2),
+		WithPush(PushConfig{Gateway: "pushgateway:9091"}),
+	)
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"service name is empty",
+		`unknown preset "qa"`,
+		"METRICS_PATH",
+		"LOG_LEVEL",
+		"jaeger has no endpoint",
+		"sample rate 2",
+		"PUSH_GATEWAY_URL",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%v", want, err)
+		}
+	}
+
+	for endpoint, want := range map[string]string{
+		"fd00::1:4317":   "write [fd00::1]:4317",
+		"collector:otlp": "between 1 and 65535",
+		"[fd00::1]:4317": "",
+		"otel-collector": "",
+		"10.0.0.12:4317": "",
+	} {
+		err := buildConfig(WithOTLP(endpoint)).Validate()
+		if want == "" && err != nil {
+			t.Errorf("WithOTLP(%q): %v", endpoint, err)
+		}
+		if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("WithOTLP(%q) error = %v, want %q", endpoint, err, want)
+		}
+	}
+
+	if _, err := New(WithLogLevel("loud")); err == nil || !strings.Contains(err.Error(), "LOG_LEVEL") {
+		t.Errorf("expected New to reject an invalid config, got %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package instrumentation
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/netaddr"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Samplers named by OTEL_TRACES_SAMPLER
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// OTLP protocols named by OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	ProtocolGRPC         = "grpc"
+	ProtocolHTTPProtobuf = "http/protobuf"
+)
+
+// newSampler builds the sampler named by an OTEL_TRACES_SAMPLER value; an
+// empty name samples SampleRate of new traces regardless of the parent
+func newSampler(name string, rate float64) (sdktrace.Sampler, error) {
+	switch name {
+	case "", SamplerTraceIDRatio:
+		return sdktrace.TraceIDRatioBased(rate), nil
+	case SamplerAlwaysOn:
+		return sdktrace.AlwaysSample(), nil
+	case SamplerAlwaysOff:
+		return sdktrace.NeverSample(), nil
+	case SamplerParentBasedAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case SamplerParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case SamplerParentBasedTraceIDRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(rate)), nil
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", name)
+	}
+}
+
+// applyOTelEnv applies the standard OpenTelemetry SDK environment variables,
+// which take precedence over SERVICE_NAME, ENVIRONMENT, VERSION, and
+// APM_PRESET. Invalid values are reported by Validate.
+//
+// Tracing is enabled when OTEL_TRACES_EXPORTER is otlp or jaeger, or when an
+// OTLP endpoint or APM_AGENT_SHARDS is set and OTEL_TRACES_EXPORTER is not.
+// OTEL_SDK_DISABLED or OTEL_TRACES_EXPORTER=none disable it.
+func applyOTelEnv(cfg *Config) {
+	fail := func(format string, args ...interface{}) {
+		cfg.optionErrs = append(cfg.optionErrs, fmt.Errorf(format, args...))
+	}
+
+	// service.* and deployment.environment attributes map onto the config;
+	// the SDK adds the rest to the trace resource itself
+	attrs, err := parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		fail("OTEL_RESOURCE_ATTRIBUTES: %v", err)
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attrs["service.name"] = name
+	}
+	if name, ok := attrs["service.name"]; ok {
+		cfg.ServiceName = name
+		cfg.setInitialField("service", name)
+		delete(attrs, "service.name")
+	}
+	if version, ok := attrs["service.version"]; ok {
+		cfg.Version = version
+		cfg.setInitialField("version", version)
+		delete(attrs, "service.version")
+	}
+	for _, key := range []string{"deployment.environment", "deployment.environment.name"} {
+		if env, ok := attrs[key]; ok {
+			cfg.Environment = env
+			cfg.setInitialField("env", env)
+			delete(attrs, key)
+		}
+	}
+
+	if parseBool(getEnv("OTEL_SDK_DISABLED", "false")) {
+		cfg.Tracing = nil
+		return
+	}
+
+	endpoint := firstEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT")
+	exporter := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER")))
+	switch exporter {
+	case "none":
+		cfg.Tracing = nil
+		return
+	case "":
+		if endpoint == "" && os.Getenv("APM_AGENT_SHARDS") == "" {
+			return
+		}
+		exporter = "otlp"
+	case "otlp", "jaeger":
+	default:
+		fail("OTEL_TRACES_EXPORTER %q is not supported: use otlp, jaeger, or none", exporter)
+		return
+	}
+
+	if cfg.Tracing == nil {
+		cfg.Tracing = &TracerConfig{}
+	}
+	t := cfg.Tracing
+	t.ExporterType = exporter
+
+	if exporter == "jaeger" {
+		t.Endpoint = getEnv("OTEL_EXPORTER_JAEGER_ENDPOINT", "http://localhost:14268/api/traces")
+	} else {
+		applyOTLPEnv(t, endpoint, fail)
+		if shards := ShardConfigFromEnv(); shards != nil {
+			t.Shards = shards
+		}
+	}
+
+	// Sampling; the SDK default is parentbased_always_on unless a preset
+	// supplies a sample rate
+	t.Sampler = strings.ToLower(os.Getenv("OTEL_TRACES_SAMPLER"))
+	if t.Sampler == "" && os.Getenv("APM_PRESET") == "" && t.Preset == "" {
+		t.Sampler = SamplerParentBasedAlwaysOn
+	}
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		rate, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			fail("OTEL_TRACES_SAMPLER_ARG %q is not a number", arg)
+		}
+		t.SampleRate = rate
+	} else if strings.HasSuffix(t.Sampler, SamplerTraceIDRatio) && t.SampleRate == 0 && os.Getenv("APM_PRESET") == "" {
+		t.SampleRate = 1
+	}
+
+	// Batch span processor
+	if delay, ok := envMillis("OTEL_BSP_SCHEDULE_DELAY", fail); ok {
+		t.BatchTimeout = delay
+	}
+	t.MaxQueueSize = getEnvInt("OTEL_BSP_MAX_QUEUE_SIZE", t.MaxQueueSize)
+	t.MaxExportBatch = getEnvInt("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", t.MaxExportBatch)
+}
+
+// applyOTLPEnv applies the OTEL_EXPORTER_OTLP_* variables; the TRACES_
+// variants take precedence over the general ones
+func applyOTLPEnv(t *TracerConfig, endpoint string, fail func(string, ...interface{})) {
+	t.Protocol = strings.ToLower(firstEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"))
+	if t.Protocol == "" {
+		t.Protocol = ProtocolGRPC
+	}
+
+	if endpoint == "" {
+		endpoint = "http://localhost:4317"
+		if t.Protocol == ProtocolHTTPProtobuf {
+			endpoint = "http://localhost:4318"
+		}
+	}
+	signalSpecific := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	hostPort, path, tls, err := parseOTLPEndpoint(endpoint, signalSpecific)
+	if err != nil {
+		fail("OTLP endpoint: %v", err)
+	}
+	t.Endpoint, t.TLS = hostPort, tls
+	if t.Protocol == ProtocolHTTPProtobuf {
+		t.URLPath = path
+	}
+	if insecure := firstEnv("OTEL_EXPORTER_OTLP_TRACES_INSECURE", "OTEL_EXPORTER_OTLP_INSECURE"); insecure != "" {
+		t.TLS = !parseBool(insecure)
+	}
+
+	headers, err := parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		fail("OTEL_EXPORTER_OTLP_HEADERS: %v", err)
+	}
+	traceHeaders, err := parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))
+	if err != nil {
+		fail("OTEL_EXPORTER_OTLP_TRACES_HEADERS: %v", err)
+	}
+	for k, v := range traceHeaders {
+		headers[k] = v
+	}
+	if len(headers) > 0 {
+		t.Headers = headers
+	}
+
+	if compression := firstEnv("OTEL_EXPORTER_OTLP_TRACES_COMPRESSION", "OTEL_EXPORTER_OTLP_COMPRESSION"); compression != "" {
+		t.Compression = strings.ToLower(compression)
+	}
+	if max := os.Getenv("APM_OTLP_MAX_PAYLOAD_BYTES"); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n < 0 {
+			fail("APM_OTLP_MAX_PAYLOAD_BYTES %q is not a byte count", max)
+		}
+		t.MaxPayloadBytes = n
+	}
+
+	if timeout, ok := envMillis("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT", fail); ok {
+		t.Timeout = timeout
+	} else if timeout, ok := envMillis("OTEL_EXPORTER_OTLP_TIMEOUT", fail); ok {
+		t.Timeout = timeout
+	}
+}
+
+// parseOTLPEndpoint splits an OTLP endpoint URL into host:port, the HTTP
+// path of the traces signal, and whether to use TLS. A general endpoint
+// gets /v1/traces appended; a signal-specific one is used as is. An endpoint
+// without a scheme is taken as a plaintext host:port.
+func parseOTLPEndpoint(raw string, signalSpecific bool) (hostPort, path string, tls bool, err error) {
+	if !strings.Contains(raw, "://") {
+		return raw, "/v1/traces", false, nil
+	}
+	if err := netaddr.ValidateURL(raw); err != nil {
+		return "", "", false, err
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", false, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", false, fmt.Errorf("%q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return "", "", false, fmt.Errorf("%q has no host", raw)
+	}
+
+	path = u.Path
+	if !signalSpecific {
+		path = strings.TrimSuffix(path, "/") + "/v1/traces"
+	} else if path == "" {
+		path = "/"
+	}
+	return u.Host, path, u.Scheme == "https", nil
+}
+
+// parseKeyValues parses a comma-separated list of percent-encoded key=value
+// pairs, the format of OTEL_RESOURCE_ATTRIBUTES and OTEL_EXPORTER_OTLP_HEADERS
+func parseKeyValues(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return out, fmt.Errorf("%q is not key=value", strings.TrimSpace(pair))
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return out, fmt.Errorf("value of %s: %w", key, err)
+		}
+		out[key] = decoded
+	}
+	return out, nil
+}
+
+// envMillis reads a duration in milliseconds, the unit of the OTEL_*
+// timeout and delay variables
+func envMillis(key string, fail func(string, ...interface{})) (time.Duration, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		fail("%s %q is not a number of milliseconds", key, value)
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// firstEnv returns the first of keys that is set to a non-empty value
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
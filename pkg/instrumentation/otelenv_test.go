@@ -0,0 +1,130 @@
+package instrumentation
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFromEnvOTelPrecedence(t *testing.T) {
+	t.Setenv("SERVICE_NAME", "from-apm")
+	t.Setenv("ENVIRONMENT", "staging")
+	t.Setenv("VERSION", "1.0.0")
+	t.Setenv("OTEL_SERVICE_NAME", "checkout")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "service.name=ignored,service.version=2.1.0,deployment.environment=prod,team=payments")
+
+	cfg := LoadFromEnv()
+	if cfg.ServiceName != "checkout" || cfg.Logging.InitialFields["service"] != "checkout" {
+		t.Errorf("service = %q, want OTEL_SERVICE_NAME to win", cfg.ServiceName)
+	}
+	if cfg.Version != "2.1.0" || cfg.Environment != "prod" || cfg.Logging.InitialFields["env"] != "prod" {
+		t.Errorf("version, environment = %q, %q, want the resource attributes", cfg.Version, cfg.Environment)
+	}
+	if cfg.Tracing != nil {
+		t.Errorf("tracing = %+v, want none without an exporter", cfg.Tracing)
+	}
+}
+
+func TestLoadFromEnvOTLP(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "https://collector.example.com:4318/otlp/")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=general,x-tenant=a%20b")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS", "api-key=traces")
+	t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "2500")
+	t.Setenv("OTEL_TRACES_SAMPLER", "parentbased_traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "1000")
+	t.Setenv("OTEL_BSP_MAX_QUEUE_SIZE", "4096")
+
+	cfg := LoadFromEnv()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tr := cfg.Tracing
+	if tr == nil {
+		t.Fatal("expected tracing to be enabled by the OTLP endpoint")
+	}
+	if tr.ExporterType != "otlp" || tr.Protocol != ProtocolHTTPProtobuf || tr.Endpoint != "collector.example.com:4318" || tr.URLPath != "/otlp/v1/traces" || !tr.TLS {
+		t.Errorf("exporter = %+v", tr)
+	}
+	if tr.Headers["api-key"] != "traces" || tr.Headers["x-tenant"] != "a b" {
+		t.Errorf("headers = %v, want trace headers to win and values decoded", tr.Headers)
+	}
+	if tr.Timeout != 2500*time.Millisecond || tr.BatchTimeout != time.Second || tr.MaxQueueSize != 4096 {
+		t.Errorf("timeouts = %+v", tr)
+	}
+	if tr.Sampler != SamplerParentBasedTraceIDRatio || tr.SampleRate != 0.25 {
+		t.Errorf("sampler = %s(%g)", tr.Sampler, tr.SampleRate)
+	}
+}
+
+func TestLoadFromEnvOTLPDefaults(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://collector:4318/custom/traces")
+
+	cfg := LoadFromEnv()
+	tr := cfg.Tracing
+	if tr == nil || tr.Protocol != ProtocolGRPC || tr.Endpoint != "collector:4318" || tr.TLS {
+		t.Fatalf("tracing = %+v", tr)
+	}
+	if tr.Sampler != SamplerParentBasedAlwaysOn {
+		t.Errorf("sampler = %q, want the SDK default", tr.Sampler)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	// A signal-specific endpoint keeps its path
+	_, path, _, _ := parseOTLPEndpoint("http://collector:4318/custom/traces", true)
+	if path != "/custom/traces" {
+		t.Errorf("path = %q", path)
+	}
+}
+
+func TestLoadFromEnvOTLPIPv6(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://[fd00::4317]:4317")
+
+	cfg := LoadFromEnv()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if tr := cfg.Tracing; tr == nil || tr.Endpoint != "[fd00::4317]:4317" {
+		t.Fatalf("tracing = %+v", tr)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://fd00::1:4317")
+	err := LoadFromEnv().Validate()
+	if err == nil || !strings.Contains(err.Error(), "write [fd00::1]:4317") {
+		t.Errorf("err = %v, want the bracketed form suggested", err)
+	}
+}
+
+func TestLoadFromEnvOTelDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	for _, env := range [][2]string{{"OTEL_SDK_DISABLED", "true"}, {"OTEL_TRACES_EXPORTER", "none"}} {
+		t.Run(env[0], func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			if cfg := LoadFromEnv(); cfg.Tracing != nil {
+				t.Errorf("tracing = %+v, want disabled", cfg.Tracing)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnvOTelInvalid(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	t.Setenv("OTEL_TRACES_SAMPLER", "sometimes")
+	t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "5s")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "novalue")
+
+	err := LoadFromEnv().Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_TRACES_SAMPLER", "OTEL_EXPORTER_OTLP_TIMEOUT", "OTEL_RESOURCE_ATTRIBUTES"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package instrumentation
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Built-in presets
+const (
+	PresetLocal      = "local"
+	PresetStaging    = "staging"
+	PresetProduction = "production"
+)
+
+// Preset holds the defaults of one environment. TracerConfig and
+// DefaultConfig fall back to it for every field that is not set explicitly.
+type Preset struct {
+	Name string
+
+	// Tracing
+	SampleRate     float64
+	ExporterType   string
+	Endpoint       string
+	BatchTimeout   time.Duration
+	MaxExportBatch int
+	MaxQueueSize   int
+
+	// Logging
+	LogLevel       string
+	LogEncoding    string
+	LogDevelopment bool
+
+	// ExporterPolicy applies when a telemetry backend is unreachable at
+	// startup
+	ExporterPolicy string
+}
+
+var presets = map[string]Preset{
+	PresetLocal: {
+		Name:           PresetLocal,
+		SampleRate:     1,
+		ExporterType:   "otlp",
+		Endpoint:       "localhost:4317",
+		BatchTimeout:   time.Second,
+		MaxExportBatch: 128,
+		MaxQueueSize:   2048,
+		LogLevel:       "debug",
+		LogEncoding:    "console",
+		LogDevelopment: true,
+		ExporterPolicy: ExporterPolicyDisable,
+	},
+	PresetStaging: {
+		Name:           PresetStaging,
+		SampleRate:     0.5,
+		ExporterType:   "otlp",
+		Endpoint:       "otel-collector:4317",
+		BatchTimeout:   5 * time.Second,
+		MaxExportBatch: 512,
+		MaxQueueSize:   2048,
+		LogLevel:       "info",
+		LogEncoding:    "json",
+		ExporterPolicy: ExporterPolicyFailOpen,
+	},
+	PresetProduction: {
+		Name:           PresetProduction,
+		SampleRate:     0.1,
+		ExporterType:   "otlp",
+		Endpoint:       "otel-collector:4317",
+		BatchTimeout:   5 * time.Second,
+		MaxExportBatch: 512,
+		MaxQueueSize:   8192,
+		LogLevel:       "warn",
+		LogEncoding:    "json",
+		ExporterPolicy: ExporterPolicyFailOpen,
+	},
+}
+
+// LookupPreset returns the built-in preset with the given name
+func LookupPreset(name string) (Preset, error) {
+	preset, ok := presets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := make([]string, 0, len(presets))
+		for n := range presets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Preset{}, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return preset, nil
+}
+
+// presetFromEnv returns the preset named by APM_PRESET, or the zero preset
+// when it is unset. An unknown name gives the zero preset and an error.
+func presetFromEnv() (Preset, error) {
+	name := os.Getenv("APM_PRESET")
+	if name == "" {
+		return Preset{}, nil
+	}
+	preset, err := LookupPreset(name)
+	if err != nil {
+		return Preset{}, fmt.Errorf("%w (APM_PRESET)", err)
+	}
+	return preset, nil
+}
+
+// applyPreset fills the zero fields of the tracer config from its preset,
+// or from APM_PRESET when Preset is empty
+func (c *TracerConfig) applyPreset() error {
+	name := c.Preset
+	if name == "" {
+		name = os.Getenv("APM_PRESET")
+	}
+	if name == "" {
+		return nil
+	}
+	preset, err := LookupPreset(name)
+	if err != nil {
+		return err
+	}
+
+	if c.Environment == "" {
+		c.Environment = preset.Name
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = preset.SampleRate
+	}
+	if c.ExporterType == "" {
+		c.ExporterType = preset.ExporterType
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = preset.Endpoint
+	}
+	if c.BatchTimeout == 0 {
+		c.BatchTimeout = preset.BatchTimeout
+	}
+	if c.MaxExportBatch == 0 {
+		c.MaxExportBatch = preset.MaxExportBatch
+	}
+	if c.MaxQueueSize == 0 {
+		c.MaxQueueSize = preset.MaxQueueSize
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package instrumentation
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracerConfigPreset(t *testing.T) {
+	config := TracerConfig{Preset: "production", ServiceName: "checkout", Endpoint: "collector.internal:4317"}
+	if err := config.applyPreset(); err != nil {
+		t.Fatal(err)
+	}
+	if config.SampleRate != 0.1 || config.ExporterType != "otlp" || config.MaxQueueSize != 8192 || config.BatchTimeout != 5*time.Second {
+		t.Errorf("expected production defaults, got %+v", config)
+	}
+	if config.Endpoint != "collector.internal:4317" || config.Environment != "production" {
+		t.Errorf("expected explicit fields to be kept, got %+v", config)
+	}
+
+	t.Setenv("APM_PRESET", "local")
+	config = TracerConfig{SampleRate: 0.25}
+	if err := config.applyPreset(); err != nil {
+		t.Fatal(err)
+	}
+	if config.SampleRate != 0.25 || config.Endpoint != "localhost:4317" {
+		t.Errorf("expected the APM_PRESET defaults under explicit fields, got %+v", config)
+	}
+
+	config = TracerConfig{Preset: "qa"}
+	if err := config.applyPreset(); err == nil {
+		t.Error("expected an unknown preset to be rejected")
+	}
+}
+
+func TestUnknownPresetFromEnv(t *testing.T) {
+	t.Setenv("APM_PRESET", "prodution")
+	err := DefaultConfig().Validate()
+	if err == nil || !strings.Contains(err.Error(), `unknown preset "prodution"`) || !strings.Contains(err.Error(), "APM_PRESET") {
+		t.Errorf("Validate() = %v, want the unknown APM_PRESET reported", err)
+	}
+	if _, err := New(); err == nil {
+		t.Error("New accepted an unknown APM_PRESET")
+	}
+
+	// An explicit preset replaces the one from the environment
+	if err := buildConfig(WithPreset("production")).Validate(); err != nil {
+		t.Errorf("WithPreset: %v", err)
+	}
+}
+
+func TestPresetConfig(t *testing.T) {
+	t.Setenv("LOG_ENCODING", "console")
+	config, err := PresetConfig("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Logging.Level != "info" || config.Environment != "staging" {
+		t.Errorf("expected staging defaults, got %+v", config.Logging)
+	}
+	if config.Logging.Encoding != "console" {
+		t.Errorf("expected the environment to override the preset, got %q", config.Logging.Encoding)
+	}
+}
@@ -0,0 +1,318 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propagation formats accepted by PropagationConfig
+const (
+	PropagationTraceContext = "tracecontext"
+	PropagationB3           = "b3"      // single b3 header
+	PropagationB3Multi      = "b3multi" // X-B3-* headers
+	PropagationJaeger       = "jaeger"  // uber-trace-id header
+)
+
+// PropagationConfig selects the trace context formats read from inbound
+// requests and written to outbound ones. W3C baggage is always propagated.
+type PropagationConfig struct {
+	// Extract is the ordered extraction chain; the first format present
+	// on a request wins
+	Extract []string
+
+	// Inject lists the formats written to outbound requests
+	Inject []string
+
+	// Negotiate also writes the format a request arrived in, so replies to
+	// a Zipkin or Jaeger caller go out in a format it reads
+	Negotiate bool
+}
+
+// DefaultPropagationConfig accepts every format and writes W3C trace context
+// plus the caller's format
+func DefaultPropagationConfig() PropagationConfig {
+	return PropagationConfig{
+		Extract:   []string{PropagationTraceContext, PropagationB3, PropagationB3Multi, PropagationJaeger},
+		Inject:    []string{PropagationTraceContext},
+		Negotiate: true,
+	}
+}
+
+// PropagationConfigFromEnv reads PROPAGATORS_EXTRACT, PROPAGATORS_INJECT, and
+// PROPAGATORS_NEGOTIATE. OTEL_PROPAGATORS sets both lists when they are
+// unset; "baggage" entries in it are ignored as baggage is always on.
+func PropagationConfigFromEnv() PropagationConfig {
+	config := DefaultPropagationConfig()
+	if otelPropagators := getEnvSlice("OTEL_PROPAGATORS", nil); otelPropagators != nil {
+		config.Extract, config.Inject = otelPropagators, otelPropagators
+	}
+	config.Extract = getEnvSlice("PROPAGATORS_EXTRACT", config.Extract)
+	config.Inject = getEnvSlice("PROPAGATORS_INJECT", config.Inject)
+	config.Negotiate = getEnvBool("PROPAGATORS_NEGOTIATE", config.Negotiate)
+	return config
+}
+
+// NewPropagator builds the propagator for the config
+func NewPropagator(config PropagationConfig) (propagation.TextMapPropagator, error) {
+	chain := &propagatorChain{negotiate: config.Negotiate}
+	var err error
+	if chain.extract, err = propagatorsFor(config.Extract); err != nil {
+		return nil, err
+	}
+	if chain.inject, err = propagatorsFor(config.Inject); err != nil {
+		return nil, err
+	}
+	if len(chain.extract) == 0 && len(chain.inject) == 0 {
+		return nil, fmt.Errorf("no trace context propagation formats configured")
+	}
+	return propagation.NewCompositeTextMapPropagator(chain, propagation.Baggage{}), nil
+}
+
+type namedPropagator struct {
+	name string
+	propagation.TextMapPropagator
+}
+
+func propagatorsFor(names []string) ([]namedPropagator, error) {
+	var out []namedPropagator
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		var p propagation.TextMapPropagator
+		switch name {
+		case PropagationTraceContext:
+			p = propagation.TraceContext{}
+		case PropagationB3:
+			p = B3Propagator{}
+		case PropagationB3Multi:
+			p = B3Propagator{Multi: true}
+		case PropagationJaeger:
+			p = JaegerPropagator{}
+		case "baggage", "none", "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown propagation format %q", name)
+		}
+		out = append(out, namedPropagator{name: name, TextMapPropagator: p})
+	}
+	return out, nil
+}
+
+type inboundFormatKey struct{}
+
+// propagatorChain extracts with the first format present and injects every
+// configured format, plus the inbound one when negotiating
+type propagatorChain struct {
+	extract   []namedPropagator
+	inject    []namedPropagator
+	negotiate bool
+}
+
+// Inject implements propagation.TextMapPropagator
+func (p *propagatorChain) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	injected := make(map[string]bool, len(p.inject)+1)
+	for _, prop := range p.inject {
+		prop.Inject(ctx, carrier)
+		injected[prop.name] = true
+	}
+	if !p.negotiate {
+		return
+	}
+	if name, ok := ctx.Value(inboundFormatKey{}).(string); ok && !injected[name] {
+		for _, prop := range p.extract {
+			if prop.name == name {
+				prop.Inject(ctx, carrier)
+			}
+		}
+	}
+}
+
+// Extract implements propagation.TextMapPropagator
+func (p *propagatorChain) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	for _, prop := range p.extract {
+		extracted := prop.Extract(ctx, carrier)
+		if extracted != ctx && trace.SpanContextFromContext(extracted).IsValid() {
+			return context.WithValue(extracted, inboundFormatKey{}, prop.name)
+		}
+	}
+	return ctx
+}
+
+// Fields implements propagation.TextMapPropagator
+func (p *propagatorChain) Fields() []string {
+	seen := map[string]bool{}
+	var fields []string
+	for _, list := range [][]namedPropagator{p.extract, p.inject} {
+		for _, prop := range list {
+			for _, f := range prop.Fields() {
+				if !seen[f] {
+					seen[f] = true
+					fields = append(fields, f)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// InboundPropagationFormat returns the format the request's trace context was
+// extracted from, or "" for a new trace
+func InboundPropagationFormat(ctx context.Context) string {
+	name, _ := ctx.Value(inboundFormatKey{}).(string)
+	return name
+}
+
+// B3 headers
+const (
+	b3Single  = "b3"
+	b3TraceID = "x-b3-traceid"
+	b3SpanID  = "x-b3-spanid"
+	b3Sampled = "x-b3-sampled"
+	b3Flags   = "x-b3-flags"
+	b3Parent  = "x-b3-parentspanid"
+)
+
+// B3Propagator reads and writes Zipkin B3 headers, as used by Istio and
+// Envoy: the single b3 header, or the X-B3-* headers when Multi is set
+type B3Propagator struct {
+	Multi bool
+}
+
+// Inject implements propagation.TextMapPropagator
+func (b B3Propagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	if b.Multi {
+		carrier.Set(b3TraceID, formatTraceID(sc.TraceID()))
+		carrier.Set(b3SpanID, sc.SpanID().String())
+		carrier.Set(b3Sampled, sampled)
+		return
+	}
+	carrier.Set(b3Single, formatTraceID(sc.TraceID())+"-"+sc.SpanID().String()+"-"+sampled)
+}
+
+// Extract implements propagation.TextMapPropagator
+func (b B3Propagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	var traceID, spanID, sampled string
+	if b.Multi {
+		traceID, spanID, sampled = carrier.Get(b3TraceID), carrier.Get(b3SpanID), carrier.Get(b3Sampled)
+		if carrier.Get(b3Flags) == "1" {
+			sampled = "d"
+		}
+	} else {
+		parts := strings.Split(carrier.Get(b3Single), "-")
+		if len(parts) < 2 {
+			return ctx // absent, or a lone sampling decision without context
+		}
+		traceID, spanID = parts[0], parts[1]
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+	}
+	if traceID == "" || spanID == "" {
+		return ctx
+	}
+
+	var flags trace.TraceFlags
+	switch strings.ToLower(sampled) {
+	case "1", "true", "d":
+		flags = trace.FlagsSampled
+	}
+	sc, ok := remoteSpanContext(traceID, spanID, flags)
+	if !ok {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields implements propagation.TextMapPropagator
+func (b B3Propagator) Fields() []string {
+	if b.Multi {
+		return []string{b3TraceID, b3SpanID, b3Sampled, b3Flags, b3Parent}
+	}
+	return []string{b3Single}
+}
+
+const jaegerHeader = "uber-trace-id"
+
+// JaegerPropagator reads and writes the Jaeger client uber-trace-id header
+type JaegerPropagator struct{}
+
+// Inject implements propagation.TextMapPropagator
+func (JaegerPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := "0"
+	if sc.IsSampled() {
+		flags = "1"
+	}
+	carrier.Set(jaegerHeader, formatTraceID(sc.TraceID())+":"+sc.SpanID().String()+":0:"+flags)
+}
+
+// Extract implements propagation.TextMapPropagator
+func (JaegerPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	// Proxies may URL-encode the colons
+	header := strings.ReplaceAll(carrier.Get(jaegerHeader), "%3A", ":")
+	parts := strings.Split(header, ":")
+	if len(parts) != 4 {
+		return ctx
+	}
+	var flags trace.TraceFlags
+	if bits, err := strconv.ParseUint(parts[3], 16, 8); err == nil && bits&1 == 1 {
+		flags = trace.FlagsSampled
+	}
+	sc, ok := remoteSpanContext(parts[0], parts[1], flags)
+	if !ok {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields implements propagation.TextMapPropagator
+func (JaegerPropagator) Fields() []string {
+	return []string{jaegerHeader}
+}
+
+// remoteSpanContext parses hex IDs, left-padding 64-bit and shorter trace
+// IDs and span IDs to their full length
+func remoteSpanContext(traceID, spanID string, flags trace.TraceFlags) (trace.SpanContext, bool) {
+	if len(traceID) > 32 || len(spanID) > 16 {
+		return trace.SpanContext{}, false
+	}
+	tid, err := trace.TraceIDFromHex(strings.Repeat("0", 32-len(traceID)) + strings.ToLower(traceID))
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	sid, err := trace.SpanIDFromHex(strings.Repeat("0", 16-len(spanID)) + strings.ToLower(spanID))
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: flags,
+		Remote:     true,
+	}), true
+}
+
+// formatTraceID writes 64-bit trace IDs in their short form, which legacy
+// Zipkin and Jaeger deployments require
+func formatTraceID(tid trace.TraceID) string {
+	s := tid.String()
+	if strings.HasPrefix(s, "0000000000000000") {
+		return s[16:]
+	}
+	return s
+}
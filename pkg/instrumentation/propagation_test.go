@@ -0,0 +1,127 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagatorExtractionChain(t *testing.T) {
+	propagator, err := NewPropagator(DefaultPropagationConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		format  string
+		traceID string
+		sampled bool
+	}{
+		{
+			name:    "b3 single",
+			headers: map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"},
+			format:  PropagationB3,
+			traceID: "80f198ee56343ba864fe8b2a57d3eff7",
+			sampled: true,
+		},
+		{
+			name:    "b3 multi with a 64-bit trace ID",
+			headers: map[string]string{"X-B3-Traceid": "a3ce929d0e0e4736", "X-B3-Spanid": "00f067aa0ba902b7", "X-B3-Sampled": "0"},
+			format:  PropagationB3Multi,
+			traceID: "0000000000000000a3ce929d0e0e4736",
+		},
+		{
+			name:    "jaeger",
+			headers: map[string]string{"Uber-Trace-Id": "4bf92f3577b34da6a3ce929d0e0e4736%3A00f067aa0ba902b7%3A0%3A3"},
+			format:  PropagationJaeger,
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			sampled: true,
+		},
+		{
+			name: "tracecontext wins over b3",
+			headers: map[string]string{
+				"Traceparent": "00-11111111111111111111111111111111-2222222222222222-01",
+				"b3":          "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1",
+			},
+			format:  PropagationTraceContext,
+			traceID: "11111111111111111111111111111111",
+			sampled: true,
+		},
+		{name: "lone b3 sampling decision", headers: map[string]string{"b3": "0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			carrier := propagation.HeaderCarrier{}
+			for k, v := range tt.headers {
+				carrier.Set(k, v)
+			}
+			ctx := propagator.Extract(context.Background(), carrier)
+			sc := trace.SpanContextFromContext(ctx)
+			if got := InboundPropagationFormat(ctx); got != tt.format {
+				t.Errorf("expected format %q, got %q", tt.format, got)
+			}
+			if tt.traceID == "" {
+				if sc.IsValid() {
+					t.Errorf("expected no span context, got %v", sc)
+				}
+				return
+			}
+			if sc.TraceID().String() != tt.traceID || sc.IsSampled() != tt.sampled || !sc.IsRemote() {
+				t.Errorf("unexpected span context %s sampled=%v", sc.TraceID(), sc.IsSampled())
+			}
+		})
+	}
+}
+
+func TestPropagatorNegotiation(t *testing.T) {
+	propagator, err := NewPropagator(DefaultPropagationConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagator)
+	defer func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	}()
+
+	var outbound map[string]string
+	app := fiber.New()
+	app.Use(FiberOtelMiddleware("test"))
+	app.Get("/", func(c *fiber.Ctx) error {
+		outbound = map[string]string{}
+		PropagateContext(c, outbound)
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("uber-trace-id", "a3ce929d0e0e4736:00f067aa0ba902b7:0:1")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(outbound["traceparent"], "00-0000000000000000a3ce929d0e0e4736-") {
+		t.Errorf("expected a traceparent in the same trace, got %q", outbound["traceparent"])
+	}
+	if !strings.HasPrefix(outbound["uber-trace-id"], "a3ce929d0e0e4736:") || !strings.HasSuffix(outbound["uber-trace-id"], ":0:1") {
+		t.Errorf("expected the caller's jaeger format, got %q", outbound["uber-trace-id"])
+	}
+	if _, ok := outbound["b3"]; ok {
+		t.Errorf("expected no b3 header, got %v", outbound)
+	}
+
+	if _, err := NewPropagator(PropagationConfig{Extract: []string{"xray"}}); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,421 @@
+package instrumentation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// PushConfig pushes metrics from batch jobs that exit before they can be
+// scraped. Metrics go to a Prometheus Pushgateway, an OTLP/HTTP endpoint, or
+// both.
+type PushConfig struct {
+	Gateway      string            // Pushgateway URL, e.g. http://pushgateway:9091
+	OTLPEndpoint string            // OTLP/HTTP endpoint, e.g. http://collector:4318
+	OTLPHeaders  map[string]string // Headers sent with OTLP requests
+
+	// Temporality of the counters and histograms sent over OTLP:
+	// cumulative (default) or delta, for backends such as Datadog that
+	// expect deltas. The Pushgateway and the scrape endpoint always see
+	// cumulative values.
+	Temporality string
+
+	// HistogramAggregation sends native Prometheus histograms over OTLP as
+	// exponential histograms when base2_exponential_bucket_histogram.
+	// Histograms with only classic buckets always keep them.
+	HistogramAggregation string
+
+	// Job and Instance group the pushed metrics; they default to the
+	// service name and the hostname. Grouping adds further labels.
+	Job      string
+	Instance string
+	Grouping map[string]string
+
+	// Interval pushes periodically while the job runs; zero pushes only on
+	// Push and at shutdown
+	Interval time.Duration
+
+	// StaleAfter deletes the Pushgateway groups of this job that have not
+	// been pushed for that long, such as those of earlier instances; zero
+	// keeps them
+	StaleAfter time.Duration
+}
+
+// Enabled reports whether any push target is configured
+func (c PushConfig) Enabled() bool {
+	return c.Gateway != "" || c.OTLPEndpoint != ""
+}
+
+// MetricsPusher pushes the metrics of a gatherer to the configured targets
+type MetricsPusher struct {
+	config   PushConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	start    time.Time
+	now      func() time.Time
+
+	mu    sync.Mutex
+	delta deltaState // cumulative points of the last OTLP push
+}
+
+// NewMetricsPusher creates a pusher for the metrics of gatherer; nil uses the
+// default Prometheus gatherer
+func NewMetricsPusher(config PushConfig, gatherer prometheus.Gatherer) (*MetricsPusher, error) {
+	if !config.Enabled() {
+		return nil, fmt.Errorf("push requires a gateway or OTLP endpoint")
+	}
+	if config.Job == "" {
+		return nil, fmt.Errorf("push requires a job name")
+	}
+	if err := validateTemporality(config.Temporality); err != nil {
+		return nil, err
+	}
+	if err := validateHistogramAggregation(config.HistogramAggregation); err != nil {
+		return nil, err
+	}
+	if config.Instance == "" {
+		config.Instance, _ = os.Hostname()
+	}
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	return &MetricsPusher{
+		config:   config,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		start:    time.Now(),
+		now:      time.Now,
+	}, nil
+}
+
+// Start pushes every Interval until ctx is done
+func (p *MetricsPusher) Start(ctx context.Context) {
+	if p.config.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Push(ctx)
+		}
+	}
+}
+
+// Push sends the current metrics to every target, replacing the job's
+// previous push, and then deletes stale groups
+func (p *MetricsPusher) Push(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	if p.config.Gateway != "" {
+		if err := p.pusher().Gatherer(p.gatherer).PushContext(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("pushgateway: %w", err))
+		} else if p.config.StaleAfter > 0 {
+			if _, err := p.deleteStale(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("pushgateway: %w", err))
+			}
+		}
+	}
+	if p.config.OTLPEndpoint != "" {
+		if err := p.pushOTLP(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("otlp: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to push metrics: %v", errs)
+	}
+	return nil
+}
+
+// Delete removes this instance's group from the Pushgateway, for jobs whose
+// metrics should not outlive them
+func (p *MetricsPusher) Delete() error {
+	if p.config.Gateway == "" {
+		return nil
+	}
+	return p.pusher().Delete()
+}
+
+// pusher returns a Pushgateway client for this instance's group
+func (p *MetricsPusher) pusher() *push.Pusher {
+	pusher := push.New(p.config.Gateway, p.config.Job).
+		Client(p.client).
+		Grouping("instance", p.config.Instance)
+	for k, v := range p.config.Grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+	return pusher
+}
+
+// pushGroup is a group of metrics as listed by the Pushgateway API
+type pushGroup struct {
+	Labels   map[string]string `json:"labels"`
+	PushTime struct {
+		Metrics []struct {
+			Value string `json:"value"`
+		} `json:"metrics"`
+	} `json:"push_time_seconds"`
+}
+
+// deleteStale deletes the job's groups, other than this instance's, last
+// pushed more than StaleAfter ago
+func (p *MetricsPusher) deleteStale(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.config.Gateway, "/")+"/api/v1/metrics", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("listing groups returned %s", resp.Status)
+	}
+	var list struct {
+		Data []pushGroup `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 32*1024*1024)).Decode(&list); err != nil {
+		return 0, fmt.Errorf("failed to decode groups: %w", err)
+	}
+
+	cutoff := p.now().Add(-p.config.StaleAfter)
+	deleted := 0
+	for _, g := range list.Data {
+		if g.Labels["job"] != p.config.Job || g.Labels["instance"] == p.config.Instance || len(g.PushTime.Metrics) == 0 {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(g.PushTime.Metrics[0].Value, 64)
+		if err != nil || time.Unix(0, int64(seconds*float64(time.Second))).After(cutoff) {
+			continue
+		}
+		pusher := push.New(p.config.Gateway, p.config.Job).Client(p.client)
+		for k, v := range g.Labels {
+			if k != "job" {
+				pusher = pusher.Grouping(k, v)
+			}
+		}
+		if err := pusher.Delete(); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// pushOTLP exports the metrics to the OTLP/HTTP endpoint as protobuf
+func (p *MetricsPusher) pushOTLP(ctx context.Context) error {
+	families, err := p.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return err
+	}
+	request := p.otlpRequest(families)
+	var delta deltaState
+	if isDelta(p.config.Temporality) {
+		delta = toDelta(request.ResourceMetrics[0].ScopeMetrics[0].Metrics, p.delta)
+	}
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimRight(p.config.OTLPEndpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/metrics") {
+		endpoint += "/v1/metrics"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range p.config.OTLPHeaders {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("export returned %s", resp.Status)
+	}
+	// Deltas are only advanced once accepted, so a failed push is
+	// included in the next one
+	p.delta = delta
+	return nil
+}
+
+// otlpRequest converts gathered metric families to an OTLP export request.
+// Counters become cumulative sums, gauges and untyped metrics gauges, and
+// histograms and summaries keep their type; native histograms become
+// exponential histograms when that aggregation is selected, or when they have
+// no classic buckets. The grouping labels become resource attributes.
+func (p *MetricsPusher) otlpRequest(families []*dto.MetricFamily) *colmetricpb.ExportMetricsServiceRequest {
+	resource := map[string]string{
+		"service.name":        p.config.Job,
+		"service.instance.id": p.config.Instance,
+	}
+	for k, v := range p.config.Grouping {
+		resource[k] = v
+	}
+
+	start := uint64(p.start.UnixNano())
+	now := uint64(p.now().UnixNano())
+	var metrics []*metricpb.Metric
+	for _, mf := range families {
+		m := &metricpb.Metric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricpb.Sum{IsMonotonic: true, AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+			for _, s := range mf.Metric {
+				sum.DataPoints = append(sum.DataPoints, numberPoint(s, s.GetCounter().GetValue(), start, now))
+			}
+			m.Data = &metricpb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricpb.Gauge{}
+			for _, s := range mf.Metric {
+				v := s.GetGauge().GetValue()
+				if s.Untyped != nil {
+					v = s.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, numberPoint(s, v, start, now))
+			}
+			m.Data = &metricpb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			if p.exponential(mf) {
+				hist := &metricpb.ExponentialHistogram{AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+				for _, s := range mf.Metric {
+					hist.DataPoints = append(hist.DataPoints, exponentialPoint(s, start, now))
+				}
+				m.Data = &metricpb.Metric_ExponentialHistogram{ExponentialHistogram: hist}
+				break
+			}
+			hist := &metricpb.Histogram{AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+			for _, s := range mf.Metric {
+				hist.DataPoints = append(hist.DataPoints, histogramPoint(s, start, now))
+			}
+			m.Data = &metricpb.Metric_Histogram{Histogram: hist}
+		case dto.MetricType_SUMMARY:
+			summary := &metricpb.Summary{}
+			for _, s := range mf.Metric {
+				point := &metricpb.SummaryDataPoint{
+					Attributes:        attributes(s.Label),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      now,
+					Count:             s.GetSummary().GetSampleCount(),
+					Sum:               s.GetSummary().GetSampleSum(),
+				}
+				for _, q := range s.GetSummary().GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &metricpb.SummaryDataPoint_ValueAtQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+				summary.DataPoints = append(summary.DataPoints, point)
+			}
+			m.Data = &metricpb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return &colmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: attributeMap(resource)},
+			ScopeMetrics: []*metricpb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: "github.com/chaksack/apm/pkg/instrumentation"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// exponential reports whether the histograms of a family are sent as
+// exponential histograms; all of its series must be native
+func (p *MetricsPusher) exponential(mf *dto.MetricFamily) bool {
+	classic := false
+	for _, s := range mf.Metric {
+		if !nativeHistogram(s.GetHistogram()) {
+			return false
+		}
+		classic = classic || len(s.GetHistogram().GetBucket()) > 0
+	}
+	return len(mf.Metric) > 0 && (p.config.HistogramAggregation == HistogramExponentialBuckets || !classic)
+}
+
+func numberPoint(s *dto.Metric, v float64, start, now uint64) *metricpb.NumberDataPoint {
+	return &metricpb.NumberDataPoint{
+		Attributes:        attributes(s.Label),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Value:             &metricpb.NumberDataPoint_AsDouble{AsDouble: v},
+	}
+}
+
+// histogramPoint converts cumulative Prometheus buckets to OTLP bucket counts
+func histogramPoint(s *dto.Metric, start, now uint64) *metricpb.HistogramDataPoint {
+	h := s.GetHistogram()
+	sum := h.GetSampleSum()
+	point := &metricpb.HistogramDataPoint{
+		Attributes:        attributes(s.Label),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+	}
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			break
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-prev)
+	return point
+}
+
+func attributes(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.GetName()] = l.GetValue()
+	}
+	return attributeMap(m)
+}
+
+func attributeMap(m map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: m[k]}}})
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMetricsPusherGateway(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+	var requests []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/api/v1/metrics" {
+			group := func(instance string, pushed time.Time) string {
+				return fmt.Sprintf(`{"labels":{"job":"nightly","instance":%q},"push_time_seconds":{"metrics":[{"value":"%d"}]}}`, instance, pushed.Unix())
+			}
+			fmt.Fprintf(w, `{"status":"success","data":[%s,%s,%s,{"labels":{"job":"other","instance":"x"},"push_time_seconds":{"metrics":[{"value":"1"}]}}]}`,
+				group("worker-1", now), group("worker-0", now.Add(-2*time.Hour)), group("worker-2", now.Add(-10*time.Minute)))
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	reg := prometheus.NewRegistry()
+	processed := prometheus.NewCounter(prometheus.CounterOpts{Name: "records_processed_total", Help: "Records"})
+	reg.MustRegister(processed)
+	processed.Add(42)
+
+	pusher, err := NewMetricsPusher(PushConfig{Gateway: gateway.URL, Job: "nightly", Instance: "worker-1", StaleAfter: time.Hour}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"PUT /metrics/job/nightly/instance/worker-1",
+		"GET /api/v1/metrics",
+		"DELETE /metrics/job/nightly/instance/worker-0",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests:\n%s", strings.Join(requests, "\n"))
+	}
+}
+
+func TestMetricsPusherOTLP(t *testing.T) {
+	var got colmetricpb.ExportMetricsServiceRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer collector.Close()
+
+	reg := prometheus.NewRegistry()
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "batch_duration_seconds", Help: "Duration", Buckets: []float64{1, 10}}, []string{"stage"})
+	reg.MustRegister(duration)
+	duration.WithLabelValues("load").Observe(0.5)
+	duration.WithLabelValues("load").Observe(5)
+	duration.WithLabelValues("load").Observe(50)
+
+	pusher, err := NewMetricsPusher(PushConfig{OTLPEndpoint: collector.URL, Job: "nightly", Instance: "worker-1"}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := got.GetResourceMetrics()
+	if len(rm) != 1 || len(rm[0].GetScopeMetrics()) != 1 {
+		t.Fatalf("unexpected export %v", &got)
+	}
+	if attrs := rm[0].GetResource().GetAttributes(); len(attrs) != 2 || attrs[0].GetKey() != "service.instance.id" || attrs[1].GetValue().GetStringValue() != "nightly" {
+		t.Errorf("unexpected resource %v", attrs)
+	}
+	point := rm[0].GetScopeMetrics()[0].GetMetrics()[0].GetHistogram().GetDataPoints()[0]
+	if fmt.Sprint(point.GetBucketCounts()) != "[1 1 1]" || fmt.Sprint(point.GetExplicitBounds()) != "[1 10]" || point.GetCount() != 3 {
+		t.Errorf("unexpected histogram point %v", point)
+	}
+}
+
+func TestMetricsPusherRequiresTarget(t *testing.T) {
+	if _, err := NewMetricsPusher(PushConfig{Job: "nightly"}, nil); err == nil {
+		t.Error("expected an error without a push target")
+	}
+}
@@ -0,0 +1,433 @@
+package instrumentation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// QuotaOverflowLabel replaces label values that would create series beyond the quota
+const QuotaOverflowLabel = "other"
+
+// quotaWindow is the accounting window for per-minute quotas
+const quotaWindow = time.Minute
+
+// QuotaConfig limits the telemetry a service produces. Zero limits are unlimited.
+type QuotaConfig struct {
+	Enabled        bool
+	SpansPerMinute int     // Spans started per minute
+	LogMBPerMinute float64 // Encoded log volume per minute
+	MaxSeries      int     // Metric series exposed by the service
+	MinSampleRate  float64 // Floor for degraded trace sampling (default 0.01)
+	MaxLogLevel    string  // Highest minimum log level degradation may raise to (default "error")
+}
+
+// QuotaUsage reports current usage against the quota
+type QuotaUsage struct {
+	Service     string        `json:"service"`
+	WindowStart time.Time     `json:"window_start"`
+	Spans       QuotaResource `json:"spans"`
+	LogBytes    QuotaResource `json:"log_bytes"`
+	Series      QuotaResource `json:"series"`
+	SampleRate  float64       `json:"sample_rate"`
+	LogLevel    string        `json:"log_level"`
+	Degraded    bool          `json:"degraded"`
+}
+
+// QuotaResource is the usage of one quota resource in the current window
+type QuotaResource struct {
+	Used    int64 `json:"used"`
+	Limit   int64 `json:"limit"`
+	Dropped int64 `json:"dropped"`
+}
+
+// QuotaManager enforces per-service telemetry quotas with graceful degradation.
+// When spans exceed the quota the sampling rate is lowered, when logs exceed it
+// the minimum log level is raised, and once the series quota is reached new
+// label values collapse to QuotaOverflowLabel. Degradation is relaxed again
+// once usage falls below half of the quota.
+type QuotaManager struct {
+	service  string
+	config   QuotaConfig
+	gatherer prometheus.Gatherer
+	now      func() time.Time
+
+	mu           sync.Mutex
+	windowStart  time.Time
+	spans        int64
+	spansDropped int64
+	logBytes     int64
+	logsDropped  int64
+	series       int64
+	seriesLabels map[string]bool
+	sampleRate   float64
+	baseLogLevel zapcore.Level
+	logLevel     zapcore.Level
+	maxLogLevel  zapcore.Level
+
+	usedDesc    *prometheus.Desc
+	limitDesc   *prometheus.Desc
+	droppedDesc *prometheus.Desc
+	rateDesc    *prometheus.Desc
+}
+
+// NewQuotaManager creates a quota manager for a service. The gatherer is used
+// to count metric series; nil uses the default Prometheus gatherer.
+func NewQuotaManager(service string, config QuotaConfig, gatherer prometheus.Gatherer) *QuotaManager {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	if config.MinSampleRate <= 0 {
+		config.MinSampleRate = 0.01
+	}
+
+	maxLevel := zapcore.ErrorLevel
+	if config.MaxLogLevel != "" {
+		if err := maxLevel.UnmarshalText([]byte(config.MaxLogLevel)); err != nil {
+			maxLevel = zapcore.ErrorLevel
+		}
+	}
+
+	labels := prometheus.Labels{"service": service}
+	return &QuotaManager{
+		service:      service,
+		config:       config,
+		gatherer:     gatherer,
+		now:          time.Now,
+		windowStart:  time.Now(),
+		seriesLabels: make(map[string]bool),
+		sampleRate:   1,
+		baseLogLevel: zapcore.DebugLevel,
+		logLevel:     zapcore.DebugLevel,
+		maxLogLevel:  maxLevel,
+		usedDesc:     prometheus.NewDesc("apm_quota_usage", "Telemetry usage in the current quota window", []string{"resource"}, labels),
+		limitDesc:    prometheus.NewDesc("apm_quota_limit", "Telemetry quota per window", []string{"resource"}, labels),
+		droppedDesc:  prometheus.NewDesc("apm_quota_dropped", "Telemetry dropped by quota in the current window", []string{"resource"}, labels),
+		rateDesc:     prometheus.NewDesc("apm_quota_sample_rate", "Trace sampling rate applied by quota degradation", nil, labels),
+	}
+}
+
+// Start refreshes the series count and closes idle windows until ctx is done
+func (q *QuotaManager) Start(ctx context.Context) {
+	q.RefreshSeries()
+
+	ticker := time.NewTicker(quotaWindow / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.RefreshSeries()
+			q.mu.Lock()
+			q.rollover()
+			q.mu.Unlock()
+		}
+	}
+}
+
+// RefreshSeries recounts the metric series exposed by the gatherer
+func (q *QuotaManager) RefreshSeries() {
+	// Gather must run without the lock: it calls Collect on this manager
+	families, err := q.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return
+	}
+
+	var count int64
+	for _, family := range families {
+		count += int64(len(family.GetMetric()))
+	}
+
+	q.mu.Lock()
+	q.series = count
+	q.mu.Unlock()
+}
+
+// rollover closes the current window and adjusts degradation; callers hold the lock
+func (q *QuotaManager) rollover() {
+	now := q.now()
+	if now.Sub(q.windowStart) < quotaWindow {
+		return
+	}
+
+	if limit := int64(q.config.SpansPerMinute); limit > 0 {
+		attempted := q.spans + q.spansDropped
+		switch {
+		case attempted > limit:
+			q.sampleRate *= float64(limit) / float64(attempted)
+			if q.sampleRate < q.config.MinSampleRate {
+				q.sampleRate = q.config.MinSampleRate
+			}
+		case attempted < limit/2 && q.sampleRate < 1:
+			q.sampleRate *= 2
+			if q.sampleRate > 1 {
+				q.sampleRate = 1
+			}
+		}
+	}
+
+	if limit := q.logLimit(); limit > 0 {
+		switch {
+		case q.logsDropped > 0 && q.logLevel < q.maxLogLevel:
+			q.logLevel++
+		case q.logsDropped == 0 && q.logBytes < limit/2 && q.logLevel > q.baseLogLevel:
+			q.logLevel--
+		}
+	}
+
+	q.windowStart = now
+	q.spans, q.spansDropped = 0, 0
+	q.logBytes, q.logsDropped = 0, 0
+}
+
+// logLimit returns the log quota in bytes
+func (q *QuotaManager) logLimit() int64 {
+	return int64(q.config.LogMBPerMinute * 1024 * 1024)
+}
+
+// admitSpan decides whether a new root span fits the quota
+func (q *QuotaManager) admitSpan(p sdktrace.SamplingParameters) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+
+	limit := int64(q.config.SpansPerMinute)
+	if limit > 0 && q.spans >= limit {
+		q.spansDropped++
+		return false
+	}
+	if q.sampleRate < 1 {
+		if sdktrace.TraceIDRatioBased(q.sampleRate).ShouldSample(p).Decision == sdktrace.Drop {
+			q.spansDropped++
+			return false
+		}
+	}
+	q.spans++
+	return true
+}
+
+// countSpan records a span whose sampling was decided by its parent
+func (q *QuotaManager) countSpan() {
+	q.mu.Lock()
+	q.rollover()
+	q.spans++
+	q.mu.Unlock()
+}
+
+// admitLog decides whether a log entry fits the quota. Errors and above are
+// always written.
+func (q *QuotaManager) admitLog(level zapcore.Level, size int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+
+	limit := q.logLimit()
+	if limit > 0 && level < zapcore.ErrorLevel && q.logBytes+int64(size) > limit {
+		q.logsDropped++
+		return false
+	}
+	q.logBytes += int64(size)
+	return true
+}
+
+// setBaseLogLevel sets the configured log level degradation starts from
+func (q *QuotaManager) setBaseLogLevel(level zapcore.Level) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.baseLogLevel = level
+	q.logLevel = level
+}
+
+// logEnabled reports whether degradation allows a log level
+func (q *QuotaManager) logEnabled(level zapcore.Level) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return level >= q.logLevel
+}
+
+// LimitLabel returns value, or QuotaOverflowLabel when value is new and the
+// series quota is exhausted
+func (q *QuotaManager) LimitLabel(value string) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.config.MaxSeries <= 0 || q.seriesLabels[value] {
+		return value
+	}
+	if q.series >= int64(q.config.MaxSeries) {
+		return QuotaOverflowLabel
+	}
+	q.seriesLabels[value] = true
+	return value
+}
+
+// MaxSeries returns the series quota
+func (q *QuotaManager) MaxSeries() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.config.MaxSeries
+}
+
+// SetMaxSeries changes the series quota; zero is unlimited. Label values
+// already admitted are kept when the quota is lowered.
+func (q *QuotaManager) SetMaxSeries(maxSeries int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.config.MaxSeries = maxSeries
+}
+
+// Usage returns current usage against the quota
+func (q *QuotaManager) Usage() QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+
+	return QuotaUsage{
+		Service:     q.service,
+		WindowStart: q.windowStart,
+		Spans:       QuotaResource{Used: q.spans, Limit: int64(q.config.SpansPerMinute), Dropped: q.spansDropped},
+		LogBytes:    QuotaResource{Used: q.logBytes, Limit: q.logLimit(), Dropped: q.logsDropped},
+		Series:      QuotaResource{Used: q.series, Limit: int64(q.config.MaxSeries)},
+		SampleRate:  q.sampleRate,
+		LogLevel:    q.logLevel.String(),
+		Degraded:    q.sampleRate < 1 || q.logLevel > q.baseLogLevel || (q.config.MaxSeries > 0 && q.series >= int64(q.config.MaxSeries)),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (q *QuotaManager) Describe(ch chan<- *prometheus.Desc) {
+	ch <- q.usedDesc
+	ch <- q.limitDesc
+	ch <- q.droppedDesc
+	ch <- q.rateDesc
+}
+
+// Collect implements prometheus.Collector
+func (q *QuotaManager) Collect(ch chan<- prometheus.Metric) {
+	usage := q.Usage()
+	resources := map[string]QuotaResource{
+		"spans":     usage.Spans,
+		"log_bytes": usage.LogBytes,
+		"series":    usage.Series,
+	}
+	for name, r := range resources {
+		ch <- prometheus.MustNewConstMetric(q.usedDesc, prometheus.GaugeValue, float64(r.Used), name)
+		ch <- prometheus.MustNewConstMetric(q.limitDesc, prometheus.GaugeValue, float64(r.Limit), name)
+		ch <- prometheus.MustNewConstMetric(q.droppedDesc, prometheus.GaugeValue, float64(r.Dropped), name)
+	}
+	ch <- prometheus.MustNewConstMetric(q.rateDesc, prometheus.GaugeValue, usage.SampleRate)
+}
+
+// Sampler wraps a sampler so new traces are subject to the span quota. Spans
+// with a sampled parent are kept so traces are never cut in half.
+func (q *QuotaManager) Sampler(base sdktrace.Sampler) sdktrace.Sampler {
+	return &quotaSampler{quota: q, base: base}
+}
+
+type quotaSampler struct {
+	quota *QuotaManager
+	base  sdktrace.Sampler
+}
+
+// ShouldSample implements sdktrace.Sampler
+func (s *quotaSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := s.base.ShouldSample(p)
+	if result.Decision == sdktrace.Drop {
+		return result
+	}
+
+	if trace.SpanContextFromContext(p.ParentContext).IsSampled() {
+		s.quota.countSpan()
+		return result
+	}
+
+	if !s.quota.admitSpan(p) {
+		return sdktrace.SamplingResult{Decision: sdktrace.Drop, Tracestate: result.Tracestate}
+	}
+	return result
+}
+
+// Description implements sdktrace.Sampler
+func (s *quotaSampler) Description() string {
+	return "QuotaSampler{" + s.base.Description() + "}"
+}
+
+// WrapCore returns a zap option that applies the log quota
+func (q *QuotaManager) WrapCore() zap.Option {
+	return q.wrapCore(nil)
+}
+
+// wrapCore applies the log quota beneath zap's sampler, which must be moved
+// out of the logger config so sampled-out entries are not counted
+func (q *QuotaManager) wrapCore(sampling *zap.SamplingConfig) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		wrapped := zapcore.Core(&quotaCore{
+			Core:    core,
+			quota:   q,
+			encoder: zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		})
+		if sampling != nil {
+			wrapped = zapcore.NewSamplerWithOptions(wrapped, time.Second, sampling.Initial, sampling.Thereafter)
+		}
+		return wrapped
+	})
+}
+
+// quotaCore drops log entries over the quota; the encoder sizes entries
+type quotaCore struct {
+	zapcore.Core
+	quota   *QuotaManager
+	encoder zapcore.Encoder
+}
+
+// Enabled implements zapcore.LevelEnabler
+func (c *quotaCore) Enabled(level zapcore.Level) bool {
+	return c.quota.logEnabled(level) && c.Core.Enabled(level)
+}
+
+// With implements zapcore.Core
+func (c *quotaCore) With(fields []zapcore.Field) zapcore.Core {
+	encoder := c.encoder.Clone()
+	for _, f := range fields {
+		f.AddTo(encoder)
+	}
+	return &quotaCore{Core: c.Core.With(fields), quota: c.quota, encoder: encoder}
+}
+
+// Check implements zapcore.Core
+func (c *quotaCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write implements zapcore.Core
+func (c *quotaCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	size := len(ent.Message)
+	if buf, err := c.encoder.EncodeEntry(ent, fields); err == nil {
+		size = buf.Len()
+		buf.Free()
+	}
+
+	if !c.quota.admitLog(ent.Level, size) {
+		return nil
+	}
+	return c.Core.Write(ent, fields)
+}
+
+// QuotaHandler returns a Fiber handler reporting usage against the quota
+func QuotaHandler(q *QuotaManager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(q.Usage())
+	}
+}
@@ -0,0 +1,103 @@
+package instrumentation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestQuota(cfg QuotaConfig) (*QuotaManager, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := NewQuotaManager("checkout", cfg, prometheus.NewRegistry())
+	q.now = func() time.Time { return now }
+	q.windowStart = now
+	return q, &now
+}
+
+func TestQuotaSpans(t *testing.T) {
+	q, now := newTestQuota(QuotaConfig{Enabled: true, SpansPerMinute: 10})
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(q.Sampler(sdktrace.AlwaysSample())))
+	tracer := tp.Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+
+	sampled := 0
+	for i := 0; i < 40; i++ {
+		_, span := tracer.Start(context.Background(), "op")
+		if span.SpanContext().IsSampled() {
+			sampled++
+		}
+		span.End()
+	}
+	if sampled != 9 {
+		t.Errorf("expected 9 more sampled root spans, got %d", sampled)
+	}
+
+	// Children of sampled spans are kept even over the quota
+	_, child := tracer.Start(ctx, "child")
+	if !parent.SpanContext().IsSampled() || !child.SpanContext().IsSampled() {
+		t.Error("expected the child of a sampled span to be kept")
+	}
+
+	usage := q.Usage()
+	if usage.Spans.Used != 11 || usage.Spans.Dropped != 31 {
+		t.Errorf("unexpected span usage %+v", usage.Spans)
+	}
+
+	*now = now.Add(time.Minute)
+	usage = q.Usage()
+	if usage.SampleRate >= 1 || !usage.Degraded {
+		t.Errorf("expected sampling to degrade after exceeding the quota, got %+v", usage)
+	}
+}
+
+func TestQuotaLogs(t *testing.T) {
+	q, now := newTestQuota(QuotaConfig{Enabled: true, LogMBPerMinute: 0.0005}) // ~524 bytes
+	q.setBaseLogLevel(zapcore.InfoLevel)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core, q.WrapCore())
+
+	for i := 0; i < 20; i++ {
+		logger.Info("request completed", zap.Int("status", 200))
+	}
+	logger.Error("payment failed")
+
+	if logs.Len() >= 21 {
+		t.Errorf("expected logs over quota to be dropped, got %d", logs.Len())
+	}
+	if logs.FilterMessage("payment failed").Len() != 1 {
+		t.Error("expected errors to bypass the log quota")
+	}
+
+	*now = now.Add(time.Minute)
+	if q.Usage().LogLevel != "warn" {
+		t.Errorf("expected log level to be raised to warn, got %s", q.Usage().LogLevel)
+	}
+	if logger.Core().Enabled(zapcore.InfoLevel) {
+		t.Error("expected info logs to be disabled while degraded")
+	}
+}
+
+func TestQuotaSeries(t *testing.T) {
+	q, _ := newTestQuota(QuotaConfig{Enabled: true, MaxSeries: 1})
+
+	if got := q.LimitLabel("/users/:id"); got != "/users/:id" {
+		t.Errorf("expected label to pass before the quota is reached, got %s", got)
+	}
+
+	q.series = 1
+	if got := q.LimitLabel("/orders/:id"); got != QuotaOverflowLabel {
+		t.Errorf("expected new label to collapse, got %s", got)
+	}
+	if got := q.LimitLabel("/users/:id"); got != "/users/:id" {
+		t.Errorf("expected known label to be kept, got %s", got)
+	}
+}
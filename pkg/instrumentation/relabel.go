@@ -0,0 +1,493 @@
+package instrumentation
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// Relabel actions
+const (
+	RelabelDrop        = "drop"         // drop the metric, or its series whose label matches regex
+	RelabelKeep        = "keep"         // keep only the series whose label matches regex
+	RelabelDropLabel   = "drop_label"   // remove a label, merging the series it separated
+	RelabelRenameLabel = "rename_label" // rename a label to target
+	RelabelReplace     = "replace"      // rewrite label values matching regex with replacement
+	RelabelRename      = "rename"       // rename the metric to target
+)
+
+// DefaultAggregationQuantiles are the quantiles of summaries built from
+// aggregated histograms
+var DefaultAggregationQuantiles = []float64{0.5, 0.9, 0.99}
+
+// RelabelConfig rewrites metrics as they are scraped, declared under
+// "metrics" in apm.yaml:
+//
+//	metrics:
+//	  relabel:
+//	    - metric: http_.*
+//	      action: replace
+//	      label: path
+//	      regex: /users/[0-9]+
+//	      replacement: /users/:id
+//	  aggregate:
+//	    - metric: http_request_duration_seconds
+//	      by: [method, status]
+type RelabelConfig struct {
+	Relabel   []RelabelRule     `yaml:"relabel" mapstructure:"relabel"`
+	Aggregate []AggregationRule `yaml:"aggregate" mapstructure:"aggregate"`
+}
+
+// RelabelRule rewrites the metrics whose name matches Metric. Metric and
+// Regex are anchored regular expressions; an empty Metric matches every
+// metric and an empty Regex matches every value.
+type RelabelRule struct {
+	Metric      string `yaml:"metric" mapstructure:"metric"`
+	Action      string `yaml:"action" mapstructure:"action"`
+	Label       string `yaml:"label" mapstructure:"label"`
+	Regex       string `yaml:"regex" mapstructure:"regex"`
+	Replacement string `yaml:"replacement" mapstructure:"replacement"`
+
+	// Target is the new name for rename and rename_label, and the label
+	// written by replace (default the rule's label)
+	Target string `yaml:"target" mapstructure:"target"`
+}
+
+// AggregationRule pre-aggregates the metrics whose name matches Metric to
+// the labels in By. Counters and gauges are summed, and histograms become
+// summaries with quantiles estimated from their buckets, so a histogram
+// exposes len(Quantiles)+2 series per group instead of one per bucket.
+type AggregationRule struct {
+	Metric    string    `yaml:"metric" mapstructure:"metric"`
+	By        []string  `yaml:"by" mapstructure:"by"`
+	Quantiles []float64 `yaml:"quantiles" mapstructure:"quantiles"`
+}
+
+// Empty reports whether the config changes nothing
+func (c RelabelConfig) Empty() bool {
+	return len(c.Relabel) == 0 && len(c.Aggregate) == 0
+}
+
+// LoadRelabelConfig reads the "metrics" section of an apm.yaml file
+func LoadRelabelConfig(path string) (RelabelConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RelabelConfig{}, err
+	}
+	var file struct {
+		Metrics RelabelConfig `yaml:"metrics"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return RelabelConfig{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file.Metrics, nil
+}
+
+// Relabeler is a Gatherer that applies relabeling and aggregation rules to
+// the metrics of another Gatherer, so the exposed series can be reduced
+// without changing the code that records them
+type Relabeler struct {
+	gatherer  prometheus.Gatherer
+	rules     []relabelRule
+	aggregate []aggregationRule
+}
+
+type relabelRule struct {
+	RelabelRule
+	metric *regexp.Regexp
+	regex  *regexp.Regexp
+}
+
+type aggregationRule struct {
+	metric    *regexp.Regexp
+	by        map[string]bool
+	quantiles []float64
+}
+
+// NewRelabeler wraps a gatherer with the rules of config; nil wraps the
+// default Prometheus gatherer
+func NewRelabeler(gatherer prometheus.Gatherer, config RelabelConfig) (*Relabeler, error) {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	r := &Relabeler{gatherer: gatherer}
+
+	for i, rule := range config.Relabel {
+		compiled := relabelRule{RelabelRule: rule}
+		var err error
+		if compiled.metric, err = anchored(rule.Metric); err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid metric: %w", i+1, err)
+		}
+		if compiled.regex, err = anchored(rule.Regex); err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid regex: %w", i+1, err)
+		}
+		switch rule.Action {
+		case RelabelDrop:
+		case RelabelKeep, RelabelDropLabel, RelabelReplace:
+			if rule.Label == "" {
+				return nil, fmt.Errorf("relabel rule %d: %s requires a label", i+1, rule.Action)
+			}
+		case RelabelRenameLabel:
+			if rule.Label == "" || rule.Target == "" {
+				return nil, fmt.Errorf("relabel rule %d: rename_label requires a label and target", i+1)
+			}
+		case RelabelRename:
+			if rule.Target == "" {
+				return nil, fmt.Errorf("relabel rule %d: rename requires a target", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i+1, rule.Action)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+
+	for i, rule := range config.Aggregate {
+		metric, err := anchored(rule.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation rule %d: invalid metric: %w", i+1, err)
+		}
+		compiled := aggregationRule{metric: metric, by: make(map[string]bool), quantiles: rule.Quantiles}
+		for _, l := range rule.By {
+			compiled.by[l] = true
+		}
+		if len(compiled.quantiles) == 0 {
+			compiled.quantiles = DefaultAggregationQuantiles
+		}
+		for _, q := range compiled.quantiles {
+			if q < 0 || q > 1 {
+				return nil, fmt.Errorf("aggregation rule %d: quantile %g is not between 0 and 1", i+1, q)
+			}
+		}
+		r.aggregate = append(r.aggregate, compiled)
+	}
+	return r, nil
+}
+
+// Handler serves the relabeled metrics in the Prometheus exposition format
+func (r *Relabeler) Handler() http.Handler {
+	return promhttp.HandlerFor(r, promhttp.HandlerOpts{})
+}
+
+// Gather gathers the wrapped metrics and applies the rules in order, then
+// the first aggregation rule matching each metric. Series that end up with
+// the same labels are merged: counters, gauges, and histograms are summed,
+// and summary quantiles take the largest value.
+func (r *Relabeler) Gather() ([]*dto.MetricFamily, error) {
+	families, gatherErr := r.gatherer.Gather()
+
+	byName := make(map[string]*dto.MetricFamily)
+	var errs prometheus.MultiError
+	if gatherErr != nil {
+		errs = append(errs, gatherErr)
+	}
+	for _, mf := range families {
+		mf = r.relabel(mf)
+		if mf == nil {
+			continue
+		}
+		for _, rule := range r.aggregate {
+			if rule.metric.MatchString(mf.GetName()) {
+				rule.apply(mf)
+				break
+			}
+		}
+		if existing, ok := byName[mf.GetName()]; ok {
+			if existing.GetType() != mf.GetType() {
+				errs = append(errs, fmt.Errorf("relabeling merged %s metrics of different types; keeping the %s", mf.GetName(), existing.GetType()))
+				continue
+			}
+			existing.Metric = append(existing.Metric, mf.Metric...)
+			continue
+		}
+		byName[mf.GetName()] = mf
+	}
+
+	out := make([]*dto.MetricFamily, 0, len(byName))
+	for _, mf := range byName {
+		mergeSeries(mf)
+		if len(mf.Metric) > 0 {
+			out = append(out, mf)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out, errs.MaybeUnwrap()
+}
+
+// relabel applies the relabel rules to a metric family, returning nil when
+// it is dropped
+func (r *Relabeler) relabel(mf *dto.MetricFamily) *dto.MetricFamily {
+	for _, rule := range r.rules {
+		if !rule.metric.MatchString(mf.GetName()) {
+			continue
+		}
+		switch rule.Action {
+		case RelabelDrop:
+			if rule.Label == "" {
+				return nil
+			}
+			mf.Metric = filterSeries(mf.Metric, func(m *dto.Metric) bool {
+				return !rule.regex.MatchString(labelValue(m, rule.Label))
+			})
+		case RelabelKeep:
+			mf.Metric = filterSeries(mf.Metric, func(m *dto.Metric) bool {
+				return rule.regex.MatchString(labelValue(m, rule.Label))
+			})
+		case RelabelDropLabel:
+			for _, m := range mf.Metric {
+				m.Label = withoutLabel(m.Label, rule.Label)
+			}
+		case RelabelRenameLabel:
+			for _, m := range mf.Metric {
+				value, ok := findLabel(m, rule.Label)
+				if !ok {
+					continue
+				}
+				m.Label = setLabel(withoutLabel(m.Label, rule.Label), rule.Target, value)
+			}
+		case RelabelReplace:
+			target := rule.Target
+			if target == "" {
+				target = rule.Label
+			}
+			for _, m := range mf.Metric {
+				value := labelValue(m, rule.Label)
+				if !rule.regex.MatchString(value) {
+					continue
+				}
+				m.Label = setLabel(m.Label, target, rule.regex.ReplaceAllString(value, rule.Replacement))
+			}
+		case RelabelRename:
+			name := rule.Target
+			mf.Name = &name
+		}
+		if len(mf.Metric) == 0 {
+			return nil
+		}
+	}
+	return mf
+}
+
+// apply drops the labels not kept by the rule and converts histograms to
+// summaries; the series are merged afterwards
+func (a aggregationRule) apply(mf *dto.MetricFamily) {
+	for _, m := range mf.Metric {
+		kept := m.Label[:0]
+		for _, l := range m.Label {
+			if a.by[l.GetName()] {
+				kept = append(kept, l)
+			}
+		}
+		m.Label = kept
+	}
+	if mf.GetType() != dto.MetricType_HISTOGRAM {
+		return
+	}
+
+	// Buckets must be merged before quantiles can be estimated
+	mergeSeries(mf)
+	summary := dto.MetricType_SUMMARY
+	mf.Type = &summary
+	for _, m := range mf.Metric {
+		h := m.GetHistogram()
+		s := &dto.Summary{SampleCount: h.SampleCount, SampleSum: h.SampleSum}
+		for _, q := range a.quantiles {
+			q, v := q, bucketQuantile(q, h)
+			s.Quantile = append(s.Quantile, &dto.Quantile{Quantile: &q, Value: &v})
+		}
+		m.Histogram = nil
+		m.Summary = s
+	}
+}
+
+// mergeSeries sorts the series of a metric family and merges those with the
+// same labels
+func mergeSeries(mf *dto.MetricFamily) {
+	merged := make(map[string]*dto.Metric)
+	var keys []string
+	for _, m := range mf.Metric {
+		sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		key := seriesKey(m)
+		existing, ok := merged[key]
+		if !ok {
+			merged[key] = m
+			keys = append(keys, key)
+			continue
+		}
+		mergeMetric(existing, m)
+	}
+	sort.Strings(keys)
+	mf.Metric = mf.Metric[:0]
+	for _, k := range keys {
+		mf.Metric = append(mf.Metric, merged[k])
+	}
+}
+
+// mergeMetric adds the samples of src to dst
+func mergeMetric(dst, src *dto.Metric) {
+	switch {
+	case dst.Counter != nil && src.Counter != nil:
+		v := dst.Counter.GetValue() + src.Counter.GetValue()
+		dst.Counter.Value = &v
+	case dst.Gauge != nil && src.Gauge != nil:
+		v := dst.Gauge.GetValue() + src.Gauge.GetValue()
+		dst.Gauge.Value = &v
+	case dst.Untyped != nil && src.Untyped != nil:
+		v := dst.Untyped.GetValue() + src.Untyped.GetValue()
+		dst.Untyped.Value = &v
+	case dst.Histogram != nil && src.Histogram != nil:
+		dst.Histogram = mergeHistograms(dst.Histogram, src.Histogram)
+	case dst.Summary != nil && src.Summary != nil:
+		count := dst.Summary.GetSampleCount() + src.Summary.GetSampleCount()
+		sum := dst.Summary.GetSampleSum() + src.Summary.GetSampleSum()
+		dst.Summary.SampleCount, dst.Summary.SampleSum = &count, &sum
+		for _, q := range src.Summary.Quantile {
+			found := false
+			for _, d := range dst.Summary.Quantile {
+				if d.GetQuantile() == q.GetQuantile() {
+					found = true
+					if q.GetValue() > d.GetValue() || math.IsNaN(d.GetValue()) {
+						d.Value = q.Value
+					}
+				}
+			}
+			if !found {
+				dst.Summary.Quantile = append(dst.Summary.Quantile, q)
+			}
+		}
+	}
+}
+
+// mergeHistograms adds two histograms. When their buckets differ, a bucket
+// missing from one histogram takes its next lower bucket's count.
+func mergeHistograms(a, b *dto.Histogram) *dto.Histogram {
+	bounds := make(map[float64]bool)
+	for _, bucket := range a.Bucket {
+		bounds[bucket.GetUpperBound()] = true
+	}
+	for _, bucket := range b.Bucket {
+		bounds[bucket.GetUpperBound()] = true
+	}
+	sorted := make([]float64, 0, len(bounds))
+	for bound := range bounds {
+		sorted = append(sorted, bound)
+	}
+	sort.Float64s(sorted)
+
+	count := a.GetSampleCount() + b.GetSampleCount()
+	sum := a.GetSampleSum() + b.GetSampleSum()
+	out := &dto.Histogram{SampleCount: &count, SampleSum: &sum}
+	for _, bound := range sorted {
+		bound, cumulative := bound, cumulativeAt(a, bound)+cumulativeAt(b, bound)
+		out.Bucket = append(out.Bucket, &dto.Bucket{UpperBound: &bound, CumulativeCount: &cumulative})
+	}
+	return out
+}
+
+// cumulativeAt is the count of observations at or below bound
+func cumulativeAt(h *dto.Histogram, bound float64) uint64 {
+	var count uint64
+	for _, bucket := range h.Bucket {
+		if bucket.GetUpperBound() > bound {
+			break
+		}
+		count = bucket.GetCumulativeCount()
+	}
+	return count
+}
+
+// bucketQuantile estimates a quantile from histogram buckets by linear
+// interpolation within the bucket that holds it, as histogram_quantile does
+func bucketQuantile(q float64, h *dto.Histogram) float64 {
+	total := float64(h.GetSampleCount())
+	if total == 0 {
+		return math.NaN()
+	}
+	rank := q * total
+	lower, prev := 0.0, 0.0
+	for _, bucket := range h.Bucket {
+		upper, count := bucket.GetUpperBound(), float64(bucket.GetCumulativeCount())
+		if math.IsInf(upper, 1) {
+			break
+		}
+		if count >= rank {
+			if upper <= 0 || count == prev {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-prev)/(count-prev)
+		}
+		lower, prev = upper, count
+	}
+	// The quantile is above the highest finite bucket
+	return lower
+}
+
+func anchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		expr = ".*"
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func filterSeries(metrics []*dto.Metric, keep func(*dto.Metric) bool) []*dto.Metric {
+	out := metrics[:0]
+	for _, m := range metrics {
+		if keep(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func findLabel(m *dto.Metric, name string) (string, bool) {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return l.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	v, _ := findLabel(m, name)
+	return v
+}
+
+func withoutLabel(labels []*dto.LabelPair, name string) []*dto.LabelPair {
+	out := labels[:0]
+	for _, l := range labels {
+		if l.GetName() != name {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// setLabel sets a label; an empty value removes it, as Prometheus treats
+// empty labels as absent
+func setLabel(labels []*dto.LabelPair, name, value string) []*dto.LabelPair {
+	labels = withoutLabel(labels, name)
+	if value == "" {
+		return labels
+	}
+	return append(labels, &dto.LabelPair{Name: &name, Value: &value})
+}
+
+func seriesKey(m *dto.Metric) string {
+	var b strings.Builder
+	for _, l := range m.Label {
+		b.WriteString(l.GetName())
+		b.WriteByte(0xff)
+		b.WriteString(l.GetValue())
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
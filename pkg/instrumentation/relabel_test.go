@@ -0,0 +1,122 @@
+package instrumentation
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRelabeler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "http_requests_total", Help: "Requests"},
+		[]string{"method", "path", "status", "instance_id"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "http_request_duration_seconds", Help: "Latency", Buckets: []float64{0.25, 0.5, 1}},
+		[]string{"method", "path", "status"})
+	debug := prometheus.NewGauge(prometheus.GaugeOpts{Name: "debug_goroutines", Help: "Debug"})
+	reg.MustRegister(requests, latency, debug)
+
+	requests.WithLabelValues("GET", "/users/1", "2xx", "a").Add(2)
+	requests.WithLabelValues("GET", "/users/2", "2xx", "b").Add(3)
+	requests.WithLabelValues("GET", "/healthz", "2xx", "a").Inc()
+	for i := 0; i < 10; i++ {
+		latency.WithLabelValues("GET", "/users/"+string(rune('0'+i)), "2xx").Observe(0.125)
+		latency.WithLabelValues("POST", "/orders", "5xx").Observe(0.375)
+	}
+	debug.Set(12)
+
+	relabeler, err := NewRelabeler(reg, RelabelConfig{
+		Relabel: []RelabelRule{
+			{Metric: "debug_.*", Action: RelabelDrop},
+			{Metric: "http_.*", Action: RelabelReplace, Label: "path", Regex: "/users/[0-9]+", Replacement: "/users/:id"},
+			{Metric: "http_requests_total", Action: RelabelDrop, Label: "path", Regex: "/healthz"},
+			{Action: RelabelDropLabel, Label: "instance_id"},
+			{Metric: "http_requests_total", Action: RelabelRenameLabel, Label: "status", Target: "code"},
+		},
+		Aggregate: []AggregationRule{
+			{Metric: "http_request_duration_seconds", By: []string{"method"}, Quantiles: []float64{0.5, 0.75}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP http_request_duration_seconds Latency
+# TYPE http_request_duration_seconds summary
+http_request_duration_seconds{method="GET",quantile="0.5"} 0.125
+http_request_duration_seconds{method="GET",quantile="0.75"} 0.1875
+http_request_duration_seconds_sum{method="GET"} 1.25
+http_request_duration_seconds_count{method="GET"} 10
+http_request_duration_seconds{method="POST",quantile="0.5"} 0.375
+http_request_duration_seconds{method="POST",quantile="0.75"} 0.4375
+http_request_duration_seconds_sum{method="POST"} 3.75
+http_request_duration_seconds_count{method="POST"} 10
+# HELP http_requests_total Requests
+# TYPE http_requests_total counter
+http_requests_total{code="2xx",method="GET",path="/users/:id"} 5
+`
+	if err := testutil.GatherAndCompare(relabeler, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelabelerInvalidRules(t *testing.T) {
+	for _, config := range []RelabelConfig{
+		{Relabel: []RelabelRule{{Action: "hash"}}},
+		{Relabel: []RelabelRule{{Action: RelabelRename}}},
+		{Relabel: []RelabelRule{{Action: RelabelKeep, Regex: "a"}}},
+		{Relabel: []RelabelRule{{Action: RelabelDrop, Metric: "("}}},
+		{Aggregate: []AggregationRule{{Metric: "x", Quantiles: []float64{1.5}}}},
+	} {
+		if _, err := NewRelabeler(prometheus.NewRegistry(), config); err == nil {
+			t.Errorf("expected %+v to be rejected", config)
+		}
+	}
+}
+
+func TestLoadRelabelConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apm.yaml")
+	data := `project:
+  name: shop
+metrics:
+  relabel:
+    - metric: go_.*
+      action: drop
+  aggregate:
+    - metric: http_request_duration_seconds
+      by: [method]
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadRelabelConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Relabel) != 1 || config.Relabel[0].Action != RelabelDrop || len(config.Aggregate) != 1 || config.Aggregate[0].By[0] != "method" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestBucketQuantileAboveBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "h", Help: "h", Buckets: []float64{1, 2}})
+	reg.MustRegister(h)
+	h.Observe(5)
+
+	families, _ := reg.Gather()
+	if got := bucketQuantile(0.5, families[0].Metric[0].GetHistogram()); got != 2 {
+		t.Errorf("expected the highest bucket bound, got %v", got)
+	}
+	h2 := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "h2", Help: "h", Buckets: []float64{1}})
+	reg.MustRegister(h2)
+	families, _ = reg.Gather()
+	if got := bucketQuantile(0.5, families[1].Metric[0].GetHistogram()); !math.IsNaN(got) {
+		t.Errorf("expected NaN for an empty histogram, got %v", got)
+	}
+}
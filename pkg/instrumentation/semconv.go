@@ -0,0 +1,330 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Semantic convention versions on either side of the HTTP and network
+// attribute renames stabilized in v1.23
+const (
+	SemconvV121 = "1.21.0"
+	SemconvV126 = "1.26.0"
+)
+
+// SemconvConfig selects the semantic conventions of exported spans. Spans
+// are recorded with whatever attributes the instrumentation sets and
+// translated at export, so backends and dashboards can move to a newer
+// convention without changing instrumented code.
+type SemconvConfig struct {
+	// Version is the convention exported, e.g. "1.21.0" or "1.26.0"; empty
+	// exports attributes as recorded
+	Version string
+
+	// Duplicate exports the attributes of both conventions, so dashboards
+	// built on either keep working during a migration
+	Duplicate bool
+}
+
+// SemconvConfigFromEnv reads SEMCONV_VERSION and SEMCONV_DUPLICATE, falling
+// back to the OpenTelemetry OTEL_SEMCONV_STABILITY_OPT_IN values "http" and
+// "http/dup"
+func SemconvConfigFromEnv() SemconvConfig {
+	config := SemconvConfig{
+		Version:   os.Getenv("SEMCONV_VERSION"),
+		Duplicate: getEnvBool("SEMCONV_DUPLICATE", false),
+	}
+	if config.Version != "" {
+		return config
+	}
+	for _, opt := range strings.Split(os.Getenv("OTEL_SEMCONV_STABILITY_OPT_IN"), ",") {
+		switch strings.TrimSpace(opt) {
+		case "http":
+			config.Version = SemconvV126
+		case "http/dup":
+			config.Version = SemconvV126
+			config.Duplicate = true
+		}
+	}
+	return config
+}
+
+// SchemaURL returns the schema URL of the configured version, or "" when
+// no version is set
+func (c SemconvConfig) SchemaURL() string {
+	if c.Version == "" {
+		return ""
+	}
+	return "https://opentelemetry.io/schemas/" + strings.TrimPrefix(c.Version, "v")
+}
+
+// stable reports whether the version uses the stable HTTP conventions
+func (c SemconvConfig) stable() (bool, error) {
+	parts := strings.Split(strings.TrimPrefix(c.Version, "v"), ".")
+	if len(parts) < 2 {
+		return false, fmt.Errorf("invalid semantic convention version %q", c.Version)
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || major != 1 {
+		return false, fmt.Errorf("invalid semantic convention version %q", c.Version)
+	}
+	return minor >= 23, nil
+}
+
+// Attribute renames from the v1.21 conventions to the stable ones. Peer
+// attributes depend on the span kind: a client's peer is the server, a
+// server's peer is the client.
+var (
+	semconvRenames = map[string]string{
+		"http.method":                          "http.request.method",
+		"http.status_code":                     "http.response.status_code",
+		"http.scheme":                          "url.scheme",
+		"http.url":                             "url.full",
+		"http.flavor":                          "network.protocol.version",
+		"http.user_agent":                      "user_agent.original",
+		"http.request_content_length":          "http.request.body.size",
+		"http.response_content_length":         "http.response.body.size",
+		"http.client_ip":                       "client.address",
+		"net.host.name":                        "server.address",
+		"net.host.port":                        "server.port",
+		"net.protocol.name":                    "network.protocol.name",
+		"net.protocol.version":                 "network.protocol.version",
+		"net.transport":                        "network.transport",
+		"net.sock.peer.addr":                   "network.peer.address",
+		"net.sock.peer.port":                   "network.peer.port",
+		"net.sock.host.addr":                   "network.local.address",
+		"net.sock.host.port":                   "network.local.port",
+		"messaging.message.payload_size_bytes": "messaging.message.body.size",
+	}
+	semconvClientPeer = map[string]string{"net.peer.name": "server.address", "net.peer.port": "server.port"}
+	semconvServerPeer = map[string]string{"net.peer.name": "client.address", "net.peer.port": "client.port"}
+)
+
+// TranslateAttributes converts span attributes to the configured convention.
+// Attributes already present in the target convention are not overwritten.
+func TranslateAttributes(kind trace.SpanKind, attrs []attribute.KeyValue, config SemconvConfig) []attribute.KeyValue {
+	if config.Version == "" {
+		return attrs
+	}
+	stable, err := config.stable()
+	if err != nil {
+		return attrs
+	}
+
+	present := make(map[attribute.Key]bool, len(attrs))
+	for _, kv := range attrs {
+		present[kv.Key] = true
+	}
+	out := make([]attribute.KeyValue, 0, len(attrs)+2)
+	add := func(kv attribute.KeyValue) {
+		if !present[kv.Key] {
+			present[kv.Key] = true
+			out = append(out, kv)
+		}
+	}
+
+	for _, kv := range attrs {
+		var translated []attribute.KeyValue
+		if stable {
+			translated = upgradeAttribute(kind, kv)
+		} else {
+			translated = downgradeAttribute(kind, kv, present)
+		}
+		if translated == nil || config.Duplicate {
+			out = append(out, kv)
+		}
+		for _, t := range translated {
+			add(t)
+		}
+	}
+	if !stable {
+		// url.path and url.query recombine into http.target
+		if path, ok := findAttribute(attrs, "url.path"); ok && !present["http.target"] {
+			target := path.AsString()
+			if query, ok := findAttribute(attrs, "url.query"); ok && query.AsString() != "" {
+				target += "?" + query.AsString()
+			}
+			add(attribute.String("http.target", target))
+		}
+	}
+	return out
+}
+
+// upgradeAttribute returns the stable attributes for a v1.21 attribute, or
+// nil when it is unchanged
+func upgradeAttribute(kind trace.SpanKind, kv attribute.KeyValue) []attribute.KeyValue {
+	key := string(kv.Key)
+	if key == "http.target" {
+		path, query, _ := strings.Cut(kv.Value.AsString(), "?")
+		out := []attribute.KeyValue{attribute.String("url.path", path)}
+		if query != "" {
+			out = append(out, attribute.String("url.query", query))
+		}
+		return out
+	}
+
+	renamed, ok := semconvRenames[key]
+	if !ok {
+		peers := semconvClientPeer
+		if kind == trace.SpanKindServer {
+			peers = semconvServerPeer
+		}
+		if renamed, ok = peers[key]; !ok {
+			return nil
+		}
+	}
+	value := kv.Value
+	switch key {
+	case "net.transport":
+		value = attribute.StringValue(strings.TrimPrefix(value.AsString(), "ip_"))
+	case "http.flavor":
+		value = attribute.StringValue(strings.TrimSuffix(value.AsString(), ".0"))
+	}
+	return []attribute.KeyValue{{Key: attribute.Key(renamed), Value: value}}
+}
+
+// downgradeAttribute returns the v1.21 attributes for a stable attribute, or
+// nil when it is unchanged
+func downgradeAttribute(kind trace.SpanKind, kv attribute.KeyValue, present map[attribute.Key]bool) []attribute.KeyValue {
+	key := string(kv.Key)
+	if key == "url.path" || key == "url.query" {
+		// Recombined into http.target once all attributes are seen
+		return []attribute.KeyValue{}
+	}
+
+	var old string
+	switch key {
+	case "server.address", "server.port":
+		suffix := "port"
+		if key == "server.address" {
+			suffix = "name"
+		}
+		if kind == trace.SpanKindServer {
+			old = "net.host." + suffix
+		} else {
+			old = "net.peer." + suffix
+		}
+	case "client.address":
+		if kind == trace.SpanKindServer {
+			old = "http.client_ip"
+		} else {
+			return nil
+		}
+	case "client.port":
+		if kind == trace.SpanKindServer {
+			old = "net.peer.port"
+		} else {
+			return nil
+		}
+	case "network.protocol.version":
+		old = "net.protocol.version"
+		if present["http.request.method"] || present["http.method"] {
+			old = "http.flavor"
+		}
+	default:
+		for from, to := range semconvRenames {
+			if to == key {
+				old = from
+				break
+			}
+		}
+		if old == "" {
+			return nil
+		}
+	}
+	value := kv.Value
+	switch old {
+	case "net.transport":
+		if v := value.AsString(); v == "tcp" || v == "udp" {
+			value = attribute.StringValue("ip_" + v)
+		}
+	case "http.flavor":
+		if v := value.AsString(); !strings.Contains(v, ".") {
+			value = attribute.StringValue(v + ".0")
+		}
+	}
+	return []attribute.KeyValue{{Key: attribute.Key(old), Value: value}}
+}
+
+func findAttribute(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// NewSemconvExporter wraps an exporter so the spans it exports follow the
+// configured convention and carry its schema URL
+func NewSemconvExporter(exporter sdktrace.SpanExporter, config SemconvConfig) (sdktrace.SpanExporter, error) {
+	if config.Version == "" {
+		return exporter, nil
+	}
+	if _, err := config.stable(); err != nil {
+		return nil, err
+	}
+	return &semconvExporter{next: exporter, config: config, resources: make(map[*resource.Resource]*resource.Resource)}, nil
+}
+
+type semconvExporter struct {
+	next   sdktrace.SpanExporter
+	config SemconvConfig
+
+	mu        sync.Mutex
+	resources map[*resource.Resource]*resource.Resource
+}
+
+// ExportSpans translates the spans and passes them on
+func (e *semconvExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	out := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		out[i] = semconvSpan{
+			ReadOnlySpan: s,
+			attrs:        TranslateAttributes(s.SpanKind(), s.Attributes(), e.config),
+			resource:     e.resource(s.Resource()),
+		}
+	}
+	return e.next.ExportSpans(ctx, out)
+}
+
+// Shutdown shuts down the wrapped exporter
+func (e *semconvExporter) Shutdown(ctx context.Context) error {
+	return e.next.Shutdown(ctx)
+}
+
+// resource returns the resource with the configured schema URL. Resources
+// are shared by the spans of a provider, so each is converted once.
+func (e *semconvExporter) resource(r *resource.Resource) *resource.Resource {
+	if r == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	converted, ok := e.resources[r]
+	if !ok {
+		converted = resource.NewWithAttributes(e.config.SchemaURL(), r.Attributes()...)
+		e.resources[r] = converted
+	}
+	return converted
+}
+
+// semconvSpan is a span with translated attributes
+type semconvSpan struct {
+	sdktrace.ReadOnlySpan
+	attrs    []attribute.KeyValue
+	resource *resource.Resource
+}
+
+func (s semconvSpan) Attributes() []attribute.KeyValue { return s.attrs }
+func (s semconvSpan) Resource() *resource.Resource     { return s.resource }
@@ -0,0 +1,116 @@
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attrMap(attrs []attribute.KeyValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		m[string(kv.Key)] = kv.Value.Emit()
+	}
+	return m
+}
+
+func TestTranslateAttributesUpgrade(t *testing.T) {
+	old := []attribute.KeyValue{
+		attribute.String("http.method", "GET"),
+		attribute.String("http.target", "/orders?id=7"),
+		attribute.Int("http.status_code", 200),
+		attribute.String("http.flavor", "2.0"),
+		attribute.String("net.host.name", "shop.example.com"),
+		attribute.String("net.peer.name", "10.0.0.9"),
+		attribute.String("http.route", "/orders"),
+	}
+
+	got := attrMap(TranslateAttributes(trace.SpanKindServer, old, SemconvConfig{Version: SemconvV126}))
+	want := map[string]string{
+		"http.request.method":       "GET",
+		"url.path":                  "/orders",
+		"url.query":                 "id=7",
+		"http.response.status_code": "200",
+		"network.protocol.version":  "2",
+		"server.address":            "shop.example.com",
+		"client.address":            "10.0.0.9",
+		"http.route":                "/orders",
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, got[k])
+		}
+	}
+
+	// A client's peer is the server
+	client := attrMap(TranslateAttributes(trace.SpanKindClient, []attribute.KeyValue{attribute.String("net.peer.name", "api.stripe.com")}, SemconvConfig{Version: "1.26"}))
+	if client["server.address"] != "api.stripe.com" {
+		t.Errorf("expected server.address on a client span, got %v", client)
+	}
+}
+
+func TestTranslateAttributesDuplicateAndDowngrade(t *testing.T) {
+	dup := attrMap(TranslateAttributes(trace.SpanKindServer, []attribute.KeyValue{
+		attribute.String("http.method", "POST"),
+		attribute.String("http.request.method", "PUT"),
+	}, SemconvConfig{Version: SemconvV126, Duplicate: true}))
+	if dup["http.method"] != "POST" || dup["http.request.method"] != "PUT" {
+		t.Errorf("expected both conventions without overwriting, got %v", dup)
+	}
+
+	down := attrMap(TranslateAttributes(trace.SpanKindClient, []attribute.KeyValue{
+		attribute.String("http.request.method", "GET"),
+		attribute.String("url.path", "/v1/charges"),
+		attribute.String("url.query", "limit=3"),
+		attribute.String("server.address", "api.stripe.com"),
+		attribute.String("network.protocol.version", "1.1"),
+	}, SemconvConfig{Version: SemconvV121}))
+	want := map[string]string{
+		"http.method":   "GET",
+		"http.target":   "/v1/charges?limit=3",
+		"net.peer.name": "api.stripe.com",
+		"http.flavor":   "1.1",
+	}
+	if len(down) != len(want) {
+		t.Errorf("expected %v, got %v", want, down)
+	}
+	for k, v := range want {
+		if down[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, down[k])
+		}
+	}
+}
+
+func TestSemconvExporter(t *testing.T) {
+	recorder := tracetest.NewInMemoryExporter()
+	exporter, err := NewSemconvExporter(recorder, SemconvConfig{Version: SemconvV126})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := tp.Tracer("test").Start(context.Background(), "GET /", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.method", "GET")))
+	span.End()
+
+	spans := recorder.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if got := attrMap(spans[0].Attributes); got["http.request.method"] != "GET" || got["http.method"] != "" {
+		t.Errorf("expected translated attributes, got %v", got)
+	}
+	if got := spans[0].Resource.SchemaURL(); got != "https://opentelemetry.io/schemas/1.26.0" {
+		t.Errorf("unexpected schema URL %q", got)
+	}
+
+	if _, err := NewSemconvExporter(recorder, SemconvConfig{Version: "latest"}); err == nil {
+		t.Error("expected an invalid version to be rejected")
+	}
+}
@@ -0,0 +1,410 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/netaddr"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ShardConfig spreads spans over several local agents by trace ID, so every
+// span of a trace reaches the same agent, as tail sampling requires
+type ShardConfig struct {
+	// Endpoints are the agents' host:port addresses
+	Endpoints []string
+	// DNSName is a host:port whose A/AAAA records are the agents, such as a
+	// headless Service; it is resolved again every RefreshInterval
+	// (default 30s) so agents may join and leave
+	DNSName         string
+	RefreshInterval time.Duration
+	// Replicas is the number of points per agent on the hash ring (default
+	// 100); more points spread traces more evenly
+	Replicas int
+	// RetryAfter is how long an agent that failed an export stays out of
+	// the ring (default 30s)
+	RetryAfter time.Duration
+}
+
+// validate checks that agents are configured
+func (c ShardConfig) validate() error {
+	if len(c.Endpoints) == 0 && c.DNSName == "" {
+		return errors.New("sharding requires agent endpoints or a DNS name")
+	}
+	if c.DNSName != "" {
+		if err := netaddr.ValidateHostPort(c.DNSName); err != nil {
+			return fmt.Errorf("agent DNS name: %w", err)
+		}
+	}
+	for _, endpoint := range c.Endpoints {
+		if err := netaddr.ValidateHostPort(endpoint); err != nil {
+			return fmt.Errorf("agent: %w", err)
+		}
+	}
+	return nil
+}
+
+// ShardConfigFromEnv reads APM_AGENT_SHARDS, a comma-separated list of agent
+// endpoints or "dns:host:port", and APM_AGENT_SHARDS_REFRESH. It returns nil
+// when sharding is not configured.
+func ShardConfigFromEnv() *ShardConfig {
+	value := strings.TrimSpace(os.Getenv("APM_AGENT_SHARDS"))
+	if value == "" {
+		return nil
+	}
+	cfg := &ShardConfig{RefreshInterval: getEnvDuration("APM_AGENT_SHARDS_REFRESH", 0)}
+	if name, ok := strings.CutPrefix(value, "dns:"); ok {
+		cfg.DNSName = name
+	} else {
+		for _, endpoint := range strings.Split(value, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				cfg.Endpoints = append(cfg.Endpoints, endpoint)
+			}
+		}
+	}
+	return cfg
+}
+
+// HashRing maps trace IDs to members with consistent hashing: when a member
+// joins or leaves, only the traces it owns move
+type HashRing struct {
+	points  []uint64
+	owners  map[uint64]string
+	members []string
+}
+
+// NewHashRing creates a ring with replicas points per member
+func NewHashRing(members []string, replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	r := &HashRing{owners: make(map[uint64]string), members: append([]string(nil), members...)}
+	sort.Strings(r.members)
+	for _, member := range r.members {
+		for i := 0; i < replicas; i++ {
+			point := hashKey([]byte(member + "#" + strconv.Itoa(i)))
+			if _, taken := r.owners[point]; taken {
+				continue
+			}
+			r.owners[point] = member
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Get returns the member owning a trace, or "" for an empty ring
+func (r *HashRing) Get(traceID trace.TraceID) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(traceID[:])
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Members returns the members in order
+func (r *HashRing) Members() []string {
+	return append([]string(nil), r.members...)
+}
+
+// hashKey hashes with FNV-1a and the murmur3 finalizer, which spreads keys
+// differing only in their last bytes over the whole ring
+func hashKey(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	k := h.Sum64()
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// ShardedExporter exports each trace to the agent that owns its trace ID.
+// An agent that fails an export leaves the ring for RetryAfter and its spans
+// are sent to their new owners.
+type ShardedExporter struct {
+	config      ShardConfig
+	newExporter func(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error)
+	lookup      func(ctx context.Context, host string) ([]string, error)
+	now         func() time.Time
+	stop        context.CancelFunc
+	done        chan struct{}
+
+	mu     sync.RWMutex
+	shards map[string]*agentShard
+	ring   *HashRing // healthy agents only
+}
+
+type agentShard struct {
+	exporter  sdktrace.SpanExporter
+	downUntil time.Time
+}
+
+// NewShardedExporter creates an exporter over the configured agents;
+// newExporter creates the exporter of one agent endpoint
+func NewShardedExporter(ctx context.Context, config ShardConfig, newExporter func(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error)) (*ShardedExporter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 30 * time.Second
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = 30 * time.Second
+	}
+
+	e := &ShardedExporter{
+		config:      config,
+		newExporter: newExporter,
+		lookup:      net.DefaultResolver.LookupHost,
+		now:         time.Now,
+		shards:      make(map[string]*agentShard),
+		ring:        NewHashRing(nil, config.Replicas),
+	}
+	if config.DNSName == "" {
+		return e, e.SetEndpoints(ctx, config.Endpoints)
+	}
+
+	if err := e.resolve(ctx); err != nil {
+		return nil, err
+	}
+	refreshCtx, stop := context.WithCancel(context.Background())
+	e.stop, e.done = stop, make(chan struct{})
+	go e.refresh(refreshCtx)
+	return e, nil
+}
+
+// SetEndpoints changes the agents: new agents get an exporter and join the
+// ring, and departed agents leave it and are shut down
+func (e *ShardedExporter) SetEndpoints(ctx context.Context, endpoints []string) error {
+	wanted := make(map[string]bool, len(endpoints))
+	var errs []error
+	added := make(map[string]*agentShard)
+	for _, endpoint := range endpoints {
+		wanted[endpoint] = true
+		e.mu.RLock()
+		_, exists := e.shards[endpoint]
+		e.mu.RUnlock()
+		if exists || added[endpoint] != nil {
+			continue
+		}
+		exporter, err := e.newExporter(ctx, endpoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("agent %s: %w", endpoint, err))
+			continue
+		}
+		added[endpoint] = &agentShard{exporter: exporter}
+	}
+
+	e.mu.Lock()
+	var removed []sdktrace.SpanExporter
+	for endpoint, shard := range e.shards {
+		if !wanted[endpoint] {
+			removed = append(removed, shard.exporter)
+			delete(e.shards, endpoint)
+		}
+	}
+	for endpoint, shard := range added {
+		e.shards[endpoint] = shard
+	}
+	e.rebuildLocked()
+	e.mu.Unlock()
+
+	for _, exporter := range removed {
+		if err := exporter.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Endpoints returns the agents currently in the ring
+func (e *ShardedExporter) Endpoints() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.ring.Members()
+}
+
+// rebuildLocked rebuilds the ring from the healthy agents; with none healthy
+// every agent is tried rather than dropping spans. Callers hold mu.
+func (e *ShardedExporter) rebuildLocked() {
+	now := e.now()
+	var healthy, all []string
+	for endpoint, shard := range e.shards {
+		all = append(all, endpoint)
+		if !now.Before(shard.downUntil) {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = all
+	}
+	e.ring = NewHashRing(healthy, e.config.Replicas)
+}
+
+// route returns the current ring, readmitting agents whose retry time passed
+func (e *ShardedExporter) route() *HashRing {
+	e.mu.RLock()
+	ring, stale := e.ring, false
+	now := e.now()
+	for _, shard := range e.shards {
+		if !shard.downUntil.IsZero() && !now.Before(shard.downUntil) {
+			stale = true
+			break
+		}
+	}
+	e.mu.RUnlock()
+	if !stale {
+		return ring
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, shard := range e.shards {
+		if !shard.downUntil.IsZero() && !now.Before(shard.downUntil) {
+			shard.downUntil = time.Time{}
+		}
+	}
+	e.rebuildLocked()
+	return e.ring
+}
+
+// markDown takes an agent out of the ring for RetryAfter
+func (e *ShardedExporter) markDown(endpoint string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if shard, ok := e.shards[endpoint]; ok {
+		shard.downUntil = e.now().Add(e.config.RetryAfter)
+		e.rebuildLocked()
+	}
+}
+
+// ExportSpans implements sdktrace.SpanExporter
+func (e *ShardedExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	failed, errs := e.export(ctx, e.route(), spans)
+	if len(failed) == 0 {
+		return nil
+	}
+	// The failed agents are out of the ring now; their traces move to the
+	// next owners once
+	if _, retryErrs := e.export(ctx, e.route(), failed); len(retryErrs) > 0 {
+		return errors.Join(append(errs, retryErrs...)...)
+	}
+	return nil
+}
+
+// export sends each span to its owner in parallel and returns the spans of
+// agents that failed, which are marked down
+func (e *ShardedExporter) export(ctx context.Context, ring *HashRing, spans []sdktrace.ReadOnlySpan) ([]sdktrace.ReadOnlySpan, []error) {
+	batches := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		owner := ring.Get(span.SpanContext().TraceID())
+		batches[owner] = append(batches[owner], span)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []sdktrace.ReadOnlySpan
+		errs   []error
+	)
+	for endpoint, batch := range batches {
+		e.mu.RLock()
+		shard := e.shards[endpoint]
+		e.mu.RUnlock()
+		if shard == nil {
+			mu.Lock()
+			errs = append(errs, errors.New("no agents to export to"))
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(endpoint string, exporter sdktrace.SpanExporter, batch []sdktrace.ReadOnlySpan) {
+			defer wg.Done()
+			if err := exporter.ExportSpans(ctx, batch); err != nil {
+				e.markDown(endpoint)
+				mu.Lock()
+				failed = append(failed, batch...)
+				errs = append(errs, fmt.Errorf("agent %s: %w", endpoint, err))
+				mu.Unlock()
+			}
+		}(endpoint, shard.exporter, batch)
+	}
+	wg.Wait()
+	return failed, errs
+}
+
+// resolve sets the agents to the addresses of the DNS name
+func (e *ShardedExporter) resolve(ctx context.Context) error {
+	host, port, err := net.SplitHostPort(e.config.DNSName)
+	if err != nil {
+		return fmt.Errorf("agent DNS name %q: %w", e.config.DNSName, err)
+	}
+	addrs, err := e.lookup(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve agents: %w", err)
+	}
+	endpoints := make([]string, len(addrs))
+	for i, addr := range addrs {
+		endpoints[i] = net.JoinHostPort(addr, port)
+	}
+	return e.SetEndpoints(ctx, endpoints)
+}
+
+// refresh resolves the agents every RefreshInterval; a failed lookup keeps
+// the current agents
+func (e *ShardedExporter) refresh(ctx context.Context) {
+	defer close(e.done)
+	ticker := time.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.resolve(ctx); err != nil && ctx.Err() == nil {
+				otel.Handle(err)
+			}
+		}
+	}
+}
+
+// Shutdown implements sdktrace.SpanExporter
+func (e *ShardedExporter) Shutdown(ctx context.Context) error {
+	if e.stop != nil {
+		e.stop()
+		<-e.done
+	}
+	e.mu.Lock()
+	shards := e.shards
+	e.shards = make(map[string]*agentShard)
+	e.ring = NewHashRing(nil, e.config.Replicas)
+	e.mu.Unlock()
+
+	var errs []error
+	for _, shard := range shards {
+		if err := shard.exporter.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,189 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func testTraceID(i int) trace.TraceID {
+	var id trace.TraceID
+	copy(id[:], fmt.Sprintf("trace-%010d", i))
+	return id
+}
+
+func TestHashRingMovesFewTraces(t *testing.T) {
+	before := NewHashRing([]string{"a:4317", "b:4317", "c:4317"}, 0)
+	after := NewHashRing([]string{"a:4317", "b:4317", "c:4317", "d:4317"}, 0)
+
+	const traces = 10000
+	moved, counts := 0, map[string]int{}
+	for i := 0; i < traces; i++ {
+		id := testTraceID(i)
+		owner := after.Get(id)
+		counts[owner]++
+		if prev := before.Get(id); prev != owner {
+			if owner != "d:4317" {
+				t.Fatalf("trace moved from %s to %s, want only moves to the new agent", prev, owner)
+			}
+			moved++
+		}
+	}
+	if moved < traces/8 || moved > traces*3/8 {
+		t.Errorf("%d of %d traces moved, want about a quarter", moved, traces)
+	}
+	for member, n := range counts {
+		if n < traces/8 {
+			t.Errorf("%s owns %d traces, want an even spread", member, n)
+		}
+	}
+}
+
+type fakeAgent struct {
+	mu       sync.Mutex
+	traces   map[trace.TraceID]bool
+	fail     bool
+	shutdown bool
+}
+
+func (a *fakeAgent) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.fail {
+		return errors.New("connection refused")
+	}
+	for _, span := range spans {
+		a.traces[span.SpanContext().TraceID()] = true
+	}
+	return nil
+}
+
+func (a *fakeAgent) Shutdown(context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.shutdown = true
+	return nil
+}
+
+func testSpans(traces, spansPerTrace int) []sdktrace.ReadOnlySpan {
+	var stubs tracetest.SpanStubs
+	for i := 0; i < traces; i++ {
+		for j := 0; j < spansPerTrace; j++ {
+			stubs = append(stubs, tracetest.SpanStub{SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: testTraceID(i),
+				SpanID:  trace.SpanID{byte(j + 1)},
+			})})
+		}
+	}
+	return stubs.Snapshots()
+}
+
+func TestShardedExporter(t *testing.T) {
+	ctx := context.Background()
+	agents := map[string]*fakeAgent{}
+	newAgent := func(_ context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+		agents[endpoint] = &fakeAgent{traces: map[trace.TraceID]bool{}}
+		return agents[endpoint], nil
+	}
+	exp, err := NewShardedExporter(ctx, ShardConfig{Endpoints: []string{"a:4317", "b:4317", "c:4317"}, RetryAfter: time.Minute}, newAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	exp.now = func() time.Time { return now }
+
+	// Every span of a trace reaches the same agent
+	if err := exp.ExportSpans(ctx, testSpans(100, 3)); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[trace.TraceID]string{}
+	for endpoint, agent := range agents {
+		if len(agent.traces) == 0 {
+			t.Errorf("agent %s received nothing", endpoint)
+		}
+		for id := range agent.traces {
+			if other, ok := seen[id]; ok {
+				t.Fatalf("trace %s split between %s and %s", id, other, endpoint)
+			}
+			seen[id] = endpoint
+		}
+	}
+
+	// A failing agent leaves the ring and its traces go to the others
+	for _, agent := range agents {
+		agent.traces = map[trace.TraceID]bool{}
+	}
+	agents["b:4317"].fail = true
+	if err := exp.ExportSpans(ctx, testSpans(100, 1)); err != nil {
+		t.Fatalf("export with a failed agent: %v", err)
+	}
+	if got := exp.Endpoints(); len(got) != 2 {
+		t.Errorf("ring = %v, want the failed agent out", got)
+	}
+	if delivered := len(agents["a:4317"].traces) + len(agents["c:4317"].traces); delivered != 100 {
+		t.Errorf("%d traces delivered, want all 100", delivered)
+	}
+
+	// It rejoins once the retry time has passed
+	agents["b:4317"].fail = false
+	now = now.Add(2 * time.Minute)
+	exp.ExportSpans(ctx, testSpans(1, 1))
+	if got := exp.Endpoints(); len(got) != 3 {
+		t.Errorf("ring = %v, want the agent back", got)
+	}
+
+	// Departed agents are shut down
+	removed := agents["c:4317"]
+	if err := exp.SetEndpoints(ctx, []string{"a:4317", "b:4317", "d:4317"}); err != nil {
+		t.Fatal(err)
+	}
+	if !removed.shutdown {
+		t.Error("departed agent was not shut down")
+	}
+	if got := exp.Endpoints(); len(got) != 3 || got[2] != "d:4317" {
+		t.Errorf("ring = %v", got)
+	}
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardedExporterDNS(t *testing.T) {
+	ctx := context.Background()
+	var endpoints []string
+	exp, err := NewShardedExporter(ctx, ShardConfig{DNSName: "localhost:4317", RefreshInterval: time.Hour}, func(_ context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+		endpoints = append(endpoints, endpoint)
+		return &fakeAgent{traces: map[trace.TraceID]bool{}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exp.Shutdown(ctx)
+	if len(endpoints) == 0 {
+		t.Fatal("no agents resolved for localhost")
+	}
+
+	if _, err := NewShardedExporter(ctx, ShardConfig{DNSName: "localhost"}, nil); err == nil {
+		t.Error("DNS name without a port accepted")
+	}
+}
+
+func TestShardConfigFromEnv(t *testing.T) {
+	t.Setenv("APM_AGENT_SHARDS", "10.0.0.1:4317, 10.0.0.2:4317")
+	cfg := LoadFromEnv()
+	if cfg.Tracing == nil || cfg.Tracing.Shards == nil || len(cfg.Tracing.Shards.Endpoints) != 2 || cfg.Tracing.Shards.Endpoints[1] != "10.0.0.2:4317" {
+		t.Fatalf("tracing = %+v, want OTLP sharded over both agents", cfg.Tracing)
+	}
+
+	t.Setenv("APM_AGENT_SHARDS", "dns:otel-agent.monitoring.svc:4317")
+	if shards := ShardConfigFromEnv(); shards.DNSName != "otel-agent.monitoring.svc:4317" {
+		t.Errorf("shards = %+v", shards)
+	}
+}
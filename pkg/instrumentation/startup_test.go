@@ -0,0 +1,120 @@
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+// readyAfter returns a check that becomes healthy on the given attempt
+func readyAfter(attempt int32) HealthCheckFunc {
+	var calls atomic.Int32
+	return func(ctx context.Context) HealthCheck {
+		if calls.Add(1) < attempt {
+			return HealthCheck{Status: HealthStatusUnhealthy, Message: "connection refused"}
+		}
+		return HealthCheck{Status: HealthStatusHealthy}
+	}
+}
+
+func TestStartupGateWaitsAndDegrades(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	health := NewHealthChecker()
+	gate := NewStartupGate(StartupConfig{
+		Dependencies: []StartupDependency{
+			{Name: "postgres", Check: readyAfter(3), Required: true},
+			{Name: "redis", Check: readyAfter(1000), Timeout: 50 * time.Millisecond},
+		},
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Health:         health,
+		Logger:         zap.NewNop(),
+		Tracer:         sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"),
+	})
+	reg := prometheus.NewRegistry()
+	if err := gate.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := gate.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Ready) != 1 || result.Ready[0] != "postgres" || len(result.Degraded) != 1 || result.Degraded[0] != "redis" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if got := testutil.ToFloat64(gate.attempts.WithLabelValues("postgres", "not_ready")); got != 2 {
+		t.Errorf("expected 2 failed postgres checks, got %v", got)
+	}
+	if got := testutil.ToFloat64(gate.ready.WithLabelValues("redis", "false")); got != 0 {
+		t.Errorf("expected redis not ready, got %v", got)
+	}
+	if overall, _ := health.CheckHealth(context.Background()); overall != HealthStatusDegraded {
+		t.Errorf("expected readiness to report degraded, got %s", overall)
+	}
+
+	names := map[string]bool{}
+	for _, s := range recorder.Ended() {
+		names[s.Name()] = true
+	}
+	for _, name := range []string{"startup.dependencies", "startup.wait postgres", "startup.wait redis"} {
+		if !names[name] {
+			t.Errorf("expected span %q, got %v", name, names)
+		}
+	}
+}
+
+func TestStartupGateFailFast(t *testing.T) {
+	gate := NewStartupGate(StartupConfig{
+		Dependencies: []StartupDependency{
+			{Name: "broker", Check: readyAfter(1000), Required: true, Timeout: 20 * time.Millisecond},
+			{Name: "collector", Check: readyAfter(1000)},
+		},
+		Timeout:        time.Minute,
+		InitialBackoff: time.Millisecond,
+		FailFast:       true,
+		Logger:         zap.NewNop(),
+	})
+
+	start := time.Now()
+	result, err := gate.Wait(context.Background())
+	if !errors.Is(err, ErrStartupFailed) {
+		t.Fatalf("expected ErrStartupFailed, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("expected the optional wait to stop with the failed required one")
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != "broker" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if err := gate.Phase(context.Background(), "migrate", func(context.Context) error { return errors.New("locked") }); err == nil {
+		t.Error("expected the phase error to be returned")
+	}
+}
+
+func TestTCPHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	if check := TCPHealthCheck("cache", addr, time.Second)(context.Background()); check.Status != HealthStatusHealthy {
+		t.Errorf("expected healthy, got %+v", check)
+	}
+	ln.Close()
+	if check := TCPHealthCheck("cache", addr, time.Second)(context.Background()); check.Status != HealthStatusUnhealthy {
+		t.Errorf("expected unhealthy after close, got %+v", check)
+	}
+}
@@ -0,0 +1,204 @@
+package instrumentation
+
+import (
+	"fmt"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP metric temporalities, named as in
+// OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE
+const (
+	TemporalityCumulative = "cumulative"
+	TemporalityDelta      = "delta"
+	// TemporalityLowMemory is accepted for compatibility; Prometheus
+	// counters and histograms are all synchronous, so it matches delta
+	TemporalityLowMemory = "lowmemory"
+)
+
+// OTLP histogram aggregations, named as in
+// OTEL_EXPORTER_OTLP_METRICS_DEFAULT_HISTOGRAM_AGGREGATION
+const (
+	HistogramExplicitBuckets    = "explicit_bucket_histogram"
+	HistogramExponentialBuckets = "base2_exponential_bucket_histogram"
+)
+
+// validateTemporality checks a temporality name
+func validateTemporality(name string) error {
+	switch name {
+	case "", TemporalityCumulative, TemporalityDelta, TemporalityLowMemory:
+		return nil
+	}
+	return fmt.Errorf("temporality %q is not supported: use cumulative or delta", name)
+}
+
+// validateHistogramAggregation checks a histogram aggregation name
+func validateHistogramAggregation(name string) error {
+	switch name {
+	case "", HistogramExplicitBuckets, HistogramExponentialBuckets:
+		return nil
+	}
+	return fmt.Errorf("histogram aggregation %q is not supported: use %s or %s", name, HistogramExplicitBuckets, HistogramExponentialBuckets)
+}
+
+// isDelta reports whether sums and histograms are exported as deltas
+func isDelta(temporality string) bool {
+	return temporality == TemporalityDelta || temporality == TemporalityLowMemory
+}
+
+// nativeHistogram reports whether a Prometheus histogram carries native,
+// exponential buckets
+func nativeHistogram(h *dto.Histogram) bool {
+	if h.Schema == nil || h.GetSchema() < -4 || h.GetSchema() > 8 {
+		return false
+	}
+	return h.GetZeroThreshold() > 0 || len(h.GetPositiveSpan()) > 0 || len(h.GetNegativeSpan()) > 0
+}
+
+// exponentialPoint converts a native Prometheus histogram to an OTLP
+// exponential histogram point. Both use base 2^(2^-schema) buckets, but
+// Prometheus bucket i covers (base^(i-1), base^i] and OTLP bucket i covers
+// (base^i, base^(i+1)], so indexes shift by one.
+func exponentialPoint(s *dto.Metric, start, now uint64) *metricpb.ExponentialHistogramDataPoint {
+	h := s.GetHistogram()
+	sum := h.GetSampleSum()
+	return &metricpb.ExponentialHistogramDataPoint{
+		Attributes:        attributes(s.Label),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+		Scale:             h.GetSchema(),
+		ZeroCount:         h.GetZeroCount(),
+		ZeroThreshold:     h.GetZeroThreshold(),
+		Positive:          exponentialBuckets(h.GetPositiveSpan(), h.GetPositiveDelta()),
+		Negative:          exponentialBuckets(h.GetNegativeSpan(), h.GetNegativeDelta()),
+	}
+}
+
+// exponentialBuckets expands the spans and count deltas of native buckets
+// into dense OTLP buckets
+func exponentialBuckets(spans []*dto.BucketSpan, deltas []int64) *metricpb.ExponentialHistogramDataPoint_Buckets {
+	buckets := &metricpb.ExponentialHistogramDataPoint_Buckets{}
+	if len(spans) == 0 {
+		return buckets
+	}
+	buckets.Offset = spans[0].GetOffset() - 1
+	var count int64
+	next := 0
+	for i, span := range spans {
+		if i > 0 {
+			for gap := span.GetOffset(); gap > 0; gap-- {
+				buckets.BucketCounts = append(buckets.BucketCounts, 0)
+			}
+		}
+		for j := uint32(0); j < span.GetLength() && next < len(deltas); j++ {
+			count += deltas[next]
+			next++
+			buckets.BucketCounts = append(buckets.BucketCounts, uint64(count))
+		}
+	}
+	return buckets
+}
+
+// deltaState holds the cumulative points of the last successful OTLP push,
+// keyed by metric name and attributes, to turn the next push into deltas
+type deltaState map[string]proto.Message
+
+func pointKey(name string, attrs []*commonpb.KeyValue) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, kv := range attrs {
+		b.WriteString("\xff")
+		b.WriteString(kv.GetKey())
+		b.WriteString("=")
+		b.WriteString(kv.GetValue().GetStringValue())
+	}
+	return b.String()
+}
+
+// toDelta rewrites the cumulative sums and histograms of metrics as deltas
+// since prev, and returns the state for the next push. A point seen for the
+// first time, or whose counts went down, is sent whole: everything it holds
+// happened since the previous push or the start of the process. Gauges and
+// summaries have no temporality and are left alone.
+func toDelta(metrics []*metricpb.Metric, prev deltaState) deltaState {
+	next := make(deltaState, len(prev))
+	for _, m := range metrics {
+		switch data := m.Data.(type) {
+		case *metricpb.Metric_Sum:
+			data.Sum.AggregationTemporality = metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+			for _, p := range data.Sum.DataPoints {
+				key := pointKey(m.Name, p.Attributes)
+				next[key] = proto.Clone(p)
+				last, ok := prev[key].(*metricpb.NumberDataPoint)
+				if !ok {
+					continue
+				}
+				p.StartTimeUnixNano = last.TimeUnixNano
+				if p.GetAsDouble() >= last.GetAsDouble() {
+					p.Value = &metricpb.NumberDataPoint_AsDouble{AsDouble: p.GetAsDouble() - last.GetAsDouble()}
+				}
+			}
+		case *metricpb.Metric_Histogram:
+			data.Histogram.AggregationTemporality = metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+			for _, p := range data.Histogram.DataPoints {
+				key := pointKey(m.Name, p.Attributes)
+				next[key] = proto.Clone(p)
+				last, ok := prev[key].(*metricpb.HistogramDataPoint)
+				if !ok {
+					continue
+				}
+				p.StartTimeUnixNano = last.TimeUnixNano
+				if p.Count < last.Count || len(p.BucketCounts) != len(last.BucketCounts) {
+					continue
+				}
+				p.Count -= last.Count
+				sum := p.GetSum() - last.GetSum()
+				p.Sum = &sum
+				for i := range p.BucketCounts {
+					p.BucketCounts[i] -= last.BucketCounts[i]
+				}
+			}
+		case *metricpb.Metric_ExponentialHistogram:
+			data.ExponentialHistogram.AggregationTemporality = metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+			for _, p := range data.ExponentialHistogram.DataPoints {
+				key := pointKey(m.Name, p.Attributes)
+				next[key] = proto.Clone(p)
+				last, ok := prev[key].(*metricpb.ExponentialHistogramDataPoint)
+				if !ok {
+					continue
+				}
+				p.StartTimeUnixNano = last.TimeUnixNano
+				// Prometheus lowers the schema when a histogram has too
+				// many buckets; counts at different scales are not
+				// comparable, so such a point is sent whole
+				if p.Count < last.Count || p.Scale != last.Scale || p.ZeroCount < last.ZeroCount {
+					continue
+				}
+				p.Count -= last.Count
+				p.ZeroCount -= last.ZeroCount
+				sum := p.GetSum() - last.GetSum()
+				p.Sum = &sum
+				subtractBuckets(p.Positive, last.Positive)
+				subtractBuckets(p.Negative, last.Negative)
+			}
+		}
+	}
+	return next
+}
+
+// subtractBuckets subtracts the counts of last from b, bucket by bucket
+func subtractBuckets(b, last *metricpb.ExponentialHistogramDataPoint_Buckets) {
+	for i, n := range last.GetBucketCounts() {
+		j := int(last.Offset) + i - int(b.GetOffset())
+		if j < 0 || j >= len(b.GetBucketCounts()) || b.BucketCounts[j] < n {
+			continue
+		}
+		b.BucketCounts[j] -= n
+	}
+}
@@ -0,0 +1,149 @@
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector records the metrics of each accepted OTLP push, keyed by
+// name, and rejects requests while failing is set
+type otlpCollector struct {
+	failing bool
+	pushes  []map[string]*metricpb.Metric
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var req colmetricpb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	metrics := map[string]*metricpb.Metric{}
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	c.pushes = append(c.pushes, metrics)
+}
+
+func TestMetricsPusherDeltaTemporality(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	orders := prometheus.NewCounter(prometheus.CounterOpts{Name: "orders_total", Help: "Orders"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "Latency", Buckets: []float64{1}})
+	reg.MustRegister(orders, latency)
+
+	pusher, err := NewMetricsPusher(PushConfig{OTLPEndpoint: server.URL, Job: "api", Temporality: TemporalityDelta}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := func() {
+		t.Helper()
+		if err := pusher.Push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orders.Add(5)
+	latency.Observe(0.5)
+	push()
+	orders.Add(3)
+	latency.Observe(2)
+	push()
+	collector.failing = true
+	orders.Add(1)
+	if err := pusher.Push(context.Background()); err == nil {
+		t.Fatal("expected the rejected push to fail")
+	}
+	collector.failing = false
+	orders.Add(1)
+	push()
+
+	var values []float64
+	for _, metrics := range collector.pushes {
+		sum := metrics["orders_total"].GetSum()
+		if sum.AggregationTemporality != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+			t.Errorf("temporality = %v", sum.AggregationTemporality)
+		}
+		values = append(values, sum.DataPoints[0].GetAsDouble())
+	}
+	if fmt.Sprint(values) != "[5 3 2]" {
+		t.Errorf("counter deltas = %v, want [5 3 2]", values)
+	}
+
+	first := collector.pushes[0]["orders_total"].GetSum().DataPoints[0]
+	second := collector.pushes[1]["orders_total"].GetSum().DataPoints[0]
+	if second.StartTimeUnixNano != first.TimeUnixNano {
+		t.Error("a delta should start where the previous one ended")
+	}
+
+	point := collector.pushes[1]["latency_seconds"].GetHistogram().DataPoints[0]
+	if point.Count != 1 || point.GetSum() != 2 || fmt.Sprint(point.BucketCounts) != "[0 1]" {
+		t.Errorf("histogram delta = %v", point)
+	}
+	if point := collector.pushes[2]["latency_seconds"].GetHistogram().DataPoints[0]; point.Count != 0 {
+		t.Errorf("histogram delta without observations = %v", point)
+	}
+}
+
+func TestMetricsPusherExponentialHistograms(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:                        "latency_seconds",
+		Help:                        "Latency",
+		Buckets:                     []float64{1},
+		NativeHistogramBucketFactor: 2,
+	})
+	reg.MustRegister(latency)
+	latency.Observe(3)
+	latency.Observe(3)
+	latency.Observe(10)
+
+	pusher, err := NewMetricsPusher(PushConfig{OTLPEndpoint: server.URL, Job: "api", HistogramAggregation: HistogramExponentialBuckets}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	hist := collector.pushes[0]["latency_seconds"].GetExponentialHistogram()
+	if hist == nil {
+		t.Fatalf("expected an exponential histogram, got %v", collector.pushes[0]["latency_seconds"])
+	}
+	// Schema 0 buckets are powers of two: 3 falls in (2, 4] and 10 in
+	// (8, 16], OTLP buckets 1 and 3
+	point := hist.DataPoints[0]
+	if point.Scale != 0 || point.Count != 3 || point.Positive.Offset != 1 || fmt.Sprint(point.Positive.BucketCounts) != "[2 0 1]" {
+		t.Errorf("point = %v", point)
+	}
+}
+
+func TestPushTemporalityValidation(t *testing.T) {
+	if _, err := NewMetricsPusher(PushConfig{OTLPEndpoint: "http://collector:4318", Job: "api", Temporality: "monotonic"}, nil); err == nil {
+		t.Error("expected an unknown temporality to be rejected")
+	}
+	if _, err := NewMetricsPusher(PushConfig{OTLPEndpoint: "http://collector:4318", Job: "api", HistogramAggregation: "summary"}, nil); err == nil {
+		t.Error("expected an unknown histogram aggregation to be rejected")
+	}
+}
@@ -0,0 +1,146 @@
+package instrumentation
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Response headers set by trace echo
+const (
+	TraceIDHeader   = "Trace-Id"
+	TraceLinkHeader = "Trace-Link"
+)
+
+// MiddlewareOption configures FiberOtelMiddleware
+type MiddlewareOption func(*middlewareOptions)
+
+type middlewareOptions struct {
+	traceEcho *traceEcho
+}
+
+// TraceEchoConfig controls echoing the trace ID and a trace deep link in
+// response headers so support engineers can go from a reported request to
+// its trace. Echo is limited to allowlisted clients and to requests that
+// present the debug header.
+type TraceEchoConfig struct {
+	// AllowedClients are client IPs or CIDRs that always receive trace headers
+	AllowedClients []string
+
+	// DebugHeader requests trace headers; defaults to X-Debug-Trace
+	DebugHeader string
+
+	// DebugToken, when set, must match the debug header value
+	DebugToken string
+
+	// JaegerURL is the Jaeger UI base URL, e.g. http://jaeger:16686
+	JaegerURL string
+
+	// GrafanaURL and GrafanaDatasource link to Grafana Explore instead of Jaeger
+	GrafanaURL        string
+	GrafanaDatasource string
+}
+
+// WithTraceEcho echoes the trace ID and a deep link in response headers
+func WithTraceEcho(config TraceEchoConfig) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.traceEcho = newTraceEcho(config)
+	}
+}
+
+type traceEcho struct {
+	config  TraceEchoConfig
+	clients []*net.IPNet
+}
+
+// newTraceEcho parses the client allowlist; invalid entries are ignored
+func newTraceEcho(config TraceEchoConfig) *traceEcho {
+	if config.DebugHeader == "" {
+		config.DebugHeader = "X-Debug-Trace"
+	}
+
+	e := &traceEcho{config: config}
+	for _, entry := range config.AllowedClients {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 128
+				if ip.To4() != nil {
+					bits = 32
+				}
+				entry = ip.String() + "/" + strconv.Itoa(bits)
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			e.clients = append(e.clients, network)
+		}
+	}
+	return e
+}
+
+// enabled reports whether the request may receive trace headers
+func (e *traceEcho) enabled(c *fiber.Ctx) bool {
+	if value := c.Get(e.config.DebugHeader); value != "" {
+		if e.config.DebugToken == "" {
+			return true
+		}
+		if subtle.ConstantTimeCompare([]byte(value), []byte(e.config.DebugToken)) == 1 {
+			return true
+		}
+	}
+
+	if ip := net.ParseIP(c.IP()); ip != nil {
+		for _, network := range e.clients {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply sets the trace headers for a span
+func (e *traceEcho) apply(c *fiber.Ctx, sc trace.SpanContext) {
+	if !sc.HasTraceID() || !e.enabled(c) {
+		return
+	}
+
+	traceID := sc.TraceID().String()
+	c.Set(TraceIDHeader, traceID)
+
+	// Unsampled traces are never exported, so a link would lead nowhere
+	if sc.IsSampled() {
+		if link := e.link(traceID); link != "" {
+			c.Set(TraceLinkHeader, link)
+		}
+	}
+}
+
+// link returns the Grafana Explore or Jaeger UI URL for a trace
+func (e *traceEcho) link(traceID string) string {
+	if e.config.GrafanaURL != "" {
+		left, err := json.Marshal(map[string]interface{}{
+			"datasource": e.config.GrafanaDatasource,
+			"queries": []map[string]interface{}{{
+				"refId":      "A",
+				"datasource": map[string]string{"uid": e.config.GrafanaDatasource},
+				"query":      traceID,
+			}},
+			"range": map[string]string{"from": "now-1h", "to": "now"},
+		})
+		if err != nil {
+			return ""
+		}
+		return strings.TrimRight(e.config.GrafanaURL, "/") + "/explore?left=" + url.QueryEscape(string(left))
+	}
+
+	if e.config.JaegerURL != "" {
+		return strings.TrimRight(e.config.JaegerURL, "/") + "/trace/" + traceID
+	}
+	return ""
+}
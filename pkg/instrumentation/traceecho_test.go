@@ -0,0 +1,79 @@
+package instrumentation
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTraceEcho(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(previous)
+
+	// app.Test cannot set the remote address, so take the client IP from a header
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Use(FiberOtelMiddleware("test", WithTraceEcho(TraceEchoConfig{
+		AllowedClients: []string{"10.0.0.0/8"},
+		DebugToken:     "secret",
+		JaegerURL:      "http://jaeger:16686/",
+	})))
+	app.Get("/orders", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	tests := []struct {
+		name     string
+		header   string
+		clientIP string
+		echo     bool
+	}{
+		{"no debug header", "", "", false},
+		{"wrong token", "guess", "", false},
+		{"debug token", "secret", "", true},
+		{"allowlisted client", "", "10.1.2.3", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/orders", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Debug-Trace", tt.header)
+			}
+			if tt.clientIP != "" {
+				req.Header.Set(fiber.HeaderXForwardedFor, tt.clientIP)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			traceID := resp.Header.Get(TraceIDHeader)
+			link := resp.Header.Get(TraceLinkHeader)
+			if !tt.echo {
+				if traceID != "" || link != "" {
+					t.Errorf("expected no trace headers, got %q %q", traceID, link)
+				}
+				return
+			}
+			if len(traceID) != 32 {
+				t.Fatalf("expected a trace ID, got %q", traceID)
+			}
+			if link != "http://jaeger:16686/trace/"+traceID {
+				t.Errorf("unexpected trace link %q", link)
+			}
+		})
+	}
+}
+
+func TestTraceEchoGrafanaLink(t *testing.T) {
+	echo := newTraceEcho(TraceEchoConfig{GrafanaURL: "https://grafana.example.com", GrafanaDatasource: "tempo"})
+
+	link := echo.link("4bf92f3577b34da6a3ce929d0e0e4736")
+	if !strings.HasPrefix(link, "https://grafana.example.com/explore?left=") || !strings.Contains(link, "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("unexpected explore link %q", link)
+	}
+}
@@ -0,0 +1,133 @@
+package instrumentation
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceStateConfig controls the vendor entries of the W3C tracestate header.
+// Inbound entries are kept on every span of the trace and forwarded
+// downstream, so a partner's tracing system can follow a request through
+// this service.
+type TraceStateConfig struct {
+	// Key and Value add this service's own entry, placed first as the spec
+	// requires of the most recent participant; empty Key adds none
+	Key   string
+	Value string
+
+	// Allow lists the vendor keys kept from inbound requests; empty keeps all
+	Allow []string
+}
+
+// TraceStateConfigFromEnv reads TRACESTATE_ENTRY ("key=value") and
+// TRACESTATE_ALLOW (comma-separated vendor keys)
+func TraceStateConfigFromEnv() TraceStateConfig {
+	var config TraceStateConfig
+	if entry := os.Getenv("TRACESTATE_ENTRY"); entry != "" {
+		config.Key, config.Value, _ = strings.Cut(entry, "=")
+	}
+	config.Allow = getEnvSlice("TRACESTATE_ALLOW", nil)
+	return config
+}
+
+// enabled reports whether the config changes the inherited tracestate
+func (c TraceStateConfig) enabled() bool {
+	return c.Key != "" || len(c.Allow) > 0
+}
+
+// validate checks the own entry against the W3C key and value rules
+func (c TraceStateConfig) validate() error {
+	if c.Key == "" {
+		return nil
+	}
+	if _, err := (trace.TraceState{}).Insert(c.Key, c.Value); err != nil {
+		return fmt.Errorf("invalid tracestate entry %s=%s: %w", c.Key, c.Value, err)
+	}
+	return nil
+}
+
+// apply filters the inherited entries and inserts the own one
+func (c TraceStateConfig) apply(ts trace.TraceState) trace.TraceState {
+	if len(c.Allow) > 0 {
+		allowed := make(map[string]bool, len(c.Allow))
+		for _, key := range c.Allow {
+			allowed[strings.TrimSpace(key)] = true
+		}
+		var drop []string
+		ts.Walk(func(key, _ string) bool {
+			if !allowed[key] && key != c.Key {
+				drop = append(drop, key)
+			}
+			return true
+		})
+		for _, key := range drop {
+			ts = ts.Delete(key)
+		}
+	}
+	if c.Key != "" {
+		// Insert moves an existing entry to the front; at the 32 entry
+		// limit the oldest one is dropped to make room
+		if updated, err := ts.Insert(c.Key, c.Value); err == nil {
+			ts = updated
+		} else if ts.Len() >= 32 {
+			var last string
+			ts.Walk(func(key, _ string) bool {
+				last = key
+				return true
+			})
+			if updated, err := ts.Delete(last).Insert(c.Key, c.Value); err == nil {
+				ts = updated
+			}
+		}
+	}
+	return ts
+}
+
+// NewTraceStateSampler wraps a sampler so new spans carry the configured
+// tracestate. The SDK copies the sampler's tracestate to the span, so this
+// is where vendor entries are kept, filtered, and added.
+func NewTraceStateSampler(base sdktrace.Sampler, config TraceStateConfig) (sdktrace.Sampler, error) {
+	if !config.enabled() {
+		return base, nil
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &traceStateSampler{base: base, config: config}, nil
+}
+
+type traceStateSampler struct {
+	base   sdktrace.Sampler
+	config TraceStateConfig
+}
+
+// ShouldSample implements sdktrace.Sampler
+func (s *traceStateSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := s.base.ShouldSample(p)
+	result.Tracestate = s.config.apply(result.Tracestate)
+	return result
+}
+
+// Description implements sdktrace.Sampler
+func (s *traceStateSampler) Description() string {
+	return "TraceStateSampler{" + s.base.Description() + "}"
+}
+
+// requestHeaders returns the request headers for extraction. Fiber keeps
+// repeated headers as separate values, but the propagator reads only the
+// first; tracestate may be split over several header lines, so its values
+// are joined as the spec requires.
+func requestHeaders(c *fiber.Ctx) map[string][]string {
+	headers := c.GetReqHeaders()
+	for key, values := range headers {
+		if len(values) > 1 && strings.EqualFold(key, "tracestate") {
+			headers[key] = []string{strings.Join(values, ",")}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,55 @@
+package instrumentation
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTraceStatePropagation(t *testing.T) {
+	sampler, err := NewTraceStateSampler(sdktrace.AlwaysSample(), TraceStateConfig{
+		Key:   "apm",
+		Value: "s1",
+		Allow: []string{"congo", "rojo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	}()
+
+	var outbound map[string]string
+	app := fiber.New()
+	app.Use(FiberOtelMiddleware("test"))
+	app.Get("/", func(c *fiber.Ctx) error {
+		outbound = map[string]string{}
+		PropagateContext(c, outbound)
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	// Split over two header lines, with one vendor not on the allowlist
+	req.Header.Add("tracestate", "rojo=00f067aa0ba902b7,legacy=x")
+	req.Header.Add("tracestate", "congo=t61rcWkgMzE")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := outbound["tracestate"], "apm=s1,rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"; got != want {
+		t.Errorf("expected tracestate %q, got %q", want, got)
+	}
+
+	if _, err := NewTraceStateSampler(sdktrace.AlwaysSample(), TraceStateConfig{Key: "Bad Key"}); err == nil {
+		t.Error("expected an invalid key to be rejected")
+	}
+}
@@ -2,14 +2,13 @@ package instrumentation
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
@@ -18,26 +17,116 @@ import (
 
 // TracerConfig holds configuration for the tracer
 type TracerConfig struct {
+	// Preset is "local", "staging", or "production"; fields left at zero
+	// take the preset's value. Empty reads APM_PRESET.
+	Preset string
+
 	ServiceName    string
 	ServiceVersion string
 	Environment    string
-	ExporterType   string // "otlp", "jaeger", or "stdout"
+	ExporterType   string // "otlp" or "jaeger"
 	Endpoint       string
-	SampleRate     float64
+	// OTLP exporter settings: Protocol is "grpc" (default) or
+	// "http/protobuf", URLPath the HTTP path (default /v1/traces). Exports
+	// use plaintext unless TLS is set or FIPS mode is active.
+	Protocol string
+	Headers  map[string]string
+	TLS      bool
+	URLPath  string
+	Timeout  time.Duration
+	// Compression is "gzip", "zstd", or "none" (the default). The collector
+	// must accept the algorithm; zstd needs collector v0.64 or later.
+	Compression string
+	// MaxPayloadBytes splits export batches whose estimated size exceeds
+	// it, e.g. to stay under the collector's 4 MiB gRPC message limit
+	MaxPayloadBytes int
+	// CompressionStats counts export bytes before and after compression.
+	// Nil disables it.
+	CompressionStats *CompressionStats
+	// Sampler is an OTEL_TRACES_SAMPLER name such as parentbased_always_on;
+	// empty samples SampleRate of new traces regardless of the parent
+	Sampler    string
+	SampleRate float64
+	// Shards spreads spans over several local agents by trace ID instead of
+	// exporting to Endpoint. Nil disables sharding.
+	Shards *ShardConfig
+	// Batch processor settings; zero uses the preset or SDK default
+	BatchTimeout   time.Duration
+	MaxExportBatch int
+	MaxQueueSize   int
+	// Quota applies the span quota to new traces. Nil disables it.
+	Quota *QuotaManager
+	// Controls lets the admin API override the sample rate. Nil disables it.
+	Controls *Controls
+	// Dependencies records client spans to third-party hosts. Nil disables it.
+	Dependencies *DependencyTracker
+	// Crash keeps the active and recent spans for crash reports and
+	// flushes the provider after a crash. Nil disables it.
+	Crash *CrashReporter
+	// Kubernetes adds the pod's Kubernetes metadata to every span. Nil
+	// disables it.
+	Kubernetes *KubernetesEnricher
+	// ClockSkew corrects the timestamps of exported spans when its Correct
+	// is set. Nil disables it.
+	ClockSkew *ClockSkewMonitor
+	// Semconv translates exported attributes to a semantic convention
+	// version. Zero reads SemconvConfigFromEnv.
+	Semconv SemconvConfig
+	// IDGenerator creates trace and span IDs, e.g. XRayIDGenerator. Nil
+	// reads IDGeneratorFromEnv.
+	IDGenerator sdktrace.IDGenerator
+	// TraceState filters and adds vendor tracestate entries. Zero reads
+	// TraceStateConfigFromEnv.
+	TraceState TraceStateConfig
+	// Propagation selects the inbound and outbound trace context formats.
+	// Zero reads PropagationConfigFromEnv.
+	Propagation PropagationConfig
 }
 
 // InitTracer initializes the OpenTelemetry tracer with the specified configuration
 func InitTracer(ctx context.Context, config TracerConfig) (trace.TracerProvider, func(), error) {
-	// Create resource
+	if err := config.applyPreset(); err != nil {
+		return nil, nil, err
+	}
+	if config.Semconv == (SemconvConfig{}) {
+		config.Semconv = SemconvConfigFromEnv()
+	}
+	if !config.TraceState.enabled() {
+		config.TraceState = TraceStateConfigFromEnv()
+	}
+	if config.Propagation.Extract == nil && config.Propagation.Inject == nil {
+		config.Propagation = PropagationConfigFromEnv()
+	}
+	propagator, err := NewPropagator(config.Propagation)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.IDGenerator == nil {
+		idGenerator, err := IDGeneratorFromEnv()
+		if err != nil {
+			return nil, nil, err
+		}
+		config.IDGenerator = idGenerator
+	}
+	schemaURL := semconv.SchemaURL
+	if config.Semconv.Version != "" {
+		schemaURL = config.Semconv.SchemaURL()
+	}
+
+	// Create resource. The SDK's default resource follows a newer schema;
+	// its attributes are kept under the exported one.
 	res, err := resource.Merge(
 		resource.Default(),
 		resource.NewWithAttributes(
-			semconv.SchemaURL,
+			schemaURL,
 			semconv.ServiceNameKey.String(config.ServiceName),
 			semconv.ServiceVersionKey.String(config.ServiceVersion),
 			semconv.DeploymentEnvironmentKey.String(config.Environment),
 		),
 	)
+	if errors.Is(err, resource.ErrSchemaURLConflict) {
+		res, err = resource.NewWithAttributes(schemaURL, res.Attributes()...), nil
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create resource: %w", err)
 	}
@@ -46,34 +135,66 @@ func InitTracer(ctx context.Context, config TracerConfig) (trace.TracerProvider,
 	var exporter sdktrace.SpanExporter
 	switch config.ExporterType {
 	case "otlp":
-		exporter, err = createOTLPExporter(ctx, config.Endpoint)
+		exporter, err = createOTLPExporter(ctx, config)
 	case "jaeger":
 		exporter, err = createJaegerExporter(config.Endpoint)
 	default:
 		return nil, nil, fmt.Errorf("unsupported exporter type: %s", config.ExporterType)
 	}
+	if err == nil {
+		exporter, err = NewSemconvExporter(exporter, config.Semconv)
+	}
+	if err == nil && config.ClockSkew != nil {
+		exporter = config.ClockSkew.Exporter(exporter)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create exporter: %w", err)
 	}
 
 	// Create sampler
-	sampler := sdktrace.TraceIDRatioBased(config.SampleRate)
+	sampler, err := newSampler(config.Sampler, config.SampleRate)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.Controls != nil {
+		sampler = config.Controls.Sampler(sampler)
+	}
+	if config.Quota != nil {
+		sampler = config.Quota.Sampler(sampler)
+	}
+	sampler, err = NewTraceStateSampler(sampler, config.TraceState)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Create tracer provider
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exporter, config.batchOptions()...),
 		sdktrace.WithResource(res),
 		sdktrace.WithSampler(sampler),
-	)
+	}
+	if config.Kubernetes != nil {
+		opts = append(opts, sdktrace.WithSpanProcessor(config.Kubernetes))
+	}
+	if config.Dependencies != nil {
+		opts = append(opts, sdktrace.WithSpanProcessor(config.Dependencies))
+	}
+	if config.IDGenerator != nil {
+		opts = append(opts, sdktrace.WithIDGenerator(config.IDGenerator))
+	}
+	if config.Crash != nil {
+		opts = append(opts, sdktrace.WithSpanProcessor(config.Crash))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	if config.Crash != nil {
+		config.Crash.OnCrash(tp.ForceFlush)
+	}
 
 	// Set global tracer provider
 	otel.SetTracerProvider(tp)
 
 	// Set global propagator
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
+	otel.SetTextMapPropagator(propagator)
 
 	// Return cleanup function
 	cleanup := func() {
@@ -87,13 +208,58 @@ func InitTracer(ctx context.Context, config TracerConfig) (trace.TracerProvider,
 	return tp, cleanup, nil
 }
 
-// createOTLPExporter creates an OTLP exporter
-func createOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
-	client := otlptracegrpc.NewClient(
-		otlptracegrpc.WithEndpoint(endpoint),
-		otlptracegrpc.WithInsecure(),
-	)
-	return otlptrace.New(ctx, client)
+// batchOptions returns the batch processor options that are set
+func (c TracerConfig) batchOptions() []sdktrace.BatchSpanProcessorOption {
+	var opts []sdktrace.BatchSpanProcessorOption
+	if c.BatchTimeout > 0 {
+		opts = append(opts, sdktrace.WithBatchTimeout(c.BatchTimeout))
+	}
+	if c.MaxExportBatch > 0 {
+		opts = append(opts, sdktrace.WithMaxExportBatchSize(c.MaxExportBatch))
+	}
+	if c.MaxQueueSize > 0 {
+		opts = append(opts, sdktrace.WithMaxQueueSize(c.MaxQueueSize))
+	}
+	return opts
+}
+
+// createOTLPExporter creates an OTLP exporter. FIPS mode switches the
+// connection from plaintext to TLS restricted to approved algorithms.
+func createOTLPExporter(ctx context.Context, config TracerConfig) (sdktrace.SpanExporter, error) {
+	exporterConfig := ExporterConfig{
+		Type:     "otlp-grpc",
+		Endpoint: config.Endpoint,
+		Headers:  config.Headers,
+		Insecure: !config.TLS && !tlspolicy.FIPSEnabled(),
+		URLPath:  config.URLPath,
+		Timeout:  config.Timeout,
+
+		Compression:      config.Compression,
+		MaxPayloadBytes:  config.MaxPayloadBytes,
+		CompressionStats: config.CompressionStats,
+	}
+	if err := validateCompression(config.Compression); err != nil {
+		return nil, err
+	}
+	var create func(context.Context, ExporterConfig) (sdktrace.SpanExporter, error)
+	switch config.Protocol {
+	case "", ProtocolGRPC:
+		create = createOTLPGRPCExporter
+	case ProtocolHTTPProtobuf:
+		exporterConfig.Type = "otlp-http"
+		create = createOTLPHTTPExporter
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol: %s", config.Protocol)
+	}
+
+	if config.Shards != nil {
+		return NewShardedExporter(ctx, *config.Shards, func(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+			shard := exporterConfig
+			shard.Endpoint = endpoint
+			return create(ctx, shard)
+		})
+	}
+	return create(ctx, exporterConfig)
 }
 
 // createJaegerExporter creates a Jaeger exporter
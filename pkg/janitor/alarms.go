@@ -0,0 +1,118 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CloudWatchAlarms collects CloudWatch alarms on EC2 instances that are
+// terminated or no longer exist. Alarms without an InstanceId dimension are
+// left alone.
+type CloudWatchAlarms struct {
+	Region string
+	// Run executes the aws CLI; nil uses os/exec
+	Run CommandRunner
+}
+
+// Kind implements Sweeper
+func (a *CloudWatchAlarms) Kind() Kind { return KindAlarm }
+
+// Find implements Sweeper
+func (a *CloudWatchAlarms) Find(ctx context.Context) ([]Orphan, error) {
+	output, err := a.aws(ctx, "cloudwatch", "describe-alarms", "--alarm-types", "MetricAlarm", "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alarms: %w", err)
+	}
+	var alarms struct {
+		MetricAlarms []struct {
+			AlarmName  string `json:"AlarmName"`
+			Dimensions []struct {
+				Name  string `json:"Name"`
+				Value string `json:"Value"`
+			} `json:"Dimensions"`
+		} `json:"MetricAlarms"`
+	}
+	if err := json.Unmarshal(output, &alarms); err != nil {
+		return nil, fmt.Errorf("invalid describe-alarms output: %w", err)
+	}
+
+	byInstance := map[string][]string{}
+	var instances []string
+	for _, alarm := range alarms.MetricAlarms {
+		for _, d := range alarm.Dimensions {
+			if d.Name != "InstanceId" {
+				continue
+			}
+			if _, ok := byInstance[d.Value]; !ok {
+				instances = append(instances, d.Value)
+			}
+			byInstance[d.Value] = append(byInstance[d.Value], alarm.AlarmName)
+		}
+	}
+	if len(instances) == 0 {
+		return nil, nil
+	}
+
+	states, err := a.instanceStates(ctx, instances)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Orphan
+	for _, id := range instances {
+		state, ok := states[id]
+		var reason string
+		switch {
+		case !ok:
+			reason = fmt.Sprintf("instance %s no longer exists", id)
+		case state == "terminated" || state == "shutting-down":
+			reason = fmt.Sprintf("instance %s is %s", id, state)
+		default:
+			continue
+		}
+		for _, name := range byInstance[id] {
+			orphans = append(orphans, Orphan{Kind: KindAlarm, ID: name, Name: name, Location: a.Region, Reason: reason})
+		}
+	}
+	sortOrphans(orphans)
+	return orphans, nil
+}
+
+// Remove implements Sweeper
+func (a *CloudWatchAlarms) Remove(ctx context.Context, o Orphan) error {
+	_, err := a.aws(ctx, "cloudwatch", "delete-alarms", "--alarm-names", o.ID)
+	return err
+}
+
+// instanceStates returns the state of each instance EC2 still knows
+func (a *CloudWatchAlarms) instanceStates(ctx context.Context, ids []string) (map[string]string, error) {
+	// Filtering instead of passing --instance-ids keeps unknown IDs from
+	// failing the whole call
+	output, err := a.aws(ctx, "ec2", "describe-instances",
+		"--filters", "Name=instance-id,Values="+strings.Join(ids, ","),
+		"--query", "Reservations[].Instances[].{id:InstanceId,state:State.Name}",
+		"--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instances: %w", err)
+	}
+	var instances []struct {
+		ID    string `json:"id"`
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(output, &instances); err != nil {
+		return nil, fmt.Errorf("invalid describe-instances output: %w", err)
+	}
+	states := make(map[string]string, len(instances))
+	for _, i := range instances {
+		states[i.ID] = i.State
+	}
+	return states, nil
+}
+
+func (a *CloudWatchAlarms) aws(ctx context.Context, args ...string) ([]byte, error) {
+	if a.Region != "" {
+		args = append(args, "--region", a.Region)
+	}
+	return runner(a.Run)(ctx, "aws", args...)
+}
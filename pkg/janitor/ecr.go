@@ -0,0 +1,169 @@
+package janitor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ecrRegistry matches ECR registry hosts, e.g.
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com
+var ecrRegistry = regexp.MustCompile(`^(https://)?\d{12}\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(\.cn)?/?$`)
+
+// ECRTokens collects expired ECR login tokens stored in a Docker config
+// file. Credentials kept by a credential helper are not in the file and are
+// left alone.
+type ECRTokens struct {
+	// ConfigPath is the Docker config file, $DOCKER_CONFIG/config.json or
+	// ~/.docker/config.json by default
+	ConfigPath string
+	now        func() time.Time
+}
+
+// Kind implements Sweeper
+func (e *ECRTokens) Kind() Kind { return KindECRToken }
+
+// Find implements Sweeper
+func (e *ECRTokens) Find(ctx context.Context) ([]Orphan, error) {
+	path, err := e.path()
+	if err != nil {
+		return nil, err
+	}
+	_, auths, err := readDockerConfig(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if e.now != nil {
+		now = e.now()
+	}
+	var orphans []Orphan
+	for registry, raw := range auths {
+		if !ecrRegistry.MatchString(registry) {
+			continue
+		}
+		var entry struct {
+			Auth string `json:"auth"`
+		}
+		if json.Unmarshal(raw, &entry) != nil || entry.Auth == "" {
+			continue
+		}
+		expiration, err := ecrExpiration(entry.Auth)
+		if err != nil || expiration.After(now) {
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			Kind:     KindECRToken,
+			ID:       registry,
+			Name:     registry,
+			Location: path,
+			Reason:   "token expired " + expiration.UTC().Format(time.RFC3339),
+		})
+	}
+	sortOrphans(orphans)
+	return orphans, nil
+}
+
+// Remove implements Sweeper, deleting the registry's entry from the config
+// file and keeping everything else
+func (e *ECRTokens) Remove(ctx context.Context, o Orphan) error {
+	path, err := e.path()
+	if err != nil {
+		return err
+	}
+	config, auths, err := readDockerConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := auths[o.ID]; !ok {
+		return nil
+	}
+	delete(auths, o.ID)
+
+	data, err := json.Marshal(auths)
+	if err != nil {
+		return err
+	}
+	config["auths"] = data
+	out, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), info.Mode().Perm())
+}
+
+func (e *ECRTokens) path() (string, error) {
+	if e.ConfigPath != "" {
+		return e.ConfigPath, nil
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// readDockerConfig reads a Docker config file, keeping the fields it does
+// not know as they are
+func readDockerConfig(path string) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("invalid docker config %s: %w", path, err)
+	}
+	auths := map[string]json.RawMessage{}
+	if raw, ok := config["auths"]; ok {
+		if err := json.Unmarshal(raw, &auths); err != nil {
+			return nil, nil, fmt.Errorf("invalid auths in docker config %s: %w", path, err)
+		}
+	}
+	return config, auths, nil
+}
+
+// ecrExpiration returns when an ECR login token expires. The auth is
+// base64 of AWS:<password>, and the password is itself base64 JSON carrying
+// the expiration as a Unix time.
+func ecrExpiration(auth string) (time.Time, error) {
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return time.Time{}, fmt.Errorf("auth is not user:password")
+	}
+	payload, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var token struct {
+		Expiration int64 `json:"expiration"`
+	}
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return time.Time{}, err
+	}
+	if token.Expiration == 0 {
+		return time.Time{}, fmt.Errorf("token has no expiration")
+	}
+	return time.Unix(token.Expiration, 0), nil
+}
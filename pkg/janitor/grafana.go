@@ -0,0 +1,156 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ServiceTagPrefix prefixes the Grafana tag naming the service a dashboard
+// belongs to, e.g. service:checkout
+const ServiceTagPrefix = "service:"
+
+// GrafanaDashboards collects dashboards tagged apm whose service has not
+// reported to Prometheus within the lookback window. Dashboards without a
+// service tag are left alone.
+type GrafanaDashboards struct {
+	GrafanaURL string
+	// Token is a Grafana API key or service account token
+	Token         string
+	PrometheusURL string
+	// Lookback is how long a service must have been silent, 7 days by default
+	Lookback time.Duration
+	Client   *http.Client
+}
+
+// Kind implements Sweeper
+func (g *GrafanaDashboards) Kind() Kind { return KindDashboard }
+
+// Find implements Sweeper
+func (g *GrafanaDashboards) Find(ctx context.Context) ([]Orphan, error) {
+	live, err := g.liveServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(live) == 0 {
+		// An empty Prometheus would make every dashboard look orphaned
+		return nil, errors.New("no services reported to prometheus in the lookback window, refusing to collect dashboards")
+	}
+
+	var dashboards []struct {
+		UID         string   `json:"uid"`
+		Title       string   `json:"title"`
+		Tags        []string `json:"tags"`
+		FolderTitle string   `json:"folderTitle"`
+	}
+	params := url.Values{"type": {"dash-db"}, "tag": {"apm"}}
+	if err := g.do(ctx, http.MethodGet, "/api/search?"+params.Encode(), &dashboards); err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	for _, d := range dashboards {
+		for _, tag := range d.Tags {
+			service, ok := strings.CutPrefix(tag, ServiceTagPrefix)
+			if !ok || live[service] {
+				continue
+			}
+			orphans = append(orphans, Orphan{
+				Kind:     KindDashboard,
+				ID:       d.UID,
+				Name:     d.Title,
+				Location: d.FolderTitle,
+				Reason:   fmt.Sprintf("service %s has not reported for %s", service, g.lookback()),
+			})
+			break
+		}
+	}
+	return orphans, nil
+}
+
+// Remove implements Sweeper
+func (g *GrafanaDashboards) Remove(ctx context.Context, o Orphan) error {
+	return g.do(ctx, http.MethodDelete, "/api/dashboards/uid/"+url.PathEscape(o.ID), nil)
+}
+
+func (g *GrafanaDashboards) lookback() time.Duration {
+	if g.Lookback <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return g.Lookback
+}
+
+// liveServices returns the jobs with an up series in the lookback window
+func (g *GrafanaDashboards) liveServices(ctx context.Context) (map[string]bool, error) {
+	query := fmt.Sprintf("count by (job) (count_over_time(up[%ds]))", int(g.lookback().Seconds()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(g.PrometheusURL, "/")+"/api/v1/query?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	if out.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s", out.Error)
+	}
+	live := map[string]bool{}
+	for _, r := range out.Data.Result {
+		live[r.Metric["job"]] = true
+	}
+	return live, nil
+}
+
+func (g *GrafanaDashboards) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(g.GrafanaURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("grafana %s %s returned %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from grafana %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+func (g *GrafanaDashboards) client() *http.Client {
+	if g.Client == nil {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+	return g.Client
+}
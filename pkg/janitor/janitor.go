@@ -0,0 +1,133 @@
+// Package janitor finds and removes telemetry resources left behind by
+// services that no longer exist: dashboards of services that stopped
+// reporting, alarms on terminated instances, port allocations nothing
+// listens on, expired registry credentials, and kubeconfig contexts for
+// deleted clusters.
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+	"github.com/chaksack/apm/pkg/progress"
+)
+
+// Kind is a kind of resource the janitor collects
+type Kind string
+
+const (
+	KindDashboard   Kind = "dashboard"
+	KindAlarm       Kind = "alarm"
+	KindPort        Kind = "port"
+	KindECRToken    Kind = "ecr-token"
+	KindKubeContext Kind = "kube-context"
+)
+
+// Kinds lists every kind in the order the janitor sweeps them
+var Kinds = []Kind{KindDashboard, KindAlarm, KindPort, KindECRToken, KindKubeContext}
+
+// Orphan is a resource whose owner is gone
+type Orphan struct {
+	Kind Kind `json:"kind"`
+	// ID identifies the resource to the sweeper that found it
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Location is where the resource lives, e.g. a region or a file
+	Location string `json:"location,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+func (o Orphan) String() string {
+	s := fmt.Sprintf("%s %s", o.Kind, o.Name)
+	if o.Location != "" {
+		s += " (" + o.Location + ")"
+	}
+	return s
+}
+
+// Sweeper finds and removes the orphans of one kind
+type Sweeper interface {
+	Kind() Kind
+	Find(ctx context.Context) ([]Orphan, error)
+	Remove(ctx context.Context, o Orphan) error
+}
+
+// Janitor runs a set of sweepers
+type Janitor struct {
+	Sweepers []Sweeper
+}
+
+// Report is the outcome of a search for orphans. A sweeper that fails, for
+// example because its backend is unreachable, does not stop the others.
+type Report struct {
+	Orphans []Orphan
+	Errors  map[Kind]error
+}
+
+// Find returns the orphans every sweeper finds
+func (j *Janitor) Find(ctx context.Context) *Report {
+	report := &Report{Errors: map[Kind]error{}}
+	for _, s := range j.Sweepers {
+		orphans, err := s.Find(ctx)
+		if err != nil {
+			report.Errors[s.Kind()] = err
+			continue
+		}
+		report.Orphans = append(report.Orphans, orphans...)
+	}
+	return report
+}
+
+// Remove removes orphans, returning those removed. It keeps going past
+// failures, which are returned together.
+func (j *Janitor) Remove(ctx context.Context, orphans []Orphan) ([]Orphan, error) {
+	sweepers := map[Kind]Sweeper{}
+	for _, s := range j.Sweepers {
+		sweepers[s.Kind()] = s
+	}
+
+	tracker := progress.FromContext(ctx)
+	tracker.AddTotal(len(orphans))
+
+	var removed []Orphan
+	var errs []error
+	for _, o := range orphans {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		tracker.Step(o.String())
+
+		s, ok := sweepers[o.Kind]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no sweeper for %s", o, o.Kind))
+			tracker.Advance(1)
+			continue
+		}
+		err := s.Remove(ctx, o)
+		tracker.Advance(1)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o, err))
+			continue
+		}
+		removed = append(removed, o)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// sortOrphans orders orphans by name, for stable output
+func sortOrphans(orphans []Orphan) {
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Name < orphans[j].Name })
+}
+
+// CommandRunner runs an external command and returns its standard output
+type CommandRunner = cmdrun.Runner
+
+func runner(run CommandRunner) CommandRunner {
+	if run == nil {
+		return cmdrun.Exec
+	}
+	return run
+}
@@ -0,0 +1,308 @@
+package janitor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/apmclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeRunner answers commands by their joined arguments and records them
+type fakeRunner struct {
+	outputs map[string]string
+	calls   []string
+}
+
+func (f *fakeRunner) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	call := name + " " + strings.Join(args, " ")
+	f.calls = append(f.calls, call)
+	for prefix, output := range f.outputs {
+		if strings.HasPrefix(call, prefix) {
+			return []byte(output), nil
+		}
+	}
+	return nil, nil
+}
+
+func names(orphans []Orphan) string {
+	var s []string
+	for _, o := range orphans {
+		s = append(s, o.Name)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestGrafanaDashboards(t *testing.T) {
+	var deleted []string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"checkout"},"value":[0,"1"]}]}}`))
+	}))
+	defer prometheus.Close()
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, r.URL.Path)
+			return
+		}
+		w.Write([]byte(`[
+			{"uid":"a","title":"Checkout","tags":["apm","service:checkout"]},
+			{"uid":"b","title":"Payments","tags":["apm","service:payments"],"folderTitle":"Services"},
+			{"uid":"c","title":"Overview","tags":["apm"]}
+		]`))
+	}))
+	defer grafana.Close()
+
+	g := &GrafanaDashboards{GrafanaURL: grafana.URL, Token: "secret", PrometheusURL: prometheus.URL}
+	orphans, err := g.Find(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(orphans) != "Payments" || orphans[0].Location != "Services" {
+		t.Fatalf("orphans = %+v", orphans)
+	}
+	if err := g.Remove(context.Background(), orphans[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/api/dashboards/uid/b" {
+		t.Errorf("deleted = %v", deleted)
+	}
+}
+
+func TestGrafanaDashboardsRefusesWithoutLiveServices(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer prometheus.Close()
+
+	g := &GrafanaDashboards{GrafanaURL: "http://127.0.0.1:0", PrometheusURL: prometheus.URL}
+	if _, err := g.Find(context.Background()); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Errorf("err = %v, want a refusal", err)
+	}
+}
+
+func TestCloudWatchAlarms(t *testing.T) {
+	run := &fakeRunner{outputs: map[string]string{
+		"aws cloudwatch describe-alarms": `{"MetricAlarms":[
+			{"AlarmName":"web-1-cpu","Dimensions":[{"Name":"InstanceId","Value":"i-1"}]},
+			{"AlarmName":"web-2-cpu","Dimensions":[{"Name":"InstanceId","Value":"i-2"}]},
+			{"AlarmName":"web-3-cpu","Dimensions":[{"Name":"InstanceId","Value":"i-3"}]},
+			{"AlarmName":"queue-depth","Dimensions":[{"Name":"QueueName","Value":"jobs"}]}
+		]}`,
+		"aws ec2 describe-instances": `[{"id":"i-1","state":"running"},{"id":"i-2","state":"terminated"}]`,
+	}}
+	a := &CloudWatchAlarms{Region: "us-east-1", Run: run.run}
+	orphans, err := a.Find(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(orphans) != "web-2-cpu,web-3-cpu" {
+		t.Fatalf("orphans = %+v", orphans)
+	}
+	if !strings.Contains(orphans[1].Reason, "no longer exists") {
+		t.Errorf("reason = %s", orphans[1].Reason)
+	}
+	if err := a.Remove(context.Background(), orphans[0]); err != nil {
+		t.Fatal(err)
+	}
+	if last := run.calls[len(run.calls)-1]; last != "aws cloudwatch delete-alarms --alarm-names web-2-cpu --region us-east-1" {
+		t.Errorf("delete call = %s", last)
+	}
+}
+
+func TestPortAllocations(t *testing.T) {
+	var released []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			released = append(released, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"allocated_ports":{"9090":"prometheus","3000":"grafana"},"count":2}`))
+	}))
+	defer server.Close()
+
+	p := &PortAllocations{
+		Client:    apmclient.NewClient(server.URL),
+		Listening: func(ctx context.Context, port int) bool { return port == 3000 },
+	}
+	orphans, err := p.Find(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(orphans) != "9090" || orphans[0].Location != "prometheus" {
+		t.Fatalf("orphans = %+v", orphans)
+	}
+	if err := p.Remove(context.Background(), orphans[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0] != "/tools/ports/9090" {
+		t.Errorf("released = %v", released)
+	}
+}
+
+func ecrAuth(expiration time.Time) string {
+	payload, _ := json.Marshal(map[string]any{"payload": "x", "expiration": expiration.Unix()})
+	return base64.StdEncoding.EncodeToString([]byte("AWS:" + base64.StdEncoding.EncodeToString(payload)))
+}
+
+func TestECRTokens(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	config := map[string]any{
+		"auths": map[string]any{
+			"111111111111.dkr.ecr.us-east-1.amazonaws.com": map[string]string{"auth": ecrAuth(now.Add(-time.Hour))},
+			"222222222222.dkr.ecr.eu-west-1.amazonaws.com": map[string]string{"auth": ecrAuth(now.Add(time.Hour))},
+			"ghcr.io": map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte("me:token"))},
+		},
+		"credHelpers": map[string]string{"gcr.io": "gcloud"},
+	}
+	data, _ := json.Marshal(config)
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &ECRTokens{ConfigPath: path, now: func() time.Time { return now }}
+	orphans, err := e.Find(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(orphans) != "111111111111.dkr.ecr.us-east-1.amazonaws.com" {
+		t.Fatalf("orphans = %+v", orphans)
+	}
+	if err := e.Remove(context.Background(), orphans[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Auths       map[string]any    `json:"auths"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Auths) != 2 || got.Auths["111111111111.dkr.ecr.us-east-1.amazonaws.com"] != nil {
+		t.Errorf("auths = %v", got.Auths)
+	}
+	if got.CredHelpers["gcr.io"] != "gcloud" {
+		t.Errorf("credHelpers lost: %s", data)
+	}
+}
+
+func TestKubeContexts(t *testing.T) {
+	run := &fakeRunner{outputs: map[string]string{
+		"kubectl --kubeconfig kc config view": `{"contexts":[
+			{"name":"prod","context":{"cluster":"arn:aws:eks:us-east-1:111111111111:cluster/prod","user":"arn:aws:eks:us-east-1:111111111111:cluster/prod"}},
+			{"name":"old","context":{"cluster":"arn:aws:eks:us-east-1:111111111111:cluster/old","user":"shared"}},
+			{"name":"old-admin","context":{"cluster":"arn:aws:eks:us-east-1:111111111111:cluster/old","user":"admin"}},
+			{"name":"kind","context":{"cluster":"kind-kind","user":"shared"}}
+		]}`,
+		"aws eks list-clusters --region us-east-1": `{"clusters":["prod"]}`,
+	}}
+	k := &KubeContexts{Kubeconfig: "kc", Run: run.run}
+	orphans, err := k.Find(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(orphans) != "old,old-admin" {
+		t.Fatalf("orphans = %+v", orphans)
+	}
+
+	run.calls = nil
+	if err := k.Remove(context.Background(), orphans[0]); err != nil {
+		t.Fatal(err)
+	}
+	// The cluster is still used by old-admin and the user by kind
+	want := []string{"kubectl --kubeconfig kc config view -o json", "kubectl --kubeconfig kc config delete-context old"}
+	if strings.Join(run.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls = %q", run.calls)
+	}
+}
+
+type stubSweeper struct {
+	kind    Kind
+	orphans []Orphan
+	findErr error
+	removed []string
+}
+
+func (s *stubSweeper) Kind() Kind { return s.kind }
+
+func (s *stubSweeper) Find(ctx context.Context) ([]Orphan, error) { return s.orphans, s.findErr }
+
+func (s *stubSweeper) Remove(ctx context.Context, o Orphan) error {
+	if o.ID == "stuck" {
+		return errors.New("access denied")
+	}
+	s.removed = append(s.removed, o.ID)
+	return nil
+}
+
+func TestJanitor(t *testing.T) {
+	ports := &stubSweeper{kind: KindPort, orphans: []Orphan{
+		{Kind: KindPort, ID: "9090", Name: "9090"},
+		{Kind: KindPort, ID: "stuck", Name: "stuck"},
+	}}
+	alarms := &stubSweeper{kind: KindAlarm, findErr: errors.New("no credentials")}
+	j := &Janitor{Sweepers: []Sweeper{ports, alarms}}
+
+	report := j.Find(context.Background())
+	if len(report.Orphans) != 2 || report.Errors[KindAlarm] == nil {
+		t.Fatalf("report = %+v", report)
+	}
+	removed, err := j.Remove(context.Background(), report.Orphans)
+	if names(removed) != "9090" || err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("removed %v, err %v", removed, err)
+	}
+}
+
+// staticSweeper finds a fixed list of orphans, or fails with err
+type staticSweeper struct {
+	kind    Kind
+	orphans []Orphan
+	err     error
+}
+
+func (s *staticSweeper) Kind() Kind { return s.kind }
+
+func (s *staticSweeper) Find(context.Context) ([]Orphan, error) { return s.orphans, s.err }
+
+func (s *staticSweeper) Remove(context.Context, Orphan) error {
+	return errors.New("the monitor must not remove orphans")
+}
+
+func TestMonitorReportsOrphans(t *testing.T) {
+	dashboards := &staticSweeper{kind: KindDashboard, orphans: []Orphan{{Kind: KindDashboard, Name: "a"}, {Kind: KindDashboard, Name: "b"}}}
+	ports := &staticSweeper{kind: KindPort}
+	m := &Monitor{Janitor: &Janitor{Sweepers: []Sweeper{dashboards, ports}}}
+
+	m.Check(context.Background())
+	if got := testutil.ToFloat64(m.gauge().WithLabelValues("dashboard")); got != 2 {
+		t.Errorf("dashboards = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.gauge().WithLabelValues("port")); got != 0 {
+		t.Errorf("ports = %v, want 0", got)
+	}
+
+	dashboards.err = errors.New("grafana unreachable")
+	m.Check(context.Background())
+	if got := testutil.ToFloat64(m.gauge().WithLabelValues("dashboard")); got != 2 {
+		t.Errorf("a failed search changed the count to %v", got)
+	}
+}
@@ -0,0 +1,159 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KubeContexts collects kubeconfig contexts for EKS clusters that have been
+// deleted, together with their cluster and user entries when no other
+// context uses them. Contexts of other clusters are left alone.
+type KubeContexts struct {
+	// Kubeconfig is the kubeconfig file, kubectl's default when empty
+	Kubeconfig string
+	// Run executes kubectl and the aws CLI; nil uses os/exec
+	Run CommandRunner
+}
+
+type kubeConfig struct {
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+}
+
+// Kind implements Sweeper
+func (k *KubeContexts) Kind() Kind { return KindKubeContext }
+
+// Find implements Sweeper
+func (k *KubeContexts) Find(ctx context.Context) ([]Orphan, error) {
+	config, err := k.view(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := map[string]map[string]bool{}
+	var orphans []Orphan
+	for _, c := range config.Contexts {
+		region, name, ok := parseEKSARN(c.Context.Cluster)
+		if !ok {
+			continue
+		}
+		existing, ok := clusters[region]
+		if !ok {
+			existing, err = k.eksClusters(ctx, region)
+			if err != nil {
+				return nil, err
+			}
+			clusters[region] = existing
+		}
+		if existing[name] {
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			Kind:     KindKubeContext,
+			ID:       c.Name,
+			Name:     c.Name,
+			Location: region,
+			Reason:   fmt.Sprintf("EKS cluster %s no longer exists", name),
+		})
+	}
+	sortOrphans(orphans)
+	return orphans, nil
+}
+
+// Remove implements Sweeper
+func (k *KubeContexts) Remove(ctx context.Context, o Orphan) error {
+	config, err := k.view(ctx)
+	if err != nil {
+		return err
+	}
+	var cluster, user string
+	found := false
+	for _, c := range config.Contexts {
+		if c.Name == o.ID {
+			cluster, user, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	if _, err := k.kubectl(ctx, "config", "delete-context", o.ID); err != nil {
+		return err
+	}
+
+	// Keep the cluster and user entries other contexts still use
+	clusterUsed, userUsed := false, false
+	for _, c := range config.Contexts {
+		if c.Name == o.ID {
+			continue
+		}
+		clusterUsed = clusterUsed || c.Context.Cluster == cluster
+		userUsed = userUsed || c.Context.User == user
+	}
+	if !clusterUsed && cluster != "" {
+		if _, err := k.kubectl(ctx, "config", "delete-cluster", cluster); err != nil {
+			return err
+		}
+	}
+	if !userUsed && user != "" {
+		if _, err := k.kubectl(ctx, "config", "delete-user", user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *KubeContexts) view(ctx context.Context) (*kubeConfig, error) {
+	output, err := k.kubectl(ctx, "config", "view", "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	var config kubeConfig
+	if err := json.Unmarshal(output, &config); err != nil {
+		return nil, fmt.Errorf("invalid kubectl config view output: %w", err)
+	}
+	return &config, nil
+}
+
+// eksClusters returns the names of the EKS clusters in a region
+func (k *KubeContexts) eksClusters(ctx context.Context, region string) (map[string]bool, error) {
+	output, err := runner(k.Run)(ctx, "aws", "eks", "list-clusters", "--region", region, "--output", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list EKS clusters in %s: %w", region, err)
+	}
+	var list struct {
+		Clusters []string `json:"clusters"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("invalid list-clusters output: %w", err)
+	}
+	names := make(map[string]bool, len(list.Clusters))
+	for _, name := range list.Clusters {
+		names[name] = true
+	}
+	return names, nil
+}
+
+func (k *KubeContexts) kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	if k.Kubeconfig != "" {
+		args = append([]string{"--kubeconfig", k.Kubeconfig}, args...)
+	}
+	return runner(k.Run)(ctx, "kubectl", args...)
+}
+
+// parseEKSARN splits a cluster ARN, as written by aws eks update-kubeconfig,
+// into its region and cluster name
+func parseEKSARN(arn string) (region, name string, ok bool) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "eks" {
+		return "", "", false
+	}
+	name, ok = strings.CutPrefix(parts[5], "cluster/")
+	return parts[3], name, ok && name != ""
+}
@@ -0,0 +1,75 @@
+package janitor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Monitor periodically searches for orphans and reports how many it found
+// as a gauge, so they can be alerted on. It never removes anything; that is
+// left to apm gc.
+type Monitor struct {
+	Janitor  *Janitor
+	Interval time.Duration // 1h by default
+
+	once    sync.Once
+	orphans *prometheus.GaugeVec
+}
+
+// Collector returns the gauge of orphans found per kind
+func (m *Monitor) Collector() prometheus.Collector {
+	return m.gauge()
+}
+
+func (m *Monitor) gauge() *prometheus.GaugeVec {
+	m.once.Do(func() {
+		m.orphans = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "apm_orphaned_resources",
+			Help: "Telemetry resources whose owner is gone, found by the last janitor search",
+		}, []string{"kind"})
+	})
+	return m.orphans
+}
+
+// Run searches at once and then every Interval until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs one search and updates the gauge. Kinds whose sweeper failed
+// keep their last count.
+func (m *Monitor) Check(ctx context.Context) *Report {
+	report := m.Janitor.Find(ctx)
+	counts := map[Kind]int{}
+	for _, o := range report.Orphans {
+		counts[o.Kind]++
+	}
+	for _, s := range m.Janitor.Sweepers {
+		kind := s.Kind()
+		if err := report.Errors[kind]; err != nil {
+			if ctx.Err() == nil {
+				log.Printf("janitor: %s search failed: %v", kind, err)
+			}
+			continue
+		}
+		m.gauge().WithLabelValues(string(kind)).Set(float64(counts[kind]))
+	}
+	return report
+}
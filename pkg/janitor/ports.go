@@ -0,0 +1,67 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/chaksack/apm/pkg/apmclient"
+)
+
+// PortAllocations collects ports the APM server has allocated to tools that
+// nothing listens on any more
+type PortAllocations struct {
+	Client *apmclient.Client
+	// Listening reports whether something listens on a local port; nil dials
+	// localhost
+	Listening func(ctx context.Context, port int) bool
+}
+
+// Kind implements Sweeper
+func (p *PortAllocations) Kind() Kind { return KindPort }
+
+// Find implements Sweeper
+func (p *PortAllocations) Find(ctx context.Context) ([]Orphan, error) {
+	allocated, err := p.Client.GetAllocatedPorts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list allocated ports: %w", err)
+	}
+	listening := p.Listening
+	if listening == nil {
+		listening = dialLocal
+	}
+
+	var orphans []Orphan
+	for port, tool := range allocated.AllocatedPorts {
+		n, err := strconv.Atoi(port)
+		if err != nil || listening(ctx, n) {
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			Kind:     KindPort,
+			ID:       port,
+			Name:     port,
+			Location: tool,
+			Reason:   "nothing listens on the port",
+		})
+	}
+	sortOrphans(orphans)
+	return orphans, nil
+}
+
+// Remove implements Sweeper
+func (p *PortAllocations) Remove(ctx context.Context, o Orphan) error {
+	return p.Client.ReleasePort(ctx, o.ID)
+}
+
+func dialLocal(ctx context.Context, port int) bool {
+	d := net.Dialer{Timeout: time.Second}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
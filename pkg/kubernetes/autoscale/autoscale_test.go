@@ -0,0 +1,129 @@
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func TestRecommend(t *testing.T) {
+	// Four replicas serving 40-400 req/s; latency breaches 300ms above 80 req/s each
+	var samples []Sample
+	for i := 0; i < 100; i++ {
+		rps := 40 + float64(i)*3.6
+		latency := 0.1
+		if rps/4 > 80 {
+			latency = 0.5
+		}
+		samples = append(samples, Sample{Time: start.Add(time.Duration(i) * time.Minute), RPS: rps, Replicas: 4, Latency: latency, CPU: rps / 4 / 100})
+	}
+
+	rec, err := Recommend(samples, SLO{Latency: 300 * time.Millisecond, Quantile: 0.99}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.RequestsPerReplica < 60 || rec.RequestsPerReplica > 65 {
+		t.Errorf("expected about 80%% of 80 req/s per replica, got %.2f", rec.RequestsPerReplica)
+	}
+	if rec.MinReplicas != 2 {
+		t.Errorf("expected the floor of 2 min replicas, got %d", rec.MinReplicas)
+	}
+	if want := int32(math.Ceil(400 * 1.5 / rec.RequestsPerReplica)); rec.MaxReplicas != want {
+		t.Errorf("expected %d max replicas, got %d", want, rec.MaxReplicas)
+	}
+	if rec.CPUTarget < 60 || rec.CPUTarget > 65 {
+		t.Errorf("expected a CPU target near 63%%, got %d", rec.CPUTarget)
+	}
+	if len(rec.Rationale) < 4 {
+		t.Errorf("expected a rationale per setting, got %v", rec.Rationale)
+	}
+}
+
+func TestRecommendCPUOnly(t *testing.T) {
+	samples := []Sample{
+		{Time: start, RPS: math.NaN(), Replicas: 6, Latency: math.NaN(), CPU: 0.5},
+		{Time: start.Add(time.Minute), RPS: math.NaN(), Replicas: 6, Latency: math.NaN(), CPU: 0.6},
+	}
+	rec, err := Recommend(samples, SLO{Latency: time.Second, Quantile: 0.99}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.RequestsPerReplica != 0 || rec.CPUTarget != DefaultCPUTarget || rec.MinReplicas != 3 || rec.MaxReplicas != 18 {
+		t.Errorf("unexpected recommendation %+v", rec)
+	}
+
+	if _, err := Recommend(nil, SLO{}, Options{}); err == nil {
+		t.Error("expected an error without replica data")
+	}
+}
+
+func TestHPAAndKEDA(t *testing.T) {
+	rec := Recommendation{Workload: "checkout", Namespace: "shop", MinReplicas: 2, MaxReplicas: 10,
+		CPUTarget: 65, RequestsPerReplica: 62.5, RequestRate: Workload{Namespace: "shop", Deployment: "checkout"}.RequestRate()}
+
+	out, err := YAML(HPA(rec, "http_requests_per_second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"kind: HorizontalPodAutoscaler", "minReplicas: 2", "maxReplicas: 10",
+		"averageUtilization: 65", "name: http_requests_per_second", "averageValue: 62500m", "kind: Deployment"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(string(out), "status") {
+		t.Errorf("expected no status in\n%s", out)
+	}
+
+	out, err = YAML(KEDA(rec, "http://prometheus:9090"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"apiVersion: keda.sh/v1alpha1", "kind: ScaledObject", "minReplicaCount: 2",
+		"type: prometheus", "serverAddress: http://prometheus:9090", `threshold: "62.5"`, "type: cpu", `value: "65"`} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		value := "3"
+		switch {
+		case strings.Contains(query, "http_requests_total"):
+			value = "150"
+		case strings.Contains(query, "histogram_quantile(0.99"):
+			value = "0.2"
+		case strings.Contains(query, "container_cpu"):
+			value = "0.5"
+		}
+		var values []string
+		for i := 0; i < 4; i++ {
+			values = append(values, fmt.Sprintf(`[%d,"%s"]`, start.Add(time.Duration(i)*5*time.Minute).Unix(), value))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"result":[{"values":[%s]}]}}`, strings.Join(values, ","))
+	}))
+	defer server.Close()
+
+	c := &Collector{PrometheusURL: server.URL, History: 15 * time.Minute, Step: 5 * time.Minute,
+		now: func() time.Time { return start.Add(15 * time.Minute) }}
+	samples, err := c.Collect(context.Background(), Workload{Namespace: "shop", Deployment: "checkout"}, SLO{Quantile: 0.99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 samples, got %d", len(samples))
+	}
+	if s := samples[0]; s.RPS != 150 || s.Replicas != 3 || s.Latency != 0.2 || s.CPU != 0.5 {
+		t.Errorf("unexpected sample %+v", s)
+	}
+}
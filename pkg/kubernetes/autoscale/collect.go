@@ -0,0 +1,173 @@
+package autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector reads a workload's history from Prometheus
+type Collector struct {
+	PrometheusURL string
+	Client        *http.Client
+
+	History time.Duration
+	Step    time.Duration
+
+	now func() time.Time
+}
+
+// Workload identifies a Deployment and the series of its pods
+type Workload struct {
+	Namespace  string
+	Deployment string
+
+	// Selector matches the workload's request metrics; empty matches its
+	// pods by name, e.g. namespace="shop", pod=~"checkout-.*"
+	Selector string
+}
+
+func (w Workload) podSelector() string {
+	return fmt.Sprintf(`namespace=%q, pod=~%q`, w.Namespace, w.Deployment+"-.*")
+}
+
+func (w Workload) selector() string {
+	if w.Selector != "" {
+		return w.Selector
+	}
+	return w.podSelector()
+}
+
+// RequestRate is the PromQL of the workload's total request rate
+func (w Workload) RequestRate() string {
+	return fmt.Sprintf(`sum(rate(http_requests_total{%s}[2m]))`, w.selector())
+}
+
+// Collect returns one sample per step of the workload's request rate,
+// available replicas, SLO-quantile latency, and CPU utilization
+func (c *Collector) Collect(ctx context.Context, w Workload, slo SLO) ([]Sample, error) {
+	queries := map[string]string{
+		"replicas": fmt.Sprintf(`sum(kube_deployment_status_replicas_available{namespace=%q, deployment=%q})`, w.Namespace, w.Deployment),
+		"rps":      fmt.Sprintf(`sum(rate(http_requests_total{%s}[5m]))`, w.selector()),
+		"latency": fmt.Sprintf(`histogram_quantile(%g, sum by (le) (rate(http_request_duration_seconds_bucket{%s}[5m])))`,
+			slo.Quantile, w.selector()),
+		"cpu": fmt.Sprintf(`sum(rate(container_cpu_usage_seconds_total{%s, container!=""}[5m])) / sum(kube_pod_container_resource_requests{%s, resource="cpu"})`,
+			w.podSelector(), w.podSelector()),
+	}
+
+	end := c.clock()
+	start := end.Add(-c.History)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	series := make(map[string]map[int64]float64)
+	var errs []string
+	for name, query := range queries {
+		wg.Add(1)
+		go func(name, query string) {
+			defer wg.Done()
+			values, err := c.queryRange(ctx, query, start, end)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				return
+			}
+			series[name] = values
+		}(name, query)
+	}
+	wg.Wait()
+	if series["replicas"] == nil {
+		return nil, fmt.Errorf("failed to query Prometheus: %s", strings.Join(errs, "; "))
+	}
+
+	var samples []Sample
+	for t := start.Truncate(c.Step); !t.After(end); t = t.Add(c.Step) {
+		ts := t.Unix()
+		s := Sample{Time: t, RPS: lookup(series["rps"], ts), Replicas: lookup(series["replicas"], ts),
+			Latency: lookup(series["latency"], ts), CPU: lookup(series["cpu"], ts)}
+		if valid(s.Replicas) || valid(s.RPS) {
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
+
+func lookup(values map[int64]float64, ts int64) float64 {
+	if v, ok := values[ts]; ok {
+		return v
+	}
+	return math.NaN()
+}
+
+func (c *Collector) queryRange(ctx context.Context, query string, start, end time.Time) (map[int64]float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Truncate(c.Step).Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatInt(int64(c.Step/time.Second), 10))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.PrometheusURL, "/")+"/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Values [][2]interface{} `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	if out.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s", out.Error)
+	}
+
+	values := make(map[int64]float64)
+	if len(out.Data.Result) == 0 {
+		return values, nil
+	}
+	for _, v := range out.Data.Result[0].Values {
+		ts, _ := v[0].(float64)
+		s, _ := v[1].(string)
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		values[int64(ts)] = f
+	}
+	return values, nil
+}
+
+func (c *Collector) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,192 @@
+package autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// managedBy labels the objects generated here
+var managedBy = map[string]string{"app.kubernetes.io/managed-by": "apm"}
+
+// scaleDownWindow keeps replicas through short traffic dips
+var scaleDownWindow int32 = 300
+
+// HPA renders a recommendation as an autoscaling/v2 HorizontalPodAutoscaler.
+// When podsMetric is set, the request rate per replica is added as a Pods
+// metric of that name, as served by prometheus-adapter.
+func HPA(rec Recommendation, podsMetric string) *autoscalingv2.HorizontalPodAutoscaler {
+	min := rec.MinReplicas
+	cpu := rec.CPUTarget
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rec.Workload,
+			Namespace: rec.Namespace,
+			Labels:    managedBy,
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: rec.Workload},
+			MinReplicas:    &min,
+			MaxReplicas:    rec.MaxReplicas,
+			Metrics: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name:   corev1.ResourceCPU,
+					Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &cpu},
+				},
+			}},
+			Behavior: &autoscalingv2.HorizontalPodAutoscalerBehavior{
+				ScaleDown: &autoscalingv2.HPAScalingRules{StabilizationWindowSeconds: &scaleDownWindow},
+			},
+		},
+	}
+	if podsMetric != "" && rec.RequestsPerReplica > 0 {
+		target := resource.MustParse(strconv.FormatInt(int64(rec.RequestsPerReplica*1000), 10) + "m")
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{Name: podsMetric},
+				Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &target},
+			},
+		})
+	}
+	return hpa
+}
+
+// ScaledObject is a KEDA ScaledObject (keda.sh/v1alpha1)
+type ScaledObject struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metav1.ObjectMeta `json:"metadata"`
+	Spec       ScaledObjectSpec  `json:"spec"`
+}
+
+// ScaledObjectSpec is the spec of a KEDA ScaledObject
+type ScaledObjectSpec struct {
+	ScaleTargetRef  ScaleTargetRef `json:"scaleTargetRef"`
+	MinReplicaCount int32          `json:"minReplicaCount"`
+	MaxReplicaCount int32          `json:"maxReplicaCount"`
+	CooldownPeriod  int32          `json:"cooldownPeriod,omitempty"`
+	Triggers        []Trigger      `json:"triggers"`
+}
+
+// ScaleTargetRef names the scaled workload
+type ScaleTargetRef struct {
+	Name string `json:"name"`
+}
+
+// Trigger is a KEDA scaler
+type Trigger struct {
+	Type       string            `json:"type"`
+	MetricType string            `json:"metricType,omitempty"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+// KEDA renders a recommendation as a KEDA ScaledObject that scales on the
+// workload's request rate from Prometheus and on CPU utilization
+func KEDA(rec Recommendation, prometheusURL string) *ScaledObject {
+	so := &ScaledObject{
+		APIVersion: "keda.sh/v1alpha1",
+		Kind:       "ScaledObject",
+		Metadata:   metav1.ObjectMeta{Name: rec.Workload, Namespace: rec.Namespace, Labels: managedBy},
+		Spec: ScaledObjectSpec{
+			ScaleTargetRef:  ScaleTargetRef{Name: rec.Workload},
+			MinReplicaCount: rec.MinReplicas,
+			MaxReplicaCount: rec.MaxReplicas,
+			CooldownPeriod:  scaleDownWindow,
+		},
+	}
+	if rec.RequestsPerReplica > 0 && rec.RequestRate != "" {
+		so.Spec.Triggers = append(so.Spec.Triggers, Trigger{
+			Type: "prometheus",
+			Metadata: map[string]string{
+				"serverAddress": prometheusURL,
+				"query":         rec.RequestRate,
+				"threshold":     strconv.FormatFloat(rec.RequestsPerReplica, 'f', -1, 64),
+			},
+		})
+	}
+	so.Spec.Triggers = append(so.Spec.Triggers, Trigger{
+		Type:       "cpu",
+		MetricType: "Utilization",
+		Metadata:   map[string]string{"value": strconv.Itoa(int(rec.CPUTarget))},
+	})
+	return so
+}
+
+// YAML renders an object as a manifest, without the empty status and
+// creation timestamp the API types always carry
+func YAML(obj interface{}) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	delete(manifest, "status")
+	if metadata, ok := manifest["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return yaml.Marshal(manifest)
+}
+
+// ApplyHPA creates or updates an HPA
+func ApplyHPA(ctx context.Context, client kubernetes.Interface, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	hpas := client.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace)
+	existing, err := hpas.Get(ctx, hpa.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = hpas.Create(ctx, hpa, metav1.CreateOptions{})
+	case err == nil:
+		hpa.ResourceVersion = existing.ResourceVersion
+		_, err = hpas.Update(ctx, hpa, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply HPA %s/%s: %w", hpa.Namespace, hpa.Name, err)
+	}
+	return nil
+}
+
+// scaledObjects is the KEDA ScaledObject resource
+var scaledObjects = schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"}
+
+// ApplyScaledObject creates or updates a KEDA ScaledObject
+func ApplyScaledObject(ctx context.Context, client dynamic.Interface, so *ScaledObject) error {
+	data, err := json.Marshal(so)
+	if err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	objects := client.Resource(scaledObjects).Namespace(so.Metadata.Namespace)
+	existing, err := objects.Get(ctx, so.Metadata.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = objects.Create(ctx, obj, metav1.CreateOptions{})
+	case err == nil:
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		_, err = objects.Update(ctx, obj, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply ScaledObject %s/%s: %w", so.Metadata.Namespace, so.Metadata.Name, err)
+	}
+	return nil
+}
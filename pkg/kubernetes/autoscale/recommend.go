@@ -0,0 +1,202 @@
+// Package autoscale recommends autoscaling settings for a workload from its
+// observed traffic, replicas, CPU utilization, and latency SLO, and renders
+// them as a Kubernetes HorizontalPodAutoscaler or a KEDA ScaledObject.
+package autoscale
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Defaults
+const (
+	DefaultTargetUtilization = 0.8
+	DefaultBurstFactor       = 1.5
+	DefaultMinReplicas       = 2
+	DefaultCPUTarget         = 70
+)
+
+// SLO is the latency objective scaling must protect
+type SLO struct {
+	// Latency is the objective for the quantile, e.g. 300ms at p99
+	Latency  time.Duration
+	Quantile float64
+}
+
+// Sample is the workload's state at one time. Missing values are NaN.
+type Sample struct {
+	Time time.Time
+
+	// RPS is the workload's total request rate
+	RPS float64
+	// Replicas is the number of available replicas
+	Replicas float64
+	// Latency is the SLO quantile latency in seconds
+	Latency float64
+	// CPU is the CPU usage as a share of CPU requests, e.g. 0.65
+	CPU float64
+}
+
+// Options tunes a recommendation
+type Options struct {
+	// TargetUtilization is the share of the per-replica throughput known to
+	// meet the SLO that replicas are scaled to; zero means 0.8
+	TargetUtilization float64
+
+	// BurstFactor multiplies the observed peak to size MaxReplicas; zero
+	// means 1.5
+	BurstFactor float64
+
+	// MinFloor is the lowest MinReplicas recommended; zero means 2
+	MinFloor int32
+}
+
+// Recommendation is a set of autoscaling settings with the reasons for them
+type Recommendation struct {
+	Workload  string `json:"workload"`
+	Namespace string `json:"namespace"`
+
+	MinReplicas int32 `json:"min_replicas"`
+	MaxReplicas int32 `json:"max_replicas"`
+
+	// CPUTarget is the average CPU utilization target, in percent of requests
+	CPUTarget int32 `json:"cpu_target"`
+
+	// RequestsPerReplica is the request rate target per replica; zero when
+	// there is no request data and scaling is on CPU only
+	RequestsPerReplica float64 `json:"requests_per_replica,omitempty"`
+
+	// RequestRate is the PromQL of the workload's total request rate, used
+	// as the KEDA trigger
+	RequestRate string `json:"request_rate,omitempty"`
+
+	Rationale []string `json:"rationale"`
+}
+
+// Recommend derives autoscaling settings from samples. The throughput a
+// replica sustains within the SLO is the 95th percentile of per-replica
+// request rate over the samples that met the SLO; replicas are targeted at a
+// share of it, and the CPU target is the utilization seen at that rate.
+func Recommend(samples []Sample, slo SLO, opts Options) (Recommendation, error) {
+	if opts.TargetUtilization <= 0 || opts.TargetUtilization > 1 {
+		opts.TargetUtilization = DefaultTargetUtilization
+	}
+	if opts.BurstFactor < 1 {
+		opts.BurstFactor = DefaultBurstFactor
+	}
+	if opts.MinFloor <= 0 {
+		opts.MinFloor = DefaultMinReplicas
+	}
+
+	var rec Recommendation
+	var perReplica, good, breaching, rps, cpuPerRPS, replicas []float64
+	for _, s := range samples {
+		if valid(s.Replicas) && s.Replicas > 0 {
+			replicas = append(replicas, s.Replicas)
+		}
+		if !valid(s.RPS) || !valid(s.Replicas) || s.Replicas <= 0 {
+			continue
+		}
+		rps = append(rps, s.RPS)
+		r := s.RPS / s.Replicas
+		perReplica = append(perReplica, r)
+		if valid(s.Latency) && slo.Latency > 0 {
+			if s.Latency <= slo.Latency.Seconds() {
+				good = append(good, r)
+			} else {
+				breaching = append(breaching, r)
+			}
+		}
+		if valid(s.CPU) && r > 0 {
+			cpuPerRPS = append(cpuPerRPS, s.CPU/r)
+		}
+	}
+	if len(replicas) == 0 {
+		return rec, fmt.Errorf("no replica data; is kube-state-metrics scraped?")
+	}
+	current := percentile(replicas, 0.5)
+
+	// Without traffic data only CPU can drive scaling
+	if len(rps) == 0 {
+		rec.CPUTarget = DefaultCPUTarget
+		rec.MinReplicas = max32(opts.MinFloor, int32(math.Ceil(current/2)))
+		rec.MaxReplicas = max32(rec.MinReplicas+1, int32(math.Ceil(current*3)))
+		rec.Rationale = append(rec.Rationale,
+			"No request metrics found; scaling on CPU only",
+			fmt.Sprintf("Replicas ranged around %.0f; allowing %d-%d", current, rec.MinReplicas, rec.MaxReplicas))
+		return rec, nil
+	}
+
+	// The per-replica throughput known to meet the SLO
+	var capacity float64
+	switch {
+	case len(good) > 0:
+		capacity = percentile(good, 0.95)
+		rec.Rationale = append(rec.Rationale, fmt.Sprintf(
+			"Replicas met the p%g %s SLO up to %.1f req/s each (%d of %d samples within SLO)",
+			slo.Quantile*100, slo.Latency, capacity, len(good), len(good)+len(breaching)))
+		if len(breaching) > 0 {
+			if low := percentile(breaching, 0.05); low < capacity {
+				capacity = low
+				rec.Rationale = append(rec.Rationale, fmt.Sprintf(
+					"The SLO was breached from %.1f req/s per replica, so that is taken as the limit", low))
+			}
+		}
+	default:
+		capacity = percentile(perReplica, 0.95)
+		rec.Rationale = append(rec.Rationale, fmt.Sprintf(
+			"No latency data within the SLO; using the observed peak of %.1f req/s per replica", capacity))
+	}
+	if capacity <= 0 {
+		return rec, fmt.Errorf("no traffic observed")
+	}
+
+	rec.RequestsPerReplica = round(capacity*opts.TargetUtilization, 2)
+	rec.Rationale = append(rec.Rationale, fmt.Sprintf(
+		"Targeting %.0f%% of that: %.2f req/s per replica", opts.TargetUtilization*100, rec.RequestsPerReplica))
+
+	trough, peak := percentile(rps, 0.05), percentile(rps, 1)
+	rec.MinReplicas = max32(opts.MinFloor, int32(math.Ceil(trough/rec.RequestsPerReplica)))
+	rec.MaxReplicas = max32(rec.MinReplicas+1, int32(math.Ceil(peak*opts.BurstFactor/rec.RequestsPerReplica)))
+	rec.Rationale = append(rec.Rationale, fmt.Sprintf(
+		"Traffic ranged from %.1f (p5) to %.1f req/s; %d replicas cover the trough and %d cover %.1f× the peak",
+		trough, peak, rec.MinReplicas, rec.MaxReplicas, opts.BurstFactor))
+
+	rec.CPUTarget = DefaultCPUTarget
+	if len(cpuPerRPS) > 0 {
+		cpu := percentile(cpuPerRPS, 0.5) * rec.RequestsPerReplica * 100
+		rec.CPUTarget = int32(math.Max(30, math.Min(90, math.Round(cpu))))
+		rec.Rationale = append(rec.Rationale, fmt.Sprintf(
+			"At the target rate replicas use about %.0f%% of their CPU requests; CPU target %d%%", cpu, rec.CPUTarget))
+	}
+	return rec, nil
+}
+
+// percentile returns the p-th percentile (0-1) by nearest rank
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func valid(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func round(v float64, places int) float64 {
+	f := math.Pow(10, float64(places))
+	return math.Round(v*f) / f
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
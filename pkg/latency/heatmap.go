@@ -0,0 +1,396 @@
+// Package latency turns Prometheus latency histograms into heatmaps: request
+// counts per latency bucket per time step, grouped by route or service, with
+// p50/p90/p99 over time. Heatmaps are returned as JSON for rendering or as
+// CSV for capacity planning.
+package latency
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults
+const (
+	DefaultMetric       = "http_request_duration_seconds"
+	DefaultRouteLabel   = "path"
+	DefaultServiceLabel = "job"
+
+	// MaxSteps bounds the columns of a heatmap
+	MaxSteps = 1000
+)
+
+// Quantiles reported for every time step
+var Quantiles = []float64{0.5, 0.9, 0.99}
+
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Query selects the histogram data of a heatmap
+type Query struct {
+	// Metric is the histogram name without the _bucket suffix
+	Metric  string
+	Service string
+	Route   string
+
+	// GroupBy is "route", "service", or empty for a single heatmap
+	GroupBy string
+
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+}
+
+// Validate checks the query and fills defaults
+func (q *Query) Validate() error {
+	if q.Metric == "" {
+		q.Metric = DefaultMetric
+	}
+	if !metricName.MatchString(q.Metric) {
+		return fmt.Errorf("invalid metric name %q", q.Metric)
+	}
+	switch q.GroupBy {
+	case "", "route", "service":
+	default:
+		return fmt.Errorf("group by must be route or service, got %q", q.GroupBy)
+	}
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+	if q.Start.IsZero() {
+		q.Start = q.End.Add(-6 * time.Hour)
+	}
+	if !q.Start.Before(q.End) {
+		return fmt.Errorf("start must be before end")
+	}
+	if q.Step <= 0 {
+		// Aim for about 120 columns, in whole minutes
+		q.Step = q.End.Sub(q.Start) / 120
+		if q.Step < time.Minute {
+			q.Step = time.Minute
+		}
+		q.Step = q.Step.Round(time.Minute)
+	}
+	if q.Step < time.Second {
+		return fmt.Errorf("step must be at least 1s")
+	}
+	if steps := q.End.Sub(q.Start) / q.Step; steps > MaxSteps {
+		return fmt.Errorf("range/step gives %d steps; at most %d are allowed", steps, MaxSteps)
+	}
+	return nil
+}
+
+// Heatmap is the latency distribution of one route, service, or the whole
+// selection. Counts[t][b] is the number of requests at Times[t] whose latency
+// fell in bucket b, i.e. above the previous bound and at most Buckets[b].
+type Heatmap struct {
+	Group string `json:"group,omitempty"`
+
+	// Buckets are the upper bounds of the histogram buckets, in seconds, as
+	// written by Prometheus ("0.005" ... "+Inf")
+	Buckets []string    `json:"buckets"`
+	Times   []time.Time `json:"times"`
+	Counts  [][]float64 `json:"counts"`
+
+	// Quantiles maps "p50", "p90", and "p99" to a latency in seconds per
+	// time step; null where there were no requests
+	Quantiles map[string][]*float64 `json:"quantiles"`
+
+	Total float64 `json:"total"`
+}
+
+// Result holds a heatmap per group
+type Result struct {
+	Metric   string        `json:"metric"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Step     time.Duration `json:"-"`
+	StepSecs int64         `json:"step_seconds"`
+	Heatmaps []Heatmap     `json:"heatmaps"`
+}
+
+// Client reads histograms from Prometheus
+type Client struct {
+	URL    string
+	Client *http.Client
+
+	// RouteLabel and ServiceLabel name the histogram's route and service
+	// labels; empty uses DefaultRouteLabel and DefaultServiceLabel
+	RouteLabel   string
+	ServiceLabel string
+}
+
+// Heatmaps queries Prometheus for the per-step bucket increases of the
+// selected histogram
+func (c *Client) Heatmaps(ctx context.Context, q Query) (*Result, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	routeLabel, serviceLabel := c.RouteLabel, c.ServiceLabel
+	if routeLabel == "" {
+		routeLabel = DefaultRouteLabel
+	}
+	if serviceLabel == "" {
+		serviceLabel = DefaultServiceLabel
+	}
+
+	var matchers []string
+	if q.Service != "" {
+		matchers = append(matchers, serviceLabel+"="+strconv.Quote(q.Service))
+	}
+	if q.Route != "" {
+		matchers = append(matchers, routeLabel+"="+strconv.Quote(q.Route))
+	}
+	by := []string{"le"}
+	groupLabel := ""
+	switch q.GroupBy {
+	case "route":
+		groupLabel = routeLabel
+	case "service":
+		groupLabel = serviceLabel
+	}
+	if groupLabel != "" {
+		by = append(by, groupLabel)
+	}
+
+	// Aligning start to the step keeps the columns stable across refreshes
+	start := q.Start.Truncate(q.Step)
+	promql := fmt.Sprintf("sum by (%s) (increase(%s_bucket{%s}[%s]))",
+		strings.Join(by, ", "), q.Metric, strings.Join(matchers, ", "), promDuration(q.Step))
+
+	params := url.Values{}
+	params.Set("query", promql)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(q.End.Unix(), 10))
+	params.Set("step", strconv.FormatInt(int64(q.Step/time.Second), 10))
+
+	var resp struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Values [][2]interface{}  `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, strings.TrimRight(c.URL, "/")+"/api/v1/query_range?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s", resp.Error)
+	}
+
+	var times []time.Time
+	index := make(map[int64]int)
+	for t := start; !t.After(q.End); t = t.Add(q.Step) {
+		index[t.Unix()] = len(times)
+		times = append(times, t.UTC())
+	}
+
+	// cumulative[group][le][t] as returned by Prometheus
+	cumulative := make(map[string]map[string][]float64)
+	for _, series := range resp.Data.Result {
+		group := series.Metric[groupLabel]
+		le := series.Metric["le"]
+		if le == "" {
+			continue
+		}
+		if cumulative[group] == nil {
+			cumulative[group] = make(map[string][]float64)
+		}
+		values := make([]float64, len(times))
+		for _, v := range series.Values {
+			ts, _ := v[0].(float64)
+			i, ok := index[int64(ts)]
+			if !ok {
+				continue
+			}
+			s, _ := v[1].(string)
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(f) {
+				continue
+			}
+			values[i] = f
+		}
+		cumulative[group][le] = values
+	}
+
+	result := &Result{Metric: q.Metric, Start: start.UTC(), End: q.End.UTC(), Step: q.Step, StepSecs: int64(q.Step / time.Second)}
+	groups := make([]string, 0, len(cumulative))
+	for group := range cumulative {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		result.Heatmaps = append(result.Heatmaps, heatmap(group, times, cumulative[group]))
+	}
+	return result, nil
+}
+
+// heatmap converts cumulative bucket counts into per-bucket counts and
+// quantiles
+func heatmap(group string, times []time.Time, buckets map[string][]float64) Heatmap {
+	type bound struct {
+		le    string
+		upper float64
+	}
+	bounds := make([]bound, 0, len(buckets))
+	for le := range buckets {
+		upper, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			continue
+		}
+		bounds = append(bounds, bound{le, upper})
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].upper < bounds[j].upper })
+
+	h := Heatmap{
+		Group:     group,
+		Times:     times,
+		Counts:    make([][]float64, len(times)),
+		Quantiles: make(map[string][]*float64, len(Quantiles)),
+	}
+	uppers := make([]float64, len(bounds))
+	for i, b := range bounds {
+		h.Buckets = append(h.Buckets, b.le)
+		uppers[i] = b.upper
+	}
+	for _, q := range Quantiles {
+		h.Quantiles[quantileName(q)] = make([]*float64, len(times))
+	}
+
+	for t := range times {
+		counts := make([]float64, len(bounds))
+		cum := make([]float64, len(bounds))
+		previous := 0.0
+		for b, bd := range bounds {
+			c := buckets[bd.le][t]
+			// Increases are extrapolated per series, so cumulative counts can
+			// dip slightly; clamp rather than report negative requests
+			if c < previous {
+				c = previous
+			}
+			counts[b] = c - previous
+			cum[b] = c
+			previous = c
+		}
+		h.Counts[t] = counts
+		h.Total += previous
+
+		for _, q := range Quantiles {
+			if v, ok := bucketQuantile(q, uppers, cum); ok {
+				h.Quantiles[quantileName(q)][t] = &v
+			}
+		}
+	}
+	return h
+}
+
+// bucketQuantile estimates a quantile from cumulative bucket counts with
+// linear interpolation, as histogram_quantile does
+func bucketQuantile(q float64, uppers, cum []float64) (float64, bool) {
+	if len(cum) == 0 || cum[len(cum)-1] == 0 {
+		return 0, false
+	}
+	total := cum[len(cum)-1]
+	rank := q * total
+	b := sort.SearchFloat64s(cum, rank)
+	if b >= len(cum) {
+		b = len(cum) - 1
+	}
+	// The +Inf bucket has no upper bound; report the highest finite bound
+	if math.IsInf(uppers[b], 1) {
+		if b == 0 {
+			return 0, false
+		}
+		return uppers[b-1], true
+	}
+
+	lower, below := 0.0, 0.0
+	if b > 0 {
+		lower, below = uppers[b-1], cum[b-1]
+	}
+	inBucket := cum[b] - below
+	if inBucket == 0 {
+		return uppers[b], true
+	}
+	return lower + (uppers[b]-lower)*(rank-below)/inBucket, true
+}
+
+// WriteCSV writes one row per group, time step, and bucket with a count:
+// group,time,le,count
+func (r *Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"group", "time", "le", "count"}); err != nil {
+		return err
+	}
+	for _, h := range r.Heatmaps {
+		for t, at := range h.Times {
+			for b, le := range h.Buckets {
+				count := h.Counts[t][b]
+				if count == 0 {
+					continue
+				}
+				row := []string{h.Group, at.Format(time.RFC3339), le, strconv.FormatFloat(count, 'f', -1, 64)}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (c *Client) get(ctx context.Context, rawURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32*1024*1024))
+	if err != nil {
+		return err
+	}
+	// Prometheus reports query errors with 4xx and a JSON body
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusUnprocessableEntity {
+		return fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+func quantileName(q float64) string {
+	return "p" + strconv.FormatFloat(q*100, 'f', -1, 64)
+}
+
+// promDuration formats a duration as a PromQL duration such as 5m or 90s
+func promDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
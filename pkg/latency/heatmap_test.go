@@ -0,0 +1,90 @@
+package latency
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeatmaps(t *testing.T) {
+	end := time.Unix(1714564800, 0) // a whole hour
+	var promql string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		promql = r.URL.Query().Get("query")
+		// Two steps of /checkout: 10 requests, then none
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"le":"0.1","path":"/checkout"},"values":[[1714564500,"4"],[1714564800,"0"]]},
+			{"metric":{"le":"0.5","path":"/checkout"},"values":[[1714564500,"9"],[1714564800,"0"]]},
+			{"metric":{"le":"+Inf","path":"/checkout"},"values":[[1714564500,"10"],[1714564800,"0"]]},
+			{"metric":{"le":"+Inf","path":"/cart"},"values":[[1714564500,"1"]]}]}}`))
+	}))
+	defer server.Close()
+
+	c := &Client{URL: server.URL}
+	result, err := c.Heatmaps(context.Background(), Query{
+		Service: "shop",
+		GroupBy: "route",
+		Start:   end.Add(-5 * time.Minute),
+		End:     end,
+		Step:    5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `sum by (le, path) (increase(http_request_duration_seconds_bucket{job="shop"}[5m]))`
+	if promql != want {
+		t.Errorf("unexpected query\n got: %s\nwant: %s", promql, want)
+	}
+	if len(result.Heatmaps) != 2 || result.Heatmaps[0].Group != "/cart" {
+		t.Fatalf("unexpected heatmaps %+v", result.Heatmaps)
+	}
+
+	h := result.Heatmaps[1]
+	if strings.Join(h.Buckets, ",") != "0.1,0.5,+Inf" || len(h.Times) != 2 || h.Total != 10 {
+		t.Errorf("unexpected heatmap %+v", h)
+	}
+	if got := h.Counts[0]; got[0] != 4 || got[1] != 5 || got[2] != 1 {
+		t.Errorf("expected per-bucket counts 4,5,1, got %v", got)
+	}
+	// p50 is the 5th request: 1 into the 5 between 0.1 and 0.5
+	if p50 := h.Quantiles["p50"][0]; p50 == nil || *p50 < 0.179 || *p50 > 0.181 {
+		t.Errorf("unexpected p50 %v", p50)
+	}
+	// p99 falls in +Inf, reported as the highest finite bound
+	if p99 := h.Quantiles["p99"][0]; p99 == nil || *p99 != 0.5 {
+		t.Errorf("unexpected p99 %v", p99)
+	}
+	if h.Quantiles["p50"][1] != nil {
+		t.Error("expected no quantile for a step without requests")
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || lines[0] != "group,time,le,count" || lines[2] != "/checkout,2024-05-01T11:55:00Z,0.1,4" {
+		t.Errorf("unexpected CSV\n%s", buf.String())
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	end := time.Now()
+	q := Query{Start: end.Add(-24 * time.Hour), End: end}
+	if err := q.Validate(); err != nil || q.Step != 12*time.Minute {
+		t.Errorf("expected a 12m default step, got %s %v", q.Step, err)
+	}
+	for _, bad := range []Query{
+		{Metric: "up{}"},
+		{GroupBy: "pod"},
+		{Start: end.Add(-30 * 24 * time.Hour), End: end, Step: time.Minute},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EtcdLease is a Lock on an etcd key attached to an etcd lease, through the
+// v3 JSON gateway. The key is created only when it does not exist, and it is
+// deleted by etcd when its holder stops keeping the lease alive.
+type EtcdLease struct {
+	// Endpoints are the etcd members, tried in order; http://localhost:2379
+	// by default
+	Endpoints []string
+	Key       string
+	Token     string // etcd auth token
+	Client    *http.Client
+
+	mu    sync.Mutex
+	lease int64
+}
+
+// TryAcquire implements Lock
+func (l *EtcdLease) TryAcquire(ctx context.Context, identity string, duration time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lease != 0 {
+		var out struct {
+			Result struct {
+				TTL int64 `json:"TTL,string"`
+			} `json:"result"`
+		}
+		if err := l.call(ctx, "/v3/lease/keepalive", map[string]string{"ID": strconv.FormatInt(l.lease, 10)}, &out); err != nil {
+			return "", err
+		}
+		if out.Result.TTL <= 0 {
+			l.lease = 0
+		}
+	}
+	if l.lease == 0 {
+		var out struct {
+			ID    int64  `json:"ID,string"`
+			Error string `json:"error"`
+		}
+		ttl := int64(duration / time.Second)
+		if ttl < 1 {
+			ttl = 1
+		}
+		if err := l.call(ctx, "/v3/lease/grant", map[string]string{"TTL": strconv.FormatInt(ttl, 10)}, &out); err != nil {
+			return "", err
+		}
+		if out.ID == 0 {
+			return "", fmt.Errorf("etcd: lease grant failed: %s", out.Error)
+		}
+		l.lease = out.ID
+	}
+
+	key := []byte(l.Key)
+	txn := map[string]interface{}{
+		"compare": []map[string]interface{}{{
+			"key": key, "target": "CREATE", "result": "EQUAL", "create_revision": "0",
+		}},
+		"success": []map[string]interface{}{{
+			"request_put": map[string]interface{}{"key": key, "value": []byte(identity), "lease": strconv.FormatInt(l.lease, 10)},
+		}},
+		"failure": []map[string]interface{}{{
+			"request_range": map[string]interface{}{"key": key},
+		}},
+	}
+	var out struct {
+		Succeeded bool `json:"succeeded"`
+		Responses []struct {
+			Range struct {
+				KVs []struct {
+					Value []byte `json:"value"`
+					Lease int64  `json:"lease,string"`
+				} `json:"kvs"`
+			} `json:"response_range"`
+		} `json:"responses"`
+	}
+	if err := l.call(ctx, "/v3/kv/txn", txn, &out); err != nil {
+		return "", err
+	}
+	if out.Succeeded {
+		return identity, nil
+	}
+	if len(out.Responses) == 0 || len(out.Responses[0].Range.KVs) == 0 {
+		// Deleted between the compare and the range; retry next round
+		return "", nil
+	}
+	kv := out.Responses[0].Range.KVs[0]
+	if string(kv.Value) == identity && kv.Lease != l.lease {
+		// Left behind by an earlier process with the same identity; it
+		// expires with that process's lease
+		return identity + " (previous process)", nil
+	}
+	return string(kv.Value), nil
+}
+
+// Release implements Lock by revoking the lease, which deletes the key
+func (l *EtcdLease) Release(ctx context.Context, identity string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lease == 0 {
+		return nil
+	}
+	err := l.call(ctx, "/v3/lease/revoke", map[string]string{"ID": strconv.FormatInt(l.lease, 10)}, nil)
+	l.lease = 0
+	return err
+}
+
+// call posts a JSON request to the first reachable endpoint and decodes the
+// response into out
+func (l *EtcdLease) call(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoints := l.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{"http://localhost:2379"}
+	}
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var errs []error
+	for _, endpoint := range endpoints {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if l.Token != "" {
+			req.Header.Set("Authorization", l.Token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, err)
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("etcd: %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("etcd: %w", err)
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,139 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubernetesLease is a Lock on a coordination.k8s.io Lease, the object
+// Kubernetes controllers elect their leaders with. Updates are conditional on
+// the resource version, so two replicas cannot both take an expired lease.
+type KubernetesLease struct {
+	Client    coordinationclient.LeasesGetter
+	Namespace string
+	Name      string
+	now       func() time.Time
+}
+
+// NewKubernetesLease creates a lock on the Lease name in namespace. The
+// cluster is the one of the kubeconfig file, or the one the server runs in
+// when kubeconfig is empty.
+func NewKubernetesLease(kubeconfig, namespace, name string) (*KubernetesLease, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the kubernetes config: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the kubernetes client: %w", err)
+	}
+	return &KubernetesLease{Client: client.CoordinationV1(), Namespace: namespace, Name: name}, nil
+}
+
+// TryAcquire implements Lock
+func (l *KubernetesLease) TryAcquire(ctx context.Context, identity string, duration time.Duration) (string, error) {
+	leases := l.Client.Leases(l.Namespace)
+	now := metav1.NewMicroTime(l.clock())
+	seconds := int32(duration / time.Second)
+
+	lease, err := leases.Get(ctx, l.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: l.Name, Namespace: l.Namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return "", nil
+			}
+			return "", fmt.Errorf("failed to create lease %s/%s: %w", l.Namespace, l.Name, err)
+		}
+		return identity, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get lease %s/%s: %w", l.Namespace, l.Name, err)
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if holder != identity && holder != "" && !l.expired(lease.Spec) {
+		return holder, nil
+	}
+
+	if holder != identity {
+		transitions := int32(0)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions
+		}
+		if holder != "" {
+			transitions++
+		}
+		lease.Spec.HolderIdentity = &identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		// Another replica updated the lease first
+		if apierrors.IsConflict(err) {
+			return holder, nil
+		}
+		return "", fmt.Errorf("failed to update lease %s/%s: %w", l.Namespace, l.Name, err)
+	}
+	return identity, nil
+}
+
+// Release implements Lock by clearing the holder, which lets any replica
+// take the lease at once
+func (l *KubernetesLease) Release(ctx context.Context, identity string) error {
+	leases := l.Client.Leases(l.Namespace)
+	lease, err := leases.Get(ctx, l.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get lease %s/%s: %w", l.Namespace, l.Name, err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != identity {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil && !apierrors.IsConflict(err) {
+		return fmt.Errorf("failed to release lease %s/%s: %w", l.Namespace, l.Name, err)
+	}
+	return nil
+}
+
+// expired reports whether a lease has gone unrenewed for its duration
+func (l *KubernetesLease) expired(spec coordinationv1.LeaseSpec) bool {
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	return l.clock().After(expiry)
+}
+
+func (l *KubernetesLease) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,246 @@
+// Package leader elects one replica of the APM server to run the jobs that
+// must run exactly once, such as the orphan report, while every replica keeps
+// serving the API. The election holds a lease in Kubernetes or etcd; a leader
+// that cannot renew it stops its jobs before the lease can pass to another
+// replica.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Lock backends
+const (
+	BackendKubernetes = "kubernetes"
+	BackendEtcd       = "etcd"
+)
+
+// DefaultLeaseDuration is how long a lease lasts without renewal
+const DefaultLeaseDuration = 15 * time.Second
+
+// Lock is a lease that at most one identity holds at a time
+type Lock interface {
+	// TryAcquire takes the lease for identity, or renews it when identity
+	// already holds it, and returns the holder after the attempt. A lease
+	// that has not been renewed for its duration may be taken over.
+	TryAcquire(ctx context.Context, identity string, duration time.Duration) (holder string, err error)
+	// Release gives up the lease if identity holds it
+	Release(ctx context.Context, identity string) error
+}
+
+// Job is a singleton job; it runs until ctx is done, which happens when the
+// replica loses the lease or shuts down
+type Job func(ctx context.Context)
+
+// Status is the state of the election as seen by one replica
+type Status struct {
+	Identity string    `json:"identity"`
+	Leader   string    `json:"leader,omitempty"`
+	Leading  bool      `json:"leading"`
+	Since    time.Time `json:"since,omitempty"`
+	Jobs     []string  `json:"jobs"`
+}
+
+// Elector campaigns for the lease and runs the singleton jobs while this
+// replica holds it
+type Elector struct {
+	lock     Lock
+	identity string
+	duration time.Duration
+
+	mu      sync.Mutex
+	jobs    map[string]Job
+	leader  string
+	leading bool
+	since   time.Time
+	renewed time.Time
+	jobCtx  context.Context
+	stop    context.CancelFunc
+	done    sync.WaitGroup
+
+	leadingGauge prometheus.Gauge
+	transitions  prometheus.Counter
+}
+
+// New creates an elector for identity, the hostname when empty. A zero
+// duration uses DefaultLeaseDuration.
+func New(lock Lock, identity string, duration time.Duration) *Elector {
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	if duration <= 0 {
+		duration = DefaultLeaseDuration
+	}
+	return &Elector{
+		lock:     lock,
+		identity: identity,
+		duration: duration,
+		jobs:     make(map[string]Job),
+		leadingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "apm_leader_is_leader",
+			Help: "Whether this replica holds the leader lease and runs the singleton jobs",
+		}),
+		transitions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "apm_leader_transitions_total",
+			Help: "Times this replica became or stopped being the leader",
+		}),
+	}
+}
+
+// Collectors returns the Prometheus collectors of the elector
+func (e *Elector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{e.leadingGauge, e.transitions}
+}
+
+// Add registers a singleton job, which starts right away when this replica
+// leads
+func (e *Elector) Add(name string, job Job) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs[name] = job
+	if e.leading {
+		e.start(job)
+	}
+}
+
+// Status returns the state of the election
+func (e *Elector) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := Status{Identity: e.identity, Leader: e.leader, Leading: e.leading, Since: e.since, Jobs: []string{}}
+	for name := range e.jobs {
+		status.Jobs = append(status.Jobs, name)
+	}
+	sort.Strings(status.Jobs)
+	return status
+}
+
+// Run campaigns until ctx is done, retrying every third of the lease
+// duration, and then releases the lease so another replica can take over
+// without waiting for it to expire
+func (e *Elector) Run(ctx context.Context) {
+	retry := e.duration / 3
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx, retry)
+		select {
+		case <-ctx.Done():
+			e.stepDown("shutting down")
+			releaseCtx, cancel := context.WithTimeout(context.Background(), retry)
+			if err := e.lock.Release(releaseCtx, e.identity); err != nil {
+				log.Printf("leader: failed to release the lease: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign makes one attempt to take or renew the lease. A leader stops its
+// jobs once its lease is within one attempt timeout of expiring, and no
+// attempt runs past that point, so an attempt stalled on the store cannot
+// keep the jobs running on two replicas at once.
+func (e *Elector) campaign(ctx context.Context, timeout time.Duration) {
+	e.mu.Lock()
+	leading, renewed := e.leading, e.renewed
+	e.mu.Unlock()
+	start := time.Now()
+	deadline := start.Add(timeout)
+	stepDownAt := renewed.Add(e.duration - timeout)
+	if leading && stepDownAt.Before(deadline) {
+		deadline = stepDownAt
+	}
+
+	attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+	holder, err := e.lock.TryAcquire(attemptCtx, e.identity, e.duration)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		log.Printf("leader: lease renewal failed: %v", err)
+		if leading && !time.Now().Before(stepDownAt) {
+			e.stepDown(fmt.Sprintf("lease not renewed for %s", time.Since(renewed).Round(time.Second)))
+		}
+		return
+	}
+
+	e.mu.Lock()
+	e.leader = holder
+	if holder == e.identity {
+		// The store set the lease no earlier than the attempt started
+		e.renewed = start
+	}
+	e.mu.Unlock()
+	if holder == e.identity {
+		e.lead(ctx)
+	} else {
+		e.stepDown("lease held by " + holder)
+	}
+}
+
+// lead starts the jobs when this replica has just become the leader
+func (e *Elector) lead(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leading {
+		return
+	}
+	e.jobCtx, e.stop = context.WithCancel(ctx)
+	e.leading, e.since = true, time.Now()
+	e.leadingGauge.Set(1)
+	e.transitions.Inc()
+	log.Printf("leader: %s is now the leader, starting %d job(s)", e.identity, len(e.jobs))
+	for _, job := range e.jobs {
+		e.start(job)
+	}
+}
+
+// start runs a job until this replica steps down; e.mu must be held while
+// leading
+func (e *Elector) start(job Job) {
+	ctx := e.jobCtx
+	e.done.Add(1)
+	go func() {
+		defer e.done.Done()
+		job(ctx)
+	}()
+}
+
+// stepDown stops the jobs, and waits for them, when this replica leads
+func (e *Elector) stepDown(reason string) {
+	e.mu.Lock()
+	if !e.leading {
+		e.mu.Unlock()
+		return
+	}
+	e.leading, e.since = false, time.Time{}
+	e.stop()
+	e.leadingGauge.Set(0)
+	e.transitions.Inc()
+	e.mu.Unlock()
+	log.Printf("leader: %s stepped down (%s), stopping jobs", e.identity, reason)
+	e.done.Wait()
+}
+
+// Standalone is the lock of a single replica, which always leads
+type Standalone struct{}
+
+// TryAcquire implements Lock
+func (Standalone) TryAcquire(_ context.Context, identity string, _ time.Duration) (string, error) {
+	return identity, nil
+}
+
+// Release implements Lock
+func (Standalone) Release(context.Context, string) error { return nil }
@@ -0,0 +1,358 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// memoryLock is a Lock held in memory, which can be made to fail
+type memoryLock struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+	failing bool
+	// stalled attempts hang until they time out; latency delays the reply
+	// to the others after the lease is set
+	stalled bool
+	latency time.Duration
+}
+
+func (l *memoryLock) TryAcquire(ctx context.Context, identity string, duration time.Duration) (string, error) {
+	l.mu.Lock()
+	if l.stalled {
+		l.mu.Unlock()
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if l.failing {
+		l.mu.Unlock()
+		return "", errors.New("store unreachable")
+	}
+	if l.holder == "" || l.holder == identity || time.Now().After(l.expires) {
+		l.holder, l.expires = identity, time.Now().Add(duration)
+	}
+	holder, latency := l.holder, l.latency
+	l.mu.Unlock()
+	time.Sleep(latency)
+	return holder, nil
+}
+
+func (l *memoryLock) Release(_ context.Context, identity string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == identity {
+		l.holder = ""
+	}
+	return nil
+}
+
+func (l *memoryLock) setFailing(failing bool) {
+	l.mu.Lock()
+	l.failing = failing
+	l.mu.Unlock()
+}
+
+func (l *memoryLock) setStalled(stalled bool) {
+	l.mu.Lock()
+	l.stalled = stalled
+	l.mu.Unlock()
+}
+
+func (l *memoryLock) setLatency(latency time.Duration) {
+	l.mu.Lock()
+	l.latency = latency
+	l.mu.Unlock()
+}
+
+func (l *memoryLock) expiry() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expires
+}
+
+// waitFor polls cond for up to two seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestElectorRunsJobsOnlyOnTheLeader(t *testing.T) {
+	lock := &memoryLock{}
+	var running [2]atomic.Int32
+	electors := make([]*Elector, 2)
+	cancels := make([]context.CancelFunc, 2)
+	done := make([]chan struct{}, 2)
+	for i := range electors {
+		i := i
+		electors[i] = New(lock, []string{"replica-0", "replica-1"}[i], 150*time.Millisecond)
+		electors[i].Add("janitor", func(ctx context.Context) {
+			running[i].Add(1)
+			<-ctx.Done()
+			running[i].Add(-1)
+		})
+		var ctx context.Context
+		ctx, cancels[i] = context.WithCancel(context.Background())
+		done[i] = make(chan struct{})
+		go func() {
+			electors[i].Run(ctx)
+			close(done[i])
+		}()
+	}
+	defer func() {
+		for i := range cancels {
+			cancels[i]()
+			<-done[i]
+		}
+	}()
+
+	waitFor(t, "a leader", func() bool { return running[0].Load()+running[1].Load() == 1 })
+	leader, follower := 0, 1
+	if running[1].Load() == 1 {
+		leader, follower = 1, 0
+	}
+	waitFor(t, "the follower to see the leader", func() bool {
+		return electors[follower].Status().Leader == electors[leader].Status().Identity
+	})
+	if status := electors[leader].Status(); !status.Leading || len(status.Jobs) != 1 || status.Jobs[0] != "janitor" {
+		t.Errorf("leader status = %+v", status)
+	}
+
+	// The leader shuts down and releases the lease; the follower takes over
+	cancels[leader]()
+	<-done[leader]
+	if running[leader].Load() != 0 {
+		t.Error("jobs kept running after shutdown")
+	}
+	waitFor(t, "the follower to take over", func() bool { return running[follower].Load() == 1 })
+}
+
+func TestElectorStepsDownWhenRenewalFails(t *testing.T) {
+	lock := &memoryLock{}
+	e := New(lock, "replica-0", 150*time.Millisecond)
+	var running atomic.Int32
+	e.Add("rule-sync", func(ctx context.Context) {
+		running.Add(1)
+		<-ctx.Done()
+		running.Add(-1)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	waitFor(t, "the job to start", func() bool { return running.Load() == 1 })
+	lock.setFailing(true)
+	waitFor(t, "the job to stop", func() bool { return running.Load() == 0 && !e.Status().Leading })
+	lock.setFailing(false)
+	waitFor(t, "the job to restart", func() bool { return running.Load() == 1 })
+}
+
+func TestElectorStartsJobsAddedWhileLeading(t *testing.T) {
+	e := New(Standalone{}, "replica-0", 150*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, "leadership", func() bool { return e.Status().Leading })
+
+	var running atomic.Int32
+	e.Add("backup", func(ctx context.Context) {
+		running.Add(1)
+		<-ctx.Done()
+		running.Add(-1)
+	})
+	waitFor(t, "the added job to start", func() bool { return running.Load() == 1 })
+	cancel()
+	<-done
+	if running.Load() != 0 {
+		t.Error("added job kept running after shutdown")
+	}
+}
+
+func TestElectorStepsDownBeforeAStalledLeaseExpires(t *testing.T) {
+	lock := &memoryLock{}
+	e := New(lock, "replica-0", 300*time.Millisecond)
+	var running atomic.Int32
+	stopped := make(chan time.Time, 1)
+	e.Add("rule-sync", func(ctx context.Context) {
+		running.Add(1)
+		<-ctx.Done()
+		stopped <- time.Now()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	waitFor(t, "the job to start", func() bool { return running.Load() == 1 })
+	// A slow renewal, replying well after it set the lease, and then every
+	// attempt hangs until its timeout
+	lock.setLatency(80 * time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	lock.setStalled(true)
+	select {
+	case at := <-stopped:
+		if expires := lock.expiry(); !at.Before(expires) {
+			t.Errorf("job stopped %s after the lease expired", at.Sub(expires))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the job to stop")
+	}
+	if e.Status().Leading {
+		t.Error("still leading with a stalled store")
+	}
+}
+
+func TestKubernetesLease(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset()
+	lease := func() *KubernetesLease {
+		return &KubernetesLease{Client: client.CoordinationV1(), Namespace: "apm", Name: "apm-server", now: func() time.Time { return now }}
+	}
+	a, b := lease(), lease()
+	ctx := context.Background()
+
+	if holder, err := a.TryAcquire(ctx, "a", 15*time.Second); err != nil || holder != "a" {
+		t.Fatalf("first acquire = %q, %v", holder, err)
+	}
+	if holder, err := b.TryAcquire(ctx, "b", 15*time.Second); err != nil || holder != "a" {
+		t.Fatalf("acquire of a held lease = %q, %v", holder, err)
+	}
+	if holder, _ := a.TryAcquire(ctx, "a", 15*time.Second); holder != "a" {
+		t.Fatalf("renewal = %q", holder)
+	}
+
+	now = now.Add(20 * time.Second)
+	if holder, err := b.TryAcquire(ctx, "b", 15*time.Second); err != nil || holder != "b" {
+		t.Fatalf("takeover of an expired lease = %q, %v", holder, err)
+	}
+	got, _ := client.CoordinationV1().Leases("apm").Get(ctx, "apm-server", metav1.GetOptions{})
+	if *got.Spec.HolderIdentity != "b" || *got.Spec.LeaseTransitions != 1 {
+		t.Errorf("lease spec = %+v", got.Spec)
+	}
+
+	if err := b.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := a.TryAcquire(ctx, "a", 15*time.Second); holder != "a" {
+		t.Errorf("acquire of a released lease = %q", holder)
+	}
+}
+
+// fakeEtcd implements the lease and transaction calls of the etcd gateway
+// used by EtcdLease, for a single key
+type fakeEtcd struct {
+	mu     sync.Mutex
+	nextID int64
+	leases map[int64]bool
+	value  string
+	owner  int64
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var req map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&req)
+	id := func() int64 {
+		var s string
+		json.Unmarshal(req["ID"], &s)
+		var n int64
+		json.Unmarshal([]byte(s), &n)
+		return n
+	}
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		f.nextID++
+		f.leases[f.nextID] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": strconv.FormatInt(f.nextID, 10), "TTL": "15"})
+	case "/v3/lease/keepalive":
+		ttl := "0"
+		if f.leases[id()] {
+			ttl = "15"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]string{"TTL": ttl}})
+	case "/v3/lease/revoke":
+		delete(f.leases, id())
+		if f.owner == id() {
+			f.value, f.owner = "", 0
+		}
+		w.Write([]byte("{}"))
+	case "/v3/kv/txn":
+		var txn struct {
+			Success []struct {
+				Put struct {
+					Value []byte `json:"value"`
+					Lease int64  `json:"lease,string"`
+				} `json:"request_put"`
+			} `json:"success"`
+		}
+		raw, _ := json.Marshal(req)
+		json.Unmarshal(raw, &txn)
+		if f.value == "" {
+			f.value, f.owner = string(txn.Success[0].Put.Value), txn.Success[0].Put.Lease
+			w.Write([]byte(`{"succeeded":true}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"responses": []interface{}{map[string]interface{}{
+				"response_range": map[string]interface{}{"kvs": []interface{}{map[string]interface{}{"value": []byte(f.value), "lease": strconv.FormatInt(f.owner, 10)}}},
+			}},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEtcdLease(t *testing.T) {
+	etcd := &fakeEtcd{leases: map[int64]bool{}}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	ctx := context.Background()
+	a := &EtcdLease{Endpoints: []string{"http://127.0.0.1:1", server.URL}, Key: "apm/leader"}
+	b := &EtcdLease{Endpoints: []string{server.URL}, Key: "apm/leader"}
+
+	if holder, err := a.TryAcquire(ctx, "a", 15*time.Second); err != nil || holder != "a" {
+		t.Fatalf("first acquire = %q, %v", holder, err)
+	}
+	if holder, err := b.TryAcquire(ctx, "b", 15*time.Second); err != nil || holder != "a" {
+		t.Fatalf("acquire of a held key = %q, %v", holder, err)
+	}
+
+	// a's lease expires, which deletes the key
+	etcd.mu.Lock()
+	delete(etcd.leases, a.lease)
+	etcd.value, etcd.owner = "", 0
+	etcd.mu.Unlock()
+	if holder, _ := b.TryAcquire(ctx, "b", 15*time.Second); holder != "b" {
+		t.Fatalf("acquire after expiry = %q", holder)
+	}
+	if holder, _ := a.TryAcquire(ctx, "a", 15*time.Second); holder != "b" {
+		t.Errorf("a with an expired lease = %q", holder)
+	}
+
+	if err := b.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := a.TryAcquire(ctx, "a", 15*time.Second); holder != "a" {
+		t.Errorf("acquire after release = %q", holder)
+	}
+}
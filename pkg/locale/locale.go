@@ -0,0 +1,200 @@
+// Package locale formats times and numbers for people: times in a chosen
+// timezone with the date order of a locale, and numbers with its digit
+// grouping and decimal separator. Machine-readable output (JSON, YAML, logs
+// for ingestion) keeps RFC 3339 UTC and plain numbers.
+package locale
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// Environment variables read by FromEnv, before the POSIX LC_ALL, LC_TIME,
+// LANG, and TZ
+const (
+	LocaleEnv   = "APM_LOCALE"
+	TimezoneEnv = "APM_TIMEZONE"
+)
+
+// layouts are the date and clock layouts of a locale
+type layouts struct {
+	date  string
+	clock string
+}
+
+// Date orders by language or region; locales not listed use ISO 8601 dates
+// and a 24-hour clock, which nobody misreads
+var (
+	iso           = layouts{"2006-01-02", "15:04:05"}
+	regionLayouts = map[string]layouts{
+		"US": {"01/02/2006", "3:04:05 PM"},
+		"GB": {"02/01/2006", "15:04:05"},
+		"IE": {"02/01/2006", "15:04:05"},
+		"AU": {"02/01/2006", "3:04:05 pm"},
+		"NZ": {"02/01/2006", "3:04:05 pm"},
+		"IN": {"02/01/2006", "3:04:05 pm"},
+		"CA": {"2006-01-02", "3:04:05 p.m."},
+	}
+	languageLayouts = map[string]layouts{
+		"de": {"02.01.2006", "15:04:05"},
+		"fr": {"02/01/2006", "15:04:05"},
+		"es": {"02/01/2006", "15:04:05"},
+		"it": {"02/01/2006", "15:04:05"},
+		"pt": {"02/01/2006", "15:04:05"},
+		"nl": {"02-01-2006", "15:04:05"},
+		"pl": {"02.01.2006", "15:04:05"},
+		"ru": {"02.01.2006", "15:04:05"},
+		"ja": {"2006/01/02", "15:04:05"},
+		"zh": {"2006/01/02", "15:04:05"},
+		"ko": {"2006. 01. 02.", "15:04:05"},
+	}
+)
+
+// Formatter formats times and numbers for one locale and timezone
+type Formatter struct {
+	tag      language.Tag
+	location *time.Location
+	layouts  layouts
+	printer  *message.Printer
+}
+
+// UTC formats in UTC with ISO 8601 dates and a 24-hour clock
+var UTC = &Formatter{tag: language.Und, location: time.UTC, layouts: iso, printer: message.NewPrinter(language.Und)}
+
+// New creates a formatter for a BCP 47 or POSIX locale such as en-GB or
+// de_DE.UTF-8, and an IANA timezone such as Europe/Berlin, "UTC", or "Local".
+// An empty locale uses ISO 8601 and an empty timezone the local one.
+func New(locale, timezone string) (*Formatter, error) {
+	tag := language.Und
+	if locale = posix(locale); locale != "" {
+		var err error
+		if tag, err = language.Parse(locale); err != nil {
+			return nil, fmt.Errorf("invalid locale %q: %w", locale, err)
+		}
+	}
+	location, err := LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	l := iso
+	base, _ := tag.Base()
+	region, confidence := tag.Region()
+	if found, ok := languageLayouts[base.String()]; ok {
+		l = found
+	}
+	// The region only counts when given, e.g. en-GB but not en
+	if found, ok := regionLayouts[region.String()]; ok && confidence == language.Exact {
+		l = found
+	}
+	return &Formatter{tag: tag, location: location, layouts: l, printer: message.NewPrinter(tag)}, nil
+}
+
+// FromEnv creates a formatter from APM_LOCALE and APM_TIMEZONE, falling back
+// to the POSIX LC_ALL, LC_TIME, and LANG for the locale and TZ for the
+// timezone
+func FromEnv() (*Formatter, error) {
+	locale := firstEnv(LocaleEnv, "LC_ALL", "LC_TIME", "LANG")
+	timezone := firstEnv(TimezoneEnv, "TZ")
+	return New(locale, timezone)
+}
+
+// LoadLocation loads an IANA timezone; empty and "Local" are the local one
+func LoadLocation(name string) (*time.Location, error) {
+	switch strings.TrimSpace(name) {
+	case "", "Local", "local":
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: use an IANA name such as Europe/Berlin or UTC", name)
+	}
+	return location, nil
+}
+
+// posix converts a POSIX locale such as de_DE.UTF-8@euro to de-DE. C and
+// POSIX name no locale.
+func posix(locale string) string {
+	locale, _, _ = strings.Cut(strings.TrimSpace(locale), ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	if locale == "C" || locale == "POSIX" {
+		return ""
+	}
+	return strings.ReplaceAll(locale, "_", "-")
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Location returns the timezone times are shown in
+func (f *Formatter) Location() *time.Location {
+	return f.location
+}
+
+// Locale returns the locale, "und" when none was set
+func (f *Formatter) Locale() string {
+	return f.tag.String()
+}
+
+// In converts t to the timezone of the formatter
+func (f *Formatter) In(t time.Time) time.Time {
+	return t.In(f.location)
+}
+
+// DateTime formats a date and time with the timezone abbreviation, e.g.
+// 2024-05-01 14:00:00 CEST
+func (f *Formatter) DateTime(t time.Time) string {
+	return f.In(t).Format(f.layouts.date + " " + f.layouts.clock + " MST")
+}
+
+// DateTimeMillis is DateTime with milliseconds, for log lines and traces
+func (f *Formatter) DateTimeMillis(t time.Time) string {
+	return f.In(t).Format(f.layouts.date + " " + millis(f.layouts.clock) + " MST")
+}
+
+// Date formats the date of t
+func (f *Formatter) Date(t time.Time) string {
+	return f.In(t).Format(f.layouts.date)
+}
+
+// Clock formats the time of day of t
+func (f *Formatter) Clock(t time.Time) string {
+	return f.In(t).Format(f.layouts.clock)
+}
+
+// ClockMillis formats the time of day of t with milliseconds
+func (f *Formatter) ClockMillis(t time.Time) string {
+	return f.In(t).Format(millis(f.layouts.clock))
+}
+
+// millis adds milliseconds to the seconds of a clock layout
+func millis(clock string) string {
+	return strings.Replace(clock, ":05", ":05.000", 1)
+}
+
+// Int formats an integer with the digit grouping of the locale
+func (f *Formatter) Int(n int64) string {
+	return f.printer.Sprint(number.Decimal(n))
+}
+
+// Float formats a number with the given digits after the decimal separator
+func (f *Formatter) Float(v float64, digits int) string {
+	return f.printer.Sprint(number.Decimal(v, number.MinFractionDigits(digits), number.MaxFractionDigits(digits)))
+}
+
+// Percent formats a ratio as a percentage, e.g. 0.125 as 12.5%
+func (f *Formatter) Percent(ratio float64, digits int) string {
+	return f.Float(ratio*100, digits) + "%"
+}
@@ -0,0 +1,76 @@
+package locale
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 5, 250*int(time.Millisecond), time.UTC)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		locale   string
+		timezone string
+		dateTime string
+		millis   string
+		number   string
+	}{
+		{"", "UTC", "2024-05-01 12:00:05 UTC", "12:00:05.250", "1,234,567.50"},
+		{"en-US", "America/New_York", "05/01/2024 8:00:05 AM EDT", "8:00:05.250 AM", "1,234,567.50"},
+		{"en_GB.UTF-8", "Europe/London", "01/05/2024 13:00:05 BST", "13:00:05.250", "1,234,567.50"},
+		{"de_DE.UTF-8@euro", "Europe/Berlin", "01.05.2024 14:00:05 CEST", "14:00:05.250", "1.234.567,50"},
+		{"ja", "Asia/Tokyo", "2024/05/01 21:00:05 JST", "21:00:05.250", "1,234,567.50"},
+		{"C", "UTC", "2024-05-01 12:00:05 UTC", "12:00:05.250", "1,234,567.50"},
+	}
+	for _, tt := range tests {
+		f, err := New(tt.locale, tt.timezone)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.locale, err)
+		}
+		if got := f.DateTime(t0); got != tt.dateTime {
+			t.Errorf("%s: DateTime = %q, want %q", tt.locale, got, tt.dateTime)
+		}
+		if got := f.ClockMillis(t0); got != tt.millis {
+			t.Errorf("%s: ClockMillis = %q, want %q", tt.locale, got, tt.millis)
+		}
+		if got := f.Float(1234567.5, 2); got != tt.number {
+			t.Errorf("%s: Float = %q, want %q", tt.locale, got, tt.number)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New("", "Mars/Olympus_Mons"); err == nil {
+		t.Error("expected an unknown timezone to be rejected")
+	}
+	if _, err := New("not a locale", "UTC"); err == nil {
+		t.Error("expected an invalid locale to be rejected")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(LocaleEnv, "")
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_TIME", "fr_FR.UTF-8")
+	t.Setenv("LANG", "en_US.UTF-8")
+	t.Setenv(TimezoneEnv, "Europe/Paris")
+	f, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Locale() != "fr-FR" || f.Location().String() != "Europe/Paris" {
+		t.Errorf("locale = %s, timezone = %s", f.Locale(), f.Location())
+	}
+	if got := f.Date(t0); got != "01/05/2024" {
+		t.Errorf("Date = %q", got)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	if got := UTC.Int(1234567); got != "1,234,567" {
+		t.Errorf("Int = %q", got)
+	}
+	if got := UTC.Percent(0.12345, 1); got != "12.3%" {
+		t.Errorf("Percent = %q", got)
+	}
+}
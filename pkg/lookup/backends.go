@@ -0,0 +1,258 @@
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxServices bounds the services searched when Jaeger lists them
+const maxServices = 50
+
+// Jaeger searches the Jaeger query API
+type Jaeger struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements TraceSearcher
+func (j *Jaeger) Name() string { return "jaeger" }
+
+// SearchTraces implements TraceSearcher, returning one event per span
+func (j *Jaeger) SearchTraces(ctx context.Context, q Query) ([]Event, error) {
+	services := q.Services
+	if len(services) == 0 {
+		var resp struct {
+			Data []string `json:"data"`
+		}
+		if err := getJSON(ctx, j.Client, strings.TrimRight(j.URL, "/")+"/api/services", &resp); err != nil {
+			return nil, err
+		}
+		services = resp.Data
+		if len(services) > maxServices {
+			services = services[:maxServices]
+		}
+	}
+
+	tags, err := json.Marshal(map[string]string{q.Attribute: q.Value})
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	seen := make(map[string]bool)
+	for _, service := range services {
+		params := url.Values{}
+		params.Set("service", service)
+		params.Set("tags", string(tags))
+		params.Set("start", strconv.FormatInt(q.Start.UnixMicro(), 10))
+		params.Set("end", strconv.FormatInt(q.End.UnixMicro(), 10))
+		params.Set("limit", strconv.Itoa(q.Limit))
+
+		var resp jaegerTracesResponse
+		if err := getJSON(ctx, j.Client, strings.TrimRight(j.URL, "/")+"/api/traces?"+params.Encode(), &resp); err != nil {
+			return nil, err
+		}
+
+		for _, t := range resp.Data {
+			// A trace spanning several services is returned for each of them
+			if seen[t.TraceID] {
+				continue
+			}
+			seen[t.TraceID] = true
+			events = append(events, t.events()...)
+		}
+	}
+	return events, nil
+}
+
+type jaegerTracesResponse struct {
+	Data []jaegerTrace `json:"data"`
+}
+
+type jaegerTrace struct {
+	TraceID string `json:"traceID"`
+	Spans   []struct {
+		SpanID        string      `json:"spanID"`
+		OperationName string      `json:"operationName"`
+		StartTime     int64       `json:"startTime"` // microseconds
+		Duration      int64       `json:"duration"`  // microseconds
+		ProcessID     string      `json:"processID"`
+		Tags          []jaegerTag `json:"tags"`
+	} `json:"spans"`
+	Processes map[string]struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"processes"`
+}
+
+type jaegerTag struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// events converts the spans of a trace to timeline events
+func (t jaegerTrace) events() []Event {
+	events := make([]Event, 0, len(t.Spans))
+	for _, span := range t.Spans {
+		e := Event{
+			Time:       time.UnixMicro(span.StartTime).UTC(),
+			Source:     SourceTrace,
+			Service:    t.Processes[span.ProcessID].ServiceName,
+			TraceID:    t.TraceID,
+			SpanID:     span.SpanID,
+			Summary:    span.OperationName,
+			Duration:   time.Duration(span.Duration) * time.Microsecond,
+			Attributes: make(map[string]string, len(span.Tags)),
+		}
+		for _, tag := range span.Tags {
+			value := fmt.Sprint(tag.Value)
+			e.Attributes[tag.Key] = value
+			if tag.Key == "error" && value == "true" || tag.Key == "otel.status_code" && value == "ERROR" {
+				e.Error = true
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Tempo searches the Tempo search API
+type Tempo struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements TraceSearcher
+func (t *Tempo) Name() string { return "tempo" }
+
+// SearchTraces implements TraceSearcher, returning one event per matching trace
+func (t *Tempo) SearchTraces(ctx context.Context, q Query) ([]Event, error) {
+	params := url.Values{}
+	params.Set("tags", q.Attribute+"="+q.Value)
+	params.Set("start", strconv.FormatInt(q.Start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(q.End.Unix(), 10))
+	params.Set("limit", strconv.Itoa(q.Limit))
+
+	var resp struct {
+		Traces []struct {
+			TraceID           string `json:"traceID"`
+			RootServiceName   string `json:"rootServiceName"`
+			RootTraceName     string `json:"rootTraceName"`
+			StartTimeUnixNano string `json:"startTimeUnixNano"`
+			DurationMs        int64  `json:"durationMs"`
+		} `json:"traces"`
+	}
+	if err := getJSON(ctx, t.Client, strings.TrimRight(t.URL, "/")+"/api/search?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(resp.Traces))
+	for _, tr := range resp.Traces {
+		ns, _ := strconv.ParseInt(tr.StartTimeUnixNano, 10, 64)
+		events = append(events, Event{
+			Time:     time.Unix(0, ns).UTC(),
+			Source:   SourceTrace,
+			Service:  tr.RootServiceName,
+			TraceID:  tr.TraceID,
+			Summary:  tr.RootTraceName,
+			Duration: time.Duration(tr.DurationMs) * time.Millisecond,
+		})
+	}
+	return events, nil
+}
+
+// Loki searches logs with LogQL
+type Loki struct {
+	URL string
+	// Selector is the stream selector searched, e.g. {namespace="shop"}.
+	// Defaults to every stream with a job label.
+	Selector string
+	Client   *http.Client
+}
+
+// Name implements LogSearcher
+func (l *Loki) Name() string { return "loki" }
+
+// SearchLogs implements LogSearcher
+func (l *Loki) SearchLogs(ctx context.Context, q Query, terms []string) ([]Event, error) {
+	selector := l.Selector
+	if selector == "" {
+		selector = `{job=~".+"}`
+	}
+
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(term))
+	}
+
+	params := url.Values{}
+	params.Set("query", selector+" |~ "+strconv.Quote(strings.Join(quoted, "|")))
+	params.Set("start", strconv.FormatInt(q.Start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(q.End.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(q.Limit*10))
+	params.Set("direction", "forward")
+
+	var resp struct {
+		Data struct {
+			Result []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, l.Client, strings.TrimRight(l.URL, "/")+"/loki/api/v1/query_range?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, stream := range resp.Data.Result {
+		service := stream.Stream["service_name"]
+		if service == "" {
+			service = stream.Stream["job"]
+		}
+		for _, v := range stream.Values {
+			ns, _ := strconv.ParseInt(v[0], 10, 64)
+			line := v[1]
+			events = append(events, Event{
+				Time:       time.Unix(0, ns).UTC(),
+				Source:     SourceLog,
+				Service:    service,
+				TraceID:    extractTraceID(line),
+				Summary:    line,
+				Error:      strings.Contains(strings.ToLower(line), `"level":"error"`),
+				Attributes: stream.Stream,
+			})
+		}
+	}
+	return events, nil
+}
+
+// getJSON fetches a URL and decodes a successful JSON response
+func getJSON(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient(client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
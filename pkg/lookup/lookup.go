@@ -0,0 +1,171 @@
+// Package lookup finds the traces and logs of a business identifier, such as
+// an order or user ID recorded as a span attribute, across the tracing and
+// logging backends and merges them into one timeline for support tooling.
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Query identifies the business entity to look up
+type Query struct {
+	// Attribute is the span attribute holding the identifier, e.g. "order.id"
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Services restricts the Jaeger search; empty searches every service
+	Services []string `json:"services,omitempty"`
+
+	// Limit bounds traces per backend and log lines
+	Limit int `json:"limit"`
+}
+
+// Validate checks the query and fills defaults
+func (q *Query) Validate() error {
+	if q.Attribute == "" || q.Value == "" {
+		return fmt.Errorf("attribute and value are required")
+	}
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+	if q.Start.IsZero() {
+		q.Start = q.End.Add(-24 * time.Hour)
+	}
+	if !q.Start.Before(q.End) {
+		return fmt.Errorf("start must be before end")
+	}
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	return nil
+}
+
+// EventSource identifies where a timeline event came from
+type EventSource string
+
+const (
+	SourceTrace EventSource = "trace"
+	SourceLog   EventSource = "log"
+)
+
+// Event is one entry in the consolidated timeline
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Source     EventSource       `json:"source"`
+	Service    string            `json:"service,omitempty"`
+	TraceID    string            `json:"trace_id,omitempty"`
+	SpanID     string            `json:"span_id,omitempty"`
+	Summary    string            `json:"summary"`
+	Duration   time.Duration     `json:"duration,omitempty"`
+	Error      bool              `json:"error,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Result is the consolidated lookup result
+type Result struct {
+	Query    Query    `json:"query"`
+	TraceIDs []string `json:"trace_ids"`
+	Events   []Event  `json:"events"`
+	// Errors lists backends that could not be searched
+	Errors []string `json:"errors,omitempty"`
+}
+
+// TraceSearcher finds spans carrying an attribute value
+type TraceSearcher interface {
+	Name() string
+	SearchTraces(ctx context.Context, q Query) ([]Event, error)
+}
+
+// LogSearcher finds log lines mentioning any of the terms
+type LogSearcher interface {
+	Name() string
+	SearchLogs(ctx context.Context, q Query, terms []string) ([]Event, error)
+}
+
+// Service searches every configured backend
+type Service struct {
+	Traces []TraceSearcher
+	Logs   []LogSearcher
+}
+
+// Lookup searches traces for the identifier, then logs for the identifier and
+// the trace IDs found, and returns events ordered by time. Backends that fail
+// are reported in Result.Errors; an error is returned only when all fail.
+func (s *Service) Lookup(ctx context.Context, q Query) (*Result, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Query: q, TraceIDs: []string{}, Events: []Event{}}
+	var failures []error
+	searched := 0
+
+	seen := make(map[string]bool)
+	for _, searcher := range s.Traces {
+		events, err := searcher.SearchTraces(ctx, q)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", searcher.Name(), err))
+			continue
+		}
+		searched++
+		for _, e := range events {
+			if e.TraceID != "" && !seen[e.TraceID] {
+				seen[e.TraceID] = true
+				result.TraceIDs = append(result.TraceIDs, e.TraceID)
+			}
+		}
+		result.Events = append(result.Events, events...)
+	}
+
+	terms := append([]string{q.Value}, result.TraceIDs...)
+	for _, searcher := range s.Logs {
+		events, err := searcher.SearchLogs(ctx, q, terms)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", searcher.Name(), err))
+			continue
+		}
+		searched++
+		result.Events = append(result.Events, events...)
+	}
+
+	for _, err := range failures {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	if searched == 0 && len(failures) > 0 {
+		return result, errors.Join(failures...)
+	}
+
+	sort.SliceStable(result.Events, func(i, j int) bool {
+		return result.Events[i].Time.Before(result.Events[j].Time)
+	})
+	return result, nil
+}
+
+// traceIDPattern finds trace IDs written by the instrumentation loggers
+var traceIDPattern = regexp.MustCompile(`(?i)trace_?id"?\s*[:=]\s*"?([0-9a-f]{32})`)
+
+// extractTraceID returns the trace ID mentioned in a log line
+func extractTraceID(line string) string {
+	if m := traceIDPattern.FindStringSubmatch(line); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// httpClient returns the client or a default with a timeout
+func httpClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
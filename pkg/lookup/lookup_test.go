@@ -0,0 +1,129 @@
+package lookup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestLookup(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var logQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/services":
+			w.Write([]byte(`{"data":["checkout","payments"]}`))
+		case "/api/traces":
+			if r.URL.Query().Get("tags") != `{"order.id":"A-1042"}` {
+				t.Errorf("unexpected tags %q", r.URL.Query().Get("tags"))
+			}
+			// Both services return the same trace
+			w.Write([]byte(`{"data":[{"traceID":"` + testTraceID + `","spans":[
+				{"spanID":"a1","operationName":"POST /orders","startTime":` + micros(start) + `,"duration":120000,"processID":"p1","tags":[{"key":"order.id","value":"A-1042"}]},
+				{"spanID":"b2","operationName":"charge","startTime":` + micros(start.Add(50*time.Millisecond)) + `,"duration":60000,"processID":"p2","tags":[{"key":"error","value":true}]}
+			],"processes":{"p1":{"serviceName":"checkout"},"p2":{"serviceName":"payments"}}}]}`))
+		case "/loki/api/v1/query_range":
+			logQuery = r.URL.Query().Get("query")
+			w.Write([]byte(`{"data":{"result":[{"stream":{"job":"payments"},"values":[
+				["` + nanos(start.Add(80*time.Millisecond)) + `","{\"level\":\"error\",\"msg\":\"card declined\",\"trace_id\":\"` + testTraceID + `\"}"]
+			]}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	service := &Service{
+		Traces: []TraceSearcher{&Jaeger{URL: server.URL}},
+		Logs:   []LogSearcher{&Loki{URL: server.URL}},
+	}
+	result, err := service.Lookup(context.Background(), Query{
+		Attribute: "order.id",
+		Value:     "A-1042",
+		Start:     start.Add(-time.Hour),
+		End:       start.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.TraceIDs) != 1 || result.TraceIDs[0] != testTraceID {
+		t.Errorf("unexpected trace IDs %v", result.TraceIDs)
+	}
+	if !strings.Contains(logQuery, "A-1042|"+testTraceID) {
+		t.Errorf("expected logs to be searched for the value and trace ID, got %q", logQuery)
+	}
+
+	if len(result.Events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(result.Events))
+	}
+	want := []struct {
+		source  EventSource
+		service string
+		err     bool
+	}{
+		{SourceTrace, "checkout", false},
+		{SourceTrace, "payments", true},
+		{SourceLog, "payments", true},
+	}
+	for i, w := range want {
+		e := result.Events[i]
+		if e.Source != w.source || e.Service != w.service || e.Error != w.err || e.TraceID != testTraceID {
+			t.Errorf("event %d: unexpected %+v", i, e)
+		}
+	}
+}
+
+func TestLookupBackendFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/loki") {
+			w.Write([]byte(`{"data":{"result":[]}}`))
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	service := &Service{
+		Traces: []TraceSearcher{&Tempo{URL: server.URL}},
+		Logs:   []LogSearcher{&Loki{URL: server.URL}},
+	}
+	result, err := service.Lookup(context.Background(), Query{Attribute: "user.id", Value: "42"})
+	if err != nil {
+		t.Fatalf("expected a partial result, got %v", err)
+	}
+	if len(result.Errors) != 1 || !strings.HasPrefix(result.Errors[0], "tempo:") {
+		t.Errorf("expected the tempo failure to be reported, got %v", result.Errors)
+	}
+
+	service.Logs = nil
+	if _, err := service.Lookup(context.Background(), Query{Attribute: "user.id", Value: "42"}); err == nil {
+		t.Error("expected an error when every backend fails")
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	q := Query{Attribute: "order.id"}
+	if err := q.Validate(); err == nil {
+		t.Error("expected an error without a value")
+	}
+
+	q.Value = "A-1042"
+	if err := q.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.Limit != 20 || q.End.Sub(q.Start) != 24*time.Hour {
+		t.Errorf("expected defaults to be filled, got %+v", q)
+	}
+}
+
+func micros(t time.Time) string { return strconv.FormatInt(t.UnixMicro(), 10) }
+
+func nanos(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
@@ -0,0 +1,42 @@
+package mcp
+
+import (
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+)
+
+// EventToolCall is the audit event type of a tool call
+const EventToolCall = "mcp_tool_call"
+
+// Audit outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// AuditEvent records one tool call
+type AuditEvent struct {
+	Timestamp  time.Time `json:"timestamp"`
+	EventType  string    `json:"event_type"`
+	Actor      string    `json:"username,omitempty"`
+	Tool       string    `json:"tool"`
+	Arguments  string    `json:"arguments"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// Auditor records tool calls
+type Auditor = auditlog.Auditor[AuditEvent]
+
+// FileAuditor appends tool calls as JSON lines to a file
+type FileAuditor = auditlog.File[AuditEvent]
+
+// NewFileAuditor creates an auditor writing to path
+func NewFileAuditor(path string) *FileAuditor {
+	return auditlog.NewFile[AuditEvent]("MCP", path)
+}
+
+// MemoryAuditor keeps tool calls in memory, for tests
+type MemoryAuditor = auditlog.Memory[AuditEvent]
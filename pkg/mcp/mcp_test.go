@@ -0,0 +1,152 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, s *Server, messages ...string) []map[string]interface{} {
+	t.Helper()
+	var out bytes.Buffer
+	if err := s.Serve(context.Background(), strings.NewReader(strings.Join(messages, "\n")+"\n"), &out); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	var responses []map[string]interface{}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp map[string]interface{}
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+func TestServerProtocol(t *testing.T) {
+	s := &Server{Name: "apm", Version: "test", Tools: []Tool{{
+		Name:        "echo",
+		InputSchema: schema(map[string]interface{}{}),
+		Handler:     func(ctx context.Context, args json.RawMessage) (string, error) { return string(args), nil },
+	}}}
+
+	responses := serve(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"missing"}}`,
+		`not json`,
+	)
+	if len(responses) != 5 {
+		t.Fatalf("expected 5 responses (none for the notification), got %d", len(responses))
+	}
+
+	byID := map[string]map[string]interface{}{}
+	for _, r := range responses {
+		byID[string(mustJSON(r["id"]))] = r
+	}
+	init := byID["1"]["result"].(map[string]interface{})
+	if init["protocolVersion"] != ProtocolVersion {
+		t.Errorf("unexpected initialize result %v", init)
+	}
+	tools := byID["2"]["result"].(map[string]interface{})["tools"].([]interface{})
+	if len(tools) != 1 || tools[0].(map[string]interface{})["name"] != "echo" {
+		t.Errorf("unexpected tools %v", tools)
+	}
+	if code := byID["3"]["error"].(map[string]interface{})["code"]; code != float64(codeMethodNotFound) {
+		t.Errorf("expected method not found, got %v", code)
+	}
+	if code := byID["4"]["error"].(map[string]interface{})["code"]; code != float64(codeInvalidParams) {
+		t.Errorf("expected invalid params, got %v", code)
+	}
+	if code := byID["null"]["error"].(map[string]interface{})["code"]; code != float64(codeParseError) {
+		t.Errorf("expected parse error, got %v", code)
+	}
+}
+
+func TestToolCallAudited(t *testing.T) {
+	auditor := &MemoryAuditor{}
+	s := &Server{Actor: "ana", Auditor: auditor, Tools: []Tool{
+		{Name: "ok", Handler: func(ctx context.Context, args json.RawMessage) (string, error) { return "fine", nil }},
+		{Name: "broken", Handler: func(ctx context.Context, args json.RawMessage) (string, error) { return "", errors.New("backend down") }},
+	}}
+
+	resp := s.handle(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"ok","arguments":{"q":"up"}}}`))
+	result := resp.Result.(map[string]interface{})
+	if result["isError"] != false || result["content"].([]map[string]string)[0]["text"] != "fine" {
+		t.Errorf("unexpected result %v", result)
+	}
+	resp = s.handle(context.Background(), []byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"broken"}}`))
+	if result := resp.Result.(map[string]interface{}); result["isError"] != true {
+		t.Errorf("expected a tool error, got %v", result)
+	}
+
+	events := auditor.Events()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d", len(events))
+	}
+	if e := events[0]; e.Actor != "ana" || e.Tool != "ok" || e.Arguments != `{"q":"up"}` || e.Outcome != OutcomeSucceeded {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := events[1]; e.Outcome != OutcomeFailed || e.Error != "backend down" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestQueryMetrics(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" || r.URL.Query().Get("step") != "60" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"route":"/checkout"},"values":[[1,"0.2"],[2,"0.9"],[3,"0.4"]]},
+			{"metric":{"route":"/cart"},"values":[[1,"0.1"]]}]}}`))
+	}))
+	defer prometheus.Close()
+
+	b := &Backends{PrometheusURL: prometheus.URL, MaxResults: 1, now: func() time.Time { return now }}
+	text, err := b.queryMetrics(context.Background(), json.RawMessage(`{"query":"p99","range":"1h"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(text, "2 matrix series (showing 1)") || !strings.Contains(text, `{route="/checkout"} first=0.2 last=0.4 min=0.2 max=0.9 points=3`) {
+		t.Errorf("unexpected output %q", text)
+	}
+
+	if _, err := b.queryMetrics(context.Background(), json.RawMessage(`{"query":"up","range":"72h"}`)); err == nil {
+		t.Error("expected ranges beyond the limit to be rejected")
+	}
+	if _, err := b.queryMetrics(context.Background(), json.RawMessage(`{}`)); err == nil {
+		t.Error("expected a missing query to be rejected")
+	}
+}
+
+func TestToolsFollowBackends(t *testing.T) {
+	b := &Backends{PrometheusURL: "http://prometheus:9090"}
+	if tools := b.Tools(); len(tools) != 1 || tools[0].Name != "query_metrics" {
+		t.Errorf("unexpected tools %v", tools)
+	}
+	b.LokiURL = "http://loki:3100"
+	b.Status = func(ctx context.Context) (map[string]error, error) { return nil, nil }
+	var names []string
+	for _, tool := range b.Tools() {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "query_metrics,search_logs,lookup_identifier,get_status" {
+		t.Errorf("unexpected tools %s", got)
+	}
+}
+
+func mustJSON(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
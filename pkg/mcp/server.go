@@ -0,0 +1,226 @@
+// Package mcp serves APM's observability queries as tools over the Model
+// Context Protocol, so AI assistants can answer questions such as "why is
+// checkout slow" from metrics, traces, logs, and stack status. Access is
+// read-only and bounded: tools only issue query requests, time ranges and
+// result sizes are capped, and every tool call is written to an audit log.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+)
+
+// ProtocolVersion is the MCP revision the server implements
+const ProtocolVersion = "2024-11-05"
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Tool is a callable tool. Handlers return text for the assistant; errors
+// are reported to the assistant as tool errors rather than protocol errors.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+
+	Handler func(ctx context.Context, args json.RawMessage) (string, error) `json:"-"`
+}
+
+// Server answers MCP requests
+type Server struct {
+	Name    string
+	Version string
+	Tools   []Tool
+
+	// Actor identifies the caller in the audit log, e.g. the local user
+	Actor   string
+	Auditor Auditor
+
+	// CallTimeout bounds each tool call; zero means one minute
+	CallTimeout time.Duration
+
+	now func() time.Time
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Serve reads newline-delimited JSON-RPC messages from r and writes
+// responses to w until r is exhausted or ctx is done (the MCP stdio transport)
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(resp *response) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(resp)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := append([]byte(nil), scanner.Bytes()...)
+		if len(line) == 0 {
+			continue
+		}
+
+		// Tool calls may be slow, so requests are answered concurrently
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := s.handle(ctx, line); resp != nil {
+				write(resp)
+			}
+		}()
+	}
+	return scanner.Err()
+}
+
+// handle answers one JSON-RPC message; notifications return nil
+func (s *Server) handle(ctx context.Context, message []byte) *response {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: "invalid JSON"}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return s.fail(req.ID, codeInvalidRequest, "invalid JSON-RPC request")
+	}
+	// Notifications such as notifications/initialized have no ID and no reply
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	switch req.Method {
+	case "initialize":
+		return s.reply(req.ID, map[string]interface{}{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": s.Name, "version": s.Version},
+			"instructions": "Read-only access to APM metrics (PromQL), traces, logs (LogQL), " +
+				"business identifier lookups, and stack status. Time ranges and result sizes are capped.",
+		})
+	case "ping":
+		return s.reply(req.ID, map[string]interface{}{})
+	case "tools/list":
+		return s.reply(req.ID, map[string]interface{}{"tools": s.Tools})
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.fail(req.ID, codeInvalidParams, "invalid tools/call params")
+		}
+		tool, ok := s.tool(params.Name)
+		if !ok {
+			return s.fail(req.ID, codeInvalidParams, fmt.Sprintf("unknown tool %q", params.Name))
+		}
+		return s.reply(req.ID, s.call(ctx, tool, params.Arguments))
+	default:
+		return s.fail(req.ID, codeMethodNotFound, fmt.Sprintf("method %q not found", req.Method))
+	}
+}
+
+// call runs a tool and audits the call
+func (s *Server) call(ctx context.Context, tool Tool, args json.RawMessage) map[string]interface{} {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	timeout := s.CallTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := s.clock()
+	text, err := tool.Handler(ctx, args)
+
+	event := AuditEvent{
+		Timestamp:  started,
+		EventType:  EventToolCall,
+		Actor:      s.Actor,
+		Tool:       tool.Name,
+		Arguments:  string(args),
+		Outcome:    OutcomeSucceeded,
+		DurationMS: s.clock().Sub(started).Milliseconds(),
+	}
+	if err != nil {
+		event.Outcome, event.Error = OutcomeFailed, err.Error()
+		text = err.Error()
+	}
+	s.auditor().Record(event)
+
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": err != nil,
+	}
+}
+
+func (s *Server) tool(name string) (Tool, bool) {
+	for _, t := range s.Tools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Tool{}, false
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{}) *response {
+	return &response{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+func (s *Server) fail(id json.RawMessage, code int, message string) *response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
+
+func (s *Server) auditor() Auditor {
+	if s.Auditor == nil {
+		return auditlog.Discard[AuditEvent]{}
+	}
+	return s.Auditor
+}
+
+func (s *Server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now().UTC()
+}
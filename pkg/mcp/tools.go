@@ -0,0 +1,538 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/lookup"
+)
+
+// Default governance limits
+const (
+	DefaultMaxRange   = 24 * time.Hour
+	DefaultMaxResults = 50
+)
+
+// maxResponseBytes bounds the text returned to the assistant per call
+const maxResponseBytes = 64 * 1024
+
+// Backends are the observability backends the tools query
+type Backends struct {
+	PrometheusURL string
+	JaegerURL     string
+	LokiURL       string
+
+	// Status reports component health for the get_status tool
+	Status func(ctx context.Context) (map[string]error, error)
+
+	Client *http.Client
+
+	// MaxRange caps the time range of a query; zero means DefaultMaxRange
+	MaxRange time.Duration
+	// MaxResults caps series, traces, and log lines; zero means DefaultMaxResults
+	MaxResults int
+
+	now func() time.Time
+}
+
+// Tools returns the tools for the configured backends
+func (b *Backends) Tools() []Tool {
+	var tools []Tool
+	if b.PrometheusURL != "" {
+		tools = append(tools, Tool{
+			Name:        "query_metrics",
+			Description: "Evaluate a PromQL query against Prometheus, as an instant query or over a time range, e.g. histogram_quantile(0.99, sum by (le, route) (rate(http_request_duration_seconds_bucket{service=\"checkout\"}[5m])))",
+			InputSchema: schema(map[string]interface{}{
+				"query": prop("string", "PromQL expression"),
+				"range": prop("string", "Look-back range such as 30m or 6h; omit for an instant query"),
+				"step":  prop("string", "Resolution step of a range query such as 1m; defaults to range/60"),
+			}, "query"),
+			Handler: b.queryMetrics,
+		})
+	}
+	if b.JaegerURL != "" {
+		tools = append(tools, Tool{
+			Name:        "search_traces",
+			Description: "Search Jaeger for traces of a service, optionally by operation, minimum duration, span tags, or errors. Returns one summary line per trace with its slowest spans.",
+			InputSchema: schema(map[string]interface{}{
+				"service":      prop("string", "Service name"),
+				"operation":    prop("string", "Operation (span) name"),
+				"min_duration": prop("string", "Minimum trace duration such as 500ms"),
+				"tags":         map[string]interface{}{"type": "object", "description": "Span tags to match, e.g. {\"http.status_code\": \"500\"}", "additionalProperties": map[string]string{"type": "string"}},
+				"errors_only":  prop("boolean", "Only traces with an error span"),
+				"since":        prop("string", "Look-back range such as 1h; defaults to 1h"),
+				"limit":        prop("integer", "Maximum traces"),
+			}, "service"),
+			Handler: b.searchTraces,
+		})
+	}
+	if b.LokiURL != "" {
+		tools = append(tools, Tool{
+			Name:        "search_logs",
+			Description: "Run a LogQL log query against Loki, e.g. {service=\"checkout\"} |= \"timeout\". Returns matching lines, newest first.",
+			InputSchema: schema(map[string]interface{}{
+				"query": prop("string", "LogQL log query starting with a stream selector"),
+				"since": prop("string", "Look-back range such as 1h; defaults to 1h"),
+				"limit": prop("integer", "Maximum lines"),
+			}, "query"),
+			Handler: b.searchLogs,
+		})
+	}
+	if b.JaegerURL != "" || b.LokiURL != "" {
+		tools = append(tools, Tool{
+			Name:        "lookup_identifier",
+			Description: "Find the traces and logs of a business identifier recorded as a span attribute, such as order.id or user.id, as a single timeline.",
+			InputSchema: schema(map[string]interface{}{
+				"attribute": prop("string", "Span attribute, e.g. order.id"),
+				"value":     prop("string", "Attribute value"),
+				"since":     prop("string", "Look-back range such as 6h; defaults to 24h"),
+			}, "attribute", "value"),
+			Handler: b.lookupIdentifier,
+		})
+	}
+	if b.Status != nil {
+		tools = append(tools, Tool{
+			Name:        "get_status",
+			Description: "Report the health of the monitoring stack components.",
+			InputSchema: schema(map[string]interface{}{}),
+			Handler:     b.getStatus,
+		})
+	}
+	return tools
+}
+
+func (b *Backends) queryMetrics(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Range string `json:"range"`
+		Step  string `json:"step"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil || args.Query == "" {
+		return "", fmt.Errorf("query is required")
+	}
+
+	params := url.Values{"query": {args.Query}}
+	path := "/api/v1/query"
+	now := b.clock()
+	if args.Range != "" {
+		window, err := b.window(args.Range, time.Hour)
+		if err != nil {
+			return "", err
+		}
+		step := window / 60
+		if args.Step != "" {
+			if step, err = time.ParseDuration(args.Step); err != nil {
+				return "", fmt.Errorf("invalid step %q", args.Step)
+			}
+		}
+		if step < 15*time.Second {
+			step = 15 * time.Second
+		}
+		path = "/api/v1/query_range"
+		params.Set("start", strconv.FormatInt(now.Add(-window).Unix(), 10))
+		params.Set("end", strconv.FormatInt(now.Unix(), 10))
+		params.Set("step", strconv.Itoa(int(step.Seconds())))
+	} else {
+		params.Set("time", strconv.FormatInt(now.Unix(), 10))
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Metric map[string]string `json:"metric"`
+				Value  []interface{}     `json:"value"`
+				Values [][]interface{}   `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := b.get(ctx, b.PrometheusURL+path+"?"+params.Encode(), &resp); err != nil {
+		return "", err
+	}
+	if resp.Status != "success" {
+		return "", fmt.Errorf("prometheus: %s", resp.Error)
+	}
+
+	var sb strings.Builder
+	results := resp.Data.Result
+	fmt.Fprintf(&sb, "%d %s series", len(results), resp.Data.ResultType)
+	if len(results) > b.maxResults() {
+		fmt.Fprintf(&sb, " (showing %d)", b.maxResults())
+		results = results[:b.maxResults()]
+	}
+	sb.WriteString("\n")
+	for _, r := range results {
+		sb.WriteString(formatLabels(r.Metric))
+		if r.Value != nil {
+			fmt.Fprintf(&sb, " = %v\n", r.Value[1])
+			continue
+		}
+		// Summarize ranges as first, last, min, and max so the answer fits
+		var first, last, lo, hi float64
+		for i, v := range r.Values {
+			f, _ := strconv.ParseFloat(fmt.Sprint(v[1]), 64)
+			if i == 0 {
+				first, lo, hi = f, f, f
+			}
+			last = f
+			if f < lo {
+				lo = f
+			}
+			if f > hi {
+				hi = f
+			}
+		}
+		fmt.Fprintf(&sb, " first=%g last=%g min=%g max=%g points=%d\n", first, last, lo, hi, len(r.Values))
+	}
+	return truncate(sb.String()), nil
+}
+
+func (b *Backends) searchTraces(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Service     string            `json:"service"`
+		Operation   string            `json:"operation"`
+		MinDuration string            `json:"min_duration"`
+		Tags        map[string]string `json:"tags"`
+		ErrorsOnly  bool              `json:"errors_only"`
+		Since       string            `json:"since"`
+		Limit       int               `json:"limit"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil || args.Service == "" {
+		return "", fmt.Errorf("service is required")
+	}
+	window, err := b.window(args.Since, time.Hour)
+	if err != nil {
+		return "", err
+	}
+
+	tags := args.Tags
+	if args.ErrorsOnly {
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags["error"] = "true"
+	}
+
+	now := b.clock()
+	params := url.Values{}
+	params.Set("service", args.Service)
+	params.Set("start", strconv.FormatInt(now.Add(-window).UnixMicro(), 10))
+	params.Set("end", strconv.FormatInt(now.UnixMicro(), 10))
+	params.Set("limit", strconv.Itoa(b.limit(args.Limit)))
+	if args.Operation != "" {
+		params.Set("operation", args.Operation)
+	}
+	if args.MinDuration != "" {
+		if _, err := time.ParseDuration(args.MinDuration); err != nil {
+			return "", fmt.Errorf("invalid min_duration %q", args.MinDuration)
+		}
+		params.Set("minDuration", args.MinDuration)
+	}
+	if len(tags) > 0 {
+		encoded, _ := json.Marshal(tags)
+		params.Set("tags", string(encoded))
+	}
+
+	var resp struct {
+		Data []struct {
+			TraceID string `json:"traceID"`
+			Spans   []struct {
+				SpanID        string `json:"spanID"`
+				OperationName string `json:"operationName"`
+				StartTime     int64  `json:"startTime"`
+				Duration      int64  `json:"duration"`
+				ProcessID     string `json:"processID"`
+				Tags          []struct {
+					Key   string      `json:"key"`
+					Value interface{} `json:"value"`
+				} `json:"tags"`
+			} `json:"spans"`
+			Processes map[string]struct {
+				ServiceName string `json:"serviceName"`
+			} `json:"processes"`
+		} `json:"data"`
+	}
+	if err := b.get(ctx, b.JaegerURL+"/api/traces?"+params.Encode(), &resp); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d trace(s) for %s in the last %s\n", len(resp.Data), args.Service, window)
+	for _, t := range resp.Data {
+		if len(t.Spans) == 0 {
+			continue
+		}
+		spans := t.Spans
+		start, end := spans[0].StartTime, spans[0].StartTime+spans[0].Duration
+		errors := 0
+		for _, s := range spans {
+			if s.StartTime < start {
+				start = s.StartTime
+			}
+			if s.StartTime+s.Duration > end {
+				end = s.StartTime + s.Duration
+			}
+			for _, tag := range s.Tags {
+				if tag.Key == "error" && fmt.Sprint(tag.Value) == "true" {
+					errors++
+				}
+			}
+		}
+		sort.Slice(spans, func(i, j int) bool { return spans[i].Duration > spans[j].Duration })
+
+		fmt.Fprintf(&sb, "\ntrace %s %s spans=%d errors=%d\n", t.TraceID, time.Duration(end-start)*time.Microsecond, len(spans), errors)
+		for i, s := range spans {
+			if i == 3 {
+				break
+			}
+			fmt.Fprintf(&sb, "  %s %s %s\n", t.Processes[s.ProcessID].ServiceName, s.OperationName, time.Duration(s.Duration)*time.Microsecond)
+		}
+	}
+	return truncate(sb.String()), nil
+}
+
+func (b *Backends) searchLogs(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Since string `json:"since"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil || !strings.HasPrefix(strings.TrimSpace(args.Query), "{") {
+		return "", fmt.Errorf("query must be a LogQL log query starting with a stream selector")
+	}
+	window, err := b.window(args.Since, time.Hour)
+	if err != nil {
+		return "", err
+	}
+
+	now := b.clock()
+	params := url.Values{}
+	params.Set("query", args.Query)
+	params.Set("start", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(now.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(b.limit(args.Limit)))
+	params.Set("direction", "backward")
+
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := b.get(ctx, b.LokiURL+"/loki/api/v1/query_range?"+params.Encode(), &resp); err != nil {
+		return "", err
+	}
+	if resp.Data.ResultType != "streams" {
+		return "", fmt.Errorf("only log queries are supported; use query_metrics for metrics")
+	}
+
+	type line struct {
+		ts     int64
+		stream string
+		text   string
+	}
+	var lines []line
+	for _, r := range resp.Data.Result {
+		stream := formatLabels(r.Stream)
+		for _, v := range r.Values {
+			ts, _ := strconv.ParseInt(v[0], 10, 64)
+			lines = append(lines, line{ts: ts, stream: stream, text: v[1]})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].ts > lines[j].ts })
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d line(s) in the last %s\n", len(lines), window)
+	for _, l := range lines {
+		fmt.Fprintf(&sb, "%s %s %s\n", time.Unix(0, l.ts).UTC().Format(time.RFC3339Nano), l.stream, l.text)
+	}
+	return truncate(sb.String()), nil
+}
+
+func (b *Backends) lookupIdentifier(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Attribute string `json:"attribute"`
+		Value     string `json:"value"`
+		Since     string `json:"since"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", fmt.Errorf("attribute and value are required")
+	}
+	window, err := b.window(args.Since, 24*time.Hour)
+	if err != nil {
+		return "", err
+	}
+
+	service := &lookup.Service{}
+	if b.JaegerURL != "" {
+		service.Traces = append(service.Traces, &lookup.Jaeger{URL: b.JaegerURL, Client: b.Client})
+	}
+	if b.LokiURL != "" {
+		service.Logs = append(service.Logs, &lookup.Loki{URL: b.LokiURL, Client: b.Client})
+	}
+
+	now := b.clock()
+	query := lookup.Query{Attribute: args.Attribute, Value: args.Value, Start: now.Add(-window), End: now, Limit: b.maxResults()}
+	if err := query.Validate(); err != nil {
+		return "", err
+	}
+	result, err := service.Lookup(ctx, query)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d trace(s), %d event(s) for %s=%s\n", len(result.TraceIDs), len(result.Events), args.Attribute, args.Value)
+	for _, e := range result.Errors {
+		fmt.Fprintf(&sb, "warning: %s\n", e)
+	}
+	for _, e := range result.Events {
+		fmt.Fprintf(&sb, "%s %s %s %s", e.Time.UTC().Format(time.RFC3339Nano), e.Source, e.Service, e.Summary)
+		if e.Duration > 0 {
+			fmt.Fprintf(&sb, " (%s)", e.Duration)
+		}
+		if e.Error {
+			sb.WriteString(" ERROR")
+		}
+		if e.TraceID != "" {
+			sb.WriteString(" trace=" + e.TraceID)
+		}
+		sb.WriteString("\n")
+	}
+	return truncate(sb.String()), nil
+}
+
+func (b *Backends) getStatus(ctx context.Context, _ json.RawMessage) (string, error) {
+	results, err := b.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		if results[name] == nil {
+			fmt.Fprintf(&sb, "%s: healthy\n", name)
+		} else {
+			fmt.Fprintf(&sb, "%s: unhealthy (%v)\n", name, results[name])
+		}
+	}
+	return sb.String(), nil
+}
+
+// window parses a look-back range, capped at MaxRange
+func (b *Backends) window(since string, fallback time.Duration) (time.Duration, error) {
+	window := fallback
+	if since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid range %q", since)
+		}
+		window = d
+	}
+	max := b.MaxRange
+	if max <= 0 {
+		max = DefaultMaxRange
+	}
+	if window > max {
+		return 0, fmt.Errorf("range %s exceeds the %s limit", window, max)
+	}
+	return window, nil
+}
+
+func (b *Backends) limit(requested int) int {
+	if requested <= 0 || requested > b.maxResults() {
+		return b.maxResults()
+	}
+	return requested
+}
+
+func (b *Backends) maxResults() int {
+	if b.MaxResults <= 0 {
+		return DefaultMaxResults
+	}
+	return b.MaxResults
+}
+
+// get issues a read-only query request and decodes the JSON response
+func (b *Backends) get(ctx context.Context, rawURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	client := b.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16*1024*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (b *Backends) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func truncate(s string) string {
+	if len(s) <= maxResponseBytes {
+		return s
+	}
+	return s[:maxResponseBytes] + "\n… truncated"
+}
+
+func schema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func prop(typ, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
+}
@@ -0,0 +1,770 @@
+package migrate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	instrumentationModule = "github.com/chaksack/apm"
+	instrumentationPath   = "github.com/chaksack/apm/pkg/instrumentation"
+
+	pathOtelFiber  = "github.com/gofiber/contrib/otelfiber"
+	pathFiber      = "github.com/gofiber/fiber/v2"
+	pathSDKTrace   = "go.opentelemetry.io/otel/sdk/trace"
+	pathOTLPGRPC   = "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	pathOTLPHTTP   = "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	pathJaeger     = "go.opentelemetry.io/otel/exporters/jaeger"
+	pathAttribute  = "go.opentelemetry.io/otel/attribute"
+	pathOtel       = "go.opentelemetry.io/otel"
+	pathTrace      = "go.opentelemetry.io/otel/trace"
+	pathPrometheus = "github.com/prometheus/client_golang/prometheus"
+	pathPromauto   = "github.com/prometheus/client_golang/prometheus/promauto"
+	pathPromhttp   = "github.com/prometheus/client_golang/prometheus/promhttp"
+	pathZap        = "go.uber.org/zap"
+	pathZapcore    = "go.uber.org/zap/zapcore"
+	prefixSemconv  = "go.opentelemetry.io/otel/semconv/"
+)
+
+// unsupported are imports pkg/instrumentation has no counterpart for
+var unsupported = map[string]string{
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp":                "net/http servers and clients are not instrumented; keep otelhttp next to the Fiber middleware",
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin": "Gin is not supported; only Fiber apps get middleware",
+	"go.opentelemetry.io/otel/sdk/metric":                                          "the OpenTelemetry metrics SDK is not set up; pkg/instrumentation exposes Prometheus metrics",
+	"github.com/gin-gonic/gin":                                                     "Gin is not supported; only Fiber apps get middleware",
+	"github.com/labstack/echo/v4":                                                  "Echo is not supported; only Fiber apps get middleware",
+	"github.com/go-chi/chi/v5":                                                     "chi is not supported; only Fiber apps get middleware",
+}
+
+// httpMetrics are the metrics inst.FiberMiddleware() records, with the
+// labels it records them with
+var httpMetrics = map[string][]string{
+	"http_requests_total":           {"method", "path", "status"},
+	"http_request_duration_seconds": {"method", "path", "status"},
+	"http_request_size_bytes":       {"method", "path"},
+	"http_response_size_bytes":      {"method", "path"},
+}
+
+// requestLogFields are the fields inst.FiberMiddleware() logs each request
+// with
+var requestLogFields = map[string]bool{
+	"method": true, "path": true, "status": true, "duration": true, "latency": true,
+	"ip": true, "user_agent": true, "error": true,
+}
+
+// file is a parsed source file
+type file struct {
+	name    string // relative to the scanned root
+	src     []byte
+	ast     *ast.File
+	imports map[string]string // local name to import path
+}
+
+// value is an expression copied from the existing wiring into the
+// instrumentation setup, with the imports it needs
+type value struct {
+	text    string
+	imports map[string]string
+}
+
+// httpMetric is a hand-rolled HTTP metric and where it is created
+type httpMetric struct {
+	name   string
+	file   string
+	line   int
+	detail string
+}
+
+// middleware is a hand-rolled Fiber middleware
+type middleware struct {
+	kind string // "metrics" or "logging"
+	file *file
+	decl *ast.FuncDecl
+}
+
+// pkg is one package being migrated
+type pkg struct {
+	root  string
+	dir   string
+	fset  *token.FileSet
+	files []*file
+	// decls are the package-level names
+	decls map[string]bool
+
+	findings []Finding
+	options  []string
+	changes  []Change
+
+	service, version, environment *value
+	tracing                       *value // the option that sets up tracing
+	sampleRate                    *value
+	// metricVars are the package-level variables holding HTTP metrics
+	metricVars map[string]*httpMetric
+	loggerVars []string
+	tracerVars []string
+	middleware map[string]*middleware
+	// wiring is set when the package sets up telemetry by hand
+	wiring bool
+}
+
+func loadPackage(root string, paths []string) (*pkg, error) {
+	sort.Strings(paths)
+	p := &pkg{
+		root:       root,
+		dir:        filepath.Dir(paths[0]),
+		fset:       token.NewFileSet(),
+		decls:      make(map[string]bool),
+		metricVars: make(map[string]*httpMetric),
+		middleware: make(map[string]*middleware),
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(p.fset, path, src, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		name, _ := filepath.Rel(root, path)
+		p.files = append(p.files, &file{name: name, src: src, ast: f, imports: importNames(f)})
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					p.decls[d.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.ValueSpec:
+						for _, n := range s.Names {
+							p.decls[n.Name] = true
+						}
+					case *ast.TypeSpec:
+						p.decls[s.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// importNames maps the local names of the imports of f to their paths
+func importNames(f *ast.File) map[string]string {
+	names := make(map[string]string)
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := defaultImportName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		names[name] = path
+	}
+	return names
+}
+
+// defaultImportName guesses the package name of an import path: its last
+// element that is not a version such as v2 or v1.21.0
+func defaultImportName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789.") == "" {
+		name = elems[len(elems)-2]
+	}
+	return strings.TrimPrefix(name, "go-")
+}
+
+func (p *pkg) add(f *file, pos token.Pos, kind string, action Action, format string, args ...any) {
+	p.findings = append(p.findings, Finding{
+		File:    f.name,
+		Line:    p.fset.Position(pos).Line,
+		Kind:    kind,
+		Action:  action,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *pkg) text(f *file, n ast.Node) string {
+	return string(f.src[p.fset.Position(n.Pos()).Offset:p.fset.Position(n.End()).Offset])
+}
+
+// selector returns the import path and name of a reference such as
+// otelfiber.Middleware
+func (f *file) selector(e ast.Expr) (string, string, bool) {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return "", "", false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || x.Obj != nil {
+		return "", "", false
+	}
+	path, ok := f.imports[x.Name]
+	return path, sel.Sel.Name, ok
+}
+
+// analyze finds the wiring of the package
+func (p *pkg) analyze() {
+	for _, f := range p.files {
+		for _, spec := range f.ast.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			if reason, ok := unsupported[path]; ok {
+				p.add(f, spec.Pos(), "framework", ActionGap, "%s: %s", path, reason)
+			}
+		}
+		p.findMetrics(f)
+	}
+	// Middleware is told apart by the metrics it records, so metrics come
+	// first
+	for _, f := range p.files {
+		p.findMiddleware(f)
+	}
+	for _, f := range p.files {
+		for _, decl := range f.ast.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+				p.findCalls(f, fn)
+			}
+		}
+	}
+	p.findGlobals()
+}
+
+// findMetrics finds Prometheus collectors created with promauto or
+// prometheus constructors
+func (p *pkg) findMetrics(f *file) {
+	// Remember which variable each collector is assigned to
+	vars := make(map[*ast.CallExpr]string)
+	ast.Inspect(f.ast, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for i, v := range n.Values {
+				if call, ok := v.(*ast.CallExpr); ok && i < len(n.Names) {
+					vars[call] = n.Names[i].Name
+				}
+			}
+		case *ast.AssignStmt:
+			for i, v := range n.Rhs {
+				if call, ok := v.(*ast.CallExpr); ok && i < len(n.Lhs) {
+					if id, ok := n.Lhs[i].(*ast.Ident); ok {
+						vars[call] = id.Name
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	ast.Inspect(f.ast, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		path, name, ok := f.selector(call.Fun)
+		if !ok || (path != pathPromauto && path != pathPrometheus) {
+			return true
+		}
+		if path == pathPrometheus && name == "NewRegistry" {
+			p.add(f, call.Pos(), "registry", ActionGap,
+				"custom registry: inst.MetricsHandler() serves the default registry, so register these collectors with prometheus.DefaultRegisterer or serve this registry yourself")
+			return true
+		}
+		if !strings.HasPrefix(name, "New") || len(call.Args) == 0 || !isCollector(strings.TrimPrefix(name, "New")) {
+			return true
+		}
+		metric := metricName(call.Args[0])
+		if metric == "" {
+			return true
+		}
+		labels := metricLabels(call)
+
+		want, isHTTP := httpMetrics[metric]
+		if !isHTTP {
+			p.add(f, call.Pos(), "custom-metric", ActionKeep,
+				"%s keeps working: inst.MetricsHandler() serves the default registry it is registered with", metric)
+			return true
+		}
+		p.wiring = true
+		detail := fmt.Sprintf("inst.FiberMiddleware() records it with labels %s", strings.Join(want, ", "))
+		if renamed := diffLabels(labels, want); renamed != "" {
+			detail += "; update dashboards and alerts that use " + renamed
+		}
+		if v := vars[call]; v != "" && p.decls[v] {
+			pos := p.fset.Position(call.Pos())
+			p.metricVars[v] = &httpMetric{name: metric, file: f.name, line: pos.Line, detail: detail}
+		}
+		msg := fmt.Sprintf("remove %s: %s", metric, detail)
+		if path == pathPromauto {
+			msg += ". Registering it twice with different labels panics, so it must go before instrumentation.New runs"
+		}
+		p.add(f, call.Pos(), "http-metric", ActionManual, "%s", msg)
+		return true
+	})
+}
+
+func isCollector(kind string) bool {
+	switch strings.TrimSuffix(kind, "Vec") {
+	case "Counter", "Gauge", "Histogram", "Summary":
+		return true
+	}
+	return false
+}
+
+// metricName returns the Name of the options literal of a collector
+func metricName(opts ast.Expr) string {
+	lit, ok := opts.(*ast.CompositeLit)
+	if !ok {
+		return ""
+	}
+	var namespace, subsystem, name string
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		key, _ := kv.Key.(*ast.Ident)
+		val, _ := kv.Value.(*ast.BasicLit)
+		if key == nil || val == nil || val.Kind != token.STRING {
+			continue
+		}
+		s, _ := strconv.Unquote(val.Value)
+		switch key.Name {
+		case "Namespace":
+			namespace = s
+		case "Subsystem":
+			subsystem = s
+		case "Name":
+			name = s
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	// Namespaced HTTP metrics are custom metrics as far as the middleware
+	// is concerned
+	for _, prefix := range []string{namespace, subsystem} {
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+	}
+	return name
+}
+
+// metricLabels returns the label names of a Vec collector
+func metricLabels(call *ast.CallExpr) []string {
+	if len(call.Args) < 2 {
+		return nil
+	}
+	lit, ok := call.Args[1].(*ast.CompositeLit)
+	if !ok {
+		return nil
+	}
+	var labels []string
+	for _, elt := range lit.Elts {
+		if s, ok := stringLit(elt); ok {
+			labels = append(labels, s)
+		}
+	}
+	return labels
+}
+
+// diffLabels describes the labels of have that want does not have
+func diffLabels(have, want []string) string {
+	var missing []string
+	for _, l := range have {
+		found := false
+		for _, w := range want {
+			found = found || l == w
+		}
+		if !found {
+			missing = append(missing, l)
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "the " + strings.Join(missing, ", ") + " label" + plural(len(missing))
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func stringLit(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// findMiddleware finds Fiber middleware that records the HTTP metrics or
+// logs requests by hand
+func (p *pkg) findMiddleware(f *file) {
+	for _, decl := range f.ast.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil || !p.isFiberHandler(f, fn) {
+			continue
+		}
+		var next, metrics, logs bool
+		var fields []string
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+				switch sel.Sel.Name {
+				case "Next":
+					next = next || len(call.Args) == 0
+				case "WithLabelValues", "With":
+					if id, ok := sel.X.(*ast.Ident); ok && p.metricVars[id.Name] != nil {
+						metrics = true
+					}
+				}
+			}
+			if path, _, ok := f.selector(call.Fun); ok && path == pathZap {
+				logs = true
+				if len(call.Args) > 0 {
+					if key, ok := stringLit(call.Args[0]); ok && !requestLogFields[key] {
+						fields = append(fields, key)
+					}
+				}
+			}
+			return true
+		})
+		switch {
+		case !next:
+			continue
+		case metrics:
+			p.middleware[fn.Name.Name] = &middleware{kind: "metrics", file: f, decl: fn}
+			p.wiring = true
+			p.add(f, fn.Pos(), "metrics-middleware", ActionRewrite,
+				"%s records the HTTP metrics by hand; its uses become inst.FiberMiddleware() and it is removed once unused", fn.Name.Name)
+		case logs:
+			p.middleware[fn.Name.Name] = &middleware{kind: "logging", file: f, decl: fn}
+			p.wiring = true
+			p.add(f, fn.Pos(), "logging-middleware", ActionRewrite,
+				"%s logs requests by hand; inst.FiberMiddleware() logs each request, and %s is removed once unused", fn.Name.Name, fn.Name.Name)
+			if len(fields) > 0 {
+				p.add(f, fn.Pos(), "log-fields", ActionGap,
+					"request logs lose the %s field%s; log them in a middleware of your own with instrumentation.GetLogger(c)", strings.Join(fields, ", "), plural(len(fields)))
+			}
+		}
+	}
+}
+
+// isFiberHandler reports whether fn is a fiber.Handler or returns one
+func (p *pkg) isFiberHandler(f *file, fn *ast.FuncDecl) bool {
+	isFiber := func(e ast.Expr, name string) bool {
+		if star, ok := e.(*ast.StarExpr); ok {
+			e = star.X
+		}
+		path, sel, ok := f.selector(e)
+		return ok && path == pathFiber && sel == name
+	}
+	params, results := fn.Type.Params.List, fn.Type.Results
+	if results == nil || len(results.List) != 1 {
+		return false
+	}
+	if isFiber(results.List[0].Type, "Handler") {
+		return true
+	}
+	return len(params) == 1 && isFiber(params[0].Type, "Ctx")
+}
+
+// findCalls finds the tracing, logging, and endpoint wiring in a function
+func (p *pkg) findCalls(f *file, fn *ast.FuncDecl) {
+	insecure := false
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if path, name, ok := f.selector(call.Fun); ok && path == pathOTLPGRPC && name == "WithInsecure" {
+				insecure = true
+			}
+		}
+		return true
+	})
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		// Key.String(v) attributes such as semconv.ServiceNameKey.String
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "String" && len(call.Args) == 1 {
+			if path, key, ok := f.selector(sel.X); ok && strings.HasPrefix(path, prefixSemconv) {
+				p.resourceAttribute(f, fn, strings.TrimSuffix(key, "Key"), call.Args[0])
+			}
+		}
+
+		path, name, ok := f.selector(call.Fun)
+		if !ok {
+			return true
+		}
+		switch {
+		case path == pathOtelFiber && name == "Middleware":
+			p.wiring = true
+			if len(call.Args) == 0 {
+				p.add(f, call.Pos(), "otelfiber", ActionRewrite, "otelfiber.Middleware() becomes instrumentation.FiberOtelMiddleware")
+			} else {
+				p.add(f, call.Pos(), "otelfiber", ActionManual,
+					"otelfiber options %s have no direct equivalent: replace the call with instrumentation.FiberOtelMiddleware by hand and review what the options did",
+					p.argsText(f, call))
+			}
+
+		case path == pathSDKTrace && name == "NewTracerProvider":
+			p.wiring = true
+			p.add(f, call.Pos(), "tracer-provider", ActionManual,
+				"remove %s and its call: instrumentation.New sets up and shuts down the tracer provider", fn.Name.Name)
+			p.tracerOptions(f, call)
+
+		case path == pathOTLPGRPC && name == "WithEndpoint" && len(call.Args) == 1:
+			if v, ok := p.capture(f, fn, call.Args[0]); ok {
+				if insecure {
+					v.text = "instrumentation.WithOTLP(" + v.text + ")"
+				} else {
+					v.text = "instrumentation.WithTracing(instrumentation.TracerConfig{ExporterType: \"otlp\", Endpoint: " + v.text + ", TLS: true})"
+				}
+				p.tracing = &v
+			}
+		case path == pathOTLPHTTP && name == "WithEndpoint" && len(call.Args) == 1:
+			if v, ok := p.capture(f, fn, call.Args[0]); ok {
+				v.text = "instrumentation.WithTracing(instrumentation.TracerConfig{ExporterType: \"otlp\", Protocol: \"http/protobuf\", Endpoint: " + v.text + "})"
+				p.tracing = &v
+			}
+		case path == pathJaeger && name == "WithEndpoint" && len(call.Args) == 1:
+			if v, ok := p.capture(f, fn, call.Args[0]); ok {
+				v.text = "instrumentation.WithJaeger(" + v.text + ")"
+				p.tracing = &v
+			}
+		case (path == pathOTLPGRPC || path == pathOTLPHTTP) && name == "WithHeaders":
+			p.add(f, call.Pos(), "exporter-headers", ActionManual, "set the exporter headers in TracerConfig.Headers or OTEL_EXPORTER_OTLP_HEADERS")
+
+		case strings.HasPrefix(path, prefixSemconv) && len(call.Args) == 1:
+			p.resourceAttribute(f, fn, name, call.Args[0])
+		case path == pathAttribute && name == "String" && len(call.Args) == 2:
+			if key, ok := stringLit(call.Args[0]); ok {
+				switch key {
+				case "service.name":
+					p.resourceAttribute(f, fn, "ServiceName", call.Args[1])
+				case "service.version":
+					p.resourceAttribute(f, fn, "ServiceVersion", call.Args[1])
+				case "environment", "deployment.environment":
+					p.resourceAttribute(f, fn, "DeploymentEnvironment", call.Args[1])
+				}
+			}
+
+		case path == pathZap && (name == "NewProduction" || name == "NewDevelopment" || name == "NewProductionConfig" || name == "NewDevelopmentConfig" || name == "NewExample"):
+			p.wiring = true
+			p.add(f, call.Pos(), "zap-logger", ActionManual,
+				"remove the logger built in %s: use inst.Logger, configured with LOG_LEVEL, LOG_ENCODING, and LOG_OUTPUT_PATHS or instrumentation.WithLogging", fn.Name.Name)
+		case path == pathZap && name == "New", path == pathZapcore && name == "NewCore":
+			p.add(f, call.Pos(), "zap-core", ActionGap,
+				"custom zap cores are not configurable; wrap inst.Logger with zap.WrapCore to keep this one")
+
+		case path == pathPromhttp && name == "Handler":
+			if fn.Name.Name == "main" && fn.Recv == nil {
+				p.add(f, call.Pos(), "metrics-endpoint", ActionRewrite, "the promhttp metrics endpoint serves inst's metrics instead")
+			} else {
+				p.add(f, call.Pos(), "metrics-endpoint", ActionManual, "serve inst.MetricsHandler() or inst.FiberMetricsHandler() instead of promhttp.Handler()")
+			}
+		case path == pathPromhttp && name == "HandlerFor":
+			p.add(f, call.Pos(), "metrics-endpoint", ActionManual, "serve inst.MetricsHandler(), which applies the configured relabeling, instead of promhttp.HandlerFor")
+		}
+		return true
+	})
+}
+
+// tracerOptions maps the options of a tracer provider to instrumentation
+// options, reporting the ones without an equivalent
+func (p *pkg) tracerOptions(f *file, call *ast.CallExpr) {
+	for _, arg := range call.Args {
+		opt, ok := arg.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		path, name, ok := f.selector(opt.Fun)
+		if !ok || path != pathSDKTrace {
+			continue
+		}
+		switch name {
+		case "WithSampler":
+			if len(opt.Args) == 1 {
+				p.sampler(f, opt.Args[0])
+			}
+		case "WithSpanProcessor":
+			p.add(f, opt.Pos(), "span-processor", ActionGap,
+				"custom span processors cannot be added to the tracer provider instrumentation.New creates")
+		case "WithIDGenerator":
+			p.add(f, opt.Pos(), "id-generator", ActionManual, "pass the ID generator in TracerConfig.IDGenerator or name it in TRACE_ID_GENERATOR")
+		case "WithSpanLimits", "WithRawSpanLimits":
+			p.add(f, opt.Pos(), "span-limits", ActionGap, "span limits are not configurable; use OTEL_SPAN_*_LIMIT variables")
+		}
+	}
+}
+
+// sampler maps a sampler to a sample rate
+func (p *pkg) sampler(f *file, e ast.Expr) {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		p.add(f, e.Pos(), "sampler", ActionManual, "set the sample rate of %s with instrumentation.WithSampleRate", p.text(f, e))
+		return
+	}
+	path, name, _ := f.selector(call.Fun)
+	if path != pathSDKTrace {
+		p.add(f, e.Pos(), "sampler", ActionGap, "custom sampler %s cannot be configured", p.text(f, e))
+		return
+	}
+	switch name {
+	case "ParentBased":
+		if len(call.Args) > 0 {
+			p.sampler(f, call.Args[0])
+		}
+	case "AlwaysSample":
+		p.sampleRate = &value{text: "1"}
+	case "NeverSample":
+		p.sampleRate = &value{text: "0"}
+	case "TraceIDRatioBased":
+		if len(call.Args) == 1 {
+			if _, isLit := call.Args[0].(*ast.BasicLit); isLit {
+				p.sampleRate = &value{text: p.text(f, call.Args[0])}
+				return
+			}
+		}
+		p.add(f, e.Pos(), "sampler", ActionManual, "set the sample rate of %s with instrumentation.WithSampleRate or OTEL_TRACES_SAMPLER_ARG", p.text(f, e))
+	}
+}
+
+// resourceAttribute records a service name, version, or environment
+func (p *pkg) resourceAttribute(f *file, fn *ast.FuncDecl, name string, arg ast.Expr) {
+	target := map[string]**value{
+		"ServiceName":           &p.service,
+		"ServiceVersion":        &p.version,
+		"DeploymentEnvironment": &p.environment,
+	}[name]
+	if target == nil {
+		return
+	}
+	if v, ok := p.capture(f, fn, arg); ok {
+		*target = &v
+	}
+}
+
+func (p *pkg) argsText(f *file, call *ast.CallExpr) string {
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = p.text(f, arg)
+	}
+	return strings.Join(args, ", ")
+}
+
+// capture copies an expression so it can be used in main. Local variables
+// assigned once are replaced by their value; anything else local makes the
+// expression unusable outside its function.
+func (p *pkg) capture(f *file, fn *ast.FuncDecl, e ast.Expr) (value, bool) {
+	if id, ok := e.(*ast.Ident); ok && !p.decls[id.Name] {
+		if assigned := localValue(fn, id.Name); assigned != nil {
+			e = assigned
+		}
+	}
+	v := value{text: p.text(f, e), imports: make(map[string]string)}
+	usable := true
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok {
+				if path, ok := f.imports[x.Name]; ok && x.Obj == nil {
+					v.imports[x.Name] = path
+					return false
+				}
+			}
+		case *ast.Ident:
+			if !p.decls[n.Name] && !universe[n.Name] {
+				usable = false
+			}
+		}
+		return usable
+	})
+	return v, usable
+}
+
+// universe are the predeclared identifiers expressions may use
+var universe = map[string]bool{
+	"true": true, "false": true, "nil": true, "len": true, "string": true,
+	"int": true, "float64": true, "bool": true,
+}
+
+// localValue returns the value of a variable assigned exactly once in fn
+func localValue(fn *ast.FuncDecl, name string) ast.Expr {
+	var found ast.Expr
+	count := 0
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Name == name {
+					count++
+					if len(n.Lhs) == len(n.Rhs) {
+						found = n.Rhs[i]
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for i, id := range n.Names {
+				if id.Name == name {
+					count++
+					if i < len(n.Values) {
+						found = n.Values[i]
+					}
+				}
+			}
+		}
+		return true
+	})
+	if count != 1 {
+		return nil
+	}
+	return found
+}
+
+// findGlobals finds package-level *zap.Logger and trace.Tracer variables,
+// which the codemod points at inst.Logger and the global tracer provider
+func (p *pkg) findGlobals() {
+	for _, f := range p.files {
+		for _, decl := range f.ast.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if len(vs.Values) > 0 {
+					continue
+				}
+				var names []string
+				for _, n := range vs.Names {
+					names = append(names, n.Name)
+				}
+				if star, ok := vs.Type.(*ast.StarExpr); ok {
+					if path, name, ok := f.selector(star.X); ok && path == pathZap && name == "Logger" {
+						p.loggerVars = append(p.loggerVars, names...)
+					}
+				} else if path, name, ok := f.selector(vs.Type); ok && path == pathTrace && name == "Tracer" {
+					p.tracerVars = append(p.tracerVars, names...)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// edit replaces src[start:end] with text
+type edit struct {
+	start, end int
+	text       string
+}
+
+// fileEdits are the edits of one file and the imports they need
+type fileEdits struct {
+	edits   []edit
+	imports map[string]string
+}
+
+func (e *fileEdits) add(start, end int, text string, imports map[string]string) {
+	e.edits = append(e.edits, edit{start, end, text})
+	for name, path := range imports {
+		e.imports[name] = path
+	}
+}
+
+// rewrite converts the wiring the codemod handles safely: it sets up
+// instrumentation.New in main, replaces otelfiber and the hand-rolled
+// middleware and metrics endpoint, and removes the metrics and middleware
+// left unused
+func (p *pkg) rewrite() error {
+	if !p.wiring {
+		return nil
+	}
+	edits := make(map[*file]*fileEdits)
+	editsOf := func(f *file) *fileEdits {
+		if edits[f] == nil {
+			edits[f] = &fileEdits{imports: make(map[string]string)}
+		}
+		return edits[f]
+	}
+
+	service := p.service
+	if service == nil {
+		abs, _ := filepath.Abs(filepath.Join(p.root, p.dir))
+		service = &value{text: strconv.Quote(filepath.Base(abs))}
+	}
+	p.options = append(p.options, "instrumentation.LoadFromEnv()", "instrumentation.WithService("+service.text+")")
+	for _, opt := range []struct {
+		name string
+		v    *value
+	}{{"WithVersion", p.version}, {"WithEnvironment", p.environment}, {"", p.tracing}, {"WithSampleRate", p.sampleRate}} {
+		switch {
+		case opt.v == nil:
+		case opt.name == "":
+			p.options = append(p.options, opt.v.text)
+		default:
+			p.options = append(p.options, "instrumentation."+opt.name+"("+opt.v.text+")")
+		}
+	}
+	optionImports := map[string]string{"instrumentation": instrumentationPath}
+	for _, v := range []*value{service, p.version, p.environment, p.tracing, p.sampleRate} {
+		if v != nil {
+			for name, path := range v.imports {
+				optionImports[name] = path
+			}
+		}
+	}
+
+	for _, f := range p.files {
+		ast.Inspect(f.ast, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) > 0 {
+				return true
+			}
+			if path, name, ok := f.selector(call.Fun); ok && path == pathOtelFiber && name == "Middleware" {
+				imports := map[string]string{"instrumentation": instrumentationPath}
+				for name, path := range service.imports {
+					imports[name] = path
+				}
+				editsOf(f).add(p.offset(call.Pos()), p.offset(call.End()),
+					"instrumentation.FiberOtelMiddleware("+service.text+")", imports)
+			}
+			return true
+		})
+	}
+
+	f, fn := p.mainFunc()
+	var setup ast.Stmt
+	if fn != nil {
+		setup = p.bootstrap(f, fn, service, optionImports, editsOf(f))
+	}
+	if setup != nil {
+		p.rewriteMain(f, fn, setup.Pos(), editsOf(f))
+	} else {
+		// Without an inst in scope the middleware and endpoint are left for
+		// the developer
+		for i, finding := range p.findings {
+			switch finding.Kind {
+			case "metrics-middleware", "logging-middleware", "metrics-endpoint":
+				p.findings[i].Action = ActionManual
+				p.findings[i].Message += " (by hand: no main function creating a Fiber app where inst can be set up)"
+			}
+		}
+	}
+
+	srcs := make(map[string][]byte)
+	for _, f := range p.files {
+		srcs[f.name] = f.src
+		if e := edits[f]; e != nil {
+			srcs[f.name] = applyEdits(f.src, e.edits)
+		}
+	}
+	if err := p.prune(srcs); err != nil {
+		return err
+	}
+	for _, f := range p.files {
+		if bytes.Equal(srcs[f.name], f.src) {
+			continue
+		}
+		var imports map[string]string
+		if e := edits[f]; e != nil {
+			imports = e.imports
+		}
+		after, err := fixImports(f.src, srcs[f.name], imports)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite %s: %w", f.name, err)
+		}
+		p.changes = append(p.changes, Change{File: f.name, Before: f.src, After: after})
+	}
+	return nil
+}
+
+func (p *pkg) offset(pos token.Pos) int {
+	return p.fset.Position(pos).Offset
+}
+
+// mainFunc returns the main function of a main package
+func (p *pkg) mainFunc() (*file, *ast.FuncDecl) {
+	for _, f := range p.files {
+		if f.ast.Name.Name != "main" {
+			continue
+		}
+		for _, decl := range f.ast.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" && fn.Body != nil {
+				return f, fn
+			}
+		}
+	}
+	return nil, nil
+}
+
+// bootstrap inserts the instrumentation.New call before the statement of
+// main that creates the Fiber app, and returns that statement
+func (p *pkg) bootstrap(f *file, fn *ast.FuncDecl, service *value, imports map[string]string, e *fileEdits) ast.Stmt {
+	for _, path := range f.imports {
+		if path == instrumentationPath {
+			// Migrated already
+			return nil
+		}
+	}
+	if uses(fn.Body, "inst") {
+		p.add(f, fn.Pos(), "bootstrap", ActionManual, "main already uses the name inst; set up instrumentation.New by hand")
+		return nil
+	}
+	at := -1
+	for i, stmt := range fn.Body.List {
+		if p.createsFiberApp(f, stmt) {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return nil
+	}
+	stmt := fn.Body.List[at]
+
+	// A later err := in main would no longer declare anything new
+	errName := "err"
+	for _, later := range fn.Body.List[at:] {
+		if assign, ok := later.(*ast.AssignStmt); ok && assign.Tok == token.DEFINE && declares(assign, "err") {
+			errName = "instErr"
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("// Logs, metrics, and traces are set up by pkg/instrumentation and can be\n")
+	b.WriteString("// tuned with the SERVICE_NAME, LOG_*, METRICS_*, and OTEL_* variables\n")
+	fmt.Fprintf(&b, "inst, %s := instrumentation.New(\n", errName)
+	for _, opt := range p.options {
+		fmt.Fprintf(&b, "%s,\n", opt)
+	}
+	fmt.Fprintf(&b, ")\nif %s != nil {\npanic(%s)\n}\n", errName, errName)
+	b.WriteString("defer inst.Shutdown(context.Background())\n")
+	imports["context"] = "context"
+	for _, name := range p.loggerVars {
+		fmt.Fprintf(&b, "%s = inst.Logger\n", name)
+	}
+	for _, name := range p.tracerVars {
+		fmt.Fprintf(&b, "%s = otel.Tracer(%s)\n", name, service.text)
+		imports["otel"] = pathOtel
+		for name, path := range service.imports {
+			imports[name] = path
+		}
+	}
+	b.WriteString("\n")
+
+	start := p.offset(stmt.Pos())
+	if doc := p.leadingComment(f, stmt); doc != nil {
+		start = p.offset(doc.Pos())
+	}
+	start = lineStart(f.src, start)
+	e.add(start, start, b.String(), imports)
+	p.add(f, stmt.Pos(), "bootstrap", ActionRewrite, "instrumentation.New is set up before the Fiber app is created")
+	return stmt
+}
+
+// createsFiberApp reports whether stmt calls fiber.New
+func (p *pkg) createsFiberApp(f *file, stmt ast.Stmt) bool {
+	found := false
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if path, name, ok := f.selector(call.Fun); ok && path == pathFiber && name == "New" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// rewriteMain replaces the hand-rolled middleware and metrics endpoint of
+// main after inst is set up with inst's
+func (p *pkg) rewriteMain(f *file, fn *ast.FuncDecl, setup token.Pos, e *fileEdits) {
+	replaced := false
+	handled := make(map[*ast.CallExpr]bool)
+	for _, stmt := range fn.Body.List {
+		expr, ok := stmt.(*ast.ExprStmt)
+		if !ok || stmt.Pos() < setup {
+			continue
+		}
+		call, ok := expr.X.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 || !p.isMiddleware(call.Args[0]) {
+			continue
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "Use" {
+			continue
+		}
+		if !replaced {
+			replaced = true
+			e.add(p.offset(call.Args[0].Pos()), p.offset(call.Args[0].End()), "inst.FiberMiddleware()", nil)
+			continue
+		}
+		start, end := p.offset(stmt.Pos()), p.offset(stmt.End())
+		if doc := p.leadingComment(f, stmt); doc != nil {
+			start = p.offset(doc.Pos())
+		}
+		start, end = lineRange(f.src, start, end)
+		e.add(start, end, "", nil)
+	}
+
+	// Endpoints serving promhttp.Handler() serve inst's metrics
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || call.Pos() < setup {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Get" || len(call.Args) != 2 {
+			return true
+		}
+		handler := call.Args[1]
+		promCalls := p.promhttpCalls(f, handler)
+		if len(promCalls) == 0 {
+			return true
+		}
+		for _, c := range promCalls {
+			handled[c] = true
+		}
+		e.add(p.offset(handler.Pos()), p.offset(handler.End()), "inst.FiberMetricsHandler()", nil)
+		return false
+	})
+	for _, c := range p.promhttpCalls(f, fn.Body) {
+		if !handled[c] && c.Pos() > setup {
+			e.add(p.offset(c.Pos()), p.offset(c.End()), "inst.MetricsHandler()", nil)
+		}
+	}
+}
+
+// isMiddleware reports whether e is a hand-rolled middleware or a call
+// returning one
+func (p *pkg) isMiddleware(e ast.Expr) bool {
+	if call, ok := e.(*ast.CallExpr); ok {
+		e = call.Fun
+	}
+	id, ok := e.(*ast.Ident)
+	return ok && p.middleware[id.Name] != nil
+}
+
+func (p *pkg) promhttpCalls(f *file, n ast.Node) []*ast.CallExpr {
+	var calls []*ast.CallExpr
+	ast.Inspect(n, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && len(call.Args) == 0 {
+			if path, name, ok := f.selector(call.Fun); ok && path == pathPromhttp && name == "Handler" {
+				calls = append(calls, call)
+			}
+		}
+		return true
+	})
+	return calls
+}
+
+// leadingComment returns the comment on the lines right above n
+func (p *pkg) leadingComment(f *file, n ast.Node) *ast.CommentGroup {
+	line := p.fset.Position(n.Pos()).Line
+	for _, group := range f.ast.Comments {
+		if p.fset.Position(group.End()).Line == line-1 {
+			start := p.offset(group.Pos())
+			if strings.TrimSpace(string(f.src[lineStart(f.src, start):start])) == "" {
+				return group
+			}
+		}
+	}
+	return nil
+}
+
+// prune removes the hand-rolled middleware and HTTP metrics nothing refers
+// to anymore, repeating until nothing more can go
+func (p *pkg) prune(srcs map[string][]byte) error {
+	for {
+		fset := token.NewFileSet()
+		files := make(map[string]*ast.File)
+		refs := make(map[string]int)
+		for name, src := range srcs {
+			f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+			if err != nil {
+				return fmt.Errorf("failed to rewrite %s: %w", name, err)
+			}
+			files[name] = f
+			ast.Inspect(f, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok {
+					refs[id.Name]++
+				}
+				return true
+			})
+		}
+		unused := func(name string) bool {
+			// The declaration itself is the only reference
+			return refs[name] == 1
+		}
+
+		removed := false
+		for name, f := range files {
+			var edits []edit
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					if d.Recv == nil && p.middleware[d.Name.Name] != nil && unused(d.Name.Name) {
+						edits = append(edits, removal(fset, srcs[name], d.Doc, d))
+					}
+				case *ast.GenDecl:
+					if d.Tok != token.VAR {
+						continue
+					}
+					var gone []ast.Spec
+					for _, spec := range d.Specs {
+						vs := spec.(*ast.ValueSpec)
+						if len(vs.Names) == 1 && p.metricVars[vs.Names[0].Name] != nil && unused(vs.Names[0].Name) {
+							gone = append(gone, spec)
+							p.removedMetric(p.metricVars[vs.Names[0].Name])
+						}
+					}
+					switch {
+					case len(gone) == 0:
+					case len(gone) == len(d.Specs):
+						edits = append(edits, removal(fset, srcs[name], d.Doc, d))
+					default:
+						for _, spec := range gone {
+							vs := spec.(*ast.ValueSpec)
+							var end ast.Node = vs
+							if vs.Comment != nil {
+								end = vs.Comment
+							}
+							edits = append(edits, removal(fset, srcs[name], vs.Doc, spanNode{vs.Pos(), end.End()}))
+						}
+					}
+				}
+			}
+			if len(edits) > 0 {
+				srcs[name] = applyEdits(srcs[name], edits)
+				removed = true
+			}
+		}
+		if !removed {
+			return nil
+		}
+	}
+}
+
+// removedMetric updates the finding of a metric the codemod removed
+func (p *pkg) removedMetric(m *httpMetric) {
+	for i, f := range p.findings {
+		if f.Kind == "http-metric" && f.File == m.file && f.Line == m.line {
+			p.findings[i].Action = ActionRewrite
+			p.findings[i].Message = m.name + " is removed: " + m.detail
+		}
+	}
+}
+
+// spanNode is a node covering a range of source
+type spanNode struct {
+	pos, end token.Pos
+}
+
+func (n spanNode) Pos() token.Pos { return n.pos }
+func (n spanNode) End() token.Pos { return n.end }
+
+// removal deletes the lines of n and its doc comment, with a blank line
+// after them
+func removal(fset *token.FileSet, src []byte, doc *ast.CommentGroup, n ast.Node) edit {
+	start := fset.Position(n.Pos()).Offset
+	if doc != nil {
+		start = fset.Position(doc.Pos()).Offset
+	}
+	start, end := lineRange(src, start, fset.Position(n.End()).Offset)
+	if end < len(src) && src[end] == '\n' {
+		end++
+	}
+	return edit{start: start, end: end}
+}
+
+func lineStart(src []byte, offset int) int {
+	return bytes.LastIndexByte(src[:offset], '\n') + 1
+}
+
+// lineRange widens a range to whole lines when nothing else is on them
+func lineRange(src []byte, start, end int) (int, int) {
+	if s := lineStart(src, start); len(bytes.TrimSpace(src[s:start])) == 0 {
+		start = s
+	}
+	if i := bytes.IndexByte(src[end:], '\n'); i >= 0 && len(bytes.TrimSpace(src[end:end+i])) == 0 {
+		end += i + 1
+	}
+	return start, end
+}
+
+// applyEdits applies non-overlapping edits to src
+func applyEdits(src []byte, edits []edit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte(nil), src...)
+	limit := len(src)
+	for _, e := range edits {
+		if e.end > limit {
+			continue
+		}
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+		limit = e.start
+	}
+	return out
+}
+
+// fixImports adds the imports the rewritten code needs, drops the ones the
+// rewrite left unused, and formats the file
+func fixImports(before, src []byte, needed map[string]string) ([]byte, error) {
+	fset := token.NewFileSet()
+	orig, err := parser.ParseFile(fset, "", before, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	usedBefore, used := qualifiers(orig), qualifiers(f)
+
+	var edits []edit
+	have := importNames(f)
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := defaultImportName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == "_" || name == "." || used[name] || !usedBefore[name] {
+			continue
+		}
+		start, end := lineRange(src, fset.Position(spec.Pos()).Offset, fset.Position(spec.End()).Offset)
+		edits = append(edits, edit{start: start, end: end})
+	}
+
+	// Standard library imports go in the first group, the others in the
+	// last, where gofmt sorts them
+	var std, other []string
+	for name, path := range needed {
+		if _, ok := have[name]; ok || !used[name] {
+			continue
+		}
+		spec := strconv.Quote(path)
+		if name != defaultImportName(path) {
+			spec = name + " " + spec
+		}
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	if len(std)+len(other) > 0 {
+		var block *ast.GenDecl
+		for _, decl := range f.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT && gen.Lparen.IsValid() {
+				block = gen
+				break
+			}
+		}
+		if block == nil {
+			at := fset.Position(f.Name.End()).Offset
+			edits = append(edits, edit{start: at, end: at, text: "\n\nimport (\n" + strings.Join(append(std, other...), "\n") + "\n)"})
+		} else {
+			if len(std) > 0 {
+				at := fset.Position(block.Lparen).Offset + 1
+				edits = append(edits, edit{start: at, end: at, text: "\n" + strings.Join(std, "\n")})
+			}
+			if len(other) > 0 {
+				at := lineStart(src, fset.Position(block.Rparen).Offset)
+				edits = append(edits, edit{start: at, end: at, text: strings.Join(other, "\n") + "\n"})
+			}
+		}
+	}
+	return format.Source(applyEdits(src, edits))
+}
+
+// qualifiers returns the package names f refers to
+func qualifiers(f *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+				names[x.Name] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// uses reports whether the identifier name appears in n
+func uses(n ast.Node, name string) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// declares reports whether assign declares name
+func declares(assign *ast.AssignStmt, name string) bool {
+	for _, lhs := range assign.Lhs {
+		if id, ok := lhs.(*ast.Ident); ok && id.Name == name {
+			return true
+		}
+	}
+	return false
+}
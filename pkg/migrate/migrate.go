@@ -0,0 +1,218 @@
+// Package migrate finds hand-rolled otelfiber, Prometheus, and zap wiring in
+// Go source and plans its migration to pkg/instrumentation, rewriting what
+// it can convert safely and reporting what it cannot.
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Action is what migrating a finding takes
+type Action string
+
+const (
+	// ActionRewrite findings are converted by the codemod
+	ActionRewrite Action = "rewrite"
+	// ActionManual findings have an equivalent but need converting by hand
+	ActionManual Action = "manual"
+	// ActionKeep findings keep working next to pkg/instrumentation
+	ActionKeep Action = "keep"
+	// ActionGap findings have no equivalent in pkg/instrumentation
+	ActionGap Action = "gap"
+)
+
+// Finding is a piece of telemetry wiring and how to migrate it
+type Finding struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+	Action  Action `json:"action"`
+	Message string `json:"message"`
+}
+
+// Change is a file the codemod rewrites
+type Change struct {
+	File   string `json:"file"`
+	Before []byte `json:"-"`
+	After  []byte `json:"-"`
+}
+
+// Report is the migration plan of a source tree
+type Report struct {
+	Root     string    `json:"root"`
+	Findings []Finding `json:"findings"`
+	// Options are the instrumentation.New options the codemod sets up,
+	// derived from the existing wiring
+	Options []string `json:"options,omitempty"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Count returns how many findings take the given action
+func (r *Report) Count(action Action) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// WritePlan writes the report as a readable migration plan
+func (r *Report) WritePlan(w io.Writer) {
+	fmt.Fprintf(w, "Migration plan for %s\n", r.Root)
+	if len(r.Findings) == 0 {
+		fmt.Fprintln(w, "\nNo hand-rolled telemetry wiring found.")
+		return
+	}
+	if len(r.Options) > 0 {
+		fmt.Fprintln(w, "\ninstrumentation.New options derived from the existing wiring:")
+		for _, opt := range r.Options {
+			fmt.Fprintf(w, "  %s\n", opt)
+		}
+	}
+
+	sections := []struct {
+		action Action
+		title  string
+	}{
+		{ActionRewrite, "Rewritten by the codemod (apm migrate scan --write)"},
+		{ActionManual, "Manual steps"},
+		{ActionKeep, "Keeps working as is"},
+		{ActionGap, "Gaps: no equivalent in pkg/instrumentation"},
+	}
+	for _, section := range sections {
+		if r.Count(section.action) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		for _, f := range r.Findings {
+			if f.Action == section.action {
+				fmt.Fprintf(w, "  %s:%d  [%s] %s\n", f.File, f.Line, f.Kind, f.Message)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n%d rewrites in %d files, %d manual steps, %d gaps\n",
+		r.Count(ActionRewrite), len(r.Changes), r.Count(ActionManual), r.Count(ActionGap))
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Diff returns the changes of the codemod as a unified diff
+func (r *Report) Diff() (string, error) {
+	var out string
+	for _, c := range r.Changes {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(c.Before)),
+			B:        difflib.SplitLines(string(c.After)),
+			FromFile: "a/" + filepath.ToSlash(c.File),
+			ToFile:   "b/" + filepath.ToSlash(c.File),
+			Context:  3,
+		})
+		if err != nil {
+			return "", err
+		}
+		out += diff
+	}
+	return out, nil
+}
+
+// Apply writes the changes of the codemod
+func (r *Report) Apply() error {
+	for _, c := range r.Changes {
+		path := filepath.Join(r.Root, c.File)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, c.After, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", c.File, err)
+		}
+	}
+	return nil
+}
+
+// Scan analyzes the Go packages under root, skipping tests, vendored code,
+// testdata, and hidden directories
+func Scan(root string) (*Report, error) {
+	dirs := make(map[string][]string)
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != root && (name == "vendor" || name == "testdata" || name == "node_modules" || name[0] == '.' || name[0] == '_') {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".go" && !isTestFile(path) {
+			dirs[filepath.Dir(path)] = append(dirs[filepath.Dir(path)], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Root: root}
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	for _, dir := range names {
+		p, err := loadPackage(root, dirs[dir])
+		if err != nil {
+			return nil, err
+		}
+		p.analyze()
+		if err := p.rewrite(); err != nil {
+			return nil, err
+		}
+		sort.SliceStable(p.findings, func(i, j int) bool {
+			if p.findings[i].File != p.findings[j].File {
+				return p.findings[i].File < p.findings[j].File
+			}
+			return p.findings[i].Line < p.findings[j].Line
+		})
+		report.Findings = append(report.Findings, p.findings...)
+		report.Options = append(report.Options, p.options...)
+		report.Changes = append(report.Changes, p.changes...)
+	}
+	report.Findings = append(report.Findings, checkModule(root, report)...)
+	return report, nil
+}
+
+func isTestFile(path string) bool {
+	return strings.HasSuffix(path, "_test.go")
+}
+
+// checkModule reports when the module of root does not require APM yet
+func checkModule(root string, report *Report) []Finding {
+	if len(report.Findings) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil || strings.Contains(string(data), instrumentationModule) {
+		return nil
+	}
+	return []Finding{{
+		File: "go.mod", Line: 1, Kind: "module", Action: ActionManual,
+		Message: "run go get " + instrumentationModule + "@latest, then go mod tidy to drop the replaced dependencies",
+	}}
+}
@@ -0,0 +1,171 @@
+package migrate
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func scanHandRolled(t *testing.T) *Report {
+	t.Helper()
+	report, err := Scan(filepath.Join("testdata", "handrolled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func findingOf(r *Report, kind string) *Finding {
+	for i := range r.Findings {
+		if r.Findings[i].Kind == kind {
+			return &r.Findings[i]
+		}
+	}
+	return nil
+}
+
+func changeOf(t *testing.T, r *Report, name string) string {
+	t.Helper()
+	for _, c := range r.Changes {
+		if c.File == name {
+			if _, err := parser.ParseFile(token.NewFileSet(), name, c.After, 0); err != nil {
+				t.Fatalf("rewritten %s does not parse: %v", name, err)
+			}
+			return string(c.After)
+		}
+	}
+	t.Fatalf("no change to %s", name)
+	return ""
+}
+
+func TestScanFindings(t *testing.T) {
+	r := scanHandRolled(t)
+
+	want := map[string]Action{
+		"bootstrap":          ActionRewrite,
+		"otelfiber":          ActionRewrite,
+		"metrics-endpoint":   ActionRewrite,
+		"http-metric":        ActionRewrite,
+		"metrics-middleware": ActionRewrite,
+		"tracer-provider":    ActionManual,
+		"module":             ActionManual,
+		"custom-metric":      ActionKeep,
+		"span-limits":        ActionGap,
+	}
+	for kind, action := range want {
+		f := findingOf(r, kind)
+		if f == nil {
+			t.Errorf("no %s finding", kind)
+			continue
+		}
+		if f.Action != action {
+			t.Errorf("%s finding is %s, want %s", kind, f.Action, action)
+		}
+	}
+	if f := findingOf(r, "http-metric"); f != nil && !strings.Contains(f.Message, "route, code labels") {
+		t.Errorf("http-metric finding does not name the dropped labels: %s", f.Message)
+	}
+
+	options := strings.Join(r.Options, "\n")
+	for _, opt := range []string{
+		`instrumentation.WithService("orders")`,
+		`instrumentation.WithTracing(instrumentation.TracerConfig{ExporterType: "otlp", Protocol: "http/protobuf", Endpoint: collectorEndpoint})`,
+		`instrumentation.WithSampleRate(0.25)`,
+	} {
+		if !strings.Contains(options, opt) {
+			t.Errorf("options lack %s:\n%s", opt, options)
+		}
+	}
+}
+
+func TestScanRewrites(t *testing.T) {
+	r := scanHandRolled(t)
+
+	main := changeOf(t, r, "main.go")
+	for _, s := range []string{
+		"inst, err := instrumentation.New(",
+		"defer inst.Shutdown(context.Background())",
+		`app.Use(instrumentation.FiberOtelMiddleware("orders"))`,
+		"app.Use(inst.FiberMiddleware())",
+		`app.Get("/metrics", inst.FiberMetricsHandler())`,
+		`"github.com/chaksack/apm/pkg/instrumentation"`,
+	} {
+		if !strings.Contains(main, s) {
+			t.Errorf("main.go lacks %s:\n%s", s, main)
+		}
+	}
+	for _, s := range []string{"otelfiber", "promhttp", "adaptor"} {
+		if strings.Contains(main, s) {
+			t.Errorf("main.go still refers to %s:\n%s", s, main)
+		}
+	}
+
+	telemetry := changeOf(t, r, "telemetry.go")
+	if strings.Contains(telemetry, "requestsTotal") || strings.Contains(telemetry, "metricsMiddleware") {
+		t.Errorf("telemetry.go keeps the hand-rolled metrics:\n%s", telemetry)
+	}
+	if !strings.Contains(telemetry, "ordersCreated") {
+		t.Errorf("telemetry.go lost the custom metric:\n%s", telemetry)
+	}
+
+	diff, err := r.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "--- a/main.go") || !strings.Contains(diff, "+	app.Use(inst.FiberMiddleware())") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+}
+
+func TestApplyConverges(t *testing.T) {
+	root := t.TempDir()
+	entries, err := os.ReadDir(filepath.Join("testdata", "handrolled"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join("testdata", "handrolled", e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, e.Name()), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Changes) != 0 {
+		t.Errorf("second scan still rewrites %d files", len(again.Changes))
+	}
+	if f := findingOf(again, "tracer-provider"); f == nil {
+		t.Error("manual steps are not reported again after applying")
+	}
+}
+
+func TestScanWithoutWiring(t *testing.T) {
+	root := t.TempDir()
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"hi\") }\n"
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Findings) != 0 || len(r.Changes) != 0 {
+		t.Errorf("got %d findings and %d changes, want none", len(r.Findings), len(r.Changes))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/adaptor/v2"
+	"github.com/gofiber/contrib/otelfiber"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const collectorEndpoint = "otel-collector:4318"
+
+func main() {
+	tp, err := initTracer(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tp.Shutdown(context.Background())
+
+	app := fiber.New()
+	app.Use(otelfiber.Middleware())
+	app.Use(metricsMiddleware)
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	app.Post("/orders", func(c *fiber.Ctx) error {
+		ordersCreated.Inc()
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	err = app.Listen(":8080")
+	log.Fatal(err)
+}
@@ -0,0 +1,179 @@
+// Package netaddr parses, validates, and formats the endpoints of exporters
+// and stack components. IPv6 literals are bracketed wherever a port follows
+// them, and validation errors say how a malformed endpoint should be written.
+package netaddr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidEndpoint is wrapped by every validation error
+var ErrInvalidEndpoint = errors.New("invalid endpoint")
+
+// FallbackDelay is how long a dual-stack dial waits on the preferred
+// address family before racing the other one (RFC 6555, happy eyeballs)
+const FallbackDelay = 300 * time.Millisecond
+
+func invalid(endpoint, format string, args ...interface{}) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidEndpoint, endpoint, fmt.Sprintf(format, args...))
+}
+
+// SplitHostPort splits a host:port endpoint such as collector:4317 or
+// [fd00::1]:4317. Unlike net.SplitHostPort it requires a host and a valid
+// port, and explains IPv6 addresses written without brackets.
+func SplitHostPort(endpoint string) (host, port string, err error) {
+	if strings.Contains(endpoint, "://") {
+		return "", "", invalid(endpoint, "expected host:port such as collector:4317, not a URL")
+	}
+	return splitAuthority(endpoint, endpoint, true)
+}
+
+// ValidateHostPort checks a host:port endpoint
+func ValidateHostPort(endpoint string) error {
+	_, _, err := SplitHostPort(endpoint)
+	return err
+}
+
+// ValidateAddress checks a host with an optional port, such as collector,
+// collector:4317, or [fd00::1]:4317
+func ValidateAddress(endpoint string) error {
+	if strings.Contains(endpoint, "://") {
+		return invalid(endpoint, "expected host:port such as collector:4317, not a URL")
+	}
+	_, _, err := splitAuthority(endpoint, endpoint, false)
+	return err
+}
+
+// ValidateURL checks an absolute URL such as http://[fd00::1]:9090/api. The
+// port is optional.
+func ValidateURL(raw string) error {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || scheme == "" {
+		return invalid(raw, "expected an absolute URL such as http://host:port")
+	}
+	authority := rest
+	if i := strings.IndexAny(authority, "/?#"); i >= 0 {
+		authority = authority[:i]
+	}
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		authority = authority[i+1:]
+	}
+	if _, _, err := splitAuthority(raw, authority, false); err != nil {
+		return err
+	}
+	if _, err := url.Parse(raw); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidEndpoint, raw, errors.Unwrap(err))
+	}
+	return nil
+}
+
+// splitAuthority splits the host[:port] part of an endpoint, naming the
+// whole endpoint in errors
+func splitAuthority(endpoint, authority string, portRequired bool) (host, port string, err error) {
+	if authority == "" {
+		return "", "", invalid(endpoint, "missing host")
+	}
+
+	if strings.HasPrefix(authority, "[") {
+		end := strings.Index(authority, "]")
+		if end < 0 {
+			return "", "", invalid(endpoint, "missing ] after the IPv6 address")
+		}
+		host = authority[1:end]
+		if !isIPv6(host) {
+			return "", "", invalid(endpoint, "%s in brackets is not an IPv6 address", host)
+		}
+		rest := authority[end+1:]
+		switch {
+		case rest == "" && portRequired:
+			return "", "", invalid(endpoint, "missing port: write [%s]:<port>", host)
+		case rest == "":
+			return host, "", nil
+		case !strings.HasPrefix(rest, ":"):
+			return "", "", invalid(endpoint, "unexpected %q after the IPv6 address", rest)
+		}
+		port = rest[1:]
+		return host, port, checkPort(endpoint, port)
+	}
+
+	if strings.Count(authority, ":") > 1 {
+		// fd00::1:4317 is itself an IPv6 address, but a trailing port is
+		// the likelier intent
+		i := strings.LastIndex(authority, ":")
+		if isIPv6(authority[:i]) && checkPort(endpoint, authority[i+1:]) == nil {
+			return "", "", invalid(endpoint, "IPv6 addresses need brackets: write [%s]:%s", authority[:i], authority[i+1:])
+		}
+		if isIPv6(authority) {
+			if portRequired {
+				return "", "", invalid(endpoint, "IPv6 addresses need brackets and a port: write [%s]:<port>", authority)
+			}
+			return "", "", invalid(endpoint, "IPv6 addresses need brackets: write [%s]", authority)
+		}
+		return "", "", invalid(endpoint, "too many colons; IPv6 addresses go in brackets, e.g. [fd00::1]:4317")
+	}
+
+	host, port, hasPort := strings.Cut(authority, ":")
+	if host == "" {
+		return "", "", invalid(endpoint, "missing host")
+	}
+	if !hasPort {
+		if portRequired {
+			return "", "", invalid(endpoint, "missing port: write %s:<port>", host)
+		}
+		return host, "", nil
+	}
+	return host, port, checkPort(endpoint, port)
+}
+
+func checkPort(endpoint, port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return invalid(endpoint, "port %q is not a number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// isIPv6 reports whether s is an IPv6 address, with an optional zone
+func isIPv6(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	return err == nil && addr.Is6()
+}
+
+// JoinHostPort joins a host and port, bracketing IPv6 addresses. A host
+// that is already bracketed is not bracketed again.
+func JoinHostPort(host string, port int) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), strconv.Itoa(port))
+}
+
+// URL returns scheme://host:port, bracketing IPv6 hosts
+func URL(scheme, host string, port int) string {
+	return scheme + "://" + JoinHostPort(host, port)
+}
+
+// Hostname returns the host of a URL or host:port endpoint, without
+// brackets or port
+func Hostname(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		if u, err := url.Parse(endpoint); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]")
+}
+
+// Dialer returns a dialer that connects to dual-stack hosts over whichever
+// of IPv6 and IPv4 answers first
+func Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, FallbackDelay: FallbackDelay}
+}
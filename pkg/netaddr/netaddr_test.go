@@ -0,0 +1,106 @@
+package netaddr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		endpoint   string
+		host, port string
+		wantErr    string
+	}{
+		{endpoint: "collector:4317", host: "collector", port: "4317"},
+		{endpoint: "10.0.0.1:4317", host: "10.0.0.1", port: "4317"},
+		{endpoint: "[fd00::1]:4317", host: "fd00::1", port: "4317"},
+		{endpoint: "[fe80::1%eth0]:4317", host: "fe80::1%eth0", port: "4317"},
+		{endpoint: "fd00::1:4317", wantErr: "write [fd00::1]:4317"},
+		{endpoint: "fd00::1", wantErr: "write [fd00::1]:<port>"},
+		{endpoint: "[fd00::1]", wantErr: "missing port"},
+		{endpoint: "[fd00::1:4317", wantErr: "missing ]"},
+		{endpoint: "[collector]:4317", wantErr: "not an IPv6 address"},
+		{endpoint: "[fd00::1]4317", wantErr: "after the IPv6 address"},
+		{endpoint: "collector", wantErr: "write collector:<port>"},
+		{endpoint: ":4317", wantErr: "missing host"},
+		{endpoint: "collector:otlp", wantErr: "between 1 and 65535"},
+		{endpoint: "collector:70000", wantErr: "between 1 and 65535"},
+		{endpoint: "http://collector:4317", wantErr: "not a URL"},
+		{endpoint: "a:b:c", wantErr: "too many colons"},
+	}
+	for _, tt := range tests {
+		host, port, err := SplitHostPort(tt.endpoint)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, ErrInvalidEndpoint) {
+				t.Errorf("SplitHostPort(%q) error = %v, want %q", tt.endpoint, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("SplitHostPort(%q) = %q, %q, %v", tt.endpoint, host, port, err)
+		}
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	for _, endpoint := range []string{"collector", "collector:4317", "[fd00::1]", "[fd00::1]:4317"} {
+		if err := ValidateAddress(endpoint); err != nil {
+			t.Errorf("ValidateAddress(%q) = %v", endpoint, err)
+		}
+	}
+	if err := ValidateAddress("fd00::1:4317"); err == nil || !strings.Contains(err.Error(), "[fd00::1]:4317") {
+		t.Errorf("unbracketed address error = %v", err)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	valid := []string{
+		"http://prometheus:9090",
+		"https://[fd00::1]:3000/grafana",
+		"http://[::1]",
+		"http://user:pass@[fd00::1]:9090/api?x=1",
+		"http://collector/api/traces",
+	}
+	for _, raw := range valid {
+		if err := ValidateURL(raw); err != nil {
+			t.Errorf("ValidateURL(%q) = %v", raw, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"http://fd00::1:9090/api": "write [fd00::1]:9090",
+		"http://fd00::1":          "write [fd00::1]",
+		"prometheus:9090":         "absolute URL",
+		"http://":                 "missing host",
+		"http://[fd00::1]:0":      "between 1 and 65535",
+	}
+	for raw, want := range invalid {
+		if err := ValidateURL(raw); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidateURL(%q) = %v, want %q", raw, err, want)
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	if got := JoinHostPort("fd00::1", 9090); got != "[fd00::1]:9090" {
+		t.Errorf("JoinHostPort = %s", got)
+	}
+	if got := JoinHostPort("[fd00::1]", 9090); got != "[fd00::1]:9090" {
+		t.Errorf("JoinHostPort of a bracketed host = %s", got)
+	}
+	if got := URL("http", "localhost", 3000); got != "http://localhost:3000" {
+		t.Errorf("URL = %s", got)
+	}
+	for endpoint, want := range map[string]string{
+		"http://[fd00::1]:9090/api": "fd00::1",
+		"[fd00::1]:4317":            "fd00::1",
+		"collector:4317":            "collector",
+		"fd00::1":                   "fd00::1",
+		"[fd00::1]":                 "fd00::1",
+	} {
+		if got := Hostname(endpoint); got != want {
+			t.Errorf("Hostname(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
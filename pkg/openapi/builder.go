@@ -0,0 +1,209 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error is the body of error responses: {"error": "..."}
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Route describes one operation of the API. Request and Response are values
+// of the Go types the handler decodes and encodes; strings are sent as
+// text/plain and nil means no body.
+type Route struct {
+	// ID names the operation, and the method of generated clients
+	ID          string
+	Summary     string
+	Description string
+	Tags        []string
+	// Query lists the query parameters; path parameters come from the path
+	Query []Param
+	// Request is the JSON request body
+	Request any
+	// Response is the body of the success response
+	Response any
+	// Status is the success status, 200 by default
+	Status int
+	// Errors lists the error statuses, answered with Error bodies
+	Errors []int
+	// TextErrors marks error bodies as plain text, as sent by fiber.NewError
+	TextErrors bool
+	// Security names the security schemes the operation accepts
+	Security []string
+}
+
+// Param is a query parameter
+type Param struct {
+	Name        string
+	Description string
+	// Type is a value of the parameter's Go type, string when nil
+	Type     any
+	Required bool
+}
+
+// Builder collects routes into an OpenAPI document
+type Builder struct {
+	doc     *Document
+	schemas *schemas
+	ids     map[string]bool
+}
+
+// NewBuilder creates a builder for a document about the given API
+func NewBuilder(info Info) *Builder {
+	s := newSchemas()
+	return &Builder{
+		doc: &Document{
+			OpenAPI:    Version,
+			Info:       info,
+			Paths:      make(map[string]*PathItem),
+			Components: Components{Schemas: s.components},
+		},
+		schemas: s,
+		ids:     make(map[string]bool),
+	}
+}
+
+// Server adds a base URL the API is served from
+func (b *Builder) Server(url, description string) *Builder {
+	b.doc.Servers = append(b.doc.Servers, Server{URL: url, Description: description})
+	return b
+}
+
+// Tag describes a tag operations are grouped by
+func (b *Builder) Tag(name, description string) *Builder {
+	b.doc.Tags = append(b.doc.Tags, Tag{Name: name, Description: description})
+	return b
+}
+
+// SecurityScheme adds a way callers authenticate
+func (b *Builder) SecurityScheme(name string, scheme *SecurityScheme) *Builder {
+	if b.doc.Components.SecuritySchemes == nil {
+		b.doc.Components.SecuritySchemes = make(map[string]*SecurityScheme)
+	}
+	b.doc.Components.SecuritySchemes[name] = scheme
+	return b
+}
+
+// Add describes an operation at a Fiber route path such as /tools/:tool.
+// It panics when the route is malformed, since routes are described at
+// startup from code.
+func (b *Builder) Add(method, path string, route Route) *Builder {
+	if route.ID == "" {
+		panic(fmt.Sprintf("openapi: %s %s has no operation ID", method, path))
+	}
+	if b.ids[route.ID] {
+		panic(fmt.Sprintf("openapi: duplicate operation ID %q", route.ID))
+	}
+	b.ids[route.ID] = true
+
+	openAPIPath, params := convertPath(path)
+	op := &Operation{
+		OperationID: route.ID,
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Responses:   make(map[string]*Response),
+	}
+	for _, name := range params {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	for _, q := range route.Query {
+		schema := &Schema{Type: "string"}
+		if q.Type != nil {
+			schema = b.schemas.of(q.Type)
+		}
+		op.Parameters = append(op.Parameters, Parameter{Name: q.Name, In: "query", Description: q.Description, Required: q.Required, Schema: schema})
+	}
+	if route.Request != nil {
+		op.RequestBody = &RequestBody{Required: true, Content: b.content(route.Request)}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		success.Content = b.content(route.Response)
+	}
+	op.Responses[strconv.Itoa(status)] = success
+	for _, code := range route.Errors {
+		var body any = Error{}
+		if route.TextErrors {
+			body = ""
+		}
+		op.Responses[strconv.Itoa(code)] = &Response{Description: http.StatusText(code), Content: b.content(body)}
+	}
+	for _, name := range route.Security {
+		op.Security = append(op.Security, map[string][]string{name: {}})
+	}
+
+	item := b.doc.Paths[openAPIPath]
+	if item == nil {
+		item = &PathItem{}
+		b.doc.Paths[openAPIPath] = item
+	}
+	switch strings.ToUpper(method) {
+	case http.MethodGet:
+		item.Get = op
+	case http.MethodPost:
+		item.Post = op
+	case http.MethodPut:
+		item.Put = op
+	case http.MethodPatch:
+		item.Patch = op
+	case http.MethodDelete:
+		item.Delete = op
+	default:
+		panic(fmt.Sprintf("openapi: unsupported method %s", method))
+	}
+	return b
+}
+
+// Document returns the document built so far
+func (b *Builder) Document() *Document {
+	return b.doc
+}
+
+func (b *Builder) content(body any) map[string]MediaType {
+	if reflect.TypeOf(body).Kind() == reflect.String {
+		return map[string]MediaType{"text/plain": {Schema: &Schema{Type: "string"}}}
+	}
+	return map[string]MediaType{fiber.MIMEApplicationJSON: {Schema: b.schemas.of(body)}}
+}
+
+// convertPath converts a Fiber path to an OpenAPI path, returning the names
+// of its parameters
+func convertPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			name = strings.TrimSuffix(name, "?")
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// Handler returns a Fiber handler that serves the document as JSON
+func Handler(doc *Document) fiber.Handler {
+	body, err := json.Marshal(doc)
+	return func(c *fiber.Ctx) error {
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed to encode OpenAPI document: "+err.Error())
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(body)
+	}
+}
@@ -0,0 +1,405 @@
+package openapi
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// GenerateClient generates the source of a Go package named pkg with a typed
+// client for every operation in doc. The client depends only on the
+// standard library.
+func GenerateClient(doc *Document, pkg string) ([]byte, error) {
+	g := &clientGenerator{doc: doc, declared: make(map[string]bool)}
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var methods strings.Builder
+	for _, path := range paths {
+		for _, op := range doc.Paths[path].Operations() {
+			if err := g.operation(&methods, op.Method, path, op.Operation); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var names []string
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g.declare(exportedName(name), doc.Components.Schemas[name])
+	}
+
+	var src strings.Builder
+	fmt.Fprintf(&src, "// Code generated by apm openapi client. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	src.WriteString(clientImports)
+	src.WriteString(clientRuntime)
+	src.WriteString(methods.String())
+	src.WriteString(g.types.String())
+
+	out, err := format.Source([]byte(src.String()))
+	if err != nil {
+		return nil, fmt.Errorf("generated client does not parse: %w", err)
+	}
+	return out, nil
+}
+
+type clientGenerator struct {
+	doc      *Document
+	types    strings.Builder
+	declared map[string]bool
+}
+
+func (g *clientGenerator) operation(w *strings.Builder, method, path string, op *Operation) error {
+	name := exportedName(op.OperationID)
+	args := []string{"ctx context.Context"}
+
+	// The path is built from its literal parts and escaped parameters
+	var pathExpr []string
+	literal := ""
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		literal += "/"
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			arg := goIdent(segment[1 : len(segment)-1])
+			args = append(args, arg+" string")
+			pathExpr = append(pathExpr, fmt.Sprintf("%q", literal), "url.PathEscape("+arg+")")
+			literal = ""
+			continue
+		}
+		literal += segment
+	}
+	if literal != "" {
+		pathExpr = append(pathExpr, fmt.Sprintf("%q", literal))
+	}
+
+	var query []Parameter
+	for _, p := range op.Parameters {
+		if p.In == "query" {
+			query = append(query, p)
+		}
+	}
+	queryExpr := "nil"
+	if len(query) > 0 {
+		paramsType := name + "Params"
+		g.queryParams(paramsType, op, query)
+		args = append(args, "params "+paramsType)
+		queryExpr = "params.values()"
+	}
+
+	bodyExpr := "nil"
+	if op.RequestBody != nil {
+		if media, ok := op.RequestBody.Content["application/json"]; ok {
+			args = append(args, "body "+g.goType(media.Schema, name+"Request", false))
+			bodyExpr = "body"
+		}
+	}
+
+	result, outExpr := "", "nil"
+	for _, code := range []string{"200", "201", "202"} {
+		resp := op.Responses[code]
+		if resp == nil {
+			continue
+		}
+		if _, ok := resp.Content["text/plain"]; ok {
+			result, outExpr = "string", "&out"
+		} else if media, ok := resp.Content["application/json"]; ok {
+			result, outExpr = g.goType(media.Schema, name+"Response", false), "&out"
+		}
+		break
+	}
+
+	fmt.Fprintf(w, "\n// %s calls %s %s", name, method, path)
+	if op.Summary != "" {
+		fmt.Fprintf(w, ": %s", lowerFirst(op.Summary))
+	}
+	w.WriteString("\n")
+	if result == "" {
+		fmt.Fprintf(w, "func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+		fmt.Fprintf(w, "\treturn c.do(ctx, %q, %s, %s, %s, nil)\n}\n", method, strings.Join(pathExpr, "+"), queryExpr, bodyExpr)
+		return nil
+	}
+	fmt.Fprintf(w, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), result)
+	fmt.Fprintf(w, "\tvar out %s\n", result)
+	fmt.Fprintf(w, "\terr := c.do(ctx, %q, %s, %s, %s, %s)\n\treturn out, err\n}\n", method, strings.Join(pathExpr, "+"), queryExpr, bodyExpr, outExpr)
+	return nil
+}
+
+// queryParams declares the struct of an operation's query parameters and
+// its encoding; zero values are left out of the query
+func (g *clientGenerator) queryParams(typeName string, op *Operation, query []Parameter) {
+	w := &g.types
+	fmt.Fprintf(w, "\n// %s holds the query parameters of %s\ntype %s struct {\n", typeName, exportedName(op.OperationID), typeName)
+	for _, p := range query {
+		if p.Description != "" {
+			fmt.Fprintf(w, "\t// %s\n", p.Description)
+		}
+		fmt.Fprintf(w, "\t%s %s\n", exportedName(p.Name), g.goType(p.Schema, typeName+exportedName(p.Name), true))
+	}
+	fmt.Fprintf(w, "}\n\nfunc (p %s) values() url.Values {\n\tq := url.Values{}\n", typeName)
+	for _, p := range query {
+		field := "p." + exportedName(p.Name)
+		switch g.goType(p.Schema, "", true) {
+		case "string":
+			fmt.Fprintf(w, "\tif %s != \"\" {\n\t\tq.Set(%q, %s)\n\t}\n", field, p.Name, field)
+		case "bool":
+			fmt.Fprintf(w, "\tif %s {\n\t\tq.Set(%q, \"true\")\n\t}\n", field, p.Name)
+		case "time.Time":
+			fmt.Fprintf(w, "\tif !%s.IsZero() {\n\t\tq.Set(%q, %s.Format(time.RFC3339))\n\t}\n", field, p.Name, field)
+		default:
+			fmt.Fprintf(w, "\tif %s != 0 {\n\t\tq.Set(%q, fmt.Sprint(%s))\n\t}\n", field, p.Name, field)
+		}
+	}
+	w.WriteString("\treturn q\n}\n")
+}
+
+// goType returns the Go type of a schema, declaring inline objects as named
+// types called hint
+func (g *clientGenerator) goType(s *Schema, hint string, required bool) string {
+	if s == nil {
+		return "any"
+	}
+	if name := s.RefName(); name != "" {
+		name = exportedName(name)
+		if required {
+			return name
+		}
+		return "*" + name
+	}
+
+	var t string
+	switch s.Type {
+	case "string":
+		switch s.Format {
+		case "date-time":
+			t = "time.Time"
+		case "byte":
+			t = "[]byte"
+		default:
+			t = "string"
+		}
+	case "integer":
+		t = "int64"
+		if s.Format == "int32" {
+			t = "int32"
+		}
+	case "number":
+		t = "float64"
+		if s.Format == "float" {
+			t = "float32"
+		}
+	case "boolean":
+		t = "bool"
+	case "array":
+		return "[]" + g.goType(s.Items, hint+"Item", true)
+	case "object":
+		if len(s.Properties) > 0 {
+			g.declare(hint, s)
+			if required {
+				return hint
+			}
+			return "*" + hint
+		}
+		if s.AdditionalProperties != nil {
+			return "map[string]" + g.goType(s.AdditionalProperties, hint+"Value", true)
+		}
+		return "map[string]any"
+	default:
+		return "any"
+	}
+	if s.Nullable {
+		return "*" + t
+	}
+	return t
+}
+
+// declare declares a struct for an object schema
+func (g *clientGenerator) declare(name string, s *Schema) {
+	if g.declared[name] {
+		return
+	}
+	g.declared[name] = true
+	if s.Type != "object" || len(s.Properties) == 0 {
+		if s.Type == "" && s.Ref == "" {
+			fmt.Fprintf(&g.types, "\n// %s is any JSON value\ntype %s = any\n", name, name)
+			return
+		}
+		fmt.Fprintf(&g.types, "\n// %s is the %s schema\ntype %s %s\n", name, name, name, g.goType(s, name+"Value", true))
+		return
+	}
+
+	required := make(map[string]bool)
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	var props []string
+	for prop := range s.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+
+	var body strings.Builder
+	for _, prop := range props {
+		field := exportedName(prop)
+		ft := g.goType(s.Properties[prop], name+field, required[prop])
+		tag := prop
+		if !required[prop] {
+			tag += ",omitempty"
+		}
+		if d := s.Properties[prop].Description; d != "" {
+			fmt.Fprintf(&body, "\t// %s\n", d)
+		}
+		fmt.Fprintf(&body, "\t%s %s `json:%q`\n", field, ft, tag)
+	}
+	comment := fmt.Sprintf("%s is the %s schema", name, name)
+	if s.Description != "" {
+		comment = name + " is " + lowerFirst(s.Description)
+	}
+	fmt.Fprintf(&g.types, "\n// %s\ntype %s struct {\n%s}\n", comment, name, body.String())
+}
+
+// goIdent returns name as an unexported Go identifier
+func goIdent(name string) string {
+	id := []rune(exportedName(name))
+	// Lower the leading word, or initialism such as ID
+	for i := 0; i < len(id) && unicode.IsUpper(id[i]); i++ {
+		if i > 0 && i+1 < len(id) && unicode.IsLower(id[i+1]) {
+			break
+		}
+		id[i] = unicode.ToLower(id[i])
+	}
+	ident := string(id)
+	if token.IsKeyword(ident) || ident == "ctx" || ident == "params" || ident == "body" {
+		ident += "Param"
+	}
+	return ident
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	// Leave initialisms such as ID alone
+	if len(r) > 1 && unicode.IsUpper(r[1]) {
+		return s
+	}
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+const clientImports = `import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+`
+
+const clientRuntime = `
+// Not every API needs every import
+var (
+	_ = fmt.Sprint
+	_ time.Time
+)
+
+// Client calls the API
+type Client struct {
+	// BaseURL is the scheme, host, and any path prefix of the API
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Header is added to every request, such as an Authorization header
+	Header http.Header
+}
+
+// NewClient creates a client for the API at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Header: make(http.Header)}
+}
+
+// APIError is a response with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var e struct {
+			Error string ` + "`json:\"error\"`" + `
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			apiErr.Message = e.Error
+		}
+		return apiErr
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *string:
+		*out = string(data)
+		return nil
+	default:
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+		return nil
+	}
+}
+`
@@ -0,0 +1,205 @@
+package openapi
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type item struct {
+	ID      string            `json:"id"`
+	Tags    []string          `json:"tags,omitempty"`
+	Labels  map[string]string `json:"labels"`
+	Created time.Time         `json:"created"`
+	Parent  *item             `json:"parent,omitempty"`
+	Limit   *int              `json:"limit"`
+	Timeout time.Duration     `json:"timeout"`
+	Extra   any               `json:"extra"`
+	secret  string
+	Skipped string `json:"-"`
+	audit
+}
+
+type audit struct {
+	CreatedBy string `json:"created_by"`
+}
+
+type itemList struct {
+	Items []item `json:"items"`
+	Count int    `json:"count"`
+}
+
+func testDocument() *Document {
+	b := NewBuilder(Info{Title: "Items", Version: "1.0.0"})
+	b.Tag("items", "Items").
+		SecurityScheme("bearer", &SecurityScheme{Type: "http", Scheme: "bearer"})
+	b.Add(fiber.MethodGet, "/items", Route{
+		ID: "listItems", Summary: "List the items", Tags: []string{"items"},
+		Query:    []Param{{Name: "tag", Description: "Only items with this tag"}, {Name: "limit", Type: 0}},
+		Response: itemList{},
+	})
+	b.Add(fiber.MethodGet, "/items/:id", Route{
+		ID: "getItem", Summary: "Get an item",
+		Response: item{},
+		Errors:   []int{fiber.StatusNotFound},
+	})
+	b.Add(fiber.MethodPut, "/items/:id", Route{
+		ID: "putItem", Summary: "Replace an item",
+		Request:  item{},
+		Response: item{},
+		Errors:   []int{fiber.StatusBadRequest},
+		Security: []string{"bearer"},
+	})
+	b.Add(fiber.MethodDelete, "/items/:id", Route{
+		ID: "deleteItem", Status: fiber.StatusNoContent, TextErrors: true, Errors: []int{fiber.StatusNotFound},
+	})
+	b.Add(fiber.MethodGet, "/items/:id/raw", Route{
+		ID: "getRawItem", Response: "",
+	})
+	return b.Document()
+}
+
+func TestBuilder(t *testing.T) {
+	doc := testDocument()
+
+	item := doc.Paths["/items/{id}"]
+	if item == nil || item.Get == nil || item.Put == nil || item.Delete == nil {
+		t.Fatalf("paths = %v, want GET, PUT, and DELETE at /items/{id}", doc.Paths)
+	}
+	if p := item.Get.Parameters; len(p) != 1 || p[0].Name != "id" || p[0].In != "path" || !p[0].Required {
+		t.Errorf("parameters = %+v, want the id path parameter", p)
+	}
+	if got := doc.Paths["/items"].Get.Parameters[1].Schema.Type; got != "integer" {
+		t.Errorf("limit type = %q, want integer", got)
+	}
+	if ref := item.Get.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/item" {
+		t.Errorf("response ref = %q", ref)
+	}
+	if ref := item.Get.Responses["404"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/Error" {
+		t.Errorf("error ref = %q", ref)
+	}
+	if _, ok := item.Delete.Responses["404"].Content["text/plain"]; !ok {
+		t.Error("text errors not documented as text/plain")
+	}
+	if resp := item.Delete.Responses["204"]; resp == nil || resp.Content != nil {
+		t.Errorf("delete response = %+v, want 204 without content", resp)
+	}
+	if len(item.Put.Security) != 1 {
+		t.Errorf("security = %v", item.Put.Security)
+	}
+
+	schema := doc.Components.Schemas["item"]
+	for name, want := range map[string]Schema{
+		"id":         {Type: "string"},
+		"created":    {Type: "string", Format: "date-time"},
+		"parent":     {Ref: "#/components/schemas/item"},
+		"limit":      {Type: "integer", Format: "int64", Nullable: true},
+		"created_by": {Type: "string"},
+	} {
+		got := schema.Properties[name]
+		if got == nil || got.Type != want.Type || got.Format != want.Format || got.Ref != want.Ref || got.Nullable != want.Nullable {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+		}
+	}
+	if got := schema.Properties["labels"].AdditionalProperties; got == nil || got.Type != "string" {
+		t.Errorf("labels = %+v, want a string map", schema.Properties["labels"])
+	}
+	for _, name := range []string{"secret", "Skipped", "audit"} {
+		if _, ok := schema.Properties[name]; ok {
+			t.Errorf("%s documented", name)
+		}
+	}
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	if !required["id"] || required["tags"] || required["parent"] || required["limit"] {
+		t.Errorf("required = %v", schema.Required)
+	}
+}
+
+type result struct {
+	Value string `json:"value"`
+}
+
+func TestBuilderNameCollision(t *testing.T) {
+	b := NewBuilder(Info{Title: "x", Version: "1"})
+	b.Add(fiber.MethodGet, "/a", Route{ID: "a", Response: result{}, Errors: []int{500}})
+	b.Add(fiber.MethodGet, "/b", Route{ID: "b", Response: fiber.Error{}})
+	doc := b.Document()
+	if _, ok := doc.Components.Schemas["Error"]; !ok {
+		t.Error("Error schema missing")
+	}
+	if _, ok := doc.Components.Schemas["FiberError"]; !ok {
+		t.Errorf("schemas = %v, want fiber.Error prefixed with its package", doc.Components.Schemas)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate operation ID accepted")
+		}
+	}()
+	b.Add(fiber.MethodGet, "/c", Route{ID: "a"})
+}
+
+func TestHandler(t *testing.T) {
+	app := fiber.New()
+	app.Get("/openapi.json", Handler(testDocument()))
+	resp, err := app.Test(httptest.NewRequest("GET", "/openapi.json", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var doc Document
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != Version || doc.Paths["/items"] == nil {
+		t.Errorf("served document = %s", body)
+	}
+}
+
+func TestGenerateClient(t *testing.T) {
+	src, err := GenerateClient(testDocument(), "itemclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "client.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check("itemclient", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("generated client does not type-check: %v\n%s", err, src)
+	}
+
+	client := pkg.Scope().Lookup("Client").Type()
+	for method, signature := range map[string]string{
+		"ListItems":  "func(ctx context.Context, params itemclient.ListItemsParams) (*itemclient.ItemList, error)",
+		"GetItem":    "func(ctx context.Context, id string) (*itemclient.Item, error)",
+		"PutItem":    "func(ctx context.Context, id string, body *itemclient.Item) (*itemclient.Item, error)",
+		"DeleteItem": "func(ctx context.Context, id string) error",
+		"GetRawItem": "func(ctx context.Context, id string) (string, error)",
+	} {
+		obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(client), false, pkg, method)
+		if obj == nil {
+			t.Errorf("%s missing", method)
+			continue
+		}
+		if got := obj.Type().String(); got != signature {
+			t.Errorf("%s = %s, want %s", method, got, signature)
+		}
+	}
+}
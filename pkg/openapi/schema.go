@@ -0,0 +1,235 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemas builds JSON schemas from Go types the way encoding/json encodes
+// them, collecting named structs as components
+type schemas struct {
+	components map[string]*Schema
+	// names maps each component type to its name; two types with the same
+	// name in different packages are told apart by a package prefix
+	names map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+// of returns the schema of the value v encodes as
+func (s *schemas) of(v any) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemas) schema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		schema := s.schema(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "duration in nanoseconds"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// Custom encodings can be anything
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	default:
+		// Interfaces hold any value
+		return &Schema{}
+	}
+}
+
+// ref returns a reference to the component of a named struct, building the
+// component the first time the type is seen
+func (s *schemas) ref(t reflect.Type) *Schema {
+	if name, ok := s.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	name := componentName(t)
+	if _, taken := s.components[name]; taken {
+		name = exportedName(packageName(t.PkgPath())) + name
+	}
+	s.names[t] = name
+	// Reserve the name first so recursive types refer back to it
+	s.components[name] = &Schema{}
+	*s.components[name] = *s.object(t)
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// object returns the inline schema of a struct
+func (s *schemas) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.fields(t, schema)
+	return schema
+}
+
+func (s *schemas) fields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Untagged embedded structs are flattened into the parent
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(ft, schema)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		field := s.schema(f.Type)
+		if strings.Contains(opts, "string") && field.Ref == "" && field.Type != "object" && field.Type != "array" {
+			field = &Schema{Type: "string"}
+		}
+		schema.Properties[name] = field
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// packageName returns the last element of an import path that is not a
+// major version suffix such as v2
+func packageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	return name
+}
+
+// componentName returns a component name for a named type; generic type
+// arguments are folded into the name
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		args := name[i+1 : len(name)-1]
+		name = name[:i]
+		for _, arg := range strings.Split(args, ",") {
+			arg = arg[strings.LastIndexAny(arg, "./*")+1:]
+			name += exportedName(arg)
+		}
+	}
+	return name
+}
+
+// initialisms are spelled in upper case in Go identifiers
+var initialisms = [][2]string{
+	{"Ids", "IDs"}, {"Id", "ID"}, {"Url", "URL"}, {"Api", "API"}, {"Http", "HTTP"},
+	{"Json", "JSON"}, {"Ms", "MS"}, {"Cpu", "CPU"},
+}
+
+// exportedName returns s as an exported Go identifier, joining the words
+// separated by underscores, dashes, dots, and spaces
+func exportedName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	for _, initialism := range initialisms {
+		name = replaceWord(name, initialism[0], initialism[1])
+	}
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+// replaceWord replaces word where it ends name or precedes an upper case
+// letter, so "ClientId" becomes "ClientID" but "Identity" is left alone
+func replaceWord(name, word, with string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(name, word)
+		if i < 0 {
+			b.WriteString(name)
+			return b.String()
+		}
+		end := i + len(word)
+		b.WriteString(name[:i])
+		if end == len(name) || unicode.IsUpper(rune(name[end])) || unicode.IsDigit(rune(name[end])) {
+			b.WriteString(with)
+		} else {
+			b.WriteString(word)
+		}
+		name = name[end:]
+	}
+}
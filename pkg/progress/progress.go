@@ -0,0 +1,244 @@
+// Package progress reports the progress of long-running CLI operations such
+// as drift detection, monitoring setup, and multi-region scans. A Tracker
+// emits step events with completion and ETA to a Sink, which renders them as
+// a terminal spinner or as JSON lines for wrapping tools, and runs registered
+// cleanups when the operation is canceled part way through.
+package progress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of progress event
+type EventType string
+
+const (
+	EventStart    EventType = "start"
+	EventStep     EventType = "step"
+	EventProgress EventType = "progress"
+	EventMessage  EventType = "message"
+	EventCleanup  EventType = "cleanup"
+	EventDone     EventType = "done"
+	EventFailed   EventType = "failed"
+	EventCanceled EventType = "canceled"
+)
+
+// Final reports whether the event ends the operation
+func (t EventType) Final() bool {
+	return t == EventDone || t == EventFailed || t == EventCanceled
+}
+
+// Event is a single progress update
+type Event struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Type      EventType `json:"type"`
+	Step      string    `json:"step,omitempty"`
+	Completed int       `json:"completed"`
+	Total     int       `json:"total,omitempty"`
+	Percent   float64   `json:"percent,omitempty"`
+	Elapsed   float64   `json:"elapsed_seconds"`
+	ETA       float64   `json:"eta_seconds,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Sink receives progress events
+type Sink interface {
+	Emit(Event)
+}
+
+// cleanupTimeout bounds cleanups run after cancellation
+const cleanupTimeout = time.Minute
+
+type cleanup struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// Tracker tracks one operation. All methods are safe for concurrent use and
+// are no-ops on a nil Tracker, so library code can report progress through
+// FromContext without checking whether anyone is listening.
+type Tracker struct {
+	mu        sync.Mutex
+	operation string
+	total     int
+	completed int
+	step      string
+	started   time.Time
+	sink      Sink
+	cleanups  []cleanup
+	finished  bool
+
+	now func() time.Time
+}
+
+// New starts tracking an operation of total units; total may be zero when
+// the amount of work is not known up front
+func New(operation string, total int, sink Sink) *Tracker {
+	t := &Tracker{
+		operation: operation,
+		total:     total,
+		sink:      sink,
+		now:       time.Now,
+	}
+	t.started = t.now()
+	t.emit(Event{Type: EventStart})
+	return t
+}
+
+// AddTotal adds units of work discovered while running, e.g. regions once
+// they are listed
+func (t *Tracker) AddTotal(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.total += n
+	t.mu.Unlock()
+}
+
+// Step reports that a named step has started
+func (t *Tracker) Step(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.step = name
+	t.mu.Unlock()
+	t.emit(Event{Type: EventStep})
+}
+
+// Advance marks n units of work as completed
+func (t *Tracker) Advance(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.completed += n
+	t.mu.Unlock()
+	t.emit(Event{Type: EventProgress})
+}
+
+// Message reports status within the current step, e.g. a polled state
+func (t *Tracker) Message(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.emit(Event{Type: EventMessage, Message: fmt.Sprintf(format, args...)})
+}
+
+// OnCancel registers a cleanup for a resource created by the operation. If
+// the operation is canceled, cleanups run in reverse order of registration.
+func (t *Tracker) OnCancel(name string, fn func(context.Context) error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.cleanups = append(t.cleanups, cleanup{name: name, fn: fn})
+	t.mu.Unlock()
+}
+
+// Finish ends the operation with its result. When err is a cancellation or
+// deadline, registered cleanups run and their failures are joined to err.
+func (t *Tracker) Finish(err error) error {
+	if t == nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.finished {
+		t.mu.Unlock()
+		return err
+	}
+	t.finished = true
+	cleanups := t.cleanups
+	t.cleanups = nil
+	t.mu.Unlock()
+
+	switch {
+	case err == nil:
+		t.emit(Event{Type: EventDone})
+		return nil
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// The operation context is done, so clean up with a fresh one
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+
+		errs := []error{err}
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			c := cleanups[i]
+			event := Event{Type: EventCleanup, Message: c.name}
+			if cerr := c.fn(ctx); cerr != nil {
+				event.Error = cerr.Error()
+				errs = append(errs, fmt.Errorf("cleanup %s: %w", c.name, cerr))
+			}
+			t.emit(event)
+		}
+		t.emit(Event{Type: EventCanceled, Error: err.Error()})
+		return errors.Join(errs...)
+	default:
+		t.emit(Event{Type: EventFailed, Error: err.Error()})
+		return err
+	}
+}
+
+// ETA estimates the remaining time from the average time per completed unit
+func (t *Tracker) ETA() time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.eta(t.now())
+}
+
+func (t *Tracker) eta(now time.Time) time.Duration {
+	if t.completed == 0 || t.total <= t.completed {
+		return 0
+	}
+	perUnit := now.Sub(t.started) / time.Duration(t.completed)
+	return perUnit * time.Duration(t.total-t.completed)
+}
+
+// emit fills the tracker state into the event and sends it to the sink
+func (t *Tracker) emit(e Event) {
+	if t.sink == nil {
+		return
+	}
+
+	t.mu.Lock()
+	now := t.now()
+	e.Time = now
+	e.Operation = t.operation
+	if e.Step == "" {
+		e.Step = t.step
+	}
+	e.Completed = t.completed
+	e.Total = t.total
+	if t.total > 0 {
+		e.Percent = float64(t.completed) * 100 / float64(t.total)
+	}
+	e.Elapsed = now.Sub(t.started).Seconds()
+	e.ETA = t.eta(now).Seconds()
+	t.mu.Unlock()
+
+	t.sink.Emit(e)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tracker
+func NewContext(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the context's tracker, or nil when there is none
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKey{}).(*Tracker)
+	return t
+}
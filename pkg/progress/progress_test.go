@@ -0,0 +1,132 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []Event
+}
+
+func (s *recordingSink) Emit(e Event) { s.events = append(s.events, e) }
+
+func TestTrackerETA(t *testing.T) {
+	sink := &recordingSink{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tracker := New("scan", 0, sink)
+	tracker.now = func() time.Time { return now }
+	tracker.started = now
+	tracker.AddTotal(4)
+
+	tracker.Step("us-east-1")
+	now = now.Add(10 * time.Second)
+	tracker.Advance(1)
+
+	last := sink.events[len(sink.events)-1]
+	if last.Type != EventProgress || last.Step != "us-east-1" || last.Percent != 25 {
+		t.Errorf("unexpected progress event %+v", last)
+	}
+	if tracker.ETA() != 30*time.Second || last.ETA != 30 {
+		t.Errorf("expected a 30s ETA, got %s", tracker.ETA())
+	}
+
+	if err := tracker.Finish(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if final := sink.events[len(sink.events)-1]; final.Type != EventDone {
+		t.Errorf("expected a done event, got %+v", final)
+	}
+}
+
+func TestTrackerCancelCleanup(t *testing.T) {
+	sink := &recordingSink{}
+	tracker := New("setup", 2, sink)
+
+	var order []string
+	tracker.OnCancel("dashboard", func(ctx context.Context) error {
+		if ctx.Err() != nil {
+			t.Error("expected cleanup to run with a live context")
+		}
+		order = append(order, "dashboard")
+		return nil
+	})
+	tracker.OnCancel("alarm", func(context.Context) error {
+		order = append(order, "alarm")
+		return errors.New("access denied")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := tracker.Finish(ctx.Err())
+
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "cleanup alarm: access denied") {
+		t.Errorf("expected cancellation and cleanup failure, got %v", err)
+	}
+	if strings.Join(order, ",") != "alarm,dashboard" {
+		t.Errorf("expected cleanups in reverse order, got %v", order)
+	}
+	if final := sink.events[len(sink.events)-1]; final.Type != EventCanceled {
+		t.Errorf("expected a canceled event, got %+v", final)
+	}
+
+	// Cleanups only run for cancellation
+	ran := false
+	failed := New("setup", 1, sink)
+	failed.OnCancel("dashboard", func(context.Context) error { ran = true; return nil })
+	failed.Finish(errors.New("quota exceeded"))
+	if ran {
+		t.Error("expected cleanups to be skipped for ordinary failures")
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	tracker := FromContext(context.Background())
+	tracker.AddTotal(1)
+	tracker.Step("noop")
+	tracker.Advance(1)
+	tracker.Message("ignored")
+	tracker.OnCancel("noop", func(context.Context) error { return nil })
+	if err := tracker.Finish(context.Canceled); err != context.Canceled {
+		t.Errorf("expected the error to pass through, got %v", err)
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	tracker := New("drift", 1, NewJSONSink(&buf))
+	tracker.Step("loki")
+	tracker.Finish(errors.New("connection refused"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 events, got %d: %s", len(lines), buf.String())
+	}
+
+	var e Event
+	if err := json.Unmarshal([]byte(lines[2]), &e); err != nil {
+		t.Fatalf("invalid JSON event: %v", err)
+	}
+	if e.Type != EventFailed || e.Operation != "drift" || e.Step != "loki" || e.Error != "connection refused" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestTerminalSinkPlain(t *testing.T) {
+	var buf bytes.Buffer
+	tracker := New("provision", 2, NewTerminalSink(&buf))
+	tracker.Step("acme")
+	tracker.Advance(1)
+	tracker.Finish(nil)
+
+	out := buf.String()
+	if !strings.Contains(out, "provision: acme [0/2 0%]") || !strings.Contains(out, "✓ provision completed") {
+		t.Errorf("unexpected output %q", out)
+	}
+}
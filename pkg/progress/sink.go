@@ -0,0 +1,186 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// JSONSink writes one JSON event per line for tools wrapping the CLI
+type JSONSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSink creates a JSON lines sink
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// Emit implements Sink
+func (s *JSONSink) Emit(e Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(data, '\n'))
+}
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// TerminalSink renders progress for people. On a terminal it redraws a
+// spinner line with the current step, percentage, and ETA; otherwise it
+// prints one line per step so logs stay readable.
+type TerminalSink struct {
+	mu          sync.Mutex
+	w           io.Writer
+	interactive bool
+	last        Event
+	frame       int
+	stop        chan struct{}
+
+	successStyle lipgloss.Style
+	errorStyle   lipgloss.Style
+	warningStyle lipgloss.Style
+}
+
+// NewTerminalSink creates a sink for w, animating when w is a terminal
+func NewTerminalSink(w io.Writer) *TerminalSink {
+	return &TerminalSink{
+		w:            w,
+		interactive:  isTerminal(w),
+		successStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+		errorStyle:   lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+		warningStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	}
+}
+
+// Emit implements Sink
+func (s *TerminalSink) Emit(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.last
+	s.last = e
+
+	if e.Type.Final() {
+		if s.stop != nil {
+			close(s.stop)
+			s.stop = nil
+		}
+		s.clearLine()
+		fmt.Fprintln(s.w, s.finalLine(e))
+		return
+	}
+
+	if e.Type == EventCleanup {
+		s.clearLine()
+		line := "↺ cleaned up " + e.Message
+		if e.Error != "" {
+			line = s.errorStyle.Render("✗ cleanup of " + e.Message + " failed: " + e.Error)
+		}
+		fmt.Fprintln(s.w, line)
+		return
+	}
+
+	if s.interactive {
+		if s.stop == nil {
+			s.stop = make(chan struct{})
+			go s.spin(s.stop)
+		}
+		s.redraw()
+		return
+	}
+
+	// Without a terminal only print steps and messages as they change
+	if e.Type == EventStep && e.Step != previous.Step || e.Type == EventMessage && e.Message != previous.Message {
+		fmt.Fprintln(s.w, s.statusLine(e))
+	}
+}
+
+// spin advances the spinner until stop is closed
+func (s *TerminalSink) spin(stop chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.stop == stop {
+				s.frame = (s.frame + 1) % len(spinnerFrames)
+				s.redraw()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *TerminalSink) redraw() {
+	s.clearLine()
+	fmt.Fprint(s.w, spinnerFrames[s.frame]+" "+s.statusLine(s.last))
+}
+
+func (s *TerminalSink) clearLine() {
+	if s.interactive {
+		fmt.Fprint(s.w, "\r\033[K")
+	}
+}
+
+// statusLine formats the operation, step, completion, and ETA
+func (s *TerminalSink) statusLine(e Event) string {
+	parts := []string{e.Operation}
+	if e.Step != "" {
+		parts[0] += ": " + e.Step
+	}
+	if e.Total > 0 {
+		parts = append(parts, fmt.Sprintf("[%d/%d %.0f%%]", e.Completed, e.Total, e.Percent))
+	}
+	if e.ETA > 0 {
+		parts = append(parts, "ETA "+formatSeconds(e.ETA))
+	}
+	if e.Message != "" {
+		parts = append(parts, "- "+e.Message)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *TerminalSink) finalLine(e Event) string {
+	elapsed := formatSeconds(e.Elapsed)
+	switch e.Type {
+	case EventFailed:
+		return s.errorStyle.Render(fmt.Sprintf("✗ %s failed after %s: %s", e.Operation, elapsed, e.Error))
+	case EventCanceled:
+		return s.warningStyle.Render(fmt.Sprintf("⚠ %s canceled after %s", e.Operation, elapsed))
+	default:
+		return s.successStyle.Render(fmt.Sprintf("✓ %s completed in %s", e.Operation, elapsed))
+	}
+}
+
+// formatSeconds rounds a duration in seconds for display
+func formatSeconds(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// isTerminal reports whether w is a character device
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
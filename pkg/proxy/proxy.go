@@ -0,0 +1,415 @@
+// Package proxy provides proxy and custom dialer configuration shared by the
+// telemetry exporters and cloud provider clients.
+//
+// By default the standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment
+// variables are honoured. A Config can override them per exporter or per
+// provider, route traffic through a SOCKS5 proxy, or trust additional CA
+// certificates for corporate TLS interception.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/netaddr"
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
+	"golang.org/x/net/http/httpproxy"
+	xproxy "golang.org/x/net/proxy"
+)
+
+// DialContextFunc dials a network connection
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Config holds proxy and dialer settings for outbound connections
+type Config struct {
+	// URL is an http://, https://, socks5://, or socks5h:// proxy URL.
+	// When empty, the proxy is taken from the environment unless
+	// DisableEnvironment is set.
+	URL string `json:"url,omitempty" yaml:"url,omitempty" mapstructure:"url"`
+
+	// NoProxy lists hosts, domains, or CIDRs that bypass the proxy, in
+	// addition to NO_PROXY when the environment is used.
+	NoProxy []string `json:"no_proxy,omitempty" yaml:"no_proxy,omitempty" mapstructure:"no_proxy"`
+
+	// CAFile is a PEM bundle appended to the system roots, typically the
+	// certificate of a TLS intercepting proxy.
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty" mapstructure:"ca_file"`
+
+	// DisableEnvironment ignores HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+	DisableEnvironment bool `json:"disable_environment,omitempty" yaml:"disable_environment,omitempty" mapstructure:"disable_environment"`
+
+	// DialTimeout bounds connection establishment, including the proxy handshake.
+	DialTimeout time.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty" mapstructure:"dial_timeout"`
+
+	// Dialer replaces the network dialer entirely when set.
+	Dialer DialContextFunc `json:"-" yaml:"-" mapstructure:"-"`
+
+	// TLS overrides the TLS policy for connections made with this Config.
+	// Nil uses tlspolicy.DefaultPolicy, which honours APM_FIPS_MODE.
+	TLS *tlspolicy.Policy `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls"`
+}
+
+// FromEnvironment returns a Config that only honours the proxy environment variables
+func FromEnvironment() *Config {
+	return &Config{}
+}
+
+// IsSOCKS reports whether the configured proxy is a SOCKS5 proxy
+func (c *Config) IsSOCKS() bool {
+	if c == nil || c.URL == "" {
+		return false
+	}
+	u, err := url.Parse(c.URL)
+	return err == nil && (u.Scheme == "socks5" || u.Scheme == "socks5h")
+}
+
+// Validate checks that the configuration can be used
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("proxy URL %q has no host", c.URL)
+		}
+	}
+
+	if c.CAFile != "" {
+		if _, err := os.Stat(c.CAFile); err != nil {
+			return fmt.Errorf("proxy CA file: %w", err)
+		}
+	}
+
+	if c.TLS != nil {
+		if err := c.TLS.Validate(); err != nil {
+			return fmt.Errorf("TLS policy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// httpProxyConfig builds the x/net proxy configuration used to resolve proxies per request
+func (c *Config) httpProxyConfig() *httpproxy.Config {
+	cfg := &httpproxy.Config{}
+	if c == nil || !c.DisableEnvironment {
+		cfg = httpproxy.FromEnvironment()
+	}
+	if c == nil {
+		return cfg
+	}
+
+	if c.URL != "" && !c.IsSOCKS() {
+		cfg.HTTPProxy = c.URL
+		cfg.HTTPSProxy = c.URL
+	}
+	if len(c.NoProxy) > 0 {
+		noProxy := strings.Join(c.NoProxy, ",")
+		if cfg.NoProxy != "" {
+			noProxy = cfg.NoProxy + "," + noProxy
+		}
+		cfg.NoProxy = noProxy
+	}
+
+	return cfg
+}
+
+// ProxyFunc returns a function suitable for http.Transport.Proxy.
+// SOCKS5 proxies are applied at the dialer level instead, so ProxyFunc
+// returns no proxy for them.
+func (c *Config) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if c.IsSOCKS() {
+		return func(*http.Request) (*url.URL, error) { return nil, nil }
+	}
+	resolve := c.httpProxyConfig().ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return resolve(req.URL)
+	}
+}
+
+// ProxyForURL returns the proxy that would be used for the target URL, or nil for a direct connection
+func (c *Config) ProxyForURL(target *url.URL) (*url.URL, error) {
+	if c.IsSOCKS() {
+		if c.bypass(target.Hostname()) {
+			return nil, nil
+		}
+		return url.Parse(c.URL)
+	}
+	return c.httpProxyConfig().ProxyFunc()(target)
+}
+
+// bypass reports whether the host matches the NoProxy configuration
+func (c *Config) bypass(host string) bool {
+	// httpproxy only matches NO_PROXY for HTTP proxies, so a sentinel HTTP
+	// proxy is used to evaluate the rules for SOCKS and raw dialers.
+	cfg := c.httpProxyConfig()
+	cfg.HTTPProxy = "http://bypass-check"
+	proxyURL, err := cfg.ProxyFunc()(&url.URL{Scheme: "http", Host: host})
+	return err == nil && proxyURL == nil
+}
+
+// baseDialer returns the dialer used for direct connections
+func (c *Config) baseDialer() DialContextFunc {
+	if c != nil && c.Dialer != nil {
+		return c.Dialer
+	}
+	timeout := 30 * time.Second
+	if c != nil && c.DialTimeout > 0 {
+		timeout = c.DialTimeout
+	}
+	// Dual-stack hosts are raced over IPv6 and IPv4
+	return netaddr.Dialer(timeout).DialContext
+}
+
+// DialContext returns a dialer that tunnels raw TCP connections through the
+// configured proxy. HTTP proxies are traversed with CONNECT, which is what
+// gRPC exporters need since they cannot use http.Transport.Proxy.
+func (c *Config) DialContext() DialContextFunc {
+	base := c.baseDialer()
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		proxyURL, err := c.ProxyForURL(&url.URL{Scheme: "https", Host: addr})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL == nil || c.bypass(host) {
+			return base(ctx, network, addr)
+		}
+
+		switch proxyURL.Scheme {
+		case "socks5", "socks5h":
+			return dialSOCKS5(ctx, proxyURL, base, network, addr)
+		default:
+			return dialConnect(ctx, proxyURL, base, addr)
+		}
+	}
+}
+
+// forwardDialer adapts a DialContextFunc to the x/net proxy interfaces
+type forwardDialer struct {
+	dial DialContextFunc
+}
+
+func (f forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return f.dial(context.Background(), network, addr)
+}
+
+func (f forwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f.dial(ctx, network, addr)
+}
+
+// dialSOCKS5 connects to addr through a SOCKS5 proxy
+func dialSOCKS5(ctx context.Context, proxyURL *url.URL, base DialContextFunc, network, addr string) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &xproxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	dialer, err := xproxy.SOCKS5("tcp", proxyURL.Host, auth, forwardDialer{dial: base})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+
+	if cd, ok := dialer.(xproxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return dialer.Dial(network, addr)
+}
+
+// dialConnect establishes a tunnel to addr through an HTTP proxy using CONNECT
+func dialConnect(ctx context.Context, proxyURL *url.URL, base DialContextFunc, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+
+	conn, err := base(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", addr, resp.Status)
+	}
+
+	return conn, nil
+}
+
+// TLSConfig returns a TLS configuration trusting the system roots plus CAFile
+// and enforcing the TLS policy. The base configuration is cloned and may be nil.
+func (c *Config) TLSConfig(base *tls.Config) (*tls.Config, error) {
+	policy := tlspolicy.DefaultPolicy()
+	if c != nil && c.TLS != nil {
+		policy = *c.TLS
+	}
+
+	cfg, err := policy.Apply(base)
+	if err != nil {
+		return nil, err
+	}
+
+	if c == nil || c.CAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy CA file: %w", err)
+	}
+
+	pool := cfg.RootCAs
+	if pool == nil {
+		pool, err = x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+	}
+	cfg.RootCAs = pool
+
+	return cfg, nil
+}
+
+// Transport returns an http.Transport honouring the proxy, dialer, and CA settings
+func (c *Config) Transport() (*http.Transport, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.TLSConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = c.ProxyFunc()
+	transport.TLSClientConfig = tlsConfig
+
+	if c.IsSOCKS() {
+		transport.DialContext = c.DialContext()
+	} else {
+		transport.DialContext = c.baseDialer()
+	}
+
+	return transport, nil
+}
+
+// HTTPClient returns an http.Client using Transport with the given timeout
+func (c *Config) HTTPClient(timeout time.Duration) (*http.Client, error) {
+	transport, err := c.Transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// CommandEnv returns environment variables that make external CLIs (aws,
+// az, gcloud, kubectl) use the same proxy and CA settings. The result is
+// meant to be appended to an exec.Cmd environment. Unlike TLSConfig, the
+// CLIs replace their trust store with CAFile, so it should be a full bundle.
+func (c *Config) CommandEnv() []string {
+	if c == nil {
+		return nil
+	}
+
+	var env []string
+	if c.URL != "" {
+		for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy", "ALL_PROXY"} {
+			env = append(env, key+"="+c.URL)
+		}
+		if u, err := url.Parse(c.URL); err == nil && !c.IsSOCKS() {
+			env = append(env,
+				"CLOUDSDK_PROXY_TYPE=http",
+				"CLOUDSDK_PROXY_ADDRESS="+u.Hostname(),
+				"CLOUDSDK_PROXY_PORT="+u.Port(),
+			)
+		}
+	}
+	if len(c.NoProxy) > 0 {
+		noProxy := strings.Join(c.NoProxy, ",")
+		if existing := os.Getenv("NO_PROXY"); existing != "" && !c.DisableEnvironment {
+			noProxy = existing + "," + noProxy
+		}
+		env = append(env, "NO_PROXY="+noProxy, "no_proxy="+noProxy)
+	}
+	if c.CAFile != "" {
+		env = append(env,
+			"AWS_CA_BUNDLE="+c.CAFile,
+			"REQUESTS_CA_BUNDLE="+c.CAFile,
+			"CLOUDSDK_CORE_CUSTOM_CA_CERTS_FILE="+c.CAFile,
+		)
+	}
+
+	return env
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// startConnectProxy starts a minimal HTTP CONNECT proxy and returns its address
+func startConnectProxy(t *testing.T, tunnels chan<- string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer upstream.Close()
+				tunnels <- req.Host
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestDialConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err == nil {
+			io.Copy(conn, conn)
+			conn.Close()
+		}
+	}()
+
+	tunnels := make(chan string, 1)
+	proxyAddr := startConnectProxy(t, tunnels)
+
+	// Loopback targets are never proxied by DialContext, so the tunnel is
+	// exercised directly.
+	proxyURL, _ := url.Parse("http://" + proxyAddr)
+	conn, err := dialConnect(context.Background(), proxyURL, (&Config{}).baseDialer(), echo.Addr().String())
+	if err != nil {
+		t.Fatalf("dial through proxy failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := <-tunnels; got != echo.Addr().String() {
+		t.Errorf("expected tunnel to %s, got %s", echo.Addr(), got)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("expected echo through tunnel, got %q (%v)", buf, err)
+	}
+}
+
+func TestProxyForURLHonoursNoProxy(t *testing.T) {
+	cfg := &Config{
+		URL:                "socks5://proxy.corp:1080",
+		NoProxy:            []string{".internal", "10.0.0.0/8"},
+		DisableEnvironment: true,
+	}
+
+	tests := []struct {
+		host   string
+		direct bool
+	}{
+		{"collector.internal:4317", true},
+		{"10.1.2.3:4317", true},
+		{"otlp.example.com:443", false},
+	}
+
+	for _, tt := range tests {
+		proxyURL, err := cfg.ProxyForURL(&url.URL{Scheme: "https", Host: tt.host})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.host, err)
+		}
+		if (proxyURL == nil) != tt.direct {
+			t.Errorf("%s: expected direct=%v, got proxy %v", tt.host, tt.direct, proxyURL)
+		}
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	cfg := &Config{URL: "http://proxy.corp:3128", NoProxy: []string{"localhost"}, DisableEnvironment: true}
+
+	env := make(map[string]string)
+	for _, kv := range cfg.CommandEnv() {
+		for i := 0; i < len(kv); i++ {
+			if kv[i] == '=' {
+				env[kv[:i]] = kv[i+1:]
+				break
+			}
+		}
+	}
+
+	if env["HTTPS_PROXY"] != "http://proxy.corp:3128" {
+		t.Errorf("expected HTTPS_PROXY to be set, got %q", env["HTTPS_PROXY"])
+	}
+	if env["NO_PROXY"] != "localhost" {
+		t.Errorf("expected NO_PROXY=localhost, got %q", env["NO_PROXY"])
+	}
+	if env["CLOUDSDK_PROXY_PORT"] != "3128" {
+		t.Errorf("expected gcloud proxy port 3128, got %q", env["CLOUDSDK_PROXY_PORT"])
+	}
+}
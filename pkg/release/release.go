@@ -0,0 +1,107 @@
+// Package release scores the health of each deployed version of a service,
+// a backend analogue of a crash-free rate. A release is scored over its bake
+// window on error rate, saturation, latency against the release before it,
+// and alert volume, so releases can be compared objectively.
+package release
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/webhook"
+)
+
+// ErrNotFound is returned for a version that was never deployed
+var ErrNotFound = errors.New("release not found")
+
+// Release is a version of a service deployed at a time
+type Release struct {
+	Service     string    `json:"service"`
+	Version     string    `json:"version"`
+	Image       string    `json:"image,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	DeployedAt  time.Time `json:"deployed_at"`
+
+	// ReplacedAt is when the next release was deployed, if any
+	ReplacedAt *time.Time `json:"replaced_at,omitempty"`
+}
+
+// Releases returns the successful deploys of a service recorded in the
+// incident timeline, oldest first. Deploys arrive as deploy.finished events,
+// e.g. from apm deploy; the version is taken from the event's version or the
+// tag of its image.
+func Releases(timeline incident.Timeline, service string) ([]Release, error) {
+	entries, err := timeline.Entries(incident.Filter{Type: incident.EntryDeploy})
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []Release
+	for _, e := range entries {
+		if e.Subject != service || str(e.Data, "event") != string(webhook.EventDeployFinished) || str(e.Data, "status") != "succeeded" {
+			continue
+		}
+		r := Release{
+			Service:     service,
+			Version:     str(e.Data, "version"),
+			Image:       str(e.Data, "image"),
+			Environment: str(e.Data, "environment"),
+			DeployedAt:  e.Time,
+		}
+		if r.Version == "" {
+			r.Version = imageTag(r.Image)
+		}
+		if r.Version == "" {
+			r.Version = e.Time.UTC().Format("20060102-150405")
+		}
+		releases = append(releases, r)
+	}
+	sort.SliceStable(releases, func(i, j int) bool { return releases[i].DeployedAt.Before(releases[j].DeployedAt) })
+
+	// Redeploying the running version continues its release
+	var out []Release
+	for _, r := range releases {
+		if n := len(out); n > 0 && out[n-1].Version == r.Version {
+			continue
+		}
+		out = append(out, r)
+	}
+	for i := 0; i+1 < len(out); i++ {
+		t := out[i+1].DeployedAt
+		out[i].ReplacedAt = &t
+	}
+	return out, nil
+}
+
+// Find returns the release of a version and the release before it
+func Find(releases []Release, version string) (current, previous *Release, err error) {
+	for i := len(releases) - 1; i >= 0; i-- {
+		if releases[i].Version == version {
+			if i > 0 {
+				previous = &releases[i-1]
+			}
+			return &releases[i], previous, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, version)
+}
+
+// imageTag returns the tag of an image reference
+func imageTag(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return image[i+1:]
+}
+
+func str(data map[string]interface{}, key string) string {
+	if v, ok := data[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
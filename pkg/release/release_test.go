@@ -0,0 +1,158 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/deployment"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func deploy(timeline incident.Timeline, at time.Time, data map[string]interface{}) {
+	data["event"] = "deploy.finished"
+	if _, ok := data["status"]; !ok {
+		data["status"] = "succeeded"
+	}
+	timeline.Append(incident.Entry{Time: at, Type: incident.EntryDeploy, Subject: "shop", Data: data})
+}
+
+func testTimeline() *incident.MemoryTimeline {
+	timeline := &incident.MemoryTimeline{}
+	deploy(timeline, t0, map[string]interface{}{"version": "v1"})
+	deploy(timeline, t0.Add(2*time.Hour), map[string]interface{}{"image": "registry:5000/shop:v2"})
+	deploy(timeline, t0.Add(150*time.Minute), map[string]interface{}{"image": "registry:5000/shop:v2"})
+	deploy(timeline, t0.Add(3*time.Hour), map[string]interface{}{"version": "v3", "status": "failed"})
+	timeline.Append(incident.Entry{Time: t0, Type: incident.EntryDeploy, Subject: "cart",
+		Data: map[string]interface{}{"event": "deploy.finished", "status": "succeeded", "version": "c1"}})
+	return timeline
+}
+
+func TestReleases(t *testing.T) {
+	releases, err := Releases(testTimeline(), "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("expected 2 releases, got %+v", releases)
+	}
+	if releases[0].Version != "v1" || releases[1].Version != "v2" {
+		t.Errorf("unexpected versions %s, %s", releases[0].Version, releases[1].Version)
+	}
+	if releases[0].ReplacedAt == nil || !releases[0].ReplacedAt.Equal(t0.Add(2*time.Hour)) {
+		t.Errorf("expected v1 to be replaced by v2, got %v", releases[0].ReplacedAt)
+	}
+	if releases[1].ReplacedAt != nil {
+		t.Errorf("expected v2 to be current")
+	}
+
+	current, previous, err := Find(releases, "v2")
+	if err != nil || current.Version != "v2" || previous.Version != "v1" {
+		t.Errorf("unexpected find result %v %v %v", current, previous, err)
+	}
+	if _, _, err := Find(releases, "v3"); err == nil {
+		t.Errorf("expected failed deploys not to be releases")
+	}
+}
+
+// fakePrometheus answers the scorer's queries with before for the window
+// ending at the deploy of v2 and after for the window after it
+func fakePrometheus(t *testing.T, before, after map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := after
+		if r.URL.Query().Get("time") == fmt.Sprint(t0.Add(2*time.Hour).Unix()) {
+			values = before
+		}
+		query := r.URL.Query().Get("query")
+		var kind string
+		switch {
+		case strings.Contains(query, "histogram_quantile"):
+			kind = "latency"
+		case strings.Contains(query, "max_over_time"):
+			kind = "saturation"
+		case strings.Contains(query, "ALERTS"):
+			kind = "alerts"
+		case strings.Contains(query, `status=~"5.."`):
+			kind = "errors"
+		default:
+			kind = "requests"
+		}
+		result := "[]"
+		if v, ok := values[kind]; ok {
+			result = fmt.Sprintf(`[{"metric":{},"value":[0,%q]}]`, v)
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+}
+
+func TestScore(t *testing.T) {
+	baseline := map[string]string{"requests": "10000", "errors": "10", "latency": "0.2", "saturation": "0.4", "alerts": "0"}
+
+	tests := []struct {
+		name        string
+		current     map[string]string
+		now         time.Duration
+		status      string
+		regressions int
+	}{
+		{"healthy", map[string]string{"requests": "10000", "errors": "12", "latency": "0.21", "saturation": "0.45", "alerts": "0"}, 4 * time.Hour, StatusHealthy, 0},
+		{"latency regression", map[string]string{"requests": "10000", "errors": "300", "latency": "0.5", "saturation": "0.95", "alerts": "2"}, 4 * time.Hour, StatusUnhealthy, 3},
+		{"no traffic", map[string]string{}, 4 * time.Hour, StatusNoData, 0},
+		{"pending", baseline, 2*time.Hour + time.Minute, StatusPending, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakePrometheus(t, baseline, tt.current)
+			defer server.Close()
+			now := t0.Add(tt.now)
+			scorer := &Scorer{PrometheusURL: server.URL, Timeline: testTimeline(), Now: func() time.Time { return now }}
+
+			h, err := scorer.Version(context.Background(), "shop", "v2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Status != tt.status {
+				t.Errorf("expected %s, got %s (score %d, %+v)", tt.status, h.Status, h.Score, h.Components)
+			}
+			if len(h.Regressions) != tt.regressions {
+				t.Errorf("expected %d regressions, got %v", tt.regressions, h.Regressions)
+			}
+			if h.Previous != "v1" {
+				t.Errorf("expected v1 as the previous release, got %q", h.Previous)
+			}
+			if tt.status == StatusHealthy {
+				if !h.End.Equal(t0.Add(3 * time.Hour)) {
+					t.Errorf("expected the window to end an hour after the deploy, got %v", h.End)
+				}
+				if got := testutil.ToFloat64(scorer.gauge().WithLabelValues("shop", "v2")); got != float64(h.Score) {
+					t.Errorf("expected release_health_score %d, got %v", h.Score, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDeploymentCheck(t *testing.T) {
+	server := fakePrometheus(t, nil, map[string]string{"requests": "1000", "errors": "200"})
+	defer server.Close()
+	scorer := &Scorer{PrometheusURL: server.URL, Timeline: testTimeline(), Now: func() time.Time { return t0.Add(4 * time.Hour) }}
+
+	check, err := scorer.DeploymentCheck()(&deployment.Deployment{Name: "shop", Version: "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Status != deployment.HealthStatusUnhealthy || check.Type != deployment.HealthCheckCustom {
+		t.Errorf("expected an unhealthy custom check, got %+v", check)
+	}
+
+	if _, err := scorer.DeploymentCheck()(&deployment.Deployment{Name: "shop", Version: "v9"}); err == nil {
+		t.Errorf("expected an error for an unknown release")
+	}
+}
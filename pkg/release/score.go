@@ -0,0 +1,411 @@
+package release
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults
+const (
+	DefaultWindow       = time.Hour
+	DefaultMinWindow    = 5 * time.Minute
+	DefaultServiceLabel = "job"
+
+	// DefaultSaturationQuery is the CPU used as a share of limits by the
+	// service's containers; $service is replaced with the service name
+	DefaultSaturationQuery = `sum(rate(container_cpu_usage_seconds_total{container="$service"}[2m])) / sum(kube_pod_container_resource_limits{container="$service", resource="cpu"})`
+)
+
+// Status of a release
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+	StatusPending   = "pending"
+	StatusNoData    = "no_data"
+)
+
+// Component names and their weights in the score
+const (
+	ComponentErrors     = "errors"
+	ComponentLatency    = "latency"
+	ComponentSaturation = "saturation"
+	ComponentAlerts     = "alerts"
+)
+
+var weights = map[string]float64{
+	ComponentErrors:     0.4,
+	ComponentLatency:    0.3,
+	ComponentSaturation: 0.15,
+	ComponentAlerts:     0.15,
+}
+
+// Health is the score of a release over its bake window
+type Health struct {
+	Release
+	Previous string `json:"previous,omitempty"`
+
+	// Start and End bound the window the release was scored over
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Score is 0-100; 100 is a release with no errors, no alerts, headroom,
+	// and no latency regression
+	Score  int    `json:"score"`
+	Status string `json:"status"`
+
+	Requests    float64     `json:"requests"`
+	Components  []Component `json:"components"`
+	Regressions []string    `json:"regressions,omitempty"`
+}
+
+// Component is one signal of a release's score
+type Component struct {
+	Name string `json:"name"`
+	// Value and Baseline are the signal for this release and the one before
+	Value    float64  `json:"value"`
+	Baseline *float64 `json:"baseline,omitempty"`
+	Score    int      `json:"score"`
+	Weight   float64  `json:"weight"`
+	Detail   string   `json:"detail"`
+}
+
+// Scorer scores releases from the metrics of the service in Prometheus
+type Scorer struct {
+	PrometheusURL string
+	Client        *http.Client
+	Timeline      incident.Timeline
+
+	// Window is how long after a deploy a release is scored over; zero
+	// means one hour. Releases younger than MinWindow are pending.
+	Window    time.Duration
+	MinWindow time.Duration
+
+	// ServiceLabel is the label of http_requests_total that names the
+	// service; empty means job
+	ServiceLabel    string
+	SaturationQuery string
+
+	// MaxErrorRate is the error rate that scores zero; zero means 5%
+	MaxErrorRate float64
+
+	// Now returns the current time; nil means time.Now. Replays of recorded
+	// incidents set it to the time of the incident.
+	Now func() time.Time
+
+	once  sync.Once
+	score *prometheus.GaugeVec
+}
+
+// Collector exports the last score of each release as release_health_score
+func (s *Scorer) Collector() prometheus.Collector {
+	return s.gauge()
+}
+
+func (s *Scorer) gauge() *prometheus.GaugeVec {
+	s.once.Do(func() {
+		s.score = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "release_health_score",
+			Help: "Health score (0-100) of a release over its bake window",
+		}, []string{"service", "version"})
+	})
+	return s.score
+}
+
+// History scores the latest releases of a service, newest first
+func (s *Scorer) History(ctx context.Context, service string, limit int) ([]*Health, error) {
+	releases, err := Releases(s.Timeline, service)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Health
+	for i := len(releases) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		var previous *Release
+		if i > 0 {
+			previous = &releases[i-1]
+		}
+		h, err := s.Score(ctx, releases[i], previous)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+// Version scores one release of a service
+func (s *Scorer) Version(ctx context.Context, service, version string) (*Health, error) {
+	releases, err := Releases(s.Timeline, service)
+	if err != nil {
+		return nil, err
+	}
+	current, previous, err := Find(releases, version)
+	if err != nil {
+		return nil, err
+	}
+	return s.Score(ctx, *current, previous)
+}
+
+// Score scores a release against the release before it, which may be nil
+func (s *Scorer) Score(ctx context.Context, r Release, previous *Release) (*Health, error) {
+	window := s.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	minWindow := s.MinWindow
+	if minWindow <= 0 {
+		minWindow = DefaultMinWindow
+	}
+
+	h := &Health{Release: r, Start: r.DeployedAt, End: r.DeployedAt.Add(window)}
+	if previous != nil {
+		h.Previous = previous.Version
+	}
+	if r.ReplacedAt != nil && r.ReplacedAt.Before(h.End) {
+		h.End = *r.ReplacedAt
+	}
+	if now := s.clock(); now.Before(h.End) {
+		h.End = now
+	}
+	d := h.End.Sub(h.Start)
+	if d < minWindow {
+		h.Status = StatusPending
+		return h, nil
+	}
+
+	current, err := s.signals(ctx, r.Service, h.End, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to score %s %s: %w", r.Service, r.Version, err)
+	}
+	h.Requests = current.requests
+	if current.requests == 0 {
+		h.Status = StatusNoData
+		return h, nil
+	}
+
+	// The baseline is the previous release over the same length of time
+	// before this deploy
+	var baseline *signals
+	if previous != nil {
+		baseline, err = s.signals(ctx, r.Service, r.DeployedAt, d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to score %s %s baseline: %w", r.Service, previous.Version, err)
+		}
+		if baseline.requests == 0 {
+			baseline = nil
+		}
+	}
+
+	maxErrors := s.MaxErrorRate
+	if maxErrors <= 0 {
+		maxErrors = 0.05
+	}
+	errRate := current.errors / current.requests
+	c := Component{Name: ComponentErrors, Value: errRate, Score: linear(errRate, 0, maxErrors),
+		Detail: fmt.Sprintf("%.2f%% of %.0f requests failed", errRate*100, current.requests)}
+	if baseline != nil {
+		b := baseline.errors / baseline.requests
+		c.Baseline = &b
+		c.Detail += fmt.Sprintf(" (%.2f%% before)", b*100)
+		if errRate > b*1.5 && errRate-b > 0.001 {
+			h.Regressions = append(h.Regressions, fmt.Sprintf("Error rate rose from %.2f%% to %.2f%%", b*100, errRate*100))
+		}
+	}
+	h.Components = append(h.Components, c)
+
+	if valid(current.latency) {
+		c := Component{Name: ComponentLatency, Value: current.latency, Score: 100,
+			Detail: fmt.Sprintf("p95 %s", seconds(current.latency))}
+		if baseline != nil && valid(baseline.latency) && baseline.latency > 0 {
+			b := baseline.latency
+			c.Baseline = &b
+			ratio := current.latency / b
+			c.Score = linear(ratio, 1.1, 2)
+			c.Detail += fmt.Sprintf(" (%s before, %+.0f%%)", seconds(b), (ratio-1)*100)
+			if ratio > 1.1 {
+				h.Regressions = append(h.Regressions, fmt.Sprintf("p95 latency rose %.0f%% from %s to %s", (ratio-1)*100, seconds(b), seconds(current.latency)))
+			}
+		} else {
+			c.Detail += " (no baseline to compare)"
+		}
+		h.Components = append(h.Components, c)
+	}
+
+	if valid(current.saturation) {
+		c := Component{Name: ComponentSaturation, Value: current.saturation, Score: linear(current.saturation, 0.7, 1),
+			Detail: fmt.Sprintf("peak CPU %.0f%% of limits", current.saturation*100)}
+		if baseline != nil && valid(baseline.saturation) {
+			b := baseline.saturation
+			c.Baseline = &b
+			c.Detail += fmt.Sprintf(" (%.0f%% before)", b*100)
+		}
+		h.Components = append(h.Components, c)
+	}
+
+	c = Component{Name: ComponentAlerts, Value: current.alerts, Score: int(math.Max(0, 100-25*current.alerts)),
+		Detail: fmt.Sprintf("%.0f alerts fired", current.alerts)}
+	if baseline != nil {
+		b := baseline.alerts
+		c.Baseline = &b
+		c.Detail += fmt.Sprintf(" (%.0f before)", b)
+		if current.alerts > b {
+			h.Regressions = append(h.Regressions, fmt.Sprintf("%.0f alerts fired, up from %.0f", current.alerts, b))
+		}
+	}
+	h.Components = append(h.Components, c)
+
+	var total, weight float64
+	for i := range h.Components {
+		h.Components[i].Weight = weights[h.Components[i].Name]
+		total += float64(h.Components[i].Score) * h.Components[i].Weight
+		weight += h.Components[i].Weight
+	}
+	h.Score = int(math.Round(total / weight))
+	switch {
+	case h.Score >= 90:
+		h.Status = StatusHealthy
+	case h.Score >= 70:
+		h.Status = StatusDegraded
+	default:
+		h.Status = StatusUnhealthy
+	}
+	s.gauge().WithLabelValues(r.Service, r.Version).Set(float64(h.Score))
+	return h, nil
+}
+
+// signals are a service's raw health signals over a window
+type signals struct {
+	requests, errors float64
+	latency          float64
+	saturation       float64
+	alerts           float64
+}
+
+// signals queries the signals of a service over the window ending at end
+func (s *Scorer) signals(ctx context.Context, service string, end time.Time, d time.Duration) (*signals, error) {
+	label := s.ServiceLabel
+	if label == "" {
+		label = DefaultServiceLabel
+	}
+	sel := fmt.Sprintf(`%s=%q`, label, service)
+	w := fmt.Sprintf("%ds", int64(d.Seconds()))
+	saturation := s.SaturationQuery
+	if saturation == "" {
+		saturation = DefaultSaturationQuery
+	}
+	saturation = strings.ReplaceAll(saturation, "$service", service)
+
+	out := &signals{}
+	var err error
+	if out.requests, err = s.query(ctx, fmt.Sprintf(`sum(increase(http_requests_total{%s}[%s]))`, sel, w), end); err != nil {
+		return nil, err
+	}
+	if !valid(out.requests) {
+		out.requests = 0
+	}
+	if out.requests == 0 {
+		return out, nil
+	}
+	if out.errors, err = s.query(ctx, fmt.Sprintf(`sum(increase(http_requests_total{%s, status=~"5.."}[%s]))`, sel, w), end); err != nil {
+		return nil, err
+	}
+	if !valid(out.errors) {
+		out.errors = 0
+	}
+	out.latency, _ = s.query(ctx, fmt.Sprintf(`histogram_quantile(0.95, sum by (le) (rate(http_request_duration_seconds_bucket{%s}[%s])))`, sel, w), end)
+	out.saturation, _ = s.query(ctx, fmt.Sprintf(`max_over_time((%s)[%s:1m])`, saturation, w), end)
+	out.alerts, _ = s.query(ctx, fmt.Sprintf(`count(count_over_time(ALERTS{alertstate="firing", %s}[%s]))`, sel, w), end)
+	if !valid(out.alerts) {
+		out.alerts = 0
+	}
+	return out, nil
+}
+
+// query runs an instant query and returns its first value, or NaN when the
+// result is empty
+func (s *Scorer) query(ctx context.Context, query string, at time.Time) (float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(at.Unix(), 10))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(s.PrometheusURL, "/")+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return math.NaN(), err
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return math.NaN(), err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return math.NaN(), err
+	}
+	var out struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Value [2]interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return math.NaN(), fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	if out.Status != "success" {
+		return math.NaN(), fmt.Errorf("prometheus: %s", out.Error)
+	}
+	if len(out.Data.Result) == 0 {
+		return math.NaN(), nil
+	}
+	v, _ := out.Data.Result[0].Value[1].(string)
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return math.NaN(), nil
+	}
+	return f, nil
+}
+
+func (s *Scorer) clock() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// linear scores 100 at or below good, 0 at or above bad, and linearly between
+func linear(v, good, bad float64) int {
+	switch {
+	case v <= good:
+		return 100
+	case v >= bad:
+		return 0
+	}
+	return int(math.Round(100 * (bad - v) / (bad - good)))
+}
+
+func valid(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
@@ -0,0 +1,56 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/deployment"
+)
+
+// DeploymentCheck adapts the scorer to deployment verification. A release
+// still inside its minimum window reports unknown so verification waits for
+// it; a release without traffic passes.
+func (s *Scorer) DeploymentCheck() deployment.ReleaseHealthFunc {
+	return func(d *deployment.Deployment) (deployment.HealthCheck, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		h, err := s.Version(ctx, d.Name, d.Version)
+		if err != nil {
+			return deployment.HealthCheck{}, err
+		}
+
+		check := deployment.HealthCheck{
+			Name:        "release_health",
+			Type:        deployment.HealthCheckCustom,
+			LastChecked: time.Now(),
+			Metadata: map[string]string{
+				"score":    fmt.Sprint(h.Score),
+				"previous": h.Previous,
+			},
+		}
+		switch h.Status {
+		case StatusHealthy:
+			check.Status = deployment.HealthStatusHealthy
+		case StatusDegraded:
+			check.Status = deployment.HealthStatusDegraded
+		case StatusUnhealthy:
+			check.Status = deployment.HealthStatusUnhealthy
+		case StatusNoData:
+			check.Status = deployment.HealthStatusHealthy
+			check.Message = "no traffic to score"
+			return check, nil
+		default:
+			check.Status = deployment.HealthStatusUnknown
+			check.Message = "waiting for the release to take traffic"
+			return check, nil
+		}
+		check.Message = fmt.Sprintf("release health %d/100", h.Score)
+		if len(h.Regressions) > 0 {
+			check.Message += ": " + strings.Join(h.Regressions, "; ")
+		}
+		return check, nil
+	}
+}
@@ -0,0 +1,216 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/lookup"
+)
+
+// Prometheus answers instant queries from recorded samples. It is an
+// http.RoundTripper, so it stands in for Prometheus behind any client.
+type Prometheus struct {
+	Samples []Sample
+
+	mu      sync.Mutex
+	missing []string
+}
+
+// RoundTrip implements http.RoundTripper for /api/v1/query
+func (p *Prometheus) RoundTrip(req *http.Request) (*http.Response, error) {
+	params := req.URL.Query()
+	query := params.Get("query")
+	at := parseTime(params.Get("time"))
+
+	result := []interface{}{}
+	if sample, ok := p.lookup(query, at); ok {
+		if sample.Value != nil {
+			result = append(result, map[string]interface{}{
+				"metric": map[string]string{},
+				"value":  []interface{}{at.Unix(), strconv.FormatFloat(*sample.Value, 'f', -1, 64)},
+			})
+		}
+	} else {
+		p.miss(fmt.Sprintf("%s at %s", query, at.UTC().Format(time.RFC3339)))
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "vector", "result": result},
+	})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// Missing returns the queries that had no recorded sample
+func (p *Prometheus) Missing() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.missing...)
+}
+
+// miss records a query without a sample once
+func (p *Prometheus) miss(query string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !slices.Contains(p.missing, query) {
+		p.missing = append(p.missing, query)
+	}
+}
+
+// lookup returns the sample of a query at a time; a sample without a time
+// matches any time
+func (p *Prometheus) lookup(query string, at time.Time) (Sample, bool) {
+	for _, s := range p.Samples {
+		if s.Query == query && (s.Time.IsZero() || s.Time.Unix() == at.Unix()) {
+			return s, true
+		}
+	}
+	return Sample{}, false
+}
+
+// recorder records the first value of the instant queries passing through
+type recorder struct {
+	next    http.RoundTripper
+	mu      sync.Mutex
+	samples []Sample
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var out struct {
+		Status string `json:"status"`
+		Data   struct {
+			Result []struct {
+				Value [2]interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &out) != nil || out.Status != "success" {
+		return resp, nil
+	}
+	params := req.URL.Query()
+	sample := Sample{Query: params.Get("query"), Time: parseTime(params.Get("time")).UTC()}
+	if len(out.Data.Result) > 0 {
+		v, _ := out.Data.Result[0].Value[1].(string)
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			sample.Value = &f
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.samples {
+		if s.Query == sample.Query && s.Time.Equal(sample.Time) {
+			return resp, nil
+		}
+	}
+	r.samples = append(r.samples, sample)
+	return resp, nil
+}
+
+// Traces answers trace searches from recorded spans
+type Traces struct {
+	Spans []Span
+}
+
+// Name implements lookup.TraceSearcher
+func (t *Traces) Name() string { return "replay" }
+
+// SearchTraces implements lookup.TraceSearcher. Like Jaeger it returns
+// every span of the traces with a span of a searched service within the
+// query's time range.
+func (t *Traces) SearchTraces(ctx context.Context, q lookup.Query) ([]lookup.Event, error) {
+	services := make(map[string]bool)
+	for _, s := range q.Services {
+		services[s] = true
+	}
+	matched := make(map[string]bool)
+	for _, s := range t.Spans {
+		if (len(services) == 0 || services[s.Service]) && !s.Time.Before(q.Start) && !s.Time.After(q.End) {
+			matched[s.TraceID] = true
+		}
+	}
+	var events []lookup.Event
+	for _, s := range t.Spans {
+		if !matched[s.TraceID] {
+			continue
+		}
+		events = append(events, lookup.Event{
+			Time:     s.Time,
+			Source:   lookup.SourceTrace,
+			Service:  s.Service,
+			TraceID:  s.TraceID,
+			Summary:  s.Operation,
+			Duration: s.Duration,
+			Error:    s.Error,
+		})
+	}
+	return events, nil
+}
+
+// traceRecorder records the spans of the trace searches passing through
+type traceRecorder struct {
+	next  lookup.TraceSearcher
+	mu    sync.Mutex
+	spans []Span
+}
+
+// Name implements lookup.TraceSearcher
+func (t *traceRecorder) Name() string { return t.next.Name() }
+
+// SearchTraces implements lookup.TraceSearcher
+func (t *traceRecorder) SearchTraces(ctx context.Context, q lookup.Query) ([]lookup.Event, error) {
+	events, err := t.next.SearchTraces(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range events {
+		t.spans = append(t.spans, Span{
+			TraceID:   e.TraceID,
+			Service:   e.Service,
+			Operation: e.Summary,
+			Time:      e.Time.UTC(),
+			Duration:  e.Duration,
+			Error:     e.Error,
+		})
+	}
+	return events, nil
+}
+
+// parseTime parses a Prometheus time parameter in Unix seconds
+func parseTime(v string) time.Time {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
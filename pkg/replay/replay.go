@@ -0,0 +1,390 @@
+// Package replay tests deploy verification offline against recorded
+// incidents. A scenario holds the deploys of a service, the Prometheus query
+// results recorded around them, and optionally the alerts that fired and the
+// spans of the failing traces. Replaying it scores the release with the
+// thresholds under test, runs the deployment health check that gates
+// rollbacks, summarizes the incident as the server would, and compares the
+// outcome with the expected one, so thresholds can be tuned against
+// historical incidents before verification is trusted to roll back.
+//
+//	name: checkout 1.5.0 error spike
+//	service: checkout
+//	at: 2024-05-01T12:40:00Z
+//	deploys:
+//	  - {version: 1.4.0, time: 2024-04-30T09:00:00Z}
+//	  - {version: 1.5.0, time: 2024-05-01T12:00:00Z}
+//	thresholds: {max_error_rate: 0.05}
+//	queries:
+//	  - query: sum(increase(http_requests_total{job="checkout"}[2400s]))
+//	    time: 2024-05-01T12:40:00Z
+//	    value: 12000
+//	expect: {status: unhealthy, rollback: true}
+//
+// Queries are matched by their PromQL and evaluation time, so scenarios are
+// best recorded from Prometheus with Record rather than written by hand.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/deployment"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/release"
+	"github.com/chaksack/apm/pkg/webhook"
+	"gopkg.in/yaml.v3"
+)
+
+// replayURL is the Prometheus URL recorded queries are served from
+const replayURL = "http://prometheus.replay"
+
+// Scenario is a recorded incident
+type Scenario struct {
+	Name    string `yaml:"name" json:"name"`
+	Service string `yaml:"service" json:"service"`
+
+	// At is when verification runs, e.g. when the release was rolled back
+	At time.Time `yaml:"at" json:"at"`
+
+	// Version is the release verified; empty means the last deploy
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+
+	Deploys    []Deploy   `yaml:"deploys" json:"deploys"`
+	Thresholds Thresholds `yaml:"thresholds,omitempty" json:"thresholds"`
+	Queries    []Sample   `yaml:"queries,omitempty" json:"queries,omitempty"`
+	Alerts     []Alert    `yaml:"alerts,omitempty" json:"alerts,omitempty"`
+	Traces     []Span     `yaml:"traces,omitempty" json:"traces,omitempty"`
+	Expect     Expect     `yaml:"expect" json:"expect"`
+}
+
+// Deploy is a deploy of the service
+type Deploy struct {
+	Version string    `yaml:"version,omitempty" json:"version,omitempty"`
+	Image   string    `yaml:"image,omitempty" json:"image,omitempty"`
+	Time    time.Time `yaml:"time" json:"time"`
+
+	// Status is the outcome of the deploy; empty means succeeded
+	Status string `yaml:"status,omitempty" json:"status,omitempty"`
+}
+
+// Thresholds are the release scorer settings under test; zero values are
+// the scorer's defaults
+type Thresholds struct {
+	Window          time.Duration `yaml:"window,omitempty" json:"window,omitempty"`
+	MinWindow       time.Duration `yaml:"min_window,omitempty" json:"min_window,omitempty"`
+	MaxErrorRate    float64       `yaml:"max_error_rate,omitempty" json:"max_error_rate,omitempty"`
+	ServiceLabel    string        `yaml:"service_label,omitempty" json:"service_label,omitempty"`
+	SaturationQuery string        `yaml:"saturation_query,omitempty" json:"saturation_query,omitempty"`
+}
+
+// Sample is the recorded result of an instant query
+type Sample struct {
+	Query string `yaml:"query" json:"query"`
+
+	// Time is the evaluation time; zero matches any time
+	Time time.Time `yaml:"time,omitempty" json:"time,omitempty"`
+
+	// Value is the first value of the result; nil is an empty result
+	Value *float64 `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+// Alert is an alert that fired during the incident
+type Alert struct {
+	Name     string    `yaml:"name" json:"name"`
+	Severity string    `yaml:"severity,omitempty" json:"severity,omitempty"`
+	Summary  string    `yaml:"summary,omitempty" json:"summary,omitempty"`
+	StartsAt time.Time `yaml:"starts_at" json:"starts_at"`
+}
+
+// Span is a recorded span of a failing trace
+type Span struct {
+	TraceID   string        `yaml:"trace_id" json:"trace_id"`
+	Service   string        `yaml:"service" json:"service"`
+	Operation string        `yaml:"operation" json:"operation"`
+	Time      time.Time     `yaml:"time" json:"time"`
+	Duration  time.Duration `yaml:"duration,omitempty" json:"duration,omitempty"`
+	Error     bool          `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Expect is the outcome a scenario should have; unset fields are not
+// checked
+type Expect struct {
+	// Status is the release status: healthy, degraded, unhealthy, pending,
+	// or no_data
+	Status   string `yaml:"status,omitempty" json:"status,omitempty"`
+	MinScore *int   `yaml:"min_score,omitempty" json:"min_score,omitempty"`
+	MaxScore *int   `yaml:"max_score,omitempty" json:"max_score,omitempty"`
+
+	// Rollback is whether the health check fails verification
+	Rollback *bool `yaml:"rollback,omitempty" json:"rollback,omitempty"`
+
+	// Regressions must each be part of a regression the scorer reports
+	Regressions []string `yaml:"regressions,omitempty" json:"regressions,omitempty"`
+
+	// Deploys and Traces are the minimum deploys and failing traces the
+	// incident summary correlates with the alerts
+	Deploys *int `yaml:"deploys,omitempty" json:"deploys,omitempty"`
+	Traces  *int `yaml:"traces,omitempty" json:"traces,omitempty"`
+}
+
+// Load reads a scenario file
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = path
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes the scenario as YAML
+func (s *Scenario) Save(path string) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// Validate checks the scenario
+func (s *Scenario) Validate() error {
+	var problems []string
+	if s.Service == "" {
+		problems = append(problems, "service is required")
+	}
+	if s.At.IsZero() {
+		problems = append(problems, "at is required")
+	}
+	if len(s.Deploys) == 0 {
+		problems = append(problems, "at least one deploy is required")
+	}
+	for n, d := range s.Deploys {
+		if d.Time.IsZero() {
+			problems = append(problems, fmt.Sprintf("deploy %d has no time", n+1))
+		}
+	}
+	for n, a := range s.Alerts {
+		if a.Name == "" || a.StartsAt.IsZero() {
+			problems = append(problems, fmt.Sprintf("alert %d needs a name and starts_at", n+1))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Result is the outcome of replaying a scenario
+type Result struct {
+	Scenario string                  `json:"scenario"`
+	Health   *release.Health         `json:"health,omitempty"`
+	Check    *deployment.HealthCheck `json:"check,omitempty"`
+
+	// Rollback is whether the health check fails verification
+	Rollback bool              `json:"rollback"`
+	Incident *incident.Summary `json:"incident,omitempty"`
+
+	// Missing lists the queries that were run but not recorded; they were
+	// answered with an empty result
+	Missing []string `json:"missing,omitempty"`
+
+	// Failures lists the expectations that were not met
+	Failures []string      `json:"failures,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Passed reports whether every expectation was met and every query was
+// recorded
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0 && len(r.Missing) == 0
+}
+
+// Run replays a scenario. An error means the scenario could not be
+// replayed; unmet expectations are failures of the result.
+func Run(ctx context.Context, s *Scenario) (*Result, error) {
+	start := time.Now()
+	prometheus := &Prometheus{Samples: s.Queries}
+	result, err := run(ctx, s, &http.Client{Transport: prometheus}, replayURL, &Traces{Spans: s.Traces})
+	if err != nil {
+		return nil, err
+	}
+	result.Missing = prometheus.Missing()
+	result.Failures = s.Expect.check(result)
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// Record runs the scenario against a live Prometheus and, when traces is
+// not nil, a trace backend, and replaces its queries and trace spans with
+// their answers. The expectations are left for the author to fill in.
+func Record(ctx context.Context, s *Scenario, client *http.Client, prometheusURL string, traces lookup.TraceSearcher) (*Result, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	recorder := &recorder{next: transport}
+	recording := *client
+	recording.Transport = recorder
+
+	var spans *traceRecorder
+	var searcher lookup.TraceSearcher
+	if traces != nil {
+		spans = &traceRecorder{next: traces}
+		searcher = spans
+	}
+	result, err := run(ctx, s, &recording, prometheusURL, searcher)
+	if err != nil {
+		return nil, err
+	}
+	s.Queries = recorder.samples
+	if spans != nil {
+		s.Traces = spans.spans
+	}
+	return result, nil
+}
+
+// run verifies the scenario's release and summarizes its incident against
+// the given backends
+func run(ctx context.Context, s *Scenario, client *http.Client, prometheusURL string, traces lookup.TraceSearcher) (*Result, error) {
+	now := func() time.Time { return s.At }
+	timeline := &incident.MemoryTimeline{}
+	summarizer := &incident.Summarizer{Timeline: timeline, Traces: traces, Now: now}
+	for _, d := range s.Deploys {
+		status := d.Status
+		if status == "" {
+			status = "succeeded"
+		}
+		data := map[string]interface{}{}
+		if d.Version != "" {
+			data["version"] = d.Version
+		}
+		if d.Image != "" {
+			data["image"] = d.Image
+		}
+		event := webhook.Event{Type: webhook.EventDeployFinished, Time: d.Time, Source: "replay", Subject: s.Service, Status: status, Data: data}
+		if err := summarizer.RecordDeploy(event); err != nil {
+			return nil, err
+		}
+	}
+
+	version := s.Version
+	if version == "" {
+		releases, err := release.Releases(timeline, s.Service)
+		if err != nil {
+			return nil, err
+		}
+		if len(releases) == 0 {
+			return nil, fmt.Errorf("%s has no successful deploy", s.Service)
+		}
+		version = releases[len(releases)-1].Version
+	}
+
+	scorer := &release.Scorer{
+		PrometheusURL:   prometheusURL,
+		Client:          client,
+		Timeline:        timeline,
+		Window:          s.Thresholds.Window,
+		MinWindow:       s.Thresholds.MinWindow,
+		ServiceLabel:    s.Thresholds.ServiceLabel,
+		SaturationQuery: s.Thresholds.SaturationQuery,
+		MaxErrorRate:    s.Thresholds.MaxErrorRate,
+		Now:             now,
+	}
+	result := &Result{Scenario: s.Name}
+	health, err := scorer.Version(ctx, s.Service, version)
+	if err != nil {
+		return nil, err
+	}
+	result.Health = health
+
+	// The health check is what deployment verification runs
+	check, err := scorer.DeploymentCheck()(&deployment.Deployment{Name: s.Service, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	result.Check = &check
+	result.Rollback = check.Status == deployment.HealthStatusUnhealthy
+
+	if len(s.Alerts) > 0 {
+		payload := webhook.AlertmanagerPayload{GroupKey: s.Name, Status: "firing"}
+		for _, a := range s.Alerts {
+			payload.Alerts = append(payload.Alerts, webhook.AlertmanagerAlert{
+				Status:      "firing",
+				Labels:      map[string]string{"alertname": a.Name, "severity": a.Severity, "service": s.Service},
+				Annotations: map[string]string{"summary": a.Summary},
+				StartsAt:    a.StartsAt,
+			})
+		}
+		if result.Incident, err = summarizer.Summarize(ctx, payload); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// check returns the expectations the result does not meet
+func (e Expect) check(r *Result) []string {
+	var failures []string
+	h := r.Health
+	if e.Status != "" && h.Status != e.Status {
+		failures = append(failures, fmt.Sprintf("status is %s, expected %s", h.Status, e.Status))
+	}
+	if e.MinScore != nil && h.Score < *e.MinScore {
+		failures = append(failures, fmt.Sprintf("score %d is below %d", h.Score, *e.MinScore))
+	}
+	if e.MaxScore != nil && h.Score > *e.MaxScore {
+		failures = append(failures, fmt.Sprintf("score %d is above %d", h.Score, *e.MaxScore))
+	}
+	if e.Rollback != nil && r.Rollback != *e.Rollback {
+		if r.Rollback {
+			failures = append(failures, "verification fails, expected it to pass: "+r.Check.Message)
+		} else {
+			failures = append(failures, "verification passes, expected a rollback: "+r.Check.Message)
+		}
+	}
+	for _, want := range e.Regressions {
+		found := false
+		for _, got := range h.Regressions {
+			found = found || strings.Contains(got, want)
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("no regression mentions %q", want))
+		}
+	}
+	if e.Deploys != nil || e.Traces != nil {
+		if r.Incident == nil {
+			return append(failures, "no alerts to summarize the incident from")
+		}
+		if e.Deploys != nil && len(r.Incident.Deploys) < *e.Deploys {
+			failures = append(failures, fmt.Sprintf("incident lists %d deploys, expected at least %d", len(r.Incident.Deploys), *e.Deploys))
+		}
+		if e.Traces != nil && len(r.Incident.Traces) < *e.Traces {
+			failures = append(failures, fmt.Sprintf("incident lists %d failing traces, expected at least %d", len(r.Incident.Traces), *e.Traces))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,144 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/lookup"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// fakePrometheus answers with before for the window ending at the deploy
+// and with after for the window after it
+func fakePrometheus(before, after map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := after
+		if r.URL.Query().Get("time") == fmt.Sprint(t0.Unix()) {
+			values = before
+		}
+		query := r.URL.Query().Get("query")
+		kind := "requests"
+		switch {
+		case strings.Contains(query, "histogram_quantile"):
+			kind = "latency"
+		case strings.Contains(query, "max_over_time"):
+			kind = "saturation"
+		case strings.Contains(query, "ALERTS"):
+			kind = "alerts"
+		case strings.Contains(query, `status=~"5.."`):
+			kind = "errors"
+		}
+		result := "[]"
+		if v, ok := values[kind]; ok {
+			result = fmt.Sprintf(`[{"metric":{},"value":[0,%q]}]`, v)
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+}
+
+type fakeTraces []lookup.Event
+
+func (fakeTraces) Name() string { return "jaeger" }
+
+func (f fakeTraces) SearchTraces(ctx context.Context, q lookup.Query) ([]lookup.Event, error) {
+	return f, nil
+}
+
+func scenario() *Scenario {
+	return &Scenario{
+		Name:    "checkout 1.5.0 error spike",
+		Service: "checkout",
+		At:      t0.Add(40 * time.Minute),
+		Deploys: []Deploy{
+			{Version: "1.4.0", Time: t0.Add(-24 * time.Hour)},
+			{Version: "1.5.0", Time: t0},
+		},
+		Alerts: []Alert{{Name: "HighErrorRate", Severity: "critical", StartsAt: t0.Add(20 * time.Minute)}},
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := fakePrometheus(
+		map[string]string{"requests": "10000", "errors": "10", "latency": "0.2", "alerts": "0"},
+		map[string]string{"requests": "10000", "errors": "400", "latency": "0.25", "alerts": "1"},
+	)
+	defer server.Close()
+	traces := fakeTraces{
+		{Time: t0.Add(19 * time.Minute), Service: "checkout", TraceID: "abc", Summary: "POST /checkout", Duration: time.Second, Error: true},
+		{Time: t0.Add(19*time.Minute + time.Millisecond), Service: "payments", TraceID: "abc", Summary: "charge", Duration: 900 * time.Millisecond, Error: true},
+	}
+
+	s := scenario()
+	recorded, err := Record(context.Background(), s, nil, server.URL, traces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded.Health.Status != "unhealthy" || !recorded.Rollback {
+		t.Fatalf("recorded = %+v", recorded.Health)
+	}
+	if len(s.Queries) != 10 || len(s.Traces) != 2 {
+		t.Fatalf("recorded %d queries and %d spans", len(s.Queries), len(s.Traces))
+	}
+
+	// The recorded scenario replays offline to the same outcome
+	server.Close()
+	rollback, one := true, 1
+	s.Expect = Expect{Status: "unhealthy", Rollback: &rollback, Regressions: []string{"Error rate rose"}, Deploys: &one, Traces: &one}
+	path := filepath.Join(t.TempDir(), "checkout.yml")
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Run(context.Background(), loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed() || result.Health.Score != recorded.Health.Score {
+		t.Errorf("replay = %+v, failures %v, missing %v", result.Health, result.Failures, result.Missing)
+	}
+	if trace := result.Incident.Traces[0]; trace.TraceID != "abc" || trace.Errors != 2 {
+		t.Errorf("incident traces = %+v", result.Incident.Traces)
+	}
+
+	// A looser error threshold no longer rolls the release back
+	loaded.Thresholds.MaxErrorRate = 0.5
+	result, err = Run(context.Background(), loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rollback || len(result.Failures) != 2 || !strings.Contains(result.Failures[1], "expected a rollback") {
+		t.Errorf("failures = %v", result.Failures)
+	}
+	// A shorter window runs queries that were not recorded
+	loaded.Thresholds = Thresholds{Window: 30 * time.Minute}
+	if result, _ = Run(context.Background(), loaded); result.Passed() || len(result.Missing) != 1 {
+		t.Errorf("missing = %v", result.Missing)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (&Scenario{}).Validate(); err == nil || !strings.Contains(err.Error(), "service is required") {
+		t.Errorf("empty scenario: %v", err)
+	}
+	s := scenario()
+	s.Alerts = append(s.Alerts, Alert{Name: "NoStart"})
+	if err := s.Validate(); err == nil {
+		t.Error("alert without starts_at accepted")
+	}
+	s = scenario()
+	s.Deploys[0].Status = "failed"
+	s.Deploys[1].Status = "failed"
+	if _, err := Run(context.Background(), s); err == nil {
+		t.Error("scenario without a successful deploy replayed")
+	}
+}
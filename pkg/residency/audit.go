@@ -0,0 +1,44 @@
+package residency
+
+import (
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+)
+
+// Event types written to the residency audit trail
+const (
+	EventViolation       = "data_residency_violation"
+	EventBlocked         = "data_residency_blocked"
+	EventOverrideGranted = "data_residency_override_granted"
+	EventOverrideUsed    = "data_residency_override_used"
+)
+
+// Event is a data residency audit record
+type Event struct {
+	Timestamp   time.Time  `json:"timestamp"`
+	EventType   string     `json:"event_type"`
+	Severity    string     `json:"severity"`
+	Environment string     `json:"environment"`
+	Component   string     `json:"component"`
+	Provider    string     `json:"provider,omitempty"`
+	Region      string     `json:"region"`
+	Actor       string     `json:"username,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Expires     *time.Time `json:"expires,omitempty"`
+}
+
+// Auditor records residency events
+type Auditor = auditlog.Auditor[Event]
+
+// FileAuditor appends residency events as JSON lines to a file
+type FileAuditor = auditlog.File[Event]
+
+// NewFileAuditor creates an auditor writing to path
+func NewFileAuditor(path string) *FileAuditor {
+	return auditlog.NewFile[Event]("residency", path)
+}
+
+// MemoryAuditor keeps residency events in memory, for tests and status
+// endpoints
+type MemoryAuditor = auditlog.Memory[Event]
@@ -0,0 +1,276 @@
+// Package residency enforces data residency by pinning cloud operations,
+// telemetry exporters, and storage to the regions allowed for an environment.
+//
+// A Guard evaluates region selections against the Policy for the active
+// environment. Selections outside the allowed set are rejected when the policy
+// is enforced, or recorded as violations in audit-only mode. Operators may
+// grant time-bound overrides, each of which is written to the audit trail.
+package residency
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chaksack/apm/pkg/auditlog"
+)
+
+// Policy declares the allowed regions per environment
+type Policy struct {
+	// Enforce rejects disallowed regions; when false violations are only audited
+	Enforce bool `mapstructure:"enforce" yaml:"enforce" json:"enforce"`
+
+	// Environments maps an environment name to its allowed regions
+	Environments map[string]EnvironmentPolicy `mapstructure:"environments" yaml:"environments" json:"environments"`
+}
+
+// EnvironmentPolicy lists the regions allowed in one environment
+type EnvironmentPolicy struct {
+	// AllowedRegions applies to every provider. Entries may be glob
+	// patterns such as "eu-*" or "europe-*".
+	AllowedRegions []string `mapstructure:"allowed_regions" yaml:"allowed_regions" json:"allowed_regions"`
+
+	// Providers overrides AllowedRegions for a provider (aws, azure, gcp)
+	Providers map[string][]string `mapstructure:"providers" yaml:"providers" json:"providers,omitempty"`
+}
+
+// ViolationError is returned when a region is outside the allowed set
+type ViolationError struct {
+	Environment string
+	Component   string
+	Provider    string
+	Region      string
+	Allowed     []string
+}
+
+func (e *ViolationError) Error() string {
+	return fmt.Sprintf("data residency: %s region %q is not allowed in environment %q (allowed: %s)",
+		e.Component, e.Region, e.Environment, strings.Join(e.Allowed, ", "))
+}
+
+// Override permits a specific region selection despite the policy
+type Override struct {
+	Component string
+	Provider  string
+	Region    string
+	Actor     string
+	Reason    string
+	Expires   time.Time // zero means no expiry
+}
+
+// Guard validates region selections for one environment
+type Guard struct {
+	policy      Policy
+	environment string
+	auditor     Auditor
+	overrides   []Override
+	now         func() time.Time
+	mu          sync.RWMutex
+}
+
+// NewGuard creates a guard for the environment. A nil auditor discards events.
+func NewGuard(policy Policy, environment string, auditor Auditor) *Guard {
+	if auditor == nil {
+		auditor = auditlog.Discard[Event]{}
+	}
+	return &Guard{
+		policy:      policy,
+		environment: environment,
+		auditor:     auditor,
+		now:         time.Now,
+	}
+}
+
+// Environment returns the environment the guard enforces
+func (g *Guard) Environment() string {
+	return g.environment
+}
+
+// AllowedRegions returns the allowed region patterns for a provider.
+// An empty result means every region is allowed.
+func (g *Guard) AllowedRegions(provider string) []string {
+	env, ok := g.policy.Environments[g.environment]
+	if !ok {
+		return nil
+	}
+	if regions, ok := env.Providers[strings.ToLower(provider)]; ok {
+		return regions
+	}
+	return env.AllowedRegions
+}
+
+// Allowed reports whether a region is allowed without consulting overrides
+func (g *Guard) Allowed(provider, region string) bool {
+	allowed := g.AllowedRegions(provider)
+	if len(allowed) == 0 {
+		return true
+	}
+	return matchRegion(allowed, region)
+}
+
+// Check validates a region selection. A nil guard or empty region always passes.
+func (g *Guard) Check(component, provider, region string) error {
+	if g == nil || region == "" || g.Allowed(provider, region) {
+		return nil
+	}
+
+	if o, ok := g.activeOverride(component, provider, region); ok {
+		g.record(Event{
+			EventType: EventOverrideUsed,
+			Severity:  "warning",
+			Component: component,
+			Provider:  provider,
+			Region:    region,
+			Actor:     o.Actor,
+			Reason:    o.Reason,
+		})
+		return nil
+	}
+
+	violation := &ViolationError{
+		Environment: g.environment,
+		Component:   component,
+		Provider:    provider,
+		Region:      region,
+		Allowed:     g.AllowedRegions(provider),
+	}
+
+	event := Event{
+		EventType: EventViolation,
+		Severity:  "warning",
+		Component: component,
+		Provider:  provider,
+		Region:    region,
+		Reason:    "audit only",
+	}
+	if g.policy.Enforce {
+		event.EventType = EventBlocked
+		event.Severity = "critical"
+		event.Reason = violation.Error()
+	}
+	g.record(event)
+
+	if g.policy.Enforce {
+		return violation
+	}
+	return nil
+}
+
+// CheckEndpoint validates the region inferred from an endpoint host name.
+// Endpoints whose region cannot be inferred pass.
+func (g *Guard) CheckEndpoint(component, provider, endpoint string) error {
+	return g.Check(component, provider, InferRegion(endpoint))
+}
+
+// Grant records an override that permits a region selection. Overrides
+// require an actor and reason so the audit trail identifies who approved them.
+func (g *Guard) Grant(o Override) error {
+	if o.Actor == "" || o.Reason == "" {
+		return fmt.Errorf("data residency override requires an actor and a reason")
+	}
+	if o.Region == "" {
+		return fmt.Errorf("data residency override requires a region")
+	}
+
+	g.mu.Lock()
+	g.overrides = append(g.overrides, o)
+	g.mu.Unlock()
+
+	event := Event{
+		EventType: EventOverrideGranted,
+		Severity:  "warning",
+		Component: o.Component,
+		Provider:  o.Provider,
+		Region:    o.Region,
+		Actor:     o.Actor,
+		Reason:    o.Reason,
+	}
+	if !o.Expires.IsZero() {
+		event.Expires = &o.Expires
+	}
+	return g.auditor.Record(g.stamp(event))
+}
+
+// activeOverride finds an unexpired override matching the selection.
+// Empty component or provider fields in an override match anything.
+func (g *Guard) activeOverride(component, provider, region string) (Override, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	now := g.now()
+	for _, o := range g.overrides {
+		if !o.Expires.IsZero() && now.After(o.Expires) {
+			continue
+		}
+		if o.Component != "" && o.Component != component {
+			continue
+		}
+		if o.Provider != "" && !strings.EqualFold(o.Provider, provider) {
+			continue
+		}
+		if strings.EqualFold(o.Region, region) {
+			return o, true
+		}
+	}
+	return Override{}, false
+}
+
+// record writes an event to the audit trail, ignoring auditor errors so that
+// auditing never changes the outcome of a check
+func (g *Guard) record(e Event) {
+	_ = g.auditor.Record(g.stamp(e))
+}
+
+// stamp fills the common event fields
+func (g *Guard) stamp(e Event) Event {
+	e.Timestamp = g.now().UTC()
+	e.Environment = g.environment
+	return e
+}
+
+// matchRegion reports whether region matches any allowed pattern
+func matchRegion(patterns []string, region string) bool {
+	region = strings.ToLower(region)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == region {
+			return true
+		}
+		if ok, err := path.Match(p, region); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-(north|south|east|west|central|northeast|southeast|northwest|southwest)-\d$`)
+	gcpRegionPattern = regexp.MustCompile(`^(us|europe|asia|australia|northamerica|southamerica|me|africa)-[a-z]+\d+$`)
+)
+
+// InferRegion extracts a cloud region from an endpoint host name, such as
+// "otlp.eu-west-1.amazonaws.com" or "collector.europe-west1.example.com".
+// It returns "" when no region label is present.
+func InferRegion(endpoint string) string {
+	host := endpoint
+	if strings.Contains(endpoint, "://") {
+		if u, err := url.Parse(endpoint); err == nil {
+			host = u.Host
+		}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, label := range strings.Split(strings.ToLower(host), ".") {
+		if awsRegionPattern.MatchString(label) || gcpRegionPattern.MatchString(label) {
+			return label
+		}
+	}
+	return ""
+}
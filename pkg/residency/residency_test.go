@@ -0,0 +1,114 @@
+package residency
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testPolicy(enforce bool) Policy {
+	return Policy{
+		Enforce: enforce,
+		Environments: map[string]EnvironmentPolicy{
+			"production": {
+				AllowedRegions: []string{"eu-*", "europe-west1"},
+				Providers:      map[string][]string{"azure": {"westeurope", "northeurope"}},
+			},
+		},
+	}
+}
+
+func TestCheckEnforced(t *testing.T) {
+	auditor := &MemoryAuditor{}
+	guard := NewGuard(testPolicy(true), "production", auditor)
+
+	if err := guard.Check("cloud.aws", "aws", "eu-central-1"); err != nil {
+		t.Errorf("expected eu-central-1 to be allowed: %v", err)
+	}
+	if err := guard.Check("cloud.azure", "azure", "westeurope"); err != nil {
+		t.Errorf("expected provider specific region to be allowed: %v", err)
+	}
+
+	err := guard.Check("cloud.aws", "aws", "us-east-1")
+	var violation *ViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected a ViolationError, got %v", err)
+	}
+
+	events := auditor.Events()
+	if len(events) != 1 || events[0].EventType != EventBlocked {
+		t.Errorf("expected one blocked event, got %+v", events)
+	}
+}
+
+func TestCheckAuditOnly(t *testing.T) {
+	auditor := &MemoryAuditor{}
+	guard := NewGuard(testPolicy(false), "production", auditor)
+
+	if err := guard.Check("exporter.otlp-grpc", "", "us-east-1"); err != nil {
+		t.Errorf("expected audit-only mode to allow the region: %v", err)
+	}
+	if events := auditor.Events(); len(events) != 1 || events[0].EventType != EventViolation {
+		t.Errorf("expected one violation event, got %+v", events)
+	}
+
+	// Environments without a policy allow every region
+	if err := NewGuard(testPolicy(true), "development", nil).Check("cloud.aws", "aws", "us-east-1"); err != nil {
+		t.Errorf("expected development to be unrestricted: %v", err)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	auditor := &MemoryAuditor{}
+	guard := NewGuard(testPolicy(true), "production", auditor)
+
+	if err := guard.Grant(Override{Region: "us-east-1"}); err == nil {
+		t.Error("expected override without actor and reason to be rejected")
+	}
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	guard.now = func() time.Time { return now }
+	if err := guard.Grant(Override{
+		Component: "cloud.aws",
+		Region:    "us-east-1",
+		Actor:     "alice",
+		Reason:    "DR test",
+		Expires:   now.Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := guard.Check("cloud.aws", "aws", "us-east-1"); err != nil {
+		t.Errorf("expected override to allow region: %v", err)
+	}
+	if err := guard.Check("exporter.otlp-http", "", "us-east-1"); err == nil {
+		t.Error("expected override to be scoped to its component")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := guard.Check("cloud.aws", "aws", "us-east-1"); err == nil {
+		t.Error("expected expired override to be ignored")
+	}
+
+	types := make(map[string]int)
+	for _, e := range auditor.Events() {
+		types[e.EventType]++
+	}
+	if types[EventOverrideGranted] != 1 || types[EventOverrideUsed] != 1 || types[EventBlocked] != 2 {
+		t.Errorf("unexpected audit trail: %v", types)
+	}
+}
+
+func TestInferRegion(t *testing.T) {
+	tests := map[string]string{
+		"https://otlp.eu-west-1.amazonaws.com:4318": "eu-west-1",
+		"collector.europe-west1.example.com:4317":   "europe-west1",
+		"logs.us-gov-west-1.amazonaws.com":          "us-gov-west-1",
+		"localhost:4317":                            "",
+	}
+	for endpoint, want := range tests {
+		if got := InferRegion(endpoint); got != want {
+			t.Errorf("InferRegion(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package retention
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ApplyLokiConfig sets compactor retention in a Loki configuration file,
+// preserving the rest of the document
+func (p Policy) ApplyLokiConfig(data []byte) ([]byte, error) {
+	if p.Logs.Period == "" {
+		return data, nil
+	}
+
+	d, err := ParseDuration(p.Logs.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{
+		"limits_config.retention_period": formatHours(d),
+		"compactor.retention_enabled":    "true",
+	}
+	if p.Logs.DeleteDelay != "" {
+		delay, err := ParseDuration(p.Logs.DeleteDelay)
+		if err != nil {
+			return nil, err
+		}
+		values["compactor.retention_delete_delay"] = formatHours(delay)
+	}
+
+	return setYAMLValues(data, values)
+}
+
+// ApplyTempoConfig sets the compactor block retention in a Tempo configuration file
+func (p Policy) ApplyTempoConfig(data []byte) ([]byte, error) {
+	if p.Traces.Period == "" {
+		return data, nil
+	}
+
+	d, err := ParseDuration(p.Traces.Period)
+	if err != nil {
+		return nil, err
+	}
+
+	return setYAMLValues(data, map[string]string{
+		"compactor.compaction.block_retention": formatHours(d),
+	})
+}
+
+// setYAMLValues sets dotted paths to scalar values in a YAML document
+func setYAMLValues(data []byte, values map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	root := doc.Content[0]
+	for path, value := range values {
+		if err := setYAMLPath(root, splitPath(path), value); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setYAMLPath walks mapping nodes, creating them as needed, and sets the leaf
+func setYAMLPath(node *yaml.Node, path []string, value string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping")
+	}
+
+	key := path[0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			continue
+		}
+		child := node.Content[i+1]
+		if len(path) == 1 {
+			child.Kind = yaml.ScalarNode
+			child.Tag = ""
+			child.Value = value
+			child.Content = nil
+			return nil
+		}
+		return setYAMLPath(child, path[1:], value)
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+	if len(path) == 1 {
+		node.Content = append(node.Content, keyNode, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, keyNode, child)
+	return setYAMLPath(child, path[1:], value)
+}
+
+// lookupYAMLPath returns the scalar at a dotted path, or "" when absent
+func lookupYAMLPath(data []byte, path string) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse config: %w", err)
+	}
+	if doc.Kind == 0 {
+		return "", nil
+	}
+
+	node := doc.Content[0]
+	for _, key := range splitPath(path) {
+		if node.Kind != yaml.MappingNode {
+			return "", nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return "", nil
+		}
+		node = next
+	}
+	return node.Value, nil
+}
+
+// splitPath splits a dotted path
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
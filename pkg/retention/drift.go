@@ -0,0 +1,262 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/cmdrun"
+	"github.com/chaksack/apm/pkg/progress"
+)
+
+// Drift describes a backend whose retention differs from the policy
+type Drift struct {
+	Component string `json:"component"`
+	Setting   string `json:"setting"`
+	Desired   string `json:"desired"`
+	Actual    string `json:"actual"`
+}
+
+// String formats the drift for CLI output
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: desired %s, actual %s", d.Component, d.Setting, d.Desired, d.Actual)
+}
+
+// CommandRunner runs a CLI command and returns its standard output
+type CommandRunner = cmdrun.Runner
+
+// Detector compares live backend retention with the policy
+type Detector struct {
+	Policy Policy
+
+	// Backend base URLs; empty URLs are skipped
+	PrometheusURL string
+	LokiURL       string
+	TempoURL      string
+
+	// Client is used for HTTP backends; nil uses a client with a 10s timeout
+	Client *http.Client
+
+	// Run executes the aws CLI for CloudWatch; nil uses os/exec
+	Run CommandRunner
+}
+
+// Detect returns all drift found. Backends that cannot be queried are reported
+// in the returned error while the remaining backends are still checked.
+func (d *Detector) Detect(ctx context.Context) ([]Drift, error) {
+	var drifts []Drift
+	var errs []error
+
+	checks := []struct {
+		name    string
+		enabled bool
+		check   func(context.Context) ([]Drift, error)
+	}{
+		{"prometheus", d.PrometheusURL != "" && d.Policy.Metrics.Period != "", d.checkPrometheus},
+		{"loki", d.LokiURL != "" && d.Policy.Logs.Period != "", d.checkLoki},
+		{"tempo", d.TempoURL != "" && d.Policy.Traces.Period != "" && d.Policy.Traces.Backend == TraceBackendTempo, d.checkTempo},
+		{"cloudwatch", d.Policy.CloudLogs.Period != "", d.checkCloudWatch},
+	}
+
+	tracker := progress.FromContext(ctx)
+	for _, c := range checks {
+		if c.enabled {
+			tracker.AddTotal(1)
+		}
+	}
+
+	for _, c := range checks {
+		if !c.enabled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return drifts, err
+		}
+		tracker.Step(c.name)
+		found, err := c.check(ctx)
+		tracker.Advance(1)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		drifts = append(drifts, found...)
+	}
+
+	return drifts, errors.Join(errs...)
+}
+
+// checkPrometheus compares the running TSDB retention flags
+func (d *Detector) checkPrometheus(ctx context.Context) ([]Drift, error) {
+	body, err := d.get(ctx, strings.TrimRight(d.PrometheusURL, "/")+"/api/v1/status/flags")
+	if err != nil {
+		return nil, fmt.Errorf("prometheus: %w", err)
+	}
+
+	var resp struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("prometheus: invalid flags response: %w", err)
+	}
+
+	var drifts []Drift
+	if drift, ok := compareDuration("prometheus", "storage.tsdb.retention.time", d.Policy.Metrics.Period, resp.Data["storage.tsdb.retention.time"]); ok {
+		drifts = append(drifts, drift)
+	}
+	if want := d.Policy.Metrics.Size; want != "" {
+		if got := resp.Data["storage.tsdb.retention.size"]; !strings.EqualFold(got, want) {
+			drifts = append(drifts, Drift{Component: "prometheus", Setting: "storage.tsdb.retention.size", Desired: want, Actual: got})
+		}
+	}
+	return drifts, nil
+}
+
+// checkLoki compares the running compactor retention
+func (d *Detector) checkLoki(ctx context.Context) ([]Drift, error) {
+	body, err := d.get(ctx, strings.TrimRight(d.LokiURL, "/")+"/config")
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+
+	period, err := lookupYAMLPath(body, "limits_config.retention_period")
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+	enabled, err := lookupYAMLPath(body, "compactor.retention_enabled")
+	if err != nil {
+		return nil, fmt.Errorf("loki: %w", err)
+	}
+
+	var drifts []Drift
+	if enabled != "true" {
+		drifts = append(drifts, Drift{Component: "loki", Setting: "compactor.retention_enabled", Desired: "true", Actual: enabled})
+	}
+	if drift, ok := compareDuration("loki", "limits_config.retention_period", d.Policy.Logs.Period, period); ok {
+		drifts = append(drifts, drift)
+	}
+	return drifts, nil
+}
+
+// checkTempo compares the running compactor block retention
+func (d *Detector) checkTempo(ctx context.Context) ([]Drift, error) {
+	body, err := d.get(ctx, strings.TrimRight(d.TempoURL, "/")+"/status/config")
+	if err != nil {
+		return nil, fmt.Errorf("tempo: %w", err)
+	}
+
+	retention, err := lookupYAMLPath(body, "compactor.compaction.block_retention")
+	if err != nil {
+		return nil, fmt.Errorf("tempo: %w", err)
+	}
+
+	if drift, ok := compareDuration("tempo", "compactor.compaction.block_retention", d.Policy.Traces.Period, retention); ok {
+		return []Drift{drift}, nil
+	}
+	return nil, nil
+}
+
+// checkCloudWatch compares the retention of each configured log group
+func (d *Detector) checkCloudWatch(ctx context.Context) ([]Drift, error) {
+	want, err := d.Policy.CloudWatchRetentionDays()
+	if err != nil {
+		return nil, err
+	}
+
+	run := d.Run
+	if run == nil {
+		run = cmdrun.Exec
+	}
+
+	var drifts []Drift
+	for _, group := range d.Policy.CloudLogs.LogGroups {
+		args := []string{"logs", "describe-log-groups", "--log-group-name-prefix", group, "--output", "json"}
+		if d.Policy.CloudLogs.Region != "" {
+			args = append(args, "--region", d.Policy.CloudLogs.Region)
+		}
+
+		out, err := run(ctx, "aws", args...)
+		if err != nil {
+			return nil, fmt.Errorf("cloudwatch: failed to describe log group %s: %w", group, err)
+		}
+
+		var resp struct {
+			LogGroups []struct {
+				LogGroupName    string `json:"logGroupName"`
+				RetentionInDays *int   `json:"retentionInDays"`
+			} `json:"logGroups"`
+		}
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return nil, fmt.Errorf("cloudwatch: invalid describe-log-groups output: %w", err)
+		}
+
+		actual := "missing"
+		for _, lg := range resp.LogGroups {
+			if lg.LogGroupName != group {
+				continue
+			}
+			actual = "never expire"
+			if lg.RetentionInDays != nil {
+				actual = strconv.Itoa(*lg.RetentionInDays)
+			}
+		}
+
+		if actual != strconv.Itoa(want) {
+			drifts = append(drifts, Drift{
+				Component: "cloudwatch",
+				Setting:   "retentionInDays[" + group + "]",
+				Desired:   strconv.Itoa(want),
+				Actual:    actual,
+			})
+		}
+	}
+	return drifts, nil
+}
+
+// get fetches a URL and returns the body of a successful response
+func (d *Detector) get(ctx context.Context, url string) ([]byte, error) {
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// compareDuration reports drift when the actual duration differs from the desired one
+func compareDuration(component, setting, desired, actual string) (Drift, bool) {
+	drift := Drift{Component: component, Setting: setting, Desired: desired, Actual: actual}
+	if actual == "" {
+		drift.Actual = "unset"
+		return drift, true
+	}
+
+	want, err := ParseDuration(desired)
+	if err != nil {
+		return drift, true
+	}
+	got, err := ParseDuration(actual)
+	if err != nil || got != want {
+		return drift, true
+	}
+	return Drift{}, false
+}
@@ -0,0 +1,244 @@
+// Package retention manages a single retention policy across the telemetry
+// backends. The policy declared in apm.yaml is translated into Prometheus
+// flags, Loki compactor settings, Jaeger or Tempo retention, and CloudWatch
+// log group retention, and a Detector reports out-of-band changes.
+package retention
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is the unified retention configuration
+type Policy struct {
+	Metrics   MetricsRetention   `yaml:"metrics" json:"metrics" mapstructure:"metrics"`
+	Logs      LogsRetention      `yaml:"logs" json:"logs" mapstructure:"logs"`
+	Traces    TracesRetention    `yaml:"traces" json:"traces" mapstructure:"traces"`
+	CloudLogs CloudLogsRetention `yaml:"cloud_logs" json:"cloud_logs" mapstructure:"cloud_logs"`
+}
+
+// MetricsRetention configures Prometheus TSDB retention
+type MetricsRetention struct {
+	Period string `yaml:"period" json:"period" mapstructure:"period"`     // e.g. "15d"
+	Size   string `yaml:"size" json:"size,omitempty" mapstructure:"size"` // e.g. "50GB"
+}
+
+// LogsRetention configures Loki compactor retention
+type LogsRetention struct {
+	Period      string `yaml:"period" json:"period" mapstructure:"period"`
+	DeleteDelay string `yaml:"delete_delay" json:"delete_delay,omitempty" mapstructure:"delete_delay"`
+}
+
+// Trace storage backends
+const (
+	TraceBackendBadger        = "badger"
+	TraceBackendCassandra     = "cassandra"
+	TraceBackendElasticsearch = "elasticsearch"
+	TraceBackendTempo         = "tempo"
+)
+
+// TracesRetention configures Jaeger storage or Tempo retention
+type TracesRetention struct {
+	Period  string `yaml:"period" json:"period" mapstructure:"period"`
+	Backend string `yaml:"backend" json:"backend" mapstructure:"backend"` // badger, cassandra, elasticsearch, tempo
+}
+
+// CloudLogsRetention configures CloudWatch log group retention
+type CloudLogsRetention struct {
+	Period    string   `yaml:"period" json:"period" mapstructure:"period"`
+	LogGroups []string `yaml:"log_groups" json:"log_groups" mapstructure:"log_groups"`
+	Region    string   `yaml:"region" json:"region,omitempty" mapstructure:"region"`
+}
+
+// cloudWatchRetentionDays are the retention values accepted by CloudWatch Logs
+var cloudWatchRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+// ParseDuration converts retention strings such as "15d", "720h", "2w", or "1y" to a duration
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty retention")
+	}
+
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q", s)
+	}
+	return d, nil
+}
+
+// Validate checks every configured period
+func (p Policy) Validate() error {
+	periods := map[string]string{
+		"metrics.period":    p.Metrics.Period,
+		"logs.period":       p.Logs.Period,
+		"logs.delete_delay": p.Logs.DeleteDelay,
+		"traces.period":     p.Traces.Period,
+		"cloud_logs.period": p.CloudLogs.Period,
+	}
+	for name, value := range periods {
+		if value == "" {
+			continue
+		}
+		if d, err := ParseDuration(value); err != nil {
+			return fmt.Errorf("retention.%s: %w", name, err)
+		} else if d <= 0 {
+			return fmt.Errorf("retention.%s must be positive", name)
+		}
+	}
+
+	switch p.Traces.Backend {
+	case "", TraceBackendBadger, TraceBackendCassandra, TraceBackendElasticsearch, TraceBackendTempo:
+	default:
+		return fmt.Errorf("retention.traces.backend %q is not supported", p.Traces.Backend)
+	}
+
+	if p.CloudLogs.Period != "" && len(p.CloudLogs.LogGroups) == 0 {
+		return fmt.Errorf("retention.cloud_logs.log_groups is required when a period is set")
+	}
+	return nil
+}
+
+// Settings returns the configured period per component, for reporting
+func (p Policy) Settings() map[string]string {
+	settings := make(map[string]string)
+	if p.Metrics.Period != "" {
+		settings["metrics"] = p.Metrics.Period
+	}
+	if p.Logs.Period != "" {
+		settings["logs"] = p.Logs.Period
+	}
+	if p.Traces.Period != "" {
+		settings["traces"] = p.Traces.Period
+	}
+	if p.CloudLogs.Period != "" {
+		settings["cloud_logs"] = p.CloudLogs.Period
+	}
+	return settings
+}
+
+// PrometheusFlags returns the TSDB retention flags for the Prometheus server
+func (p Policy) PrometheusFlags() ([]string, error) {
+	var flags []string
+	if p.Metrics.Period != "" {
+		d, err := ParseDuration(p.Metrics.Period)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, "--storage.tsdb.retention.time="+formatDuration(d))
+	}
+	if p.Metrics.Size != "" {
+		flags = append(flags, "--storage.tsdb.retention.size="+p.Metrics.Size)
+	}
+	return flags, nil
+}
+
+// JaegerSettings holds Jaeger storage retention settings
+type JaegerSettings struct {
+	// Env is added to the Jaeger collector (badger) or schema job (cassandra)
+	Env map[string]string
+	// IndexCleanerDays is the argument for jaeger-es-index-cleaner (elasticsearch)
+	IndexCleanerDays int
+}
+
+// JaegerSettings translates the trace retention for Jaeger storage backends
+func (p Policy) JaegerSettings() (JaegerSettings, error) {
+	settings := JaegerSettings{Env: make(map[string]string)}
+	if p.Traces.Period == "" {
+		return settings, nil
+	}
+
+	d, err := ParseDuration(p.Traces.Period)
+	if err != nil {
+		return settings, err
+	}
+
+	switch p.Traces.Backend {
+	case "", TraceBackendBadger:
+		settings.Env["BADGER_SPAN_STORE_TTL"] = formatHours(d)
+	case TraceBackendCassandra:
+		settings.Env["TRACE_TTL"] = strconv.Itoa(int(d.Seconds()))
+	case TraceBackendElasticsearch:
+		settings.IndexCleanerDays = ceilDays(d)
+	case TraceBackendTempo:
+		return settings, fmt.Errorf("tempo retention is applied with ApplyTempoConfig")
+	}
+	return settings, nil
+}
+
+// CloudWatchRetentionDays returns the smallest CloudWatch retention value
+// that keeps logs at least as long as the policy requires
+func (p Policy) CloudWatchRetentionDays() (int, error) {
+	d, err := ParseDuration(p.CloudLogs.Period)
+	if err != nil {
+		return 0, err
+	}
+	days := ceilDays(d)
+	for _, allowed := range cloudWatchRetentionDays {
+		if allowed >= days {
+			return allowed, nil
+		}
+	}
+	return 0, fmt.Errorf("retention of %d days exceeds the CloudWatch maximum of %d", days, cloudWatchRetentionDays[len(cloudWatchRetentionDays)-1])
+}
+
+// CloudWatchCommands returns the aws CLI arguments that apply the log group retention
+func (p Policy) CloudWatchCommands() ([][]string, error) {
+	if p.CloudLogs.Period == "" {
+		return nil, nil
+	}
+
+	days, err := p.CloudWatchRetentionDays()
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make([][]string, 0, len(p.CloudLogs.LogGroups))
+	for _, group := range p.CloudLogs.LogGroups {
+		args := []string{"logs", "put-retention-policy",
+			"--log-group-name", group,
+			"--retention-in-days", strconv.Itoa(days)}
+		if p.CloudLogs.Region != "" {
+			args = append(args, "--region", p.CloudLogs.Region)
+		}
+		commands = append(commands, args)
+	}
+	return commands, nil
+}
+
+// formatDuration renders whole days as "Nd" and anything else in hours
+func formatDuration(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
+	return formatHours(d)
+}
+
+// formatHours renders a duration as a Go duration in hours, as Loki and Tempo expect
+func formatHours(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return d.String()
+}
+
+// ceilDays rounds a duration up to whole days
+func ceilDays(d time.Duration) int {
+	day := 24 * time.Hour
+	return int((d + day - 1) / day)
+}
@@ -0,0 +1,90 @@
+package retention
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPolicy() Policy {
+	return Policy{
+		Metrics:   MetricsRetention{Period: "15d"},
+		Logs:      LogsRetention{Period: "30d"},
+		Traces:    TracesRetention{Period: "72h", Backend: TraceBackendBadger},
+		CloudLogs: CloudLogsRetention{Period: "45d", LogGroups: []string{"/apm/app"}},
+	}
+}
+
+func TestTranslations(t *testing.T) {
+	p := testPolicy()
+
+	flags, err := p.PrometheusFlags()
+	if err != nil || !reflect.DeepEqual(flags, []string{"--storage.tsdb.retention.time=15d"}) {
+		t.Errorf("unexpected prometheus flags %v (%v)", flags, err)
+	}
+
+	jaeger, err := p.JaegerSettings()
+	if err != nil || jaeger.Env["BADGER_SPAN_STORE_TTL"] != "72h" {
+		t.Errorf("unexpected jaeger settings %+v (%v)", jaeger, err)
+	}
+
+	days, err := p.CloudWatchRetentionDays()
+	if err != nil || days != 60 {
+		t.Errorf("expected 45d to round up to 60 days, got %d (%v)", days, err)
+	}
+
+	loki, err := p.ApplyLokiConfig([]byte("auth_enabled: false\ncompactor:\n  working_directory: /loki/compactor\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"auth_enabled: false", "working_directory: /loki/compactor", "retention_enabled: true", "retention_period: 720h"} {
+		if !strings.Contains(string(loki), want) {
+			t.Errorf("expected loki config to contain %q, got:\n%s", want, loki)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/status/flags":
+			w.Write([]byte(`{"status":"success","data":{"storage.tsdb.retention.time":"360h"}}`))
+		case "/config":
+			w.Write([]byte("limits_config:\n  retention_period: 168h\ncompactor:\n  retention_enabled: true\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	detector := &Detector{
+		Policy:        testPolicy(),
+		PrometheusURL: server.URL,
+		LokiURL:       server.URL,
+		Run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			return []byte(`{"logGroups":[{"logGroupName":"/apm/app","retentionInDays":14}]}`), nil
+		},
+	}
+
+	drifts, err := detector.Detect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := make(map[string]Drift)
+	for _, d := range drifts {
+		got[d.Component] = d
+	}
+	if _, ok := got["prometheus"]; ok {
+		t.Error("expected 360h to match 15d")
+	}
+	if d, ok := got["loki"]; !ok || d.Actual != "168h" {
+		t.Errorf("expected loki drift, got %+v", drifts)
+	}
+	if d, ok := got["cloudwatch"]; !ok || d.Desired != "60" || d.Actual != "14" {
+		t.Errorf("expected cloudwatch drift, got %+v", drifts)
+	}
+}
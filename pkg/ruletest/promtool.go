@@ -0,0 +1,166 @@
+package ruletest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPromtoolImage runs promtool when it is not on PATH; it matches the
+// Prometheus of the generated stack
+const DefaultPromtoolImage = "prom/prometheus:v2.48.0"
+
+// ErrNoPromtool is returned when neither promtool nor docker is available
+var ErrNoPromtool = errors.New("promtool not found: install Prometheus' promtool or docker")
+
+// Promtool runs promtool from PATH, or from the Prometheus image through
+// docker with Dir mounted, in which case every file must be inside Dir
+type Promtool struct {
+	// Path is the promtool binary, default promtool on PATH
+	Path string
+	// Image is used when promtool is not found, default DefaultPromtoolImage
+	Image string
+	// Dir is the working directory, default the current one
+	Dir string
+}
+
+// command returns the command running promtool with args
+func (p *Promtool) command(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	dir := p.Dir
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	path := p.Path
+	if path == "" {
+		path = "promtool"
+	}
+	if found, err := exec.LookPath(path); err == nil {
+		cmd := exec.CommandContext(ctx, found, args...)
+		cmd.Dir = dir
+		return cmd, nil
+	} else if p.Path != "" {
+		return nil, fmt.Errorf("promtool %s: %w", p.Path, err)
+	}
+
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return nil, ErrNoPromtool
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	image := p.Image
+	if image == "" {
+		image = DefaultPromtoolImage
+	}
+	for n, arg := range args {
+		if strings.HasPrefix(arg, "-") || n < 2 {
+			continue
+		}
+		if filepath.IsAbs(arg) {
+			rel, err := filepath.Rel(abs, arg)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return nil, fmt.Errorf("%s is outside %s, which is mounted into the promtool container", arg, abs)
+			}
+			args[n] = rel
+		}
+		args[n] = filepath.ToSlash(args[n])
+	}
+	dockerArgs := append([]string{"run", "--rm", "-v", abs + ":/work", "-w", "/work", "--entrypoint", "promtool", image}, args...)
+	cmd := exec.CommandContext(ctx, docker, dockerArgs...)
+	cmd.Dir = dir
+	return cmd, nil
+}
+
+// run runs promtool and returns its output; a non-zero exit is reported as
+// failed, other errors are returned
+func (p *Promtool) run(ctx context.Context, args ...string) (output string, failed bool, d time.Duration, err error) {
+	cmd, err := p.command(ctx, args...)
+	if err != nil {
+		return "", false, 0, err
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	start := time.Now()
+	err = cmd.Run()
+	d = time.Since(start)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return out.String(), true, d, nil
+	}
+	if err != nil {
+		return "", false, d, fmt.Errorf("failed to run promtool: %w", err)
+	}
+	return out.String(), false, d, nil
+}
+
+// CheckRules checks the syntax and expressions of each rule file
+func (p *Promtool) CheckRules(ctx context.Context, files []string) ([]Result, error) {
+	results := make([]Result, 0, len(files))
+	for _, file := range files {
+		output, failed, d, err := p.run(ctx, "check", "rules", file)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result(SuiteRules, file, output, failed, d))
+	}
+	return results, nil
+}
+
+// TestRules runs the unit tests of each test file
+func (p *Promtool) TestRules(ctx context.Context, files []string) ([]Result, error) {
+	results := make([]Result, 0, len(files))
+	for _, file := range files {
+		output, failed, d, err := p.run(ctx, "test", "rules", file)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result(SuiteTests, file, output, failed, d))
+	}
+	return results, nil
+}
+
+// CheckConfig checks a Prometheus config and the rule files it loads
+func (p *Promtool) CheckConfig(ctx context.Context, file string) (Result, error) {
+	output, failed, d, err := p.run(ctx, "check", "config", file)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(SuiteConfig, file, output, failed, d), nil
+}
+
+// result converts promtool's output for a file, keeping the lines that
+// explain the outcome
+func result(suite, file, output string, failed bool, d time.Duration) Result {
+	r := Result{Suite: suite, Name: file, File: file, Status: StatusPassed, Duration: d}
+	if failed {
+		r.Status = StatusFailed
+	}
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", trimmed == "SUCCESS", trimmed == "FAILED:",
+			strings.HasPrefix(trimmed, "Checking "), strings.HasPrefix(trimmed, "Unit Testing: "):
+			continue
+		case strings.HasPrefix(trimmed, "SUCCESS: "):
+			trimmed = strings.TrimPrefix(trimmed, "SUCCESS: ")
+		case strings.HasPrefix(trimmed, "FAILED: "):
+			trimmed = strings.TrimPrefix(trimmed, "FAILED: ")
+		}
+		lines = append(lines, trimmed)
+	}
+	r.Message = strings.Join(lines, "\n")
+	return r
+}
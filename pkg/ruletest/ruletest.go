@@ -0,0 +1,289 @@
+// Package ruletest tests Prometheus alerting rules before they ship: rule
+// files are checked, unit tests feed rules synthetic series and compare the
+// alerts they fire with the expected ones, alerts without a test are
+// reported, and the scrape targets of a Prometheus config are checked
+// against a live server. Results are written as text, JSON, JUnit XML, or
+// GitHub Actions annotations.
+//
+// Rules are evaluated by promtool, from PATH or the Prometheus image, so
+// tests use promtool's test file format:
+//
+//	rule_files: [../alerts/basic-alerts.yml]
+//	tests:
+//	  - interval: 1m
+//	    input_series:
+//	      - series: 'up{job="api", instance="api:8080"}'
+//	        values: '1 1 0x10'
+//	    alert_rule_test:
+//	      - eval_time: 10m
+//	        alertname: InstanceDown
+//	        exp_alerts:
+//	          - exp_labels: {severity: critical, job: api, instance: "api:8080"}
+package ruletest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Suites group the results
+const (
+	SuiteRules    = "rules"
+	SuiteTests    = "tests"
+	SuiteCoverage = "coverage"
+	SuiteConfig   = "config"
+	SuiteTargets  = "targets"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"
+	StatusSkipped Status = "skipped"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Suite    string        `json:"suite"`
+	Name     string        `json:"name"`
+	File     string        `json:"file,omitempty"`
+	Status   Status        `json:"status"`
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report collects the results of a run
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Add appends results
+func (r *Report) Add(results ...Result) {
+	r.Results = append(r.Results, results...)
+}
+
+// Count returns the number of results with the status
+func (r *Report) Count(status Status) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Tests   int          `xml:"tests,attr"`
+	Failed  int          `xml:"failures,attr"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name    string      `xml:"name,attr"`
+	Tests   int         `xml:"tests,attr"`
+	Failed  int         `xml:"failures,attr"`
+	Skipped int         `xml:"skipped,attr"`
+	Time    string      `xml:"time,attr"`
+	Cases   []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the report as JUnit XML, one test suite per suite
+func (r *Report) WriteJUnit(w io.Writer) error {
+	out := junitSuites{Tests: len(r.Results), Failed: r.Count(StatusFailed)}
+	index := make(map[string]int)
+	var durations []time.Duration
+	for _, result := range r.Results {
+		n, ok := index[result.Suite]
+		if !ok {
+			n = len(out.Suites)
+			index[result.Suite] = n
+			out.Suites = append(out.Suites, junitSuite{Name: "apm." + result.Suite})
+			durations = append(durations, 0)
+		}
+		suite := &out.Suites[n]
+		tc := junitCase{
+			Name:      result.Name,
+			ClassName: "apm." + result.Suite,
+			File:      result.File,
+			Time:      seconds(result.Duration),
+		}
+		firstLine, _, _ := strings.Cut(result.Message, "\n")
+		switch result.Status {
+		case StatusFailed:
+			tc.Failure = &junitMessage{Message: firstLine, Text: result.Message}
+			suite.Failed++
+		case StatusSkipped:
+			tc.Skipped = &junitMessage{Message: firstLine}
+			suite.Skipped++
+		}
+		suite.Tests++
+		durations[n] += result.Duration
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for n := range out.Suites {
+		out.Suites[n].Time = seconds(durations[n])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// githubEscaper escapes workflow command data
+var githubEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+// githubPropertyEscaper escapes workflow command properties
+var githubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+// WriteGitHub writes the failures as GitHub Actions error annotations and
+// the untested alerts as warnings, which show on the pull request's files
+func (r *Report) WriteGitHub(w io.Writer) error {
+	for _, result := range r.Results {
+		level := ""
+		switch {
+		case result.Status == StatusFailed:
+			level = "error"
+		case result.Status == StatusSkipped && result.Suite == SuiteCoverage:
+			level = "warning"
+		default:
+			continue
+		}
+		props := "title=" + githubPropertyEscaper.Replace(result.Suite+": "+result.Name)
+		if result.File != "" {
+			props = "file=" + githubPropertyEscaper.Replace(filepath.ToSlash(result.File)) + "," + props
+		}
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", level, props, githubEscaper.Replace(result.Message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindFiles returns the .yml and .yaml files of the paths, with directories
+// searched recursively. Missing paths are skipped.
+func FindFiles(paths ...string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(path); !d.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// testFile is the part of a promtool test file read for coverage
+type testFile struct {
+	Tests []struct {
+		AlertRuleTests []struct {
+			AlertName string `yaml:"alertname"`
+		} `yaml:"alert_rule_test"`
+	} `yaml:"tests"`
+}
+
+// TestedAlerts returns the alerts each test file has an alert_rule_test for
+func TestedAlerts(testFiles []string) (map[string][]string, error) {
+	tested := make(map[string][]string)
+	for _, name := range testFiles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var tf testFile
+		if err := yaml.Unmarshal(data, &tf); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		for _, test := range tf.Tests {
+			for _, art := range test.AlertRuleTests {
+				if art.AlertName != "" && !slices.Contains(tested[art.AlertName], name) {
+					tested[art.AlertName] = append(tested[art.AlertName], name)
+				}
+			}
+		}
+	}
+	return tested, nil
+}
+
+// Alert is an alerting rule checked for coverage
+type Alert struct {
+	Name string
+	File string
+}
+
+// Coverage reports which alerts have a unit test. Untested alerts are
+// skipped, or failed when required.
+func Coverage(alerts []Alert, tested map[string][]string, required bool) []Result {
+	results := make([]Result, 0, len(alerts))
+	for _, alert := range alerts {
+		result := Result{Suite: SuiteCoverage, Name: alert.Name, File: alert.File, Status: StatusPassed}
+		if files := tested[alert.Name]; len(files) > 0 {
+			sort.Strings(files)
+			result.Message = "tested in " + strings.Join(files, ", ")
+		} else {
+			result.Status = StatusSkipped
+			if required {
+				result.Status = StatusFailed
+			}
+			result.Message = "no alert_rule_test for " + alert.Name
+		}
+		results = append(results, result)
+	}
+	return results
+}
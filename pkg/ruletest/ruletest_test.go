@@ -0,0 +1,234 @@
+package ruletest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakePromtool writes a promtool stand-in that fails for files named
+// broken*, printing what promtool prints
+func fakePromtool(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake promtool is a shell script")
+	}
+	path := filepath.Join(t.TempDir(), "promtool")
+	script := `#!/bin/sh
+file="$3"
+case "$1 $2" in
+"test rules")
+	echo "Unit Testing:  $file"
+	case "$file" in
+	*broken*)
+		echo "  FAILED:"
+		echo "    alertname: InstanceDown, time: 10m,"
+		echo "        exp:[0:Labels:{alertname=\"InstanceDown\", severity=\"critical\"}]"
+		echo "        got:[]"
+		exit 1 ;;
+	esac
+	echo "  SUCCESS" ;;
+*)
+	echo "Checking $file"
+	case "$file" in
+	*broken*)
+		echo "  FAILED:"
+		echo "$file: group \"basic\", rule 1, \"InstanceDown\": could not parse expression"
+		exit 1 ;;
+	esac
+	echo "  SUCCESS: 2 rules found" ;;
+esac
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPromtool(t *testing.T) {
+	p := &Promtool{Path: fakePromtool(t)}
+	ctx := context.Background()
+
+	results, err := p.CheckRules(ctx, []string{"alerts.yml", "broken-alerts.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusPassed || results[0].Message != "2 rules found" {
+		t.Errorf("passing rule file = %+v", results[0])
+	}
+	if results[1].Status != StatusFailed || !strings.Contains(results[1].Message, "could not parse expression") {
+		t.Errorf("failing rule file = %+v", results[1])
+	}
+
+	results, err = p.TestRules(ctx, []string{"alerts_test.yml", "broken_test.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != StatusPassed || results[0].Suite != SuiteTests {
+		t.Errorf("passing test = %+v", results[0])
+	}
+	if results[1].Status != StatusFailed || !strings.HasPrefix(results[1].Message, "alertname: InstanceDown, time: 10m,") {
+		t.Errorf("failing test = %+v", results[1])
+	}
+
+	missing := &Promtool{Path: filepath.Join(t.TempDir(), "promtool")}
+	if _, err := missing.CheckRules(ctx, []string{"alerts.yml"}); err == nil {
+		t.Error("missing promtool binary not reported")
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "basic_test.yml")
+	if err := os.WriteFile(testFile, []byte(`rule_files: [alerts.yml]
+tests:
+  - interval: 1m
+    input_series:
+      - series: up{job="api"}
+        values: 1 0x10
+    alert_rule_test:
+      - eval_time: 10m
+        alertname: InstanceDown
+        exp_alerts: [{exp_labels: {severity: critical, job: api}}]
+      - eval_time: 2m
+        alertname: InstanceDown
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tested, err := TestedAlerts([]string{testFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := []Alert{{Name: "InstanceDown", File: "alerts.yml"}, {Name: "HighCPUUsage", File: "alerts.yml"}}
+
+	results := Coverage(alerts, tested, false)
+	if results[0].Status != StatusPassed || results[0].Message != "tested in "+testFile {
+		t.Errorf("tested alert = %+v", results[0])
+	}
+	if results[1].Status != StatusSkipped {
+		t.Errorf("untested alert = %+v", results[1])
+	}
+	if results := Coverage(alerts, tested, true); results[1].Status != StatusFailed {
+		t.Errorf("untested alert with coverage required = %+v", results[1])
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/targets" || r.URL.Query().Get("state") != "active" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"activeTargets":[
+			{"scrapePool":"node-exporter","scrapeUrl":"http://node-exporter:9100/metrics","labels":{"instance":"docker-host","job":"node-exporter"},"health":"up","lastError":"","lastScrapeDuration":0.012},
+			{"scrapePool":"sample-app","scrapeUrl":"http://sample-app:9091/metrics","labels":{"instance":"sample-app:9091","job":"sample-app"},"health":"down","lastError":"connection refused","lastScrapeDuration":0},
+			{"scrapePool":"kubernetes-pods","scrapeUrl":"http://10.0.0.5:8080/metrics","labels":{"job":"kubernetes-pods"},"health":"up","lastError":"","lastScrapeDuration":0.2}
+		]}}`))
+	}))
+	defer server.Close()
+
+	config := filepath.Join(t.TempDir(), "prometheus.yml")
+	if err := os.WriteFile(config, []byte(`scrape_configs:
+  - job_name: node-exporter
+    static_configs: [{targets: ['node-exporter:9100']}]
+  - job_name: sample-app
+    static_configs: [{targets: ['sample-app:9091']}]
+  - job_name: cadvisor
+    static_configs: [{targets: ['cadvisor:8080']}]
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := CheckTargets(context.Background(), server.Client(), server.URL, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name   string
+		status Status
+	}{
+		{"node-exporter docker-host", StatusPassed},
+		{"sample-app sample-app:9091", StatusFailed},
+		{"cadvisor", StatusFailed},
+		{"kubernetes-pods", StatusSkipped},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("%d results, want %d: %+v", len(results), len(want), results)
+	}
+	for n, w := range want {
+		if results[n].Name != w.name || results[n].Status != w.status {
+			t.Errorf("result %d = %s %s, want %s %s", n, results[n].Name, results[n].Status, w.name, w.status)
+		}
+	}
+	if !strings.Contains(results[1].Message, "connection refused") {
+		t.Errorf("down target message = %q", results[1].Message)
+	}
+
+	if _, err := CheckTargets(context.Background(), server.Client(), server.URL+"/missing", config); err == nil {
+		t.Error("failed Prometheus query not reported")
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	report := &Report{}
+	report.Add(
+		Result{Suite: SuiteRules, Name: "alerts.yml", File: "alerts.yml", Status: StatusPassed},
+		Result{Suite: SuiteTests, Name: "alerts_test.yml", File: "alerts_test.yml", Status: StatusFailed, Message: "alertname: InstanceDown, time: 10m,\ngot:[]"},
+		Result{Suite: SuiteCoverage, Name: "HighCPUUsage", File: "alerts.yml", Status: StatusSkipped, Message: "no alert_rule_test for HighCPUUsage"},
+	)
+
+	var junit bytes.Buffer
+	if err := report.WriteJUnit(&junit); err != nil {
+		t.Fatal(err)
+	}
+	var parsed junitSuites
+	if err := xml.Unmarshal(junit.Bytes(), &parsed); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, junit.String())
+	}
+	if parsed.Tests != 3 || parsed.Failed != 1 || len(parsed.Suites) != 3 {
+		t.Errorf("testsuites = %d tests, %d failures, %d suites", parsed.Tests, parsed.Failed, len(parsed.Suites))
+	}
+	if failure := parsed.Suites[1].Cases[0].Failure; failure == nil || failure.Message != "alertname: InstanceDown, time: 10m," {
+		t.Errorf("failure = %+v", failure)
+	}
+	if parsed.Suites[2].Skipped != 1 {
+		t.Errorf("coverage suite = %+v", parsed.Suites[2])
+	}
+
+	var github bytes.Buffer
+	if err := report.WriteGitHub(&github); err != nil {
+		t.Fatal(err)
+	}
+	want := "::error file=alerts_test.yml,title=tests%3A alerts_test.yml::alertname: InstanceDown, time: 10m,%0Agot:[]\n" +
+		"::warning file=alerts.yml,title=coverage%3A HighCPUUsage::no alert_rule_test for HighCPUUsage\n"
+	if github.String() != want {
+		t.Errorf("annotations:\n%s\nwant:\n%s", github.String(), want)
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.yml", "nested/b.yaml", "README.md"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := FindFiles(dir, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !strings.HasSuffix(files[0], "a.yml") || !strings.HasSuffix(files[1], "b.yaml") {
+		t.Errorf("files = %v", files)
+	}
+}
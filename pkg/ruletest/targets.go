@@ -0,0 +1,151 @@
+package ruletest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scrapeConfig is the part of a Prometheus config read for target checks
+type scrapeConfig struct {
+	ScrapeConfigs []struct {
+		JobName string `yaml:"job_name"`
+	} `yaml:"scrape_configs"`
+}
+
+// activeTarget is a target of Prometheus' /api/v1/targets
+type activeTarget struct {
+	ScrapePool         string            `json:"scrapePool"`
+	ScrapeURL          string            `json:"scrapeUrl"`
+	Labels             map[string]string `json:"labels"`
+	Health             string            `json:"health"`
+	LastError          string            `json:"lastError"`
+	LastScrapeDuration float64           `json:"lastScrapeDuration"`
+}
+
+// CheckTargets compares the scrape jobs of a Prometheus config with the
+// targets a live Prometheus scrapes: each target of a job passes when its
+// last scrape succeeded, and a job without targets fails, since Prometheus
+// has not loaded it or discovered nothing. Jobs Prometheus scrapes that are
+// not in the config are skipped.
+func CheckTargets(ctx context.Context, client *http.Client, prometheusURL, configFile string) ([]Result, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var cfg scrapeConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configFile, err)
+	}
+
+	targets, err := fetchTargets(ctx, client, prometheusURL)
+	if err != nil {
+		return nil, err
+	}
+	byJob := make(map[string][]activeTarget)
+	for _, t := range targets {
+		byJob[t.ScrapePool] = append(byJob[t.ScrapePool], t)
+	}
+
+	var results []Result
+	configured := make(map[string]bool)
+	for _, sc := range cfg.ScrapeConfigs {
+		job := sc.JobName
+		configured[job] = true
+		jobTargets := byJob[job]
+		if len(jobTargets) == 0 {
+			results = append(results, Result{
+				Suite:   SuiteTargets,
+				Name:    job,
+				File:    configFile,
+				Status:  StatusFailed,
+				Message: "Prometheus has no active targets for job " + job + "; reload it if the config changed, or check service discovery",
+			})
+			continue
+		}
+		sort.Slice(jobTargets, func(i, j int) bool { return jobTargets[i].ScrapeURL < jobTargets[j].ScrapeURL })
+		for _, t := range jobTargets {
+			results = append(results, targetResult(job, configFile, t))
+		}
+	}
+
+	var extra []string
+	for job := range byJob {
+		if !configured[job] {
+			extra = append(extra, job)
+		}
+	}
+	sort.Strings(extra)
+	for _, job := range extra {
+		results = append(results, Result{
+			Suite:   SuiteTargets,
+			Name:    job,
+			Status:  StatusSkipped,
+			Message: "job " + job + " is scraped by Prometheus but not in " + configFile,
+		})
+	}
+	return results, nil
+}
+
+// targetResult converts the health of a scraped target
+func targetResult(job, configFile string, t activeTarget) Result {
+	instance := t.Labels["instance"]
+	if instance == "" {
+		instance = t.ScrapeURL
+	}
+	r := Result{
+		Suite:    SuiteTargets,
+		Name:     job + " " + instance,
+		File:     configFile,
+		Duration: time.Duration(t.LastScrapeDuration * float64(time.Second)),
+	}
+	switch t.Health {
+	case "up":
+		r.Status = StatusPassed
+		r.Message = t.ScrapeURL
+	case "down":
+		r.Status = StatusFailed
+		r.Message = t.ScrapeURL + ": " + t.LastError
+	default:
+		r.Status = StatusSkipped
+		r.Message = t.ScrapeURL + " has not been scraped yet"
+	}
+	return r
+}
+
+// fetchTargets returns the active targets of Prometheus
+func fetchTargets(ctx context.Context, client *http.Client, prometheusURL string) ([]activeTarget, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(prometheusURL, "/")+"/api/v1/targets?state=active", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus targets: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query Prometheus targets: %s", resp.Status)
+	}
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ActiveTargets []activeTarget `json:"activeTargets"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode Prometheus targets: %w", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("failed to query Prometheus targets: %s", body.Error)
+	}
+	return body.Data.ActiveTargets, nil
+}
@@ -0,0 +1,128 @@
+package runenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Parse reads .env content: one KEY=VALUE per line with an optional
+// `export` prefix and # comments. Single-quoted values are literal. Double
+// quoted values may span lines and support \n, \t, \", and \\ escapes.
+// Unquoted and double-quoted values expand ${NAME} and $NAME from earlier
+// lines, then from lookup; ${NAME:-default} uses default when NAME is unset
+// or empty.
+func Parse(r io.Reader, lookup func(string) (string, bool)) ([]Var, error) {
+	var vars []Var
+	seen := map[string]int{}
+	resolve := func(name string) (string, bool) {
+		if i, ok := seen[name]; ok {
+			return vars[i].Value, true
+		}
+		if lookup != nil {
+			return lookup(name)
+		}
+		return "", false
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, rest, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validName(name) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		rest = strings.TrimSpace(rest)
+		start := lineNo
+
+		var value string
+		switch {
+		case strings.HasPrefix(rest, "'"):
+			end := strings.Index(rest[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", start)
+			}
+			value = rest[1 : end+1]
+
+		case strings.HasPrefix(rest, `"`):
+			raw := rest[1:]
+			for closingQuote(raw) < 0 {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated double quote", start)
+				}
+				lineNo++
+				raw += "\n" + scanner.Text()
+			}
+			value = expand(unescape(raw[:closingQuote(raw)]), resolve)
+
+		default:
+			if i := strings.Index(rest, " #"); i >= 0 {
+				rest = strings.TrimSpace(rest[:i])
+			}
+			value = expand(rest, resolve)
+		}
+
+		if i, ok := seen[name]; ok {
+			vars[i].Value = value
+			continue
+		}
+		seen[name] = len(vars)
+		vars = append(vars, Var{Name: name, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// closingQuote returns the index of the first unescaped double quote
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`, `\$`, "\x00").Replace(s)
+}
+
+// expand substitutes variables; an escaped \$ was turned into NUL by
+// unescape and becomes a literal $ here
+func expand(s string, resolve func(string) (string, bool)) string {
+	s = os.Expand(s, func(ref string) string {
+		name, def, hasDefault := strings.Cut(ref, ":-")
+		if v, ok := resolve(name); ok && (v != "" || !hasDefault) {
+			return v
+		}
+		return def
+	})
+	return strings.ReplaceAll(s, "\x00", "$")
+}
@@ -0,0 +1,74 @@
+package runenv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# database
+export DB_HOST=localhost
+DB_PORT = 5432 # inline comment
+DB_URL=postgres://${DB_HOST}:$DB_PORT/app
+LITERAL='${DB_HOST} stays'
+QUOTED="line one\nline \"two\""
+MULTI="first
+second"
+ESCAPED="costs \$5"
+FALLBACK=${MISSING:-fallback}
+FROM_LOOKUP=${HOME_DIR}/data
+EMPTY=
+DB_PORT=5433
+`
+	lookup := func(name string) (string, bool) {
+		if name == "HOME_DIR" {
+			return "/home/app", true
+		}
+		return "", false
+	}
+	vars, err := Parse(strings.NewReader(input), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	var order []string
+	for _, v := range vars {
+		got[v.Name] = v.Value
+		order = append(order, v.Name)
+	}
+	want := map[string]string{
+		"DB_HOST":     "localhost",
+		"DB_PORT":     "5433",
+		"DB_URL":      "postgres://localhost:5432/app",
+		"LITERAL":     "${DB_HOST} stays",
+		"QUOTED":      "line one\nline \"two\"",
+		"MULTI":       "first\nsecond",
+		"ESCAPED":     "costs $5",
+		"FALLBACK":    "fallback",
+		"FROM_LOOKUP": "/home/app/data",
+		"EMPTY":       "",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %q", name, got[name], value)
+		}
+	}
+	if len(vars) != len(want) || order[1] != "DB_PORT" {
+		t.Errorf("vars = %v, want each name once in first-seen order", order)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"NO_EQUALS",
+		"1BAD=x",
+		"BAD-NAME=x",
+		"OPEN='never closed",
+		"OPEN=\"never closed\nstill open",
+	} {
+		if _, err := Parse(strings.NewReader(input), nil); err == nil {
+			t.Errorf("Parse(%q) succeeded", input)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package runenv builds the environment an application runs with under
+// `apm run`. Variables come in layers of increasing precedence: values derived
+// from apm.yaml, .env files in the order given, and the process environment.
+// Values may be secret references resolved at load time, and a schema checks
+// that required variables are present and well formed before the
+// application starts.
+package runenv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sources of variables that do not come from a file
+const (
+	SourceConfig  = "apm.yaml"
+	SourceProcess = "environment"
+	SourceDefault = "schema default"
+)
+
+// Var is one environment variable and where its value came from
+type Var struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Secret values are masked when printed
+	Secret bool `json:"secret,omitempty"`
+}
+
+// Environment is an ordered set of variables; a later Set of the same name
+// replaces the value but keeps its position
+type Environment struct {
+	vars  map[string]*Var
+	order []string
+}
+
+// New creates an empty environment
+func New() *Environment {
+	return &Environment{vars: map[string]*Var{}}
+}
+
+// Set adds or replaces a variable
+func (e *Environment) Set(v Var) {
+	if existing, ok := e.vars[v.Name]; ok {
+		// A secret stays masked even if a later layer overrides it
+		v.Secret = v.Secret || existing.Secret
+		*existing = v
+		return
+	}
+	e.vars[v.Name] = &v
+	e.order = append(e.order, v.Name)
+}
+
+// SetAll sets vars in order
+func (e *Environment) SetAll(vars []Var) {
+	for _, v := range vars {
+		e.Set(v)
+	}
+}
+
+// Get returns a variable
+func (e *Environment) Get(name string) (Var, bool) {
+	v, ok := e.vars[name]
+	if !ok {
+		return Var{}, false
+	}
+	return *v, true
+}
+
+// Lookup returns a variable's value, for use with Parse
+func (e *Environment) Lookup(name string) (string, bool) {
+	v, ok := e.vars[name]
+	if !ok {
+		return "", false
+	}
+	return v.Value, true
+}
+
+// Vars returns the variables in the order they were first set
+func (e *Environment) Vars() []Var {
+	out := make([]Var, 0, len(e.order))
+	for _, name := range e.order {
+		out = append(out, *e.vars[name])
+	}
+	return out
+}
+
+// Environ returns the variables as KEY=VALUE pairs for exec.Cmd.Env
+func (e *Environment) Environ() []string {
+	out := make([]string, 0, len(e.order))
+	for _, name := range e.order {
+		out = append(out, name+"="+e.vars[name].Value)
+	}
+	return out
+}
+
+// Masked returns the variables sorted by name with secret values hidden,
+// for display
+func (e *Environment) Masked() []Var {
+	out := e.Vars()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	for i := range out {
+		if out[i].Secret {
+			out[i].Value = "********"
+		}
+	}
+	return out
+}
+
+// Options configures Load
+type Options struct {
+	// Derived are the lowest-precedence variables, typically derived from
+	// apm.yaml
+	Derived []Var
+
+	// Files are .env files applied in order, later files overriding earlier
+	// ones. A file that does not exist is an error unless it is listed in
+	// OptionalFiles.
+	Files         []string
+	OptionalFiles []string
+
+	// Process is the process environment as KEY=VALUE pairs, which
+	// overrides everything else; nil uses os.Environ
+	Process []string
+
+	// Schema validates the merged environment after secret resolution
+	Schema Schema
+
+	// Resolvers resolve secret references; nil uses DefaultResolvers
+	Resolvers map[string]Resolver
+}
+
+// Load merges the layers, resolves secret references, and validates the
+// result against the schema. A *ValidationError lists every schema problem.
+func Load(opts Options) (*Environment, error) {
+	process := opts.Process
+	if process == nil {
+		process = os.Environ()
+	}
+	processVars := make([]Var, 0, len(process))
+	processLookup := map[string]string{}
+	for _, kv := range process {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			continue
+		}
+		processVars = append(processVars, Var{Name: name, Value: value, Source: SourceProcess})
+		processLookup[name] = value
+	}
+
+	env := New()
+	for _, v := range opts.Derived {
+		if v.Source == "" {
+			v.Source = SourceConfig
+		}
+		env.Set(v)
+	}
+
+	optional := map[string]bool{}
+	for _, f := range opts.OptionalFiles {
+		optional[f] = true
+	}
+	for _, path := range append(append([]string{}, opts.OptionalFiles...), opts.Files...) {
+		vars, err := ParseFile(path, func(name string) (string, bool) {
+			// Files may reference the process environment and earlier layers
+			if v, ok := processLookup[name]; ok {
+				return v, true
+			}
+			return env.Lookup(name)
+		})
+		if err != nil {
+			if optional[path] && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		env.SetAll(vars)
+	}
+
+	env.SetAll(processVars)
+
+	resolvers := opts.Resolvers
+	if resolvers == nil {
+		resolvers = DefaultResolvers()
+	}
+	if err := resolveSecrets(env, resolvers); err != nil {
+		return nil, err
+	}
+	if err := opts.Schema.Apply(env); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+// ParseFile reads a .env file, resolving references to variables not
+// defined in the file with lookup
+func ParseFile(path string, lookup func(string) (string, bool)) ([]Var, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+
+	vars, err := Parse(f, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("env file %s: %w", path, err)
+	}
+	for i := range vars {
+		vars[i].Source = path
+	}
+	return vars, nil
+}
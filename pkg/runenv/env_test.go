@@ -0,0 +1,137 @@
+package runenv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, ".env", "PORT=8080\nLOG_LEVEL=info\nREGION=eu\n")
+	local := writeFile(t, dir, ".env.local", "LOG_LEVEL=debug\nURL=http://localhost:${PORT}\n")
+
+	env, err := Load(Options{
+		Derived: []Var{
+			{Name: "OTEL_SERVICE_NAME", Value: "from-config"},
+			{Name: "PORT", Value: "9000"},
+		},
+		OptionalFiles: []string{filepath.Join(dir, ".env.missing")},
+		Files:         []string{base, local},
+		Process:       []string{"REGION=us", "PATH=/bin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]struct{ value, source string }{
+		"OTEL_SERVICE_NAME": {"from-config", SourceConfig},
+		"PORT":              {"8080", base},
+		"LOG_LEVEL":         {"debug", local},
+		"URL":               {"http://localhost:8080", local},
+		"REGION":            {"us", SourceProcess},
+		"PATH":              {"/bin", SourceProcess},
+	} {
+		v, ok := env.Get(name)
+		if !ok || v.Value != want.value || v.Source != want.source {
+			t.Errorf("%s = %+v, want %q from %s", name, v, want.value, want.source)
+		}
+	}
+	if environ := strings.Join(env.Environ(), " "); !strings.HasPrefix(environ, "OTEL_SERVICE_NAME=from-config PORT=8080") {
+		t.Errorf("Environ() = %s", environ)
+	}
+
+	if _, err := Load(Options{Files: []string{filepath.Join(dir, "absent")}, Process: []string{}}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing required file: err = %v", err)
+	}
+}
+
+func TestLoadResolvesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secret := writeFile(t, dir, "db_password", "hunter2\n")
+	envFile := writeFile(t, dir, ".env", "DB_PASSWORD=secret:file:"+secret+"\nTOKEN=secret:test:abc\n")
+
+	env, err := Load(Options{
+		Files:   []string{envFile},
+		Process: []string{"SHELL_VALUE=secret:file:/not/resolved"},
+		Resolvers: map[string]Resolver{
+			"file": resolveFile,
+			"test": func(ref string) (string, error) { return "resolved-" + ref, nil },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := env.Get("DB_PASSWORD"); v.Value != "hunter2" || !v.Secret {
+		t.Errorf("DB_PASSWORD = %+v", v)
+	}
+	if v, _ := env.Get("TOKEN"); v.Value != "resolved-abc" {
+		t.Errorf("TOKEN = %+v", v)
+	}
+	if v, _ := env.Get("SHELL_VALUE"); v.Value != "secret:file:/not/resolved" || v.Secret {
+		t.Errorf("process values must pass through unchanged, got %+v", v)
+	}
+	for _, v := range env.Masked() {
+		if v.Name == "DB_PASSWORD" && v.Value == "hunter2" {
+			t.Error("Masked() shows the secret")
+		}
+	}
+
+	_, err = Load(Options{Derived: []Var{{Name: "X", Value: "secret:vault:db"}}, Process: []string{}, Resolvers: DefaultResolvers()})
+	if err == nil || !strings.Contains(err.Error(), `unknown secret scheme "vault"`) {
+		t.Errorf("unknown scheme: err = %v", err)
+	}
+}
+
+func TestSchemaApply(t *testing.T) {
+	schema := Schema{
+		{Name: "DATABASE_URL", Required: true, Pattern: `postgres://.+`, Secret: true},
+		{Name: "PORT", Default: "8080", Pattern: `[0-9]+`},
+		{Name: "LOG_LEVEL", Enum: []string{"debug", "info", "warn", "error"}},
+		{Name: "API_KEY", Required: true, Description: "key for the billing API"},
+		{Name: "OPTIONAL"},
+	}
+
+	env := New()
+	env.Set(Var{Name: "DATABASE_URL", Value: "mysql://db", Source: ".env"})
+	env.Set(Var{Name: "LOG_LEVEL", Value: "verbose", Source: ".env"})
+	err := schema.Apply(env)
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 3 {
+		t.Fatalf("err = %v, want three problems", err)
+	}
+	msg := err.Error()
+	for _, want := range []string{"DATABASE_URL from .env does not match", "LOG_LEVEL from .env must be one of", "API_KEY is required but not set (key for the billing API)"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not mention %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "mysql://db") {
+		t.Error("error leaks a value")
+	}
+	if v, _ := env.Get("PORT"); v.Value != "8080" || v.Source != SourceDefault {
+		t.Errorf("PORT = %+v, want the default", v)
+	}
+	if v, _ := env.Get("DATABASE_URL"); !v.Secret {
+		t.Error("DATABASE_URL not marked secret")
+	}
+
+	env.Set(Var{Name: "DATABASE_URL", Value: "postgres://db/app"})
+	env.Set(Var{Name: "LOG_LEVEL", Value: "info"})
+	env.Set(Var{Name: "API_KEY", Value: "k"})
+	if err := schema.Apply(env); err != nil {
+		t.Errorf("valid environment: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package runenv
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// VarSpec describes one expected variable
+type VarSpec struct {
+	Name        string `mapstructure:"name" yaml:"name" json:"name"`
+	Description string `mapstructure:"description" yaml:"description,omitempty" json:"description,omitempty"`
+	// Required variables must be set to a non-empty value
+	Required bool `mapstructure:"required" yaml:"required,omitempty" json:"required,omitempty"`
+	// Default is used when the variable is not set
+	Default string `mapstructure:"default" yaml:"default,omitempty" json:"default,omitempty"`
+	// Pattern is a regular expression a set value must match in full
+	Pattern string `mapstructure:"pattern" yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	// Enum lists the allowed values
+	Enum []string `mapstructure:"enum" yaml:"enum,omitempty" json:"enum,omitempty"`
+	// Secret values are masked when printed
+	Secret bool `mapstructure:"secret" yaml:"secret,omitempty" json:"secret,omitempty"`
+}
+
+// Schema lists the variables an application expects
+type Schema []VarSpec
+
+// ValidationError lists every problem found by Schema.Apply
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "environment validation failed: " + e.Problems[0]
+	}
+	return fmt.Sprintf("environment validation failed with %d problems:\n  - %s",
+		len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Apply fills in defaults, marks secrets, and validates env. It returns a
+// *ValidationError listing every missing or malformed variable.
+func (s Schema) Apply(env *Environment) error {
+	var problems []string
+	for _, spec := range s {
+		if spec.Name == "" {
+			problems = append(problems, "schema entry without a name")
+			continue
+		}
+
+		v, ok := env.Get(spec.Name)
+		if !ok && spec.Default != "" {
+			v, ok = Var{Name: spec.Name, Value: spec.Default, Source: SourceDefault}, true
+		}
+		if ok {
+			v.Secret = v.Secret || spec.Secret
+			env.Set(v)
+		}
+
+		if !ok || v.Value == "" {
+			if spec.Required {
+				msg := spec.Name + " is required but not set"
+				if spec.Description != "" {
+					msg += " (" + spec.Description + ")"
+				}
+				problems = append(problems, msg)
+			}
+			continue
+		}
+
+		if spec.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + spec.Pattern + ")$")
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s has an invalid pattern: %v", spec.Name, err))
+			} else if !re.MatchString(v.Value) {
+				problems = append(problems, fmt.Sprintf("%s from %s does not match %s", spec.Name, v.Source, spec.Pattern))
+			}
+		}
+		if len(spec.Enum) > 0 && !contains(spec.Enum, v.Value) {
+			problems = append(problems, fmt.Sprintf("%s from %s must be one of %s", spec.Name, v.Source, strings.Join(spec.Enum, ", ")))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
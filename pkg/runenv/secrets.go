@@ -0,0 +1,92 @@
+package runenv
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SecretPrefix marks a value as a secret reference of the form
+// secret:<scheme>:<reference>, e.g. secret:file:/run/secrets/db_password
+const SecretPrefix = "secret:"
+
+// Resolver returns the secret a reference points to
+type Resolver func(ref string) (string, error)
+
+// DefaultResolvers resolves the built-in schemes:
+//
+//	env   another environment variable of the apm process
+//	file  the contents of a file, without the trailing newline, such as a
+//	      Docker or Kubernetes secret mount
+func DefaultResolvers() map[string]Resolver {
+	return map[string]Resolver{
+		"env":  resolveEnv,
+		"file": resolveFile,
+	}
+}
+
+// ParseSecretRef splits a secret reference into scheme and reference
+func ParseSecretRef(value string) (scheme, ref string, ok bool) {
+	rest, ok := strings.CutPrefix(value, SecretPrefix)
+	if !ok {
+		return "", "", false
+	}
+	scheme, ref, ok = strings.Cut(rest, ":")
+	if !ok || scheme == "" || ref == "" {
+		return "", "", false
+	}
+	return scheme, ref, true
+}
+
+// resolveSecrets replaces secret references with their values and marks the
+// variables secret. Values from the process environment are passed through
+// as they are.
+func resolveSecrets(env *Environment, resolvers map[string]Resolver) error {
+	for _, v := range env.Vars() {
+		if v.Source == SourceProcess {
+			continue
+		}
+		scheme, ref, ok := ParseSecretRef(v.Value)
+		if !ok {
+			continue
+		}
+		resolve, ok := resolvers[scheme]
+		if !ok {
+			return fmt.Errorf("%s (%s): unknown secret scheme %q, want one of %s", v.Name, v.Source, scheme, schemes(resolvers))
+		}
+		value, err := resolve(ref)
+		if err != nil {
+			return fmt.Errorf("%s (%s): resolving %s secret: %w", v.Name, v.Source, scheme, err)
+		}
+		v.Value = value
+		v.Secret = true
+		env.Set(v)
+	}
+	return nil
+}
+
+func schemes(resolvers map[string]Resolver) string {
+	names := make([]string, 0, len(resolvers))
+	for name := range resolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func resolveEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func resolveFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -54,6 +54,7 @@ const (
 	ResourceDashboards  Resource = "dashboards"
 	ResourceUsers       Resource = "users"
 	ResourceAPIKeys     Resource = "api_keys"
+	ResourceRuntime     Resource = "runtime"
 	ResourceAll         Resource = "*"
 )
 
@@ -77,6 +78,7 @@ var DefaultRoles = []Role{
 			{Resource: string(ResourceLogs), Actions: []string{string(ActionRead), string(ActionList)}},
 			{Resource: string(ResourceAlerts), Actions: []string{string(ActionRead), string(ActionUpdate), string(ActionList)}},
 			{Resource: string(ResourceDashboards), Actions: []string{string(ActionRead), string(ActionList)}},
+			{Resource: string(ResourceRuntime), Actions: []string{string(ActionRead), string(ActionUpdate)}},
 		},
 	},
 	{
@@ -90,6 +92,7 @@ var DefaultRoles = []Role{
 			{Resource: string(ResourceLogs), Actions: []string{string(ActionRead), string(ActionList)}},
 			{Resource: string(ResourceAlerts), Actions: []string{string(ActionRead), string(ActionList)}},
 			{Resource: string(ResourceDashboards), Actions: []string{string(ActionRead), string(ActionList)}},
+			{Resource: string(ResourceRuntime), Actions: []string{string(ActionRead)}},
 		},
 	},
 }
@@ -84,6 +84,9 @@ type AuthContext struct {
 	Token     string
 	Claims    *Claims
 	RequestID string
+	// ClientID identifies the API client the request is attributed to: the
+	// ID of the API key it authenticated with
+	ClientID string
 }
 
 // GetAuthContext retrieves auth context from fiber context
@@ -0,0 +1,73 @@
+package security
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
+)
+
+// minFIPSHMACKeyLength is the minimum HMAC key length in bytes (112 bits) under FIPS 140
+const minFIPSHMACKeyLength = 14
+
+// ValidateCompliance reports settings in the security configuration that do
+// not satisfy its TLS policy. Call Err on the report to fail startup.
+func ValidateCompliance(config Config) *tlspolicy.Report {
+	policy := config.TLS.Effective()
+	report := tlspolicy.NewReport(policy)
+
+	if err := config.TLS.Validate(); err != nil {
+		report.Add(tlspolicy.Finding{
+			Component:   "security.tls",
+			Setting:     "policy",
+			Value:       err.Error(),
+			Requirement: "valid TLS policy",
+			Severity:    tlspolicy.SeverityViolation,
+		})
+	}
+
+	if tlsConfig, err := config.TLS.TLSConfig(); err == nil {
+		policy.CheckTLSConfig(report, "security.server", tlsConfig)
+	}
+
+	if config.Auth.EnableJWT && len(config.Auth.JWT.Secret) > 0 && len(config.Auth.JWT.Secret) < minFIPSHMACKeyLength {
+		severity := tlspolicy.SeverityWarning
+		if policy.FIPSMode {
+			severity = tlspolicy.SeverityViolation
+		}
+		report.Add(tlspolicy.Finding{
+			Component:   "security.auth.jwt",
+			Setting:     "secret",
+			Value:       "too short",
+			Requirement: "HMAC key of at least 112 bits",
+			Severity:    severity,
+		})
+	}
+
+	if !strings.Contains(config.Headers.StrictTransportSecurity, "max-age=") {
+		report.Add(tlspolicy.Finding{
+			Component:   "security.headers",
+			Setting:     "strict_transport_security",
+			Value:       config.Headers.StrictTransportSecurity,
+			Requirement: "HSTS must be enabled",
+			Severity:    tlspolicy.SeverityWarning,
+		})
+	}
+
+	if policy.FIPSMode && !config.TLSEnforcement.Enabled {
+		report.Add(tlspolicy.Finding{
+			Component:   "security.tls_enforcement",
+			Setting:     "enabled",
+			Value:       "false",
+			Requirement: "plaintext requests should be rejected in FIPS mode",
+			Severity:    tlspolicy.SeverityWarning,
+		})
+	}
+
+	return report
+}
+
+// ServerTLSConfig returns a tls.Config for the API server that enforces the TLS policy
+func (c Config) ServerTLSConfig(base *tls.Config) (*tls.Config, error) {
+	return c.TLS.Apply(base)
+}
@@ -0,0 +1,295 @@
+package compliance
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/chaksack/apm/pkg/security"
+	"github.com/chaksack/apm/pkg/security/middleware"
+)
+
+// auditLine is an audit event as written by the zap audit logger, whose
+// timestamp may be an RFC 3339 string or epoch seconds
+type auditLine struct {
+	middleware.AuditEvent
+	EventID   string          `json:"event_id"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	TS        json.RawMessage `json:"ts"`
+}
+
+// collectAudit reads JSON-lines audit logs and extracts events in the period
+func collectAudit(cfg security.Config, paths []string, start, end time.Time, maxExtracts int) (AuditEvidence, error) {
+	evidence := AuditEvidence{
+		Enabled:      cfg.Audit.EnableAudit,
+		Sources:      paths,
+		EventsByType: make(map[string]int),
+		Extracts:     []middleware.AuditEvent{},
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return evidence, fmt.Errorf("failed to open audit log %s: %w", path, err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			event, ok := parseAuditLine([]byte(line))
+			if !ok {
+				evidence.SkippedLines++
+				continue
+			}
+			if event.Timestamp.Before(start) || event.Timestamp.After(end) {
+				continue
+			}
+
+			evidence.TotalEvents++
+			evidence.EventsByType[event.EventType]++
+
+			ts := event.Timestamp
+			if evidence.FirstEvent == nil || ts.Before(*evidence.FirstEvent) {
+				evidence.FirstEvent = &ts
+			}
+			if evidence.LastEvent == nil || ts.After(*evidence.LastEvent) {
+				evidence.LastEvent = &ts
+			}
+
+			// Auditors sample security relevant events, not routine access
+			if isReportableEvent(event) && len(evidence.Extracts) < maxExtracts {
+				evidence.Extracts = append(evidence.Extracts, event)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return evidence, fmt.Errorf("failed to read audit log %s: %w", path, err)
+		}
+	}
+
+	return evidence, nil
+}
+
+// parseAuditLine decodes a single audit log line
+func parseAuditLine(data []byte) (middleware.AuditEvent, bool) {
+	var line auditLine
+	if err := json.Unmarshal(data, &line); err != nil || line.EventType == "" {
+		return middleware.AuditEvent{}, false
+	}
+
+	event := line.AuditEvent
+	if event.ID == "" {
+		event.ID = line.EventID
+	}
+
+	raw := line.Timestamp
+	if len(raw) == 0 {
+		raw = line.TS
+	}
+	ts, ok := parseTimestamp(raw)
+	if !ok {
+		return middleware.AuditEvent{}, false
+	}
+	event.Timestamp = ts
+
+	return event, true
+}
+
+// parseTimestamp accepts RFC 3339 strings and epoch seconds
+func parseTimestamp(raw json.RawMessage) (time.Time, bool) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		return ts, err == nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(raw, &f); err == nil {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC(), true
+	}
+
+	return time.Time{}, false
+}
+
+// isReportableEvent reports whether an event belongs in the evidence extract
+func isReportableEvent(event middleware.AuditEvent) bool {
+	switch event.EventType {
+	case middleware.EventTypeAuthFailure,
+		middleware.EventTypeAuthzFailure,
+		middleware.EventTypeConfigChange,
+		middleware.EventTypeDeployment,
+		middleware.EventTypeSuspiciousInput:
+		return true
+	}
+	// Region overrides and blocks from the data residency guard
+	if strings.HasPrefix(event.EventType, "data_residency_") {
+		return true
+	}
+	return event.Severity == middleware.SeverityCritical
+}
+
+// collectAccessControl snapshots authentication and RBAC policy
+func collectAccessControl(cfg security.Config) AccessControlEvidence {
+	evidence := AccessControlEvidence{
+		JWTEnabled:    cfg.Auth.EnableJWT,
+		APIKeyEnabled: cfg.Auth.EnableAPI,
+		DefaultRole:   cfg.RBAC.DefaultRole,
+		Roles:         cfg.RBAC.Roles,
+		RoleMapping:   cfg.RBAC.RoleMapping,
+		APIKeyCount:   len(cfg.Auth.APIKey.Keys),
+	}
+	if cfg.Auth.JWT.AccessTokenExpiry > 0 {
+		evidence.TokenExpiry = cfg.Auth.JWT.AccessTokenExpiry.String()
+	}
+	return evidence
+}
+
+// collectEncryption records the TLS policy and its compliance findings
+func collectEncryption(cfg security.Config) EncryptionEvidence {
+	report := security.ValidateCompliance(cfg)
+	return EncryptionEvidence{
+		Policy:      cfg.TLS.Effective(),
+		TLSEnforced: cfg.TLSEnforcement.Enabled,
+		HSTS:        cfg.Headers.StrictTransportSecurity,
+		Findings:    report.Findings,
+	}
+}
+
+// coverageKeywords maps alerting coverage areas to rule name and expression keywords
+var coverageKeywords = map[string][]string{
+	"availability": {"down", "up ==", "unavailable", "health"},
+	"errors":       {"error", "5.."},
+	"latency":      {"latency", "duration", "slow"},
+	"resources":    {"cpu", "memory", "disk"},
+	"certificates": {"certificate", "tls_"},
+	"security":     {"auth", "security", "rate_limit", "ratelimit", "audit"},
+}
+
+// ruleFile is the subset of a Prometheus rule file needed for coverage
+type ruleFile struct {
+	Groups []struct {
+		Name  string `yaml:"name"`
+		Rules []struct {
+			Alert  string            `yaml:"alert"`
+			Expr   string            `yaml:"expr"`
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// collectAlerting parses alert rule files and computes coverage
+func collectAlerting(paths []string) (AlertingEvidence, error) {
+	evidence := AlertingEvidence{
+		RuleFiles: []string{},
+		Rules:     []AlertRule{},
+		Coverage:  make(map[string]bool),
+	}
+	for area := range coverageKeywords {
+		evidence.Coverage[area] = false
+	}
+
+	files, err := expandRuleFiles(paths)
+	if err != nil {
+		return evidence, err
+	}
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return evidence, fmt.Errorf("failed to read rule file %s: %w", path, err)
+		}
+
+		var rf ruleFile
+		if err := yaml.Unmarshal(data, &rf); err != nil {
+			return evidence, fmt.Errorf("failed to parse rule file %s: %w", path, err)
+		}
+		evidence.RuleFiles = append(evidence.RuleFiles, path)
+
+		for _, group := range rf.Groups {
+			for _, rule := range group.Rules {
+				if rule.Alert == "" {
+					continue // recording rule
+				}
+				evidence.Rules = append(evidence.Rules, AlertRule{
+					Name:     rule.Alert,
+					Group:    group.Name,
+					Severity: rule.Labels["severity"],
+					File:     path,
+				})
+
+				text := strings.ToLower(rule.Alert + " " + rule.Expr)
+				for area, keywords := range coverageKeywords {
+					for _, kw := range keywords {
+						if strings.Contains(text, kw) {
+							evidence.Coverage[area] = true
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return evidence, nil
+}
+
+// expandRuleFiles expands directories to their .yml and .yaml files
+func expandRuleFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("alert rules path: %w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// requiredRetention lists components whose retention must be documented
+var requiredRetention = []string{"metrics", "logs", "traces", "audit_logs"}
+
+// collectRetention records retention settings and flags undocumented components
+func collectRetention(settings map[string]string) RetentionEvidence {
+	evidence := RetentionEvidence{Settings: make(map[string]string)}
+	for k, v := range settings {
+		if v != "" {
+			evidence.Settings[k] = v
+		}
+	}
+	for _, component := range requiredRetention {
+		if _, ok := evidence.Settings[component]; !ok {
+			evidence.Missing = append(evidence.Missing, component)
+		}
+	}
+	return evidence
+}
@@ -0,0 +1,343 @@
+package compliance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/chaksack/apm/pkg/locale"
+	"github.com/chaksack/apm/pkg/retention"
+	"github.com/chaksack/apm/pkg/security"
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
+)
+
+// Options configures report generation
+type Options struct {
+	Framework Framework
+
+	// PeriodStart and PeriodEnd bound the audit events included.
+	// A zero end means now; a zero start means 90 days before the end.
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+
+	// Security is the security configuration in effect
+	Security security.Config
+
+	// AuditLogs are JSON-lines audit log files written by the audit middleware
+	AuditLogs []string
+
+	// AlertRules are Prometheus rule files or directories
+	AlertRules []string
+
+	// Retention maps components (metrics, logs, traces, audit_logs) to retention periods
+	Retention map[string]string
+
+	// MaxExtracts bounds the number of audit events copied into the report
+	MaxExtracts int
+}
+
+// Generate assembles an evidence package for the requested framework
+func Generate(opts Options) (*Report, error) {
+	if opts.Framework == "" {
+		opts.Framework = FrameworkSOC2
+	}
+	if opts.Framework != FrameworkSOC2 && opts.Framework != FrameworkPCI {
+		return nil, fmt.Errorf("unsupported framework %q: must be %s or %s", opts.Framework, FrameworkSOC2, FrameworkPCI)
+	}
+	if opts.PeriodEnd.IsZero() {
+		opts.PeriodEnd = time.Now().UTC()
+	}
+	if opts.PeriodStart.IsZero() {
+		opts.PeriodStart = opts.PeriodEnd.AddDate(0, 0, -90)
+	}
+	if opts.MaxExtracts <= 0 {
+		opts.MaxExtracts = 500
+	}
+
+	report := &Report{
+		Framework:   opts.Framework,
+		GeneratedAt: time.Now().UTC(),
+		PeriodStart: opts.PeriodStart,
+		PeriodEnd:   opts.PeriodEnd,
+	}
+
+	var err error
+	report.Audit, err = collectAudit(opts.Security, opts.AuditLogs, opts.PeriodStart, opts.PeriodEnd, opts.MaxExtracts)
+	if err != nil {
+		return nil, err
+	}
+	report.Alerting, err = collectAlerting(opts.AlertRules)
+	if err != nil {
+		return nil, err
+	}
+	report.AccessControl = collectAccessControl(opts.Security)
+	report.Encryption = collectEncryption(opts.Security)
+	report.Retention = collectRetention(opts.Retention)
+
+	report.Controls = evaluateControls(report)
+
+	return report, nil
+}
+
+// controlDefinition ties a framework control to an evaluator
+type controlDefinition struct {
+	id       string
+	title    string
+	evaluate func(r *Report) Control
+}
+
+// controlsFor returns the control set for a framework
+func controlsFor(framework Framework) []controlDefinition {
+	if framework == FrameworkPCI {
+		return []controlDefinition{
+			{"PCI 4.2.1", "Strong cryptography protects data in transit", evaluateEncryption},
+			{"PCI 7.2", "Access is assigned by role with least privilege", evaluateAccessControl},
+			{"PCI 10.2", "Audit logs capture user activity and security events", evaluateAuditLogging},
+			{"PCI 10.5.1", "Audit log history is retained for at least 12 months", retentionEvaluator(365 * 24 * time.Hour)},
+			{"PCI 10.7", "Failures of critical security controls are detected", evaluateMonitoring},
+		}
+	}
+
+	return []controlDefinition{
+		{"CC6.1", "Logical access security is implemented", evaluateAccessControl},
+		{"CC6.7", "Transmission of data is encrypted", evaluateEncryption},
+		{"CC7.2", "System components are monitored for anomalies", evaluateMonitoring},
+		{"CC7.3", "Security events are logged and evaluated", evaluateAuditLogging},
+		{"C1.2", "Data is retained and disposed of per policy", retentionEvaluator(0)},
+	}
+}
+
+// evaluateControls evaluates every control for the report framework
+func evaluateControls(r *Report) []Control {
+	defs := controlsFor(r.Framework)
+	controls := make([]Control, 0, len(defs))
+	for _, def := range defs {
+		c := def.evaluate(r)
+		c.ID = def.id
+		c.Title = def.title
+		c.Status = statusFor(c)
+		controls = append(controls, c)
+	}
+	return controls
+}
+
+// statusFor derives a control status from its evidence and gaps
+func statusFor(c Control) ControlStatus {
+	switch {
+	case len(c.Gaps) == 0:
+		return ControlSatisfied
+	case len(c.Evidence) > 0:
+		return ControlPartial
+	default:
+		return ControlNotSatisfied
+	}
+}
+
+// evaluateAccessControl checks authentication and role configuration
+func evaluateAccessControl(r *Report) Control {
+	var c Control
+	ac := r.AccessControl
+
+	if ac.JWTEnabled || ac.APIKeyEnabled {
+		c.Evidence = append(c.Evidence, fmt.Sprintf("authentication enabled (jwt=%t, api_key=%t)", ac.JWTEnabled, ac.APIKeyEnabled))
+	} else {
+		c.Gaps = append(c.Gaps, "no authentication method is enabled")
+	}
+
+	if len(ac.Roles) > 0 {
+		c.Evidence = append(c.Evidence, fmt.Sprintf("%d RBAC roles defined", len(ac.Roles)))
+	} else {
+		c.Gaps = append(c.Gaps, "no RBAC roles defined")
+	}
+
+	if ac.DefaultRole == "admin" {
+		c.Gaps = append(c.Gaps, "default role grants admin privileges")
+	}
+
+	return c
+}
+
+// evaluateEncryption checks the TLS policy findings
+func evaluateEncryption(r *Report) Control {
+	var c Control
+	enc := r.Encryption
+
+	c.Evidence = append(c.Evidence, fmt.Sprintf("minimum TLS %s (FIPS mode %t)", enc.Policy.MinVersion, enc.Policy.FIPSMode))
+	if enc.TLSEnforced {
+		c.Evidence = append(c.Evidence, "plaintext requests rejected by TLS enforcement middleware")
+	} else {
+		c.Gaps = append(c.Gaps, "TLS enforcement middleware disabled")
+	}
+
+	for _, f := range enc.Findings {
+		if f.Severity == tlspolicy.SeverityViolation {
+			c.Gaps = append(c.Gaps, fmt.Sprintf("%s %s: %s", f.Component, f.Setting, f.Requirement))
+		}
+	}
+
+	return c
+}
+
+// evaluateAuditLogging checks that audit logging is enabled and producing events
+func evaluateAuditLogging(r *Report) Control {
+	var c Control
+	audit := r.Audit
+
+	if !audit.Enabled {
+		c.Gaps = append(c.Gaps, "audit logging disabled")
+	}
+	if len(audit.Sources) == 0 {
+		c.Gaps = append(c.Gaps, "no audit log sources provided")
+		return c
+	}
+	if audit.TotalEvents == 0 {
+		c.Gaps = append(c.Gaps, "no audit events recorded in the reporting period")
+		return c
+	}
+
+	c.Evidence = append(c.Evidence, fmt.Sprintf("%d audit events from %d source(s)", audit.TotalEvents, len(audit.Sources)))
+	c.Evidence = append(c.Evidence, fmt.Sprintf("%d security relevant events extracted", len(audit.Extracts)))
+	return c
+}
+
+// evaluateMonitoring checks alert rule coverage
+func evaluateMonitoring(r *Report) Control {
+	var c Control
+	alerting := r.Alerting
+
+	if len(alerting.Rules) > 0 {
+		c.Evidence = append(c.Evidence, fmt.Sprintf("%d alert rules in %d file(s)", len(alerting.Rules), len(alerting.RuleFiles)))
+	}
+
+	areas := make([]string, 0, len(alerting.Coverage))
+	for area := range alerting.Coverage {
+		areas = append(areas, area)
+	}
+	sort.Strings(areas)
+	for _, area := range areas {
+		if alerting.Coverage[area] {
+			c.Evidence = append(c.Evidence, "alerts cover "+area)
+		} else {
+			c.Gaps = append(c.Gaps, "no alerts cover "+area)
+		}
+	}
+
+	return c
+}
+
+// retentionEvaluator checks that retention is documented and, for audit logs, long enough
+func retentionEvaluator(minAudit time.Duration) func(r *Report) Control {
+	return func(r *Report) Control {
+		var c Control
+		ret := r.Retention
+
+		keys := make([]string, 0, len(ret.Settings))
+		for k := range ret.Settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c.Evidence = append(c.Evidence, fmt.Sprintf("%s retained for %s", k, ret.Settings[k]))
+		}
+		for _, m := range ret.Missing {
+			c.Gaps = append(c.Gaps, "no retention period documented for "+m)
+		}
+
+		if minAudit > 0 {
+			if v, ok := ret.Settings["audit_logs"]; ok {
+				d, err := retention.ParseDuration(v)
+				if err != nil {
+					c.Gaps = append(c.Gaps, err.Error())
+				} else if d < minAudit {
+					c.Gaps = append(c.Gaps, fmt.Sprintf("audit log retention %s is below the required %d days", v, int(minAudit.Hours()/24)))
+				}
+			}
+		}
+
+		return c
+	}
+}
+
+// Summary counts controls by status
+func (r *Report) Summary() map[ControlStatus]int {
+	summary := make(map[ControlStatus]int)
+	for _, c := range r.Controls {
+		summary[c.Status]++
+	}
+	return summary
+}
+
+// Write renders the report as json, yaml, or markdown with UTC times
+func (r *Report) Write(w io.Writer, format string) error {
+	return r.WriteIn(w, format, locale.UTC)
+}
+
+// WriteIn renders the report as json, yaml, or markdown. The markdown shows
+// times and dates with f; json and yaml keep RFC 3339 UTC.
+func (r *Report) WriteIn(w io.Writer, format string, f *locale.Formatter) error {
+	switch format {
+	case "", "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(r)
+	case "markdown", "md":
+		_, err := io.WriteString(w, r.markdown(f))
+		return err
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+}
+
+// markdown renders an auditor facing summary
+func (r *Report) markdown(f *locale.Formatter) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s Compliance Evidence Report\n\n", strings.ToUpper(string(r.Framework)))
+	fmt.Fprintf(&b, "- Generated: %s\n", f.DateTime(r.GeneratedAt))
+	fmt.Fprintf(&b, "- Period: %s to %s\n\n", f.Date(r.PeriodStart), f.Date(r.PeriodEnd))
+
+	b.WriteString("## Controls\n\n| Control | Title | Status |\n|---|---|---|\n")
+	for _, c := range r.Controls {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", c.ID, c.Title, c.Status)
+	}
+
+	for _, c := range r.Controls {
+		fmt.Fprintf(&b, "\n### %s %s\n\n", c.ID, c.Title)
+		for _, e := range c.Evidence {
+			fmt.Fprintf(&b, "- ✓ %s\n", e)
+		}
+		for _, g := range c.Gaps {
+			fmt.Fprintf(&b, "- ✗ %s\n", g)
+		}
+	}
+
+	b.WriteString("\n## Audit Log Extracts\n\n")
+	if len(r.Audit.Extracts) == 0 {
+		b.WriteString("No security relevant events in the reporting period.\n")
+	} else {
+		b.WriteString("| Time | Type | Severity | User | Method | Path | Status |\n|---|---|---|---|---|---|---|\n")
+		for _, e := range r.Audit.Extracts {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %d |\n",
+				f.DateTime(e.Timestamp), e.EventType, e.Severity, e.Username, e.Method, e.Path, e.StatusCode)
+		}
+	}
+
+	b.WriteString("\n## RBAC Policy Snapshot\n\n")
+	for _, role := range r.AccessControl.Roles {
+		fmt.Fprintf(&b, "- **%s**: %s\n", role.Name, role.Description)
+		for _, p := range role.Permissions {
+			fmt.Fprintf(&b, "  - %s: %s\n", p.Resource, strings.Join(p.Actions, ", "))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package compliance
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/security"
+)
+
+const testAuditLog = `{"level":"warn","ts":1760000000.5,"msg":"security audit event","event_id":"e1","timestamp":"2025-10-09T08:53:20Z","event_type":"auth_failure","severity":"warning","ip":"10.0.0.1","method":"POST","path":"/api/auth/login","status_code":401}
+{"level":"info","msg":"security audit event","event_id":"e2","timestamp":"2025-10-09T09:00:00Z","event_type":"data_access","severity":"info","method":"GET","path":"/api/metrics","status_code":200}
+not json
+{"level":"info","msg":"security audit event","event_id":"e3","timestamp":"2024-01-01T00:00:00Z","event_type":"config_change","severity":"info"}
+`
+
+const testRules = `groups:
+  - name: basic
+    rules:
+      - alert: InstanceDown
+        expr: up == 0
+        labels:
+          severity: critical
+      - record: job:errors:rate5m
+        expr: sum(rate(errors_total[5m]))
+`
+
+func TestGenerateSOC2(t *testing.T) {
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	rulesPath := filepath.Join(dir, "rules.yml")
+	if err := os.WriteFile(auditPath, []byte(testAuditLog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rulesPath, []byte(testRules), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Generate(Options{
+		Framework:   FrameworkSOC2,
+		PeriodStart: time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC),
+		Security:    security.DefaultConfig(),
+		AuditLogs:   []string{auditPath},
+		AlertRules:  []string{dir},
+		Retention:   map[string]string{"metrics": "15d", "logs": "7d"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.Audit.TotalEvents != 2 {
+		t.Errorf("expected 2 events in period, got %d", report.Audit.TotalEvents)
+	}
+	if report.Audit.SkippedLines != 1 {
+		t.Errorf("expected 1 skipped line, got %d", report.Audit.SkippedLines)
+	}
+	if len(report.Audit.Extracts) != 1 || report.Audit.Extracts[0].ID != "e1" {
+		t.Errorf("expected only the auth failure to be extracted, got %+v", report.Audit.Extracts)
+	}
+
+	if len(report.Alerting.Rules) != 1 || !report.Alerting.Coverage["availability"] {
+		t.Errorf("expected one availability alert, got %+v", report.Alerting)
+	}
+
+	statuses := make(map[string]ControlStatus)
+	for _, c := range report.Controls {
+		statuses[c.ID] = c.Status
+	}
+	if statuses["CC6.1"] != ControlSatisfied {
+		t.Errorf("expected CC6.1 satisfied, got %s", statuses["CC6.1"])
+	}
+	if statuses["C1.2"] != ControlPartial {
+		t.Errorf("expected C1.2 partial with missing retention, got %s", statuses["C1.2"])
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, "markdown"); err != nil {
+		t.Fatalf("failed to render markdown: %v", err)
+	}
+	if !strings.Contains(buf.String(), "| CC6.7 |") {
+		t.Errorf("expected control table in markdown output")
+	}
+}
+
+func TestPCIAuditRetention(t *testing.T) {
+	report, err := Generate(Options{
+		Framework: FrameworkPCI,
+		Security:  security.DefaultConfig(),
+		Retention: map[string]string{"metrics": "15d", "logs": "30d", "traces": "72h", "audit_logs": "90d"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, c := range report.Controls {
+		if c.ID == "PCI 10.5.1" {
+			if c.Status == ControlSatisfied {
+				t.Error("expected 90 day audit retention to fail PCI 10.5.1")
+			}
+			return
+		}
+	}
+	t.Error("PCI 10.5.1 control missing")
+}
@@ -0,0 +1,105 @@
+// Package compliance assembles evidence packages for SOC 2 and PCI DSS audits
+// from audit logs, security configuration, alert rules, and retention settings.
+package compliance
+
+import (
+	"time"
+
+	"github.com/chaksack/apm/pkg/security/auth"
+	"github.com/chaksack/apm/pkg/security/middleware"
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
+)
+
+// Framework identifies the compliance framework a report is generated for
+type Framework string
+
+const (
+	// FrameworkSOC2 is the AICPA SOC 2 Trust Services Criteria
+	FrameworkSOC2 Framework = "soc2"
+	// FrameworkPCI is PCI DSS v4.0
+	FrameworkPCI Framework = "pci-dss"
+)
+
+// ControlStatus is the evaluated state of a control
+type ControlStatus string
+
+const (
+	ControlSatisfied    ControlStatus = "satisfied"
+	ControlPartial      ControlStatus = "partial"
+	ControlNotSatisfied ControlStatus = "not_satisfied"
+)
+
+// Report is a structured evidence package for auditors
+type Report struct {
+	Framework     Framework             `json:"framework" yaml:"framework"`
+	GeneratedAt   time.Time             `json:"generated_at" yaml:"generated_at"`
+	PeriodStart   time.Time             `json:"period_start" yaml:"period_start"`
+	PeriodEnd     time.Time             `json:"period_end" yaml:"period_end"`
+	Controls      []Control             `json:"controls" yaml:"controls"`
+	Audit         AuditEvidence         `json:"audit" yaml:"audit"`
+	AccessControl AccessControlEvidence `json:"access_control" yaml:"access_control"`
+	Encryption    EncryptionEvidence    `json:"encryption" yaml:"encryption"`
+	Alerting      AlertingEvidence      `json:"alerting" yaml:"alerting"`
+	Retention     RetentionEvidence     `json:"retention" yaml:"retention"`
+}
+
+// Control maps a framework requirement to the evidence that supports it
+type Control struct {
+	ID       string        `json:"id" yaml:"id"`
+	Title    string        `json:"title" yaml:"title"`
+	Status   ControlStatus `json:"status" yaml:"status"`
+	Evidence []string      `json:"evidence" yaml:"evidence"`
+	Gaps     []string      `json:"gaps,omitempty" yaml:"gaps,omitempty"`
+}
+
+// AuditEvidence summarises audit log activity for the reporting period
+type AuditEvidence struct {
+	Enabled      bool                    `json:"enabled" yaml:"enabled"`
+	Sources      []string                `json:"sources" yaml:"sources"`
+	TotalEvents  int                     `json:"total_events" yaml:"total_events"`
+	EventsByType map[string]int          `json:"events_by_type" yaml:"events_by_type"`
+	FirstEvent   *time.Time              `json:"first_event,omitempty" yaml:"first_event,omitempty"`
+	LastEvent    *time.Time              `json:"last_event,omitempty" yaml:"last_event,omitempty"`
+	Extracts     []middleware.AuditEvent `json:"extracts" yaml:"extracts"`
+	SkippedLines int                     `json:"skipped_lines,omitempty" yaml:"skipped_lines,omitempty"`
+}
+
+// AccessControlEvidence is a snapshot of authentication and RBAC policy
+type AccessControlEvidence struct {
+	JWTEnabled    bool              `json:"jwt_enabled" yaml:"jwt_enabled"`
+	APIKeyEnabled bool              `json:"api_key_enabled" yaml:"api_key_enabled"`
+	TokenExpiry   string            `json:"token_expiry,omitempty" yaml:"token_expiry,omitempty"`
+	DefaultRole   string            `json:"default_role" yaml:"default_role"`
+	Roles         []auth.Role       `json:"roles" yaml:"roles"`
+	RoleMapping   map[string]string `json:"role_mapping,omitempty" yaml:"role_mapping,omitempty"`
+	APIKeyCount   int               `json:"api_key_count" yaml:"api_key_count"`
+}
+
+// EncryptionEvidence describes transport encryption settings
+type EncryptionEvidence struct {
+	Policy      tlspolicy.Policy    `json:"policy" yaml:"policy"`
+	TLSEnforced bool                `json:"tls_enforced" yaml:"tls_enforced"`
+	HSTS        string              `json:"hsts" yaml:"hsts"`
+	Findings    []tlspolicy.Finding `json:"findings,omitempty" yaml:"findings,omitempty"`
+}
+
+// AlertingEvidence describes alert rule coverage
+type AlertingEvidence struct {
+	RuleFiles []string        `json:"rule_files" yaml:"rule_files"`
+	Rules     []AlertRule     `json:"rules" yaml:"rules"`
+	Coverage  map[string]bool `json:"coverage" yaml:"coverage"`
+}
+
+// AlertRule is a Prometheus alerting rule summary
+type AlertRule struct {
+	Name     string `json:"name" yaml:"name"`
+	Group    string `json:"group" yaml:"group"`
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	File     string `json:"file" yaml:"file"`
+}
+
+// RetentionEvidence lists data retention periods per component
+type RetentionEvidence struct {
+	Settings map[string]string `json:"settings" yaml:"settings"`
+	Missing  []string          `json:"missing,omitempty" yaml:"missing,omitempty"`
+}
@@ -3,6 +3,7 @@ package security
 import (
 	"github.com/chaksack/apm/pkg/security/auth"
 	"github.com/chaksack/apm/pkg/security/middleware"
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
 )
 
 // Config represents the complete security configuration
@@ -30,6 +31,12 @@ type Config struct {
 
 	// API security configuration
 	APISecurity middleware.APISecurityConfig `yaml:"api_security" json:"api_security"`
+
+	// TLS policy configuration, including FIPS mode
+	TLS tlspolicy.Policy `yaml:"tls" json:"tls"`
+
+	// TLS enforcement configuration for inbound requests
+	TLSEnforcement middleware.TLSEnforcementConfig `yaml:"tls_enforcement" json:"tls_enforcement"`
 }
 
 // DefaultConfig returns a secure default configuration
@@ -51,11 +58,13 @@ func DefaultConfig() Config {
 			Roles:       auth.DefaultRoles,
 			DefaultRole: "viewer",
 		},
-		Headers:     middleware.DefaultSecurityHeadersConfig,
-		CORS:        middleware.DefaultCORSConfig,
-		RateLimit:   middleware.DefaultRateLimitConfig,
-		Audit:       middleware.DefaultAuditConfig,
-		CSRF:        middleware.DefaultCSRFConfig,
-		APISecurity: middleware.DefaultAPISecurityConfig,
+		Headers:        middleware.DefaultSecurityHeadersConfig,
+		CORS:           middleware.DefaultCORSConfig,
+		RateLimit:      middleware.DefaultRateLimitConfig,
+		Audit:          middleware.DefaultAuditConfig,
+		CSRF:           middleware.DefaultCSRFConfig,
+		APISecurity:    middleware.DefaultAPISecurityConfig,
+		TLS:            tlspolicy.DefaultPolicy(),
+		TLSEnforcement: middleware.DefaultTLSEnforcementConfig,
 	}
 }
@@ -21,6 +21,7 @@ type AuditEvent struct {
 	Severity     string                 `json:"severity"`
 	UserID       string                 `json:"user_id,omitempty"`
 	Username     string                 `json:"username,omitempty"`
+	ClientID     string                 `json:"client_id,omitempty"`
 	IP           string                 `json:"ip"`
 	UserAgent    string                 `json:"user_agent"`
 	Method       string                 `json:"method"`
@@ -93,6 +94,9 @@ func (l *ZapAuditLogger) Log(event *AuditEvent) error {
 	if event.Username != "" {
 		fields = append(fields, zap.String("username", event.Username))
 	}
+	if event.ClientID != "" {
+		fields = append(fields, zap.String("client_id", event.ClientID))
+	}
 	if event.Query != "" {
 		fields = append(fields, zap.String("query", event.Query))
 	}
@@ -219,6 +223,7 @@ func (m *AuditMiddleware) Apply() fiber.Handler {
 		if authCtx := auth.GetAuthContext(c); authCtx != nil {
 			event.UserID = authCtx.User.ID
 			event.Username = authCtx.User.Username
+			event.ClientID = authCtx.ClientID
 			event.Details["auth_type"] = string(authCtx.AuthType)
 			event.Details["roles"] = authCtx.User.Roles
 		}
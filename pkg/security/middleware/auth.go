@@ -87,6 +87,7 @@ func (m *AuthMiddleware) Authenticate() fiber.Handler {
 						AuthType:  auth.AuthTypeAPIKey,
 						Token:     apiKey,
 						RequestID: requestID,
+						ClientID:  key.ID,
 					}
 					auth.SetAuthContext(c, authCtx)
 
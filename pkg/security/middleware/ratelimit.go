@@ -27,6 +27,9 @@ type RateLimitConfig struct {
 
 	// Response headers
 	IncludeHeaders bool `yaml:"include_headers" json:"include_headers"`
+
+	// Signed per-trace bypass tokens for support reproduction
+	Bypass BypassConfig `yaml:"bypass" json:"bypass"`
 }
 
 // EndpointLimit represents rate limit for specific endpoint
@@ -158,15 +161,29 @@ func (m *RateLimitMiddleware) Apply() fiber.Handler {
 
 		// Check whitelist
 		if m.whitelistMap[ip] {
+			recordDecision(c, RateLimitScopeWhitelist, true, 0, 0)
+			return c.Next()
+		}
+
+		// Check bypass token for the request's trace
+		traceID := requestTraceID(c)
+		if m.checkBypass(c, traceID) {
+			m.logger.Info("rate limit bypassed",
+				zap.String("ip", ip),
+				zap.String("path", c.Path()),
+				zap.String("trace_id", traceID.String()))
+			recordDecision(c, RateLimitScopeBypass, true, 0, 0)
 			return c.Next()
 		}
 
 		// Check global rate limit
 		allowed, remaining, limit := m.globalLimiter.allow()
+		recordDecision(c, RateLimitScopeGlobal, allowed, remaining, limit)
 		if !allowed {
 			m.logger.Warn("global rate limit exceeded",
 				zap.String("ip", ip),
-				zap.String("path", c.Path()))
+				zap.String("path", c.Path()),
+				zap.String("trace_id", traceID.String()))
 
 			return m.rateLimitExceeded(c, remaining, limit)
 		}
@@ -174,10 +191,12 @@ func (m *RateLimitMiddleware) Apply() fiber.Handler {
 		// Check per-IP rate limit
 		ipLimiter := m.getIPLimiter(ip)
 		allowed, remaining, limit = ipLimiter.allow()
+		recordDecision(c, RateLimitScopeIP, allowed, remaining, limit)
 		if !allowed {
 			m.logger.Warn("IP rate limit exceeded",
 				zap.String("ip", ip),
-				zap.String("path", c.Path()))
+				zap.String("path", c.Path()),
+				zap.String("trace_id", traceID.String()))
 
 			return m.rateLimitExceeded(c, remaining, limit)
 		}
@@ -185,10 +204,12 @@ func (m *RateLimitMiddleware) Apply() fiber.Handler {
 		// Check endpoint-specific rate limit
 		if endpointLimiter, exists := m.endpointLimiters[c.Path()]; exists {
 			allowed, remaining, limit = endpointLimiter.allow()
+			recordDecision(c, RateLimitScopeEndpoint, allowed, remaining, limit)
 			if !allowed {
 				m.logger.Warn("endpoint rate limit exceeded",
 					zap.String("ip", ip),
-					zap.String("path", c.Path()))
+					zap.String("path", c.Path()),
+					zap.String("trace_id", traceID.String()))
 
 				return m.rateLimitExceeded(c, remaining, limit)
 			}
@@ -357,9 +378,15 @@ func (m *RateLimitMiddleware) rateLimitExceeded(c *fiber.Ctx, remaining, limit f
 		c.Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Duration(retryAfter)*time.Second).Unix()))
 	}
 
-	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+	body := fiber.Map{
 		"error":       "rate_limit_exceeded",
 		"message":     "too many requests",
 		"retry_after": retryAfter,
-	})
+	}
+	// The trace ID lets support mint a bypass token to reproduce the request
+	if traceID := requestTraceID(c); traceID.IsValid() {
+		body["trace_id"] = traceID.String()
+	}
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(body)
 }
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// BypassConfig configures rate limit bypass tokens. A support engineer
+// mints a short-lived token for the trace ID of a throttled request; the
+// request can then be replayed with that trace ID and the token to
+// reproduce the issue without being throttled.
+type BypassConfig struct {
+	// Secret signs the tokens; bypass tokens are disabled when it is empty
+	Secret string `yaml:"secret" json:"-"`
+	// Header carries the token; defaults to X-RateLimit-Bypass
+	Header string `yaml:"header" json:"header"`
+	// DefaultTTL and MaxTTL bound the token lifetime; they default to 15
+	// minutes and 1 hour
+	DefaultTTL time.Duration `yaml:"default_ttl" json:"default_ttl"`
+	MaxTTL     time.Duration `yaml:"max_ttl" json:"max_ttl"`
+}
+
+// Rate limit decision scopes recorded on spans
+const (
+	RateLimitScopeGlobal    = "global"
+	RateLimitScopeIP        = "ip"
+	RateLimitScopeEndpoint  = "endpoint"
+	RateLimitScopeWhitelist = "whitelist"
+	RateLimitScopeBypass    = "bypass"
+)
+
+var (
+	// ErrBypassDisabled is returned when minting without a bypass secret
+	ErrBypassDisabled = errors.New("rate limit bypass tokens are disabled")
+	errInvalidToken   = errors.New("invalid bypass token")
+)
+
+func (c BypassConfig) withDefaults() BypassConfig {
+	if c.Header == "" {
+		c.Header = "X-RateLimit-Bypass"
+	}
+	if c.DefaultTTL <= 0 {
+		c.DefaultTTL = 15 * time.Minute
+	}
+	if c.MaxTTL <= 0 {
+		c.MaxTTL = time.Hour
+	}
+	return c
+}
+
+// MintBypassToken creates a token that exempts requests of the given trace
+// from rate limiting until it expires. A zero ttl uses the default; ttls
+// above the maximum are capped.
+func (m *RateLimitMiddleware) MintBypassToken(traceID trace.TraceID, ttl time.Duration) (string, time.Time, error) {
+	cfg := m.config.Bypass.withDefaults()
+	if cfg.Secret == "" {
+		return "", time.Time{}, ErrBypassDisabled
+	}
+	if !traceID.IsValid() {
+		return "", time.Time{}, fmt.Errorf("invalid trace ID")
+	}
+	if ttl <= 0 {
+		ttl = cfg.DefaultTTL
+	}
+	if ttl > cfg.MaxTTL {
+		ttl = cfg.MaxTTL
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	payload := traceID.String() + "." + strconv.FormatInt(expires.Unix(), 10)
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(m.sign(payload))
+	return token, expires, nil
+}
+
+// verifyBypassToken checks that token is signed, unexpired, and minted for
+// traceID
+func (m *RateLimitMiddleware) verifyBypassToken(token string, traceID trace.TraceID) error {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, m.sign(string(payload))) {
+		return errInvalidToken
+	}
+
+	tokenTrace, expiry, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return errInvalidToken
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return errInvalidToken
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		return fmt.Errorf("bypass token expired")
+	}
+	if !traceID.IsValid() || tokenTrace != traceID.String() {
+		return fmt.Errorf("bypass token is for another trace")
+	}
+	return nil
+}
+
+func (m *RateLimitMiddleware) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(m.config.Bypass.Secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// checkBypass reports whether the request carries a valid bypass token
+func (m *RateLimitMiddleware) checkBypass(c *fiber.Ctx, traceID trace.TraceID) bool {
+	cfg := m.config.Bypass.withDefaults()
+	token := c.Get(cfg.Header)
+	if cfg.Secret == "" || token == "" {
+		return false
+	}
+	if err := m.verifyBypassToken(token, traceID); err != nil {
+		m.logger.Warn("rejected rate limit bypass token",
+			zap.String("ip", c.IP()),
+			zap.String("path", c.Path()),
+			zap.String("trace_id", traceID.String()),
+			zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// MintBypassHandler mints bypass tokens from a JSON body such as
+// {"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "ttl": "10m"}. It must be
+// mounted behind authentication for support staff.
+func (m *RateLimitMiddleware) MintBypassHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			TraceID string `json:"trace_id"`
+			TTL     string `json:"ttl"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid request body")
+		}
+		traceID, err := trace.TraceIDFromHex(req.TraceID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "trace_id must be 32 hex characters")
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			if ttl, err = time.ParseDuration(req.TTL); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "ttl must be a duration such as 10m")
+			}
+		}
+
+		token, expires, err := m.MintBypassToken(traceID, ttl)
+		if errors.Is(err, ErrBypassDisabled) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		m.logger.Info("minted rate limit bypass token",
+			zap.String("trace_id", req.TraceID),
+			zap.Time("expires_at", expires),
+			zap.String("minted_by_ip", c.IP()))
+
+		return c.JSON(fiber.Map{
+			"token":      token,
+			"header":     m.config.Bypass.withDefaults().Header,
+			"trace_id":   req.TraceID,
+			"expires_at": expires.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// requestTraceID returns the trace ID of the request's span, or of its
+// traceparent header when no tracing middleware ran before the limiter
+func requestTraceID(c *fiber.Ctx) trace.TraceID {
+	if sc := trace.SpanContextFromContext(c.UserContext()); sc.IsValid() {
+		return sc.TraceID()
+	}
+	// traceparent: version-traceid-parentid-flags
+	parts := strings.Split(c.Get("traceparent"), "-")
+	if len(parts) == 4 {
+		if id, err := trace.TraceIDFromHex(parts[1]); err == nil {
+			return id
+		}
+	}
+	return trace.TraceID{}
+}
+
+// recordDecision adds the rate limit decision to the request's span
+func recordDecision(c *fiber.Ctx, scope string, allowed bool, remaining, limit float64) {
+	span := trace.SpanFromContext(c.UserContext())
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent("rate_limit.decision", trace.WithAttributes(
+		attribute.String("rate_limit.scope", scope),
+		attribute.Bool("rate_limit.allowed", allowed),
+		attribute.Float64("rate_limit.remaining", remaining),
+		attribute.Float64("rate_limit.limit", limit),
+	))
+	if !allowed {
+		span.SetAttributes(
+			attribute.Bool("rate_limit.throttled", true),
+			attribute.String("rate_limit.scope", scope),
+		)
+	}
+}
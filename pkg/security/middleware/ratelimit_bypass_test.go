@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func newBypassLimiter(secret string) *RateLimitMiddleware {
+	return NewRateLimitMiddleware(RateLimitConfig{
+		RequestsPerMinute:      60,
+		BurstSize:              100,
+		PerIPRequestsPerMinute: 1,
+		PerIPBurstSize:         1,
+		Bypass:                 BypassConfig{Secret: secret},
+	}, zap.NewNop())
+}
+
+func TestBypassToken(t *testing.T) {
+	m := newBypassLimiter("s3cret")
+	traceID, _ := trace.TraceIDFromHex(testTraceID)
+
+	token, expires, err := m.MintBypassToken(traceID, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expires); until > time.Hour || until < 59*time.Minute {
+		t.Errorf("expires in %s, want the ttl capped at an hour", until)
+	}
+	if err := m.verifyBypassToken(token, traceID); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+
+	other, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	if err := m.verifyBypassToken(token, other); err == nil {
+		t.Error("token accepted for another trace")
+	}
+	if err := newBypassLimiter("other").verifyBypassToken(token, traceID); err == nil {
+		t.Error("token accepted with another secret")
+	}
+	if err := m.verifyBypassToken(token[:len(token)-2]+"AA", traceID); err == nil {
+		t.Error("tampered token accepted")
+	}
+
+	if _, _, err := newBypassLimiter("").MintBypassToken(traceID, 0); err != ErrBypassDisabled {
+		t.Errorf("err = %v, want ErrBypassDisabled without a secret", err)
+	}
+}
+
+func TestRateLimitBypassAndSpanEvents(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	m := newBypassLimiter("s3cret")
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		traceID, _ := trace.TraceIDFromHex(testTraceID)
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{1},
+			TraceFlags: trace.FlagsSampled,
+			Remote:     true,
+		}))
+		ctx, span := tracer.Start(ctx, c.Path())
+		defer span.End()
+		c.SetUserContext(ctx)
+		return c.Next()
+	})
+	app.Post("/bypass", m.MintBypassHandler())
+	app.Use(m.Apply())
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	get := func(token string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			req.Header.Set("X-RateLimit-Bypass", token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := get(""); code != fiber.StatusOK {
+		t.Fatalf("first request = %d", code)
+	}
+	if code := get(""); code != fiber.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want throttled", code)
+	}
+
+	req := httptest.NewRequest("POST", "/bypass", strings.NewReader(`{"trace_id":"`+testTraceID+`","ttl":"5m"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var minted struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&minted); err != nil || minted.Token == "" {
+		t.Fatalf("mint response %d: %v", resp.StatusCode, err)
+	}
+	if code := get(minted.Token); code != fiber.StatusOK {
+		t.Errorf("bypassed request = %d", code)
+	}
+
+	var scopes []string
+	throttled := 0
+	for _, span := range recorder.Ended() {
+		for _, attr := range span.Attributes() {
+			if attr.Key == "rate_limit.throttled" {
+				throttled++
+			}
+		}
+		for _, event := range span.Events() {
+			if event.Name != "rate_limit.decision" {
+				continue
+			}
+			for _, attr := range event.Attributes {
+				if attr.Key == "rate_limit.scope" {
+					scopes = append(scopes, attr.Value.AsString())
+				}
+			}
+		}
+	}
+	want := "global,ip,global,ip,bypass"
+	if got := strings.Join(scopes, ","); got != want {
+		t.Errorf("decision scopes = %s, want %s", got, want)
+	}
+	if throttled != 1 {
+		t.Errorf("%d throttled spans, want 1", throttled)
+	}
+}
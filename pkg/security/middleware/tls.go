@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/chaksack/apm/pkg/security/tlspolicy"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// TLSEnforcementConfig represents TLS enforcement configuration
+type TLSEnforcementConfig struct {
+	// Enabled rejects requests that do not satisfy the TLS policy
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// TrustForwardedProto accepts X-Forwarded-Proto from a TLS terminating proxy
+	TrustForwardedProto bool `yaml:"trust_forwarded_proto" json:"trust_forwarded_proto"`
+
+	// SkipPaths are exempt from enforcement, such as health checks
+	SkipPaths []string `yaml:"skip_paths" json:"skip_paths"`
+}
+
+// DefaultTLSEnforcementConfig provides secure defaults
+var DefaultTLSEnforcementConfig = TLSEnforcementConfig{
+	Enabled:   false,
+	SkipPaths: []string{"/health", "/ready"},
+}
+
+// TLSEnforcementMiddleware rejects requests that do not meet the TLS policy
+type TLSEnforcementMiddleware struct {
+	config     TLSEnforcementConfig
+	policy     tlspolicy.Policy
+	minVersion uint16
+	suites     map[uint16]bool
+	logger     *zap.Logger
+}
+
+// NewTLSEnforcementMiddleware creates a new TLS enforcement middleware
+func NewTLSEnforcementMiddleware(config TLSEnforcementConfig, policy tlspolicy.Policy, logger *zap.Logger) (*TLSEnforcementMiddleware, error) {
+	tlsConfig, err := policy.TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS policy: %w", err)
+	}
+
+	suites := make(map[uint16]bool)
+	for _, id := range tlsConfig.CipherSuites {
+		suites[id] = true
+	}
+
+	return &TLSEnforcementMiddleware{
+		config:     config,
+		policy:     policy.Effective(),
+		minVersion: tlsConfig.MinVersion,
+		suites:     suites,
+		logger:     logger,
+	}, nil
+}
+
+// Apply returns the TLS enforcement middleware handler
+func (m *TLSEnforcementMiddleware) Apply() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !m.config.Enabled {
+			return c.Next()
+		}
+
+		for _, path := range m.config.SkipPaths {
+			if c.Path() == path {
+				return c.Next()
+			}
+		}
+
+		state := c.Context().TLSConnectionState()
+		if state == nil {
+			if m.config.TrustForwardedProto && c.Get("X-Forwarded-Proto") == "https" {
+				return c.Next()
+			}
+			return m.reject(c, "TLS required")
+		}
+
+		if state.Version < m.minVersion {
+			return m.reject(c, fmt.Sprintf("%s below minimum TLS %s", tls.VersionName(state.Version), m.policy.MinVersion))
+		}
+
+		// TLS 1.3 suites are always approved
+		if state.Version < tls.VersionTLS13 && len(m.suites) > 0 && !m.suites[state.CipherSuite] {
+			return m.reject(c, fmt.Sprintf("cipher suite %s not permitted", tls.CipherSuiteName(state.CipherSuite)))
+		}
+
+		return c.Next()
+	}
+}
+
+// reject logs and rejects a request that violates the TLS policy
+func (m *TLSEnforcementMiddleware) reject(c *fiber.Ctx, reason string) error {
+	if m.logger != nil {
+		m.logger.Warn("Request rejected by TLS policy",
+			zap.String("reason", reason),
+			zap.String("path", c.Path()),
+			zap.String("ip", c.IP()),
+		)
+	}
+
+	return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+		"error":   "TLS policy violation",
+		"message": reason,
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !fips
+
+package tlspolicy
+
+// buildFIPS is set when the binary is built with the "fips" build tag
+const buildFIPS = false
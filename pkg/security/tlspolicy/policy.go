@@ -0,0 +1,220 @@
+// Package tlspolicy defines the TLS policy shared by the security middleware,
+// telemetry exporters, and cloud clients, including a FIPS mode that restricts
+// connections to FIPS 140 approved protocol versions, cipher suites, and curves.
+//
+// FIPS mode is enabled either by building with the "fips" build tag, by
+// setting APM_FIPS_MODE=true, or by setting FIPSMode in the configuration.
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Policy describes the TLS requirements for inbound and outbound connections
+type Policy struct {
+	// FIPSMode restricts TLS to FIPS approved algorithms
+	FIPSMode bool `yaml:"fips_mode" json:"fips_mode" mapstructure:"fips_mode"`
+
+	// MinVersion is the minimum TLS version: "1.2" or "1.3"
+	MinVersion string `yaml:"min_version" json:"min_version" mapstructure:"min_version"`
+
+	// CipherSuites lists allowed TLS 1.2 cipher suites by IANA name.
+	// TLS 1.3 suites are not configurable in Go.
+	CipherSuites []string `yaml:"cipher_suites" json:"cipher_suites,omitempty" mapstructure:"cipher_suites"`
+
+	// Curves lists allowed key exchange curves: P256, P384, P521, X25519
+	Curves []string `yaml:"curves" json:"curves,omitempty" mapstructure:"curves"`
+}
+
+// FIPSCipherSuites are the TLS 1.2 cipher suites approved under FIPS 140
+var FIPSCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// FIPSCurves are the key exchange curves approved under FIPS 140
+var FIPSCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// DefaultPolicy returns the policy for the current build and environment
+func DefaultPolicy() Policy {
+	if FIPSEnabled() {
+		return FIPSPolicy()
+	}
+	return Policy{MinVersion: "1.2"}
+}
+
+// FIPSPolicy returns a policy that only permits FIPS approved algorithms
+func FIPSPolicy() Policy {
+	return Policy{
+		FIPSMode:     true,
+		MinVersion:   "1.2",
+		CipherSuites: cipherSuiteNames(FIPSCipherSuites),
+		Curves:       []string{"P256", "P384", "P521"},
+	}
+}
+
+// FIPSEnabled reports whether FIPS mode is enabled by build tag or environment
+func FIPSEnabled() bool {
+	if buildFIPS {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(os.Getenv("APM_FIPS_MODE"))
+	return enabled
+}
+
+// Effective returns the policy with FIPS restrictions applied when required
+func (p Policy) Effective() Policy {
+	if !p.FIPSMode && !FIPSEnabled() {
+		if p.MinVersion == "" {
+			p.MinVersion = "1.2"
+		}
+		return p
+	}
+
+	fips := FIPSPolicy()
+	if p.MinVersion == "1.3" {
+		fips.MinVersion = "1.3"
+	}
+	// Keep an explicit allow list only when it is a subset of the approved suites
+	if len(p.CipherSuites) > 0 && len(nonApprovedSuites(p.CipherSuites)) == 0 {
+		fips.CipherSuites = p.CipherSuites
+	}
+	return fips
+}
+
+// Validate checks the policy for unknown or inconsistent values
+func (p Policy) Validate() error {
+	if _, err := parseVersion(p.MinVersion); err != nil {
+		return err
+	}
+	if _, err := parseCipherSuites(p.CipherSuites); err != nil {
+		return err
+	}
+	if _, err := parseCurves(p.Curves); err != nil {
+		return err
+	}
+	if p.FIPSMode {
+		if bad := nonApprovedSuites(p.CipherSuites); len(bad) > 0 {
+			return fmt.Errorf("cipher suites not approved for FIPS mode: %s", strings.Join(bad, ", "))
+		}
+	}
+	return nil
+}
+
+// TLSConfig returns a new tls.Config enforcing the policy
+func (p Policy) TLSConfig() (*tls.Config, error) {
+	return p.Apply(nil)
+}
+
+// Apply enforces the policy on a clone of base, which may be nil
+func (p Policy) Apply(base *tls.Config) (*tls.Config, error) {
+	p = p.Effective()
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var cfg *tls.Config
+	if base != nil {
+		cfg = base.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+
+	minVersion, _ := parseVersion(p.MinVersion)
+	if cfg.MinVersion < minVersion {
+		cfg.MinVersion = minVersion
+	}
+
+	if len(p.CipherSuites) > 0 {
+		cfg.CipherSuites, _ = parseCipherSuites(p.CipherSuites)
+	}
+	if len(p.Curves) > 0 {
+		cfg.CurvePreferences, _ = parseCurves(p.Curves)
+	}
+
+	return cfg, nil
+}
+
+// parseVersion converts a policy version string to a tls version constant
+func parseVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(v), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum TLS version %q: must be 1.2 or 1.3", v)
+	}
+}
+
+// parseCipherSuites converts IANA cipher suite names to IDs
+func parseCipherSuites(names []string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		byName[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		byName[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseCurves converts curve names to IDs
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		switch strings.ToUpper(strings.ReplaceAll(name, "-", "")) {
+		case "P256":
+			curves = append(curves, tls.CurveP256)
+		case "P384":
+			curves = append(curves, tls.CurveP384)
+		case "P521":
+			curves = append(curves, tls.CurveP521)
+		case "X25519":
+			curves = append(curves, tls.X25519)
+		default:
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+	}
+	return curves, nil
+}
+
+// cipherSuiteNames converts cipher suite IDs to names
+func cipherSuiteNames(ids []uint16) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return names
+}
+
+// nonApprovedSuites returns the cipher suites that are not FIPS approved
+func nonApprovedSuites(names []string) []string {
+	approved := make(map[string]bool)
+	for _, name := range cipherSuiteNames(FIPSCipherSuites) {
+		approved[name] = true
+	}
+
+	var bad []string
+	for _, name := range names {
+		if !approved[name] {
+			bad = append(bad, name)
+		}
+	}
+	return bad
+}
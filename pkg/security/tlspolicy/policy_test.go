@@ -0,0 +1,72 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestFIPSPolicyApply(t *testing.T) {
+	cfg, err := FIPSPolicy().Apply(&tls.Config{MinVersion: tls.VersionTLS10, ServerName: "collector"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 minimum, got %s", tls.VersionName(cfg.MinVersion))
+	}
+	if cfg.ServerName != "collector" {
+		t.Errorf("expected base config to be preserved, got server name %q", cfg.ServerName)
+	}
+	if len(cfg.CipherSuites) != len(FIPSCipherSuites) {
+		t.Errorf("expected %d cipher suites, got %d", len(FIPSCipherSuites), len(cfg.CipherSuites))
+	}
+	for _, curve := range cfg.CurvePreferences {
+		if curve == tls.X25519 {
+			t.Error("X25519 must not be preferred in FIPS mode")
+		}
+	}
+}
+
+func TestValidateRejectsNonApprovedSuites(t *testing.T) {
+	p := Policy{
+		FIPSMode:     true,
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+	}
+	if err := p.Validate(); err == nil {
+		t.Error("expected ChaCha20 to be rejected in FIPS mode")
+	}
+
+	if err := (Policy{MinVersion: "1.1"}).Validate(); err == nil {
+		t.Error("expected TLS 1.1 minimum to be rejected")
+	}
+}
+
+func TestCheckTLSConfigReport(t *testing.T) {
+	t.Setenv("APM_FIPS_MODE", "true")
+
+	report := NewReport(DefaultPolicy())
+	DefaultPolicy().CheckTLSConfig(report, "exporter.otlp-http", &tls.Config{
+		MinVersion:         tls.VersionTLS11,
+		InsecureSkipVerify: true, // #nosec G402 -- test fixture
+	})
+
+	if !report.FIPSMode {
+		t.Error("expected APM_FIPS_MODE to enable FIPS mode")
+	}
+	if report.Compliant() {
+		t.Fatal("expected violations")
+	}
+
+	settings := make(map[string]bool)
+	for _, f := range report.Violations() {
+		settings[f.Setting] = true
+	}
+	for _, want := range []string{"insecure_skip_verify", "min_version", "cipher_suites"} {
+		if !settings[want] {
+			t.Errorf("expected a %s violation, got %v", want, report.Findings)
+		}
+	}
+	if report.Err() == nil {
+		t.Error("expected Err to summarise violations")
+	}
+}
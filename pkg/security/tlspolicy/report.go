@@ -0,0 +1,181 @@
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// Severity indicates how serious a compliance finding is
+type Severity string
+
+const (
+	// SeverityViolation means the setting is not permitted by the policy
+	SeverityViolation Severity = "violation"
+	// SeverityWarning means the setting is permitted but discouraged
+	SeverityWarning Severity = "warning"
+)
+
+// Finding describes a single non-compliant setting
+type Finding struct {
+	Component   string   `json:"component"`
+	Setting     string   `json:"setting"`
+	Value       string   `json:"value,omitempty"`
+	Requirement string   `json:"requirement"`
+	Severity    Severity `json:"severity"`
+}
+
+// Report collects compliance findings across components
+type Report struct {
+	FIPSMode bool      `json:"fips_mode"`
+	Findings []Finding `json:"findings"`
+}
+
+// NewReport creates an empty report for the effective policy
+func NewReport(p Policy) *Report {
+	return &Report{FIPSMode: p.Effective().FIPSMode}
+}
+
+// Add records a finding
+func (r *Report) Add(f Finding) {
+	r.Findings = append(r.Findings, f)
+}
+
+// Violations returns the findings that violate the policy
+func (r *Report) Violations() []Finding {
+	var out []Finding
+	for _, f := range r.Findings {
+		if f.Severity == SeverityViolation {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Compliant reports whether the report contains no violations
+func (r *Report) Compliant() bool {
+	return len(r.Violations()) == 0
+}
+
+// Err returns an error summarising violations, or nil when compliant
+func (r *Report) Err() error {
+	violations := r.Violations()
+	if len(violations) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = fmt.Sprintf("%s: %s (%s)", v.Component, v.Setting, v.Requirement)
+	}
+	return fmt.Errorf("TLS policy violations: %s", strings.Join(msgs, "; "))
+}
+
+// String renders the report as human readable text
+func (r *Report) String() string {
+	var b strings.Builder
+	mode := "standard"
+	if r.FIPSMode {
+		mode = "FIPS"
+	}
+	fmt.Fprintf(&b, "TLS policy report (%s mode): %d finding(s)\n", mode, len(r.Findings))
+	for _, f := range r.Findings {
+		fmt.Fprintf(&b, "  [%s] %s %s", f.Severity, f.Component, f.Setting)
+		if f.Value != "" {
+			fmt.Fprintf(&b, "=%s", f.Value)
+		}
+		fmt.Fprintf(&b, ": %s\n", f.Requirement)
+	}
+	return b.String()
+}
+
+// CheckTLSConfig records findings for a tls.Config that does not satisfy the policy
+func (p Policy) CheckTLSConfig(r *Report, component string, cfg *tls.Config) {
+	p = p.Effective()
+
+	if cfg == nil {
+		// A nil config means Go defaults, which allow non-FIPS suites
+		if p.FIPSMode {
+			r.Add(Finding{
+				Component:   component,
+				Setting:     "tls_config",
+				Value:       "default",
+				Requirement: "explicit FIPS TLS configuration required",
+				Severity:    SeverityViolation,
+			})
+		}
+		return
+	}
+
+	if cfg.InsecureSkipVerify {
+		r.Add(Finding{
+			Component:   component,
+			Setting:     "insecure_skip_verify",
+			Value:       "true",
+			Requirement: "certificate verification must be enabled",
+			Severity:    severityFor(p),
+		})
+	}
+
+	minVersion, _ := parseVersion(p.MinVersion)
+	if cfg.MinVersion < minVersion {
+		r.Add(Finding{
+			Component:   component,
+			Setting:     "min_version",
+			Value:       tls.VersionName(cfg.MinVersion),
+			Requirement: fmt.Sprintf("minimum TLS %s", p.MinVersion),
+			Severity:    SeverityViolation,
+		})
+	}
+
+	if p.FIPSMode {
+		if len(cfg.CipherSuites) == 0 {
+			r.Add(Finding{
+				Component:   component,
+				Setting:     "cipher_suites",
+				Value:       "default",
+				Requirement: "cipher suites must be restricted to FIPS approved suites",
+				Severity:    SeverityViolation,
+			})
+		} else if bad := nonApprovedSuites(cipherSuiteNames(cfg.CipherSuites)); len(bad) > 0 {
+			r.Add(Finding{
+				Component:   component,
+				Setting:     "cipher_suites",
+				Value:       strings.Join(bad, ","),
+				Requirement: "only FIPS approved cipher suites are allowed",
+				Severity:    SeverityViolation,
+			})
+		}
+
+		for _, curve := range cfg.CurvePreferences {
+			if curve == tls.X25519 {
+				r.Add(Finding{
+					Component:   component,
+					Setting:     "curves",
+					Value:       "X25519",
+					Requirement: "only NIST P-curves are allowed",
+					Severity:    SeverityViolation,
+				})
+			}
+		}
+	}
+}
+
+// CheckPlaintext records a finding for a component configured without TLS
+func (p Policy) CheckPlaintext(r *Report, component, endpoint string) {
+	r.Add(Finding{
+		Component:   component,
+		Setting:     "insecure",
+		Value:       endpoint,
+		Requirement: "connections must use TLS",
+		Severity:    severityFor(p.Effective()),
+	})
+}
+
+// severityFor returns a violation in FIPS mode and a warning otherwise
+func severityFor(p Policy) Severity {
+	if p.FIPSMode {
+		return SeverityViolation
+	}
+	return SeverityWarning
+}
@@ -0,0 +1,90 @@
+package statuspage
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+//go:embed page.html.tmpl
+var pageTemplate string
+
+var pageHTML = template.Must(template.New("page").Funcs(template.FuncMap{
+	"banner": func(status string) string {
+		switch status {
+		case StatusOperational:
+			return "All systems operational"
+		case StatusMaintenance:
+			return "Scheduled maintenance in progress"
+		case StatusDegraded:
+			return "Some systems are degraded"
+		case StatusOutage:
+			return "Some systems are down"
+		}
+		return "System status unknown"
+	},
+	"label": func(status string) string {
+		switch status {
+		case StatusOutage:
+			return "Major outage"
+		case StatusDegraded:
+			return "Degraded"
+		case StatusMaintenance:
+			return "Maintenance"
+		case StatusUnknown:
+			return "Unknown"
+		}
+		return "Operational"
+	},
+	"dayClass": func(d Day, objective float64) string {
+		switch {
+		case d.Uptime == nil:
+			return "bg-unknown"
+		case *d.Uptime < outageBelow*100:
+			return "bg-outage"
+		case d.Incidents > 0, objective > 0 && *d.Uptime < objective:
+			return "bg-degraded"
+		case d.Maintenance:
+			return "bg-maintenance"
+		}
+		return "bg-operational"
+	},
+	"dayTitle": func(d Day) string {
+		title := d.Date + ": "
+		if d.Uptime == nil {
+			title += "no data"
+		} else {
+			title += fmt.Sprintf("%.2f%% uptime", *d.Uptime)
+		}
+		if d.Incidents == 1 {
+			title += ", 1 incident"
+		} else if d.Incidents > 1 {
+			title += fmt.Sprintf(", %d incidents", d.Incidents)
+		}
+		if d.Maintenance {
+			title += ", maintenance"
+		}
+		return title
+	},
+	"upcoming": func(windows []MaintenanceWindow) bool {
+		for _, m := range windows {
+			if !m.InProgress {
+				return true
+			}
+		}
+		return false
+	},
+	"percent": func(pct float64) string { return fmt.Sprintf("%.2f%%", pct) },
+	"join":    func(names []string) string { return strings.Join(names, ", ") },
+	"datetime": func(t time.Time) string {
+		return t.UTC().Format("Jan 2, 2006 15:04 UTC")
+	},
+}).Parse(pageTemplate))
+
+// WriteHTML renders the page as a self-contained HTML document
+func (p *Page) WriteHTML(w io.Writer) error {
+	return pageHTML.Execute(w, p)
+}
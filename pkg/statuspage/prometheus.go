@@ -0,0 +1,111 @@
+package statuspage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoData is returned by scalar when the query has no result
+var errNoData = fmt.Errorf("no data")
+
+// scalar runs an instant query and returns the value of its first series
+func (b *Builder) scalar(ctx context.Context, query string, at time.Time) (float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(at.Unix(), 10))
+
+	var data struct {
+		Result []struct {
+			Value [2]interface{} `json:"value"`
+		} `json:"result"`
+	}
+	if err := b.get(ctx, "/api/v1/query", params, &data); err != nil {
+		return 0, err
+	}
+	for _, r := range data.Result {
+		s, _ := r.Value[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil && valid(v) {
+			return v, nil
+		}
+	}
+	return 0, errNoData
+}
+
+// matrix runs a range query and returns the first series by timestamp
+func (b *Builder) matrix(ctx context.Context, query string, start, end time.Time, step time.Duration) (map[int64]float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatInt(int64(step.Seconds()), 10))
+
+	var data struct {
+		Result []struct {
+			Values [][2]interface{} `json:"values"`
+		} `json:"result"`
+	}
+	if err := b.get(ctx, "/api/v1/query_range", params, &data); err != nil {
+		return nil, err
+	}
+	values := make(map[int64]float64)
+	if len(data.Result) == 0 {
+		return values, nil
+	}
+	for _, v := range data.Result[0].Values {
+		ts, _ := v[0].(float64)
+		s, _ := v[1].(string)
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || !valid(f) {
+			continue
+		}
+		values[int64(ts)] = f
+	}
+	return values, nil
+}
+
+// get calls a Prometheus API endpoint and decodes its data
+func (b *Builder) get(ctx context.Context, path string, params url.Values, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(b.PrometheusURL, "/")+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	client := b.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32*1024*1024))
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Status string          `json:"status"`
+		Error  string          `json:"error"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+	if out.Status != "success" {
+		return fmt.Errorf("prometheus: %s", out.Error)
+	}
+	return json.Unmarshal(out.Data, data)
+}
+
+func valid(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
@@ -0,0 +1,486 @@
+// Package statuspage generates a public status page: the components of a
+// service grouped as declared in apm.yaml, each with its current status and
+// daily uptime from synthetic checks or SLIs in Prometheus, the incidents of
+// the incident timeline that affected them, and scheduled maintenance. The
+// page is rendered as a single static HTML file or as JSON.
+//
+// Incidents show only which components were affected and when, unless
+// ShowIncidentTitles is set, since alert names and summaries are internal.
+package statuspage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/incident"
+)
+
+// Defaults
+const (
+	DefaultTitle = "Service Status"
+	DefaultDays  = 90
+)
+
+// Status of a component or of the page, from best to worst
+const (
+	StatusOperational = "operational"
+	StatusMaintenance = "maintenance"
+	StatusUnknown     = "unknown"
+	StatusDegraded    = "degraded"
+	StatusOutage      = "outage"
+)
+
+// statusRank orders the statuses for the overall status
+var statusRank = map[string]int{
+	StatusOperational: 0,
+	StatusMaintenance: 1,
+	StatusUnknown:     2,
+	StatusDegraded:    3,
+	StatusOutage:      4,
+}
+
+// outageBelow is the availability under which a component is down rather
+// than degraded
+const outageBelow = 0.5
+
+// Config is the status_page section of apm.yaml
+type Config struct {
+	Title       string `mapstructure:"title" yaml:"title" json:"title"`
+	Description string `mapstructure:"description" yaml:"description" json:"description,omitempty"`
+
+	// Days of uptime history shown, default DefaultDays
+	Days int `mapstructure:"days" yaml:"days" json:"days,omitempty"`
+
+	// ShowIncidentTitles publishes the incident summaries' titles instead
+	// of naming the affected components only
+	ShowIncidentTitles bool `mapstructure:"show_incident_titles" yaml:"show_incident_titles" json:"show_incident_titles,omitempty"`
+
+	Components  []Component   `mapstructure:"components" yaml:"components" json:"components"`
+	Maintenance []Maintenance `mapstructure:"maintenance" yaml:"maintenance" json:"maintenance,omitempty"`
+}
+
+// Component is a part of the service shown on the page
+type Component struct {
+	Name        string `mapstructure:"name" yaml:"name" json:"name"`
+	Group       string `mapstructure:"group" yaml:"group" json:"group,omitempty"`
+	Description string `mapstructure:"description" yaml:"description" json:"description,omitempty"`
+
+	// Query is PromQL for the component's availability between 0 and 1,
+	// e.g. probe_success of a synthetic check or an SLI ratio; several
+	// series are averaged
+	Query string `mapstructure:"query" yaml:"query" json:"query"`
+
+	// Objective is the availability SLO in percent, e.g. 99.9; below it the
+	// component is degraded
+	Objective float64 `mapstructure:"objective" yaml:"objective" json:"objective,omitempty"`
+
+	// Services are the service label values of alerts affecting the
+	// component, matched against the incident timeline
+	Services []string `mapstructure:"services" yaml:"services" json:"services,omitempty"`
+}
+
+// Maintenance is a scheduled maintenance window
+type Maintenance struct {
+	Title       string    `mapstructure:"title" yaml:"title" json:"title"`
+	Description string    `mapstructure:"description" yaml:"description" json:"description,omitempty"`
+	Components  []string  `mapstructure:"components" yaml:"components" json:"components"`
+	Start       time.Time `mapstructure:"start" yaml:"start" json:"start"`
+	End         time.Time `mapstructure:"end" yaml:"end" json:"end"`
+}
+
+// Validate checks the config and fills in defaults
+func (c *Config) Validate() error {
+	if c.Title == "" {
+		c.Title = DefaultTitle
+	}
+	if c.Days == 0 {
+		c.Days = DefaultDays
+	}
+	if c.Days < 1 || c.Days > 366 {
+		return fmt.Errorf("status page days must be between 1 and 366")
+	}
+	if len(c.Components) == 0 {
+		return fmt.Errorf("status page has no components")
+	}
+	names := make(map[string]bool)
+	for _, comp := range c.Components {
+		if comp.Name == "" {
+			return fmt.Errorf("status page component without a name")
+		}
+		if names[comp.Name] {
+			return fmt.Errorf("status page component %s is declared twice", comp.Name)
+		}
+		names[comp.Name] = true
+		if comp.Query == "" {
+			return fmt.Errorf("status page component %s has no query", comp.Name)
+		}
+		if comp.Objective < 0 || comp.Objective >= 100 {
+			return fmt.Errorf("status page component %s: objective must be a percentage below 100", comp.Name)
+		}
+	}
+	for _, m := range c.Maintenance {
+		if m.Start.IsZero() || m.End.IsZero() {
+			return fmt.Errorf("maintenance %q needs a start and an end", m.Title)
+		}
+		if !m.End.After(m.Start) {
+			return fmt.Errorf("maintenance %q ends before it starts", m.Title)
+		}
+		for _, name := range m.Components {
+			if !names[name] {
+				return fmt.Errorf("maintenance %q: unknown component %s", m.Title, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Page is a generated status page
+type Page struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Status is the worst status of the components
+	Status      string              `json:"status"`
+	Days        int                 `json:"days"`
+	Groups      []Group             `json:"groups"`
+	Incidents   []Incident          `json:"incidents"`
+	Maintenance []MaintenanceWindow `json:"maintenance"`
+}
+
+// Group is a set of components shown together; components without a group
+// are in a group without a name
+type Group struct {
+	Name       string            `json:"name,omitempty"`
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// ComponentStatus is the status and uptime history of a component
+type ComponentStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Status      string `json:"status"`
+	// Uptime is the availability in percent over the days with data, nil
+	// without data
+	Uptime    *float64 `json:"uptime"`
+	Objective float64  `json:"objective,omitempty"`
+	History   []Day    `json:"history"`
+}
+
+// Day is the uptime of a component on one UTC day
+type Day struct {
+	Date        string   `json:"date"`
+	Uptime      *float64 `json:"uptime"`
+	Incidents   int      `json:"incidents"`
+	Maintenance bool     `json:"maintenance"`
+}
+
+// Incident is an incident that affected components of the page
+type Incident struct {
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Resolved   bool       `json:"resolved"`
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Components []string   `json:"components"`
+}
+
+// MaintenanceWindow is a maintenance in progress or scheduled
+type MaintenanceWindow struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Components  []string  `json:"components"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	InProgress  bool      `json:"in_progress"`
+}
+
+// Builder generates pages from Prometheus and the incident timeline
+type Builder struct {
+	PrometheusURL string
+	Client        *http.Client
+	// Timeline supplies the incidents; nil shows none
+	Timeline incident.Timeline
+
+	now func() time.Time
+}
+
+// Build generates the page. A component whose query fails is shown with an
+// unknown status rather than failing the page.
+func (b *Builder) Build(ctx context.Context, cfg Config) (*Page, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	now := b.clock().UTC()
+	today := now.Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, -(cfg.Days - 1))
+
+	page := &Page{
+		Title:       cfg.Title,
+		Description: cfg.Description,
+		GeneratedAt: now,
+		Days:        cfg.Days,
+		Groups:      []Group{},
+		Incidents:   []Incident{},
+		Maintenance: []MaintenanceWindow{},
+	}
+
+	incidents, err := b.incidents(cfg, first)
+	if err != nil {
+		return nil, err
+	}
+	page.Incidents = incidents
+
+	inMaintenance := make(map[string]bool)
+	maintenanceDays := make(map[string]map[string]bool)
+	for _, m := range cfg.Maintenance {
+		start, end := m.Start.UTC(), m.End.UTC()
+		for _, name := range m.Components {
+			if maintenanceDays[name] == nil {
+				maintenanceDays[name] = make(map[string]bool)
+			}
+			for d := start.Truncate(24 * time.Hour); d.Before(end); d = d.AddDate(0, 0, 1) {
+				maintenanceDays[name][d.Format(time.DateOnly)] = true
+			}
+		}
+		if end.Before(now) {
+			continue
+		}
+		inProgress := !start.After(now)
+		if inProgress {
+			for _, name := range m.Components {
+				inMaintenance[name] = true
+			}
+		}
+		page.Maintenance = append(page.Maintenance, MaintenanceWindow{
+			Title:       m.Title,
+			Description: m.Description,
+			Components:  m.Components,
+			Start:       start,
+			End:         end,
+			InProgress:  inProgress,
+		})
+	}
+	sort.SliceStable(page.Maintenance, func(i, j int) bool { return page.Maintenance[i].Start.Before(page.Maintenance[j].Start) })
+
+	groupIndex := make(map[string]int)
+	for _, comp := range cfg.Components {
+		status := b.component(ctx, comp, now, first, today)
+		ongoing := false
+		for _, inc := range incidents {
+			affected := false
+			for _, name := range inc.Components {
+				affected = affected || name == comp.Name
+			}
+			if !affected {
+				continue
+			}
+			ongoing = ongoing || !inc.Resolved
+			end := now
+			if inc.ResolvedAt != nil {
+				end = *inc.ResolvedAt
+			}
+			for n := range status.History {
+				day, _ := time.Parse(time.DateOnly, status.History[n].Date)
+				if inc.StartedAt.Before(day.AddDate(0, 0, 1)) && !end.Before(day) {
+					status.History[n].Incidents++
+				}
+			}
+		}
+		for n := range status.History {
+			status.History[n].Maintenance = maintenanceDays[comp.Name][status.History[n].Date]
+		}
+		switch {
+		case inMaintenance[comp.Name]:
+			status.Status = StatusMaintenance
+		case ongoing && statusRank[status.Status] < statusRank[StatusDegraded]:
+			status.Status = StatusDegraded
+		}
+
+		n, ok := groupIndex[comp.Group]
+		if !ok {
+			n = len(page.Groups)
+			groupIndex[comp.Group] = n
+			page.Groups = append(page.Groups, Group{Name: comp.Group, Status: StatusOperational})
+		}
+		page.Groups[n].Components = append(page.Groups[n].Components, status)
+		page.Groups[n].Status = worse(page.Groups[n].Status, status.Status)
+	}
+
+	page.Status = StatusOperational
+	for _, g := range page.Groups {
+		page.Status = worse(page.Status, g.Status)
+	}
+	return page, nil
+}
+
+// component queries the current availability and daily uptime of a
+// component
+func (b *Builder) component(ctx context.Context, comp Component, now, first, today time.Time) ComponentStatus {
+	status := ComponentStatus{
+		Name:        comp.Name,
+		Description: comp.Description,
+		Status:      StatusUnknown,
+		Objective:   comp.Objective,
+	}
+
+	// A day's value is evaluated at its end, covering the day before
+	daily, err := b.matrix(ctx, fmt.Sprintf("avg(avg_over_time((%s)[1d:5m]))", comp.Query),
+		first.AddDate(0, 0, 1), today.AddDate(0, 0, 1), 24*time.Hour)
+	if err != nil {
+		daily = nil
+	}
+	sum, days := 0.0, 0
+	for d := first; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := Day{Date: d.Format(time.DateOnly)}
+		if v, ok := daily[d.AddDate(0, 0, 1).Unix()]; ok {
+			pct := round(v * 100)
+			day.Uptime = &pct
+			sum += v
+			days++
+		}
+		status.History = append(status.History, day)
+	}
+	if days > 0 {
+		uptime := round(sum / float64(days) * 100)
+		status.Uptime = &uptime
+	}
+
+	current, err := b.scalar(ctx, fmt.Sprintf("avg(%s)", comp.Query), now)
+	if err != nil {
+		return status
+	}
+	switch {
+	case current < outageBelow:
+		status.Status = StatusOutage
+	case current*100 < comp.Objective:
+		status.Status = StatusDegraded
+	default:
+		status.Status = StatusOperational
+	}
+	return status
+}
+
+// incidents returns the incidents of the timeline since first that affected
+// components of the page, newest first
+func (b *Builder) incidents(cfg Config, first time.Time) ([]Incident, error) {
+	if b.Timeline == nil {
+		return []Incident{}, nil
+	}
+	entries, err := b.Timeline.Entries(incident.Filter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the incident timeline: %w", err)
+	}
+
+	componentsOf := make(map[string][]string)
+	for _, comp := range cfg.Components {
+		for _, service := range comp.Services {
+			componentsOf[service] = append(componentsOf[service], comp.Name)
+		}
+	}
+
+	type state struct {
+		incident Incident
+		firing   map[string]bool
+		affected map[string]bool
+	}
+	byID := make(map[string]*state)
+	var order []string
+	for _, e := range entries {
+		if e.Incident == "" {
+			continue
+		}
+		s, ok := byID[e.Incident]
+		if !ok {
+			s = &state{incident: Incident{ID: e.Incident}, firing: make(map[string]bool), affected: make(map[string]bool)}
+			byID[e.Incident] = s
+			order = append(order, e.Incident)
+		}
+		switch e.Type {
+		case incident.EntryAlert:
+			if s.incident.StartedAt.IsZero() || e.Time.Before(s.incident.StartedAt) {
+				s.incident.StartedAt = e.Time.UTC()
+			}
+			s.firing[e.Subject] = true
+			for _, label := range incident.DefaultServiceLabels {
+				for _, name := range componentsOf[entryLabel(e, label)] {
+					s.affected[name] = true
+				}
+			}
+		case incident.EntryResolved:
+			delete(s.firing, e.Subject)
+			if len(s.firing) == 0 {
+				t := e.Time.UTC()
+				s.incident.ResolvedAt = &t
+			}
+		case incident.EntrySummary:
+			if s.incident.Title == "" {
+				s.incident.Title = e.Subject
+			}
+		}
+	}
+
+	incidents := []Incident{}
+	for _, id := range order {
+		s := byID[id]
+		inc := s.incident
+		if len(s.affected) == 0 || inc.StartedAt.IsZero() {
+			continue
+		}
+		inc.Resolved = len(s.firing) == 0
+		if !inc.Resolved {
+			inc.ResolvedAt = nil
+		}
+		if inc.Resolved && inc.ResolvedAt.Before(first) {
+			continue
+		}
+		for _, comp := range cfg.Components {
+			if s.affected[comp.Name] {
+				inc.Components = append(inc.Components, comp.Name)
+			}
+		}
+		if !cfg.ShowIncidentTitles || inc.Title == "" {
+			inc.Title = "Degraded service: " + strings.Join(inc.Components, ", ")
+		}
+		incidents = append(incidents, inc)
+	}
+	sort.SliceStable(incidents, func(i, j int) bool { return incidents[i].StartedAt.After(incidents[j].StartedAt) })
+	return incidents, nil
+}
+
+// entryLabel returns an alert label of a timeline entry, whose labels are a
+// map of strings when recorded and of interfaces when read back from JSON
+func entryLabel(e incident.Entry, name string) string {
+	switch labels := e.Data["labels"].(type) {
+	case map[string]string:
+		return labels[name]
+	case map[string]interface{}:
+		s, _ := labels[name].(string)
+		return s
+	}
+	return ""
+}
+
+func worse(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+	return a
+}
+
+// round rounds a percentage to two decimals
+func round(pct float64) float64 {
+	return math.Round(pct*100) / 100
+}
+
+func (b *Builder) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
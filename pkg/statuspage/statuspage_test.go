@@ -0,0 +1,164 @@
+package statuspage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/incident"
+)
+
+// fakePrometheus answers the status page queries: the API is always up, the
+// web checkout is partially failing, and the search has no data
+func fakePrometheus(t *testing.T, today time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		var current, daily string
+		switch {
+		case strings.Contains(query, `job="api"`):
+			current, daily = "1", "1"
+		case strings.Contains(query, `job="checkout"`):
+			current, daily = "0.98", "0.995"
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+			return
+		}
+		if r.URL.Path == "/api/v1/query" {
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[%d,"%s"]}]}}`, today.Unix(), current)
+			return
+		}
+		if !strings.HasPrefix(query, "avg(avg_over_time((") {
+			t.Errorf("daily query = %s", query)
+		}
+		// The last two days of history
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[%d,"%s"],[%d,"%s"]]}]}}`,
+			today.Unix(), daily, today.AddDate(0, 0, 1).Unix(), daily)
+	}))
+}
+
+func TestBuild(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	today := now.Truncate(24 * time.Hour)
+	prometheus := fakePrometheus(t, today)
+	defer prometheus.Close()
+
+	timeline := &incident.MemoryTimeline{}
+	started := now.Add(-30 * time.Hour)
+	timeline.Append(incident.Entry{Time: started, Incident: "inc-1", Type: incident.EntryAlert, Subject: "fp-1",
+		Data: map[string]interface{}{"labels": map[string]interface{}{"alertname": "CheckoutErrors", "service": "checkout"}}})
+	timeline.Append(incident.Entry{Time: started, Incident: "inc-1", Type: incident.EntrySummary, Subject: "Payment provider timeouts"})
+	timeline.Append(incident.Entry{Time: started.Add(time.Hour), Incident: "inc-1", Type: incident.EntryResolved, Subject: "fp-1"})
+	timeline.Append(incident.Entry{Time: now.Add(-time.Hour), Incident: "inc-2", Type: incident.EntryAlert, Subject: "fp-2",
+		Data: map[string]interface{}{"labels": map[string]string{"alertname": "CheckoutLatency", "job": "checkout"}}})
+	timeline.Append(incident.Entry{Time: now.Add(-time.Hour), Incident: "inc-3", Type: incident.EntryAlert, Subject: "fp-3",
+		Data: map[string]interface{}{"labels": map[string]string{"alertname": "DiskFull", "service": "batch"}}})
+
+	cfg := Config{
+		Title: "Shop Status",
+		Days:  30,
+		Components: []Component{
+			{Name: "API", Group: "Core", Query: `probe_success{job="api"}`, Objective: 99.9},
+			{Name: "Checkout", Group: "Core", Query: `probe_success{job="checkout"}`, Objective: 99.5, Services: []string{"checkout"}},
+			{Name: "Search", Query: `probe_success{job="search"}`},
+		},
+		Maintenance: []Maintenance{
+			{Title: "Database upgrade", Components: []string{"Search"}, Start: at("2024-05-10T11:00:00Z"), End: at("2024-05-10T13:00:00Z")},
+			{Title: "Network work", Components: []string{"API"}, Start: at("2024-05-12T01:00:00Z"), End: at("2024-05-12T02:00:00Z")},
+			{Title: "Done", Components: []string{"API"}, Start: at("2024-05-01T01:00:00Z"), End: at("2024-05-01T02:00:00Z")},
+		},
+	}
+	builder := &Builder{PrometheusURL: prometheus.URL, Client: prometheus.Client(), Timeline: timeline, now: func() time.Time { return now }}
+	page, err := builder.Build(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Status != StatusDegraded || len(page.Groups) != 2 || page.Groups[0].Name != "Core" || page.Groups[1].Name != "" {
+		t.Fatalf("page = %s, groups %+v", page.Status, page.Groups)
+	}
+	api, checkout, search := page.Groups[0].Components[0], page.Groups[0].Components[1], page.Groups[1].Components[0]
+	if api.Status != StatusOperational || api.Uptime == nil || *api.Uptime != 100 || len(api.History) != 30 {
+		t.Errorf("api = %+v", api)
+	}
+	if api.History[29].Date != "2024-05-10" || api.History[29].Uptime == nil || api.History[27].Uptime != nil {
+		t.Errorf("api history = %+v", api.History[27:])
+	}
+	// Below its objective, with an incident ongoing
+	if checkout.Status != StatusDegraded || *checkout.Uptime != 99.5 {
+		t.Errorf("checkout = %+v", checkout)
+	}
+	if checkout.History[28].Incidents != 1 || checkout.History[29].Incidents != 1 || checkout.History[27].Incidents != 0 {
+		t.Errorf("checkout incidents per day = %+v", checkout.History[27:])
+	}
+	if search.Status != StatusMaintenance || search.Uptime != nil || !search.History[29].Maintenance {
+		t.Errorf("search = %+v", search)
+	}
+
+	// inc-3 affects no component of the page
+	if len(page.Incidents) != 2 || page.Incidents[0].ID != "inc-2" || page.Incidents[0].Resolved {
+		t.Fatalf("incidents = %+v", page.Incidents)
+	}
+	if inc := page.Incidents[1]; !inc.Resolved || !inc.ResolvedAt.Equal(started.Add(time.Hour)) || inc.Title != "Degraded service: Checkout" {
+		t.Errorf("resolved incident = %+v", inc)
+	}
+	if len(page.Maintenance) != 2 || !page.Maintenance[0].InProgress || page.Maintenance[1].InProgress {
+		t.Errorf("maintenance = %+v", page.Maintenance)
+	}
+
+	var html bytes.Buffer
+	if err := page.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<title>Shop Status</title>", "Some systems are degraded", "Maintenance in progress: Database upgrade", "Network work", "99.50% uptime", "Ongoing"} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("HTML lacks %q", want)
+		}
+	}
+	if strings.Contains(html.String(), "Payment provider") {
+		t.Error("incident title published without show_incident_titles")
+	}
+
+	cfg.ShowIncidentTitles = true
+	page, err = builder.Build(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Incidents[1].Title != "Payment provider timeouts" {
+		t.Errorf("title = %s", page.Incidents[1].Title)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{Components: []Component{{Name: "API", Query: "up"}}}
+	if err := cfg.Validate(); err != nil || cfg.Title != DefaultTitle || cfg.Days != DefaultDays {
+		t.Errorf("defaults = %+v, %v", cfg, err)
+	}
+	for name, cfg := range map[string]Config{
+		"no components": {},
+		"no query":      {Components: []Component{{Name: "API"}}},
+		"duplicate":     {Components: []Component{{Name: "API", Query: "up"}, {Name: "API", Query: "up"}}},
+		"objective":     {Components: []Component{{Name: "API", Query: "up", Objective: 100}}},
+		"no start":      {Components: []Component{{Name: "API", Query: "up"}}, Maintenance: []Maintenance{{Components: []string{"API"}, End: at("2024-05-12T02:00:00Z")}}},
+		"reversed": {Components: []Component{{Name: "API", Query: "up"}}, Maintenance: []Maintenance{{Components: []string{"API"},
+			Start: at("2024-05-12T02:00:00Z"), End: at("2024-05-12T01:00:00Z")}}},
+		"unknown component": {Components: []Component{{Name: "API", Query: "up"}}, Maintenance: []Maintenance{{Components: []string{"DB"},
+			Start: at("2024-05-12T01:00:00Z"), End: at("2024-05-12T02:00:00Z")}}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func at(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
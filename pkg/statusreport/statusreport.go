@@ -0,0 +1,245 @@
+// Package statusreport defines the versioned document printed by
+// `apm status --json`, so watchdogs monitoring the monitoring stack can parse
+// it without tracking the terminal output. The document is deterministic:
+// every field is always present, lists are sorted, times are RFC 3339 UTC,
+// and durations are in seconds. Fields are only added within a version;
+// renaming or removing one bumps Version.
+package statusreport
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// Version is the version of the document, and of the schema
+const Version = 1
+
+// SchemaID identifies the JSON Schema of this version
+const SchemaID = "https://github.com/chaksack/apm/schemas/status-v1.json"
+
+// Health of a deployment, component, or tool
+const (
+	HealthHealthy   = "healthy"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+	HealthUnknown   = "unknown"
+)
+
+//go:embed schema.json
+var schema []byte
+
+// Schema returns the JSON Schema of the document
+func Schema() []byte {
+	return schema
+}
+
+// Report is the status of the deployments and local tools at one moment
+type Report struct {
+	Schema      string       `json:"$schema"`
+	Version     int          `json:"version"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Health      string       `json:"health"`
+	Deployments []Deployment `json:"deployments"`
+	Tools       []Tool       `json:"tools"`
+
+	// Exporters is the startup check of the application's telemetry
+	// backends, from its readiness endpoint; nil when it was not read
+	Exporters *Exporters `json:"exporters"`
+
+	// Error is why the status could not be collected, e.g. an unreadable
+	// apm.yaml; a watchdog should treat it as unhealthy
+	Error *Error `json:"error"`
+}
+
+// Deployment is the status of one deployment
+type Deployment struct {
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Type            string      `json:"type"`
+	Status          string      `json:"status"`
+	Health          string      `json:"health"`
+	Progress        int         `json:"progress"`
+	StartedAt       time.Time   `json:"started_at"`
+	DurationSeconds float64     `json:"duration_seconds"`
+	LastCheckedAt   time.Time   `json:"last_checked_at"`
+	Message         string      `json:"message"`
+	LastError       *Error      `json:"last_error"`
+	Components      []Component `json:"components"`
+	Endpoints       []Endpoint  `json:"endpoints"`
+}
+
+// Component is the status of one component of a deployment
+type Component struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	Health    string `json:"health"`
+	Version   string `json:"version"`
+	Message   string `json:"message"`
+	LastError *Error `json:"last_error"`
+}
+
+// Endpoint is a named URL of a deployment
+type Endpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Tool is a local APM tool probed by the CLI
+type Tool struct {
+	Type           string  `json:"type"`
+	Detected       bool    `json:"detected"`
+	Endpoint       string  `json:"endpoint"`
+	Version        string  `json:"version"`
+	Health         string  `json:"health"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Reason         string  `json:"reason"`
+}
+
+// Exporters is the outcome of the application's exporter policy
+type Exporters struct {
+	Policy    string     `json:"policy"`
+	Health    string     `json:"health"`
+	Message   string     `json:"message"`
+	Exporters []Exporter `json:"exporters"`
+}
+
+// Exporter is the check of one telemetry backend; an exporter the disable
+// policy turned off is not enabled
+type Exporter struct {
+	Name      string `json:"name"`
+	Endpoint  string `json:"endpoint"`
+	Reachable bool   `json:"reachable"`
+	Enabled   bool   `json:"enabled"`
+	Error     string `json:"error"`
+}
+
+// Error is an error with the time it was seen
+type Error struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// New creates an empty report generated at now
+func New(now time.Time) *Report {
+	return &Report{
+		Schema:      SchemaID,
+		Version:     Version,
+		GeneratedAt: now.UTC(),
+		Health:      HealthUnknown,
+		Deployments: []Deployment{},
+		Tools:       []Tool{},
+	}
+}
+
+// Normalize sorts the lists, converts times to UTC, replaces nil lists with
+// empty ones, and derives the overall health, so that equal states encode to
+// equal bytes
+func (r *Report) Normalize() {
+	r.GeneratedAt = r.GeneratedAt.UTC()
+	if r.Deployments == nil {
+		r.Deployments = []Deployment{}
+	}
+	if r.Tools == nil {
+		r.Tools = []Tool{}
+	}
+	normalizeError(r.Error)
+
+	for i := range r.Deployments {
+		d := &r.Deployments[i]
+		d.StartedAt = d.StartedAt.UTC()
+		d.LastCheckedAt = d.LastCheckedAt.UTC()
+		d.Health = health(d.Health)
+		normalizeError(d.LastError)
+		if d.Components == nil {
+			d.Components = []Component{}
+		}
+		if d.Endpoints == nil {
+			d.Endpoints = []Endpoint{}
+		}
+		for j := range d.Components {
+			d.Components[j].Health = health(d.Components[j].Health)
+			normalizeError(d.Components[j].LastError)
+		}
+		sort.SliceStable(d.Components, func(a, b int) bool { return d.Components[a].Name < d.Components[b].Name })
+		sort.Slice(d.Endpoints, func(a, b int) bool { return d.Endpoints[a].Name < d.Endpoints[b].Name })
+	}
+	sort.SliceStable(r.Deployments, func(a, b int) bool { return r.Deployments[a].ID < r.Deployments[b].ID })
+	for i := range r.Tools {
+		r.Tools[i].Health = health(r.Tools[i].Health)
+	}
+	sort.Slice(r.Tools, func(a, b int) bool { return r.Tools[a].Type < r.Tools[b].Type })
+	if e := r.Exporters; e != nil {
+		e.Health = health(e.Health)
+		if e.Exporters == nil {
+			e.Exporters = []Exporter{}
+		}
+		sort.Slice(e.Exporters, func(a, b int) bool { return e.Exporters[a].Name < e.Exporters[b].Name })
+	}
+
+	r.Health = r.overall()
+}
+
+// overall is the worst health of the deployments and the exporters,
+// unhealthy on an error, and unknown without deployments
+func (r *Report) overall() string {
+	if r.Error != nil {
+		return HealthUnhealthy
+	}
+	if len(r.Deployments) == 0 {
+		return HealthUnknown
+	}
+	rank := map[string]int{HealthHealthy: 0, HealthUnknown: 1, HealthDegraded: 2, HealthUnhealthy: 3}
+	worst := HealthHealthy
+	for _, d := range r.Deployments {
+		if rank[d.Health] > rank[worst] {
+			worst = d.Health
+		}
+	}
+	if r.Exporters != nil && rank[r.Exporters.Health] > rank[worst] {
+		worst = r.Exporters.Health
+	}
+	return worst
+}
+
+// health maps the health words used by deployments and tools onto the four
+// of the schema
+func health(h string) string {
+	switch h {
+	case HealthHealthy, HealthDegraded, HealthUnhealthy:
+		return h
+	case "warning":
+		return HealthDegraded
+	case "critical", "failed", "error":
+		return HealthUnhealthy
+	}
+	return HealthUnknown
+}
+
+func normalizeError(e *Error) {
+	if e != nil {
+		e.Time = e.Time.UTC()
+	}
+}
+
+// Seconds converts a duration to seconds with millisecond precision
+func Seconds(d time.Duration) float64 {
+	return float64(d.Milliseconds()) / 1000
+}
+
+// Write writes the normalized report as indented JSON
+func (r *Report) Write(w io.Writer) error {
+	r.Normalize()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteLine writes the normalized report as one line of NDJSON
+func (r *Report) WriteLine(w io.Writer) error {
+	r.Normalize()
+	return json.NewEncoder(w).Encode(r)
+}
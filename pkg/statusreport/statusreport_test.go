@@ -0,0 +1,168 @@
+package statusreport
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// TestSchemaMatchesTypes keeps the schema and the Go types in step: every
+// field is a property and required
+func TestSchemaMatchesTypes(t *testing.T) {
+	var doc struct {
+		ID         string         `json:"$id"`
+		Properties map[string]any `json:"properties"`
+		Required   []string       `json:"required"`
+		Defs       map[string]struct {
+			Properties map[string]any `json:"properties"`
+			Required   []string       `json:"required"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(Schema(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != SchemaID {
+		t.Errorf("$id = %s, want %s", doc.ID, SchemaID)
+	}
+
+	check := func(name string, typ reflect.Type, properties map[string]any, required []string) {
+		fields := jsonFields(typ)
+		if got := keys(properties); !reflect.DeepEqual(got, fields) {
+			t.Errorf("%s: properties %v, fields %v", name, got, fields)
+		}
+		sort.Strings(required)
+		if !reflect.DeepEqual(required, fields) {
+			t.Errorf("%s: required %v, fields %v", name, required, fields)
+		}
+	}
+	check("report", reflect.TypeOf(Report{}), doc.Properties, doc.Required)
+	for name, typ := range map[string]reflect.Type{
+		"deployment": reflect.TypeOf(Deployment{}),
+		"component":  reflect.TypeOf(Component{}),
+		"endpoint":   reflect.TypeOf(Endpoint{}),
+		"tool":       reflect.TypeOf(Tool{}),
+		"exporters":  reflect.TypeOf(Exporters{}),
+		"exporter":   reflect.TypeOf(Exporter{}),
+		"error":      reflect.TypeOf(Error{}),
+	} {
+		def, ok := doc.Defs[name]
+		if !ok {
+			t.Errorf("schema has no %s", name)
+			continue
+		}
+		check(name, typ, def.Properties, def.Required)
+	}
+}
+
+func jsonFields(typ reflect.Type) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func keys(m map[string]any) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDeterministic(t *testing.T) {
+	report := func(components []Component, endpoints []Endpoint) *Report {
+		r := New(t0.In(time.FixedZone("CEST", 2*3600)))
+		r.Deployments = []Deployment{{ID: "dep-1", Health: "healthy", StartedAt: t0, Components: components, Endpoints: endpoints}}
+		return r
+	}
+	var a, b bytes.Buffer
+	report(
+		[]Component{{Name: "grafana"}, {Name: "app"}},
+		[]Endpoint{{Name: "grafana", URL: "https://grafana"}, {Name: "app", URL: "https://app"}},
+	).Write(&a)
+	report(
+		[]Component{{Name: "app"}, {Name: "grafana"}},
+		[]Endpoint{{Name: "app", URL: "https://app"}, {Name: "grafana", URL: "https://grafana"}},
+	).Write(&b)
+	if a.String() != b.String() {
+		t.Errorf("reports differ\n%s\n%s", a.String(), b.String())
+	}
+	if !strings.Contains(a.String(), `"generated_at": "2024-05-01T12:00:00Z"`) {
+		t.Errorf("expected UTC times\n%s", a.String())
+	}
+	if !strings.Contains(a.String(), `"last_error": null`) || !strings.Contains(a.String(), `"tools": []`) {
+		t.Errorf("expected every field to be present\n%s", a.String())
+	}
+}
+
+func TestOverallHealth(t *testing.T) {
+	tests := []struct {
+		healths []string
+		err     *Error
+		want    string
+	}{
+		{nil, nil, HealthUnknown},
+		{[]string{"healthy", "healthy"}, nil, HealthHealthy},
+		{[]string{"healthy", "warning"}, nil, HealthDegraded},
+		{[]string{"degraded", "critical"}, nil, HealthUnhealthy},
+		{[]string{"healthy", "starting"}, nil, HealthUnknown},
+		{[]string{"healthy"}, &Error{Message: "no apm.yaml", Time: t0}, HealthUnhealthy},
+	}
+	for _, tt := range tests {
+		r := New(t0)
+		r.Error = tt.err
+		for i, h := range tt.healths {
+			r.Deployments = append(r.Deployments, Deployment{ID: string(rune('a' + i)), Health: h})
+		}
+		r.Normalize()
+		if r.Health != tt.want {
+			t.Errorf("%v: health = %s, want %s", tt.healths, r.Health, tt.want)
+		}
+	}
+}
+
+func TestExportersHealth(t *testing.T) {
+	r := New(t0)
+	r.Deployments = []Deployment{{ID: "dep-1", Health: HealthHealthy}}
+	r.Exporters = &Exporters{
+		Policy: "fail-open",
+		Health: HealthDegraded,
+		Exporters: []Exporter{
+			{Name: "tracing", Endpoint: "collector:4317", Enabled: true, Error: "connection refused"},
+			{Name: "push_gateway", Endpoint: "http://pushgateway:9091", Reachable: true, Enabled: true},
+		},
+	}
+	r.Normalize()
+	if r.Health != HealthDegraded {
+		t.Errorf("health = %s with an unreachable exporter, want degraded", r.Health)
+	}
+	if r.Exporters.Exporters[0].Name != "push_gateway" {
+		t.Errorf("exporters not sorted by name: %+v", r.Exporters.Exporters)
+	}
+}
+
+func TestWriteLine(t *testing.T) {
+	var buf bytes.Buffer
+	New(t0).WriteLine(&buf)
+	New(t0.Add(time.Second)).WriteLine(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines, got %q", buf.String())
+	}
+	for _, line := range lines {
+		var r Report
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.Version != Version {
+			t.Errorf("line %q: %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migration is one schema change. Statements are shared by both databases
+// except the identity column, written {{id}}.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations are applied in order and never edited once released; a schema
+// change is a new migration
+var migrations = []migration{
+	{1, "incident timeline", `
+		CREATE TABLE timeline_entries (
+			id {{id}},
+			time BIGINT NOT NULL,
+			incident TEXT NOT NULL DEFAULT '',
+			type TEXT NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			text TEXT NOT NULL DEFAULT '',
+			data TEXT
+		);
+		CREATE INDEX timeline_entries_incident ON timeline_entries (incident, time);
+		CREATE INDEX timeline_entries_type ON timeline_entries (type, time)`},
+	{2, "chat command audit", `
+		CREATE TABLE audit_events (
+			id {{id}},
+			time BIGINT NOT NULL,
+			event_type TEXT NOT NULL,
+			platform TEXT NOT NULL DEFAULT '',
+			user_id TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			channel TEXT NOT NULL DEFAULT '',
+			command TEXT NOT NULL DEFAULT '',
+			text TEXT NOT NULL DEFAULT '',
+			roles TEXT NOT NULL DEFAULT '',
+			outcome TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX audit_events_time ON audit_events (time)`},
+	{3, "tenant usage", `
+		CREATE TABLE tenant_usage (
+			instance TEXT NOT NULL,
+			tenant TEXT NOT NULL,
+			requests BIGINT NOT NULL DEFAULT 0,
+			rejected BIGINT NOT NULL DEFAULT 0,
+			bytes_in BIGINT NOT NULL DEFAULT 0,
+			bytes_out BIGINT NOT NULL DEFAULT 0,
+			last_seen BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (instance, tenant)
+		)`},
+	{4, "config change requests", `
+		CREATE TABLE change_requests (
+			id TEXT PRIMARY KEY,
+			created BIGINT NOT NULL,
+			status TEXT NOT NULL,
+			data TEXT NOT NULL
+		);
+		CREATE INDEX change_requests_status ON change_requests (status, created)`},
+	{5, "config change audit", `
+		CREATE TABLE change_audit (
+			id {{id}},
+			time BIGINT NOT NULL,
+			request_id TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL
+		);
+		CREATE INDEX change_audit_time ON change_audit (time)`},
+}
+
+// migrate applies the migrations newer than the schema version, each in its
+// own transaction. On Postgres an advisory lock keeps replicas starting
+// together from migrating twice.
+func (s *Store) migrate(ctx context.Context) error {
+	if s.config.Driver == DriverPostgres {
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to connect to postgres: %w", err)
+		}
+		defer conn.Close()
+		// The key is arbitrary but fixed: "apm" in ASCII
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(6385773)"); err != nil {
+			return fmt.Errorf("failed to lock schema migrations: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(6385773)")
+	}
+
+	if _, err := s.exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.config.Driver == DriverPostgres {
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range strings.Split(strings.ReplaceAll(m.sql, "{{id}}", id), ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			m.version, m.name, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied migration
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
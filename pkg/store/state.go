@@ -0,0 +1,290 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/tenancy"
+)
+
+// Timeline returns the incident timeline kept in the store
+func (s *Store) Timeline() incident.Timeline {
+	return &timeline{s}
+}
+
+type timeline struct{ s *Store }
+
+// Append implements incident.Timeline
+func (t *timeline) Append(entry incident.Entry) error {
+	var data sql.NullString
+	if len(entry.Data) > 0 {
+		raw, err := json.Marshal(entry.Data)
+		if err != nil {
+			return err
+		}
+		data = sql.NullString{String: string(raw), Valid: true}
+	}
+	_, err := t.s.exec(context.Background(),
+		"INSERT INTO timeline_entries (time, incident, type, subject, text, data) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.Time.UnixNano(), entry.Incident, entry.Type, entry.Subject, entry.Text, data)
+	return err
+}
+
+// Entries implements incident.Timeline, returning entries in time order
+func (t *timeline) Entries(filter incident.Filter) ([]incident.Entry, error) {
+	var where []string
+	var args []interface{}
+	if filter.Incident != "" {
+		where, args = append(where, "incident = ?"), append(args, filter.Incident)
+	}
+	if filter.Type != "" {
+		where, args = append(where, "type = ?"), append(args, filter.Type)
+	}
+	if !filter.Since.IsZero() {
+		where, args = append(where, "time >= ?"), append(args, filter.Since.UnixNano())
+	}
+	if !filter.Until.IsZero() {
+		where, args = append(where, "time <= ?"), append(args, filter.Until.UnixNano())
+	}
+	query := "SELECT time, incident, type, subject, text, data FROM timeline_entries"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := t.s.query(context.Background(), query+" ORDER BY time, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []incident.Entry
+	for rows.Next() {
+		var e incident.Entry
+		var nanos int64
+		var data sql.NullString
+		if err := rows.Scan(&nanos, &e.Incident, &e.Type, &e.Subject, &e.Text, &data); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, nanos).UTC()
+		if data.Valid {
+			if err := json.Unmarshal([]byte(data.String), &e.Data); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Auditor returns the chat command audit log kept in the store
+func (s *Store) Auditor() chatops.Auditor {
+	return &auditor{s}
+}
+
+type auditor struct{ s *Store }
+
+// Record implements chatops.Auditor
+func (a *auditor) Record(event chatops.AuditEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	_, err := a.s.exec(context.Background(),
+		`INSERT INTO audit_events (time, event_type, platform, user_id, actor, channel, command, text, roles, outcome, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Timestamp.UnixNano(), event.EventType, event.Platform, event.UserID, event.Actor, event.Channel,
+		event.Command, event.Text, strings.Join(event.Roles, ","), event.Outcome, event.Error)
+	return err
+}
+
+// AuditEvents returns the audit events recorded since a time, oldest first
+func (s *Store) AuditEvents(ctx context.Context, since time.Time) ([]chatops.AuditEvent, error) {
+	rows, err := s.query(ctx,
+		`SELECT time, event_type, platform, user_id, actor, channel, command, text, roles, outcome, error
+		FROM audit_events WHERE time >= ? ORDER BY time, id`, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []chatops.AuditEvent
+	for rows.Next() {
+		var e chatops.AuditEvent
+		var nanos int64
+		var roles string
+		if err := rows.Scan(&nanos, &e.EventType, &e.Platform, &e.UserID, &e.Actor, &e.Channel,
+			&e.Command, &e.Text, &roles, &e.Outcome, &e.Error); err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(0, nanos).UTC()
+		if roles != "" {
+			e.Roles = strings.Split(roles, ",")
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ChangeAuditor returns the config change audit log kept in the store
+func (s *Store) ChangeAuditor() changes.Auditor {
+	return &changeAuditor{s}
+}
+
+type changeAuditor struct{ s *Store }
+
+// Record implements changes.Auditor
+func (a *changeAuditor) Record(event changes.AuditEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = a.s.exec(context.Background(),
+		`INSERT INTO change_audit (time, request_id, data) VALUES (?, ?, ?)`,
+		event.Timestamp.UnixNano(), event.RequestID, string(data))
+	return err
+}
+
+// ChangeAuditEvents returns the config change audit events recorded since a
+// time, oldest first
+func (s *Store) ChangeAuditEvents(ctx context.Context, since time.Time) ([]changes.AuditEvent, error) {
+	rows, err := s.query(ctx,
+		`SELECT data FROM change_audit WHERE time >= ? ORDER BY time, id`, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []changes.AuditEvent
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e changes.AuditEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Changes returns the config change requests kept in the store
+func (s *Store) Changes() changes.Store {
+	return &changeStore{s}
+}
+
+type changeStore struct{ s *Store }
+
+// Save implements changes.Store
+func (c *changeStore) Save(req changes.Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = c.s.exec(context.Background(),
+		`INSERT INTO change_requests (id, created, status, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, data = excluded.data`,
+		req.ID, req.CreatedAt.UnixNano(), req.Status, string(data))
+	return err
+}
+
+// Get implements changes.Store
+func (c *changeStore) Get(id string) (changes.Request, bool, error) {
+	requests, err := c.list("SELECT data FROM change_requests WHERE id = ?", id)
+	if err != nil || len(requests) == 0 {
+		return changes.Request{}, false, err
+	}
+	return requests[0], true, nil
+}
+
+// List implements changes.Store, returning requests in creation order
+func (c *changeStore) List(status string) ([]changes.Request, error) {
+	if status == "" {
+		return c.list("SELECT data FROM change_requests ORDER BY created")
+	}
+	return c.list("SELECT data FROM change_requests WHERE status = ? ORDER BY created", status)
+}
+
+func (c *changeStore) list(query string, args ...interface{}) ([]changes.Request, error) {
+	rows, err := c.s.query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []changes.Request{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var req changes.Request
+		if err := json.Unmarshal([]byte(data), &req); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// SaveUsage stores the tenant usage metered by one server instance,
+// replacing what it stored before. Each replica meters its own requests, so
+// usage is kept per instance.
+func (s *Store) SaveUsage(ctx context.Context, instance string, usage []tenancy.Usage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.rebind(`INSERT INTO tenant_usage (instance, tenant, requests, rejected, bytes_in, bytes_out, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (instance, tenant) DO UPDATE SET requests = excluded.requests, rejected = excluded.rejected,
+			bytes_in = excluded.bytes_in, bytes_out = excluded.bytes_out, last_seen = excluded.last_seen`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range usage {
+		if _, err := stmt.ExecContext(ctx, instance, u.Tenant, u.Requests, u.Rejected, u.BytesIn, u.BytesOut, u.LastSeen.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadUsage returns the tenant usage stored by one server instance, or by
+// every instance, summed per tenant, when instance is empty
+func (s *Store) LoadUsage(ctx context.Context, instance string) ([]tenancy.Usage, error) {
+	query := `SELECT tenant, SUM(requests), SUM(rejected), SUM(bytes_in), SUM(bytes_out), MAX(last_seen)
+		FROM tenant_usage`
+	var args []interface{}
+	if instance != "" {
+		query += " WHERE instance = ?"
+		args = append(args, instance)
+	}
+	rows, err := s.query(ctx, query+" GROUP BY tenant ORDER BY tenant", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []tenancy.Usage
+	for rows.Next() {
+		var u tenancy.Usage
+		var lastSeen int64
+		if err := rows.Scan(&u.Tenant, &u.Requests, &u.Rejected, &u.BytesIn, &u.BytesOut, &lastSeen); err != nil {
+			return nil, err
+		}
+		u.LastSeen = time.Unix(0, lastSeen).UTC()
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
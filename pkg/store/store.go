@@ -0,0 +1,197 @@
+// Package store keeps the state of the APM server in SQLite or Postgres
+// instead of local files, so several replicas can share it and it survives
+// the loss of a node. It holds the incident timeline, including deploy
+// events, the audit log of chat commands and config changes, config change
+// requests, and tenant usage. The schema is migrated on Open, and Backup
+// writes a consistent copy of the database.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Config selects the database
+type Config struct {
+	// Driver is sqlite or postgres
+	Driver string `mapstructure:"driver" yaml:"driver" json:"driver"`
+
+	// DSN is the SQLite file or the Postgres connection string, e.g.
+	// postgres://apm:secret@db:5432/apm?sslmode=require
+	DSN string `mapstructure:"dsn" yaml:"dsn" json:"-"`
+
+	// BackupDir receives the files written by Backup
+	BackupDir string `mapstructure:"backup_dir" yaml:"backup_dir" json:"backup_dir,omitempty"`
+}
+
+// BackupHook is called with the path of each backup, e.g. to upload it
+type BackupHook func(ctx context.Context, path string) error
+
+// Store is a database holding the server state
+type Store struct {
+	db     *sql.DB
+	config Config
+
+	mu    sync.Mutex
+	hooks []BackupHook
+}
+
+// Open connects to the database and migrates its schema
+func Open(ctx context.Context, config Config) (*Store, error) {
+	var driver, dsn string
+	switch config.Driver {
+	case DriverSQLite:
+		if config.DSN == "" {
+			return nil, fmt.Errorf("sqlite requires a database file")
+		}
+		driver, dsn = "sqlite3", sqliteDSN(config.DSN)
+	case DriverPostgres:
+		driver, dsn = "postgres", config.DSN
+	default:
+		return nil, fmt.Errorf("unsupported storage driver %q: use sqlite or postgres", config.Driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", config.Driver, err)
+	}
+	if config.Driver == DriverSQLite {
+		// SQLite allows one writer; a single connection avoids busy errors
+		db.SetMaxOpenConns(1)
+	}
+	s := &Store{db: db, config: config}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqliteDSN enables write-ahead logging, waiting on locks, and foreign keys
+// unless the DSN sets them
+func sqliteDSN(dsn string) string {
+	params := []string{"_journal_mode=WAL", "_busy_timeout=5000", "_foreign_keys=on"}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	for _, p := range params {
+		if !strings.Contains(dsn, strings.SplitN(p, "=", 2)[0]+"=") {
+			dsn += sep + p
+			sep = "&"
+		}
+	}
+	return dsn
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres
+func (s *Store) rebind(query string) string {
+	if s.config.Driver != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.rebind(query), args...)
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.rebind(query), args...)
+}
+
+// OnBackup adds a hook run after each successful backup
+func (s *Store) OnBackup(hook BackupHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// Backup writes a consistent copy of the database to BackupDir and runs the
+// backup hooks. SQLite is copied with VACUUM INTO while it stays writable;
+// Postgres is dumped with pg_dump, which must be installed.
+func (s *Store) Backup(ctx context.Context) (string, error) {
+	if s.config.BackupDir == "" {
+		return "", fmt.Errorf("no backup directory configured")
+	}
+	if err := os.MkdirAll(s.config.BackupDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var path string
+	switch s.config.Driver {
+	case DriverSQLite:
+		path = filepath.Join(s.config.BackupDir, "apm-"+stamp+".db")
+		if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+			return "", fmt.Errorf("failed to back up sqlite database: %w", err)
+		}
+	case DriverPostgres:
+		path = filepath.Join(s.config.BackupDir, "apm-"+stamp+".dump")
+		out, err := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--file="+path, "--dbname="+s.config.DSN).CombinedOutput()
+		if err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("pg_dump failed: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	s.mu.Lock()
+	hooks := append([]BackupHook(nil), s.hooks...)
+	s.mu.Unlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, path); err != nil {
+			return path, fmt.Errorf("backup hook failed for %s: %w", path, err)
+		}
+	}
+	return path, nil
+}
+
+// CommandHook returns a backup hook that runs command with sh, with the
+// backup path in APM_BACKUP_PATH
+func CommandHook(command string) BackupHook {
+	return func(ctx context.Context, path string) error {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(), "APM_BACKUP_PATH="+path)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
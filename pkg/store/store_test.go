@@ -0,0 +1,239 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/changes"
+	"github.com/chaksack/apm/pkg/chatops"
+	"github.com/chaksack/apm/pkg/incident"
+	"github.com/chaksack/apm/pkg/tenancy"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := Open(context.Background(), Config{Driver: DriverSQLite, DSN: filepath.Join(dir, "apm.db"), BackupDir: filepath.Join(dir, "backups")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMigrations(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{Driver: DriverSQLite, DSN: filepath.Join(dir, "apm.db")}
+	for i := 0; i < 2; i++ {
+		s, err := Open(context.Background(), config)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		version, err := s.SchemaVersion(context.Background())
+		if err != nil || version != len(migrations) {
+			t.Errorf("open %d: version = %d, %v", i, version, err)
+		}
+		s.Close()
+	}
+
+	if _, err := Open(context.Background(), Config{Driver: "mysql"}); err == nil {
+		t.Error("expected an unsupported driver to be rejected")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	s := &Store{config: Config{Driver: DriverPostgres}}
+	if got := s.rebind("SELECT * FROM t WHERE a = ? AND b = ?"); got != "SELECT * FROM t WHERE a = $1 AND b = $2" {
+		t.Errorf("rebind = %q", got)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	timeline := openTestStore(t).Timeline()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []incident.Entry{
+		{Time: start, Type: incident.EntryDeploy, Subject: "checkout", Text: "v2", Data: map[string]interface{}{"version": "v2"}},
+		{Time: start.Add(time.Minute), Incident: "inc-1", Type: incident.EntryAlert, Subject: "HighErrorRate"},
+		{Time: start.Add(2 * time.Minute), Incident: "inc-1", Type: incident.EntrySummary, Text: "errors after deploy"},
+	}
+	for _, e := range entries {
+		if err := timeline.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := timeline.Entries(incident.Filter{Incident: "inc-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Subject != "HighErrorRate" || got[1].Type != incident.EntrySummary {
+		t.Errorf("incident entries = %+v", got)
+	}
+
+	deploys, err := timeline.Entries(incident.Filter{Type: incident.EntryDeploy, Until: start.Add(30 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deploys) != 1 || !deploys[0].Time.Equal(start) || deploys[0].Data["version"] != "v2" {
+		t.Errorf("deploys = %+v", deploys)
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	s := openTestStore(t)
+	event := chatops.AuditEvent{
+		Timestamp: time.Now(),
+		EventType: chatops.EventCommand,
+		Platform:  "slack",
+		UserID:    "U1",
+		Command:   "silence",
+		Roles:     []string{"oncall", "viewer"},
+		Outcome:   chatops.OutcomeSucceeded,
+	}
+	if err := s.Auditor().Record(event); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.AuditEvents(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Command != "silence" || len(events[0].Roles) != 2 || events[0].Outcome != chatops.OutcomeSucceeded {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestChangeAuditor(t *testing.T) {
+	s := openTestStore(t)
+	event := changes.AuditEvent{
+		Timestamp: time.Now(),
+		EventType: changes.EventChange,
+		Action:    changes.ActionApprove,
+		RequestID: "cr-1",
+		Kind:      changes.KindRules,
+		Actor:     "bob",
+		Roles:     []string{"approver"},
+		Outcome:   changes.OutcomeSucceeded,
+	}
+	if err := s.ChangeAuditor().Record(event); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.ChangeAuditEvents(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].RequestID != "cr-1" || events[0].Action != changes.ActionApprove || len(events[0].Roles) != 1 {
+		t.Errorf("events = %+v", events)
+	}
+	if chat, _ := s.AuditEvents(context.Background(), time.Now().Add(-time.Hour)); len(chat) != 0 {
+		t.Errorf("change steps recorded as chat commands: %+v", chat)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	s := openTestStore(t)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	requests := s.Changes()
+	for i, id := range []string{"cr-1", "cr-2"} {
+		req := changes.Request{ID: id, Kind: changes.KindRules, Status: changes.StatusPending, CreatedAt: created.Add(time.Duration(i) * time.Minute)}
+		if err := requests.Save(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, ok, err := requests.Get("cr-1")
+	if err != nil || !ok || !req.CreatedAt.Equal(created) {
+		t.Fatalf("get = %+v, %v, %v", req, ok, err)
+	}
+	req.Status, req.Reviewer = changes.StatusApproved, "alice"
+	if err := requests.Save(req); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := requests.List(changes.StatusPending)
+	if err != nil || len(pending) != 1 || pending[0].ID != "cr-2" {
+		t.Errorf("pending = %+v, %v", pending, err)
+	}
+	all, err := requests.List("")
+	if err != nil || len(all) != 2 || all[0].Reviewer != "alice" {
+		t.Errorf("all = %+v, %v", all, err)
+	}
+	if _, ok, err := requests.Get("cr-3"); ok || err != nil {
+		t.Errorf("missing request = %v, %v", ok, err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := s.SaveUsage(ctx, "replica-0", []tenancy.Usage{{Tenant: "acme", Requests: 5, BytesIn: 100, LastSeen: seen}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveUsage(ctx, "replica-0", []tenancy.Usage{{Tenant: "acme", Requests: 7, BytesIn: 150, LastSeen: seen}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveUsage(ctx, "replica-1", []tenancy.Usage{{Tenant: "acme", Requests: 3, Rejected: 1, LastSeen: seen.Add(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	own, err := s.LoadUsage(ctx, "replica-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(own) != 1 || own[0].Requests != 7 || own[0].BytesIn != 150 {
+		t.Errorf("replica-0 usage = %+v", own)
+	}
+	total, err := s.LoadUsage(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(total) != 1 || total[0].Requests != 10 || total[0].Rejected != 1 || !total[0].LastSeen.Equal(seen.Add(time.Hour)) {
+		t.Errorf("total usage = %+v", total)
+	}
+
+	meter := tenancy.NewMeter(nil)
+	meter.Record("acme", 10, 20)
+	meter.Restore(own)
+	if u, _ := meter.Usage("acme"); u.Requests != 8 || u.BytesIn != 160 {
+		t.Errorf("restored usage = %+v", u)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.Timeline().Append(incident.Entry{Time: time.Now(), Type: incident.EntryDeploy, Subject: "api"}); err != nil {
+		t.Fatal(err)
+	}
+	var hooked string
+	s.OnBackup(func(_ context.Context, path string) error {
+		hooked = path
+		return nil
+	})
+	marker := filepath.Join(t.TempDir(), "uploaded")
+	s.OnBackup(CommandHook(`cp "$APM_BACKUP_PATH" ` + marker))
+
+	path, err := s.Backup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooked != path {
+		t.Errorf("hook got %q, want %q", hooked, path)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("command hook did not run: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM timeline_entries").Scan(&n); err != nil || n != 1 {
+		t.Errorf("backup holds %d entries, %v", n, err)
+	}
+}
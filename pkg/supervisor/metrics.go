@@ -0,0 +1,85 @@
+package supervisor
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the Prometheus metrics of supervised processes. A nil
+// *Metrics records nothing.
+type Metrics struct {
+	restarts  *prometheus.CounterVec
+	exits     *prometheus.CounterVec
+	up        *prometheus.GaugeVec
+	crashLoop *prometheus.GaugeVec
+}
+
+// NewMetrics creates the metrics, registering them with reg when non-nil
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		restarts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "apm_supervisor_restarts_total",
+				Help: "Total number of process restarts by reason (exit or requested)",
+			},
+			[]string{"process", "reason"},
+		),
+		exits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "apm_supervisor_exits_total",
+				Help: "Total number of process exits by exit code",
+			},
+			[]string{"process", "code"},
+		),
+		up: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "apm_supervisor_process_up",
+				Help: "Whether the supervised process is running",
+			},
+			[]string{"process"},
+		),
+		crashLoop: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "apm_supervisor_crash_loop",
+				Help: "Whether the supervised process is in a crash loop",
+			},
+			[]string{"process"},
+		),
+	}
+	if reg != nil {
+		reg.MustRegister(m.restarts, m.exits, m.up, m.crashLoop)
+	}
+	return m
+}
+
+func (m *Metrics) restarted(process, reason string) {
+	if m != nil {
+		m.restarts.WithLabelValues(process, reason).Inc()
+	}
+}
+
+func (m *Metrics) exited(process string, code int) {
+	if m != nil {
+		m.exits.WithLabelValues(process, strconv.Itoa(code)).Inc()
+	}
+}
+
+func (m *Metrics) setUp(process string, up bool) {
+	if m != nil {
+		m.up.WithLabelValues(process).Set(boolValue(up))
+	}
+}
+
+func (m *Metrics) setCrashLoop(process string, looping bool) {
+	if m != nil {
+		m.crashLoop.WithLabelValues(process).Set(boolValue(looping))
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
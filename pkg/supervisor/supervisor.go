@@ -0,0 +1,349 @@
+// Package supervisor keeps a process running under a restart policy. It
+// restarts the process with exponential backoff when it exits, detects crash
+// loops, gives up after a maximum number of restarts, and reports every
+// lifecycle change as an Event and as Prometheus metrics. `apm run` uses it
+// for the application.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// RestartPolicy decides whether an exited process is started again
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartAlways    RestartPolicy = "always"
+	RestartOnFailure RestartPolicy = "on-failure" // only after a non-zero exit
+)
+
+// ErrMaxRestarts is returned by Run when the process keeps failing after
+// Policy.MaxRestarts restarts
+var ErrMaxRestarts = errors.New("maximum restarts reached")
+
+// Policy configures when and how quickly a process is restarted
+type Policy struct {
+	Restart RestartPolicy `mapstructure:"restart" yaml:"restart" json:"restart"`
+
+	// MaxRestarts bounds the restarts since the process last ran stably;
+	// 0 restarts without limit
+	MaxRestarts int `mapstructure:"max_restarts" yaml:"max_restarts" json:"max_restarts"`
+
+	// The delay before a restart starts at InitialBackoff and doubles with
+	// each consecutive failure up to MaxBackoff
+	InitialBackoff time.Duration `mapstructure:"initial_backoff" yaml:"initial_backoff" json:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff" yaml:"max_backoff" json:"max_backoff"`
+
+	// A run lasting StableAfter resets the backoff and restart count
+	StableAfter time.Duration `mapstructure:"stable_after" yaml:"stable_after" json:"stable_after"`
+
+	// CrashLoopRestarts restarts within CrashLoopWindow mark a crash loop,
+	// in which restarts wait MaxBackoff until the process runs stably again
+	CrashLoopRestarts int           `mapstructure:"crash_loop_restarts" yaml:"crash_loop_restarts" json:"crash_loop_restarts"`
+	CrashLoopWindow   time.Duration `mapstructure:"crash_loop_window" yaml:"crash_loop_window" json:"crash_loop_window"`
+
+	// StopTimeout is how long a stopping process has after SIGTERM before
+	// it is killed
+	StopTimeout time.Duration `mapstructure:"stop_timeout" yaml:"stop_timeout" json:"stop_timeout"`
+}
+
+// DefaultPolicy restarts on failure, backing off from 1s to 30s
+func DefaultPolicy() Policy {
+	return Policy{
+		Restart:           RestartOnFailure,
+		MaxRestarts:       10,
+		InitialBackoff:    time.Second,
+		MaxBackoff:        30 * time.Second,
+		StableAfter:       30 * time.Second,
+		CrashLoopRestarts: 5,
+		CrashLoopWindow:   time.Minute,
+		StopTimeout:       5 * time.Second,
+	}
+}
+
+// Validate checks the policy and fills zero values from DefaultPolicy
+func (p *Policy) Validate() error {
+	def := DefaultPolicy()
+	switch p.Restart {
+	case "":
+		p.Restart = def.Restart
+	case RestartNever, RestartAlways, RestartOnFailure:
+	default:
+		return fmt.Errorf("restart policy must be never, always, or on-failure, got %q", p.Restart)
+	}
+	if p.MaxRestarts < 0 {
+		return fmt.Errorf("max restarts must not be negative")
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = def.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = def.MaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		return fmt.Errorf("max backoff %s is below initial backoff %s", p.MaxBackoff, p.InitialBackoff)
+	}
+	if p.StableAfter <= 0 {
+		p.StableAfter = def.StableAfter
+	}
+	if p.CrashLoopRestarts <= 0 {
+		p.CrashLoopRestarts = def.CrashLoopRestarts
+	}
+	if p.CrashLoopWindow <= 0 {
+		p.CrashLoopWindow = def.CrashLoopWindow
+	}
+	if p.StopTimeout <= 0 {
+		p.StopTimeout = def.StopTimeout
+	}
+	return nil
+}
+
+// shouldRestart applies the policy to an exit
+func (p Policy) shouldRestart(exitErr error) bool {
+	switch p.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// EventType is the kind of lifecycle event
+type EventType string
+
+const (
+	EventStarted    EventType = "started"
+	EventExited     EventType = "exited"
+	EventRestarting EventType = "restarting"
+	EventCrashLoop  EventType = "crash_loop"
+	EventGaveUp     EventType = "gave_up"
+	EventStopped    EventType = "stopped"
+)
+
+// Event is a change in the supervised process's lifecycle
+type Event struct {
+	Process string    `json:"process"`
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	PID     int       `json:"pid,omitempty"`
+
+	// ExitCode and Err describe an exit; ExitCode is -1 when the process
+	// could not start or was killed by a signal
+	ExitCode int           `json:"exit_code,omitempty"`
+	Err      error         `json:"-"`
+	Uptime   time.Duration `json:"uptime,omitempty"`
+
+	// Restarts counts restarts since the process last ran stably, and
+	// Backoff is the wait before the next one
+	Restarts int           `json:"restarts,omitempty"`
+	Backoff  time.Duration `json:"backoff,omitempty"`
+
+	// Reason says why a restart happens: "exit" or "requested"
+	Reason string `json:"reason,omitempty"`
+}
+
+// Supervisor runs one process under a policy
+type Supervisor struct {
+	// Name identifies the process in events and metrics
+	Name string
+	// Command returns a new, unstarted command for each run
+	Command func() *exec.Cmd
+	Policy  Policy
+
+	// OnEvent is called synchronously for each event
+	OnEvent func(Event)
+	// Metrics, when set, records restarts, exits, and state
+	Metrics *Metrics
+	// Signal delivers a stop signal to the process; the default signals the
+	// process itself, so set it to reach a process group
+	Signal func(cmd *exec.Cmd, sig os.Signal) error
+
+	restart chan struct{}
+}
+
+// New creates a supervisor, validating the policy
+func New(name string, command func() *exec.Cmd, policy Policy) (*Supervisor, error) {
+	if command == nil {
+		return nil, fmt.Errorf("supervisor %s: command is required", name)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("supervisor %s: %w", name, err)
+	}
+	return &Supervisor{
+		Name:    name,
+		Command: command,
+		Policy:  policy,
+		restart: make(chan struct{}, 1),
+	}, nil
+}
+
+// Restart stops the running process and starts it again at once, without
+// counting as a failure, e.g. after a code change. It does not block.
+func (s *Supervisor) Restart() {
+	select {
+	case s.restart <- struct{}{}:
+	default:
+		// A restart is already pending
+	}
+}
+
+// Run starts the process and supervises it until ctx is done, which stops
+// the process and returns nil. It also returns when the policy lets the
+// process stay down: nil after a clean exit, the exit error otherwise, or an
+// error wrapping ErrMaxRestarts when the restarts ran out.
+func (s *Supervisor) Run(ctx context.Context) error {
+	p := s.Policy
+	restarts := 0
+	backoff := p.InitialBackoff
+	looping := false
+	var recent []time.Time
+
+	// Drop a restart requested while nothing was running
+	select {
+	case <-s.restart:
+	default:
+	}
+
+	for {
+		cmd := s.Command()
+		started := time.Now()
+		done := make(chan error, 1)
+		if err := cmd.Start(); err != nil {
+			done <- fmt.Errorf("failed to start: %w", err)
+		} else {
+			s.emit(Event{Type: EventStarted, PID: cmd.Process.Pid, Restarts: restarts})
+			s.Metrics.setUp(s.Name, true)
+			go func() { done <- cmd.Wait() }()
+		}
+
+		var exitErr error
+		select {
+		case exitErr = <-done:
+		case <-ctx.Done():
+			s.stop(cmd, done)
+			s.emit(Event{Type: EventStopped, Uptime: time.Since(started)})
+			s.Metrics.setUp(s.Name, false)
+			return nil
+		case <-s.restart:
+			s.stop(cmd, done)
+			s.Metrics.setUp(s.Name, false)
+			s.emit(Event{Type: EventRestarting, Reason: "requested", Uptime: time.Since(started)})
+			s.Metrics.restarted(s.Name, "requested")
+			restarts, backoff, looping, recent = 0, p.InitialBackoff, false, nil
+			s.Metrics.setCrashLoop(s.Name, false)
+			continue
+		}
+
+		uptime := time.Since(started)
+		code := exitCode(exitErr)
+		s.Metrics.setUp(s.Name, false)
+		s.Metrics.exited(s.Name, code)
+		s.emit(Event{Type: EventExited, ExitCode: code, Err: exitErr, Uptime: uptime, Restarts: restarts})
+
+		if uptime >= p.StableAfter {
+			restarts, backoff, looping, recent = 0, p.InitialBackoff, false, nil
+			s.Metrics.setCrashLoop(s.Name, false)
+		}
+		if !p.shouldRestart(exitErr) {
+			return exitErr
+		}
+		if p.MaxRestarts > 0 && restarts >= p.MaxRestarts {
+			s.emit(Event{Type: EventGaveUp, ExitCode: code, Err: exitErr, Restarts: restarts})
+			if exitErr == nil {
+				return fmt.Errorf("%s: %w (%d)", s.Name, ErrMaxRestarts, restarts)
+			}
+			return fmt.Errorf("%s: %w (%d): %v", s.Name, ErrMaxRestarts, restarts, exitErr)
+		}
+
+		now := time.Now()
+		recent = append(recent, now)
+		for len(recent) > 0 && now.Sub(recent[0]) > p.CrashLoopWindow {
+			recent = recent[1:]
+		}
+		if !looping && len(recent) >= p.CrashLoopRestarts {
+			looping = true
+			s.Metrics.setCrashLoop(s.Name, true)
+			s.emit(Event{Type: EventCrashLoop, ExitCode: code, Err: exitErr, Restarts: restarts + 1, Backoff: p.MaxBackoff})
+		}
+
+		wait := backoff
+		if looping {
+			wait = p.MaxBackoff
+		}
+		restarts++
+		s.emit(Event{Type: EventRestarting, Reason: "exit", ExitCode: code, Restarts: restarts, Backoff: wait})
+		s.Metrics.restarted(s.Name, "exit")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			s.emit(Event{Type: EventStopped})
+			return nil
+		case <-s.restart:
+			timer.Stop()
+			restarts, backoff, looping, recent = 0, p.InitialBackoff, false, nil
+			s.Metrics.setCrashLoop(s.Name, false)
+			continue
+		}
+		if backoff *= 2; backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// stop sends SIGTERM and kills the process if it has not exited within
+// the stop timeout
+func (s *Supervisor) stop(cmd *exec.Cmd, done <-chan error) {
+	if cmd.Process == nil {
+		<-done
+		return
+	}
+	signal := s.Signal
+	if signal == nil {
+		signal = func(cmd *exec.Cmd, sig os.Signal) error { return cmd.Process.Signal(sig) }
+	}
+	if err := signal(cmd, syscall.SIGTERM); err != nil {
+		signal(cmd, os.Kill)
+	}
+
+	timer := time.NewTimer(s.Policy.StopTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		signal(cmd, os.Kill)
+		<-done
+	}
+}
+
+func (s *Supervisor) emit(e Event) {
+	if s.OnEvent == nil {
+		return
+	}
+	e.Process = s.Name
+	e.Time = time.Now()
+	s.OnEvent(e)
+}
+
+// exitCode returns the process exit code, or -1 when it has none
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
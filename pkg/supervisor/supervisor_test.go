@@ -0,0 +1,274 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recorder collects events from a supervisor
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) record(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) count(t EventType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *recorder) backoffs() []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []time.Duration
+	for _, e := range r.events {
+		if e.Type == EventRestarting && e.Reason == "exit" {
+			out = append(out, e.Backoff)
+		}
+	}
+	return out
+}
+
+func shell(script string) func() *exec.Cmd {
+	return func() *exec.Cmd { return exec.Command("sh", "-c", script) }
+}
+
+func newTestSupervisor(t *testing.T, script string, policy Policy) (*Supervisor, *recorder) {
+	t.Helper()
+	if policy.InitialBackoff == 0 {
+		policy.InitialBackoff = 5 * time.Millisecond
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = 40 * time.Millisecond
+	}
+	if policy.StopTimeout == 0 {
+		policy.StopTimeout = time.Second
+	}
+	s, err := New("app", shell(script), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	s.OnEvent = rec.record
+	return s, rec
+}
+
+func TestPolicyValidate(t *testing.T) {
+	p := Policy{}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// MaxRestarts stays 0, which means no limit
+	want := DefaultPolicy()
+	want.MaxRestarts = 0
+	if p != want {
+		t.Errorf("zero policy = %+v, want defaults", p)
+	}
+
+	for _, bad := range []Policy{
+		{Restart: "sometimes"},
+		{MaxRestarts: -1},
+		{InitialBackoff: time.Minute, MaxBackoff: time.Second},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%+v validated", bad)
+		}
+	}
+}
+
+func TestOnFailureGivesUpWithBackoff(t *testing.T) {
+	s, rec := newTestSupervisor(t, "exit 3", Policy{Restart: RestartOnFailure, MaxRestarts: 4, CrashLoopRestarts: 100})
+	reg := prometheus.NewRegistry()
+	s.Metrics = NewMetrics(reg)
+
+	err := s.Run(context.Background())
+	if !errors.Is(err, ErrMaxRestarts) || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("err = %v, want max restarts with the exit status", err)
+	}
+	if n := rec.count(EventStarted); n != 5 {
+		t.Errorf("started %d times, want 5", n)
+	}
+	if n := rec.count(EventGaveUp); n != 1 {
+		t.Errorf("gave up %d times, want 1", n)
+	}
+	want := []time.Duration{5, 10, 20, 40}
+	got := rec.backoffs()
+	if len(got) != len(want) {
+		t.Fatalf("backoffs = %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i]*time.Millisecond {
+			t.Errorf("backoffs = %v, want doubling up to the max", got)
+			break
+		}
+	}
+
+	if v := testutil.ToFloat64(s.Metrics.restarts.WithLabelValues("app", "exit")); v != 4 {
+		t.Errorf("restarts_total = %v, want 4", v)
+	}
+	if v := testutil.ToFloat64(s.Metrics.exits.WithLabelValues("app", "3")); v != 5 {
+		t.Errorf("exits_total{code=3} = %v, want 5", v)
+	}
+	if v := testutil.ToFloat64(s.Metrics.up.WithLabelValues("app")); v != 0 {
+		t.Errorf("process_up = %v, want 0", v)
+	}
+}
+
+func TestOnFailureCleanExit(t *testing.T) {
+	s, rec := newTestSupervisor(t, "exit 0", Policy{Restart: RestartOnFailure})
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if n := rec.count(EventStarted); n != 1 {
+		t.Errorf("started %d times, want 1", n)
+	}
+}
+
+func TestNeverReturnsExitError(t *testing.T) {
+	s, rec := newTestSupervisor(t, "exit 2", Policy{Restart: RestartNever})
+	err := s.Run(context.Background())
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+		t.Fatalf("err = %v, want exit status 2", err)
+	}
+	if n := rec.count(EventRestarting); n != 0 {
+		t.Errorf("restarted %d times", n)
+	}
+}
+
+func TestAlwaysRestartsCleanExit(t *testing.T) {
+	s, rec := newTestSupervisor(t, "exit 0", Policy{Restart: RestartAlways, MaxRestarts: 2})
+	if err := s.Run(context.Background()); !errors.Is(err, ErrMaxRestarts) {
+		t.Fatalf("err = %v, want max restarts", err)
+	}
+	if n := rec.count(EventStarted); n != 3 {
+		t.Errorf("started %d times, want 3", n)
+	}
+}
+
+func TestCrashLoopUsesMaxBackoff(t *testing.T) {
+	s, rec := newTestSupervisor(t, "exit 1", Policy{
+		Restart:           RestartOnFailure,
+		MaxRestarts:       5,
+		InitialBackoff:    time.Millisecond,
+		MaxBackoff:        30 * time.Millisecond,
+		CrashLoopRestarts: 2,
+	})
+	s.Metrics = NewMetrics(nil)
+
+	if err := s.Run(context.Background()); !errors.Is(err, ErrMaxRestarts) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := rec.count(EventCrashLoop); n != 1 {
+		t.Fatalf("crash loop reported %d times, want once", n)
+	}
+	got := rec.backoffs()
+	if got[0] != time.Millisecond {
+		t.Errorf("first backoff = %s, want the initial backoff", got[0])
+	}
+	for _, b := range got[1:] {
+		if b != 30*time.Millisecond {
+			t.Errorf("backoffs = %v, want the max backoff once crash looping", got)
+			break
+		}
+	}
+	if v := testutil.ToFloat64(s.Metrics.crashLoop.WithLabelValues("app")); v != 1 {
+		t.Errorf("crash_loop = %v, want 1", v)
+	}
+}
+
+func TestStableRunResetsRestarts(t *testing.T) {
+	// Runs long enough to count as stable, so the limit is never reached
+	s, rec := newTestSupervisor(t, "sleep 0.05; exit 1", Policy{
+		Restart:     RestartOnFailure,
+		MaxRestarts: 1,
+		StableAfter: 20 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.count(EventStarted) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("err = %v, want nil after cancel", err)
+	}
+	if n := rec.count(EventStarted); n < 4 {
+		t.Errorf("started %d times, want restarts to continue past the limit", n)
+	}
+	if n := rec.count(EventGaveUp); n != 0 {
+		t.Errorf("gave up %d times", n)
+	}
+}
+
+func TestCancelStopsProcess(t *testing.T) {
+	s, rec := newTestSupervisor(t, "sleep 60", Policy{Restart: RestartAlways})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	for rec.count(EventStarted) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if n := rec.count(EventStopped); n != 1 {
+		t.Errorf("stopped %d times, want 1", n)
+	}
+}
+
+func TestRequestedRestart(t *testing.T) {
+	s, rec := newTestSupervisor(t, "sleep 60", Policy{Restart: RestartOnFailure, MaxRestarts: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	// Requested restarts do not count towards MaxRestarts
+	for i := 1; i <= 3; i++ {
+		for rec.count(EventStarted) < i {
+			time.Sleep(5 * time.Millisecond)
+		}
+		s.Restart()
+	}
+	for rec.count(EventStarted) < 4 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if n := rec.count(EventGaveUp); n != 0 {
+		t.Errorf("gave up after requested restarts")
+	}
+}
@@ -0,0 +1,268 @@
+package tenancy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/progress"
+	"gopkg.in/yaml.v3"
+)
+
+// Endpoints are the shared backend URLs behind each tenant's datasources
+type Endpoints struct {
+	Loki  string `mapstructure:"loki" yaml:"loki" json:"loki,omitempty"`
+	Mimir string `mapstructure:"mimir" yaml:"mimir" json:"mimir,omitempty"` // Prometheus API base, e.g. http://mimir:8080/prometheus
+	Tempo string `mapstructure:"tempo" yaml:"tempo" json:"tempo,omitempty"`
+}
+
+// Datasource is a Grafana datasource scoped to one tenant
+type Datasource struct {
+	OrgID          int                    `yaml:"orgId,omitempty" json:"-"`
+	Name           string                 `yaml:"name" json:"name"`
+	UID            string                 `yaml:"uid" json:"uid"`
+	Type           string                 `yaml:"type" json:"type"`
+	Access         string                 `yaml:"access" json:"access"`
+	URL            string                 `yaml:"url" json:"url"`
+	IsDefault      bool                   `yaml:"isDefault,omitempty" json:"isDefault,omitempty"`
+	JSONData       map[string]interface{} `yaml:"jsonData" json:"jsonData"`
+	SecureJSONData map[string]string      `yaml:"secureJsonData" json:"secureJsonData"`
+	Editable       bool                   `yaml:"editable" json:"editable"`
+}
+
+// Datasources returns the Loki, Mimir, and Tempo datasources for a tenant.
+// Each sends the tenant header so a Grafana organization only sees its own data.
+func (c Config) Datasources(tenant Tenant, endpoints Endpoints, orgID int) []Datasource {
+	header := c.HeaderName()
+	scoped := func(name, uid, typ, endpoint string, jsonData map[string]interface{}) Datasource {
+		if jsonData == nil {
+			jsonData = make(map[string]interface{})
+		}
+		jsonData["httpHeaderName1"] = header
+		return Datasource{
+			OrgID:          orgID,
+			Name:           name,
+			UID:            uid + "-" + tenant.ID,
+			Type:           typ,
+			Access:         "proxy",
+			URL:            endpoint,
+			JSONData:       jsonData,
+			SecureJSONData: map[string]string{"httpHeaderValue1": tenant.ID},
+		}
+	}
+
+	var datasources []Datasource
+	if endpoints.Mimir != "" {
+		ds := scoped("Mimir", "mimir", "prometheus", endpoints.Mimir, map[string]interface{}{"httpMethod": "POST"})
+		ds.IsDefault = true
+		datasources = append(datasources, ds)
+	}
+	if endpoints.Loki != "" {
+		var jsonData map[string]interface{}
+		if endpoints.Tempo != "" {
+			jsonData = map[string]interface{}{
+				"derivedFields": []map[string]interface{}{{
+					"datasourceUid": "tempo-" + tenant.ID,
+					"matcherRegex":  `"trace_id":"(\w+)"`,
+					"name":          "TraceID",
+					"url":           "${__value.raw}",
+				}},
+			}
+		}
+		datasources = append(datasources, scoped("Loki", "loki", "loki", endpoints.Loki, jsonData))
+	}
+	if endpoints.Tempo != "" {
+		var jsonData map[string]interface{}
+		if endpoints.Loki != "" {
+			jsonData = map[string]interface{}{
+				"tracesToLogsV2": map[string]interface{}{
+					"datasourceUid":   "loki-" + tenant.ID,
+					"filterByTraceID": true,
+				},
+			}
+		}
+		datasources = append(datasources, scoped("Tempo", "tempo", "tempo", endpoints.Tempo, jsonData))
+	}
+	return datasources
+}
+
+// ProvisioningFile renders datasources as a Grafana provisioning file.
+// Organizations must exist before Grafana loads the file; see GrafanaProvisioner.
+func ProvisioningFile(datasources []Datasource) ([]byte, error) {
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion":  1,
+		"datasources": datasources,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Grafana expands ${...} in provisioning files, so escape Grafana variables
+	return bytes.ReplaceAll(data, []byte("${__"), []byte("$${__")), nil
+}
+
+// GrafanaProvisioner creates an organization and datasources per tenant
+// through the Grafana HTTP API. Organization management needs a server
+// admin, so basic auth is used.
+type GrafanaProvisioner struct {
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+// Provision ensures every configured tenant has an organization named after
+// it and tenant-scoped datasources, returning the organization IDs. With a
+// progress tracker in ctx, organizations and datasources created by this run
+// are deleted again if provisioning is canceled.
+func (p *GrafanaProvisioner) Provision(ctx context.Context, cfg Config, endpoints Endpoints) (map[string]int, error) {
+	tracker := progress.FromContext(ctx)
+	tracker.AddTotal(len(cfg.Tenants))
+
+	orgs := make(map[string]int, len(cfg.Tenants))
+	for _, tenant := range cfg.Tenants {
+		if err := ctx.Err(); err != nil {
+			return orgs, err
+		}
+
+		name := tenant.Name
+		if name == "" {
+			name = tenant.ID
+		}
+		tracker.Step(tenant.ID)
+
+		orgID, created, err := p.ensureOrg(ctx, name)
+		if err != nil {
+			return orgs, fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+		orgs[tenant.ID] = orgID
+		if created {
+			// Deleting the organization also deletes its datasources
+			tracker.OnCancel("grafana org "+name, func(ctx context.Context) error {
+				_, err := p.do(ctx, http.MethodDelete, "/api/orgs/"+strconv.Itoa(orgID), 0, nil, nil)
+				return err
+			})
+		}
+
+		for _, ds := range cfg.Datasources(tenant, endpoints, orgID) {
+			dsCreated, err := p.ensureDatasource(ctx, ds)
+			if err != nil {
+				return orgs, fmt.Errorf("tenant %s: %w", tenant.ID, err)
+			}
+			if dsCreated && !created {
+				uid, dsOrg := ds.UID, ds.OrgID
+				tracker.OnCancel("grafana datasource "+uid, func(ctx context.Context) error {
+					_, err := p.do(ctx, http.MethodDelete, "/api/datasources/uid/"+url.PathEscape(uid), dsOrg, nil, nil)
+					return err
+				})
+			}
+		}
+		tracker.Advance(1)
+	}
+	return orgs, nil
+}
+
+// EnsureOrg returns the ID of the named organization, creating it if needed
+func (p *GrafanaProvisioner) EnsureOrg(ctx context.Context, name string) (int, error) {
+	id, _, err := p.ensureOrg(ctx, name)
+	return id, err
+}
+
+// ensureOrg is EnsureOrg, also reporting whether the organization was created
+func (p *GrafanaProvisioner) ensureOrg(ctx context.Context, name string) (int, bool, error) {
+	var org struct {
+		ID int `json:"id"`
+	}
+	status, err := p.do(ctx, http.MethodGet, "/api/orgs/name/"+url.PathEscape(name), 0, nil, &org)
+	if err != nil && status != http.StatusNotFound {
+		return 0, false, err
+	}
+	if status == http.StatusOK {
+		return org.ID, false, nil
+	}
+
+	var created struct {
+		OrgID int `json:"orgId"`
+	}
+	if _, err := p.do(ctx, http.MethodPost, "/api/orgs", 0, map[string]string{"name": name}, &created); err != nil {
+		return 0, false, fmt.Errorf("failed to create organization %s: %w", name, err)
+	}
+	return created.OrgID, true, nil
+}
+
+// EnsureDatasource creates a datasource in its organization, updating it if
+// a datasource with the same UID exists
+func (p *GrafanaProvisioner) EnsureDatasource(ctx context.Context, ds Datasource) error {
+	_, err := p.ensureDatasource(ctx, ds)
+	return err
+}
+
+// ensureDatasource is EnsureDatasource, also reporting whether the
+// datasource was created
+func (p *GrafanaProvisioner) ensureDatasource(ctx context.Context, ds Datasource) (bool, error) {
+	status, err := p.do(ctx, http.MethodGet, "/api/datasources/uid/"+url.PathEscape(ds.UID), ds.OrgID, nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		return false, err
+	}
+
+	exists := status == http.StatusOK
+	if exists {
+		_, err = p.do(ctx, http.MethodPut, "/api/datasources/uid/"+url.PathEscape(ds.UID), ds.OrgID, ds, nil)
+	} else {
+		_, err = p.do(ctx, http.MethodPost, "/api/datasources", ds.OrgID, ds, nil)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to provision datasource %s: %w", ds.UID, err)
+	}
+	return !exists, nil
+}
+
+// do sends a Grafana API request, scoped to orgID when non-zero
+func (p *GrafanaProvisioner) do(ctx context.Context, method, path string, orgID int, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(p.URL, "/")+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth(p.Username, p.Password)
+	req.Header.Set("Content-Type", "application/json")
+	if orgID > 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.Itoa(orgID))
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("grafana %s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("invalid grafana response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
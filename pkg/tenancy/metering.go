@@ -0,0 +1,189 @@
+package tenancy
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LocalsKey is the fiber.Ctx locals key holding the resolved tenant ID
+const LocalsKey = "tenant"
+
+// Usage is the metered usage of one tenant
+type Usage struct {
+	Tenant   string    `json:"tenant"`
+	Requests int64     `json:"requests"`
+	Rejected int64     `json:"rejected"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Meter records per-tenant usage in memory and as Prometheus metrics
+type Meter struct {
+	mu    sync.RWMutex
+	usage map[string]*Usage
+
+	requests *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+}
+
+// NewMeter creates a meter, registering its metrics with reg when non-nil
+func NewMeter(reg prometheus.Registerer) *Meter {
+	m := &Meter{
+		usage: make(map[string]*Usage),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "apm_tenant_requests_total",
+				Help: "Total number of requests per tenant",
+			},
+			[]string{"tenant", "outcome"},
+		),
+		bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "apm_tenant_bytes_total",
+				Help: "Total request and response bytes per tenant",
+			},
+			[]string{"tenant", "direction"},
+		),
+	}
+	if reg != nil {
+		reg.MustRegister(m.requests, m.bytes)
+	}
+	return m
+}
+
+// Record adds a served request to the tenant's usage
+func (m *Meter) Record(tenant string, bytesIn, bytesOut int) {
+	m.mu.Lock()
+	u := m.entry(tenant)
+	u.Requests++
+	u.BytesIn += int64(bytesIn)
+	u.BytesOut += int64(bytesOut)
+	m.mu.Unlock()
+
+	m.requests.WithLabelValues(tenant, "served").Inc()
+	m.bytes.WithLabelValues(tenant, "in").Add(float64(bytesIn))
+	m.bytes.WithLabelValues(tenant, "out").Add(float64(bytesOut))
+}
+
+// Reject adds a rejected request to the tenant's usage
+func (m *Meter) Reject(tenant string) {
+	m.mu.Lock()
+	m.entry(tenant).Rejected++
+	m.mu.Unlock()
+
+	m.requests.WithLabelValues(tenant, "rejected").Inc()
+}
+
+// entry returns the usage record for a tenant; callers hold the lock
+func (m *Meter) entry(tenant string) *Usage {
+	u, ok := m.usage[tenant]
+	if !ok {
+		u = &Usage{Tenant: tenant}
+		m.usage[tenant] = u
+	}
+	u.LastSeen = time.Now()
+	return u
+}
+
+// Restore adds previously saved usage, such as that of the last run, to the
+// meter. The Prometheus counters are not changed; they restart from zero.
+func (m *Meter) Restore(usage []Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, saved := range usage {
+		u, ok := m.usage[saved.Tenant]
+		if !ok {
+			u = &Usage{Tenant: saved.Tenant}
+			m.usage[saved.Tenant] = u
+		}
+		u.Requests += saved.Requests
+		u.Rejected += saved.Rejected
+		u.BytesIn += saved.BytesIn
+		u.BytesOut += saved.BytesOut
+		if saved.LastSeen.After(u.LastSeen) {
+			u.LastSeen = saved.LastSeen
+		}
+	}
+}
+
+// Usage returns the usage of one tenant
+func (m *Meter) Usage(tenant string) (Usage, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.usage[tenant]
+	if !ok {
+		return Usage{}, false
+	}
+	return *u, true
+}
+
+// Snapshot returns the usage of every tenant ordered by tenant ID
+func (m *Meter) Snapshot() []Usage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot := make([]Usage, 0, len(m.usage))
+	for _, u := range m.usage {
+		snapshot = append(snapshot, *u)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Tenant < snapshot[j].Tenant })
+	return snapshot
+}
+
+// Middleware resolves the tenant of each request, rejects unknown tenants,
+// and meters usage. The tenant is stored in the fiber locals under LocalsKey
+// and in the user context for outgoing backend requests.
+func Middleware(cfg Config, meter *Meter) fiber.Handler {
+	header := cfg.HeaderName()
+	exempt := make(map[string]bool, len(cfg.ExemptPaths))
+	for _, path := range cfg.ExemptPaths {
+		exempt[path] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		if exempt[c.Path()] {
+			return c.Next()
+		}
+
+		// Fiber reuses header buffers, so copy the value before retaining it
+		tenant := utils.CopyString(c.Get(header))
+		if tenant == "" {
+			tenant = cfg.DefaultTenant
+		}
+
+		if tenant == "" {
+			if cfg.Required {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "missing tenant header " + header,
+				})
+			}
+			return c.Next()
+		}
+
+		if !cfg.Allowed(tenant) {
+			if meter != nil {
+				meter.Reject(tenant)
+			}
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "unknown tenant",
+			})
+		}
+
+		c.Locals(LocalsKey, tenant)
+		c.SetUserContext(WithTenant(c.UserContext(), tenant))
+
+		err := c.Next()
+
+		if meter != nil {
+			meter.Record(tenant, len(c.Request().Body()), len(c.Response().Body()))
+		}
+		return err
+	}
+}
@@ -0,0 +1,113 @@
+package tenancy
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/chaksack/apm/pkg/retention"
+)
+
+const megabyte = 1 << 20
+
+// LokiOverrides renders the Loki runtime configuration with per-tenant limits
+func (c Config) LokiOverrides() ([]byte, error) {
+	return c.renderOverrides(func(l Limits) (map[string]interface{}, error) {
+		o := make(map[string]interface{})
+		if l.IngestionRateMB > 0 {
+			o["ingestion_rate_mb"] = l.IngestionRateMB
+		}
+		if l.IngestionBurstMB > 0 {
+			o["ingestion_burst_size_mb"] = l.IngestionBurstMB
+		}
+		if l.MaxStreams > 0 {
+			o["max_global_streams_per_user"] = l.MaxStreams
+		}
+		if l.Retention != "" {
+			period, err := retentionHours(l.Retention)
+			if err != nil {
+				return nil, err
+			}
+			o["retention_period"] = period
+		}
+		return o, nil
+	})
+}
+
+// MimirOverrides renders the Mimir runtime configuration with per-tenant limits
+func (c Config) MimirOverrides() ([]byte, error) {
+	return c.renderOverrides(func(l Limits) (map[string]interface{}, error) {
+		o := make(map[string]interface{})
+		if l.SamplesPerSecond > 0 {
+			o["ingestion_rate"] = l.SamplesPerSecond
+			// Allow ten seconds of burst at the sustained rate
+			o["ingestion_burst_size"] = l.SamplesPerSecond * 10
+		}
+		if l.MaxSeries > 0 {
+			o["max_global_series_per_user"] = l.MaxSeries
+		}
+		if l.Retention != "" {
+			period, err := retentionHours(l.Retention)
+			if err != nil {
+				return nil, err
+			}
+			o["compactor_blocks_retention_period"] = period
+		}
+		return o, nil
+	})
+}
+
+// TempoOverrides renders the Tempo per-tenant overrides file
+func (c Config) TempoOverrides() ([]byte, error) {
+	return c.renderOverrides(func(l Limits) (map[string]interface{}, error) {
+		o := make(map[string]interface{})
+
+		ingestion := make(map[string]interface{})
+		if l.IngestionRateMB > 0 {
+			ingestion["rate_limit_bytes"] = int(l.IngestionRateMB * megabyte)
+		}
+		if l.IngestionBurstMB > 0 {
+			ingestion["burst_size_bytes"] = int(l.IngestionBurstMB * megabyte)
+		}
+		if l.MaxTraces > 0 {
+			ingestion["max_traces_per_user"] = l.MaxTraces
+		}
+		if len(ingestion) > 0 {
+			o["ingestion"] = ingestion
+		}
+
+		if l.Retention != "" {
+			period, err := retentionHours(l.Retention)
+			if err != nil {
+				return nil, err
+			}
+			o["compaction"] = map[string]interface{}{"block_retention": period}
+		}
+		return o, nil
+	})
+}
+
+// renderOverrides builds an overrides document keyed by tenant ID
+func (c Config) renderOverrides(translate func(Limits) (map[string]interface{}, error)) ([]byte, error) {
+	overrides := make(map[string]interface{})
+	for _, t := range c.Tenants {
+		o, err := translate(c.EffectiveLimits(t))
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+		if len(o) > 0 {
+			overrides[t.ID] = o
+		}
+	}
+	return yaml.Marshal(map[string]interface{}{"overrides": overrides})
+}
+
+// retentionHours converts a retention period to the hour form the backends expect
+func retentionHours(period string) (string, error) {
+	d, err := retention.ParseDuration(period)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%dh", int(d/time.Hour)), nil
+}
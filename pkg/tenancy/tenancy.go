@@ -0,0 +1,181 @@
+// Package tenancy isolates telemetry per tenant on a shared observability stack.
+//
+// Requests to Loki, Mimir, and Tempo carry the tenant in the X-Scope-OrgID
+// header, per-tenant limits are rendered as runtime overrides for each backend,
+// Grafana gets one organization per tenant with tenant-scoped datasources, and
+// a Meter records per-tenant usage of the APM server.
+package tenancy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultHeader is the tenant header understood by Loki, Mimir, and Tempo
+const DefaultHeader = "X-Scope-OrgID"
+
+// Config holds multi-tenant settings
+type Config struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+
+	// Header carries the tenant ID; defaults to X-Scope-OrgID
+	Header string `mapstructure:"header" yaml:"header" json:"header,omitempty"`
+
+	// DefaultTenant is used for requests without a tenant header
+	DefaultTenant string `mapstructure:"default_tenant" yaml:"default_tenant" json:"default_tenant,omitempty"`
+
+	// Required rejects requests without a tenant when no default is set
+	Required bool `mapstructure:"required" yaml:"required" json:"required"`
+
+	// ExemptPaths are served without tenant resolution, e.g. health checks
+	ExemptPaths []string `mapstructure:"exempt_paths" yaml:"exempt_paths" json:"exempt_paths,omitempty"`
+
+	// DefaultLimits apply to every tenant unless overridden
+	DefaultLimits Limits `mapstructure:"default_limits" yaml:"default_limits" json:"default_limits"`
+
+	// Tenants lists known tenants; when non-empty unknown tenants are rejected
+	Tenants []Tenant `mapstructure:"tenants" yaml:"tenants" json:"tenants"`
+}
+
+// Tenant describes one tenant of the shared stack
+type Tenant struct {
+	ID     string `mapstructure:"id" yaml:"id" json:"id"`
+	Name   string `mapstructure:"name" yaml:"name" json:"name,omitempty"`
+	Limits Limits `mapstructure:"limits" yaml:"limits" json:"limits"`
+
+	// GrafanaOrgID is the tenant's Grafana organization, for file provisioning
+	GrafanaOrgID int `mapstructure:"grafana_org_id" yaml:"grafana_org_id" json:"grafana_org_id,omitempty"`
+}
+
+// Limits are per-tenant backend limits; zero values inherit the backend default
+type Limits struct {
+	// Loki and Tempo ingestion rate and burst in MB per second
+	IngestionRateMB  float64 `mapstructure:"ingestion_rate_mb" yaml:"ingestion_rate_mb" json:"ingestion_rate_mb,omitempty"`
+	IngestionBurstMB float64 `mapstructure:"ingestion_burst_mb" yaml:"ingestion_burst_mb" json:"ingestion_burst_mb,omitempty"`
+
+	// MaxStreams is the Loki active stream limit
+	MaxStreams int `mapstructure:"max_streams" yaml:"max_streams" json:"max_streams,omitempty"`
+
+	// SamplesPerSecond and MaxSeries are the Mimir ingestion and series limits
+	SamplesPerSecond int `mapstructure:"samples_per_second" yaml:"samples_per_second" json:"samples_per_second,omitempty"`
+	MaxSeries        int `mapstructure:"max_series" yaml:"max_series" json:"max_series,omitempty"`
+
+	// MaxTraces is the Tempo live trace limit
+	MaxTraces int `mapstructure:"max_traces" yaml:"max_traces" json:"max_traces,omitempty"`
+
+	// Retention applies to logs, metrics, and traces, e.g. "30d"
+	Retention string `mapstructure:"retention" yaml:"retention" json:"retention,omitempty"`
+}
+
+// merge returns l with zero fields filled from defaults
+func (l Limits) merge(defaults Limits) Limits {
+	if l.IngestionRateMB == 0 {
+		l.IngestionRateMB = defaults.IngestionRateMB
+	}
+	if l.IngestionBurstMB == 0 {
+		l.IngestionBurstMB = defaults.IngestionBurstMB
+	}
+	if l.MaxStreams == 0 {
+		l.MaxStreams = defaults.MaxStreams
+	}
+	if l.SamplesPerSecond == 0 {
+		l.SamplesPerSecond = defaults.SamplesPerSecond
+	}
+	if l.MaxSeries == 0 {
+		l.MaxSeries = defaults.MaxSeries
+	}
+	if l.MaxTraces == 0 {
+		l.MaxTraces = defaults.MaxTraces
+	}
+	if l.Retention == "" {
+		l.Retention = defaults.Retention
+	}
+	return l
+}
+
+// HeaderName returns the configured tenant header
+func (c Config) HeaderName() string {
+	if c.Header == "" {
+		return DefaultHeader
+	}
+	return c.Header
+}
+
+// Lookup returns a known tenant by ID
+func (c Config) Lookup(id string) (Tenant, bool) {
+	for _, t := range c.Tenants {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// Allowed reports whether a tenant may use the stack
+func (c Config) Allowed(id string) bool {
+	if len(c.Tenants) == 0 {
+		return ValidateID(id) == nil
+	}
+	_, ok := c.Lookup(id)
+	return ok
+}
+
+// EffectiveLimits returns the tenant limits merged with the defaults
+func (c Config) EffectiveLimits(t Tenant) Limits {
+	return t.Limits.merge(c.DefaultLimits)
+}
+
+// Validate checks tenant IDs and the default tenant
+func (c Config) Validate() error {
+	seen := make(map[string]bool)
+	for _, t := range c.Tenants {
+		if err := ValidateID(t.ID); err != nil {
+			return err
+		}
+		if seen[t.ID] {
+			return fmt.Errorf("duplicate tenant %q", t.ID)
+		}
+		seen[t.ID] = true
+	}
+
+	if c.DefaultTenant != "" && !c.Allowed(c.DefaultTenant) {
+		return fmt.Errorf("default tenant %q is not a configured tenant", c.DefaultTenant)
+	}
+	return nil
+}
+
+// ValidateID checks a tenant ID against the rules shared by Loki, Mimir, and Tempo
+func ValidateID(id string) error {
+	if id == "" {
+		return fmt.Errorf("tenant ID is empty")
+	}
+	if len(id) > 150 {
+		return fmt.Errorf("tenant ID %q exceeds 150 characters", id)
+	}
+	if id == "." || id == ".." {
+		return fmt.Errorf("tenant ID %q is not allowed", id)
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("!-_.*'()", r):
+		default:
+			return fmt.Errorf("tenant ID %q contains unsupported character %q", id, r)
+		}
+	}
+	return nil
+}
+
+type contextKey struct{}
+
+// WithTenant returns a context carrying the tenant ID
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant ID carried by ctx
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
@@ -0,0 +1,158 @@
+package tenancy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+func testConfig() Config {
+	return Config{
+		Enabled:       true,
+		DefaultLimits: Limits{IngestionRateMB: 4, Retention: "30d"},
+		Tenants: []Tenant{
+			{ID: "team-a", Limits: Limits{MaxSeries: 1000, Retention: "7d"}},
+			{ID: "team-b"},
+		},
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	cfg := testConfig()
+
+	data, err := cfg.LokiOverrides()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var loki struct {
+		Overrides map[string]map[string]interface{} `yaml:"overrides"`
+	}
+	if err := yaml.Unmarshal(data, &loki); err != nil {
+		t.Fatalf("invalid yaml: %v", err)
+	}
+	if loki.Overrides["team-a"]["retention_period"] != "168h" {
+		t.Errorf("expected team-a retention 168h, got %v", loki.Overrides["team-a"])
+	}
+	if loki.Overrides["team-b"]["retention_period"] != "720h" || loki.Overrides["team-b"]["ingestion_rate_mb"] != 4 {
+		t.Errorf("expected team-b to inherit defaults, got %v", loki.Overrides["team-b"])
+	}
+
+	data, err = cfg.MimirOverrides()
+	if err != nil || !strings.Contains(string(data), "max_global_series_per_user: 1000") {
+		t.Errorf("unexpected mimir overrides (%v):\n%s", err, data)
+	}
+
+	data, err = cfg.TempoOverrides()
+	if err != nil || !strings.Contains(string(data), "rate_limit_bytes: 4194304") {
+		t.Errorf("unexpected tempo overrides (%v):\n%s", err, data)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(DefaultHeader)
+	}))
+	defer server.Close()
+
+	client := NewClient(nil, "fallback")
+
+	req, _ := http.NewRequestWithContext(WithTenant(context.Background(), "team-a"), http.MethodGet, server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if got != "team-a" {
+		t.Errorf("expected context tenant, got %q", got)
+	}
+
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if got != "fallback" {
+		t.Errorf("expected fallback tenant, got %q", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	cfg := testConfig()
+	cfg.Required = true
+	cfg.ExemptPaths = []string{"/health"}
+	meter := NewMeter(nil)
+
+	app := fiber.New()
+	app.Use(Middleware(cfg, meter))
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Post("/ingest", func(c *fiber.Ctx) error {
+		tenant, _ := FromContext(c.UserContext())
+		return c.SendString(tenant)
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		tenant string
+		status int
+	}{
+		{"known tenant", "/ingest", "team-a", fiber.StatusOK},
+		{"unknown tenant", "/ingest", "team-z", fiber.StatusForbidden},
+		{"missing tenant", "/ingest", "", fiber.StatusUnauthorized},
+		{"exempt path", "/health", "", fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodPost
+			if tt.path == "/health" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, strings.NewReader("payload"))
+			if tt.tenant != "" {
+				req.Header.Set(DefaultHeader, tt.tenant)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.status == fiber.StatusOK && tt.tenant != "" {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.tenant {
+					t.Errorf("expected tenant %q in context, got %q", tt.tenant, body)
+				}
+			}
+		})
+	}
+
+	usage, ok := meter.Usage("team-a")
+	if !ok || usage.Requests != 1 || usage.BytesIn != int64(len("payload")) {
+		t.Errorf("unexpected team-a usage %+v", usage)
+	}
+	if usage, ok := meter.Usage("team-z"); !ok || usage.Rejected != 1 {
+		t.Errorf("expected rejected request for team-z, got %+v", usage)
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"team-a", "Org_1", "a.b"} {
+		if err := ValidateID(id); err != nil {
+			t.Errorf("expected %q to be valid: %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "..", "team/a", "team a", strings.Repeat("x", 151)} {
+		if err := ValidateID(id); err == nil {
+			t.Errorf("expected %q to be invalid", id)
+		}
+	}
+}
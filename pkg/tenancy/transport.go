@@ -0,0 +1,55 @@
+package tenancy
+
+import (
+	"net/http"
+)
+
+// Transport injects the tenant header into requests to Loki, Mimir, and Tempo.
+// The tenant is taken from the request context, falling back to Tenant.
+type Transport struct {
+	// Base is the underlying transport; nil uses http.DefaultTransport
+	Base http.RoundTripper
+
+	// Header overrides DefaultHeader
+	Header string
+
+	// Tenant is used when the request context carries no tenant
+	Tenant string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tenant, ok := FromContext(req.Context())
+	if !ok {
+		tenant = t.Tenant
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if tenant == "" {
+		return base.RoundTrip(req)
+	}
+
+	header := t.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set(header, tenant)
+	return base.RoundTrip(req)
+}
+
+// NewClient returns an HTTP client that sends every request as tenant
+func NewClient(base *http.Client, tenant string) *http.Client {
+	client := &http.Client{}
+	if base != nil {
+		*client = *base
+	}
+	client.Transport = &Transport{Base: client.Transport, Tenant: tenant}
+	return client
+}
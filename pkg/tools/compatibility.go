@@ -0,0 +1,239 @@
+package tools
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompatibilityStatus is the contract test outcome for a tool version
+type CompatibilityStatus string
+
+const (
+	CompatibilityCompatible   CompatibilityStatus = "compatible"   // every contract passed
+	CompatibilityPartial      CompatibilityStatus = "partial"      // the tool works, some integrations do not
+	CompatibilityIncompatible CompatibilityStatus = "incompatible" // the health check or config template failed
+	CompatibilityUntested     CompatibilityStatus = "untested"
+)
+
+// Contracts verified against each tool version
+const (
+	ContractHealth  = "health"  // the health checker reports healthy
+	ContractVersion = "version" // the health checker reports the running version
+	ContractClient  = "client"  // the APM clients can query the tool's API
+	ContractConfig  = "config"  // the tool starts with the rendered config template
+)
+
+// ContractResult is the outcome of one contract against one tool version
+type ContractResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CompatibilityEntry holds the contract results for one pinned tool version
+type CompatibilityEntry struct {
+	Tool      ToolType            `json:"tool"`
+	Version   string              `json:"version"`
+	Image     string              `json:"image"`
+	Status    CompatibilityStatus `json:"status"`
+	Contracts []ContractResult    `json:"contracts,omitempty"`
+	TestedAt  *time.Time          `json:"tested_at,omitempty"`
+}
+
+// StatusFromContracts derives an entry's status from its contract results
+func StatusFromContracts(results []ContractResult) CompatibilityStatus {
+	if len(results) == 0 {
+		return CompatibilityUntested
+	}
+	status := CompatibilityCompatible
+	for _, r := range results {
+		if r.Passed {
+			continue
+		}
+		if r.Name == ContractHealth || r.Name == ContractConfig {
+			return CompatibilityIncompatible
+		}
+		status = CompatibilityPartial
+	}
+	return status
+}
+
+// CompatibilityMatrix lists the tool versions the contract tests in
+// test/contract run against, and how each fared
+type CompatibilityMatrix struct {
+	GeneratedAt *time.Time           `json:"generated_at,omitempty"`
+	Entries     []CompatibilityEntry `json:"entries"`
+}
+
+//go:embed compatibility.json
+var compatibilityJSON []byte
+
+// DefaultCompatibilityMatrix returns the matrix shipped with this build
+func DefaultCompatibilityMatrix() (*CompatibilityMatrix, error) {
+	return parseCompatibilityMatrix(compatibilityJSON)
+}
+
+// LoadCompatibilityMatrix reads a matrix written by Save
+func LoadCompatibilityMatrix(path string) (*CompatibilityMatrix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compatibility matrix: %w", err)
+	}
+	return parseCompatibilityMatrix(data)
+}
+
+func parseCompatibilityMatrix(data []byte) (*CompatibilityMatrix, error) {
+	var m CompatibilityMatrix
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse compatibility matrix: %w", err)
+	}
+	return &m, nil
+}
+
+// Save writes the matrix as indented JSON
+func (m *CompatibilityMatrix) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Record adds an entry, replacing any for the same tool version, and keeps
+// the entries sorted by tool and version
+func (m *CompatibilityMatrix) Record(entry CompatibilityEntry) {
+	entry.Version = normalizeVersion(entry.Version)
+	for i, e := range m.Entries {
+		if e.Tool == entry.Tool && e.Version == entry.Version {
+			m.Entries[i] = entry
+			return
+		}
+	}
+	m.Entries = append(m.Entries, entry)
+	sort.SliceStable(m.Entries, func(i, j int) bool {
+		a, b := m.Entries[i], m.Entries[j]
+		if a.Tool != b.Tool {
+			return a.Tool < b.Tool
+		}
+		return compareVersions(a.Version, b.Version) < 0
+	})
+}
+
+// CompatibilityVerdict is the matrix's answer for a running tool version
+type CompatibilityVerdict struct {
+	Tool    ToolType            `json:"tool"`
+	Version string              `json:"version"`
+	Status  CompatibilityStatus `json:"status"`
+	// Tested is the matrix version the verdict is based on; it differs from
+	// Version when only the same minor release was tested
+	Tested  string `json:"tested,omitempty"`
+	Message string `json:"message"`
+}
+
+// Check looks up a running tool version. An exact match returns its status;
+// otherwise the latest tested patch of the same minor release is used.
+func (m *CompatibilityMatrix) Check(tool ToolType, version string) CompatibilityVerdict {
+	version = normalizeVersion(version)
+	verdict := CompatibilityVerdict{Tool: tool, Version: version, Status: CompatibilityUntested}
+
+	var tested []CompatibilityEntry
+	for _, e := range m.Entries {
+		if e.Tool == tool && e.Status != CompatibilityUntested {
+			tested = append(tested, e)
+		}
+	}
+	switch {
+	case version == "":
+		verdict.Message = "version unknown"
+		return verdict
+	case len(tested) == 0:
+		verdict.Message = fmt.Sprintf("no %s versions have been contract tested", tool)
+		return verdict
+	}
+
+	var sameMinor *CompatibilityEntry
+	for i, e := range tested {
+		if e.Version == version {
+			verdict.Status, verdict.Tested = e.Status, e.Version
+			verdict.Message = fmt.Sprintf("%s %s is %s", tool, version, e.Status)
+			return verdict
+		}
+		if minorRelease(e.Version) == minorRelease(version) {
+			sameMinor = &tested[i]
+		}
+	}
+	if sameMinor != nil {
+		verdict.Status, verdict.Tested = sameMinor.Status, sameMinor.Version
+		verdict.Message = fmt.Sprintf("%s %s not tested; %s is %s", tool, version, sameMinor.Version, sameMinor.Status)
+		return verdict
+	}
+
+	sort.Slice(tested, func(i, j int) bool { return compareVersions(tested[i].Version, tested[j].Version) < 0 })
+	oldest, newest := tested[0].Version, tested[len(tested)-1].Version
+	switch {
+	case compareVersions(version, oldest) < 0:
+		verdict.Message = fmt.Sprintf("%s %s is older than any tested version (oldest %s)", tool, version, oldest)
+	case compareVersions(version, newest) > 0:
+		verdict.Message = fmt.Sprintf("%s %s is newer than any tested version (latest %s)", tool, version, newest)
+	default:
+		verdict.Message = fmt.Sprintf("%s %s not tested (tested %s to %s)", tool, version, oldest, newest)
+	}
+	return verdict
+}
+
+// normalizeVersion strips a leading "v" and any build suffix, so
+// "v2.48.1+ds" and "2.48.1" match
+func normalizeVersion(version string) string {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "+ "); i >= 0 {
+		version = version[:i]
+	}
+	return version
+}
+
+// minorRelease returns "major.minor" of a version
+func minorRelease(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// compareVersions compares dotted versions numerically; a pre-release
+// ("3.0.0-rc.1") sorts before its release
+func compareVersions(a, b string) int {
+	aCore, aPre, _ := strings.Cut(a, "-")
+	bCore, bPre, _ := strings.Cut(b, "-")
+	as, bs := strings.Split(aCore, "."), strings.Split(bCore, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return strings.Compare(aPre, bPre)
+}
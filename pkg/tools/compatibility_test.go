@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefaultCompatibilityMatrix(t *testing.T) {
+	m, err := DefaultCompatibilityMatrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[ToolType]bool{}
+	for _, e := range m.Entries {
+		seen[e.Tool] = true
+		if e.Version == "" || e.Image == "" {
+			t.Errorf("entry %+v has no version or image", e)
+		}
+	}
+	for _, tool := range []ToolType{ToolTypePrometheus, ToolTypeGrafana, ToolTypeJaeger, ToolTypeLoki} {
+		if !seen[tool] {
+			t.Errorf("no pinned %s versions", tool)
+		}
+	}
+}
+
+func TestStatusFromContracts(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []ContractResult
+		want    CompatibilityStatus
+	}{
+		{"none", nil, CompatibilityUntested},
+		{"all pass", []ContractResult{{Name: ContractHealth, Passed: true}, {Name: ContractClient, Passed: true}}, CompatibilityCompatible},
+		{"client fails", []ContractResult{{Name: ContractHealth, Passed: true}, {Name: ContractClient}}, CompatibilityPartial},
+		{"config fails", []ContractResult{{Name: ContractVersion}, {Name: ContractConfig}}, CompatibilityIncompatible},
+	}
+	for _, tt := range tests {
+		if got := StatusFromContracts(tt.results); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompatibilityMatrixCheck(t *testing.T) {
+	m := &CompatibilityMatrix{}
+	m.Record(CompatibilityEntry{Tool: ToolTypePrometheus, Version: "v2.53.3", Status: CompatibilityCompatible})
+	m.Record(CompatibilityEntry{Tool: ToolTypePrometheus, Version: "2.45.6", Status: CompatibilityPartial})
+	m.Record(CompatibilityEntry{Tool: ToolTypePrometheus, Version: "3.1.0", Status: CompatibilityUntested})
+	m.Record(CompatibilityEntry{Tool: ToolTypeGrafana, Version: "10.4.13", Status: CompatibilityIncompatible})
+
+	tests := []struct {
+		tool    ToolType
+		version string
+		status  CompatibilityStatus
+		tested  string
+	}{
+		{ToolTypePrometheus, "2.53.3", CompatibilityCompatible, "2.53.3"},
+		{ToolTypePrometheus, "v2.45.6+ds", CompatibilityPartial, "2.45.6"},
+		{ToolTypePrometheus, "2.53.0", CompatibilityCompatible, "2.53.3"},
+		{ToolTypePrometheus, "2.50.1", CompatibilityUntested, ""},
+		{ToolTypePrometheus, "3.1.0", CompatibilityUntested, ""}, // pinned but not yet run
+		{ToolTypePrometheus, "", CompatibilityUntested, ""},
+		{ToolTypeGrafana, "10.4.2", CompatibilityIncompatible, "10.4.13"},
+		{ToolTypeLoki, "3.3.2", CompatibilityUntested, ""},
+	}
+	for _, tt := range tests {
+		v := m.Check(tt.tool, tt.version)
+		if v.Status != tt.status || v.Tested != tt.tested || v.Message == "" {
+			t.Errorf("Check(%s, %q) = %+v, want %s tested %q", tt.tool, tt.version, v, tt.status, tt.tested)
+		}
+	}
+
+	if got := m.Check(ToolTypePrometheus, "2.10.0").Message; got != "prometheus 2.10.0 is older than any tested version (oldest 2.45.6)" {
+		t.Errorf("old version message = %q", got)
+	}
+	if got := m.Check(ToolTypePrometheus, "4.0.0").Message; got != "prometheus 4.0.0 is newer than any tested version (latest 2.53.3)" {
+		t.Errorf("new version message = %q", got)
+	}
+}
+
+func TestCompatibilityMatrixRecordAndSave(t *testing.T) {
+	m := &CompatibilityMatrix{}
+	m.Record(CompatibilityEntry{Tool: ToolTypeLoki, Version: "3.3.2", Status: CompatibilityUntested})
+	m.Record(CompatibilityEntry{Tool: ToolTypeLoki, Version: "2.9.10", Status: CompatibilityUntested})
+	m.Record(CompatibilityEntry{Tool: ToolTypeGrafana, Version: "11.3.1", Status: CompatibilityUntested})
+
+	now := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	m.Record(CompatibilityEntry{Tool: ToolTypeLoki, Version: "3.3.2", Status: CompatibilityCompatible, TestedAt: &now})
+
+	if len(m.Entries) != 3 {
+		t.Fatalf("entries = %+v", m.Entries)
+	}
+	order := []string{"11.3.1", "2.9.10", "3.3.2"}
+	for i, e := range m.Entries {
+		if e.Version != order[i] {
+			t.Errorf("entry %d = %s, want %s", i, e.Version, order[i])
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "matrix.json")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCompatibilityMatrix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := loaded.Check(ToolTypeLoki, "3.3.2"); v.Status != CompatibilityCompatible {
+		t.Errorf("loaded verdict = %+v", v)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.9.10", "2.10.0", -1},
+		{"10.4.13", "9.5.21", 1},
+		{"3.0.0-rc.1", "3.0.0", -1},
+		{"1.62", "1.62.0", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
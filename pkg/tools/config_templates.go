@@ -3,6 +3,8 @@ package tools
 import (
 	"bytes"
 	"fmt"
+	"net"
+	"strings"
 	"text/template"
 )
 
@@ -46,7 +48,7 @@ scrape_configs:
 
   - job_name: 'apm-application'
     static_configs:
-      - targets: ['{{ .APMHost | default "localhost" }}:{{ .APMPort | default "8080" }}']
+      - targets: ['{{ hostport (.APMHost | default "localhost") (.APMPort | default "8080") }}']
     metrics_path: '/metrics'
 
   {{- if .ServiceDiscovery }}
@@ -71,6 +73,13 @@ scrape_configs:
         regex: ([^:]+)(?::\d+)?;(\d+)
         replacement: $1:$2
         target_label: __address__
+      # IPv6 pods: the address above has colons, so rebuild it from the
+      # pod IP in brackets
+      - source_labels: [__meta_kubernetes_pod_annotation_prometheus_io_port, __meta_kubernetes_pod_ip]
+        action: replace
+        regex: (\d+);(([A-Fa-f0-9]{1,4}::?){1,7}[A-Fa-f0-9]{1,4})
+        replacement: '[$2]:$1'
+        target_label: __address__
   {{- end }}
 
   {{- range .CustomScrapeConfigs }}
@@ -199,7 +208,10 @@ server:
 
 ingester:
   lifecycler:
-    address: 127.0.0.1
+    address: {{ .RingAddress | default "127.0.0.1" }}
+    {{- if .IPv6 }}
+    enable_inet6: true
+    {{- end }}
     ring:
       kvstore:
         store: {{ .KVStore | default "inmemory" }}
@@ -265,7 +277,7 @@ var AlertManagerConfigTemplate = ConfigTemplate{
 	Template: `global:
   resolve_timeout: {{ .ResolveTimeout | default "5m" }}
   {{- if .SMTPConfig }}
-  smtp_smarthost: '{{ .SMTPConfig.Host }}:{{ .SMTPConfig.Port }}'
+  smtp_smarthost: '{{ hostport .SMTPConfig.Host .SMTPConfig.Port }}'
   smtp_from: '{{ .SMTPConfig.From }}'
   smtp_auth_username: '{{ .SMTPConfig.Username }}'
   smtp_auth_password: '{{ .SMTPConfig.Password }}'
@@ -409,6 +421,10 @@ func templateFuncs() template.FuncMap {
 			// Simple YAML conversion for demo purposes
 			return fmt.Sprintf("%v", v)
 		},
+		// hostport joins a host and port, bracketing IPv6 addresses
+		"hostport": func(host, port interface{}) string {
+			return net.JoinHostPort(strings.Trim(fmt.Sprint(host), "[]"), fmt.Sprint(port))
+		},
 		"indent": func(spaces int, text string) string {
 			padding := ""
 			for i := 0; i < spaces; i++ {
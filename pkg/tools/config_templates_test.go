@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderIPv6Hosts(t *testing.T) {
+	renderer, err := NewConfigTemplateRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prometheus, err := renderer.Render(ToolTypePrometheus, map[string]interface{}{"APMHost": "fd00::10", "APMPort": 8080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prometheus, "targets: ['[fd00::10]:8080']") {
+		t.Errorf("IPv6 target is not bracketed:\n%s", prometheus)
+	}
+
+	prometheus, err = renderer.Render(ToolTypePrometheus, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prometheus, "targets: ['localhost:8080']") {
+		t.Errorf("default target changed:\n%s", prometheus)
+	}
+
+	alertmanager, err := renderer.Render(ToolTypeAlertManager, map[string]interface{}{
+		"SMTPConfig": map[string]interface{}{"Host": "[fd00::25]", "Port": 587},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(alertmanager, "smtp_smarthost: '[fd00::25]:587'") {
+		t.Errorf("smarthost is not bracketed once:\n%s", alertmanager)
+	}
+}
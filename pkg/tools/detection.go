@@ -0,0 +1,118 @@
+package tools
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default detection timeouts
+const (
+	DefaultDetectTimeout = 5 * time.Second
+	DefaultProbeTimeout  = 3 * time.Second
+)
+
+// DetectableToolTypes are the tools DetectTools probes by default
+var DetectableToolTypes = []ToolType{
+	ToolTypePrometheus,
+	ToolTypeGrafana,
+	ToolTypeJaeger,
+	ToolTypeLoki,
+	ToolTypeAlertManager,
+}
+
+// createDetector is replaced in tests
+var createDetector = NewDetectorFactory().CreateDetector
+
+// DetectOptions configures DetectTools
+type DetectOptions struct {
+	// ToolTypes to probe; empty probes DetectableToolTypes
+	ToolTypes []ToolType
+
+	// Timeout bounds the whole detection and ProbeTimeout each tool's
+	// probe; zero uses DefaultDetectTimeout and DefaultProbeTimeout
+	Timeout      time.Duration
+	ProbeTimeout time.Duration
+}
+
+// DetectionResult is the outcome of probing one tool type
+type DetectionResult struct {
+	ToolType ToolType
+	// Tool is nil when the tool was not detected
+	Tool    *Tool
+	Err     error
+	Elapsed time.Duration
+}
+
+// DetectTools probes the tool types concurrently and sends each result as
+// soon as its probe finishes, so callers such as the TUI can show tools as
+// they are found. Every tool type gets exactly one result. The channel is
+// closed after the last one, at the latest when the global timeout or ctx
+// ends the remaining probes.
+func DetectTools(ctx context.Context, opts DetectOptions) <-chan DetectionResult {
+	toolTypes := opts.ToolTypes
+	if len(toolTypes) == 0 {
+		toolTypes = DetectableToolTypes
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDetectTimeout
+	}
+	probeTimeout := opts.ProbeTimeout
+	if probeTimeout <= 0 {
+		probeTimeout = DefaultProbeTimeout
+	}
+
+	// Buffered so probes never block on a reader that stopped early
+	results := make(chan DetectionResult, len(toolTypes))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	var wg sync.WaitGroup
+	for _, toolType := range toolTypes {
+		wg.Add(1)
+		go func(toolType ToolType) {
+			defer wg.Done()
+			probeCtx, probeCancel := context.WithTimeout(ctx, probeTimeout)
+			defer probeCancel()
+
+			start := time.Now()
+			tool, err := probe(probeCtx, toolType)
+			results <- DetectionResult{ToolType: toolType, Tool: tool, Err: err, Elapsed: time.Since(start)}
+		}(toolType)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
+	return results
+}
+
+// probe runs one detector. Detectors without DetectContext run in their own
+// goroutine and are abandoned when ctx is done.
+func probe(ctx context.Context, toolType ToolType) (*Tool, error) {
+	detector, err := createDetector(toolType)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := detector.(ContextDetector); ok {
+		return cd.DetectContext(ctx)
+	}
+
+	type outcome struct {
+		tool *Tool
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		tool, err := detector.Detect()
+		done <- outcome{tool, err}
+	}()
+	select {
+	case o := <-done:
+		return o.tool, o.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubDetector returns its tool after a delay, or ctx's error if that
+// comes first
+type stubDetector struct {
+	tool  *Tool
+	delay time.Duration
+}
+
+func (d *stubDetector) Detect() (*Tool, error) { return d.DetectContext(context.Background()) }
+
+func (d *stubDetector) DetectContext(ctx context.Context) (*Tool, error) {
+	select {
+	case <-time.After(d.delay):
+		if d.tool == nil {
+			return nil, errors.New("not detected")
+		}
+		return d.tool, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *stubDetector) Validate() error             { return nil }
+func (d *stubDetector) GetVersion() (string, error) { return "", nil }
+
+func stubDetectors(t *testing.T, detectors map[ToolType]ToolDetector) {
+	orig := createDetector
+	createDetector = func(toolType ToolType) (ToolDetector, error) {
+		if d, ok := detectors[toolType]; ok {
+			return d, nil
+		}
+		return nil, errors.New("unsupported")
+	}
+	t.Cleanup(func() { createDetector = orig })
+}
+
+func TestDetectToolsStreamsAndTimesOut(t *testing.T) {
+	stubDetectors(t, map[ToolType]ToolDetector{
+		ToolTypeGrafana:    &stubDetector{tool: &Tool{Name: "grafana"}, delay: 30 * time.Millisecond},
+		ToolTypePrometheus: &stubDetector{tool: &Tool{Name: "prometheus"}},
+		ToolTypeLoki:       &stubDetector{delay: time.Hour},
+	})
+
+	start := time.Now()
+	var order []ToolType
+	results := map[ToolType]DetectionResult{}
+	for r := range DetectTools(context.Background(), DetectOptions{
+		ToolTypes:    []ToolType{ToolTypeLoki, ToolTypeGrafana, ToolTypePrometheus},
+		ProbeTimeout: 200 * time.Millisecond,
+	}) {
+		order = append(order, r.ToolType)
+		results[r.ToolType] = r
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("detection took %s, want about the probe timeout", elapsed)
+	}
+	if len(order) != 3 || order[0] != ToolTypePrometheus || order[1] != ToolTypeGrafana || order[2] != ToolTypeLoki {
+		t.Errorf("results arrived in order %v, want fastest first", order)
+	}
+	if r := results[ToolTypeLoki]; r.Tool != nil || !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Errorf("slow probe = %+v, want deadline exceeded", r)
+	}
+	if r := results[ToolTypeGrafana]; r.Tool == nil || r.Tool.Name != "grafana" || r.Elapsed < 30*time.Millisecond {
+		t.Errorf("grafana = %+v", r)
+	}
+}
+
+func TestDetectToolsCanceled(t *testing.T) {
+	stubDetectors(t, map[ToolType]ToolDetector{
+		ToolTypeJaeger: &stubDetector{delay: time.Hour},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := DetectTools(ctx, DetectOptions{ToolTypes: []ToolType{ToolTypeJaeger, ToolTypeSonarQube}})
+	cancel()
+
+	n := 0
+	for r := range results {
+		n++
+		if r.Err == nil {
+			t.Errorf("%s: no error after cancel", r.ToolType)
+		}
+	}
+	if n != 2 {
+		t.Errorf("got %d results, want one per tool type", n)
+	}
+}
+
+func TestDetectAllToolsKeepsOrder(t *testing.T) {
+	stubDetectors(t, map[ToolType]ToolDetector{
+		ToolTypePrometheus:   &stubDetector{tool: &Tool{Type: ToolTypePrometheus}, delay: 20 * time.Millisecond},
+		ToolTypeAlertManager: &stubDetector{tool: &Tool{Type: ToolTypeAlertManager}},
+	})
+
+	tools, err := DetectAllTools(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != 2 || tools[0].Type != ToolTypePrometheus || tools[1].Type != ToolTypeAlertManager {
+		t.Errorf("DetectAllTools = %+v", tools)
+	}
+}
+
+func TestDetectByPortPrefersConfiguredOrder(t *testing.T) {
+	listen := func() (net.Listener, int) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l, l.Addr().(*net.TCPAddr).Port
+	}
+	_, first := listen()
+	_, second := listen()
+	closed, closedPort := listen()
+	closed.Close()
+
+	d := NewBaseDetector(ToolTypeLoki, []int{closedPort, second, first})
+	tool, err := d.DetectByPortContext(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tool.Port != second {
+		t.Errorf("port = %d, want %d", tool.Port, second)
+	}
+
+	d = NewBaseDetector(ToolTypeLoki, []int{closedPort})
+	if _, err := d.DetectByPortContext(context.Background(), "127.0.0.1"); err == nil {
+		t.Error("closed port detected")
+	}
+}
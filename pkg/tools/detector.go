@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -30,15 +31,34 @@ func NewBaseDetector(toolType ToolType, ports []int) *BaseDetector {
 
 // DetectByPort checks if a tool is running on specified ports
 func (bd *BaseDetector) DetectByPort(host string) (*Tool, error) {
-	for _, port := range bd.ports {
-		address := fmt.Sprintf("%s:%d", host, port)
-		conn, err := net.DialTimeout("tcp", address, 2*time.Second)
-		if err == nil {
-			conn.Close()
+	return bd.DetectByPortContext(context.Background(), host)
+}
+
+// DetectByPortContext dials every configured port at once and returns the
+// first port, in configuration order, that accepts a connection
+func (bd *BaseDetector) DetectByPortContext(ctx context.Context, host string) (*Tool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	open := make([]chan bool, len(bd.ports))
+	for i, port := range bd.ports {
+		open[i] = make(chan bool, 1)
+		go func(result chan<- bool, address string) {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err == nil {
+				conn.Close()
+			}
+			result <- err == nil
+		}(open[i], net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+
+	for i, port := range bd.ports {
+		if <-open[i] {
 			return &Tool{
 				Type:        bd.toolType,
 				Port:        port,
-				Endpoint:    fmt.Sprintf("http://%s", address),
+				Endpoint:    "http://" + net.JoinHostPort(host, strconv.Itoa(port)),
 				InstallType: InstallTypeNative, // Will be determined later
 				Status:      ToolStatusUnknown,
 			}, nil
@@ -49,15 +69,23 @@ func (bd *BaseDetector) DetectByPort(host string) (*Tool, error) {
 
 // DetectByProcess checks if a tool is running as a process
 func (bd *BaseDetector) DetectByProcess(processName string) (*Tool, error) {
-	cmd := exec.Command("pgrep", "-f", processName)
+	return bd.DetectByProcessContext(context.Background(), processName)
+}
+
+// DetectByProcessContext is DetectByProcess, killing pgrep when ctx is done
+func (bd *BaseDetector) DetectByProcessContext(ctx context.Context, processName string) (*Tool, error) {
+	cmd := exec.CommandContext(ctx, "pgrep", "-f", processName)
 	output, err := cmd.Output()
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("process not found: %s", processName)
 	}
 
 	if len(strings.TrimSpace(string(output))) > 0 {
 		// Process found, now find the port
-		tool, err := bd.DetectByPort("localhost")
+		tool, err := bd.DetectByPortContext(ctx, "localhost")
 		if err != nil {
 			return nil, err
 		}
@@ -68,6 +96,24 @@ func (bd *BaseDetector) DetectByProcess(processName string) (*Tool, error) {
 	return nil, fmt.Errorf("process not running: %s", processName)
 }
 
+// detect looks for the tool on its ports, then as a process, and names the
+// result. It gives up with ctx's error once ctx is done.
+func (bd *BaseDetector) detect(ctx context.Context, name, processName, healthPath string) (*Tool, error) {
+	tool, err := bd.DetectByPortContext(ctx, "localhost")
+	if err != nil && ctx.Err() == nil {
+		tool, err = bd.DetectByProcessContext(ctx, processName)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s not detected: %w", name, ctx.Err())
+		}
+		return nil, fmt.Errorf("%s not detected", name)
+	}
+	tool.Name = name
+	tool.HealthEndpoint = tool.Endpoint + healthPath
+	return tool, nil
+}
+
 // PrometheusDetector detects Prometheus installations
 type PrometheusDetector struct {
 	*BaseDetector
@@ -82,27 +128,20 @@ func NewPrometheusDetector() *PrometheusDetector {
 
 // Detect attempts to detect Prometheus installation
 func (pd *PrometheusDetector) Detect() (*Tool, error) {
-	// Try to detect by port first
-	tool, err := pd.DetectByPort("localhost")
-	if err == nil {
-		// Verify it's actually Prometheus
-		if err := pd.Validate(); err != nil {
-			return nil, err
-		}
-		tool.Name = "prometheus"
-		tool.HealthEndpoint = fmt.Sprintf("%s/-/healthy", tool.Endpoint)
-		return tool, nil
-	}
+	return pd.DetectContext(context.Background())
+}
 
-	// Try to detect by process
-	tool, err = pd.DetectByProcess("prometheus")
-	if err == nil {
-		tool.Name = "prometheus"
-		tool.HealthEndpoint = fmt.Sprintf("%s/-/healthy", tool.Endpoint)
-		return tool, nil
+// DetectContext detects Prometheus, giving up when ctx is done
+func (pd *PrometheusDetector) DetectContext(ctx context.Context) (*Tool, error) {
+	tool, err := pd.detect(ctx, "prometheus", "prometheus", "/-/healthy")
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, fmt.Errorf("prometheus not detected")
+	// Verify it's actually Prometheus
+	if err := pd.Validate(); err != nil {
+		return nil, err
+	}
+	return tool, nil
 }
 
 // Validate verifies that the detected tool is actually Prometheus
@@ -132,21 +171,12 @@ func NewGrafanaDetector() *GrafanaDetector {
 
 // Detect attempts to detect Grafana installation
 func (gd *GrafanaDetector) Detect() (*Tool, error) {
-	tool, err := gd.DetectByPort("localhost")
-	if err == nil {
-		tool.Name = "grafana"
-		tool.HealthEndpoint = fmt.Sprintf("%s/api/health", tool.Endpoint)
-		return tool, nil
-	}
-
-	tool, err = gd.DetectByProcess("grafana-server")
-	if err == nil {
-		tool.Name = "grafana"
-		tool.HealthEndpoint = fmt.Sprintf("%s/api/health", tool.Endpoint)
-		return tool, nil
-	}
+	return gd.DetectContext(context.Background())
+}
 
-	return nil, fmt.Errorf("grafana not detected")
+// DetectContext detects Grafana, giving up when ctx is done
+func (gd *GrafanaDetector) DetectContext(ctx context.Context) (*Tool, error) {
+	return gd.detect(ctx, "grafana", "grafana-server", "/api/health")
 }
 
 // Validate verifies that the detected tool is actually Grafana
@@ -173,21 +203,12 @@ func NewJaegerDetector() *JaegerDetector {
 
 // Detect attempts to detect Jaeger installation
 func (jd *JaegerDetector) Detect() (*Tool, error) {
-	tool, err := jd.DetectByPort("localhost")
-	if err == nil {
-		tool.Name = "jaeger"
-		tool.HealthEndpoint = fmt.Sprintf("%s/", tool.Endpoint)
-		return tool, nil
-	}
-
-	tool, err = jd.DetectByProcess("jaeger")
-	if err == nil {
-		tool.Name = "jaeger"
-		tool.HealthEndpoint = fmt.Sprintf("%s/", tool.Endpoint)
-		return tool, nil
-	}
+	return jd.DetectContext(context.Background())
+}
 
-	return nil, fmt.Errorf("jaeger not detected")
+// DetectContext detects Jaeger, giving up when ctx is done
+func (jd *JaegerDetector) DetectContext(ctx context.Context) (*Tool, error) {
+	return jd.detect(ctx, "jaeger", "jaeger", "/")
 }
 
 // Validate verifies that the detected tool is actually Jaeger
@@ -214,21 +235,12 @@ func NewLokiDetector() *LokiDetector {
 
 // Detect attempts to detect Loki installation
 func (ld *LokiDetector) Detect() (*Tool, error) {
-	tool, err := ld.DetectByPort("localhost")
-	if err == nil {
-		tool.Name = "loki"
-		tool.HealthEndpoint = fmt.Sprintf("%s/ready", tool.Endpoint)
-		return tool, nil
-	}
-
-	tool, err = ld.DetectByProcess("loki")
-	if err == nil {
-		tool.Name = "loki"
-		tool.HealthEndpoint = fmt.Sprintf("%s/ready", tool.Endpoint)
-		return tool, nil
-	}
+	return ld.DetectContext(context.Background())
+}
 
-	return nil, fmt.Errorf("loki not detected")
+// DetectContext detects Loki, giving up when ctx is done
+func (ld *LokiDetector) DetectContext(ctx context.Context) (*Tool, error) {
+	return ld.detect(ctx, "loki", "loki", "/ready")
 }
 
 // Validate verifies that the detected tool is actually Loki
@@ -255,21 +267,12 @@ func NewAlertManagerDetector() *AlertManagerDetector {
 
 // Detect attempts to detect AlertManager installation
 func (ad *AlertManagerDetector) Detect() (*Tool, error) {
-	tool, err := ad.DetectByPort("localhost")
-	if err == nil {
-		tool.Name = "alertmanager"
-		tool.HealthEndpoint = fmt.Sprintf("%s/-/healthy", tool.Endpoint)
-		return tool, nil
-	}
-
-	tool, err = ad.DetectByProcess("alertmanager")
-	if err == nil {
-		tool.Name = "alertmanager"
-		tool.HealthEndpoint = fmt.Sprintf("%s/-/healthy", tool.Endpoint)
-		return tool, nil
-	}
+	return ad.DetectContext(context.Background())
+}
 
-	return nil, fmt.Errorf("alertmanager not detected")
+// DetectContext detects AlertManager, giving up when ctx is done
+func (ad *AlertManagerDetector) DetectContext(ctx context.Context) (*Tool, error) {
+	return ad.detect(ctx, "alertmanager", "alertmanager", "/-/healthy")
 }
 
 // Validate verifies that the detected tool is actually AlertManager
@@ -308,29 +311,22 @@ func (df *DetectorFactory) CreateDetector(toolType ToolType) (ToolDetector, erro
 	}
 }
 
-// DetectAllTools attempts to detect all supported tools
+// DetectAllTools attempts to detect all supported tools. The tools are
+// probed concurrently with the default timeouts; see DetectTools.
 func DetectAllTools(ctx context.Context) ([]*Tool, error) {
-	factory := NewDetectorFactory()
-	toolTypes := []ToolType{
-		ToolTypePrometheus,
-		ToolTypeGrafana,
-		ToolTypeJaeger,
-		ToolTypeLoki,
-		ToolTypeAlertManager,
+	found := make(map[ToolType]*Tool)
+	for result := range DetectTools(ctx, DetectOptions{}) {
+		if result.Tool != nil {
+			found[result.ToolType] = result.Tool
+		}
 	}
 
 	var detectedTools []*Tool
-	for _, toolType := range toolTypes {
-		detector, err := factory.CreateDetector(toolType)
-		if err != nil {
-			continue
-		}
-
-		tool, err := detector.Detect()
-		if err == nil {
+	for _, toolType := range DetectableToolTypes {
+		if tool, ok := found[toolType]; ok {
 			detectedTools = append(detectedTools, tool)
 		}
 	}
 
-	return detectedTools, nil
-}
\ No newline at end of file
+	return detectedTools, ctx.Err()
+}
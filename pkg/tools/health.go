@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/chaksack/apm/pkg/netaddr"
 )
 
 // BaseHealthChecker provides common health check functionality
@@ -42,6 +44,7 @@ func (bhc *BaseHealthChecker) HTTPHealthCheck(ctx context.Context, path string)
 		return &HealthStatus{
 			Status:      ToolStatusUnhealthy,
 			LastChecked: time.Now(),
+			Details:     map[string]string{},
 			Error:       err.Error(),
 		}, nil
 	}
@@ -351,9 +354,44 @@ func (lhc *LokiHealthChecker) Check(ctx context.Context) (*HealthStatus, error)
 		health.Details["metrics"] = "unavailable"
 	}
 
+	// Get build info for version
+	buildInfo, err := lhc.getBuildInfo(ctx)
+	if err == nil {
+		health.Version = buildInfo.Version
+		health.Details["goVersion"] = buildInfo.GoVersion
+	}
+
 	return health, nil
 }
 
+// getBuildInfo retrieves Loki build information, which unlike Prometheus's
+// is not wrapped in a data field
+func (lhc *LokiHealthChecker) getBuildInfo(ctx context.Context) (*prometheusBuildInfo, error) {
+	url := fmt.Sprintf("%s/loki/api/v1/status/buildinfo", lhc.endpoint)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := lhc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("buildinfo returned status %d", resp.StatusCode)
+	}
+
+	var info prometheusBuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
 // checkMetricsEndpoint checks if Loki metrics endpoint is responsive
 func (lhc *LokiHealthChecker) checkMetricsEndpoint(ctx context.Context) bool {
 	url := fmt.Sprintf("%s/metrics", lhc.endpoint)
@@ -468,6 +506,10 @@ func NewHealthCheckerFactory() *HealthCheckerFactory {
 
 // CreateHealthChecker creates a health checker for the specified tool
 func (hcf *HealthCheckerFactory) CreateHealthChecker(tool *Tool) (HealthChecker, error) {
+	if err := netaddr.ValidateURL(tool.Endpoint); err != nil {
+		return nil, fmt.Errorf("%s health check: %w", tool.Type, err)
+	}
+
 	switch tool.Type {
 	case ToolTypePrometheus:
 		return NewPrometheusHealthChecker(tool.Endpoint), nil
@@ -3,7 +3,10 @@ package tools
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"sync"
+
+	"github.com/chaksack/apm/pkg/netaddr"
 )
 
 // PortRegistry defines default ports for each tool
@@ -257,7 +260,7 @@ func (pcr *PortConflictResolver) ResolveConflicts(tools []*Tool) error {
 	}
 
 	// Resolve conflicts
-	for port, conflictingTools := range portToTools {
+	for _, conflictingTools := range portToTools {
 		if len(conflictingTools) <= 1 {
 			continue
 		}
@@ -270,36 +273,19 @@ func (pcr *PortConflictResolver) ResolveConflicts(tools []*Tool) error {
 				return fmt.Errorf("failed to resolve port conflict for %s: %w", tool.Name, err)
 			}
 			tool.Port = newPort
-			tool.Endpoint = fmt.Sprintf("http://%s:%d", getHost(tool.Endpoint), newPort)
+			tool.Endpoint = withPort(tool.Endpoint, newPort)
 		}
 	}
 
 	return nil
 }
 
-// getHost extracts host from an endpoint URL
-func getHost(endpoint string) string {
-	// Simple extraction, assumes http://host:port format
-	if len(endpoint) > 7 {
-		start := 7 // len("http://")
-		if endpoint[:8] == "https://" {
-			start = 8
-		}
-		hostPort := endpoint[start:]
-		if colonIdx := findLastIndex(hostPort, ":"); colonIdx != -1 {
-			return hostPort[:colonIdx]
-		}
-		return hostPort
+// withPort returns endpoint with its port replaced, bracketing IPv6 hosts
+func withPort(endpoint string, port int) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Hostname() == "" {
+		return netaddr.URL("http", "localhost", port)
 	}
-	return "localhost"
+	u.Host = netaddr.JoinHostPort(u.Hostname(), port)
+	return u.String()
 }
-
-// findLastIndex finds the last occurrence of a substring
-func findLastIndex(s, substr string) int {
-	for i := len(s) - len(substr); i >= 0; i-- {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
-	}
-	return -1
-}
\ No newline at end of file
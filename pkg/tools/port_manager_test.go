@@ -0,0 +1,16 @@
+package tools
+
+import "testing"
+
+func TestWithPort(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"http://localhost:9090":       "http://localhost:9091",
+		"http://[fd00::1]:9090/graph": "http://[fd00::1]:9091/graph",
+		"https://[::1]":               "https://[::1]:9091",
+		"":                            "http://localhost:9091",
+	} {
+		if got := withPort(endpoint, 9091); got != want {
+			t.Errorf("withPort(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
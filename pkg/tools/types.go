@@ -136,6 +136,12 @@ type ToolDetector interface {
 	GetVersion() (string, error)
 }
 
+// ContextDetector is a ToolDetector whose detection stops when ctx is done
+type ContextDetector interface {
+	ToolDetector
+	DetectContext(ctx context.Context) (*Tool, error)
+}
+
 // HealthChecker interface for tool health monitoring
 type HealthChecker interface {
 	Check(ctx context.Context) (*HealthStatus, error)
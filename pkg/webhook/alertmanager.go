@@ -0,0 +1,54 @@
+package webhook
+
+import "time"
+
+// AlertmanagerPayload is the body Alertmanager posts to a webhook receiver
+type AlertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+// AlertmanagerAlert is one alert of an Alertmanager notification
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertEvents converts an Alertmanager notification into one alert.fired or
+// alert.resolved event per alert
+func AlertEvents(payload AlertmanagerPayload) []Event {
+	events := make([]Event, 0, len(payload.Alerts))
+	for _, alert := range payload.Alerts {
+		event := Event{
+			Type:    EventAlertFired,
+			Time:    alert.StartsAt,
+			Source:  "alertmanager",
+			Subject: alert.Labels["alertname"],
+			Status:  alert.Status,
+			Data: map[string]interface{}{
+				"labels":      alert.Labels,
+				"annotations": alert.Annotations,
+				"fingerprint": alert.Fingerprint,
+				"generator":   alert.GeneratorURL,
+				"receiver":    payload.Receiver,
+			},
+		}
+		if alert.Status == "resolved" {
+			event.Type = EventAlertResolved
+			event.Time = alert.EndsAt
+		}
+		events = append(events, event)
+	}
+	return events
+}
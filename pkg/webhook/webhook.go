@@ -0,0 +1,312 @@
+// Package webhook delivers APM lifecycle events (deployments, alerts, drift,
+// failed tests, incident summaries) to user-configured HTTP endpoints so that ticketing systems
+// and chatops bots can react to them. Every delivery is signed with an HMAC
+// of the payload and retried with backoff on network errors, 429s, and 5xx
+// responses.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of lifecycle event
+type EventType string
+
+const (
+	EventDeployStarted  EventType = "deploy.started"
+	EventDeployFinished EventType = "deploy.finished"
+	EventAlertFired     EventType = "alert.fired"
+	EventAlertResolved  EventType = "alert.resolved"
+	EventDriftDetected  EventType = "drift.detected"
+	EventTestFailed     EventType = "test.failed"
+
+	EventIncidentSummary EventType = "incident.summary"
+)
+
+// EventTypes lists every event type
+var EventTypes = []EventType{
+	EventDeployStarted, EventDeployFinished,
+	EventAlertFired, EventAlertResolved,
+	EventDriftDetected, EventTestFailed,
+	EventIncidentSummary,
+}
+
+// Delivery headers
+const (
+	HeaderEvent     = "X-APM-Event"
+	HeaderDelivery  = "X-APM-Delivery"
+	HeaderTimestamp = "X-APM-Timestamp"
+	HeaderSignature = "X-APM-Signature"
+)
+
+// Event is the JSON payload of a webhook
+type Event struct {
+	ID   string    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Source is the component that emitted the event, e.g. "apm deploy"
+	Source string `json:"source"`
+
+	// Subject is what the event is about: a service, alert, or backend
+	Subject string `json:"subject"`
+
+	// Status summarizes the outcome, e.g. "succeeded", "failed", "firing"
+	Status string `json:"status,omitempty"`
+
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Endpoint is a webhook receiver
+type Endpoint struct {
+	URL string `mapstructure:"url" yaml:"url" json:"url"`
+
+	// Secret signs deliveries; SecretEnv names an environment variable
+	// holding it, so the secret need not be written to the config file
+	Secret    string `mapstructure:"secret" yaml:"secret,omitempty" json:"-"`
+	SecretEnv string `mapstructure:"secret_env" yaml:"secret_env,omitempty" json:"secret_env,omitempty"`
+
+	// Events limits delivery to these types; empty means every event
+	Events []EventType `mapstructure:"events" yaml:"events,omitempty" json:"events,omitempty"`
+
+	// Headers are added to every delivery, e.g. an Authorization header
+	Headers map[string]string `mapstructure:"headers" yaml:"headers,omitempty" json:"-"`
+}
+
+// Validate checks the endpoint URL and event types
+func (e Endpoint) Validate() error {
+	if !strings.HasPrefix(e.URL, "http://") && !strings.HasPrefix(e.URL, "https://") {
+		return fmt.Errorf("webhook url %q must be http or https", e.URL)
+	}
+	for _, t := range e.Events {
+		if !knownType(t) {
+			return fmt.Errorf("webhook %s: unknown event type %q", e.URL, t)
+		}
+	}
+	return nil
+}
+
+// Accepts reports whether the endpoint subscribes to an event type
+func (e Endpoint) Accepts(t EventType) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, et := range e.Events {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Endpoint) secret() string {
+	if e.SecretEnv != "" {
+		if s := os.Getenv(e.SecretEnv); s != "" {
+			return s
+		}
+	}
+	return e.Secret
+}
+
+func knownType(t EventType) bool {
+	for _, et := range EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Emitter delivers events to its endpoints
+type Emitter struct {
+	Endpoints []Endpoint
+
+	// Client sends deliveries; nil uses a client with a 10 second timeout
+	Client *http.Client
+
+	// MaxAttempts per endpoint; zero means 4
+	MaxAttempts int
+
+	// Backoff before the first retry, doubled on each retry; zero means 1s
+	Backoff time.Duration
+
+	now func() time.Time
+}
+
+// New creates an emitter after validating its endpoints
+func New(endpoints ...Endpoint) (*Emitter, error) {
+	for _, e := range endpoints {
+		if err := e.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Emitter{Endpoints: endpoints}, nil
+}
+
+// Emit delivers an event to every endpoint subscribed to its type, in
+// parallel, and returns the failed deliveries joined. A nil Emitter or one
+// without endpoints does nothing.
+func (em *Emitter) Emit(ctx context.Context, event Event) error {
+	if em == nil || len(em.Endpoints) == 0 {
+		return nil
+	}
+	if event.ID == "" {
+		event.ID = newID()
+	}
+	if event.Time.IsZero() {
+		event.Time = em.clock()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, endpoint := range em.Endpoints {
+		if !endpoint.Accepts(event.Type) {
+			continue
+		}
+		wg.Add(1)
+		go func(endpoint Endpoint) {
+			defer wg.Done()
+			if err := em.deliver(ctx, endpoint, event, body); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("webhook %s: %w", endpoint.URL, err))
+				mu.Unlock()
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// deliver posts one event to one endpoint, retrying transient failures
+func (em *Emitter) deliver(ctx context.Context, endpoint Endpoint, event Event, body []byte) error {
+	attempts := em.MaxAttempts
+	if attempts <= 0 {
+		attempts = 4
+	}
+	backoff := em.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	client := em.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		retry, err := em.post(ctx, client, endpoint, event, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == attempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return lastErr
+}
+
+// post sends a single delivery and reports whether a failure is worth retrying
+func (em *Emitter) post(ctx context.Context, client *http.Client, endpoint Endpoint, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(em.clock().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "apm-webhook/1")
+	req.Header.Set(HeaderEvent, string(event.Type))
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if secret := endpoint.secret(); secret != "" {
+		req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+	}
+	for k, v := range endpoint.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+}
+
+func (em *Emitter) clock() time.Time {
+	if em.now != nil {
+		return em.now()
+	}
+	return time.Now().UTC()
+}
+
+// Sign returns the signature header value for a delivery: the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the secret, prefixed with "sha256="
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp is within
+// tolerance of now, so receivers can reject forged and replayed deliveries
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp %q", timestamp)
+	}
+	if age := time.Since(time.Unix(sent, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return fmt.Errorf("webhook timestamp is outside the %s tolerance", tolerance)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("webhook signature mismatch")
+	}
+	return nil
+}
+
+// newID returns a random delivery ID
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
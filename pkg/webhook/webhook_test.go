@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEmitSignsAndRetries(t *testing.T) {
+	var attempts int32
+	var got Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify("s3cret", r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp), body, time.Minute); err != nil {
+			t.Errorf("unexpected signature error: %v", err)
+		}
+		if r.Header.Get(HeaderEvent) != "deploy.finished" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	t.Setenv("TEST_WEBHOOK_SECRET", "s3cret")
+	emitter, err := New(Endpoint{
+		URL:       server.URL,
+		Secret:    "ignored",
+		SecretEnv: "TEST_WEBHOOK_SECRET",
+		Headers:   map[string]string{"Authorization": "Bearer token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.Backoff = time.Millisecond
+
+	err = emitter.Emit(context.Background(), Event{Type: EventDeployFinished, Source: "apm deploy", Subject: "checkout", Status: "succeeded"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected one retry after the 503, got %d attempts", attempts)
+	}
+	if got.ID == "" || got.Subject != "checkout" || got.Time.IsZero() {
+		t.Errorf("unexpected event %+v", got)
+	}
+}
+
+func TestEmitFiltersAndFails(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	emitter, err := New(
+		Endpoint{URL: server.URL, Events: []EventType{EventAlertFired}},
+		Endpoint{URL: server.URL + "/tests", Events: []EventType{EventTestFailed}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.Backoff = time.Millisecond
+
+	err = emitter.Emit(context.Background(), Event{Type: EventAlertFired, Subject: "HighErrorRate"})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected the 400 to be reported, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected one unretried delivery to the subscribed endpoint, got %d", attempts)
+	}
+
+	if _, err := New(Endpoint{URL: server.URL, Events: []EventType{"deploy.exploded"}}); err == nil {
+		t.Error("expected an error for an unknown event type")
+	}
+	if _, err := New(Endpoint{URL: "ftp://example.com"}); err == nil {
+		t.Error("expected an error for a non-http url")
+	}
+	if err := (*Emitter)(nil).Emit(context.Background(), Event{Type: EventTestFailed}); err != nil {
+		t.Errorf("expected a nil emitter to do nothing, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"drift.detected"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := Sign("key", now, body)
+
+	if err := Verify("key", sig, now, body, time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := Verify("other", sig, now, body, time.Minute); err == nil {
+		t.Error("expected a mismatch with the wrong secret")
+	}
+	if err := Verify("key", sig, now, []byte(`{}`), time.Minute); err == nil {
+		t.Error("expected a mismatch for a modified body")
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := Verify("key", Sign("key", old, body), old, body, 5*time.Minute); err == nil {
+		t.Error("expected a replayed delivery to be rejected")
+	}
+}
+
+func TestAlertEvents(t *testing.T) {
+	var payload AlertmanagerPayload
+	err := json.Unmarshal([]byte(`{
+		"status": "firing",
+		"receiver": "apm-webhooks",
+		"alerts": [
+			{"status": "firing", "labels": {"alertname": "HighErrorRate", "service": "checkout"}, "startsAt": "2024-05-01T10:00:00Z"},
+			{"status": "resolved", "labels": {"alertname": "HighLatency"}, "startsAt": "2024-05-01T09:00:00Z", "endsAt": "2024-05-01T09:30:00Z"}
+		]
+	}`), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := AlertEvents(payload)
+	if len(events) != 2 {
+		t.Fatalf("expected an event per alert, got %d", len(events))
+	}
+	if events[0].Type != EventAlertFired || events[0].Subject != "HighErrorRate" || events[0].Time.Hour() != 10 {
+		t.Errorf("unexpected fired event %+v", events[0])
+	}
+	if events[1].Type != EventAlertResolved || events[1].Time.Minute() != 30 {
+		t.Errorf("unexpected resolved event %+v", events[1])
+	}
+}
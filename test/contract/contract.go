@@ -0,0 +1,359 @@
+// Package contract verifies the APM health checkers, clients, and config
+// templates against pinned versions of Prometheus, Grafana, Jaeger, and Loki
+// running in containers. The results are written to the compatibility
+// matrix that `apm doctor` reads.
+package contract
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chaksack/apm/pkg/latency"
+	"github.com/chaksack/apm/pkg/lookup"
+	"github.com/chaksack/apm/pkg/tenancy"
+	"github.com/chaksack/apm/pkg/tools"
+)
+
+// Grafana admin credentials set on the test containers
+const (
+	GrafanaUser     = "admin"
+	GrafanaPassword = "contract"
+)
+
+// ToolSpec describes how to run one tool in a container
+type ToolSpec struct {
+	// Port is the API port health checks and clients use
+	Port string
+	// ReadyPath is polled on Port until the tool is up
+	ReadyPath string
+	// ExtraPorts are also exposed, e.g. Jaeger's OTLP receiver
+	ExtraPorts []string
+	Env        map[string]string
+
+	// ConfigPath is where the rendered template is mounted; ConfigArgs are
+	// the arguments that make the tool read it
+	ConfigPath string
+	ConfigArgs []string
+	// ConfigData is passed to the template. Nested sections must be present
+	// because the templates dereference them.
+	ConfigData map[string]interface{}
+	// ConfigFiles are other files the rendered config refers to
+	ConfigFiles map[string]string
+
+	StartupTimeout time.Duration
+}
+
+// Specs holds the container setup for every contract-tested tool
+var Specs = map[tools.ToolType]ToolSpec{
+	tools.ToolTypePrometheus: {
+		Port:       "9090",
+		ReadyPath:  "/-/ready",
+		ConfigPath: "/etc/prometheus/prometheus.yml",
+		ConfigArgs: []string{"--config.file=/etc/prometheus/prometheus.yml", "--storage.tsdb.path=/prometheus"},
+		ConfigData: map[string]interface{}{
+			"ScrapeInterval": "5s",
+			"RuleFiles":      []string{"/etc/prometheus/rules/*.yml"},
+		},
+		StartupTimeout: time.Minute,
+	},
+	tools.ToolTypeGrafana: {
+		Port:      "3000",
+		ReadyPath: "/api/health",
+		Env: map[string]string{
+			"GF_SECURITY_ADMIN_USER":     GrafanaUser,
+			"GF_SECURITY_ADMIN_PASSWORD": GrafanaPassword,
+		},
+		ConfigPath: "/etc/grafana/grafana.ini",
+		ConfigData: map[string]interface{}{
+			"AdminUser":     GrafanaUser,
+			"AdminPassword": GrafanaPassword,
+			"Database":      map[string]interface{}{},
+			"Alerting":      map[string]interface{}{},
+		},
+		StartupTimeout: 2 * time.Minute,
+	},
+	tools.ToolTypeJaeger: {
+		Port:       "16686",
+		ReadyPath:  "/",
+		ExtraPorts: []string{"4318"},
+		Env:        map[string]string{"COLLECTOR_OTLP_ENABLED": "true"},
+		ConfigPath: "/etc/jaeger/config.yaml",
+		ConfigArgs: []string{"--config-file=/etc/jaeger/config.yaml"},
+		ConfigData: map[string]interface{}{
+			"StorageType":   "memory",
+			"Elasticsearch": map[string]interface{}{},
+			"Cassandra":     map[string]interface{}{},
+			"Collector":     map[string]interface{}{},
+			"Query":         map[string]interface{}{},
+			"Processor":     map[string]interface{}{},
+			"Sampling":      map[string]interface{}{},
+		},
+		ConfigFiles: map[string]string{
+			"/etc/jaeger/sampling_strategies.json": `{"default_strategy": {"type": "probabilistic", "param": 1}}`,
+		},
+		StartupTimeout: time.Minute,
+	},
+	tools.ToolTypeLoki: {
+		Port:       "3100",
+		ReadyPath:  "/ready",
+		ConfigPath: "/etc/loki/local-config.yaml",
+		ConfigArgs: []string{"-config.file=/etc/loki/local-config.yaml"},
+		ConfigData: map[string]interface{}{
+			"Store":       "boltdb-shipper",
+			"ObjectStore": "filesystem",
+			"DataDir":     "/tmp/loki",
+			"WALDir":      "/tmp/loki/wal",
+		},
+		// Loki reports not ready until the ingester ring settles
+		StartupTimeout: 2 * time.Minute,
+	},
+}
+
+// RenderConfig renders the tool's config template with its spec's data
+func RenderConfig(tool tools.ToolType) (string, error) {
+	spec, ok := Specs[tool]
+	if !ok {
+		return "", fmt.Errorf("no contract spec for %s", tool)
+	}
+	renderer, err := tools.NewConfigTemplateRenderer()
+	if err != nil {
+		return "", err
+	}
+	return renderer.Render(tool, spec.ConfigData)
+}
+
+// CheckHealth runs the tool's health checker. It returns the reported
+// version along with the health and version contract results.
+func CheckHealth(ctx context.Context, tool tools.ToolType, endpoint, wantVersion string) (string, []tools.ContractResult) {
+	checker, err := tools.NewHealthCheckerFactory().CreateHealthChecker(&tools.Tool{Type: tool, Endpoint: endpoint})
+	if err != nil {
+		return "", []tools.ContractResult{failed(tools.ContractHealth, err), failed(tools.ContractVersion, err)}
+	}
+
+	health, err := checker.Check(ctx)
+	if err == nil && health.Status != tools.ToolStatusHealthy {
+		err = fmt.Errorf("status %s: %s", health.Status, health.Error)
+	}
+	if err != nil {
+		return "", []tools.ContractResult{failed(tools.ContractHealth, err), failed(tools.ContractVersion, err)}
+	}
+
+	results := []tools.ContractResult{passed(tools.ContractHealth)}
+	got := strings.TrimPrefix(health.Version, "v")
+	switch {
+	case got == "":
+		results = append(results, failed(tools.ContractVersion, fmt.Errorf("health checker reported no version")))
+	case got != strings.TrimPrefix(wantVersion, "v"):
+		results = append(results, failed(tools.ContractVersion, fmt.Errorf("reported version %s, want %s", got, wantVersion)))
+	default:
+		results = append(results, passed(tools.ContractVersion))
+	}
+	return health.Version, results
+}
+
+// CheckClient exercises the APM client for the tool. endpoints maps a
+// container port to its host URL.
+func CheckClient(ctx context.Context, tool tools.ToolType, endpoints map[string]string) tools.ContractResult {
+	var err error
+	switch tool {
+	case tools.ToolTypePrometheus:
+		err = checkPrometheusClient(ctx, endpoints["9090"])
+	case tools.ToolTypeGrafana:
+		err = checkGrafanaClient(ctx, endpoints["3000"])
+	case tools.ToolTypeJaeger:
+		err = checkJaegerClient(ctx, endpoints["16686"], endpoints["4318"])
+	case tools.ToolTypeLoki:
+		err = checkLokiClient(ctx, endpoints["3100"])
+	default:
+		err = fmt.Errorf("no client contract for %s", tool)
+	}
+	if err != nil {
+		return failed(tools.ContractClient, err)
+	}
+	return passed(tools.ContractClient)
+}
+
+// checkPrometheusClient builds a heatmap from Prometheus' own request
+// duration histogram
+func checkPrometheusClient(ctx context.Context, url string) error {
+	client := &latency.Client{URL: url, RouteLabel: "handler"}
+	return poll(ctx, func() error {
+		end := time.Now()
+		result, err := client.Heatmaps(ctx, latency.Query{
+			Metric: "prometheus_http_request_duration_seconds",
+			Start:  end.Add(-2 * time.Minute),
+			End:    end,
+			Step:   15 * time.Second,
+		})
+		if err != nil {
+			return err
+		}
+		if len(result.Heatmaps) == 0 {
+			return fmt.Errorf("no heatmaps for prometheus_http_request_duration_seconds")
+		}
+		return nil
+	})
+}
+
+// checkGrafanaClient provisions an organization and a datasource twice, the
+// second time through the update path
+func checkGrafanaClient(ctx context.Context, url string) error {
+	p := &tenancy.GrafanaProvisioner{URL: url, Username: GrafanaUser, Password: GrafanaPassword}
+	orgID, err := p.EnsureOrg(ctx, "contract")
+	if err != nil {
+		return err
+	}
+	ds := tenancy.Datasource{
+		OrgID:  orgID,
+		Name:   "Contract Prometheus",
+		UID:    "contract-prometheus",
+		Type:   "prometheus",
+		Access: "proxy",
+		URL:    "http://prometheus:9090",
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.EnsureDatasource(ctx, ds); err != nil {
+			return err
+		}
+	}
+	if again, err := p.EnsureOrg(ctx, "contract"); err != nil || again != orgID {
+		return fmt.Errorf("second EnsureOrg returned %d, %v; want %d", again, err, orgID)
+	}
+	return nil
+}
+
+// checkJaegerClient exports a span over OTLP/HTTP and finds it by attribute
+func checkJaegerClient(ctx context.Context, queryURL, otlpURL string) error {
+	traceID := hex.EncodeToString([]byte(fmt.Sprintf("%016x", time.Now().UnixNano())))
+	now := time.Now()
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []interface{}{
+				stringAttr("service.name", "contract"),
+			}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"spans": []interface{}{map[string]interface{}{
+					"traceId":           traceID,
+					"spanId":            traceID[:16],
+					"name":              "contract-span",
+					"kind":              2,
+					"startTimeUnixNano": fmt.Sprint(now.Add(-time.Second).UnixNano()),
+					"endTimeUnixNano":   fmt.Sprint(now.UnixNano()),
+					"attributes":        []interface{}{stringAttr("contract.id", traceID)},
+				}},
+			}},
+		}},
+	}
+	if err := postJSON(ctx, otlpURL+"/v1/traces", body); err != nil {
+		return fmt.Errorf("failed to export span: %w", err)
+	}
+
+	jaeger := &lookup.Jaeger{URL: queryURL, Client: http.DefaultClient}
+	return poll(ctx, func() error {
+		events, err := jaeger.SearchTraces(ctx, lookup.Query{
+			Attribute: "contract.id",
+			Value:     traceID,
+			Start:     now.Add(-time.Minute),
+			End:       time.Now().Add(time.Minute),
+			Services:  []string{"contract"},
+			Limit:     10,
+		})
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return fmt.Errorf("span %s not found", traceID)
+		}
+		return nil
+	})
+}
+
+// checkLokiClient pushes a log line and finds it by its contents
+func checkLokiClient(ctx context.Context, url string) error {
+	marker := fmt.Sprintf("contract-%d", time.Now().UnixNano())
+	now := time.Now()
+	body := map[string]interface{}{
+		"streams": []interface{}{map[string]interface{}{
+			"stream": map[string]string{"job": "contract"},
+			"values": [][]string{{fmt.Sprint(now.UnixNano()), "request order=" + marker}},
+		}},
+	}
+	if err := postJSON(ctx, url+"/loki/api/v1/push", body); err != nil {
+		return fmt.Errorf("failed to push log line: %w", err)
+	}
+
+	loki := &lookup.Loki{URL: url, Client: http.DefaultClient}
+	return poll(ctx, func() error {
+		events, err := loki.SearchLogs(ctx, lookup.Query{
+			Attribute: "order",
+			Value:     marker,
+			Start:     now.Add(-time.Minute),
+			End:       time.Now().Add(time.Minute),
+			Limit:     10,
+		}, []string{marker})
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return fmt.Errorf("log line %s not found", marker)
+		}
+		return nil
+	})
+}
+
+func stringAttr(key, value string) map[string]interface{} {
+	return map[string]interface{}{"key": key, "value": map[string]string{"stringValue": value}}
+}
+
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// poll retries fn every two seconds until it succeeds or a minute passes,
+// since ingested data takes a moment to become queryable
+func poll(ctx context.Context, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func passed(name string) tools.ContractResult {
+	return tools.ContractResult{Name: name, Passed: true}
+}
+
+func failed(name string, err error) tools.ContractResult {
+	return tools.ContractResult{Name: name, Error: err.Error()}
+}
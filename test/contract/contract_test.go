@@ -0,0 +1,190 @@
+package contract
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chaksack/apm/pkg/tools"
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+var updateMatrix = flag.String("update-matrix", "", "write the contract results to this compatibility matrix, e.g. ../../pkg/tools/compatibility.json")
+
+var (
+	resultsMu sync.Mutex
+	results   []tools.CompatibilityEntry
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	// Failed contracts are recorded too; the matrix tells doctor about them
+	if *updateMatrix != "" && len(results) > 0 {
+		if err := writeMatrix(*updateMatrix); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// writeMatrix merges this run's results into the matrix at path
+func writeMatrix(path string) error {
+	matrix, err := tools.LoadCompatibilityMatrix(path)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	matrix.GeneratedAt = &now
+	for _, entry := range results {
+		matrix.Record(entry)
+	}
+	return matrix.Save(path)
+}
+
+func TestRenderConfigs(t *testing.T) {
+	for tool := range Specs {
+		config, err := RenderConfig(tool)
+		if err != nil {
+			t.Errorf("%s: %v", tool, err)
+			continue
+		}
+		if strings.Contains(config, "<no value>") {
+			t.Errorf("%s config has unset values:\n%s", tool, config)
+		}
+	}
+}
+
+func TestContracts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts containers")
+	}
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	matrix, err := tools.DefaultCompatibilityMatrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range matrix.Entries {
+		entry := entry
+		if _, ok := Specs[entry.Tool]; !ok {
+			continue
+		}
+		t.Run(string(entry.Tool)+"-"+entry.Version, func(t *testing.T) {
+			t.Parallel()
+			runContracts(t, entry)
+		})
+	}
+}
+
+// runContracts checks one pinned version. The health, version, and client
+// contracts run against the image's default config, and the config contract
+// against a second container started with the rendered template, so a
+// template problem does not hide the others.
+func runContracts(t *testing.T, entry tools.CompatibilityEntry) {
+	ctx := context.Background()
+	spec := Specs[entry.Tool]
+	var contracts []tools.ContractResult
+
+	endpoints, err := startTool(ctx, t, entry, false)
+	if err != nil {
+		contracts = append(contracts,
+			failed(tools.ContractHealth, err),
+			failed(tools.ContractVersion, err),
+			failed(tools.ContractClient, err))
+	} else {
+		_, health := CheckHealth(ctx, entry.Tool, endpoints[spec.Port], entry.Version)
+		contracts = append(contracts, health...)
+		contracts = append(contracts, CheckClient(ctx, entry.Tool, endpoints))
+	}
+
+	if _, err := startTool(ctx, t, entry, true); err != nil {
+		contracts = append(contracts, failed(tools.ContractConfig, err))
+	} else {
+		contracts = append(contracts, passed(tools.ContractConfig))
+	}
+
+	for _, c := range contracts {
+		if !c.Passed {
+			t.Errorf("%s contract failed: %s", c.Name, c.Error)
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	entry.Contracts = contracts
+	entry.Status = tools.StatusFromContracts(contracts)
+	entry.TestedAt = &now
+
+	resultsMu.Lock()
+	results = append(results, entry)
+	resultsMu.Unlock()
+}
+
+// startTool runs the image until its ready path answers, returning the host
+// URL of each exposed port. With withConfig, the rendered template is mounted
+// and passed to the tool.
+func startTool(ctx context.Context, t *testing.T, entry tools.CompatibilityEntry, withConfig bool) (map[string]string, error) {
+	spec := Specs[entry.Tool]
+	ports := append([]string{spec.Port}, spec.ExtraPorts...)
+	req := testcontainers.ContainerRequest{
+		Image: entry.Image,
+		Env:   spec.Env,
+		WaitingFor: wait.ForHTTP(spec.ReadyPath).
+			WithPort(nat.Port(spec.Port + "/tcp")).
+			WithStartupTimeout(spec.StartupTimeout),
+	}
+	for _, port := range ports {
+		req.ExposedPorts = append(req.ExposedPorts, port+"/tcp")
+	}
+
+	if withConfig {
+		config, err := RenderConfig(entry.Tool)
+		if err != nil {
+			return nil, err
+		}
+		req.Files = append(req.Files, testcontainers.ContainerFile{
+			Reader:            strings.NewReader(config),
+			ContainerFilePath: spec.ConfigPath,
+			FileMode:          0o644,
+		})
+		for path, content := range spec.ConfigFiles {
+			req.Files = append(req.Files, testcontainers.ContainerFile{
+				Reader:            strings.NewReader(content),
+				ContainerFilePath: path,
+				FileMode:          0o644,
+			})
+		}
+		req.Cmd = spec.ConfigArgs
+	}
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[string]string, len(ports))
+	for _, port := range ports {
+		mapped, err := container.MappedPort(ctx, nat.Port(port+"/tcp"))
+		if err != nil {
+			return nil, err
+		}
+		endpoints[port] = fmt.Sprintf("http://%s:%s", host, mapped.Port())
+	}
+	return endpoints, nil
+}